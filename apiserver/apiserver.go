@@ -0,0 +1,497 @@
+// Package apiserver serves an HTTP API for running many sketch sessions at once,
+// so that CI systems and custom UIs can drive the agent without a terminal or browser.
+//
+// The API is JSON over HTTP:
+//
+//	POST   /sessions       create a session: {"working_dir": "/abs/path", "prompt": "optional first message",
+//	                       "profile": "optional profile, such as safe or review-only",
+//	                       "max_dollars": optional part of the user's budget to set aside for the session}
+//	GET    /sessions       list sessions
+//	GET    /sessions/{id}  describe a session
+//	DELETE /sessions/{id}  end a session
+//	GET    /me             describe the user, with what their sessions have spent
+//	GET    /audit          list the recent audit entries: requests that acted on sessions, and refused ones
+//
+// Everything else under /sessions/{id}/ is the session's own HTTP API, the one the web UI uses.
+// Among others, it has:
+//
+//	POST /sessions/{id}/chat             send a user message: {"message": "...", "interrupt": false}
+//	GET  /sessions/{id}/ws               stream the session's events (turns, response deltas, tool calls and
+//	                                     their output, approval requests, usage) and state over a WebSocket, optionally ?from=N
+//	GET  /sessions/{id}/stream           stream the session's state and messages as server-sent events
+//	GET  /sessions/{id}/messages         fetch the transcript, optionally ?start=N&end=M
+//	GET  /sessions/{id}/state            fetch the session's state
+//	GET  /sessions/{id}/changes          list file changes that await approval
+//	POST /sessions/{id}/changes/approve  approve changes: {"path": "optional", "hunks": [optional]}
+//	POST /sessions/{id}/changes/reject   reject changes, likewise
+//	POST /sessions/{id}/changes/review   accept and reject hunks of a file, telling the agent:
+//	                                     {"path": "...", "decisions": [{"hunk": 0, "accept": false, "comment": "optional"}]}
+//	GET  /sessions/{id}/changes/file     fetch a changed file's staged contents, ?path=/abs/path
+//	POST /sessions/{id}/changes/edit     write the user's version of a changed file: {"path": "...", "content": "..."}
+//	POST /sessions/{id}/changes/approve-session  approve all changes, and later ones as they are made
+//	GET  /sessions/{id}/undo             list the agent's file writes that can be undone
+//	POST /sessions/{id}/undo/last        undo the agent's most recent file write
+//	POST /sessions/{id}/undo/all         undo all the agent's file writes, most recent first
+//	GET  /sessions/{id}/jobs             list the background jobs the agent started
+//	POST /sessions/{id}/jobs/stop        stop a background job: {"job": 3}
+//	GET  /sessions/{id}/attachments      list the context pinned into the conversation
+//	POST /sessions/{id}/attachments      pin files, globs, and URLs: {"sources": ["docs/*.md"]}
+//	POST /sessions/{id}/attachments/detach  unpin attachments: {"names": [...]}, or all of them
+//	POST /sessions/{id}/cancel           cancel the current turn
+//
+// POST /sessions/{id}/end ends the session, like DELETE.
+//
+// GET /metrics serves the server's metrics in the Prometheus text format,
+// such as LLM requests and tokens by model, tool call latencies, and active sessions.
+//
+// Messages and events have a schema_version field; see claudetool.SchemaVersion for what it guarantees.
+//
+// A Server with an Authenticator serves only the users it authenticates; see Users for a team's.
+// Each session belongs to the user who created it: other users cannot see it or act on it,
+// nor on their audit entries, except for admins. A user's sessions start in their workspace
+// and, together, spend at most their budget: each holds a part of it, which it may spend before the user's next message,
+// and which is topped up as it spends when the user sends one.
+//
+// # Isolation
+//
+// The Server does not isolate users' files from each other. A workspace only limits the working_dir
+// that a user may ask for: every session's commands run as the server's operating system user,
+// and can read and write other users' workspaces, and anything else that user can.
+// Users who must not reach each other's files need a server each, run as different operating system users
+// or in different containers.
+package apiserver
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/metrics"
+	"sketch.dev/skabandclient"
+)
+
+var sessionsActive = metrics.NewGauge("sketch_sessions_active", "Sessions that the API server is running.")
+
+// A CreateRequest asks for a new session.
+type CreateRequest struct {
+	// WorkingDir is the absolute path of the directory the session works in.
+	WorkingDir string `json:"working_dir"`
+	// Prompt, if set, is the session's first user message.
+	Prompt string `json:"prompt,omitempty"`
+	// Profile, if set, names the session's preset of tools, permission rules, and budget,
+	// such as "safe" or "review-only"; see projectconfig.Profile.
+	Profile string `json:"profile,omitempty"`
+
+	// MaxDollars, if the user has a budget, is the part of it that the session may spend before the user's next message;
+	// by default a quarter of the budget. The Server lowers it to what is left of the budget, less what their other sessions hold,
+	// and leaves it 0 if the user has no budget.
+	MaxDollars float64 `json:"max_dollars,omitempty"`
+
+	// Owner, set by the Server, is the name of the user the session is for.
+	Owner string `json:"-"`
+}
+
+// A StartFunc starts a session with the given ID and returns the handler for its HTTP API.
+// The session runs until ctx is done.
+// If the handler is a Coster, what the session spends counts toward its user's budget.
+type StartFunc func(ctx context.Context, id string, req CreateRequest) (http.Handler, error)
+
+// A Coster reports what a session has spent so far, in US dollars.
+type Coster interface {
+	CostUSD() float64
+}
+
+// A Session is a session that a Server runs.
+type Session struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"owner"`
+	WorkingDir string    `json:"working_dir"`
+	Profile    string    `json:"profile,omitempty"`
+	Created    time.Time `json:"created"`
+
+	handler http.Handler
+	cancel  context.CancelFunc
+	// budget is its owner's budget, or 0 if they have none.
+	budget float64
+	// allowance is what the session may spend before the user's next message: its CreateRequest's MaxDollars.
+	allowance float64
+	// reserved is the part of its owner's budget that is set aside for the session: what it spent, and what it may yet spend.
+	reserved float64
+}
+
+// costUSD returns what sess has spent so far.
+func (sess *Session) costUSD() float64 {
+	if c, ok := sess.handler.(Coster); ok {
+		return c.CostUSD()
+	}
+	return 0
+}
+
+// A Server runs sessions and serves the API for them.
+type Server struct {
+	ctx   context.Context
+	start StartFunc
+	auth  Authenticator
+	mux   *http.ServeMux
+	audit auditLog
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	spent    map[string]float64 // what the sessions that ended spent, by user
+	reserved map[string]float64 // what is set aside for the running sessions, and those starting, of their user's budget
+}
+
+// New returns a Server that starts sessions with start. They run until ctx is done.
+// If auth is not nil, it must authenticate every request; otherwise every request is an admin's.
+func New(ctx context.Context, start StartFunc, auth Authenticator) *Server {
+	s := &Server{
+		ctx:      ctx,
+		start:    start,
+		auth:     auth,
+		mux:      http.NewServeMux(),
+		sessions: make(map[string]*Session),
+		spent:    make(map[string]float64),
+		reserved: make(map[string]float64),
+	}
+	s.mux.HandleFunc("POST /sessions", s.handleCreate)
+	s.mux.HandleFunc("GET /sessions", s.handleList)
+	s.mux.HandleFunc("GET /me", s.handleMe)
+	s.mux.HandleFunc("GET /audit", s.handleAudit)
+	s.mux.HandleFunc("GET /sessions/{id}", s.handleGet)
+	s.mux.HandleFunc("DELETE /sessions/{id}", s.handleEnd)
+	// The session's own /end exits the process; here it ends only the session.
+	s.mux.HandleFunc("POST /sessions/{id}/end", s.handleEnd)
+	s.mux.HandleFunc("/sessions/{id}/", s.handleSession)
+	s.mux.Handle("GET /metrics", metrics.Handler())
+	return s
+}
+
+// AuditTo writes every audit entry to w, as a line of JSON, as well as keeping the recent ones for GET /audit.
+func (s *Server) AuditTo(w io.Writer) {
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+	s.audit.w = w
+}
+
+// contextKey is the type of the keys of the values a Server puts in its requests' contexts.
+type contextKey int
+
+const (
+	userKey  contextKey = iota // the *User who made the request
+	auditKey                   // the request's *AuditEntry, for handlers to add to
+)
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	entry := &AuditEntry{Time: time.Now(), Method: r.Method, Path: r.URL.Path}
+	user := tokenUser
+	if s.auth != nil {
+		var err error
+		if user, err = s.auth.Authenticate(r); err != nil {
+			entry.Status, entry.Detail = http.StatusUnauthorized, err.Error()
+			s.audit.add(*entry)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	entry.User = user.Name
+	ctx := context.WithValue(r.Context(), userKey, user)
+	r = r.WithContext(context.WithValue(ctx, auditKey, entry))
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		// Reading is not audited, and streams need the ResponseWriter itself.
+		s.mux.ServeHTTP(w, r)
+		return
+	}
+	rec := &statusRecorder{ResponseWriter: w}
+	s.mux.ServeHTTP(rec, r)
+	entry.Status = rec.status
+	if entry.Session == "" {
+		entry.Session = r.PathValue("id")
+	}
+	s.audit.add(*entry)
+}
+
+// userOf returns the user who made r.
+func userOf(r *http.Request) *User {
+	return r.Context().Value(userKey).(*User)
+}
+
+// auditEntryOf returns the audit entry of r, for its handler to add to.
+func auditEntryOf(r *http.Request) *AuditEntry {
+	return r.Context().Value(auditKey).(*AuditEntry)
+}
+
+// Close ends all sessions.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.sessions {
+		sess.cancel()
+		s.endLocked(sess)
+		delete(s.sessions, id)
+	}
+	sessionsActive.Set(0)
+}
+
+// spentBy returns what the sessions of user have spent, including those that ended.
+func (s *Server) spentBy(user *User) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spent := s.spent[user.Name]
+	for _, sess := range s.sessions {
+		if sess.Owner == user.Name {
+			spent += sess.costUSD()
+		}
+	}
+	return spent
+}
+
+// defaultSessionShare is the share of its user's budget that a session may spend before the user's next message,
+// unless its request asks for another amount: so that, by default, four of a user's sessions can work at once.
+const defaultSessionShare = 0.25
+
+// reserveBudget sets aside part of user's budget for a new session that asks to spend want before the user's next message,
+// and returns it: want, or defaultSessionShare of the budget if want is 0, but no more than the budget's available part,
+// which is what its sessions have not spent or set aside. It returns 0 if the user has no budget.
+// What is set aside counts as spent until releaseBudget or endLocked returns it,
+// so that the user's sessions, however many run at once, together spend at most the budget.
+func (s *Server) reserveBudget(user *User, want float64) (float64, error) {
+	if user.MaxDollars <= 0 {
+		return 0, nil
+	}
+	if want <= 0 {
+		want = user.MaxDollars * defaultSessionShare
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	available := s.availableLocked(user.Name, user.MaxDollars)
+	if available <= 0 {
+		return 0, fmt.Errorf("your budget of $%.2f is spent, or set aside for your running sessions", user.MaxDollars)
+	}
+	reserved := min(want, available)
+	s.reserved[user.Name] += reserved
+	return reserved, nil
+}
+
+// availableLocked returns the part of the named user's budget that their sessions have not spent or set aside.
+// A running session that spent more than was set aside for it, as a turn may, is charged what it spent.
+// s.mu must be held.
+func (s *Server) availableLocked(name string, budget float64) float64 {
+	available := budget - s.spent[name] - s.reserved[name]
+	for _, sess := range s.sessions {
+		if sess.Owner == name {
+			available -= max(sess.costUSD()-sess.reserved, 0)
+		}
+	}
+	return available
+}
+
+// topUp tops up what is set aside for sess, which the user is about to send a message,
+// to what it spent and its allowance, as far as the budget allows. It fails if nothing is left for the session to spend.
+func (s *Server) topUp(sess *Session) error {
+	if sess.budget <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cost := sess.costUSD()
+	if more := min(cost+sess.allowance-sess.reserved, s.availableLocked(sess.Owner, sess.budget)); more > 0 {
+		sess.reserved += more
+		s.reserved[sess.Owner] += more
+	}
+	if cost >= sess.reserved {
+		return fmt.Errorf("this session has spent the $%.2f of your budget that was set aside for it, and the rest of your budget of $%.2f is spent or set aside for your other sessions", sess.reserved, sess.budget)
+	}
+	return nil
+}
+
+// releaseBudget returns what reserveBudget set aside for a session of user that did not start.
+func (s *Server) releaseBudget(user *User, reserved float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reserved[user.Name] -= reserved
+}
+
+// endLocked records that sess ended: its owner's budget is charged what it spent, instead of what was set aside for it.
+// s.mu must be held.
+func (s *Server) endLocked(sess *Session) {
+	s.spent[sess.Owner] += sess.costUSD()
+	s.reserved[sess.Owner] -= sess.reserved
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	user := userOf(r)
+	var req CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	auditEntryOf(r).Detail = req.WorkingDir
+	if err := checkWorkingDir(req.WorkingDir); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkWorkspace(user, req.WorkingDir); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	reserved, err := s.reserveBudget(user, req.MaxDollars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req.Owner, req.MaxDollars = user.Name, reserved
+
+	id := skabandclient.NewSessionID()
+	auditEntryOf(r).Session = id
+	ctx, cancel := context.WithCancel(s.ctx)
+	handler, err := s.start(ctx, id, req)
+	if err != nil {
+		cancel()
+		s.releaseBudget(user, reserved)
+		slog.ErrorContext(r.Context(), "api_session_start_failed", "working_dir", req.WorkingDir, "err", err)
+		http.Error(w, "Failed to start session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sess := &Session{
+		ID:         id,
+		Owner:      user.Name,
+		WorkingDir: req.WorkingDir,
+		Profile:    req.Profile,
+		Created:    time.Now(),
+		handler:    handler,
+		cancel:     cancel,
+		budget:     user.MaxDollars,
+		allowance:  reserved,
+		reserved:   reserved,
+	}
+	s.mu.Lock()
+	s.sessions[id] = sess
+	sessionsActive.Set(float64(len(s.sessions)))
+	s.mu.Unlock()
+	slog.InfoContext(r.Context(), "api_session_started", "id", id, "user", user.Name, "working_dir", req.WorkingDir)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sess)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	user := userOf(r)
+	s.mu.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		if user.Admin || sess.Owner == user.Name {
+			sessions = append(sessions, sess)
+		}
+	}
+	s.mu.Unlock()
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return cmp.Or(a.Created.Compare(b.Created), strings.Compare(a.ID, b.ID))
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	sess := s.session(w, r)
+	if sess == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sess)
+}
+
+func (s *Server) handleEnd(w http.ResponseWriter, r *http.Request) {
+	sess := s.session(w, r)
+	if sess == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.sessions, sess.ID)
+	s.endLocked(sess)
+	sessionsActive.Set(float64(len(s.sessions)))
+	s.mu.Unlock()
+	sess.cancel()
+	slog.InfoContext(r.Context(), "api_session_ended", "id", sess.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	sess := s.session(w, r)
+	if sess == nil {
+		return
+	}
+	if r.Method == http.MethodPost && r.URL.Path == "/sessions/"+sess.ID+"/chat" {
+		if err := s.topUp(sess); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	http.StripPrefix("/sessions/"+sess.ID, sess.handler).ServeHTTP(w, r)
+}
+
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	user := userOf(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*User
+		SpentUSD float64 `json:"spent_usd"`
+	}{user, s.spentBy(user)})
+}
+
+// handleAudit lists the recent audit entries: all of them, or those of ?user=, for admins,
+// and the user's own for other users.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	user := userOf(r)
+	only := r.URL.Query().Get("user")
+	if !user.Admin {
+		only = user.Name
+	}
+	entries := s.audit.list(func(e AuditEntry) bool { return only == "" || e.User == only })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// session returns the session named in r's path, if the user who made r may act on it,
+// or responds with an error and returns nil if there is no such session.
+// Other users' sessions are not found, so that their IDs are not disclosed.
+func (s *Server) session(w http.ResponseWriter, r *http.Request) *Session {
+	user := userOf(r)
+	s.mu.Lock()
+	sess := s.sessions[r.PathValue("id")]
+	s.mu.Unlock()
+	if sess != nil && !user.Admin && sess.Owner != user.Name {
+		sess = nil
+	}
+	if sess == nil {
+		http.Error(w, "No such session", http.StatusNotFound)
+	}
+	return sess
+}
+
+// checkWorkingDir reports whether dir can be a session's working directory.
+func checkWorkingDir(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("working_dir must be an absolute path, not %q", dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("working_dir is not a directory")
+	}
+	return nil
+}
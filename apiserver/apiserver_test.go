@@ -0,0 +1,115 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+
+	var started []CreateRequest
+	ended := make(map[string]<-chan struct{})
+	start := func(ctx context.Context, id string, req CreateRequest) (http.Handler, error) {
+		started = append(started, req)
+		ended[id] = ctx.Done()
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, id+" "+r.Method+" "+r.URL.Path)
+		}), nil
+	}
+	srv := httptest.NewServer(New(ctx, start, &TokenAuth{Token: "secret"}))
+	defer srv.Close()
+
+	do := func(method, path, token, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	if code, _ := do("GET", "/sessions", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("GET /sessions without a token = %d, want 401", code)
+	}
+	if code, _ := do("GET", "/sessions", "wrong", ""); code != http.StatusUnauthorized {
+		t.Errorf("GET /sessions with the wrong token = %d, want 401", code)
+	}
+	if code, _ := do("POST", "/sessions", "secret", `{"working_dir": "relative"}`); code != http.StatusBadRequest {
+		t.Errorf("POST /sessions with a relative working_dir = %d, want 400", code)
+	}
+
+	code, body := do("POST", "/sessions", "secret", `{"working_dir": "`+dir+`", "prompt": "hi", "profile": "safe"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("POST /sessions = %d %s", code, body)
+	}
+	var sess Session
+	if err := json.Unmarshal([]byte(body), &sess); err != nil {
+		t.Fatal(err)
+	}
+	if sess.ID == "" || sess.WorkingDir != dir || sess.Profile != "safe" {
+		t.Errorf("created session = %+v", sess)
+	}
+	if len(started) != 1 || started[0].Prompt != "hi" || started[0].Profile != "safe" {
+		t.Errorf("started = %+v", started)
+	}
+
+	code, body = do("GET", "/sessions", "secret", "")
+	var list []Session
+	if err := json.Unmarshal([]byte(body), &list); code != http.StatusOK || err != nil || len(list) != 1 || list[0].ID != sess.ID {
+		t.Errorf("GET /sessions = %d %s", code, body)
+	}
+
+	if code, _ := do("GET", "/metrics", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("GET /metrics without a token = %d, want 401", code)
+	}
+	if code, body := do("GET", "/metrics", "secret", ""); code != http.StatusOK || !strings.Contains(body, "\nsketch_sessions_active 1\n") {
+		t.Errorf("GET /metrics = %d %s", code, body)
+	}
+
+	// Requests under the session go to its handler.
+	if code, body := do("POST", "/sessions/"+sess.ID+"/chat", "secret", `{"message": "more"}`); code != http.StatusOK || body != sess.ID+" POST /chat" {
+		t.Errorf("POST /sessions/{id}/chat = %d %q", code, body)
+	}
+	if code, _ := do("GET", "/sessions/nope/messages", "secret", ""); code != http.StatusNotFound {
+		t.Errorf("GET /sessions/nope/messages = %d, want 404", code)
+	}
+
+	// The session's own /end ends only the session.
+	if code, body := do("POST", "/sessions/"+sess.ID+"/end", "secret", ""); code != http.StatusNoContent {
+		t.Errorf("POST /sessions/{id}/end = %d %s", code, body)
+	}
+	select {
+	case <-ended[sess.ID]:
+	default:
+		t.Error("session context not canceled after end")
+	}
+	if _, body := do("GET", "/metrics", "secret", ""); !strings.Contains(body, "\nsketch_sessions_active 0\n") {
+		t.Errorf("GET /metrics after end = %s", body)
+	}
+	if code, _ := do("GET", "/sessions/"+sess.ID, "secret", ""); code != http.StatusNotFound {
+		t.Errorf("GET ended session = %d, want 404", code)
+	}
+	if code, _ := do("DELETE", "/sessions/"+sess.ID, "secret", ""); code != http.StatusNotFound {
+		t.Errorf("DELETE ended session = %d, want 404", code)
+	}
+}
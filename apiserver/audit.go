@@ -0,0 +1,81 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// An AuditEntry records a request that acted on the server or a session, or that was refused.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user,omitempty"` // empty for requests that did not authenticate
+	Session string    `json:"session,omitempty"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	Status  int       `json:"status"`
+	// Detail says more, such as the working directory of a session that was created.
+	Detail string `json:"detail,omitempty"`
+}
+
+// maxAuditEntries is how many audit entries a Server keeps in memory, for GET /audit.
+const maxAuditEntries = 10000
+
+// auditLog keeps the recent audit entries, and writes every entry to w, if set, as a line of JSON.
+type auditLog struct {
+	mu      sync.Mutex
+	w       io.Writer
+	entries []AuditEntry
+}
+
+func (l *auditLog) add(e AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) == maxAuditEntries {
+		l.entries = append(l.entries[:0], l.entries[maxAuditEntries/10:]...)
+	}
+	l.entries = append(l.entries, e)
+	if l.w == nil {
+		return
+	}
+	line, _ := json.Marshal(e)
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		slog.Warn("api_audit_write_failed", "err", err)
+	}
+}
+
+// list returns the recent entries for which keep returns true, oldest first.
+func (l *auditLog) list(keep func(AuditEntry) bool) []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := []AuditEntry{}
+	for _, e := range l.entries {
+		if keep(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// statusRecorder records the status of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,195 @@
+package apiserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A User is someone the server runs sessions for.
+type User struct {
+	Name string `yaml:"-" json:"name"`
+	// TokenSHA256 is the hex SHA-256 hash of the user's bearer token, as printed by `printf %s "$token" | sha256sum`.
+	// Users who sign in with OIDC need none.
+	TokenSHA256 string `yaml:"token_sha256" json:"-"`
+	// Admin users see and act on every user's sessions and audit entries.
+	Admin bool `yaml:"admin" json:"admin"`
+	// Workspace, if set, is the directory that the user's sessions must start in.
+	// It limits the working_dir that the user may ask for, not what the sessions' commands can reach.
+	Workspace string `yaml:"workspace" json:"workspace,omitempty"`
+	// MaxDollars, if positive, is the most the user's sessions may spend, together, while the server runs.
+	MaxDollars float64 `yaml:"max_dollars" json:"max_dollars,omitempty"`
+}
+
+// An Authenticator identifies the user who made a request.
+type Authenticator interface {
+	// Authenticate returns the user who made r, or an error if r does not identify one.
+	Authenticate(r *http.Request) (*User, error)
+}
+
+// errUnauthenticated is the error for requests that do not identify a user.
+var errUnauthenticated = errors.New("missing or invalid bearer token")
+
+// bearerToken returns r's bearer token, or "".
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// TokenAuth authenticates the requests that present Token as a bearer token, all as the same admin user.
+type TokenAuth struct {
+	Token string
+}
+
+// tokenUser is the user of TokenAuth, and of a Server without an Authenticator.
+var tokenUser = &User{Name: "admin", Admin: true}
+
+func (a *TokenAuth) Authenticate(r *http.Request) (*User, error) {
+	token := bearerToken(r)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+		return nil, errUnauthenticated
+	}
+	return tokenUser, nil
+}
+
+// Users are a team's users, as loaded from a users file by LoadUsers.
+// They authenticate with bearer tokens, or with ID tokens from an OIDC provider.
+type Users struct {
+	// OIDC, if set, is the provider whose ID tokens users may present as bearer tokens.
+	OIDC *OIDC `yaml:"oidc"`
+	// Users are the users, by name; for OIDC, by the value of OIDC.Claim.
+	Users map[string]*User `yaml:"users"`
+
+	byHash map[string]*User
+}
+
+// LoadUsers reads and validates the users file at path:
+//
+//	oidc:                              # optional
+//	  issuer: https://accounts.example.com
+//	  audience: sketch
+//	  claim: email                     # the claim that names the user; email by default
+//	users:
+//	  alice@example.com:
+//	    admin: true
+//	  bob@example.com:
+//	    token_sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    workspace: /srv/sketch/bob
+//	    max_dollars: 50
+//
+// Users who are not admins must have a workspace; see Server for what it does and does not keep them from.
+func LoadUsers(path string) (*Users, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var u Users
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&u); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := u.init(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &u, nil
+}
+
+// init checks u and indexes its users' tokens.
+func (u *Users) init() error {
+	if len(u.Users) == 0 {
+		return errors.New("no users")
+	}
+	if u.OIDC != nil {
+		if err := u.OIDC.check(); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	u.byHash = make(map[string]*User)
+	for _, name := range slices.Sorted(maps.Keys(u.Users)) {
+		user := u.Users[name]
+		if user == nil {
+			user = new(User)
+			u.Users[name] = user
+		}
+		user.Name = name
+		if user.TokenSHA256 != "" {
+			hash := strings.ToLower(user.TokenSHA256)
+			if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+				errs = append(errs, fmt.Errorf("users.%s: token_sha256 is not a hex SHA-256 hash", name))
+			} else if other := u.byHash[hash]; other != nil {
+				errs = append(errs, fmt.Errorf("users.%s: same token as %s", name, other.Name))
+			} else {
+				u.byHash[hash] = user
+			}
+		} else if u.OIDC == nil {
+			errs = append(errs, fmt.Errorf("users.%s: token_sha256 is required without oidc", name))
+		}
+		if user.Workspace == "" && !user.Admin {
+			errs = append(errs, fmt.Errorf("users.%s: workspace is required for users who are not admins", name))
+		}
+		if user.Workspace != "" && !filepath.IsAbs(user.Workspace) {
+			errs = append(errs, fmt.Errorf("users.%s: workspace %q is not an absolute path", name, user.Workspace))
+		}
+		if user.MaxDollars < 0 {
+			errs = append(errs, fmt.Errorf("users.%s: max_dollars is negative", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (u *Users) Authenticate(r *http.Request) (*User, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errUnauthenticated
+	}
+	sum := sha256.Sum256([]byte(token))
+	if user := u.byHash[hex.EncodeToString(sum[:])]; user != nil {
+		return user, nil
+	}
+	if u.OIDC == nil || strings.Count(token, ".") != 2 {
+		return nil, errUnauthenticated
+	}
+	name, err := u.OIDC.Verify(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	user := u.Users[name]
+	if user == nil {
+		return nil, fmt.Errorf("%s is not a user of this server", name)
+	}
+	return user, nil
+}
+
+// checkWorkspace reports whether user may start a session in dir, which checkWorkingDir accepted.
+// It checks only the directory that the session starts in; the session's commands can leave it.
+func checkWorkspace(user *User, dir string) error {
+	if user.Workspace == "" {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(user.Workspace)
+	if err != nil {
+		return fmt.Errorf("your workspace: %w", err)
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, real); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("working_dir must be inside your workspace, %s", user.Workspace)
+	}
+	return nil
+}
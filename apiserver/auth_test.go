@@ -0,0 +1,366 @@
+package apiserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// costHandler is a session handler that has spent cost cents.
+type costHandler struct {
+	http.Handler
+	cost *atomic.Int64
+}
+
+func (h costHandler) CostUSD() float64 { return float64(h.cost.Load()) / 100 }
+
+func TestUsers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root := t.TempDir()
+	for _, dir := range []string{"alice/repo", "bob"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(root, "users.yaml")
+	users := "users:\n" +
+		"  root:\n    admin: true\n    token_sha256: " + hashToken("root-token") + "\n" +
+		"  alice:\n    token_sha256: " + hashToken("alice-token") + "\n    workspace: " + filepath.Join(root, "alice") + "\n    max_dollars: 5\n" +
+		"  bob:\n    token_sha256: " + hashToken("bob-token") + "\n    workspace: " + filepath.Join(root, "bob") + "\n"
+	if err := os.WriteFile(path, []byte(users), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := LoadUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var started []CreateRequest
+	costs := make(map[string]*atomic.Int64) // cents, by session ID
+	start := func(ctx context.Context, id string, req CreateRequest) (http.Handler, error) {
+		started = append(started, req)
+		costs[id] = new(atomic.Int64)
+		return costHandler{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, id)
+		}), costs[id]}, nil
+	}
+	api := New(ctx, start, auth)
+	var auditFile strings.Builder
+	api.AuditTo(&auditFile)
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	do := func(method, path, token, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	if code, _ := do("GET", "/sessions", "mallory-token", ""); code != http.StatusUnauthorized {
+		t.Errorf("GET /sessions with an unknown token = %d, want 401", code)
+	}
+	if code, body := do("POST", "/sessions", "alice-token", `{"working_dir": "`+filepath.Join(root, "bob")+`"}`); code != http.StatusForbidden {
+		t.Errorf("POST /sessions outside the workspace = %d %s, want 403", code, body)
+	}
+	create := func(req string) Session {
+		t.Helper()
+		code, body := do("POST", "/sessions", "alice-token", req)
+		if code != http.StatusCreated {
+			t.Fatalf("POST /sessions %s = %d %s", req, code, body)
+		}
+		var sess Session
+		if err := json.Unmarshal([]byte(body), &sess); err != nil {
+			t.Fatal(err)
+		}
+		return sess
+	}
+	sess := create(`{"working_dir": "` + filepath.Join(root, "alice/repo") + `", "max_dollars": 2}`)
+	if sess.Owner != "alice" || started[0].Owner != "alice" || started[0].MaxDollars != 2 {
+		t.Errorf("created session = %+v, started with %+v", sess, started[0])
+	}
+
+	// Bob cannot see or act on Alice's session; the admin can.
+	if code, body := do("GET", "/sessions", "bob-token", ""); code != http.StatusOK || body != "[]\n" {
+		t.Errorf("bob's GET /sessions = %d %s", code, body)
+	}
+	if code, _ := do("GET", "/sessions/"+sess.ID, "bob-token", ""); code != http.StatusNotFound {
+		t.Errorf("bob's GET of alice's session = %d, want 404", code)
+	}
+	if code, _ := do("DELETE", "/sessions/"+sess.ID, "bob-token", ""); code != http.StatusNotFound {
+		t.Errorf("bob's DELETE of alice's session = %d, want 404", code)
+	}
+	if code, body := do("GET", "/sessions/"+sess.ID, "root-token", ""); code != http.StatusOK || !strings.Contains(body, `"owner":"alice"`) {
+		t.Errorf("admin's GET of alice's session = %d %s", code, body)
+	}
+
+	// Alice's first session holds the $2 it asked for of her $5; the next ones hold a quarter of it, or what is left.
+	costs[sess.ID].Store(150)
+	if code, body := do("GET", "/me", "alice-token", ""); code != http.StatusOK || !strings.Contains(body, `"spent_usd":1.5`) {
+		t.Errorf("GET /me = %d %s", code, body)
+	}
+	create(`{"working_dir": "` + filepath.Join(root, "alice") + `"}`)
+	third := create(`{"working_dir": "` + filepath.Join(root, "alice") + `", "max_dollars": 2}`)
+	if started[1].MaxDollars != 1.25 || started[2].MaxDollars != 1.75 {
+		t.Errorf("second and third sessions started with %+v and %+v, want $1.25 and the $1.75 left", started[1], started[2])
+	}
+	if code, body := do("POST", "/sessions", "alice-token", `{"working_dir": "`+filepath.Join(root, "alice")+`"}`); code != http.StatusForbidden {
+		t.Errorf("POST /sessions with all of the budget set aside = %d %s, want 403", code, body)
+	}
+
+	// The first session may chat until it has spent what it holds; then, only if the budget can top it up.
+	chat := func(id string, want int) {
+		t.Helper()
+		if code, body := do("POST", "/sessions/"+id+"/chat", "alice-token", `{"message": "more"}`); code != want {
+			t.Errorf("POST /chat = %d %s, want %d", code, body, want)
+		}
+	}
+	chat(sess.ID, http.StatusOK)
+	costs[sess.ID].Store(200)
+	chat(sess.ID, http.StatusForbidden)
+	if code, _ := do("DELETE", "/sessions/"+third.ID, "alice-token", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE /sessions/{id} = %d", code)
+	}
+	chat(sess.ID, http.StatusOK)
+
+	// What the sessions spent counts once they end; what they held but did not spend is available again.
+	costs[sess.ID].Store(350)
+	if code, _ := do("DELETE", "/sessions/"+sess.ID, "alice-token", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE /sessions/{id} = %d", code)
+	}
+	create(`{"working_dir": "` + filepath.Join(root, "alice") + `", "max_dollars": 2}`)
+	if started[3].MaxDollars != 0.25 {
+		t.Errorf("session after $3.50 was spent and $1.25 is held started with %+v, want the $0.25 left", started[3])
+	}
+
+	// Users see their own audit entries, and the admin sees everyone's.
+	var entries []AuditEntry
+	_, body := do("GET", "/audit", "bob-token", "")
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].User != "bob" || entries[0].Method != "DELETE" || entries[0].Status != http.StatusNotFound {
+		t.Errorf("bob's GET /audit = %+v", entries)
+	}
+	_, body = do("GET", "/audit?user=alice", "root-token", "")
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 11 || entries[1].Session != sess.ID || entries[1].Status != http.StatusCreated || entries[1].Detail != filepath.Join(root, "alice/repo") {
+		t.Errorf("admin's GET /audit?user=alice = %+v", entries)
+	}
+	if lines := strings.Count(auditFile.String(), "\n"); lines != 13 {
+		t.Errorf("audit file has %d entries, want 13:\n%s", lines, auditFile.String())
+	}
+}
+
+// TestBudgetConcurrentCreates checks that sessions created at once together hold at most the budget.
+func TestBudgetConcurrentCreates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root := t.TempDir()
+	path := filepath.Join(root, "users.yaml")
+	users := "users:\n  alice:\n    token_sha256: " + hashToken("alice-token") + "\n    workspace: " + root + "\n    max_dollars: 5\n"
+	if err := os.WriteFile(path, []byte(users), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := LoadUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var held float64
+	start := func(ctx context.Context, id string, req CreateRequest) (http.Handler, error) {
+		mu.Lock()
+		held += req.MaxDollars
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond) // so that the creates overlap
+		return costHandler{http.NotFoundHandler(), new(atomic.Int64)}, nil
+	}
+	srv := httptest.NewServer(New(ctx, start, auth))
+	defer srv.Close()
+
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequest("POST", srv.URL+"/sessions", strings.NewReader(`{"working_dir": "`+root+`"}`))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.Header.Set("Authorization", "Bearer alice-token")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusCreated {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	// Each holds a quarter of the budget.
+	if n := created.Load(); n != 4 || held != 5 {
+		t.Errorf("%d of 5 concurrent POST /sessions were created, holding $%.2f; want 4, holding $5", n, held)
+	}
+}
+
+func TestLoadUsersErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, users, want string
+	}{
+		{"no users", "users: {}\n", "no users"},
+		{"no token", "users:\n  bob:\n    workspace: /srv/bob\n", "token_sha256 is required"},
+		{"bad hash", "users:\n  bob:\n    token_sha256: abc\n    workspace: /srv/bob\n", "not a hex SHA-256 hash"},
+		{"no workspace", "users:\n  bob:\n    token_sha256: " + hashToken("t") + "\n", "workspace is required"},
+		{"same token", "users:\n  a:\n    admin: true\n    token_sha256: " + hashToken("t") + "\n  b:\n    admin: true\n    token_sha256: " + hashToken("t") + "\n", "same token as a"},
+		{"unknown field", "users:\n  a:\n    admin: true\n    password: x\n", "password"},
+		{"oidc without audience", "oidc:\n  issuer: https://example.com\nusers:\n  a:\n    admin: true\n", "audience is required"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.yaml")
+			if err := os.WriteFile(path, []byte(tt.users), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadUsers(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadUsers = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	var issuer string
+	var jwksFetches atomic.Int32
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"jwks_uri": issuer + "/jwks"})
+		case "/jwks":
+			jwksFetches.Add(1)
+			time.Sleep(10 * time.Millisecond) // so that the requests below wait on the fetch together
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+				"kty": "RSA", "kid": "k1", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer provider.Close()
+	issuer = provider.URL
+
+	sign := func(claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		signed := b64(header) + "." + b64(payload)
+		digest := sha256.Sum256([]byte(signed))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed + "." + b64(sig)
+	}
+	claims := func(edit func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss": issuer, "aud": "sketch", "email": "alice@example.com", "email_verified": true,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+
+	users := &Users{
+		OIDC:  &OIDC{Issuer: issuer, Audience: "sketch", Client: provider.Client()},
+		Users: map[string]*User{"alice@example.com": {Admin: true}},
+	}
+	if err := users.init(); err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(token string) (*User, error) {
+		r := httptest.NewRequest("GET", "/sessions", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return users.Authenticate(r)
+	}
+
+	// Concurrent requests share one fetch of the provider's keys.
+	token := sign(claims(nil))
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if user, err := authenticate(token); err != nil || user.Name != "alice@example.com" {
+				t.Errorf("Authenticate = %v, %v", user, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := jwksFetches.Load(); n != 1 {
+		t.Errorf("fetched the provider's keys %d times, want once", n)
+	}
+	if user, err := authenticate(sign(claims(func(c map[string]any) { c["aud"] = []string{"other", "sketch"} }))); err != nil || user == nil {
+		t.Errorf("Authenticate with an audience list = %v, %v", user, err)
+	}
+	for _, tt := range []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"expired", sign(claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), "expired"},
+		{"wrong issuer", sign(claims(func(c map[string]any) { c["iss"] = "https://evil.example.com" })), "issued by"},
+		{"wrong audience", sign(claims(func(c map[string]any) { c["aud"] = "other" })), "audience"},
+		{"unverified email", sign(claims(func(c map[string]any) { c["email_verified"] = false })), "not verified"},
+		{"not a user", sign(claims(func(c map[string]any) { c["email"] = "mallory@example.com" })), "not a user"},
+		{"bad signature", sign(claims(nil))[:len(sign(claims(nil)))-4] + "AAAA", "signature"},
+	} {
+		if _, err := authenticate(tt.token); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Authenticate = %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package apiserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// OIDC verifies ID tokens issued by an OpenID Connect provider, signed with RS256 or ES256.
+type OIDC struct {
+	// Issuer is the provider's issuer URL; its keys are found by OpenID Connect discovery.
+	Issuer string `yaml:"issuer"`
+	// Audience is the client ID that tokens must be issued for.
+	Audience string `yaml:"audience"`
+	// Claim is the claim that names the user; email by default.
+	// Tokens with an email_verified claim that is false are refused.
+	Claim string `yaml:"claim"`
+
+	Client *http.Client `yaml:"-"` // if nil, http.DefaultClient is used
+
+	fetches singleflight.Group
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // by key ID
+	fetched time.Time
+}
+
+// keyRefreshInterval is how often the provider's keys may be fetched again, for tokens signed with keys not yet seen.
+const keyRefreshInterval = time.Minute
+
+// clockSkew is how far the provider's clock may be from ours.
+const clockSkew = time.Minute
+
+func (o *OIDC) check() error {
+	if !strings.HasPrefix(o.Issuer, "https://") && !strings.HasPrefix(o.Issuer, "http://") {
+		return fmt.Errorf("oidc: issuer %q is not a URL", o.Issuer)
+	}
+	if o.Audience == "" {
+		return errors.New("oidc: audience is required")
+	}
+	return nil
+}
+
+// Verify checks the ID token, and returns the value of its Claim.
+func (o *OIDC) Verify(ctx context.Context, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("oidc: malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("oidc: token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("oidc: token signature: %w", err)
+	}
+	key, err := o.key(ctx, header.Kid)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, digest[:], sig); err != nil {
+		return "", err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("oidc: token claims: %w", err)
+	}
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); iss != o.Issuer {
+		return "", fmt.Errorf("oidc: token issued by %q, not %q", iss, o.Issuer)
+	}
+	if !hasAudience(claims["aud"], o.Audience) {
+		return "", fmt.Errorf("oidc: token is not for audience %q", o.Audience)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return "", errors.New("oidc: token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return "", errors.New("oidc: token not yet valid")
+	}
+	claim := o.Claim
+	if claim == "" {
+		claim = "email"
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified && claim == "email" {
+		return "", errors.New("oidc: email not verified")
+	}
+	name, _ := claims[claim].(string)
+	if name == "" {
+		return "", fmt.Errorf("oidc: token has no %s claim", claim)
+	}
+	return name, nil
+}
+
+// hasAudience reports whether aud, a token's aud claim, is or includes audience.
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifySignature checks sig, made with alg over digest, against key.
+func verifySignature(alg string, key crypto.PublicKey, digest, sig []byte) error {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, sig) == nil {
+			return nil
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if ok && len(sig) == 64 {
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(ecKey, digest, r, s) {
+				return nil
+			}
+		}
+	default:
+		return fmt.Errorf("oidc: unsupported signing algorithm %q", alg)
+	}
+	return errors.New("oidc: invalid token signature")
+}
+
+// key returns the provider's key with the ID kid, fetching the provider's keys if it has not seen it.
+// The keys are fetched without holding o.mu, so that tokens signed with known keys are verified meanwhile,
+// and once for all the requests that need them.
+func (o *OIDC) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	key, ok := o.keys[kid]
+	recent := time.Since(o.fetched) < keyRefreshInterval
+	o.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if recent {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+	_, err, _ := o.fetches.Do("keys", func() (any, error) {
+		// The fetch is shared, so it must not end when the request that started it does.
+		keys, err := o.fetchKeys(context.WithoutCancel(ctx))
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.fetched = time.Now()
+		if err == nil {
+			o.keys = keys
+		}
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	key, ok = o.keys[kid]
+	o.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+// fetchKeys fetches the provider's signing keys, from the JWKS that its discovery document names.
+func (o *OIDC) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := o.getJSON(ctx, strings.TrimSuffix(o.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+func (o *OIDC) getJSON(ctx context.Context, url string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("oidc: %w", err)
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oidc: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("oidc: GET %s: %w", url, err)
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a token into v.
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,233 @@
+package claudetool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sketch.dev/llm/conversation"
+)
+
+// maxAutoCommitDiff is how much of the diff AutoCommit shows the LLM.
+const maxAutoCommitDiff = 100 << 10
+
+// conventionalCommit matches the subject of a conventional commit, such as "fix(parser): handle empty input".
+var conventionalCommit = regexp.MustCompile(`^(feat|fix|refactor|perf|test|docs|style|build|ci|chore|revert)(\([^)]+\))?!?: \S`)
+
+// An autoCommit is one commit that AutoCommit makes.
+type autoCommit struct {
+	Message string   `json:"message"`
+	Files   []string `json:"files"`
+}
+
+// AutoCommit commits the uncommitted changes to paths, the files the agent wrote, in repoRoot as a series of small commits,
+// one per logical change, with conventional-commit messages written by the LLM in a subconversation of convo.
+// It returns the subjects of the commits it made, in order.
+//
+// Other changes in the repository, such as the user's own work, are not committed, and the index is left alone
+// except for paths, which match the new commits afterwards. Paths are taken literally, not as git pathspecs.
+// Those outside repoRoot, and those that git ignores, are skipped.
+func AutoCommit(ctx context.Context, convo *conversation.Convo, repoRoot string, paths []string) ([]string, error) {
+	var rel []string
+	for _, p := range paths {
+		if r, err := filepath.Rel(repoRoot, p); err == nil && filepath.IsLocal(r) && !slices.Contains(rel, r) {
+			rel = append(rel, r)
+		}
+	}
+	if len(rel) == 0 {
+		return nil, nil
+	}
+
+	// The commits are built in an index of their own, starting from HEAD, so that the user's index,
+	// with whatever they staged, is not committed or disturbed.
+	index, err := os.CreateTemp("", "sketch-autocommit-index")
+	if err != nil {
+		return nil, err
+	}
+	index.Close()
+	defer os.Remove(index.Name())
+	env := []string{"GIT_INDEX_FILE=" + index.Name(), "GIT_LITERAL_PATHSPECS=1"}
+	git := func(args ...string) (string, error) {
+		return gitOutput(ctx, repoRoot, env, args...)
+	}
+	readHEAD := func() error {
+		if _, err := git("rev-parse", "--verify", "-q", "HEAD"); err != nil {
+			_, err = git("read-tree", "--empty") // no commits yet
+			return err
+		}
+		_, err := git("read-tree", "HEAD")
+		return err
+	}
+	if err := readHEAD(); err != nil {
+		return nil, err
+	}
+	if rel = addable(ctx, repoRoot, env, rel); len(rel) == 0 {
+		return nil, nil
+	}
+	if _, err := git(append([]string{"add", "-A", "--"}, rel...)...); err != nil {
+		return nil, err
+	}
+	// Without renames, a renamed file is a deleted path and an added one, each of which can be committed.
+	out, err := git("diff", "--cached", "--no-renames", "--name-only", "-z")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	files := strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+	diff, err := git("diff", "--cached", "--no-renames", "--stat", "--patch")
+	if err != nil {
+		return nil, err
+	}
+	if len(diff) > maxAutoCommitDiff {
+		diff = diff[:maxAutoCommitDiff] + "\n[diff truncated]"
+	}
+
+	commits, err := groupChanges(ctx, convo, files, diff)
+	if err != nil {
+		return nil, err
+	}
+	// Each commit adds its files to the one before: the index starts again from HEAD.
+	if err := readHEAD(); err != nil {
+		return nil, err
+	}
+	var subjects []string
+	var committed []string
+	defer func() {
+		// The user's index gets the committed files as they are now in HEAD, so they do not show as staged reversions.
+		if len(committed) > 0 {
+			args := append([]string{"reset", "-q", "--"}, committed...)
+			if _, err := gitOutput(ctx, repoRoot, []string{"GIT_LITERAL_PATHSPECS=1"}, args...); err != nil {
+				slog.WarnContext(ctx, "autocommit_index_not_updated", "err", err)
+			}
+		}
+	}()
+	for _, c := range commits {
+		if _, err := git(append([]string{"add", "-A", "--"}, c.Files...)...); err != nil {
+			return subjects, err
+		}
+		if _, err := git("diff", "--cached", "--quiet"); err == nil {
+			continue // nothing staged
+		}
+		if _, err := git("commit", "-q", "-m", c.Message); err != nil {
+			return subjects, err
+		}
+		committed = append(committed, c.Files...)
+		subject, _, _ := strings.Cut(c.Message, "\n")
+		subjects = append(subjects, subject)
+	}
+	return subjects, nil
+}
+
+// addable returns the paths in rel that git add can take, running git with env, which selects the index that starts from HEAD:
+// those in HEAD, whether or not they still exist, and the others that exist and are not ignored.
+// A file that the agent wrote and then removed, and that was never committed, is not a change.
+func addable(ctx context.Context, repoRoot string, env []string, rel []string) []string {
+	var paths []string
+	for _, r := range rel {
+		if _, err := gitOutput(ctx, repoRoot, env, "ls-files", "--error-unmatch", "--", r); err == nil {
+			paths = append(paths, r)
+		} else if _, err := os.Lstat(filepath.Join(repoRoot, r)); err == nil {
+			// check-ignore takes paths, not pathspecs, and refuses GIT_LITERAL_PATHSPECS.
+			if _, err := gitOutput(ctx, repoRoot, nil, "check-ignore", "-q", "--", r); err != nil {
+				paths = append(paths, r)
+			}
+		}
+	}
+	return paths
+}
+
+// groupChanges asks the LLM to group the changed files into commits and write their messages.
+// Every file is in exactly one commit, and every message is a conventional commit.
+func groupChanges(ctx context.Context, convo *conversation.Convo, files []string, diff string) ([]autoCommit, error) {
+	sub := convo.SubConvo()
+	sub.Hidden = true
+	sub.PromptCaching = false
+	sub.Task = "autocommit"
+	sub.SystemPrompt = `You group a coding agent's uncommitted changes into small commits, each one logical change,
+so that a reviewer can read the work as a series of commits instead of one large diff.
+
+For each commit, write a conventional commit message: a subject like "feat(parser): accept trailing commas",
+using one of the types feat, fix, refactor, perf, test, docs, style, build, ci, or chore,
+and, if it helps, a blank line and a short body explaining why.
+Order the commits so that each builds on the ones before it.
+Every file must be in exactly one commit.
+
+Reply with ONLY a JSON array, without commentary: [{"message": "...", "files": ["path", ...]}, ...]`
+
+	prompt := fmt.Sprintf("<files>\n%s\n</files>\n\n<diff>\n%s\n</diff>", strings.Join(files, "\n"), diff)
+	resp, err := sub.SendUserTextMessage(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to group changes into commits: %w", err)
+	}
+	var text strings.Builder
+	for _, c := range resp.Content {
+		text.WriteString(c.Text)
+	}
+	return parseAutoCommits(text.String(), files)
+}
+
+// parseAutoCommits parses the LLM's reply to groupChanges.
+// It drops files that did not change and files already in an earlier commit,
+// adds files the LLM left out to the last commit, and makes messages conventional commits.
+func parseAutoCommits(reply string, files []string) ([]autoCommit, error) {
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no commits in the reply: %q", reply)
+	}
+	var commits []autoCommit
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &commits); err != nil {
+		return nil, fmt.Errorf("malformed commits in the reply: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var grouped []autoCommit
+	for _, c := range commits {
+		c.Files = slices.DeleteFunc(c.Files, func(f string) bool {
+			drop := seen[f] || !slices.Contains(files, f)
+			seen[f] = true
+			return drop
+		})
+		c.Message = strings.TrimSpace(c.Message)
+		if len(c.Files) == 0 || c.Message == "" {
+			continue
+		}
+		if !conventionalCommit.MatchString(c.Message) {
+			c.Message = "chore: " + c.Message
+		}
+		grouped = append(grouped, c)
+	}
+	if len(grouped) == 0 {
+		return []autoCommit{{Message: fmt.Sprintf("chore: update %d files", len(files)), Files: files}}, nil
+	}
+	for _, f := range files {
+		if !seen[f] {
+			last := &grouped[len(grouped)-1]
+			last.Files = append(last.Files, f)
+		}
+	}
+	return grouped, nil
+}
+
+// gitOutput runs git in dir, with env added to its environment, and returns its standard output.
+func gitOutput(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %w", args[0], strings.TrimSpace(stderr.String()), err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,121 @@
+package claudetool
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"sketch.dev/llm/conversation"
+	"sketch.dev/llm/llmtest"
+)
+
+func TestParseAutoCommits(t *testing.T) {
+	files := []string{"a.go", "a_test.go", "README.md", "go.mod"}
+	reply := `Here you go:
+[
+  {"message": "feat(a): add A", "files": ["a.go", "a_test.go", "gone.go"]},
+  {"message": "document A", "files": ["README.md", "a.go"]},
+  {"message": "fix: nothing", "files": []}
+]`
+	commits, err := parseAutoCommits(reply, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []autoCommit{
+		{Message: "feat(a): add A", Files: []string{"a.go", "a_test.go"}},
+		{Message: "chore: document A", Files: []string{"README.md", "go.mod"}},
+	}
+	if len(commits) != len(want) {
+		t.Fatalf("got %+v, want %+v", commits, want)
+	}
+	for i := range want {
+		if commits[i].Message != want[i].Message || !slices.Equal(commits[i].Files, want[i].Files) {
+			t.Errorf("commit %d = %+v, want %+v", i, commits[i], want[i])
+		}
+	}
+
+	if _, err := parseAutoCommits("I can't do that.", files); err == nil {
+		t.Error("parsed a reply without commits")
+	}
+	commits, err = parseAutoCommits("[]", files)
+	if err != nil || len(commits) != 1 || !slices.Equal(commits[0].Files, files) {
+		t.Errorf("empty reply = %+v, %v; want one commit of every file", commits, err)
+	}
+}
+
+func TestAutoCommit(t *testing.T) {
+	repo := t.TempDir()
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+		return string(out)
+	}
+	git("init", "-q")
+	write := func(name, data string) string {
+		t.Helper()
+		path := filepath.Join(repo, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("old.txt", "old\n")
+	write("user.txt", "user\n")
+	write(".gitignore", "*.log\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	// The agent removes old.txt and writes three files, one of them ignored, and one whose name is a glob pattern.
+	old := filepath.Join(repo, "old.txt")
+	os.Remove(old)
+	wrote := []string{old, write("a.go", "package a\n"), write("[n]otes.md", "notes\n"), write("build.log", "log\n"), "/elsewhere/x.go"}
+	// The user has their own work: a staged change, an unstaged one, and a file that the agent's pattern would match.
+	write("user.txt", "user staged\n")
+	git("add", "user.txt")
+	write("user.txt", "user unstaged\n")
+	write("notes.md", "mine\n")
+
+	srv := llmtest.NewService(llmtest.Turn{
+		Expect: "[n]otes.md",
+		Text:   `[{"message": "feat: add package a", "files": ["a.go"]}, {"message": "docs: add notes", "files": ["[n]otes.md", "notes.md", "user.txt"]}]`,
+	})
+	convo := conversation.New(context.Background(), srv, nil)
+	subjects, err := AutoCommit(context.Background(), convo, repo, wrote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"feat: add package a", "docs: add notes"}; !slices.Equal(subjects, want) {
+		t.Errorf("subjects = %q, want %q", subjects, want)
+	}
+	if log := git("log", "--format=%s"); log != "docs: add notes\nfeat: add package a\ninitial\n" {
+		t.Errorf("log = %q", log)
+	}
+	// old.txt, which the LLM left out, is in the last commit; the user's files are in none.
+	if files := git("show", "--name-only", "--format=", "HEAD"); files != "[n]otes.md\nold.txt\n" {
+		t.Errorf("last commit has %q, want [n]otes.md and old.txt", files)
+	}
+	// The user's work is as it was, staged or not.
+	if status := git("status", "--porcelain"); status != "MM user.txt\n?? notes.md\n" {
+		t.Errorf("status after AutoCommit = %q, want only the user's changes", status)
+	}
+	if staged := git("show", ":user.txt"); staged != "user staged\n" {
+		t.Errorf("user's staged user.txt = %q", staged)
+	}
+
+	subjects, err = AutoCommit(context.Background(), convo, repo, wrote)
+	if err != nil || len(subjects) != 0 {
+		t.Errorf("AutoCommit with nothing to commit = %q, %v", subjects, err)
+	}
+}
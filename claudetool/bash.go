@@ -2,8 +2,10 @@ package claudetool
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,15 +13,22 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/creack/pty"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"sketch.dev/claudetool/bashkit"
+	"sketch.dev/credentials"
 	"sketch.dev/llm"
 	"sketch.dev/llm/conversation"
+	"sketch.dev/notify"
+	"sketch.dev/statedb"
 )
 
 // PermissionCallback is a function type for checking if a command is allowed to run
@@ -31,6 +40,42 @@ type BashTool struct {
 	CheckPermission PermissionCallback
 	// EnableJITInstall enables just-in-time tool installation for missing commands
 	EnableJITInstall bool
+	// Notifier, if set, is told about foreground commands that run longer than NotifyAfter.
+	Notifier notify.Notifier
+	// NotifyAfter is how long a foreground command runs before Notifier is told about it.
+	// Zero means 30s.
+	NotifyAfter time.Duration
+	// State, if set, records commands and background jobs, keeps background job output,
+	// and remembers which missing commands installation was attempted for
+	// and how often each category of command had its output truncated.
+	State *statedb.Session
+	// AutoRewrite rewrites foreground commands whose category often has its output truncated
+	// to print less, before running them. Either way, truncated output comes with a suggested rewrite.
+	AutoRewrite bool
+	// Supervisor, if set, runs background commands under the system's service manager, when there is one.
+	Supervisor *Supervisor
+	// Versions, if set, records the files that foreground commands read, and the changes they make to them.
+	Versions *FileVersions
+	// Env are environment variables for every command, beneath the session's overrides.
+	Env map[string]string
+	// Timeout and BackgroundTimeout, if set, replace the default timeouts
+	// of foreground and background commands that do not set their own.
+	Timeout           time.Duration
+	BackgroundTimeout time.Duration
+	// Output, if set, receives the output of foreground commands while they run,
+	// in pieces no more often than every outputInterval, for UIs to show it live.
+	Output func(ctx context.Context, output string)
+	// JobCrashed, if set, is called when a background job exits on its own, before it is stopped,
+	// unless it is known to have succeeded. err says how it exited, and output is the end of its output.
+	JobCrashed func(ctx context.Context, job JobInfo, err error, output string)
+	// Cluster, if set, runs the foreground commands whose input sets cluster,
+	// such as full builds and integration suites, elsewhere; see the kubejob package.
+	Cluster Runner
+
+	jobs     jobRegistry
+	env      envState
+	outputMu sync.Mutex
+	output   map[bashkit.Category]statedb.OutputStats // used when State is nil
 }
 
 const (
@@ -44,15 +89,42 @@ func NewBashTool(checkPermission PermissionCallback, enableJITInstall bool) *llm
 		CheckPermission:  checkPermission,
 		EnableJITInstall: enableJITInstall,
 	}
+	return tool.Tool()
+}
 
+// Tool returns an llm.Tool based on b.
+func (b *BashTool) Tool() *llm.Tool {
 	return &llm.Tool{
 		Name:        bashName,
 		Description: strings.TrimSpace(bashDescription),
-		InputSchema: llm.MustSchema(bashInputSchema),
-		Run:         tool.Run,
+		InputSchema: b.inputSchema(),
+		// Commands change the worktree, and read what the calls before them wrote.
+		Serial: true,
+		Run:    b.Run,
+		Retry:  b.retry,
 	}
 }
 
+// inputSchema returns the bash tool's input schema, with the cluster input if b has a Cluster.
+func (b *BashTool) inputSchema() json.RawMessage {
+	if b.Cluster == nil {
+		return llm.MustSchema(bashInputSchema)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(bashInputSchema), &schema); err != nil {
+		panic(err)
+	}
+	schema["properties"].(map[string]any)["cluster"] = map[string]any{
+		"type":        "boolean",
+		"description": bashClusterDescription,
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
 // The Bash tool executes shell commands with bash -c and optional timeout
 var Bash = NewBashTool(nil, NoBashToolJITInstall)
 
@@ -62,8 +134,17 @@ const (
 Executes a shell command using bash -c with an optional timeout, returning combined stdout and stderr.
 When run with background flag, the process may keep running after the tool call returns, and
 the agent can inspect the output by reading the output files. Use the background task when, for example,
-starting a server to test something. Be sure to kill the process group when done.
+starting a server to test something. Be sure to kill the process group when done, or, if the result
+has a stop_command, run it; it also stops processes that left the group.
+Give a background command a ready probe to say when it is ready, for example when the server it starts
+accepts connections, and list its job number in wait_for of the commands that need it,
+instead of sleeping or polling: they wait until it is ready, and fail if it exits or never becomes ready.
 `
+	bashClusterDescription = "If true, runs the command as a Kubernetes Job, on a snapshot of the workspace taken when the command starts, " +
+		"on a node with more CPU and memory than this one. Use it for full builds and integration test suites. " +
+		"What the command changes is not copied back, and it cannot reach this machine's background commands. " +
+		"Scheduling takes a while, so the timeout defaults to 10m. Not for background commands"
+
 	// If you modify this, update the termui template for prettier rendering.
 	bashInputSchema = `
 {
@@ -76,11 +157,50 @@ starting a server to test something. Be sure to kill the process group when done
     },
     "timeout": {
       "type": "string",
-      "description": "Timeout as a Go duration string, defaults to 10s if background is false; 10m if background is true"
+      "description": "Timeout as a Go duration string, defaults to 10s if background is false; 10m if background is true or idle_timeout is set"
+    },
+    "idle_timeout": {
+      "type": "string",
+      "description": "If set, a Go duration string: kill the command only after it prints nothing for this long. Use it for builds and test suites that stream progress but whose total duration is unpredictable. Ignored if background is true"
+    },
+    "cpu_timeout": {
+      "type": "string",
+      "description": "If set, a Go duration string: kill the command once it and its children have used this much CPU time. Unlike timeout, time spent waiting on I/O, the network, or a port doesn't count, so use it with a generous timeout to stop runaway loops early without killing slow but healthy commands. Ignored if background is true"
     },
     "background": {
       "type": "boolean",
       "description": "If true, executes the command in the background without waiting for completion"
+    },
+    "tail": {
+      "type": "boolean",
+      "description": "If true, output too long to return is cut to its end, where failures usually are, instead of failing the command. Ignored if background is true"
+    },
+    "ready": {
+      "type": "object",
+      "description": "For background commands: when the job is ready. Every condition given must hold",
+      "properties": {
+        "port": {
+          "type": "integer",
+          "description": "A TCP port on localhost that accepts connections once the job is ready"
+        },
+        "http_path": {
+          "type": "string",
+          "description": "With port, a path that answers GET with 200 OK once the job is ready, such as /healthz"
+        },
+        "log": {
+          "type": "string",
+          "description": "A Go regular expression that a line of the job's output matches once it is ready"
+        },
+        "timeout": {
+          "type": "string",
+          "description": "How long the job has to become ready, as a Go duration string; defaults to 2m"
+        }
+      }
+    },
+    "wait_for": {
+      "type": "array",
+      "items": {"type": "integer"},
+      "description": "Job numbers of background commands that must be ready before this command runs"
     }
   }
 }
@@ -88,15 +208,154 @@ starting a server to test something. Be sure to kill the process group when done
 )
 
 type bashInput struct {
-	Command    string `json:"command"`
-	Timeout    string `json:"timeout,omitempty"`
-	Background bool   `json:"background,omitempty"`
+	Command     string `json:"command"`
+	Timeout     string `json:"timeout,omitempty"`
+	IdleTimeout string `json:"idle_timeout,omitempty"`
+	CPUTimeout  string `json:"cpu_timeout,omitempty"`
+	Background  bool   `json:"background,omitempty"`
+	Tail        bool   `json:"tail,omitempty"`
+	// Ready is the readiness probe of a background command.
+	Ready   *ReadyProbe `json:"ready,omitempty"`
+	WaitFor []int       `json:"wait_for,omitempty"`
+	Cluster bool        `json:"cluster,omitempty"`
+	// Env holds the session's environment overrides, as KEY=value, which take precedence over sketch's environment.
+	Env []string `json:"-"`
+	// output, if set, receives a copy of the command's output as it runs.
+	output io.Writer
+	// runner, if set, runs the command instead of this machine.
+	runner Runner
+}
+
+// environ returns the environment req's command runs in: sketch's, with SKETCH=1, extra, and req's overrides.
+func (req *bashInput) environ(extra ...string) []string {
+	env := append(os.Environ(), "SKETCH=1")
+	env = append(env, extra...)
+	return append(env, req.Env...)
 }
 
 type BackgroundResult struct {
+	SchemaVersion int `json:"schema_version"`
+	// Job numbers the background job for wait_for; Status is its status, one of the Job* constants.
+	Job        int    `json:"job,omitempty"`
+	Status     string `json:"status,omitempty"`
 	PID        int    `json:"pid"`
 	StdoutFile string `json:"stdout_file"`
 	StderrFile string `json:"stderr_file"`
+	// Unit and StopCommand are set for commands run under a Supervisor:
+	// the service manager's name for the command, and a shell command that stops it and everything it started.
+	Unit        string `json:"unit,omitempty"`
+	StopCommand string `json:"stop_command,omitempty"`
+
+	exit *jobExit
+}
+
+// A jobExit records how a background job ended, to tell crashes from jobs that finished or were stopped.
+type jobExit struct {
+	stopped atomic.Bool   // sketch stopped the job, on request or at its timeout
+	done    chan struct{} // closed once err is the job's exit status; nil if it cannot be learned
+	err     error
+}
+
+// CommandCategories classifies the command run by a tool call.
+// It returns nil for calls to tools other than bash.
+func CommandCategories(toolName string, toolInput json.RawMessage) []bashkit.Category {
+	if toolName != bashName {
+		return nil
+	}
+	var req bashInput
+	if err := json.Unmarshal(toolInput, &req); err != nil {
+		return nil
+	}
+	return bashkit.Classify(req.Command)
+}
+
+// PreviewCommand returns the command run by a bash tool call whose input may still be streaming,
+// and the error bashkit.Check reports for the command so far.
+// The error is advisory: Run checks the complete command again.
+// It returns "" for calls to tools other than bash.
+func PreviewCommand(toolName string, toolInput json.RawMessage) (string, error) {
+	if toolName != bashName || toolInput == nil {
+		return "", nil
+	}
+	var req bashInput
+	if err := json.Unmarshal(toolInput, &req); err != nil {
+		return "", nil
+	}
+	return req.Command, bashkit.Check(req.Command)
+}
+
+// setTimeout sets req's timeout to b's default for its kind of command, if it has none.
+func (b *BashTool) setTimeout(req *bashInput) {
+	if req.Timeout != "" {
+		return
+	}
+	if req.Background || req.Cluster || req.idleTimeout() > 0 || req.cpuTimeout() > 0 {
+		if b.BackgroundTimeout > 0 {
+			req.Timeout = b.BackgroundTimeout.String()
+		}
+	} else if b.Timeout > 0 {
+		req.Timeout = b.Timeout.String()
+	}
+}
+
+const (
+	// retryScale is how many times longer the limits of a command that timed out are when it runs again.
+	retryScale = 4
+	// maxRetryTimeout is the longest wall-clock timeout a command that timed out runs again with.
+	maxRetryTimeout = 10 * time.Minute
+)
+
+// rerunCategories are the kinds of commands that retry may run again:
+// running them twice does no more than running them once.
+var rerunCategories = []bashkit.Category{bashkit.CategoryBuild, bashkit.CategoryTest, bashkit.CategoryFileRead}
+
+// retry implements llm.Tool.Retry for foreground commands that only build, test, or read files.
+// A command that was killed for taking too long runs again with limits retryScale times as long,
+// and a command whose output was too long runs again keeping only the end of its output.
+func (b *BashTool) retry(err error, m json.RawMessage) (json.RawMessage, string, bool) {
+	var req bashInput
+	if json.Unmarshal(m, &req) != nil || req.Background {
+		return nil, "", false
+	}
+	cats := bashkit.Classify(req.Command)
+	if len(cats) == 0 || slices.ContainsFunc(cats, func(c bashkit.Category) bool { return !slices.Contains(rerunCategories, c) }) {
+		return nil, "", false
+	}
+	var note string
+	switch llm.ToolErrorKindOf(err) {
+	case llm.ToolErrorTimeout:
+		b.setTimeout(&req)
+		var limits []string
+		if timeout := req.timeout(); timeout < maxRetryTimeout {
+			req.Timeout = min(timeout*retryScale, maxRetryTimeout).String()
+			limits = append(limits, "timeout "+req.Timeout)
+		}
+		if idle := req.idleTimeout(); idle > 0 {
+			req.IdleTimeout = (idle * retryScale).String()
+			limits = append(limits, "idle_timeout "+req.IdleTimeout)
+		}
+		if cpu := req.cpuTimeout(); cpu > 0 {
+			req.CPUTimeout = (cpu * retryScale).String()
+			limits = append(limits, "cpu_timeout "+req.CPUTimeout)
+		}
+		if len(limits) == 0 {
+			return nil, "", false
+		}
+		note = fmt.Sprintf("[The command was killed for taking too long; sketch ran it again with %s.]", strings.Join(limits, ", "))
+	case llm.ToolErrorOutputTooLarge:
+		if req.Tail {
+			return nil, "", false
+		}
+		req.Tail = true
+		note = "[The command's output was too long; sketch ran it again with tail, keeping only the end of its output.]"
+	default:
+		return nil, "", false
+	}
+	adjusted, err := json.Marshal(req)
+	if err != nil {
+		return nil, "", false
+	}
+	return adjusted, note, true
 }
 
 func (i *bashInput) timeout() time.Duration {
@@ -108,13 +367,37 @@ func (i *bashInput) timeout() time.Duration {
 	}
 
 	// Otherwise, use different defaults based on background mode
-	if i.Background {
+	if i.Background || i.Cluster || i.idleTimeout() > 0 || i.cpuTimeout() > 0 {
 		return 10 * time.Minute
 	} else {
 		return 10 * time.Second
 	}
 }
 
+// idleTimeout returns how long a foreground command may go without output, or 0 for no limit.
+func (i *bashInput) idleTimeout() time.Duration {
+	if i.IdleTimeout == "" || i.Background {
+		return 0
+	}
+	dur, err := time.ParseDuration(i.IdleTimeout)
+	if err != nil || dur <= 0 {
+		return 0
+	}
+	return dur
+}
+
+// cpuTimeout returns how much CPU time a foreground command may use, or 0 for no limit.
+func (i *bashInput) cpuTimeout() time.Duration {
+	if i.CPUTimeout == "" || i.Background {
+		return 0
+	}
+	dur, err := time.ParseDuration(i.CPUTimeout)
+	if err != nil || dur <= 0 {
+		return 0
+	}
+	return dur
+}
+
 func (b *BashTool) Run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
 	var req bashInput
 	if err := json.Unmarshal(m, &req); err != nil {
@@ -130,21 +413,71 @@ func (b *BashTool) Run(ctx context.Context, m json.RawMessage) ([]llm.Content, e
 	// Custom permission callback if set
 	if b.CheckPermission != nil {
 		if err := b.CheckPermission(req.Command); err != nil {
+			PermissionDenials.Inc(bashName, "policy")
+			return nil, err
+		}
+	}
+
+	remote := ExecutorOf(ctx)
+	if remote != nil && (req.Ready != nil || len(req.WaitFor) > 0) {
+		return nil, fmt.Errorf("ready and wait_for are not supported for commands on a remote host")
+	}
+	if req.Cluster {
+		if b.Cluster == nil {
+			return nil, fmt.Errorf("cluster is not available in this session")
+		}
+		if req.Background || len(req.WaitFor) > 0 {
+			return nil, fmt.Errorf("cluster is only for foreground commands, which cannot wait_for background commands on this machine")
+		}
+	}
+	if req.Ready != nil {
+		if !req.Background {
+			return nil, fmt.Errorf("ready is only for background commands")
+		}
+		if _, _, err := req.Ready.check(); err != nil {
 			return nil, err
 		}
 	}
 
 	// Check for missing tools and try to install them if needed, best effort only
-	if b.EnableJITInstall {
+	if b.EnableJITInstall && remote == nil && !req.Cluster {
 		err := b.checkAndInstallMissingTools(ctx, req.Command)
 		if err != nil {
 			slog.DebugContext(ctx, "failed to auto-install missing tools", "error", err)
 		}
 	}
 
+	// Rewrite commands that would likely have their output truncated.
+	var rewriteNote string
+	cats := bashkit.Classify(req.Command)
+	if !req.Background && b.AutoRewrite {
+		if cat, ok := b.oftenTruncated(ctx, cats); ok {
+			if r, ok := bashkit.SuggestRewrite(req.Command); ok {
+				rewriteNote = fmt.Sprintf("[sketch ran `%s` instead, because the output of %s commands is often truncated (%s)]\n", r.Command, cat, r.Reason)
+				req.Command = r.Command
+			}
+		}
+	}
+
+	if err := b.jobs.wait(ctx, req.WaitFor); err != nil {
+		return nil, err
+	}
+
+	b.setTimeout(&req)
+	b.recordCommand(ctx, req)
+	req.Env = b.environ()
+	if req.Cluster {
+		req.runner = b.Cluster
+	}
+
 	// If Background is set to true, use executeBackgroundBash
 	if req.Background {
-		result, err := executeBackgroundBash(ctx, req)
+		var result *BackgroundResult
+		if remote != nil {
+			result, err = executeRemoteBackground(ctx, remote, req)
+		} else {
+			result, err = b.startBackground(ctx, req)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -158,11 +491,222 @@ func (b *BashTool) Run(ctx context.Context, m json.RawMessage) ([]llm.Content, e
 	}
 
 	// For foreground commands, use executeBash
+	done := notify.Watch(ctx, b.Notifier, cmp.Or(b.NotifyAfter, 30*time.Second), credentials.Redact(req.Command))
+	var live *liveOutput
+	if b.Output != nil {
+		live = &liveOutput{emit: func(s string) { b.Output(ctx, s) }}
+		req.output = live
+	}
 	out, execErr := executeBash(ctx, req)
+	live.flush()
+	done(execErr)
+	b.sawFiles(ctx, req.Command)
+	traceCommand(ctx, cats, execErr)
+	var tooLong *outputTooLongError
+	truncated := errors.As(execErr, &tooLong)
+	if rewriteNote == "" {
+		// Rewritten commands say nothing about how the command the model chose behaves.
+		b.recordOutput(ctx, cats, truncated)
+	}
+	if truncated {
+		slog.InfoContext(ctx, "bash_output_truncated", "categories", cats, "size", tooLong.size)
+		if r, ok := bashkit.SuggestRewrite(req.Command); ok {
+			execErr = fmt.Errorf("%w\nTo see less output, run instead (%s):\n%s", execErr, r.Reason, r.Command)
+		}
+	}
 	if execErr != nil {
+		if rewriteNote != "" {
+			return nil, fmt.Errorf("%s%w", rewriteNote, execErr)
+		}
 		return nil, execErr
 	}
-	return llm.TextContent(out), nil
+	return llm.TextContent(rewriteNote + out), nil
+}
+
+// traceCommand records how a foreground command of categories cats ended, with err, on the span of its tool call.
+func traceCommand(ctx context.Context, cats []bashkit.Category, err error) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	var names []string
+	for _, cat := range cats {
+		names = append(names, string(cat))
+	}
+	span.SetAttributes(attribute.StringSlice("sketch.bash.categories", names))
+	var ee *exec.ExitError
+	switch {
+	case err == nil:
+		span.SetAttributes(attribute.Int("process.exit.code", 0))
+	case errors.As(err, &ee):
+		span.SetAttributes(attribute.Int("process.exit.code", ee.ExitCode()))
+	}
+}
+
+// sawFiles records the changes that a foreground command may have made to the files the agent saw,
+// and the files that the command read.
+func (b *BashTool) sawFiles(ctx context.Context, command string) {
+	if b.Versions == nil {
+		return
+	}
+	b.Versions.Refresh()
+	for _, path := range bashkit.ReadFiles(command) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(WorkingDir(ctx), path)
+		}
+		b.Versions.SawFile(path)
+	}
+}
+
+// Commands are oftenTruncated once at least minTruncations of them had their output truncated,
+// and at least half of them did.
+const minTruncations = 3
+
+// oftenTruncated returns the first of cats whose commands often have their output truncated.
+func (b *BashTool) oftenTruncated(ctx context.Context, cats []bashkit.Category) (bashkit.Category, bool) {
+	for _, cat := range cats {
+		stats, err := b.outputStats(cat)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to read output stats", "error", err)
+			return "", false
+		}
+		if stats.Truncated >= minTruncations && 2*stats.Truncated >= stats.Runs {
+			return cat, true
+		}
+	}
+	return "", false
+}
+
+// outputStats returns the output statistics of commands of category cat.
+func (b *BashTool) outputStats(cat bashkit.Category) (statedb.OutputStats, error) {
+	if b.State != nil {
+		return b.State.DB().Output(string(cat))
+	}
+	b.outputMu.Lock()
+	defer b.outputMu.Unlock()
+	return b.output[cat], nil
+}
+
+// recordOutput counts a foreground command of categories cats, and whether its output was truncated.
+func (b *BashTool) recordOutput(ctx context.Context, cats []bashkit.Category, truncated bool) {
+	if b.State != nil {
+		names := make([]string, len(cats))
+		for i, cat := range cats {
+			names[i] = string(cat)
+		}
+		if err := b.State.DB().AddOutput(names, truncated); err != nil {
+			slog.WarnContext(ctx, "failed to record output stats", "error", err)
+		}
+		return
+	}
+	b.outputMu.Lock()
+	defer b.outputMu.Unlock()
+	if b.output == nil {
+		b.output = make(map[bashkit.Category]statedb.OutputStats)
+	}
+	for _, cat := range cats {
+		stats := b.output[cat]
+		stats.Runs++
+		if truncated {
+			stats.Truncated++
+		}
+		b.output[cat] = stats
+	}
+}
+
+// recordCommand adds the command in req to the command history, if b has a State.
+func (b *BashTool) recordCommand(ctx context.Context, req bashInput) {
+	if b.State == nil {
+		return
+	}
+	err := b.State.AddCommand(statedb.Command{
+		Command:    req.Command,
+		Dir:        WorkingDir(ctx),
+		Background: req.Background,
+		Time:       time.Now(),
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "failed to record bash command", "error", err)
+	}
+}
+
+// startBackground starts req in the background, registers it as a job, and records it in b's State.
+func (b *BashTool) startBackground(ctx context.Context, req bashInput) (*BackgroundResult, error) {
+	jobID, jobDir := b.newJob(ctx)
+	result, err := b.executeBackground(ctx, req, jobDir)
+	if err != nil {
+		return nil, err
+	}
+	result.SchemaVersion = SchemaVersion
+	b.jobs.add(req.Command, result, req.Ready)
+	b.recordJob(ctx, jobID, req, result)
+	if b.JobCrashed != nil && result.exit != nil {
+		go b.watchForCrash(context.WithoutCancel(ctx), req.Command, result)
+	}
+	return result, nil
+}
+
+// Jobs returns the background jobs b started, in the order they started, with their current status.
+func (b *BashTool) Jobs() []JobInfo {
+	return b.jobs.list()
+}
+
+// StopJob stops background job id, and the processes it started.
+func (b *BashTool) StopJob(ctx context.Context, id int) error {
+	result := b.jobs.result(id)
+	if result == nil {
+		return fmt.Errorf("no background job %d", id)
+	}
+	if b.jobs.statusOf(id) == JobExited {
+		return fmt.Errorf("background job %d has already exited", id)
+	}
+	stopJob(ctx, result)
+	return nil
+}
+
+// newJob reserves a job ID and an output directory for a background job in b's State, if it has one.
+// Otherwise, or on failure, it returns 0 and "", and the output goes to a new temporary directory.
+func (b *BashTool) newJob(ctx context.Context) (uint64, string) {
+	if b.State == nil {
+		return 0, ""
+	}
+	id, dir, err := b.State.NewJob()
+	if err != nil {
+		slog.WarnContext(ctx, "failed to create background job directory", "error", err)
+		return 0, ""
+	}
+	return id, dir
+}
+
+// recordJob records the background job started for req, and its output files, if b has a State.
+func (b *BashTool) recordJob(ctx context.Context, id uint64, req bashInput, result *BackgroundResult) {
+	if b.State == nil {
+		return
+	}
+	job := &statedb.Job{
+		ID:         id,
+		Command:    req.Command,
+		Dir:        WorkingDir(ctx),
+		PID:        result.PID,
+		StdoutFile: result.StdoutFile,
+		StderrFile: result.StderrFile,
+		Start:      time.Now(),
+	}
+	err := b.State.AddJob(job)
+	for _, path := range []string{result.StdoutFile, result.StderrFile} {
+		if err != nil {
+			break
+		}
+		err = b.State.AddArtifact(statedb.Artifact{
+			Path:    path,
+			Kind:    statedb.ArtifactJobOutput,
+			Source:  req.Command,
+			Created: job.Start,
+		})
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "failed to record background job", "error", err)
+	}
 }
 
 const maxBashOutputLength = 131072
@@ -170,28 +714,132 @@ const maxBashOutputLength = 131072
 func executeBash(ctx context.Context, req bashInput) (string, error) {
 	execCtx, cancel := context.WithTimeout(ctx, req.timeout())
 	defer cancel()
+	watch := &commandWatch{output: io.Discard}
+	if idle := req.idleTimeout(); idle > 0 {
+		var stop func()
+		execCtx, watch.output, stop = watchIdle(execCtx, idle)
+		defer stop()
+	}
+	if req.output != nil {
+		watch.output = io.MultiWriter(watch.output, req.output)
+	}
+	if limit := req.cpuTimeout(); limit > 0 {
+		cpuCtx, cancel := context.WithCancelCause(execCtx)
+		defer cancel(nil)
+		execCtx = cpuCtx
+		watch.started = func(pgid int) { go watchCPU(cpuCtx, pgid, limit, cancel) }
+	}
+	if req.runner != nil {
+		return executeRemoteBash(execCtx, req.runner, req, watch)
+	}
+	if e := ExecutorOf(ctx); e != nil {
+		return executeRemoteBash(execCtx, e, req, watch)
+	}
 
 	// Try PTY first for better interactive support, fallback to exec if it fails
-	if output, err := executeBashWithPty(execCtx, req); err == nil {
+	if output, err := executeBashWithPty(execCtx, req, watch); err == nil {
 		return output, nil
+	} else if execCtx.Err() != nil {
+		// The command timed out or was canceled; running it again would fail the same way.
+		return "", err
 	} else {
 		// Log PTY failure for debugging but don't fail the command
 		slog.Debug("PTY execution failed, falling back to exec", "error", err)
 	}
 
 	// Fallback to original exec-based implementation
-	return executeBashWithExec(execCtx, req)
+	return executeBashWithExec(execCtx, req, watch)
+}
+
+// errIdle is the cause of the cancellation of a command that went too long without output.
+var errIdle = errors.New("command went idle")
+
+// watchIdle returns a context that is canceled, with cause errIdle, once nothing has been written
+// to the returned writer for idle, and a function that stops watching.
+func watchIdle(ctx context.Context, idle time.Duration) (context.Context, io.Writer, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(idle, func() { cancel(errIdle) })
+	stop := func() {
+		timer.Stop()
+		cancel(nil)
+	}
+	return ctx, &idleWriter{timer: timer, idle: idle}, stop
+}
+
+// An idleWriter restarts an idle timer whenever it is written to.
+type idleWriter struct {
+	timer *time.Timer
+	idle  time.Duration
+}
+
+func (w *idleWriter) Write(p []byte) (int, error) {
+	w.timer.Reset(w.idle)
+	return len(p), nil
+}
+
+// outputInterval is how often a liveOutput passes on what a command printed.
+const outputInterval = 200 * time.Millisecond
+
+// A liveOutput collects a running command's output and passes it on to emit,
+// no more often than every outputInterval, so that chatty commands do not flood UIs.
+// A nil *liveOutput does nothing.
+type liveOutput struct {
+	emit func(string)
+
+	mu   sync.Mutex
+	buf  []byte
+	last time.Time
+}
+
+func (l *liveOutput) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	if time.Since(l.last) >= outputInterval {
+		l.flushLocked()
+	}
+	return len(p), nil
+}
+
+// flush passes on the output collected since the last time.
+func (l *liveOutput) flush() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flushLocked()
+}
+
+func (l *liveOutput) flushLocked() {
+	l.last = time.Now()
+	if len(l.buf) > 0 {
+		l.emit(string(l.buf))
+		l.buf = l.buf[:0]
+	}
 }
 
-// executeBashWithPty attempts to run bash command using pty for interactive support
-func executeBashWithPty(ctx context.Context, req bashInput) (string, error) {
+// A commandWatch observes a running command, to enforce its limits other than the timeout.
+type commandWatch struct {
+	output  io.Writer      // receives a copy of the command's output
+	started func(pgid int) // if set, called with the command's process group once it starts
+}
+
+func (w *commandWatch) start(proc *os.Process) {
+	if w.started != nil && proc != nil {
+		w.started(proc.Pid)
+	}
+}
+
+// executeBashWithPty attempts to run bash command using pty for interactive support.
+func executeBashWithPty(ctx context.Context, req bashInput, watch *commandWatch) (string, error) {
 	// Start bash with a pty for better interactive support
 	cmd := exec.CommandContext(ctx, "bash")
 	cmd.Dir = WorkingDir(ctx)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	// Set environment with SKETCH=1 and TERM for proper pty behavior
-	cmd.Env = append(os.Environ(), "SKETCH=1", "TERM=xterm-256color")
+	// Set environment with SKETCH=1, TERM for proper pty behavior, and the session's overrides
+	cmd.Env = req.environ("TERM=xterm-256color")
 
 	// Start the command with a pty
 	ptmx, err := pty.Start(cmd)
@@ -201,12 +849,14 @@ func executeBashWithPty(ctx context.Context, req bashInput) (string, error) {
 	defer ptmx.Close()
 
 	proc := cmd.Process
+	watch.start(proc)
 	done := make(chan struct{})
 	go func() {
 		select {
 		case <-ctx.Done():
-			if ctx.Err() == context.DeadlineExceeded && proc != nil {
-				// Kill the entire process group.
+			// On timeout or cancellation, kill the entire process group,
+			// so that no children of the command outlive it.
+			if proc != nil {
 				syscall.Kill(-proc.Pid, syscall.SIGKILL)
 			}
 		case <-done:
@@ -222,7 +872,7 @@ func executeBashWithPty(ctx context.Context, req bashInput) (string, error) {
 
 	// Read all output from the pty
 	var output bytes.Buffer
-	_, err = io.Copy(&output, ptmx)
+	_, err = io.Copy(io.MultiWriter(&output, watch.output), ptmx)
 	if err != nil && err != io.EOF {
 		// Don't treat EOF as an error since it's expected when the process exits
 		slog.Debug("pty read error (may be normal)", "error", err)
@@ -236,61 +886,35 @@ func executeBashWithPty(ctx context.Context, req bashInput) (string, error) {
 	outputStr := output.String()
 	outputStr = cleanPtyOutput(outputStr, req.Command)
 
-	longOutput := len(outputStr) > maxBashOutputLength
-	var outstr string
-	if longOutput {
-		outstr = fmt.Sprintf("output too long: got %v, max is %v\ninitial bytes of output:\n%s",
-			humanizeBytes(len(outputStr)), humanizeBytes(maxBashOutputLength),
-			outputStr[:1024],
-		)
-	} else {
-		outstr = outputStr
-	}
-
-	if ctx.Err() == context.DeadlineExceeded {
-		// Get the partial output that was captured before the timeout
-		partialOutput := outputStr
-		// Truncate if the output is too large
-		if len(partialOutput) > maxBashOutputLength {
-			partialOutput = partialOutput[:maxBashOutputLength] + "\n[output truncated due to size]\n"
-		}
-		return "", fmt.Errorf("command timed out after %s\nCommand output (until it timed out):\n%s", req.timeout(), outstr)
-	}
-	if err != nil {
-		return "", fmt.Errorf("command failed: %w\n%s", err, outstr)
-	}
-
-	if longOutput {
-		return "", fmt.Errorf("%s", outstr)
-	}
-
-	return outputStr, nil
+	return bashResult(ctx, req, outputStr, err)
 }
 
-// executeBashWithExec runs bash command using the original exec approach
-func executeBashWithExec(ctx context.Context, req bashInput) (string, error) {
+// executeBashWithExec runs bash command using the original exec approach.
+func executeBashWithExec(ctx context.Context, req bashInput, watch *commandWatch) (string, error) {
 	// Can't do the simple thing and call CombinedOutput because of the need to kill the process group.
 	cmd := exec.CommandContext(ctx, "bash", "-c", req.Command)
 	cmd.Dir = WorkingDir(ctx)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	// Set environment with SKETCH=1
-	cmd.Env = append(os.Environ(), "SKETCH=1")
+	// Set environment with SKETCH=1 and the session's overrides
+	cmd.Env = req.environ()
 
 	var output bytes.Buffer
 	cmd.Stdin = nil
-	cmd.Stdout = &output
-	cmd.Stderr = &output
+	cmd.Stdout = io.MultiWriter(&output, watch.output)
+	cmd.Stderr = cmd.Stdout
 	if err := cmd.Start(); err != nil {
 		return "", fmt.Errorf("command failed: %w", err)
 	}
 	proc := cmd.Process
+	watch.start(proc)
 	done := make(chan struct{})
 	go func() {
 		select {
 		case <-ctx.Done():
-			if ctx.Err() == context.DeadlineExceeded && proc != nil {
-				// Kill the entire process group.
+			// On timeout or cancellation, kill the entire process group,
+			// so that no children of the command outlive it.
+			if proc != nil {
 				syscall.Kill(-proc.Pid, syscall.SIGKILL)
 			}
 		case <-done:
@@ -300,35 +924,59 @@ func executeBashWithExec(ctx context.Context, req bashInput) (string, error) {
 	err := cmd.Wait()
 	close(done)
 
-	longOutput := output.Len() > maxBashOutputLength
-	var outstr string
-	if longOutput {
-		outstr = fmt.Sprintf("output too long: got %v, max is %v\ninitial bytes of output:\n%s",
-			humanizeBytes(output.Len()), humanizeBytes(maxBashOutputLength),
-			output.Bytes()[:1024],
-		)
-	} else {
-		outstr = output.String()
-	}
+	return bashResult(ctx, req, output.String(), err)
+}
 
-	if ctx.Err() == context.DeadlineExceeded {
-		// Get the partial output that was captured before the timeout
-		partialOutput := output.String()
-		// Truncate if the output is too large
-		if len(partialOutput) > maxBashOutputLength {
-			partialOutput = partialOutput[:maxBashOutputLength] + "\n[output truncated due to size]\n"
+// bashResult returns the bash tool's result for a command that printed output and exited with err.
+// Output longer than maxBashOutputLength is an *outputTooLongError, wrapped if the command also failed,
+// unless req.Tail is set, in which case only the end of the output is kept.
+// A command that was killed for taking too long fails with an llm.ToolErrorTimeout.
+func bashResult(ctx context.Context, req bashInput, output string, err error) (string, error) {
+	if req.Tail && len(output) > maxBashOutputLength {
+		note := fmt.Sprintf("[the first %s of output were cut]\n", humanizeBytes(len(output)-maxBashOutputLength))
+		output = note + output[len(output)-maxBashOutputLength+len(note):]
+	}
+	if len(output) <= maxBashOutputLength {
+		switch {
+		case context.Cause(ctx) == errIdle:
+			return "", llm.NewToolError(llm.ToolErrorTimeout, fmt.Errorf("command printed nothing for %s and was killed\nCommand output (until it was killed):\n%s", req.idleTimeout(), output))
+		case context.Cause(ctx) == errCPU:
+			return "", llm.NewToolError(llm.ToolErrorTimeout, fmt.Errorf("command used more than %s of CPU time and was killed\nCommand output (until it was killed):\n%s", req.cpuTimeout(), output))
+		case ctx.Err() == context.DeadlineExceeded:
+			return "", llm.NewToolError(llm.ToolErrorTimeout, fmt.Errorf("command timed out after %s of wall-clock time\nCommand output (until it timed out):\n%s", req.timeout(), output))
+		case err != nil:
+			return "", fmt.Errorf("command failed: %w\n%s", err, output)
 		}
-		return "", fmt.Errorf("command timed out after %s\nCommand output (until it timed out):\n%s", req.timeout(), outstr)
+		return output, nil
 	}
-	if err != nil {
-		return "", fmt.Errorf("command failed: %w\n%s", err, outstr)
+	tooLong := &outputTooLongError{size: len(output), head: output[:1024]}
+	switch {
+	case context.Cause(ctx) == errIdle:
+		return "", llm.NewToolError(llm.ToolErrorTimeout, fmt.Errorf("command printed nothing for %s and was killed\nCommand output (until it was killed):\n%w", req.idleTimeout(), tooLong))
+	case context.Cause(ctx) == errCPU:
+		return "", llm.NewToolError(llm.ToolErrorTimeout, fmt.Errorf("command used more than %s of CPU time and was killed\nCommand output (until it was killed):\n%w", req.cpuTimeout(), tooLong))
+	case ctx.Err() == context.DeadlineExceeded:
+		return "", llm.NewToolError(llm.ToolErrorTimeout, fmt.Errorf("command timed out after %s of wall-clock time\nCommand output (until it timed out):\n%w", req.timeout(), tooLong))
+	case err != nil:
+		return "", fmt.Errorf("command failed: %w\n%w", err, tooLong)
 	}
+	return "", tooLong
+}
 
-	if longOutput {
-		return "", fmt.Errorf("%s", outstr)
-	}
+// An outputTooLongError reports that a command printed more than maxBashOutputLength.
+type outputTooLongError struct {
+	size int    // how much the command printed
+	head string // the start of the output
+}
+
+// ToolErrorKind classifies the error as llm.ToolErrorOutputTooLarge.
+func (e *outputTooLongError) ToolErrorKind() llm.ToolErrorKind {
+	return llm.ToolErrorOutputTooLarge
+}
 
-	return output.String(), nil
+func (e *outputTooLongError) Error() string {
+	return fmt.Sprintf("output too long: got %v, max is %v\ninitial bytes of output:\n%s",
+		humanizeBytes(e.size), humanizeBytes(maxBashOutputLength), e.head)
 }
 
 func humanizeBytes(bytes int) string {
@@ -345,10 +993,11 @@ func humanizeBytes(bytes int) string {
 	return "more than 1GB"
 }
 
-// executeBackgroundBash executes a command in the background and returns the pid and output file locations
-func executeBackgroundBash(ctx context.Context, req bashInput) (*BackgroundResult, error) {
+// executeBackgroundBash executes a command in the background and returns the pid and output file locations.
+// The output files are in outDir, or, if outDir is "", in a new temporary directory.
+func executeBackgroundBash(ctx context.Context, req bashInput, outDir string) (*BackgroundResult, error) {
 	// Try PTY first for better interactive support, fallback to exec if it fails
-	if result, err := executeBackgroundBashWithPty(ctx, req); err == nil {
+	if result, err := executeBackgroundBashWithPty(ctx, req, outDir); err == nil {
 		return result, nil
 	} else {
 		// Log PTY failure for debugging but don't fail the command
@@ -356,15 +1005,38 @@ func executeBackgroundBash(ctx context.Context, req bashInput) (*BackgroundResul
 	}
 
 	// Fallback to original exec-based implementation
-	return executeBackgroundBashWithExec(ctx, req)
+	return executeBackgroundBashWithExec(ctx, req, outDir)
 }
 
-// executeBackgroundBashWithPty executes a command in the background using pty
-func executeBackgroundBashWithPty(ctx context.Context, req bashInput) (*BackgroundResult, error) {
-	// Create temporary directory for output files
+// executeBackground executes a command in the background, under b's Supervisor if it has one that is available.
+func (b *BashTool) executeBackground(ctx context.Context, req bashInput, outDir string) (*BackgroundResult, error) {
+	if b.Supervisor != nil && supervisorAvailable() {
+		result, err := b.Supervisor.start(ctx, req, outDir)
+		if err == nil {
+			return result, nil
+		}
+		slog.WarnContext(ctx, "bash_supervisor_failed", "error", err)
+	}
+	return executeBackgroundBash(ctx, req, outDir)
+}
+
+// backgroundOutputDir returns outDir, or, if outDir is "", a new temporary directory.
+func backgroundOutputDir(outDir string) (string, error) {
+	if outDir != "" {
+		return outDir, nil
+	}
 	tmpDir, err := os.MkdirTemp("", "sketch-bg-")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	return tmpDir, nil
+}
+
+// executeBackgroundBashWithPty executes a command in the background using pty
+func executeBackgroundBashWithPty(ctx context.Context, req bashInput, outDir string) (*BackgroundResult, error) {
+	tmpDir, err := backgroundOutputDir(outDir)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create temp files for stdout and stderr (with pty, both go to same output)
@@ -376,8 +1048,8 @@ func executeBackgroundBashWithPty(ctx context.Context, req bashInput) (*Backgrou
 	cmd.Dir = WorkingDir(ctx)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	// Set environment with SKETCH=1 and TERM for proper pty behavior
-	cmd.Env = append(os.Environ(), "SKETCH=1", "TERM=xterm-256color")
+	// Set environment with SKETCH=1, TERM for proper pty behavior, and the session's overrides
+	cmd.Env = req.environ("TERM=xterm-256color")
 
 	// Start the command with a pty
 	ptmx, err := pty.Start(cmd)
@@ -410,6 +1082,9 @@ func executeBackgroundBashWithPty(ctx context.Context, req bashInput) (*Backgrou
 		return nil, fmt.Errorf("failed to write command to background pty: %w", err)
 	}
 
+	// The shell reads commands from the pty, so it outlives the command, and its exit status says nothing of it.
+	exit := &jobExit{}
+
 	// Start a goroutine to copy pty output to the stdout file
 	go func() {
 		defer stdout.Close()
@@ -435,6 +1110,7 @@ func executeBackgroundBashWithPty(ctx context.Context, req bashInput) (*Backgrou
 
 			// TODO(philip): Should we do SIGQUIT and then SIGKILL in 5s?
 
+			exit.stopped.Store(true)
 			// Try to kill the process group
 			killErr := syscall.Kill(-pid, syscall.SIGKILL)
 			if killErr != nil {
@@ -449,15 +1125,15 @@ func executeBackgroundBashWithPty(ctx context.Context, req bashInput) (*Backgrou
 		PID:        cmd.Process.Pid,
 		StdoutFile: stdoutFile,
 		StderrFile: stderrFile,
+		exit:       exit,
 	}, nil
 }
 
 // executeBackgroundBashWithExec executes a command in the background using the original exec approach
-func executeBackgroundBashWithExec(ctx context.Context, req bashInput) (*BackgroundResult, error) {
-	// Create temporary directory for output files
-	tmpDir, err := os.MkdirTemp("", "sketch-bg-")
+func executeBackgroundBashWithExec(ctx context.Context, req bashInput, outDir string) (*BackgroundResult, error) {
+	tmpDir, err := backgroundOutputDir(outDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+		return nil, err
 	}
 
 	// Create temp files for stdout and stderr
@@ -469,8 +1145,8 @@ func executeBackgroundBashWithExec(ctx context.Context, req bashInput) (*Backgro
 	cmd.Dir = WorkingDir(ctx)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	// Set environment with SKETCH=1
-	cmd.Env = append(os.Environ(), "SKETCH=1")
+	// Set environment with SKETCH=1 and the session's overrides
+	cmd.Env = req.environ()
 
 	// Open output files
 	stdout, err := os.Create(stdoutFile)
@@ -496,9 +1172,10 @@ func executeBackgroundBashWithExec(ctx context.Context, req bashInput) (*Backgro
 	}
 
 	// Start a goroutine to reap the process when it finishes
+	exit := &jobExit{done: make(chan struct{})}
 	go func() {
-		cmd.Wait()
-		// Process has been reaped
+		exit.err = cmd.Wait()
+		close(exit.done)
 	}()
 
 	// Set up timeout handling if a timeout was specified
@@ -514,6 +1191,7 @@ func executeBackgroundBashWithExec(ctx context.Context, req bashInput) (*Backgro
 
 			// TODO(philip): Should we do SIGQUIT and then SIGKILL in 5s?
 
+			exit.stopped.Store(true)
 			// Try to kill the process group
 			killErr := syscall.Kill(-pid, syscall.SIGKILL)
 			if killErr != nil {
@@ -528,6 +1206,7 @@ func executeBackgroundBashWithExec(ctx context.Context, req bashInput) (*Backgro
 		PID:        cmd.Process.Pid,
 		StdoutFile: stdoutFile,
 		StderrFile: stderrFile,
+		exit:       exit,
 	}, nil
 }
 
@@ -546,6 +1225,12 @@ func (b *BashTool) checkAndInstallMissingTools(ctx context.Context, command stri
 		if doNotAttemptToolInstall[cmd] {
 			continue
 		}
+		if b.State != nil {
+			if attempted, _ := b.State.DB().InstallAttempted(cmd); attempted {
+				doNotAttemptToolInstall[cmd] = true
+				continue
+			}
+		}
 		_, err := exec.LookPath(cmd)
 		if err == nil {
 			doNotAttemptToolInstall[cmd] = true // spare future LookPath calls
@@ -565,6 +1250,11 @@ func (b *BashTool) checkAndInstallMissingTools(ctx context.Context, command stri
 	for _, cmd := range missing {
 		doNotAttemptToolInstall[cmd] = true // either it's installed or it's not--either way, we're done with it
 	}
+	if b.State != nil {
+		if err := b.State.DB().MarkInstallAttempted(missing...); err != nil {
+			slog.WarnContext(ctx, "failed to record tool installation attempts", "error", err)
+		}
+	}
 	return nil
 }
 
@@ -575,8 +1265,13 @@ var (
 )
 
 // installTools installs missing tools.
-func (b *BashTool) installTools(ctx context.Context, missing []string) error {
+func (b *BashTool) installTools(ctx context.Context, missing []string) (err error) {
 	slog.InfoContext(ctx, "installTools subconvo", "tools", missing)
+	defer func() {
+		if err != nil {
+			jitInstalls.Add(float64(len(missing)), "failed")
+		}
+	}()
 
 	info := conversation.ToolCallInfoFromContext(ctx)
 	if info.Convo == nil {
@@ -584,60 +1279,10 @@ func (b *BashTool) installTools(ctx context.Context, missing []string) error {
 	}
 	subConvo := info.Convo.SubConvo()
 	subConvo.Hidden = true
+	subConvo.Task = "install-tools"
 	subBash := NewBashTool(nil, NoBashToolJITInstall)
 
-	done := false
-	doneTool := &llm.Tool{
-		Name:        "done",
-		Description: "Call this tool once when finished processing all commands, providing the installation status for each.",
-		InputSchema: json.RawMessage(`{
-  "type": "object",
-  "properties": {
-    "results": {
-      "type": "array",
-      "items": {
-        "type": "object",
-        "properties": {
-          "command_name": {
-            "type": "string",
-            "description": "The name of the command"
-          },
-          "installed": {
-            "type": "boolean",
-            "description": "Whether the command was installed"
-          }
-        },
-        "required": ["command_name", "installed"]
-      }
-    }
-  },
-  "required": ["results"]
-}`),
-		Run: func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
-			type InstallResult struct {
-				CommandName string `json:"command_name"`
-				Installed   bool   `json:"installed"`
-			}
-			type DoneInput struct {
-				Results []InstallResult `json:"results"`
-			}
-			var doneInput DoneInput
-			err := json.Unmarshal(input, &doneInput)
-			results := doneInput.Results
-			if err != nil {
-				slog.WarnContext(ctx, "failed to parse install results", "raw", string(input), "error", err)
-			} else {
-				slog.InfoContext(ctx, "auto-tool installation complete", "results", results)
-			}
-			done = true
-			return llm.TextContent(""), nil
-		},
-	}
-
-	subConvo.Tools = []*llm.Tool{
-		subBash,
-		doneTool,
-	}
+	subConvo.Tools = []*llm.Tool{subBash}
 
 	const autoinstallSystemPrompt = `The assistant powers an entirely automated auto-installer tool.
 
@@ -653,7 +1298,7 @@ For each appropriate command:
 2. Make a minimal verification attempt (package manager success is sufficient).
 3. If installation fails after reasonable attempts, mark as failed and move on.
 
-Once all commands have been processed, call the "done" tool with the status of each command.
+Once all commands have been processed, stop. You will then be asked for the status of each command.
 `
 
 	subConvo.SystemPrompt = autoinstallSystemPrompt
@@ -672,11 +1317,7 @@ Once all commands have been processed, call the "done" tool with the status of e
 		return err
 	}
 
-	for !done {
-		if resp.StopReason != llm.StopReasonToolUse {
-			return fmt.Errorf("subagent finished without calling tool")
-		}
-
+	for resp.StopReason == llm.StopReasonToolUse {
 		ctxWithWorkDir := WithWorkingDir(ctx, WorkingDir(ctx))
 		results, _, err := subConvo.ToolResultContents(ctxWithWorkDir, resp)
 		if err != nil {
@@ -692,9 +1333,52 @@ Once all commands have been processed, call the "done" tool with the status of e
 		}
 	}
 
+	var status struct {
+		Results []struct {
+			CommandName string `json:"command_name"`
+			Installed   bool   `json:"installed"`
+		} `json:"results"`
+	}
+	_, err = subConvo.SendStructured(llm.UserStringMessage("Report the installation status of each command."), installResultsSchema, &status)
+	if err != nil {
+		return fmt.Errorf("failed to get installation status: %w", err)
+	}
+	slog.InfoContext(ctx, "auto-tool installation complete", "results", status.Results)
+	for _, r := range status.Results {
+		if r.Installed {
+			jitInstalls.Inc("installed")
+		} else {
+			jitInstalls.Inc("failed")
+		}
+	}
 	return nil
 }
 
+// installResultsSchema is the schema of installTools' final status report.
+var installResultsSchema = llm.MustSchema(`{
+  "type": "object",
+  "properties": {
+    "results": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "command_name": {
+            "type": "string",
+            "description": "The name of the command"
+          },
+          "installed": {
+            "type": "boolean",
+            "description": "Whether the command was installed"
+          }
+        },
+        "required": ["command_name", "installed"]
+      }
+    }
+  },
+  "required": ["results"]
+}`)
+
 // cleanPtyOutput removes shell prompts and command echoes from pty output
 func cleanPtyOutput(output, command string) string {
 	lines := strings.Split(output, "\n")
@@ -3,12 +3,16 @@ package claudetool
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
+
+	"sketch.dev/llm"
 )
 
 func TestBashTool(t *testing.T) {
@@ -543,3 +547,175 @@ func TestPtyVsExecComparison(t *testing.T) {
 		}
 	})
 }
+
+func TestExecuteBashCancelKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			if data, _ := os.ReadFile(pidFile); strings.HasSuffix(string(data), "\n") {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	req := bashInput{Command: "sleep 60 & echo $! > " + pidFile + "; wait", Timeout: "30s"}
+	start := time.Now()
+	if _, err := executeBash(ctx, req); err == nil {
+		t.Fatal("canceled command succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("canceled command took %v to return", elapsed)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := strings.TrimSpace(string(data))
+	// The background sleep must be gone, or at most a zombie awaiting its new parent.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stat, err := os.ReadFile("/proc/" + pid + "/stat")
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("child process %s survived cancellation: %s", pid, stat)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBashRewritesOftenTruncatedCommands(t *testing.T) {
+	ctx := context.Background()
+	tool := (&BashTool{AutoRewrite: true}).Tool()
+	input := json.RawMessage(`{"command":"seq 1 30000"}`)
+
+	for range minTruncations {
+		_, err := tool.Run(ctx, input)
+		if err == nil || !strings.Contains(err.Error(), "output too long") {
+			t.Fatalf("Run = %v, want output too long", err)
+		}
+		if !strings.Contains(err.Error(), "To see less output, run instead (keeps the first 200 lines):\n{ seq 1 30000; } 2>&1 | head -n 200") {
+			t.Errorf("error suggests no rewrite: %v", err)
+		}
+	}
+
+	// Now that seq's category is often truncated, the command is rewritten before it runs.
+	result, err := tool.Run(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(result[0].Text), "\n")
+	if len(lines) != 201 || !strings.HasPrefix(lines[0], "[sketch ran `{ seq 1 30000; }") || lines[200] != "200" {
+		t.Errorf("rewritten command printed %d lines, starting %q and ending %q", len(lines), lines[0], lines[len(lines)-1])
+	}
+}
+
+func TestBashIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	tool := (&BashTool{}).Tool()
+
+	// A command that keeps printing outlives its idle timeout.
+	input := json.RawMessage(`{"command":"for i in $(seq 1 10); do echo tick; sleep 0.1; done; echo done","idle_timeout":"1s"}`)
+	result, err := tool.Run(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(result[0].Text), "done") {
+		t.Errorf("output = %q, want it to end with done", result[0].Text)
+	}
+
+	// A command that goes quiet is killed long before its timeout.
+	start := time.Now()
+	input = json.RawMessage(`{"command":"echo started; sleep 30","idle_timeout":"200ms"}`)
+	_, err = tool.Run(ctx, input)
+	if err == nil || !strings.Contains(err.Error(), "command printed nothing for 200ms and was killed") || !strings.Contains(err.Error(), "started") {
+		t.Errorf("Run = %v, want idle kill with output", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("idle command ran for %s", elapsed)
+	}
+}
+
+func TestBashCPUTimeout(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc to read CPU time from")
+	}
+	ctx := context.Background()
+	tool := (&BashTool{}).Tool()
+
+	// A command that waits uses no CPU time, so it outlives a CPU limit shorter than its run time.
+	input := json.RawMessage(`{"command":"sleep 1; echo done","cpu_timeout":"200ms"}`)
+	result, err := tool.Run(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(result[0].Text) != "done" {
+		t.Errorf("output = %q, want done", result[0].Text)
+	}
+
+	// A command that spins is killed long before its timeout.
+	start := time.Now()
+	input = json.RawMessage(`{"command":"echo spinning; while :; do :; done","cpu_timeout":"300ms"}`)
+	_, err = tool.Run(ctx, input)
+	if err == nil || !strings.Contains(err.Error(), "command used more than 300ms of CPU time and was killed") || !strings.Contains(err.Error(), "spinning") {
+		t.Errorf("Run = %v, want CPU time kill with output", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("spinning command ran for %s", elapsed)
+	}
+
+	// Wall-clock timeouts say which limit they are.
+	input = json.RawMessage(`{"command":"sleep 5","timeout":"100ms"}`)
+	if _, err := tool.Run(ctx, input); err == nil || !strings.Contains(err.Error(), "timed out after 100ms of wall-clock time") || llm.ToolErrorKindOf(err) != llm.ToolErrorTimeout {
+		t.Errorf("Run = %v, want wall-clock timeout", err)
+	}
+}
+
+func TestBashLiveOutput(t *testing.T) {
+	var pieces []string
+	tool := (&BashTool{Output: func(ctx context.Context, output string) { pieces = append(pieces, output) }}).Tool()
+	input := json.RawMessage(`{"command":"echo one; sleep 0.5; echo two"}`)
+	if _, err := tool.Run(context.Background(), input); err != nil {
+		t.Fatal(err)
+	}
+	all := strings.Join(pieces, "")
+	if len(pieces) < 2 || !strings.Contains(all, "one") || !strings.Contains(all, "two") {
+		t.Errorf("live output came in pieces %q, want one and two separately", pieces)
+	}
+}
+
+func TestBashRetry(t *testing.T) {
+	timedOut := llm.NewToolError(llm.ToolErrorTimeout, errors.New("command timed out"))
+	tooLong := fmt.Errorf("command failed: exit status 1\n%w", &outputTooLongError{size: 200000})
+	tool := &BashTool{Timeout: 15 * time.Second}
+	for _, tt := range []struct {
+		input string
+		err   error
+		want  string // the adjusted input, or "" if the call is not retried
+	}{
+		{`{"command":"go test ./..."}`, timedOut, `{"command":"go test ./...","timeout":"1m0s"}`},
+		{`{"command":"go test ./...","timeout":"10s"}`, timedOut, `{"command":"go test ./...","timeout":"40s"}`},
+		{`{"command":"make","idle_timeout":"30s"}`, timedOut, `{"command":"make","idle_timeout":"2m0s"}`},
+		{`{"command":"go test ./...","timeout":"10m"}`, timedOut, ""},
+		{`{"command":"cat build.log"}`, tooLong, `{"command":"cat build.log","tail":true}`},
+		{`{"command":"cat build.log","tail":true}`, tooLong, ""},
+		{`{"command":"git push"}`, timedOut, ""},
+		{`{"command":"go test ./...","background":true}`, timedOut, ""},
+		{`{"command":"go test ./..."}`, errors.New("command failed: exit status 1"), ""},
+	} {
+		adjusted, note, ok := tool.retry(tt.err, json.RawMessage(tt.input))
+		if got := string(adjusted); got != tt.want || ok != (tt.want != "") || ok && note == "" {
+			t.Errorf("retry(%s) = %s, %q, %v; want %s", tt.input, got, note, ok, tt.want)
+		}
+	}
+
+	output := strings.Repeat("x", 2*maxBashOutputLength) + "FAIL: TestParse"
+	out, err := bashResult(context.Background(), bashInput{Tail: true}, output, nil)
+	if err != nil || len(out) > maxBashOutputLength || !strings.HasPrefix(out, "[the first 128kB of output were cut]") || !strings.HasSuffix(out, "FAIL: TestParse") {
+		t.Errorf("bashResult with tail = %.60q...%q, %v; want the end of the output", out, out[max(len(out)-20, 0):], err)
+	}
+}
@@ -0,0 +1,222 @@
+package bashkit
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// A Category describes what kind of work a command does.
+// Categories are used as tags on executed commands,
+// so that UIs and audit queries can filter by them.
+type Category string
+
+const (
+	CategoryBuild          Category = "build"
+	CategoryTest           Category = "test"
+	CategoryVCS            Category = "vcs"
+	CategoryPackageInstall Category = "package-install"
+	CategoryFileRead       Category = "file-read"
+	CategoryNetwork        Category = "network"
+	CategoryOther          Category = "other"
+)
+
+// Classify returns the categories of all commands in bashScript, sorted and deduplicated.
+// A script that cannot be parsed, or whose commands match no category, is CategoryOther.
+// Like Check, Classify uses simple heuristics and has both false positives and false negatives.
+func Classify(bashScript string) []Category {
+	r := strings.NewReader(bashScript)
+	parser := syntax.NewParser()
+	file, err := parser.Parse(r, "")
+	if err != nil {
+		return []Category{CategoryOther}
+	}
+
+	var cats []Category
+	syntax.Walk(file, func(node syntax.Node) bool {
+		callExpr, ok := node.(*syntax.CallExpr)
+		if !ok {
+			return true
+		}
+		cats = append(cats, classifyCall(callExpr)...)
+		return true
+	})
+	slices.Sort(cats)
+	cats = slices.Compact(cats)
+	if len(cats) == 0 {
+		return []Category{CategoryOther}
+	}
+	return cats
+}
+
+// classifyCall returns the categories of a single simple command.
+func classifyCall(cmd *syntax.CallExpr) []Category {
+	args := make([]string, 0, len(cmd.Args))
+	for _, arg := range cmd.Args {
+		args = append(args, arg.Lit())
+	}
+	if len(args) == 0 || args[0] == "" {
+		return nil
+	}
+	name := filepath.Base(args[0])
+	// Look through wrappers such as "sudo" or "timeout 10".
+	switch name {
+	case "sudo", "env", "time", "nice", "nohup", "xargs":
+		return classifyArgs(skipFlags(args[1:]))
+	case "timeout":
+		rest := skipFlags(args[1:])
+		if len(rest) > 0 {
+			rest = rest[1:] // duration
+		}
+		return classifyArgs(rest)
+	}
+	return classifyArgs(args)
+}
+
+// skipFlags drops leading flags and VAR=value assignments.
+func skipFlags(args []string) []string {
+	for len(args) > 0 && (strings.HasPrefix(args[0], "-") || strings.Contains(args[0], "=")) {
+		args = args[1:]
+	}
+	return args
+}
+
+func classifyArgs(args []string) []Category {
+	if len(args) == 0 || args[0] == "" {
+		return nil
+	}
+	name := filepath.Base(args[0])
+	if cat, ok := commandCategories[name]; ok {
+		return []Category{cat}
+	}
+	if subs, ok := subcommandCategories[name]; ok {
+		return subs[findSubcommand(args[1:], subs)]
+	}
+	return nil
+}
+
+// findSubcommand returns the first non-flag argument that is a key in subs, or "".
+// Scanning past unknown arguments handles flags with values, as in "git -C dir push".
+func findSubcommand(args []string, subs map[string][]Category) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if _, ok := subs[arg]; ok {
+			return arg
+		}
+	}
+	return ""
+}
+
+// commandCategories maps commands to their category regardless of arguments.
+var commandCategories = map[string]Category{
+	"make": CategoryBuild, "gcc": CategoryBuild, "g++": CategoryBuild, "clang": CategoryBuild,
+	"javac": CategoryBuild, "tsc": CategoryBuild, "cmake": CategoryBuild, "ninja": CategoryBuild,
+	"bazel": CategoryBuild, "mvn": CategoryBuild, "gradle": CategoryBuild, "esbuild": CategoryBuild,
+
+	"pytest": CategoryTest, "jest": CategoryTest, "vitest": CategoryTest, "mocha": CategoryTest,
+	"rspec": CategoryTest, "tox": CategoryTest, "playwright": CategoryTest,
+
+	"hg": CategoryVCS, "svn": CategoryVCS, "gh": CategoryVCS,
+
+	"apt": CategoryPackageInstall, "apt-get": CategoryPackageInstall, "apk": CategoryPackageInstall,
+	"dnf": CategoryPackageInstall, "yum": CategoryPackageInstall, "brew": CategoryPackageInstall,
+	"pacman": CategoryPackageInstall, "gem": CategoryPackageInstall,
+
+	"cat": CategoryFileRead, "head": CategoryFileRead, "tail": CategoryFileRead, "less": CategoryFileRead,
+	"more": CategoryFileRead, "grep": CategoryFileRead, "rg": CategoryFileRead, "ag": CategoryFileRead,
+	"find": CategoryFileRead, "ls": CategoryFileRead, "tree": CategoryFileRead, "wc": CategoryFileRead,
+	"sed": CategoryFileRead, "awk": CategoryFileRead, "stat": CategoryFileRead, "file": CategoryFileRead,
+	"diff": CategoryFileRead, "jq": CategoryFileRead, "bat": CategoryFileRead,
+
+	"curl": CategoryNetwork, "wget": CategoryNetwork, "ssh": CategoryNetwork, "scp": CategoryNetwork,
+	"rsync": CategoryNetwork, "nc": CategoryNetwork, "ncat": CategoryNetwork, "ping": CategoryNetwork,
+	"dig": CategoryNetwork, "nslookup": CategoryNetwork, "nmap": CategoryNetwork, "telnet": CategoryNetwork,
+	"ftp": CategoryNetwork, "http": CategoryNetwork, "traceroute": CategoryNetwork,
+}
+
+// subcommandCategories maps commands whose category depends on their subcommand.
+// The "" entry is the category when no subcommand matches.
+var subcommandCategories = map[string]map[string][]Category{
+	"git": {
+		"":       {CategoryVCS},
+		"add":    {CategoryVCS},
+		"commit": {CategoryVCS},
+		"diff":   {CategoryVCS},
+		"log":    {CategoryVCS},
+		"show":   {CategoryVCS},
+		"status": {CategoryVCS},
+		"clone":  {CategoryVCS, CategoryNetwork},
+		"fetch":  {CategoryVCS, CategoryNetwork},
+		"pull":   {CategoryVCS, CategoryNetwork},
+		"push":   {CategoryVCS, CategoryNetwork},
+	},
+	"go": {
+		"":         {CategoryBuild},
+		"build":    {CategoryBuild},
+		"run":      {CategoryBuild},
+		"vet":      {CategoryBuild},
+		"test":     {CategoryTest},
+		"get":      {CategoryPackageInstall, CategoryNetwork},
+		"install":  {CategoryPackageInstall},
+		"mod":      {CategoryPackageInstall},
+		"doc":      {CategoryFileRead},
+		"list":     {CategoryFileRead},
+		"env":      {CategoryOther},
+		"version":  {CategoryOther},
+		"generate": {CategoryBuild},
+	},
+	"cargo": {
+		"":        {CategoryBuild},
+		"test":    {CategoryTest},
+		"install": {CategoryPackageInstall},
+		"add":     {CategoryPackageInstall},
+		"fetch":   {CategoryPackageInstall, CategoryNetwork},
+	},
+	"npm": {
+		"":        {CategoryOther},
+		"test":    {CategoryTest},
+		"t":       {CategoryTest},
+		"install": {CategoryPackageInstall},
+		"i":       {CategoryPackageInstall},
+		"ci":      {CategoryPackageInstall},
+		"add":     {CategoryPackageInstall},
+		"run":     {CategoryBuild},
+	},
+	"yarn": {
+		"":        {CategoryPackageInstall},
+		"test":    {CategoryTest},
+		"build":   {CategoryBuild},
+		"run":     {CategoryBuild},
+		"add":     {CategoryPackageInstall},
+		"install": {CategoryPackageInstall},
+	},
+	"pnpm": {
+		"":        {CategoryOther},
+		"test":    {CategoryTest},
+		"build":   {CategoryBuild},
+		"run":     {CategoryBuild},
+		"add":     {CategoryPackageInstall},
+		"install": {CategoryPackageInstall},
+		"i":       {CategoryPackageInstall},
+	},
+	"pip": {
+		"":         {CategoryOther},
+		"install":  {CategoryPackageInstall},
+		"download": {CategoryPackageInstall, CategoryNetwork},
+	},
+	"pip3": {
+		"":         {CategoryOther},
+		"install":  {CategoryPackageInstall},
+		"download": {CategoryPackageInstall, CategoryNetwork},
+	},
+	"docker": {
+		"":      {CategoryOther},
+		"build": {CategoryBuild},
+		"pull":  {CategoryNetwork},
+		"push":  {CategoryNetwork},
+	},
+}
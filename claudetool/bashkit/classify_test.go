@@ -0,0 +1,35 @@
+package bashkit
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []Category
+	}{
+		{"go build ./...", []Category{CategoryBuild}},
+		{"go test -run TestFoo ./pkg", []Category{CategoryTest}},
+		{"git status", []Category{CategoryVCS}},
+		{"git -C /repo push origin main", []Category{CategoryNetwork, CategoryVCS}},
+		{"sudo apt-get install -y ripgrep", []Category{CategoryPackageInstall}},
+		{"cat main.go | grep func", []Category{CategoryFileRead}},
+		{"curl -s https://example.com > out.html", []Category{CategoryNetwork}},
+		{"timeout 30 npm test", []Category{CategoryTest}},
+		{"env CGO_ENABLED=0 go build && ./bin/app", []Category{CategoryBuild}},
+		{"npm install && npm run build", []Category{CategoryBuild, CategoryPackageInstall}},
+		{"echo hello", []Category{CategoryOther}},
+		{"echo 'unterminated", []Category{CategoryOther}},
+		{"if true; then wget x; fi", []Category{CategoryNetwork}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			got := Classify(tt.script)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Classify(%q) = %v, want %v", tt.script, got, tt.want)
+			}
+		})
+	}
+}
@@ -65,3 +65,63 @@ func ExtractCommands(command string) ([]string, error) {
 
 	return commands, nil
 }
+
+// fileReaders are the commands that ReadFiles takes to print the contents of their operands.
+var fileReaders = map[string]bool{
+	"cat": true, "head": true, "tail": true, "less": true, "more": true, "bat": true, "nl": true, "sed": true,
+}
+
+// ReadFiles returns the files whose contents the commands of bashScript print, as cat or head do,
+// deduplicated, in the order they appear. A script that cannot be parsed reads no files.
+// Like Classify, ReadFiles uses simple heuristics: it takes every literal operand of such a command
+// to be a file, so callers should check that they are.
+func ReadFiles(bashScript string) []string {
+	parser := syntax.NewParser()
+	file, err := parser.Parse(strings.NewReader(bashScript), "")
+	if err != nil {
+		return nil
+	}
+	var files []string
+	seen := make(map[string]bool)
+	syntax.Walk(file, func(node syntax.Node) bool {
+		callExpr, ok := node.(*syntax.CallExpr)
+		if !ok || len(callExpr.Args) == 0 {
+			return true
+		}
+		name := callExpr.Args[0].Lit()
+		if !fileReaders[name] {
+			return true
+		}
+		var operands []string
+		script := name == "sed" // sed's first operand is its script, unless a flag gives it
+		skip := false           // the argument is the value of a flag
+		for _, arg := range callExpr.Args[1:] {
+			lit := arg.Lit()
+			switch {
+			case skip:
+				skip = false
+			case name == "sed" && (strings.HasPrefix(lit, "-i") || strings.HasPrefix(lit, "--in-place")):
+				return true // edits in place, so what it printed is not what the file holds
+			case name == "sed" && (strings.HasPrefix(lit, "-e") || strings.HasPrefix(lit, "-f") || strings.HasPrefix(lit, "--expression") || strings.HasPrefix(lit, "--file")):
+				script = false
+				skip = lit == "-e" || lit == "-f" || lit == "--expression" || lit == "--file"
+			case (name == "head" || name == "tail") && (lit == "-n" || lit == "-c"):
+				skip = true
+			case strings.HasPrefix(lit, "-"):
+			default:
+				operands = append(operands, lit) // "" if quoted or expanded, which is no file ReadFiles can name
+			}
+		}
+		if script && len(operands) > 0 {
+			operands = operands[1:]
+		}
+		for _, f := range operands {
+			if f != "" && !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+		return true
+	})
+	return files
+}
@@ -2,6 +2,7 @@ package bashkit
 
 import (
 	"reflect"
+	"slices"
 	"testing"
 )
 
@@ -144,3 +145,25 @@ func TestExtractCommandsPathFiltering(t *testing.T) {
 		})
 	}
 }
+
+func TestReadFiles(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []string
+	}{
+		{"cat main.go", []string{"main.go"}},
+		{"head -n 20 a.go && tail -c 100 b.go | grep x c.go", []string{"a.go", "b.go"}},
+		{"cat a.go; nl -ba a.go", []string{"a.go"}},
+		{"sed -n '1,40p' x.go", []string{"x.go"}},
+		{"sed -e 's/a/b/' -n y.go z.go", []string{"y.go", "z.go"}},
+		{"sed -i 's/a/b/' x.go", nil},
+		{"ls -la && go test ./...", nil},
+		{"cat $FILE", nil},
+		{"cat 'unterminated", nil},
+	}
+	for _, tt := range tests {
+		if got := ReadFiles(tt.script); !slices.Equal(got, tt.want) {
+			t.Errorf("ReadFiles(%q) = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package bashkit
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// A Rewrite is a change to a command that makes it print less.
+type Rewrite struct {
+	Command string // the rewritten command
+	Reason  string // why the rewritten command prints less, for the model
+}
+
+// rewriteLines is how many lines of output a rewrite that cuts output short keeps.
+const rewriteLines = 200
+
+// SuggestRewrite suggests a way to run bashScript that prints less,
+// so that its output fits in the bash tool's output limit.
+// It prefers flags that make commands quieter; failing that, it keeps only the end of the output
+// of build, test, and installation commands, where failures are reported, and the start of anything else's.
+// It returns false if bashScript already limits its output, for example by piping it to head or a file,
+// or cannot be parsed.
+// Like Check, SuggestRewrite uses simple heuristics.
+func SuggestRewrite(bashScript string) (Rewrite, bool) {
+	parser := syntax.NewParser()
+	file, err := parser.Parse(strings.NewReader(bashScript), "")
+	if err != nil || limitsOutput(file) {
+		return Rewrite{}, false
+	}
+
+	var edits []edit
+	var reasons []string
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if call, ok := node.(*syntax.CallExpr); ok {
+			if e, reason, ok := quieten(call); ok {
+				edits = append(edits, e)
+				reasons = append(reasons, reason)
+			}
+		}
+		return true
+	})
+	if len(edits) > 0 {
+		return Rewrite{Command: applyEdits(bashScript, edits), Reason: strings.Join(reasons, "; ")}, true
+	}
+
+	// Wrap the whole script.
+	body := "{ " + bashScript + "; }"
+	if strings.ContainsAny(bashScript, "\n#&") {
+		body = "{\n" + bashScript + "\n}"
+	}
+	cats := Classify(bashScript)
+	if slices.ContainsFunc(cats, func(c Category) bool {
+		return c == CategoryBuild || c == CategoryTest || c == CategoryPackageInstall
+	}) {
+		// tail reads all of its input, so with pipefail the script's exit status survives.
+		// (head stops early, which would fail the script with SIGPIPE.)
+		return Rewrite{
+			Command: fmt.Sprintf("set -o pipefail; %s 2>&1 | tail -n %d", body, rewriteLines),
+			Reason:  fmt.Sprintf("keeps the last %d lines, where failures are reported", rewriteLines),
+		}, true
+	}
+	return Rewrite{
+		Command: fmt.Sprintf("%s 2>&1 | head -n %d", body, rewriteLines),
+		Reason:  fmt.Sprintf("keeps the first %d lines", rewriteLines),
+	}, true
+}
+
+// limitsOutput reports whether file pipes output into a command that shortens it,
+// or sends standard output somewhere other than the terminal.
+func limitsOutput(file *syntax.File) bool {
+	limited := false
+	syntax.Walk(file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.BinaryCmd:
+			if n.Op != syntax.Pipe && n.Op != syntax.PipeAll {
+				break
+			}
+			if call, ok := n.Y.Cmd.(*syntax.CallExpr); ok && len(call.Args) > 0 && shortensOutput[filepath.Base(call.Args[0].Lit())] {
+				limited = true
+			}
+		case *syntax.Redirect:
+			stdout := n.N == nil || n.N.Value == "1"
+			switch n.Op {
+			case syntax.RdrOut, syntax.AppOut:
+				limited = limited || stdout
+			case syntax.RdrAll, syntax.AppAll:
+				limited = true
+			}
+		}
+		return !limited
+	})
+	return limited
+}
+
+// shortensOutput holds commands that print less than they are fed, typically.
+var shortensOutput = map[string]bool{
+	"head": true, "tail": true, "grep": true, "rg": true, "wc": true, "less": true, "more": true,
+	"uniq": true, "jq": true, "cut": true, "awk": true, "sed": true,
+}
+
+// A quietRule says how to make a command print less.
+type quietRule struct {
+	subcommands []string // if set, the rule applies only to these subcommands
+	add         string   // a flag to add after the (sub)command
+	drop        string   // a flag to remove
+	quiet       []string // prefixes of flags that already make the command quiet; add is not added when present
+}
+
+var quietRules = map[string]quietRule{
+	"go":      {subcommands: []string{"test"}, drop: "-v"},
+	"cargo":   {subcommands: []string{"build", "check", "test"}, add: "-q", quiet: []string{"-q", "--quiet"}},
+	"npm":     {subcommands: []string{"install", "i", "ci"}, add: "--loglevel=error", quiet: []string{"-s", "--silent", "-q", "--quiet", "--loglevel"}},
+	"pip":     {subcommands: []string{"install"}, add: "-q", quiet: []string{"-q", "--quiet"}},
+	"pip3":    {subcommands: []string{"install"}, add: "-q", quiet: []string{"-q", "--quiet"}},
+	"apt-get": {subcommands: []string{"install", "update", "upgrade"}, add: "-qq", quiet: []string{"-q", "--quiet"}},
+	"make":    {add: "-s", quiet: []string{"-s", "--silent", "--quiet"}},
+	"mvn":     {add: "-q", quiet: []string{"-q", "--quiet"}},
+	"gradle":  {add: "-q", quiet: []string{"-q", "--quiet"}},
+	"curl":    {add: "-sS", quiet: []string{"-s", "--silent"}},
+	"wget":    {add: "-nv", quiet: []string{"-q", "--quiet", "-nv", "--no-verbose"}},
+	"git":     {subcommands: []string{"log"}, add: "--max-count=50", quiet: []string{"-n", "--max-count", "-1", "-2", "-3", "-4", "-5", "-6", "-7", "-8", "-9"}},
+}
+
+// An edit replaces the bytes from start to end of a script with text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// quieten returns the edit that makes cmd print less, if there is a rule for it, and the reason for the edit.
+func quieten(cmd *syntax.CallExpr) (edit, string, bool) {
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = arg.Lit()
+	}
+	i := commandIndex(args)
+	if i < 0 {
+		return edit{}, "", false
+	}
+	name := filepath.Base(args[i])
+	rule, ok := quietRules[name]
+	if !ok {
+		return edit{}, "", false
+	}
+	if len(rule.subcommands) > 0 {
+		sub := slices.IndexFunc(args[i+1:], func(arg string) bool { return !strings.HasPrefix(arg, "-") })
+		if sub < 0 || !slices.Contains(rule.subcommands, args[i+1+sub]) {
+			return edit{}, "", false
+		}
+		i += 1 + sub
+		name += " " + args[i]
+	}
+
+	if rule.drop != "" {
+		j := slices.Index(args[i+1:], rule.drop)
+		if j < 0 {
+			return edit{}, "", false
+		}
+		j += i + 1
+		return edit{
+			start: int(cmd.Args[j-1].End().Offset()),
+			end:   int(cmd.Args[j].End().Offset()),
+		}, fmt.Sprintf("%s without %s prints less", name, rule.drop), true
+	}
+
+	for _, arg := range args[i+1:] {
+		for _, q := range rule.quiet {
+			if strings.HasPrefix(arg, q) {
+				return edit{}, "", false
+			}
+		}
+	}
+	at := int(cmd.Args[i].End().Offset())
+	return edit{start: at, end: at, text: " " + rule.add}, fmt.Sprintf("%s %s prints less", name, rule.add), true
+}
+
+// commandIndex returns the index in args of the command that runs,
+// looking through wrappers such as "sudo" or "timeout 10", or -1 if there is none.
+func commandIndex(args []string) int {
+	i := 0
+	for i < len(args) {
+		if args[i] == "" {
+			return -1 // not a literal
+		}
+		switch filepath.Base(args[i]) {
+		case "sudo", "env", "time", "nice", "nohup":
+			i = skipFlagsFrom(args, i+1)
+		case "timeout":
+			i = skipFlagsFrom(args, i+1) + 1 // and the duration
+		default:
+			return i
+		}
+	}
+	return -1
+}
+
+// skipFlagsFrom returns the index of the first argument from i on that is neither a flag nor a VAR=value assignment.
+func skipFlagsFrom(args []string, i int) int {
+	for i < len(args) && (strings.HasPrefix(args[i], "-") || strings.Contains(args[i], "=")) {
+		i++
+	}
+	return i
+}
+
+// applyEdits applies edits, which are in order and do not overlap, to script.
+func applyEdits(script string, edits []edit) string {
+	var b strings.Builder
+	last := 0
+	for _, e := range edits {
+		b.WriteString(script[last:e.start])
+		b.WriteString(e.text)
+		last = e.end
+	}
+	b.WriteString(script[last:])
+	return b.String()
+}
@@ -0,0 +1,40 @@
+package bashkit
+
+import "testing"
+
+func TestSuggestRewrite(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string // "" for no rewrite
+	}{
+		{"go test -v ./...", "go test ./..."},
+		{"cd pkg && go test -v -run TestFoo .", "cd pkg && go test -run TestFoo ."},
+		{"npm install", "npm install --loglevel=error"},
+		{"sudo apt-get install -y ripgrep", "sudo apt-get install -qq -y ripgrep"},
+		{"timeout 60 make all", "timeout 60 make -s all"},
+		{"curl https://example.com", "curl -sS https://example.com"},
+		{"git log", "git log --max-count=50"},
+		{"git log -5", "{ git log -5; } 2>&1 | head -n 200"},
+		{"go build ./...", "set -o pipefail; { go build ./...; } 2>&1 | tail -n 200"},
+		{"find . -name '*.go'", "{ find . -name '*.go'; } 2>&1 | head -n 200"},
+		{"go build ./... # all of it", "set -o pipefail; {\ngo build ./... # all of it\n} 2>&1 | tail -n 200"},
+		{"go test ./... | tail -50", ""},
+		{"cat big.log > /dev/null", ""},
+		{"make &> build.log", ""},
+		{"echo 'unterminated", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			got, ok := SuggestRewrite(tt.script)
+			if !ok {
+				got.Command = ""
+			}
+			if got.Command != tt.want {
+				t.Errorf("SuggestRewrite(%q) = %q, want %q", tt.script, got.Command, tt.want)
+			}
+			if ok && got.Reason == "" {
+				t.Errorf("SuggestRewrite(%q) has no reason", tt.script)
+			}
+		})
+	}
+}
@@ -765,6 +765,10 @@ func (b *BrowseTools) GetTools(includeScreenshotTools bool) []*llm.Tool {
 		tools = append(tools, b.NewReadImageTool())
 	}
 
+	// The tools drive a single browser tab, so their calls must not interleave.
+	for _, t := range tools {
+		t.Serial = true
+	}
 	return tools
 }
 
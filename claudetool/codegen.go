@@ -0,0 +1,244 @@
+package claudetool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// CodegenTool specifies an llm.Tool that regenerates code from the repo's
+// OpenAPI and protobuf definitions, using the repo's own codegen setup,
+// and checks that the result compiles.
+// It supports spec-first workflows: edit the spec, regenerate, then implement.
+type CodegenTool struct {
+	// RepoRoot is the root of the repo to search for specs and generators.
+	RepoRoot string
+}
+
+// Tool returns an llm.Tool based on c.
+func (c *CodegenTool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        CodegenName,
+		Description: strings.TrimSpace(CodegenDescription),
+		InputSchema: llm.MustSchema(CodegenInputSchema),
+		Run:         c.run,
+	}
+}
+
+const (
+	CodegenName        = "codegen"
+	CodegenDescription = `
+Finds the repo's OpenAPI and protobuf definitions and the generators configured for them
+(buf.gen.yaml files, and go:generate directives that run oapi-codegen, ogen, protoc, or buf),
+and runs those generators.
+
+Run it after every edit to an API spec, instead of editing generated code by hand.
+The run action regenerates everything, then builds the Go code to check that the generated code compiles.
+Use detect to see what would run.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	CodegenInputSchema = `
+{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["detect", "run"],
+      "description": "detect lists the specs and generators; run runs the generators and verifies the output compiles"
+    }
+  }
+}
+`
+)
+
+// codegenTimeout bounds each generator run and the verification build.
+const codegenTimeout = 5 * time.Minute
+
+// A codegenTarget is one way the repo generates code: a command and the directory it runs in.
+type codegenTarget struct {
+	Dir     string   // relative to the repo root
+	Command []string // command and arguments
+	Origin  string   // where the command was found, relative to the repo root
+}
+
+func (t codegenTarget) String() string {
+	return fmt.Sprintf("%s (in %s, from %s)", strings.Join(t.Command, " "), t.Dir, t.Origin)
+}
+
+// codegenGenerators are the generators whose go:generate directives the codegen tool runs.
+var codegenGenerators = regexp.MustCompile(`\b(oapi-codegen|ogen|protoc|buf)\b`)
+
+func (c *CodegenTool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var input struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(m, &input); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal codegen input: %w", err)
+	}
+	specs, targets, err := detectCodegen(c.RepoRoot)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	switch input.Action {
+	case "detect":
+		fmt.Fprintf(&b, "API specs:\n")
+		for _, s := range specs {
+			fmt.Fprintf(&b, "- %s\n", s)
+		}
+		if len(specs) == 0 {
+			b.WriteString("(none)\n")
+		}
+		fmt.Fprintf(&b, "Generators:\n")
+		for _, t := range targets {
+			fmt.Fprintf(&b, "- %s\n", t)
+		}
+		if len(targets) == 0 {
+			b.WriteString("(none)\n")
+		}
+		return llm.TextContent(b.String()), nil
+	case "run":
+	default:
+		return nil, fmt.Errorf("unknown action %q; want detect or run", input.Action)
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("found no codegen configuration (buf.gen.yaml, or go:generate directives running oapi-codegen, ogen, protoc, or buf); " +
+			"add one, or run the generator with bash")
+	}
+	var failed bool
+	for _, t := range targets {
+		out, err := c.runCommand(ctx, t.Dir, t.Command...)
+		slog.InfoContext(ctx, "codegen_run", "command", strings.Join(t.Command, " "), "dir", t.Dir, "error", err)
+		fmt.Fprintf(&b, "$ %s\n", t)
+		if len(out) > 0 {
+			fmt.Fprintf(&b, "%s\n", out)
+		}
+		if err != nil {
+			failed = true
+			fmt.Fprintf(&b, "FAILED: %v\n", err)
+		}
+	}
+	if failed {
+		return nil, fmt.Errorf("codegen failed:\n%s", b.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(c.RepoRoot, "go.mod")); err == nil {
+		out, err := c.runCommand(ctx, ".", "go", "build", "./...")
+		if err != nil {
+			return nil, fmt.Errorf("codegen succeeded, but the Go code does not build; fix the spec or the code that uses the generated code:\n%s\n\n%s", out, b.String())
+		}
+		b.WriteString("Generated code builds.\n")
+	}
+	return llm.TextContent(b.String()), nil
+}
+
+// runCommand runs args in dir, relative to the repo root, and returns its combined output.
+func (c *CodegenTool) runCommand(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, codegenTimeout)
+	defer cancel()
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("%s is not installed: %w", args[0], err)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = filepath.Join(c.RepoRoot, dir)
+	out, err := cmd.CombinedOutput()
+	return bytes.TrimSpace(out), err
+}
+
+// detectCodegen finds the API specs in the repo at root, and the commands that generate code from them.
+// Paths are relative to root.
+func detectCodegen(root string) (specs []string, targets []codegenTarget, err error) {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(name, ".") || name == "node_modules" || name == "vendor" || name == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		dir := filepath.Dir(rel)
+		switch {
+		case name == "buf.gen.yaml":
+			targets = append(targets, codegenTarget{Dir: dir, Command: []string{"buf", "generate"}, Origin: rel})
+		case strings.HasSuffix(name, ".go"):
+			directives, err := goGenerateDirectives(path)
+			if err != nil {
+				return err
+			}
+			if slices.ContainsFunc(directives, codegenGenerators.MatchString) {
+				targets = append(targets, codegenTarget{Dir: dir, Command: []string{"go", "generate", name}, Origin: rel})
+			}
+		}
+		if IsAPISpec(path) {
+			specs = append(specs, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to search for API specs: %w", err)
+	}
+	return specs, targets, nil
+}
+
+// goGenerateDirectives returns the go:generate directives in the Go file at path.
+func goGenerateDirectives(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var directives []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if d, ok := strings.CutPrefix(sc.Text(), "//go:generate "); ok {
+			directives = append(directives, d)
+		}
+	}
+	return directives, sc.Err()
+}
+
+// apiSpecMarker matches the top-level key that identifies an OpenAPI or Swagger document.
+var apiSpecMarker = regexp.MustCompile(`(?m)^(openapi|swagger):|"(openapi|swagger)"\s*:`)
+
+// IsAPISpec reports whether the file at path is an API definition:
+// a protobuf file, or an OpenAPI or Swagger document in YAML or JSON.
+func IsAPISpec(path string) bool {
+	switch filepath.Ext(path) {
+	case ".proto":
+		return true
+	case ".yaml", ".yml", ".json":
+	default:
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	// The marker comes first in practice, after at most a comment header.
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(f, head)
+	return apiSpecMarker.Match(head[:n])
+}
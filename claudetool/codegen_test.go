@@ -0,0 +1,97 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDetectCodegen(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"api/openapi.yaml":        "# The API.\nopenapi: 3.0.0\ninfo: {title: x}\n",
+		"api/config.yaml":         "name: not a spec\n",
+		"api/swagger.json":        `{"swagger": "2.0"}`,
+		"proto/buf.gen.yaml":      "version: v1\n",
+		"proto/greet/greet.proto": `syntax = "proto3";`,
+		"server/gen.go":           "package server\n\n//go:generate oapi-codegen -config cfg.yaml ../api/openapi.yaml\n",
+		"server/other.go":         "package server\n\n//go:generate stringer -type T\n",
+		"node_modules/x/a.proto":  "",
+	})
+	specs, targets, err := detectCodegen(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSpecs := []string{"api/openapi.yaml", "api/swagger.json", "proto/greet/greet.proto"}
+	if !slices.Equal(specs, wantSpecs) {
+		t.Errorf("specs = %q, want %q", specs, wantSpecs)
+	}
+	var got []string
+	for _, tgt := range targets {
+		got = append(got, tgt.String())
+	}
+	want := []string{
+		"buf generate (in proto, from proto/buf.gen.yaml)",
+		"go generate gen.go (in server, from server/gen.go)",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("targets = %q, want %q", got, want)
+	}
+}
+
+func TestCodegenRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go generate and go build")
+	}
+	run := func(root string) (string, error) {
+		out, err := (&CodegenTool{RepoRoot: root}).Tool().Run(context.Background(), json.RawMessage(`{"action": "run"}`))
+		if err != nil {
+			return "", err
+		}
+		return out[0].Text, nil
+	}
+
+	// The "generator" writes the generated code; naming oapi-codegen marks the directive as codegen.
+	gen := "package api\n\n//go:generate sh -c \"printf '%s' > api.gen.go\" oapi-codegen\n"
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":     "module example.com/m\n\ngo 1.22\n",
+		"api/gen.go": strings.ReplaceAll(gen, "%s", `package api\\n\\nfunc Hello() string { return \"hi\" }\\n`),
+	})
+	out, err := run(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Generated code builds.") {
+		t.Errorf("output does not report a successful build:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(root, "api", "api.gen.go")); err != nil {
+		t.Errorf("generator did not run: %v", err)
+	}
+
+	root = t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":     "module example.com/m\n\ngo 1.22\n",
+		"api/gen.go": strings.ReplaceAll(gen, "%s", `package api\\n\\nfunc Hello() string { return 1 }\\n`),
+	})
+	if _, err := run(root); err == nil || !strings.Contains(err.Error(), "does not build") {
+		t.Errorf("err = %v, want a build failure", err)
+	}
+}
@@ -0,0 +1,65 @@
+package codeindex
+
+import (
+	"strings"
+)
+
+const (
+	// chunkLines is how many lines a chunk grows to before it looks for a place to end,
+	// and maxChunkLines how many it may have at most.
+	chunkLines    = 40
+	maxChunkLines = 80
+	// maxChunkBytes is the most text a chunk has; longer lines are cut.
+	maxChunkBytes = 4000
+)
+
+// A span is a range of lines of a file, numbered from 1, and their text.
+type span struct {
+	start, end int
+	text       string
+}
+
+// chunkFile splits content into chunks of about chunkLines lines, ending each, where it can,
+// before a line that starts a top-level declaration or after a blank line, so that
+// chunks tend to hold whole functions and types. Chunks of only blank lines are dropped.
+func chunkFile(content string) []span {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	var spans []span
+	start := 0
+	flush := func(end int) {
+		text := strings.Join(lines[start:end], "\n")
+		if len(text) > maxChunkBytes {
+			text = text[:maxChunkBytes]
+		}
+		if strings.TrimSpace(text) != "" {
+			spans = append(spans, span{start: start + 1, end: end, text: text})
+		}
+		start = end
+	}
+	for i := range lines {
+		n := i - start
+		switch {
+		case n >= maxChunkLines:
+			flush(i)
+		case n >= chunkLines && (topLevel(lines[i]) || strings.TrimSpace(lines[i-1]) == ""):
+			flush(i)
+		}
+	}
+	if start < len(lines) {
+		flush(len(lines))
+	}
+	return spans
+}
+
+// topLevel reports whether line starts a top-level construct: it is not indented,
+// and is not a closing bracket or a blank line.
+func topLevel(line string) bool {
+	if line == "" {
+		return false
+	}
+	switch line[0] {
+	case ' ', '\t', '}', ')', ']':
+		return false
+	}
+	return true
+}
@@ -0,0 +1,386 @@
+// Package codeindex keeps a semantic index of a repository's code: it splits the files into chunks,
+// embeds them with an llm.Embedder, and stores the vectors on disk under .sketch/index,
+// so that the agent can find code by what it does rather than by what it is called.
+// The index is updated incrementally, re-embedding only the files that changed.
+package codeindex
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// Dir is where the index is stored, relative to the repository root.
+const Dir = ".sketch/index"
+
+const (
+	// formatVersion changes whenever the stored index or how files are chunked does,
+	// so that indexes in an older format are rebuilt.
+	formatVersion = 1
+	// maxFileSize is the largest file indexed.
+	maxFileSize = 512 << 10
+	// maxFiles is the most files indexed; in larger repositories, the rest are left out.
+	maxFiles = 20000
+	// debounce is how long Run waits for files to stop changing before it updates the index.
+	debounce = 2 * time.Second
+)
+
+// skipFiles are files not worth indexing: lockfiles and other generated data.
+var skipFiles = []string{"go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "Cargo.lock", "poetry.lock", "uv.lock", "Gemfile.lock", "composer.lock"}
+
+// An Index is a semantic index of the files in a repository.
+// Its methods may be called concurrently.
+type Index struct {
+	root     string
+	embedder llm.Embedder
+	model    string
+
+	update  sync.Mutex // held while updating
+	mu      sync.Mutex // protects the fields below
+	files   map[string]*file
+	dirty   bool
+	changed chan struct{}
+}
+
+// A file is an indexed file.
+type file struct {
+	Size    int64
+	ModTime time.Time
+	Hash    [sha256.Size]byte
+	Chunks  []chunk
+}
+
+// A chunk is an indexed range of lines of a file.
+type chunk struct {
+	StartLine, EndLine int
+	Text               string
+	Vector             []float32
+}
+
+// stored is the index as stored on disk.
+type stored struct {
+	Version int
+	Model   string
+	Files   map[string]*file
+}
+
+// Open opens the index of the repository at root, which embeds with e.
+// model identifies e's embedding model: an index made with another model is rebuilt.
+// The index is loaded from disk if it was stored there; Update brings it up to date.
+func Open(root string, e llm.Embedder, model string) (*Index, error) {
+	ix := &Index{
+		root:     root,
+		embedder: e,
+		model:    model,
+		files:    make(map[string]*file),
+		dirty:    true,
+		changed:  make(chan struct{}, 1),
+	}
+	data, err := os.ReadFile(ix.path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s stored
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		slog.Warn("code_index_unreadable", "path", ix.path(), "error", err)
+		return ix, nil
+	}
+	if s.Version == formatVersion && s.Model == model && s.Files != nil {
+		ix.files = s.Files
+	}
+	return ix, nil
+}
+
+func (ix *Index) path() string {
+	return filepath.Join(ix.root, Dir, "index.gob")
+}
+
+// save stores the index on disk, with a .gitignore so that git ignores it.
+func (ix *Index) save() error {
+	dir := filepath.Join(ix.root, Dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*\n"), 0o644); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	ix.mu.Lock()
+	err := gob.NewEncoder(&buf).Encode(stored{Version: formatVersion, Model: ix.model, Files: ix.files})
+	ix.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := ix.path() + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ix.path())
+}
+
+// Invalidate notes that files may have changed, so that the index is updated before the next search,
+// and by Run once they stop changing.
+func (ix *Index) Invalidate() {
+	ix.mu.Lock()
+	ix.dirty = true
+	ix.mu.Unlock()
+	select {
+	case ix.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run keeps the index up to date until ctx is done: it updates it now,
+// and again whenever files stop changing for a moment after Invalidate.
+func (ix *Index) Run(ctx context.Context) {
+	for {
+		if _, err := ix.Update(ctx); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "code_index_update_failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ix.changed:
+		}
+		for quiet := false; !quiet; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ix.changed:
+			case <-time.After(debounce):
+				quiet = true
+			}
+		}
+	}
+}
+
+// Stats describes the index, and what an update changed.
+type Stats struct {
+	Files, Chunks int // in the index
+	Updated       int // files embedded again
+	Removed       int // files no longer indexed
+}
+
+// Update brings the index up to date with the files in the repository, embedding the files
+// that changed since they were last indexed, and stores it.
+func (ix *Index) Update(ctx context.Context) (Stats, error) {
+	ix.update.Lock()
+	defer ix.update.Unlock()
+	return ix.updateLocked(ctx)
+}
+
+func (ix *Index) updateLocked(ctx context.Context) (Stats, error) {
+	ix.mu.Lock()
+	ix.dirty = false
+	ix.mu.Unlock()
+
+	paths, err := listFiles(ctx, ix.root)
+	if err != nil {
+		ix.Invalidate()
+		return Stats{}, err
+	}
+	var stats Stats
+	type pending struct {
+		path string
+		f    *file
+	}
+	var todo []pending
+	var texts []string
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		seen[p] = true
+		f, changed := ix.check(p)
+		if f == nil || !changed {
+			continue
+		}
+		for _, c := range f.Chunks {
+			texts = append(texts, fmt.Sprintf("%s (lines %d-%d)\n\n%s", p, c.StartLine, c.EndLine, c.Text))
+		}
+		todo = append(todo, pending{p, f})
+	}
+
+	if len(texts) > 0 {
+		start := time.Now()
+		vecs, err := ix.embedder.Embed(ctx, texts)
+		if err != nil {
+			ix.Invalidate()
+			return Stats{}, fmt.Errorf("embedding %d chunks: %w", len(texts), err)
+		}
+		slog.InfoContext(ctx, "code_index_embedded", "files", len(todo), "chunks", len(texts), "elapsed", time.Since(start))
+		for _, t := range todo {
+			for i := range t.f.Chunks {
+				t.f.Chunks[i].Vector, vecs = vecs[0], vecs[1:]
+			}
+		}
+	}
+
+	ix.mu.Lock()
+	for _, t := range todo {
+		ix.files[t.path] = t.f
+	}
+	stats.Updated = len(todo)
+	for p := range ix.files {
+		if !seen[p] {
+			delete(ix.files, p)
+			stats.Removed++
+		}
+	}
+	for _, f := range ix.files {
+		stats.Files++
+		stats.Chunks += len(f.Chunks)
+	}
+	ix.mu.Unlock()
+
+	if stats.Updated > 0 || stats.Removed > 0 {
+		if err := ix.save(); err != nil {
+			return stats, fmt.Errorf("storing the code index: %w", err)
+		}
+	}
+	return stats, nil
+}
+
+// check returns the indexed file at path, relative to the root, and whether it changed since it was indexed.
+// A changed file is returned chunked but not yet embedded. It returns nil for files not worth indexing.
+func (ix *Index) check(p string) (*file, bool) {
+	info, err := os.Stat(filepath.Join(ix.root, p))
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxFileSize || info.Size() == 0 {
+		return nil, false
+	}
+	ix.mu.Lock()
+	old := ix.files[p]
+	ix.mu.Unlock()
+	if old != nil && old.Size == info.Size() && old.ModTime.Equal(info.ModTime()) {
+		return old, false
+	}
+	data, err := os.ReadFile(filepath.Join(ix.root, p))
+	if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return nil, false // unreadable, or binary
+	}
+	f := &file{Size: info.Size(), ModTime: info.ModTime(), Hash: sha256.Sum256(data)}
+	if old != nil && old.Hash == f.Hash {
+		// Touched, but not changed.
+		f.Chunks = old.Chunks
+		ix.mu.Lock()
+		ix.files[p] = f
+		ix.mu.Unlock()
+		return f, false
+	}
+	for _, s := range chunkFile(string(data)) {
+		f.Chunks = append(f.Chunks, chunk{StartLine: s.start, EndLine: s.end, Text: s.text})
+	}
+	return f, true
+}
+
+// listFiles returns the files in the repository at root worth indexing, relative to it,
+// with forward slashes: those git tracks or does not ignore, or if root is not a git repository,
+// those not in hidden directories.
+func listFiles(ctx context.Context, root string) ([]string, error) {
+	var paths []string
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = root
+	if out, err := cmd.Output(); err == nil {
+		for p := range strings.SplitSeq(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+			if p != "" {
+				paths = append(paths, p)
+			}
+		}
+	} else {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if p != root && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules" || d.Name() == "vendor") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel, _ := filepath.Rel(root, p)
+			paths = append(paths, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	paths = slices.DeleteFunc(paths, func(p string) bool {
+		base := path.Base(p)
+		return strings.HasPrefix(p, Dir+"/") || slices.Contains(skipFiles, base) ||
+			strings.HasSuffix(base, ".min.js") || strings.HasSuffix(base, ".min.css") || strings.HasSuffix(base, ".map")
+	})
+	slices.Sort(paths)
+	if len(paths) > maxFiles {
+		slog.WarnContext(ctx, "code_index_truncated", "files", len(paths), "indexed", maxFiles)
+		paths = paths[:maxFiles]
+	}
+	return paths, nil
+}
+
+// A Hit is a chunk of code that matches a search.
+type Hit struct {
+	Path               string
+	StartLine, EndLine int
+	Text               string
+	Score              float64 // cosine similarity to the query
+}
+
+// Search returns the count chunks most similar to query, best first, among the files in dir,
+// or the file dir, relative to the root; an empty dir is the whole repository.
+// If files changed since the last update, it updates the index first,
+// unless it is already being updated: then it searches the index as it is, rather than wait.
+func (ix *Index) Search(ctx context.Context, query string, count int, dir string) ([]Hit, error) {
+	ix.mu.Lock()
+	dirty := ix.dirty
+	ix.mu.Unlock()
+	if dirty && ix.update.TryLock() {
+		_, err := ix.updateLocked(ctx)
+		ix.update.Unlock()
+		if err != nil {
+			// Search the index as it is; most of it is likely still current.
+			slog.WarnContext(ctx, "code_index_update_failed", "error", err)
+		}
+	}
+	vecs, err := ix.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, llm.NewToolError(llm.ToolErrorProvider, fmt.Errorf("embedding the query: %w", err))
+	}
+	q := vecs[0]
+
+	var hits []Hit
+	ix.mu.Lock()
+	for p, f := range ix.files {
+		if dir != "" && p != dir && !strings.HasPrefix(p, dir+"/") {
+			continue
+		}
+		for _, c := range f.Chunks {
+			if c.Vector == nil {
+				continue
+			}
+			hits = append(hits, Hit{Path: p, StartLine: c.StartLine, EndLine: c.EndLine, Text: c.Text, Score: llm.CosineSimilarity(q, c.Vector)})
+		}
+	}
+	ix.mu.Unlock()
+	slices.SortFunc(hits, func(a, b Hit) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Path, b.Path), cmp.Compare(a.StartLine, b.StartLine))
+	})
+	return hits[:min(count, len(hits))], nil
+}
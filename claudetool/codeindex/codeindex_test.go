@@ -0,0 +1,171 @@
+package codeindex
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode"
+)
+
+// wordEmbedder embeds text as a bag of its words, so that texts sharing words are similar.
+type wordEmbedder struct {
+	mu     sync.Mutex
+	inputs int
+}
+
+func (e *wordEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	e.mu.Lock()
+	e.inputs += len(inputs)
+	e.mu.Unlock()
+	var vecs [][]float32
+	for _, in := range inputs {
+		v := make([]float32, 256)
+		for _, w := range strings.FieldsFunc(strings.ToLower(in), func(r rune) bool { return !unicode.IsLetter(r) }) {
+			h := fnv.New32a()
+			h.Write([]byte(w))
+			v[h.Sum32()%256]++
+		}
+		vecs = append(vecs, v)
+	}
+	return vecs, nil
+}
+
+func (e *wordEmbedder) embedded() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.inputs
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".gitignore":      "build/\n",
+		"retry/retry.go":  "package retry\n\n// Do calls f until it succeeds, sleeping with exponential backoff between attempts.\nfunc Do(f func() error) error { return f() }\n",
+		"config/parse.go": "package config\n\n// Parse reads the yaml config file.\nfunc Parse(path string) {}\n",
+		"build/out.go":    "package out // exponential backoff attempts\n",
+		"image.png":       "\x89PNG\x00\x00backoff",
+		"go.sum":          "example.com/backoff v1.0.0 h1:abc\n",
+	})
+	cmd := exec.Command("git", "init", "-q")
+	cmd.Dir = root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+
+	e := &wordEmbedder{}
+	ix, err := Open(root, e, "words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ix.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ignored, binary, and lock files are left out.
+	if stats.Files != 3 || stats.Updated != 3 {
+		t.Errorf("first update: %+v, want 3 files (.gitignore, retry.go, parse.go) updated", stats)
+	}
+
+	hits, err := ix.Search(ctx, "sleeping with exponential backoff", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Path != "retry/retry.go" || hits[0].StartLine != 1 || hits[0].EndLine != 4 {
+		t.Errorf("search for backoff = %+v, want retry/retry.go:1-4", hits)
+	}
+	hits, err = ix.Search(ctx, "sleeping with exponential backoff", 5, "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Path != "config/parse.go" {
+		t.Errorf("search under config = %+v, want only config/parse.go", hits)
+	}
+
+	// Only changed files are embedded again, and deleted ones are dropped.
+	before := e.embedded()
+	writeFiles(t, root, map[string]string{"config/parse.go": "package config\n\n// Parse reads the toml config file.\nfunc Parse(path string) {}\n"})
+	if err := os.Remove(filepath.Join(root, "retry/retry.go")); err != nil {
+		t.Fatal(err)
+	}
+	ix.Invalidate()
+	hits, err = ix.Search(ctx, "toml", 5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.embedded() - before; got != 2 {
+		t.Errorf("after changing one file, embedded %d texts, want 2 (the file and the query)", got)
+	}
+	if len(hits) != 2 || hits[0].Path != "config/parse.go" {
+		t.Errorf("search after changes = %+v, want config/parse.go first, and no retry/retry.go", hits)
+	}
+
+	// The index is stored, and reopening it embeds nothing new, unless the model changed.
+	before = e.embedded()
+	ix, err = Open(root, e, "words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := ix.Update(ctx); err != nil || stats.Updated != 0 || stats.Files != 2 {
+		t.Errorf("update after reopening: %+v, %v; want 2 files, none updated", stats, err)
+	}
+	if e.embedded() != before {
+		t.Errorf("reopening the index embedded %d texts", e.embedded()-before)
+	}
+	ix, err = Open(root, e, "other words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := ix.Update(ctx); err != nil || stats.Updated != 2 {
+		t.Errorf("update with another model: %+v, %v; want 2 files updated", stats, err)
+	}
+
+	out, err := ix.Tool().Run(ctx, json.RawMessage(`{"query": "toml config", "count": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out[0].Text, "config/parse.go:1-4 (score ") || !strings.Contains(out[0].Text, "// Parse reads the toml config file.") {
+		t.Errorf("semantic_search =\n%s", out[0].Text)
+	}
+	out, err = ix.Tool().Run(ctx, json.RawMessage(`{"query": "toml", "path": "nowhere/"}`))
+	if err != nil || out[0].Text != "No indexed code under nowhere." {
+		t.Errorf("semantic_search under nowhere/ = %v, %v", out, err)
+	}
+}
+
+func TestChunkFile(t *testing.T) {
+	var b strings.Builder
+	for i := range 50 {
+		b.WriteString("\tline\n")
+		if i == 44 {
+			b.WriteString("}\n\nfunc next() {\n")
+		}
+	}
+	spans := chunkFile("func first() {\n" + b.String() + "}\n\n\n")
+	if len(spans) != 2 {
+		t.Fatalf("chunkFile made %d chunks, want 2: %+v", len(spans), spans)
+	}
+	// The first chunk ends at the blank line before next, not in the middle of first.
+	if spans[0].start != 1 || spans[0].end != 48 || spans[1].start != 49 || !strings.HasPrefix(spans[1].text, "func next() {") {
+		t.Errorf("chunks = %d-%d, %d-%d %q", spans[0].start, spans[0].end, spans[1].start, spans[1].end, spans[1].text[:20])
+	}
+}
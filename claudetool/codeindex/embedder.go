@@ -0,0 +1,85 @@
+package codeindex
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"strings"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/gem"
+	"sketch.dev/llm/oai"
+)
+
+// Kinds of embedders.
+const (
+	Voyage = "voyage"
+	OpenAI = "openai"
+	Gemini = "gemini"
+	Local  = "local" // an OpenAI-compatible server, such as llama.cpp or Ollama
+)
+
+// Kinds are the kinds of embedders, in the order FromEnvironment prefers them.
+// Local is never chosen unless asked for.
+var Kinds = []string{Voyage, OpenAI, Gemini, Local}
+
+// Env returns the environment variable holding the API key for embedders of kind,
+// or "" if they need none.
+func Env(kind string) string {
+	switch kind {
+	case Voyage:
+		return oai.VoyageAPIKeyEnv
+	case OpenAI:
+		return oai.OpenAIAPIKeyEnv
+	case Gemini:
+		return oai.GeminiAPIKeyEnv
+	}
+	return ""
+}
+
+// New returns an embedder of kind, using model, or the kind's default model if model is empty,
+// and for Local, the server at url, or llama.cpp's default address if url is empty.
+// It also returns a name for the model that identifies the embeddings it makes.
+func New(kind, apiKey, url, model string) (llm.Embedder, string, error) {
+	switch kind {
+	case Voyage, OpenAI:
+		m := oai.TextEmbedding3Small
+		if kind == Voyage {
+			m = oai.VoyageCode3
+		}
+		m.ModelName = cmp.Or(model, m.ModelName)
+		m.URL = cmp.Or(url, m.URL)
+		return &oai.Embedder{APIKey: apiKey, Model: m}, kind + "/" + m.ModelName, nil
+	case Gemini:
+		model = cmp.Or(model, gem.DefaultEmbeddingModel)
+		return &gem.Embedder{APIKey: apiKey, URL: url, Model: model}, kind + "/" + model, nil
+	case Local:
+		m := oai.LlamaCPPEmbedding
+		m.ModelName = cmp.Or(model, m.ModelName)
+		m.URL = cmp.Or(url, m.URL)
+		return &oai.Embedder{APIKey: apiKey, Model: m}, kind + "/" + m.URL + "/" + m.ModelName, nil
+	}
+	return nil, "", fmt.Errorf("unknown embedder %q; want one of %s", kind, strings.Join(Kinds, ", "))
+}
+
+// FromEnvironment returns an embedder of kind, configured by url and model as New is,
+// with its API key from the environment, or nil if that is not set.
+// If kind is "", it returns the first of Kinds whose API key is set, or nil if none is.
+func FromEnvironment(kind, url, model string) (llm.Embedder, string, error) {
+	if kind == Local {
+		return New(kind, "", url, model)
+	}
+	if kind != "" {
+		key := os.Getenv(Env(kind))
+		if key == "" && Env(kind) != "" {
+			return nil, "", nil
+		}
+		return New(kind, key, url, model)
+	}
+	for _, kind := range Kinds {
+		if key := os.Getenv(Env(kind)); key != "" {
+			return New(kind, key, url, model)
+		}
+	}
+	return nil, "", nil
+}
@@ -0,0 +1,101 @@
+package codeindex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+const (
+	// defaultCount and maxCount are the default and greatest count of results.
+	defaultCount = 8
+	maxCount     = 30
+)
+
+// Tool returns an llm.Tool that searches ix.
+func (ix *Index) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        Name,
+		Description: strings.TrimSpace(Description),
+		InputSchema: llm.MustSchema(InputSchema),
+		Run:         ix.run,
+	}
+}
+
+const (
+	Name        = "semantic_search"
+	Description = `
+Searches the repository's code by meaning, returning the chunks of code most similar to a description,
+each with its file, lines, and similarity score.
+
+Use it to find where something is done when you do not know what it is called,
+such as "where are retries with backoff handled" or "parsing of the config file".
+When you know a name or an exact string, grep is faster and more precise.
+The index follows changes to files as they are made.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	InputSchema = `
+{
+  "type": "object",
+  "required": ["query"],
+  "properties": {
+    "query": {
+      "type": "string",
+      "description": "A description of the code to find"
+    },
+    "count": {
+      "type": "integer",
+      "description": "How many chunks to return, at most 30; defaults to 8"
+    },
+    "path": {
+      "type": "string",
+      "description": "Only search files under this path, relative to the repository root"
+    }
+  }
+}
+`
+)
+
+func (ix *Index) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var input struct {
+		Query string `json:"query"`
+		Count int    `json:"count"`
+		Path  string `json:"path"`
+	}
+	if err := json.Unmarshal(m, &input); err != nil {
+		return nil, err
+	}
+	input.Query = strings.TrimSpace(input.Query)
+	if input.Query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	count := input.Count
+	if count <= 0 {
+		count = defaultCount
+	}
+	count = min(count, maxCount)
+	dir := strings.TrimPrefix(path.Clean("/"+strings.TrimSpace(input.Path)), "/")
+
+	hits, err := ix.Search(ctx, input.Query, count, dir)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "semantic_searched", "query", input.Query, "path", dir, "hits", len(hits))
+	if len(hits) == 0 {
+		if dir != "" {
+			return llm.TextContent(fmt.Sprintf("No indexed code under %s.", dir)), nil
+		}
+		return llm.TextContent("No indexed code yet; the repository may still be being indexed. Use grep meanwhile."), nil
+	}
+	var b strings.Builder
+	for _, h := range hits {
+		fmt.Fprintf(&b, "%s:%d-%d (score %.3f)\n%s\n\n", h.Path, h.StartLine, h.EndLine, h.Score, h.Text)
+	}
+	return llm.TextContent(strings.TrimSpace(b.String())), nil
+}
@@ -43,7 +43,9 @@ func (r *CodeReviewer) Tool() *llm.Tool {
 				}
 			}
 		}`),
-		Run: r.Run,
+		// The review runs go generate and go mod tidy in the worktree, and reviews what the calls before it committed.
+		Serial: true,
+		Run:    r.Run,
 	}
 	return spec
 }
@@ -167,6 +169,15 @@ func (r *CodeReviewer) Run(ctx context.Context, m json.RawMessage) ([]llm.Conten
 		errorMessages = append(errorMessages, goplsMsg)
 	}
 
+	unused, err := r.checkUnused(timeoutCtx, changedFiles)
+	if err != nil {
+		// Not worth failing the review over.
+		slog.DebugContext(ctx, "CodeReviewer.Run: failed to check for unused code", "err", err)
+	}
+	if unusedMsg := r.formatUnusedFindings(unused); unusedMsg != "" {
+		errorMessages = append(errorMessages, unusedMsg)
+	}
+
 	// NOTE: If you change this output format, update the corresponding UI parsing in:
 	// webui/src/web-components/sketch-tool-card.ts (SketchToolCardCodeReview.getStatusIcon)
 	buf := new(strings.Builder)
@@ -188,7 +199,13 @@ func (r *CodeReviewer) Run(ctx context.Context, m json.RawMessage) ([]llm.Conten
 
 func (r *CodeReviewer) initializeInitialCommitWorktree(ctx context.Context) error {
 	if r.initialWorktree != "" {
-		return nil
+		// Refresh the worktree's modification time, so that cleanup knows it is in use.
+		now := time.Now()
+		if err := os.Chtimes(r.initialWorktree, now, now); err == nil {
+			return nil
+		}
+		// The worktree is gone, perhaps removed by cleanup; make another.
+		r.initialWorktree = ""
 	}
 	tmpDir, err := os.MkdirTemp("", "sketch-codereview-worktree")
 	if err != nil {
@@ -256,6 +273,9 @@ var goplsIgnore = []string{
 
 	// as of May 2025, Claude doesn't understand strings/bytes.SplitSeq well enough to use it
 	"SplitSeq",
+
+	// unused functions are reported by checkUnused, which knows which code the session added
+	" is unused",
 }
 
 // checkGopls runs gopls check on the provided files in both the current and initial state,
@@ -0,0 +1,144 @@
+package codereview
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// This file finds exported functions that the session changed but that no test refers to.
+// It is a cheap stand-in for coverage: a test may exercise a function indirectly,
+// so an untested function is a prompt to consider a test, not an error.
+
+// An UntestedFinding is an exported function or method, changed since the base commit,
+// that no test in its package refers to.
+type UntestedFinding struct {
+	File         string // relative to the repo root
+	Line, Column int
+	Name         string // Func, or Type.Method
+}
+
+func (f UntestedFinding) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", f.File, f.Line, f.Column, f.Name)
+}
+
+// UntestedFunctions reports the exported functions and methods changed since the base commit
+// that no test in their packages refers to.
+// The done tool uses it to remind the model about tests before it finishes.
+func (r *CodeReviewer) UntestedFunctions(ctx context.Context) ([]UntestedFinding, error) {
+	head, err := r.CurrentCommit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changedFiles, err := r.changedFiles(ctx, r.sketchBaseRef, head)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, added, err := r.loadChangedGoFiles(ctx, changedFiles)
+	if err != nil || len(pkgs) == 0 {
+		return nil, err
+	}
+	findings := findUntested(pkgs, added)
+	for i, f := range findings {
+		if rel, err := filepath.Rel(r.repoRoot, f.File); err == nil {
+			findings[i].File = rel
+		}
+	}
+	return findings, nil
+}
+
+// findUntested finds the exported functions and methods in the non-test files of pkgs
+// that have added lines and that no test file in pkgs refers to.
+// Methods count only if their receiver type is exported too.
+func findUntested(pkgs []*packages.Package, added map[string]map[int]bool) []UntestedFinding {
+	changed := make(map[token.Position]UntestedFinding)
+	tested := make(map[token.Position]bool)
+	for _, pkg := range pkgs {
+		if pkg.TypesInfo == nil {
+			continue
+		}
+		for _, f := range pkg.Syntax {
+			filename := pkg.Fset.File(f.Pos()).Name()
+			if strings.HasSuffix(filename, "_test.go") {
+				ast.Inspect(f, func(n ast.Node) bool {
+					if id, ok := n.(*ast.Ident); ok {
+						if fn, ok := pkg.TypesInfo.Uses[id].(*types.Func); ok {
+							tested[pkg.Fset.Position(fn.Origin().Pos())] = true
+						}
+					}
+					return true
+				})
+				continue
+			}
+			for _, decl := range f.Decls {
+				fd, ok := decl.(*ast.FuncDecl)
+				if !ok || !fd.Name.IsExported() {
+					continue
+				}
+				name := fd.Name.Name
+				if fd.Recv != nil {
+					recv := receiverTypeName(fd.Recv.List[0].Type)
+					if !ast.IsExported(recv) {
+						continue
+					}
+					name = recv + "." + name
+				}
+				if !anyAdded(added[filename], pkg.Fset.Position(fd.Pos()).Line, pkg.Fset.Position(fd.End()).Line) {
+					continue
+				}
+				pos := pkg.Fset.Position(fd.Name.Pos())
+				changed[pos] = UntestedFinding{File: pos.Filename, Line: pos.Line, Column: pos.Column, Name: name}
+			}
+		}
+	}
+
+	var findings []UntestedFinding
+	for pos, f := range changed {
+		if !tested[pos] {
+			findings = append(findings, f)
+		}
+	}
+	slices.SortFunc(findings, func(a, b UntestedFinding) int {
+		return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+	return findings
+}
+
+// receiverTypeName returns the name of the type of a method receiver expression,
+// such as T for *T or T[K].
+func receiverTypeName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// anyAdded reports whether any of the lines from first to last, inclusive, is in added.
+func anyAdded(added map[int]bool, first, last int) bool {
+	for l := first; l <= last; l++ {
+		if added[l] {
+			return true
+		}
+	}
+	return false
+}
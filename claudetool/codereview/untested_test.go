@@ -0,0 +1,87 @@
+package codereview
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+func TestFindUntested(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/p\n\ngo 1.22\n",
+		"p.go": `package p
+
+func Tested() {}
+
+func Untested() {}
+
+func Indirect() {}
+
+func Preexisting() {}
+
+func unexported() {}
+
+type T struct{}
+
+func (T) Tested()    {}
+func (*T) Untested() {}
+
+type G[K any] struct{}
+
+func (G[K]) Generic() {}
+
+type hidden struct{}
+
+func (hidden) Method() {}
+`,
+		"p_test.go": `package p
+
+import "testing"
+
+func TestP(t *testing.T) {
+	f := Indirect
+	f()
+	Tested()
+	T{}.Tested()
+	G[int]{}.Generic()
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &packages.Config{
+		Mode:  packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedDeps | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:   dir,
+		Tests: true,
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every line was added, except the one declaring Preexisting.
+	pfile := filepath.Join(dir, "p.go")
+	added := map[string]map[int]bool{pfile: {}}
+	for i, line := range strings.Split(files["p.go"], "\n") {
+		if !strings.Contains(line, "Preexisting") {
+			added[pfile][i+1] = true
+		}
+	}
+
+	var got []string
+	for _, f := range findUntested(pkgs, added) {
+		got = append(got, f.Name)
+	}
+	want := []string{"Untested", "T.Untested"}
+	if !slices.Equal(got, want) {
+		t.Errorf("findUntested = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,272 @@
+package codereview
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// This file finds dead code added during the session:
+// unexported package-level functions, variables, constants, and types that nothing uses.
+// Only declarations on lines the session added are reported,
+// so that the model cleans up after itself without touching pre-existing code.
+// Unused imports and local variables are compile errors, which checkGopls reports.
+
+// An UnusedFinding is a declaration, added since the base commit, that nothing uses.
+type UnusedFinding struct {
+	File         string // relative to the repo root
+	Line, Column int
+	Kind         string // "function", "variable", "constant", or "type"
+	Name         string
+}
+
+func (f UnusedFinding) String() string {
+	return fmt.Sprintf("%s:%d:%d: unused %s %s", f.File, f.Line, f.Column, f.Kind, f.Name)
+}
+
+// checkUnused reports unused declarations added to changedFiles since the base commit.
+func (r *CodeReviewer) checkUnused(ctx context.Context, changedFiles []string) ([]UnusedFinding, error) {
+	pkgs, added, err := r.loadChangedGoFiles(ctx, changedFiles)
+	if err != nil || len(pkgs) == 0 {
+		return nil, err
+	}
+	findings := findUnused(pkgs, added)
+	for i, f := range findings {
+		if rel, err := filepath.Rel(r.repoRoot, f.File); err == nil {
+			findings[i].File = rel
+		}
+	}
+	return findings, nil
+}
+
+// loadChangedGoFiles type-checks the packages, including test variants, of the Go files among changedFiles,
+// and returns them with the lines of those files added since the base commit (see addedLines).
+// It returns no packages if no Go files changed.
+func (r *CodeReviewer) loadChangedGoFiles(ctx context.Context, changedFiles []string) ([]*packages.Package, map[string]map[int]bool, error) {
+	var goFiles []string
+	for _, file := range changedFiles {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			continue // deleted
+		}
+		goFiles = append(goFiles, file)
+	}
+	if len(goFiles) == 0 {
+		return nil, nil, nil
+	}
+
+	added, err := r.addedLines(ctx, goFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	patterns := make([]string, len(goFiles))
+	for i, file := range goFiles {
+		patterns[i] = "file=" + file
+	}
+	// Type-checking dependencies from source is slower than reading their export data,
+	// but export data is not always readable, as when the toolchain is newer than x/tools.
+	cfg := &packages.Config{
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedDeps | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Context: ctx,
+		Dir:     r.repoRoot,
+		Tests:   true,
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load packages: %w", err)
+	}
+	return pkgs, added, nil
+}
+
+// addedLines returns the lines of files, by absolute path and line number,
+// that were added or changed since the base commit.
+func (r *CodeReviewer) addedLines(ctx context.Context, files []string) (map[string]map[int]bool, error) {
+	args := append([]string{"diff", "-U0", "--no-color", "--no-ext-diff", r.sketchBaseRef, "HEAD", "--"}, files...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff changed files: %w", err)
+	}
+	return parseAddedLines(r.repoRoot, out), nil
+}
+
+// parseAddedLines parses the output of git diff -U0 into the added lines of each file,
+// by absolute path (joined to root) and line number.
+func parseAddedLines(root string, diff []byte) map[string]map[int]bool {
+	added := make(map[string]map[int]bool)
+	var lines map[int]bool
+	sc := bufio.NewScanner(bytes.NewReader(diff))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			lines = nil
+			if path, ok := strings.CutPrefix(line, "+++ b/"); ok {
+				lines = make(map[int]bool)
+				added[filepath.Join(root, path)] = lines
+			}
+		case strings.HasPrefix(line, "@@ ") && lines != nil:
+			// @@ -start[,count] +start[,count] @@
+			fields := strings.Fields(line)
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+				continue
+			}
+			startStr, countStr, hasCount := strings.Cut(fields[2][1:], ",")
+			start, err := strconv.Atoi(startStr)
+			if err != nil {
+				continue
+			}
+			count := 1
+			if hasCount {
+				if count, err = strconv.Atoi(countStr); err != nil {
+					continue
+				}
+			}
+			for l := start; l < start+count; l++ {
+				lines[l] = true
+			}
+		}
+	}
+	return added
+}
+
+// findUnused finds the unused declarations in pkgs that are on added lines.
+// pkgs may include several variants of a package, such as its test variant;
+// a declaration is unused only if no variant uses it.
+// Exported declarations are not reported, as other packages may use them,
+// nor are methods, which may implement interfaces.
+func findUnused(pkgs []*packages.Package, added map[string]map[int]bool) []UnusedFinding {
+	isAdded := func(file string, line int) bool { return added[file][line] }
+
+	declared := make(map[token.Position]UnusedFinding)
+	used := make(map[token.Position]bool)
+	for _, pkg := range pkgs {
+		if pkg.Types == nil || pkg.TypesInfo == nil {
+			continue
+		}
+		scope := pkg.Types.Scope()
+		extents := declExtents(pkg)
+		for id, obj := range pkg.TypesInfo.Defs {
+			if obj == nil || obj.Parent() != scope || obj.Exported() || obj.Name() == "_" {
+				continue
+			}
+			kind := objectKind(obj)
+			if kind == "" || kind == "function" && (obj.Name() == "init" || obj.Name() == "main" && pkg.Name == "main") {
+				continue
+			}
+			pos := pkg.Fset.Position(id.Pos())
+			if !isAdded(pos.Filename, pos.Line) {
+				continue
+			}
+			declared[pos] = UnusedFinding{File: pos.Filename, Line: pos.Line, Column: pos.Column, Kind: kind, Name: obj.Name()}
+		}
+		for id, obj := range pkg.TypesInfo.Uses {
+			if obj.Pkg() != pkg.Types {
+				continue
+			}
+			if slices.ContainsFunc(extents[obj], func(ext [2]token.Pos) bool { return ext[0] <= id.Pos() && id.Pos() < ext[1] }) {
+				continue // recursion, or a type's use in its own methods, does not make it used
+			}
+			if pos := pkg.Fset.Position(obj.Pos()); pos.IsValid() {
+				used[pos] = true
+			}
+		}
+	}
+
+	var findings []UnusedFinding
+	for pos, f := range declared {
+		if !used[pos] {
+			findings = append(findings, f)
+		}
+	}
+	slices.SortFunc(findings, func(a, b UnusedFinding) int {
+		return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+	return findings
+}
+
+func objectKind(obj types.Object) string {
+	switch obj.(type) {
+	case *types.Func:
+		return "function"
+	case *types.Var:
+		return "variable"
+	case *types.Const:
+		return "constant"
+	case *types.TypeName:
+		return "type"
+	}
+	return ""
+}
+
+// declExtents returns the extents of the declarations of pkg's package-level functions and types,
+// including, for a type, those of its methods.
+// Uses within them do not count.
+func declExtents(pkg *packages.Package) map[types.Object][][2]token.Pos {
+	extents := make(map[types.Object][][2]token.Pos)
+	add := func(obj types.Object, n ast.Node) {
+		if obj != nil {
+			extents[obj] = append(extents[obj], [2]token.Pos{n.Pos(), n.End()})
+		}
+	}
+	for _, f := range pkg.Syntax {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				fn, _ := pkg.TypesInfo.Defs[decl.Name].(*types.Func)
+				if fn == nil {
+					continue
+				}
+				if recv := fn.Signature().Recv(); recv != nil {
+					t := recv.Type()
+					if ptr, ok := t.(*types.Pointer); ok {
+						t = ptr.Elem()
+					}
+					if named, ok := t.(*types.Named); ok {
+						add(named.Obj(), decl)
+					}
+					continue
+				}
+				add(fn, decl)
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if ts, ok := spec.(*ast.TypeSpec); ok {
+						add(pkg.TypesInfo.Defs[ts.Name], ts)
+					}
+				}
+			}
+		}
+	}
+	return extents
+}
+
+// formatUnusedFindings formats findings for the model.
+func (r *CodeReviewer) formatUnusedFindings(findings []UnusedFinding) string {
+	if len(findings) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("Unused code added in this session:\n\n")
+	for i, f := range findings {
+		fmt.Fprintf(&sb, "%d. %s:%d:%d: unused %s %s\n", i+1, filepath.Join(r.repoRoot, f.File), f.Line, f.Column, f.Kind, f.Name)
+	}
+	sb.WriteString("\nRemove these declarations, or use them if they were meant to be used.\n")
+	return sb.String()
+}
@@ -0,0 +1,127 @@
+package codereview
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+func TestParseAddedLines(t *testing.T) {
+	diff := `diff --git a/p.go b/p.go
+index 1111111..2222222 100644
+--- a/p.go
++++ b/p.go
+@@ -3,0 +4,2 @@ import "fmt"
++func a() {}
++func b() {}
+@@ -10 +12 @@ func F() {
+-	old()
++	new()
+@@ -20,3 +21,0 @@ func G() {
+diff --git a/gone.go b/gone.go
+--- a/gone.go
++++ /dev/null
+@@ -1,2 +0,0 @@
+`
+	got := parseAddedLines("/repo", []byte(diff))
+	if lines := slices.Sorted(maps.Keys(got["/repo/p.go"])); !slices.Equal(lines, []int{4, 5, 12}) {
+		t.Errorf("added lines of p.go = %v, want [4 5 12]", lines)
+	}
+	if len(got) != 1 {
+		t.Errorf("got added lines for %d files, want 1 (deleted files have none)", len(got))
+	}
+}
+
+func TestFindUnused(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/p\n\ngo 1.22\n",
+		"p.go": `package p
+
+import (
+	"os"
+	"strings"
+)
+
+func Exported() string { return used() }
+
+func used() string {
+	leftover := 1
+	return strings.ToUpper("x")
+}
+
+func unusedFunc() {}
+
+func recursive(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return recursive(n - 1)
+}
+
+var unusedVar = 1
+
+const unusedConst = "c"
+
+type unusedType struct{}
+
+func (unusedType) method() {}
+
+func testOnly() {}
+
+func preexisting() {}
+
+func init() {}
+`,
+		"p_test.go": `package p
+
+import "testing"
+
+func TestP(t *testing.T) { testOnly() }
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &packages.Config{
+		Mode:  packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedDeps | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:   dir,
+		Tests: true,
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every line was added, except the one declaring preexisting.
+	pfile := filepath.Join(dir, "p.go")
+	added := map[string]map[int]bool{pfile: {}}
+	for i, line := range strings.Split(files["p.go"], "\n") {
+		if !strings.Contains(line, "preexisting") {
+			added[pfile][i+1] = true
+		}
+	}
+
+	var got []string
+	for _, f := range findUnused(pkgs, added) {
+		got = append(got, f.Kind+" "+f.Name)
+	}
+	// The unused import and local variable are compile errors, left to gopls.
+	want := []string{
+		"function unusedFunc",
+		"function recursive",
+		"variable unusedVar",
+		"constant unusedConst",
+		"type unusedType",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("findUnused = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package claudetool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// errCPU is the cause of the cancellation of a command that used too much CPU time.
+var errCPU = errors.New("command used too much CPU time")
+
+// cpuPollInterval is how often watchCPU checks a command's CPU time.
+const cpuPollInterval = 100 * time.Millisecond
+
+// clockTicks is the unit of CPU times in /proc: USER_HZ, which is 100 on every Linux platform Go supports.
+const clockTicks = 100
+
+// watchCPU cancels ctx, with cause errCPU, once the processes in process group pgid
+// have used more than limit of CPU time. It returns when ctx is done.
+//
+// CPU time is read from /proc, so the limit is only enforced on Linux.
+func watchCPU(ctx context.Context, pgid int, limit time.Duration, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(cpuPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		used, err := groupCPUTime(pgid)
+		if err != nil {
+			slog.WarnContext(ctx, "bash_cpu_timeout_unenforced", "error", err)
+			return
+		}
+		if used > limit {
+			cancel(errCPU)
+			return
+		}
+	}
+}
+
+// groupCPUTime returns the CPU time, user and system, used by the live processes in process group pgid
+// and by the children they have waited for.
+func groupCPUTime(pgid int) (time.Duration, error) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return 0, err
+	}
+	if len(stats) == 0 {
+		return 0, errors.New("no processes in /proc")
+	}
+	var ticks int64
+	for _, path := range stats {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue // the process exited
+		}
+		group, used, err := parseProcStat(data)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		if group == pgid {
+			ticks += used
+		}
+	}
+	return time.Duration(ticks) * time.Second / clockTicks, nil
+}
+
+// parseProcStat returns the process group and the utime+stime+cutime+cstime, in clock ticks,
+// of the contents of a /proc/<pid>/stat file.
+func parseProcStat(data []byte) (pgid int, ticks int64, err error) {
+	// The command name, in parentheses, may contain spaces and parentheses itself.
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, 0, errors.New("malformed stat")
+	}
+	// Fields after the name, counting from 0: state, ppid, pgrp, ..., utime (11), stime, cutime, cstime (14).
+	fields := bytes.Fields(data[i+1:])
+	if len(fields) < 15 {
+		return 0, 0, errors.New("malformed stat")
+	}
+	if pgid, err = strconv.Atoi(string(fields[2])); err != nil {
+		return 0, 0, err
+	}
+	for _, f := range fields[11:15] {
+		n, err := strconv.ParseInt(string(f), 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		ticks += n
+	}
+	return pgid, ticks, nil
+}
@@ -0,0 +1,271 @@
+// Package deps lets the agent manage a project's dependencies — Go modules, npm packages,
+// and pip requirements: add and upgrade them, check them for known vulnerabilities,
+// and summarize how a change alters them.
+package deps
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// Ecosystems, as the tool's input names them.
+const (
+	Go  = "go"
+	NPM = "npm"
+	Pip = "pip"
+)
+
+// Ecosystems are the ecosystems the tool knows, in the order it looks for their manifests.
+var Ecosystems = []string{Go, NPM, Pip}
+
+// manifests are the files that list each ecosystem's dependencies.
+var manifests = map[string]string{
+	Go:  "go.mod",
+	NPM: "package.json",
+	Pip: "requirements.txt",
+}
+
+// ecosystemOf returns the ecosystem whose manifest is named name, or "".
+func ecosystemOf(name string) string {
+	for eco, m := range manifests {
+		if m == name {
+			return eco
+		}
+	}
+	return ""
+}
+
+// detect returns the ecosystems with a manifest in dir.
+func detect(dir string) []string {
+	var found []string
+	for _, eco := range Ecosystems {
+		if _, err := os.Stat(filepath.Join(dir, manifests[eco])); err == nil {
+			found = append(found, eco)
+		}
+	}
+	return found
+}
+
+// A dep is a dependency as a manifest lists it.
+type dep struct {
+	Version string
+	// Indirect is set for Go modules required only by other modules, and npm dev dependencies.
+	Indirect bool
+}
+
+// parseManifest returns the dependencies listed in data, a manifest of eco, by name.
+// Empty data lists none.
+func parseManifest(eco string, data []byte) (map[string]dep, error) {
+	deps := make(map[string]dep)
+	if len(bytes.TrimSpace(data)) == 0 {
+		return deps, nil
+	}
+	switch eco {
+	case Go:
+		f, err := modfile.Parse("go.mod", data, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range f.Require {
+			deps[r.Mod.Path] = dep{Version: r.Mod.Version, Indirect: r.Indirect}
+		}
+		// A replacement changes what is built as surely as a version does.
+		for _, r := range f.Replace {
+			if d, ok := deps[r.Old.Path]; ok {
+				d.Version += " => " + strings.TrimSpace(r.New.Path+" "+r.New.Version)
+				deps[r.Old.Path] = d
+			}
+		}
+	case NPM:
+		var pkg struct {
+			Dependencies    map[string]string `json:"dependencies"`
+			DevDependencies map[string]string `json:"devDependencies"`
+		}
+		if err := json.Unmarshal(data, &pkg); err != nil {
+			return nil, err
+		}
+		for name, v := range pkg.DevDependencies {
+			deps[name] = dep{Version: v, Indirect: true}
+		}
+		for name, v := range pkg.Dependencies {
+			deps[name] = dep{Version: v}
+		}
+	case Pip:
+		s := bufio.NewScanner(bytes.NewReader(data))
+		for s.Scan() {
+			if name, version, ok := parseRequirement(s.Text()); ok {
+				deps[name] = dep{Version: version}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown ecosystem %q", eco)
+	}
+	return deps, nil
+}
+
+var requirementPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(?:\[[^\]]*\])?\s*(.*)$`)
+
+// parseRequirement parses a line of a requirements file, such as "requests[socks]==2.31.0 ; python_version>'3'",
+// returning the normalized name of the requirement and its version specifier.
+// Comments, blank lines, and options such as -r are not requirements.
+func parseRequirement(line string) (name, version string, ok bool) {
+	line, _, _ = strings.Cut(line, "#")
+	line, _, _ = strings.Cut(line, ";")
+	line = strings.TrimSpace(line)
+	m := requirementPattern.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return normalizePipName(m[1]), strings.ReplaceAll(m[2], " ", ""), true
+}
+
+var pipNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// normalizePipName normalizes a Python package name, as PEP 503 does.
+func normalizePipName(name string) string {
+	return strings.ToLower(pipNameSeparators.ReplaceAllString(name, "-"))
+}
+
+// A Change is a change to one dependency.
+type Change struct {
+	Ecosystem string
+	Name      string
+	From, To  string // "" when the dependency is added or removed
+	Indirect  bool
+}
+
+// Kind returns what kind of change c is: added, removed, upgraded, downgraded, or changed.
+func (c Change) Kind() string {
+	switch {
+	case c.From == "":
+		return "added"
+	case c.To == "":
+		return "removed"
+	}
+	switch compareVersions(c.From, c.To) {
+	case -1:
+		return "upgraded"
+	case 1:
+		return "downgraded"
+	}
+	return "changed"
+}
+
+func (c Change) String() string {
+	marks := map[string]string{"added": "+", "removed": "-", "upgraded": "↑", "downgraded": "↓", "changed": "~"}
+	var s string
+	switch c.Kind() {
+	case "added":
+		s = fmt.Sprintf("%s %s %s", marks["added"], c.Name, c.To)
+	case "removed":
+		s = fmt.Sprintf("%s %s %s", marks["removed"], c.Name, c.From)
+	default:
+		s = fmt.Sprintf("%s %s %s → %s", marks[c.Kind()], c.Name, c.From, c.To)
+	}
+	if c.Indirect {
+		s += indirectNote(c.Ecosystem)
+	}
+	return strings.TrimSpace(s)
+}
+
+func indirectNote(eco string) string {
+	if eco == NPM {
+		return " (dev)"
+	}
+	return " (indirect)"
+}
+
+// compareVersions compares versions a and b, which may have prefixes such as v, ^, ~, or ==,
+// returning 0 if either is not a semantic version.
+func compareVersions(a, b string) int {
+	canon := func(v string) string {
+		v = strings.TrimLeft(v, "v^~=<>! ")
+		v, _, _ = strings.Cut(v, " ")
+		v = "v" + v
+		if !semver.IsValid(v) {
+			return ""
+		}
+		return v
+	}
+	ca, cb := canon(a), canon(b)
+	if ca == "" || cb == "" {
+		return 0
+	}
+	return semver.Compare(ca, cb)
+}
+
+// diffDeps returns the changes from old to new dependencies of eco, sorted by name.
+func diffDeps(eco string, old, new map[string]dep) []Change {
+	var changes []Change
+	for name, n := range new {
+		o, ok := old[name]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Ecosystem: eco, Name: name, To: n.Version, Indirect: n.Indirect})
+		case o.Version != n.Version:
+			changes = append(changes, Change{Ecosystem: eco, Name: name, From: o.Version, To: n.Version, Indirect: n.Indirect})
+		}
+	}
+	for name, o := range old {
+		if _, ok := new[name]; !ok {
+			changes = append(changes, Change{Ecosystem: eco, Name: name, From: o.Version, Indirect: o.Indirect})
+		}
+	}
+	slices.SortFunc(changes, func(a, b Change) int {
+		if a.Indirect != b.Indirect {
+			if a.Indirect {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return changes
+}
+
+// diffManifest returns the changes from the old to the new contents of a manifest of eco.
+func diffManifest(eco string, old, new []byte) ([]Change, error) {
+	o, err := parseManifest(eco, old)
+	if err != nil {
+		return nil, fmt.Errorf("old %s: %w", manifests[eco], err)
+	}
+	n, err := parseManifest(eco, new)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", manifests[eco], err)
+	}
+	return diffDeps(eco, o, n), nil
+}
+
+// summarize writes changes to the manifest at path, with a count of direct and indirect changes.
+func summarize(b *strings.Builder, path string, changes []Change) {
+	if len(changes) == 0 {
+		fmt.Fprintf(b, "%s: no dependency changes\n", path)
+		return
+	}
+	var direct, indirect int
+	for _, c := range changes {
+		if c.Indirect {
+			indirect++
+		} else {
+			direct++
+		}
+	}
+	noun := "dependencies"
+	if len(changes) == 1 {
+		noun = "dependency"
+	}
+	fmt.Fprintf(b, "%s: %d %s changed (%d direct, %d %s)\n", path, len(changes), noun, direct, indirect, strings.Trim(indirectNote(changes[0].Ecosystem), " ()"))
+	for _, c := range changes {
+		fmt.Fprintf(b, "  %s\n", c)
+	}
+}
@@ -0,0 +1,192 @@
+package deps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffManifest(t *testing.T) {
+	for _, tt := range []struct {
+		eco      string
+		old, new string
+		want     []string
+	}{
+		{Go, `module m
+
+require (
+	golang.org/x/net v0.38.0
+	golang.org/x/text v0.24.0 // indirect
+	github.com/old/gone v1.0.0
+)
+`, `module m
+
+require (
+	golang.org/x/net v0.39.0
+	golang.org/x/sync v0.13.0
+	golang.org/x/text v0.23.0 // indirect
+	github.com/new/fork v1.0.0
+)
+
+replace github.com/new/fork => ../fork
+`, []string{
+			"+ github.com/new/fork v1.0.0 => ../fork",
+			"- github.com/old/gone v1.0.0",
+			"↑ golang.org/x/net v0.38.0 → v0.39.0",
+			"+ golang.org/x/sync v0.13.0",
+			"↓ golang.org/x/text v0.24.0 → v0.23.0 (indirect)",
+		}},
+		{NPM, `{"dependencies": {"react": "^18.2.0", "left-pad": "1.0.0"}, "devDependencies": {"vitest": "1.0.0"}}`,
+			`{"dependencies": {"react": "^18.3.1"}, "devDependencies": {"vitest": "1.0.0", "typescript": "latest"}}`, []string{
+				"- left-pad 1.0.0",
+				"↑ react ^18.2.0 → ^18.3.1",
+				"+ typescript latest (dev)",
+			}},
+		{Pip, "# deps\nRequests==2.31.0\nflask>=2 ; python_version > '3.8'\n-r dev.txt\n",
+			"requests[socks]==2.32.3\nFlask>=2\nnumpy\n", []string{
+				"+ numpy",
+				"↑ requests ==2.31.0 → ==2.32.3",
+			}},
+		{Go, "", "module m\nrequire golang.org/x/mod v0.24.0\n", []string{"+ golang.org/x/mod v0.24.0"}},
+	} {
+		changes, err := diffManifest(tt.eco, []byte(tt.old), []byte(tt.new))
+		if err != nil {
+			t.Errorf("%s: %v", tt.eco, err)
+			continue
+		}
+		var got []string
+		for _, c := range changes {
+			got = append(got, c.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s changes =\n%s\nwant\n%s", tt.eco, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestTool(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":         "module example.com/app\n\ngo 1.21\n\nreplace example.com/lib => ./lib\n",
+		"main.go":        "package main\n\nfunc main() {}\n",
+		"lib/go.mod":     "module example.com/lib\n\ngo 1.21\n",
+		"lib/lib.go":     "package lib\n",
+		"web/index.html": "<html></html>\n",
+	})
+	git(t, root, "init", "-q")
+	git(t, root, "add", ".")
+	git(t, root, "commit", "-qm", "initial")
+	base := git(t, root, "rev-parse", "HEAD")
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOFLAGS", "-mod=mod")
+
+	var approved []string
+	approve := func(ctx context.Context, description string) error {
+		approved = append(approved, description)
+		return nil
+	}
+	run := func(tool *Tool, input string) (string, error) {
+		out, err := tool.Tool().Run(context.Background(), json.RawMessage(input))
+		if err != nil {
+			return "", err
+		}
+		return out[0].Text, nil
+	}
+
+	tool := &Tool{RepoRoot: root, BaseRef: base, Approve: approve}
+	out, err := run(tool, `{"action": "add", "packages": ["example.com/lib"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approved) != 1 || approved[0] != "Run go get example.com/lib, changing go.mod" {
+		t.Errorf("approvals = %q", approved)
+	}
+	// Nothing imports it yet, so it is required indirectly.
+	if !strings.Contains(out, "go.mod: 1 dependency changed (0 direct, 1 indirect)\n  + example.com/lib v0.0.0-00010101000000-000000000000 => ./lib (indirect)") {
+		t.Errorf("deps add =\n%s", out)
+	}
+
+	// A new manifest shows up in the impact, along with the changed go.mod.
+	writeFiles(t, root, map[string]string{"web/package.json": `{"dependencies": {"lit": "^3.0.0"}}`})
+	out, err = run(tool, `{"action": "impact"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"go.mod: 1 dependency changed", "web/package.json: 1 dependency changed (1 direct, 0 dev)\n  + lit ^3.0.0"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("deps impact =\n%s\nwant it to contain %q", out, want)
+		}
+	}
+
+	// Declined and disallowed changes are not made.
+	declined := &Tool{RepoRoot: root, Approve: func(context.Context, string) error { return errors.New("the user denied permission") }}
+	for _, tool := range []*Tool{declined, {RepoRoot: root}} {
+		if _, err := run(tool, `{"action": "upgrade"}`); err == nil {
+			t.Errorf("deps upgrade was not gated")
+		}
+	}
+
+	for _, tt := range []struct {
+		input, want string
+	}{
+		{`{"action": "add", "packages": ["-x"]}`, "bad package"},
+		{`{"action": "add"}`, "add needs packages"},
+		{`{"action": "add", "packages": ["lodash"], "ecosystem": "npm"}`, "no package.json"},
+		{`{"action": "add", "packages": ["x"], "dir": "../elsewhere"}`, "outside the repository"},
+		{`{"action": "add", "packages": ["x"], "dir": "web/none"}`, "no go.mod, package.json, or requirements.txt"},
+		{`{"action": "remove"}`, "unknown action"},
+	} {
+		if _, err := run(tool, tt.input); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("deps %s: error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestPinRequirements(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	if exec.Command("python3", "-m", "pip", "show", "pip").Run() != nil {
+		t.Skip("pip is not installed")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"requirements.txt": "# tools\nPip>=1\nother==1.0\n"})
+	if err := pinRequirements(context.Background(), dir, []string{"pip"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "requirements.txt"))
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != "# tools" || !strings.HasPrefix(lines[1], "pip==") || lines[2] != "other==1.0" {
+		t.Errorf("requirements.txt after pinning pip =\n%s", data)
+	}
+}
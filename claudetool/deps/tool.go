@@ -0,0 +1,379 @@
+package deps
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// Tool specifies an llm.Tool that manages the project's dependencies.
+// Every action that changes a manifest must be approved first.
+type Tool struct {
+	// RepoRoot is the root of the repo; directories in the input are relative to it.
+	RepoRoot string
+	// BaseRef is the commit the session started from, which impact compares the manifests with.
+	BaseRef string
+	// Approve is called before each change to a manifest, with a description of it.
+	// The change is made only if it returns nil. If Approve is nil, dependencies cannot be changed.
+	Approve func(ctx context.Context, description string) error
+}
+
+// Tool returns an llm.Tool based on t.
+func (t *Tool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        Name,
+		Description: strings.TrimSpace(Description),
+		InputSchema: llm.MustSchema(InputSchema),
+		Run:         t.run,
+	}
+}
+
+const (
+	Name        = "deps"
+	Description = `
+Manages the project's dependencies: Go modules (go.mod), npm packages (package.json), and pip requirements (requirements.txt).
+
+add adds packages, or changes their versions; upgrade upgrades them to their latest versions.
+Both change the manifest, so the user must approve each one; if they decline, do not retry, and do not edit the manifest by hand instead.
+vulncheck checks the dependencies for known vulnerabilities, with govulncheck, npm audit, or pip-audit.
+impact summarizes how the session's changes alter the dependencies: what was added, removed, upgraded, or downgraded.
+
+Use add rather than editing manifests or running go get and npm install with bash.
+After adding or upgrading dependencies, run vulncheck, and mention the impact when you report your work.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	InputSchema = `
+{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["add", "upgrade", "vulncheck", "impact"],
+      "description": "add adds packages or changes their versions; upgrade upgrades packages to their latest versions; vulncheck checks for known vulnerabilities; impact summarizes dependency changes since the session started"
+    },
+    "packages": {
+      "type": "array",
+      "items": {"type": "string"},
+      "description": "Packages to add or upgrade, optionally with a version: golang.org/x/sync@v0.13.0, react@18.3.1, requests==2.32.3. upgrade without packages upgrades every direct dependency"
+    },
+    "ecosystem": {
+      "type": "string",
+      "enum": ["go", "npm", "pip"],
+      "description": "Which dependencies to work with; defaults to the only kind whose manifest is in dir"
+    },
+    "dir": {
+      "type": "string",
+      "description": "Directory of the manifest, relative to the repository root; defaults to the root"
+    }
+  }
+}
+`
+)
+
+const (
+	// commandTimeout bounds each package manager and vulnerability checker run.
+	commandTimeout = 5 * time.Minute
+	// maxOutput is the most of a command's output returned.
+	maxOutput = 16 << 10
+)
+
+type input struct {
+	Action    string   `json:"action"`
+	Packages  []string `json:"packages"`
+	Ecosystem string   `json:"ecosystem"`
+	Dir       string   `json:"dir"`
+}
+
+func (t *Tool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var in input
+	if err := json.Unmarshal(m, &in); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deps input: %w", err)
+	}
+	dir := filepath.Join(t.RepoRoot, in.Dir)
+	if rel, err := filepath.Rel(t.RepoRoot, dir); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("dir %q is outside the repository", in.Dir)
+	}
+	for _, p := range in.Packages {
+		if p == "" || strings.HasPrefix(p, "-") {
+			return nil, fmt.Errorf("bad package %q", p)
+		}
+	}
+
+	var out string
+	var err error
+	switch in.Action {
+	case "impact":
+		out, err = t.impact(ctx)
+	case "add", "upgrade", "vulncheck":
+		eco, ecoErr := ecosystem(dir, in.Ecosystem)
+		if ecoErr != nil {
+			return nil, ecoErr
+		}
+		if in.Action == "vulncheck" {
+			out, err = vulncheck(ctx, dir, eco)
+		} else {
+			out, err = t.change(ctx, dir, eco, in)
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q; want add, upgrade, vulncheck, or impact", in.Action)
+	}
+	slog.InfoContext(ctx, "deps", "action", in.Action, "ecosystem", in.Ecosystem, "packages", in.Packages, "error", err)
+	if err != nil {
+		return nil, err
+	}
+	return llm.TextContent(out), nil
+}
+
+// ecosystem returns eco, if set, checking that dir has its manifest; otherwise,
+// the only ecosystem with a manifest in dir.
+func ecosystem(dir, eco string) (string, error) {
+	found := detect(dir)
+	if eco != "" {
+		if manifests[eco] == "" {
+			return "", fmt.Errorf("unknown ecosystem %q; want go, npm, or pip", eco)
+		}
+		if eco != Pip && !slices.Contains(found, eco) {
+			return "", fmt.Errorf("no %s in %s", manifests[eco], dir)
+		}
+		return eco, nil
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no go.mod, package.json, or requirements.txt in %s; say which ecosystem, or give a dir", dir)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("%s has manifests for %s; say which ecosystem", dir, strings.Join(found, ", "))
+}
+
+// change adds or upgrades packages, once approved, and reports what changed.
+func (t *Tool) change(ctx context.Context, dir, eco string, in input) (string, error) {
+	args, err := changeCommand(eco, in.Action, in.Packages)
+	if err != nil {
+		return "", err
+	}
+	manifest := filepath.Join(dir, manifests[eco])
+	rel, _ := filepath.Rel(t.RepoRoot, manifest)
+	if t.Approve == nil {
+		return "", fmt.Errorf("changing dependencies is not allowed in this session")
+	}
+	if err := t.Approve(ctx, fmt.Sprintf("Run %s, changing %s", strings.Join(args, " "), rel)); err != nil {
+		return "", err
+	}
+
+	before, _ := os.ReadFile(manifest)
+	out, err := runCommand(ctx, dir, args...)
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w\n%s", strings.Join(args, " "), err, out)
+	}
+	if eco == Pip {
+		if err := pinRequirements(ctx, dir, in.Packages); err != nil {
+			return "", err
+		}
+	}
+	after, _ := os.ReadFile(manifest)
+	changes, err := diffManifest(eco, before, after)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ %s\n", strings.Join(args, " "))
+	if out != "" {
+		fmt.Fprintf(&b, "%s\n", out)
+	}
+	b.WriteString("\n")
+	summarize(&b, rel, changes)
+	if len(changes) > 0 {
+		b.WriteString("\nRun deps vulncheck to check the new versions for known vulnerabilities.\n")
+	}
+	return b.String(), nil
+}
+
+// changeCommand returns the command that adds packages to the dependencies of eco,
+// or with action upgrade, upgrades them, or every direct dependency if there are none.
+func changeCommand(eco, action string, packages []string) ([]string, error) {
+	if action == "add" && len(packages) == 0 {
+		return nil, fmt.Errorf("add needs packages")
+	}
+	switch eco {
+	case Go:
+		if action == "upgrade" {
+			if len(packages) == 0 {
+				return []string{"go", "get", "-u", "./..."}, nil
+			}
+			return append([]string{"go", "get", "-u"}, packages...), nil
+		}
+		return append([]string{"go", "get"}, packages...), nil
+	case NPM:
+		if action == "upgrade" {
+			if len(packages) == 0 {
+				return []string{"npm", "update"}, nil
+			}
+			latest := make([]string, len(packages))
+			for i, p := range packages {
+				latest[i] = npmName(p) + "@latest"
+			}
+			return append([]string{"npm", "install"}, latest...), nil
+		}
+		return append([]string{"npm", "install"}, packages...), nil
+	case Pip:
+		if action == "upgrade" {
+			if len(packages) == 0 {
+				return nil, fmt.Errorf("pip cannot upgrade everything at once; name the packages to upgrade")
+			}
+			return append([]string{"python3", "-m", "pip", "install", "--upgrade"}, packages...), nil
+		}
+		return append([]string{"python3", "-m", "pip", "install"}, packages...), nil
+	}
+	return nil, fmt.Errorf("unknown ecosystem %q", eco)
+}
+
+// npmName returns the name of the npm package in spec, such as react@18 or @types/node@20.
+func npmName(spec string) string {
+	if i := strings.LastIndexByte(spec, '@'); i > 0 {
+		return spec[:i]
+	}
+	return spec
+}
+
+// pinRequirements pins packages, which pip just installed, in dir's requirements.txt,
+// at the versions installed, replacing any requirements for them already there.
+func pinRequirements(ctx context.Context, dir string, packages []string) error {
+	path := filepath.Join(dir, manifests[Pip])
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	pins := make(map[string]string) // normalized name to requirement
+	var order []string
+	for _, p := range packages {
+		name, _, ok := parseRequirement(p)
+		if !ok {
+			continue
+		}
+		show, err := runCommand(ctx, dir, "python3", "-m", "pip", "show", name)
+		if err != nil {
+			return fmt.Errorf("pip show %s: %w", name, err)
+		}
+		for line := range strings.Lines(show) {
+			if v, ok := strings.CutPrefix(line, "Version:"); ok {
+				pins[name] = name + "==" + strings.TrimSpace(v)
+				order = append(order, name)
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if name, _, ok := parseRequirement(line); ok && pins[name] != "" {
+			b.WriteString(pins[name] + "\n")
+			delete(pins, name)
+			continue
+		}
+		b.WriteString(line + "\n")
+	}
+	for _, name := range order {
+		if pin, ok := pins[name]; ok {
+			b.WriteString(pin + "\n")
+		}
+	}
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+// vulncheck checks eco's dependencies in dir for known vulnerabilities.
+func vulncheck(ctx context.Context, dir, eco string) (string, error) {
+	var args []string
+	var install string
+	switch eco {
+	case Go:
+		args, install = []string{"govulncheck", "./..."}, "go install golang.org/x/vuln/cmd/govulncheck@latest"
+	case NPM:
+		args = []string{"npm", "audit"}
+	case Pip:
+		args, install = []string{"pip-audit", "-r", manifests[Pip]}, "python3 -m pip install pip-audit"
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		if install != "" {
+			return "", fmt.Errorf("%s is not installed; install it with: %s", args[0], install)
+		}
+		return "", fmt.Errorf("%s is not installed", args[0])
+	}
+	out, err := runCommand(ctx, dir, args...)
+	// The checkers exit non-zero when they find vulnerabilities, which is a result, not a failure.
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && out != "") {
+		return "", fmt.Errorf("%s failed: %w\n%s", strings.Join(args, " "), err, out)
+	}
+	if err != nil {
+		return fmt.Sprintf("$ %s\n%s\n\nVulnerabilities found (exit status %d).", strings.Join(args, " "), out, exitErr.ExitCode()), nil
+	}
+	return fmt.Sprintf("$ %s\n%s", strings.Join(args, " "), out), nil
+}
+
+// impact summarizes the changes to the repo's manifests since the session started.
+func (t *Tool) impact(ctx context.Context) (string, error) {
+	if t.BaseRef == "" {
+		return "", fmt.Errorf("the session's starting commit is unknown")
+	}
+	out, err := runCommand(ctx, t.RepoRoot, "git", "diff", "--name-only", t.BaseRef, "--", "*go.mod", "*package.json", "*requirements.txt")
+	if err != nil {
+		return "", fmt.Errorf("git diff: %w\n%s", err, out)
+	}
+	// New manifests are not in the diff until they are added.
+	untracked, err := runCommand(ctx, t.RepoRoot, "git", "ls-files", "--others", "--exclude-standard", "--", "*go.mod", "*package.json", "*requirements.txt")
+	if err != nil {
+		return "", fmt.Errorf("git ls-files: %w\n%s", err, untracked)
+	}
+	var b strings.Builder
+	for _, path := range strings.Split(out+"\n"+untracked, "\n") {
+		eco := ecosystemOf(filepath.Base(path))
+		if eco == "" || strings.Contains(path, "node_modules/") {
+			continue
+		}
+		old, err := runCommand(ctx, t.RepoRoot, "git", "show", t.BaseRef+":"+path)
+		if err != nil {
+			old = "" // the manifest is new
+		}
+		current, _ := os.ReadFile(filepath.Join(t.RepoRoot, path))
+		changes, err := diffManifest(eco, []byte(old), current)
+		if err != nil {
+			fmt.Fprintf(&b, "%s: %v\n", path, err)
+			continue
+		}
+		summarize(&b, path, changes)
+	}
+	if b.Len() == 0 {
+		return "No dependency changes since the session started.", nil
+	}
+	return b.String(), nil
+}
+
+// runCommand runs args in dir, returning its combined output, truncated to maxOutput.
+func runCommand(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if len(out) > maxOutput {
+		out = append(out[:maxOutput], "\n[output truncated]"...)
+	}
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,386 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// EnvironmentTool returns an llm.Tool that sets environment overrides for b's commands,
+// and snapshots and restores the mutable state of the environment: installed packages,
+// the overrides, and b's background jobs.
+func (b *BashTool) EnvironmentTool() *llm.Tool {
+	return &llm.Tool{
+		Name:        environmentName,
+		Description: strings.TrimSpace(environmentDescription),
+		InputSchema: llm.MustSchema(environmentInputSchema),
+		Run:         b.runEnvironment,
+	}
+}
+
+const (
+	environmentName        = "environment"
+	environmentDescription = `
+Manages the environment that bash commands run in, so that invasive experiments can be rolled back.
+set and unset change environment variables for all later bash commands and background jobs.
+snapshot records the installed system, Python, and global npm packages, the environment variables set,
+and the background jobs running, under a name. Take one before an experiment such as installing
+another version of a tool. restore returns to a snapshot: it removes packages installed since,
+reinstalls the versions that were changed or removed, resets the environment variables,
+and stops the background jobs and job groups started since. Files outside package managers are not restored;
+use git for the repository. show lists the environment variables set and the snapshots.
+`
+	// If you modify this, update the termui template for prettier rendering.
+	environmentInputSchema = `
+{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["set", "unset", "snapshot", "restore", "show"]
+    },
+    "vars": {
+      "type": "object",
+      "additionalProperties": {"type": "string"},
+      "description": "For set: environment variables and their values"
+    },
+    "names": {
+      "type": "array",
+      "items": {"type": "string"},
+      "description": "For unset: names of environment variables to stop setting"
+    },
+    "snapshot": {
+      "type": "string",
+      "description": "For snapshot and restore: name of the snapshot"
+    }
+  }
+}
+`
+)
+
+type environmentInput struct {
+	Action   string            `json:"action"`
+	Vars     map[string]string `json:"vars"`
+	Names    []string          `json:"names"`
+	Snapshot string            `json:"snapshot"`
+}
+
+// envState holds a BashTool's environment overrides and snapshots.
+// Its zero value is ready to use.
+type envState struct {
+	mu        sync.Mutex
+	vars      map[string]string
+	snapshots map[string]*envSnapshot
+}
+
+// An envSnapshot is the mutable state of the environment at a point in time.
+type envSnapshot struct {
+	taken    time.Time
+	vars     map[string]string
+	packages map[string]map[string]string // by package manager, installed versions by package
+	jobs     []int                        // background jobs running
+	groups   []string                     // job groups running
+}
+
+// environ returns the overrides as KEY=value, sorted.
+func (s *envState) environ() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var env []string
+	for _, k := range slices.Sorted(maps.Keys(s.vars)) {
+		env = append(env, k+"="+s.vars[k])
+	}
+	return env
+}
+
+// environ returns the environment variables for b's commands, beyond sketch's own, as KEY=value:
+// b.Env, then the session's overrides, which take precedence.
+func (b *BashTool) environ() []string {
+	var env []string
+	for _, k := range slices.Sorted(maps.Keys(b.Env)) {
+		env = append(env, k+"="+b.Env[k])
+	}
+	return append(env, b.env.environ()...)
+}
+
+// A packageManager is a package manager whose installed packages are snapshotted.
+type packageManager struct {
+	name string
+	// list prints each installed package's name and version, separated by a space, one per line.
+	// It fails if the package manager is not installed.
+	list    string
+	pin     string // formats a package's name and version for install
+	install string // installs the packages that follow it
+	remove  string // removes the packages that follow it
+}
+
+// packageManagers are the package managers whose packages snapshots record.
+var packageManagers = []packageManager{
+	{
+		name:    "apt",
+		list:    `dpkg-query -W -f='${Package} ${Version}\n'`,
+		pin:     "%s=%s",
+		install: "DEBIAN_FRONTEND=noninteractive apt-get install -y --allow-downgrades",
+		remove:  "DEBIAN_FRONTEND=noninteractive apt-get remove -y",
+	},
+	{
+		name:    "pip",
+		list:    `python3 -m pip list --format=freeze --disable-pip-version-check | sed -n 's/==/ /p'`,
+		pin:     "%s==%s",
+		install: "python3 -m pip install --disable-pip-version-check",
+		remove:  "python3 -m pip uninstall -y --disable-pip-version-check",
+	},
+	{
+		name:    "npm",
+		list:    `npm ls -g --depth=0 --json | node -e 'for (const [n, d] of Object.entries(JSON.parse(require("fs").readFileSync(0)).dependencies || {})) console.log(n, d.version)'`,
+		pin:     "%s@%s",
+		install: "npm install -g",
+		remove:  "npm uninstall -g",
+	},
+}
+
+// packageTimeout bounds each command that lists, installs, or removes packages.
+const packageTimeout = 10 * time.Minute
+
+func (b *BashTool) runEnvironment(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var in environmentInput
+	if err := json.Unmarshal(m, &in); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal environment input: %w", err)
+	}
+	switch in.Action {
+	case "set":
+		if len(in.Vars) == 0 {
+			return nil, fmt.Errorf("set requires vars")
+		}
+		for k := range in.Vars {
+			if k == "" || strings.ContainsAny(k, "= \x00") {
+				return nil, fmt.Errorf("bad environment variable name %q", k)
+			}
+		}
+		b.env.mu.Lock()
+		if b.env.vars == nil {
+			b.env.vars = make(map[string]string)
+		}
+		maps.Copy(b.env.vars, in.Vars)
+		b.env.mu.Unlock()
+		return llm.TextContent(b.showEnvironment()), nil
+	case "unset":
+		b.env.mu.Lock()
+		for _, k := range in.Names {
+			delete(b.env.vars, k)
+		}
+		b.env.mu.Unlock()
+		return llm.TextContent(b.showEnvironment()), nil
+	case "snapshot":
+		if in.Snapshot == "" {
+			return nil, fmt.Errorf("snapshot requires a snapshot name")
+		}
+		snap := b.takeSnapshot(ctx)
+		b.env.mu.Lock()
+		if b.env.snapshots == nil {
+			b.env.snapshots = make(map[string]*envSnapshot)
+		}
+		b.env.snapshots[in.Snapshot] = snap
+		b.env.mu.Unlock()
+		slog.InfoContext(ctx, "environment_snapshot", "snapshot", in.Snapshot, "jobs", len(snap.jobs))
+		return llm.TextContent(fmt.Sprintf("Took snapshot %s: %s.", in.Snapshot, snap.describe())), nil
+	case "restore":
+		b.env.mu.Lock()
+		snap := b.env.snapshots[in.Snapshot]
+		b.env.mu.Unlock()
+		if snap == nil {
+			return nil, fmt.Errorf("no snapshot %q", in.Snapshot)
+		}
+		out, err := b.restoreSnapshot(ctx, snap)
+		if err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "environment_restore", "snapshot", in.Snapshot)
+		return llm.TextContent(out), nil
+	case "show":
+		return llm.TextContent(b.showEnvironment()), nil
+	default:
+		return nil, fmt.Errorf("unknown action %q", in.Action)
+	}
+}
+
+// showEnvironment describes b's environment overrides and snapshots.
+func (b *BashTool) showEnvironment() string {
+	var s strings.Builder
+	env := b.env.environ()
+	if len(env) == 0 {
+		s.WriteString("No environment variables are set.\n")
+	} else {
+		s.WriteString("Environment variables set:\n")
+		for _, kv := range env {
+			fmt.Fprintf(&s, "  %s\n", kv)
+		}
+	}
+	b.env.mu.Lock()
+	defer b.env.mu.Unlock()
+	for _, name := range slices.Sorted(maps.Keys(b.env.snapshots)) {
+		snap := b.env.snapshots[name]
+		fmt.Fprintf(&s, "Snapshot %s, taken %s: %s\n", name, snap.taken.Format(time.TimeOnly), snap.describe())
+	}
+	return s.String()
+}
+
+func (snap *envSnapshot) describe() string {
+	var parts []string
+	for _, pm := range slices.Sorted(maps.Keys(snap.packages)) {
+		parts = append(parts, fmt.Sprintf("%d %s packages", len(snap.packages[pm]), pm))
+	}
+	parts = append(parts, fmt.Sprintf("%d environment variables", len(snap.vars)), fmt.Sprintf("%d background jobs", len(snap.jobs)))
+	return strings.Join(parts, ", ")
+}
+
+// takeSnapshot records the current state of b's environment.
+func (b *BashTool) takeSnapshot(ctx context.Context) *envSnapshot {
+	snap := &envSnapshot{
+		taken:    time.Now(),
+		packages: make(map[string]map[string]string),
+		jobs:     b.jobs.running(),
+		groups:   b.jobs.groupNames(),
+	}
+	b.env.mu.Lock()
+	snap.vars = maps.Clone(b.env.vars)
+	b.env.mu.Unlock()
+	for _, pm := range packageManagers {
+		pkgs, err := b.listPackages(ctx, pm)
+		if err != nil {
+			slog.DebugContext(ctx, "environment_list_failed", "manager", pm.name, "error", err)
+			continue // not installed
+		}
+		snap.packages[pm.name] = pkgs
+	}
+	return snap
+}
+
+// listPackages returns the packages pm has installed, by name, with their versions.
+func (b *BashTool) listPackages(ctx context.Context, pm packageManager) (map[string]string, error) {
+	out, err := executeBash(ctx, bashInput{Command: pm.list, Timeout: packageTimeout.String(), Env: b.environ()})
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make(map[string]string)
+	for line := range strings.Lines(out) {
+		if name, version, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			pkgs[name] = version
+		}
+	}
+	return pkgs, nil
+}
+
+// packageChanges returns what changes installed packages from now back to then:
+// the packages to install at the given versions, and the packages to remove, each sorted.
+func packageChanges(then, now map[string]string) (install, remove []string) {
+	for name, version := range then {
+		if now[name] != version {
+			install = append(install, name)
+		}
+	}
+	for name := range now {
+		if _, ok := then[name]; !ok {
+			remove = append(remove, name)
+		}
+	}
+	slices.Sort(install)
+	slices.Sort(remove)
+	return install, remove
+}
+
+// restoreSnapshot returns b's environment to snap, and describes what it did.
+// Failures to restore packages are reported in the description, after the rest is restored.
+func (b *BashTool) restoreSnapshot(ctx context.Context, snap *envSnapshot) (string, error) {
+	var s strings.Builder
+
+	// Stop jobs first, so that no job uses a package while it is replaced.
+	var stopped []string
+	for _, group := range b.jobs.groupNames() {
+		if slices.Contains(snap.groups, group) {
+			continue
+		}
+		jobs, _ := b.jobs.removeGroup(group)
+		for _, job := range slices.Backward(jobs) {
+			if job.BackgroundResult != nil {
+				stopJob(ctx, job.BackgroundResult)
+			}
+		}
+		stopped = append(stopped, "group "+group)
+	}
+	var exited []string
+	for _, id := range b.jobs.running() {
+		if !slices.Contains(snap.jobs, id) {
+			stopJob(ctx, b.jobs.result(id))
+			stopped = append(stopped, fmt.Sprintf("job %d", id))
+		}
+	}
+	for _, id := range snap.jobs {
+		if b.jobs.statusOf(id) == JobExited {
+			exited = append(exited, fmt.Sprint(id))
+		}
+	}
+	if len(stopped) > 0 {
+		fmt.Fprintf(&s, "Stopped %s.\n", strings.Join(stopped, ", "))
+	}
+	if len(exited) > 0 {
+		fmt.Fprintf(&s, "Background jobs %s, running at the snapshot, have exited since; restart them if they are needed.\n", strings.Join(exited, ", "))
+	}
+
+	b.env.mu.Lock()
+	b.env.vars = maps.Clone(snap.vars)
+	b.env.mu.Unlock()
+	fmt.Fprintf(&s, "Reset environment variables: %d set.\n", len(snap.vars))
+
+	for _, pm := range packageManagers {
+		then, ok := snap.packages[pm.name]
+		if !ok {
+			continue
+		}
+		now, err := b.listPackages(ctx, pm)
+		if err != nil {
+			fmt.Fprintf(&s, "Could not list %s packages: %v\n", pm.name, err)
+			continue
+		}
+		install, remove := packageChanges(then, now)
+		if len(install) == 0 && len(remove) == 0 {
+			continue
+		}
+		var cmds []string
+		if len(remove) > 0 {
+			cmds = append(cmds, pm.remove+" "+strings.Join(remove, " "))
+		}
+		if len(install) > 0 {
+			var pins []string
+			for _, name := range install {
+				pins = append(pins, fmt.Sprintf(pm.pin, name, then[name]))
+			}
+			cmds = append(cmds, pm.install+" "+strings.Join(pins, " "))
+		}
+		for _, cmd := range cmds {
+			if b.CheckPermission != nil {
+				if err := b.CheckPermission(cmd); err != nil {
+					PermissionDenials.Inc(environmentName, "policy")
+					return "", err
+				}
+			}
+			req := bashInput{Command: cmd, Timeout: packageTimeout.String(), Env: b.environ()}
+			b.recordCommand(ctx, req)
+			if _, err := executeBash(ctx, req); err != nil {
+				fmt.Fprintf(&s, "Failed: %s\n%v\n", cmd, err)
+				continue
+			}
+			fmt.Fprintf(&s, "Ran: %s\n", cmd)
+		}
+	}
+	return s.String(), nil
+}
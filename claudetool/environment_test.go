@@ -0,0 +1,121 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPackageChanges(t *testing.T) {
+	then := map[string]string{"curl": "7.0", "jq": "1.6", "git": "2.40"}
+	now := map[string]string{"curl": "7.0", "jq": "1.7", "ripgrep": "14.0"}
+	install, remove := packageChanges(then, now)
+	if !slices.Equal(install, []string{"git", "jq"}) || !slices.Equal(remove, []string{"ripgrep"}) {
+		t.Errorf("packageChanges = install %q, remove %q", install, remove)
+	}
+}
+
+func TestEnvironmentSnapshot(t *testing.T) {
+	// A fake package manager keeps its packages in a file, one "name version" per line.
+	dir := t.TempDir()
+	pkgs := filepath.Join(dir, "pkgs")
+	os.WriteFile(pkgs, []byte("tool 1.0\nlib 2.0\n"), 0o644)
+	saved := packageManagers
+	packageManagers = []packageManager{{
+		name:    "fake",
+		list:    "cat " + pkgs,
+		pin:     "%s %s",
+		install: "f() { while [ $# -gt 0 ]; do sed -i \"/^$1 /d\" " + pkgs + "; echo \"$1 $2\" >> " + pkgs + "; shift 2; done; }; f",
+		remove:  "f() { for p; do sed -i \"/^$p /d\" " + pkgs + "; done; }; f",
+	}}
+	t.Cleanup(func() { packageManagers = saved })
+
+	bash := &BashTool{}
+	env := bash.EnvironmentTool()
+	ctx := context.Background()
+	run := func(input string) string {
+		t.Helper()
+		out, err := env.Run(ctx, json.RawMessage(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out[0].Text
+	}
+
+	run(`{"action":"set","vars":{"MODE":"old"}}`)
+	before, err := bash.Run(ctx, json.RawMessage(`{"command":"sleep 30","background":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept BackgroundResult
+	json.Unmarshal([]byte(before[0].Text), &kept)
+	if out := run(`{"action":"snapshot","snapshot":"base"}`); !strings.Contains(out, "2 fake packages, 1 environment variables") {
+		t.Errorf("snapshot: %q", out)
+	}
+
+	// The experiment: upgrade tool, add a package, change the environment, and start a server.
+	os.WriteFile(pkgs, []byte("tool 2.0\nlib 2.0\nextra 0.1\n"), 0o644)
+	run(`{"action":"set","vars":{"MODE":"new","EXTRA":"1"}}`)
+	out, err := bash.Run(ctx, json.RawMessage(`{"command":"echo $MODE $EXTRA"}`))
+	if err != nil || strings.TrimSpace(out[0].Text) != "new 1" {
+		t.Fatalf("overridden environment: %q, %v", out[0].Text, err)
+	}
+	out, err = bash.Run(ctx, json.RawMessage(`{"command":"sleep 30","background":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server BackgroundResult
+	json.Unmarshal([]byte(out[0].Text), &server)
+
+	restored := run(`{"action":"restore","snapshot":"base"}`)
+	if !strings.Contains(restored, "Stopped job 2.") {
+		t.Errorf("restore: %q", restored)
+	}
+	if processAlive(server.PID) {
+		t.Error("the server started after the snapshot is still running")
+	}
+	if !processAlive(kept.PID) {
+		t.Error("the job running at the snapshot was stopped")
+	}
+	stopJob(ctx, &kept)
+	data, _ := os.ReadFile(pkgs)
+	if lines := strings.Fields(string(data)); len(lines) != 4 || !strings.Contains(string(data), "tool 1.0\n") || strings.Contains(string(data), "extra") {
+		t.Errorf("packages after restore:\n%s\nrestore said:\n%s", data, restored)
+	}
+	out, err = bash.Run(ctx, json.RawMessage(`{"command":"echo $MODE ${EXTRA:-unset}"}`))
+	if err != nil || strings.TrimSpace(out[0].Text) != "old unset" {
+		t.Errorf("restored environment: %q, %v", out[0].Text, err)
+	}
+
+	if _, err := env.Run(ctx, json.RawMessage(`{"action":"restore","snapshot":"nope"}`)); err == nil {
+		t.Error("restored a snapshot that was never taken")
+	}
+}
+
+func TestBashToolDefaults(t *testing.T) {
+	bash := &BashTool{Env: map[string]string{"BASE": "project", "MODE": "project"}, Timeout: 200 * time.Millisecond}
+	ctx := context.Background()
+	if _, err := bash.EnvironmentTool().Run(ctx, json.RawMessage(`{"action":"set","vars":{"MODE":"session"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	out, err := bash.Run(ctx, json.RawMessage(`{"command":"echo $BASE $MODE"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(out[0].Text); got != "project session" {
+		t.Errorf("environment = %q, want the project's, overridden by the session's", got)
+	}
+
+	start := time.Now()
+	if _, err := bash.Run(ctx, json.RawMessage(`{"command":"sleep 5"}`)); err == nil {
+		t.Error("command outlived the default timeout")
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("command with a 200ms default timeout ran for %v", d)
+	}
+}
@@ -0,0 +1,302 @@
+package claudetool
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// EnvVarsTool specifies an llm.Tool that inventories the environment variables a Go codebase reads,
+// for tasks like documenting configuration, and flags names that look like typos of others.
+type EnvVarsTool struct {
+	// RepoRoot is the directory scanned when the input names none.
+	RepoRoot string
+}
+
+// Tool returns an llm.Tool based on e.
+func (e *EnvVarsTool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        EnvVarsName,
+		Description: strings.TrimSpace(EnvVarsDescription),
+		InputSchema: llm.MustSchema(EnvVarsInputSchema),
+		Run:         e.run,
+	}
+}
+
+const (
+	EnvVarsName        = "env_vars"
+	EnvVarsDescription = `
+Lists the environment variables that the Go code in a directory tree reads,
+with every place each is read: os.Getenv and os.LookupEnv calls,
+and struct fields tagged env or envconfig.
+Names that differ from another variable by a character or two are flagged as possible typos.
+
+Use it to document configuration options, and after adding an environment variable, to check its name.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	EnvVarsInputSchema = `
+{
+  "type": "object",
+  "properties": {
+    "dir": {
+      "type": "string",
+      "description": "Absolute path of the directory to scan; defaults to the repo root"
+    }
+  }
+}
+`
+)
+
+// An envVarUse is a place where the code reads an environment variable.
+type envVarUse struct {
+	Pos  token.Position
+	Kind string // os.Getenv, os.LookupEnv, or the struct tag key
+}
+
+// dynamicEnvVar is the name under which reads of computed variable names are listed.
+const dynamicEnvVar = "(computed name)"
+
+func (e *EnvVarsTool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var input struct {
+		Dir string `json:"dir"`
+	}
+	if err := json.Unmarshal(m, &input); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal env_vars input: %w", err)
+	}
+	dir := cmp.Or(input.Dir, e.RepoRoot)
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("dir %q is not absolute", dir)
+	}
+	vars, err := scanEnvVars(dir)
+	if err != nil {
+		return nil, err
+	}
+	return llm.TextContent(formatEnvVars(vars)), nil
+}
+
+// scanEnvVars returns the environment variables read by the Go files under dir,
+// with their uses, whose positions are relative to dir.
+func scanEnvVars(dir string) (map[string][]envVarUse, error) {
+	vars := make(map[string][]envVarUse)
+	pkgDirs := make(map[string][]string) // directory -> Go files
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(name, ".") || name == "node_modules" || name == "vendor" || name == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(name, ".go") {
+			pkgDirs[filepath.Dir(path)] = append(pkgDirs[filepath.Dir(path)], path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+
+	for _, files := range pkgDirs {
+		fset := token.NewFileSet()
+		var parsed []*ast.File
+		for _, file := range files {
+			f, err := parser.ParseFile(fset, file, nil, parser.SkipObjectResolution)
+			if err != nil {
+				continue // a file being edited may not parse; report what does
+			}
+			parsed = append(parsed, f)
+		}
+		// Variable names are often constants, declared anywhere in the package.
+		consts := stringConsts(parsed)
+		add := func(name string, pos token.Pos, kind string) {
+			p := fset.Position(pos)
+			if rel, err := filepath.Rel(dir, p.Filename); err == nil {
+				p.Filename = rel
+			}
+			vars[name] = append(vars[name], envVarUse{Pos: p, Kind: kind})
+		}
+		for _, f := range parsed {
+			osNames := importNames(f, "os")
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.CallExpr:
+					sel, ok := n.Fun.(*ast.SelectorExpr)
+					if !ok || len(n.Args) != 1 {
+						return true
+					}
+					if x, ok := sel.X.(*ast.Ident); !ok || !osNames[x.Name] || sel.Sel.Name != "Getenv" && sel.Sel.Name != "LookupEnv" {
+						return true
+					}
+					name, ok := stringValue(n.Args[0], consts)
+					if !ok {
+						name = dynamicEnvVar
+					}
+					add(name, n.Pos(), "os."+sel.Sel.Name)
+				case *ast.Field:
+					if n.Tag == nil {
+						return true
+					}
+					tag, err := strconv.Unquote(n.Tag.Value)
+					if err != nil {
+						return true
+					}
+					for _, key := range []string{"env", "envconfig"} {
+						if v, ok := reflect.StructTag(tag).Lookup(key); ok {
+							if name, _, _ := strings.Cut(v, ","); name != "" && name != "-" {
+								add(name, n.Pos(), key+" tag")
+							}
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	return vars, nil
+}
+
+// importNames returns the names under which f imports path.
+func importNames(f *ast.File, path string) map[string]bool {
+	names := make(map[string]bool)
+	for _, imp := range f.Imports {
+		if p, _ := strconv.Unquote(imp.Path.Value); p != path {
+			continue
+		}
+		if imp.Name != nil {
+			names[imp.Name.Name] = true
+		} else {
+			names[filepath.Base(path)] = true
+		}
+	}
+	return names
+}
+
+// stringConsts returns the package-level string constants declared in files.
+func stringConsts(files []*ast.File) map[string]string {
+	consts := make(map[string]string)
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, id := range vs.Names {
+					if i >= len(vs.Values) {
+						break
+					}
+					if lit, ok := vs.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						if s, err := strconv.Unquote(lit.Value); err == nil {
+							consts[id.Name] = s
+						}
+					}
+				}
+			}
+		}
+	}
+	return consts
+}
+
+// stringValue returns the value of expr if it is a string literal or one of consts.
+func stringValue(expr ast.Expr, consts map[string]string) (string, bool) {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		if expr.Kind == token.STRING {
+			s, err := strconv.Unquote(expr.Value)
+			return s, err == nil
+		}
+	case *ast.Ident:
+		s, ok := consts[expr.Name]
+		return s, ok
+	}
+	return "", false
+}
+
+func formatEnvVars(vars map[string][]envVarUse) string {
+	if len(vars) == 0 {
+		return "No environment variables found.\n"
+	}
+	names := slices.Sorted(maps.Keys(vars))
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d environment variables:\n", len(names))
+	for _, name := range names {
+		uses := vars[name]
+		slices.SortFunc(uses, func(a, b envVarUse) int {
+			return cmp.Or(strings.Compare(a.Pos.Filename, b.Pos.Filename), cmp.Compare(a.Pos.Line, b.Pos.Line))
+		})
+		fmt.Fprintf(&b, "\n%s\n", name)
+		for _, u := range uses {
+			fmt.Fprintf(&b, "  %s:%d %s\n", u.Pos.Filename, u.Pos.Line, u.Kind)
+		}
+	}
+	if typos := envVarTypos(vars); len(typos) > 0 {
+		b.WriteString("\nPossible typos:\n")
+		for _, t := range typos {
+			fmt.Fprintf(&b, "- %s\n", t)
+		}
+	}
+	return b.String()
+}
+
+// envVarTypos reports pairs of names that are nearly the same,
+// naming the less used one of each pair as the likely typo.
+func envVarTypos(vars map[string][]envVarUse) []string {
+	names := slices.Sorted(maps.Keys(vars))
+	var typos []string
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			if a == dynamicEnvVar || b == dynamicEnvVar || len(a) < 5 || len(b) < 5 {
+				continue
+			}
+			if d := editDistance(a, b); d == 0 || d > 1+min(len(a), len(b))/12 {
+				continue
+			}
+			typo, other := a, b
+			if len(vars[a]) > len(vars[b]) {
+				typo, other = b, a
+			}
+			use := vars[typo][0].Pos
+			typos = append(typos, fmt.Sprintf("%s (%s:%d) is nearly %s", typo, use.Filename, use.Line, other))
+		}
+	}
+	return typos
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			sub := prev[j-1]
+			if a[i-1] != b[j-1] {
+				sub++
+			}
+			cur[j] = min(sub, prev[j]+1, cur[j-1]+1)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
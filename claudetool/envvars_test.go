@@ -0,0 +1,69 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEnvVars(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"config/config.go": `package config
+
+import (
+	"os"
+	sys "os"
+)
+
+const keyVar = "SERVICE_API_KEY"
+
+type Config struct {
+	Port  int    ` + "`env:\"SERVICE_PORT,required\"`" + `
+	Debug bool   ` + "`envconfig:\"SERVICE_DEBUG\"`" + `
+	Skip  string ` + "`env:\"-\" json:\"skip\"`" + `
+}
+
+func Load() string {
+	if v, ok := sys.LookupEnv("SERVICE_HOST"); ok {
+		return v
+	}
+	name := "X"
+	return os.Getenv(keyVar) + os.Getenv(name)
+}
+`,
+		"cmd/main.go": `package main
+
+import "os"
+
+func main() {
+	_ = os.Getenv("SERVICE_API_KEY")
+	_ = os.Getenv("SERVICE_APIKEY")
+}
+`,
+		"vendor/x/x.go": "package x\n\nimport \"os\"\n\nvar _ = os.Getenv(\"VENDORED\")\n",
+	})
+
+	out, err := (&EnvVarsTool{RepoRoot: root}).Tool().Run(context.Background(), json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out[0].Text
+	for _, want := range []string{
+		"6 environment variables:",
+		"SERVICE_API_KEY\n  cmd/main.go:6 os.Getenv\n  config/config.go:21 os.Getenv\n",
+		"SERVICE_HOST\n  config/config.go:17 os.LookupEnv\n",
+		"SERVICE_PORT\n  config/config.go:11 env tag\n",
+		"SERVICE_DEBUG\n  config/config.go:12 envconfig tag\n",
+		"(computed name)\n  config/config.go:21 os.Getenv\n",
+		"Possible typos:\n- SERVICE_APIKEY (cmd/main.go:7) is nearly SERVICE_API_KEY\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "VENDORED") {
+		t.Errorf("output includes vendored code:\n%s", got)
+	}
+}
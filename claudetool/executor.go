@@ -0,0 +1,79 @@
+package claudetool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// An Executor runs the tools' commands, and reads and writes their files, on another machine,
+// such as a build server that sketch reaches over SSH; see the remote package.
+// The bash, patch, and keyword_search tools use the Executor of their context, if it has one,
+// and then the working directory is the other machine's.
+type Executor interface {
+	Runner
+	// ReadFile returns the contents of the file at path. If there is none, the error wraps fs.ErrNotExist.
+	ReadFile(ctx context.Context, path string) ([]byte, error)
+	// WriteFile replaces the contents of the file at path with data, creating it and its directory if need be.
+	WriteFile(ctx context.Context, path string, data []byte) error
+}
+
+// A Runner runs commands on another machine, such as the Kubernetes Jobs of BashTool.Cluster.
+type Runner interface {
+	// Run runs command with bash in dir, with env (KEY=value) added to its environment,
+	// writing its output to out, in a pseudo-terminal if tty is set, until it exits or ctx is done.
+	Run(ctx context.Context, dir, command string, env []string, tty bool, out io.Writer) error
+}
+
+type executorCtxKeyType string
+
+const executorCtxKey executorCtxKeyType = "executor"
+
+// WithExecutor returns a context whose tools run their commands, and use files, with e.
+func WithExecutor(ctx context.Context, e Executor) context.Context {
+	return context.WithValue(ctx, executorCtxKey, e)
+}
+
+// ExecutorOf returns the Executor of ctx, or nil if tools run their commands, and use files, on this machine.
+func ExecutorOf(ctx context.Context) Executor {
+	e, _ := ctx.Value(executorCtxKey).(Executor)
+	return e
+}
+
+// executeRemoteBash runs req's command with e, in a pseudo-terminal, as executeBash does on this machine.
+// cpu_timeout does not apply: the command's CPU time is not visible from here.
+func executeRemoteBash(ctx context.Context, e Runner, req bashInput, watch *commandWatch) (string, error) {
+	var output bytes.Buffer
+	err := e.Run(ctx, WorkingDir(ctx), req.Command, append([]string{"SKETCH=1"}, req.Env...), true, io.MultiWriter(&output, watch.output))
+	out := strings.TrimRight(strings.ReplaceAll(output.String(), "\r\n", "\n"), "\n")
+	return bashResult(ctx, req, out, err)
+}
+
+// executeRemoteBackground starts req's command in the background with e, in a session of its own,
+// with its output in files on the other machine. Unlike the background commands on this machine,
+// it is not a job, and has no readiness probe; its StopCommand stops it and everything it started.
+func executeRemoteBackground(ctx context.Context, e Executor, req bashInput) (*BackgroundResult, error) {
+	script := `dir=$(mktemp -d /tmp/sketch-bg-XXXXXX) || exit; ` +
+		`setsid nohup bash -c "$SKETCH_BG_COMMAND" >"$dir/stdout" 2>"$dir/stderr" </dev/null & echo "$! $dir"`
+	env := append([]string{"SKETCH=1"}, req.Env...)
+	env = append(env, "SKETCH_BG_COMMAND="+req.Command)
+	var out bytes.Buffer
+	if err := e.Run(ctx, WorkingDir(ctx), script, env, false, &out); err != nil {
+		return nil, fmt.Errorf("failed to start background command: %w\n%s", err, out.String())
+	}
+	pidStr, dir, ok := strings.Cut(strings.TrimSpace(out.String()), " ")
+	pid, err := strconv.Atoi(pidStr)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("failed to start background command: unexpected output %q", out.String())
+	}
+	return &BackgroundResult{
+		SchemaVersion: SchemaVersion,
+		PID:           pid,
+		StdoutFile:    dir + "/stdout",
+		StderrFile:    dir + "/stderr",
+		StopCommand:   fmt.Sprintf("kill -TERM -- -%d", pid),
+	}, nil
+}
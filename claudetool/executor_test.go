@@ -0,0 +1,116 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// localExecutor is an Executor that runs commands on this machine, recording what it is asked to do.
+type localExecutor struct {
+	commands []string
+	writes   []string
+}
+
+func (e *localExecutor) Run(ctx context.Context, dir, command string, env []string, tty bool, out io.Writer) error {
+	e.commands = append(e.commands, command)
+	cmd := exec.CommandContext(ctx, "bash", "-c", command)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = out, out
+	return cmd.Run()
+}
+
+func (e *localExecutor) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (e *localExecutor) WriteFile(ctx context.Context, path string, data []byte) error {
+	e.writes = append(e.writes, path)
+	return os.WriteFile(path, data, 0o600)
+}
+
+func TestExecutor(t *testing.T) {
+	dir := t.TempDir()
+	e := &localExecutor{}
+	ctx := WithExecutor(WithWorkingDir(context.Background(), dir), e)
+
+	result, err := Bash.Run(ctx, json.RawMessage(`{"command":"echo \"$PWD $SKETCH\""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := result[0].Text, dir+" 1"; got != want {
+		t.Errorf("bash output = %q, want %q", got, want)
+	}
+	if _, err := Bash.Run(ctx, json.RawMessage(`{"command":"exit 3"}`)); err == nil {
+		t.Errorf("bash of a failing command succeeded")
+	}
+
+	result, err = Bash.Run(ctx, json.RawMessage(`{"command":"echo started; sleep 30","background":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bg BackgroundResult
+	if err := json.Unmarshal([]byte(result[0].Text), &bg); err != nil {
+		t.Fatalf("background result %q: %v", result[0].Text, err)
+	}
+	if bg.PID == 0 || !strings.HasPrefix(bg.StopCommand, "kill ") {
+		t.Errorf("background result = %+v", bg)
+	}
+	waitForFile(t, bg.StdoutFile) // by then, the command has its session
+	if err := exec.Command("bash", "-c", bg.StopCommand).Run(); err != nil {
+		t.Errorf("%s: %v", bg.StopCommand, err)
+	}
+	os.RemoveAll(filepath.Dir(bg.StdoutFile))
+
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte("one\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := &PatchTool{}
+	m, _ := json.Marshal(PatchInput{Path: path, Patches: []PatchRequest{{Operation: "append_eof", NewText: "two\n"}}})
+	if _, err := p.Tool().Run(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "one\ntwo\n" {
+		t.Errorf("patched file = %q", got)
+	}
+	if len(e.writes) != 1 || e.writes[0] != path {
+		t.Errorf("executor writes = %q, want %q", e.writes, path)
+	}
+}
+
+func TestBashCluster(t *testing.T) {
+	dir := t.TempDir()
+	ctx := WithWorkingDir(context.Background(), dir)
+	cluster := &localExecutor{}
+	b := &BashTool{Cluster: cluster}
+	if !strings.Contains(string(b.Tool().InputSchema), `"cluster"`) || strings.Contains(string((&BashTool{}).Tool().InputSchema), `"cluster"`) {
+		t.Errorf("only bash tools with a Cluster should offer the cluster input")
+	}
+
+	if _, err := b.Run(ctx, json.RawMessage(`{"command":"echo here"}`)); err != nil {
+		t.Fatal(err)
+	}
+	result, err := b.Run(ctx, json.RawMessage(`{"command":"echo there","cluster":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result[0].Text; got != "there" {
+		t.Errorf("cluster output = %q, want %q", got, "there")
+	}
+	if len(cluster.commands) != 1 || cluster.commands[0] != "echo there" {
+		t.Errorf("cluster commands = %q, want only the one with cluster set", cluster.commands)
+	}
+	if _, err := b.Run(ctx, json.RawMessage(`{"command":"sleep 1","cluster":true,"background":true}`)); err == nil {
+		t.Errorf("a background cluster command succeeded")
+	}
+	if _, err := Bash.Run(ctx, json.RawMessage(`{"command":"true","cluster":true}`)); err == nil {
+		t.Errorf("a cluster command without a Cluster succeeded")
+	}
+}
@@ -0,0 +1,460 @@
+package claudetool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"sketch.dev/llm"
+)
+
+// FetchTool specifies an llm.Tool that fetches a web page and reduces it to readable Markdown,
+// so that the agent can read library documentation and look up error messages.
+// It obeys robots.txt, and the domain policy in AllowDomains and DenyDomains.
+// Pages are cached for a while, so that reading a long page in parts fetches it once.
+type FetchTool struct {
+	// Client makes the requests. If nil, a client with a timeout of fetchTimeout is used.
+	Client *http.Client
+	// AllowDomains, if not empty, are the only domains fetched from, along with their subdomains.
+	AllowDomains []string
+	// DenyDomains are domains never fetched from, along with their subdomains.
+	DenyDomains []string
+
+	mu     sync.Mutex
+	pages  map[string]*fetchedPage // by URL
+	robots map[string]*robotsTxt   // by scheme and host
+}
+
+// Tool returns an llm.Tool based on f.
+func (f *FetchTool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        FetchName,
+		Description: strings.TrimSpace(FetchDescription),
+		InputSchema: llm.MustSchema(FetchInputSchema),
+		Run:         f.run,
+	}
+}
+
+const (
+	FetchName        = "fetch_url"
+	FetchDescription = `
+Fetches a web page and returns its main content as Markdown, without navigation, ads, and other boilerplate.
+Plain text and JSON are returned as they are.
+
+Use it to read library and API documentation, changelogs, and issue threads,
+and to look up error messages you do not recognize.
+Long pages come back in parts: to read on, call again with the start_index the result gives.
+It respects robots.txt and the project's domain policy, and cannot log in or run JavaScript;
+use the browser tools for pages that need them.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	FetchInputSchema = `
+{
+  "type": "object",
+  "required": ["url"],
+  "properties": {
+    "url": {
+      "type": "string",
+      "description": "The http or https URL to fetch"
+    },
+    "start_index": {
+      "type": "integer",
+      "description": "Where in the content to start, in bytes, to read on from an earlier call; defaults to 0"
+    },
+    "max_length": {
+      "type": "integer",
+      "description": "The most content to return, in bytes; defaults to 20000"
+    },
+    "raw": {
+      "type": "boolean",
+      "description": "Return the page's HTML as it is, rather than its readable content"
+    }
+  }
+}
+`
+)
+
+const (
+	// fetchTimeout limits how long a fetch may take, robots.txt included.
+	fetchTimeout = 30 * time.Second
+	// fetchMaxBody is the most of a response that is read.
+	fetchMaxBody = 5 << 20
+	// fetchDefaultLength and fetchMaxLength are the default and greatest max_length.
+	fetchDefaultLength = 20000
+	fetchMaxLength     = 100000
+	// fetchCacheTTL is how long fetched pages and robots.txt files are reused.
+	fetchCacheTTL = 15 * time.Minute
+	// fetchCacheSize is how many pages are cached at most.
+	fetchCacheSize = 32
+	// fetchUserAgent identifies the tool to servers, and robotsAgent to robots.txt.
+	fetchUserAgent = "Mozilla/5.0 (compatible; sketch-fetch/1.0; +https://sketch.dev)"
+	robotsAgent    = "sketch"
+)
+
+// A fetchedPage is a page's content, as fetch_url returns it.
+type fetchedPage struct {
+	url     string // after redirects
+	title   string
+	content string
+	at      time.Time
+}
+
+func (f *FetchTool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var input struct {
+		URL        string `json:"url"`
+		StartIndex int    `json:"start_index"`
+		MaxLength  int    `json:"max_length"`
+		Raw        bool   `json:"raw"`
+	}
+	if err := json.Unmarshal(m, &input); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fetch_url input: %w", err)
+	}
+	if input.StartIndex < 0 || input.MaxLength < 0 {
+		return nil, fmt.Errorf("start_index and max_length must not be negative")
+	}
+	maxLength := input.MaxLength
+	if maxLength == 0 {
+		maxLength = fetchDefaultLength
+	}
+	maxLength = min(maxLength, fetchMaxLength)
+
+	page, err := f.fetch(ctx, input.URL, input.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return llm.TextContent(page.excerpt(input.StartIndex, maxLength)), nil
+}
+
+// excerpt returns the part of p's content from start, of at most maxLength bytes, with a header,
+// and a note on how to read on if there is more.
+func (p *fetchedPage) excerpt(start, maxLength int) string {
+	content := p.content
+	buf := new(strings.Builder)
+	fmt.Fprintf(buf, "URL: %s\n", p.url)
+	if p.title != "" {
+		fmt.Fprintf(buf, "Title: %s\n", p.title)
+	}
+	if start >= len(content) && len(content) > 0 {
+		fmt.Fprintf(buf, "\nThe content is only %d bytes long; there is nothing from start_index %d.", len(content), start)
+		return buf.String()
+	}
+	end := min(start+maxLength, len(content))
+	// Cut at character boundaries, preferring a line break near the end.
+	for start > 0 && start < len(content) && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	if end < len(content) {
+		if nl := strings.LastIndexByte(content[start:end], '\n'); nl > maxLength/2 {
+			end = start + nl + 1
+		}
+		for end > start && !utf8.RuneStart(content[end]) {
+			end--
+		}
+	}
+	buf.WriteString("\n")
+	if content == "" {
+		buf.WriteString("(The page has no readable content; try raw, or the browser tools if it needs JavaScript.)")
+		return buf.String()
+	}
+	buf.WriteString(content[start:end])
+	if end < len(content) {
+		fmt.Fprintf(buf, "\n\n(Showing bytes %d-%d of %d. To read on, call fetch_url with start_index %d.)", start, end, len(content), end)
+	}
+	return buf.String()
+}
+
+// fetch returns the content of the page at rawURL, from the cache if it was fetched recently.
+func (f *FetchTool) fetch(ctx context.Context, rawURL string, raw bool) (*fetchedPage, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q: only http and https URLs can be fetched", rawURL)
+	}
+	u.Fragment = ""
+	key := u.String()
+	if raw {
+		key = "raw:" + key
+	}
+	f.mu.Lock()
+	page, ok := f.pages[key]
+	f.mu.Unlock()
+	if ok && time.Since(page.at) < fetchCacheTTL {
+		return page, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	if err := f.check(ctx, u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,text/markdown;q=0.9,application/json;q=0.8,*/*;q=0.5")
+	client := *f.client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return f.check(req.Context(), req.URL)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, fetchMaxBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s failed: %s", resp.Request.URL, resp.Status)
+	}
+
+	page = &fetchedPage{url: resp.Request.URL.String(), at: time.Now()}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		if raw {
+			page.content = string(body)
+		} else {
+			page.title, page.content = readableMarkdown(body, resp.Request.URL)
+		}
+	case isTextMediaType(mediaType) || mediaType == "" && utf8.Valid(body):
+		page.content = string(body)
+	default:
+		return nil, fmt.Errorf("%s is %s, not text; use bash to download it", page.url, mediaType)
+	}
+	page.content = strings.ToValidUTF8(page.content, "\uFFFD")
+	slog.InfoContext(ctx, "url_fetched", "url", page.url, "bytes", len(body), "content_bytes", len(page.content))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pages == nil {
+		f.pages = make(map[string]*fetchedPage)
+	}
+	if len(f.pages) >= fetchCacheSize {
+		// Evict the oldest page.
+		oldest := ""
+		for k, p := range f.pages {
+			if oldest == "" || p.at.Before(f.pages[oldest].at) {
+				oldest = k
+			}
+		}
+		delete(f.pages, oldest)
+	}
+	f.pages[key] = page
+	return page, nil
+}
+
+// isTextMediaType reports whether content of mediaType can be returned as it is.
+func isTextMediaType(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-yaml", "application/yaml", "application/toml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+func (f *FetchTool) client() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+	return &http.Client{Timeout: fetchTimeout}
+}
+
+// check returns an error if the domain policy or the site's robots.txt forbids fetching u.
+func (f *FetchTool) check(ctx context.Context, u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("cannot fetch %s: only http and https URLs can be fetched", u)
+	}
+	host := strings.ToLower(u.Hostname())
+	if matchesDomain(host, f.DenyDomains) {
+		return fmt.Errorf("cannot fetch %s: the project's domain policy denies %s", u, host)
+	}
+	if len(f.AllowDomains) > 0 && !matchesDomain(host, f.AllowDomains) {
+		return fmt.Errorf("cannot fetch %s: the project's domain policy allows only %s", u, strings.Join(f.AllowDomains, ", "))
+	}
+	robots := f.robotsTxt(ctx, u)
+	if !robots.allows(u) {
+		return fmt.Errorf("cannot fetch %s: %s://%s/robots.txt disallows it", u, u.Scheme, u.Host)
+	}
+	return nil
+}
+
+// matchesDomain reports whether host is one of domains, or a subdomain of one.
+func matchesDomain(host string, domains []string) bool {
+	return slices.ContainsFunc(domains, func(d string) bool {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		return host == d || strings.HasSuffix(host, "."+d)
+	})
+}
+
+// robotsTxt returns the rules of the robots.txt of u's site, fetching it if it is not cached.
+func (f *FetchTool) robotsTxt(ctx context.Context, u *url.URL) *robotsTxt {
+	site := u.Scheme + "://" + u.Host
+	f.mu.Lock()
+	r, ok := f.robots[site]
+	f.mu.Unlock()
+	if ok && time.Since(r.at) < fetchCacheTTL {
+		return r
+	}
+	r = f.fetchRobotsTxt(ctx, site)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.robots == nil {
+		f.robots = make(map[string]*robotsTxt)
+	}
+	f.robots[site] = r
+	return r
+}
+
+// fetchRobotsTxt fetches and parses the robots.txt of site.
+// As RFC 9309 has it, a missing robots.txt allows everything, and one that cannot be read because
+// of a server error disallows everything. If the site cannot be reached, everything is allowed,
+// and fetching the page itself reports the problem.
+func (f *FetchTool) fetchRobotsTxt(ctx context.Context, site string) *robotsTxt {
+	r := &robotsTxt{at: time.Now()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, site+"/robots.txt", nil)
+	if err != nil {
+		return r
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return r
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		r.disallowAll = true
+	case resp.StatusCode == http.StatusOK:
+		r.rules = parseRobotsTxt(io.LimitReader(resp.Body, 500<<10), robotsAgent)
+	}
+	return r
+}
+
+// robotsTxt holds the rules of a robots.txt that apply to robotsAgent.
+type robotsTxt struct {
+	rules       []robotsRule
+	disallowAll bool
+	at          time.Time
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string // a path, in which * matches anything, and which a trailing $ anchors
+}
+
+// parseRobotsTxt returns the rules of the group for agent in a robots.txt, or of the group for * if there is none.
+func parseRobotsTxt(r io.Reader, agent string) []robotsRule {
+	var (
+		agentRules, anyRules []robotsRule
+		forAgent, forAny     bool // the current group applies to agent, or to *
+		inRules              bool // the current group's user-agent lines are over
+		seenAgent            bool
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if inRules {
+				forAgent, forAny, inRules = false, false, false
+			}
+			name := strings.ToLower(value)
+			if name == "*" {
+				forAny = true
+			} else if strings.Contains(name, agent) {
+				forAgent, seenAgent = true, true
+			}
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				// An empty disallow allows everything; an empty allow means nothing.
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", pattern: value}
+			if forAgent {
+				agentRules = append(agentRules, rule)
+			}
+			if forAny {
+				anyRules = append(anyRules, rule)
+			}
+		}
+	}
+	if seenAgent {
+		return agentRules
+	}
+	return anyRules
+}
+
+// allows reports whether the rules allow fetching u: the rule with the longest matching pattern decides,
+// allow winning ties, and paths that no rule matches are allowed.
+func (r *robotsTxt) allows(u *url.URL) bool {
+	if r.disallowAll {
+		return u.Path == "/robots.txt"
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	allow, longest := true, -1
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if l := len(rule.pattern); l > longest || l == longest && rule.allow {
+			allow, longest = rule.allow, l
+		}
+	}
+	return allow
+}
+
+// robotsMatch reports whether path matches a robots.txt pattern.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		j := strings.Index(rest, part)
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(part):]
+	}
+	return !anchored || rest == ""
+}
@@ -0,0 +1,205 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadableMarkdown(t *testing.T) {
+	page := `<!doctype html>
+<html><head><title>  strings package
+ - Go </title><style>body{}</style></head>
+<body>
+<header><a href="/">Home</a> <a href="/pkg">Packages</a></header>
+<div class="sidebar-nav"><ul><li>Overview</li></ul></div>
+<main>
+  <h1>Package strings</h1>
+  <p>Package strings implements <em>simple</em> functions to manipulate
+     UTF-8 encoded strings. See <a href="/blog/strings">the blog post</a>
+     and <a href="#index">the index</a>.</p>
+  <div class="cookie-banner">We use cookies.</div>
+  <h2 id="Cut">func Cut</h2>
+  <pre class="language-go"><code>func Cut(s, sep string) (before, after string, found bool)</code></pre>
+  <p>Cut slices <code>s</code> around the first instance of <strong>sep</strong>.</p>
+  <ul>
+    <li>one</li>
+    <li><p>two</p>
+      <ol start="3"><li>three</li><li>four</li></ol>
+    </li>
+  </ul>
+  <blockquote><p>Quoted<br>text</p></blockquote>
+  <table><tr><th>Name</th><th>Use</th></tr><tr><td>Cut</td><td>a|b</td></tr></table>
+  <script>alert("no")</script>
+  <button>Copy</button>
+</main>
+<footer>© Google</footer>
+</body></html>`
+	base, _ := url.Parse("https://pkg.go.dev/strings")
+	title, md := readableMarkdown([]byte(page), base)
+	if title != "strings package - Go" {
+		t.Errorf("title = %q", title)
+	}
+	want := "# Package strings\n\n" +
+		"Package strings implements _simple_ functions to manipulate UTF-8 encoded strings. See [the blog post](https://pkg.go.dev/blog/strings) and the index.\n\n" +
+		"## func Cut\n\n" +
+		"```go\nfunc Cut(s, sep string) (before, after string, found bool)\n```\n\n" +
+		"Cut slices `s` around the first instance of **sep**.\n\n" +
+		"- one\n" +
+		"- two\n" +
+		"  3. three\n" +
+		"  4. four\n\n" +
+		"> Quoted\n> text\n\n" +
+		"| Name | Use |\n| --- | --- |\n| Cut | a\\|b |"
+	if md != want {
+		t.Errorf("readableMarkdown =\n%s\n\nwant\n%s", md, want)
+	}
+
+	// Without main or article, the body is the content, less its header.
+	_, md = readableMarkdown([]byte(`<body><header>Site</header><p>Just text.</p><nav>Links</nav></body>`), base)
+	if md != "Just text." {
+		t.Errorf("readableMarkdown of a plain body = %q", md)
+	}
+}
+
+func TestRobotsTxt(t *testing.T) {
+	rules := parseRobotsTxt(strings.NewReader(`
+# comment
+User-agent: Googlebot
+Disallow: /
+
+User-agent: *
+Disallow: /private/
+Allow: /private/docs/
+Disallow: /*.pdf$
+Disallow: /search?
+`), robotsAgent)
+	r := &robotsTxt{rules: rules}
+	for path, want := range map[string]bool{
+		"/":                    true,
+		"/private/x":           false,
+		"/private/docs/intro":  true,
+		"/files/a.pdf":         false,
+		"/files/a.pdf.html":    true,
+		"/search?q=error":      false,
+		"/searching":           true,
+		"/docs/private/thing":  true,
+		"/private":             true,
+		"/private/docs":        false,
+		"/private/docs/?x=1":   true,
+		"/files/b.pdf?inline=": true,
+	} {
+		u, _ := url.Parse("https://example.com" + path)
+		if got := r.allows(u); got != want {
+			t.Errorf("allows(%s) = %v, want %v", path, got, want)
+		}
+	}
+
+	// A group for sketch takes precedence over the one for everyone.
+	rules = parseRobotsTxt(strings.NewReader("User-agent: *\nDisallow: /\n\nUser-agent: sketch\nUser-agent: other\nDisallow: /tmp/\n"), robotsAgent)
+	if len(rules) != 1 || rules[0].pattern != "/tmp/" {
+		t.Errorf("rules for sketch = %+v, want just Disallow: /tmp/", rules)
+	}
+}
+
+func TestFetchTool(t *testing.T) {
+	var pageFetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /secret\n"))
+	})
+	mux.HandleFunc("/docs", func(w http.ResponseWriter, r *http.Request) {
+		pageFetches.Add(1)
+		if r.UserAgent() != fetchUserAgent {
+			t.Errorf("User-Agent = %q", r.UserAgent())
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><head><title>Docs</title></head><body><article><h1>Intro</h1><p>" + strings.Repeat("word ", 30) + "</p><p>The end.</p></article></body></html>"))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/secret/page", http.StatusFound)
+	})
+	mux.HandleFunc("/data.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok": true}`))
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tool := (&FetchTool{}).Tool()
+	fetch := func(input string) (string, error) {
+		out, err := tool.Run(context.Background(), json.RawMessage(input))
+		if err != nil {
+			return "", err
+		}
+		return out[0].Text, nil
+	}
+
+	out, err := fetch(`{"url": "` + ts.URL + `/docs#section"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "URL: "+ts.URL+"/docs\nTitle: Docs\n\n# Intro\n\nword word") || !strings.HasSuffix(out, "The end.") {
+		t.Errorf("fetch_url of a page =\n%s", out)
+	}
+
+	// A short max_length pages the content, from the cache.
+	out, err = fetch(`{"url": "` + ts.URL + `/docs", "max_length": 40}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "To read on, call fetch_url with start_index 40.") {
+		t.Errorf("fetch_url with max_length 40 =\n%s", out)
+	}
+	out, err = fetch(`{"url": "` + ts.URL + `/docs", "start_index": 40, "max_length": 1000}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "# Intro") || !strings.HasSuffix(out, "The end.") || strings.Contains(out, "read on") {
+		t.Errorf("fetch_url from start_index 40 =\n%s", out)
+	}
+	if n := pageFetches.Load(); n != 1 {
+		t.Errorf("page fetched %d times, want 1", n)
+	}
+
+	if out, err := fetch(`{"url": "` + ts.URL + `/data.json"}`); err != nil || !strings.HasSuffix(out, `{"ok": true}`) {
+		t.Errorf("fetch_url of JSON = %q, %v", out, err)
+	}
+
+	for _, tt := range []struct {
+		input, want string
+	}{
+		{`{"url": "` + ts.URL + `/secret/page"}`, "robots.txt disallows"},
+		{`{"url": "` + ts.URL + `/moved"}`, "robots.txt disallows"},
+		{`{"url": "` + ts.URL + `/image.png"}`, "image/png, not text"},
+		{`{"url": "` + ts.URL + `/missing"}`, "404"},
+		{`{"url": "file:///etc/passwd"}`, "only http and https"},
+	} {
+		if _, err := fetch(tt.input); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("fetch_url(%s) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+
+	// The domain policy applies before anything is fetched.
+	u, _ := url.Parse(ts.URL)
+	denied := &FetchTool{DenyDomains: []string{u.Hostname()}}
+	if _, err := denied.Tool().Run(context.Background(), json.RawMessage(`{"url": "`+ts.URL+`/docs"}`)); err == nil || !strings.Contains(err.Error(), "domain policy denies") {
+		t.Errorf("fetch from a denied domain: %v", err)
+	}
+	allowed := &FetchTool{AllowDomains: []string{"go.dev"}}
+	if _, err := allowed.Tool().Run(context.Background(), json.RawMessage(`{"url": "`+ts.URL+`/docs"}`)); err == nil || !strings.Contains(err.Error(), "allows only go.dev") {
+		t.Errorf("fetch from a domain not allowed: %v", err)
+	}
+	if !matchesDomain("pkg.go.dev", []string{"go.dev"}) || matchesDomain("notgo.dev", []string{"go.dev"}) {
+		t.Error("matchesDomain does not match subdomains only")
+	}
+}
@@ -0,0 +1,196 @@
+package forge
+
+import (
+	"context"
+	"fmt"
+)
+
+// bitbucket is a repository on Bitbucket Cloud, accessed with a repository, project, or workspace access token.
+type bitbucket struct {
+	api
+	loc Location
+}
+
+func newBitbucket(apiURL string, loc Location, token string) *bitbucket {
+	return &bitbucket{api: api{name: "Bitbucket", base: apiURL, token: token}, loc: loc}
+}
+
+func (b *bitbucket) Name() string { return "Bitbucket" }
+func (b *bitbucket) Repo() string { return b.loc.Path }
+
+// do calls the API for a path within the repository.
+func (b *bitbucket) do(ctx context.Context, method, path string, body, out any) error {
+	return b.api.do(ctx, method, "/repositories/"+escapePath(b.loc.Path)+path, body, out)
+}
+
+type bitbucketUser struct {
+	DisplayName string `json:"display_name"`
+	Nickname    string `json:"nickname"`
+}
+
+func (u bitbucketUser) name() string {
+	if u.Nickname != "" {
+		return u.Nickname
+	}
+	return u.DisplayName
+}
+
+type bitbucketContent struct {
+	Raw string `json:"raw"`
+}
+
+type bitbucketLinks struct {
+	HTML struct {
+		Href string `json:"href"`
+	} `json:"html"`
+}
+
+func (b *bitbucket) Issue(ctx context.Context, number int) (*Issue, error) {
+	var r struct {
+		ID       int              `json:"id"`
+		Title    string           `json:"title"`
+		State    string           `json:"state"`
+		Kind     string           `json:"kind"`
+		Content  bitbucketContent `json:"content"`
+		Reporter bitbucketUser    `json:"reporter"`
+		Links    bitbucketLinks   `json:"links"`
+	}
+	if err := b.do(ctx, "GET", fmt.Sprintf("/issues/%d", number), nil, &r); err != nil {
+		return nil, err
+	}
+	issue := &Issue{
+		Number: r.ID,
+		Title:  r.Title,
+		State:  r.State,
+		Body:   r.Content.Raw,
+		Author: r.Reporter.name(),
+		URL:    r.Links.HTML.Href,
+	}
+	if r.Kind != "" {
+		// Bitbucket has no labels, but issues have a kind, such as bug or enhancement.
+		issue.Labels = []string{r.Kind}
+	}
+	return issue, nil
+}
+
+type bitbucketComment struct {
+	User      bitbucketUser    `json:"user"`
+	Content   bitbucketContent `json:"content"`
+	CreatedOn string           `json:"created_on"`
+	Links     bitbucketLinks   `json:"links"`
+}
+
+func (c bitbucketComment) comment() Comment {
+	return Comment{Author: c.User.name(), Body: c.Content.Raw, Created: c.CreatedOn, URL: c.Links.HTML.Href}
+}
+
+func (b *bitbucket) Comments(ctx context.Context, number int) ([]Comment, error) {
+	var r struct {
+		Values []bitbucketComment `json:"values"`
+	}
+	if err := b.do(ctx, "GET", fmt.Sprintf("/issues/%d/comments?pagelen=100", number), nil, &r); err != nil {
+		return nil, err
+	}
+	var comments []Comment
+	for _, c := range r.Values {
+		if c.Content.Raw != "" { // comments that only record changes to the issue have no content
+			comments = append(comments, c.comment())
+		}
+	}
+	return comments, nil
+}
+
+func (b *bitbucket) Comment(ctx context.Context, number int, body string) (*Comment, error) {
+	var r bitbucketComment
+	in := map[string]any{"content": bitbucketContent{Raw: body}}
+	if err := b.do(ctx, "POST", fmt.Sprintf("/issues/%d/comments", number), in, &r); err != nil {
+		return nil, err
+	}
+	c := r.comment()
+	return &c, nil
+}
+
+func (b *bitbucket) DefaultBranch(ctx context.Context) (string, error) {
+	var r struct {
+		MainBranch struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+	if err := b.do(ctx, "GET", "", nil, &r); err != nil {
+		return "", err
+	}
+	return r.MainBranch.Name, nil
+}
+
+type bitbucketBranch struct {
+	Name   string `json:"name,omitempty"`
+	Target struct {
+		Hash string `json:"hash"`
+	} `json:"target"`
+}
+
+func (b *bitbucket) CreateBranch(ctx context.Context, branch, base string) (string, error) {
+	var from bitbucketBranch
+	if err := b.do(ctx, "GET", "/refs/branches/"+escapePath(base), nil, &from); err != nil {
+		return "", fmt.Errorf("branch %s: %w", base, err)
+	}
+	in := bitbucketBranch{Name: branch, Target: from.Target}
+	if err := b.do(ctx, "POST", "/refs/branches", in, nil); err != nil {
+		return "", err
+	}
+	return from.Target.Hash, nil
+}
+
+func (b *bitbucket) CreatePullRequest(ctx context.Context, pr NewPullRequest) (*PullRequest, error) {
+	branch := func(name string) map[string]any {
+		return map[string]any{"branch": map[string]string{"name": name}}
+	}
+	in := map[string]any{
+		"title":       pr.Title,
+		"description": pr.Body,
+		"source":      branch(pr.Head),
+		"destination": branch(pr.Base),
+		"draft":       pr.Draft,
+	}
+	var r struct {
+		ID    int            `json:"id"`
+		Links bitbucketLinks `json:"links"`
+	}
+	if err := b.do(ctx, "POST", "/pullrequests", in, &r); err != nil {
+		return nil, err
+	}
+	return &PullRequest{Number: r.ID, URL: r.Links.HTML.Href}, nil
+}
+
+func (b *bitbucket) PullRequestDiff(ctx context.Context, number int) (string, error) {
+	return b.text(ctx, fmt.Sprintf("/repositories/%s/pullrequests/%d/diff", escapePath(b.loc.Path), number), "")
+}
+
+func (b *bitbucket) PostReview(ctx context.Context, number int, summary string, comments []LineComment) (string, error) {
+	path := fmt.Sprintf("/pullrequests/%d/comments", number)
+	for _, c := range comments {
+		// Bitbucket has no suggestions, so show the suggested lines as a block of code.
+		body := c.rangeNote() + c.Body
+		if c.Suggestion != nil {
+			body += "\n\nSuggested change:" + c.suggestionBlock("")
+		}
+		in := map[string]any{
+			"content": bitbucketContent{Raw: body},
+			"inline":  map[string]any{"path": c.File, "to": c.Line},
+		}
+		if err := b.do(ctx, "POST", path, in, nil); err != nil {
+			return "", fmt.Errorf("comment on %s:%d: %w", c.File, c.Line, err)
+		}
+	}
+	if summary != "" {
+		in := map[string]any{"content": bitbucketContent{Raw: summary}}
+		if err := b.do(ctx, "POST", path, in, nil); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%s/pull-requests/%d", b.loc.URL(), number), nil
+}
+
+func (b *bitbucket) PushAuth() (string, string, string) {
+	return b.loc.URL() + ".git", "x-token-auth", b.api.token
+}
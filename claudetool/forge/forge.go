@@ -0,0 +1,365 @@
+// Package forge lets the agent work with a repository on a forge — GitHub, GitLab, or Bitbucket:
+// read issues, comment on them, create and push branches, and open pull requests.
+package forge
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A Forge is a repository on a forge, and the API to work with it.
+type Forge interface {
+	// Name is the forge's name, such as "GitHub".
+	Name() string
+	// Repo is the repository's path on the forge, such as "owner/name".
+	Repo() string
+	// Issue returns issue number.
+	Issue(ctx context.Context, number int) (*Issue, error)
+	// Comments returns the first 100 comments on issue number, oldest first.
+	Comments(ctx context.Context, number int) ([]Comment, error)
+	// Comment comments body on issue number.
+	Comment(ctx context.Context, number int, body string) (*Comment, error)
+	// DefaultBranch returns the name of the repository's default branch.
+	DefaultBranch(ctx context.Context) (string, error)
+	// CreateBranch creates branch, pointing where branch base points.
+	// It returns the commit the new branch points to.
+	CreateBranch(ctx context.Context, branch, base string) (string, error)
+	// CreatePullRequest opens pr; on GitLab, a merge request.
+	CreatePullRequest(ctx context.Context, pr NewPullRequest) (*PullRequest, error)
+	// PullRequestDiff returns the unified diff of pull request number.
+	PullRequestDiff(ctx context.Context, number int) (string, error)
+	// PostReview posts a review of pull request number: a summary, and comments on lines of the
+	// new versions of the files it changes. It returns the URL of the review, or of the pull request.
+	PostReview(ctx context.Context, number int, summary string, comments []LineComment) (string, error)
+	// PushAuth returns the URL to push the repository to, and the user name and password to push with.
+	PushAuth() (gitURL, user, password string)
+}
+
+// An Issue is an issue, or, on GitHub, also a pull request.
+type Issue struct {
+	Number        int
+	Title         string
+	State         string
+	Body          string
+	Author        string
+	URL           string
+	Labels        []string
+	IsPullRequest bool
+}
+
+// A Comment is a comment on an issue.
+type Comment struct {
+	Author  string
+	Body    string
+	Created string
+	URL     string
+}
+
+// NewPullRequest is what CreatePullRequest opens.
+type NewPullRequest struct {
+	Title string
+	Head  string // the branch to merge
+	Base  string // the branch to merge into
+	Body  string
+	Draft bool
+}
+
+// A PullRequest is an opened pull request.
+type PullRequest struct {
+	Number int
+	URL    string
+}
+
+// A LineComment is a review comment on lines StartLine through Line of the new version of File.
+type LineComment struct {
+	File      string
+	StartLine int // the same as Line for a comment on one line
+	Line      int
+	Body      string
+	// Suggestion, if set, is the text to replace the lines with, which forges that support it
+	// show as a change the author can apply.
+	Suggestion *string
+}
+
+// suggestionBlock returns c's suggestion in a fenced block with the given info string,
+// such as "suggestion", or "" if it has none.
+func (c LineComment) suggestionBlock(info string) string {
+	if c.Suggestion == nil {
+		return ""
+	}
+	s := strings.TrimSuffix(*c.Suggestion, "\n")
+	if s != "" {
+		s += "\n"
+	}
+	fence := "```"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	return "\n\n" + fence + info + "\n" + s + fence
+}
+
+// rangeNote returns a note of the lines c is on, for forges that can only attach comments to one line,
+// or "" if c is on one line.
+func (c LineComment) rangeNote() string {
+	if c.StartLine >= c.Line {
+		return ""
+	}
+	return fmt.Sprintf("(lines %d–%d) ", c.StartLine, c.Line)
+}
+
+// Kinds of forges.
+const (
+	GitHub    = "github"
+	GitLab    = "gitlab"
+	Bitbucket = "bitbucket"
+)
+
+// Kinds are the kinds of forges, in the order they are documented.
+var Kinds = []string{GitHub, GitLab, Bitbucket}
+
+// defaultHosts are the hosts of the public instances of the kinds of forges.
+var defaultHosts = map[string]string{
+	GitHub:    "github.com",
+	GitLab:    "gitlab.com",
+	Bitbucket: "bitbucket.org",
+}
+
+// TokenEnv returns the environment variable that holds the API token for forges of kind.
+func TokenEnv(kind string) string {
+	return strings.ToUpper(kind) + "_TOKEN"
+}
+
+// A Location is where a repository is.
+type Location struct {
+	Kind string // GitHub, GitLab, or Bitbucket
+	Host string // such as "github.com" or "gitlab.example.com"
+	Path string // such as "owner/name"; on GitLab, "group/subgroup/name"
+}
+
+// URL returns the repository's web URL, which ParseRepo parses back to l.
+func (l Location) URL() string {
+	return "https://" + l.Host + "/" + l.Path
+}
+
+var remotePattern = regexp.MustCompile(`^(?:https?://(?:[^@/]+@)?|ssh://(?:[^@/]+@)?|[\w.-]+@)([\w.-]+)(?::\d+)?[:/](.+?)(?:\.git)?/?$`)
+
+// ParseRemote returns the location of the repository at git remote URL remote.
+// The kind of forge is known from the host for the public instances of each,
+// and for self-hosted ones whose host name contains the kind, such as gitlab.example.com;
+// otherwise it is "".
+func ParseRemote(remote string) (Location, bool) {
+	m := remotePattern.FindStringSubmatch(strings.TrimSpace(remote))
+	if m == nil || !strings.Contains(m[2], "/") {
+		return Location{}, false
+	}
+	loc := Location{Host: strings.ToLower(m[1]), Path: strings.Trim(m[2], "/")}
+	for _, kind := range Kinds {
+		if loc.Host == defaultHosts[kind] || strings.Contains(loc.Host, kind) {
+			loc.Kind = kind
+			break
+		}
+	}
+	return loc, true
+}
+
+// ParseRepo returns the location of repo, which is a git remote URL, a web URL,
+// or the path of a repository on the public instance of a forge, such as "owner/name".
+// If kind is set, it is the kind of forge, whatever repo's host suggests; otherwise,
+// a path is on GitHub.
+func ParseRepo(kind, repo string) (Location, error) {
+	if kind != "" && defaultHosts[kind] == "" {
+		return Location{}, fmt.Errorf("unknown forge %q; want one of %s", kind, strings.Join(Kinds, ", "))
+	}
+	loc, ok := ParseRemote(repo)
+	if !ok || !strings.ContainsAny(repo, ":@") {
+		// A path, such as owner/name.
+		path := strings.Trim(repo, "/")
+		if strings.Count(path, "/") < 1 || strings.ContainsAny(path, " :") {
+			return Location{}, fmt.Errorf("bad repository %q; want a URL or owner/name", repo)
+		}
+		loc = Location{Kind: cmp.Or(kind, GitHub), Path: path}
+		loc.Host = defaultHosts[loc.Kind]
+		return loc, nil
+	}
+	if kind != "" {
+		loc.Kind = kind
+	}
+	if loc.Kind == "" {
+		return Location{}, fmt.Errorf("can't tell what kind of forge %s is; say with -forge", loc.Host)
+	}
+	return loc, nil
+}
+
+// New returns the Forge for the repository at loc, which calls the forge's API with token.
+func New(loc Location, token string) (Forge, error) {
+	switch loc.Kind {
+	case GitHub:
+		apiURL := "https://" + loc.Host + "/api/v3" // GitHub Enterprise Server
+		if loc.Host == defaultHosts[GitHub] {
+			apiURL = "https://api.github.com"
+		}
+		return newGitHub(apiURL, loc, token), nil
+	case GitLab:
+		return newGitLab("https://"+loc.Host+"/api/v4", loc, token), nil
+	case Bitbucket:
+		if loc.Host != defaultHosts[Bitbucket] {
+			return nil, fmt.Errorf("only Bitbucket Cloud (bitbucket.org) is supported, not %s", loc.Host)
+		}
+		if strings.Count(loc.Path, "/") != 1 {
+			return nil, fmt.Errorf("bad Bitbucket repository %q; want workspace/name", loc.Path)
+		}
+		return newBitbucket("https://api.bitbucket.org/2.0", loc, token), nil
+	}
+	return nil, fmt.Errorf("unknown forge %q", loc.Kind)
+}
+
+// An APIError is an error response from a forge's API.
+type APIError struct {
+	Forge   string
+	Status  string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s API: %s", e.Forge, e.Status)
+	}
+	return fmt.Sprintf("%s API: %s: %s", e.Forge, e.Status, e.Message)
+}
+
+// api calls a forge's JSON API with a bearer token.
+type api struct {
+	name   string // the forge's name, for errors
+	base   string // URL the paths of requests are relative to
+	token  string
+	header map[string]string // extra request headers
+	client *http.Client      // http.DefaultClient if nil
+}
+
+// send calls the API: method path, with body, if non-nil, as the JSON request body,
+// and accept, if set, as the Accept header. It returns the response if it was successful.
+func (a *api) send(ctx context.Context, method, path string, body any, accept string) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(a.base, "/")+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	for k, v := range a.header {
+		req.Header.Set(k, v)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := cmp.Or(a.client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, &APIError{Forge: a.name, Status: resp.Status, Message: errorMessage(data)}
+	}
+	return resp, nil
+}
+
+// do calls the API: method path, with body, if non-nil, as the JSON request body,
+// decoding the JSON response into out, if non-nil.
+func (a *api) do(ctx context.Context, method, path string, body, out any) error {
+	resp, err := a.send(ctx, method, path, body, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s API: bad response to %s %s: %w", a.name, method, path, err)
+	}
+	return nil
+}
+
+// maxDiff is the most of a diff that text reads.
+const maxDiff = 16 << 20
+
+// text GETs path, accepting accept, and returns the response body, such as a diff.
+func (a *api) text(ctx context.Context, path, accept string) (string, error) {
+	resp, err := a.send(ctx, "GET", path, nil, accept)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDiff))
+	if err != nil {
+		return "", fmt.Errorf("%s API: reading %s: %w", a.name, path, err)
+	}
+	return string(data), nil
+}
+
+// errorMessage returns the message in the JSON body of an error response, in any of the forms
+// that GitHub ({"message": "..."}), GitLab ({"message": ...} or {"error": "..."}),
+// and Bitbucket ({"error": {"message": "..."}}) use.
+func errorMessage(data []byte) string {
+	var e struct {
+		Message json.RawMessage `json:"message"`
+		Error   json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &e) != nil {
+		return ""
+	}
+	for _, raw := range []json.RawMessage{e.Message, e.Error} {
+		var s string
+		if json.Unmarshal(raw, &s) == nil && s != "" {
+			return s
+		}
+		var nested struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(raw, &nested) == nil && nested.Message != "" {
+			return nested.Message
+		}
+		if len(raw) > 0 && raw[0] != '"' {
+			return string(raw) // such as GitLab's validation errors
+		}
+	}
+	return ""
+}
+
+// escapePath escapes each element of a slash-separated path for use in a URL path.
+func escapePath(path string) string {
+	elems := strings.Split(path, "/")
+	for i, e := range elems {
+		elems[i] = url.PathEscape(e)
+	}
+	return strings.Join(elems, "/")
+}
+
+// FromEnvironment returns the Forge for the repository at loc, with the token
+// in the environment variable named by TokenEnv, or nil if that is not set.
+func FromEnvironment(loc Location) (Forge, error) {
+	token := os.Getenv(TokenEnv(loc.Kind))
+	if token == "" {
+		return nil, nil
+	}
+	return New(loc, token)
+}
@@ -0,0 +1,287 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeForge serves handlers for the paths of a forge's API, recording the bodies of write requests by path.
+func fakeForge(t *testing.T, handlers map[string]string) (string, map[string]map[string]any) {
+	writes := make(map[string]map[string]any)
+	mux := http.NewServeMux()
+	for pattern, response := range handlers {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer tok" {
+				http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+				return
+			}
+			if r.Method == "POST" {
+				var body map[string]any
+				json.NewDecoder(r.Body).Decode(&body)
+				writes[r.URL.Path] = body
+			}
+			w.Write([]byte(response))
+		})
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL, writes
+}
+
+func fakeGitHub(t *testing.T) (*gitHub, map[string]map[string]any) {
+	url, writes := fakeForge(t, map[string]string{
+		"GET /repos/o/r/issues/7":           `{"number":7,"title":"Flaky test","state":"open","body":"It fails sometimes.","user":{"login":"ann"},"html_url":"https://github.com/o/r/issues/7","labels":[{"name":"bug"}]}`,
+		"GET /repos/o/r/issues/7/comments":  `[{"user":{"login":"bob"},"body":"Me too.","created_at":"2025-01-02T03:04:05Z"}]`,
+		"GET /repos/o/r":                    `{"default_branch":"main"}`,
+		"POST /repos/o/r/issues/7/comments": `{"html_url":"https://github.com/o/r/issues/7#c"}`,
+		"POST /repos/o/r/pulls":             `{"number":8,"html_url":"https://github.com/o/r/pull/8"}`,
+	})
+	return newGitHub(url, Location{Kind: GitHub, Host: "github.com", Path: "o/r"}, "tok"), writes
+}
+
+func runTool(t *testing.T, tool *Tool, input string) (string, error) {
+	t.Helper()
+	out, err := tool.Tool().Run(context.Background(), json.RawMessage(input))
+	if err != nil {
+		return "", err
+	}
+	return out[0].Text, nil
+}
+
+func allow(context.Context, string) error { return nil }
+
+func TestForges(t *testing.T) {
+	gh, ghWrites := fakeGitHub(t)
+
+	glURL, glWrites := fakeForge(t, map[string]string{
+		"GET /projects/{id}/issues/7":        `{"iid":7,"title":"Flaky test","state":"opened","description":"It fails sometimes.","author":{"username":"ann"},"web_url":"https://gitlab.com/g/sub/r/-/issues/7","labels":["bug"]}`,
+		"GET /projects/{id}/issues/7/notes":  `[{"id":1,"author":{"username":"gitlab"},"body":"changed the description","system":true},{"id":2,"author":{"username":"bob"},"body":"Me too.","created_at":"2025-01-02T03:04:05Z"}]`,
+		"GET /projects/{id}":                 `{"default_branch":"main"}`,
+		"POST /projects/{id}/merge_requests": `{"iid":8,"web_url":"https://gitlab.com/g/sub/r/-/merge_requests/8"}`,
+	})
+	gl := newGitLab(glURL, Location{Kind: GitLab, Host: "gitlab.com", Path: "g/sub/r"}, "tok")
+
+	bbURL, bbWrites := fakeForge(t, map[string]string{
+		"GET /repositories/w/r/issues/7":          `{"id":7,"title":"Flaky test","state":"new","kind":"bug","content":{"raw":"It fails sometimes."},"reporter":{"nickname":"ann"},"links":{"html":{"href":"https://bitbucket.org/w/r/issues/7"}}}`,
+		"GET /repositories/w/r/issues/7/comments": `{"values":[{"user":{"nickname":"gitlab"},"content":{"raw":""}},{"user":{"display_name":"Bob"},"content":{"raw":"Me too."},"created_on":"2025-01-02T03:04:05Z"}]}`,
+		"GET /repositories/w/r":                   `{"mainbranch":{"name":"main"}}`,
+		"POST /repositories/w/r/pullrequests":     `{"id":8,"links":{"html":{"href":"https://bitbucket.org/w/r/pull-requests/8"}}}`,
+	})
+	bb := newBitbucket(bbURL, Location{Kind: Bitbucket, Host: "bitbucket.org", Path: "w/r"}, "tok")
+
+	for _, tt := range []struct {
+		forge    Forge
+		writes   map[string]map[string]any
+		issue    []string
+		prPath   string
+		pr       string
+		opened   string
+		wantBody map[string]any
+	}{
+		{
+			forge: gh, writes: ghWrites,
+			issue:  []string{"#7 Flaky test (open issue by ann)", "Labels: bug", "--- bob commented at 2025-01-02T03:04:05Z:\nMe too."},
+			prPath: "/repos/o/r/pulls",
+			opened: "Opened pull request #8: https://github.com/o/r/pull/8",
+			wantBody: map[string]any{
+				"title": "Fix the flake", "head": "fix", "base": "main", "body": "Fixes #7.", "draft": true,
+			},
+		},
+		{
+			forge: gl, writes: glWrites,
+			issue:  []string{"#7 Flaky test (opened issue by ann)", "Labels: bug", "--- bob commented at 2025-01-02T03:04:05Z:\nMe too."},
+			prPath: "/projects/g/sub/r/merge_requests",
+			opened: "Opened pull request #8: https://gitlab.com/g/sub/r/-/merge_requests/8",
+			wantBody: map[string]any{
+				"title": "Draft: Fix the flake", "source_branch": "fix", "target_branch": "main", "description": "Fixes #7.",
+			},
+		},
+		{
+			forge: bb, writes: bbWrites,
+			issue:  []string{"#7 Flaky test (new issue by ann)", "Labels: bug", "--- Bob commented at 2025-01-02T03:04:05Z:\nMe too."},
+			prPath: "/repositories/w/r/pullrequests",
+			opened: "Opened pull request #8: https://bitbucket.org/w/r/pull-requests/8",
+			wantBody: map[string]any{
+				"title": "Fix the flake", "description": "Fixes #7.", "draft": true,
+				"source":      map[string]any{"branch": map[string]any{"name": "fix"}},
+				"destination": map[string]any{"branch": map[string]any{"name": "main"}},
+			},
+		},
+	} {
+		t.Run(tt.forge.Name(), func(t *testing.T) {
+			tool := &Tool{Forge: tt.forge, Approve: allow}
+			out, err := runTool(t, tool, `{"action":"issue","number":7}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range append(tt.issue, "It fails sometimes.") {
+				if !strings.Contains(out, want) {
+					t.Errorf("output lacks %q:\n%s", want, out)
+				}
+			}
+			if strings.Contains(out, "changed the description") || strings.Count(out, "commented at") != 1 {
+				t.Errorf("output has comments that record changes:\n%s", out)
+			}
+
+			out, err = runTool(t, tool, `{"action":"create_pr","branch":"fix","title":"Fix the flake","body":"Fixes #7.","draft":true}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.opened {
+				t.Errorf("output %q, want %q", out, tt.opened)
+			}
+			got, _ := json.Marshal(tt.writes[tt.prPath])
+			want, _ := json.Marshal(tt.wantBody)
+			if string(got) != string(want) {
+				t.Errorf("opened %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	gh, _ := fakeGitHub(t)
+	gh.api.token = "wrong"
+	_, err := runTool(t, &Tool{Forge: gh}, `{"action":"issue","number":7}`)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "Bad credentials" || apiErr.Forge != "GitHub" {
+		t.Errorf("err = %v, want GitHub's Bad credentials APIError", err)
+	}
+
+	for body, want := range map[string]string{
+		`{"message":"Not Found"}`:                          "Not Found",
+		`{"error":"insufficient_scope"}`:                   "insufficient_scope",
+		`{"type":"error","error":{"message":"No access"}}`: "No access",
+		`{"message":{"title":["can't be blank"]}}`:         `{"title":["can't be blank"]}`,
+		`not json`: "",
+	} {
+		if got := errorMessage([]byte(body)); got != want {
+			t.Errorf("errorMessage(%s) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestWritesNeedApproval(t *testing.T) {
+	gh, writes := fakeGitHub(t)
+	var asked []string
+	tool := &Tool{Forge: gh, Approve: func(ctx context.Context, description string) error {
+		asked = append(asked, description)
+		return errors.New("denied")
+	}}
+
+	if _, err := runTool(t, tool, `{"action":"comment","number":7,"body":"Fixed in #8."}`); err == nil || !strings.Contains(err.Error(), "not approved") {
+		t.Errorf("denied comment: err = %v", err)
+	}
+	if len(writes) != 0 || len(asked) != 1 || !strings.Contains(asked[0], "Fixed in #8.") {
+		t.Fatalf("denied comment wrote %v after asking %q", writes, asked)
+	}
+
+	tool.Approve = allow
+	out, err := runTool(t, tool, `{"action":"comment","number":7,"body":"Fixed in #8."}`)
+	if err != nil || out != "Commented: https://github.com/o/r/issues/7#c" {
+		t.Errorf("approved comment: %q, %v", out, err)
+	}
+
+	// Without an Approve function, nothing may be written.
+	tool.Approve = nil
+	if _, err := runTool(t, tool, `{"action":"comment","number":7,"body":"x"}`); err == nil {
+		t.Error("comment without Approve succeeded")
+	}
+}
+
+func TestParseRepo(t *testing.T) {
+	for _, tt := range []struct {
+		kind, repo string
+		want       Location
+	}{
+		{"", "https://github.com/boldsoftware/sketch.git\n", Location{GitHub, "github.com", "boldsoftware/sketch"}},
+		{"", "git@github.com:boldsoftware/sketch.git", Location{GitHub, "github.com", "boldsoftware/sketch"}},
+		{"", "ssh://git@github.com/boldsoftware/sketch", Location{GitHub, "github.com", "boldsoftware/sketch"}},
+		{"", "boldsoftware/sketch", Location{GitHub, "github.com", "boldsoftware/sketch"}},
+		{"", "https://github.com/a/b.c", Location{GitHub, "github.com", "a/b.c"}},
+		{"", "git@gitlab.com:group/sub/project.git", Location{GitLab, "gitlab.com", "group/sub/project"}},
+		{"", "https://gitlab.example.com/group/project", Location{GitLab, "gitlab.example.com", "group/project"}},
+		{"gitlab", "group/sub/project", Location{GitLab, "gitlab.com", "group/sub/project"}},
+		{"gitlab", "https://git.example.com/group/project.git", Location{GitLab, "git.example.com", "group/project"}},
+		{"", "https://user@bitbucket.org/workspace/repo.git", Location{Bitbucket, "bitbucket.org", "workspace/repo"}},
+		{"", "ssh://git@bitbucket.org:22/workspace/repo.git", Location{Bitbucket, "bitbucket.org", "workspace/repo"}},
+	} {
+		got, err := ParseRepo(tt.kind, tt.repo)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRepo(%q, %q) = %+v, %v, want %+v", tt.kind, tt.repo, got, err, tt.want)
+		}
+		if again, err := ParseRepo(got.Kind, got.URL()); err != nil || again != got {
+			t.Errorf("ParseRepo(%q, %q) = %+v, %v, want %+v", got.Kind, got.URL(), again, err, got)
+		}
+	}
+	for _, tt := range []struct{ kind, repo string }{
+		{"", "https://git.example.com/a/b.git"}, // unknown forge
+		{"", "sketch"},
+		{"sourcehut", "a/b"},
+	} {
+		if got, err := ParseRepo(tt.kind, tt.repo); err == nil {
+			t.Errorf("ParseRepo(%q, %q) = %+v, want error", tt.kind, tt.repo, got)
+		}
+	}
+}
+
+func TestReviews(t *testing.T) {
+	suggestion := "\treturn err\n"
+	comments := []LineComment{
+		{File: "main.go", StartLine: 3, Line: 5, Body: "Handle the error.", Suggestion: &suggestion},
+		{File: "main.go", StartLine: 9, Line: 9, Body: "Typo."},
+	}
+
+	ghURL, ghWrites := fakeForge(t, map[string]string{
+		"GET /repos/o/r/pulls/8":          "diff --git a/main.go b/main.go\n",
+		"POST /repos/o/r/pulls/8/reviews": `{"html_url":"https://github.com/o/r/pull/8#pullrequestreview-1"}`,
+	})
+	gh := newGitHub(ghURL, Location{Kind: GitHub, Host: "github.com", Path: "o/r"}, "tok")
+	if diff, err := gh.PullRequestDiff(context.Background(), 8); err != nil || diff != "diff --git a/main.go b/main.go\n" {
+		t.Errorf("GitHub diff = %q, %v", diff, err)
+	}
+	url, err := gh.PostReview(context.Background(), 8, "Mostly fine.", comments)
+	if err != nil || url != "https://github.com/o/r/pull/8#pullrequestreview-1" {
+		t.Fatalf("GitHub review = %q, %v", url, err)
+	}
+	got, _ := json.Marshal(ghWrites["/repos/o/r/pulls/8/reviews"])
+	want := `{"body":"Mostly fine.","comments":[` +
+		`{"body":"Handle the error.\n\n` + "```suggestion\\n\\treturn err\\n```" + `","line":5,"path":"main.go","side":"RIGHT","start_line":3,"start_side":"RIGHT"},` +
+		`{"body":"Typo.","line":9,"path":"main.go","side":"RIGHT"}],"event":"COMMENT"}`
+	if string(got) != want {
+		t.Errorf("GitHub review\n got %s\nwant %s", got, want)
+	}
+
+	glURL, glWrites := fakeForge(t, map[string]string{
+		"GET /projects/{id}/merge_requests/8/diffs":        `[{"old_path":"main.go","new_path":"main.go","diff":"@@ -1 +1 @@\n-a\n+b\n"},{"old_path":"new.go","new_path":"new.go","new_file":true,"diff":"@@ -0,0 +1 @@\n+c"}]`,
+		"GET /projects/{id}/merge_requests/8":              `{"web_url":"https://gitlab.com/g/r/-/merge_requests/8","diff_refs":{"base_sha":"b","head_sha":"h","start_sha":"s"}}`,
+		"POST /projects/{id}/merge_requests/8/discussions": `{}`,
+		"POST /projects/{id}/merge_requests/8/notes":       `{}`,
+	})
+	gl := newGitLab(glURL, Location{Kind: GitLab, Host: "gitlab.com", Path: "g/r"}, "tok")
+	diff, err := gl.PullRequestDiff(context.Background(), 8)
+	wantDiff := "diff --git a/main.go b/main.go\n--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-a\n+b\n" +
+		"diff --git a/new.go b/new.go\n--- /dev/null\n+++ b/new.go\n@@ -0,0 +1 @@\n+c\n"
+	if err != nil || diff != wantDiff {
+		t.Errorf("GitLab diff = %q, %v\nwant %q", diff, err, wantDiff)
+	}
+	url, err = gl.PostReview(context.Background(), 8, "Mostly fine.", comments[:1])
+	if err != nil || url != "https://gitlab.com/g/r/-/merge_requests/8" {
+		t.Fatalf("GitLab review = %q, %v", url, err)
+	}
+	discussion := glWrites["/projects/g/r/merge_requests/8/discussions"]
+	if body := discussion["body"]; body != "(lines 3–5) Handle the error.\n\n```suggestion:-2+0\n\treturn err\n```" {
+		t.Errorf("GitLab discussion body %q", body)
+	}
+	if pos, _ := discussion["position"].(map[string]any); pos["new_line"] != 5.0 || pos["head_sha"] != "h" {
+		t.Errorf("GitLab discussion position %v", pos)
+	}
+	if note := glWrites["/projects/g/r/merge_requests/8/notes"]; note["body"] != "Mostly fine." {
+		t.Errorf("GitLab summary %v", note)
+	}
+}
@@ -0,0 +1,180 @@
+package forge
+
+import (
+	"context"
+	"fmt"
+)
+
+// gitHub is a repository on GitHub or GitHub Enterprise Server.
+type gitHub struct {
+	api
+	loc Location
+}
+
+func newGitHub(apiURL string, loc Location, token string) *gitHub {
+	return &gitHub{
+		api: api{name: "GitHub", base: apiURL, token: token, header: map[string]string{
+			"Accept":               "application/vnd.github+json",
+			"X-GitHub-Api-Version": "2022-11-28",
+		}},
+		loc: loc,
+	}
+}
+
+func (g *gitHub) Name() string { return "GitHub" }
+func (g *gitHub) Repo() string { return g.loc.Path }
+
+// do calls the API for a path within the repository.
+func (g *gitHub) do(ctx context.Context, method, path string, body, out any) error {
+	return g.api.do(ctx, method, "/repos/"+g.loc.Path+path, body, out)
+}
+
+type gitHubUser struct {
+	Login string `json:"login"`
+}
+
+func (g *gitHub) Issue(ctx context.Context, number int) (*Issue, error) {
+	var r struct {
+		Number  int        `json:"number"`
+		Title   string     `json:"title"`
+		State   string     `json:"state"`
+		Body    string     `json:"body"`
+		User    gitHubUser `json:"user"`
+		HTMLURL string     `json:"html_url"`
+		Labels  []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+		PullRequest *struct{} `json:"pull_request"` // set for pull requests
+	}
+	if err := g.do(ctx, "GET", fmt.Sprintf("/issues/%d", number), nil, &r); err != nil {
+		return nil, err
+	}
+	issue := &Issue{
+		Number:        r.Number,
+		Title:         r.Title,
+		State:         r.State,
+		Body:          r.Body,
+		Author:        r.User.Login,
+		URL:           r.HTMLURL,
+		IsPullRequest: r.PullRequest != nil,
+	}
+	for _, l := range r.Labels {
+		issue.Labels = append(issue.Labels, l.Name)
+	}
+	return issue, nil
+}
+
+type gitHubComment struct {
+	User      gitHubUser `json:"user"`
+	Body      string     `json:"body"`
+	CreatedAt string     `json:"created_at"`
+	HTMLURL   string     `json:"html_url"`
+}
+
+func (c gitHubComment) comment() Comment {
+	return Comment{Author: c.User.Login, Body: c.Body, Created: c.CreatedAt, URL: c.HTMLURL}
+}
+
+func (g *gitHub) Comments(ctx context.Context, number int) ([]Comment, error) {
+	var r []gitHubComment
+	if err := g.do(ctx, "GET", fmt.Sprintf("/issues/%d/comments?per_page=100", number), nil, &r); err != nil {
+		return nil, err
+	}
+	comments := make([]Comment, len(r))
+	for i, c := range r {
+		comments[i] = c.comment()
+	}
+	return comments, nil
+}
+
+func (g *gitHub) Comment(ctx context.Context, number int, body string) (*Comment, error) {
+	var r gitHubComment
+	in := map[string]string{"body": body}
+	if err := g.do(ctx, "POST", fmt.Sprintf("/issues/%d/comments", number), in, &r); err != nil {
+		return nil, err
+	}
+	c := r.comment()
+	return &c, nil
+}
+
+func (g *gitHub) DefaultBranch(ctx context.Context) (string, error) {
+	var r struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := g.do(ctx, "GET", "", nil, &r); err != nil {
+		return "", err
+	}
+	return r.DefaultBranch, nil
+}
+
+func (g *gitHub) CreateBranch(ctx context.Context, branch, base string) (string, error) {
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	if err := g.do(ctx, "GET", "/git/ref/heads/"+escapePath(base), nil, &ref); err != nil {
+		return "", fmt.Errorf("branch %s: %w", base, err)
+	}
+	in := map[string]string{"ref": "refs/heads/" + branch, "sha": ref.Object.SHA}
+	if err := g.do(ctx, "POST", "/git/refs", in, nil); err != nil {
+		return "", err
+	}
+	return ref.Object.SHA, nil
+}
+
+func (g *gitHub) CreatePullRequest(ctx context.Context, pr NewPullRequest) (*PullRequest, error) {
+	in := struct {
+		Title string `json:"title"`
+		Head  string `json:"head"`
+		Base  string `json:"base"`
+		Body  string `json:"body,omitempty"`
+		Draft bool   `json:"draft,omitempty"`
+	}{pr.Title, pr.Head, pr.Base, pr.Body, pr.Draft}
+	var r struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := g.do(ctx, "POST", "/pulls", in, &r); err != nil {
+		return nil, err
+	}
+	return &PullRequest{Number: r.Number, URL: r.HTMLURL}, nil
+}
+
+func (g *gitHub) PullRequestDiff(ctx context.Context, number int) (string, error) {
+	return g.text(ctx, fmt.Sprintf("/repos/%s/pulls/%d", g.loc.Path, number), "application/vnd.github.diff")
+}
+
+func (g *gitHub) PostReview(ctx context.Context, number int, summary string, comments []LineComment) (string, error) {
+	type reviewComment struct {
+		Path      string `json:"path"`
+		Line      int    `json:"line"`
+		Side      string `json:"side"`
+		StartLine int    `json:"start_line,omitempty"`
+		StartSide string `json:"start_side,omitempty"`
+		Body      string `json:"body"`
+	}
+	in := struct {
+		Body     string          `json:"body,omitempty"`
+		Event    string          `json:"event"`
+		Comments []reviewComment `json:"comments"`
+	}{Body: summary, Event: "COMMENT", Comments: []reviewComment{}}
+	for _, c := range comments {
+		rc := reviewComment{Path: c.File, Line: c.Line, Side: "RIGHT", Body: c.Body + c.suggestionBlock("suggestion")}
+		if c.StartLine < c.Line {
+			rc.StartLine, rc.StartSide = c.StartLine, "RIGHT"
+		}
+		in.Comments = append(in.Comments, rc)
+	}
+	var r struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := g.do(ctx, "POST", fmt.Sprintf("/pulls/%d/reviews", number), in, &r); err != nil {
+		return "", err
+	}
+	return r.HTMLURL, nil
+}
+
+func (g *gitHub) PushAuth() (string, string, string) {
+	return g.loc.URL() + ".git", "x-access-token", g.api.token
+}
@@ -0,0 +1,212 @@
+package forge
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// gitLab is a project on GitLab.com or a self-managed GitLab.
+type gitLab struct {
+	api
+	loc Location
+}
+
+func newGitLab(apiURL string, loc Location, token string) *gitLab {
+	return &gitLab{api: api{name: "GitLab", base: apiURL, token: token}, loc: loc}
+}
+
+func (g *gitLab) Name() string { return "GitLab" }
+func (g *gitLab) Repo() string { return g.loc.Path }
+
+// do calls the API for a path within the project.
+func (g *gitLab) do(ctx context.Context, method, path string, body, out any) error {
+	return g.api.do(ctx, method, "/projects/"+url.PathEscape(g.loc.Path)+path, body, out)
+}
+
+type gitLabUser struct {
+	Username string `json:"username"`
+}
+
+func (g *gitLab) Issue(ctx context.Context, number int) (*Issue, error) {
+	var r struct {
+		IID         int        `json:"iid"`
+		Title       string     `json:"title"`
+		State       string     `json:"state"`
+		Description string     `json:"description"`
+		Author      gitLabUser `json:"author"`
+		WebURL      string     `json:"web_url"`
+		Labels      []string   `json:"labels"`
+	}
+	if err := g.do(ctx, "GET", fmt.Sprintf("/issues/%d", number), nil, &r); err != nil {
+		return nil, err
+	}
+	return &Issue{
+		Number: r.IID,
+		Title:  r.Title,
+		State:  r.State,
+		Body:   r.Description,
+		Author: r.Author.Username,
+		URL:    r.WebURL,
+		Labels: r.Labels,
+	}, nil
+}
+
+type gitLabNote struct {
+	ID        int        `json:"id"`
+	Author    gitLabUser `json:"author"`
+	Body      string     `json:"body"`
+	CreatedAt string     `json:"created_at"`
+	System    bool       `json:"system"` // notes GitLab adds itself, such as "changed the description"
+}
+
+func (g *gitLab) comment(number int, n gitLabNote) Comment {
+	return Comment{
+		Author:  n.Author.Username,
+		Body:    n.Body,
+		Created: n.CreatedAt,
+		URL:     fmt.Sprintf("%s/-/issues/%d#note_%d", g.loc.URL(), number, n.ID),
+	}
+}
+
+func (g *gitLab) Comments(ctx context.Context, number int) ([]Comment, error) {
+	var notes []gitLabNote
+	if err := g.do(ctx, "GET", fmt.Sprintf("/issues/%d/notes?sort=asc&per_page=100", number), nil, &notes); err != nil {
+		return nil, err
+	}
+	var comments []Comment
+	for _, n := range notes {
+		if !n.System {
+			comments = append(comments, g.comment(number, n))
+		}
+	}
+	return comments, nil
+}
+
+func (g *gitLab) Comment(ctx context.Context, number int, body string) (*Comment, error) {
+	var n gitLabNote
+	in := map[string]string{"body": body}
+	if err := g.do(ctx, "POST", fmt.Sprintf("/issues/%d/notes", number), in, &n); err != nil {
+		return nil, err
+	}
+	c := g.comment(number, n)
+	return &c, nil
+}
+
+func (g *gitLab) DefaultBranch(ctx context.Context) (string, error) {
+	var r struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := g.do(ctx, "GET", "", nil, &r); err != nil {
+		return "", err
+	}
+	return r.DefaultBranch, nil
+}
+
+func (g *gitLab) CreateBranch(ctx context.Context, branch, base string) (string, error) {
+	var r struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+	q := url.Values{"branch": {branch}, "ref": {base}}
+	if err := g.do(ctx, "POST", "/repository/branches?"+q.Encode(), nil, &r); err != nil {
+		return "", err
+	}
+	return r.Commit.ID, nil
+}
+
+func (g *gitLab) CreatePullRequest(ctx context.Context, pr NewPullRequest) (*PullRequest, error) {
+	title := pr.Title
+	if pr.Draft && !strings.HasPrefix(title, "Draft:") {
+		title = "Draft: " + title
+	}
+	in := map[string]string{
+		"title":         title,
+		"source_branch": pr.Head,
+		"target_branch": pr.Base,
+		"description":   pr.Body,
+	}
+	var r struct {
+		IID    int    `json:"iid"`
+		WebURL string `json:"web_url"`
+	}
+	if err := g.do(ctx, "POST", "/merge_requests", in, &r); err != nil {
+		return nil, err
+	}
+	return &PullRequest{Number: r.IID, URL: r.WebURL}, nil
+}
+
+func (g *gitLab) PullRequestDiff(ctx context.Context, number int) (string, error) {
+	var files []struct {
+		OldPath     string `json:"old_path"`
+		NewPath     string `json:"new_path"`
+		Diff        string `json:"diff"`
+		NewFile     bool   `json:"new_file"`
+		DeletedFile bool   `json:"deleted_file"`
+	}
+	if err := g.do(ctx, "GET", fmt.Sprintf("/merge_requests/%d/diffs?per_page=100", number), nil, &files); err != nil {
+		return "", err
+	}
+	// GitLab returns the hunks of each file; add the headers of a unified diff.
+	var b strings.Builder
+	for _, f := range files {
+		oldName, newName := "a/"+f.OldPath, "b/"+f.NewPath
+		if f.NewFile {
+			oldName = "/dev/null"
+		}
+		if f.DeletedFile {
+			newName = "/dev/null"
+		}
+		fmt.Fprintf(&b, "diff --git a/%s b/%s\n--- %s\n+++ %s\n%s", f.OldPath, f.NewPath, oldName, newName, f.Diff)
+		if !strings.HasSuffix(f.Diff, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), nil
+}
+
+func (g *gitLab) PostReview(ctx context.Context, number int, summary string, comments []LineComment) (string, error) {
+	var mr struct {
+		WebURL   string `json:"web_url"`
+		DiffRefs struct {
+			BaseSHA  string `json:"base_sha"`
+			HeadSHA  string `json:"head_sha"`
+			StartSHA string `json:"start_sha"`
+		} `json:"diff_refs"`
+	}
+	if err := g.do(ctx, "GET", fmt.Sprintf("/merge_requests/%d", number), nil, &mr); err != nil {
+		return "", err
+	}
+	for _, c := range comments {
+		// GitLab's suggestions replace the lines from -N above the commented line to +M below it.
+		info := fmt.Sprintf("suggestion:-%d+0", c.Line-c.StartLine)
+		in := map[string]any{
+			"body": c.rangeNote() + c.Body + c.suggestionBlock(info),
+			"position": map[string]any{
+				"position_type": "text",
+				"base_sha":      mr.DiffRefs.BaseSHA,
+				"head_sha":      mr.DiffRefs.HeadSHA,
+				"start_sha":     mr.DiffRefs.StartSHA,
+				"old_path":      c.File,
+				"new_path":      c.File,
+				"new_line":      c.Line,
+			},
+		}
+		if err := g.do(ctx, "POST", fmt.Sprintf("/merge_requests/%d/discussions", number), in, nil); err != nil {
+			return "", fmt.Errorf("comment on %s:%d: %w", c.File, c.Line, err)
+		}
+	}
+	if summary != "" {
+		in := map[string]string{"body": summary}
+		if err := g.do(ctx, "POST", fmt.Sprintf("/merge_requests/%d/notes", number), in, nil); err != nil {
+			return "", err
+		}
+	}
+	return mr.WebURL, nil
+}
+
+func (g *gitLab) PushAuth() (string, string, string) {
+	return g.loc.URL() + ".git", "oauth2", g.api.token
+}
@@ -0,0 +1,299 @@
+package forge
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// Tool specifies an llm.Tool that works with a repository on a forge.
+// Every action that changes something on the forge must be approved first.
+type Tool struct {
+	Forge Forge
+	// RepoRoot is the local clone of the repository, which push pushes from.
+	RepoRoot string
+	// BaseRef is the commit the session started from.
+	// Pull requests opened without a description get one generated from the commits since.
+	BaseRef string
+	// Approve is called before each action that changes something on the forge, with a description of it.
+	// The action is taken only if it returns nil. If Approve is nil, no write actions are allowed.
+	Approve func(ctx context.Context, description string) error
+}
+
+// Tool returns an llm.Tool based on t.
+func (t *Tool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        Name,
+		Description: strings.TrimSpace(fmt.Sprintf(Description, t.Forge.Name(), t.Forge.Repo())),
+		InputSchema: llm.MustSchema(InputSchema),
+		Run:         t.run,
+	}
+}
+
+const (
+	Name        = "forge"
+	Description = `
+Works with the %s repository %s: reads issues, comments on them, creates and pushes branches,
+and opens pull requests (merge requests, on GitLab).
+
+Use issue to read an issue or pull request and its comments, for example when the user refers to one by number.
+comment, create_branch, push, and create_pr change things that others can see, so the user must approve each one;
+if they decline, do not retry.
+push pushes the current HEAD, so commit first.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	InputSchema = `
+{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["issue", "comment", "create_branch", "push", "create_pr"],
+      "description": "issue reads an issue and its comments; comment comments on one; create_branch creates a branch on the forge; push pushes HEAD to a branch on the forge; create_pr opens a pull request"
+    },
+    "number": {
+      "type": "integer",
+      "description": "Issue number, for issue and comment"
+    },
+    "body": {
+      "type": "string",
+      "description": "Markdown comment, for comment; pull request description, for create_pr, generated from the session's commits if omitted"
+    },
+    "branch": {
+      "type": "string",
+      "description": "Branch to create (create_branch), push to (push), or merge from (create_pr)"
+    },
+    "base": {
+      "type": "string",
+      "description": "Branch to start the new branch from (create_branch) or to merge into (create_pr); defaults to the repository's default branch"
+    },
+    "title": {
+      "type": "string",
+      "description": "Pull request title, for create_pr"
+    },
+    "draft": {
+      "type": "boolean",
+      "description": "Open the pull request as a draft, for create_pr"
+    }
+  }
+}
+`
+)
+
+type input struct {
+	Action string `json:"action"`
+	Number int    `json:"number"`
+	Body   string `json:"body"`
+	Branch string `json:"branch"`
+	Base   string `json:"base"`
+	Title  string `json:"title"`
+	Draft  bool   `json:"draft"`
+}
+
+func (t *Tool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var in input
+	if err := json.Unmarshal(m, &in); err != nil {
+		return nil, fmt.Errorf("failed to parse forge input: %w", err)
+	}
+	var out string
+	var err error
+	switch in.Action {
+	case "issue":
+		out, err = t.issue(ctx, in)
+	case "comment":
+		out, err = t.comment(ctx, in)
+	case "create_branch":
+		out, err = t.createBranch(ctx, in)
+	case "push":
+		out, err = t.push(ctx, in)
+	case "create_pr":
+		out, err = t.createPR(ctx, in)
+	default:
+		return nil, fmt.Errorf("unknown action %q", in.Action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return llm.TextContent(out), nil
+}
+
+// approve asks for approval of the write action described by description.
+func (t *Tool) approve(ctx context.Context, description string) error {
+	if t.Approve == nil {
+		return fmt.Errorf("changes to %s are not allowed in this session", t.Forge.Name())
+	}
+	if err := t.Approve(ctx, description); err != nil {
+		return fmt.Errorf("not approved: %w", err)
+	}
+	return nil
+}
+
+func (t *Tool) issue(ctx context.Context, in input) (string, error) {
+	if in.Number <= 0 {
+		return "", fmt.Errorf("issue requires a number")
+	}
+	issue, err := t.Forge.Issue(ctx, in.Number)
+	if err != nil {
+		return "", err
+	}
+	comments, err := t.Forge.Comments(ctx, in.Number)
+	if err != nil {
+		return "", err
+	}
+	kind := "issue"
+	if issue.IsPullRequest {
+		kind = "pull request"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s (%s %s by %s)\n%s\n", issue.Number, issue.Title, issue.State, kind, issue.Author, issue.URL)
+	if len(issue.Labels) > 0 {
+		fmt.Fprintf(&b, "Labels: %s\n", strings.Join(issue.Labels, ", "))
+	}
+	fmt.Fprintf(&b, "\n%s\n", strings.TrimSpace(cmp.Or(issue.Body, "(no description)")))
+	for _, c := range comments {
+		fmt.Fprintf(&b, "\n--- %s commented at %s:\n%s\n", c.Author, c.Created, strings.TrimSpace(c.Body))
+	}
+	return b.String(), nil
+}
+
+func (t *Tool) comment(ctx context.Context, in input) (string, error) {
+	if in.Number <= 0 || strings.TrimSpace(in.Body) == "" {
+		return "", fmt.Errorf("comment requires a number and a body")
+	}
+	if err := t.approve(ctx, fmt.Sprintf("comment on %s#%d:\n\n%s", t.Forge.Repo(), in.Number, in.Body)); err != nil {
+		return "", err
+	}
+	c, err := t.Forge.Comment(ctx, in.Number, in.Body)
+	if err != nil {
+		return "", err
+	}
+	return "Commented: " + c.URL, nil
+}
+
+func (t *Tool) createBranch(ctx context.Context, in input) (string, error) {
+	if in.Branch == "" {
+		return "", fmt.Errorf("create_branch requires a branch")
+	}
+	base, err := t.base(ctx, in)
+	if err != nil {
+		return "", err
+	}
+	if err := t.approve(ctx, fmt.Sprintf("create branch %s in %s from %s", in.Branch, t.Forge.Repo(), base)); err != nil {
+		return "", err
+	}
+	sha, err := t.Forge.CreateBranch(ctx, in.Branch, base)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Created branch %s at %.12s", in.Branch, sha), nil
+}
+
+func (t *Tool) push(ctx context.Context, in input) (string, error) {
+	if in.Branch == "" {
+		return "", fmt.Errorf("push requires a branch")
+	}
+	head, err := t.git(ctx, "log", "-1", "--format=%h %s")
+	if err != nil {
+		return "", err
+	}
+	if err := t.approve(ctx, fmt.Sprintf("push HEAD (%s) to branch %s of %s", strings.TrimSpace(head), in.Branch, t.Forge.Repo())); err != nil {
+		return "", err
+	}
+	gitURL, _, _ := t.Forge.PushAuth()
+	out, err := t.git(ctx, "push", gitURL, "HEAD:refs/heads/"+in.Branch)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace("Pushed HEAD to " + in.Branch + "\n" + out), nil
+}
+
+func (t *Tool) createPR(ctx context.Context, in input) (string, error) {
+	if in.Branch == "" || in.Title == "" {
+		return "", fmt.Errorf("create_pr requires a branch and a title")
+	}
+	base, err := t.base(ctx, in)
+	if err != nil {
+		return "", err
+	}
+	body := in.Body
+	if body == "" {
+		body = t.describeCommits(ctx)
+	}
+	kind := "pull request"
+	if in.Draft {
+		kind = "draft pull request"
+	}
+	if err := t.approve(ctx, fmt.Sprintf("open a %s in %s from %s into %s:\n\n%s\n\n%s", kind, t.Forge.Repo(), in.Branch, base, in.Title, body)); err != nil {
+		return "", err
+	}
+	pr, err := t.Forge.CreatePullRequest(ctx, NewPullRequest{Title: in.Title, Head: in.Branch, Base: base, Body: body, Draft: in.Draft})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Opened pull request #%d: %s", pr.Number, pr.URL), nil
+}
+
+// base returns the base branch of in, defaulting to the repository's default branch.
+func (t *Tool) base(ctx context.Context, in input) (string, error) {
+	if in.Base != "" {
+		return in.Base, nil
+	}
+	return t.Forge.DefaultBranch(ctx)
+}
+
+// describeCommits returns a pull request description listing the commits since t.BaseRef, or "" if there are none.
+func (t *Tool) describeCommits(ctx context.Context) string {
+	if t.BaseRef == "" {
+		return ""
+	}
+	out, err := t.git(ctx, "log", "--reverse", "--format=%s%x00%b%x1e", t.BaseRef+"..HEAD")
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for rec := range strings.SplitSeq(out, "\x1e") {
+		subject, body, ok := strings.Cut(strings.TrimSpace(rec), "\x00")
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "- %s\n", subject)
+		for line := range strings.Lines(strings.TrimSpace(body)) {
+			if strings.TrimSpace(line) != "" {
+				fmt.Fprintf(&b, "  %s", line)
+			}
+		}
+		if !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// git runs git with args in t.RepoRoot, authenticated to the forge, and returns its output.
+func (t *Tool) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = t.RepoRoot
+	// Pass the token in the environment, where other users' ps can't see it.
+	_, user, password := t.Forge.PushAuth()
+	auth := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=AUTHORIZATION: basic "+auth,
+		"GIT_TERMINAL_PROMPT=0",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %w", args[0], strings.TrimSpace(string(out)), err)
+	}
+	return string(out), nil
+}
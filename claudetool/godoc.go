@@ -0,0 +1,211 @@
+package claudetool
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// GoDocTool specifies an llm.Tool that shows the documentation of Go packages and symbols with go doc:
+// the standard library's, the module's own, and its dependencies' at the versions it requires.
+// Packages the module does not depend on are fetched from the module proxy.
+type GoDocTool struct {
+	// RepoRoot is the root of the repo, where go doc runs unless the input says otherwise.
+	RepoRoot string
+}
+
+// Tool returns an llm.Tool based on g.
+func (g *GoDocTool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        GoDocName,
+		Description: strings.TrimSpace(GoDocDescription),
+		InputSchema: llm.MustSchema(GoDocInputSchema),
+		Run:         g.run,
+	}
+}
+
+const (
+	GoDocName        = "godoc"
+	GoDocDescription = `
+Shows the documentation and signatures of a Go package, or of one of its symbols, using go doc.
+
+Use it before calling an API you are not sure of, instead of guessing its signature or grepping the module cache.
+Dependencies are documented at the version the module requires. Packages the module does not depend on
+are fetched from the module proxy, at the latest version unless you give one.
+Without a symbol, it lists the package's exported declarations; with one, it shows the symbol's full documentation.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	GoDocInputSchema = `
+{
+  "type": "object",
+  "required": ["package"],
+  "properties": {
+    "package": {
+      "type": "string",
+      "description": "Import path of the package, such as net/http or github.com/google/go-cmp/cmp, or a relative path to a package in the module, such as ./internal/store"
+    },
+    "symbol": {
+      "type": "string",
+      "description": "Exported symbol in the package, such as Client, Client.Do, or Client.Timeout"
+    },
+    "all": {
+      "type": "boolean",
+      "description": "Show the full documentation of the whole package, which can be long; ignored with a symbol"
+    },
+    "version": {
+      "type": "string",
+      "description": "Module version, such as v1.2.3, to fetch a package the module does not depend on at; defaults to the latest"
+    },
+    "dir": {
+      "type": "string",
+      "description": "Directory of the Go module whose dependencies to use, relative to the repository root; defaults to the root"
+    }
+  }
+}
+`
+)
+
+const (
+	// goDocTimeout bounds go doc, including fetching a module from the proxy.
+	goDocTimeout = 2 * time.Minute
+	// goDocMaxOutput is the most documentation returned.
+	goDocMaxOutput = 24 << 10
+)
+
+func (g *GoDocTool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var input struct {
+		Package string `json:"package"`
+		Symbol  string `json:"symbol"`
+		All     bool   `json:"all"`
+		Version string `json:"version"`
+		Dir     string `json:"dir"`
+	}
+	if err := json.Unmarshal(m, &input); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal godoc input: %w", err)
+	}
+	pkg := strings.TrimSpace(input.Package)
+	if pkg == "" {
+		return nil, fmt.Errorf("package is required")
+	}
+	if strings.HasPrefix(pkg, "-") || strings.HasPrefix(input.Symbol, "-") {
+		return nil, fmt.Errorf("package and symbol cannot be flags")
+	}
+	dir := filepath.Join(g.RepoRoot, input.Dir)
+	if rel, err := filepath.Rel(g.RepoRoot, dir); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("dir %q is outside the repository", input.Dir)
+	}
+	ctx, cancel := context.WithTimeout(ctx, goDocTimeout)
+	defer cancel()
+
+	args := goDocArgs(pkg, input.Symbol, input.All)
+	var source string
+	out, err := runGo(ctx, dir, nil, args...)
+	if err != nil && isRemoteImportPath(pkg) && notDependedOn(err) {
+		out, source, err = goDocFromProxy(ctx, pkg, input.Version, args)
+	}
+	slog.InfoContext(ctx, "godoc", "package", pkg, "symbol", input.Symbol, "source", source, "error", err)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := string(out)
+	if source != "" {
+		doc = fmt.Sprintf("(%s is not a dependency of this module; this is %s, from the module proxy)\n\n%s", pkg, source, doc)
+	}
+	if len(doc) > goDocMaxOutput {
+		doc = doc[:goDocMaxOutput]
+		if i := strings.LastIndexByte(doc, '\n'); i > 0 {
+			doc = doc[:i+1]
+		}
+		doc += "\n[documentation truncated; ask for a symbol to see its documentation in full]"
+	}
+	return llm.TextContent(doc), nil
+}
+
+// goDocArgs returns the go command's arguments to document symbol in pkg,
+// or pkg itself, in full if all is set.
+func goDocArgs(pkg, symbol string, all bool) []string {
+	args := []string{"doc"}
+	if symbol != "" {
+		return append(args, pkg, symbol)
+	}
+	if all {
+		args = append(args, "-all")
+	}
+	return append(args, pkg)
+}
+
+// runGo runs the go command with args in dir, with env added to its environment,
+// returning its output, or an error with what it printed.
+func runGo(ctx context.Context, dir string, env []string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("go %s: %w", strings.Join(args, " "), context.Cause(ctx))
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("go %s: %s", strings.Join(args, " "), msg)
+		}
+		return nil, fmt.Errorf("go %s: %w", strings.Join(args, " "), err)
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+// goDocFromProxy documents pkg at version, or the latest, by requiring its module from the module proxy
+// in a scratch module. It returns the documentation, and the module and version it is from.
+func goDocFromProxy(ctx context.Context, pkg, version string, args []string) (out []byte, source string, err error) {
+	dir, err := os.MkdirTemp("", "sketch-godoc-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module sketch.dev/godoc\n"), 0o644); err != nil {
+		return nil, "", err
+	}
+	// The scratch module has no go.sum to keep current; -mod=mod lets go doc load the package anyway.
+	env := []string{"GOFLAGS=" + strings.TrimSpace("-mod=mod "+os.Getenv("GOFLAGS")), "GOWORK=off"}
+	if _, err := runGo(ctx, dir, env, "get", pkg+"@"+cmp.Or(version, "latest")); err != nil {
+		return nil, "", err
+	}
+	mod, err := runGo(ctx, dir, env, "list", "-f", "{{with .Module}}{{.Path}}@{{.Version}}{{end}}", pkg)
+	if err != nil {
+		return nil, "", err
+	}
+	out, err = runGo(ctx, dir, env, args...)
+	return out, string(mod), err
+}
+
+// isRemoteImportPath reports whether pkg is an import path that could be fetched,
+// one whose first element has a dot, such as github.com/owner/repo.
+func isRemoteImportPath(pkg string) bool {
+	first, _, _ := strings.Cut(pkg, "/")
+	return strings.Contains(first, ".") && !strings.HasPrefix(pkg, ".")
+}
+
+// notDependedOn reports whether err from go doc means the module does not provide the package,
+// rather than, say, that the symbol does not exist.
+func notDependedOn(err error) bool {
+	msg := err.Error()
+	for _, s := range []string{"no required module provides", "no such package", "cannot find module providing", "is not in std", "go.mod file not found", "no Go files", "cannot find package", "not a known dependency"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
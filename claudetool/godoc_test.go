@@ -0,0 +1,125 @@
+package claudetool
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGoDocTool(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"internal/store/store.go": `// Package store keeps things.
+package store
+
+// Open opens the store at path.
+func Open(path string) (*Store, error) { return nil, nil }
+
+// A Store keeps things.
+type Store struct{}
+
+// Get returns the thing named name.
+func (s *Store) Get(name string) string { return "" }
+`,
+	})
+	t.Setenv("GOFLAGS", "-mod=mod")
+	t.Setenv("GOPROXY", "off")
+
+	tool := (&GoDocTool{RepoRoot: root}).Tool()
+	godoc := func(input string) (string, error) {
+		out, err := tool.Run(context.Background(), json.RawMessage(input))
+		if err != nil {
+			return "", err
+		}
+		return out[0].Text, nil
+	}
+
+	for _, tt := range []struct {
+		input string
+		want  []string
+	}{
+		{`{"package": "strings", "symbol": "Cut"}`, []string{"func Cut(s, sep string) (before, after string, found bool)"}},
+		{`{"package": "./internal/store"}`, []string{"Package store keeps things.", "func Open(path string) (*Store, error)", "type Store struct{}"}},
+		{`{"package": "./internal/store", "symbol": "Store.Get"}`, []string{"func (s *Store) Get(name string) string", "Get returns the thing named name."}},
+		{`{"package": "./internal/store", "all": true}`, []string{"Get returns the thing named name."}},
+	} {
+		out, err := godoc(tt.input)
+		if err != nil {
+			t.Errorf("godoc(%s): %v", tt.input, err)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("godoc(%s) =\n%s\nwant it to contain %q", tt.input, out, want)
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		input, want string
+	}{
+		{`{"package": "strings", "symbol": "NoSuch"}`, "no symbol NoSuch"},
+		{`{"package": ""}`, "package is required"},
+		{`{"package": "-u"}`, "cannot be flags"},
+		{`{"package": "strings", "dir": "../.."}`, "outside the repository"},
+		{`{"package": "example.com/missing"}`, "example.com/missing"},
+	} {
+		if _, err := godoc(tt.input); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("godoc(%s) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestGoDocFromProxy(t *testing.T) {
+	// A file-based module proxy with one version of one module.
+	proxy := t.TempDir()
+	dir := filepath.Join(proxy, "example.com", "greet", "@v")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{
+		"list":        "v1.2.0\n",
+		"v1.2.0.info": `{"Version": "v1.2.0", "Time": "2024-01-01T00:00:00Z"}`,
+		"v1.2.0.mod":  "module example.com/greet\n",
+	})
+	f, err := os.Create(filepath.Join(dir, "v1.2.0.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"go.mod":   "module example.com/greet\n",
+		"greet.go": "// Package greet greets.\npackage greet\n\n// Hello returns a greeting for name.\nfunc Hello(name string) string { return \"hello, \" + name }\n",
+	} {
+		w, err := zw.Create("example.com/greet@v1.2.0/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n"})
+	t.Setenv("GOPROXY", "file://"+filepath.ToSlash(proxy))
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GOFLAGS", "-modcacherw")
+	t.Setenv("GOMODCACHE", t.TempDir())
+
+	out, err := (&GoDocTool{RepoRoot: root}).Tool().Run(context.Background(), json.RawMessage(`{"package": "example.com/greet", "symbol": "Hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := out[0].Text
+	if !strings.Contains(text, "this is example.com/greet@v1.2.0, from the module proxy") || !strings.Contains(text, "func Hello(name string) string") {
+		t.Errorf("godoc of a package from the proxy =\n%s", text)
+	}
+}
@@ -0,0 +1,341 @@
+package claudetool
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"sketch.dev/claudetool/bashkit"
+	"sketch.dev/llm"
+)
+
+// GroupTool returns an llm.Tool that starts and stops groups of background jobs
+// that depend on each other, sharing b's jobs, so that commands can wait_for them.
+func (b *BashTool) GroupTool() *llm.Tool {
+	return &llm.Tool{
+		Name:        jobGroupName,
+		Description: strings.TrimSpace(jobGroupDescription),
+		InputSchema: llm.MustSchema(jobGroupInputSchema),
+		Run:         b.runGroup,
+	}
+}
+
+const (
+	jobGroupName        = "job_group"
+	jobGroupDescription = `
+Starts or stops a named group of background commands that depend on each other, such as a database,
+the migrations that need it, and the server that needs both, in one call.
+start starts each job once the jobs it comes after are ready, and returns their job numbers, PIDs,
+and output files. A job with once set, such as migrations, instead runs to completion,
+and the jobs after it start only if it succeeds. If a job fails to start or become ready, the jobs already started are stopped.
+stop stops a group's jobs in the reverse order. Stop groups when you are done with them.
+`
+	// If you modify this, update the termui template for prettier rendering.
+	jobGroupInputSchema = `
+{
+  "type": "object",
+  "required": ["action", "group"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["start", "stop"]
+    },
+    "group": {
+      "type": "string",
+      "description": "Name of the group"
+    },
+    "jobs": {
+      "type": "array",
+      "description": "For start: the group's jobs",
+      "items": {
+        "type": "object",
+        "required": ["name", "command"],
+        "properties": {
+          "name": {
+            "type": "string",
+            "description": "Name of the job within the group"
+          },
+          "command": {
+            "type": "string",
+            "description": "Shell script to run in the background"
+          },
+          "after": {
+            "type": "array",
+            "items": {"type": "string"},
+            "description": "Names of jobs that must be ready before this one starts"
+          },
+          "once": {
+            "type": "boolean",
+            "description": "Run the command to completion instead of in the background; it is ready once it succeeds"
+          },
+          "timeout": {
+            "type": "string",
+            "description": "For once jobs: timeout as a Go duration string, defaults to 10m"
+          },
+          "ready": {
+            "type": "object",
+            "description": "When the job is ready, as for bash; without it, a job is ready once started",
+            "properties": {
+              "port": {"type": "integer"},
+              "http_path": {"type": "string"},
+              "log": {"type": "string"},
+              "timeout": {"type": "string"}
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
+)
+
+type jobGroupInput struct {
+	Action string         `json:"action"`
+	Group  string         `json:"group"`
+	Jobs   []groupJobSpec `json:"jobs"`
+}
+
+type groupJobSpec struct {
+	Name    string      `json:"name"`
+	Command string      `json:"command"`
+	After   []string    `json:"after,omitempty"`
+	Once    bool        `json:"once,omitempty"`
+	Timeout string      `json:"timeout,omitempty"`
+	Ready   *ReadyProbe `json:"ready,omitempty"`
+}
+
+// A groupJob is a started job of a group: a background job, or the output of a once job.
+type groupJob struct {
+	Name   string `json:"name"`
+	Output string `json:"output,omitempty"`
+	*BackgroundResult
+}
+
+// stopGrace is how long a stopped job has to exit after SIGTERM before it is killed.
+const stopGrace = 5 * time.Second
+
+func (b *BashTool) runGroup(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var in jobGroupInput
+	if err := json.Unmarshal(m, &in); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job_group input: %w", err)
+	}
+	if in.Group == "" {
+		return nil, fmt.Errorf("job_group requires a group name")
+	}
+	switch in.Action {
+	case "start":
+		jobs, err := b.startGroup(ctx, in.Group, in.Jobs)
+		if err != nil {
+			return nil, err
+		}
+		out, err := json.Marshal(jobs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal job group: %w", err)
+		}
+		return llm.TextContent(string(out)), nil
+	case "stop":
+		jobs, ok := b.jobs.removeGroup(in.Group)
+		if !ok {
+			return nil, fmt.Errorf("no job group %q", in.Group)
+		}
+		var names []string
+		for _, job := range slices.Backward(jobs) {
+			if job.BackgroundResult != nil {
+				stopJob(ctx, job.BackgroundResult)
+				names = append(names, job.Name)
+			}
+		}
+		return llm.TextContent(fmt.Sprintf("Stopped %s: %s", in.Group, strings.Join(names, ", "))), nil
+	default:
+		return nil, fmt.Errorf("unknown action %q", in.Action)
+	}
+}
+
+// startGroup starts the jobs of group in dependency order, each once the jobs it comes after are ready.
+// If one fails, it stops the jobs it started, in reverse order.
+func (b *BashTool) startGroup(ctx context.Context, group string, specs []groupJobSpec) ([]groupJob, error) {
+	order, err := groupOrder(specs)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range order {
+		if err := b.checkGroupJob(spec); err != nil {
+			return nil, fmt.Errorf("job %s: %w", spec.Name, err)
+		}
+	}
+	if !b.jobs.reserveGroup(group) {
+		return nil, fmt.Errorf("job group %q is already running; stop it first", group)
+	}
+
+	var started []groupJob
+	byName := make(map[string]int)
+	fail := func(err error) ([]groupJob, error) {
+		for _, job := range slices.Backward(started) {
+			if job.BackgroundResult != nil {
+				stopJob(ctx, job.BackgroundResult)
+			}
+		}
+		b.jobs.removeGroup(group)
+		return nil, err
+	}
+	for _, spec := range order {
+		var deps []int
+		for _, name := range spec.After {
+			if id, ok := byName[name]; ok { // once jobs are done already
+				deps = append(deps, id)
+			}
+		}
+		if err := b.jobs.wait(ctx, deps); err != nil {
+			return fail(fmt.Errorf("job %s: %w", spec.Name, err))
+		}
+		if spec.Once {
+			req := bashInput{Command: spec.Command, Timeout: cmp.Or(spec.Timeout, "10m"), Env: b.environ()}
+			b.recordCommand(ctx, req)
+			out, err := executeBash(ctx, req)
+			if err != nil {
+				return fail(fmt.Errorf("job %s: %w", spec.Name, err))
+			}
+			started = append(started, groupJob{Name: spec.Name, Output: out})
+			b.jobs.setGroup(group, started)
+			continue
+		}
+		req := bashInput{Command: spec.Command, Background: true, Ready: spec.Ready, Env: b.environ()}
+		b.recordCommand(ctx, req)
+		result, err := b.startBackground(ctx, req)
+		if err != nil {
+			return fail(fmt.Errorf("job %s: %w", spec.Name, err))
+		}
+		byName[spec.Name] = result.Job
+		started = append(started, groupJob{Name: spec.Name, BackgroundResult: result})
+		b.jobs.setGroup(group, started)
+	}
+	// The group is up once its last jobs are ready too.
+	var ids []int
+	for _, id := range byName {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	if err := b.jobs.wait(ctx, ids); err != nil {
+		return fail(err)
+	}
+	for _, job := range started {
+		if job.BackgroundResult != nil {
+			job.Status = b.jobs.statusOf(job.Job)
+		}
+	}
+	slog.InfoContext(ctx, "job_group_started", "group", group, "jobs", len(started))
+	return started, nil
+}
+
+// checkGroupJob checks spec the way Run checks a command, before any job of its group starts.
+func (b *BashTool) checkGroupJob(spec groupJobSpec) error {
+	if err := bashkit.Check(spec.Command); err != nil {
+		return err
+	}
+	if b.CheckPermission != nil {
+		if err := b.CheckPermission(spec.Command); err != nil {
+			PermissionDenials.Inc(jobGroupName, "policy")
+			return err
+		}
+	}
+	if spec.Ready != nil {
+		if spec.Once {
+			return fmt.Errorf("once jobs are ready when they succeed, and take no ready probe")
+		}
+		if _, _, err := spec.Ready.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupOrder returns specs in an order in which every job comes after the jobs it names in After,
+// keeping the given order otherwise.
+func groupOrder(specs []groupJobSpec) ([]groupJobSpec, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("a job group needs at least one job")
+	}
+	index := make(map[string]int)
+	for i, spec := range specs {
+		if spec.Name == "" || spec.Command == "" {
+			return nil, fmt.Errorf("every job needs a name and a command")
+		}
+		if _, dup := index[spec.Name]; dup {
+			return nil, fmt.Errorf("two jobs are named %s", spec.Name)
+		}
+		index[spec.Name] = i
+	}
+	for _, spec := range specs {
+		for _, dep := range spec.After {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("job %s comes after %s, which is not in the group", spec.Name, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(specs))
+	var order []groupJobSpec
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		switch state[i] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("jobs depend on each other in a cycle: %s", strings.Join(append(path, specs[i].Name), " → "))
+		}
+		state[i] = visiting
+		for _, dep := range specs[i].After {
+			if err := visit(index[dep], append(path, specs[i].Name)); err != nil {
+				return err
+			}
+		}
+		state[i] = done
+		order = append(order, specs[i])
+		return nil
+	}
+	for i := range specs {
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// stopJob stops the job started with result and everything it started:
+// with its stop command if it has one, or else with SIGTERM to its process group,
+// followed by SIGKILL if it is still running after stopGrace.
+func stopJob(ctx context.Context, result *BackgroundResult) {
+	if result.exit != nil {
+		result.exit.stopped.Store(true)
+	}
+	if result.StopCommand != "" {
+		if out, err := exec.CommandContext(ctx, "bash", "-c", result.StopCommand).CombinedOutput(); err != nil {
+			slog.WarnContext(ctx, "job_stop_failed", "pid", result.PID, "error", err, "output", string(out))
+		}
+		return
+	}
+	if syscall.Kill(-result.PID, syscall.SIGTERM) != nil {
+		syscall.Kill(result.PID, syscall.SIGTERM)
+	}
+	for deadline := time.Now().Add(stopGrace); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if !processAlive(result.PID) {
+			return
+		}
+	}
+	if syscall.Kill(-result.PID, syscall.SIGKILL) != nil {
+		syscall.Kill(result.PID, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,420 @@
+package claudetool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Statuses of background jobs.
+const (
+	JobRunning  = "running"   // started without a readiness probe
+	JobStarting = "starting"  // waiting for its readiness probe to pass
+	JobReady    = "ready"     // its readiness probe passed
+	JobNotReady = "not_ready" // its readiness probe did not pass in time
+	JobExited   = "exited"
+)
+
+// A ReadyProbe says when a background job is ready, for example when a server it starts accepts requests.
+// Every condition that is set must hold.
+type ReadyProbe struct {
+	// Port is a TCP port on localhost that the job listens on.
+	Port int `json:"port,omitempty"`
+	// HTTPPath, with Port, is a path that must answer GET with 200 OK.
+	HTTPPath string `json:"http_path,omitempty"`
+	// Log is a regular expression that a line of the job's output must match.
+	Log string `json:"log,omitempty"`
+	// Timeout is how long, as a Go duration string, the job has to become ready; 2m if empty.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+const (
+	defaultReadyTimeout = 2 * time.Minute
+	readyPollInterval   = 200 * time.Millisecond
+)
+
+// check reports whether p is well-formed, and returns its compiled log pattern and its timeout.
+func (p *ReadyProbe) check() (*regexp.Regexp, time.Duration, error) {
+	if p.Port == 0 && p.Log == "" {
+		return nil, 0, fmt.Errorf("ready needs a port or a log pattern")
+	}
+	if p.Port < 0 || p.Port > 65535 {
+		return nil, 0, fmt.Errorf("ready port %d is out of range", p.Port)
+	}
+	if p.HTTPPath != "" && p.Port == 0 {
+		return nil, 0, fmt.Errorf("ready http_path needs a port")
+	}
+	var re *regexp.Regexp
+	if p.Log != "" {
+		var err error
+		if re, err = regexp.Compile(p.Log); err != nil {
+			return nil, 0, fmt.Errorf("bad ready log pattern: %w", err)
+		}
+	}
+	timeout := defaultReadyTimeout
+	if p.Timeout != "" {
+		d, err := time.ParseDuration(p.Timeout)
+		if err != nil || d <= 0 {
+			return nil, 0, fmt.Errorf("bad ready timeout %q", p.Timeout)
+		}
+		timeout = d
+	}
+	return re, timeout, nil
+}
+
+// jobRegistry tracks the background jobs a BashTool started, and the status of their readiness probes.
+// Its zero value is ready to use.
+type jobRegistry struct {
+	mu     sync.Mutex
+	next   int
+	jobs   map[int]*bgJob
+	groups map[string][]groupJob // job groups, by name, with their jobs in the order they started
+}
+
+type bgJob struct {
+	command string
+	result  *BackgroundResult
+	status  string
+	reason  string        // why the job is not ready, for JobNotReady
+	done    chan struct{} // closed when the status leaves JobStarting
+}
+
+// add registers the job that runs command, started with result, evaluating probe, if non-nil, in the background.
+// It sets result's Job and Status.
+func (r *jobRegistry) add(command string, result *BackgroundResult, probe *ReadyProbe) {
+	job := &bgJob{command: command, result: result, status: JobRunning, done: make(chan struct{})}
+	if probe == nil {
+		close(job.done)
+	} else {
+		job.status = JobStarting
+	}
+	r.mu.Lock()
+	r.next++
+	result.Job = r.next
+	if r.jobs == nil {
+		r.jobs = make(map[int]*bgJob)
+	}
+	r.jobs[result.Job] = job
+	r.mu.Unlock()
+	liveJobs.Store(result.PID, true)
+	result.Status = job.status
+	if probe != nil {
+		go r.probe(job, probe)
+	}
+}
+
+// probe evaluates p for job until it passes, the job exits, or p's timeout passes.
+func (r *jobRegistry) probe(job *bgJob, p *ReadyProbe) {
+	re, timeout, _ := p.check() // Run checked p before starting the job
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	logs := &logMatcher{re: re, files: []string{job.result.StdoutFile, job.result.StderrFile}}
+	t := time.NewTicker(readyPollInterval)
+	defer t.Stop()
+	for {
+		if ready(ctx, p, logs) {
+			r.settle(job, JobReady, "")
+			return
+		}
+		if !processAlive(job.result.PID) {
+			r.settle(job, JobExited, "")
+			return
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			r.settle(job, JobNotReady, fmt.Sprintf("its readiness probe did not pass within %s", timeout))
+			return
+		}
+	}
+}
+
+func (r *jobRegistry) settle(job *bgJob, status, reason string) {
+	r.mu.Lock()
+	job.status, job.reason = status, reason
+	r.mu.Unlock()
+	close(job.done)
+}
+
+// current returns job's status, noticing if it has exited since it was last probed.
+func (r *jobRegistry) current(job *bgJob) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if job.status != JobStarting && job.status != JobExited && !processAlive(job.result.PID) {
+		job.status = JobExited
+	}
+	return job.status
+}
+
+// statusOf returns the status of job id.
+func (r *jobRegistry) statusOf(id int) string {
+	r.mu.Lock()
+	job := r.jobs[id]
+	r.mu.Unlock()
+	if job == nil {
+		return ""
+	}
+	return r.current(job)
+}
+
+// running returns the IDs of the jobs that have not exited, in the order they started.
+func (r *jobRegistry) running() []int {
+	r.mu.Lock()
+	jobs := maps.Clone(r.jobs)
+	r.mu.Unlock()
+	var ids []int
+	for id, job := range jobs {
+		if r.current(job) != JobExited {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// A JobInfo describes a background job.
+type JobInfo struct {
+	BackgroundResult
+	Command string `json:"command"`
+}
+
+// list describes all the jobs, in the order they started.
+func (r *jobRegistry) list() []JobInfo {
+	r.mu.Lock()
+	jobs := maps.Clone(r.jobs)
+	r.mu.Unlock()
+	var infos []JobInfo
+	for _, id := range slices.Sorted(maps.Keys(jobs)) {
+		info := JobInfo{BackgroundResult: *jobs[id].result, Command: jobs[id].command}
+		info.Status = r.current(jobs[id])
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// result returns the BackgroundResult of job id, or nil if there is no such job.
+func (r *jobRegistry) result(id int) *BackgroundResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if job := r.jobs[id]; job != nil {
+		return job.result
+	}
+	return nil
+}
+
+// groupNames returns the names of the groups that are running or starting, sorted.
+func (r *jobRegistry) groupNames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Sorted(maps.Keys(r.groups))
+}
+
+// reserveGroup claims the name group for a group that is starting.
+// It reports false if a group of that name is already running.
+func (r *jobRegistry) reserveGroup(group string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.groups[group]; ok {
+		return false
+	}
+	if r.groups == nil {
+		r.groups = make(map[string][]groupJob)
+	}
+	r.groups[group] = []groupJob{}
+	return true
+}
+
+// setGroup records the jobs of group that have started.
+func (r *jobRegistry) setGroup(group string, jobs []groupJob) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.groups[group] = slices.Clone(jobs)
+}
+
+// removeGroup forgets group, returning its jobs.
+func (r *jobRegistry) removeGroup(group string) ([]groupJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs, ok := r.groups[group]
+	delete(r.groups, group)
+	return jobs, ok
+}
+
+// wait waits until each of the jobs ids is ready, or running if it has no readiness probe.
+// It fails as soon as one of them exits or does not become ready in time.
+func (r *jobRegistry) wait(ctx context.Context, ids []int) error {
+	for _, id := range ids {
+		r.mu.Lock()
+		job := r.jobs[id]
+		r.mu.Unlock()
+		if job == nil {
+			return fmt.Errorf("no background job %d", id)
+		}
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for background job %d to become ready: %w", id, context.Cause(ctx))
+		}
+		switch status := r.current(job); status {
+		case JobReady, JobRunning:
+		case JobExited:
+			return fmt.Errorf("background job %d exited before it was ready%s", id, outputTail(job.result))
+		default:
+			return fmt.Errorf("background job %d is not ready: %s%s", id, job.reason, outputTail(job.result))
+		}
+	}
+	return nil
+}
+
+// ready reports whether every condition of p holds.
+func ready(ctx context.Context, p *ReadyProbe, logs *logMatcher) bool {
+	if p.Port != 0 {
+		addr := net.JoinHostPort("localhost", strconv.Itoa(p.Port))
+		conn, err := (&net.Dialer{Timeout: time.Second}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		if p.HTTPPath != "" && !httpOK(ctx, "http://"+addr+"/"+strings.TrimPrefix(p.HTTPPath, "/")) {
+			return false
+		}
+	}
+	return logs.re == nil || logs.match()
+}
+
+func httpOK(ctx context.Context, url string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// logMatcher looks for a line matching re in files as they grow.
+type logMatcher struct {
+	re      *regexp.Regexp
+	files   []string
+	offsets [2]int64
+	partial [2][]byte // each file's last line, if incomplete
+	matched bool
+}
+
+// match reports whether a line of output written so far matches m.re.
+func (m *logMatcher) match() bool {
+	for i, name := range m.files {
+		if m.matched || i >= len(m.offsets) {
+			break
+		}
+		m.scan(i, name)
+	}
+	return m.matched
+}
+
+func (m *logMatcher) scan(i int, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.NewSectionReader(f, m.offsets[i], 1<<62))
+	if err != nil || len(data) == 0 {
+		return
+	}
+	m.offsets[i] += int64(len(data))
+	data = append(m.partial[i], data...)
+	lines := bytes.Split(data, []byte("\n"))
+	m.partial[i] = lines[len(lines)-1]
+	// A server may print its banner without a trailing newline, so try the incomplete line too.
+	for _, line := range lines {
+		if m.re.Match(bytes.TrimRight(line, "\r")) {
+			m.matched = true
+			return
+		}
+	}
+	if len(m.partial[i]) > 64<<10 {
+		m.partial[i] = nil
+	}
+}
+
+// outputTail returns the end of the output of the job that produced result, for an error message.
+func outputTail(result *BackgroundResult) string {
+	out := lastOutput(result)
+	if out == "" {
+		return ""
+	}
+	return "\nIts output ends:\n" + out
+}
+
+// lastOutput returns the end of the output of the job of result.
+func lastOutput(result *BackgroundResult) string {
+	var out []byte
+	for _, name := range []string{result.StdoutFile, result.StderrFile} {
+		data, _ := os.ReadFile(name)
+		out = append(out, data...)
+	}
+	out = bytes.TrimSpace(out)
+	if len(out) > 2000 {
+		out = append([]byte("..."), out[len(out)-2000:]...)
+	}
+	return string(out)
+}
+
+// jobWatchInterval is how often watchForCrash checks on a job whose exit status it cannot learn.
+const jobWatchInterval = time.Second
+
+// watchForCrash waits for the job of result, which runs command, to exit, and tells b.JobCrashed if it crashed:
+// if it exited before it was stopped, with a failure status or one that cannot be learned.
+func (b *BashTool) watchForCrash(ctx context.Context, command string, result *BackgroundResult) {
+	exit := result.exit
+	if exit.done != nil {
+		<-exit.done
+	} else {
+		for processAlive(result.PID) {
+			time.Sleep(jobWatchInterval)
+		}
+	}
+	if exit.stopped.Load() {
+		return
+	}
+	err := exit.err
+	if err == nil {
+		if exit.done != nil {
+			return // it finished
+		}
+		err = errors.New("exited on its own")
+	}
+	slog.WarnContext(ctx, "bash_job_crashed", "job", result.Job, "pid", result.PID, "error", err)
+	info := JobInfo{BackgroundResult: *result, Command: command}
+	info.Status = JobExited
+	b.JobCrashed(ctx, info, err, lastOutput(result))
+}
+
+// processAlive reports whether a process with the given pid exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
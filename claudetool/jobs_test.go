@@ -0,0 +1,261 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadyProbes(t *testing.T) {
+	bash := &BashTool{}
+	ctx := context.Background()
+	start := func(input string) BackgroundResult {
+		t.Helper()
+		out, err := bash.Run(ctx, json.RawMessage(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result BackgroundResult
+		if err := json.Unmarshal([]byte(out[0].Text), &result); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { syscall.Kill(-result.PID, syscall.SIGKILL) })
+		return result
+	}
+	waitFor := func(job int) error {
+		_, err := bash.Run(ctx, json.RawMessage(`{"command":"true","wait_for":[`+strconv.Itoa(job)+`]}`))
+		return err
+	}
+
+	t.Run("log", func(t *testing.T) {
+		job := start(`{"command":"sleep 0.3; echo listening on 8080; sleep 10","background":true,"ready":{"log":"listening on \\d+"}}`)
+		if job.Status != JobStarting {
+			t.Errorf("status %q, want %q", job.Status, JobStarting)
+		}
+		begin := time.Now()
+		if err := waitFor(job.Job); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(begin); d < 200*time.Millisecond || d > 5*time.Second {
+			t.Errorf("waited %v for a job that was ready after 0.3s", d)
+		}
+	})
+
+	t.Run("exited", func(t *testing.T) {
+		job := start(`{"command":"echo boom; exit 1","background":true,"ready":{"log":"never"}}`)
+		err := waitFor(job.Job)
+		if err == nil || !strings.Contains(err.Error(), "exited before it was ready") || !strings.Contains(err.Error(), "boom") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		job := start(`{"command":"sleep 10","background":true,"ready":{"log":"never","timeout":"300ms"}}`)
+		err := waitFor(job.Job)
+		if err == nil || !strings.Contains(err.Error(), "did not pass within 300ms") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("no probe", func(t *testing.T) {
+		job := start(`{"command":"sleep 10","background":true}`)
+		if job.Status != JobRunning {
+			t.Errorf("status %q, want %q", job.Status, JobRunning)
+		}
+		if err := waitFor(job.Job); err != nil {
+			t.Error(err)
+		}
+		if err := waitFor(job.Job + 100); err == nil {
+			t.Error("waiting for a job that doesn't exist succeeded")
+		}
+		jobs := bash.Jobs()
+		if last := jobs[len(jobs)-1]; last.Job != job.Job || last.Command != "sleep 10" || last.Status != JobRunning {
+			t.Errorf("last of Jobs = %+v, want job %d running sleep 10", last, job.Job)
+		}
+		if err := bash.StopJob(ctx, job.Job); err != nil {
+			t.Fatalf("StopJob: %v", err)
+		}
+		if processAlive(job.PID) {
+			t.Errorf("job %d is still running after StopJob", job.Job)
+		}
+		if err := bash.StopJob(ctx, job.Job); err == nil {
+			t.Error("stopping a job that exited succeeded")
+		}
+	})
+
+	for _, input := range []string{
+		`{"command":"true","ready":{"port":8080}}`,
+		`{"command":"true","background":true,"ready":{}}`,
+		`{"command":"true","background":true,"ready":{"http_path":"/"}}`,
+		`{"command":"true","background":true,"ready":{"log":"("}}`,
+	} {
+		if _, err := bash.Run(ctx, json.RawMessage(input)); err == nil {
+			t.Errorf("%s: no error", input)
+		}
+	}
+}
+
+func TestReadyPortAndHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	_, portStr, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	ctx := context.Background()
+	for _, tt := range []struct {
+		probe ReadyProbe
+		want  bool
+	}{
+		{ReadyProbe{Port: port}, true},
+		{ReadyProbe{Port: port, HTTPPath: "/healthz"}, true},
+		{ReadyProbe{Port: port, HTTPPath: "missing"}, false},
+	} {
+		if got := ready(ctx, &tt.probe, &logMatcher{}); got != tt.want {
+			t.Errorf("ready(%+v) = %v, want %v", tt.probe, got, tt.want)
+		}
+	}
+
+	srv.Close()
+	if ready(ctx, &ReadyProbe{Port: port}, &logMatcher{}) {
+		t.Error("closed port is ready")
+	}
+}
+
+func TestJobGroup(t *testing.T) {
+	bash := &BashTool{}
+	group := bash.GroupTool()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// db writes a marker once it's up; migrate needs it; server needs both.
+	input := `{"action":"start","group":"env","jobs":[
+		{"name":"server","command":"test -f ` + dir + `/migrated && echo serving && sleep 30","after":["migrate"],"ready":{"log":"serving"}},
+		{"name":"migrate","command":"test -f ` + dir + `/db && touch ` + dir + `/migrated","once":true,"after":["db"]},
+		{"name":"db","command":"sleep 0.2; touch ` + dir + `/db; echo db up; sleep 30","ready":{"log":"db up"}}
+	]}`
+	out, err := group.Run(ctx, json.RawMessage(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jobs []groupJob
+	if err := json.Unmarshal([]byte(out[0].Text), &jobs); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+	if got := strings.Join(names, " "); got != "db migrate server" {
+		t.Fatalf("started %s, want db migrate server", got)
+	}
+	if jobs[0].Status != JobReady || jobs[1].BackgroundResult != nil || jobs[2].Status != JobReady || jobs[2].SchemaVersion != SchemaVersion {
+		t.Errorf("jobs %+v", jobs)
+	}
+	if _, err := group.Run(ctx, json.RawMessage(input)); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("starting the group again: err = %v", err)
+	}
+
+	out, err = group.Run(ctx, json.RawMessage(`{"action":"stop","group":"env"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[0].Text != "Stopped env: server, db" {
+		t.Errorf("stop: %q", out[0].Text)
+	}
+	for _, job := range []groupJob{jobs[0], jobs[2]} {
+		if processAlive(job.PID) {
+			t.Errorf("job %s is still running", job.Name)
+		}
+	}
+
+	// A failure stops the jobs already started.
+	os.Remove(filepath.Join(dir, "db"))
+	_, err = group.Run(ctx, json.RawMessage(`{"action":"start","group":"bad","jobs":[
+		{"name":"db","command":"echo db up; sleep 30","ready":{"log":"db up"}},
+		{"name":"migrate","command":"echo no schema; exit 1","once":true,"after":["db"]}
+	]}`))
+	if err == nil || !strings.Contains(err.Error(), "job migrate") {
+		t.Errorf("failing group: err = %v", err)
+	}
+	if _, err := group.Run(ctx, json.RawMessage(`{"action":"stop","group":"bad"}`)); err == nil {
+		t.Error("failed group is still registered")
+	}
+}
+
+func TestGroupOrder(t *testing.T) {
+	for _, tt := range []struct {
+		jobs string
+		err  string
+	}{
+		{`[{"name":"a","command":"x","after":["b"]},{"name":"b","command":"x","after":["a"]}]`, "cycle: a → b → a"},
+		{`[{"name":"a","command":"x","after":["c"]}]`, "not in the group"},
+		{`[{"name":"a","command":"x"},{"name":"a","command":"y"}]`, "two jobs are named a"},
+		{`[]`, "at least one job"},
+	} {
+		var specs []groupJobSpec
+		if err := json.Unmarshal([]byte(tt.jobs), &specs); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := groupOrder(specs); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("groupOrder(%s) = %v, want error containing %q", tt.jobs, err, tt.err)
+		}
+	}
+}
+
+func TestJobCrashed(t *testing.T) {
+	type crash struct {
+		job    JobInfo
+		err    error
+		output string
+	}
+	crashes := make(chan crash, 4)
+	bash := &BashTool{JobCrashed: func(ctx context.Context, job JobInfo, err error, output string) {
+		crashes <- crash{job, err, output}
+	}}
+	ctx := context.Background()
+	start := func(command string) BackgroundResult {
+		t.Helper()
+		out, err := bash.Run(ctx, json.RawMessage(`{"command":`+strconv.Quote(command)+`,"background":true}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result BackgroundResult
+		if err := json.Unmarshal([]byte(out[0].Text), &result); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { syscall.Kill(-result.PID, syscall.SIGKILL) })
+		return result
+	}
+
+	stopped := start("sleep 10")
+	if err := bash.StopJob(ctx, stopped.Job); err != nil {
+		t.Fatal(err)
+	}
+	crashed := start("echo boom; exit 3")
+	select {
+	case c := <-crashes:
+		if c.job.Job != crashed.Job || c.job.Command != "echo boom; exit 3" || c.err == nil || !strings.Contains(c.output, "boom") {
+			t.Errorf("JobCrashed(%+v, %v, %q)", c.job, c.err, c.output)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("JobCrashed was not called for a job that exited")
+	}
+	select {
+	case c := <-crashes:
+		t.Errorf("JobCrashed was called again, for job %d", c.job.Job)
+	case <-time.After(2 * jobWatchInterval):
+	}
+}
@@ -1,9 +1,11 @@
 package claudetool
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os/exec"
@@ -89,8 +91,12 @@ func keywordRun(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
 		return nil, err
 	}
 	wd := WorkingDir(ctx)
-	root, err := FindRepoRoot(wd)
-	if err == nil {
+	if e := ExecutorOf(ctx); e != nil {
+		var out bytes.Buffer
+		if e.Run(ctx, wd, "git rev-parse --show-toplevel", nil, false, &out) == nil {
+			wd = strings.TrimSpace(out.String())
+		}
+	} else if root, err := FindRepoRoot(wd); err == nil {
 		wd = root
 	}
 	slog.InfoContext(ctx, "keyword search input", "query", input.Query, "keywords", input.SearchTerms, "wd", wd)
@@ -131,6 +137,8 @@ func keywordRun(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
 	convo := info.Convo.SubConvo()
 	convo.SystemPrompt = strings.TrimSpace(keywordSystemPrompt)
 	convo.PromptCaching = false
+	convo.CacheResponses = true
+	convo.Task = "keyword-search"
 
 	initialMessage := llm.Message{
 		Role: llm.MessageRoleUser,
@@ -167,6 +175,9 @@ func ripgrep(ctx context.Context, wd string, terms []string) (string, error) {
 	for _, term := range terms {
 		args = append(args, "-e", term)
 	}
+	if e := ExecutorOf(ctx); e != nil {
+		return remoteRipgrep(ctx, e, wd, args)
+	}
 	cmd := exec.CommandContext(ctx, "rg", args...)
 	cmd.Dir = wd
 	out, err := cmd.CombinedOutput()
@@ -180,3 +191,22 @@ func ripgrep(ctx context.Context, wd string, terms []string) (string, error) {
 	outStr := string(out)
 	return outStr, nil
 }
+
+// remoteRipgrep runs ripgrep with args in wd with e.
+func remoteRipgrep(ctx context.Context, e Executor, wd string, args []string) (string, error) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	var out bytes.Buffer
+	err := e.Run(ctx, wd, "rg "+strings.Join(quoted, " "), nil, false, &out)
+	// As locally, ripgrep's exit status 1 means that nothing matched.
+	var exit interface{ ExitStatus() int }
+	if errors.As(err, &exit) && exit.ExitStatus() == 1 {
+		return "no matches found", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("search failed: %v\n%s", err, out.String())
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,36 @@
+package claudetool
+
+import (
+	"sync"
+
+	"sketch.dev/metrics"
+)
+
+// PermissionDenials counts commands that were not allowed to run, by tool and reason:
+// "policy" when a CheckPermission callback refused the command, and, counted by the agent,
+// "user" when the user said no and "one_shot" when there was no user to ask.
+var PermissionDenials = metrics.NewCounter("sketch_permission_denials_total",
+	"Tool calls that were denied permission, by tool and reason (policy, user, or one_shot).",
+	"tool", "reason")
+
+var jitInstalls = metrics.NewCounter("sketch_jit_installs_total",
+	"Commands that bash tried to install just in time because they were missing, by result (installed or failed).",
+	"result")
+
+// liveJobs holds the pids of background jobs that have not been seen to exit.
+var liveJobs sync.Map // map[int]bool
+
+func init() {
+	metrics.NewGaugeFunc("sketch_background_jobs_active", "Background jobs that are running.", func() float64 {
+		n := 0
+		liveJobs.Range(func(pid, _ any) bool {
+			if processAlive(pid.(int)) {
+				n++
+			} else {
+				liveJobs.Delete(pid)
+			}
+			return true
+		})
+		return float64(n)
+	})
+}
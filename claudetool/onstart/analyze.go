@@ -8,10 +8,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 
 	"golang.org/x/sync/errgroup"
@@ -35,8 +37,23 @@ type Codebase struct {
 	// InjectFileContents maps paths to file contents for critical inject files
 	// to avoid requiring an extra file read during template rendering
 	InjectFileContents map[string]string
+	// DirCounts tracks the number of files in each top-level directory ("." for the root)
+	DirCounts map[string]int
+	// Contributing is the start of the root CONTRIBUTING file, if any
+	Contributing string
+	// RecentCommits are the most recent commits, newest first, as "<hash> <subject>"
+	RecentCommits []string
+	// Instructions are the instruction files that apply to the working directory, outermost first;
+	// see LoadInstructions
+	Instructions []InstructionFile
 }
 
+// maxContributing is how much of the CONTRIBUTING file Codebase keeps.
+const maxContributing = 8 << 10
+
+// nRecentCommits is how many commits Codebase.RecentCommits has.
+const nRecentCommits = 10
+
 // AnalyzeCodebase walks the codebase and analyzes the paths it finds.
 func AnalyzeCodebase(ctx context.Context, repoPath string) (*Codebase, error) {
 	// TODO: do a filesystem walk instead?
@@ -54,6 +71,8 @@ func AnalyzeCodebase(ctx context.Context, repoPath string) (*Codebase, error) {
 	}
 
 	extCounts := make(map[string]int)
+	dirCounts := make(map[string]int)
+	var contributing string
 	var buildFiles []string
 	var documentationFiles []string
 	var guidanceFiles []string
@@ -78,6 +97,11 @@ func AnalyzeCodebase(ctx context.Context, repoPath string) (*Codebase, error) {
 			ext := strings.ToLower(filepath.Ext(file))
 			ext = cmp.Or(ext, "<no-extension>")
 			extCounts[ext]++
+			dir, _, ok := strings.Cut(file, "/")
+			if !ok {
+				dir = "."
+			}
+			dirCounts[dir]++
 
 			fileCategory := categorizeFile(file)
 			// fmt.Println(file, "->", fileCategory)
@@ -86,6 +110,9 @@ func AnalyzeCodebase(ctx context.Context, repoPath string) (*Codebase, error) {
 				buildFiles = append(buildFiles, file)
 			case "documentation":
 				documentationFiles = append(documentationFiles, file)
+				if contributing == "" && !strings.Contains(file, "/") && strings.HasPrefix(strings.ToLower(file), "contributing") {
+					contributing = file
+				}
 			case "guidance":
 				guidanceFiles = append(guidanceFiles, file)
 			case "inject":
@@ -121,7 +148,7 @@ func AnalyzeCodebase(ctx context.Context, repoPath string) (*Codebase, error) {
 		injectFileContents[filePath] = string(content)
 	}
 
-	return &Codebase{
+	c := &Codebase{
 		ExtensionCounts:    extCounts,
 		TotalFiles:         totalFiles,
 		BuildFiles:         buildFiles,
@@ -129,7 +156,23 @@ func AnalyzeCodebase(ctx context.Context, repoPath string) (*Codebase, error) {
 		GuidanceFiles:      guidanceFiles,
 		InjectFiles:        injectFiles,
 		InjectFileContents: injectFileContents,
-	}, nil
+		DirCounts:          dirCounts,
+	}
+	if contributing != "" {
+		if content, err := os.ReadFile(filepath.Join(repoPath, contributing)); err == nil {
+			if len(content) > maxContributing {
+				content = append(content[:maxContributing:maxContributing], "\n[...]"...)
+			}
+			c.Contributing = string(content)
+		}
+	}
+	// A repository without commits has no log.
+	cmd = exec.CommandContext(ctx, "git", "log", "-n", strconv.Itoa(nRecentCommits), "--format=%h %s")
+	cmd.Dir = repoPath
+	if out, err := cmd.Output(); err == nil {
+		c.RecentCommits = strings.Split(strings.TrimSpace(string(out)), "\n")
+	}
+	return c, nil
 }
 
 // categorizeFile categorizes a file into one of four categories: build, documentation, guidance, or inject.
@@ -150,6 +193,7 @@ func categorizeFile(path string) string {
 		if (strings.HasPrefix(lowerFilename, "claude.") && strings.HasSuffix(lowerFilename, ".md")) ||
 			strings.HasPrefix(lowerFilename, "dear_llm") ||
 			(strings.HasPrefix(lowerFilename, "agent.") && strings.HasSuffix(lowerFilename, ".md")) ||
+			lowerFilename == "agents.md" ||
 			strings.Contains(lowerFilename, "cursorrules") {
 			return "inject"
 		}
@@ -175,7 +219,8 @@ func categorizeFile(path string) string {
 	// GuidanceFiles - other files that provide guidance but aren't critical enough to inject
 	// Non-root directory claude.md files, and other guidance files
 	if (strings.HasPrefix(lowerFilename, "claude.") && strings.HasSuffix(lowerFilename, ".md")) ||
-		(strings.HasPrefix(lowerFilename, "agent.") && strings.HasSuffix(lowerFilename, ".md")) {
+		(strings.HasPrefix(lowerFilename, "agent.") && strings.HasSuffix(lowerFilename, ".md")) ||
+		lowerFilename == "agents.md" {
 		return "guidance"
 	}
 
@@ -211,6 +256,52 @@ func (c *Codebase) TopExtensions() []string {
 	return result
 }
 
+// languages maps file extensions to the programming languages they hold.
+var languages = map[string]string{
+	".go": "Go", ".py": "Python", ".js": "JavaScript", ".jsx": "JavaScript", ".mjs": "JavaScript",
+	".ts": "TypeScript", ".tsx": "TypeScript", ".java": "Java", ".kt": "Kotlin", ".scala": "Scala",
+	".rs": "Rust", ".c": "C", ".h": "C", ".cc": "C++", ".cpp": "C++", ".hpp": "C++", ".cs": "C#",
+	".rb": "Ruby", ".php": "PHP", ".swift": "Swift", ".m": "Objective-C", ".sh": "Shell", ".bash": "Shell",
+	".lua": "Lua", ".ex": "Elixir", ".exs": "Elixir", ".erl": "Erlang", ".hs": "Haskell", ".ml": "OCaml",
+	".clj": "Clojure", ".dart": "Dart", ".r": "R", ".jl": "Julia", ".zig": "Zig", ".sql": "SQL",
+	".html": "HTML", ".css": "CSS", ".scss": "CSS", ".vue": "Vue", ".svelte": "Svelte", ".tf": "Terraform",
+}
+
+// Languages returns the top 5 programming languages in the codebase, by number of files.
+func (c *Codebase) Languages() []string {
+	counts := make(map[string]int)
+	for ext, n := range c.ExtensionCounts {
+		if lang, ok := languages[ext]; ok {
+			counts[lang] += n
+		}
+	}
+	return topCounts(counts, 5, func(lang string, n int) string {
+		return fmt.Sprintf("%v: %v files (%0.0f%%)", lang, n, 100*float64(n)/float64(c.TotalFiles))
+	})
+}
+
+// KeyDirectories returns the top 8 top-level directories of the codebase, by number of files.
+func (c *Codebase) KeyDirectories() []string {
+	counts := maps.Clone(c.DirCounts)
+	delete(counts, ".")
+	return topCounts(counts, 8, func(dir string, n int) string {
+		return fmt.Sprintf("%v/: %v files", dir, n)
+	})
+}
+
+// topCounts formats the n keys of counts with the highest counts, highest first, ties broken by key.
+func topCounts(counts map[string]int, n int, format func(string, int) string) []string {
+	keys := slices.Collect(maps.Keys(counts))
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(-cmp.Compare(counts[a], counts[b]), cmp.Compare(a, b))
+	})
+	var result []string
+	for _, k := range keys[:min(n, len(keys))] {
+		result = append(result, format(k, counts[k]))
+	}
+	return result
+}
+
 func scanZero(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if atEOF && len(data) == 0 {
 		return 0, nil, nil
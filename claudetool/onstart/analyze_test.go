@@ -2,10 +2,12 @@ package onstart
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
 )
 
@@ -180,6 +182,8 @@ func TestCategorizeFile(t *testing.T) {
 			{"Japanese Makefile", "Makefile-日本語", "build"},
 			{"Spanish README", "readme-español.md", "documentation"},
 			{"Korean Claude file", "subdir/claude.한국어.md", "guidance"},
+			{"Root AGENTS.md", "AGENTS.md", "inject"},
+			{"Nested AGENTS.md", "web/AGENTS.md", "guidance"},
 			// Test edge cases with Unicode normalization and combining characters
 			{"Mixed Unicode file", "test中文🚀.txt", ""},
 			{"Combining characters", "filé̂.go", ""}, // file with combining acute and circumflex accents
@@ -236,3 +240,42 @@ func TestTopExtensions(t *testing.T) {
 		}
 	})
 }
+
+func TestRepoFacts(t *testing.T) {
+	codebase := &Codebase{
+		ExtensionCounts: map[string]int{".go": 6, ".ts": 2, ".tsx": 1, ".md": 1},
+		TotalFiles:      10,
+		DirCounts:       map[string]int{".": 2, "loop": 5, "webui": 3},
+	}
+	if got, want := codebase.Languages(), []string{"Go: 6 files (60%)", "TypeScript: 3 files (30%)"}; !slices.Equal(got, want) {
+		t.Errorf("Languages() = %q, want %q", got, want)
+	}
+	if got, want := codebase.KeyDirectories(), []string{"loop/: 5 files", "webui/: 3 files"}; !slices.Equal(got, want) {
+		t.Errorf("KeyDirectories() = %q, want %q", got, want)
+	}
+
+	// A repository with more commits than RecentCommits keeps.
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test User", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %q: %v\n%s", args, err, out)
+		}
+	}
+	git("init")
+	for i := range nRecentCommits + 2 {
+		git("commit", "--allow-empty", "-m", fmt.Sprintf("commit %d", i))
+	}
+	codebase, err := AnalyzeCodebase(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codebase.RecentCommits) != nRecentCommits {
+		t.Fatalf("RecentCommits = %q, want %d commits", codebase.RecentCommits, nRecentCommits)
+	}
+	if got, want := codebase.RecentCommits[0], fmt.Sprintf("commit %d", nRecentCommits+1); !strings.HasSuffix(got, " "+want) {
+		t.Errorf("RecentCommits[0] = %q, want the newest, %q", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package onstart
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// An InstructionFile is a file of instructions for agents, such as AGENTS.md.
+type InstructionFile struct {
+	// Path is the file's path relative to the repository root, with forward slashes
+	Path string
+	// Content is the start of the file
+	Content string
+}
+
+// instructionNames are the instruction files read from each directory, in order, matched case-insensitively.
+var instructionNames = []string{"agents.md", "claude.md", ".sketch/instructions.md"}
+
+// maxInstructions is how much of each instruction file LoadInstructions keeps.
+const maxInstructions = 32 << 10
+
+// LoadInstructions reads the instruction files (AGENTS.md, CLAUDE.md, and .sketch/instructions.md)
+// in the repository root and in each directory from there down to dir, the working directory,
+// into c.Instructions, outermost first, so that more deeply nested instructions come later and take precedence.
+// Files it loads are removed from c.InjectFiles and c.GuidanceFiles, so they appear only once.
+// It can be called again to pick up changes to the files.
+func (c *Codebase) LoadInstructions(repoRoot, dir string) {
+	dirs := []string{"."}
+	if rel, err := filepath.Rel(repoRoot, dir); err == nil && rel != "." && filepath.IsLocal(rel) {
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		for i := range parts {
+			dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+		}
+	}
+
+	c.Instructions = nil
+	for _, d := range dirs {
+		for _, name := range instructionNames {
+			path := findFold(repoRoot, d, name)
+			if path == "" {
+				continue
+			}
+			content, err := os.ReadFile(filepath.Join(repoRoot, filepath.FromSlash(path)))
+			if err != nil || len(strings.TrimSpace(string(content))) == 0 {
+				continue
+			}
+			if len(content) > maxInstructions {
+				content = append(content[:maxInstructions:maxInstructions], "\n[...]"...)
+			}
+			c.Instructions = append(c.Instructions, InstructionFile{Path: path, Content: string(content)})
+		}
+	}
+
+	loaded := func(path string) bool {
+		return slices.ContainsFunc(c.Instructions, func(f InstructionFile) bool { return f.Path == path })
+	}
+	c.InjectFiles = slices.DeleteFunc(c.InjectFiles, loaded)
+	c.GuidanceFiles = slices.DeleteFunc(c.GuidanceFiles, loaded)
+}
+
+// findFold returns the slash-separated path, relative to repoRoot, of the regular file in dir
+// whose path relative to dir matches name case-insensitively, or "" if there is none.
+func findFold(repoRoot, dir, name string) string {
+	for part := range strings.SplitSeq(name, "/") {
+		entries, err := os.ReadDir(filepath.Join(repoRoot, filepath.FromSlash(dir)))
+		if err != nil {
+			return ""
+		}
+		i := slices.IndexFunc(entries, func(e os.DirEntry) bool { return strings.EqualFold(e.Name(), part) })
+		if i < 0 {
+			return ""
+		}
+		dir = filepath.ToSlash(filepath.Join(dir, entries[i].Name()))
+	}
+	if fi, err := os.Stat(filepath.Join(repoRoot, filepath.FromSlash(dir))); err != nil || !fi.Mode().IsRegular() {
+		return ""
+	}
+	return dir
+}
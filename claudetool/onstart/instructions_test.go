@@ -0,0 +1,63 @@
+package onstart
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadInstructions(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"AGENTS.md":                        "root agents",
+		"claude.md":                        "root claude",
+		".sketch/instructions.md":          "root sketch",
+		"svc/AGENTS.md":                    "svc agents",
+		"svc/api/.sketch/instructions.md":  "api sketch",
+		"other/AGENTS.md":                  "not on the path",
+		"svc/api/handlers/CLAUDE.md":       "below the working directory",
+		"svc/api/.sketch/instructions.txt": "wrong name",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &Codebase{
+		InjectFiles:   []string{"AGENTS.md", "claude.md", "dear_llm.md"},
+		GuidanceFiles: []string{"svc/AGENTS.md", "other/AGENTS.md", "svc/api/handlers/CLAUDE.md"},
+	}
+	c.LoadInstructions(root, filepath.Join(root, "svc", "api"))
+
+	var got []string
+	for _, f := range c.Instructions {
+		got = append(got, f.Path+": "+f.Content)
+	}
+	want := []string{
+		"AGENTS.md: root agents",
+		"claude.md: root claude",
+		".sketch/instructions.md: root sketch",
+		"svc/AGENTS.md: svc agents",
+		"svc/api/.sketch/instructions.md: api sketch",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Instructions = %q, want %q", got, want)
+	}
+	if want := []string{"dear_llm.md"}; !slices.Equal(c.InjectFiles, want) {
+		t.Errorf("InjectFiles = %q, want %q", c.InjectFiles, want)
+	}
+	if want := []string{"other/AGENTS.md", "svc/api/handlers/CLAUDE.md"}; !slices.Equal(c.GuidanceFiles, want) {
+		t.Errorf("GuidanceFiles = %q, want %q", c.GuidanceFiles, want)
+	}
+
+	// A working directory outside the repository gets only the root's instructions.
+	c.LoadInstructions(root, t.TempDir())
+	if len(c.Instructions) != 3 {
+		t.Errorf("got %d instruction files outside the repository, want the root's 3", len(c.Instructions))
+	}
+}
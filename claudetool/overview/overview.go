@@ -0,0 +1,320 @@
+// Package overview summarizes a repository package by package, in the background, with a cheap model:
+// each package's purpose, key types, and entry points, cached under .sketch/overview
+// and regenerated only for packages whose files change.
+// The codebase_overview tool serves the summaries, so that an agent new to a large repository
+// can find its way around without reading dozens of files first.
+package overview
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm/conversation"
+)
+
+// Dir is where the summaries are cached, relative to the repository root.
+const Dir = ".sketch/overview"
+
+// Task is the task that summarizing runs as, so that an llm.Router can send it to a cheap model.
+const Task = "codebase-overview"
+
+const (
+	// formatVersion changes whenever the cache or the summaries' prompt does, so that old summaries are redone.
+	formatVersion = 1
+	// maxPackages is the most packages summarized; in larger repositories, the deepest are left out.
+	maxPackages = 1500
+	// parallelism is how many packages are summarized at once.
+	parallelism = 4
+	// debounce is how long Run waits for files to stop changing before it summarizes the changed packages.
+	// Summaries need not be current to the minute, and packages being edited change often.
+	debounce = time.Minute
+	// saveEvery is how many new summaries are made between saves of the cache.
+	saveEvery = 20
+)
+
+// sourceExts are the extensions of source files, whose directories are packages.
+var sourceExts = []string{
+	".go", ".py", ".js", ".jsx", ".ts", ".tsx", ".mjs", ".vue", ".svelte", ".rs", ".java", ".kt", ".scala",
+	".c", ".h", ".cc", ".cpp", ".hpp", ".cs", ".swift", ".m", ".rb", ".php", ".ex", ".exs", ".erl",
+	".lua", ".zig", ".dart", ".hs", ".ml", ".clj", ".sh", ".sql", ".proto",
+}
+
+// skipDirs are directories whose files are not the repository's own code.
+var skipDirs = []string{"vendor", "node_modules", "third_party", "testdata", "dist", "build"}
+
+// A Package is a directory of source files, and its summary.
+type Package struct {
+	Dir   string   `json:"dir"` // relative to the repository root, with forward slashes; "." for the root
+	Files []string `json:"files"`
+	Lines int      `json:"lines"`
+	// Hash fingerprints the files' names, sizes, and modification times, to tell when they change.
+	Hash    string `json:"hash"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// An Overview is the summaries of the packages in a repository.
+// Its methods may be called concurrently.
+type Overview struct {
+	root string
+	// newConvo returns a conversation to make summaries in, such as a sub-conversation of the agent's,
+	// so that their cost is counted with the session's.
+	newConvo func() *conversation.Convo
+
+	update  sync.Mutex          // held while updating
+	mu      sync.Mutex          // protects the fields below
+	pkgs    map[string]*Package // summarized packages, as they were when summarized
+	current map[string]*Package // packages as of the last scan, without summaries
+	changed chan struct{}
+}
+
+// cache is the summaries as cached on disk.
+type cache struct {
+	Version  int        `json:"version"`
+	Packages []*Package `json:"packages"`
+}
+
+// New returns the overview of the repository at root, which makes summaries in conversations from newConvo.
+// Summaries cached by earlier sessions are loaded; Update brings them up to date.
+func New(root string, newConvo func() *conversation.Convo) (*Overview, error) {
+	o := &Overview{
+		root:     root,
+		newConvo: newConvo,
+		pkgs:     make(map[string]*Package),
+		changed:  make(chan struct{}, 1),
+	}
+	data, err := os.ReadFile(o.path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c cache
+	if err := json.Unmarshal(data, &c); err != nil {
+		slog.Warn("codebase_overview_unreadable", "path", o.path(), "error", err)
+		return o, nil
+	}
+	if c.Version == formatVersion {
+		for _, p := range c.Packages {
+			o.pkgs[p.Dir] = p
+		}
+	}
+	return o, nil
+}
+
+func (o *Overview) path() string {
+	return filepath.Join(o.root, Dir, "summaries.json")
+}
+
+// save caches the summaries on disk, with a .gitignore so that git ignores them.
+func (o *Overview) save() error {
+	dir := filepath.Join(o.root, Dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*\n"), 0o644); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	c := cache{Version: formatVersion}
+	for _, p := range o.pkgs {
+		c.Packages = append(c.Packages, p)
+	}
+	slices.SortFunc(c.Packages, func(a, b *Package) int { return strings.Compare(a.Dir, b.Dir) })
+	data, err := json.MarshalIndent(c, "", "  ")
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := o.path() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path())
+}
+
+// Invalidate notes that files may have changed, so that Run summarizes the changed packages again
+// once files stop changing.
+func (o *Overview) Invalidate() {
+	select {
+	case o.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run keeps the summaries up to date until ctx is done: it updates them now,
+// and again whenever files stop changing for a while after Invalidate.
+func (o *Overview) Run(ctx context.Context) {
+	for {
+		if err := o.Update(ctx); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "codebase_overview_update_failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.changed:
+		}
+		for quiet := false; !quiet; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.changed:
+			case <-time.After(debounce):
+				quiet = true
+			}
+		}
+	}
+}
+
+// Update finds the repository's packages and summarizes those that are new or changed,
+// shallowest first, so that the top of the tree is described soonest.
+// Until a changed package is summarized again, its old summary stands.
+// A package that fails to be summarized is tried again at the next update.
+func (o *Overview) Update(ctx context.Context) error {
+	o.update.Lock()
+	defer o.update.Unlock()
+
+	scanned, err := scan(ctx, o.root)
+	if err != nil {
+		return err
+	}
+	var todo []*Package
+	o.mu.Lock()
+	o.current = scanned
+	for dir := range o.pkgs {
+		if _, ok := scanned[dir]; !ok {
+			delete(o.pkgs, dir)
+		}
+	}
+	for dir, p := range scanned {
+		if old := o.pkgs[dir]; old == nil || old.Hash != p.Hash {
+			todo = append(todo, p)
+		}
+	}
+	o.mu.Unlock()
+	if len(todo) == 0 {
+		return nil
+	}
+
+	slices.SortFunc(todo, func(a, b *Package) int {
+		return cmp.Or(cmp.Compare(depth(a.Dir), depth(b.Dir)), strings.Compare(a.Dir, b.Dir))
+	})
+	slog.InfoContext(ctx, "codebase_overview_updating", "packages", len(scanned), "summarizing", len(todo))
+	start := time.Now()
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, parallelism)
+		mu       sync.Mutex
+		done     int
+		failures int
+		lastErr  error
+	)
+	for _, p := range todo {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			summarized, err := o.summarize(ctx, p)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures++
+				lastErr = err
+				return
+			}
+			o.mu.Lock()
+			o.pkgs[p.Dir] = summarized
+			o.mu.Unlock()
+			done++
+			if done%saveEvery == 0 {
+				if err := o.save(); err != nil {
+					slog.WarnContext(ctx, "codebase_overview_save_failed", "error", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	slog.InfoContext(ctx, "codebase_overview_updated", "summarized", done, "failed", failures, "elapsed", time.Since(start))
+	if err := o.save(); err != nil {
+		return fmt.Errorf("caching the codebase overview: %w", err)
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d packages were not summarized: %w", failures, len(todo), lastErr)
+	}
+	return ctx.Err()
+}
+
+func depth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// scan returns the packages in the repository at root, by directory, without summaries:
+// the directories with source files that git tracks or does not ignore.
+func scan(ctx context.Context, root string) (map[string]*Package, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in %s: %w", root, err)
+	}
+	pkgs := make(map[string]*Package)
+	hashes := make(map[string]*strings.Builder)
+	for name := range strings.SplitSeq(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if !slices.Contains(sourceExts, path.Ext(name)) || slices.ContainsFunc(strings.Split(path.Dir(name), "/"), func(d string) bool {
+			return slices.Contains(skipDirs, d) || strings.HasPrefix(d, ".") && d != "."
+		}) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(root, name))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		dir := path.Dir(name)
+		p := pkgs[dir]
+		if p == nil {
+			p = &Package{Dir: dir}
+			pkgs[dir] = p
+			hashes[dir] = &strings.Builder{}
+		}
+		p.Files = append(p.Files, path.Base(name))
+		fmt.Fprintf(hashes[dir], "%s\x00%d\x00%d\n", name, info.Size(), info.ModTime().UnixNano())
+	}
+	if len(pkgs) > maxPackages {
+		dirs := make([]string, 0, len(pkgs))
+		for dir := range pkgs {
+			dirs = append(dirs, dir)
+		}
+		slices.SortFunc(dirs, func(a, b string) int { return cmp.Or(cmp.Compare(depth(a), depth(b)), strings.Compare(a, b)) })
+		slog.WarnContext(ctx, "codebase_overview_truncated", "packages", len(pkgs), "summarized", maxPackages)
+		for _, dir := range dirs[maxPackages:] {
+			delete(pkgs, dir)
+		}
+	}
+	for dir, p := range pkgs {
+		slices.Sort(p.Files)
+		sum := sha256.Sum256([]byte(hashes[dir].String()))
+		p.Hash = hex.EncodeToString(sum[:8])
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,162 @@
+package overview
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// summaryService is an llm.Service that summarizes each package as its name, recording the requests.
+type summaryService struct {
+	mu       sync.Mutex
+	packages []string
+	tasks    []string
+}
+
+var packagePattern = regexp.MustCompile(`<package>(.*)</package>`)
+
+func (s *summaryService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	pkg := packagePattern.FindStringSubmatch(req.Messages[0].Content[0].Text)[1]
+	s.mu.Lock()
+	s.packages = append(s.packages, pkg)
+	s.tasks = append(s.tasks, llm.TaskFromContext(ctx))
+	s.mu.Unlock()
+	return &llm.Response{
+		Role:       llm.MessageRoleAssistant,
+		Content:    []llm.Content{llm.StringContent("Purpose: the " + pkg + " package.\nKey types: none\nEntry points: none")},
+		StopReason: llm.StopReasonEndTurn,
+	}, nil
+}
+
+func (s *summaryService) TokenContextWindow() int { return 200000 }
+
+// summarized returns the packages summarized, sorted: they are summarized in parallel.
+func (s *summaryService) summarized() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(slices.Values(s.packages))
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOverview(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":                 "package main\n\nfunc main() {}\n",
+		"server/server.go":        "package server\n\n// Server serves.\ntype Server struct{}\n",
+		"server/server_test.go":   "package server\n",
+		"server/static/app.ts":    "export function start() {}\n",
+		"node_modules/x/index.js": "module.exports = 1\n",
+		"docs/README.md":          "# docs\n",
+	})
+	cmd := exec.Command("git", "init", "-q")
+	cmd.Dir = root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+
+	srv := &summaryService{}
+	convo := conversation.New(ctx, srv, nil)
+	o, err := New(root, func() *conversation.Convo { return convo })
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(input string) string {
+		t.Helper()
+		out, err := o.Tool().Run(ctx, json.RawMessage(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out[0].Text
+	}
+	if got := run(`{}`); !strings.Contains(got, "still being made") {
+		t.Errorf("overview before the first update =\n%s", got)
+	}
+
+	if err := o.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// node_modules and directories without source files are left out.
+	if got := strings.Join(srv.summarized(), " "); got != ". server server/static" {
+		t.Errorf("summarized %s, want . server server/static", got)
+	}
+	if srv.tasks[0] != Task {
+		t.Errorf("summaries ran as task %q, want %q", srv.tasks[0], Task)
+	}
+	want := "## server (2 files, 5 lines)\nPurpose: the server package.\nKey types: none\nEntry points: none\nFiles: server.go server_test.go"
+	if got := run(`{"path": "server"}`); !strings.HasPrefix(got, "2 packages, about 6 lines\n") || !strings.Contains(got, want) || strings.Contains(got, "## .") {
+		t.Errorf("overview of server =\n%s\nwant it to contain\n%s", got, want)
+	}
+
+	// Only changed packages are summarized again; until then, their old summaries stand.
+	writeFiles(t, root, map[string]string{"server/server.go": "package server\n\n// Server serves HTTP.\ntype Server struct{}\n"})
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(root, "server/server.go"), future, future)
+	o.mu.Lock()
+	o.current, _ = scan(ctx, root)
+	o.mu.Unlock()
+	if got := run(`{"path": "server/"}`); !strings.Contains(got, "(0 not yet summarized, 1 changed since summarized") || !strings.Contains(got, "## server (2 files, 5 lines) [changed since summarized]\nPurpose: the server package.") {
+		t.Errorf("overview with a changed package =\n%s", got)
+	}
+	if err := o.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(srv.summarized(), " "); got != ". server server server/static" {
+		t.Errorf("after changing server, summarized %s", got)
+	}
+
+	// Summaries are cached across sessions.
+	o, err = New(root, func() *conversation.Convo { return convo })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := run(`{"path": "server/static"}`); !strings.Contains(got, "Purpose: the server/static package.") {
+		t.Errorf("cached overview =\n%s", got)
+	}
+	if err := o.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.summarized()); n != 4 {
+		t.Errorf("updating cached summaries made %d new ones", n-4)
+	}
+	if got := run(`{"path": "docs"}`); got != "No packages of source files in docs." {
+		t.Errorf("overview of docs = %q", got)
+	}
+}
+
+func TestExcerptFile(t *testing.T) {
+	dir := t.TempDir()
+	var b strings.Builder
+	for range headLines {
+		b.WriteString("// header\n")
+	}
+	b.WriteString("func A() {\n\tbody()\n}\n\n// B does things.\nfunc B() {}\n")
+	writeFiles(t, dir, map[string]string{"a.go": b.String()})
+	got, n := excerptFile(filepath.Join(dir, "a.go"))
+	if n != headLines+6 || !strings.HasSuffix(got, "// header\nfunc A() {\nfunc B() {}") {
+		t.Errorf("excerptFile = %d lines,\n%s", n, got)
+	}
+}
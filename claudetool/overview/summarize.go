@@ -0,0 +1,143 @@
+package overview
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+const (
+	// maxExcerpt is the most source text sent to be summarized for one package,
+	// and maxFileExcerpt for one file of it.
+	maxExcerpt     = 24 << 10
+	maxFileExcerpt = 4 << 10
+	// headLines is how many lines at the top of each file are excerpted whole:
+	// package comments, imports, and the first declarations.
+	headLines = 30
+)
+
+const summarySystemPrompt = `You summarize one package of a codebase for a programmer new to it, from excerpts of its files:
+their first lines, and the lines that start top-level declarations.
+
+Reply in exactly this form, without commentary or Markdown headings:
+
+Purpose: one or two sentences on what the package is for and how it fits into the codebase.
+Key types: the most important types, each with a few words on what it is, comma-separated; or "none".
+Entry points: the functions, commands, handlers, or exported constructors through which the package is used; or "none".
+
+Be concrete: name the identifiers as they appear in the code. Do not guess at what the excerpts do not show.`
+
+// summarize asks the LLM to summarize p, returning p with its line count and summary.
+func (o *Overview) summarize(ctx context.Context, p *Package) (*Package, error) {
+	excerpt, lines := excerpt(o.root, p)
+	convo := o.newConvo()
+	if convo == nil {
+		return nil, fmt.Errorf("no conversation to summarize %s in", p.Dir)
+	}
+	sub := convo.SubConvo()
+	sub.Hidden = true
+	sub.PromptCaching = false
+	sub.Task = Task
+	sub.SystemPrompt = summarySystemPrompt
+	resp, err := sub.SendMessageContext(ctx, llm.UserStringMessage(fmt.Sprintf("<package>%s</package>\n\n%s", p.Dir, excerpt)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize %s: %w", p.Dir, err)
+	}
+	var summary strings.Builder
+	for _, c := range resp.Content {
+		if c.Type == llm.ContentTypeText {
+			summary.WriteString(c.Text)
+		}
+	}
+	if strings.TrimSpace(summary.String()) == "" {
+		return nil, fmt.Errorf("empty summary of %s", p.Dir)
+	}
+	s := *p
+	s.Lines = lines
+	s.Summary = strings.TrimSpace(summary.String())
+	return &s, nil
+}
+
+// isTest reports whether name is a test file, by the conventions of common languages.
+func isTest(name string) bool {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	return strings.HasSuffix(base, "_test") || strings.HasPrefix(base, "test_") ||
+		strings.HasSuffix(base, ".test") || strings.HasSuffix(base, ".spec")
+}
+
+// excerpt returns excerpts of p's files, each with its name, and their total number of lines.
+// Test files come last, and once the excerpt is full, files are listed by name only.
+func excerpt(root string, p *Package) (string, int) {
+	files := slices.Clone(p.Files)
+	slices.SortStableFunc(files, func(a, b string) int {
+		switch {
+		case isTest(a) == isTest(b):
+			return 0
+		case isTest(a):
+			return 1
+		}
+		return -1
+	})
+	var b strings.Builder
+	var lines int
+	var omitted []string
+	for _, name := range files {
+		text, n := excerptFile(filepath.Join(root, filepath.FromSlash(p.Dir), name))
+		lines += n
+		if b.Len()+len(text) > maxExcerpt {
+			omitted = append(omitted, name)
+			continue
+		}
+		fmt.Fprintf(&b, "<file name=%q lines=\"%d\">\n%s\n</file>\n", name, n, text)
+	}
+	if len(omitted) > 0 {
+		fmt.Fprintf(&b, "<omitted_files>%s</omitted_files>\n", strings.Join(omitted, " "))
+	}
+	return b.String(), lines
+}
+
+// excerptFile returns the first lines of the file at name and the lines after them
+// that start top-level declarations, up to maxFileExcerpt, and how many lines the file has.
+func excerptFile(name string) (string, int) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", 0
+	}
+	defer f.Close()
+	var b strings.Builder
+	var n int
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		n++
+		line := s.Text()
+		if b.Len()+len(line) >= maxFileExcerpt {
+			continue // keep counting lines
+		}
+		if n <= headLines || declaration(line) {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	return strings.TrimRight(b.String(), "\n"), n
+}
+
+// declaration reports whether line likely starts a top-level declaration:
+// it is not indented, and is neither a closing bracket nor a comment.
+func declaration(line string) bool {
+	if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "/*") || strings.HasPrefix(line, "*") {
+		return false
+	}
+	switch line[0] {
+	case ' ', '\t', '}', ')', ']':
+		return false
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package overview
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// maxOutput is the most text codebase_overview returns. Past it, only each package's purpose is shown,
+// and past that, the output is cut short, with a note to narrow the path.
+const maxOutput = 48 << 10
+
+// Tool returns an llm.Tool that shows o's summaries.
+func (o *Overview) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        Name,
+		Description: strings.TrimSpace(Description),
+		InputSchema: llm.MustSchema(InputSchema),
+		Run:         o.run,
+	}
+}
+
+const (
+	Name        = "codebase_overview"
+	Description = `
+Shows a summary of each package in the repository: its purpose, key types, and entry points,
+along with its files and size. Summaries are made in the background and kept up to date as files change;
+packages not yet summarized are listed by their files.
+
+Use it at the start of work in an unfamiliar or large codebase, before reading files,
+to decide where to look. Narrow it to a subtree with path.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	InputSchema = `
+{
+  "type": "object",
+  "properties": {
+    "path": {
+      "type": "string",
+      "description": "Only show packages in this directory and below, relative to the repository root"
+    }
+  }
+}
+`
+)
+
+func (o *Overview) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var input struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(m, &input); err != nil {
+		return nil, err
+	}
+	dir := strings.TrimPrefix(path.Clean("/"+strings.TrimSpace(input.Path)), "/")
+	if dir == "" {
+		dir = "."
+	}
+	out := o.render(dir, false)
+	if len(out) > maxOutput {
+		out = o.render(dir, true)
+	}
+	if len(out) > maxOutput {
+		cut := strings.LastIndex(out[:maxOutput], "\n## ")
+		if cut < 0 {
+			cut = maxOutput
+		}
+		out = out[:cut] + "\n\n[The overview was cut short. Call codebase_overview again with a path to see the rest.]"
+	}
+	slog.InfoContext(ctx, "codebase_overview_shown", "path", dir, "bytes", len(out))
+	return llm.TextContent(out), nil
+}
+
+// render returns the overview of the packages in dir and below; if brief, with only their purposes.
+func (o *Overview) render(dir string, brief bool) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	in := func(p string) bool { return dir == "." || p == dir || strings.HasPrefix(p, dir+"/") }
+
+	// Before the first scan, show what was summarized in earlier sessions.
+	current := o.current
+	if current == nil {
+		current = o.pkgs
+	}
+	var dirs []string
+	for d := range current {
+		if in(d) {
+			dirs = append(dirs, d)
+		}
+	}
+	if len(dirs) == 0 {
+		if o.current == nil {
+			return "The codebase overview is still being made; try again shortly, or explore with other tools meanwhile."
+		}
+		return fmt.Sprintf("No packages of source files in %s.", dir)
+	}
+	slices.Sort(dirs)
+
+	var b strings.Builder
+	var pending, stale, lines int
+	for _, d := range dirs {
+		p, s := current[d], o.pkgs[d]
+		switch {
+		case s == nil:
+			pending++
+		case s.Hash != p.Hash:
+			stale++
+		}
+		if s != nil {
+			lines += s.Lines
+		}
+	}
+	fmt.Fprintf(&b, "%d packages", len(dirs))
+	if lines > 0 {
+		fmt.Fprintf(&b, ", about %d lines", lines)
+	}
+	if pending > 0 || stale > 0 {
+		fmt.Fprintf(&b, " (%d not yet summarized, %d changed since summarized; summarizing continues in the background)", pending, stale)
+	}
+	if brief {
+		b.WriteString("\nShowing only each package's purpose; narrow the path for key types and entry points.")
+	}
+	b.WriteString("\n")
+
+	for _, d := range dirs {
+		p, s := current[d], o.pkgs[d]
+		fmt.Fprintf(&b, "\n## %s (%d files", d, len(p.Files))
+		if s != nil && s.Lines > 0 {
+			fmt.Fprintf(&b, ", %d lines", s.Lines)
+		}
+		b.WriteString(")")
+		if s != nil && s.Hash != p.Hash {
+			b.WriteString(" [changed since summarized]")
+		}
+		b.WriteString("\n")
+		switch {
+		case s == nil:
+			fmt.Fprintf(&b, "Files: %s\n", strings.Join(p.Files, " "))
+		case brief:
+			purpose, _, _ := strings.Cut(s.Summary, "\n")
+			fmt.Fprintf(&b, "%s\n", purpose)
+		default:
+			fmt.Fprintf(&b, "%s\nFiles: %s\n", s.Summary, strings.Join(p.Files, " "))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
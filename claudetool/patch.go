@@ -11,11 +11,17 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"sketch.dev/claudetool/editbuf"
 	"sketch.dev/claudetool/patchkit"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/claudetool/undo"
 	"sketch.dev/llm"
+	"sketch.dev/statedb"
 )
 
 // PatchCallback defines the signature for patch tool callbacks.
@@ -24,17 +30,43 @@ import (
 // and returns a new, possibly altered tool call result.
 type PatchCallback func(input PatchInput, result []llm.Content, err error) ([]llm.Content, error)
 
-// Patch creates a patch tool. The callback may be nil.
-func Patch(callback PatchCallback) *llm.Tool {
+// PatchTool specifies an llm.Tool for modifying files.
+type PatchTool struct {
+	// Callback, if non-nil, runs after every patch.
+	Callback PatchCallback
+	// Stage, if non-nil, receives all file modifications instead of the disk.
+	// They are written only once a human approves them.
+	Stage *staging.Area
+	// BackupDir is where rewrite operations save the contents they replace.
+	// If empty, the backups directory of State's session is used, or, without State,
+	// a sketch-backups directory under os.TempDir.
+	BackupDir string
+	// State, if set, indexes the backups.
+	State *statedb.Session
+	// Versions, if set, refuses patches to files that changed since the agent last read them.
+	Versions *FileVersions
+	// Journal, if set, records the patches written to disk, so that they can be undone.
+	Journal *undo.Journal
+
+	mu sync.Mutex
+	// failures counts consecutive failed patches per path,
+	// to suggest a rewrite when diff-based edits keep failing.
+	failures map[string]int
+}
+
+// Tool returns an llm.Tool based on p.
+func (p *PatchTool) Tool() *llm.Tool {
 	return &llm.Tool{
 		Name:        PatchName,
 		Description: strings.TrimSpace(PatchDescription),
 		InputSchema: llm.MustSchema(PatchInputSchema),
+		// Patches read and then write their file; concurrent patches to one file would lose edits.
+		Serial: true,
 		Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
 			var input PatchInput
-			result, err := patchRun(ctx, m, &input)
-			if callback != nil {
-				return callback(input, result, err)
+			result, err := p.patchRun(ctx, m, &input)
+			if p.Callback != nil {
+				return p.Callback(input, result, err)
 			}
 			return result, err
 		},
@@ -51,10 +83,15 @@ Operations:
 - append_eof: Append new text at the end of the file
 - prepend_bof: Insert new text at the beginning of the file
 - overwrite: Replace the entire file with new content (automatically creates the file)
+- rewrite: Replace an existing file with new content, with safeguards; prefer this over overwrite for existing files
 
 Usage notes:
 - All inputs are interpreted literally (no automatic newline or whitespace handling)
 - For replace operations, oldText must appear EXACTLY ONCE in the file
+- When replace operations on a heavily modified file keep failing, rewrite it instead
+- A rewrite must be the only patch in its request, and newText must be the COMPLETE file: never elide unchanged code
+- A rewrite is rejected if it shrinks the file drastically or breaks the syntax of a Go or JSON file; the previous contents are backed up
+- Patches to a file that changed since you last read it, such as by the user, are refused with its current contents; redo your edits on them
 `
 
 	// If you modify this, update the termui template for prettier rendering.
@@ -76,7 +113,7 @@ Usage notes:
         "properties": {
           "operation": {
             "type": "string",
-            "enum": ["replace", "append_eof", "prepend_bof", "overwrite"],
+            "enum": ["replace", "append_eof", "prepend_bof", "overwrite", "rewrite"],
             "description": "Type of operation to perform"
           },
           "oldText": {
@@ -110,9 +147,42 @@ type PatchRequest struct {
 	NewText   string `json:"newText,omitempty"`
 }
 
+// Rewrite safeguards: a rewrite may not shrink a file of at least rewriteMinCheckSize bytes
+// to less than rewriteMinRatio of its size. Such a shrink usually means
+// that the model elided unchanged code ("// ... rest unchanged").
+const (
+	rewriteMinCheckSize = 1024
+	rewriteMinRatio     = 0.5
+)
+
+// suggestRewriteAfter is the number of consecutive failed patches to a file
+// after which the patch tool suggests rewriting it whole.
+const suggestRewriteAfter = 2
+
 // patchRun implements the guts of the patch tool.
 // It populates input from m.
-func patchRun(ctx context.Context, m json.RawMessage, input *PatchInput) ([]llm.Content, error) {
+func (p *PatchTool) patchRun(ctx context.Context, m json.RawMessage, input *PatchInput) ([]llm.Content, error) {
+	result, err := p.patchRunOnce(ctx, m, input)
+	if input.Path == "" {
+		return result, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		delete(p.failures, input.Path)
+		return result, nil
+	}
+	if p.failures == nil {
+		p.failures = make(map[string]int)
+	}
+	p.failures[input.Path]++
+	if n := p.failures[input.Path]; n >= suggestRewriteAfter {
+		err = fmt.Errorf("%w\n\nPatches to this file have now failed %d times in a row. Consider a single rewrite operation with the complete new contents of the file instead", err, n)
+	}
+	return result, err
+}
+
+func (p *PatchTool) patchRunOnce(ctx context.Context, m json.RawMessage, input *PatchInput) ([]llm.Content, error) {
 	if err := json.Unmarshal(m, &input); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal user_patch input: %w", err)
 	}
@@ -124,9 +194,13 @@ func patchRun(ctx context.Context, m json.RawMessage, input *PatchInput) ([]llm.
 	if len(input.Patches) == 0 {
 		return nil, fmt.Errorf("no patches provided")
 	}
+	rewrite := slices.ContainsFunc(input.Patches, func(patch PatchRequest) bool { return patch.Operation == "rewrite" })
+	if rewrite && len(input.Patches) > 1 {
+		return nil, fmt.Errorf("a rewrite must be the only patch in a request; it replaces the whole file")
+	}
 	// TODO: check whether the file is autogenerated, and if so, require a "force" flag to modify it.
 
-	orig, err := os.ReadFile(input.Path)
+	orig, err := p.readFile(ctx, input.Path)
 	// If the file doesn't exist, we can still apply patches
 	// that don't require finding existing text.
 	switch {
@@ -134,6 +208,8 @@ func patchRun(ctx context.Context, m json.RawMessage, input *PatchInput) ([]llm.
 		for _, patch := range input.Patches {
 			switch patch.Operation {
 			case "prepend_bof", "append_eof", "overwrite":
+			case "rewrite":
+				return nil, fmt.Errorf("file %q does not exist; use overwrite to create it", input.Path)
 			default:
 				return nil, fmt.Errorf("file %q does not exist", input.Path)
 			}
@@ -141,6 +217,9 @@ func patchRun(ctx context.Context, m json.RawMessage, input *PatchInput) ([]llm.
 	case err != nil:
 		return nil, fmt.Errorf("failed to read file %q: %w", input.Path, err)
 	}
+	if err := p.Versions.Check(input.Path, orig, err == nil); err != nil {
+		return nil, err
+	}
 
 	likelyGoFile := strings.HasSuffix(input.Path, ".go")
 
@@ -164,7 +243,7 @@ func patchRun(ctx context.Context, m json.RawMessage, input *PatchInput) ([]llm.
 			buf.Insert(0, patch.NewText)
 		case "append_eof":
 			buf.Insert(len(orig), patch.NewText)
-		case "overwrite":
+		case "overwrite", "rewrite":
 			buf.Replace(0, len(orig), patch.NewText)
 		case "replace":
 			if patch.OldText == "" {
@@ -243,15 +322,32 @@ func patchRun(ctx context.Context, m json.RawMessage, input *PatchInput) ([]llm.
 	if err != nil {
 		return nil, err
 	}
-	if err := os.MkdirAll(filepath.Dir(input.Path), 0o700); err != nil {
-		return nil, fmt.Errorf("failed to create directory %q: %w", filepath.Dir(input.Path), err)
+	var backup string
+	if rewrite {
+		if err := checkRewrite(input.Path, orig, patched); err != nil {
+			return nil, err
+		}
+		backup, err = p.backupFile(input.Path, orig)
+		if err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "patch_rewrite", "path", input.Path, "old_size", len(orig), "new_size", len(patched), "backup", backup)
 	}
-	if err := os.WriteFile(input.Path, patched, 0o600); err != nil {
-		return nil, fmt.Errorf("failed to write patched contents to file %q: %w", input.Path, err)
+	if err := p.writeFile(ctx, input.Path, patched); err != nil {
+		return nil, err
 	}
+	p.Versions.Saw(input.Path, patched)
 
 	response := new(strings.Builder)
-	fmt.Fprintf(response, "- Applied all patches\n")
+	if backup != "" {
+		fmt.Fprintf(response, "- Rewrote the file; its previous contents are saved in %s\n", backup)
+	}
+	if p.Stage != nil && !p.Stage.AutoApproving() {
+		fmt.Fprintf(response, "- Staged all patches; they will be written to disk once the user approves them\n")
+		fmt.Fprintf(response, "- Until then, shell commands see the unmodified file\n")
+	} else {
+		fmt.Fprintf(response, "- Applied all patches\n")
+	}
 
 	if parsed {
 		parseErr := parseGo(patched)
@@ -268,6 +364,104 @@ func patchRun(ctx context.Context, m json.RawMessage, input *PatchInput) ([]llm.
 	return llm.TextContent(response.String()), nil
 }
 
+// checkRewrite reports whether replacing orig with patched, the contents of path, looks like a mistake:
+// a drastic shrink, or a file of a known language that parsed before and no longer does.
+func checkRewrite(path string, orig, patched []byte) error {
+	if len(orig) >= rewriteMinCheckSize && float64(len(patched)) < rewriteMinRatio*float64(len(orig)) {
+		return fmt.Errorf("rewrite would shrink %q from %d to %d bytes, which usually means unchanged code was left out; "+
+			"include the complete file in newText, or use overwrite if the shrink is intended", path, len(orig), len(patched))
+	}
+	if validateSyntax(path, orig) != nil {
+		// Broken already; a rewrite may be how it gets fixed.
+		return nil
+	}
+	if err := validateSyntax(path, patched); err != nil {
+		return fmt.Errorf("rewrite rejected: the new contents of %q do not parse:\n%w", path, err)
+	}
+	return nil
+}
+
+// validateSyntax checks data, the contents of path, for syntax errors,
+// if path's extension names a language it knows.
+func validateSyntax(path string, data []byte) error {
+	switch filepath.Ext(path) {
+	case ".go":
+		return parseGo(data)
+	case ".json":
+		var v any
+		return json.Unmarshal(data, &v)
+	}
+	return nil
+}
+
+// backupFile saves data, the contents of path before a rewrite, and returns where.
+func (p *PatchTool) backupFile(path string, data []byte) (string, error) {
+	dir := p.BackupDir
+	if dir == "" && p.State != nil {
+		var err error
+		if dir, err = p.State.Dir("backups"); err != nil {
+			return "", err
+		}
+	}
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "sketch-backups")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory %q: %w", dir, err)
+	}
+	now := time.Now()
+	name := strings.ReplaceAll(strings.TrimPrefix(filepath.Clean(path), string(filepath.Separator)), string(filepath.Separator), "_")
+	backup := filepath.Join(dir, name+"."+now.Format("20060102T150405.000000000"))
+	if err := os.WriteFile(backup, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to back up %q: %w", path, err)
+	}
+	if p.State != nil {
+		err := p.State.AddArtifact(statedb.Artifact{Path: backup, Kind: statedb.ArtifactBackup, Source: path, Created: now})
+		if err != nil {
+			// The backup itself is intact.
+			slog.Warn("patch_backup_index_failed", "path", path, "err", err)
+		}
+	}
+	return backup, nil
+}
+
+// readFile reads path, preferring staged contents when staging is enabled.
+func (p *PatchTool) readFile(ctx context.Context, path string) ([]byte, error) {
+	if p.Stage != nil {
+		return p.Stage.ReadFile(path)
+	}
+	if e := ExecutorOf(ctx); e != nil {
+		return e.ReadFile(ctx, path)
+	}
+	return os.ReadFile(path)
+}
+
+// writeFile writes data to path, or stages it when staging is enabled.
+// Files on a remote host are not recorded in the Journal, which undoes changes on this machine.
+func (p *PatchTool) writeFile(ctx context.Context, path string, data []byte) error {
+	if p.Stage != nil {
+		return p.Stage.WriteFile(path, data)
+	}
+	if e := ExecutorOf(ctx); e != nil {
+		if err := e.WriteFile(ctx, path, data); err != nil {
+			return fmt.Errorf("failed to write patched contents to file %q: %w", path, err)
+		}
+		return nil
+	}
+	before, err := os.ReadFile(path)
+	existed := err == nil
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write patched contents to file %q: %w", path, err)
+	}
+	if err := p.Journal.Record(path, before, existed, data); err != nil {
+		slog.Warn("patch_undo_record_failed", "path", path, "err", err)
+	}
+	return nil
+}
+
 func parseGo(buf []byte) error {
 	fset := token.NewFileSet()
 	_, err := parser.ParseFile(fset, "", buf, parser.SkipObjectResolution)
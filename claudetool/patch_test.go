@@ -0,0 +1,208 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+	"sketch.dev/llm/llmtest"
+)
+
+func runPatch(t *testing.T, p *PatchTool, path string, patches ...PatchRequest) error {
+	t.Helper()
+	m, err := json.Marshal(PatchInput{Path: path, Patches: patches})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Tool().Run(context.Background(), m)
+	return err
+}
+
+func TestPatchRewrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	orig := "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"
+	if err := os.WriteFile(path, []byte(orig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := &PatchTool{BackupDir: filepath.Join(dir, "backups")}
+
+	rewritten := "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n"
+	if err := runPatch(t, p, path, PatchRequest{Operation: "rewrite", NewText: rewritten}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != rewritten {
+		t.Errorf("file = %q, want %q", got, rewritten)
+	}
+	backups, _ := os.ReadDir(p.BackupDir)
+	if len(backups) != 1 {
+		t.Fatalf("got %d backups, want 1", len(backups))
+	}
+	if got, _ := os.ReadFile(filepath.Join(p.BackupDir, backups[0].Name())); string(got) != orig {
+		t.Errorf("backup = %q, want the original contents", got)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		patches []PatchRequest
+		wantErr string
+	}{
+		{"syntax error", path, []PatchRequest{{Operation: "rewrite", NewText: "package main\n\nfunc main() {\n"}}, "do not parse"},
+		{"mixed", path, []PatchRequest{{Operation: "rewrite", NewText: rewritten}, {Operation: "append_eof", NewText: "\n"}}, "only patch"},
+		{"missing file", filepath.Join(dir, "new.go"), []PatchRequest{{Operation: "rewrite", NewText: rewritten}}, "use overwrite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runPatch(t, &PatchTool{BackupDir: t.TempDir()}, tt.path, tt.patches...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+	if got, _ := os.ReadFile(path); string(got) != rewritten {
+		t.Errorf("rejected rewrites changed the file to %q", got)
+	}
+}
+
+func TestPatchRewriteShrink(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	orig := strings.Repeat("a line of notes\n", 200)
+	if err := os.WriteFile(path, []byte(orig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := &PatchTool{BackupDir: t.TempDir()}
+	err := runPatch(t, p, path, PatchRequest{Operation: "rewrite", NewText: "a line of notes\n// ... rest unchanged\n"})
+	if err == nil || !strings.Contains(err.Error(), "shrink") {
+		t.Fatalf("err = %v, want a shrink error", err)
+	}
+	// overwrite is the escape hatch for intended shrinks.
+	if err := runPatch(t, p, path, PatchRequest{Operation: "overwrite", NewText: "short\n"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPatchSuggestsRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.txt")
+	if err := os.WriteFile(path, []byte("hello\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := &PatchTool{}
+	bad := PatchRequest{Operation: "replace", OldText: "goodbye", NewText: "farewell"}
+	if err := runPatch(t, p, path, bad); err == nil || strings.Contains(err.Error(), "rewrite") {
+		t.Fatalf("first failure: err = %v, want a plain failure", err)
+	}
+	if err := runPatch(t, p, path, bad); err == nil || !strings.Contains(err.Error(), "rewrite") {
+		t.Fatalf("second failure: err = %v, want a rewrite suggestion", err)
+	}
+	if err := runPatch(t, p, path, PatchRequest{Operation: "replace", OldText: "hello", NewText: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPatch(t, p, path, bad); err == nil || strings.Contains(err.Error(), "rewrite") {
+		t.Fatalf("after a success: err = %v, want the count reset", err)
+	}
+}
+
+func TestPatchConflict(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte("one\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	versions := &FileVersions{}
+	b := &BashTool{Versions: versions}
+	p := &PatchTool{Versions: versions}
+	ctx := WithWorkingDir(context.Background(), dir)
+
+	// The agent reads the file, and then edits it, more than once.
+	b.sawFiles(ctx, "cat notes.txt")
+	for _, text := range []string{"two\n", "three\n"} {
+		if err := runPatch(t, p, path, PatchRequest{Operation: "append_eof", NewText: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Changes by the agent's own commands are not conflicts.
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\nfour\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b.sawFiles(ctx, "echo four >> notes.txt")
+	if err := runPatch(t, p, path, PatchRequest{Operation: "append_eof", NewText: "five\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The user edits the file.
+	edited := "zero\none\ntwo\nthree\nfour\nfive\n"
+	if err := os.WriteFile(path, []byte(edited+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := runPatch(t, p, path, PatchRequest{Operation: "append_eof", NewText: "six\n"})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Content != edited+"\n" || !strings.Contains(err.Error(), "zero\none") {
+		t.Fatalf("err = %v, want a conflict with the edited contents", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != edited+"\n" {
+		t.Errorf("file = %q after a conflict, want it unchanged", got)
+	}
+	// The conflict showed the agent the new contents, so its next edit applies.
+	if err := runPatch(t, p, path, PatchRequest{Operation: "append_eof", NewText: "six\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The user deletes the file.
+	os.Remove(path)
+	err = runPatch(t, p, path, PatchRequest{Operation: "overwrite", NewText: "seven\n"})
+	if !errors.As(err, &conflict) || !conflict.Deleted {
+		t.Fatalf("err = %v, want a conflict for the deleted file", err)
+	}
+
+	// Files the agent never saw have no conflicts.
+	other := filepath.Join(dir, "other.txt")
+	os.WriteFile(other, []byte("x\n"), 0o600)
+	if err := runPatch(t, p, other, PatchRequest{Operation: "append_eof", NewText: "y\n"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestPatchThenRead checks that a command the model runs after a patch, in the same response, sees the patched file.
+func TestPatchThenRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.txt")
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	patch := (&PatchTool{BackupDir: t.TempDir()}).Tool()
+	run := patch.Run
+	patch.Run = func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+		time.Sleep(100 * time.Millisecond) // a slow patch, so that the read would overtake it
+		return run(ctx, m)
+	}
+	convo := conversation.New(context.Background(), llmtest.NewService(), nil)
+	convo.Tools = []*llm.Tool{patch, (&BashTool{}).Tool()}
+
+	patchInput, err := json.Marshal(PatchInput{Path: path, Patches: []PatchRequest{{Operation: "replace", OldText: "old", NewText: "new"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bashInput, err := json.Marshal(map[string]string{"command": "cat " + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &llm.Response{StopReason: llm.StopReasonToolUse, Content: []llm.Content{
+		{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: PatchName, ToolInput: patchInput},
+		{Type: llm.ContentTypeToolUse, ID: "t2", ToolName: bashName, ToolInput: bashInput},
+	}}
+	results, _, err := convo.ToolResultContents(context.Background(), resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].ToolError || results[1].ToolError || results[1].ToolResult[0].Text != "new\n" {
+		t.Errorf("results = %+v, want the read to see the patched file", results)
+	}
+}
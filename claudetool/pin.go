@@ -0,0 +1,62 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// The PinResults tool marks the results of the model's most recent tool calls as important,
+// so that they are kept verbatim when the conversation is compacted.
+var PinResults = &llm.Tool{
+	Name:        pinResultsName,
+	Description: pinResultsDescription,
+	InputSchema: llm.MustSchema(pinResultsInputSchema),
+	Run:         pinResultsRun,
+}
+
+const (
+	pinResultsName        = "pin_results"
+	pinResultsDescription = `Marks the results of your most recent tool calls as important.
+When the conversation nears the context window, older history is replaced by a summary,
+but pinned results are kept word for word. Pin results you will need exactly later,
+such as a specification, an error you are fixing, or output you must match.
+Only results you have already received can be pinned; call this tool on its own, after them.`
+
+	// If you modify this, update the termui template for prettier rendering.
+	pinResultsInputSchema = `
+{
+  "type": "object",
+  "properties": {
+    "count": {
+      "type": "integer",
+      "description": "How many of the most recent tool results to pin; defaults to 1"
+    }
+  }
+}
+`
+)
+
+func pinResultsRun(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var input struct {
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal(m, &input); err != nil {
+		return nil, err
+	}
+	if input.Count <= 0 {
+		input.Count = 1
+	}
+	convo := conversation.ToolCallInfoFromContext(ctx).Convo
+	if convo == nil {
+		return nil, fmt.Errorf("pin_results is only available in a conversation")
+	}
+	n := convo.PinRecentToolResults(input.Count)
+	if n == 0 {
+		return nil, fmt.Errorf("there are no tool results to pin")
+	}
+	return llm.TextContent(fmt.Sprintf("pinned %d tool results", n)), nil
+}
@@ -61,6 +61,8 @@ func representativeCommitSHAs(ctx context.Context, repoRoot string) ([]string, s
 	sub := info.Convo.SubConvo()
 	sub.Hidden = true
 	sub.PromptCaching = false
+	sub.CacheResponses = true // same commits, same style
+	sub.Task = "commit-style"
 
 	sub.SystemPrompt = `Analyze the provided git commit messages to identify consistent patterns, including but not limited to:
 - Formatting conventions
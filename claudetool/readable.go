@@ -0,0 +1,469 @@
+package claudetool
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// readableMarkdown returns the title of an HTML page and its main content as Markdown,
+// without navigation, sidebars, scripts, and other boilerplate.
+// Relative links are resolved against base.
+func readableMarkdown(page []byte, base *url.URL) (title, markdown string) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		// html.Parse accepts anything; it fails only if reading fails.
+		return "", ""
+	}
+	if t := findNode(doc, func(n *html.Node) bool { return n.DataAtom == atom.Title }); t != nil {
+		title = collapseSpace(nodeText(t))
+	}
+	root, inBody := contentRoot(doc)
+	w := &mdWriter{base: base, inBody: inBody, breaks: 2}
+	w.children(root)
+	return title, strings.TrimSpace(blankLines.ReplaceAllString(w.buf.String(), "\n\n"))
+}
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// contentRoot returns the element that holds a page's main content: its main element,
+// or else its longest article, or else its body, which is reported by inBody.
+func contentRoot(doc *html.Node) (root *html.Node, inBody bool) {
+	if main := findNode(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Main || attr(n, "role") == "main"
+	}); main != nil {
+		return main, false
+	}
+	var best *html.Node
+	bestLen := 0
+	walkNodes(doc, func(n *html.Node) {
+		if n.DataAtom == atom.Article {
+			if l := len(nodeText(n)); l > bestLen {
+				best, bestLen = n, l
+			}
+		}
+	})
+	if best != nil {
+		return best, false
+	}
+	if body := findNode(doc, func(n *html.Node) bool { return n.DataAtom == atom.Body }); body != nil {
+		return body, true
+	}
+	return doc, true
+}
+
+// boilerplateClass matches the classes and ids of elements that are not a page's content.
+var boilerplateClass = regexp.MustCompile(`(?i)(^|[-_\s])(nav|navbar|navigation|menu|sidebar|footer|cookies?|consent|banner|breadcrumbs?|advert|advertisement|ads|share|social|skip-link)($|[-_\s])`)
+
+// skip reports whether n is boilerplate, whose text is left out.
+func (w *mdWriter) skip(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg, atom.Iframe, atom.Object,
+		atom.Nav, atom.Aside, atom.Footer, atom.Form, atom.Button, atom.Select, atom.Input, atom.Textarea, atom.Dialog:
+		return true
+	case atom.Header:
+		// An article's header holds its title; the page's holds the site's navigation.
+		return w.inBody
+	}
+	if _, hidden := attrOK(n, "hidden"); hidden || attr(n, "aria-hidden") == "true" {
+		return true
+	}
+	switch attr(n, "role") {
+	case "navigation", "banner", "contentinfo", "complementary", "search", "menu", "dialog":
+		return true
+	}
+	return boilerplateClass.MatchString(attr(n, "class")) || boilerplateClass.MatchString(attr(n, "id"))
+}
+
+// mdWriter writes HTML as Markdown.
+type mdWriter struct {
+	buf    strings.Builder
+	base   *url.URL
+	inBody bool // the content root is the page's body, so its header is boilerplate
+
+	prefix string // written at the start of each line: blockquote markers and list indentation
+	last   string // the prefix of the last line written
+	marker string // a list item's marker, written with its first line, in place of the end of prefix
+	breaks int    // newlines owed before the next text: 1 to start a line, 2 to start a block
+	space  bool   // a space is owed before the next text
+	lists  int    // depth of lists
+}
+
+// block starts a new block, unless a list item has yet to write its first line.
+func (w *mdWriter) block() {
+	if w.marker == "" {
+		w.breaks = 2
+	}
+}
+
+// line starts a new line.
+func (w *mdWriter) line() {
+	w.breaks = max(w.breaks, 1)
+}
+
+// startLine writes the newlines and prefix that the next text needs.
+func (w *mdWriter) startLine() {
+	if w.breaks == 0 {
+		if w.space {
+			w.buf.WriteString(" ")
+			w.space = false
+		}
+		return
+	}
+	if w.buf.Len() > 0 {
+		// Empty lines belong to both the block before them and the one after.
+		between := strings.TrimRight(commonPrefix(w.last, w.prefix), " ")
+		for i := range w.breaks {
+			w.buf.WriteString("\n")
+			if i < w.breaks-1 {
+				w.buf.WriteString(between)
+			}
+		}
+	}
+	w.last = w.prefix
+	if w.marker != "" {
+		w.buf.WriteString(w.prefix[:len(w.prefix)-len(w.marker)] + w.marker)
+		w.marker = ""
+	} else {
+		w.buf.WriteString(w.prefix)
+	}
+	w.breaks = 0
+	w.space = false
+}
+
+// text writes inline text, collapsing its white space.
+func (w *mdWriter) text(s string) {
+	if s == "" {
+		return
+	}
+	words := strings.Fields(s)
+	if isSpace(s[0]) {
+		w.space = true
+	}
+	if len(words) == 0 {
+		return
+	}
+	w.startLine()
+	w.buf.WriteString(strings.Join(words, " "))
+	w.space = isSpace(s[len(s)-1])
+}
+
+// inline writes s, which is Markdown such as a link, as a single word.
+func (w *mdWriter) inline(s string) {
+	w.startLine()
+	w.buf.WriteString(s)
+}
+
+// lines writes s, whose lines are kept as they are, each with the prefix.
+func (w *mdWriter) lines(s string) {
+	for i, l := range strings.Split(s, "\n") {
+		if i > 0 {
+			w.line()
+		}
+		if l == "" {
+			// Owe the empty line, which startLine writes without a trailing prefix.
+			w.breaks++
+			continue
+		}
+		w.inline(l)
+	}
+}
+
+func (w *mdWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+func (w *mdWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+	if w.skip(n) {
+		return
+	}
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		if text := collapseSpace(nodeText(n)); text != "" {
+			w.block()
+			w.inline(strings.Repeat("#", int(n.Data[1]-'0')) + " " + text)
+			w.block()
+		}
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Header, atom.Figure, atom.Figcaption,
+		atom.Details, atom.Summary, atom.Dl, atom.Address, atom.Center:
+		w.block()
+		w.children(n)
+		w.block()
+	case atom.Dt, atom.Dd:
+		w.line()
+		w.children(n)
+		w.line()
+	case atom.Br:
+		w.line()
+	case atom.Hr:
+		w.block()
+		w.inline("---")
+		w.block()
+	case atom.Pre:
+		w.pre(n)
+	case atom.Blockquote:
+		w.block()
+		prefix := w.prefix
+		w.prefix += "> "
+		w.children(n)
+		w.prefix = prefix
+		w.block()
+	case atom.Ul, atom.Ol:
+		w.list(n)
+	case atom.Li:
+		// An item outside a list.
+		w.line()
+		w.children(n)
+		w.line()
+	case atom.Table:
+		w.table(n)
+	case atom.A:
+		w.link(n)
+	case atom.Img:
+		if alt := collapseSpace(attr(n, "alt")); alt != "" {
+			w.inline("![" + escapeBrackets(alt) + "](" + w.resolve(attr(n, "src")) + ")")
+		}
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		w.wrapped(n, codeSpan)
+	case atom.Strong, atom.B:
+		w.wrapped(n, func(s string) string { return "**" + s + "**" })
+	case atom.Em, atom.I:
+		w.wrapped(n, func(s string) string { return "_" + s + "_" })
+	default:
+		w.children(n)
+	}
+}
+
+// wrapped writes n's text, as Markdown that format makes of it.
+func (w *mdWriter) wrapped(n *html.Node, format func(string) string) {
+	raw := nodeText(n)
+	text := collapseSpace(raw)
+	if text == "" {
+		w.text(raw)
+		return
+	}
+	if isSpace(raw[0]) {
+		w.space = true
+	}
+	w.inline(format(text))
+	w.space = isSpace(raw[len(raw)-1])
+}
+
+func (w *mdWriter) link(n *html.Node) {
+	href := attr(n, "href")
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		w.children(n)
+		return
+	}
+	w.wrapped(n, func(text string) string { return "[" + escapeBrackets(text) + "](" + w.resolve(href) + ")" })
+}
+
+// resolve returns ref as an absolute URL.
+func (w *mdWriter) resolve(ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || w.base == nil {
+		return ref
+	}
+	return w.base.ResolveReference(u).String()
+}
+
+func (w *mdWriter) pre(n *html.Node) {
+	code := strings.Trim(nodeText(n), "\n")
+	lang := languageClass(n)
+	if c := findNode(n, func(c *html.Node) bool { return c.DataAtom == atom.Code }); c != nil && lang == "" {
+		lang = languageClass(c)
+	}
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	w.block()
+	w.lines(fence + lang + "\n" + code + "\n" + fence)
+	w.block()
+}
+
+// languageClass returns the language that n's class names, as in "language-go", for syntax highlighting.
+func languageClass(n *html.Node) string {
+	for _, class := range strings.Fields(attr(n, "class")) {
+		for _, p := range []string{"language-", "lang-", "highlight-source-"} {
+			if lang, ok := strings.CutPrefix(class, p); ok {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+func (w *mdWriter) list(n *html.Node) {
+	if w.lists > 0 {
+		// Keep lists tight, even after paragraphs.
+		w.breaks = 1
+	} else {
+		w.block()
+	}
+	w.lists++
+	num, first := 1, true
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		num = start
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			w.node(c)
+			continue
+		}
+		if w.skip(c) {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(num) + ". "
+			num++
+		}
+		if first {
+			w.line()
+		} else {
+			w.breaks = 1
+		}
+		first = false
+		prefix := w.prefix
+		w.prefix += strings.Repeat(" ", len(marker))
+		w.marker = marker
+		w.children(c)
+		w.marker = ""
+		w.prefix = prefix
+	}
+	w.lists--
+	if w.lists > 0 {
+		w.line()
+	} else {
+		w.block()
+	}
+}
+
+func (w *mdWriter) table(n *html.Node) {
+	var rows [][]string
+	walkNodes(n, func(r *html.Node) {
+		if r.DataAtom != atom.Tr {
+			return
+		}
+		var cells []string
+		for c := r.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Td || c.DataAtom == atom.Th {
+				cells = append(cells, strings.ReplaceAll(collapseSpace(nodeText(c)), "|", `\|`))
+			}
+		}
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+		}
+	})
+	if len(rows) == 0 {
+		return
+	}
+	w.block()
+	for i, cells := range rows {
+		w.line()
+		w.inline("| " + strings.Join(cells, " | ") + " |")
+		if i == 0 {
+			w.line()
+			w.inline(strings.Repeat("| --- ", len(cells)) + "|")
+		}
+	}
+	w.block()
+}
+
+// codeSpan returns s as Markdown inline code, delimited by more backticks than it contains in a row.
+func codeSpan(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+func escapeBrackets(s string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(s)
+}
+
+// nodeText returns the text in n, as it is.
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	walkNodes(n, func(c *html.Node) {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+	})
+	return b.String()
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+// walkNodes calls f for n and each node under it, depth first, skipping scripts and styles.
+func walkNodes(n *html.Node, f func(*html.Node)) {
+	f(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Script || c.DataAtom == atom.Style {
+			continue
+		}
+		walkNodes(c, f)
+	}
+}
+
+// findNode returns the first node under n, depth first, for which match is true, or nil.
+func findNode(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findNode(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	v, _ := attrOK(n, key)
+	return v
+}
+
+func attrOK(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,193 @@
+// Package review lets the agent review a diff — local changes or a pull request —
+// as structured comments on line ranges, each with a severity and an optional suggested change,
+// which can be shown in the UI and posted back to the forge.
+package review
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Severities of comments, from least to most severe.
+var Severities = []string{"nit", "minor", "major", "blocker"}
+
+// A Comment is a review comment on lines StartLine through EndLine of the new version of File.
+type Comment struct {
+	File      string `json:"file"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line,omitempty"` // StartLine if zero
+	Severity  string `json:"severity"`
+	Body      string `json:"body"`
+	// Suggestion, if set, replaces the lines; an empty suggestion deletes them.
+	Suggestion *string `json:"suggestion,omitempty"`
+}
+
+// lines returns the first and last line c is on.
+func (c Comment) lines() (int, int) {
+	return c.StartLine, max(c.EndLine, c.StartLine)
+}
+
+func (c Comment) location() string {
+	start, end := c.lines()
+	if start == end {
+		return fmt.Sprintf("%s:%d", c.File, start)
+	}
+	return fmt.Sprintf("%s:%d-%d", c.File, start, end)
+}
+
+// A lineRange is a range of lines of the new version of a file, from start through end.
+type lineRange struct{ start, end int }
+
+// A diff is a parsed unified diff.
+type diff struct {
+	text string
+	// hunks are, for each file in its new version, the lines that the diff's hunks show.
+	hunks map[string][]lineRange
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// parseDiff parses the unified diff text.
+func parseDiff(text string) *diff {
+	d := &diff{text: text, hunks: make(map[string][]lineRange)}
+	file := ""
+	for line := range strings.Lines(text) {
+		line = strings.TrimRight(line, "\n")
+		if name, ok := strings.CutPrefix(line, "+++ "); ok {
+			file = ""
+			if name != "/dev/null" {
+				file = strings.TrimPrefix(name, "b/")
+			}
+			continue
+		}
+		m := hunkHeader.FindStringSubmatch(line)
+		if m == nil || file == "" {
+			continue
+		}
+		start, _ := strconv.Atoi(m[1])
+		count := 1
+		if m[2] != "" {
+			count, _ = strconv.Atoi(m[2])
+		}
+		if count > 0 {
+			d.hunks[file] = append(d.hunks[file], lineRange{start, start + count - 1})
+		}
+	}
+	return d
+}
+
+// files returns the files with lines in d, sorted.
+func (d *diff) files() []string {
+	var files []string
+	for f := range d.hunks {
+		files = append(files, f)
+	}
+	slices.Sort(files)
+	return files
+}
+
+// check reports whether c is on lines that d shows, which are the only lines forges take comments on.
+func (d *diff) check(c Comment) error {
+	if c.File == "" || c.StartLine <= 0 || (c.EndLine != 0 && c.EndLine < c.StartLine) {
+		return fmt.Errorf("a comment needs a file and a start_line, and an end_line, if given, not before it")
+	}
+	if !slices.Contains(Severities, c.Severity) {
+		return fmt.Errorf("%s: unknown severity %q; want one of %s", c.location(), c.Severity, strings.Join(Severities, ", "))
+	}
+	if strings.TrimSpace(c.Body) == "" {
+		return fmt.Errorf("%s: the comment has no body", c.location())
+	}
+	hunks, ok := d.hunks[c.File]
+	if !ok {
+		return fmt.Errorf("%s: the diff does not change %s; it changes %s", c.location(), c.File, strings.Join(d.files(), ", "))
+	}
+	start, end := c.lines()
+	var shown []string
+	for _, h := range hunks {
+		if h.start <= start && end <= h.end {
+			return nil
+		}
+		shown = append(shown, fmt.Sprintf("%d-%d", h.start, h.end))
+	}
+	return fmt.Errorf("%s: comments must be on lines within one hunk of the diff; it shows lines %s of %s", c.location(), strings.Join(shown, ", "), c.File)
+}
+
+// annotate returns d's text with the line number in the new version of the file
+// before each line that is in it, so that comments can refer to lines.
+func (d *diff) annotate() string {
+	var b strings.Builder
+	next := 0 // line number of the next line of the new version of the file in the hunk
+	inHunk := false
+	for line := range strings.Lines(d.text) {
+		if m := hunkHeader.FindStringSubmatch(line); m != nil {
+			next, _ = strconv.Atoi(m[1])
+			inHunk = true
+			b.WriteString("      " + line)
+			continue
+		}
+		if inHunk && line != "" {
+			switch line[0] {
+			case ' ', '+', '\n': // an empty context line may have lost its space
+				fmt.Fprintf(&b, "%5d %s", next, line)
+				next++
+				continue
+			case '-', '\\':
+				b.WriteString("      " + line)
+				continue
+			}
+		}
+		inHunk = false
+		b.WriteString("      " + line)
+	}
+	return b.String()
+}
+
+// render returns a Markdown rendering of a review of target with summary and comments.
+func render(target, summary string, comments []Comment) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Review of %s: %s\n", target, countSeverities(comments))
+	if summary != "" {
+		fmt.Fprintf(&b, "\n%s\n", strings.TrimSpace(summary))
+	}
+	for _, c := range comments {
+		fmt.Fprintf(&b, "\n**[%s]** %s\n%s\n", c.Severity, c.location(), strings.TrimSpace(c.Body))
+		if c.Suggestion != nil {
+			fmt.Fprintf(&b, "```suggestion\n%s```\n", withNewline(*c.Suggestion))
+		}
+	}
+	return b.String()
+}
+
+// countSeverities describes how many of comments have each severity, most severe first.
+func countSeverities(comments []Comment) string {
+	if len(comments) == 0 {
+		return "no comments"
+	}
+	var parts []string
+	for _, sev := range slices.Backward(Severities) {
+		n := 0
+		for _, c := range comments {
+			if c.Severity == sev {
+				n++
+			}
+		}
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, sev))
+		}
+	}
+	noun := "comments"
+	if len(comments) == 1 {
+		noun = "comment"
+	}
+	return fmt.Sprintf("%d %s (%s)", len(comments), noun, strings.Join(parts, ", "))
+}
+
+func withNewline(s string) string {
+	if s == "" || strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
@@ -0,0 +1,191 @@
+package review
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/claudetool/forge"
+)
+
+const testDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,4 +1,5 @@
+ package main
+
+-func main() {}
++func main() {
++	run()
++}
+@@ -20,2 +21,3 @@ func run() {
+ 	x := 1
++	_ = x
+ }
+diff --git a/old.go b/old.go
+deleted file mode 100644
+--- a/old.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package main
+`
+
+func TestDiff(t *testing.T) {
+	d := parseDiff(testDiff)
+	if got := d.files(); len(got) != 1 || got[0] != "main.go" {
+		t.Fatalf("files = %q, want only main.go", got)
+	}
+
+	annotated := d.annotate()
+	for _, want := range []string{
+		"      @@ -1,4 +1,5 @@\n",
+		"    1  package main\n",
+		"      -func main() {}\n",
+		"    3 +func main() {\n",
+		"    5 +}\n",
+		"   22 +\t_ = x\n",
+		"      +++ /dev/null\n",
+	} {
+		if !strings.Contains(annotated, want) {
+			t.Errorf("annotated diff lacks %q:\n%s", want, annotated)
+		}
+	}
+
+	for _, tt := range []struct {
+		c       Comment
+		wantErr string
+	}{
+		{Comment{File: "main.go", StartLine: 3, EndLine: 5, Severity: "nit", Body: "ok"}, ""},
+		{Comment{File: "main.go", StartLine: 22, Severity: "major", Body: "ok"}, ""},
+		{Comment{File: "main.go", StartLine: 5, EndLine: 21, Severity: "nit", Body: "spans hunks"}, "lines 1-5, 21-23"},
+		{Comment{File: "main.go", StartLine: 10, Severity: "nit", Body: "between hunks"}, "within one hunk"},
+		{Comment{File: "old.go", StartLine: 1, Severity: "nit", Body: "deleted"}, "it changes main.go"},
+		{Comment{File: "main.go", StartLine: 3, Severity: "critical", Body: "x"}, "unknown severity"},
+		{Comment{File: "main.go", StartLine: 3, Severity: "nit", Body: " "}, "no body"},
+		{Comment{File: "main.go", StartLine: 4, EndLine: 3, Severity: "nit", Body: "x"}, "not before it"},
+	} {
+		err := d.check(tt.c)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("check(%+v) = %v, want error containing %q", tt.c, err, tt.wantErr)
+		}
+	}
+}
+
+// fakeForge serves one pull request's diff and records the reviews posted to it.
+type fakeForge struct {
+	forge.Forge
+	posted []forge.LineComment
+}
+
+func (f *fakeForge) Name() string { return "GitHub" }
+
+func (f *fakeForge) PullRequestDiff(ctx context.Context, number int) (string, error) {
+	if number != 8 {
+		return "", errors.New("no such pull request")
+	}
+	return testDiff, nil
+}
+
+func (f *fakeForge) PostReview(ctx context.Context, number int, summary string, comments []forge.LineComment) (string, error) {
+	f.posted = comments
+	return "https://github.com/o/r/pull/8#review", nil
+}
+
+func run(t *testing.T, tool *Tool, input string) (string, error) {
+	t.Helper()
+	out, err := tool.Tool().Run(context.Background(), json.RawMessage(input))
+	if err != nil {
+		return "", err
+	}
+	return out[0].Text, nil
+}
+
+func TestReviewPullRequest(t *testing.T) {
+	f := &fakeForge{}
+	var asked []string
+	tool := &Tool{Forge: f, Approve: func(ctx context.Context, description string) error {
+		asked = append(asked, description)
+		return nil
+	}}
+
+	out, err := run(t, tool, `{"action":"diff","pr":8}`)
+	if err != nil || !strings.Contains(out, "    4 +\trun()") {
+		t.Fatalf("diff = %q, %v", out, err)
+	}
+
+	if _, err := run(t, tool, `{"action":"submit","pr":8,"comments":[{"file":"main.go","start_line":10,"severity":"nit","body":"x"}]}`); err == nil || !strings.Contains(err.Error(), "submit again") {
+		t.Errorf("comment outside the diff: err = %v", err)
+	}
+
+	submit := `{"action":"submit","pr":8,"summary":"Looks good.","comments":[
+		{"file":"main.go","start_line":22,"severity":"nit","body":"Unused.","suggestion":""},
+		{"file":"main.go","start_line":3,"end_line":5,"severity":"major","body":"main should handle errors from run."}]}`
+	out, err = run(t, tool, submit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Review of pull request #8: 2 comments (1 major, 1 nit)\n\nLooks good.\n\n" +
+		"**[nit]** main.go:22\nUnused.\n```suggestion\n```\n\n" +
+		"**[major]** main.go:3-5\nmain should handle errors from run.\n"
+	if out != want {
+		t.Errorf("submit = %q\nwant %q", out, want)
+	}
+	if len(asked) != 0 || f.posted != nil {
+		t.Errorf("unposted review asked %q and posted %+v", asked, f.posted)
+	}
+
+	out, err = run(t, tool, strings.Replace(submit, `"pr":8,`, `"pr":8,"post":true,`, 1))
+	if err != nil || !strings.HasSuffix(out, "\nPosted: https://github.com/o/r/pull/8#review") {
+		t.Fatalf("post = %q, %v", out, err)
+	}
+	if len(asked) != 1 || !strings.Contains(asked[0], "1 major") {
+		t.Errorf("asked %q", asked)
+	}
+	if len(f.posted) != 2 || f.posted[1] != (forge.LineComment{File: "main.go", StartLine: 3, Line: 5, Body: "**major**: main should handle errors from run."}) {
+		t.Errorf("posted %+v", f.posted)
+	}
+
+	tool.Approve = func(context.Context, string) error { return errors.New("denied") }
+	if _, err := run(t, tool, strings.Replace(submit, `"pr":8,`, `"pr":8,"post":true,`, 1)); err == nil || !strings.Contains(err.Error(), "not approved") {
+		t.Errorf("denied post: err = %v", err)
+	}
+}
+
+func TestReviewLocalChanges(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@t"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\ntwo\n"), 0o644)
+	git("add", ".")
+	git("commit", "-qm", "base")
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\n2\n"), 0o644)
+
+	tool := &Tool{RepoRoot: dir, BaseRef: "HEAD"}
+	out, err := run(t, tool, `{"action":"diff"}`)
+	if err != nil || !strings.Contains(out, "    2 +2\n") {
+		t.Fatalf("diff = %q, %v", out, err)
+	}
+	out, err = run(t, tool, `{"action":"submit","comments":[{"file":"a.txt","start_line":2,"severity":"minor","body":"Spell it out.","suggestion":"two"}]}`)
+	if err != nil || !strings.Contains(out, "**[minor]** a.txt:2\nSpell it out.\n```suggestion\ntwo\n```\n") {
+		t.Errorf("submit = %q, %v", out, err)
+	}
+	if _, err := run(t, tool, `{"action":"submit","post":true,"comments":[]}`); err == nil {
+		t.Error("posted a review of local changes")
+	}
+	if _, err := run(t, tool, `{"action":"diff","pr":1}`); err == nil {
+		t.Error("reviewed a pull request without a forge")
+	}
+}
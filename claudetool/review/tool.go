@@ -0,0 +1,236 @@
+package review
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"sketch.dev/claudetool/forge"
+	"sketch.dev/llm"
+)
+
+// Tool specifies an llm.Tool that reviews a diff as structured comments.
+type Tool struct {
+	// RepoRoot is the repository whose local changes are reviewed.
+	RepoRoot string
+	// BaseRef is the commit local changes are diffed against by default.
+	BaseRef string
+	// Forge, if non-nil, is where pull requests are reviewed and reviews are posted.
+	Forge forge.Forge
+	// Approve is called before a review is posted to the forge, with a description of it.
+	// The review is posted only if it returns nil. If Approve is nil, reviews are never posted.
+	Approve func(ctx context.Context, description string) error
+
+	mu    sync.Mutex
+	diffs map[string]*diff // by target
+}
+
+// Tool returns an llm.Tool based on t.
+func (t *Tool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        Name,
+		Description: strings.TrimSpace(Description),
+		InputSchema: llm.MustSchema(InputSchema),
+		Run:         t.run,
+	}
+}
+
+const (
+	Name        = "review"
+	Description = `
+Reviews a diff as structured comments, when the user asks for a code review of changes or of a pull request.
+This is unlike codereview, which runs automated checks on your own work.
+
+First use diff to read the diff under review: local changes since a base commit, or a pull request by number.
+Each line of the new version of a file is shown with its line number.
+Then use submit once, with a short summary and a comment for each issue found.
+Each comment is on a range of lines that one hunk of the diff shows, and has a severity:
+nit (style, naming), minor (small improvements), major (bugs, missing tests), or blocker (must fix before merging).
+Where a fix is clear, give it as a suggestion, the full replacement text of the commented lines.
+Do not comment on what is fine; a review with no comments is a fine review.
+Set post to post a pull request's review to the forge; the user must approve it first.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	InputSchema = `
+{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["diff", "submit"],
+      "description": "diff shows the diff under review with line numbers; submit records the review"
+    },
+    "pr": {
+      "type": "integer",
+      "description": "Pull request number to review; omit to review local changes"
+    },
+    "base": {
+      "type": "string",
+      "description": "For local changes, the commit to diff against; defaults to the session's starting commit"
+    },
+    "summary": {
+      "type": "string",
+      "description": "For submit, a short overall assessment in Markdown"
+    },
+    "comments": {
+      "type": "array",
+      "description": "For submit, the review comments",
+      "items": {
+        "type": "object",
+        "required": ["file", "start_line", "severity", "body"],
+        "properties": {
+          "file": {"type": "string", "description": "Path of the file, as in the diff"},
+          "start_line": {"type": "integer", "description": "First commented line of the new version of the file"},
+          "end_line": {"type": "integer", "description": "Last commented line; defaults to start_line"},
+          "severity": {"type": "string", "enum": ["nit", "minor", "major", "blocker"]},
+          "body": {"type": "string", "description": "The comment, in Markdown"},
+          "suggestion": {"type": "string", "description": "Replacement text for the commented lines"}
+        }
+      }
+    },
+    "post": {
+      "type": "boolean",
+      "description": "For submit with pr, post the review to the pull request"
+    }
+  }
+}
+`
+)
+
+type input struct {
+	Action   string    `json:"action"`
+	PR       int       `json:"pr"`
+	Base     string    `json:"base"`
+	Summary  string    `json:"summary"`
+	Comments []Comment `json:"comments"`
+	Post     bool      `json:"post"`
+}
+
+// maxOutput is the most of a diff that diff shows.
+const maxOutput = 200 << 10
+
+func (t *Tool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var in input
+	if err := json.Unmarshal(m, &in); err != nil {
+		return nil, fmt.Errorf("failed to parse review input: %w", err)
+	}
+	target, d, err := t.diff(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	switch in.Action {
+	case "diff":
+		out := d.annotate()
+		if out == "" {
+			return llm.TextContent(fmt.Sprintf("%s has no changes.", target)), nil
+		}
+		if len(out) > maxOutput {
+			out = out[:maxOutput] + fmt.Sprintf("\n[diff truncated; it changes %s]", strings.Join(d.files(), ", "))
+		}
+		return llm.TextContent(out), nil
+	case "submit":
+		out, err := t.submit(ctx, in, target, d)
+		if err != nil {
+			return nil, err
+		}
+		return llm.TextContent(out), nil
+	default:
+		return nil, fmt.Errorf("unknown action %q", in.Action)
+	}
+}
+
+// diff returns the diff under review for in, and a description of its target.
+// Diffs are fetched once per target, so that submit checks comments against the diff that was reviewed.
+func (t *Tool) diff(ctx context.Context, in input) (string, *diff, error) {
+	var target string
+	if in.PR > 0 {
+		if t.Forge == nil {
+			return "", nil, fmt.Errorf("no forge is configured, so pull requests cannot be reviewed; review local changes instead")
+		}
+		target = fmt.Sprintf("pull request #%d", in.PR)
+	} else {
+		base := cmp.Or(in.Base, t.BaseRef)
+		if base == "" {
+			return "", nil, fmt.Errorf("review of local changes requires a base")
+		}
+		target = "changes since " + base
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if d, ok := t.diffs[target]; ok && in.Action == "submit" {
+		return target, d, nil
+	}
+	var text string
+	if in.PR > 0 {
+		var err error
+		text, err = t.Forge.PullRequestDiff(ctx, in.PR)
+		if err != nil {
+			return "", nil, fmt.Errorf("diff of %s: %w", target, err)
+		}
+	} else {
+		cmd := exec.CommandContext(ctx, "git", "diff", "--no-color", "--no-ext-diff", cmp.Or(in.Base, t.BaseRef))
+		cmd.Dir = t.RepoRoot
+		out, err := cmd.Output()
+		if err != nil {
+			if ee, ok := err.(*exec.ExitError); ok {
+				return "", nil, fmt.Errorf("git diff: %s", strings.TrimSpace(string(ee.Stderr)))
+			}
+			return "", nil, fmt.Errorf("git diff: %w", err)
+		}
+		text = string(out)
+	}
+	d := parseDiff(text)
+	if t.diffs == nil {
+		t.diffs = make(map[string]*diff)
+	}
+	t.diffs[target] = d
+	return target, d, nil
+}
+
+func (t *Tool) submit(ctx context.Context, in input, target string, d *diff) (string, error) {
+	var errs []string
+	for _, c := range in.Comments {
+		if err := d.check(c); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("fix these comments and submit again:\n%s", strings.Join(errs, "\n"))
+	}
+	out := render(target, in.Summary, in.Comments)
+	if !in.Post {
+		return out, nil
+	}
+	if in.PR <= 0 {
+		return "", fmt.Errorf("only reviews of pull requests can be posted")
+	}
+	if t.Approve == nil {
+		return "", fmt.Errorf("posting reviews to %s is not allowed in this session", t.Forge.Name())
+	}
+	if err := t.Approve(ctx, fmt.Sprintf("Post a review of %s with %s", target, countSeverities(in.Comments))); err != nil {
+		return "", fmt.Errorf("not approved: %w", err)
+	}
+	var comments []forge.LineComment
+	for _, c := range in.Comments {
+		start, end := c.lines()
+		comments = append(comments, forge.LineComment{
+			File:       c.File,
+			StartLine:  start,
+			Line:       end,
+			Body:       fmt.Sprintf("**%s**: %s", c.Severity, strings.TrimSpace(c.Body)),
+			Suggestion: c.Suggestion,
+		})
+	}
+	url, err := t.Forge.PostReview(ctx, in.PR, in.Summary, comments)
+	if err != nil {
+		return "", fmt.Errorf("posting review: %w", err)
+	}
+	return out + "\nPosted: " + url, nil
+}
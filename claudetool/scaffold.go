@@ -0,0 +1,351 @@
+package claudetool
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"golang.org/x/tools/txtar"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/claudetool/undo"
+	"sketch.dev/llm"
+)
+
+// ScaffoldTool specifies an llm.Tool that creates files from templates,
+// so that common additions, such as a new package or handler, get a consistent structure.
+//
+// Templates are txtar archives. The archive's comment describes the template,
+// followed by a "vars:" line and one "Name: description" line per variable.
+// The names and contents of its files are text/template templates over those variables,
+// with the functions lower, upper, and title.
+// Templates embedded in sketch can be overridden or added to by templates
+// in the repo's .sketch/scaffold directory.
+type ScaffoldTool struct {
+	// RepoRoot is the root of the repo, whose .sketch/scaffold directory holds its templates.
+	RepoRoot string
+	// Stage, if non-nil, receives the new files instead of the disk.
+	Stage *staging.Area
+	// Journal, if set, records the files written to disk, so that they can be undone.
+	Journal *undo.Journal
+}
+
+//go:embed scaffold/*.txtar
+var scaffoldFS embed.FS
+
+// scaffoldRepoDir is where repos keep their own templates, relative to the repo root.
+const scaffoldRepoDir = ".sketch/scaffold"
+
+// Tool returns an llm.Tool based on s.
+func (s *ScaffoldTool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        ScaffoldName,
+		Description: strings.TrimSpace(ScaffoldDescription),
+		InputSchema: llm.MustSchema(ScaffoldInputSchema),
+		Run:         s.run,
+	}
+}
+
+const (
+	ScaffoldName        = "scaffold"
+	ScaffoldDescription = `
+Creates new files from project templates, for consistent structure.
+
+Use it when asked to add something a template covers, such as a new package or HTTP handler,
+instead of writing the boilerplate by hand; then fill in the generated files.
+Run the list action first to see the templates and the variables each one needs.
+Existing files are never overwritten.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	ScaffoldInputSchema = `
+{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["list", "generate"],
+      "description": "list the available templates, or generate files from one"
+    },
+    "template": {
+      "type": "string",
+      "description": "Name of the template to generate from"
+    },
+    "dir": {
+      "type": "string",
+      "description": "Absolute path of the directory to generate the files in"
+    },
+    "vars": {
+      "type": "object",
+      "additionalProperties": {"type": "string"},
+      "description": "Values of the template's variables"
+    }
+  }
+}
+`
+)
+
+// ScaffoldInput is the input to the scaffold tool.
+type ScaffoldInput struct {
+	Action   string            `json:"action"`
+	Template string            `json:"template,omitempty"`
+	Dir      string            `json:"dir,omitempty"`
+	Vars     map[string]string `json:"vars,omitempty"`
+}
+
+// A scaffoldTemplate is a parsed template archive.
+type scaffoldTemplate struct {
+	Name        string
+	Description string
+	Vars        []scaffoldVar
+	Files       []txtar.File
+}
+
+type scaffoldVar struct {
+	Name, Description string
+}
+
+func (s *ScaffoldTool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var input ScaffoldInput
+	if err := json.Unmarshal(m, &input); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal scaffold input: %w", err)
+	}
+	templates, err := s.templates()
+	if err != nil {
+		return nil, err
+	}
+	switch input.Action {
+	case "list":
+		return llm.TextContent(formatScaffoldTemplates(templates)), nil
+	case "generate":
+		i := slices.IndexFunc(templates, func(t *scaffoldTemplate) bool { return t.Name == input.Template })
+		if i < 0 {
+			return nil, fmt.Errorf("no template named %q; available templates:\n%s", input.Template, formatScaffoldTemplates(templates))
+		}
+		created, err := s.generate(templates[i], input.Dir, input.Vars)
+		if err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "scaffold_generated", "template", input.Template, "dir", input.Dir, "files", len(created))
+		var b strings.Builder
+		fmt.Fprintf(&b, "Created from template %s:\n", input.Template)
+		for _, f := range created {
+			fmt.Fprintf(&b, "- %s\n", f)
+		}
+		b.WriteString("Fill in the TODOs and wire up the new code.\n")
+		return llm.TextContent(b.String()), nil
+	}
+	return nil, fmt.Errorf("unknown action %q; want list or generate", input.Action)
+}
+
+// templates returns the embedded templates and the repo's, sorted by name.
+// A repo template replaces an embedded one of the same name.
+func (s *ScaffoldTool) templates() ([]*scaffoldTemplate, error) {
+	byName := make(map[string]*scaffoldTemplate)
+	embedded, err := fs.Glob(scaffoldFS, "scaffold/*.txtar")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range embedded {
+		data, err := scaffoldFS.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseScaffoldTemplate(strings.TrimSuffix(path.Base(name), ".txtar"), data)
+		if err != nil {
+			return nil, err
+		}
+		byName[t.Name] = t
+	}
+	if s.RepoRoot != "" {
+		files, err := filepath.Glob(filepath.Join(s.RepoRoot, scaffoldRepoDir, "*.txtar"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			t, err := parseScaffoldTemplate(strings.TrimSuffix(filepath.Base(file), ".txtar"), data)
+			if err != nil {
+				return nil, err
+			}
+			byName[t.Name] = t
+		}
+	}
+	templates := slices.Collect(maps.Values(byName))
+	slices.SortFunc(templates, func(a, b *scaffoldTemplate) int { return cmp.Compare(a.Name, b.Name) })
+	return templates, nil
+}
+
+// parseScaffoldTemplate parses the template archive data.
+func parseScaffoldTemplate(name string, data []byte) (*scaffoldTemplate, error) {
+	ar := txtar.Parse(data)
+	if len(ar.Files) == 0 {
+		return nil, fmt.Errorf("scaffold template %s has no files", name)
+	}
+	desc, vars, _ := strings.Cut(string(ar.Comment), "\nvars:\n")
+	t := &scaffoldTemplate{
+		Name:        name,
+		Description: strings.TrimSpace(desc),
+		Files:       ar.Files,
+	}
+	for line := range strings.Lines(vars) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		v, d, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("scaffold template %s: malformed variable line %q; want \"Name: description\"", name, line)
+		}
+		t.Vars = append(t.Vars, scaffoldVar{Name: strings.TrimSpace(v), Description: strings.TrimSpace(d)})
+	}
+	return t, nil
+}
+
+func formatScaffoldTemplates(templates []*scaffoldTemplate) string {
+	if len(templates) == 0 {
+		return "No templates available.\n"
+	}
+	var b strings.Builder
+	for _, t := range templates {
+		fmt.Fprintf(&b, "%s: %s\n", t.Name, t.Description)
+		for _, v := range t.Vars {
+			fmt.Fprintf(&b, "  %s: %s\n", v.Name, v.Description)
+		}
+		for _, f := range t.Files {
+			fmt.Fprintf(&b, "  creates %s\n", f.Name)
+		}
+	}
+	return b.String()
+}
+
+var scaffoldFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"title": func(s string) string {
+		if s == "" {
+			return s
+		}
+		return strings.ToUpper(s[:1]) + s[1:]
+	},
+}
+
+// generate creates t's files in dir, an absolute path, and returns their paths.
+// It creates nothing if any of the files already exists.
+func (s *ScaffoldTool) generate(t *scaffoldTemplate, dir string, vars map[string]string) ([]string, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("dir %q is not absolute", dir)
+	}
+	var missing []string
+	for _, v := range t.Vars {
+		if vars[v.Name] == "" {
+			missing = append(missing, fmt.Sprintf("%s (%s)", v.Name, v.Description))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("template %s needs values for: %s", t.Name, strings.Join(missing, ", "))
+	}
+	for name := range vars {
+		if !slices.ContainsFunc(t.Vars, func(v scaffoldVar) bool { return v.Name == name }) {
+			return nil, fmt.Errorf("template %s has no variable %q", t.Name, name)
+		}
+	}
+
+	// Render everything before writing anything, so that an error leaves no partial scaffold.
+	type file struct {
+		path string
+		data []byte
+	}
+	var files []file
+	for _, f := range t.Files {
+		name, err := executeScaffold(t.Name, f.Name, vars)
+		if err != nil {
+			return nil, err
+		}
+		rel := filepath.Clean(filepath.FromSlash(string(name)))
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("template %s: file name %q is outside dir", t.Name, name)
+		}
+		data, err := executeScaffold(t.Name, string(f.Data), vars)
+		if err != nil {
+			return nil, err
+		}
+		p := filepath.Join(dir, rel)
+		if strings.HasSuffix(p, ".go") {
+			formatted, err := format.Source(data)
+			if err != nil {
+				return nil, fmt.Errorf("template %s: generated %s is not valid Go; check the variables: %w", t.Name, rel, err)
+			}
+			data = formatted
+		}
+		if _, err := s.readFile(p); err == nil {
+			return nil, fmt.Errorf("%s already exists; scaffold never overwrites files", p)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		files = append(files, file{p, data})
+	}
+
+	var created []string
+	for _, f := range files {
+		if err := s.writeFile(f.path, f.data); err != nil {
+			return created, err
+		}
+		created = append(created, f.path)
+	}
+	return created, nil
+}
+
+// executeScaffold executes text, a template from the template named name, with vars.
+func executeScaffold(name, text string, vars map[string]string) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(scaffoldFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// readFile reads path, preferring staged contents when staging is enabled.
+func (s *ScaffoldTool) readFile(path string) ([]byte, error) {
+	if s.Stage != nil {
+		return s.Stage.ReadFile(path)
+	}
+	return os.ReadFile(path)
+}
+
+// writeFile writes data to path, or stages it when staging is enabled.
+func (s *ScaffoldTool) writeFile(path string, data []byte) error {
+	if s.Stage != nil {
+		return s.Stage.WriteFile(path, data)
+	}
+	before, err := os.ReadFile(path)
+	existed := err == nil
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %q: %w", path, err)
+	}
+	return s.Journal.Record(path, before, existed, data)
+}
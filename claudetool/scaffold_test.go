@@ -0,0 +1,122 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/claudetool/staging"
+)
+
+func runScaffold(t *testing.T, s *ScaffoldTool, input ScaffoldInput) (string, error) {
+	t.Helper()
+	m, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := s.Tool().Run(context.Background(), m)
+	if err != nil {
+		return "", err
+	}
+	return out[0].Text, nil
+}
+
+func TestScaffoldEmbeddedTemplates(t *testing.T) {
+	templates, err := (&ScaffoldTool{}).templates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) == 0 {
+		t.Fatal("no embedded templates")
+	}
+	// Every embedded template must generate valid Go from plausible variables.
+	for _, tmpl := range templates {
+		t.Run(tmpl.Name, func(t *testing.T) {
+			vars := make(map[string]string)
+			for _, v := range tmpl.Vars {
+				vars[v.Name] = map[string]string{"Package": "widgets", "Name": "Widget", "Route": "GET /widgets/{id}"}[v.Name]
+				if vars[v.Name] == "" {
+					vars[v.Name] = "does things"
+				}
+			}
+			created, err := (&ScaffoldTool{}).generate(tmpl, t.TempDir(), vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(created) != len(tmpl.Files) {
+				t.Errorf("created %d files, want %d", len(created), len(tmpl.Files))
+			}
+		})
+	}
+}
+
+func TestScaffoldGenerate(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(repo, scaffoldRepoDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	service := `Creates a service.
+
+vars:
+Name: the service name
+-- {{lower .Name}}/service.go --
+package {{lower .Name}}
+type {{.Name}} struct{ }
+-- {{lower .Name}}/README.md --
+# {{upper .Name}}
+`
+	if err := os.WriteFile(filepath.Join(repo, scaffoldRepoDir, "service.txtar"), []byte(service), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stage := staging.NewArea()
+	s := &ScaffoldTool{RepoRoot: repo, Stage: stage}
+
+	out, err := runScaffold(t, s, ScaffoldInput{Action: "list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"service: Creates a service.", "Name: the service name", "go-package:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("list output does not contain %q:\n%s", want, out)
+		}
+	}
+
+	input := ScaffoldInput{Action: "generate", Template: "service", Dir: repo, Vars: map[string]string{"Name": "Billing"}}
+	if _, err := runScaffold(t, s, input); err != nil {
+		t.Fatal(err)
+	}
+	got, err := stage.ReadFile(filepath.Join(repo, "billing", "service.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package billing\n\ntype Billing struct{}\n"; string(got) != want {
+		t.Errorf("service.go = %q, want gofmt'd %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "billing")); !os.IsNotExist(err) {
+		t.Errorf("scaffold wrote to disk despite staging: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   ScaffoldInput
+		wantErr string
+	}{
+		{"existing", input, "already exists"},
+		{"missing var", ScaffoldInput{Action: "generate", Template: "service", Dir: repo}, "needs values for: Name"},
+		{"unknown var", ScaffoldInput{Action: "generate", Template: "service", Dir: repo, Vars: map[string]string{"Name": "X", "Nmae": "X"}}, `no variable "Nmae"`},
+		{"unknown template", ScaffoldInput{Action: "generate", Template: "nope", Dir: repo}, `no template named "nope"`},
+		{"relative dir", ScaffoldInput{Action: "generate", Template: "service", Dir: "svc", Vars: map[string]string{"Name": "X"}}, "not absolute"},
+		{"invalid go", ScaffoldInput{Action: "generate", Template: "service", Dir: repo, Vars: map[string]string{"Name": "two words"}}, "not valid Go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runScaffold(t, s, tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -9,6 +9,17 @@ import (
 	"context"
 )
 
+// SchemaVersion is the version of the JSON that carries tool calls to UIs, logs, and other consumers.
+// Three types have a schema_version field saying which version they follow:
+// BackgroundResult, loop.AgentMessage, and loop.Event.
+// The tool inputs and results inside a message follow its version.
+// Other tool results are plain text and are not versioned.
+//
+// Within a version, changes are only additive: fields may be added, and consumers must ignore fields
+// they do not know, but no field is removed or renamed, or changes type or meaning.
+// Any other change increments SchemaVersion.
+const SchemaVersion = 1
+
 type workingDirCtxKeyType string
 
 const workingDirCtxKey workingDirCtxKeyType = "workingDir"
@@ -0,0 +1,184 @@
+// Package skills loads a project's skills: named bundles of instructions, scripts, and tool settings
+// for the project's recurring procedures, such as cutting a release, which the agent or the user invokes by name.
+// It backs the skill tool.
+//
+// Skills live in the repository under .sketch/skills, a directory per skill, named for it:
+//
+//	.sketch/skills/release/SKILL.md
+//	.sketch/skills/release/bump-version.sh
+//
+// SKILL.md holds the skill's instructions, which the agent follows when it uses the skill,
+// after optional YAML front matter:
+//
+//	---
+//	description: Cut a release of the project
+//	tools:
+//	  disable: [browser_navigate]
+//	  descriptions:
+//	    bash: Runs a command. Releases must not touch the network until the tag is pushed.
+//	---
+//	1. Run ./bump-version.sh with the new version.
+//	...
+//
+// The other files in a skill's directory are its scripts, which its instructions may refer to.
+package skills
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Dir is where a project keeps its skills, relative to the repository root.
+const Dir = ".sketch/skills"
+
+// File is the file in a skill's directory that describes it.
+const File = "SKILL.md"
+
+// A Skill is a named bundle of instructions, scripts, and tool settings.
+type Skill struct {
+	Name string // its directory's name
+	// Description says what the skill is for; by default, the first line of its instructions.
+	Description string
+	// Instructions are what the agent follows when it uses the skill.
+	Instructions string
+	// Dir is the absolute path of the skill's directory.
+	Dir string
+	// Scripts are the names of the other files in Dir, sorted.
+	Scripts []string
+	// Tools changes the tools offered to the agent while it uses the skill.
+	Tools Tools
+}
+
+// Tools changes the tools offered to the agent, by tool name.
+type Tools struct {
+	// Enable offers tools that were disabled.
+	Enable []string `yaml:"enable"`
+	// Disable stops offering tools.
+	Disable []string `yaml:"disable"`
+	// Descriptions replace the descriptions of tools.
+	Descriptions map[string]string `yaml:"descriptions"`
+}
+
+// validName matches the names of skills.
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Load returns the skills of the repository at repoRoot, sorted by name.
+// It returns what skills it can load, along with the errors for the rest.
+// A repository without a skills directory has no skills.
+func Load(repoRoot string) ([]*Skill, error) {
+	entries, err := os.ReadDir(filepath.Join(repoRoot, Dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var skills []*Skill
+	var errs error
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		s, err := load(filepath.Join(repoRoot, Dir, e.Name()))
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("skill %s: %w", e.Name(), err))
+			continue
+		}
+		skills = append(skills, s)
+	}
+	return skills, errs
+}
+
+// Find returns the skill of the repository at repoRoot named name.
+func Find(repoRoot, name string) (*Skill, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid skill name %q", name)
+	}
+	s, err := load(filepath.Join(repoRoot, Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no skill named %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("skill %s: %w", name, err)
+	}
+	return s, nil
+}
+
+// load loads the skill in dir.
+func load(dir string) (*Skill, error) {
+	name := filepath.Base(dir)
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid skill name %q: use lowercase letters, digits, _ and -", name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, File))
+	if err != nil {
+		return nil, err
+	}
+	s, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	s.Name = name
+	s.Dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && e.Name() != File && !strings.HasPrefix(e.Name(), ".") {
+			s.Scripts = append(s.Scripts, e.Name())
+		}
+	}
+	slices.Sort(s.Scripts)
+	return s, nil
+}
+
+// parse parses the contents of a SKILL.md file.
+func parse(data []byte) (*Skill, error) {
+	var front struct {
+		Description string `yaml:"description"`
+		Tools       Tools  `yaml:"tools"`
+	}
+	body := data
+	if rest, ok := bytes.CutPrefix(data, []byte("---\n")); ok {
+		fm, after, ok := bytes.Cut(rest, []byte("\n---\n"))
+		if !ok {
+			fm, ok = bytes.CutSuffix(rest, []byte("\n---"))
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s: front matter is not closed by a --- line", File)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(fm))
+		dec.KnownFields(true)
+		if err := dec.Decode(&front); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: invalid front matter: %w", File, err)
+		}
+		body = after
+	}
+	s := &Skill{
+		Description:  strings.TrimSpace(front.Description),
+		Instructions: strings.TrimSpace(string(body)),
+		Tools:        front.Tools,
+	}
+	if s.Instructions == "" {
+		return nil, fmt.Errorf("%s has no instructions", File)
+	}
+	if s.Description == "" {
+		first, _, _ := strings.Cut(s.Instructions, "\n")
+		s.Description = strings.TrimLeft(first, "# ")
+	}
+	return s, nil
+}
@@ -0,0 +1,113 @@
+package skills
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if skills, err := Load(t.TempDir()); skills != nil || err != nil {
+		t.Errorf("Load without skills = %v, %v", skills, err)
+	}
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".sketch/skills/release/SKILL.md": `---
+description: Cut a release
+tools:
+  disable: [browse]
+  descriptions:
+    bash: Runs a command, offline.
+---
+1. Run ./bump.sh with the new version.
+2. Tag it.
+`,
+		".sketch/skills/release/bump.sh":   "#!/bin/sh\n",
+		".sketch/skills/triage/SKILL.md":   "# Triage a bug report\n\nReproduce it first.\n",
+		".sketch/skills/broken/SKILL.md":   "---\nbogus: true\n---\nDo it.\n",
+		".sketch/skills/Bad Name/SKILL.md": "Do it.\n",
+	})
+	skills, err := Load(root)
+	if err == nil || !strings.Contains(err.Error(), "skill broken") || !strings.Contains(err.Error(), "invalid skill name") {
+		t.Errorf("Load error = %v, want the broken and badly named skills", err)
+	}
+	if len(skills) != 2 {
+		t.Fatalf("Load = %d skills, want 2", len(skills))
+	}
+	release, triage := skills[0], skills[1]
+	if release.Name != "release" || release.Description != "Cut a release" || !strings.HasPrefix(release.Instructions, "1. Run") {
+		t.Errorf("release = %+v", release)
+	}
+	if !slices.Equal(release.Scripts, []string{"bump.sh"}) || release.Dir != filepath.Join(root, Dir, "release") {
+		t.Errorf("release scripts %v in %s", release.Scripts, release.Dir)
+	}
+	if !slices.Equal(release.Tools.Disable, []string{"browse"}) || release.Tools.Descriptions["bash"] != "Runs a command, offline." {
+		t.Errorf("release tools = %+v", release.Tools)
+	}
+	if triage.Description != "Triage a bug report" {
+		t.Errorf("triage description = %q, want its first line", triage.Description)
+	}
+
+	if _, err := Find(root, "missing"); err == nil || !strings.Contains(err.Error(), `no skill named "missing"`) {
+		t.Errorf("Find(missing) = %v", err)
+	}
+	if _, err := Find(root, "../release"); err == nil {
+		t.Errorf("Find outside the skills directory succeeded")
+	}
+}
+
+func TestUse(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".sketch/skills/release/SKILL.md": "---\ndescription: Cut a release\ntools:\n  disable: [browse, nope]\n  descriptions:\n    bash: Runs a command, offline.\n---\nTag it.\n",
+		".sketch/skills/release/bump.sh":  "#!/bin/sh\n",
+	})
+	tool := (&Tool{RepoRoot: root}).Tool()
+	if !strings.Contains(tool.Description, "- release: Cut a release") {
+		t.Errorf("description does not list the skills:\n%s", tool.Description)
+	}
+
+	convo := conversation.New(context.Background(), nil, nil)
+	for _, name := range []string{"bash", "browse"} {
+		convo.Tools = append(convo.Tools, &llm.Tool{Name: name, InputSchema: llm.EmptySchema()})
+	}
+	ctx := conversation.ContextWithToolCallInfo(context.Background(), conversation.ToolCallInfo{Convo: convo})
+	out, err := tool.Run(ctx, json.RawMessage(`{"action":"use","name":"release"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := out[0].Text
+	for _, want := range []string{"<skill name=\"release\">\nTag it.\n</skill>", filepath.Join(root, Dir, "release"), "- bump.sh", "- disabled browse", `tool "nope" not found`, "- replaced the description of bash"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("use output lacks %q:\n%s", want, text)
+		}
+	}
+	if !slices.Equal(convo.DisabledTools(), []string{"browse"}) || convo.ToolOverrides()["bash"].Description != "Runs a command, offline." {
+		t.Errorf("tool settings not applied: disabled %v, overrides %v", convo.DisabledTools(), convo.ToolOverrides())
+	}
+
+	if _, err := tool.Run(ctx, json.RawMessage(`{"action":"use","name":"deploy"}`)); err == nil {
+		t.Errorf("using a missing skill succeeded")
+	}
+}
@@ -0,0 +1,162 @@
+package skills
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// Tool specifies an llm.Tool through which the agent lists and uses the skills of the repository at RepoRoot.
+type Tool struct {
+	RepoRoot string
+}
+
+// Tool returns an llm.Tool based on t.
+// Its description lists the skills there are when it is called.
+func (t *Tool) Tool() *llm.Tool {
+	description := strings.TrimSpace(Description)
+	if skills, _ := Load(t.RepoRoot); len(skills) > 0 {
+		description += "\n\nThe project's skills:\n" + formatSkills(skills)
+	}
+	return &llm.Tool{
+		Name:        Name,
+		Description: description,
+		InputSchema: llm.MustSchema(InputSchema),
+		Run:         t.run,
+	}
+}
+
+const (
+	Name        = "skill"
+	Description = `
+Uses the project's skills: its own procedures for recurring tasks, such as cutting a release,
+each with instructions to follow, scripts to run, and settings for your tools.
+
+Actions:
+- list: list the skills, with what each is for
+- use: get a skill's instructions and scripts, and apply its tool settings
+
+When a task matches a skill, or the user asks you to use one, use it and follow its instructions
+instead of working out the procedure yourself.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	InputSchema = `
+{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["list", "use"]
+    },
+    "name": {
+      "type": "string",
+      "description": "For use, the skill's name"
+    }
+  }
+}
+`
+)
+
+type input struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+}
+
+func (t *Tool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var in input
+	if err := json.Unmarshal(m, &in); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal skill input: %w", err)
+	}
+	switch in.Action {
+	case "list":
+		skills, err := Load(t.RepoRoot)
+		if len(skills) == 0 {
+			return nil, errors.Join(fmt.Errorf("the project has no skills in %s", Dir), err)
+		}
+		out := formatSkills(skills)
+		if err != nil {
+			out += "\nSkills that could not be loaded:\n" + err.Error() + "\n"
+		}
+		return llm.TextContent(out), nil
+	case "use":
+		s, err := Find(t.RepoRoot, in.Name)
+		if err != nil {
+			return nil, err
+		}
+		var applied string
+		if convo := conversation.ToolCallInfoFromContext(ctx).Convo; convo != nil {
+			applied = apply(convo, s.Tools)
+		}
+		slog.InfoContext(ctx, "skill_used", "skill", s.Name)
+		return llm.TextContent(formatUse(s, applied)), nil
+	default:
+		return nil, fmt.Errorf("unknown action %q; use list or use", in.Action)
+	}
+}
+
+// apply applies tools to convo's tools, and returns a summary of what changed, with any settings it could not apply.
+// The changes last for the rest of the conversation.
+func apply(convo *conversation.Convo, tools Tools) string {
+	var b strings.Builder
+	note := func(format string, args ...any) {
+		fmt.Fprintf(&b, "- "+format+"\n", args...)
+	}
+	for _, name := range tools.Enable {
+		if err := convo.EnableTools(name); err != nil {
+			note("not enabled: %v", err)
+		} else {
+			note("enabled %s", name)
+		}
+	}
+	for _, name := range tools.Disable {
+		if err := convo.DisableTools(name); err != nil {
+			note("not disabled: %v", err)
+		} else {
+			note("disabled %s", name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(tools.Descriptions)) {
+		if err := convo.OverrideTool(name, conversation.ToolOverride{Description: tools.Descriptions[name]}); err != nil {
+			note("description not replaced: %v", err)
+		} else {
+			note("replaced the description of %s", name)
+		}
+	}
+	return b.String()
+}
+
+// formatSkills lists skills, a line each.
+func formatSkills(skills []*Skill) string {
+	var b strings.Builder
+	for _, s := range skills {
+		fmt.Fprintf(&b, "- %s: %s\n", s.Name, s.Description)
+	}
+	return b.String()
+}
+
+// formatUse returns the tool's output for using s, whose tool settings changed the tools as applied says.
+func formatUse(s *Skill, applied string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<skill name=%q>\n%s\n</skill>\n", s.Name, s.Instructions)
+	if len(s.Scripts) > 0 {
+		fmt.Fprintf(&b, "\nThe skill's scripts, in %s:\n", s.Dir)
+		for _, script := range s.Scripts {
+			fmt.Fprintf(&b, "- %s\n", script)
+		}
+	}
+	if applied != "" {
+		fmt.Fprintf(&b, "\nYour tools changed for the rest of the session:\n%s", applied)
+	}
+	b.WriteString("\nFollow the skill's instructions now.\n")
+	return b.String()
+}
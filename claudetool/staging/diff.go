@@ -0,0 +1,183 @@
+package staging
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the size of the LCS table used by diffLines.
+// Beyond it, the differing middle of the file is treated as a single block.
+const maxDiffCells = 4 << 20
+
+// contextLines is the number of unchanged lines shown around each hunk.
+const contextLines = 3
+
+// A lineDiff is a line-based diff between two texts.
+type lineDiff struct {
+	old, new []string
+	blocks   []block // maximal runs of changed lines, in order
+}
+
+// A block replaces old[oldStart:oldEnd] with new[newStart:newEnd].
+type block struct {
+	oldStart, oldEnd int
+	newStart, newEnd int
+}
+
+// splitLines splits b into lines, keeping line terminators.
+func splitLines(b []byte) []string {
+	var lines []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			lines = append(lines, string(b))
+			break
+		}
+		lines = append(lines, string(b[:i+1]))
+		b = b[i+1:]
+	}
+	return lines
+}
+
+func diffLines(oldText, newText []byte) *lineDiff {
+	d := &lineDiff{old: splitLines(oldText), new: splitLines(newText)}
+
+	// Trim the common prefix and suffix; they are the common case and cheap.
+	pre := 0
+	for pre < len(d.old) && pre < len(d.new) && d.old[pre] == d.new[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(d.old)-pre && suf < len(d.new)-pre && d.old[len(d.old)-1-suf] == d.new[len(d.new)-1-suf] {
+		suf++
+	}
+	a := d.old[pre : len(d.old)-suf]
+	b := d.new[pre : len(d.new)-suf]
+	if len(a) == 0 && len(b) == 0 {
+		return d
+	}
+	if len(a)*len(b) > maxDiffCells || len(a) == 0 || len(b) == 0 {
+		d.blocks = []block{{pre, pre + len(a), pre, pre + len(b)}}
+		return d
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	var cur *block
+	flush := func() {
+		if cur != nil {
+			d.blocks = append(d.blocks, *cur)
+			cur = nil
+		}
+	}
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			i++
+			j++
+			continue
+		case cur == nil:
+			cur = &block{oldStart: pre + i, oldEnd: pre + i, newStart: pre + j, newEnd: pre + j}
+		}
+		if j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+			i++
+			cur.oldEnd = pre + i
+		} else {
+			j++
+			cur.newEnd = pre + j
+		}
+	}
+	flush()
+	return d
+}
+
+// hunks renders each block of d as a Hunk with surrounding context.
+func (d *lineDiff) hunks() []Hunk {
+	var hunks []Hunk
+	for _, blk := range d.blocks {
+		ctxStart := max(blk.oldStart-contextLines, 0)
+		ctxEnd := min(blk.oldEnd+contextLines, len(d.old))
+		before := blk.oldStart - ctxStart
+		after := ctxEnd - blk.oldEnd
+		h := Hunk{
+			OldStart: ctxStart + 1,
+			OldLines: ctxEnd - ctxStart,
+			NewStart: blk.newStart - before + 1,
+			NewLines: before + (blk.newEnd - blk.newStart) + after,
+		}
+		buf := new(strings.Builder)
+		fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+		writeLines(buf, " ", d.old[ctxStart:blk.oldStart])
+		writeLines(buf, "-", d.old[blk.oldStart:blk.oldEnd])
+		writeLines(buf, "+", d.new[blk.newStart:blk.newEnd])
+		writeLines(buf, " ", d.old[blk.oldEnd:ctxEnd])
+		h.Diff = buf.String()
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+func writeLines(buf *strings.Builder, prefix string, lines []string) {
+	for _, line := range lines {
+		buf.WriteString(prefix)
+		buf.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// selection converts a list of hunk indices into a membership func.
+// A nil list selects all hunks.
+func (d *lineDiff) selection(hunks []int) (func(int) bool, error) {
+	if hunks == nil {
+		return func(int) bool { return true }, nil
+	}
+	set := make(map[int]bool)
+	for _, h := range hunks {
+		if h < 0 || h >= len(d.blocks) {
+			return nil, fmt.Errorf("hunk %d out of range (have %d hunks)", h, len(d.blocks))
+		}
+		set[h] = true
+	}
+	return func(i int) bool { return set[i] }, nil
+}
+
+// apply returns the old text with the blocks for which keep returns true applied.
+func (d *lineDiff) apply(keep func(int) bool) []byte {
+	var out bytes.Buffer
+	pos := 0
+	for i, blk := range d.blocks {
+		for _, line := range d.old[pos:blk.oldStart] {
+			out.WriteString(line)
+		}
+		lines := d.old[blk.oldStart:blk.oldEnd]
+		if keep(i) {
+			lines = d.new[blk.newStart:blk.newEnd]
+		}
+		for _, line := range lines {
+			out.WriteString(line)
+		}
+		pos = blk.oldEnd
+	}
+	for _, line := range d.old[pos:] {
+		out.WriteString(line)
+	}
+	return out.Bytes()
+}
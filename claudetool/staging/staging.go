@@ -0,0 +1,393 @@
+// Package staging holds file modifications in memory until a human approves them.
+//
+// When write approval is enabled, file editing tools write to an Area instead of to disk.
+// The user reviews the aggregated changes and approves (or rejects) them per file or per hunk,
+// or edits them, and only approved hunks are written to disk.
+// Once the user trusts the agent, AutoApprove writes later changes straight to disk.
+package staging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"sketch.dev/claudetool/undo"
+)
+
+// An Area is a set of staged file modifications.
+// It is safe for concurrent use.
+type Area struct {
+	mu          sync.Mutex
+	files       map[string]*stagedFile // keyed by absolute path
+	store       Store                  // nil if the area is not persisted
+	autoApprove bool                   // write changes to disk instead of staging them
+	journal     *undo.Journal          // records writes to disk; nil to record nothing
+}
+
+type stagedFile struct {
+	orig    []byte // contents on disk when first staged (or last approved)
+	existed bool   // whether the file existed on disk when first staged
+	data    []byte // staged contents
+}
+
+// NewArea returns an empty staging area.
+func NewArea() *Area {
+	return &Area{files: make(map[string]*stagedFile)}
+}
+
+// A Store persists the files in an Area, so that staged changes survive a restart.
+type Store interface {
+	// LoadStaged returns all persisted files, keyed by absolute path.
+	LoadStaged() (map[string]File, error)
+	SaveStaged(path string, f File) error
+	DeleteStaged(path string) error
+}
+
+// A File is the persisted form of a staged file.
+type File struct {
+	Orig    []byte `json:"orig"`    // contents on disk when first staged (or last approved)
+	Existed bool   `json:"existed"` // whether the file existed on disk when first staged
+	Data    []byte `json:"data"`    // staged contents
+}
+
+// NewPersistentArea returns a staging area holding the changes persisted in s,
+// which it keeps up to date as changes are staged, approved, and rejected.
+func NewPersistentArea(s Store) (*Area, error) {
+	files, err := s.LoadStaged()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load staged changes: %w", err)
+	}
+	a := &Area{files: make(map[string]*stagedFile), store: s}
+	for path, f := range files {
+		a.files[path] = &stagedFile{orig: f.Orig, existed: f.Existed, data: f.Data}
+	}
+	return a, nil
+}
+
+// persist updates the store with the state of path, if a has a store.
+// a.mu must be held.
+func (a *Area) persist(path string) error {
+	if a.store == nil {
+		return nil
+	}
+	f, ok := a.files[path]
+	if !ok {
+		return a.store.DeleteStaged(path)
+	}
+	return a.store.SaveStaged(path, File{Orig: f.orig, Existed: f.existed, Data: f.data})
+}
+
+// SetJournal has a record its writes to disk in j, so that they can be undone.
+func (a *Area) SetJournal(j *undo.Journal) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.journal = j
+}
+
+// ReadFile returns the staged contents of path, if any, falling back to the contents on disk.
+func (a *Area) ReadFile(path string) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if f, ok := a.files[filepath.Clean(path)]; ok {
+		return slices.Clone(f.data), nil
+	}
+	return os.ReadFile(path)
+}
+
+// WriteFile stages data as the new contents of path.
+// Nothing is written to disk until the change is approved, unless a is auto-approving.
+func (a *Area) WriteFile(path string, data []byte) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("path %q is not absolute", path)
+	}
+	path = filepath.Clean(path)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.autoApprove {
+		before, err := os.ReadFile(path)
+		existed := err == nil
+		if err := writeFile(path, data); err != nil {
+			return err
+		}
+		if err := a.journal.Record(path, before, existed, data); err != nil {
+			return err
+		}
+		if _, ok := a.files[path]; ok {
+			delete(a.files, path)
+			return a.persist(path)
+		}
+		return nil
+	}
+	f, ok := a.files[path]
+	if !ok {
+		orig, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			f = &stagedFile{}
+		case err != nil:
+			return fmt.Errorf("failed to read %q: %w", path, err)
+		default:
+			f = &stagedFile{orig: orig, existed: true}
+		}
+		a.files[path] = f
+	}
+	f.data = slices.Clone(data)
+	return a.persist(path)
+}
+
+// A Change is the set of staged modifications to a single file.
+type Change struct {
+	Path  string `json:"path"`
+	New   bool   `json:"new"` // the file does not yet exist on disk
+	Hunks []Hunk `json:"hunks"`
+}
+
+// A Hunk is a single contiguous modification within a Change.
+// Hunks are identified by their index within the Change.
+type Hunk struct {
+	OldStart int    `json:"old_start"` // 1-based
+	OldLines int    `json:"old_lines"`
+	NewStart int    `json:"new_start"` // 1-based
+	NewLines int    `json:"new_lines"`
+	Diff     string `json:"diff"` // unified diff of the hunk, including its @@ header
+}
+
+// Diff returns a unified diff of all hunks in c.
+func (c Change) Diff() string {
+	buf := new(strings.Builder)
+	from := "a" + c.Path
+	if c.New {
+		from = "/dev/null"
+	}
+	fmt.Fprintf(buf, "--- %s\n+++ b%s\n", from, c.Path)
+	for _, h := range c.Hunks {
+		buf.WriteString(h.Diff)
+	}
+	return buf.String()
+}
+
+// Pending returns all staged changes, sorted by path.
+// Files whose staged contents match the disk are omitted.
+func (a *Area) Pending() []Change {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var changes []Change
+	for path, f := range a.files {
+		d := diffLines(f.orig, f.data)
+		if len(d.blocks) == 0 && f.existed {
+			continue
+		}
+		changes = append(changes, Change{Path: path, New: !f.existed, Hunks: d.hunks()})
+	}
+	slices.SortFunc(changes, func(x, y Change) int { return strings.Compare(x.Path, y.Path) })
+	return changes
+}
+
+// ErrConflict reports that a file changed on disk, by a command or by the user, after changes to it were staged.
+// Writing the changes would overwrite that, so they are discarded instead, to be made again from the file's current contents.
+var ErrConflict = errors.New("changed on disk since its changes were staged")
+
+// checkDisk returns an error wrapping ErrConflict, and discards f, the staged change to path,
+// if path on disk no longer has the contents that f was made against.
+// a.mu must be held.
+func (a *Area) checkDisk(path string, f *stagedFile) error {
+	cur, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if !f.existed {
+			return nil
+		}
+	case err != nil:
+		return fmt.Errorf("failed to read %q: %w", path, err)
+	case f.existed && bytes.Equal(cur, f.orig):
+		return nil
+	}
+	delete(a.files, path)
+	return errors.Join(fmt.Errorf("%s %w; the staged changes were discarded, so read the file again and redo them", path, ErrConflict), a.persist(path))
+}
+
+// Approve writes the given hunks of the staged change to path to disk.
+// If hunks is nil, all hunks are approved.
+// Hunks that are not approved remain staged.
+func (a *Area) Approve(path string, hunks []int) error {
+	path = filepath.Clean(path)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.files[path]
+	if !ok {
+		return fmt.Errorf("no staged changes for %q", path)
+	}
+	d := diffLines(f.orig, f.data)
+	keep, err := d.selection(hunks)
+	if err != nil {
+		return err
+	}
+	if err := a.checkDisk(path, f); err != nil {
+		return err
+	}
+	out := d.apply(keep)
+	if err := writeFile(path, out); err != nil {
+		return err
+	}
+	if err := a.journal.Record(path, f.orig, f.existed, out); err != nil {
+		return err
+	}
+	f.orig = out
+	f.existed = true
+	if slices.Equal(f.orig, f.data) {
+		delete(a.files, path)
+	}
+	return a.persist(path)
+}
+
+// Edit replaces the staged contents of path with data, the user's edit of the staged change.
+// The edit stays staged until it is approved.
+func (a *Area) Edit(path string, data []byte) error {
+	path = filepath.Clean(path)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.files[path]
+	if !ok {
+		return fmt.Errorf("no staged changes for %q", path)
+	}
+	f.data = slices.Clone(data)
+	return a.persist(path)
+}
+
+// Reject discards the given hunks of the staged change to path.
+// If hunks is nil, the whole change is discarded.
+func (a *Area) Reject(path string, hunks []int) error {
+	path = filepath.Clean(path)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.files[path]
+	if !ok {
+		return fmt.Errorf("no staged changes for %q", path)
+	}
+	if hunks == nil {
+		delete(a.files, path)
+		return a.persist(path)
+	}
+	d := diffLines(f.orig, f.data)
+	reject, err := d.selection(hunks)
+	if err != nil {
+		return err
+	}
+	f.data = d.apply(func(i int) bool { return !reject(i) })
+	if slices.Equal(f.orig, f.data) && f.existed {
+		delete(a.files, path)
+	}
+	return a.persist(path)
+}
+
+// A Decision is the user's verdict on one hunk of a staged change.
+type Decision struct {
+	Hunk    int    `json:"hunk"` // index of the hunk, as Pending lists it
+	Accept  bool   `json:"accept"`
+	Comment string `json:"comment,omitempty"` // the user's reason, for the agent
+}
+
+// Review resolves hunks of the staged change to path all at once: accepted hunks are written to disk,
+// and rejected ones are discarded. Hunks without a decision remain staged.
+// It returns the hunks it resolved, in the order of decisions.
+func (a *Area) Review(path string, decisions []Decision) ([]Hunk, error) {
+	path = filepath.Clean(path)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.files[path]
+	if !ok {
+		return nil, fmt.Errorf("no staged changes for %q", path)
+	}
+	d := diffLines(f.orig, f.data)
+	hunks := d.hunks()
+	accept := make(map[int]bool)
+	var resolved []Hunk
+	for _, dec := range decisions {
+		if dec.Hunk < 0 || dec.Hunk >= len(hunks) {
+			return nil, fmt.Errorf("hunk %d out of range (have %d hunks)", dec.Hunk, len(hunks))
+		}
+		if _, dup := accept[dec.Hunk]; dup {
+			return nil, fmt.Errorf("hunk %d has more than one decision", dec.Hunk)
+		}
+		accept[dec.Hunk] = dec.Accept
+		resolved = append(resolved, hunks[dec.Hunk])
+	}
+	if err := a.checkDisk(path, f); err != nil {
+		return nil, err
+	}
+	// The disk gets the accepted hunks; what stays staged loses the rejected ones.
+	staged := d.apply(func(i int) bool {
+		accepted, decided := accept[i]
+		return accepted || !decided
+	})
+	if slices.ContainsFunc(decisions, func(dec Decision) bool { return dec.Accept }) {
+		out := d.apply(func(i int) bool { return accept[i] })
+		if err := writeFile(path, out); err != nil {
+			return nil, err
+		}
+		if err := a.journal.Record(path, f.orig, f.existed, out); err != nil {
+			return nil, err
+		}
+		f.orig = out
+		f.existed = true
+	}
+	f.data = staged
+	if slices.Equal(f.orig, f.data) && (f.existed || len(decisions) > 0) {
+		delete(a.files, path)
+	}
+	return resolved, a.persist(path)
+}
+
+// ApproveAll writes all staged changes to disk.
+func (a *Area) ApproveAll() error {
+	var errs error
+	for _, c := range a.Pending() {
+		errs = errors.Join(errs, a.Approve(c.Path, nil))
+	}
+	return errs
+}
+
+// RejectAll discards all staged changes.
+func (a *Area) RejectAll() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var errs error
+	for path := range a.files {
+		delete(a.files, path)
+		errs = errors.Join(errs, a.persist(path))
+	}
+	return errs
+}
+
+// AutoApprove writes all staged changes to disk, and has a write later changes straight to disk
+// instead of staging them, for as long as it lives.
+func (a *Area) AutoApprove() error {
+	a.mu.Lock()
+	a.autoApprove = true
+	a.mu.Unlock()
+	return a.ApproveAll()
+}
+
+// AutoApproving reports whether a writes changes straight to disk; see AutoApprove.
+func (a *Area) AutoApproving() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.autoApprove
+}
+
+// writeFile writes data to path, creating its directory as needed.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write %q: %w", path, err)
+	}
+	return nil
+}
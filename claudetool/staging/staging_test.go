@@ -0,0 +1,243 @@
+package staging
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffApply(t *testing.T) {
+	tests := []struct {
+		name       string
+		old, new   string
+		wantBlocks int
+	}{
+		{name: "identical", old: "a\nb\n", new: "a\nb\n", wantBlocks: 0},
+		{name: "append", old: "a\n", new: "a\nb\n", wantBlocks: 1},
+		{name: "delete", old: "a\nb\nc\n", new: "a\nc\n", wantBlocks: 1},
+		{name: "two hunks", old: "a\nb\nc\nd\ne\nf\ng\nh\ni\n", new: "A\nb\nc\nd\ne\nf\ng\nh\nI\n", wantBlocks: 2},
+		{name: "no trailing newline", old: "a\nb", new: "a\nc", wantBlocks: 1},
+		{name: "from empty", old: "", new: "x\ny\n", wantBlocks: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := diffLines([]byte(tt.old), []byte(tt.new))
+			if len(d.blocks) != tt.wantBlocks {
+				t.Fatalf("got %d blocks, want %d", len(d.blocks), tt.wantBlocks)
+			}
+			if got := string(d.apply(func(int) bool { return true })); got != tt.new {
+				t.Errorf("apply all = %q, want %q", got, tt.new)
+			}
+			if got := string(d.apply(func(int) bool { return false })); got != tt.old {
+				t.Errorf("apply none = %q, want %q", got, tt.old)
+			}
+		})
+	}
+}
+
+func TestAreaApproveReject(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	orig := "one\n2\n3\n4\n5\n6\n7\n8\nnine\n"
+	if err := os.WriteFile(path, []byte(orig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewArea()
+	staged := "ONE\n2\n3\n4\n5\n6\n7\n8\nNINE\n"
+	if err := a.WriteFile(path, []byte(staged)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing hits the disk until approval.
+	if got, _ := os.ReadFile(path); string(got) != orig {
+		t.Fatalf("file modified before approval: %q", got)
+	}
+	if got, _ := a.ReadFile(path); string(got) != staged {
+		t.Fatalf("ReadFile = %q, want staged contents", got)
+	}
+
+	pending := a.Pending()
+	if len(pending) != 1 || len(pending[0].Hunks) != 2 {
+		t.Fatalf("Pending = %+v, want one change with two hunks", pending)
+	}
+	if diff := pending[0].Diff(); !strings.Contains(diff, "-one\n+ONE\n") {
+		t.Errorf("Diff missing first hunk:\n%s", diff)
+	}
+
+	// Approve the first hunk only.
+	if err := a.Approve(path, []int{0}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "ONE\n2\n3\n4\n5\n6\n7\n8\nnine\n" {
+		t.Fatalf("after approving hunk 0, file = %q", got)
+	}
+	pending = a.Pending()
+	if len(pending) != 1 || len(pending[0].Hunks) != 1 {
+		t.Fatalf("Pending = %+v, want one remaining hunk", pending)
+	}
+
+	// Reject the rest.
+	if err := a.Reject(path, []int{0}); err != nil {
+		t.Fatal(err)
+	}
+	if pending := a.Pending(); len(pending) != 0 {
+		t.Fatalf("Pending = %+v, want none", pending)
+	}
+	if err := a.Approve(path, nil); err == nil {
+		t.Errorf("Approve with nothing staged succeeded, want error")
+	}
+}
+
+func TestAreaReview(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	orig := "one\n2\n3\n4\n5\n6\n7\n8\nnine\n10\n11\n12\n13\n14\n15\n16\nseventeen\n"
+	if err := os.WriteFile(path, []byte(orig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a := NewArea()
+	if err := a.WriteFile(path, []byte("ONE\n2\n3\n4\n5\n6\n7\n8\nNINE\n10\n11\n12\n13\n14\n15\n16\nSEVENTEEN\n")); err != nil {
+		t.Fatal(err)
+	}
+	if pending := a.Pending(); len(pending) != 1 || len(pending[0].Hunks) != 3 {
+		t.Fatalf("Pending = %+v, want one change with three hunks", pending)
+	}
+
+	if _, err := a.Review(path, []Decision{{Hunk: 0, Accept: true}, {Hunk: 0}}); err == nil {
+		t.Error("Review with two decisions for one hunk succeeded")
+	}
+	if _, err := a.Review(path, []Decision{{Hunk: 3}}); err == nil {
+		t.Error("Review of a hunk out of range succeeded")
+	}
+
+	// Accept the first hunk, reject the last, and leave the middle one staged.
+	resolved, err := a.Review(path, []Decision{{Hunk: 0, Accept: true}, {Hunk: 2, Comment: "keep it lowercase"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resolved) != 2 || !strings.Contains(resolved[0].Diff, "+ONE") || !strings.Contains(resolved[1].Diff, "+SEVENTEEN") {
+		t.Errorf("Review resolved %+v, want the first and last hunks", resolved)
+	}
+	if got, _ := os.ReadFile(path); string(got) != strings.Replace(orig, "one", "ONE", 1) {
+		t.Errorf("after review, file = %q", got)
+	}
+	pending := a.Pending()
+	if len(pending) != 1 || len(pending[0].Hunks) != 1 || !strings.Contains(pending[0].Hunks[0].Diff, "+NINE") {
+		t.Fatalf("Pending = %+v, want only the middle hunk", pending)
+	}
+
+	// Rejecting the last hunk leaves nothing staged.
+	if _, err := a.Review(path, []Decision{{Hunk: 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if pending := a.Pending(); len(pending) != 0 {
+		t.Errorf("Pending = %+v, want none", pending)
+	}
+}
+
+func TestAreaNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "new.txt")
+	a := NewArea()
+	if err := a.WriteFile(path, []byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	pending := a.Pending()
+	if len(pending) != 1 || !pending[0].New {
+		t.Fatalf("Pending = %+v, want one new file", pending)
+	}
+	if err := a.Approve(path, []int{5}); err == nil {
+		t.Errorf("Approve with out of range hunk succeeded, want error")
+	}
+	if err := a.ApproveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "hello\n" {
+		t.Errorf("file = %q, want hello", got)
+	}
+}
+
+func TestAreaEditAutoApprove(t *testing.T) {
+	dir := t.TempDir()
+	a := NewArea()
+	edited := filepath.Join(dir, "edited.txt")
+	if err := a.WriteFile(edited, []byte("agent\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Edit(edited, []byte("user\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := a.ReadFile(edited); string(got) != "user\n" {
+		t.Errorf("ReadFile after Edit = %q, want the user's edit", got)
+	}
+	if err := a.Edit(filepath.Join(dir, "unstaged.txt"), []byte("x\n")); err == nil {
+		t.Error("Edit of a file with nothing staged succeeded, want error")
+	}
+
+	if err := a.AutoApprove(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(edited); string(got) != "user\n" {
+		t.Errorf("after AutoApprove, file = %q, want the staged edit", got)
+	}
+	later := filepath.Join(dir, "later.txt")
+	if err := a.WriteFile(later, []byte("later\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(later); string(got) != "later\n" {
+		t.Errorf("write after AutoApprove = %q, want it on disk", got)
+	}
+	if pending := a.Pending(); len(pending) != 0 || !a.AutoApproving() {
+		t.Errorf("Pending = %+v, AutoApproving = %v; want nothing staged while auto-approving", pending, a.AutoApproving())
+	}
+}
+
+func TestAreaConflict(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a := NewArea()
+	if err := a.WriteFile(path, []byte("ONE\ntwo\n")); err != nil {
+		t.Fatal(err)
+	}
+	// A command or the user changes the file after the change was staged.
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Approve(path, nil); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Approve of a file changed on disk = %v, want ErrConflict", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "one\ntwo\nthree\n" {
+		t.Errorf("after the conflict, file = %q, want the change on disk kept", got)
+	}
+	if pending := a.Pending(); len(pending) != 0 {
+		t.Errorf("Pending = %+v, want the stale change discarded", pending)
+	}
+
+	// Staged again, from the current contents, the change applies.
+	if err := a.WriteFile(path, []byte("ONE\ntwo\nthree\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Review(path, []Decision{{Hunk: 0, Accept: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "ONE\ntwo\nthree\n" {
+		t.Errorf("after staging again, file = %q", got)
+	}
+
+	// A new file that appears on disk before it is approved conflicts too.
+	created := filepath.Join(dir, "new.txt")
+	if err := a.WriteFile(created, []byte("agent\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(created, []byte("user\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Review(created, []Decision{{Hunk: 0, Accept: true}}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Review of a new file created on disk = %v, want ErrConflict", err)
+	}
+}
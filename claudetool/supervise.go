@@ -0,0 +1,217 @@
+package claudetool
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// A Supervisor runs background commands under the operating system's service manager,
+// systemd on Linux and launchd on macOS, instead of as bare detached process groups.
+// The service manager tracks every process a command starts, however it detaches,
+// so that stopping the command stops all of them; it can also limit their resources.
+type Supervisor struct {
+	// Properties are systemd unit properties for each command, such as MemoryMax=2G or CPUQuota=200%.
+	// launchd has no equivalent, so they are ignored on macOS.
+	Properties []string
+}
+
+// supervisorAvailable reports whether the service manager of this system can supervise commands.
+// systemd-run is often installed where there is no systemd user instance to talk to, such as in containers.
+var supervisorAvailable = sync.OnceValue(func() bool {
+	switch runtime.GOOS {
+	case "linux":
+		return exec.Command("systemd-run", "--user", "--scope", "--quiet", "true").Run() == nil
+	case "darwin":
+		_, err := exec.LookPath("launchctl")
+		return err == nil
+	}
+	return false
+})
+
+// supervisedJobs numbers the commands this process runs under a Supervisor, to name their units.
+var supervisedJobs atomic.Uint64
+
+// start starts req's command in the background under the service manager,
+// with its output in files in outDir, or, if outDir is "", in a new temporary directory.
+func (s *Supervisor) start(ctx context.Context, req bashInput, outDir string) (*BackgroundResult, error) {
+	dir, err := backgroundOutputDir(outDir)
+	if err != nil {
+		return nil, err
+	}
+	result := &BackgroundResult{
+		StdoutFile: filepath.Join(dir, "stdout"),
+		StderrFile: filepath.Join(dir, "stderr"),
+		exit:       &jobExit{},
+	}
+	name := fmt.Sprintf("sketch-bg-%d-%d", os.Getpid(), supervisedJobs.Add(1))
+	var stop func() error
+	switch runtime.GOOS {
+	case "linux":
+		stop, err = s.startSystemd(ctx, req, name, result)
+	case "darwin":
+		stop, err = startLaunchd(ctx, req, name, result)
+	default:
+		err = fmt.Errorf("no service manager on %s", runtime.GOOS)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := req.timeout()
+	time.AfterFunc(timeout, func() {
+		result.exit.stopped.Store(true)
+		if err := stop(); err != nil {
+			slog.WarnContext(ctx, "bash_supervised_stop_failed", "unit", result.Unit, "error", err)
+		}
+	})
+	return result, nil
+}
+
+// startSystemd runs req's command in a transient systemd scope named name,
+// and returns a function that stops the scope and every process in it.
+// A scope, unlike a service, leaves the command a child of sketch, with sketch's environment.
+func (s *Supervisor) startSystemd(ctx context.Context, req bashInput, name string, result *BackgroundResult) (func() error, error) {
+	result.Unit = name + ".scope"
+	result.StopCommand = "systemctl --user stop " + result.Unit
+
+	args := []string{"--user", "--scope", "--quiet", "--collect", "--unit=" + result.Unit}
+	for _, p := range s.Properties {
+		args = append(args, "--property="+p)
+	}
+	args = append(args, "--", "bash", "-c", req.Command)
+	cmd := exec.Command("systemd-run", args...)
+	cmd.Dir = WorkingDir(ctx)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = req.environ()
+
+	stdout, err := os.Create(result.StdoutFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout file: %w", err)
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(result.StderrFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr file: %w", err)
+	}
+	defer stderr.Close()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start systemd-run: %w", err)
+	}
+	// systemd-run execs the command once the scope exists, so this is the command's PID.
+	result.PID = cmd.Process.Pid
+	result.exit.done = make(chan struct{})
+	go func() {
+		result.exit.err = cmd.Wait()
+		close(result.exit.done)
+	}()
+
+	return func() error {
+		return exec.Command("systemctl", "--user", "stop", result.Unit).Run()
+	}, nil
+}
+
+// startLaunchd runs req's command as a launchd job in the user's GUI domain, labeled name,
+// and returns a function that removes the job, which kills its processes.
+func startLaunchd(ctx context.Context, req bashInput, name string, result *BackgroundResult) (func() error, error) {
+	label := "dev.sketch." + name
+	target := fmt.Sprintf("gui/%d/%s", os.Getuid(), label)
+	result.Unit = label
+	result.StopCommand = "launchctl bootout " + target
+
+	// launchd starts jobs with its own environment, not sketch's.
+	env := make(map[string]string)
+	for _, kv := range req.environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	plist := filepath.Join(filepath.Dir(result.StdoutFile), label+".plist")
+	if err := os.WriteFile(plist, launchdPlist(label, WorkingDir(ctx), req.Command, result, env), 0o600); err != nil {
+		return nil, err
+	}
+	domain := fmt.Sprintf("gui/%d", os.Getuid())
+	if out, err := exec.Command("launchctl", "bootstrap", domain, plist).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("launchctl bootstrap: %s: %w", bytes.TrimSpace(out), err)
+	}
+
+	// The PID is only known once launchd has started the job.
+	for range 20 {
+		out, err := exec.Command("launchctl", "print", target).Output()
+		if err == nil {
+			if m := launchdPID.FindSubmatch(out); m != nil {
+				result.PID, _ = strconv.Atoi(string(m[1]))
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return func() error {
+		return exec.Command("launchctl", "bootout", target).Run()
+	}, nil
+}
+
+var launchdPID = regexp.MustCompile(`(?m)^\s*pid = (\d+)$`)
+
+// launchdPlist returns a launchd job definition that runs command once, in dir, with env,
+// writing its output to result's files.
+func launchdPlist(label, dir, command string, result *BackgroundResult, env map[string]string) []byte {
+	var b bytes.Buffer
+	str := func(s string) {
+		b.WriteString("<string>")
+		xml.EscapeText(&b, []byte(s))
+		b.WriteString("</string>")
+	}
+	key := func(k string) {
+		b.WriteString("<key>")
+		xml.EscapeText(&b, []byte(k))
+		b.WriteString("</key>")
+	}
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0"><dict>`)
+	key("Label")
+	str(label)
+	key("ProgramArguments")
+	b.WriteString("<array>")
+	str("bash")
+	str("-c")
+	str(command)
+	b.WriteString("</array>")
+	key("WorkingDirectory")
+	str(dir)
+	key("StandardOutPath")
+	str(result.StdoutFile)
+	key("StandardErrorPath")
+	str(result.StderrFile)
+	key("EnvironmentVariables")
+	b.WriteString("<dict>")
+	for k, v := range env {
+		key(k)
+		str(v)
+	}
+	b.WriteString("</dict>")
+	key("RunAtLoad")
+	b.WriteString("<true/>")
+	key("KeepAlive")
+	b.WriteString("<false/>")
+	b.WriteString("</dict></plist>\n")
+	return b.Bytes()
+}
@@ -0,0 +1,64 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLaunchdPlist(t *testing.T) {
+	result := &BackgroundResult{StdoutFile: "/tmp/out", StderrFile: "/tmp/err"}
+	plist := launchdPlist("dev.sketch.x", "/repo", `echo "<hi>" && sleep 1`, result, map[string]string{"PATH": "/bin"})
+
+	// Every token must be well-formed XML, with the command intact.
+	dec := xml.NewDecoder(strings.NewReader(string(plist)))
+	var text []string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("malformed plist: %v\n%s", err, plist)
+			}
+			break
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			text = append(text, string(cd))
+		}
+	}
+	for _, want := range []string{"dev.sketch.x", `echo "<hi>" && sleep 1`, "/repo", "/tmp/out", "/tmp/err", "PATH", "/bin"} {
+		if !strings.Contains(strings.Join(text, "\n"), want) {
+			t.Errorf("plist lacks %q:\n%s", want, plist)
+		}
+	}
+}
+
+func TestBashSupervisorFallback(t *testing.T) {
+	if supervisorAvailable() {
+		t.Skip("this system's service manager is available")
+	}
+	// Without a service manager, supervised commands run as bare process groups.
+	tool := (&BashTool{Supervisor: &Supervisor{Properties: []string{"MemoryMax=1G"}}}).Tool()
+	result, err := tool.Run(context.Background(), json.RawMessage(`{"command":"echo hello","background":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bg BackgroundResult
+	if err := json.Unmarshal([]byte(result[0].Text), &bg); err != nil {
+		t.Fatal(err)
+	}
+	if bg.PID == 0 || bg.Unit != "" || bg.StopCommand != "" {
+		t.Errorf("result %+v; want an unsupervised job", bg)
+	}
+	for range 50 {
+		if out, _ := os.ReadFile(bg.StdoutFile); strings.Contains(string(out), "hello") {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("background command printed nothing to %s", bg.StdoutFile)
+}
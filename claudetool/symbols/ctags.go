@@ -0,0 +1,126 @@
+package symbols
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	ctagsOnce sync.Once
+	ctagsPath string
+)
+
+// universalCtags returns the path of Universal Ctags, or "" if it is not installed.
+// Other ctags, such as Exuberant Ctags and BSD ctags, cannot write JSON.
+func universalCtags() string {
+	ctagsOnce.Do(func() {
+		for _, name := range []string{"ctags", "universal-ctags", "uctags"} {
+			p, err := exec.LookPath(name)
+			if err != nil {
+				continue
+			}
+			out, err := exec.Command(p, "--version").Output()
+			if err == nil && bytes.Contains(out, []byte("Universal Ctags")) {
+				ctagsPath = p
+				return
+			}
+		}
+	})
+	return ctagsPath
+}
+
+// ctagsKinds are how ctags' kinds of symbols are named here; kinds mapped to "" are left out.
+var ctagsKinds = map[string]string{
+	"member":         "method",
+	"func":           "function",
+	"typedef":        "type",
+	"alias":          "type",
+	"namespace":      "module",
+	"package":        "module",
+	"implementation": "impl",
+	"enumerator":     "",
+	"local":          "",
+	"parameter":      "",
+	"label":          "",
+	"unknown":        "",
+	"field":          "",
+	"property":       "",
+	"import":         "",
+	"heading1":       "",
+	"heading2":       "",
+	"heading3":       "",
+}
+
+// ctagsTag is a tag as Universal Ctags writes it in JSON.
+type ctagsTag struct {
+	Type      string `json:"_type"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Line      int    `json:"line"`
+	End       int    `json:"end"`
+	Kind      string `json:"kind"`
+	Scope     string `json:"scope"`
+	ScopeKind string `json:"scopeKind"`
+	Signature string `json:"signature"`
+	Pattern   string `json:"pattern"`
+}
+
+// extractCtags returns the symbols in files, relative to root, as Universal Ctags at ctags finds them.
+func extractCtags(ctx context.Context, ctags, root string, files []string) ([]Symbol, error) {
+	cmd := exec.CommandContext(ctx, ctags, "--output-format=json", "--fields=+neKS", "--extras=-F", "-f", "-", "-L", "-")
+	cmd.Dir = root
+	cmd.Stdin = strings.NewReader(strings.Join(files, "\n"))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ctags: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseCtags(out), nil
+}
+
+// parseCtags parses the JSON lines that Universal Ctags writes, skipping those it cannot.
+func parseCtags(out []byte) []Symbol {
+	var syms []Symbol
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var t ctagsTag
+		if json.Unmarshal(s.Bytes(), &t) != nil || t.Type != "tag" {
+			continue
+		}
+		kind, ok := ctagsKinds[t.Kind]
+		if !ok {
+			kind = t.Kind
+		}
+		if kind == "" || t.ScopeKind == "function" || t.ScopeKind == "method" {
+			continue // locals
+		}
+		if kind == "function" && (t.ScopeKind == "class" || t.ScopeKind == "struct" || t.ScopeKind == "interface" || t.ScopeKind == "implementation" || t.ScopeKind == "trait") {
+			kind = "method"
+		}
+		sig := strings.TrimSuffix(strings.TrimPrefix(t.Pattern, "/^"), "$/")
+		sig = signature(strings.ReplaceAll(sig, `\/`, "/"))
+		if sig == "" {
+			sig = t.Name + t.Signature
+		}
+		syms = append(syms, Symbol{
+			Name:      t.Name,
+			Kind:      kind,
+			Container: t.Scope,
+			Path:      filepath.ToSlash(t.Path),
+			Line:      t.Line,
+			EndLine:   t.End,
+			Signature: sig,
+		})
+	}
+	sortSymbols(syms)
+	return syms
+}
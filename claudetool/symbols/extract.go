@@ -0,0 +1,396 @@
+package symbols
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Where a rule applies.
+const (
+	anywhere = iota // at the top level, or directly in a container
+	topLevel        // not directly in a type's body
+	member          // directly in a type's body
+)
+
+// A rule recognizes one kind of declaration, by its first line.
+type rule struct {
+	re        *regexp.Regexp // group 1 is the name; or, if kindGroup is set, group 2
+	kind      string
+	kindGroup bool // group 1 is the kind, group 2 the name
+	where     int
+	container bool // members declared in its body belong to it
+}
+
+const (
+	jsName    = `([A-Za-z_$][\w$]*)`
+	jsPrefix  = `^\s*(?:export\s+)?(?:default\s+)?(?:declare\s+)?`
+	javaAnnot = `(?:@[\w.]+(?:\([^)]*\))?\s+)*`
+	rustVis   = `^\s*(?:pub(?:\([^)]*\))?\s+)?`
+)
+
+// tsRules are TypeScript's and JavaScript's declarations.
+var tsRules = []rule{
+	{re: regexp.MustCompile(jsPrefix + `(?:abstract\s+)?class\s+` + jsName), kind: "class", where: anywhere, container: true},
+	{re: regexp.MustCompile(jsPrefix + `interface\s+` + jsName), kind: "interface", where: topLevel, container: true},
+	{re: regexp.MustCompile(jsPrefix + `(?:const\s+)?enum\s+` + jsName), kind: "enum", where: topLevel},
+	{re: regexp.MustCompile(jsPrefix + `(?:namespace|module)\s+([A-Za-z_$][\w$.]*)\s*\{`), kind: "module", where: topLevel, container: true},
+	{re: regexp.MustCompile(jsPrefix + `type\s+` + jsName + `\s*(?:<[^=]*>)?\s*=`), kind: "type", where: topLevel},
+	{re: regexp.MustCompile(jsPrefix + `(?:async\s+)?function\s*\*?\s*` + jsName), kind: "function", where: topLevel},
+	{re: regexp.MustCompile(`^\s*(?:export\s+)?(?:const|let|var)\s+` + jsName + `\s*(?::[^=]+)?=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*(?::[^=]+)?=>|[A-Za-z_$][\w$]*\s*=>)`), kind: "function", where: topLevel},
+	{re: regexp.MustCompile(`^export\s+(?:const|let|var)\s+` + jsName), kind: "variable", where: topLevel},
+	{re: regexp.MustCompile(`^\s*(?:(?:public|private|protected|static|readonly|abstract|override|async|declare|get|set)\s+)*\*?(#?[A-Za-z_$][\w$]*)\s*[?!]?\s*(?:<[^>(]*>)?\s*\(`), kind: "method", where: member},
+}
+
+// javaRules are Java's declarations.
+var javaRules = []rule{
+	{re: regexp.MustCompile(`^\s*` + javaAnnot + `(?:(?:public|private|protected|static|final|abstract|sealed|non-sealed|strictfp)\s+)*(class|interface|enum|record|@interface)\s+([A-Za-z_$][\w$]*)`), kindGroup: true, where: anywhere, container: true},
+	{re: regexp.MustCompile(`^\s*` + javaAnnot + `(?:(?:public|private|protected|static|final|abstract|synchronized|native|default|strictfp)\s+)*(?:<[^>]+>\s+)?(?:[\w$.\[\]?]+(?:<[^()]*>)?(?:\[\])*\s+)?([A-Za-z_$][\w$]*)\s*\(`), kind: "method", where: member},
+}
+
+// rustRules are Rust's declarations.
+var rustRules = []rule{
+	{re: regexp.MustCompile(rustVis + `(?:default\s+)?(?:const\s+)?(?:async\s+)?(?:unsafe\s+)?(?:extern\s+"[^"]*"\s+)?fn\s+([A-Za-z_]\w*)`), kind: "function", where: anywhere},
+	{re: regexp.MustCompile(rustVis + `struct\s+([A-Za-z_]\w*)`), kind: "struct", where: topLevel},
+	{re: regexp.MustCompile(rustVis + `enum\s+([A-Za-z_]\w*)`), kind: "enum", where: topLevel},
+	{re: regexp.MustCompile(rustVis + `union\s+([A-Za-z_]\w*)`), kind: "struct", where: topLevel},
+	{re: regexp.MustCompile(rustVis + `(?:unsafe\s+)?trait\s+([A-Za-z_]\w*)`), kind: "trait", where: topLevel, container: true},
+	{re: regexp.MustCompile(`^\s*(?:unsafe\s+)?impl(?:\s*<[^{]*?>)?\s+(?:[!\w:<>, ]+?\s+for\s+)?&?(?:dyn\s+)?([A-Za-z_][\w:]*)`), kind: "impl", where: topLevel, container: true},
+	{re: regexp.MustCompile(rustVis + `mod\s+([A-Za-z_]\w*)\s*\{`), kind: "module", where: topLevel, container: true},
+	{re: regexp.MustCompile(rustVis + `type\s+([A-Za-z_]\w*)`), kind: "type", where: anywhere},
+	{re: regexp.MustCompile(rustVis + `(?:const|static(?:\s+mut)?)\s+([A-Za-z_]\w*)\s*:`), kind: "constant", where: anywhere},
+	{re: regexp.MustCompile(`^\s*(?:#\[macro_export\]\s*)?macro_rules!\s*([A-Za-z_]\w*)`), kind: "macro", where: topLevel},
+}
+
+// notNames are keywords that rules for methods would otherwise take for their names.
+var notNames = []string{"if", "for", "while", "switch", "catch", "return", "new", "throw", "else", "do", "try", "super", "this", "function", "synchronized"}
+
+// A frame is a symbol whose body is open.
+type frame struct {
+	sym       int // index in the symbols
+	depth     int // brace depth outside the body
+	container bool
+}
+
+// extractBraces extracts the symbols of lang, a language whose blocks are braces, from src.
+func extractBraces(lang string, src []byte) []Symbol {
+	var rules []rule
+	switch lang {
+	case "typescript", "javascript":
+		rules = tsRules
+	case "java":
+		rules = javaRules
+	case "rust":
+		rules = rustRules
+	}
+	var (
+		syms    []Symbol
+		stack   []frame
+		depth   int
+		pending *frame // a symbol whose body opens on a later line, as in brace-on-next-line style
+		strip   = stripper{lang: lang}
+	)
+	for i, raw := range strings.Split(string(src), "\n") {
+		line := strip.line(raw)
+		opens, closes := strings.Count(line, "{"), strings.Count(line, "}")
+
+		// Where is this line? Declarations in function bodies and other blocks are not symbols.
+		where, container := topLevel, ""
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+			switch {
+			case !top.container || depth != top.depth+1:
+				where = -1
+			case syms[top.sym].Kind == "module":
+				container = syms[top.sym].QualifiedName()
+			default:
+				where, container = member, syms[top.sym].QualifiedName()
+			}
+		}
+
+		matched := false
+		for _, r := range rules {
+			if where < 0 || r.where != anywhere && r.where != where {
+				continue
+			}
+			m := r.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			name, kind := m[1], r.kind
+			if r.kindGroup {
+				kind, name = m[1], m[2]
+				switch kind {
+				case "@interface":
+					kind = "annotation"
+				case "record":
+					kind = "class"
+				}
+			}
+			if slices.Contains(notNames, name) {
+				continue
+			}
+			if kind == "function" && where == member {
+				kind = "method"
+			}
+			if kind == "impl" {
+				name = name[strings.LastIndex(name, ":")+1:]
+			}
+			syms = append(syms, Symbol{Name: name, Kind: kind, Container: container, Line: i + 1, Signature: signature(raw)})
+			matched = true
+			f := frame{sym: len(syms) - 1, depth: depth, container: r.container}
+			trimmed := strings.TrimSpace(line)
+			switch {
+			case opens > closes:
+				stack = append(stack, f)
+				pending = nil
+			case opens > 0 || strings.HasSuffix(trimmed, ";") || strings.HasSuffix(trimmed, ","):
+				syms[f.sym].EndLine = i + 1 // a one-line body, or none
+				pending = nil
+			default:
+				pending = &f
+			}
+			break
+		}
+		if !matched && pending != nil {
+			switch {
+			case opens > closes:
+				stack = append(stack, *pending)
+				pending = nil
+			case strings.HasSuffix(strings.TrimSpace(line), ";"):
+				syms[pending.sym].EndLine = i + 1 // a declaration without a body
+				pending = nil
+			}
+		}
+
+		depth += opens - closes
+		for len(stack) > 0 && depth <= stack[len(stack)-1].depth {
+			syms[stack[len(stack)-1].sym].EndLine = i + 1
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return syms
+}
+
+// A stripper removes comments and the contents of string literals from lines of source,
+// so that braces in them are not counted, and declarations in them are not found.
+// Block comments, and strings that span lines (JavaScript's template literals, Java's text blocks,
+// and Rust's strings), carry over from one line to the next.
+type stripper struct {
+	lang    string
+	comment bool   // in a block comment
+	end     string // in a string that spans lines, which this closes
+	escapes bool   // whether backslashes escape characters in that string
+}
+
+func (s *stripper) line(line string) string {
+	var b strings.Builder
+	i := 0
+	if s.end != "" {
+		if i = s.skipString(line, 0); i < 0 {
+			return ""
+		}
+		b.WriteString(s.end)
+		s.end = ""
+	}
+	for ; i < len(line); i++ {
+		c := line[i]
+		open := "" // the start of a string that may span lines
+		if s.comment {
+			if c == '*' && i+1 < len(line) && line[i+1] == '/' {
+				s.comment = false
+				i++
+			}
+			continue
+		}
+		switch {
+		case c == '/' && i+1 < len(line) && line[i+1] == '/':
+			return b.String()
+		case c == '/' && i+1 < len(line) && line[i+1] == '*':
+			s.comment = true
+			i++
+		case s.lang == "java" && strings.HasPrefix(line[i:], `"""`):
+			open, s.end, s.escapes = `"""`, `"""`, true
+		case s.lang == "rust" && c == 'r' && (i == 0 || !isIdent(line[i-1])) && rawStringStart(line[i+1:]) > 0:
+			n := rawStringStart(line[i+1:])
+			open, s.end, s.escapes = line[i:i+1+n], `"`+strings.Repeat("#", n-1), false
+		case c == '`' && (s.lang == "typescript" || s.lang == "javascript"), c == '"' && s.lang == "rust":
+			open, s.end, s.escapes = string(c), string(c), true
+		case c == '"' || c == '\'' && (s.lang == "typescript" || s.lang == "javascript"):
+			// Skip to the end of the string, keeping its quotes.
+			b.WriteByte(c)
+			for i++; i < len(line) && line[i] != c; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+			b.WriteByte(c)
+			continue
+		case c == '\'':
+			// A character literal, or in Rust, perhaps a lifetime.
+			switch {
+			case i+2 < len(line) && line[i+2] == '\'':
+				b.WriteString("''")
+				i += 2
+			case i+3 < len(line) && line[i+1] == '\\':
+				end := strings.IndexByte(line[i+2:], '\'')
+				if end < 0 {
+					return b.String()
+				}
+				b.WriteString("''")
+				i += 2 + end
+			default:
+				b.WriteByte(c)
+			}
+			continue
+		default:
+			b.WriteByte(c)
+			continue
+		}
+		if open != "" {
+			// Keep the string's delimiters, and skip its contents, which may go on to the next lines.
+			b.WriteString(open)
+			end := s.skipString(line, i+len(open))
+			if end < 0 {
+				return b.String()
+			}
+			b.WriteString(s.end)
+			s.end = ""
+			i = end - 1
+		}
+	}
+	return b.String()
+}
+
+// skipString returns the index in line just past the end of the string that s is in, from start,
+// or -1 if the string goes on to the next line.
+func (s *stripper) skipString(line string, start int) int {
+	for i := start; i < len(line); i++ {
+		switch {
+		case s.escapes && line[i] == '\\':
+			i++
+		case strings.HasPrefix(line[i:], s.end):
+			return i + len(s.end)
+		}
+	}
+	return -1
+}
+
+// rawStringStart returns the length of the start of a Rust raw string after its r: some #s, then a quote; or 0.
+func rawStringStart(s string) int {
+	n := 0
+	for n < len(s) && s[n] == '#' {
+		n++
+	}
+	if n < len(s) && s[n] == '"' {
+		return n + 1
+	}
+	return 0
+}
+
+// isIdent reports whether c can be part of an identifier.
+func isIdent(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+var (
+	pythonDef   = regexp.MustCompile(`^(\s*)(?:async\s+)?def\s+([A-Za-z_]\w*)`)
+	pythonClass = regexp.MustCompile(`^(\s*)class\s+([A-Za-z_]\w*)`)
+)
+
+// extractPython extracts Python's classes, functions, and methods from src, following indentation.
+func extractPython(src []byte) []Symbol {
+	type frame struct {
+		sym    int
+		indent int
+	}
+	var (
+		syms     []Symbol
+		stack    []frame
+		lastCode int    // the last line with code
+		quote    string // the delimiter of the multi-line string the line is in, if any
+		brackets int    // how many brackets are open: the line continues the one they opened on
+	)
+	lines := strings.Split(string(src), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if quote != "" {
+			if strings.Count(line, quote)%2 == 1 {
+				quote = ""
+			}
+			lastCode = i + 1
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if brackets > 0 {
+			brackets = max(brackets+countBrackets(line), 0)
+			lastCode = i + 1
+			continue
+		}
+		brackets = max(countBrackets(line), 0)
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		for len(stack) > 0 && indent <= stack[len(stack)-1].indent {
+			syms[stack[len(stack)-1].sym].EndLine = lastCode
+			stack = stack[:len(stack)-1]
+		}
+		lastCode = i + 1
+		for _, q := range []string{`"""`, `'''`} {
+			if strings.Count(line, q)%2 == 1 {
+				quote = q
+			}
+		}
+
+		kind, m := "class", pythonClass.FindStringSubmatch(line)
+		if m == nil {
+			kind, m = "function", pythonDef.FindStringSubmatch(line)
+		}
+		if m == nil {
+			continue
+		}
+		container := ""
+		if len(stack) > 0 {
+			top := syms[stack[len(stack)-1].sym]
+			if top.Kind != "class" {
+				continue // declared in a function: local
+			}
+			container = top.QualifiedName()
+			if kind == "function" {
+				kind = "method"
+			}
+		}
+		syms = append(syms, Symbol{Name: m[2], Kind: kind, Container: container, Line: i + 1, Signature: signature(strings.TrimSuffix(trimmed, ":"))})
+		stack = append(stack, frame{sym: len(syms) - 1, indent: indent})
+	}
+	for _, f := range stack {
+		syms[f.sym].EndLine = lastCode
+	}
+	return syms
+}
+
+// countBrackets returns how many more brackets line opens than it closes, outside strings and comments.
+func countBrackets(line string) int {
+	n := 0
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '#':
+			return n
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			n++
+		case c == ')' || c == ']' || c == '}':
+			n--
+		}
+	}
+	return n
+}
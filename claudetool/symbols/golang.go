@@ -0,0 +1,83 @@
+package symbols
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// extractGo returns the top-level declarations in Go source src, and the methods of its types.
+// A file with syntax errors yields the declarations parsed before them.
+func extractGo(name string, src []byte) ([]Symbol, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, parser.SkipObjectResolution)
+	if f == nil {
+		return nil, err
+	}
+	lines := strings.Split(string(src), "\n")
+	sym := func(name, kind, container string, node ast.Node) Symbol {
+		start, end := fset.Position(node.Pos()).Line, fset.Position(node.End()).Line
+		s := Symbol{Name: name, Kind: kind, Container: container, Line: start, EndLine: end}
+		if start >= 1 && start <= len(lines) {
+			s.Signature = signature(lines[start-1])
+		}
+		return s
+	}
+	var syms []Symbol
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				syms = append(syms, sym(d.Name.Name, "function", "", d))
+			} else {
+				syms = append(syms, sym(d.Name.Name, "method", receiverType(d.Recv.List[0].Type), d))
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					kind := "type"
+					switch s.Type.(type) {
+					case *ast.StructType:
+						kind = "struct"
+					case *ast.InterfaceType:
+						kind = "interface"
+					}
+					syms = append(syms, sym(s.Name.Name, kind, "", s))
+				case *ast.ValueSpec:
+					kind := "variable"
+					if d.Tok == token.CONST {
+						kind = "constant"
+					}
+					for _, n := range s.Names {
+						if n.Name != "_" {
+							syms = append(syms, sym(n.Name, kind, "", s))
+						}
+					}
+				}
+			}
+		}
+	}
+	return syms, nil
+}
+
+// receiverType returns the name of a method receiver's type, without pointers or type parameters.
+func receiverType(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
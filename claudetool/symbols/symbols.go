@@ -0,0 +1,111 @@
+// Package symbols finds the declarations in source files — functions, methods, classes, and types —
+// for languages other than Go, which gopls does not cover: TypeScript, JavaScript, Python, Rust, and Java,
+// and Go too, for a uniform view. It backs the symbols tool.
+//
+// When Universal Ctags is installed, it extracts the symbols, for any language it knows.
+// Otherwise a built-in extractor does, following each language's declarations line by line,
+// with braces or indentation for nesting. Tree-sitter grammars would be more exact,
+// but they need cgo, and sketch is built without it.
+package symbols
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Languages the built-in extractor knows, by file extension.
+var languages = map[string]string{
+	".go":   "go",
+	".ts":   "typescript",
+	".tsx":  "typescript",
+	".mts":  "typescript",
+	".cts":  "typescript",
+	".js":   "javascript",
+	".jsx":  "javascript",
+	".mjs":  "javascript",
+	".cjs":  "javascript",
+	".py":   "python",
+	".pyi":  "python",
+	".rs":   "rust",
+	".java": "java",
+}
+
+// Language returns the language of the file at name, as the built-in extractor knows it, or "".
+func Language(name string) string {
+	return languages[strings.ToLower(path.Ext(name))]
+}
+
+// A Symbol is a declaration in a source file.
+type Symbol struct {
+	Name string
+	// Kind is what is declared: function, method, class, interface, struct, enum, trait, impl, type,
+	// module, constant, or variable; ctags may report other kinds for other languages.
+	Kind string
+	// Container is the name of the type or module the symbol is declared in, or "" at the top level.
+	Container string
+	Path      string // relative to the repository root, with forward slashes
+	Line      int
+	EndLine   int    // 0 if unknown
+	Signature string // the declaration's first line, trimmed
+}
+
+// QualifiedName returns the symbol's name, qualified by its container's.
+func (s Symbol) QualifiedName() string {
+	if s.Container == "" {
+		return s.Name
+	}
+	return s.Container + "." + s.Name
+}
+
+func (s Symbol) String() string {
+	lines := fmt.Sprint(s.Line)
+	if s.EndLine > s.Line {
+		lines += fmt.Sprintf("-%d", s.EndLine)
+	}
+	str := fmt.Sprintf("%s:%s %s %s", s.Path, lines, s.Kind, s.QualifiedName())
+	if s.Signature != "" {
+		str += " — " + s.Signature
+	}
+	return str
+}
+
+// Extract returns the symbols declared in src, the content of the file at name,
+// relative to the repository root, using the built-in extractor, in the order they appear.
+func Extract(name string, src []byte) ([]Symbol, error) {
+	var syms []Symbol
+	var err error
+	switch lang := Language(name); lang {
+	case "":
+		return nil, fmt.Errorf("no symbol support for %s files", cmp.Or(path.Ext(name), path.Base(name)))
+	case "go":
+		syms, err = extractGo(name, src)
+	case "python":
+		syms = extractPython(src)
+	default:
+		syms = extractBraces(lang, src)
+	}
+	for i := range syms {
+		syms[i].Path = name
+	}
+	return syms, err
+}
+
+// signature returns line as a declaration's signature: trimmed, without its opening brace, and not too long.
+func signature(line string) string {
+	s := strings.TrimSpace(line)
+	s = strings.TrimSpace(strings.TrimSuffix(s, "{"))
+	if len(s) > 160 {
+		s = s[:157] + "..."
+	}
+	return s
+}
+
+// sortSymbols sorts syms by path and line.
+func sortSymbols(syms []Symbol) {
+	slices.SortStableFunc(syms, func(a, b Symbol) int {
+		return cmp.Or(strings.Compare(a.Path, b.Path), cmp.Compare(a.Line, b.Line))
+	})
+}
@@ -0,0 +1,377 @@
+package symbols
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// outline returns syms as lines of "start-end kind qualified.name".
+func outline(syms []Symbol) string {
+	var lines []string
+	for _, s := range syms {
+		lines = append(lines, fmt.Sprintf("%d-%d %s %s", s.Line, s.EndLine, s.Kind, s.QualifiedName()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestExtract(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"app.ts", `import { x } from "./x";
+
+export interface Shape {
+  area(): number;
+}
+
+export class Circle implements Shape {
+  private r = 1;
+  constructor(r: number) {
+    this.r = r; // "}"
+  }
+  area(): number {
+    if (this.r > 0) {
+      return Math.PI * this.r ** 2;
+    }
+    return 0;
+  }
+  static unit<T>(
+    x: T,
+  ): Circle {
+    return new Circle(1);
+  }
+}
+
+export const render = async (s: Shape) => {
+  function helper() {}
+  return "{";
+};
+
+/* function commented() {} */
+export type Id = string;
+export default function main() {}
+`, `3-5 interface Shape
+4-4 method Shape.area
+7-23 class Circle
+9-11 method Circle.constructor
+12-17 method Circle.area
+18-22 method Circle.unit
+25-28 function render
+31-31 type Id
+32-32 function main`},
+		{"app.py", `import os
+
+class Server(Base):
+    """A server.
+
+    def not_a_method(self):
+    """
+
+    def __init__(self, port):
+        def local():
+            pass
+        self.port = port
+
+    async def serve(self):
+        pass
+
+
+def main():
+    Server(8080).serve()
+`, `3-15 class Server
+9-12 method Server.__init__
+14-15 method Server.serve
+18-19 function main`},
+		{"lib.rs", `use std::fmt;
+
+pub struct Point<'a> {
+    name: &'a str,
+}
+
+impl<'a> fmt::Display for Point<'a> {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        let c = '{';
+        write!(f, "{}", self.name)
+    }
+}
+
+pub trait Shape {
+    fn area(&self) -> f64;
+}
+
+pub(crate) async fn run()
+where
+    T: Send,
+{
+    fn inner() {}
+}
+
+const MAX: usize = 10;
+`, `3-5 struct Point
+7-12 impl Point
+8-11 method Point.fmt
+14-16 trait Shape
+15-15 method Shape.area
+18-23 function run
+25-25 constant MAX`},
+		{"Main.java", `package app;
+
+@SuppressWarnings("unchecked")
+public final class Main {
+    private final Map<String, Integer> counts = new HashMap<>();
+
+    public Main() {}
+
+    @Override
+    public static <T> List<T> run(String[] args)
+        throws IOException
+    {
+        if (args.length > 0) {
+            System.out.println("}");
+        }
+        return null;
+    }
+
+    interface Listener {
+        void on(Event e);
+    }
+
+    enum Color { RED, GREEN }
+}
+`, `4-24 class Main
+7-7 method Main.Main
+10-17 method Main.run
+19-21 interface Main.Listener
+20-20 method Main.Listener.on
+23-23 enum Main.Color`},
+		{"main.go", `package main
+
+type Server struct {
+	port int
+}
+
+func (s *Server) Serve() error { return nil }
+
+func main() {
+	var local int
+	_ = local
+}
+
+const Port = 8080
+`, `3-5 struct Server
+7-7 method Server.Serve
+9-12 function main
+14-14 constant Port`},
+	} {
+		syms, err := Extract(tt.name, []byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := outline(syms); got != tt.want {
+			t.Errorf("%s symbols:\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := Extract("notes.txt", nil); err == nil {
+		t.Errorf("Extract of a .txt file succeeded")
+	}
+}
+
+// TestExtractCommentsAndStrings checks that declarations in comments and strings, including those that span lines,
+// are not taken for symbols, and that their braces do not throw off nesting.
+func TestExtractCommentsAndStrings(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"app.ts", `/*
+export class Fake {
+*/
+const tmpl = `+"`"+`
+function fake() {
+${x}
+`+"`"+`;
+export function real(s: string) {
+  return s + "class Fake {";
+}
+`, `8-10 function real`},
+		{"lib.rs", `const DOC: &str = "
+fn fake() {
+";
+const RAW: &str = r#"
+pub struct Fake { "#;
+/* fn commented() {
+} */
+pub fn real() {
+    let s = "\" fn fake() {";
+}
+`, `1-3 constant DOC
+4-5 constant RAW
+8-10 function real`},
+		{"Main.java", `/**
+ * public class Fake {
+ */
+public class Main {
+    static final String SQL = """
+        void fake() {
+        """;
+    // void commented() {
+    void real() {}
+}
+`, `4-10 class Main
+9-9 method Main.real`},
+		{"app.py", `# def commented():
+HELP = """
+def fake():
+    pass
+"""
+def real():
+    return "def fake():"
+`, `6-7 function real`},
+	} {
+		syms, err := Extract(tt.name, []byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := outline(syms); got != tt.want {
+			t.Errorf("%s symbols:\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestExtractMultiLineSignatures checks declarations whose signatures span lines:
+// the symbol is found on its first line, its signature is that line, and its body ends where it should.
+func TestExtractMultiLineSignatures(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want, signature string
+	}{
+		{"app.ts", `export async function load(
+  id: string,
+  opts: { retries: number } = { retries: 1 },
+): Promise<void> {
+  await fetch(id);
+}
+`, `1-6 function load`, "export async function load("},
+		{"lib.rs", `pub fn parse<'a, T>(
+    input: &'a str,
+) -> Result<T, Error>
+where
+    T: FromStr,
+{
+    input.parse()
+}
+`, `1-8 function parse`, "pub fn parse<'a, T>("},
+		{"Main.java", `class Main {
+    public Map<String, List<Integer>> group(
+            List<Integer> values,
+            Function<Integer, String> key) {
+        return null;
+    }
+}
+`, "1-7 class Main\n2-6 method Main.group", "public Map<String, List<Integer>> group("},
+		{"app.py", `def handler(
+    event,
+    context,
+):
+    return event
+`, `1-5 function handler`, "def handler("},
+	} {
+		syms, err := Extract(tt.name, []byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := outline(syms); got != tt.want {
+			t.Errorf("%s symbols:\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		if got := syms[len(syms)-1].Signature; got != tt.signature {
+			t.Errorf("%s signature = %q, want %q", tt.name, got, tt.signature)
+		}
+	}
+}
+
+func TestParseCtags(t *testing.T) {
+	out := `{"_type": "ptag", "name": "JSON_OUTPUT_VERSION", "path": "0.0"}
+{"_type": "tag", "name": "Server", "path": "app/server.py", "pattern": "/^class Server(Base):$/", "language": "Python", "line": 3, "kind": "class", "end": 15}
+{"_type": "tag", "name": "serve", "path": "app/server.py", "pattern": "/^    async def serve(self):$/", "language": "Python", "line": 14, "kind": "member", "scope": "Server", "scopeKind": "class", "end": 15, "signature": "(self)"}
+{"_type": "tag", "name": "port", "path": "app/server.py", "pattern": "/^        port = 1$/", "language": "Python", "line": 16, "kind": "local", "scope": "serve", "scopeKind": "member"}
+not json
+`
+	got := outline(parseCtags([]byte(out)))
+	want := "3-15 class Server\n14-15 method Server.serve"
+	if got != want {
+		t.Errorf("parseCtags:\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTool(t *testing.T) {
+	if universalCtags() != "" {
+		t.Skip("Universal Ctags is installed; these expectations are the built-in extractor's")
+	}
+	root := t.TempDir()
+	files := map[string]string{
+		"web/app.ts":           "export class App {\n  start() {}\n}\n",
+		"web/util.ts":          "export function start() {}\n",
+		"server/server.py":     "class Server:\n    def Start(self):\n        pass\n",
+		"node_modules/x/x.js":  "function start() {}\n",
+		"README.md":            "# start\n",
+		"server/lib/helper.rs": "pub fn started() {}\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command("git", "init", "-q")
+	cmd.Dir = root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+
+	tool := &Tool{RepoRoot: root}
+	for _, tt := range []struct {
+		input, want string
+	}{
+		{`{"action": "find", "name": "start"}`, "web/app.ts:2 method App.start — start() {}\nweb/util.ts:1 function start — export function start() {}\n"},
+		{`{"action": "find", "name": "App.start"}`, "web/app.ts:2 method App.start — start() {}\n"},
+		{`{"action": "find", "name": "start", "kind": "function", "path": "web"}`, "web/util.ts:1 function start — export function start() {}\n"},
+		{`{"action": "find", "name": "start", "path": "server"}`, "No definition of start; these differ only in case:\nserver/server.py:2-3 method Server.Start — def Start(self)\n"},
+		{`{"action": "find", "name": "star", "path": "server/lib"}`, "No definition of star; these names contain it:\nserver/lib/helper.rs:1 function started — pub fn started() {}\n"},
+		{`{"action": "find", "name": "stop"}`, "No definition of stop found."},
+		{`{"action": "list", "path": "web"}`, "web/app.ts\n  1-3 class export class App\n    2 method start() {}\n\nweb/util.ts\n  1 function export function start() {}\n"},
+		{`{"action": "list", "path": "server/server.py"}`, "server/server.py\n  1-3 class class Server\n    2-3 method def Start(self)\n"},
+	} {
+		out, err := tool.Tool().Run(context.Background(), json.RawMessage(tt.input))
+		if err != nil {
+			t.Errorf("symbols %s: %v", tt.input, err)
+			continue
+		}
+		if out[0].Text != tt.want {
+			t.Errorf("symbols %s =\n%s\nwant\n%s", tt.input, out[0].Text, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		input, want string
+	}{
+		{`{"action": "list", "path": "README.md"}`, "no symbol support for .md files"},
+		{`{"action": "list", "path": "missing"}`, "no such file"},
+		{`{"action": "find"}`, "find needs a name"},
+		{`{"action": "rename"}`, "unknown action"},
+	} {
+		if _, err := tool.Tool().Run(context.Background(), json.RawMessage(tt.input)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("symbols %s: error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package symbols
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+const (
+	// maxFileSize is the largest file searched for symbols.
+	maxFileSize = 1 << 20
+	// maxResults is the most definitions find returns.
+	maxResults = 50
+	// maxOutput is the most text the tool returns.
+	maxOutput = 32 << 10
+)
+
+// skipDirs are directories whose files are not the repository's own code.
+var skipDirs = []string{"node_modules", "vendor", "dist", "build", "target", "third_party", "__pycache__"}
+
+// Tool specifies an llm.Tool that lists and finds symbols in the repository at RepoRoot.
+type Tool struct {
+	RepoRoot string
+}
+
+// Tool returns an llm.Tool based on t.
+func (t *Tool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        Name,
+		Description: strings.TrimSpace(Description),
+		InputSchema: llm.MustSchema(InputSchema),
+		Run:         t.run,
+	}
+}
+
+const (
+	Name        = "symbols"
+	Description = `
+Lists and finds the declarations in source files: functions, methods, classes, interfaces, structs, traits, and types.
+It covers TypeScript, JavaScript, Python, Rust, Java, and Go, and more languages when Universal Ctags is installed.
+
+Actions:
+- list: outline the symbols in a file, or in the files of a directory, with their line ranges
+- find: find where a symbol is defined across the repository, by name ("Parse") or qualified by its type ("Server.start")
+
+Use it to orient in a file before reading it, and to jump to a definition instead of grepping for it.
+
+Without ctags, declarations are found line by line rather than by a full parser: comments and strings are skipped, and signatures may span lines, but an unusual layout can hide a declaration or misplace where it ends. Read the file when a range looks wrong.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	InputSchema = `
+{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["list", "find"]
+    },
+    "path": {
+      "type": "string",
+      "description": "For list, the file or directory to outline; for find, only search under this path. Relative to the repository root"
+    },
+    "name": {
+      "type": "string",
+      "description": "For find, the symbol's name, optionally qualified by its container, as in Server.start"
+    },
+    "kind": {
+      "type": "string",
+      "description": "For find, only symbols of this kind, such as function, method, class, interface, struct, or type"
+    }
+  }
+}
+`
+)
+
+type input struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+}
+
+func (t *Tool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var in input
+	if err := json.Unmarshal(m, &in); err != nil {
+		return nil, err
+	}
+	dir := strings.TrimPrefix(path.Clean("/"+strings.TrimSpace(in.Path)), "/")
+	var out string
+	var err error
+	switch in.Action {
+	case "list":
+		out, err = t.list(ctx, dir)
+	case "find":
+		out, err = t.find(ctx, strings.TrimSpace(in.Name), strings.TrimSpace(in.Kind), dir)
+	default:
+		return nil, fmt.Errorf("unknown action %q; want list or find", in.Action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxOutput {
+		out = out[:strings.LastIndexByte(out[:maxOutput], '\n')+1] + "[Output truncated; narrow the path.]"
+	}
+	return llm.TextContent(out), nil
+}
+
+// list outlines the symbols in the file p, or the files directly in the directory p.
+func (t *Tool) list(ctx context.Context, p string) (string, error) {
+	p = cmp.Or(p, ".")
+	info, err := os.Stat(filepath.Join(t.RepoRoot, filepath.FromSlash(p)))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", p, err)
+	}
+	files := []string{p}
+	if info.IsDir() {
+		all, err := t.files(ctx)
+		if err != nil {
+			return "", err
+		}
+		files = slices.DeleteFunc(all, func(f string) bool { return path.Dir(f) != p })
+		if len(files) == 0 {
+			return "", fmt.Errorf("no source files in %s", p)
+		}
+	} else if Language(p) == "" && universalCtags() == "" {
+		return "", fmt.Errorf("no symbol support for %s files without Universal Ctags", cmp.Or(path.Ext(p), path.Base(p)))
+	}
+	syms, err := t.symbols(ctx, files)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	last := ""
+	for _, s := range syms {
+		if s.Path != last {
+			if last != "" {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "%s\n", s.Path)
+			last = s.Path
+		}
+		indent := "  "
+		if s.Container != "" {
+			indent += strings.Repeat("  ", min(strings.Count(s.Container, ".")+1, 3))
+		}
+		lines := fmt.Sprint(s.Line)
+		if s.EndLine > s.Line {
+			lines += fmt.Sprintf("-%d", s.EndLine)
+		}
+		fmt.Fprintf(&b, "%s%s %s %s\n", indent, lines, s.Kind, s.Signature)
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("No symbols in %s.", p), nil
+	}
+	return b.String(), nil
+}
+
+// find returns the definitions of name, of kind if set, in the files under dir.
+func (t *Tool) find(ctx context.Context, name, kind, dir string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("find needs a name")
+	}
+	all, err := t.files(ctx)
+	if err != nil {
+		return "", err
+	}
+	// Only files that mention the name can define it.
+	base := name[strings.LastIndexByte(name, '.')+1:]
+	var files []string
+	for _, f := range all {
+		if dir != "" && f != dir && !strings.HasPrefix(f, dir+"/") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(t.RepoRoot, filepath.FromSlash(f)))
+		if err == nil && bytes.Contains(bytes.ToLower(data), []byte(strings.ToLower(base))) {
+			files = append(files, f)
+		}
+	}
+	syms, err := t.symbols(ctx, files)
+	if err != nil {
+		return "", err
+	}
+	if kind != "" {
+		syms = slices.DeleteFunc(syms, func(s Symbol) bool { return s.Kind != kind })
+	}
+
+	matches := func(match func(Symbol) bool) []Symbol {
+		return slices.DeleteFunc(slices.Clone(syms), func(s Symbol) bool { return !match(s) })
+	}
+	found := matches(func(s Symbol) bool {
+		q := s.QualifiedName()
+		return s.Name == name || q == name || strings.HasSuffix(q, "."+name)
+	})
+	note := ""
+	if len(found) == 0 {
+		found = matches(func(s Symbol) bool { return strings.EqualFold(s.Name, base) })
+		note = fmt.Sprintf("No definition of %s; these differ only in case:\n", name)
+	}
+	if len(found) == 0 {
+		found = matches(func(s Symbol) bool { return strings.Contains(strings.ToLower(s.Name), strings.ToLower(base)) })
+		note = fmt.Sprintf("No definition of %s; these names contain it:\n", name)
+	}
+	slog.InfoContext(ctx, "symbols_found", "name", name, "kind", kind, "files", len(files), "found", len(found))
+	if len(found) == 0 {
+		return fmt.Sprintf("No definition of %s found.", name), nil
+	}
+	var b strings.Builder
+	b.WriteString(note)
+	for i, s := range found {
+		if i == maxResults {
+			fmt.Fprintf(&b, "[%d more; narrow with kind or path.]\n", len(found)-maxResults)
+			break
+		}
+		fmt.Fprintf(&b, "%s\n", s)
+	}
+	return b.String(), nil
+}
+
+// symbols returns the symbols in files, sorted by path and line.
+// Go files, and all files when Universal Ctags is not installed, go to the built-in extractor;
+// files it does not know are then skipped.
+func (t *Tool) symbols(ctx context.Context, files []string) ([]Symbol, error) {
+	ctags := universalCtags()
+	var syms []Symbol
+	var forCtags []string
+	for _, f := range files {
+		lang := Language(f)
+		if ctags != "" && lang != "go" {
+			forCtags = append(forCtags, f)
+			continue
+		}
+		if lang == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(t.RepoRoot, filepath.FromSlash(f)))
+		if err != nil {
+			return nil, err
+		}
+		s, err := Extract(f, data)
+		if err != nil && len(files) == 1 {
+			return nil, err
+		}
+		syms = append(syms, s...)
+	}
+	if len(forCtags) > 0 {
+		s, err := extractCtags(ctx, ctags, t.RepoRoot, forCtags)
+		if err != nil {
+			return nil, err
+		}
+		syms = append(syms, s...)
+	}
+	sortSymbols(syms)
+	return syms, nil
+}
+
+// files returns the source files in the repository, relative to its root, with forward slashes:
+// those git tracks or does not ignore, outside vendored and generated directories.
+func (t *Tool) files(ctx context.Context) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = t.RepoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in %s: %w", t.RepoRoot, err)
+	}
+	ctags := universalCtags() != ""
+	var files []string
+	for f := range strings.SplitSeq(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if f == "" || Language(f) == "" && !ctags {
+			continue
+		}
+		if slices.ContainsFunc(strings.Split(path.Dir(f), "/"), func(d string) bool { return slices.Contains(skipDirs, d) }) {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(t.RepoRoot, filepath.FromSlash(f))); err != nil || !info.Mode().IsRegular() || info.Size() > maxFileSize {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
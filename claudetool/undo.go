@@ -0,0 +1,78 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"sketch.dev/claudetool/undo"
+	"sketch.dev/llm"
+)
+
+// UndoTool specifies the llm.Tools that undo the agent's file writes, as recorded in a journal.
+type UndoTool struct {
+	Journal *undo.Journal
+}
+
+const (
+	UndoLastName        = "undo_last_change"
+	UndoLastDescription = `Undoes your most recent file write (from the patch or scaffold tools): restores the file's previous contents, or deletes it if the write created it.
+Works whether or not the file is in a git repository. Refuses if the file changed since the write.
+Call repeatedly to step further back. Changes made with shell commands are not undone.`
+
+	UndoAllName        = "undo_all"
+	UndoAllDescription = `Undoes all your file writes (from the patch or scaffold tools) in this session, most recent first, returning every file to how it was before you changed it.
+Stops at the first file that changed since your last write to it. Changes made with shell commands are not undone.
+Only use this when the user asks to discard all your changes.`
+)
+
+// LastTool returns the undo_last_change tool.
+func (u *UndoTool) LastTool() *llm.Tool {
+	return &llm.Tool{
+		Name:        UndoLastName,
+		Description: UndoLastDescription,
+		InputSchema: llm.EmptySchema(),
+		Serial:      true,
+		Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			e, err := u.Journal.UndoLast()
+			if err != nil {
+				return nil, err
+			}
+			return llm.TextContent(DescribeUndo(*e)), nil
+		},
+	}
+}
+
+// AllTool returns the undo_all tool.
+func (u *UndoTool) AllTool() *llm.Tool {
+	return &llm.Tool{
+		Name:        UndoAllName,
+		Description: UndoAllDescription,
+		InputSchema: llm.EmptySchema(),
+		Serial:      true,
+		Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			undone, err := u.Journal.UndoAll()
+			buf := new(strings.Builder)
+			for _, e := range undone {
+				fmt.Fprintf(buf, "- %s\n", DescribeUndo(e))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%sstopped: %w", buf, err)
+			}
+			if len(undone) == 0 {
+				return llm.TextContent("There were no file writes to undo."), nil
+			}
+			return llm.TextContent(buf.String()), nil
+		},
+	}
+}
+
+// DescribeUndo describes the undoing of e.
+func DescribeUndo(e undo.Entry) string {
+	if !e.Existed {
+		return fmt.Sprintf("Deleted %s, which the write of %s created", e.Path, e.Time.Format(time.TimeOnly))
+	}
+	return fmt.Sprintf("Restored %s to its contents before the write of %s", e.Path, e.Time.Format(time.TimeOnly))
+}
@@ -0,0 +1,174 @@
+// Package undo keeps a journal of the agent's file writes, so that they can be undone
+// whether or not the files are in a git repository.
+//
+// Each write is an Entry holding the file's contents before and after it.
+// Undoing an entry restores the contents before it, or removes the file if the write created it,
+// provided the file still holds what the write left; otherwise someone changed it since,
+// and undoing would lose their work.
+package undo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxEntries is how many writes a Journal remembers; older ones can no longer be undone.
+const maxEntries = 1000
+
+// An Entry is one file write.
+type Entry struct {
+	// ID identifies the entry in its Store.
+	ID      uint64    `json:"id"`
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+	Existed bool      `json:"existed"` // whether the file existed before the write
+	Before  []byte    `json:"before"`  // contents before the write
+	After   []byte    `json:"after"`   // contents after the write
+}
+
+// A Store persists the entries of a Journal, so that writes can be undone after a restart.
+type Store interface {
+	// LoadUndo returns the persisted entries, oldest first.
+	LoadUndo() ([]Entry, error)
+	// AddUndo persists e, setting e.ID.
+	AddUndo(e *Entry) error
+	RemoveUndo(id uint64) error
+}
+
+// A Journal records file writes so that they can be undone, most recent first.
+// It is safe for concurrent use. A nil *Journal records nothing.
+type Journal struct {
+	mu      sync.Mutex
+	entries []Entry // oldest first
+	store   Store   // nil if the journal is not persisted
+}
+
+// NewJournal returns an empty journal kept in memory.
+func NewJournal() *Journal {
+	return &Journal{}
+}
+
+// NewPersistentJournal returns a journal holding the entries persisted in s,
+// which it keeps up to date as writes are recorded and undone.
+func NewPersistentJournal(s Store) (*Journal, error) {
+	entries, err := s.LoadUndo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load undo journal: %w", err)
+	}
+	return &Journal{entries: entries, store: s}, nil
+}
+
+// Record records that after was written to path, which held before if existed.
+func (j *Journal) Record(path string, before []byte, existed bool, after []byte) error {
+	if j == nil {
+		return nil
+	}
+	e := Entry{
+		Path:    filepath.Clean(path),
+		Time:    time.Now(),
+		Existed: existed,
+		Before:  slices.Clone(before),
+		After:   slices.Clone(after),
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.store != nil {
+		if err := j.store.AddUndo(&e); err != nil {
+			return fmt.Errorf("failed to record write to %s: %w", path, err)
+		}
+	}
+	j.entries = append(j.entries, e)
+	var errs error
+	for len(j.entries) > maxEntries {
+		errs = errors.Join(errs, j.remove(0))
+	}
+	return errs
+}
+
+// Entries returns the recorded writes that have not been undone, oldest first.
+func (j *Journal) Entries() []Entry {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return slices.Clone(j.entries)
+}
+
+// UndoLast undoes the most recent write and returns it.
+func (j *Journal) UndoLast() (*Entry, error) {
+	if j == nil {
+		return nil, errors.New("no writes to undo")
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) == 0 {
+		return nil, errors.New("no writes to undo")
+	}
+	e := j.entries[len(j.entries)-1]
+	if err := restore(e); err != nil {
+		return nil, err
+	}
+	return &e, j.remove(len(j.entries) - 1)
+}
+
+// UndoAll undoes all writes, most recent first, and returns them in that order.
+// It stops at the first write that cannot be undone, returning those it undid and the error.
+func (j *Journal) UndoAll() ([]Entry, error) {
+	if j == nil {
+		return nil, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var undone []Entry
+	for len(j.entries) > 0 {
+		e := j.entries[len(j.entries)-1]
+		if err := restore(e); err != nil {
+			return undone, err
+		}
+		undone = append(undone, e)
+		if err := j.remove(len(j.entries) - 1); err != nil {
+			return undone, err
+		}
+	}
+	return undone, nil
+}
+
+// remove forgets the i'th entry. j.mu must be held.
+func (j *Journal) remove(i int) error {
+	e := j.entries[i]
+	j.entries = slices.Delete(j.entries, i, i+1)
+	if j.store != nil {
+		return j.store.RemoveUndo(e.ID)
+	}
+	return nil
+}
+
+// restore undoes e: it writes e.Before to e.Path, or removes e.Path if e created it.
+func restore(e Entry) error {
+	cur, err := os.ReadFile(e.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot undo the write to %s of %s: the file was deleted since", e.Path, e.Time.Format(time.TimeOnly))
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(cur, e.After) {
+		return fmt.Errorf("cannot undo the write to %s of %s: the file changed since", e.Path, e.Time.Format(time.TimeOnly))
+	}
+	if !e.Existed {
+		return os.Remove(e.Path)
+	}
+	info, err := os.Stat(e.Path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(e.Path, e.Before, info.Mode().Perm())
+}
@@ -0,0 +1,125 @@
+package undo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// write writes data to path and records the write in j.
+func write(t *testing.T, j *Journal, path, data string) {
+	t.Helper()
+	before, err := os.ReadFile(path)
+	existed := err == nil
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Record(path, before, existed, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "<missing>"
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	os.WriteFile(a, []byte("a0\n"), 0o644)
+
+	j := NewJournal()
+	write(t, j, a, "a1\n")
+	write(t, j, b, "b1\n")
+	write(t, j, a, "a2\n")
+	if n := len(j.Entries()); n != 3 {
+		t.Fatalf("%d entries, want 3", n)
+	}
+
+	e, err := j.UndoLast()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != a || readFile(t, a) != "a1\n" {
+		t.Errorf("UndoLast undid %s, leaving a = %q; want a1", e.Path, readFile(t, a))
+	}
+
+	// b changed outside the journal: undoing it would lose that change.
+	os.WriteFile(b, []byte("user\n"), 0o644)
+	if _, err := j.UndoLast(); err == nil {
+		t.Error("UndoLast of a file changed since succeeded")
+	}
+	os.WriteFile(b, []byte("b1\n"), 0o644)
+
+	undone, err := j.UndoAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undone) != 2 || undone[0].Path != b || undone[1].Path != a {
+		t.Errorf("UndoAll undid %+v, want b then a", undone)
+	}
+	if readFile(t, a) != "a0\n" || readFile(t, b) != "<missing>" {
+		t.Errorf("after UndoAll, a = %q and b = %q; want a0 and b removed", readFile(t, a), readFile(t, b))
+	}
+	if _, err := j.UndoLast(); err == nil {
+		t.Error("UndoLast with nothing to undo succeeded")
+	}
+}
+
+type memStore struct {
+	entries map[uint64]Entry
+	next    uint64
+}
+
+func (m *memStore) LoadUndo() ([]Entry, error) {
+	var es []Entry
+	for id := uint64(1); id <= m.next; id++ {
+		if e, ok := m.entries[id]; ok {
+			es = append(es, e)
+		}
+	}
+	return es, nil
+}
+
+func (m *memStore) AddUndo(e *Entry) error {
+	m.next++
+	e.ID = m.next
+	m.entries[e.ID] = *e
+	return nil
+}
+
+func (m *memStore) RemoveUndo(id uint64) error {
+	delete(m.entries, id)
+	return nil
+}
+
+func TestPersistentJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.txt")
+	s := &memStore{entries: make(map[uint64]Entry)}
+	j, err := NewPersistentJournal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, j, path, "one\n")
+	write(t, j, path, "two\n")
+
+	// A restart loads the journal from the store.
+	j, err = NewPersistentJournal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.UndoLast(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "one\n" || len(s.entries) != 1 {
+		t.Errorf("after UndoLast, file = %q with %d stored entries; want one with 1", got, len(s.entries))
+	}
+}
@@ -0,0 +1,141 @@
+package claudetool
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileVersions tracks the contents of files as the agent last saw them, by hash,
+// so that edits to a file that someone else changed since are refused instead of overwriting that work.
+// The agent sees a file when it reads it with a shell command or edits it,
+// and it is taken to see the changes its own commands make. A nil *FileVersions tracks nothing.
+type FileVersions struct {
+	// ReadFile, if set, reads files as the agent's edits see them, such as with staged changes.
+	// If nil, os.ReadFile is used.
+	ReadFile func(path string) ([]byte, error)
+
+	mu       sync.Mutex
+	versions map[string]fileVersion // by absolute path
+}
+
+type fileVersion struct {
+	hash    [sha256.Size]byte
+	deleted bool
+	// size and mtime on disk when hashed, to skip rehashing files that have not changed.
+	size  int64
+	mtime time.Time
+}
+
+// A ConflictError is the error of an edit to a file that changed since the agent last saw it.
+type ConflictError struct {
+	Path    string
+	Deleted bool   // whether the file was deleted
+	Content string // the file's current contents, if not deleted
+}
+
+// maxConflictContent is the most of a file's contents that a ConflictError's message includes.
+const maxConflictContent = 64 << 10
+
+func (e *ConflictError) Error() string {
+	if e.Deleted {
+		return fmt.Sprintf("conflict: %s was deleted since you last read it, probably by the user; no patches were applied. Ask the user before creating it again", e.Path)
+	}
+	content := e.Content
+	if len(content) > maxConflictContent {
+		content = content[:maxConflictContent] + "\n[truncated; read the rest of the file]"
+	}
+	return fmt.Sprintf("conflict: %s changed since you last read it, probably edited by the user; no patches were applied. "+
+		"Its current contents follow. Make your edits to them, keeping the other changes:\n%s", e.Path, content)
+}
+
+func (v *FileVersions) read(path string) ([]byte, error) {
+	if v.ReadFile != nil {
+		return v.ReadFile(path)
+	}
+	return os.ReadFile(path)
+}
+
+// Saw records that the agent saw data as the contents of path.
+func (v *FileVersions) Saw(path string, data []byte) {
+	if v == nil {
+		return
+	}
+	ver := fileVersion{hash: sha256.Sum256(data)}
+	if info, err := os.Stat(path); err == nil {
+		ver.size, ver.mtime = info.Size(), info.ModTime()
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.versions == nil {
+		v.versions = make(map[string]fileVersion)
+	}
+	v.versions[filepath.Clean(path)] = ver
+}
+
+// SawFile records that the agent saw the current contents of path, if it is a regular file.
+func (v *FileVersions) SawFile(path string) {
+	if v == nil {
+		return
+	}
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	if data, err := v.read(path); err == nil {
+		v.Saw(path, data)
+	}
+}
+
+// Refresh records the current contents of the files v tracks as seen,
+// after the agent did something that may have changed them.
+func (v *FileVersions) Refresh() {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for path, ver := range v.versions {
+		info, err := os.Stat(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			v.versions[path] = fileVersion{deleted: true}
+		case err != nil || info.Size() == ver.size && info.ModTime().Equal(ver.mtime) && !ver.deleted:
+		default:
+			if data, err := v.read(path); err == nil {
+				v.versions[path] = fileVersion{hash: sha256.Sum256(data), size: info.Size(), mtime: info.ModTime()}
+			}
+		}
+	}
+}
+
+// Check returns a *ConflictError if path changed since the agent last saw it:
+// data, its contents, are not what the agent saw, or it no longer exists (exists is false).
+// Either way, the agent sees the file's current state in the error, so Check records it as seen.
+// Files that the agent never saw have no conflicts.
+func (v *FileVersions) Check(path string, data []byte, exists bool) error {
+	if v == nil {
+		return nil
+	}
+	path = filepath.Clean(path)
+	v.mu.Lock()
+	ver, ok := v.versions[path]
+	v.mu.Unlock()
+	switch {
+	case !ok:
+		return nil
+	case !exists && !ver.deleted:
+		v.mu.Lock()
+		v.versions[path] = fileVersion{deleted: true}
+		v.mu.Unlock()
+		return &ConflictError{Path: path, Deleted: true}
+	case !exists || !ver.deleted && ver.hash == sha256.Sum256(data):
+		return nil
+	}
+	v.Saw(path, data)
+	return &ConflictError{Path: path, Content: string(data)}
+}
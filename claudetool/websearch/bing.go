@@ -0,0 +1,43 @@
+package websearch
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const bingURL = "https://api.bing.microsoft.com/v7.0/search"
+
+// bingProvider searches with the Bing Web Search API.
+// See https://learn.microsoft.com/en-us/bing/search-apis/bing-web-search/reference/endpoints
+type bingProvider struct {
+	apiKey string
+	url    string       // defaults to bingURL
+	client *http.Client // http.DefaultClient if nil
+}
+
+func (b *bingProvider) Name() string { return "Bing" }
+
+func (b *bingProvider) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	q := url.Values{"q": {query}, "count": {strconv.Itoa(min(count, 50))}, "responseFilter": {"Webpages"}}
+	var r struct {
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	header := map[string]string{"Ocp-Apim-Subscription-Key": b.apiKey}
+	if err := getJSON(ctx, b.client, b.Name(), cmp.Or(b.url, bingURL)+"?"+q.Encode(), header, &r); err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, w := range r.WebPages.Value {
+		results = append(results, Result{Title: w.Name, URL: w.URL, Snippet: w.Snippet})
+	}
+	return results, nil
+}
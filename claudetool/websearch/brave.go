@@ -0,0 +1,43 @@
+package websearch
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const braveURL = "https://api.search.brave.com/res/v1/web/search"
+
+// braveProvider searches with the Brave Search API.
+// See https://api-dashboard.search.brave.com/app/documentation/web-search/get-started
+type braveProvider struct {
+	apiKey string
+	url    string       // defaults to braveURL
+	client *http.Client // http.DefaultClient if nil
+}
+
+func (b *braveProvider) Name() string { return "Brave" }
+
+func (b *braveProvider) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	q := url.Values{"q": {query}, "count": {strconv.Itoa(min(count, 20))}}
+	var r struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	header := map[string]string{"X-Subscription-Token": b.apiKey}
+	if err := getJSON(ctx, b.client, b.Name(), cmp.Or(b.url, braveURL)+"?"+q.Encode(), header, &r); err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, w := range r.Web.Results {
+		results = append(results, Result{Title: plainText(w.Title), URL: w.URL, Snippet: plainText(w.Description)})
+	}
+	return results, nil
+}
@@ -0,0 +1,26 @@
+package websearch
+
+import (
+	"context"
+
+	"sketch.dev/llm"
+)
+
+// nativeProvider searches with the LLM provider's own web search.
+type nativeProvider struct {
+	searcher llm.WebSearcher
+}
+
+func (n *nativeProvider) Name() string { return "native" }
+
+func (n *nativeProvider) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	found, err := n.searcher.WebSearch(ctx, query, count)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, r := range found {
+		results = append(results, Result{Title: r.Title, URL: r.URL, Snippet: r.Snippet})
+	}
+	return results, nil
+}
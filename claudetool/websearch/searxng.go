@@ -0,0 +1,37 @@
+package websearch
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// searxngProvider searches with a SearXNG instance, which must have the JSON format enabled.
+// See https://docs.searxng.org/dev/search_api.html
+type searxngProvider struct {
+	base   string       // the instance's URL
+	client *http.Client // http.DefaultClient if nil
+}
+
+func (s *searxngProvider) Name() string { return "SearXNG" }
+
+func (s *searxngProvider) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	q := url.Values{"q": {query}, "format": {"json"}}
+	var r struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := getJSON(ctx, s.client, s.Name(), strings.TrimSuffix(s.base, "/")+"/search?"+q.Encode(), nil, &r); err != nil {
+		return nil, err
+	}
+	// SearXNG has no parameter for the number of results.
+	var results []Result
+	for _, x := range r.Results[:min(count, len(r.Results))] {
+		results = append(results, Result{Title: plainText(x.Title), URL: x.URL, Snippet: plainText(x.Content)})
+	}
+	return results, nil
+}
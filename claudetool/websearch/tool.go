@@ -0,0 +1,150 @@
+package websearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"sketch.dev/llm"
+)
+
+// Tool specifies an llm.Tool that searches the web with Provider.
+// Its budget spans every conversation that uses it, so one Tool should serve a whole session.
+type Tool struct {
+	Provider Provider
+	// Budget is how many searches may be made, in all; zero means DefaultBudget.
+	Budget int
+
+	mu   sync.Mutex
+	used int
+}
+
+// DefaultBudget is how many searches a session may make, unless Tool.Budget says otherwise.
+const DefaultBudget = 25
+
+const (
+	// defaultCount and maxCount are the default and greatest count of results.
+	defaultCount = 5
+	maxCount     = 20
+)
+
+// Tool returns an llm.Tool based on t.
+func (t *Tool) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name:        Name,
+		Description: strings.TrimSpace(Description),
+		InputSchema: llm.MustSchema(InputSchema),
+		Run:         t.run,
+	}
+}
+
+const (
+	Name        = "web_search"
+	Description = `
+Searches the web and returns the top results, each with its title, URL, and a snippet.
+
+Use it to find documentation, release notes, and discussions of errors you do not recognize,
+then read the most promising results with fetch_url.
+Searches are limited per session, and each result says how many are left:
+prefer one precise query to several vague ones.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	InputSchema = `
+{
+  "type": "object",
+  "required": ["query"],
+  "properties": {
+    "query": {
+      "type": "string",
+      "description": "What to search for"
+    },
+    "count": {
+      "type": "integer",
+      "description": "How many results to return, at most 20; defaults to 5"
+    }
+  }
+}
+`
+)
+
+// output is what web_search returns, as JSON.
+type output struct {
+	Provider     string   `json:"provider"`
+	Query        string   `json:"query"`
+	Results      []Result `json:"results"`
+	SearchesLeft int      `json:"searches_left"`
+}
+
+func (t *Tool) run(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+	var input struct {
+		Query string `json:"query"`
+		Count int    `json:"count"`
+	}
+	if err := json.Unmarshal(m, &input); err != nil {
+		return nil, err
+	}
+	input.Query = strings.TrimSpace(input.Query)
+	if input.Query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	count := input.Count
+	if count <= 0 {
+		count = defaultCount
+	}
+	count = min(count, maxCount)
+
+	left, ok := t.spend()
+	if !ok {
+		return nil, llm.NewToolError(llm.ToolErrorBudgetExceeded, fmt.Errorf("this session's %d web searches are used up; work from what you have, or ask the user", t.budget()))
+	}
+	results, err := t.Provider.Search(ctx, input.Query, count)
+	if err != nil {
+		t.refund()
+		return nil, llm.NewToolError(llm.ToolErrorProvider, err)
+	}
+	if len(results) > count {
+		results = results[:count]
+	}
+	slog.InfoContext(ctx, "web_searched", "provider", t.Provider.Name(), "query", input.Query, "results", len(results), "searches_left", left)
+
+	out, err := json.MarshalIndent(output{
+		Provider:     t.Provider.Name(),
+		Query:        input.Query,
+		Results:      results,
+		SearchesLeft: left,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return llm.TextContent(string(out)), nil
+}
+
+func (t *Tool) budget() int {
+	if t.Budget <= 0 {
+		return DefaultBudget
+	}
+	return t.Budget
+}
+
+// spend counts a search against the budget, reporting how many are left after it,
+// and whether there was one to spend. Failed searches count too: they cost the provider's quota all the same.
+func (t *Tool) spend() (left int, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.used >= t.budget() {
+		return 0, false
+	}
+	t.used++
+	return t.budget() - t.used, true
+}
+
+// refund gives back a search that failed, so that it does not count against the budget.
+func (t *Tool) refund() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.used--
+}
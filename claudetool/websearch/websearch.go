@@ -0,0 +1,151 @@
+// Package websearch lets the agent search the web, through one of several search providers:
+// Brave Search, a SearXNG instance, Bing, or the LLM provider's own search.
+package websearch
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// A Provider searches the web.
+type Provider interface {
+	// Name is the provider's name, such as "Brave".
+	Name() string
+	// Search returns up to count results for query, most relevant first.
+	Search(ctx context.Context, query string, count int) ([]Result, error)
+}
+
+// A Result is a web search result.
+type Result struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// Kinds of providers.
+const (
+	Brave   = "brave"
+	SearXNG = "searxng"
+	Bing    = "bing"
+	Native  = "native" // the LLM provider's own search, where it has one
+)
+
+// Kinds are the kinds of providers, in the order FromEnvironment prefers them.
+var Kinds = []string{Brave, SearXNG, Bing, Native}
+
+// Env returns the environment variable that configures providers of kind:
+// an API key, or for SearXNG, the instance's URL. Native search needs none, so it returns "".
+func Env(kind string) string {
+	switch kind {
+	case Brave:
+		return "BRAVE_API_KEY"
+	case SearXNG:
+		return "SEARXNG_URL"
+	case Bing:
+		return "BING_API_KEY"
+	}
+	return ""
+}
+
+// New returns the provider of kind, configured with setting, the value of its Env variable.
+// Native search searches with srv.
+func New(kind, setting string, srv llm.Service) (Provider, error) {
+	switch kind {
+	case Brave:
+		return &braveProvider{apiKey: setting}, nil
+	case SearXNG:
+		if !strings.HasPrefix(setting, "http://") && !strings.HasPrefix(setting, "https://") {
+			return nil, fmt.Errorf("bad SearXNG URL %q", setting)
+		}
+		return &searxngProvider{base: setting}, nil
+	case Bing:
+		return &bingProvider{apiKey: setting}, nil
+	case Native:
+		ws, ok := llm.WebSearcherOf(srv)
+		if !ok {
+			return nil, fmt.Errorf("the LLM provider has no web search of its own")
+		}
+		return &nativeProvider{searcher: ws}, nil
+	}
+	return nil, fmt.Errorf("unknown web search provider %q; want one of %s", kind, strings.Join(Kinds, ", "))
+}
+
+// FromEnvironment returns the provider of kind, configured from the environment variable named by Env,
+// or nil if that is not set. If kind is "", it returns the first of Kinds that is configured,
+// falling back to native search if srv has it, or nil.
+func FromEnvironment(kind string, srv llm.Service) (Provider, error) {
+	if kind != "" {
+		setting := os.Getenv(Env(kind))
+		if setting == "" && kind != Native {
+			return nil, nil
+		}
+		return New(kind, setting, srv)
+	}
+	for _, kind := range Kinds {
+		if setting := os.Getenv(Env(kind)); setting != "" {
+			return New(kind, setting, srv)
+		}
+	}
+	if _, ok := llm.WebSearcherOf(srv); ok {
+		return New(Native, "", srv)
+	}
+	return nil, nil
+}
+
+// An APIError is an error response from a search provider's API.
+type APIError struct {
+	Provider string
+	Status   string
+	Message  string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s search: %s", e.Provider, e.Status)
+	}
+	return fmt.Sprintf("%s search: %s: %s", e.Provider, e.Status, e.Message)
+}
+
+// getJSON GETs u with header, decoding the JSON response into out.
+// name is the provider's name, for errors.
+func getJSON(ctx context.Context, client *http.Client, name, u string, header map[string]string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := cmp.Or(client, http.DefaultClient).Do(req)
+	if err != nil {
+		return fmt.Errorf("%s search: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return &APIError{Provider: name, Status: resp.Status, Message: strings.TrimSpace(string(data))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s search: bad response: %w", name, err)
+	}
+	return nil
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// plainText returns s, which may have HTML tags and entities such as search providers
+// use to highlight matches, as plain text.
+func plainText(s string) string {
+	return strings.TrimSpace(html.UnescapeString(tagPattern.ReplaceAllString(s, "")))
+}
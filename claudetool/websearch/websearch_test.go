@@ -0,0 +1,162 @@
+package websearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestProviders(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/brave", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Subscription-Token") != "brave-key" || r.URL.Query().Get("count") != "2" {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"web":{"results":[
+			{"title":"Go <strong>1.24</strong>","url":"https://go.dev/doc/go1.24","description":"Release notes &amp; <strong>more</strong>."},
+			{"title":"Blog","url":"https://go.dev/blog/go1.24","description":""}]}}`))
+	})
+	mux.HandleFunc("/searxng/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") != "json" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"results":[
+			{"title":"One","url":"https://one.example","content":"first"},
+			{"title":"Two","url":"https://two.example","content":"second"},
+			{"title":"Three","url":"https://three.example","content":"third"}]}`))
+	})
+	mux.HandleFunc("/bing", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Ocp-Apim-Subscription-Key") != "bing-key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"webPages":{"value":[{"name":"Go","url":"https://go.dev","snippet":"Build simple, secure, scalable systems."}]}}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		provider Provider
+		want     []Result
+	}{
+		{&braveProvider{apiKey: "brave-key", url: ts.URL + "/brave"}, []Result{
+			{Title: "Go 1.24", URL: "https://go.dev/doc/go1.24", Snippet: "Release notes & more."},
+			{Title: "Blog", URL: "https://go.dev/blog/go1.24"},
+		}},
+		{&searxngProvider{base: ts.URL + "/searxng/"}, []Result{
+			{Title: "One", URL: "https://one.example", Snippet: "first"},
+			{Title: "Two", URL: "https://two.example", Snippet: "second"},
+		}},
+		{&bingProvider{apiKey: "bing-key", url: ts.URL + "/bing"}, []Result{
+			{Title: "Go", URL: "https://go.dev", Snippet: "Build simple, secure, scalable systems."},
+		}},
+		{&nativeProvider{searcher: fakeSearcher{}}, []Result{
+			{Title: "Native", URL: "https://native.example", Snippet: "from the model"},
+		}},
+	} {
+		got, err := tt.provider.Search(context.Background(), "go 1.24", 2)
+		if err != nil {
+			t.Errorf("%s: %v", tt.provider.Name(), err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Search = %+v, want %+v", tt.provider.Name(), got, tt.want)
+		}
+	}
+
+	_, err := (&braveProvider{apiKey: "wrong", url: ts.URL + "/brave"}).Search(context.Background(), "go", 2)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !strings.Contains(err.Error(), "Brave search: 401") {
+		t.Errorf("Search with a bad key: %v", err)
+	}
+}
+
+type fakeSearcher struct{}
+
+func (fakeSearcher) WebSearch(ctx context.Context, query string, maxResults int) ([]llm.WebSearchResult, error) {
+	return []llm.WebSearchResult{{Title: "Native", URL: "https://native.example", Snippet: "from the model"}}, nil
+}
+
+// searcherService is an llm.Service with its own web search.
+type searcherService struct {
+	llm.Service
+	fakeSearcher
+}
+
+func TestFromEnvironment(t *testing.T) {
+	for _, kind := range Kinds {
+		if env := Env(kind); env != "" {
+			t.Setenv(env, "")
+		}
+	}
+	plain := &llm.Router{Services: []llm.Service{nil}}
+	native := &llm.Router{Services: []llm.Service{searcherService{}}}
+
+	if p, err := FromEnvironment("", plain); p != nil || err != nil {
+		t.Errorf("FromEnvironment with nothing configured = %v, %v; want nil", p, err)
+	}
+	if p, err := FromEnvironment("", native); err != nil || p == nil || p.Name() != "native" {
+		t.Errorf("FromEnvironment with native search = %v, %v", p, err)
+	}
+	if _, err := FromEnvironment(Native, plain); err == nil {
+		t.Errorf("FromEnvironment(native) without native search succeeded")
+	}
+
+	t.Setenv("BING_API_KEY", "bing-key")
+	t.Setenv("SEARXNG_URL", "https://searx.example")
+	if p, err := FromEnvironment("", native); err != nil || p == nil || p.Name() != "SearXNG" {
+		t.Errorf("FromEnvironment with SearXNG and Bing configured = %v, %v; want SearXNG", p, err)
+	}
+	if p, err := FromEnvironment(Bing, native); err != nil || p == nil || p.Name() != "Bing" {
+		t.Errorf("FromEnvironment(bing) = %v, %v", p, err)
+	}
+	if p, err := FromEnvironment(Brave, native); p != nil || err != nil {
+		t.Errorf("FromEnvironment(brave) without a key = %v, %v; want nil", p, err)
+	}
+	t.Setenv("SEARXNG_URL", "searx.example")
+	if _, err := FromEnvironment(SearXNG, native); err == nil {
+		t.Errorf("FromEnvironment(searxng) with a bad URL succeeded")
+	}
+}
+
+func TestTool(t *testing.T) {
+	tool := (&Tool{Provider: &nativeProvider{searcher: fakeSearcher{}}, Budget: 2}).Tool()
+	search := func(input string) (string, error) {
+		out, err := tool.Run(context.Background(), json.RawMessage(input))
+		if err != nil {
+			return "", err
+		}
+		return out[0].Text, nil
+	}
+
+	out, err := search(`{"query": "  go  "}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got output
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Provider != "native" || got.Query != "go" || len(got.Results) != 1 || got.SearchesLeft != 1 {
+		t.Errorf("web_search = %s", out)
+	}
+
+	if _, err := search(`{"query": ""}`); err == nil || !strings.Contains(err.Error(), "query is required") {
+		t.Errorf("web_search without a query: %v", err)
+	}
+	if _, err := search(`{"query": "two"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := search(`{"query": "three"}`); err == nil || !strings.Contains(err.Error(), "2 web searches are used up") || llm.ToolErrorKindOf(err) != llm.ToolErrorBudgetExceeded {
+		t.Errorf("web_search beyond the budget: %v", err)
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"os"
 
 	"go.skia.org/infra/go/go2ts"
+	"sketch.dev/claudetool/bashkit"
+	"sketch.dev/claudetool/staging"
 	"sketch.dev/git_tools"
 	"sketch.dev/llm"
 	"sketch.dev/loop"
@@ -48,6 +50,34 @@ func TS() *go2ts.Go2TS {
 			loop.CommitMessageType,
 			loop.AutoMessageType,
 		},
+		[]bashkit.Category{
+			bashkit.CategoryBuild,
+			bashkit.CategoryTest,
+			bashkit.CategoryVCS,
+			bashkit.CategoryPackageInstall,
+			bashkit.CategoryFileRead,
+			bashkit.CategoryNetwork,
+			bashkit.CategoryOther,
+		},
+		[]loop.EventType{
+			loop.EventTurnStarted,
+			loop.EventMessageDelta,
+			loop.EventMessage,
+			loop.EventToolCallStarted,
+			loop.EventToolCallOutput,
+			loop.EventToolCallFinished,
+			loop.EventPermissionRequested,
+			loop.EventUsageUpdated,
+			loop.EventStateChanged,
+		},
+		[]llm.ToolErrorKind{
+			llm.ToolErrorUserDenied,
+			llm.ToolErrorTimeout,
+			llm.ToolErrorOutputTooLarge,
+			llm.ToolErrorCrashed,
+			llm.ToolErrorBudgetExceeded,
+			llm.ToolErrorProvider,
+		},
 	)
 
 	// Struct types
@@ -55,6 +85,11 @@ func TS() *go2ts.Go2TS {
 		loop.AgentMessage{},
 		loop.GitCommit{},
 		loop.ToolCall{},
+		loop.StreamingResponse{},
+		loop.StreamingToolCall{},
+		loop.Event{},
+		loop.EventToolCall{},
+		loop.PermissionRequest{},
 		llm.Usage{},
 		server.State{},
 		server.TodoItem{},
@@ -63,6 +98,8 @@ func TS() *go2ts.Go2TS {
 		loop.MultipleChoiceParams{},
 		git_tools.DiffFile{},
 		git_tools.GitLogEntry{},
+		staging.Change{},
+		staging.Decision{},
 	)
 
 	generator.GenerateNominalTypes = true
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"sketch.dev/editorbridge"
+)
+
+// runEditor runs the editor bridge, for editor extensions to drive a running session; see package editorbridge.
+func runEditor(args []string) error {
+	fs := flag.NewFlagSet("sketch editor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s editor [session URL]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "\nFor editor extensions: speaks JSON-RPC 2.0 on standard input and output, one message per line,\n")
+		fmt.Fprintf(fs.Output(), "to open a running session, send it selected code, preview and review its proposed edits,\n")
+		fmt.Fprintf(fs.Output(), "and answer its permission requests. The session URL is that of the session's web UI;\n")
+		fmt.Fprintf(fs.Output(), "without it, the extension opens a session with the session/open method.\n")
+		fmt.Fprintf(fs.Output(), "For the sessions of sketch serve, set %s to its bearer token.\n", apiTokenEnv)
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return editorbridge.New(nil, os.Getenv(apiTokenEnv)).Serve(context.Background(), fs.Arg(0), os.Stdin, os.Stdout)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sketch.dev/transcript"
+)
+
+// runExport runs `sketch export`, which renders a session as Markdown, HTML, or JSON.
+// The session comes from a file downloaded from the web UI, a running session's web UI URL,
+// or a session log directory.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("sketch export", flag.ExitOnError)
+	format := fs.String("format", "", "export format: md, html, or json (default: from the -o file extension, else md)")
+	output := fs.String("o", "", "write the export to this file instead of standard output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export [flags] <session.json | session URL | session log directory>\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "\nThe session is a file downloaded from the web UI, the URL of a running session's web UI,\n")
+		fmt.Fprintf(fs.Output(), "or a session's log directory, which sketch prints when a session starts.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f := transcript.Markdown
+	if *format != "" {
+		var err error
+		if f, err = transcript.ParseFormat(*format); err != nil {
+			return err
+		}
+	} else if ext := filepath.Ext(*output); ext != "" {
+		if parsed, err := transcript.ParseFormat(ext); err == nil {
+			f = parsed
+		}
+	}
+
+	sess, err := readSession(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return transcript.Write(os.Stdout, sess, f)
+	}
+	w, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := transcript.Write(w, sess, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// readSession reads a session from a JSON file, a session log directory,
+// or the web UI at source if it is an HTTP(S) URL.
+func readSession(source string) (*transcript.Session, error) {
+	if fi, err := os.Stat(source); err == nil && fi.IsDir() {
+		return transcript.FromLog(source)
+	}
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return transcript.Read(f)
+	}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	u = u.JoinPath("download")
+	u.RawQuery = "format=json"
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch session: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to fetch session from %s: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return transcript.Read(resp.Body)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"sketch.dev/dockerimg"
+	"sketch.dev/loop"
+	"sketch.dev/sessionstore"
+)
+
+// stdout is the process's standard output.
+// With -output=json it carries only the result, and run points os.Stdout at standard error.
+var stdout io.Writer = os.Stdout
+
+// A headlessResult is what a headless session (-output=json) prints when its turn ends.
+type headlessResult struct {
+	SessionID string `json:"session_id"`
+	// Status is how the turn ended: done, unfinished (the agent stopped without calling done),
+	// budget_exceeded, or error.
+	Status          sessionstore.Outcome `json:"status"`
+	ExitCode        int                  `json:"exit_code"`
+	FinalMessage    string               `json:"final_message"`
+	FilesChanged    []string             `json:"files_changed"`
+	Commands        []headlessCommand    `json:"commands"`
+	CostUSD         float64              `json:"cost_usd"`
+	InputTokens     uint64               `json:"input_tokens"`
+	OutputTokens    uint64               `json:"output_tokens"`
+	DurationSeconds float64              `json:"duration_seconds"`
+}
+
+// A headlessCommand is a bash command the agent ran.
+type headlessCommand struct {
+	Command string `json:"command"`
+	Failed  bool   `json:"failed"`
+}
+
+// headlessExitCode returns the exit status of a headless session that ended with outcome.
+func headlessExitCode(outcome sessionstore.Outcome) int {
+	switch outcome {
+	case sessionstore.OutcomeError:
+		return 1
+	case sessionstore.OutcomeBudgetExceeded:
+		return 3 // 2 is for bad flags
+	}
+	return 0
+}
+
+// newHeadlessResult summarizes the session sess, whose messages are msgs.
+func newHeadlessResult(sess *sessionstore.Session, msgs []loop.AgentMessage) *headlessResult {
+	r := &headlessResult{
+		SessionID:       sess.ID,
+		Status:          sess.Outcome,
+		ExitCode:        headlessExitCode(sess.Outcome),
+		FilesChanged:    sess.FilesChanged,
+		Commands:        []headlessCommand{},
+		CostUSD:         sess.CostUSD,
+		InputTokens:     sess.InputTokens,
+		OutputTokens:    sess.OutputTokens,
+		DurationSeconds: sess.End.Sub(sess.Start).Seconds(),
+	}
+	if r.FilesChanged == nil {
+		r.FilesChanged = []string{}
+	}
+	for _, m := range msgs {
+		if m.ParentConversationID != nil {
+			continue // subagents' work is summarized by their results
+		}
+		switch m.Type {
+		case loop.ToolUseMessageType:
+			if m.ToolName != "bash" {
+				continue
+			}
+			var input struct {
+				Command string `json:"command"`
+			}
+			if json.Unmarshal([]byte(m.ToolInput), &input) == nil && input.Command != "" {
+				r.Commands = append(r.Commands, headlessCommand{Command: input.Command, Failed: m.ToolError})
+			}
+		case loop.AgentMessageType, loop.ErrorMessageType, loop.BudgetMessageType:
+			if m.Content != "" {
+				r.FinalMessage = m.Content
+			}
+		}
+	}
+	return r
+}
+
+// runHeadless waits for agent's first turn to end and writes its result as JSON,
+// to stdout or, inside a container, to dockerimg.HeadlessResultPath for the host to print.
+// The returned error is an exitCode if the turn did not succeed.
+func runHeadless(ctx context.Context, agent *loop.Agent, inInsideSketch bool) error {
+	it := agent.NewIterator(ctx, 0)
+	for {
+		m := it.Next()
+		if m == nil {
+			return ctx.Err()
+		}
+		if m.EndOfTurn && m.ParentConversationID == nil {
+			break
+		}
+	}
+
+	sess := agent.SessionSummary(ctx)
+	result := newHeadlessResult(sess, agent.Messages(0, agent.MessageCount()))
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if inInsideSketch {
+		if err := os.MkdirAll(filepath.Dir(dockerimg.HeadlessResultPath), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dockerimg.HeadlessResultPath, data, 0o644); err != nil {
+			return err
+		}
+	} else if _, err := stdout.Write(data); err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return exitCode(result.ExitCode)
+	}
+	return nil
+}
+
+// printContainerResult prints the result a headless session in a container left in data.
+// It returns an exitCode if the session did not succeed.
+func printContainerResult(data []byte) error {
+	var result headlessResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to read the container's result: %w", err)
+	}
+	if _, err := stdout.Write(data); err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return exitCode(result.ExitCode)
+	}
+	return nil
+}
+
+// An exitCode is an error that makes sketch exit with that status, without printing anything.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"sketch.dev/loop"
+	"sketch.dev/sessionstore"
+)
+
+func TestNewHeadlessResult(t *testing.T) {
+	start := time.Now()
+	sess := &sessionstore.Session{
+		ID:      "s1",
+		Start:   start,
+		End:     start.Add(90 * time.Second),
+		CostUSD: 0.25,
+		Outcome: sessionstore.OutcomeBudgetExceeded,
+	}
+	sub := "sub"
+	msgs := []loop.AgentMessage{
+		{Type: loop.UserMessageType, Content: "fix the failing test"},
+		{Type: loop.AgentMessageType, Content: "Running the tests."},
+		{Type: loop.ToolUseMessageType, ToolName: "bash", ToolInput: `{"command":"go test ./..."}`, ToolError: true},
+		{Type: loop.ToolUseMessageType, ToolName: "bash", ToolInput: `{"command":"go vet"}`, ParentConversationID: &sub},
+		{Type: loop.ToolUseMessageType, ToolName: "patch", ToolInput: `{"path":"x.go"}`},
+		{Type: loop.BudgetMessageType, Content: "budget exceeded: $0.25"},
+	}
+
+	r := newHeadlessResult(sess, msgs)
+	if r.Status != sessionstore.OutcomeBudgetExceeded || r.ExitCode != 3 {
+		t.Errorf("status %s, exit code %d; want budget_exceeded, 3", r.Status, r.ExitCode)
+	}
+	if r.FinalMessage != "budget exceeded: $0.25" {
+		t.Errorf("final message %q", r.FinalMessage)
+	}
+	if len(r.Commands) != 1 || r.Commands[0] != (headlessCommand{Command: "go test ./...", Failed: true}) {
+		t.Errorf("commands %+v; want only the failed go test", r.Commands)
+	}
+	if r.FilesChanged == nil || len(r.FilesChanged) != 0 {
+		t.Errorf("files changed %#v; want an empty list", r.FilesChanged)
+	}
+	if r.DurationSeconds != 90 {
+		t.Errorf("duration %v; want 90", r.DurationSeconds)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -17,24 +19,42 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
 	"sketch.dev/experiment"
+	"sketch.dev/janitor"
+	"sketch.dev/kubejob"
 	"sketch.dev/llm"
 	"sketch.dev/llm/gem"
+	"sketch.dev/llm/llmcache"
 	"sketch.dev/llm/oai"
 	"sketch.dev/mcp"
 
 	"sketch.dev/browser"
+	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/codeindex"
+	"sketch.dev/claudetool/forge"
+	"sketch.dev/claudetool/websearch"
+	"sketch.dev/credentials"
 	"sketch.dev/dockerimg"
 	"sketch.dev/llm/ant"
 	"sketch.dev/llm/conversation"
 	"sketch.dev/loop"
 	"sketch.dev/loop/server"
+	"sketch.dev/notify"
+	"sketch.dev/projectconfig"
+	"sketch.dev/remote"
+	"sketch.dev/sessionlog"
+	"sketch.dev/sessionstore"
 	"sketch.dev/skabandclient"
 	"sketch.dev/skribe"
+	"sketch.dev/statedb"
 	"sketch.dev/termui"
+	"sketch.dev/toolplugin"
+	"sketch.dev/tracing"
 	"sketch.dev/webui"
 
 	"golang.org/x/term"
@@ -46,10 +66,16 @@ var (
 	commit  = "none"    // git commit hash
 	date    = "unknown" // build timestamp
 	builtBy = "unknown" // who built this binary
+
+	// creds looks up API keys, in the providers named by -credentials.
+	creds = &credentials.Store{Providers: []credentials.Provider{credentials.Env{}}}
 )
 
 func main() {
 	err := run()
+	if code, ok := err.(exitCode); ok {
+		os.Exit(int(code))
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
 		os.Exit(1)
@@ -59,7 +85,33 @@ func main() {
 // run is the main entry point that parses flags and dispatches to the appropriate
 // execution path based on whether we're running in a container or not.
 func run() error {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		return runExport(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "worktree" {
+		return runWorktree(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schedule" {
+		return runSchedule(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "editor" {
+		return runEditor(os.Args[2:])
+	}
 	flagArgs := parseCLIFlags()
+	switch flagArgs.output {
+	case "text":
+	case "json":
+		if flagArgs.prompt == "" {
+			return fmt.Errorf("-output=json requires -prompt")
+		}
+		if flagArgs.serve || flagArgs.mcpServe {
+			return fmt.Errorf("-output=json runs a single session; it cannot be used with serve or -mcp-serve")
+		}
+		// Keep stdout for the result.
+		os.Stdout = os.Stderr
+	default:
+		return fmt.Errorf("unknown -output %q; want text or json", flagArgs.output)
+	}
 
 	// Set up signal handling if -ignoresig flag is set
 	if flagArgs.ignoreSig {
@@ -97,6 +149,7 @@ func run() error {
 			}
 			fmt.Printf("- %s%s\n", name, note)
 		}
+		fmt.Println("- any other model name, served by the OpenAI-compatible API at -llm-url (requires -unsafe)")
 		return nil
 	}
 
@@ -104,12 +157,84 @@ func run() error {
 		return dumpDistFilesystem(flagArgs.dumpDist)
 	}
 
+	// sketch serve's sessions each have their own working directory.
+	if !flagArgs.serve && !flagArgs.mcpServe {
+		if err := loadProjectConfig(&flagArgs, cmp.Or(flagArgs.workingDir, ".")); err != nil {
+			return err
+		}
+	}
+
 	// Claude and Gemini are supported in container mode
 	// TODO: finish support--thread through API keys, add server support
 	isContainerSupported := flagArgs.modelName == "claude" || flagArgs.modelName == "" || flagArgs.modelName == "gemini"
 	if !isContainerSupported && (!flagArgs.unsafe || flagArgs.skabandAddr != "") {
 		return fmt.Errorf("only -model=claude is supported in safe mode right now, use -unsafe -skaband-addr=''")
 	}
+	if flagArgs.shadow && !flagArgs.unsafe {
+		return fmt.Errorf("-shadow requires -unsafe; container sessions already work on a copy of the repo")
+	}
+	if flagArgs.worktree && !flagArgs.unsafe {
+		return fmt.Errorf("-worktree requires -unsafe; container sessions already work on a copy of the repo")
+	}
+	if flagArgs.worktree && flagArgs.shadow {
+		return fmt.Errorf("-worktree and -shadow are two ways to keep your checkout untouched; choose one")
+	}
+	if flagArgs.ssh != "" {
+		if !flagArgs.unsafe {
+			return fmt.Errorf("-ssh requires -unsafe; the agent works on the remote host rather than in a container")
+		}
+		if flagArgs.shadow || flagArgs.worktree || flagArgs.approveWrites || flagArgs.autoCommit {
+			return fmt.Errorf("-ssh cannot be used with -shadow, -worktree, -approve-writes, or -autocommit, which work on a local checkout")
+		}
+		if _, err := remote.ParseTarget(flagArgs.ssh); err != nil {
+			return fmt.Errorf("-ssh: %w", err)
+		}
+	}
+	if flagArgs.kubeJobs != "" {
+		if !flagArgs.unsafe {
+			return fmt.Errorf("-kube-jobs requires -unsafe; Jobs mount the workspace that sketch's pod does, not a container's")
+		}
+		if flagArgs.ssh != "" {
+			return fmt.Errorf("-kube-jobs cannot be used with -ssh; Jobs run on a snapshot of the local workspace")
+		}
+	}
+	if flagArgs.llmPlatform != "" && !flagArgs.unsafe {
+		return fmt.Errorf("-llm-platform requires -unsafe; cloud credentials are not available in the container")
+	}
+	if (len(flagArgs.llmFallback) > 0 || len(flagArgs.llmRoutes) > 0) && !flagArgs.unsafe {
+		return fmt.Errorf("-llm-fallback and -llm-route require -unsafe; API keys for other providers are not available in the container")
+	}
+	if !slices.Contains([]string{"ask", "allow", "deny"}, flagArgs.forgeWrites) {
+		return fmt.Errorf("unknown -forge-writes %q; want ask, allow, or deny", flagArgs.forgeWrites)
+	}
+	if flagArgs.forge != "" && !slices.Contains(forge.Kinds, flagArgs.forge) {
+		return fmt.Errorf("unknown -forge %q; want one of %s", flagArgs.forge, strings.Join(forge.Kinds, ", "))
+	}
+	if flagArgs.forgeRepo != "" {
+		if _, err := forge.ParseRepo(flagArgs.forge, flagArgs.forgeRepo); err != nil {
+			return fmt.Errorf("-forge-repo: %w", err)
+		}
+	}
+	if flagArgs.webSearch != "" && flagArgs.webSearch != "off" && !slices.Contains(websearch.Kinds, flagArgs.webSearch) {
+		return fmt.Errorf("unknown -web-search %q; want one of %s, or off", flagArgs.webSearch, strings.Join(websearch.Kinds, ", "))
+	}
+	if flagArgs.embedder != "" && flagArgs.embedder != "auto" && !slices.Contains(codeindex.Kinds, flagArgs.embedder) {
+		return fmt.Errorf("unknown -embedder %q; want one of %s, or auto", flagArgs.embedder, strings.Join(codeindex.Kinds, ", "))
+	}
+	if err := termui.CheckTheme(flagArgs.theme); err != nil {
+		return fmt.Errorf("-theme: %w", err)
+	}
+	if flagArgs.supervise && !flagArgs.unsafe {
+		return fmt.Errorf("-supervise requires -unsafe; containers have no service manager")
+	}
+	if slices.Contains(flagArgs.notify, "desktop") && !flagArgs.unsafe {
+		return fmt.Errorf("-notify=desktop requires -unsafe; commands in a container cannot reach your desktop")
+	}
+	store, err := credentials.Parse(flagArgs.credentials)
+	if err != nil {
+		return fmt.Errorf("-credentials: %w", err)
+	}
+	creds = store
 
 	if err := flagArgs.experimentFlag.Process(); err != nil {
 		fmt.Fprintf(os.Stderr, "error parsing experimental flags: %v\n", err)
@@ -122,10 +247,15 @@ func run() error {
 
 	// Add a global "session_id" to all logs using this context.
 	// A "session" is a single full run of the agent.
-	ctx := skribe.ContextWithAttr(context.Background(), slog.String("session_id", flagArgs.sessionID))
+	// sketch serve runs many sessions, which add their own.
+	ctx := context.Background()
+	if !flagArgs.serve {
+		ctx = skribe.ContextWithAttr(ctx, slog.String("session_id", flagArgs.sessionID))
+	}
 
 	// Configure logging
-	slogHandler, logFile, err := setupLogging(flagArgs.termUI, flagArgs.verbose, flagArgs.unsafe)
+	// In -mcp-serve mode, stdout carries the protocol; don't print the log file name to it.
+	slogHandler, logFile, err := setupLogging(flagArgs.termUI, flagArgs.verbose, flagArgs.unsafe && !flagArgs.mcpServe)
 	if err != nil {
 		return err
 	}
@@ -134,6 +264,19 @@ func run() error {
 	}
 	slog.SetDefault(slog.New(slogHandler))
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Endpoint(flagArgs.otlpEndpoint), version)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Flush the last spans, but don't hang on an unreachable collector.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.WarnContext(ctx, "tracing_shutdown_failed", "error", err)
+		}
+	}()
+
 	// Change to working directory if specified
 	if flagArgs.workingDir != "" {
 		if err := os.Chdir(flagArgs.workingDir); err != nil {
@@ -153,7 +296,11 @@ func run() error {
 	inInsideSketch := flagArgs.outsideHostname != ""
 
 	// Dispatch to the appropriate execution path
-	if inInsideSketch {
+	if flagArgs.mcpServe {
+		return runMCPServer(ctx, flagArgs)
+	} else if flagArgs.serve {
+		return runServe(ctx, flagArgs, logFile)
+	} else if inInsideSketch {
 		// We're running inside the Docker container
 		return runInContainerMode(ctx, flagArgs, logFile)
 	} else if flagArgs.unsafe {
@@ -209,13 +356,27 @@ type CLIFlags struct {
 	addr         string
 	skabandAddr  string
 	unsafe       bool
+	mcpServe     bool
+	serve        bool
+	usersFile    string
+	auditLog     string
 	openBrowser  bool
 	httprrFile   string
 	maxDollars   float64
+	maxTokens    uint64
+	maxToolCalls int
+	maxWallTime  time.Duration
 	oneShot      bool
 	prompt       string
+	output       string
 	modelName    string
 	llmAPIKey    string
+	credentials  StringSliceFlag
+	llmURL       string
+	llmPlatform  string
+	llmRegion    string
+	llmFallback  StringSliceFlag
+	llmRoutes    StringSliceFlag
 	listModels   bool
 	verbose      bool
 	version      bool
@@ -224,6 +385,8 @@ type CLIFlags struct {
 	sshPort      int
 	forceRebuild bool
 	baseImage    string
+	devContainer string
+	uncommitted  bool
 	linkToGitHub bool
 	ignoreSig    bool
 
@@ -249,6 +412,37 @@ type CLIFlags struct {
 	sshConnectionString string
 	subtraceToken       string
 	mcpServers          StringSliceFlag
+	approveWrites       bool
+	notify              StringSliceFlag
+	notifyAfter         time.Duration
+	responseCacheTTL    time.Duration
+	llmStallTimeout     time.Duration
+	llmRateLimit        int
+	otlpEndpoint        string
+	sessionDB           string
+	shadow              bool
+	worktree            bool
+	ssh                 string
+	kubeJobs            string
+	pluginDir           string
+	systemPrompt        string
+	supervise           bool
+	superviseProperties StringSliceFlag
+	forge               string
+	forgeRepo           string
+	forgeWrites         string
+	webSearch           string
+	webSearchBudget     int
+	embedder            string
+	embedderURL         string
+	embedderModel       string
+	autoCommit          bool
+	theme               string
+	projectConfig       string
+	project             *projectconfig.Config // loaded from projectConfig by loadProjectConfig
+	profile             string
+
+	setFlags map[string]bool // the flags set on the command line
 }
 
 // parseCLIFlags parses all command-line flags and returns a CLIFlags struct
@@ -264,13 +458,30 @@ func parseCLIFlags() CLIFlags {
 	userFlags.StringVar(&flags.skabandAddr, "skaband-addr", "https://sketch.dev", "URL of the skaband server; set to empty to disable sketch.dev integration")
 	userFlags.StringVar(&flags.skabandAddr, "ska-band-addr", "https://sketch.dev", "URL of the skaband server; set to empty to disable sketch.dev integration (alias for -skaband-addr)")
 	userFlags.BoolVar(&flags.unsafe, "unsafe", false, "run without a docker container")
+	userFlags.BoolVar(&flags.shadow, "shadow", false, "with -unsafe, work in a private copy of the repo and offer to apply the changes at exit")
+	userFlags.BoolVar(&flags.worktree, "worktree", false, "with -unsafe, work in a git worktree on a branch of the session's own and offer to merge it at exit; see sketch worktree -h")
+	userFlags.StringVar(&flags.ssh, "ssh", "", "with -unsafe, run the agent's commands and file edits on a remote host over SSH, in the directory of [user@]host:/dir or ssh://user@host:port/dir, authenticating with ssh-agent or ~/.ssh keys and checking ~/.ssh/known_hosts")
+	userFlags.StringVar(&flags.kubeJobs, "kube-jobs", "", "with -unsafe, in a Kubernetes pod, a YAML file configuring Kubernetes Jobs that the agent may run heavy commands in, on a snapshot of the workspace's PersistentVolumeClaim; see kubejob.LoadConfig")
+	userFlags.StringVar(&flags.usersFile, "users", "", "with serve, a YAML file of the users to serve, with their tokens or OIDC provider, the directories their sessions may start in, and budgets; the users are not isolated from each other; see apiserver.LoadUsers")
+	userFlags.StringVar(&flags.auditLog, "audit-log", "", "with serve, a file to append an audit trail to, as lines of JSON")
+	userFlags.BoolVar(&flags.mcpServe, "mcp-serve", false, "instead of running an agent, serve sketch's bash, patch, and keyword search tools over MCP on stdin/stdout, running commands directly on the host like -unsafe")
 	userFlags.BoolVar(&flags.openBrowser, "open", true, "open sketch URL in system browser; on by default except if -one-shot is used or a ssh connection is detected")
 	userFlags.Float64Var(&flags.maxDollars, "max-dollars", 10.0, "maximum dollars the agent should spend per turn, 0 to disable limit")
+	userFlags.Uint64Var(&flags.maxTokens, "max-tokens", 0, "maximum tokens the agent should use per turn, 0 to disable limit")
+	userFlags.IntVar(&flags.maxToolCalls, "max-tool-calls", 0, "maximum tool calls the agent should make per turn, 0 to disable limit")
+	userFlags.DurationVar(&flags.maxWallTime, "max-wall-time", 0, "maximum time the agent should work per turn, 0 to disable limit")
 	userFlags.BoolVar(&flags.oneShot, "one-shot", false, "exit after the first turn without termui")
 	userFlags.StringVar(&flags.prompt, "prompt", "", "prompt to send to sketch")
 	userFlags.StringVar(&flags.prompt, "p", "", "prompt to send to sketch (alias for -prompt)")
+	userFlags.StringVar(&flags.output, "output", "text", "text, or json to run -prompt headless for CI: one turn without UI, then a JSON result (status, final message, files changed, commands run) on stdout; exits 1 if the turn failed and 3 if it ran out of budget")
 	userFlags.StringVar(&flags.modelName, "model", "claude", "model to use (e.g. claude, gpt4.1)")
-	userFlags.StringVar(&flags.llmAPIKey, "llm-api-key", "", "API key for the LLM provider; if not set, will be read from an env var")
+	userFlags.StringVar(&flags.llmAPIKey, "llm-api-key", "", "API key for the LLM provider, or a comma-separated list of keys to rotate through on rate limits; if not set, will be read from an env var")
+	userFlags.Var(&flags.credentials, "credentials", "where to look up API keys, tried in order: env, keychain, 1password[:VAULT], or vault:PATH (a HashiCorp Vault secret, with VAULT_ADDR and VAULT_TOKEN) (can be repeated); defaults to env")
+	userFlags.StringVar(&flags.llmURL, "llm-url", "", "base URL of the LLM API; with a -model that is not in -list-models, the URL of an OpenAI-compatible server (e.g. vLLM, llama.cpp) serving that model; requires -unsafe")
+	userFlags.StringVar(&flags.llmPlatform, "llm-platform", "", "cloud platform to call Claude through: bedrock (AWS credentials from the environment) or vertex (Google Application Default Credentials); requires -unsafe")
+	userFlags.StringVar(&flags.llmRegion, "llm-region", "", "cloud region for -llm-platform; defaults to AWS_REGION for bedrock and CLOUD_ML_REGION or us-east5 for vertex")
+	userFlags.Var(&flags.llmFallback, "llm-fallback", "model to fall back to when -model is overloaded, failing, or rate limited (can be repeated, tried in order); requires -unsafe")
+	userFlags.Var(&flags.llmRoutes, "llm-route", "task=model[,model...] sends a kind of background work to its own models, tried in order; tasks are install-tools, compaction, commit-style, keyword-search, tool-result-summary, autocommit, and codebase-overview (which also enables the codebase_overview tool) (can be repeated); requires -unsafe")
 	userFlags.BoolVar(&flags.listModels, "list-models", false, "list all available models and exit")
 	userFlags.BoolVar(&flags.verbose, "verbose", false, "enable verbose output")
 	userFlags.BoolVar(&flags.version, "version", false, "print the version and exit")
@@ -280,12 +491,40 @@ func parseCLIFlags() CLIFlags {
 	defaultImageName, _, defaultTag := dockerimg.DefaultImage()
 	defaultHelpText := fmt.Sprintf("base Docker image to use (defaults to %s:%s); see https://sketch.dev/docs/docker for instructions", defaultImageName, defaultTag)
 	userFlags.StringVar(&flags.baseImage, "base-image", "", defaultHelpText)
+	userFlags.StringVar(&flags.devContainer, "devcontainer", dockerimg.DevContainerAuto, "what to build the container's base image from: auto for the repo's .devcontainer/devcontainer.json or .devcontainer.json, if it has one and -base-image is not set, none for the default base image, or the path of a devcontainer.json or Dockerfile in the repo")
+	userFlags.BoolVar(&flags.uncommitted, "include-uncommitted", false, "start the container's session from your uncommitted changes, including untracked files, committed on top of HEAD")
 
 	userFlags.StringVar(&flags.dockerArgs, "docker-args", "--cap-add=NET_ADMIN --cap-add=NET_RAW", "additional arguments to pass to the docker create command (e.g., --memory=2g --cpus=2)")
 	userFlags.Var(&flags.mounts, "mount", "volume to mount in the container in format /path/on/host:/path/in/container (can be repeated)")
 	userFlags.BoolVar(&flags.termUI, "termui", true, "enable terminal UI")
+	userFlags.StringVar(&flags.theme, "theme", termui.DefaultTheme, "chroma style to highlight code and diffs with in the terminal UI (e.g. monokai, github, dracula), or none")
 	userFlags.StringVar(&flags.branchPrefix, "branch-prefix", "sketch/", "prefix for git branches created by sketch")
 	userFlags.BoolVar(&flags.ignoreSig, "ignoresig", false, "ignore typical termination signals (SIGINT, SIGTERM)")
+	userFlags.BoolVar(&flags.approveWrites, "approve-writes", false, "stage file modifications until you approve them (per file or per hunk)")
+	userFlags.BoolVar(&flags.autoCommit, "autocommit", false, "commit the files the agent wrote at the end of every turn, as small commits with LLM-written conventional-commit messages; other changes, and what you staged, are left alone")
+	userFlags.Var(&flags.notify, "notify", "notify when a command runs longer than -notify-after and when it completes, when a turn ends or exceeds its budget, when the agent waits for permission, and when a background job crashes: bell, desktop (with -unsafe), or a webhook URL, which is sent Slack-compatible JSON (can be repeated)")
+	userFlags.DurationVar(&flags.notifyAfter, "notify-after", 30*time.Second, "how long a command runs before -notify notifications are sent")
+	userFlags.DurationVar(&flags.responseCacheTTL, "response-cache-ttl", llmcache.DefaultTTL, "how long to reuse LLM responses to deterministic subagent prompts, such as commit style analysis; 0 disables the cache")
+	userFlags.DurationVar(&flags.llmStallTimeout, "llm-stall-timeout", llm.DefaultStallTimeout, "retry LLM requests whose responses stop producing data for this long; 0 waits forever")
+	userFlags.IntVar(&flags.llmRateLimit, "llm-rate-limit", 0, "requests a minute to send to each LLM provider account, shared by the agent and its subagents; 0 sends as fast as the provider allows")
+	userFlags.StringVar(&flags.otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP URL (e.g. http://localhost:4318) to export OpenTelemetry traces of turns, LLM requests, and tool calls to; defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+	defaultSessionDB, _ := sessionstore.DefaultPath()
+	userFlags.StringVar(&flags.sessionDB, "session-db", defaultSessionDB, "SQLite database that keeps a summary of each session (task, duration, cost, outcome, files changed, tool failures) for later analysis; empty disables it")
+	defaultPluginDir, _ := toolplugin.DefaultDir()
+	userFlags.StringVar(&flags.systemPrompt, "system-prompt", "", "file with a Go text/template to use as the system prompt instead of the built-in one, which it can include with {{template \"sketch\" .}}; it can use facts about the repository such as {{.Codebase.Languages}} and {{.Codebase.RecentCommits}}")
+	userFlags.StringVar(&flags.projectConfig, "project-config", "", "YAML file of project settings: model, tools to disable, timeouts, permission rules for tool calls, and environment variables for commands (see package projectconfig); defaults to "+projectconfig.Path+" in the repository, if there is one; flags take precedence")
+	userFlags.StringVar(&flags.profile, "profile", "", "named preset of tools, permission rules, and budget: safe, yolo, ci, review-only, or one of the project configuration's profiles; flags take precedence")
+	userFlags.StringVar(&flags.pluginDir, "plugin-dir", defaultPluginDir, "directory of tool plugins: Go plugins (*.so) and executables that speak JSON-RPC on stdin/stdout; in a container, executables must run on linux; empty disables plugins")
+	userFlags.BoolVar(&flags.supervise, "supervise", false, "with -unsafe, run background commands under systemd (systemd-run --user --scope) on Linux or launchd on macOS, so that stopping one stops every process it started")
+	userFlags.Var(&flags.superviseProperties, "supervise-property", "systemd unit property for -supervise commands, such as MemoryMax=2G or CPUQuota=200% (can be repeated; ignored by launchd)")
+	userFlags.StringVar(&flags.forgeRepo, "forge-repo", "", "repository (a URL, or owner/name) for the forge tool, which reads issues and opens pull requests; defaults to the origin remote; the tool is enabled when the forge's token (GITHUB_TOKEN, GITLAB_TOKEN, or BITBUCKET_TOKEN) is set")
+	userFlags.StringVar(&flags.forge, "forge", "", "kind of forge the -forge-repo is on: github, gitlab, or bitbucket; defaults to what its host name suggests")
+	userFlags.StringVar(&flags.forgeWrites, "forge-writes", "ask", "whether the forge tool may comment, push, and open pull requests: ask (each time), allow, or deny")
+	userFlags.StringVar(&flags.webSearch, "web-search", "", "search provider for the web_search tool: brave, searxng, bing, native (the model provider's own search), or off; defaults to the first whose setting (BRAVE_API_KEY, SEARXNG_URL, or BING_API_KEY) is set, else native search, if the model provider has it")
+	userFlags.IntVar(&flags.webSearchBudget, "web-search-budget", websearch.DefaultBudget, "most web searches the agent may make in a session")
+	userFlags.StringVar(&flags.embedder, "embedder", "", "embedding provider that indexes the repository under .sketch/index for the semantic_search tool: voyage, openai, gemini, local (an OpenAI-compatible server such as llama.cpp), or auto (the first whose API key, VOYAGE_API_KEY, OPENAI_API_KEY, or GEMINI_API_KEY, is set); empty disables indexing")
+	userFlags.StringVar(&flags.embedderURL, "embedder-url", "", "embeddings API URL, such as a local server's; defaults to the provider's")
+	userFlags.StringVar(&flags.embedderModel, "embedder-model", "", "embedding model; defaults to the provider's code or general-purpose model")
 	userFlags.Var(&flags.mcpServers, "mcp", "MCP server configuration as JSON (can be repeated). Schema: {\"name\": \"server-name\", \"type\": \"stdio|http|sse\", \"url\": \"...\", \"command\": \"...\", \"args\": [...], \"env\": {...}, \"headers\": {...}}")
 
 	// Internal flags (for sketch developers or internal use)
@@ -320,6 +559,10 @@ func parseCLIFlags() CLIFlags {
 	// Custom usage function that shows only user-visible flags by default
 	userFlags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags]                  run a session\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s serve [flags]            serve an HTTP API for driving sessions programmatically (requires -unsafe)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s export [flags] <session> export a session as Markdown, HTML, or JSON\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s schedule [flags]         run the project's jobs on their schedules; see sketch schedule -h\n", os.Args[0])
 		userFlags.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nFor additional internal/debugging flags, use -help-internal\n")
 	}
@@ -346,17 +589,23 @@ func parseCLIFlags() CLIFlags {
 	})
 
 	// Parse all arguments with the combined flagset
-	allFlags.Parse(os.Args[1:])
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "serve" {
+		flags.serve = true
+		args = args[1:]
+	}
+	allFlags.Parse(args)
+	if flags.output == "json" {
+		flags.oneShot = true
+		flags.termUI = false
+	}
 
-	// -open's default value is not a simple true/false; it depends on other flags and conditions.
-	// Distinguish between -open default value vs explicitly set.
-	openExplicit := false
+	flags.setFlags = make(map[string]bool)
 	allFlags.Visit(func(f *flag.Flag) {
-		if f.Name == "open" {
-			openExplicit = true
-		}
+		flags.setFlags[f.Name] = true
 	})
-	if !openExplicit {
+	// -open's default value is not a simple true/false; it depends on other flags and conditions.
+	if !flags.setFlags["open"] {
 		// Not explicitly set.
 		// Calculate the right default value: true except with one-shot mode or if we're running in a ssh session.
 		flags.openBrowser = !flags.oneShot && os.Getenv("SSH_CONNECTION") == ""
@@ -412,11 +661,12 @@ func runInHostMode(ctx context.Context, flags CLIFlags) error {
 		if flags.modelName == "gemini" {
 			envName = gem.GeminiAPIKeyEnv
 		}
-		apiKey = cmp.Or(os.Getenv(envName), flags.llmAPIKey)
+		apiKey = cmp.Or(credential(ctx, envName), flags.llmAPIKey)
 		if apiKey == "" {
-			return fmt.Errorf("%s environment variable is not set, -llm-api-key flag not provided", envName)
+			return fmt.Errorf("%s environment variable is not set or found with -credentials, -llm-api-key flag not provided", envName)
 		}
 	}
+	credentials.AddSecret(apiKey)
 
 	// Get current working directory
 	cwd, err := os.Getwd()
@@ -443,25 +693,84 @@ func runInHostMode(ctx context.Context, flags CLIFlags) error {
 		SSHPort:           flags.sshPort,
 		ForceRebuild:      flags.forceRebuild,
 		BaseImage:         flags.baseImage,
+		DevContainer:      flags.devContainer,
 		OutsideHostname:   getHostname(),
 		OutsideOS:         runtime.GOOS,
 		OutsideWorkingDir: cwd,
 		OneShot:           flags.oneShot,
 		Prompt:            flags.prompt,
 
-		Verbose:        flags.verbose,
-		DockerArgs:     flags.dockerArgs,
-		Mounts:         flags.mounts,
-		ExperimentFlag: flags.experimentFlag.String(),
-		TermUI:         flags.termUI,
-		MaxDollars:     flags.maxDollars,
-		BranchPrefix:   flags.branchPrefix,
-		LinkToGitHub:   flags.linkToGitHub,
-		SubtraceToken:  flags.subtraceToken,
-		MCPServers:     flags.mcpServers,
+		Verbose:          flags.verbose,
+		DockerArgs:       flags.dockerArgs,
+		Mounts:           flags.mounts,
+		ExperimentFlag:   flags.experimentFlag.String(),
+		TermUI:           flags.termUI,
+		MaxDollars:       flags.maxDollars,
+		MaxTokens:        flags.maxTokens,
+		MaxToolCalls:     flags.maxToolCalls,
+		MaxWallTime:      flags.maxWallTime,
+		BranchPrefix:     flags.branchPrefix,
+		LinkToGitHub:     flags.linkToGitHub,
+		SubtraceToken:    flags.subtraceToken,
+		MCPServers:       flags.mcpServers,
+		ApproveWrites:    flags.approveWrites,
+		Notify:           flags.notify,
+		NotifyAfter:      flags.notifyAfter,
+		ResponseCacheTTL: flags.responseCacheTTL,
+		LLMStallTimeout:  flags.llmStallTimeout,
+		LLMRateLimit:     flags.llmRateLimit,
+		OTLPEndpoint:     tracing.Endpoint(flags.otlpEndpoint),
+		ForgeWrites:      flags.forgeWrites,
+		WebSearch:        flags.webSearch,
+		WebSearchBudget:  flags.webSearchBudget,
+		Embedder:         flags.embedder,
+		EmbedderURL:      flags.embedderURL,
+		EmbedderModel:    flags.embedderModel,
+		AutoCommit:       flags.autoCommit,
+		Theme:            flags.theme,
+
+		IncludeUncommitted: flags.uncommitted,
+	}
+	if loc, ok := forgeLocation(ctx, flags, cwd); ok {
+		config.ForgeKind, config.ForgeRepo = loc.Kind, loc.URL()
+	}
+	if flags.pluginDir != "" {
+		if info, err := os.Stat(flags.pluginDir); err == nil && info.IsDir() {
+			config.PluginDir = flags.pluginDir
+		}
+	}
+	if flags.systemPrompt != "" {
+		// Check the template here, rather than after the container starts.
+		if _, err := readSystemPrompt(flags.systemPrompt); err != nil {
+			return err
+		}
+		if config.SystemPrompt, err = filepath.Abs(flags.systemPrompt); err != nil {
+			return err
+		}
+	}
+	config.ProjectConfig = flags.projectConfig
+	config.Profile = flags.profile
+	if flags.sessionDB != "" {
+		store, err := sessionstore.Open(flags.sessionDB)
+		if err != nil {
+			slog.WarnContext(ctx, "session_store_disabled", "err", err)
+		} else {
+			defer store.Close()
+			config.SessionStore = store
+		}
+	}
+
+	var result bytes.Buffer
+	if flags.output == "json" {
+		config.Result = &result
 	}
 
-	if err := dockerimg.LaunchContainer(ctx, config); err != nil {
+	err = dockerimg.LaunchContainer(ctx, config)
+	if result.Len() > 0 {
+		// The container's exit status is in the result.
+		return printContainerResult(result.Bytes())
+	}
+	if err != nil {
 		if flags.verbose {
 			fmt.Fprintf(os.Stderr, "dockerimg launch container failed: %v\n", err)
 		}
@@ -471,6 +780,27 @@ func runInHostMode(ctx context.Context, flags CLIFlags) error {
 	return nil
 }
 
+// forgeLocation returns the location of the repository for the forge tool:
+// flags' -forge-repo, or else the origin remote of the repo at dir, if it is on a known forge.
+func forgeLocation(ctx context.Context, flags CLIFlags, dir string) (forge.Location, bool) {
+	repo := flags.forgeRepo
+	if repo == "" {
+		cmd := exec.CommandContext(ctx, "git", "remote", "get-url", "origin")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			return forge.Location{}, false
+		}
+		repo = strings.TrimSpace(string(out))
+	}
+	loc, err := forge.ParseRepo(flags.forge, repo)
+	if err != nil {
+		slog.DebugContext(ctx, "no_forge", "repo", repo, "err", err)
+		return forge.Location{}, false
+	}
+	return loc, true
+}
+
 func skabandMcpConfiguration(flags CLIFlags) string {
 	skabandaddr, err := skabandclient.LocalhostToDockerInternal(flags.skabandAddr)
 	if err != nil {
@@ -512,16 +842,13 @@ func runInContainerMode(ctx context.Context, flags CLIFlags, logFile *os.File) e
 // This mode is used when the -unsafe flag is provided.
 func runInUnsafeMode(ctx context.Context, flags CLIFlags, logFile *os.File) error {
 	// Check if we need to get the API key from environment
-	var apiKey, antURL, pubKey string
+	var apiKey, modelURL, pubKey string
 
 	if flags.skabandAddr == "" {
-		envName := "ANTHROPIC_API_KEY"
-		if flags.modelName == "gemini" {
-			envName = gem.GeminiAPIKeyEnv
-		}
-		apiKey = cmp.Or(os.Getenv(envName), flags.llmAPIKey)
-		if apiKey == "" {
-			return fmt.Errorf("%s environment variable is not set, -llm-api-key flag not provided", envName)
+		var err error
+		modelURL, apiKey, err = directCredentials(ctx, flags)
+		if err != nil {
+			return err
 		}
 	} else {
 		// Connect to skaband
@@ -529,7 +856,7 @@ func runInUnsafeMode(ctx context.Context, flags CLIFlags, logFile *os.File) erro
 		if err != nil {
 			return err
 		}
-		pubKey, antURL, apiKey, err = skabandclient.Login(os.Stdout, privKey, flags.skabandAddr, flags.sessionID, flags.modelName)
+		pubKey, modelURL, apiKey, err = skabandclient.Login(os.Stdout, privKey, flags.skabandAddr, flags.sessionID, flags.modelName)
 		if err != nil {
 			return err
 		}
@@ -538,58 +865,135 @@ func runInUnsafeMode(ctx context.Context, flags CLIFlags, logFile *os.File) erro
 		flags.mcpServers = append(flags.mcpServers, skabandMcpConfiguration(flags))
 	}
 
-	return setupAndRunAgent(ctx, flags, antURL, apiKey, pubKey, false, logFile)
+	if flags.shadow {
+		ws, err := enterShadowWorkspace(ctx)
+		if err != nil {
+			return err
+		}
+		defer finishShadowWorkspace(ctx, ws, flags)
+	}
+	if flags.worktree {
+		w, err := enterWorktree(ctx, flags)
+		if err != nil {
+			return err
+		}
+		defer finishWorktree(ctx, w)
+	}
+
+	return setupAndRunAgent(ctx, flags, modelURL, apiKey, pubKey, false, logFile)
+}
+
+// directCredentials returns the model URL and API key for using the LLM API directly, without skaband.
+func directCredentials(ctx context.Context, flags CLIFlags) (modelURL, apiKey string, err error) {
+	switch {
+	case flags.llmPlatform != "":
+		// Authenticated with cloud credentials rather than an API key.
+	case flags.modelName == "" || flags.modelName == "claude" || flags.modelName == "gemini":
+		envName := "ANTHROPIC_API_KEY"
+		if flags.modelName == "gemini" {
+			envName = gem.GeminiAPIKeyEnv
+		}
+		apiKey = cmp.Or(credential(ctx, envName), flags.llmAPIKey)
+		if apiKey == "" {
+			return "", "", fmt.Errorf("%s environment variable is not set or found with -credentials, -llm-api-key flag not provided", envName)
+		}
+	default:
+		// OpenAI-compatible models name their own API key environment variable, if any.
+		apiKey = flags.llmAPIKey
+	}
+	return flags.llmURL, apiKey, nil
+}
+
+// dialRemote connects to the host of target, written as for -ssh, and returns it and the directory to work in there.
+func dialRemote(ctx context.Context, target string) (*remote.Host, string, error) {
+	t, err := remote.ParseTarget(target)
+	if err != nil {
+		return nil, "", fmt.Errorf("-ssh: %w", err)
+	}
+	config, err := remote.ClientConfig(t.User)
+	if err != nil {
+		return nil, "", fmt.Errorf("-ssh: %w", err)
+	}
+	host, err := remote.Dial(ctx, t.Addr, config)
+	if err != nil {
+		return nil, "", fmt.Errorf("-ssh: %w", err)
+	}
+	slog.InfoContext(ctx, "remote_connected", "target", t.String())
+	return host, t.Dir, nil
+}
+
+// credential returns the secret called name from creds, or "" if there is none.
+func credential(ctx context.Context, name string) string {
+	value, err := creds.Lookup(ctx, name)
+	if err != nil {
+		slog.DebugContext(ctx, "credential_not_found", "name", name, "error", err)
+	}
+	return value
 }
 
 // setupAndRunAgent handles the common logic for setting up and running the agent
 // in both container and unsafe modes.
-func setupAndRunAgent(ctx context.Context, flags CLIFlags, modelURL, apiKey, pubKey string, inInsideSketch bool, logFile *os.File) error {
-	var client *http.Client
-
+func setupAndRunAgent(ctx context.Context, flags CLIFlags, modelURL, apiKey, pubKey string, inInsideSketch bool, logFile *os.File) (err error) {
 	// Set the public key environment variable if provided
 	// This is needed for MCP server authentication placeholder replacement
 	if pubKey != "" {
 		os.Setenv("SKETCH_PUB_KEY", pubKey)
 	}
+	// Keep the API key out of tool output and logs.
+	credentials.AddSecret(apiKey)
 
 	wd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-
-	llmService, err := selectLLMService(client, flags.modelName, modelURL, apiKey)
+	agentConfig, closeConfig, err := newAgentConfig(ctx, flags, modelURL, apiKey, wd)
 	if err != nil {
-		return fmt.Errorf("failed to initialize LLM service: %w", err)
+		return err
 	}
-	budget := conversation.Budget{
-		MaxDollars: flags.maxDollars,
+	defer closeConfig()
+	ctx = agentConfig.Context // it carries the session log
+	if flags.ssh != "" {
+		host, dir, err := dialRemote(ctx, flags.ssh)
+		if err != nil {
+			return err
+		}
+		defer host.Close()
+		agentConfig.Executor = host
+		agentConfig.WorkingDir = dir
 	}
-
-	agentConfig := loop.AgentConfig{
-		Context:           ctx,
-		Service:           llmService,
-		Budget:            budget,
-		GitUsername:       flags.gitUsername,
-		GitEmail:          flags.gitEmail,
-		SessionID:         flags.sessionID,
-		ClientGOOS:        runtime.GOOS,
-		ClientGOARCH:      runtime.GOARCH,
-		OutsideHostname:   flags.outsideHostname,
-		OutsideOS:         flags.outsideOS,
-		OutsideWorkingDir: flags.outsideWorkingDir,
-		WorkingDir:        wd,
-		// Ultimately this is a subtle flag because it's trying to distinguish
-		// between unsafe-on-host and inside sketch, and should probably be renamed/simplified.
-		InDocker:            flags.outsideHostname != "",
-		OneShot:             flags.oneShot,
-		GitRemoteAddr:       flags.gitRemoteURL,
-		Upstream:            flags.upstream,
-		OutsideHTTP:         flags.outsideHTTP,
-		Commit:              flags.commit,
-		BranchPrefix:        flags.branchPrefix,
-		LinkToGitHub:        flags.linkToGitHub,
-		SSHConnectionString: flags.sshConnectionString,
-		MCPServers:          flags.mcpServers,
+	if flags.kubeJobs != "" {
+		config, err := kubejob.LoadConfig(flags.kubeJobs)
+		if err != nil {
+			return fmt.Errorf("-kube-jobs: %w", err)
+		}
+		runner, err := kubejob.New(*config)
+		if err != nil {
+			return fmt.Errorf("-kube-jobs: %w", err)
+		}
+		agentConfig.Cluster = runner
+	}
+	go (&janitor.Janitor{State: agentConfig.StateDB, Repo: wd}).Run(ctx, time.Hour)
+
+	switch {
+	case inInsideSketch:
+		// The container can't use SQLite; leave the summary for the host to record.
+		agentConfig.RecordSession = func(ctx context.Context, sess *sessionstore.Session) {
+			if err := sessionstore.WriteFile(dockerimg.SessionSummaryPath, sess); err != nil {
+				slog.WarnContext(ctx, "session_summary_write_failed", "err", err)
+			}
+		}
+	case flags.sessionDB != "":
+		store, err := sessionstore.Open(flags.sessionDB)
+		if err != nil {
+			slog.WarnContext(ctx, "session_store_disabled", "err", err)
+			break
+		}
+		defer store.Close()
+		agentConfig.RecordSession = func(ctx context.Context, sess *sessionstore.Session) {
+			if err := store.Record(ctx, sess); err != nil {
+				slog.WarnContext(ctx, "session_record_failed", "err", err)
+			}
+		}
 	}
 
 	// Create SkabandClient if skaband address is provided
@@ -603,11 +1007,28 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, modelURL, apiKey, pub
 	if err != nil {
 		return err
 	}
+	// Ending the session from the web UI stops everything below and returns
+	// like any other exit, so that deferred cleanup such as -shadow's still runs.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-srv.Ended():
+			cancel(errSessionEnded)
+		case <-ctx.Done():
+		}
+	}()
+	defer func() {
+		if errors.Is(context.Cause(ctx), errSessionEnded) {
+			err = nil
+		}
+	}()
 
 	// Initialize the agent (only needed when not inside sketch with outside hostname)
 	// In the innie case, outtie sends a POST /init
 	if !inInsideSketch {
-		if err = agent.Init(loop.AgentInit{}); err != nil {
+		// A remote working directory's repository is not on this machine for the agent's git features.
+		if err = agent.Init(loop.AgentInit{NoGit: flags.ssh != ""}); err != nil {
 			return fmt.Errorf("failed to initialize agent: %v", err)
 		}
 	}
@@ -660,7 +1081,7 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, modelURL, apiKey, pub
 
 	// Create the termui instance only if needed
 	if flags.termUI {
-		s = termui.New(agent, ps1URL)
+		s = termui.New(agent, ps1URL, flags.theme)
 	}
 
 	// Start skaband connection loop if needed
@@ -684,6 +1105,10 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, modelURL, apiKey, pub
 		}
 	}
 
+	if flags.output == "json" {
+		return runHeadless(ctx, agent, inInsideSketch)
+	}
+
 	// Handle one-shot mode or mode without terminal UI
 	if flags.oneShot || s == nil {
 		it := agent.NewIterator(ctx, 0)
@@ -729,6 +1154,267 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, modelURL, apiKey, pub
 	return nil
 }
 
+// errSessionEnded is the cause of the session context's cancellation when a client ends the session.
+var errSessionEnded = errors.New("session ended")
+
+// newAgentConfig configures an agent that works in wd according to flags.
+// Call the returned function once the agent is done to release what the configuration holds.
+func newAgentConfig(ctx context.Context, flags CLIFlags, modelURL, apiKey, wd string) (loop.AgentConfig, func(), error) {
+	// All of the session's conversations share each provider account's rate limit.
+	transport := &llm.RateLimitTransport{PerMinute: flags.llmRateLimit}
+	if flags.llmStallTimeout > 0 {
+		transport.Base = &llm.StallTransport{Timeout: flags.llmStallTimeout}
+	}
+	client := &http.Client{Transport: transport}
+
+	platform, err := claudePlatform(flags.llmPlatform, flags.llmRegion)
+	if err != nil {
+		return loop.AgentConfig{}, nil, err
+	}
+	llmService, err := selectLLMService(client, flags.modelName, modelURL, apiKey, platform)
+	if err != nil {
+		return loop.AgentConfig{}, nil, fmt.Errorf("failed to initialize LLM service: %w", err)
+	}
+	llmService, err = routeLLMService(client, flags.modelName, llmService, flags.llmFallback, flags.llmRoutes)
+	if err != nil {
+		return loop.AgentConfig{}, nil, err
+	}
+	notifier, err := notify.Parse(flags.notify, os.Stderr)
+	if err != nil {
+		return loop.AgentConfig{}, nil, err
+	}
+	notifier = withProjectWebhooks(notifier, flags.project)
+	var systemPrompt string
+	if flags.systemPrompt != "" {
+		if systemPrompt, err = readSystemPrompt(flags.systemPrompt); err != nil {
+			return loop.AgentConfig{}, nil, err
+		}
+	}
+
+	var responseCache *llmcache.Cache
+	if flags.responseCacheTTL > 0 {
+		if dir, err := llmcache.DefaultDir(); err != nil {
+			slog.WarnContext(ctx, "llm_response_cache_disabled", "err", err)
+		} else {
+			namespace := strings.TrimSuffix(flags.modelName+"@"+flags.llmURL, "@")
+			responseCache = llmcache.New(dir, namespace, flags.responseCacheTTL)
+			go func() {
+				if err := responseCache.Prune(); err != nil {
+					slog.WarnContext(ctx, "llm_response_cache_prune_failed", "err", err)
+				}
+			}()
+		}
+	}
+
+	var closers []func() error
+	var stateDB *statedb.DB
+	if path, err := statedb.DefaultPath(wd); err != nil {
+		slog.WarnContext(ctx, "state_db_disabled", "err", err)
+	} else if stateDB, err = statedb.Open(path); err != nil {
+		// Most likely another session in the same directory has it open.
+		slog.WarnContext(ctx, "state_db_disabled", "err", err)
+	} else {
+		closers = append(closers, stateDB.Close)
+	}
+	var sessionLog *sessionlog.Log
+	if stateDB != nil {
+		if dir, err := stateDB.Session(flags.sessionID).Dir("log"); err != nil {
+			slog.WarnContext(ctx, "session_log_disabled", "err", err)
+		} else if sessionLog, err = sessionlog.Open(dir, sessionlog.Limits{}); err != nil {
+			slog.WarnContext(ctx, "session_log_disabled", "err", err)
+		} else {
+			// The session's log lines go to its log too; see setupLogging.
+			h := sessionLog.Handler(&slog.HandlerOptions{Level: slog.LevelInfo})
+			ctx = skribe.ContextWithHandler(ctx, credentials.RedactHandler(skribe.AttrsWrap(h)))
+			closers = append(closers, sessionLog.Close)
+			slog.InfoContext(ctx, "session_log", "dir", dir)
+			if flags.unsafe {
+				fmt.Printf("session log: %s\n", dir)
+			}
+		}
+	}
+	var pluginTools []*llm.Tool
+	var pluginCommands []*toolplugin.Command
+	if flags.pluginDir != "" {
+		plugins, err := toolplugin.Load(ctx, flags.pluginDir, wd)
+		if err != nil {
+			slog.WarnContext(ctx, "plugin_load_failed", "err", err)
+		}
+		if plugins != nil {
+			closers = append(closers, plugins.Close)
+			pluginTools = plugins.Tools
+			pluginCommands = plugins.Commands
+		}
+	}
+
+	budget := conversation.Budget{
+		MaxDollars:   flags.maxDollars,
+		MaxTokens:    flags.maxTokens,
+		MaxToolCalls: flags.maxToolCalls,
+		MaxWallTime:  flags.maxWallTime,
+	}
+
+	agentConfig := loop.AgentConfig{
+		Context:           ctx,
+		Service:           llmService,
+		Budget:            budget,
+		GitUsername:       flags.gitUsername,
+		GitEmail:          flags.gitEmail,
+		SessionID:         flags.sessionID,
+		ClientGOOS:        runtime.GOOS,
+		ClientGOARCH:      runtime.GOARCH,
+		OutsideHostname:   flags.outsideHostname,
+		OutsideOS:         flags.outsideOS,
+		OutsideWorkingDir: flags.outsideWorkingDir,
+		WorkingDir:        wd,
+		// Ultimately this is a subtle flag because it's trying to distinguish
+		// between unsafe-on-host and inside sketch, and should probably be renamed/simplified.
+		InDocker:            flags.outsideHostname != "",
+		OneShot:             flags.oneShot,
+		GitRemoteAddr:       flags.gitRemoteURL,
+		Upstream:            flags.upstream,
+		OutsideHTTP:         flags.outsideHTTP,
+		Commit:              flags.commit,
+		BranchPrefix:        flags.branchPrefix,
+		LinkToGitHub:        flags.linkToGitHub,
+		SSHConnectionString: flags.sshConnectionString,
+		MCPServers:          flags.mcpServers,
+		ApproveWrites:       flags.approveWrites,
+		Notifier:            notifier,
+		NotifyAfter:         flags.notifyAfter,
+		ResponseCache:       responseCache,
+		StateDB:             stateDB,
+		SessionLog:          sessionLog,
+		PluginTools:         pluginTools,
+		PluginCommands:      pluginCommands,
+		ForgeWrites:         flags.forgeWrites,
+		AutoCommit:          flags.autoCommit,
+	}
+	if loc, ok := forgeLocation(ctx, flags, wd); ok {
+		if f, err := forge.FromEnvironment(loc); err != nil {
+			slog.WarnContext(ctx, "forge_disabled", "repo", loc.URL(), "err", err)
+		} else if f != nil {
+			agentConfig.Forge = f
+		}
+	}
+	if flags.webSearch != "off" {
+		if p, err := websearch.FromEnvironment(flags.webSearch, llmService); err != nil {
+			slog.WarnContext(ctx, "web_search_disabled", "provider", flags.webSearch, "err", err)
+		} else if p != nil {
+			agentConfig.WebSearch = p
+			agentConfig.WebSearchBudget = flags.webSearchBudget
+		}
+	}
+	if flags.embedder != "" {
+		kind := flags.embedder
+		if kind == "auto" {
+			kind = ""
+		}
+		if e, model, err := codeindex.FromEnvironment(kind, flags.embedderURL, flags.embedderModel); err != nil {
+			slog.WarnContext(ctx, "code_index_disabled", "embedder", flags.embedder, "err", err)
+		} else if e != nil {
+			agentConfig.Embedder = e
+			agentConfig.EmbeddingModel = model
+		} else {
+			slog.WarnContext(ctx, "code_index_disabled", "embedder", flags.embedder, "err", "no embedding API key is set")
+		}
+	}
+	if flags.supervise {
+		agentConfig.Supervisor = &claudetool.Supervisor{Properties: flags.superviseProperties}
+	}
+	agentConfig.SystemPromptTemplate = systemPrompt
+	agentConfig.Project = flags.project
+	closeConfig := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	return agentConfig, closeConfig, nil
+}
+
+// loadProjectConfig loads the configuration of the project in dir, from -project-config or else the repository's,
+// and then the -profile, and applies their settings to the flags that were not set on the command line.
+func loadProjectConfig(flags *CLIFlags, dir string) error {
+	path := flags.projectConfig
+	if path == "" {
+		path = projectconfig.Find(dir)
+	}
+	if path != "" {
+		project, err := projectconfig.Load(path)
+		if err != nil {
+			return fmt.Errorf("invalid project configuration: %w", err)
+		}
+		if flags.projectConfig, err = filepath.Abs(path); err != nil {
+			return err
+		}
+		flags.project = project
+		if project.Model != "" && !flags.setFlags["model"] {
+			flags.modelName = project.Model
+		}
+		if project.Timeouts.LLMStall > 0 && !flags.setFlags["llm-stall-timeout"] {
+			flags.llmStallTimeout = project.Timeouts.LLMStall
+		}
+		if project.Timeouts.Turn > 0 && !flags.setFlags["max-wall-time"] {
+			flags.maxWallTime = project.Timeouts.Turn
+		}
+	}
+	if flags.profile == "" {
+		return nil
+	}
+	profile, err := flags.project.Profile(flags.profile)
+	if err != nil {
+		return err
+	}
+	flags.project = flags.project.WithProfile(profile)
+	setDefault(flags, "max-dollars", &flags.maxDollars, profile.Budget.MaxDollars)
+	setDefault(flags, "max-tokens", &flags.maxTokens, profile.Budget.MaxTokens)
+	setDefault(flags, "max-tool-calls", &flags.maxToolCalls, profile.Budget.MaxToolCalls)
+	setDefault(flags, "max-wall-time", &flags.maxWallTime, profile.Budget.MaxWallTime)
+	setDefault(flags, "approve-writes", &flags.approveWrites, profile.ApproveWrites)
+	if profile.ForgeWrites != "" {
+		setDefault(flags, "forge-writes", &flags.forgeWrites, &profile.ForgeWrites)
+	}
+	return nil
+}
+
+// withProjectWebhooks returns notifier, if any, along with the webhooks of project, if any.
+func withProjectWebhooks(notifier notify.Notifier, project *projectconfig.Config) notify.Notifier {
+	if project == nil || len(project.Webhooks) == 0 {
+		return notifier
+	}
+	var m notify.Multi
+	if notifier != nil {
+		m = append(m, notifier)
+	}
+	for _, w := range project.Webhooks {
+		kinds := w.Events
+		if len(kinds) == 0 {
+			kinds = notify.SessionKinds
+		}
+		m = append(m, &notify.Webhook{URL: w.URL, Kinds: kinds})
+	}
+	return m
+}
+
+// setDefault sets *flag to *v, if v is set and the named flag was not set on the command line.
+func setDefault[T any](flags *CLIFlags, name string, flag *T, v *T) {
+	if v != nil && !flags.setFlags[name] {
+		*flag = *v
+	}
+}
+
+// readSystemPrompt reads the system prompt template at path and checks that it parses.
+func readSystemPrompt(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("-system-prompt: %w", err)
+	}
+	if _, err := loop.ParseSystemPrompt(string(b)); err != nil {
+		return "", fmt.Errorf("-system-prompt %s: %w", path, err)
+	}
+	return string(b), nil
+}
+
 // setupLogging configures the logging system based on command-line flags.
 // Returns the slog handler and optionally a log file (which should be closed by the caller).
 func setupLogging(termui, verbose, unsafe bool) (slog.Handler, *os.File, error) {
@@ -739,7 +1425,7 @@ func setupLogging(termui, verbose, unsafe bool) (slog.Handler, *os.File, error)
 	if verbose && !termui {
 		// Log to stderr
 		slogHandler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
-		return slogHandler, nil, nil
+		return skribe.HandlerWrap(credentials.RedactHandler(slogHandler)), nil, nil
 	}
 
 	// Log to a file
@@ -753,6 +1439,9 @@ func setupLogging(termui, verbose, unsafe bool) (slog.Handler, *os.File, error)
 
 	slogHandler = slog.NewJSONHandler(logFile, &slog.HandlerOptions{Level: slog.LevelDebug})
 	slogHandler = skribe.AttrsWrap(slogHandler)
+	slogHandler = credentials.RedactHandler(slogHandler)
+	// Sessions also log to their own session logs.
+	slogHandler = skribe.HandlerWrap(slogHandler)
 
 	return slogHandler, logFile, nil
 }
@@ -783,10 +1472,21 @@ func defaultGitEmail() string {
 
 // selectLLMService creates an LLM service based on the specified model name.
 // If modelName is empty or "claude", it uses the Anthropic service.
+// If platform is non-nil, it uses the Anthropic service through that cloud platform.
 // If modelName is "gemini", it uses the Gemini service.
 // Otherwise, it tries to use the OpenAI service with the specified model.
+// A model that is not in the registry is served by the OpenAI-compatible API at modelURL, if set.
 // Returns an error if the model name is not recognized or if required configuration is missing.
-func selectLLMService(client *http.Client, modelName string, modelURL, apiKey string) (llm.Service, error) {
+func selectLLMService(client *http.Client, modelName string, modelURL, apiKey string, platform ant.Platform) (llm.Service, error) {
+	if platform != nil {
+		if modelName != "" && modelName != "claude" {
+			return nil, fmt.Errorf("-llm-platform only supports claude, not %q", modelName)
+		}
+		return &ant.Service{
+			HTTPC:    client,
+			Platform: platform,
+		}, nil
+	}
 	if modelName == "" || modelName == "claude" {
 		if apiKey == "" {
 			return nil, fmt.Errorf("missing ANTHROPIC_API_KEY")
@@ -795,6 +1495,8 @@ func selectLLMService(client *http.Client, modelName string, modelURL, apiKey st
 			HTTPC:  client,
 			URL:    modelURL,
 			APIKey: apiKey,
+			Keys:   llm.ParseKeyPool(apiKey),
+			Stream: true,
 		}, nil
 	}
 
@@ -807,27 +1509,114 @@ func selectLLMService(client *http.Client, modelName string, modelURL, apiKey st
 			URL:    modelURL,
 			Model:  gem.DefaultModel,
 			APIKey: apiKey,
+			Keys:   llm.ParseKeyPool(apiKey),
 		}, nil
 	}
 
 	model := oai.ModelByUserName(modelName)
 	if model == nil {
-		return nil, fmt.Errorf("unknown model '%s', use -list-models to see available models", modelName)
+		if modelURL == "" {
+			return nil, fmt.Errorf("unknown model '%s', use -list-models to see available models, or -llm-url to use an OpenAI-compatible server", modelName)
+		}
+		custom := oai.CustomModel(modelName, modelURL)
+		return &oai.Service{
+			HTTPC:  client,
+			Model:  custom,
+			APIKey: apiKey,
+			Keys:   llm.ParseKeyPool(apiKey),
+			Stream: true,
+		}, nil
+	}
+	if modelURL != "" {
+		model.URL = modelURL
 	}
 
 	// Verify we have an API key, if necessary.
-	apiKey = os.Getenv(model.APIKeyEnv)
-	if model.APIKeyEnv != "" && apiKey == "" {
-		return nil, fmt.Errorf("missing API key for %s model, set %s environment variable", model.UserName, model.APIKeyEnv)
+	if model.APIKeyEnv != "" {
+		apiKey = cmp.Or(credential(context.Background(), model.APIKeyEnv), apiKey)
+		if apiKey == "" {
+			return nil, fmt.Errorf("missing API key for %s model, set %s environment variable or store it with a -credentials provider", model.UserName, model.APIKeyEnv)
+		}
 	}
 
 	return &oai.Service{
 		HTTPC:  client,
 		Model:  *model,
 		APIKey: apiKey,
+		Keys:   llm.ParseKeyPool(apiKey),
+		Stream: true,
 	}, nil
 }
 
+// routeLLMService wraps primary, the service for modelName, in an llm.Router
+// that falls back to the fallback models and sends the tasks in routes to their own models.
+// Each route has the form task=model[,model...].
+// Alternate models look up their API keys with -credentials.
+// If there are no fallbacks or routes, it returns primary unchanged.
+func routeLLMService(client *http.Client, modelName string, primary llm.Service, fallback, routes []string) (llm.Service, error) {
+	if len(fallback) == 0 && len(routes) == 0 {
+		return primary, nil
+	}
+	services := map[string]llm.Service{cmp.Or(modelName, "claude"): primary}
+	service := func(name string) (llm.Service, error) {
+		if s, ok := services[name]; ok {
+			return s, nil
+		}
+		var apiKey string
+		switch name {
+		case "claude":
+			apiKey = credential(context.Background(), "ANTHROPIC_API_KEY")
+		case "gemini":
+			apiKey = credential(context.Background(), gem.GeminiAPIKeyEnv)
+		}
+		s, err := selectLLMService(client, name, "", apiKey, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize LLM service for %s: %w", name, err)
+		}
+		services[name] = s
+		return s, nil
+	}
+
+	router := &llm.Router{Services: []llm.Service{primary}}
+	for _, name := range fallback {
+		s, err := service(name)
+		if err != nil {
+			return nil, err
+		}
+		router.Services = append(router.Services, s)
+	}
+	for _, route := range routes {
+		task, names, ok := strings.Cut(route, "=")
+		if !ok || task == "" || names == "" {
+			return nil, fmt.Errorf("invalid -llm-route %q, want task=model[,model...]", route)
+		}
+		if router.Routes == nil {
+			router.Routes = map[string][]llm.Service{}
+		}
+		for name := range strings.SplitSeq(names, ",") {
+			s, err := service(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			router.Routes[task] = append(router.Routes[task], s)
+		}
+	}
+	return router, nil
+}
+
+// claudePlatform returns the ant.Platform for the -llm-platform flag, or nil to use Anthropic's API.
+func claudePlatform(name, region string) (ant.Platform, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "bedrock":
+		return &ant.Bedrock{Region: region}, nil
+	case "vertex":
+		return &ant.Vertex{Region: region}, nil
+	}
+	return nil, fmt.Errorf("unknown -llm-platform %q: want bedrock or vertex", name)
+}
+
 // dumpDistFilesystem dumps the embedded /dist/ filesystem to the specified directory
 func dumpDistFilesystem(outputDir string) error {
 	// Build the embedded filesystem
@@ -4,6 +4,8 @@ import (
 	"context"
 	"os"
 	"testing"
+
+	"sketch.dev/llm"
 )
 
 func TestExpandTilde(t *testing.T) {
@@ -110,3 +112,36 @@ func TestSetupAndRunAgent_DoesNotSetEmptyPubKey(t *testing.T) {
 		t.Error("Expected setupAndRunAgent to fail due to missing API key")
 	}
 }
+
+func TestRouteLLMService(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "test-key")
+	primary, err := selectLLMService(nil, "claude", "", "test-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := routeLLMService(nil, "claude", primary, nil, nil)
+	if err != nil || s != primary {
+		t.Errorf("routeLLMService with no fallbacks or routes = %v, %v; want the primary", s, err)
+	}
+
+	t.Setenv("GEMINI_API_KEY", "test-key")
+	s, err = routeLLMService(nil, "claude", primary, []string{"gemini"}, []string{"install-tools=gemini,claude"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := s.(*llm.Router)
+	if !ok {
+		t.Fatalf("routeLLMService = %T, want *llm.Router", s)
+	}
+	if len(r.Services) != 2 || r.Services[0] != primary {
+		t.Errorf("Services = %v, want the primary then gemini", r.Services)
+	}
+	if route := r.Routes["install-tools"]; len(route) != 2 || route[0] != r.Services[1] || route[1] != primary {
+		t.Errorf("install-tools route = %v, want gemini then the primary", route)
+	}
+
+	if _, err := routeLLMService(nil, "claude", primary, nil, []string{"install-tools"}); err == nil {
+		t.Errorf("routeLLMService accepted a route without models")
+	}
+}
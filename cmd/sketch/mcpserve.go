@@ -0,0 +1,50 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+	"sketch.dev/llm/gem"
+	"sketch.dev/mcp"
+)
+
+// runMCPServer serves sketch's bash, patch, and (given an LLM API key) keyword search tools
+// over MCP on stdin and stdout, running them in the current directory.
+// Like -unsafe, it runs commands directly on the host.
+func runMCPServer(ctx context.Context, flags CLIFlags) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	ctx = claudetool.WithWorkingDir(ctx, wd)
+
+	// Keyword search and bash's installation of missing commands need an LLM; they are optional here.
+	var convo *conversation.Convo
+	envName := "ANTHROPIC_API_KEY"
+	if flags.modelName == "gemini" {
+		envName = gem.GeminiAPIKeyEnv
+	}
+	apiKey := cmp.Or(credential(ctx, envName), flags.llmAPIKey)
+	if service, err := selectLLMService(nil, flags.modelName, flags.llmURL, apiKey, nil); err != nil {
+		slog.InfoContext(ctx, "mcp_serve_without_llm", "err", err)
+	} else {
+		convo = conversation.New(ctx, service, nil)
+	}
+
+	bashTool := &claudetool.BashTool{EnableJITInstall: convo != nil}
+	patchTool := &claudetool.PatchTool{}
+	tools := []*llm.Tool{bashTool.Tool(), bashTool.GroupTool(), patchTool.Tool()}
+	if convo != nil {
+		tools = append(tools, claudetool.Keyword)
+	}
+
+	s := mcp.NewServer("sketch", version, convo, tools)
+	fmt.Fprintf(os.Stderr, "sketch: serving %d tools over MCP on stdin/stdout in %s\n", len(tools), wd)
+	return mcp.ServeStdio(ctx, s)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"sketch.dev/projectconfig"
+	"sketch.dev/schedule"
+	"sketch.dev/schedule/cron"
+)
+
+// runSchedule runs `sketch schedule`, which runs the project's jobs as headless sessions
+// on their cron schedules and after git merges, delivering their results by webhook or email.
+func runSchedule(args []string) error {
+	fs := flag.NewFlagSet("sketch schedule", flag.ExitOnError)
+	list := fs.Bool("list", false, "list the jobs and when they run next, and exit")
+	runJob := fs.String("run", "", "run the named job now, print its result as JSON, and exit")
+	event := fs.String("event", "", "run the jobs for a git event, such as post-merge, and exit; for git hooks")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s schedule [flags] [-- session flags]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "\nschedule runs the jobs in %s as headless sessions (sketch -prompt -output=json)\n", projectconfig.Path)
+		fmt.Fprintf(fs.Output(), "on their cron schedules and after merges into the checked-out branch, until interrupted,\n")
+		fmt.Fprintf(fs.Output(), "and delivers their results to their webhooks and email addresses.\n")
+		fmt.Fprintf(fs.Output(), "Email goes through the SMTP server set by $SKETCH_SMTP_ADDR and $SKETCH_SMTP_FROM,\n")
+		fmt.Fprintf(fs.Output(), "with $SKETCH_SMTP_USERNAME and $SKETCH_SMTP_PASSWORD if it needs them.\n")
+		fmt.Fprintf(fs.Output(), "Flags after -- are passed to every session, as in: %s schedule -- -unsafe\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	path := projectconfig.Find(".")
+	if path == "" {
+		return fmt.Errorf("no %s in this repository; jobs are configured there", projectconfig.Path)
+	}
+	config, err := projectconfig.Load(path)
+	if err != nil {
+		return err
+	}
+	if len(config.Jobs) == 0 {
+		return fmt.Errorf("%s has no jobs", path)
+	}
+	root := filepath.Dir(filepath.Dir(path))
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	s := &schedule.Scheduler{
+		Jobs:     config.Jobs,
+		RepoRoot: root,
+		Run:      (&schedule.Session{Executable: exe, Dir: root, Args: fs.Args()}).Run,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch {
+	case *list:
+		for _, name := range slices.Sorted(maps.Keys(config.Jobs)) {
+			fmt.Println(describeJob(name, config.Jobs[name]))
+		}
+		return nil
+	case *runJob != "":
+		r, err := s.RunJob(ctx, *runJob)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		if r.ExitCode != 0 {
+			return exitCode(r.ExitCode)
+		}
+		return nil
+	case *event != "":
+		if !slices.Contains(projectconfig.GitEvents, *event) {
+			return fmt.Errorf("unknown -event %q; want one of %s", *event, strings.Join(projectconfig.GitEvents, ", "))
+		}
+		s.Event(ctx, *event)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "running %d job(s) from %s; interrupt to stop\n", len(config.Jobs), path)
+	if err := s.Serve(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// describeJob returns a line describing the job named name, with when it runs.
+func describeJob(name string, job projectconfig.Job) string {
+	var when []string
+	if job.Cron != "" {
+		w := "cron " + job.Cron
+		if sched, err := cron.Parse(job.Cron); err == nil {
+			if next := sched.Next(time.Now()); !next.IsZero() {
+				w += ", next " + next.Format("2006-01-02 15:04")
+			}
+		}
+		when = append(when, w)
+	}
+	for _, event := range job.On {
+		when = append(when, "on "+event)
+	}
+	profile := job.Profile
+	if profile == "" {
+		profile = projectconfig.DefaultJobProfile
+	}
+	return fmt.Sprintf("%s\t%s\tprofile %s", name, strings.Join(when, "; "), profile)
+}
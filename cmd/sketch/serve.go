@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"sketch.dev/apiserver"
+	"sketch.dev/janitor"
+	"sketch.dev/loop"
+	"sketch.dev/loop/server"
+	"sketch.dev/skribe"
+)
+
+// apiTokenEnv names the environment variable that holds the bearer token `sketch serve` requires.
+const apiTokenEnv = "SKETCH_API_TOKEN"
+
+// serveAuth returns the Authenticator for `sketch serve`: the users in -users, or the token in $SKETCH_API_TOKEN,
+// or nil if there is neither.
+func serveAuth(flags CLIFlags) (apiserver.Authenticator, error) {
+	token := os.Getenv(apiTokenEnv)
+	switch {
+	case flags.usersFile != "" && token != "":
+		return nil, fmt.Errorf("-users names the users and their tokens; unset %s", apiTokenEnv)
+	case flags.usersFile != "":
+		return apiserver.LoadUsers(flags.usersFile)
+	case token != "":
+		return &apiserver.TokenAuth{Token: token}, nil
+	}
+	return nil, nil
+}
+
+// sessionHandler is the HTTP API of a session that `sketch serve` runs, which reports what the session has spent.
+type sessionHandler struct {
+	http.Handler
+	agent *loop.Agent
+}
+
+func (h sessionHandler) CostUSD() float64 {
+	return h.agent.TotalUsage().TotalCostUSD
+}
+
+// runServe runs `sketch serve`: an HTTP API that creates and drives sessions.
+// Like -unsafe, sessions run directly on the host, each in the working directory it is created with.
+func runServe(ctx context.Context, flags CLIFlags, logFile *os.File) error {
+	if !flags.unsafe {
+		return fmt.Errorf("sketch serve runs sessions directly on this machine; pass -unsafe to confirm")
+	}
+	modelURL, apiKey, err := directCredentials(ctx, flags)
+	if err != nil {
+		return err
+	}
+	auth, err := serveAuth(flags)
+	if err != nil {
+		return err
+	}
+	var audit *os.File
+	if flags.auditLog != "" {
+		if audit, err = os.OpenFile(flags.auditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600); err != nil {
+			return fmt.Errorf("cannot open the audit log: %w", err)
+		}
+		defer audit.Close()
+	}
+
+	ln, err := net.Listen("tcp", flags.addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %w", flags.addr, err)
+	}
+	defer ln.Close()
+	if addr, ok := ln.Addr().(*net.TCPAddr); auth == nil && (!ok || !addr.IP.IsLoopback()) {
+		return fmt.Errorf("sketch serve listens beyond this machine on %s; set %s or -users to require bearer tokens", ln.Addr(), apiTokenEnv)
+	}
+
+	go (&janitor.Janitor{}).Run(ctx, time.Hour)
+
+	start := func(ctx context.Context, id string, req apiserver.CreateRequest) (http.Handler, error) {
+		flags := flags
+		flags.sessionID = id
+		flags.oneShot = false
+		flags.profile = cmp.Or(req.Profile, flags.profile)
+		if err := loadProjectConfig(&flags, req.WorkingDir); err != nil {
+			return nil, err
+		}
+		// The session may spend no more than the part of the user's budget set aside for it until the user's next message.
+		if req.MaxDollars > 0 && (flags.maxDollars <= 0 || flags.maxDollars > req.MaxDollars) {
+			flags.maxDollars = req.MaxDollars
+		}
+		ctx = skribe.ContextWithAttr(ctx, slog.String("session_id", id), slog.String("user", req.Owner))
+		agentConfig, closeConfig, err := newAgentConfig(ctx, flags, modelURL, apiKey, req.WorkingDir)
+		if err != nil {
+			return nil, err
+		}
+		ctx = agentConfig.Context // it carries the session log
+		agent := loop.NewAgent(agentConfig)
+		srv, err := server.New(agent, logFile)
+		if err != nil {
+			closeConfig()
+			return nil, err
+		}
+		if err := agent.Init(loop.AgentInit{}); err != nil {
+			closeConfig()
+			return nil, fmt.Errorf("failed to initialize agent: %w", err)
+		}
+		go func() {
+			defer closeConfig()
+			agent.Loop(ctx)
+		}()
+		if req.Prompt != "" {
+			agent.UserMessage(ctx, req.Prompt)
+		}
+		return sessionHandler{srv, agent}, nil
+	}
+
+	api := apiserver.New(ctx, start, auth)
+	defer api.Close()
+	if audit != nil {
+		api.AuditTo(audit)
+	}
+	fmt.Fprintf(os.Stderr, "sketch: serving the session API at http://%s/sessions\n", ln.Addr())
+	httpServer := &http.Server{Handler: api}
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+	if err := httpServer.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
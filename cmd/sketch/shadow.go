@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+	"sketch.dev/shadow"
+)
+
+// enterShadowWorkspace copies the current repository (or, outside a git repository,
+// the current directory) into a shadow workspace and changes into the
+// corresponding directory there, so the agent never touches the user's checkout.
+func enterShadowWorkspace(ctx context.Context) (*shadow.Workspace, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	root := cwd
+	if out, err := exec.CommandContext(ctx, "git", "rev-parse", "--show-toplevel").Output(); err == nil {
+		root = strings.TrimSpace(string(out))
+	}
+	fmt.Printf("📋 copying %s to a shadow workspace...\n", root)
+	ws, err := shadow.Create(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(ws.ShadowPath(cwd)); err != nil {
+		ws.Remove()
+		return nil, err
+	}
+	fmt.Printf("📋 working in %s; your checkout is untouched until you apply the changes\n", ws.Dir)
+	return ws, nil
+}
+
+// finishShadowWorkspace reports the changes made in ws and, after confirmation,
+// applies them to the user's checkout.
+func finishShadowWorkspace(ctx context.Context, ws *shadow.Workspace, flags CLIFlags) {
+	changes, err := ws.Changes()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ could not list shadow workspace changes: %v\nThe workspace is preserved at %s\n", err, ws.Dir)
+		return
+	}
+	if err := ws.FetchBranches(ctx, flags.branchPrefix); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ could not fetch %s* branches from the shadow workspace: %v\n", flags.branchPrefix, err)
+	}
+	if len(changes) == 0 {
+		fmt.Println("📋 no file changes in the shadow workspace")
+		ws.Remove()
+		return
+	}
+
+	fmt.Printf("\n📋 %d file(s) changed in the shadow workspace:\n", len(changes))
+	for _, c := range changes {
+		fmt.Printf("  %s %s\n", c.Status, c.Path)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Printf("Not applied. The workspace is preserved at %s\n", ws.Dir)
+		return
+	}
+	fmt.Printf("Apply these changes to %s? [y/N] ", ws.Origin)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		fmt.Printf("Not applied. The workspace is preserved at %s\n", ws.Dir)
+		return
+	}
+
+	res, err := ws.Apply()
+	if res != nil {
+		fmt.Printf("✅ applied %d change(s)\n", len(res.Applied))
+		if len(res.Conflicts) > 0 {
+			fmt.Printf("⚠️ %d file(s) were modified in your checkout during the session and were not applied:\n", len(res.Conflicts))
+			for _, c := range res.Conflicts {
+				fmt.Printf("  %s %s\n", c.Status, c.Path)
+			}
+		}
+	}
+	if err != nil || (res != nil && len(res.Conflicts) > 0) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		}
+		fmt.Printf("The workspace is preserved at %s\n", ws.Dir)
+		return
+	}
+	ws.Remove()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+	"sketch.dev/worktree"
+)
+
+// worktreeBranch returns the name of the branch of the worktree of the session with the given flags.
+func worktreeBranch(flags CLIFlags) string {
+	return flags.branchPrefix + "worktree-" + flags.sessionID
+}
+
+// enterWorktree adds a worktree of the current repository on a branch of the session's own,
+// and changes into the corresponding directory there, so the agent never touches the user's checkout.
+func enterWorktree(ctx context.Context, flags CLIFlags) (*worktree.Worktree, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	w, err := worktree.Create(ctx, cwd, worktreeBranch(flags))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(w.Path(cwd)); err != nil {
+		w.Remove(ctx)
+		return nil, err
+	}
+	fmt.Printf("🌿 working in %s on branch %s; your checkout is untouched until you merge it\n", w.Dir, w.Branch)
+	return w, nil
+}
+
+// finishWorktree reports the commits made in w and, after confirmation, merges them into the user's checkout
+// and removes w. Otherwise it leaves w, and says how to merge or remove it later.
+func finishWorktree(ctx context.Context, w *worktree.Worktree) {
+	commits, err := w.Commits(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ could not list the worktree's commits: %v\nThe worktree is preserved at %s\n", err, w.Dir)
+		return
+	}
+	dirty, err := w.Dirty(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ could not check the worktree for uncommitted changes: %v\n", err)
+	}
+	if len(commits) == 0 && !dirty && err == nil {
+		fmt.Println("🌿 no commits in the worktree")
+		if err := w.Remove(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ could not remove the worktree: %v\n", err)
+		}
+		return
+	}
+
+	keep := func() {
+		fmt.Printf("The worktree is preserved at %s.\n", w.Dir)
+		fmt.Printf("Merge it later with `sketch worktree merge %s`, or discard it with `sketch worktree remove %s`.\n", w.Branch, w.Branch)
+	}
+	fmt.Printf("\n🌿 %d commit(s) on %s:\n", len(commits), w.Branch)
+	for _, c := range commits {
+		fmt.Printf("  %s\n", c)
+	}
+	if dirty {
+		fmt.Println("⚠️ the worktree also has uncommitted changes, which a merge leaves behind")
+		keep()
+		return
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Println("Not merged.")
+		keep()
+		return
+	}
+	fmt.Printf("Merge %s into %s? [y/N] ", w.Branch, w.Repo)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		fmt.Println("Not merged.")
+		keep()
+		return
+	}
+	if err := w.Merge(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		keep()
+		return
+	}
+	fmt.Printf("✅ merged %d commit(s)\n", len(commits))
+	if err := w.Remove(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ could not remove the worktree: %v\n", err)
+	}
+}
+
+// runWorktree runs `sketch worktree`, which lists, merges, and removes the worktrees of -worktree sessions.
+func runWorktree(args []string) error {
+	fs := flag.NewFlagSet("sketch worktree", flag.ExitOnError)
+	prefix := fs.String("branch-prefix", "sketch/", "prefix of the branches of the sessions' worktrees, as for sketch -branch-prefix")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s worktree [flags] list | merge <branch> | remove <branch>\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "\nlist shows the worktrees of -worktree sessions in the current repository and their commits.\n")
+		fmt.Fprintf(fs.Output(), "merge merges a worktree's branch into the current branch and removes the worktree.\n")
+		fmt.Fprintf(fs.Output(), "remove removes a worktree and its branch, discarding commits that were not merged.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	ctx := context.Background()
+
+	switch {
+	case fs.Arg(0) == "list" && fs.NArg() == 1:
+		wts, err := worktree.List(ctx, ".", *prefix+"worktree-")
+		if err != nil {
+			return err
+		}
+		if len(wts) == 0 {
+			fmt.Println("no session worktrees")
+		}
+		for _, w := range wts {
+			commits, err := w.Commits(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%s\t%d commit(s) to merge\n", w.Branch, w.Dir, len(commits))
+		}
+		return nil
+	case fs.Arg(0) == "merge" && fs.NArg() == 2:
+		w, err := worktree.Find(ctx, ".", fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		if dirty, err := w.Dirty(ctx); err != nil {
+			return err
+		} else if dirty {
+			return fmt.Errorf("the worktree at %s has uncommitted changes; commit or discard them first", w.Dir)
+		}
+		if err := w.Merge(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("merged %s\n", w.Branch)
+		return w.Remove(ctx)
+	case fs.Arg(0) == "remove" && fs.NArg() == 2:
+		w, err := worktree.Find(ctx, ".", fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		return w.Remove(ctx)
+	default:
+		fs.Usage()
+		os.Exit(2)
+		return nil
+	}
+}
@@ -0,0 +1,94 @@
+// Package credentials looks up secrets, such as LLM provider API keys,
+// in the places users keep them: the environment, the OS keychain, 1Password, and Vault.
+//
+// Every secret it finds is remembered, so that Redact can remove it from tool output and logs.
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ErrNotFound is returned by a Provider that does not have the secret asked for.
+var ErrNotFound = errors.New("credential not found")
+
+// A Provider looks up secrets by name, such as ANTHROPIC_API_KEY.
+type Provider interface {
+	// Name describes the provider in errors and logs.
+	Name() string
+	// Lookup returns the secret called name, or an error wrapping ErrNotFound if it has none.
+	Lookup(ctx context.Context, name string) (string, error)
+}
+
+// A Store looks up secrets in each of its providers in turn, and remembers the ones it finds for Redact.
+type Store struct {
+	Providers []Provider
+}
+
+// Lookup returns the secret called name from the first of s's providers that has it.
+// Providers that fail are skipped; their errors are returned only if no provider has the secret.
+// Lookup returns an error wrapping ErrNotFound if none does.
+func (s *Store) Lookup(ctx context.Context, name string) (string, error) {
+	var errs []error
+	for _, p := range s.Providers {
+		value, err := p.Lookup(ctx, name)
+		if err == nil && value != "" {
+			slog.DebugContext(ctx, "credential_found", "name", name, "provider", p.Name())
+			AddSecret(value)
+			return value, nil
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			slog.DebugContext(ctx, "credential_lookup_failed", "name", name, "provider", p.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		}
+	}
+	return "", fmt.Errorf("%s: %w in %s", name, errors.Join(append([]error{ErrNotFound}, errs...)...), s.names())
+}
+
+func (s *Store) names() string {
+	var names []string
+	for _, p := range s.Providers {
+		names = append(names, p.Name())
+	}
+	if len(names) == 0 {
+		return "no providers"
+	}
+	return strings.Join(names, ", ")
+}
+
+// Parse builds a Store from specs, as given to the -credentials flag. Each spec is one of:
+//   - "env", the environment
+//   - "keychain", the OS keychain (macOS Keychain or the Linux secret service), under the service "sketch"
+//   - "1password" or "1password:VAULT", items of the 1Password vault VAULT (default "sketch"), read with the op CLI
+//   - "vault:PATH", the fields of the HashiCorp Vault secret at PATH, such as "secret/data/sketch",
+//     from the server at $VAULT_ADDR with the token $VAULT_TOKEN
+//
+// Providers are tried in the order given. If specs is empty, the Store uses the environment.
+func Parse(specs []string) (*Store, error) {
+	s := new(Store)
+	for _, spec := range specs {
+		kind, arg, _ := strings.Cut(spec, ":")
+		switch kind {
+		case "env":
+			s.Providers = append(s.Providers, Env{})
+		case "keychain":
+			s.Providers = append(s.Providers, Keychain{Service: arg})
+		case "1password":
+			s.Providers = append(s.Providers, OnePassword{Vault: arg})
+		case "vault":
+			if arg == "" {
+				return nil, fmt.Errorf("invalid credentials provider %q: want vault:PATH", spec)
+			}
+			s.Providers = append(s.Providers, &Vault{Path: arg})
+		default:
+			return nil, fmt.Errorf("unknown credentials provider %q: want env, keychain, 1password[:VAULT], or vault:PATH", spec)
+		}
+	}
+	if len(s.Providers) == 0 {
+		s.Providers = []Provider{Env{}}
+	}
+	return s, nil
+}
@@ -0,0 +1,143 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeProvider struct {
+	name    string
+	secrets map[string]string
+	err     error
+}
+
+func (f fakeProvider) Name() string { return f.name }
+
+func (f fakeProvider) Lookup(ctx context.Context, name string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	if v, ok := f.secrets[name]; ok {
+		return v, nil
+	}
+	return "", ErrNotFound
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := &Store{Providers: []Provider{
+		fakeProvider{name: "broken", err: errors.New("op: not signed in")},
+		fakeProvider{name: "first", secrets: map[string]string{"A_KEY": "sk-first-aaaaaaaa"}},
+		fakeProvider{name: "second", secrets: map[string]string{"A_KEY": "sk-second-aaaaaaa", "B_KEY": "sk-second-bbbbbbb"}},
+	}}
+	for name, want := range map[string]string{"A_KEY": "sk-first-aaaaaaaa", "B_KEY": "sk-second-bbbbbbb"} {
+		if got, err := s.Lookup(ctx, name); got != want || err != nil {
+			t.Errorf("Lookup(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	_, err := s.Lookup(ctx, "C_KEY")
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "not signed in") {
+		t.Errorf("Lookup of a missing secret: err = %v, want ErrNotFound with the broken provider's error", err)
+	}
+
+	// Secrets that were found are redacted.
+	if got := Redact("key sk-second-bbbbbbb!"); got != "key [REDACTED]!" {
+		t.Errorf("Redact = %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]string{"vault:secret/data/sketch", "1password:Work", "keychain", "env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.names(), "vault:secret/data/sketch, 1password, keychain, env"; got != want {
+		t.Errorf("providers = %q, want %q", got, want)
+	}
+	if s.Providers[1].(OnePassword).Vault != "Work" {
+		t.Errorf("1password vault = %q, want Work", s.Providers[1].(OnePassword).Vault)
+	}
+	if s, _ := Parse(nil); s.names() != "env" {
+		t.Errorf("default providers = %q, want env", s.names())
+	}
+	for _, spec := range []string{"vault", "lastpass"} {
+		if _, err := Parse([]string{spec}); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestVault(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case r.Header.Get("X-Vault-Token") != "tok":
+			http.Error(w, "permission denied", http.StatusForbidden)
+		case r.URL.Path == "/v1/secret/data/sketch": // KV version 2
+			w.Write([]byte(`{"data": {"data": {"ANTHROPIC_API_KEY": "sk-ant-vault-key"}, "metadata": {"version": 3}}}`))
+		case r.URL.Path == "/v1/kv/sketch": // KV version 1
+			w.Write([]byte(`{"data": {"GEMINI_API_KEY": "gemini-vault-key"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	v2 := &Vault{Path: "secret/data/sketch", Addr: srv.URL, Token: "tok"}
+	if got, err := v2.Lookup(ctx, "ANTHROPIC_API_KEY"); got != "sk-ant-vault-key" || err != nil {
+		t.Errorf("KV v2 Lookup = %q, %v", got, err)
+	}
+	if _, err := v2.Lookup(ctx, "OPENAI_API_KEY"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup of a missing field: err = %v, want ErrNotFound", err)
+	}
+	if requests != 1 {
+		t.Errorf("read the secret %d times, want once", requests)
+	}
+	v1 := &Vault{Path: "kv/sketch", Addr: srv.URL, Token: "tok"}
+	if got, err := v1.Lookup(ctx, "GEMINI_API_KEY"); got != "gemini-vault-key" || err != nil {
+		t.Errorf("KV v1 Lookup = %q, %v", got, err)
+	}
+	missing := &Vault{Path: "secret/data/other", Addr: srv.URL, Token: "tok"}
+	if _, err := missing.Lookup(ctx, "ANTHROPIC_API_KEY"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup in a missing secret: err = %v, want ErrNotFound", err)
+	}
+	denied := &Vault{Path: "secret/data/sketch", Addr: srv.URL, Token: "wrong"}
+	if _, err := denied.Lookup(ctx, "ANTHROPIC_API_KEY"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup with a bad token: err = %v, want a failure", err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	AddSecret("short")
+	AddSecret("sk-ant-one-1234567,sk-ant-two-1234567")
+	AddSecret("sk-ant-REDACTED")
+	tests := []struct{ in, want string }{
+		{"a short word", "a short word"},
+		{"keys: sk-ant-one-1234567,sk-ant-two-1234567", "keys: [REDACTED]"},
+		{"x-api-key: sk-ant-two-1234567\n", "x-api-key: [REDACTED]\n"},
+		{"sk-ant-REDACTED", "[REDACTED]"},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(RedactHandler(slog.NewTextHandler(&buf, nil))).With("key", "sk-ant-one-1234567")
+	logger.Info("using sk-ant-two-1234567",
+		"args", []string{"-llm-api-key=sk-ant-two-1234567"},
+		"err", errors.New("bad key sk-ant-two-1234567"),
+		slog.Group("req", "header", "sk-ant-two-1234567"))
+	if strings.Contains(buf.String(), "sk-ant") {
+		t.Errorf("log contains a secret: %s", buf.String())
+	}
+}
@@ -0,0 +1,154 @@
+package credentials
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Env is a Provider of environment variables.
+type Env struct{}
+
+func (Env) Name() string { return "env" }
+
+func (Env) Lookup(ctx context.Context, name string) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+	return "", ErrNotFound
+}
+
+// Keychain is a Provider of the OS keychain's passwords:
+// generic passwords in the macOS Keychain, or secret service items on Linux, found with secret-tool.
+// A secret's name is its account, or on Linux, its "name" attribute.
+type Keychain struct {
+	Service string // if empty, "sketch"
+}
+
+func (k Keychain) Name() string { return "keychain" }
+
+func (k Keychain) Lookup(ctx context.Context, name string) (string, error) {
+	service := cmp.Or(k.Service, "sketch")
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.CommandContext(ctx, "security", "find-generic-password", "-s", service, "-a", name, "-w")
+	case "linux":
+		cmd = exec.CommandContext(ctx, "secret-tool", "lookup", "service", service, "name", name)
+	default:
+		return "", fmt.Errorf("the keychain is not supported on %s", runtime.GOOS)
+	}
+	return runSecretCommand(cmd)
+}
+
+// OnePassword is a Provider of 1Password items, read with the op CLI, which must be signed in.
+// A secret's name is the title of the item, whose "credential" field holds it.
+type OnePassword struct {
+	Vault string // if empty, "sketch"
+}
+
+func (o OnePassword) Name() string { return "1password" }
+
+func (o OnePassword) Lookup(ctx context.Context, name string) (string, error) {
+	ref := fmt.Sprintf("op://%s/%s/credential", cmp.Or(o.Vault, "sketch"), name)
+	return runSecretCommand(exec.CommandContext(ctx, "op", "read", "--no-newline", ref))
+}
+
+// runSecretCommand runs cmd, which prints a secret, and returns the secret.
+// A command that fails without a secret is taken to have none,
+// except when it could not run at all.
+func runSecretCommand(cmd *exec.Cmd) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var ee *exec.ExitError
+	switch {
+	case errors.As(err, &ee):
+		return "", fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSpace(stderr.String()))
+	case err != nil:
+		return "", err
+	}
+	value := strings.TrimRight(string(out), "\r\n")
+	if value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// Vault is a Provider of the fields of a HashiCorp Vault secret,
+// read from a KV secrets engine of either version.
+// A secret's name is its field. The secret is read once, on first use.
+type Vault struct {
+	Path   string       // the API path of the secret, without "v1/", such as "secret/data/sketch"
+	Addr   string       // if empty, $VAULT_ADDR is used
+	Token  string       // if empty, $VAULT_TOKEN is used
+	Client *http.Client // if nil, http.DefaultClient is used
+
+	once   sync.Once
+	fields map[string]any
+	err    error
+}
+
+func (v *Vault) Name() string { return "vault:" + v.Path }
+
+func (v *Vault) Lookup(ctx context.Context, name string) (string, error) {
+	v.once.Do(func() { v.fields, v.err = v.read(ctx) })
+	if v.err != nil {
+		return "", v.err
+	}
+	value, ok := v.fields[name].(string)
+	if !ok || value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// read returns the fields of v's secret.
+func (v *Vault) read(ctx context.Context) (map[string]any, error) {
+	addr := cmp.Or(v.Addr, os.Getenv("VAULT_ADDR"))
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(v.Path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", cmp.Or(v.Token, os.Getenv("VAULT_TOKEN")))
+	client := cmp.Or(v.Client, http.DefaultClient)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("failed to read secret: %s", resp.Status)
+	}
+	// KV version 2 nests the fields in another "data" object, with the version's "metadata".
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("failed to decode secret: %w", err)
+	}
+	if data, ok := secret.Data["data"].(map[string]any); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return data, nil
+		}
+	}
+	return secret.Data, nil
+}
@@ -0,0 +1,110 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// minSecretLen is the length of the shortest secret that is redacted.
+// Shorter values are too likely to occur by chance, and redacting them would garble output.
+const minSecretLen = 8
+
+// redacted replaces secrets in redacted text.
+const redacted = "[REDACTED]"
+
+var (
+	secretsMu sync.RWMutex
+	replacer  *strings.Replacer
+	secrets   []string
+)
+
+// AddSecret registers value as a secret, to be removed from text by Redact.
+// A comma-separated list of keys, as given to -llm-api-key, is also registered key by key.
+func AddSecret(value string) {
+	values := []string{value}
+	if strings.Contains(value, ",") {
+		values = append(values, strings.Split(value, ",")...)
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) < minSecretLen || slices.Contains(secrets, v) {
+			continue
+		}
+		secrets = append(secrets, v)
+	}
+	// Replace longer secrets first, so that a secret containing another is redacted whole.
+	slices.SortFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+	var oldnew []string
+	for _, s := range secrets {
+		oldnew = append(oldnew, s, redacted)
+	}
+	replacer = strings.NewReplacer(oldnew...)
+}
+
+// Redact returns s with every registered secret replaced by "[REDACTED]".
+func Redact(s string) string {
+	secretsMu.RLock()
+	r := replacer
+	secretsMu.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// RedactHandler returns a slog.Handler that redacts registered secrets from
+// the messages and attribute values of records before passing them to h.
+func RedactHandler(h slog.Handler) slog.Handler {
+	return &redactHandler{Handler: h}
+}
+
+type redactHandler struct {
+	slog.Handler
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	attrs = slices.Clone(attrs)
+	for i, a := range attrs {
+		attrs[i] = redactAttr(a)
+	}
+	return &redactHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Redact(v.String()))
+	case slog.KindGroup:
+		attrs := slices.Clone(v.Group())
+		for i, ga := range attrs {
+			attrs[i] = redactAttr(ga)
+		}
+		a.Value = slog.GroupValue(attrs...)
+	case slog.KindAny:
+		// Values such as errors and string slices are logged as text; redact that text if it holds a secret.
+		if s := fmt.Sprint(v.Any()); Redact(s) != s {
+			a.Value = slog.StringValue(Redact(s))
+		}
+	}
+	return a
+}
@@ -0,0 +1,318 @@
+package dockerimg
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DevContainerAuto, as ContainerConfig.DevContainer, finds the repository's devcontainer.json, if it has one.
+const DevContainerAuto = "auto"
+
+// DevContainerNone, as ContainerConfig.DevContainer, uses BaseImage even if the repository has a devcontainer.json.
+const DevContainerNone = "none"
+
+// devContainerPaths are where findDevContainer looks for a devcontainer.json, relative to the repository root.
+var devContainerPaths = []string{".devcontainer/devcontainer.json", ".devcontainer.json"}
+
+// A devContainer is the part of a devcontainer.json (https://containers.dev/implementors/json_reference/)
+// that sketch builds a session's image from. The session's image adds the repository at /app to it,
+// whatever the workspaceFolder, and runs sketch as root.
+type devContainer struct {
+	// Image is an image to pull; otherwise, the image is built from Build.
+	Image string `json:"image"`
+	Build struct {
+		Dockerfile string            `json:"dockerfile"` // relative to the devcontainer.json
+		Context    string            `json:"context"`    // relative to the devcontainer.json; "." by default
+		Args       map[string]string `json:"args"`
+		Target     string            `json:"target"`
+	} `json:"build"`
+	// DockerFile is the older spelling of Build.Dockerfile.
+	DockerFile string `json:"dockerFile"`
+	// ContainerEnv is set in the session's container.
+	ContainerEnv map[string]string `json:"containerEnv"`
+	// PostCreateCommand is run when the session's image is built, in /app, after the repository is copied in.
+	PostCreateCommand devCommand `json:"postCreateCommand"`
+
+	path    string // of the devcontainer.json, or of the Dockerfile for a repository without one
+	gitRoot string
+}
+
+// A devCommand is a devcontainer.json lifecycle command: a shell command, a command and its arguments,
+// or an object of named commands, which sketch runs one after another, in the order of their names.
+type devCommand [][]string
+
+func (c *devCommand) UnmarshalJSON(data []byte) error {
+	var shell string
+	if err := json.Unmarshal(data, &shell); err == nil {
+		if shell != "" {
+			*c = devCommand{{"/bin/sh", "-c", shell}}
+		}
+		return nil
+	}
+	var args []string
+	if err := json.Unmarshal(data, &args); err == nil {
+		if len(args) > 0 {
+			*c = devCommand{args}
+		}
+		return nil
+	}
+	var named map[string]devCommand
+	if err := json.Unmarshal(data, &named); err != nil {
+		return fmt.Errorf("want a string, an array of strings, or an object of them")
+	}
+	for _, name := range slices.Sorted(maps.Keys(named)) {
+		*c = append(*c, named[name]...)
+	}
+	return nil
+}
+
+// findDevContainer returns what the repository at gitRoot builds its sessions' images from, as spec says:
+// DevContainerAuto for its devcontainer.json, if it has one, DevContainerNone for none,
+// or the path of a devcontainer.json or a Dockerfile, relative to gitRoot.
+// It returns nil if there is nothing to build from.
+func findDevContainer(gitRoot, spec string) (*devContainer, error) {
+	switch spec {
+	case "", DevContainerNone:
+		return nil, nil
+	case DevContainerAuto:
+		for _, p := range devContainerPaths {
+			if _, err := os.Stat(filepath.Join(gitRoot, p)); err == nil {
+				return loadDevContainer(gitRoot, p)
+			}
+		}
+		return nil, nil
+	}
+	if strings.HasSuffix(spec, ".json") {
+		return loadDevContainer(gitRoot, spec)
+	}
+	path := filepath.Join(gitRoot, spec)
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	dc := &devContainer{path: path, gitRoot: gitRoot}
+	dc.Build.Dockerfile = filepath.Base(path)
+	return dc, nil
+}
+
+// loadDevContainer reads the devcontainer.json at rel, relative to gitRoot.
+func loadDevContainer(gitRoot, rel string) (*devContainer, error) {
+	path := filepath.Join(gitRoot, rel)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dc := &devContainer{path: path, gitRoot: gitRoot}
+	if err := json.Unmarshal(stripJSONC(data), dc); err != nil {
+		return nil, fmt.Errorf("%s: %w", rel, err)
+	}
+	dc.Build.Dockerfile = cmp.Or(dc.Build.Dockerfile, dc.DockerFile)
+	if dc.Image == "" && dc.Build.Dockerfile == "" {
+		return nil, fmt.Errorf("%s has neither an image nor a build.dockerfile; sketch cannot build from Docker Compose configurations", rel)
+	}
+	return dc, nil
+}
+
+// baseImage pulls or builds the image that the session's image adds the repository to, and returns its name.
+// Built images are named for the devcontainer.json or Dockerfile they are built from; docker's build cache
+// makes rebuilding them quick when nothing has changed, unless forceRebuild asks for a build from scratch.
+func (dc *devContainer) baseImage(ctx context.Context, forceRebuild bool) (string, error) {
+	if dc.Image != "" {
+		image := dc.expand(dc.Image)
+		if forceRebuild {
+			if out, err := combinedOutput(ctx, "docker", "pull", image); err != nil {
+				return "", fmt.Errorf("docker pull %s failed: %s: %w", image, out, err)
+			}
+			return image, nil
+		}
+		return image, ensureBaseImageExists(ctx, image)
+	}
+	h := sha256.Sum256([]byte(dc.path))
+	image := "sketch-dev-" + hex.EncodeToString(h[:])[:12]
+	rel, _ := filepath.Rel(dc.gitRoot, dc.path)
+	start := time.Now()
+	fmt.Printf("🏗️  building dev container image %s from %s...\n", image, rel)
+	cmd := exec.CommandContext(ctx, "docker", dc.buildArgs(image, forceRebuild)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := run(ctx, "docker build", cmd); err != nil {
+		return "", fmt.Errorf("building the dev container image from %s failed: %w", rel, err)
+	}
+	fmt.Printf("built dev container image %s in %s\n", image, time.Since(start).Round(time.Millisecond))
+	return image, nil
+}
+
+// buildArgs returns the arguments of the docker command that builds dc's image, named image.
+func (dc *devContainer) buildArgs(image string, forceRebuild bool) []string {
+	dir := filepath.Dir(dc.path)
+	args := []string{"build", "-t", image, "-f", filepath.Join(dir, dc.Build.Dockerfile)}
+	if forceRebuild {
+		args = append(args, "--no-cache", "--pull")
+	}
+	if dc.Build.Target != "" {
+		args = append(args, "--target", dc.Build.Target)
+	}
+	for _, k := range slices.Sorted(maps.Keys(dc.Build.Args)) {
+		args = append(args, "--build-arg", k+"="+dc.expand(dc.Build.Args[k]))
+	}
+	return append(args, filepath.Join(dir, cmp.Or(dc.Build.Context, ".")))
+}
+
+// env returns dc's containerEnv, as docker's -e arguments.
+func (dc *devContainer) env() []string {
+	var env []string
+	for _, k := range slices.Sorted(maps.Keys(dc.ContainerEnv)) {
+		env = append(env, "-e", k+"="+dc.expand(dc.ContainerEnv[k]))
+	}
+	return env
+}
+
+// devVar matches the devcontainer.json variables that expand handles:
+// ${localEnv:NAME}, ${localEnv:NAME:default}, ${localWorkspaceFolder}, and ${containerWorkspaceFolder}.
+var devVar = regexp.MustCompile(`\$\{(localEnv:([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?|localWorkspaceFolder|containerWorkspaceFolder)\}`)
+
+// expand replaces the devcontainer.json variables in s.
+func (dc *devContainer) expand(s string) string {
+	return devVar.ReplaceAllStringFunc(s, func(v string) string {
+		m := devVar.FindStringSubmatch(v)
+		switch m[1] {
+		case "localWorkspaceFolder":
+			return dc.gitRoot
+		case "containerWorkspaceFolder":
+			return "/app"
+		}
+		return cmp.Or(os.Getenv(m[2]), m[3])
+	})
+}
+
+// layeredDockerfile returns the Dockerfile of a session's image, which adds the repository to baseImage,
+// and does what dc, if not nil, says to when the image is created.
+func layeredDockerfile(baseImage string, dc *devContainer) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\n", baseImage)
+	if dc != nil {
+		// Dev container images often switch to a user of their own; sketch runs as root.
+		b.WriteString("USER root\n")
+		b.WriteString(`RUN command -v git >/dev/null || { echo "sketch needs git in the dev container image" >&2; exit 1; }` + "\n")
+	}
+	b.WriteString("COPY . /app\nWORKDIR /app\n")
+	if dc == nil {
+		b.WriteString("RUN if [ -f go.mod ]; then go mod download; fi\n")
+	} else {
+		b.WriteString("RUN if [ -f go.mod ] && command -v go >/dev/null; then go mod download; fi\n")
+		for _, args := range dc.PostCreateCommand {
+			run, _ := json.Marshal(args)
+			fmt.Fprintf(&b, "RUN %s\n", run)
+		}
+	}
+	b.WriteString(`CMD ["/bin/sketch"]` + "\n")
+	return b.String()
+}
+
+// stripJSONC returns the JSON in data, which may have comments and trailing commas, as devcontainer.json files do.
+func stripJSONC(data []byte) []byte {
+	var out bytes.Buffer
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out.WriteByte(data[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out.WriteByte(c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			out.WriteByte('\n')
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return out.Bytes()
+			}
+			i += end + 3
+			out.WriteByte(' ')
+		case c == '}' || c == ']':
+			// Drop a trailing comma before the closing bracket.
+			trimmed := bytes.TrimRight(out.Bytes(), " \t\r\n")
+			if len(trimmed) > 0 && trimmed[len(trimmed)-1] == ',' {
+				rest := slices.Clone(out.Bytes()[len(trimmed):])
+				out.Truncate(len(trimmed) - 1)
+				out.Write(rest)
+			}
+			out.WriteByte(c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.Bytes()
+}
+
+// syncRef is the prefix of the refs that hold the snapshots of uncommitted changes that sessions start from.
+const syncRef = "refs/sketch/sync/"
+
+// snapshotWorkingTree commits the uncommitted changes in the repository at gitRoot, including untracked files
+// that are not ignored, on top of HEAD, without touching the working tree or the index, and returns the commit.
+// The commit is kept at syncRef+sessionID, for the container to fetch; the caller deletes the ref when it is done.
+// If there are no uncommitted changes, snapshotWorkingTree returns "".
+func snapshotWorkingTree(ctx context.Context, gitRoot, sessionID string) (string, error) {
+	tmpDir, err := os.MkdirTemp("", "sketch-index-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	gitCmd := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = gitRoot
+		cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(tmpDir, "index"))
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %w", args[0], err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	if _, err := gitCmd("read-tree", "HEAD"); err != nil {
+		return "", err
+	}
+	if _, err := gitCmd("add", "-A"); err != nil {
+		return "", err
+	}
+	tree, err := gitCmd("write-tree")
+	if err != nil {
+		return "", err
+	}
+	head, err := gitCmd("rev-parse", "HEAD^{tree}")
+	if err != nil {
+		return "", err
+	}
+	if tree == head {
+		return "", nil
+	}
+	commit, err := gitCmd("commit-tree", tree, "-p", "HEAD", "-m", "Uncommitted changes the session started from")
+	if err != nil {
+		return "", err
+	}
+	if _, err := gitCmd("update-ref", syncRef+sessionID, commit); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
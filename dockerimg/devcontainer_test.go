@@ -0,0 +1,171 @@
+package dockerimg
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+	in := `{
+	// The image.
+	"image": "golang:1.24", /* pinned */
+	"url": "http://example.com/a//b",
+	"quote": "say \"//hi\"",
+	"list": [1, 2,],
+}`
+	var got map[string]any
+	if err := json.Unmarshal(stripJSONC([]byte(in)), &got); err != nil {
+		t.Fatalf("stripJSONC output does not parse: %v\n%s", err, stripJSONC([]byte(in)))
+	}
+	want := map[string]any{
+		"image": "golang:1.24",
+		"url":   "http://example.com/a//b",
+		"quote": `say "//hi"`,
+		"list":  []any{1.0, 2.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stripJSONC = %v, want %v", got, want)
+	}
+}
+
+func TestFindDevContainer(t *testing.T) {
+	root := t.TempDir()
+	if dc, err := findDevContainer(root, DevContainerAuto); dc != nil || err != nil {
+		t.Errorf("findDevContainer in a repo without one = %v, %v", dc, err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, ".devcontainer"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	devcontainerJSON := `{
+	"name": "dev",
+	// Build from the Dockerfile next to this file.
+	"build": {
+		"dockerfile": "Dockerfile",
+		"context": "..",
+		"args": {"GO_VERSION": "${localEnv:SKETCH_TEST_GO_VERSION:1.24}", "ROOT": "${localWorkspaceFolder}"},
+		"target": "dev",
+	},
+	"containerEnv": {"WORKSPACE": "${containerWorkspaceFolder}", "TOKEN": "${localEnv:SKETCH_TEST_TOKEN}"},
+	"postCreateCommand": {"b": ["make", "deps"], "a": "npm ci"},
+}`
+	if err := os.WriteFile(filepath.Join(root, ".devcontainer/devcontainer.json"), []byte(devcontainerJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SKETCH_TEST_TOKEN", "secret")
+	dc, err := findDevContainer(root, DevContainerAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := []string{
+		"build", "-t", "img", "-f", filepath.Join(root, ".devcontainer/Dockerfile"),
+		"--target", "dev",
+		"--build-arg", "GO_VERSION=1.24",
+		"--build-arg", "ROOT=" + root,
+		root,
+	}
+	if got := dc.buildArgs("img", false); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("buildArgs = %q, want %q", got, wantArgs)
+	}
+	if got, want := dc.env(), []string{"-e", "TOKEN=secret", "-e", "WORKSPACE=/app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+	if got, want := dc.PostCreateCommand, (devCommand{{"/bin/sh", "-c", "npm ci"}, {"make", "deps"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("PostCreateCommand = %q, want %q", got, want)
+	}
+
+	dockerfile := layeredDockerfile("base", dc)
+	for _, want := range []string{"FROM base\nUSER root\n", "COPY . /app\n", `RUN ["/bin/sh","-c","npm ci"]` + "\n" + `RUN ["make","deps"]` + "\n"} {
+		if !strings.Contains(dockerfile, want) {
+			t.Errorf("layeredDockerfile does not contain %q:\n%s", want, dockerfile)
+		}
+	}
+	if dockerfile := layeredDockerfile("base", nil); strings.Contains(dockerfile, "USER") {
+		t.Errorf("layeredDockerfile without a dev container switches users:\n%s", dockerfile)
+	}
+
+	if dc, err := findDevContainer(root, DevContainerNone); dc != nil || err != nil {
+		t.Errorf("findDevContainer(none) = %v, %v", dc, err)
+	}
+
+	// A Dockerfile is built with its own directory as the context.
+	if err := os.WriteFile(filepath.Join(root, "Dockerfile.dev"), []byte("FROM golang\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dc, err = findDevContainer(root, "Dockerfile.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dc.buildArgs("img", true), []string{"build", "-t", "img", "-f", filepath.Join(root, "Dockerfile.dev"), "--no-cache", "--pull", root}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildArgs = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, ".devcontainer.json"), []byte(`{"dockerComposeFile": "compose.yml"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findDevContainer(root, ".devcontainer.json"); err == nil || !strings.Contains(err.Error(), "neither an image nor a build.dockerfile") {
+		t.Errorf("findDevContainer of a compose configuration = %v", err)
+	}
+}
+
+func TestSnapshotWorkingTree(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("ignored.txt\n"), 0o644)
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	head := git("rev-parse", "HEAD")
+
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	if commit, err := snapshotWorkingTree(ctx, dir, "s1"); commit != "" || err != nil {
+		t.Errorf("snapshotWorkingTree of a clean tree = %q, %v", commit, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("ignored\n"), 0o644)
+	commit, err := snapshotWorkingTree(ctx, dir, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := git("rev-parse", syncRef+"s1"); got != commit {
+		t.Errorf("%ss1 = %s, want %s", syncRef, got, commit)
+	}
+	if got := git("rev-parse", commit+"^"); got != head {
+		t.Errorf("snapshot's parent = %s, want HEAD %s", got, head)
+	}
+	if got := git("show", commit+":a.txt"); got != "changed" {
+		t.Errorf("snapshot's a.txt = %q", got)
+	}
+	if got := git("ls-tree", "--name-only", commit); got != ".gitignore\na.txt\nnew.txt" {
+		t.Errorf("snapshot's files = %q", got)
+	}
+	// The working tree and index are untouched.
+	if got := git("status", "--porcelain"); got != "M a.txt\n?? new.txt" {
+		t.Errorf("git status after snapshot = %q", got)
+	}
+}
@@ -3,6 +3,7 @@ package dockerimg
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
@@ -17,13 +18,21 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 	"sketch.dev/browser"
+	"sketch.dev/claudetool/codeindex"
+	"sketch.dev/claudetool/forge"
+	"sketch.dev/claudetool/websearch"
+	"sketch.dev/llm/oai"
 	"sketch.dev/loop/server"
+	"sketch.dev/sessionstore"
+	"sketch.dev/skabandclient"
 	"sketch.dev/skribe"
 	"sketch.dev/webui"
 )
@@ -69,6 +78,15 @@ type ContainerConfig struct {
 	// BaseImage is the base Docker image to use for layering the repo
 	BaseImage string
 
+	// DevContainer is what to build the base image from instead: DevContainerAuto for the repo's
+	// devcontainer.json, if it has one, DevContainerNone for nothing, or the path of a devcontainer.json
+	// or a Dockerfile, relative to the root of the repo
+	DevContainer string
+
+	// IncludeUncommitted starts the session from the host's uncommitted changes, committed on top of HEAD,
+	// rather than from HEAD
+	IncludeUncommitted bool
+
 	// Host directory to copy container logs into, if not set to ""
 	ContainerLogDest string
 
@@ -108,7 +126,10 @@ type ContainerConfig struct {
 	TermUI bool
 
 	// Budget configuration
-	MaxDollars float64
+	MaxDollars   float64
+	MaxTokens    uint64
+	MaxToolCalls int
+	MaxWallTime  time.Duration
 
 	GitRemoteUrl string
 
@@ -132,8 +153,92 @@ type ContainerConfig struct {
 
 	// MCPServers contains MCP server configurations
 	MCPServers []string
+
+	// ApproveWrites stages file modifications until the user approves them
+	ApproveWrites bool
+
+	// Notify lists notifiers for long-running commands, and NotifyAfter is their threshold
+	Notify      []string
+	NotifyAfter time.Duration
+
+	// ResponseCacheTTL is how long responses to deterministic subagent prompts are cached; 0 disables caching
+	ResponseCacheTTL time.Duration
+
+	// LLMStallTimeout is how long an LLM response may produce no data before it is retried; 0 waits forever
+	LLMStallTimeout time.Duration
+
+	// LLMRateLimit is how many requests a minute the container sends to each LLM provider account; 0 for no limit
+	LLMRateLimit int
+
+	// OTLPEndpoint, if set, is where the container exports OpenTelemetry traces
+	OTLPEndpoint string
+
+	// SessionStore, if set, records the summary the container leaves at SessionSummaryPath when it exits
+	SessionStore *sessionstore.Store
+
+	// PluginDir, if set, is a host directory of tool plugins, mounted read-only at ContainerPluginDir
+	PluginDir string
+
+	// SystemPrompt, if set, is the absolute path of a host file with a system prompt template,
+	// mounted read-only at ContainerSystemPromptPath
+	SystemPrompt string
+
+	// ProjectConfig, if set, is the absolute path of the host's project configuration file,
+	// mounted read-only at ContainerProjectConfigPath, so that it applies even if it is not committed
+	ProjectConfig string
+
+	// Profile, if set, is the name of the session's profile; see projectconfig.Profile
+	Profile string
+
+	// ForgeKind and ForgeRepo (a URL) are the kind of forge and the repository on it for the forge tool,
+	// which is enabled when the forge's token is set; see forge.TokenEnv.
+	ForgeKind string
+	ForgeRepo string
+	// ForgeWrites is whether the forge tool may change things on the forge: ask, allow, or deny
+	ForgeWrites string
+
+	// WebSearch is the web search provider, as -web-search: empty to choose one,
+	// from the variables named by websearch.Env that are set, or "off".
+	WebSearch string
+	// WebSearchBudget is how many web searches the session may make
+	WebSearchBudget int
+
+	// Embedder, EmbedderURL, and EmbedderModel configure the code index, as -embedder,
+	// -embedder-url, and -embedder-model do; an empty Embedder disables it
+	Embedder      string
+	EmbedderURL   string
+	EmbedderModel string
+
+	// AutoCommit commits the agent's changes at the end of every turn
+	AutoCommit bool
+
+	// Theme is the chroma style the terminal UI highlights code with
+	Theme string
+
+	// Result, if set, runs the container's agent headless (-output=json)
+	// and receives the result it leaves at HeadlessResultPath when it exits
+	Result io.Writer
+
+	devContainer *devContainer // what the base image was built from, if anything
 }
 
+// SessionSummaryPath is where sketch in the container keeps a summary of its session,
+// which the host records in its session store when the container exits.
+const SessionSummaryPath = "/root/.cache/sketch/session.json"
+
+// HeadlessResultPath is where sketch in the container leaves the result of a headless session,
+// which the host prints when the container exits.
+const HeadlessResultPath = "/root/.cache/sketch/result.json"
+
+// ContainerPluginDir is where the host's tool plugin directory is mounted in the container.
+const ContainerPluginDir = "/sketch-plugins"
+
+// ContainerSystemPromptPath is where the host's system prompt template is mounted in the container.
+const ContainerSystemPromptPath = "/sketch-system-prompt.tmpl"
+
+// ContainerProjectConfigPath is where the host's project configuration file is mounted in the container.
+const ContainerProjectConfigPath = "/sketch-project-config.yaml"
+
 // LaunchContainer creates a docker container for a project, installs sketch and opens a connection to it.
 // It writes status to stdout.
 func LaunchContainer(ctx context.Context, config ContainerConfig) error {
@@ -166,7 +271,18 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 		return err
 	}
 
-	imgName, err := findOrBuildDockerImage(ctx, gitRoot, config.BaseImage, config.ForceRebuild, config.Verbose)
+	dc, err := findDevContainer(gitRoot, config.DevContainer)
+	if err != nil {
+		return fmt.Errorf("dev container: %w", err)
+	}
+	if dc != nil && config.BaseImage != "" {
+		if config.DevContainer == DevContainerAuto {
+			dc = nil // an explicit -base-image wins over the repo's devcontainer.json
+		} else {
+			return fmt.Errorf("a base image and a dev container to build one from are both set; choose one")
+		}
+	}
+	imgName, err := findOrBuildDockerImage(ctx, gitRoot, config.BaseImage, dc, config.ForceRebuild, config.Verbose)
 	if err != nil {
 		return err
 	}
@@ -216,6 +332,18 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 		commit = strings.TrimSpace(string(out))
 	}
 
+	if config.IncludeUncommitted {
+		snapshot, err := snapshotWorkingTree(ctx, gitRoot, config.SessionID)
+		if err != nil {
+			return fmt.Errorf("failed to commit the uncommitted changes for the session: %w", err)
+		}
+		if snapshot != "" {
+			defer combinedOutput(context.WithoutCancel(ctx), "git", "-C", gitRoot, "update-ref", "-d", syncRef+config.SessionID)
+			fmt.Printf("📎 starting from your uncommitted changes, committed as %s\n", snapshot[:12])
+			commit = snapshot
+		}
+	}
+
 	var upstream string
 	if out, err := combinedOutput(ctx, "git", "branch", "--show-current"); err != nil {
 		slog.DebugContext(ctx, "git branch --show-current failed (continuing)", "error", err)
@@ -237,6 +365,7 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 	config.Commit = commit
 
 	// Create the sketch container
+	config.devContainer = dc
 	if err := createDockerContainer(ctx, cntrName, hostPort, relPath, imgName, config); err != nil {
 		return fmt.Errorf("failed to create docker container: %w", err)
 	}
@@ -421,7 +550,63 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 		errCh <- run(ctx, "docker attach", cmd)
 	}()
 
+	// Records the container's session summary in the host's session store.
+	recordSession := func() {
+		if config.SessionStore == nil {
+			return
+		}
+		ctx := context.WithoutCancel(ctx)
+		dir, err := os.MkdirTemp("", "sketch-session")
+		if err != nil {
+			slog.WarnContext(ctx, "session_record_failed", "err", err)
+			return
+		}
+		defer os.RemoveAll(dir)
+		dst := filepath.Join(dir, "session.json")
+		if out, err := combinedOutput(ctx, "docker", "cp", cntrName+":"+SessionSummaryPath, dst); err != nil {
+			// The session may have ended before its first turn did.
+			slog.DebugContext(ctx, "session_summary_copy_failed", "out", string(out), "err", err)
+			return
+		}
+		sess, err := sessionstore.ReadFile(dst)
+		if err == nil {
+			err = config.SessionStore.Record(ctx, sess)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "session_record_failed", "err", err)
+		}
+	}
+
+	// Copies the result of a headless session to config.Result.
+	copyResult := func() {
+		if config.Result == nil {
+			return
+		}
+		ctx := context.WithoutCancel(ctx)
+		dir, err := os.MkdirTemp("", "sketch-result")
+		if err != nil {
+			slog.WarnContext(ctx, "headless_result_copy_failed", "err", err)
+			return
+		}
+		defer os.RemoveAll(dir)
+		dst := filepath.Join(dir, "result.json")
+		if out, err := combinedOutput(ctx, "docker", "cp", cntrName+":"+HeadlessResultPath, dst); err != nil {
+			// The session may have failed before its turn ended.
+			slog.WarnContext(ctx, "headless_result_copy_failed", "out", string(out), "err", err)
+			return
+		}
+		data, err := os.ReadFile(dst)
+		if err == nil {
+			_, err = config.Result.Write(data)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "headless_result_copy_failed", "err", err)
+		}
+	}
+
 	defer copyLogs()
+	defer recordSession()
+	defer copyResult()
 
 	for {
 		select {
@@ -524,12 +709,32 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 	for _, envVar := range getEnvForwardingFromGitConfig(ctx) {
 		cmdArgs = append(cmdArgs, "-e", envVar)
 	}
+	if config.devContainer != nil {
+		cmdArgs = append(cmdArgs, config.devContainer.env()...)
+	}
 	if config.ModelURL != "" {
 		cmdArgs = append(cmdArgs, "-e", "SKETCH_MODEL_URL="+config.ModelURL)
 	}
 	if config.SketchPubKey != "" {
 		cmdArgs = append(cmdArgs, "-e", "SKETCH_PUB_KEY="+config.SketchPubKey)
 	}
+	if env := forge.TokenEnv(config.ForgeKind); config.ForgeRepo != "" && os.Getenv(env) != "" {
+		cmdArgs = append(cmdArgs, "-e", env+"="+os.Getenv(env))
+	}
+	if config.WebSearch != "off" {
+		for _, kind := range websearch.Kinds {
+			if env := websearch.Env(kind); env != "" && os.Getenv(env) != "" {
+				cmdArgs = append(cmdArgs, "-e", env+"="+os.Getenv(env))
+			}
+		}
+	}
+	if config.Embedder != "" {
+		for _, kind := range codeindex.Kinds {
+			if env := codeindex.Env(kind); env != "" && os.Getenv(env) != "" && !slices.Contains(cmdArgs, env+"="+os.Getenv(env)) {
+				cmdArgs = append(cmdArgs, "-e", env+"="+os.Getenv(env))
+			}
+		}
+	}
 	if config.SSHPort > 0 {
 		cmdArgs = append(cmdArgs, "-p", fmt.Sprintf("%d:22", config.SSHPort)) // forward container ssh port to host ssh port
 	} else {
@@ -562,6 +767,15 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 			cmdArgs = append(cmdArgs, "-v", mount)
 		}
 	}
+	if config.PluginDir != "" {
+		cmdArgs = append(cmdArgs, "-v", config.PluginDir+":"+ContainerPluginDir+":ro")
+	}
+	if config.SystemPrompt != "" {
+		cmdArgs = append(cmdArgs, "-v", config.SystemPrompt+":"+ContainerSystemPromptPath+":ro")
+	}
+	if config.ProjectConfig != "" {
+		cmdArgs = append(cmdArgs, "-v", config.ProjectConfig+":"+ContainerProjectConfigPath+":ro")
+	}
 	cmdArgs = append(cmdArgs, imgName)
 
 	// Add command: either [sketch] or [subtrace run -- sketch]
@@ -582,6 +796,9 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 		"-outside-os="+config.OutsideOS,
 		"-outside-working-dir="+config.OutsideWorkingDir,
 		fmt.Sprintf("-max-dollars=%f", config.MaxDollars),
+		fmt.Sprintf("-max-tokens=%d", config.MaxTokens),
+		fmt.Sprintf("-max-tool-calls=%d", config.MaxToolCalls),
+		"-max-wall-time="+config.MaxWallTime.String(),
 		"-open=false",
 		"-termui="+fmt.Sprintf("%t", config.TermUI),
 		"-verbose="+fmt.Sprintf("%t", config.Verbose),
@@ -612,6 +829,36 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 	if config.OneShot {
 		cmdArgs = append(cmdArgs, "-one-shot")
 	}
+	if config.Result != nil {
+		cmdArgs = append(cmdArgs, "-output=json")
+	}
+	if config.ForgeRepo != "" {
+		cmdArgs = append(cmdArgs, "-forge="+config.ForgeKind, "-forge-repo="+config.ForgeRepo)
+	}
+	if config.ForgeWrites != "" {
+		cmdArgs = append(cmdArgs, "-forge-writes="+config.ForgeWrites)
+	}
+	if config.WebSearch != "" {
+		cmdArgs = append(cmdArgs, "-web-search="+config.WebSearch)
+	}
+	if config.WebSearchBudget > 0 {
+		cmdArgs = append(cmdArgs, fmt.Sprintf("-web-search-budget=%d", config.WebSearchBudget))
+	}
+	if config.Embedder != "" {
+		cmdArgs = append(cmdArgs, "-embedder="+config.Embedder)
+		embedderURL := config.EmbedderURL
+		if config.Embedder == codeindex.Local {
+			embedderURL = cmp.Or(embedderURL, oai.LlamaCPPURL)
+		}
+		if embedderURL != "" {
+			// A local server on the host is at host.docker.internal from inside the container.
+			embedderURL = strings.NewReplacer("//localhost:", "//host.docker.internal:", "//127.0.0.1:", "//host.docker.internal:").Replace(embedderURL)
+			cmdArgs = append(cmdArgs, "-embedder-url="+embedderURL)
+		}
+		if config.EmbedderModel != "" {
+			cmdArgs = append(cmdArgs, "-embedder-model="+config.EmbedderModel)
+		}
+	}
 	if config.ModelURL == "" {
 		// Forward ANTHROPIC_API_KEY for direct use.
 		// TODO: have outtie run an http proxy?
@@ -622,6 +869,47 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 	for _, mcpServer := range config.MCPServers {
 		cmdArgs = append(cmdArgs, "-mcp", mcpServer)
 	}
+	if config.ApproveWrites {
+		cmdArgs = append(cmdArgs, "-approve-writes")
+	}
+	if config.AutoCommit {
+		cmdArgs = append(cmdArgs, "-autocommit")
+	}
+	if config.Theme != "" {
+		cmdArgs = append(cmdArgs, "-theme="+config.Theme)
+	}
+	for _, n := range config.Notify {
+		cmdArgs = append(cmdArgs, "-notify", n)
+	}
+	if len(config.Notify) > 0 {
+		cmdArgs = append(cmdArgs, "-notify-after="+config.NotifyAfter.String())
+	}
+	cmdArgs = append(cmdArgs, "-response-cache-ttl="+config.ResponseCacheTTL.String())
+	cmdArgs = append(cmdArgs, "-llm-stall-timeout="+config.LLMStallTimeout.String())
+	if config.LLMRateLimit > 0 {
+		cmdArgs = append(cmdArgs, "-llm-rate-limit="+strconv.Itoa(config.LLMRateLimit))
+	}
+	if config.OTLPEndpoint != "" {
+		endpoint, err := skabandclient.LocalhostToDockerInternal(config.OTLPEndpoint)
+		if err != nil {
+			return fmt.Errorf("-otlp-endpoint: %w", err)
+		}
+		cmdArgs = append(cmdArgs, "-otlp-endpoint="+endpoint)
+	}
+	if config.PluginDir != "" {
+		cmdArgs = append(cmdArgs, "-plugin-dir="+ContainerPluginDir)
+	} else {
+		cmdArgs = append(cmdArgs, "-plugin-dir=")
+	}
+	if config.SystemPrompt != "" {
+		cmdArgs = append(cmdArgs, "-system-prompt="+ContainerSystemPromptPath)
+	}
+	if config.ProjectConfig != "" {
+		cmdArgs = append(cmdArgs, "-project-config="+ContainerProjectConfigPath)
+	}
+	if config.Profile != "" {
+		cmdArgs = append(cmdArgs, "-profile="+config.Profile)
+	}
 
 	// Add additional docker arguments if provided
 	if config.DockerArgs != "" {
@@ -783,7 +1071,13 @@ func postContainerInitConfig(ctx context.Context, localAddr string, sshAvailable
 	return nil
 }
 
-func findOrBuildDockerImage(ctx context.Context, gitRoot, baseImage string, forceRebuild, verbose bool) (imgName string, err error) {
+func findOrBuildDockerImage(ctx context.Context, gitRoot, baseImage string, dc *devContainer, forceRebuild, verbose bool) (imgName string, err error) {
+	// Build the base image from the repo's dev container, if it has one.
+	if dc != nil {
+		if baseImage, err = dc.baseImage(ctx, forceRebuild); err != nil {
+			return "", err
+		}
+	}
 	// Default to the published sketch image if no base image is specified
 	if baseImage == "" {
 		imageTag := dockerfileBaseHash()
@@ -811,7 +1105,7 @@ func findOrBuildDockerImage(ctx context.Context, gitRoot, baseImage string, forc
 	// SKIPPED: Force local build by removing pre-existing image check.
 
 	// Build the layered image
-	if err := buildLayeredImage(ctx, imgName, baseImage, gitRoot, verbose); err != nil {
+	if err := buildLayeredImage(ctx, imgName, baseImage, gitRoot, dc, verbose); err != nil {
 		return "", fmt.Errorf("failed to build layered image: %w", err)
 	}
 
@@ -885,13 +1179,8 @@ func dockerImageExists(ctx context.Context, imageName string) (bool, error) {
 // Note that buildx has some support for conditional COPY, but without buildx, which
 // we can't reliably depend on, we have to run the base image to inspect its file system,
 // and then we can decide what to do.
-func buildLayeredImage(ctx context.Context, imgName, baseImage, gitRoot string, _ bool) error {
-	dockerfileContent := fmt.Sprintf(`FROM %s
-COPY . /app
-WORKDIR /app
-RUN if [ -f go.mod ]; then go mod download; fi
-CMD ["/bin/sketch"]
-`, baseImage)
+func buildLayeredImage(ctx context.Context, imgName, baseImage, gitRoot string, dc *devContainer, _ bool) error {
+	dockerfileContent := layeredDockerfile(baseImage, dc)
 
 	// Create a temporary directory for the Dockerfile
 	tmpDir, err := os.MkdirTemp("", "sketch-docker-*")
@@ -121,6 +121,10 @@ func (g *gitHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"GIT_HTTP_ALLOW_REPACK=true",
 			"GIT_HTTP_ALLOW_PUSH=true",
 			"GIT_HTTP_VERBOSE=1",
+			// Containers fetch the snapshots of uncommitted changes they start from by commit.
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=uploadpack.allowReachableSHA1InWant",
+			"GIT_CONFIG_VALUE_0=true",
 		},
 	}
 	h.ServeHTTP(w, r)
@@ -0,0 +1,443 @@
+// Package editorbridge lets editor extensions, such as for VS Code or Neovim, drive a running sketch session.
+//
+// An extension starts `sketch editor [session URL]` and speaks JSON-RPC 2.0 with it on stdin and stdout,
+// one message per line. The bridge talks to the session over the session's HTTP API, the one the web UI uses,
+// and forwards the session's events to the editor as notifications.
+//
+// Methods, with their params and results:
+//
+//	session/open        {"url": "http://localhost:8000/"} → the session's state; unless the URL was on the command line,
+//	                    this must come first. Opening another session closes the last.
+//	session/state       {} → the session's state
+//	session/cancel      {} → {}; cancels the agent's current turn
+//	chat/send           {"message": "...", "interrupt": false, "selections": [Selection]} → {}
+//	edits/pending       {} → EditProposal, the changes awaiting approval (sketch -approve-writes)
+//	edits/review        {"path": "/abs/path", "decisions": [{"hunk": 0, "accept": true, "comment": "optional"}]}
+//	                    → EditProposal, the changes still awaiting approval; the agent is told what was decided
+//	permissions/list    {} → [PermissionRequest], tools' requests awaiting an answer
+//	permissions/answer  {"id": "...", "allow": true} → [PermissionRequest] still awaiting an answer
+//
+// Notifications from the bridge:
+//
+//	sketch/event                every event of the session, as the web UI's WebSocket streams them
+//	sketch/proposedEdits        EditProposal, when the agent stages changes for approval
+//	sketch/permissionRequested  PermissionRequest, when a tool asks to take an action
+//	sketch/disconnected         {"error": "..."}, when the bridge loses the session; session/open reconnects
+package editorbridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/loop"
+)
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	// codeSessionError is for failures of the session's API, and for methods called before session/open.
+	codeSessionError = -32000
+)
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"` // absent for notifications
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// A Selection is code the user selected in the editor, sent to the agent along with a message.
+type Selection struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line"` // 1-based
+	EndLine   int    `json:"end_line"`   // 1-based, inclusive
+	Text      string `json:"text"`
+	Language  string `json:"language,omitempty"` // such as "go", for the code fence
+}
+
+// A Bridge serves the protocol to one editor.
+type Bridge struct {
+	client *http.Client
+	token  string
+
+	outMu sync.Mutex // guards writes to out
+	out   io.Writer
+
+	mu         sync.Mutex
+	base       *url.URL           // the session's URL; nil until a session is opened
+	stopEvents context.CancelFunc // stops forwarding the open session's events
+}
+
+// New returns a Bridge that uses client to reach sessions, or http.DefaultClient if client is nil.
+// If token is not empty, the bridge presents it as a bearer token, as sessions of `sketch serve` may require.
+func New(client *http.Client, token string) *Bridge {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Bridge{client: client, token: token}
+}
+
+// Serve serves the protocol on in and out until in is closed.
+// If sessionURL is not empty, the bridge opens that session first.
+func (b *Bridge) Serve(ctx context.Context, sessionURL string, in io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b.out = out
+	defer b.close()
+	if sessionURL != "" {
+		if _, err := b.open(ctx, sessionURL); err != nil {
+			return err
+		}
+	}
+	sc := bufio.NewScanner(in)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var req request
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			b.write(response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: err.Error()}})
+			continue
+		}
+		result, err := b.handle(ctx, req.Method, req.Params)
+		if req.ID == nil {
+			continue // a notification; the editor wants no answer
+		}
+		resp := response{JSONRPC: "2.0", ID: req.ID, Result: result}
+		if err != nil {
+			slog.InfoContext(ctx, "editor_bridge_error", "method", req.Method, "err", err)
+			var rerr *rpcError
+			if !errors.As(err, &rerr) {
+				rerr = &rpcError{Code: codeSessionError, Message: err.Error()}
+			}
+			resp.Result, resp.Error = nil, rerr
+		} else if result == nil {
+			resp.Result = struct{}{}
+		}
+		b.write(resp)
+	}
+	return sc.Err()
+}
+
+// handle runs a method.
+func (b *Bridge) handle(ctx context.Context, method string, params json.RawMessage) (any, error) {
+	if method == "session/open" {
+		var p struct {
+			URL string `json:"url"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return b.open(ctx, p.URL)
+	}
+	if b.session() == nil {
+		return nil, &rpcError{Code: codeSessionError, Message: "no session is open; call session/open first"}
+	}
+	switch method {
+	case "session/state":
+		return b.getJSON(ctx, "state")
+	case "session/cancel":
+		_, err := b.postJSON(ctx, "cancel", struct {
+			Reason string `json:"reason"`
+		}{"canceled in the editor"})
+		return nil, err
+	case "chat/send":
+		var p struct {
+			Message    string      `json:"message"`
+			Interrupt  bool        `json:"interrupt"`
+			Selections []Selection `json:"selections"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Message == "" && len(p.Selections) == 0 {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "chat/send needs a message or selections"}
+		}
+		_, err := b.postJSON(ctx, "chat", map[string]any{"message": withSelections(p.Message, p.Selections), "interrupt": p.Interrupt})
+		return nil, err
+	case "edits/pending":
+		return b.pendingEdits(ctx, nil)
+	case "edits/review":
+		var p struct {
+			Path      string             `json:"path"`
+			Decisions []staging.Decision `json:"decisions"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		changes, err := b.postJSON(ctx, "changes/review", p)
+		if err != nil {
+			return nil, err
+		}
+		return b.pendingEdits(ctx, changes)
+	case "permissions/list":
+		return b.getJSON(ctx, "permissions")
+	case "permissions/answer":
+		var p struct {
+			ID    string `json:"id"`
+			Allow bool   `json:"allow"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		action := "permissions/deny"
+		if p.Allow {
+			action = "permissions/allow"
+		}
+		return b.postJSON(ctx, action, map[string]string{"id": p.ID})
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("unknown method %q", method)}
+}
+
+// pendingEdits returns the staged changes in changes, a JSON list of them,
+// or, if changes is nil, those the session has now, as an EditProposal.
+func (b *Bridge) pendingEdits(ctx context.Context, changes json.RawMessage) (any, error) {
+	if changes == nil {
+		var err error
+		if changes, err = b.getJSON(ctx, "changes"); err != nil {
+			return nil, err
+		}
+	}
+	var list []staging.Change
+	if err := json.Unmarshal(changes, &list); err != nil {
+		return nil, fmt.Errorf("bad changes from the session: %w", err)
+	}
+	return proposal(list), nil
+}
+
+// withSelections returns msg with the selected code appended, fenced, so that the agent need not read it.
+func withSelections(msg string, selections []Selection) string {
+	buf := new(strings.Builder)
+	buf.WriteString(msg)
+	for _, s := range selections {
+		// Fence the code with more backticks than any run of them inside.
+		fence := "```"
+		for strings.Contains(s.Text, fence) {
+			fence += "`"
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n\n")
+		}
+		fmt.Fprintf(buf, "Selected in my editor, %s lines %d-%d:\n%s%s\n%s", s.Path, s.StartLine, s.EndLine, fence, s.Language, s.Text)
+		if !strings.HasSuffix(s.Text, "\n") {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(fence)
+	}
+	return buf.String()
+}
+
+// open connects to the session at rawURL, and starts forwarding its events. It returns the session's state.
+func (b *Bridge) open(ctx context.Context, rawURL string) (json.RawMessage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("want the http(s) URL of a session, not %q", rawURL)}
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/" // so that the API's paths resolve under it
+	}
+	b.close()
+	b.mu.Lock()
+	b.base = u
+	b.mu.Unlock()
+	state, err := b.getJSON(ctx, "state")
+	if err != nil {
+		b.mu.Lock()
+		b.base = nil
+		b.mu.Unlock()
+		return nil, err
+	}
+	var s struct {
+		MessageCount int `json:"message_count"`
+	}
+	json.Unmarshal(state, &s)
+	eventsCtx, stop := context.WithCancel(ctx)
+	b.mu.Lock()
+	b.stopEvents = stop
+	b.mu.Unlock()
+	go b.forwardEvents(eventsCtx, u, s.MessageCount)
+	slog.InfoContext(ctx, "editor_bridge_opened", "url", u.String())
+	return state, nil
+}
+
+// close stops forwarding the open session's events, if any.
+func (b *Bridge) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopEvents != nil {
+		b.stopEvents()
+		b.stopEvents = nil
+	}
+}
+
+// session returns the open session's URL, or nil.
+func (b *Bridge) session() *url.URL {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.base
+}
+
+// forwardEvents sends the editor the session's events from message index from on, until ctx is done or the session is lost.
+func (b *Bridge) forwardEvents(ctx context.Context, base *url.URL, from int) {
+	wsURL := *base.JoinPath("ws")
+	wsURL.Scheme = strings.Replace(wsURL.Scheme, "http", "ws", 1)
+	wsURL.RawQuery = "from=" + strconv.Itoa(from)
+	cfg, err := websocket.NewConfig(wsURL.String(), base.String())
+	if err == nil {
+		b.authorize(cfg.Header)
+		var ws *websocket.Conn
+		if ws, err = cfg.DialContext(ctx); err == nil {
+			go func() {
+				<-ctx.Done()
+				ws.Close()
+			}()
+			err = b.receiveEvents(ws)
+		}
+	}
+	if ctx.Err() != nil {
+		return // closed on purpose
+	}
+	slog.InfoContext(ctx, "editor_bridge_disconnected", "err", err)
+	b.notify("sketch/disconnected", map[string]string{"error": fmt.Sprint(err)})
+}
+
+// receiveEvents forwards the events on ws until it fails.
+func (b *Bridge) receiveEvents(ws *websocket.Conn) error {
+	for {
+		var frame json.RawMessage
+		if err := websocket.JSON.Receive(ws, &frame); err != nil {
+			return err
+		}
+		var e loop.Event
+		if err := json.Unmarshal(frame, &e); err != nil {
+			continue
+		}
+		switch e.Type {
+		case "state", "heartbeat":
+			continue // the editor asks for the state when it wants it
+		case loop.EventPermissionRequested:
+			if len(e.Changes) > 0 {
+				b.notify("sketch/proposedEdits", proposal(e.Changes))
+			}
+			if e.Permission != nil {
+				b.notify("sketch/permissionRequested", e.Permission)
+			}
+		}
+		b.notify("sketch/event", frame)
+	}
+}
+
+func (b *Bridge) notify(method string, params any) {
+	b.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// write sends msg to the editor, on a line of its own.
+func (b *Bridge) write(msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("editor_bridge_marshal_failed", "err", err)
+		return
+	}
+	b.outMu.Lock()
+	defer b.outMu.Unlock()
+	b.out.Write(append(data, '\n'))
+}
+
+// getJSON fetches the session's endpoint.
+func (b *Bridge) getJSON(ctx context.Context, endpoint string) (json.RawMessage, error) {
+	return b.do(ctx, http.MethodGet, endpoint, nil)
+}
+
+// postJSON posts body to the session's endpoint, as JSON.
+func (b *Bridge) postJSON(ctx context.Context, endpoint string, body any) (json.RawMessage, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return b.do(ctx, http.MethodPost, endpoint, data)
+}
+
+func (b *Bridge) do(ctx context.Context, method, endpoint string, body []byte) (json.RawMessage, error) {
+	base := b.session()
+	if base == nil {
+		return nil, &rpcError{Code: codeSessionError, Message: "no session is open"}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base.JoinPath(endpoint).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	b.authorize(req.Header)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, endpoint, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if !json.Valid(data) {
+		return nil, nil // an endpoint that answers in plain text, such as cancel
+	}
+	return data, nil
+}
+
+// authorize adds the bridge's bearer token, if any, to h.
+func (b *Bridge) authorize(h http.Header) {
+	if b.token != "" {
+		h.Set("Authorization", "Bearer "+b.token)
+	}
+}
+
+// unmarshalParams decodes a method's params into p. Absent params leave p as it is.
+func unmarshalParams(params json.RawMessage, p any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, p); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package editorbridge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/loop"
+)
+
+// applyEdits applies edits, which must not overlap, to text, as an editor would. Positions count bytes.
+func applyEdits(text string, edits []TextEdit) string {
+	lines := strings.SplitAfter(text, "\n")
+	offset := func(p Position) int {
+		n := 0
+		for _, l := range lines[:min(p.Line, len(lines))] {
+			n += len(l)
+		}
+		return n + p.Character
+	}
+	// Apply the last edit first, so that earlier offsets hold.
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		text = text[:offset(e.Range.Start)] + e.NewText + text[offset(e.Range.End):]
+	}
+	return text
+}
+
+func TestHunkEdits(t *testing.T) {
+	for _, tt := range []struct {
+		name, old, new string
+	}{
+		{"middle", "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n", "a\nB\nc\nd\ne\nf\ng\nh\ni\nj2\nj3\nk\n"},
+		{"no final newline", "one\ntwo", "one\ntwo\nthree"},
+		{"removes final newline", "one\ntwo\n", "one\n2"},
+		{"deletion", "a\nb\nc\n", "a\nc\n"},
+		{"new file", "", "package main\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "f.txt")
+			if tt.old != "" {
+				if err := os.WriteFile(path, []byte(tt.old), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			a := staging.NewArea()
+			if err := a.WriteFile(path, []byte(tt.new)); err != nil {
+				t.Fatal(err)
+			}
+			p := proposal(a.Pending())
+			doc := p.Edit.DocumentChanges[len(p.Edit.DocumentChanges)-1].(TextDocumentEdit)
+			if doc.TextDocument.URI != "file://"+path {
+				t.Errorf("URI = %q, want file://%s", doc.TextDocument.URI, path)
+			}
+			if tt.old == "" {
+				if create, ok := p.Edit.DocumentChanges[0].(CreateFile); !ok || create.URI != doc.TextDocument.URI {
+					t.Errorf("DocumentChanges[0] = %+v, want a CreateFile of %s", p.Edit.DocumentChanges[0], doc.TextDocument.URI)
+				}
+			}
+			edits := doc.Edits
+			if got := applyEdits(tt.old, edits); got != tt.new {
+				t.Errorf("applying %+v to %q = %q, want %q", edits, tt.old, got, tt.new)
+			}
+			if len(p.Hunks) != len(edits) || !strings.HasPrefix(p.Hunks[0].Header, "@@ ") {
+				t.Errorf("Hunks = %+v, want one per edit", p.Hunks)
+			}
+		})
+	}
+}
+
+func TestHunkEditUTF16(t *testing.T) {
+	// Positions count UTF-16 code units: 👋 is two of them.
+	e := hunkEdit(staging.Hunk{OldStart: 1, OldLines: 1, Diff: "@@ -1,1 +1,1 @@\n-héllo 👋\n\\ No newline at end of file\n+bye\n"})
+	if want := (Range{End: Position{Character: 8}}); e.Range != want || e.NewText != "bye\n" {
+		t.Errorf("hunkEdit = %+v, want range %+v and new text %q", e, want, "bye\n")
+	}
+}
+
+func TestServe(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		chats []string
+	)
+	events := make(chan loop.Event, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/s/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message_count": 3, "slug": "fix-tests"}`))
+	})
+	mux.HandleFunc("/s/chat", func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Message string }
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		chats = append(chats, body.Message)
+		mu.Unlock()
+	})
+	mux.HandleFunc("/s/permissions/allow", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	})
+	mux.Handle("/s/ws", websocket.Server{Handler: func(ws *websocket.Conn) {
+		if ws.Request().URL.Query().Get("from") != "3" {
+			t.Errorf("ws from=%q, want 3", ws.Request().URL.Query().Get("from"))
+		}
+		websocket.JSON.Send(ws, map[string]string{"type": "state"})
+		for e := range events {
+			websocket.JSON.Send(ws, e)
+		}
+	}})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	defer close(events)
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go New(nil, "").Serve(context.Background(), "", inR, outW)
+	defer inW.Close()
+	out := bufio.NewScanner(outR)
+	call := func(line string) map[string]any {
+		t.Helper()
+		inW.Write([]byte(line + "\n"))
+		return read(t, out)
+	}
+
+	if resp := call(`{"jsonrpc":"2.0","id":1,"method":"chat/send","params":{"message":"hi"}}`); resp["error"] == nil {
+		t.Errorf("chat/send before session/open = %v, want an error", resp)
+	}
+	resp := call(`{"jsonrpc":"2.0","id":2,"method":"session/open","params":{"url":"` + ts.URL + `/s"}}`)
+	if state, _ := resp["result"].(map[string]any); state["slug"] != "fix-tests" {
+		t.Fatalf("session/open = %v, want the session's state", resp)
+	}
+	resp = call(`{"jsonrpc":"2.0","id":3,"method":"chat/send","params":{"message":"Why does this fail?","selections":[{"path":"/app/x.go","start_line":3,"end_line":4,"text":"x := 1\ny := 2","language":"go"}]}}`)
+	if resp["error"] != nil {
+		t.Fatalf("chat/send = %v", resp)
+	}
+	want := "Why does this fail?\n\nSelected in my editor, /app/x.go lines 3-4:\n```go\nx := 1\ny := 2\n```"
+	mu.Lock()
+	if len(chats) != 1 || chats[0] != want {
+		t.Errorf("chat messages = %q, want %q", chats, want)
+	}
+	mu.Unlock()
+	if resp := call(`{"jsonrpc":"2.0","id":4,"method":"permissions/answer","params":{"id":"p1","allow":true}}`); resp["error"] != nil {
+		t.Errorf("permissions/answer = %v", resp)
+	}
+	if resp := call(`{"jsonrpc":"2.0","id":5,"method":"no/such"}`); resp["error"] == nil {
+		t.Errorf("unknown method = %v, want an error", resp)
+	}
+
+	// Staged changes come as a proposal, and then as the event itself; the state frame is not forwarded.
+	events <- loop.Event{Type: loop.EventPermissionRequested, Changes: []staging.Change{{Path: "/app/x.go", Hunks: []staging.Hunk{{OldStart: 1, OldLines: 1, NewStart: 1, NewLines: 1, Diff: "@@ -1,1 +1,1 @@\n-a\n+b\n"}}}}}
+	if n := read(t, out); n["method"] != "sketch/proposedEdits" {
+		t.Errorf("first notification = %v, want sketch/proposedEdits", n)
+	}
+	if n := read(t, out); n["method"] != "sketch/event" {
+		t.Errorf("second notification = %v, want sketch/event", n)
+	}
+}
+
+// read reads the bridge's next message.
+func read(t *testing.T, out *bufio.Scanner) map[string]any {
+	t.Helper()
+	done := make(chan bool)
+	go func() { done <- out.Scan() }()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatalf("bridge output ended: %v", out.Err())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the bridge")
+	}
+	var msg map[string]any
+	if err := json.Unmarshal(out.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
@@ -0,0 +1,154 @@
+package editorbridge
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"sketch.dev/claudetool/staging"
+)
+
+// The types below are the Language Server Protocol's, so that editors can preview and apply them as they would a refactoring.
+// Lines and characters are 0-based; characters count UTF-16 code units.
+
+// A Position is a place in a text document.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// A Range is the text between two positions, including start and excluding end.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// A TextEdit replaces the text in Range with NewText.
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// A TextDocumentEdit is the edits to one document.
+type TextDocumentEdit struct {
+	TextDocument struct {
+		URI     string `json:"uri"`
+		Version *int   `json:"version"` // always null: the edits are to the file on disk
+	} `json:"textDocument"`
+	Edits []TextEdit `json:"edits"`
+}
+
+// A CreateFile creates the file at URI, before it is edited.
+type CreateFile struct {
+	Kind    string `json:"kind"` // "create"
+	URI     string `json:"uri"`
+	Options struct {
+		IgnoreIfExists bool `json:"ignoreIfExists"`
+	} `json:"options"`
+}
+
+// A WorkspaceEdit is edits to any number of documents.
+// Its DocumentChanges are CreateFiles and TextDocumentEdits, in the order they are to be applied.
+type WorkspaceEdit struct {
+	DocumentChanges []any `json:"documentChanges"`
+}
+
+// An EditProposal is the changes the agent staged for approval, as a workspace edit for the editor to preview.
+// The editor should not apply the edit itself: sketch writes the hunks the user accepts with edits/review.
+type EditProposal struct {
+	Edit  WorkspaceEdit  `json:"edit"`
+	Hunks []ProposedHunk `json:"hunks"`
+}
+
+// A ProposedHunk describes a hunk of an EditProposal, which edits/review accepts or rejects by path and index.
+type ProposedHunk struct {
+	Path   string `json:"path"`
+	URI    string `json:"uri"`
+	Hunk   int    `json:"hunk"`   // index of the hunk in the file's change
+	Header string `json:"header"` // the hunk's @@ line
+	Range  Range  `json:"range"`  // the text the hunk replaces
+	New    bool   `json:"new"`    // the file does not exist yet
+}
+
+// proposal converts staged changes to an EditProposal.
+func proposal(changes []staging.Change) EditProposal {
+	p := EditProposal{Edit: WorkspaceEdit{DocumentChanges: []any{}}, Hunks: []ProposedHunk{}}
+	for _, c := range changes {
+		uri := fileURI(c.Path)
+		if c.New {
+			create := CreateFile{Kind: "create", URI: uri}
+			create.Options.IgnoreIfExists = true
+			p.Edit.DocumentChanges = append(p.Edit.DocumentChanges, create)
+		}
+		doc := TextDocumentEdit{Edits: []TextEdit{}}
+		doc.TextDocument.URI = uri
+		for i, h := range c.Hunks {
+			edit := hunkEdit(h)
+			doc.Edits = append(doc.Edits, edit)
+			header, _, _ := strings.Cut(h.Diff, "\n")
+			p.Hunks = append(p.Hunks, ProposedHunk{Path: c.Path, URI: uri, Hunk: i, Header: header, Range: edit.Range, New: c.New})
+		}
+		p.Edit.DocumentChanges = append(p.Edit.DocumentChanges, doc)
+	}
+	return p
+}
+
+// hunkEdit converts a hunk to the edit that makes its change: its removed lines replaced by its added lines.
+// The hunk's context lines are left out, so that the edits of nearby hunks do not overlap.
+func hunkEdit(h staging.Hunk) TextEdit {
+	type line struct {
+		text      string
+		noNewline bool // the last line of a file that does not end in a newline
+	}
+	var removed, added []*line
+	lead := 0 // context lines before the change
+	var last *line
+	for i, text := range strings.Split(strings.TrimSuffix(h.Diff, "\n"), "\n") {
+		switch {
+		case i == 0: // the @@ header
+		case strings.HasPrefix(text, `\`):
+			if last != nil {
+				last.noNewline = true
+			}
+		case strings.HasPrefix(text, "-"):
+			last = &line{text: text[1:]}
+			removed = append(removed, last)
+		case strings.HasPrefix(text, "+"):
+			last = &line{text: text[1:]}
+			added = append(added, last)
+		default:
+			last = &line{text: strings.TrimPrefix(text, " ")}
+			if len(removed) == 0 && len(added) == 0 {
+				lead++
+			}
+		}
+	}
+	start := Position{Line: max(h.OldStart-1, 0) + lead}
+	end := Position{Line: start.Line + len(removed)}
+	if n := len(removed); n > 0 && removed[n-1].noNewline {
+		end = Position{Line: start.Line + n - 1, Character: utf16Len(removed[n-1].text)}
+	}
+	buf := new(strings.Builder)
+	for _, l := range added {
+		buf.WriteString(l.text)
+		if !l.noNewline {
+			buf.WriteString("\n")
+		}
+	}
+	return TextEdit{Range: Range{Start: start, End: end}, NewText: buf.String()}
+}
+
+// utf16Len returns the length of s in UTF-16 code units, as LSP positions count characters.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// fileURI returns the file: URI of the absolute path.
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -29,6 +29,10 @@ var (
 			Name:        "all",
 			Description: "Enable all experiments",
 		},
+		{
+			Name:        "quiet-rewrite",
+			Description: "Rewrite commands whose kind of output is often truncated to print less",
+		},
 	}
 	byName = map[string]*Experiment{}
 )
@@ -0,0 +1,273 @@
+// Package janitor removes the byproducts that sketch sessions leave behind:
+// expired artifacts, records of background jobs that have exited, stale code review worktrees,
+// and orphaned temporary directories. It keeps long-lived hosts clean.
+package janitor
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"sketch.dev/statedb"
+)
+
+// Prefixes of the temporary directories that sketch creates.
+const (
+	jobDirPrefix      = "sketch-bg-"                 // background job output without a state database; see claudetool's backgroundOutputDir
+	worktreeDirPrefix = "sketch-codereview-worktree" // see codereview's initializeInitialCommitWorktree
+	backupDirName     = "sketch-backups"             // see claudetool's PatchTool.BackupDir
+)
+
+// A Policy says how long byproducts are kept.
+// Ages are measured from a byproduct's creation, for artifacts,
+// and otherwise from the last modification of the directory or any file directly in it.
+type Policy struct {
+	// ArtifactAge is how long artifacts, such as backups and background job output, are kept.
+	ArtifactAge time.Duration
+	// WorktreeAge is how long code review worktrees are kept after their last use.
+	WorktreeAge time.Duration
+	// TempDirAge is how long temporary directories that no record refers to are kept.
+	TempDirAge time.Duration
+}
+
+// DefaultPolicy is the Policy used for zero fields of Janitor.Policy.
+var DefaultPolicy = Policy{
+	ArtifactAge: 7 * 24 * time.Hour,
+	WorktreeAge: 24 * time.Hour,
+	TempDirAge:  24 * time.Hour,
+}
+
+// A Janitor removes session byproducts according to a retention policy.
+type Janitor struct {
+	// State, if set, is the database whose job records and artifacts are collected.
+	State *statedb.DB
+	// Repo, if set, is a git repository whose worktree records are pruned
+	// after stale worktrees are removed.
+	Repo string
+	// TempDir is where sketch creates temporary directories. Empty means os.TempDir().
+	TempDir string
+	// Policy is the retention policy.
+	Policy Policy
+}
+
+// A Report counts what Collect removed.
+type Report struct {
+	Artifacts int // expired artifacts, including backups that were never indexed
+	Jobs      int // records of background jobs that have exited
+	Worktrees int // stale code review worktrees
+	TempDirs  int // orphaned temporary directories
+	Sessions  int // directories of sessions with no records left
+}
+
+// Empty reports whether nothing was removed.
+func (r Report) Empty() bool {
+	return r == Report{}
+}
+
+// Run calls Collect now and then every interval, until ctx is done.
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := j.Collect(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "janitor_collect_failed", "err", err)
+		}
+		if !report.Empty() {
+			slog.InfoContext(ctx, "janitor_collected", "artifacts", report.Artifacts, "jobs", report.Jobs, "worktrees", report.Worktrees, "temp_dirs", report.TempDirs, "sessions", report.Sessions)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect removes the byproducts that the policy no longer keeps.
+// It removes as much as it can, and reports what it removed along with any errors.
+// Output of background jobs that are still running is always kept.
+func (j *Janitor) Collect(ctx context.Context) (Report, error) {
+	policy := Policy{
+		ArtifactAge: cmp.Or(j.Policy.ArtifactAge, DefaultPolicy.ArtifactAge),
+		WorktreeAge: cmp.Or(j.Policy.WorktreeAge, DefaultPolicy.WorktreeAge),
+		TempDirAge:  cmp.Or(j.Policy.TempDirAge, DefaultPolicy.TempDirAge),
+	}
+	tmp := cmp.Or(j.TempDir, os.TempDir())
+	now := time.Now()
+	var report Report
+	var errs []error
+
+	// inUse holds the output directories of running jobs, and, once artifacts are collected,
+	// the directories of the artifacts that remain.
+	inUse := make(map[string]bool)
+	// active holds the IDs of sessions that still have records.
+	active := make(map[string]bool)
+	if j.State != nil {
+		jobs, err := j.State.Jobs()
+		errs = append(errs, err)
+		for _, job := range jobs {
+			if processAlive(job.PID) {
+				inUse[filepath.Dir(job.StdoutFile)] = true
+				active[job.Session] = true
+				continue
+			}
+			if err := j.State.RemoveJob(job.ID); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			report.Jobs++
+		}
+
+		artifacts, err := j.State.Artifacts("")
+		errs = append(errs, err)
+		var kept []string
+		for _, a := range artifacts {
+			if now.Sub(a.Created) < policy.ArtifactAge || inUse[filepath.Dir(a.Path)] {
+				kept = append(kept, filepath.Dir(a.Path))
+				active[a.Session] = true
+				continue
+			}
+			if err := os.Remove(a.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+				continue
+			}
+			if a.Kind == statedb.ArtifactJobOutput {
+				os.Remove(filepath.Dir(a.Path)) // only succeeds once the job's output is all gone
+			}
+			if err := j.State.RemoveArtifact(a.Path); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			report.Artifacts++
+		}
+		for _, dir := range kept {
+			inUse[dir] = true
+		}
+
+		sessions, err := j.State.SessionIDs()
+		errs = append(errs, err)
+		for _, id := range sessions {
+			dir, err := j.State.Session(id).Dir("")
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			// A session whose records have all expired is removed as a unit.
+			if !active[id] {
+				modified, err := lastModified(dir)
+				if err == nil && now.Sub(modified) >= policy.ArtifactAge {
+					if err := j.State.RemoveSession(id); err != nil {
+						errs = append(errs, err)
+						continue
+					}
+					report.Sessions++
+					continue
+				}
+			}
+			// Job output directories that no artifact refers to were left behind,
+			// for example by a crash before the job was recorded.
+			n, err := removeStale(filepath.Join(dir, "jobs"), "", now, policy.TempDirAge, inUse)
+			report.TempDirs += n
+			errs = append(errs, err)
+		}
+	}
+
+	n, err := removeStale(tmp, worktreeDirPrefix, now, policy.WorktreeAge, nil)
+	report.Worktrees += n
+	errs = append(errs, err)
+	if n > 0 && j.Repo != "" {
+		cmd := exec.CommandContext(ctx, "git", "worktree", "prune")
+		cmd.Dir = j.Repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("git worktree prune: %w\n%s", err, out))
+		}
+	}
+
+	n, err = removeStale(tmp, jobDirPrefix, now, policy.TempDirAge, inUse)
+	report.TempDirs += n
+	errs = append(errs, err)
+
+	// Backups made without a state database are not indexed; expire them by age.
+	n, err = removeStale(filepath.Join(tmp, backupDirName), "", now, policy.ArtifactAge, nil)
+	report.Artifacts += n
+	errs = append(errs, err)
+
+	return report, errors.Join(errs...)
+}
+
+// removeStale removes the entries of dir whose names start with prefix,
+// that are not in keep, and that were last modified at least maxAge before now.
+// It returns how many it removed.
+func removeStale(dir, prefix string, now time.Time, maxAge time.Duration, keep map[string]bool) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var errs []error
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if !strings.HasPrefix(e.Name(), prefix) || keep[path] {
+			continue
+		}
+		modified, err := lastModified(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if now.Sub(modified) < maxAge {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+	return removed, errors.Join(errs...)
+}
+
+// lastModified returns the latest modification time of path and,
+// if it is a directory, of the entries directly in it.
+func lastModified(path string) (time.Time, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := info.ModTime()
+	if !info.IsDir() {
+		return latest, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// processAlive reports whether a process with the given pid exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
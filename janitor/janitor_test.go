@@ -0,0 +1,134 @@
+package janitor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sketch.dev/statedb"
+)
+
+func TestCollect(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	db, err := statedb.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	mkdir := func(path string, mtime time.Time) string {
+		t.Helper()
+		if err := os.MkdirAll(path, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "stdout"), []byte("out"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{filepath.Join(path, "stdout"), path} {
+			if err := os.Chtimes(p, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return path
+	}
+
+	// A job that exited, and one that is still running (this test).
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	sess := db.Session("current")
+	newJob := func(pid int) string {
+		t.Helper()
+		id, dir, err := sess.NewJob()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mkdir(dir, old)
+		if pid == 0 {
+			return dir // never recorded
+		}
+		job := &statedb.Job{ID: id, PID: pid, StdoutFile: filepath.Join(dir, "stdout")}
+		if err := sess.AddJob(job); err != nil {
+			t.Fatal(err)
+		}
+		if err := sess.AddArtifact(statedb.Artifact{Path: job.StdoutFile, Kind: statedb.ArtifactJobOutput, Created: old}); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	deadOut := newJob(exited.Process.Pid)
+	liveOut := newJob(os.Getpid())
+	orphanOut := newJob(0)
+
+	// An old session with nothing left but its directory.
+	oldSession := db.Session("old")
+	_, oldOut, err := oldSession.NewJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mkdir(oldOut, old)
+	oldSessionDir := filepath.Dir(filepath.Dir(oldOut))
+	for _, dir := range []string{filepath.Dir(oldOut), oldSessionDir} {
+		if err := os.Chtimes(dir, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	freshBackup := filepath.Join(t.TempDir(), "main.go.1")
+	if err := os.WriteFile(freshBackup, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.AddArtifact(statedb.Artifact{Path: freshBackup, Kind: statedb.ArtifactBackup, Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	staleWorktree := mkdir(filepath.Join(tmp, "sketch-codereview-worktree123"), old)
+	freshWorktree := mkdir(filepath.Join(tmp, "sketch-codereview-worktree456"), time.Now())
+	staleTemp := mkdir(filepath.Join(tmp, "sketch-bg-789"), old)
+	unrelated := mkdir(filepath.Join(tmp, "other"), old)
+	staleBackup := mkdir(filepath.Join(tmp, "sketch-backups", "a.go.1"), old)
+
+	j := &Janitor{State: db, TempDir: tmp}
+	report, err := j.Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Report{Artifacts: 2, Jobs: 1, Worktrees: 1, TempDirs: 2, Sessions: 1}
+	if report != want {
+		t.Errorf("Collect = %+v, want %+v", report, want)
+	}
+	for path, wantExist := range map[string]bool{
+		deadOut:       false,
+		oldSessionDir: false,
+		liveOut:       true,
+		orphanOut:     false,
+		freshBackup:   true,
+		staleWorktree: false,
+		freshWorktree: true,
+		staleTemp:     false,
+		unrelated:     true,
+		staleBackup:   false,
+	} {
+		if _, err := os.Stat(path); (err == nil) != wantExist {
+			t.Errorf("%s exists = %v, want %v", path, err == nil, wantExist)
+		}
+	}
+	jobs, err := db.Jobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].PID != os.Getpid() {
+		t.Errorf("Jobs after Collect = %+v, want only the running job", jobs)
+	}
+
+	// Nothing is left to collect.
+	if report, err := j.Collect(ctx); err != nil || !report.Empty() {
+		t.Errorf("second Collect = %+v, %v; want nothing", report, err)
+	}
+}
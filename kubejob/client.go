@@ -0,0 +1,138 @@
+package kubejob
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// serviceAccountDir holds the credentials that Kubernetes mounts into every pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// A Client makes requests of the Kubernetes API server.
+type Client struct {
+	// URL is the API server's, such as https://10.0.0.1:443.
+	URL string
+	// HTTPClient makes the requests; if nil, http.DefaultClient.
+	HTTPClient *http.Client
+	// TokenFile, if set, holds the bearer token for requests. It is read for every request,
+	// as Kubernetes rotates the tokens it mounts into pods.
+	TokenFile string
+	// Namespace is the namespace of sketch's pod, where Jobs are created by default.
+	Namespace string
+}
+
+// InCluster returns a Client for the cluster that sketch's pod runs in,
+// authenticating as the pod's service account.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("%s/ca.crt has no certificates", serviceAccountDir)
+	}
+	namespace, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &Client{
+		URL:        "https://" + net.JoinHostPort(host, port),
+		HTTPClient: &http.Client{Transport: transport},
+		TokenFile:  serviceAccountDir + "/token",
+		Namespace:  strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// An APIError is an error status from the API server.
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Reason     string `json:"reason"` // such as NotFound or AlreadyExists
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("kubernetes: %s (%d %s)", e.Message, e.StatusCode, e.Reason)
+}
+
+// isNotFound reports whether err says that an object does not exist.
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// open sends a request with method to path, with body, if non-nil, as JSON,
+// and returns the response body if the request succeeded.
+func (c *Client) open(ctx context.Context, method, path string, body any) (io.ReadCloser, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.TokenFile != "" {
+		token, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return nil, apiErr
+	}
+	return resp.Body, nil
+}
+
+// do sends a request as open does, and decodes the JSON response into out, if non-nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	rc, err := c.open(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if out == nil {
+		_, err := io.Copy(io.Discard, rc)
+		return err
+	}
+	return json.NewDecoder(rc).Decode(out)
+}
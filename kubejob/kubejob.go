@@ -0,0 +1,479 @@
+// Package kubejob runs heavy commands, such as full builds and integration suites, as Kubernetes Jobs,
+// for sessions that run in a cluster with their workspace on a PersistentVolumeClaim.
+//
+// For each command, a Runner takes a VolumeSnapshot of the workspace's claim, restores it to a new claim,
+// and runs the command in a Job whose pod mounts that claim where sketch's pod mounts the workspace,
+// streaming the pod's log back as the command's output. Whatever the command changes stays in the snapshot:
+// the Job builds and tests the workspace as it was, but does not edit it. The snapshot, the claim,
+// and the Job are deleted when the command finishes, and the Job deletes itself if sketch does not.
+package kubejob
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pollInterval is how often a Runner checks on the snapshot and the Job's pod.
+var pollInterval = 2 * time.Second
+
+// keepFinished is how long a finished Job, and its pod, are kept if sketch does not delete them.
+const keepFinished = 10 * time.Minute
+
+// A Config says where and how a Runner runs Jobs.
+type Config struct {
+	// Image is the container image that commands run in. It must have bash. Required.
+	Image string `yaml:"image"`
+	// PVC is the PersistentVolumeClaim that holds the workspace. Required.
+	PVC string `yaml:"pvc"`
+	// MountPath is where sketch's pod mounts PVC, and where Jobs mount its snapshot. Required.
+	MountPath string `yaml:"mount_path"`
+	// Namespace is where PVC is, and where Jobs run; if empty, the namespace of sketch's pod.
+	Namespace string `yaml:"namespace"`
+	// SnapshotClass is the VolumeSnapshotClass of the snapshots; if empty, the cluster's default.
+	SnapshotClass string `yaml:"snapshot_class"`
+	// StorageClass is the StorageClass of the claims restored from snapshots; if empty, the cluster's default.
+	StorageClass string `yaml:"storage_class"`
+	// ServiceAccount is the service account that Jobs run as; if empty, the namespace's default.
+	ServiceAccount string `yaml:"service_account"`
+	// CPU and Memory are the resources that Jobs request, as Kubernetes quantities such as "8" and "16Gi".
+	CPU    string `yaml:"cpu"`
+	Memory string `yaml:"memory"`
+	// NodeSelector, if set, limits Jobs to the nodes with these labels.
+	NodeSelector map[string]string `yaml:"node_selector"`
+}
+
+// LoadConfig reads and validates the Job configuration file at path:
+//
+//	image: golang:1.24
+//	pvc: sketch-workspace
+//	mount_path: /workspace
+//	snapshot_class: csi-snapclass   # optional
+//	cpu: "8"                        # optional
+//	memory: 16Gi                    # optional
+//	node_selector:                  # optional
+//	  pool: builders
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// check reports what c is missing.
+func (c *Config) check() error {
+	var errs []error
+	if c.Image == "" {
+		errs = append(errs, errors.New("no image"))
+	}
+	if c.PVC == "" {
+		errs = append(errs, errors.New("no pvc"))
+	}
+	if !path.IsAbs(c.MountPath) {
+		errs = append(errs, fmt.Errorf("mount_path %q is not absolute", c.MountPath))
+	}
+	return errors.Join(errs...)
+}
+
+// A Runner runs commands as Kubernetes Jobs. It implements claudetool.Runner.
+type Runner struct {
+	Config Config
+	API    *Client
+}
+
+// New returns a Runner for config that creates Jobs in the cluster that sketch's pod runs in.
+func New(config Config) (*Runner, error) {
+	if err := config.check(); err != nil {
+		return nil, err
+	}
+	api, err := InCluster()
+	if err != nil {
+		return nil, err
+	}
+	config.MountPath = path.Clean(config.MountPath)
+	if config.Namespace == "" {
+		config.Namespace = api.Namespace
+	}
+	return &Runner{Config: config, API: api}, nil
+}
+
+// An ExitError reports that a Job's command failed.
+type ExitError struct {
+	Code   int
+	Reason string // why Kubernetes says the container ended, such as OOMKilled
+}
+
+func (e *ExitError) Error() string {
+	if e.Reason != "" && e.Reason != "Error" {
+		return fmt.Sprintf("exit status %d (%s)", e.Code, e.Reason)
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitStatus returns the command's exit status.
+func (e *ExitError) ExitStatus() int {
+	return e.Code
+}
+
+// Run runs command with bash in dir, which must be in the workspace, in a Job on a snapshot of the workspace,
+// with env (KEY=value) added to its environment, writing the Job's log to out, in a pseudo-terminal if tty is set,
+// until it exits or ctx is done. A command that fails returns an *ExitError.
+// When ctx is done, the Job is deleted, which stops the command.
+func (r *Runner) Run(ctx context.Context, dir, command string, env []string, tty bool, out io.Writer) error {
+	mount := r.Config.MountPath
+	if dir != mount && !strings.HasPrefix(dir, mount+"/") {
+		return fmt.Errorf("%s is not in the workspace at %s, which is all that Kubernetes Jobs see", dir, mount)
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := "sketch-job-" + hex.EncodeToString(suffix)
+	start := time.Now()
+	defer func() {
+		// Clean up even when ctx is done: that is how a Job is stopped.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		r.cleanUp(ctx, name)
+	}()
+
+	fmt.Fprintf(out, "[snapshotting %s for Job %s]\n", r.Config.PVC, name)
+	size, err := r.snapshot(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := r.createJob(ctx, name, size, dir, command, env, tty); err != nil {
+		return err
+	}
+	pod, err := r.waitForPod(ctx, name, out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "[running in pod %s]\n", pod)
+	if err := r.followLog(ctx, pod, out); err != nil && ctx.Err() == nil {
+		slog.WarnContext(ctx, "kubejob_log_failed", "pod", pod, "error", err)
+	}
+	err = r.waitForExit(ctx, pod)
+	slog.InfoContext(ctx, "kubejob_finished", "job", name, "pod", pod, "duration", time.Since(start), "error", err)
+	return err
+}
+
+// obj is a Kubernetes object, or part of one, to send to the API server.
+type obj = map[string]any
+
+// labels are the labels of everything a Runner creates.
+func (r *Runner) labels(name string) obj {
+	return obj{"app.kubernetes.io/managed-by": "sketch", "sketch.dev/job": name}
+}
+
+// nsPath returns the API path of the objects of a kind in the namespace of r's Jobs,
+// such as /api/v1/namespaces/default/pods for group "" and resource "pods".
+func (r *Runner) nsPath(group, resource string) string {
+	prefix := "/api/v1"
+	if group != "" {
+		prefix = "/apis/" + group
+	}
+	return prefix + "/namespaces/" + url.PathEscape(r.Config.Namespace) + "/" + resource
+}
+
+const snapshotGroup = "snapshot.storage.k8s.io/v1"
+
+// snapshot takes a snapshot of the workspace called name, restores it to a claim called name,
+// and returns the size of the snapshot.
+func (r *Runner) snapshot(ctx context.Context, name string) (string, error) {
+	spec := obj{"source": obj{"persistentVolumeClaimName": r.Config.PVC}}
+	if r.Config.SnapshotClass != "" {
+		spec["volumeSnapshotClassName"] = r.Config.SnapshotClass
+	}
+	snap := obj{
+		"apiVersion": snapshotGroup,
+		"kind":       "VolumeSnapshot",
+		"metadata":   obj{"name": name, "labels": r.labels(name)},
+		"spec":       spec,
+	}
+	if err := r.API.do(ctx, "POST", r.nsPath(snapshotGroup, "volumesnapshots"), snap, nil); err != nil {
+		return "", fmt.Errorf("failed to snapshot %s: %w", r.Config.PVC, err)
+	}
+	var size string
+	for {
+		var status struct {
+			Status struct {
+				ReadyToUse  bool   `json:"readyToUse"`
+				RestoreSize string `json:"restoreSize"`
+				Error       *struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			} `json:"status"`
+		}
+		if err := r.API.do(ctx, "GET", r.nsPath(snapshotGroup, "volumesnapshots")+"/"+name, nil, &status); err != nil {
+			return "", fmt.Errorf("failed to snapshot %s: %w", r.Config.PVC, err)
+		}
+		if e := status.Status.Error; e != nil && e.Message != "" {
+			return "", fmt.Errorf("failed to snapshot %s: %s", r.Config.PVC, e.Message)
+		}
+		if status.Status.ReadyToUse {
+			size = status.Status.RestoreSize
+			break
+		}
+		if err := sleep(ctx, pollInterval); err != nil {
+			return "", err
+		}
+	}
+
+	claimSpec := obj{
+		"accessModes": []string{"ReadWriteOnce"},
+		"resources":   obj{"requests": obj{"storage": size}},
+		"dataSource":  obj{"apiGroup": "snapshot.storage.k8s.io", "kind": "VolumeSnapshot", "name": name},
+	}
+	if r.Config.StorageClass != "" {
+		claimSpec["storageClassName"] = r.Config.StorageClass
+	}
+	claim := obj{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolumeClaim",
+		"metadata":   obj{"name": name, "labels": r.labels(name)},
+		"spec":       claimSpec,
+	}
+	if err := r.API.do(ctx, "POST", r.nsPath("", "persistentvolumeclaims"), claim, nil); err != nil {
+		return "", fmt.Errorf("failed to restore the snapshot of %s: %w", r.Config.PVC, err)
+	}
+	return size, nil
+}
+
+// createJob creates the Job called name, which runs command in dir on the claim called name.
+func (r *Runner) createJob(ctx context.Context, name, size, dir, command string, env []string, tty bool) error {
+	var envVars []obj
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		envVars = append(envVars, obj{"name": k, "value": v})
+	}
+	container := obj{
+		"name":         "command",
+		"image":        r.Config.Image,
+		"command":      []string{"bash", "-c", command},
+		"workingDir":   dir,
+		"env":          envVars,
+		"tty":          tty,
+		"volumeMounts": []obj{{"name": "workspace", "mountPath": r.Config.MountPath}},
+	}
+	requests := obj{}
+	if r.Config.CPU != "" {
+		requests["cpu"] = r.Config.CPU
+	}
+	if r.Config.Memory != "" {
+		requests["memory"] = r.Config.Memory
+	}
+	if len(requests) > 0 {
+		container["resources"] = obj{"requests": requests}
+	}
+	podSpec := obj{
+		"restartPolicy": "Never",
+		"containers":    []obj{container},
+		"volumes":       []obj{{"name": "workspace", "persistentVolumeClaim": obj{"claimName": name}}},
+	}
+	if r.Config.ServiceAccount != "" {
+		podSpec["serviceAccountName"] = r.Config.ServiceAccount
+	}
+	if len(r.Config.NodeSelector) > 0 {
+		podSpec["nodeSelector"] = r.Config.NodeSelector
+	}
+	jobSpec := obj{
+		"backoffLimit":            0,
+		"ttlSecondsAfterFinished": int(keepFinished.Seconds()),
+		"template":                obj{"metadata": obj{"labels": r.labels(name)}, "spec": podSpec},
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		// In case sketch is gone before it can delete the Job.
+		jobSpec["activeDeadlineSeconds"] = int(math.Ceil(time.Until(deadline).Seconds())) + 60
+	}
+	job := obj{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   obj{"name": name, "labels": r.labels(name)},
+		"spec":       jobSpec,
+	}
+	if err := r.API.do(ctx, "POST", r.nsPath("batch/v1", "jobs"), job, nil); err != nil {
+		return fmt.Errorf("failed to create Job: %w", err)
+	}
+	slog.InfoContext(ctx, "kubejob_created", "job", name, "namespace", r.Config.Namespace, "size", size, "dir", dir)
+	return nil
+}
+
+// A pod is the part of a pod's state that a Runner looks at.
+type pod struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Status struct {
+		Phase      string `json:"phase"`
+		Conditions []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+		ContainerStatuses []struct {
+			State struct {
+				Waiting *struct {
+					Reason  string `json:"reason"`
+					Message string `json:"message"`
+				} `json:"waiting"`
+				Running    *struct{} `json:"running"`
+				Terminated *struct {
+					ExitCode int    `json:"exitCode"`
+					Reason   string `json:"reason"`
+				} `json:"terminated"`
+			} `json:"state"`
+		} `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+// stuckReasons are the reasons for a container to wait that it will not get over by itself.
+var stuckReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// waitForPod waits until the pod of the Job called name has started its container, and returns the pod's name.
+// While the pod waits to be scheduled, it says why to out.
+func (r *Runner) waitForPod(ctx context.Context, name string, out io.Writer) (string, error) {
+	query := "?labelSelector=" + url.QueryEscape("job-name="+name)
+	var said string
+	for {
+		var pods struct {
+			Items []pod `json:"items"`
+		}
+		if err := r.API.do(ctx, "GET", r.nsPath("", "pods")+query, nil, &pods); err != nil {
+			return "", fmt.Errorf("failed to find the Job's pod: %w", err)
+		}
+		if len(pods.Items) == 0 {
+			if err := r.jobFailed(ctx, name); err != nil {
+				return "", err
+			}
+		}
+		for _, p := range pods.Items {
+			if p.Status.Phase != "Pending" {
+				return p.Metadata.Name, nil
+			}
+			for _, cs := range p.Status.ContainerStatuses {
+				if w := cs.State.Waiting; w != nil && stuckReasons[w.Reason] {
+					return "", fmt.Errorf("the Job's container cannot start: %s: %s", w.Reason, w.Message)
+				}
+			}
+			for _, c := range p.Status.Conditions {
+				if c.Type == "PodScheduled" && c.Status == "False" && c.Message != said {
+					fmt.Fprintf(out, "[waiting for a node: %s]\n", c.Message)
+					said = c.Message
+				}
+			}
+		}
+		if err := sleep(ctx, pollInterval); err != nil {
+			return "", err
+		}
+	}
+}
+
+// jobFailed returns an error if the Job called name has failed, such as when it cannot create its pod.
+func (r *Runner) jobFailed(ctx context.Context, name string) error {
+	var job struct {
+		Status struct {
+			Conditions []struct {
+				Type    string `json:"type"`
+				Status  string `json:"status"`
+				Message string `json:"message"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+	if err := r.API.do(ctx, "GET", r.nsPath("batch/v1", "jobs")+"/"+name, nil, &job); err != nil {
+		return fmt.Errorf("failed to check on the Job: %w", err)
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == "Failed" && c.Status == "True" {
+			return fmt.Errorf("the Job failed: %s", c.Message)
+		}
+	}
+	return nil
+}
+
+// followLog copies the log of pod's container to out until the container ends.
+func (r *Runner) followLog(ctx context.Context, pod string, out io.Writer) error {
+	rc, err := r.API.open(ctx, "GET", r.nsPath("", "pods")+"/"+pod+"/log?container=command&follow=true", nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(out, rc)
+	return err
+}
+
+// waitForExit waits until pod's container ends, and returns an *ExitError if it failed.
+func (r *Runner) waitForExit(ctx context.Context, name string) error {
+	for {
+		var p pod
+		if err := r.API.do(ctx, "GET", r.nsPath("", "pods")+"/"+name, nil, &p); err != nil {
+			return fmt.Errorf("failed to check on the Job's pod: %w", err)
+		}
+		for _, cs := range p.Status.ContainerStatuses {
+			if t := cs.State.Terminated; t != nil {
+				if t.ExitCode != 0 {
+					return &ExitError{Code: t.ExitCode, Reason: t.Reason}
+				}
+				return nil
+			}
+		}
+		if p.Status.Phase == "Failed" {
+			return errors.New("the Job's pod failed before its command ended")
+		}
+		if err := sleep(ctx, pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanUp deletes the Job, claim, and snapshot called name, and the Job's pod.
+func (r *Runner) cleanUp(ctx context.Context, name string) {
+	for _, p := range []string{
+		r.nsPath("batch/v1", "jobs") + "/" + name + "?propagationPolicy=Background",
+		r.nsPath("", "persistentvolumeclaims") + "/" + name,
+		r.nsPath(snapshotGroup, "volumesnapshots") + "/" + name,
+	} {
+		if err := r.API.do(ctx, "DELETE", p, nil, nil); err != nil && !isNotFound(err) {
+			slog.WarnContext(ctx, "kubejob_cleanup_failed", "path", p, "error", err)
+		}
+	}
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,177 @@
+package kubejob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCluster is an API server that keeps the objects a Runner creates,
+// with snapshots that are ready at once and pods that run as soon as their Job is created.
+type fakeCluster struct {
+	exitCode int
+	log      string
+
+	mu      sync.Mutex
+	created map[string]map[string]any // by API path
+	deleted []string
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := r.URL.Path
+	switch {
+	case r.Method == "POST":
+		var o map[string]any
+		json.NewDecoder(r.Body).Decode(&o)
+		name := o["metadata"].(map[string]any)["name"].(string)
+		c.created[p+"/"+name] = o
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(o)
+	case r.Method == "DELETE":
+		c.deleted = append(c.deleted, p)
+		if _, ok := c.created[p]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"kind":"Status","reason":"NotFound","message":"not found"}`)
+		}
+	case strings.Contains(p, "/volumesnapshots/"):
+		io.WriteString(w, `{"status":{"readyToUse":true,"restoreSize":"5Gi"}}`)
+	case strings.HasSuffix(p, "/pods"):
+		io.WriteString(w, `{"items":[{"metadata":{"name":"job-pod"},"status":{"phase":"Running"}}]}`)
+	case strings.HasSuffix(p, "/pods/job-pod/log"):
+		io.WriteString(w, c.log)
+	case strings.HasSuffix(p, "/pods/job-pod"):
+		json.NewEncoder(w).Encode(map[string]any{"status": map[string]any{
+			"phase":             "Succeeded",
+			"containerStatuses": []any{map[string]any{"state": map[string]any{"terminated": map[string]any{"exitCode": c.exitCode}}}},
+		}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// find returns the created object whose path has prefix.
+func (c *fakeCluster) find(t *testing.T, prefix string) map[string]any {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p, o := range c.created {
+		if strings.HasPrefix(p, prefix) {
+			return o
+		}
+	}
+	t.Fatalf("nothing created under %s", prefix)
+	return nil
+}
+
+func newTestRunner(t *testing.T, cluster *fakeCluster) *Runner {
+	t.Helper()
+	pollInterval = time.Millisecond
+	cluster.created = make(map[string]map[string]any)
+	srv := httptest.NewServer(cluster)
+	t.Cleanup(srv.Close)
+	token := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(token, []byte("secret\n"), 0o600)
+	return &Runner{
+		Config: Config{Image: "golang:1.24", PVC: "workspace", MountPath: "/workspace", Namespace: "ci", CPU: "8"},
+		API:    &Client{URL: srv.URL, TokenFile: token},
+	}
+}
+
+func TestRun(t *testing.T) {
+	cluster := &fakeCluster{log: "ok  \texample.com/app\n"}
+	r := newTestRunner(t, cluster)
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := r.Run(ctx, "/workspace/app", "go test ./...", []string{"SKETCH=1", "GOFLAGS=-count=1"}, true, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "[running in pod job-pod]\n"+cluster.log) {
+		t.Errorf("output = %q", out.String())
+	}
+
+	snap := cluster.find(t, "/apis/snapshot.storage.k8s.io/v1/namespaces/ci/volumesnapshots/")
+	name := snap["metadata"].(map[string]any)["name"].(string)
+	if got := snap["spec"].(map[string]any)["source"].(map[string]any)["persistentVolumeClaimName"]; got != "workspace" {
+		t.Errorf("snapshot source = %v", got)
+	}
+	claim := cluster.find(t, "/api/v1/namespaces/ci/persistentvolumeclaims/")
+	claimSpec := claim["spec"].(map[string]any)
+	if claimSpec["dataSource"].(map[string]any)["name"] != name || claimSpec["resources"].(map[string]any)["requests"].(map[string]any)["storage"] != "5Gi" {
+		t.Errorf("claim spec = %v", claimSpec)
+	}
+
+	job := cluster.find(t, "/apis/batch/v1/namespaces/ci/jobs/")
+	jobSpec := job["spec"].(map[string]any)
+	if d, _ := jobSpec["activeDeadlineSeconds"].(float64); d < 60 || d > 121 {
+		t.Errorf("activeDeadlineSeconds = %v", jobSpec["activeDeadlineSeconds"])
+	}
+	podSpec := jobSpec["template"].(map[string]any)["spec"].(map[string]any)
+	container := podSpec["containers"].([]any)[0].(map[string]any)
+	data, _ := json.Marshal(container)
+	want := `{"command":["bash","-c","go test ./..."],"env":[{"name":"SKETCH","value":"1"},{"name":"GOFLAGS","value":"-count=1"}],` +
+		`"image":"golang:1.24","name":"command","resources":{"requests":{"cpu":"8"}},"tty":true,` +
+		`"volumeMounts":[{"mountPath":"/workspace","name":"workspace"}],"workingDir":"/workspace/app"}`
+	if string(data) != want {
+		t.Errorf("container = %s\nwant %s", data, want)
+	}
+	if claimName := podSpec["volumes"].([]any)[0].(map[string]any)["persistentVolumeClaim"].(map[string]any)["claimName"]; claimName != name {
+		t.Errorf("volume claim = %v, want %s", claimName, name)
+	}
+
+	for _, p := range []string{
+		"/apis/batch/v1/namespaces/ci/jobs/" + name,
+		"/api/v1/namespaces/ci/persistentvolumeclaims/" + name,
+		"/apis/snapshot.storage.k8s.io/v1/namespaces/ci/volumesnapshots/" + name,
+	} {
+		if !slices.Contains(cluster.deleted, p) {
+			t.Errorf("%s was not deleted; deleted %q", p, cluster.deleted)
+		}
+	}
+}
+
+func TestRunFails(t *testing.T) {
+	cluster := &fakeCluster{exitCode: 2, log: "FAIL\n"}
+	r := newTestRunner(t, cluster)
+	var exitErr *ExitError
+	if err := r.Run(context.Background(), "/workspace", "make", nil, false, io.Discard); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 2 {
+		t.Errorf("Run of a failing command = %v, want exit status 2", err)
+	}
+	if err := r.Run(context.Background(), "/home/me", "make", nil, false, io.Discard); err == nil {
+		t.Errorf("Run outside the workspace succeeded")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.yaml")
+	os.WriteFile(good, []byte("image: golang:1.24\npvc: workspace\nmount_path: /workspace\nnode_selector:\n  pool: builders\n"), 0o600)
+	c, err := LoadConfig(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Image != "golang:1.24" || c.NodeSelector["pool"] != "builders" {
+		t.Errorf("LoadConfig = %+v", c)
+	}
+
+	bad := filepath.Join(dir, "bad.yaml")
+	os.WriteFile(bad, []byte("image: golang:1.24\nmount_path: workspace\n"), 0o600)
+	_, err = LoadConfig(bad)
+	if err == nil || !strings.Contains(err.Error(), "no pvc") || !strings.Contains(err.Error(), "not absolute") {
+		t.Errorf("LoadConfig of a bad file = %v", err)
+	}
+}
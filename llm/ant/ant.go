@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"math/rand/v2"
 	"net/http"
 	"strings"
 	"testing"
@@ -36,22 +35,19 @@ const (
 
 // TokenContextWindow returns the maximum token context window size for this service
 func (s *Service) TokenContextWindow() int {
-	model := s.Model
-	if model == "" {
-		model = DefaultModel
-	}
-
-	switch model {
-	case Claude35Sonnet, Claude37Sonnet:
-		return 200000
-	case Claude35Haiku:
-		return 200000
-	case Claude4Sonnet, Claude4Opus:
-		return 200000
-	default:
-		// Default for unknown models
-		return 200000
+	return s.Capabilities().ContextWindow
+}
+
+// Capabilities returns the capabilities of s.Model, from the llm capability registry.
+func (s *Service) Capabilities() llm.Capabilities {
+	if caps, ok := llm.CapabilitiesFor(cmp.Or(s.Model, DefaultModel)); ok {
+		return caps
 	}
+	// All Claude models accept images and have at least a 200k context window.
+	caps := llm.DefaultCapabilities
+	caps.ContextWindow = 200000
+	caps.Vision = true
+	return caps
 }
 
 // Service provides Claude completions.
@@ -59,9 +55,26 @@ func (s *Service) TokenContextWindow() int {
 type Service struct {
 	HTTPC     *http.Client // defaults to http.DefaultClient if nil
 	URL       string       // defaults to DefaultURL if empty
-	APIKey    string       // must be non-empty
+	APIKey    string       // must be non-empty, unless Keys is set
+	Keys      *llm.KeyPool // if set, used instead of APIKey, rotating keys on rate limits
 	Model     string       // defaults to DefaultModel if empty
 	MaxTokens int          // defaults to DefaultMaxTokens if zero
+
+	// Platform, if set, sends requests through a cloud platform such as Bedrock or Vertex AI
+	// instead of to URL, and authenticates them with the platform's credentials instead of an API key.
+	Platform Platform
+
+	// Stream requests streaming responses, so that text and tool input reach
+	// the callbacks installed with llm.WithTextDeltaFunc and llm.WithPartialToolUseFunc as they are generated.
+	// Requests sent through a Platform are not streamed.
+	Stream bool
+}
+
+func (s *Service) apiKey() string {
+	if s.Keys != nil {
+		return s.Keys.Key()
+	}
+	return s.APIKey
 }
 
 var _ llm.Service = (*Service)(nil)
@@ -144,9 +157,10 @@ type tool struct {
 	Name string `json:"name"`
 	// Type is used by the text editor tool; see
 	// https://docs.anthropic.com/en/docs/build-with-claude/tool-use/text-editor-tool
-	Type        string          `json:"type,omitempty"`
-	Description string          `json:"description,omitempty"`
-	InputSchema json.RawMessage `json:"input_schema,omitempty"`
+	Type         string          `json:"type,omitempty"`
+	Description  string          `json:"description,omitempty"`
+	InputSchema  json.RawMessage `json:"input_schema,omitempty"`
+	CacheControl json.RawMessage `json:"cache_control,omitempty"`
 }
 
 // usage represents the billing and rate-limit usage.
@@ -338,10 +352,11 @@ func fromLLMToolChoice(tc *llm.ToolChoice) *toolChoice {
 
 func fromLLMTool(t *llm.Tool) *tool {
 	return &tool{
-		Name:        t.Name,
-		Type:        t.Type,
-		Description: t.Description,
-		InputSchema: t.InputSchema,
+		Name:         t.Name,
+		Type:         t.Type,
+		Description:  t.Description,
+		InputSchema:  t.InputSchema,
+		CacheControl: fromLLMCache(t.Cache),
 	}
 }
 
@@ -353,15 +368,39 @@ func fromLLMSystem(s llm.SystemContent) systemContent {
 	}
 }
 
+// structuredOutputTool is the name of the tool used to get structured output from Claude,
+// which has no JSON mode: the model is required to call it, with the output schema as its input schema.
+const structuredOutputTool = "structured_output"
+
 func (s *Service) fromLLMRequest(r *llm.Request) *request {
-	return &request{
+	req := &request{
 		Model:      cmp.Or(s.Model, DefaultModel),
 		Messages:   mapped(r.Messages, fromLLMMessage),
-		MaxTokens:  cmp.Or(s.MaxTokens, DefaultMaxTokens),
+		MaxTokens:  cmp.Or(s.MaxTokens, min(DefaultMaxTokens, s.Capabilities().MaxOutputTokens)),
 		ToolChoice: fromLLMToolChoice(r.ToolChoice),
 		Tools:      mapped(r.Tools, fromLLMTool),
 		System:     mapped(r.System, fromLLMSystem),
 	}
+	if r.OutputSchema != nil {
+		req.Tools = append(req.Tools, &tool{
+			Name:        structuredOutputTool,
+			Description: "Respond with your output. Its input is your complete response.",
+			InputSchema: r.OutputSchema,
+		})
+		req.ToolChoice = &toolChoice{Type: "tool", Name: structuredOutputTool}
+	}
+	return req
+}
+
+// toStructuredOutput replaces a call to structuredOutputTool in resp with its input, as text.
+func toStructuredOutput(resp *llm.Response) {
+	for _, c := range resp.Content {
+		if c.Type == llm.ContentTypeToolUse && c.ToolName == structuredOutputTool {
+			resp.Content = []llm.Content{llm.StringContent(string(c.ToolInput))}
+			resp.StopReason = llm.StopReasonEndTurn
+			return
+		}
+	}
 }
 
 func toLLMUsage(u usage) llm.Usage {
@@ -416,9 +455,37 @@ func toLLMResponse(r *response) *llm.Response {
 	}
 }
 
+// newRequest returns an HTTP request sending payload to Anthropic, through s.Platform if set,
+// and the API key it carries, if any.
+func (s *Service) newRequest(ctx context.Context, model string, payload []byte, features []string) (*http.Request, string, error) {
+	if s.Platform != nil {
+		req, err := s.Platform.NewRequest(ctx, model, payload, features)
+		return req, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", cmp.Or(s.URL, DefaultURL), bytes.NewReader(payload))
+	if err != nil {
+		return nil, "", err
+	}
+	return req, s.setHeaders(req, features), nil
+}
+
+// setHeaders sets the headers of a request to Anthropic's API, with the beta features it uses,
+// and returns the API key it carries.
+func (s *Service) setHeaders(req *http.Request, features []string) string {
+	apiKey := s.apiKey()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+	req.Header.Set("Anthropic-Version", "2023-06-01")
+	if len(features) > 0 {
+		req.Header.Set("anthropic-beta", strings.Join(features, ","))
+	}
+	return apiKey
+}
+
 // Do sends a request to Anthropic.
 func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error) {
 	request := s.fromLLMRequest(ir)
+	request.Stream = s.Stream && s.Platform == nil
 
 	var payload []byte
 	var err error
@@ -436,36 +503,36 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 		fmt.Printf("claude request payload:\n%s\n", payload)
 	}
 
-	backoff := []time.Duration{15 * time.Second, 30 * time.Second, time.Minute}
+	backoff := llm.Backoff{Base: 15 * time.Second, Max: time.Minute}
 	largerMaxTokens := false
 	var partialUsage usage
 
-	url := cmp.Or(s.URL, DefaultURL)
 	httpc := cmp.Or(s.HTTPC, http.DefaultClient)
 
 	// retry loop
-	var errs error // accumulated errors across all attempts
-	for attempts := 0; ; attempts++ {
-		if attempts > 10 {
-			return nil, fmt.Errorf("anthropic request failed after %d attempts: %w", attempts, errs)
-		}
-		if attempts > 0 {
-			sleep := backoff[min(attempts, len(backoff)-1)] + time.Duration(rand.Int64N(int64(time.Second)))
-			slog.WarnContext(ctx, "anthropic request sleep before retry", "sleep", sleep, "attempts", attempts)
-			time.Sleep(sleep)
+	var errs error    // accumulated errors across all attempts
+	var lastErr error // the retryable error from the last attempt, if it failed
+	retries := 0
+	rotated := false // whether the last attempt switched to a fresh key, so there's no need to wait
+	for {
+		if lastErr != nil {
+			if retries >= llm.RetryLimit(ctx, 10) {
+				return nil, fmt.Errorf("anthropic request failed after %d attempts: %w", retries+1, errs)
+			}
+			retries++
+			if !rotated {
+				sleep := backoff.Delay(retries, lastErr)
+				slog.WarnContext(ctx, "anthropic_request_retry", "sleep", sleep, "retries", retries, "error", lastErr)
+				if err := llm.Sleep(ctx, sleep); err != nil {
+					return nil, errors.Join(errs, err)
+				}
+			}
+			lastErr = nil
 		}
 		if dumpText {
 			fmt.Printf("RAW REQUEST:\n%s\n\n", payload)
 		}
-		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
-		if err != nil {
-			return nil, errors.Join(errs, err)
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-API-Key", s.APIKey)
-		req.Header.Set("Anthropic-Version", "2023-06-01")
-
+		rotated = false
 		var features []string
 		if request.TokenEfficientToolUse {
 			features = append(features, "token-efficient-tool-use-2025-02-19")
@@ -474,34 +541,53 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 			features = append(features, "output-128k-2025-02-19")
 			request.MaxTokens = 128 * 1024
 		}
-		if len(features) > 0 {
-			req.Header.Set("anthropic-beta", strings.Join(features, ","))
+
+		req, apiKey, err := s.newRequest(ctx, request.Model, payload, features)
+		if err != nil {
+			return nil, errors.Join(errs, err)
 		}
 
 		resp, err := httpc.Do(req)
 		if err != nil {
 			// Don't retry httprr cache misses
-			if strings.Contains(err.Error(), "cached HTTP response not found") {
-				return nil, err
+			if strings.Contains(err.Error(), "cached HTTP response not found") || !llm.IsRetryable(err) {
+				return nil, errors.Join(err, errs)
 			}
 			errs = errors.Join(errs, err)
+			lastErr = err
 			continue
 		}
-		buf, err := io.ReadAll(resp.Body)
+		var buf []byte
+		var streamed *response
+		if request.Stream && resp.StatusCode == http.StatusOK {
+			streamed, err = readStream(ctx, resp.Body)
+		} else {
+			buf, err = io.ReadAll(resp.Body)
+		}
 		resp.Body.Close()
 		if err != nil {
+			if errors.Is(err, llm.ErrStalled) {
+				slog.WarnContext(ctx, "anthropic_stream_stalled", "error", err)
+			}
+			if serr := (*llm.StatusError)(nil); errors.As(err, &serr) {
+				slog.WarnContext(ctx, "anthropic_stream_error", "status_code", serr.StatusCode, "message", serr.Message)
+			}
+			if !llm.IsRetryable(err) {
+				return nil, errors.Join(err, errs)
+			}
 			errs = errors.Join(errs, err)
+			lastErr = err
 			continue
 		}
 
-		switch {
-		case resp.StatusCode == http.StatusOK:
+		if resp.StatusCode == http.StatusOK {
 			if dumpText {
 				fmt.Printf("RAW RESPONSE:\n%s\n\n", buf)
 			}
 			var response response
-			err = json.NewDecoder(bytes.NewReader(buf)).Decode(&response)
-			if err != nil {
+			if streamed != nil {
+				response = *streamed
+			} else if err := json.NewDecoder(bytes.NewReader(buf)).Decode(&response); err != nil {
 				return nil, errors.Join(errs, err)
 			}
 			if response.StopReason == "max_tokens" && !largerMaxTokens {
@@ -518,27 +604,33 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 				response.Usage.Add(partialUsage)
 			}
 			response.Usage.CostUSD = llm.CostUSDFromResponse(resp.Header)
+			if s.Keys != nil {
+				s.Keys.Record(apiKey, toLLMUsage(response.Usage))
+			}
 
-			return toLLMResponse(&response), nil
-		case resp.StatusCode >= 500 && resp.StatusCode < 600:
-			// server error, retry
-			slog.WarnContext(ctx, "anthropic_request_failed", "response", string(buf), "status_code", resp.StatusCode)
-			errs = errors.Join(errs, fmt.Errorf("status %v: %s", resp.Status, buf))
-			continue
-		case resp.StatusCode == 429:
-			// rate limited, retry
-			slog.WarnContext(ctx, "anthropic_request_rate_limited", "response", string(buf))
-			errs = errors.Join(errs, fmt.Errorf("status %v: %s", resp.Status, buf))
-			continue
-		case resp.StatusCode >= 400 && resp.StatusCode < 500:
-			// some other 400, probably unrecoverable
-			slog.WarnContext(ctx, "anthropic_request_failed", "response", string(buf), "status_code", resp.StatusCode)
-			return nil, errors.Join(errs, fmt.Errorf("status %v: %s", resp.Status, buf))
-		default:
-			// ...retry, I guess?
+			llmResp := toLLMResponse(&response)
+			llmResp.Usage.Retries = uint64(retries)
+			if ir.OutputSchema != nil {
+				toStructuredOutput(llmResp)
+			}
+			return llmResp, nil
+		}
+
+		serr := &llm.StatusError{StatusCode: resp.StatusCode, Message: string(buf), RetryAfter: llm.RetryAfter(resp.Header)}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			slog.WarnContext(ctx, "anthropic_request_rate_limited", "response", string(buf), "retry_after", serr.RetryAfter)
+			if s.Keys != nil {
+				rotated = s.Keys.RateLimited(apiKey, serr.RetryAfter)
+			}
+		} else {
 			slog.WarnContext(ctx, "anthropic_request_failed", "response", string(buf), "status_code", resp.StatusCode)
-			errs = errors.Join(errs, fmt.Errorf("status %v: %s", resp.Status, buf))
-			continue
 		}
+		if !serr.Retryable() {
+			// an invalid request, probably unrecoverable
+			// Put it first, so that errors.As finds it rather than an earlier, retryable failure.
+			return nil, errors.Join(serr, errs)
+		}
+		errs = errors.Join(errs, serr)
+		lastErr = serr
 	}
 }
@@ -0,0 +1,69 @@
+package ant
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestKeyRotation(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		keys = append(keys, key)
+		if key == "exhausted-key" {
+			w.Header().Set("Retry-After", "600")
+			http.Error(w, `{"type":"error","error":{"type":"rate_limit_error"}}`, http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514",
+			"content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn",
+			"usage":{"input_tokens":3,"output_tokens":1}}`))
+	}))
+	defer srv.Close()
+
+	pool := llm.NewKeyPool("exhausted-key", "fresh-key")
+	s := &Service{URL: srv.URL, Keys: pool}
+	resp, err := s.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hello")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Usage.InputTokens != 3 || resp.Usage.Retries != 1 {
+		t.Errorf("Usage = %+v", resp.Usage)
+	}
+	if len(keys) != 2 || keys[0] != "exhausted-key" || keys[1] != "fresh-key" {
+		t.Errorf("requests used keys %q, want exhausted-key then fresh-key", keys)
+	}
+	usage := pool.Usage()
+	if usage[0].RateLimits != 1 || usage[1].Requests != 1 || usage[1].Usage.InputTokens != 3 {
+		t.Errorf("pool usage = %+v", usage)
+	}
+}
+
+func TestRetryAfterThenPermanentError(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After-Ms", "10")
+			http.Error(w, `{"type":"error","error":{"type":"overloaded_error"}}`, 529)
+			return
+		}
+		http.Error(w, `{"type":"error","error":{"type":"invalid_request_error"}}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	s := &Service{URL: srv.URL, APIKey: "key"}
+	_, err := s.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hello")}})
+	if serr := (*llm.StatusError)(nil); !errors.As(err, &serr) || serr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Do err = %v, want the 400", err)
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests, want a retry of the 529 and none of the 400", requests)
+	}
+}
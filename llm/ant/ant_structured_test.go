@@ -0,0 +1,41 @@
+package ant
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestStructuredOutput(t *testing.T) {
+	var req request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514",
+			"content":[{"type":"tool_use","id":"toolu_1","name":"structured_output","input":{"ok":true}}],
+			"stop_reason":"tool_use","usage":{"input_tokens":3,"output_tokens":1}}`))
+	}))
+	defer srv.Close()
+
+	schema := llm.MustSchema(`{"type":"object","properties":{"ok":{"type":"boolean"}}}`)
+	s := &Service{URL: srv.URL, APIKey: "key"}
+	resp, err := s.Do(context.Background(), &llm.Request{
+		Messages:     []llm.Message{llm.UserStringMessage("ok?")},
+		OutputSchema: schema,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ToolChoice == nil || req.ToolChoice.Name != structuredOutputTool || len(req.Tools) != 1 {
+		t.Errorf("request did not force the structured output tool: %+v", req)
+	}
+	if resp.StopReason != llm.StopReasonEndTurn || len(resp.Content) != 1 || resp.Content[0].Text != `{"ok":true}` {
+		t.Errorf("response = %+v, want JSON text", resp)
+	}
+}
@@ -0,0 +1,202 @@
+package ant
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+var _ llm.Batcher = (*Service)(nil)
+
+// batchDiscount is the fraction of the list price that batched requests cost.
+const batchDiscount = 0.5
+
+// See https://docs.anthropic.com/en/api/creating-message-batches
+type batchRequest struct {
+	CustomID string   `json:"custom_id"`
+	Params   *request `json:"params"`
+}
+
+type batch struct {
+	ID               string `json:"id"`
+	ProcessingStatus string `json:"processing_status"` // in_progress, canceling, or ended
+	RequestCounts    struct {
+		Processing int `json:"processing"`
+		Succeeded  int `json:"succeeded"`
+		Errored    int `json:"errored"`
+		Canceled   int `json:"canceled"`
+		Expired    int `json:"expired"`
+	} `json:"request_counts"`
+	ResultsURL string `json:"results_url"`
+}
+
+// batchResult is a line of a batch's results.
+type batchResult struct {
+	CustomID string `json:"custom_id"`
+	Result   struct {
+		Type    string    `json:"type"` // succeeded, errored, canceled, or expired
+		Message *response `json:"message"`
+		Error   struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"error"`
+	} `json:"result"`
+}
+
+// SubmitBatch implements llm.Batcher with Anthropic's Message Batches API.
+// Batches cannot be sent through a Platform.
+func (s *Service) SubmitBatch(ctx context.Context, reqs []llm.BatchRequest) (string, error) {
+	if s.Platform != nil {
+		return "", fmt.Errorf("anthropic batches are not supported through %T", s.Platform)
+	}
+	params := make([]batchRequest, len(reqs))
+	for i, r := range reqs {
+		params[i] = batchRequest{CustomID: r.ID, Params: s.fromLLMRequest(r.Request)}
+	}
+	var b batch
+	if err := s.doJSON(ctx, "POST", s.apiURL("batches"), map[string]any{"requests": params}, &b); err != nil {
+		return "", err
+	}
+	return b.ID, nil
+}
+
+// BatchStatus implements llm.Batcher.
+func (s *Service) BatchStatus(ctx context.Context, id string) (llm.BatchStatus, error) {
+	var b batch
+	if err := s.doJSON(ctx, "GET", s.apiURL("batches", id), nil, &b); err != nil {
+		return llm.BatchStatus{}, err
+	}
+	return llm.BatchStatus{
+		Ended:      b.ProcessingStatus == "ended",
+		Processing: b.RequestCounts.Processing,
+		Succeeded:  b.RequestCounts.Succeeded,
+		Errored:    b.RequestCounts.Errored,
+		Canceled:   b.RequestCounts.Canceled,
+		Expired:    b.RequestCounts.Expired,
+	}, nil
+}
+
+// BatchResults implements llm.Batcher.
+// Since Anthropic does not report the cost of batched requests, it is estimated from the model's list price.
+func (s *Service) BatchResults(ctx context.Context, id string) ([]llm.BatchResult, error) {
+	var b batch
+	if err := s.doJSON(ctx, "GET", s.apiURL("batches", id), nil, &b); err != nil {
+		return nil, err
+	}
+	if b.ProcessingStatus != "ended" {
+		return nil, fmt.Errorf("anthropic batch %s has not ended", id)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", cmp.Or(b.ResultsURL, s.apiURL("batches", id, "results")), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.setHeaders(req, nil)
+	resp, err := cmp.Or(s.HTTPC, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic batch results: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		buf, _ := io.ReadAll(resp.Body)
+		return nil, &llm.StatusError{StatusCode: resp.StatusCode, Message: string(buf), RetryAfter: llm.RetryAfter(resp.Header)}
+	}
+
+	var results []llm.BatchResult
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var r batchResult
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("anthropic batch results: %w", err)
+		}
+		results = append(results, toLLMBatchResult(r))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("anthropic batch results: %w", err)
+	}
+	return results, nil
+}
+
+// CancelBatch implements llm.Batcher.
+func (s *Service) CancelBatch(ctx context.Context, id string) error {
+	return s.doJSON(ctx, "POST", s.apiURL("batches", id, "cancel"), nil, nil)
+}
+
+func toLLMBatchResult(r batchResult) llm.BatchResult {
+	result := llm.BatchResult{ID: r.CustomID}
+	switch r.Result.Type {
+	case "succeeded":
+		if r.Result.Message == nil {
+			result.Err = errors.New("anthropic batch request succeeded without a message")
+			break
+		}
+		resp := toLLMResponse(r.Result.Message)
+		if p, ok := llm.PricingFor(resp.Model); ok {
+			resp.Usage.CostUSD = batchDiscount * p.Cost(resp.Usage)
+		}
+		toStructuredOutput(resp)
+		result.Response = resp
+	case "errored":
+		e := r.Result.Error.Error
+		result.Err = fmt.Errorf("anthropic batch request failed: %s: %s", e.Type, e.Message)
+	default:
+		result.Err = fmt.Errorf("anthropic batch request %s", r.Result.Type)
+	}
+	return result
+}
+
+// apiURL returns the URL of an endpoint under the Messages API, such as its batches, with elem appended to its path.
+func (s *Service) apiURL(elem ...string) string {
+	return strings.Join(append([]string{strings.TrimSuffix(cmp.Or(s.URL, DefaultURL), "/")}, elem...), "/")
+}
+
+// doJSON sends body, if not nil, to Anthropic's API at url as JSON,
+// and decodes the response into out, if not nil.
+func (s *Service) doJSON(ctx context.Context, method, url string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return err
+	}
+	s.setHeaders(req, nil)
+	resp, err := cmp.Or(s.HTTPC, http.DefaultClient).Do(req)
+	if err != nil {
+		return fmt.Errorf("anthropic: %w", err)
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("anthropic: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &llm.StatusError{StatusCode: resp.StatusCode, Message: string(buf), RetryAfter: llm.RetryAfter(resp.Header)}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(buf, out); err != nil {
+		return fmt.Errorf("anthropic: %w", err)
+	}
+	return nil
+}
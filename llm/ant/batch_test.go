@@ -0,0 +1,92 @@
+package ant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+func TestBatch(t *testing.T) {
+	var submitted struct {
+		Requests []batchRequest `json:"requests"`
+	}
+	polls := 0
+	canceled := false
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "key" {
+			t.Errorf("%s %s without the API key", r.Method, r.URL.Path)
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/messages/batches":
+			if err := json.NewDecoder(r.Body).Decode(&submitted); err != nil {
+				t.Error(err)
+			}
+			fmt.Fprint(w, `{"id":"msgbatch_1","processing_status":"in_progress","request_counts":{"processing":3}}`)
+		case r.Method == "GET" && r.URL.Path == "/v1/messages/batches/msgbatch_1":
+			polls++
+			if polls < 3 {
+				fmt.Fprint(w, `{"id":"msgbatch_1","processing_status":"in_progress","request_counts":{"processing":3}}`)
+				return
+			}
+			fmt.Fprintf(w, `{"id":"msgbatch_1","processing_status":"ended","request_counts":{"succeeded":1,"errored":1,"expired":1},"results_url":"%s/results/msgbatch_1"}`, srv.URL)
+		case r.Method == "GET" && r.URL.Path == "/results/msgbatch_1":
+			fmt.Fprint(w, `{"custom_id":"pkg-b","result":{"type":"errored","error":{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long"}}}}
+{"custom_id":"pkg-a","result":{"type":"succeeded","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"text","text":"Parses flags."}],"stop_reason":"end_turn","usage":{"input_tokens":1000000,"output_tokens":0}}}}
+{"custom_id":"pkg-c","result":{"type":"expired"}}
+`)
+		case r.Method == "POST" && r.URL.Path == "/v1/messages/batches/msgbatch_1/cancel":
+			canceled = true
+			fmt.Fprint(w, `{"id":"msgbatch_1","processing_status":"canceling"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s := &Service{URL: srv.URL + "/v1/messages", APIKey: "key", Model: Claude4Sonnet}
+	var reqs []llm.BatchRequest
+	for _, id := range []string{"pkg-a", "pkg-b", "pkg-c"} {
+		reqs = append(reqs, llm.BatchRequest{ID: id, Request: &llm.Request{Messages: []llm.Message{llm.UserStringMessage("Summarize " + id)}}})
+	}
+	results, err := llm.RunBatch(context.Background(), s, reqs, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(submitted.Requests) != 3 || submitted.Requests[1].CustomID != "pkg-b" || submitted.Requests[1].Params.Model != Claude4Sonnet || submitted.Requests[1].Params.Stream {
+		t.Errorf("submitted %+v", submitted.Requests)
+	}
+	if polls != 4 {
+		t.Errorf("batch was checked %d times, want 3 until it ended and 1 for its results", polls)
+	}
+
+	a := results[0]
+	if a.Err != nil || a.Response.Content[0].Text != "Parses flags." {
+		t.Fatalf("pkg-a = %+v, want its summary", a)
+	}
+	p, _ := llm.PricingFor(Claude4Sonnet)
+	if math.Abs(a.Response.Usage.CostUSD-p.Input/2) > 1e-9 {
+		t.Errorf("a million input tokens cost $%v in a batch, want half of $%v", a.Response.Usage.CostUSD, p.Input)
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "prompt is too long") {
+		t.Errorf("pkg-b = %+v, want its error", results[1])
+	}
+	if results[2].Err == nil || !strings.Contains(results[2].Err.Error(), "expired") {
+		t.Errorf("pkg-c = %+v, want it expired", results[2])
+	}
+
+	if err := s.CancelBatch(context.Background(), "msgbatch_1"); err != nil || !canceled {
+		t.Errorf("CancelBatch = %v, canceled %v", err, canceled)
+	}
+	if _, err := (&Service{Platform: &Vertex{}}).SubmitBatch(context.Background(), reqs); err == nil {
+		t.Errorf("SubmitBatch through a platform succeeded")
+	}
+}
@@ -0,0 +1,198 @@
+package ant
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	googleTokenURL         = "https://oauth2.googleapis.com/token"
+	googleScope            = "https://www.googleapis.com/auth/cloud-platform"
+	googleMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// googleTokenSource fetches and caches Google access tokens using Application Default Credentials:
+// the credentials file named by $GOOGLE_APPLICATION_CREDENTIALS, then the file written by
+// "gcloud auth application-default login", then the metadata server of the instance we are running on.
+// Service account keys and authorized user credentials are supported.
+type googleTokenSource struct {
+	mu      sync.Mutex
+	tok     string
+	expires time.Time
+}
+
+// googleCredentials is a Google credentials JSON file.
+type googleCredentials struct {
+	Type string `json:"type"` // "service_account" or "authorized_user"
+
+	// service_account
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (ts *googleTokenSource) token(ctx context.Context, httpc *http.Client) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.tok != "" && time.Until(ts.expires) > time.Minute {
+		return ts.tok, nil
+	}
+	tok, expiresIn, err := fetchGoogleToken(ctx, httpc)
+	if err != nil {
+		return "", err
+	}
+	ts.tok = tok
+	ts.expires = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	return tok, nil
+}
+
+// googleCredentialsPath returns the path of the Application Default Credentials file, if there is one.
+func googleCredentialsPath() string {
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+func fetchGoogleToken(ctx context.Context, httpc *http.Client) (token string, expiresIn int, err error) {
+	path := googleCredentialsPath()
+	if path == "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", googleMetadataTokenURL, nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		tok, exp, err := doGoogleTokenRequest(httpc, req)
+		if err != nil {
+			return "", 0, fmt.Errorf("no Google credentials found (run 'gcloud auth application-default login' or set GOOGLE_APPLICATION_CREDENTIALS), and the metadata server is unavailable: %w", err)
+		}
+		return tok, exp, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return "", 0, fmt.Errorf("%s: %w", path, err)
+	}
+	form := url.Values{}
+	tokenURL := googleTokenURL
+	switch creds.Type {
+	case "service_account":
+		if creds.TokenURI != "" {
+			tokenURL = creds.TokenURI
+		}
+		assertion, err := googleJWT(&creds, tokenURL, time.Now())
+		if err != nil {
+			return "", 0, fmt.Errorf("%s: %w", path, err)
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", creds.ClientID)
+		form.Set("client_secret", creds.ClientSecret)
+		form.Set("refresh_token", creds.RefreshToken)
+	default:
+		return "", 0, fmt.Errorf("%s: unsupported credentials type %q", path, creds.Type)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doGoogleTokenRequest(httpc, req)
+}
+
+func doGoogleTokenRequest(httpc *http.Client, req *http.Request) (string, int, error) {
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token request failed: %s: %s", resp.Status, body)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", 0, err
+	}
+	if tok.AccessToken == "" {
+		return "", 0, errors.New("token response has no access token")
+	}
+	return tok.AccessToken, tok.ExpiresIn, nil
+}
+
+// googleJWT returns a signed JWT asserting the service account's identity, to exchange for an access token.
+func googleJWT(creds *googleCredentials, aud string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", errors.New("invalid private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("private key is not an RSA key")
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": creds.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   creds.ClientEmail,
+		"scope": googleScope,
+		"aud":   aud,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,178 @@
+package ant
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A Platform sends Claude requests through a cloud provider's endpoint,
+// so that traffic stays inside the user's cloud account.
+type Platform interface {
+	// NewRequest returns an authenticated HTTP request that sends payload,
+	// a Messages API request body, to model.
+	// betas lists the Anthropic beta features the request uses.
+	NewRequest(ctx context.Context, model string, payload []byte, betas []string) (*http.Request, error)
+}
+
+// platformPayload converts a Messages API request body to the form used by cloud platforms,
+// which take the model from the URL and the API version from the body.
+func platformPayload(payload []byte, version string, betas []string) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return nil, err
+	}
+	delete(body, "model")
+	body["anthropic_version"], _ = json.Marshal(version)
+	if len(betas) > 0 {
+		body["anthropic_beta"], _ = json.Marshal(betas)
+	}
+	return json.Marshal(body)
+}
+
+// Bedrock is a Platform that calls Claude through AWS Bedrock,
+// authenticating with AWS Signature Version 4.
+type Bedrock struct {
+	// Region is the AWS region, e.g. "us-east-1".
+	// If empty, $AWS_REGION is used, then $AWS_DEFAULT_REGION.
+	Region string
+	// Credentials authenticate requests.
+	// If nil, credentials are read from the environment or the shared credentials file;
+	// see EnvAWSCredentials.
+	Credentials *AWSCredentials
+	// ModelID, if set, is the Bedrock model or inference profile ID to use,
+	// overriding the ID derived from the Anthropic model name.
+	ModelID string
+}
+
+func (b *Bedrock) region() string {
+	return cmp.Or(b.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+}
+
+func (b *Bedrock) NewRequest(ctx context.Context, model string, payload []byte, betas []string) (*http.Request, error) {
+	region := b.region()
+	if region == "" {
+		return nil, fmt.Errorf("bedrock: no region configured; set AWS_REGION")
+	}
+	creds := b.Credentials
+	if creds == nil {
+		var err error
+		creds, err = EnvAWSCredentials()
+		if err != nil {
+			return nil, fmt.Errorf("bedrock: %w", err)
+		}
+	}
+	body, err := platformPayload(payload, "bedrock-2023-05-31", betas)
+	if err != nil {
+		return nil, err
+	}
+	modelID := cmp.Or(b.ModelID, BedrockModelID(model, region))
+	u := &url.URL{
+		Scheme:  "https",
+		Host:    "bedrock-runtime." + region + ".amazonaws.com",
+		Path:    "/model/" + modelID + "/invoke",
+		RawPath: "/model/" + awsURIEscape(modelID) + "/invoke",
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	signV4(req, body, creds, region, "bedrock", time.Now())
+	return req, nil
+}
+
+// bedrockVersions lists the Bedrock version suffix of models whose suffix is not "v1:0".
+var bedrockVersions = map[string]string{
+	Claude35Sonnet: "v2:0",
+}
+
+// BedrockModelID returns the Bedrock ID for an Anthropic model name, e.g.
+// "us.anthropic.claude-sonnet-4-20250514-v1:0" for "claude-sonnet-4-20250514" in "us-east-1".
+// It uses the cross-region inference profile for region's geography,
+// which newer models require. IDs that are already Bedrock IDs are returned unchanged.
+func BedrockModelID(model, region string) string {
+	if strings.Contains(model, "anthropic.") {
+		return model
+	}
+	id := "anthropic." + model + "-" + cmp.Or(bedrockVersions[model], "v1:0")
+	switch {
+	case strings.HasPrefix(region, "us-"):
+		return "us." + id
+	case strings.HasPrefix(region, "eu-"):
+		return "eu." + id
+	case strings.HasPrefix(region, "ap-"):
+		return "apac." + id
+	}
+	return id
+}
+
+// Vertex is a Platform that calls Claude through Google Cloud Vertex AI,
+// authenticating with Application Default Credentials.
+type Vertex struct {
+	// ProjectID is the Google Cloud project.
+	// If empty, $ANTHROPIC_VERTEX_PROJECT_ID is used, then $GOOGLE_CLOUD_PROJECT.
+	ProjectID string
+	// Region is the Vertex AI region, e.g. "us-east5".
+	// If empty, $CLOUD_ML_REGION is used, then "us-east5".
+	Region string
+	// ModelID, if set, is the Vertex model ID to use,
+	// overriding the ID derived from the Anthropic model name.
+	ModelID string
+	// HTTPC is used to fetch access tokens; defaults to http.DefaultClient if nil.
+	HTTPC *http.Client
+
+	tokens googleTokenSource
+}
+
+func (v *Vertex) NewRequest(ctx context.Context, model string, payload []byte, betas []string) (*http.Request, error) {
+	project := cmp.Or(v.ProjectID, os.Getenv("ANTHROPIC_VERTEX_PROJECT_ID"), os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if project == "" {
+		return nil, fmt.Errorf("vertex: no project configured; set ANTHROPIC_VERTEX_PROJECT_ID")
+	}
+	region := cmp.Or(v.Region, os.Getenv("CLOUD_ML_REGION"), "us-east5")
+	token, err := v.tokens.token(ctx, cmp.Or(v.HTTPC, http.DefaultClient))
+	if err != nil {
+		return nil, fmt.Errorf("vertex: %w", err)
+	}
+	body, err := platformPayload(payload, "vertex-2023-10-16", nil)
+	if err != nil {
+		return nil, err
+	}
+	host := region + "-aiplatform.googleapis.com"
+	if region == "global" {
+		host = "aiplatform.googleapis.com"
+	}
+	u := fmt.Sprintf("https://%s/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:rawPredict",
+		host, project, region, cmp.Or(v.ModelID, VertexModelID(model)))
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if len(betas) > 0 {
+		req.Header.Set("anthropic-beta", strings.Join(betas, ","))
+	}
+	return req, nil
+}
+
+var modelDate = regexp.MustCompile(`-(\d{8})$`)
+
+// VertexModelID returns the Vertex AI ID for an Anthropic model name,
+// e.g. "claude-sonnet-4@20250514" for "claude-sonnet-4-20250514".
+func VertexModelID(model string) string {
+	if strings.Contains(model, "@") {
+		return model
+	}
+	return modelDate.ReplaceAllString(model, "@$1")
+}
@@ -0,0 +1,141 @@
+package ant
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+func TestSignV4(t *testing.T) {
+	// The get-vanilla case from the AWS Signature Version 4 test suite.
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := &AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestModelIDs(t *testing.T) {
+	bedrock := []struct{ model, region, want string }{
+		{Claude4Sonnet, "us-east-1", "us.anthropic.claude-sonnet-4-20250514-v1:0"},
+		{Claude35Sonnet, "eu-west-1", "eu.anthropic.claude-3-5-sonnet-20241022-v2:0"},
+		{Claude37Sonnet, "ap-northeast-1", "apac.anthropic.claude-3-7-sonnet-20250219-v1:0"},
+		{"anthropic.claude-v2", "us-east-1", "anthropic.claude-v2"},
+	}
+	for _, tt := range bedrock {
+		if got := BedrockModelID(tt.model, tt.region); got != tt.want {
+			t.Errorf("BedrockModelID(%q, %q) = %q, want %q", tt.model, tt.region, got, tt.want)
+		}
+	}
+	if got := VertexModelID(Claude4Opus); got != "claude-opus-4@20250514" {
+		t.Errorf("VertexModelID(%q) = %q", Claude4Opus, got)
+	}
+	if got := VertexModelID("claude-3-5-haiku@20241022"); got != "claude-3-5-haiku@20241022" {
+		t.Errorf("VertexModelID changed a Vertex ID: %q", got)
+	}
+}
+
+func TestBedrockRequest(t *testing.T) {
+	b := &Bedrock{Region: "us-west-2", Credentials: &AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "session"}}
+	req, err := b.NewRequest(context.Background(), Claude4Sonnet, []byte(`{"model":"claude-sonnet-4-20250514","max_tokens":10}`), []string{"beta-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.URL.String(), "https://bedrock-runtime.us-west-2.amazonaws.com/model/us.anthropic.claude-sonnet-4-20250514-v1%3A0/invoke"; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || req.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Errorf("request not signed: %v", req.Header)
+	}
+	body, _ := io.ReadAll(req.Body)
+	var got map[string]any
+	json.Unmarshal(body, &got)
+	if _, ok := got["model"]; ok || got["anthropic_version"] != "bedrock-2023-05-31" || got["max_tokens"] != 10.0 {
+		t.Errorf("body = %s", body)
+	}
+	if betas, _ := got["anthropic_beta"].([]any); len(betas) != 1 {
+		t.Errorf("anthropic_beta = %v", got["anthropic_beta"])
+	}
+}
+
+func TestVertexServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(r.Form.Get("assertion"), ".") != 2 {
+			t.Errorf("token request form = %v", r.Form)
+		}
+		w.Write([]byte(`{"access_token":"ya29.test","expires_in":3600}`))
+	}))
+	defer tokens.Close()
+	creds, _ := json.Marshal(googleCredentials{
+		Type:        "service_account",
+		ClientEmail: "sketch@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    tokens.URL,
+	})
+	path := filepath.Join(t.TempDir(), "creds.json")
+	if err := os.WriteFile(path, creds, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	v := &Vertex{ProjectID: "proj", Region: "us-east5"}
+	req, err := v.NewRequest(context.Background(), Claude4Sonnet, []byte(`{"model":"x","max_tokens":10}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.URL.String(), "https://us-east5-aiplatform.googleapis.com/v1/projects/proj/locations/us-east5/publishers/anthropic/models/claude-sonnet-4@20250514:rawPredict"; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer ya29.test" {
+		t.Errorf("Authorization = %q", got)
+	}
+}
+
+// redirectPlatform sends requests to a test server.
+type redirectPlatform struct{ url string }
+
+func (p redirectPlatform) NewRequest(ctx context.Context, model string, payload []byte, betas []string) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, "POST", p.url+"/"+model, strings.NewReader(string(payload)))
+}
+
+func TestServicePlatform(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+DefaultModel || r.Header.Get("X-API-Key") != "" {
+			t.Errorf("request to %s with API key %q", r.URL.Path, r.Header.Get("X-API-Key"))
+		}
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`))
+	}))
+	defer srv.Close()
+	s := &Service{APIKey: "unused", Platform: redirectPlatform{srv.URL}}
+	resp, err := s.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hello")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content[0].Text != "hi" {
+		t.Errorf("response = %+v", resp)
+	}
+}
@@ -0,0 +1,138 @@
+package ant
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// streamEvent is a server-sent event from a streaming Messages API response.
+// See https://docs.anthropic.com/en/docs/build-with-claude/streaming.
+type streamEvent struct {
+	Type    string    `json:"type"`
+	Message *response `json:"message,omitempty"` // message_start
+	Index   int       `json:"index"`
+	Block   *content  `json:"content_block,omitempty"` // content_block_start
+	Delta   struct {
+		Type        string  `json:"type"`
+		Text        string  `json:"text"`
+		PartialJSON string  `json:"partial_json"`
+		Thinking    string  `json:"thinking"`
+		Signature   string  `json:"signature"`
+		StopReason  string  `json:"stop_reason"`
+		StopSeq     *string `json:"stop_sequence"`
+	} `json:"delta"`
+	Usage *usage `json:"usage,omitempty"` // message_delta
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// streamErrorStatus maps the types of error events in the middle of a stream
+// to the HTTP status codes the same errors have when they happen before the stream starts.
+// See https://docs.anthropic.com/en/api/errors.
+var streamErrorStatus = map[string]int{
+	"invalid_request_error": 400,
+	"rate_limit_error":      429,
+	"api_error":             500,
+	"overloaded_error":      529,
+}
+
+// readStream assembles a streaming Messages API response from r.
+// As text and tool input arrive, it passes them to the callbacks installed in ctx.
+func readStream(ctx context.Context, r io.Reader) (*response, error) {
+	onText := llm.TextDeltaFunc(ctx)
+	onToolUse := llm.PartialToolUseFunc(ctx)
+
+	var resp response
+	var toolInputs []strings.Builder // partial JSON input of tool_use blocks, by index
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		data, ok := bytes.CutPrefix(sc.Bytes(), []byte("data:"))
+		if !ok {
+			continue // event names, blank lines, and comments; the payload carries the type
+		}
+		var ev streamEvent
+		if err := json.Unmarshal(bytes.TrimSpace(data), &ev); err != nil {
+			return nil, fmt.Errorf("malformed stream event: %w", err)
+		}
+		switch ev.Type {
+		case "message_start":
+			if ev.Message != nil {
+				resp = *ev.Message
+				resp.Content = nil
+			}
+		case "content_block_start":
+			if ev.Block == nil || ev.Index != len(resp.Content) {
+				return nil, fmt.Errorf("unexpected content block %d in stream", ev.Index)
+			}
+			block := *ev.Block
+			if block.Type == "tool_use" {
+				block.ToolInput = nil // arrives as input_json_delta
+			}
+			resp.Content = append(resp.Content, block)
+			toolInputs = append(toolInputs, strings.Builder{})
+		case "content_block_delta":
+			if ev.Index >= len(resp.Content) {
+				return nil, fmt.Errorf("delta for unknown content block %d in stream", ev.Index)
+			}
+			block := &resp.Content[ev.Index]
+			switch ev.Delta.Type {
+			case "text_delta":
+				text := ev.Delta.Text
+				if block.Text != nil {
+					text = *block.Text + text
+				}
+				block.Text = &text
+				if onText != nil {
+					onText(ev.Delta.Text)
+				}
+			case "input_json_delta":
+				toolInputs[ev.Index].WriteString(ev.Delta.PartialJSON)
+				if onToolUse != nil {
+					input, _ := llm.CompletePartialJSON(toolInputs[ev.Index].String())
+					onToolUse(llm.PartialToolUse{ID: block.ID, ToolName: block.ToolName, Input: input})
+				}
+			case "thinking_delta":
+				block.Thinking += ev.Delta.Thinking
+			case "signature_delta":
+				block.Signature += ev.Delta.Signature
+			}
+		case "content_block_stop":
+			if ev.Index < len(resp.Content) && resp.Content[ev.Index].Type == "tool_use" {
+				input := toolInputs[ev.Index].String()
+				if input == "" {
+					input = "{}" // a tool call with no arguments
+				}
+				resp.Content[ev.Index].ToolInput = json.RawMessage(input)
+			}
+		case "message_delta":
+			resp.StopReason = ev.Delta.StopReason
+			resp.StopSequence = ev.Delta.StopSeq
+			if ev.Usage != nil {
+				resp.Usage.OutputTokens = ev.Usage.OutputTokens
+			}
+		case "message_stop":
+			return &resp, nil
+		case "error":
+			if ev.Error == nil {
+				return nil, &llm.StatusError{StatusCode: 500, Message: "unknown stream error"}
+			}
+			status := cmp.Or(streamErrorStatus[ev.Error.Type], 500)
+			return nil, &llm.StatusError{StatusCode: status, Message: ev.Error.Type + ": " + ev.Error.Message}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
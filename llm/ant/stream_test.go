@@ -0,0 +1,112 @@
+package ant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+const testStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":25,"cache_read_input_tokens":10,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"check."}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"bash","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"command\": \"l"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"s\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":42}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestStream(t *testing.T) {
+	var gotReq map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, testStream)
+	}))
+	defer srv.Close()
+
+	var text strings.Builder
+	var partial []string
+	ctx := llm.WithTextDeltaFunc(context.Background(), func(s string) { text.WriteString(s) })
+	ctx = llm.WithPartialToolUseFunc(ctx, func(p llm.PartialToolUse) { partial = append(partial, string(p.Input)) })
+
+	s := &Service{URL: srv.URL, APIKey: "key", Stream: true}
+	resp, err := s.Do(ctx, &llm.Request{Messages: []llm.Message{llm.UserStringMessage("list files")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotReq["stream"] != true {
+		t.Errorf("request stream = %v, want true", gotReq["stream"])
+	}
+	if text.String() != "Let me check." {
+		t.Errorf("text deltas = %q", text.String())
+	}
+	if want := `{"command": "l"}|{"command": "ls"}`; strings.Join(partial, "|") != want {
+		t.Errorf("partial tool inputs = %q, want %q", partial, want)
+	}
+	if len(resp.Content) != 2 || resp.Content[0].Text != "Let me check." {
+		t.Fatalf("content = %+v", resp.Content)
+	}
+	if tool := resp.Content[1]; tool.ID != "toolu_1" || tool.ToolName != "bash" || string(tool.ToolInput) != `{"command": "ls"}` {
+		t.Errorf("tool use = %+v (input %s)", tool, tool.ToolInput)
+	}
+	if resp.StopReason != llm.StopReasonToolUse {
+		t.Errorf("StopReason = %v", resp.StopReason)
+	}
+	if resp.Usage.InputTokens != 25 || resp.Usage.CacheReadInputTokens != 10 || resp.Usage.OutputTokens != 42 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestReadStreamErrors(t *testing.T) {
+	overloaded := `data: {"type":"message_start","message":{"id":"msg_1","content":[]}}
+
+data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}
+`
+	_, err := readStream(context.Background(), strings.NewReader(overloaded))
+	if serr := (*llm.StatusError)(nil); !errors.As(err, &serr) || serr.StatusCode != 529 {
+		t.Errorf("error event: got %v, want status 529", err)
+	}
+
+	truncated := testStream[:strings.Index(testStream, "event: message_delta")]
+	if _, err := readStream(context.Background(), strings.NewReader(truncated)); err == nil {
+		t.Errorf("truncated stream: got no error")
+	}
+}
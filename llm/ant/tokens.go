@@ -0,0 +1,43 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+
+	"sketch.dev/llm"
+)
+
+var _ llm.TokenCounter = (*Service)(nil)
+
+// countTokensRequest is the part of a request that the token counting endpoint accepts.
+// See https://docs.anthropic.com/en/api/messages-count-tokens
+type countTokensRequest struct {
+	Model      string          `json:"model"`
+	Messages   []message       `json:"messages"`
+	ToolChoice *toolChoice     `json:"tool_choice,omitempty"`
+	Tools      []*tool         `json:"tools,omitempty"`
+	System     []systemContent `json:"system,omitempty"`
+}
+
+// CountTokens implements llm.TokenCounter with Anthropic's token counting endpoint.
+// Tokens cannot be counted through a Platform.
+func (s *Service) CountTokens(ctx context.Context, req *llm.Request) (int, error) {
+	if s.Platform != nil {
+		return 0, fmt.Errorf("anthropic token counting is not supported through %T", s.Platform)
+	}
+	r := s.fromLLMRequest(req)
+	var resp struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	err := s.doJSON(ctx, "POST", s.apiURL("count_tokens"), countTokensRequest{
+		Model:      r.Model,
+		Messages:   r.Messages,
+		ToolChoice: r.ToolChoice,
+		Tools:      r.Tools,
+		System:     r.System,
+	}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.InputTokens, nil
+}
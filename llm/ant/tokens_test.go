@@ -0,0 +1,42 @@
+package ant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestCountTokens(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/messages/count_tokens" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if _, ok := body["max_tokens"]; ok || body["model"] != Claude4Sonnet || body["system"] == nil {
+			t.Errorf("counted %v, want the model, messages, and system prompt only", body)
+		}
+		fmt.Fprint(w, `{"input_tokens":17}`)
+	}))
+	defer srv.Close()
+
+	s := &Service{URL: srv.URL + "/v1/messages", APIKey: "key", Model: Claude4Sonnet}
+	req := &llm.Request{
+		System:   []llm.SystemContent{{Text: "Be brief."}},
+		Messages: []llm.Message{llm.UserStringMessage("hello")},
+	}
+	if n, err := s.CountTokens(context.Background(), req); err != nil || n != 17 {
+		t.Errorf("CountTokens = %d, %v; want 17", n, err)
+	}
+	if _, err := (&Service{Platform: &Vertex{}}).CountTokens(context.Background(), req); err == nil {
+		t.Errorf("CountTokens through a platform succeeded")
+	}
+}
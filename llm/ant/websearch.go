@@ -0,0 +1,128 @@
+package ant
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"sketch.dev/llm"
+)
+
+var _ llm.WebSearcher = (*Service)(nil)
+
+// webSearchTool is Anthropic's server-side web search tool.
+// See https://docs.anthropic.com/en/docs/agents-and-tools/tool-use/web-search-tool
+type webSearchTool struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	MaxUses int    `json:"max_uses"`
+}
+
+type webSearchRequest struct {
+	Model     string          `json:"model"`
+	MaxTokens int             `json:"max_tokens"`
+	Messages  []message       `json:"messages"`
+	Tools     []webSearchTool `json:"tools"`
+}
+
+// webSearchContent is a content block in a web search response.
+// The content of a web_search_tool_result is either a list of results or an error.
+type webSearchContent struct {
+	Type      string            `json:"type"`
+	Text      string            `json:"text"`
+	Content   json.RawMessage   `json:"content"`
+	Citations []webSearchResult `json:"citations"`
+}
+
+type webSearchResult struct {
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	Title     string `json:"title"`
+	CitedText string `json:"cited_text"`
+	ErrorCode string `json:"error_code"`
+}
+
+const webSearchPrompt = `Search the web for the query below, once. Then, for each result worth reading, quote the sentence from it that best answers the query, citing the result.
+
+Query: %s`
+
+// WebSearch implements llm.WebSearcher with Anthropic's server-side web search tool.
+// Snippets are the passages the model cites from each result; results it does not cite have none.
+func (s *Service) WebSearch(ctx context.Context, query string, maxResults int) ([]llm.WebSearchResult, error) {
+	text := fmt.Sprintf(webSearchPrompt, query)
+	payload, err := json.Marshal(webSearchRequest{
+		Model:     cmp.Or(s.Model, DefaultModel),
+		MaxTokens: 2048,
+		Messages:  []message{{Role: "user", Content: []content{{Type: "text", Text: &text}}}},
+		Tools:     []webSearchTool{{Type: "web_search_20250305", Name: "web_search", MaxUses: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, _, err := s.newRequest(ctx, cmp.Or(s.Model, DefaultModel), payload, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cmp.Or(s.HTTPC, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic web search: %w", err)
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic web search: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &llm.StatusError{StatusCode: resp.StatusCode, Message: string(buf), RetryAfter: llm.RetryAfter(resp.Header)}
+	}
+	var response struct {
+		Content []webSearchContent `json:"content"`
+	}
+	if err := json.Unmarshal(buf, &response); err != nil {
+		return nil, fmt.Errorf("anthropic web search: %w", err)
+	}
+	return webSearchResults(response.Content, maxResults)
+}
+
+// webSearchResults collects the results from a web search response,
+// taking each result's snippet from the text that cites it.
+func webSearchResults(blocks []webSearchContent, maxResults int) ([]llm.WebSearchResult, error) {
+	var results []llm.WebSearchResult
+	index := make(map[string]int) // URL to index in results
+	for _, b := range blocks {
+		if b.Type != "web_search_tool_result" {
+			continue
+		}
+		var found []webSearchResult
+		if err := json.Unmarshal(b.Content, &found); err != nil {
+			var e webSearchResult
+			if json.Unmarshal(b.Content, &e) == nil && e.ErrorCode != "" {
+				return nil, fmt.Errorf("anthropic web search failed: %s", e.ErrorCode)
+			}
+			return nil, fmt.Errorf("anthropic web search: unexpected result %s", b.Content)
+		}
+		for _, r := range found {
+			if _, ok := index[r.URL]; ok || len(results) >= maxResults {
+				continue
+			}
+			index[r.URL] = len(results)
+			results = append(results, llm.WebSearchResult{Title: r.Title, URL: r.URL})
+		}
+	}
+	for _, b := range blocks {
+		for _, c := range b.Citations {
+			i, ok := index[c.URL]
+			if !ok || c.CitedText == "" {
+				continue
+			}
+			if results[i].Snippet != "" {
+				results[i].Snippet += " … "
+			}
+			results[i].Snippet += c.CitedText
+		}
+	}
+	return results, nil
+}
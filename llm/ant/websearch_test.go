@@ -0,0 +1,57 @@
+package ant
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestWebSearch(t *testing.T) {
+	reply := `{"id":"msg_1","type":"message","role":"assistant","content":[
+		{"type":"server_tool_use","id":"srvtoolu_1","name":"web_search","input":{"query":"go 1.24 release"}},
+		{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":[
+			{"type":"web_search_result","url":"https://go.dev/doc/go1.24","title":"Go 1.24 Release Notes","encrypted_content":"x"},
+			{"type":"web_search_result","url":"https://go.dev/blog/go1.24","title":"Go 1.24 is released!","encrypted_content":"y"},
+			{"type":"web_search_result","url":"https://example.com/go","title":"Elsewhere","encrypted_content":"z"}]},
+		{"type":"text","text":"The notes say","citations":[
+			{"type":"web_search_result_location","url":"https://go.dev/doc/go1.24","title":"Go 1.24 Release Notes","cited_text":"Go 1.24 arrives six months after Go 1.23."},
+			{"type":"web_search_result_location","url":"https://go.dev/doc/go1.24","title":"Go 1.24 Release Notes","cited_text":"It fully supports generic type aliases."}]}],
+		"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`
+	var body webSearchRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if strings.Contains(*body.Messages[0].Content[0].Text, "fail") {
+			w.Write([]byte(`{"content":[{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":{"type":"web_search_tool_result_error","error_code":"max_uses_exceeded"}}]}`))
+			return
+		}
+		w.Write([]byte(reply))
+	}))
+	defer srv.Close()
+
+	s := &Service{URL: srv.URL, APIKey: "key"}
+	results, err := s.WebSearch(context.Background(), "go 1.24 release", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Tools) != 1 || body.Tools[0].Type != "web_search_20250305" || body.Tools[0].MaxUses != 1 {
+		t.Errorf("request tools = %+v", body.Tools)
+	}
+	want := []llm.WebSearchResult{
+		{Title: "Go 1.24 Release Notes", URL: "https://go.dev/doc/go1.24", Snippet: "Go 1.24 arrives six months after Go 1.23. … It fully supports generic type aliases."},
+		{Title: "Go 1.24 is released!", URL: "https://go.dev/blog/go1.24"},
+	}
+	if len(results) != len(want) || results[0] != want[0] || results[1] != want[1] {
+		t.Errorf("WebSearch = %+v, want %+v", results, want)
+	}
+
+	if _, err := s.WebSearch(context.Background(), "fail", 5); err == nil || !strings.Contains(err.Error(), "max_uses_exceeded") {
+		t.Errorf("WebSearch error = %v, want max_uses_exceeded", err)
+	}
+}
@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// A Batcher runs requests in bulk and asynchronously, as with Anthropic's Message Batches API.
+// Batched requests cost half as much as requests sent one at a time, but a batch may take up to a day,
+// so batches suit work no one is waiting on, such as summarizing every package in a monorepo.
+type Batcher interface {
+	// SubmitBatch starts running reqs and returns the batch's ID.
+	SubmitBatch(ctx context.Context, reqs []BatchRequest) (string, error)
+	// BatchStatus returns the progress of the batch with id.
+	BatchStatus(ctx context.Context, id string) (BatchStatus, error)
+	// BatchResults returns the results of the ended batch with id, in no particular order.
+	BatchResults(ctx context.Context, id string) ([]BatchResult, error)
+	// CancelBatch stops the batch with id; requests that have not run are canceled.
+	CancelBatch(ctx context.Context, id string) error
+}
+
+// A BatchRequest is a request in a batch.
+type BatchRequest struct {
+	ID      string // identifies the request's result; unique in its batch, of letters, digits, _ and -
+	Request *Request
+}
+
+// A BatchStatus is the progress of a batch: whether it has ended, and how many of its requests are in each state.
+type BatchStatus struct {
+	Ended      bool
+	Processing int
+	Succeeded  int
+	Errored    int
+	Canceled   int
+	Expired    int
+}
+
+// A BatchResult is the outcome of a BatchRequest: its Response if it succeeded, or why it did not.
+type BatchResult struct {
+	ID       string
+	Response *Response
+	Err      error
+}
+
+// BatcherOf returns s as a Batcher, if it is one.
+// For a Router, it returns the primary service's batcher.
+func BatcherOf(s Service) (Batcher, bool) {
+	if r, ok := s.(*Router); ok {
+		if len(r.Services) == 0 {
+			return nil, false
+		}
+		s = r.Services[0]
+	}
+	b, ok := s.(Batcher)
+	return b, ok
+}
+
+// RunBatch runs reqs as a batch with b, checking its progress every interval until it ends,
+// and returns the results in the order of reqs.
+// A request with no result fails with an error saying so.
+// If ctx is done first, RunBatch cancels the batch and returns ctx's error.
+func RunBatch(ctx context.Context, b Batcher, reqs []BatchRequest, interval time.Duration) ([]BatchResult, error) {
+	index := make(map[string]int, len(reqs))
+	for i, r := range reqs {
+		if _, ok := index[r.ID]; ok {
+			return nil, fmt.Errorf("batch request ID %q is not unique", r.ID)
+		}
+		index[r.ID] = i
+	}
+	id, err := b.SubmitBatch(ctx, reqs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit batch: %w", err)
+	}
+	slog.InfoContext(ctx, "llm_batch_submitted", "batch", id, "requests", len(reqs))
+	for {
+		if err := Sleep(ctx, interval); err != nil {
+			cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			if cerr := b.CancelBatch(cancelCtx, id); cerr != nil {
+				slog.WarnContext(ctx, "llm_batch_cancel_failed", "batch", id, "error", cerr)
+			}
+			cancel()
+			return nil, err
+		}
+		status, err := b.BatchStatus(ctx, id)
+		if err != nil {
+			if IsRetryable(err) {
+				slog.WarnContext(ctx, "llm_batch_status_failed", "batch", id, "error", err)
+				continue
+			}
+			return nil, fmt.Errorf("failed to check batch %s: %w", id, err)
+		}
+		if status.Ended {
+			slog.InfoContext(ctx, "llm_batch_ended", "batch", id, "succeeded", status.Succeeded, "errored", status.Errored, "canceled", status.Canceled, "expired", status.Expired)
+			break
+		}
+	}
+
+	got, err := b.BatchResults(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the results of batch %s: %w", id, err)
+	}
+	results := make([]BatchResult, len(reqs))
+	for i, r := range reqs {
+		results[i] = BatchResult{ID: r.ID, Err: fmt.Errorf("batch %s has no result for request %s", id, r.ID)}
+	}
+	for _, r := range got {
+		if i, ok := index[r.ID]; ok {
+			results[i] = r
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBatcher ends a batch after polls checks of its status, answering every request but the last.
+type fakeBatcher struct {
+	polls    int
+	fail     error // returned by the first check of the batch's status
+	reqs     []BatchRequest
+	canceled bool
+}
+
+func (f *fakeBatcher) SubmitBatch(ctx context.Context, reqs []BatchRequest) (string, error) {
+	f.reqs = reqs
+	return "batch_1", nil
+}
+
+func (f *fakeBatcher) BatchStatus(ctx context.Context, id string) (BatchStatus, error) {
+	if f.fail != nil {
+		err := f.fail
+		f.fail = nil
+		return BatchStatus{}, err
+	}
+	f.polls--
+	return BatchStatus{Ended: f.polls <= 0}, nil
+}
+
+func (f *fakeBatcher) BatchResults(ctx context.Context, id string) ([]BatchResult, error) {
+	var results []BatchResult
+	for i := len(f.reqs) - 2; i >= 0; i-- {
+		r := f.reqs[i]
+		results = append(results, BatchResult{ID: r.ID, Response: &Response{ID: "msg_" + r.ID}})
+	}
+	return results, nil
+}
+
+func (f *fakeBatcher) CancelBatch(ctx context.Context, id string) error {
+	f.canceled = true
+	return nil
+}
+
+func TestRunBatch(t *testing.T) {
+	reqs := []BatchRequest{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	b := &fakeBatcher{polls: 3, fail: &StatusError{StatusCode: 529}}
+	results, err := RunBatch(context.Background(), b, reqs, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.polls != 0 {
+		t.Errorf("RunBatch stopped polling with %d polls to go", b.polls)
+	}
+	if len(results) != 3 || results[0].Response.ID != "msg_a" || results[1].Response.ID != "msg_b" {
+		t.Errorf("results = %+v, want them in the order of the requests", results)
+	}
+	if results[2].Response != nil || results[2].Err == nil || !strings.Contains(results[2].Err.Error(), "no result for request c") {
+		t.Errorf("result without an answer = %+v, want an error", results[2])
+	}
+
+	if _, err := RunBatch(context.Background(), &fakeBatcher{}, []BatchRequest{{ID: "a"}, {ID: "a"}}, time.Millisecond); err == nil {
+		t.Errorf("RunBatch with duplicate IDs succeeded")
+	}
+
+	b = &fakeBatcher{polls: 1, fail: errors.New("invalid batch ID")}
+	if _, err := RunBatch(context.Background(), b, reqs, time.Millisecond); err == nil || !strings.Contains(err.Error(), "invalid batch ID") {
+		t.Errorf("RunBatch with a permanent status failure = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	b = &fakeBatcher{polls: 1 << 30}
+	if _, err := RunBatch(ctx, b, reqs, time.Millisecond); !errors.Is(err, context.DeadlineExceeded) || !b.canceled {
+		t.Errorf("RunBatch past its deadline = %v, canceled %v; want the batch canceled", err, b.canceled)
+	}
+}
@@ -0,0 +1,93 @@
+package llm
+
+import "strings"
+
+// Capabilities describes what a model supports and its limits.
+type Capabilities struct {
+	// ContextWindow is the maximum number of input tokens.
+	ContextWindow int `json:"context_window"`
+	// MaxOutputTokens is the maximum number of tokens in a single response.
+	MaxOutputTokens int `json:"max_output_tokens"`
+	// Tools reports whether the model supports tool use.
+	Tools bool `json:"tools"`
+	// Vision reports whether the model accepts images.
+	Vision bool `json:"vision"`
+	// Pricing is the list price of the model, or nil if unknown,
+	// as for local or self-hosted models.
+	Pricing *Pricing `json:"pricing,omitempty"`
+}
+
+// DefaultCapabilities are assumed for models that are not in the registry.
+// They are conservative enough that most tool-capable models work.
+var DefaultCapabilities = Capabilities{
+	ContextWindow:   128000,
+	MaxOutputTokens: 8192,
+	Tools:           true,
+}
+
+// A CapabilityReporter is a Service that knows the capabilities of the model it calls.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf returns the capabilities of the model behind s.
+// Services that are not CapabilityReporters are assumed to support every feature,
+// within their context window.
+func CapabilitiesOf(s Service) Capabilities {
+	if r, ok := s.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+	caps := DefaultCapabilities
+	caps.ContextWindow = s.TokenContextWindow()
+	caps.Vision = true
+	return caps
+}
+
+// modelCapabilities maps model name prefixes to their capabilities.
+// Dated model versions (e.g. "claude-sonnet-4-20250514") match by prefix;
+// the longest matching prefix wins.
+var modelCapabilities = map[string]Capabilities{
+	// Anthropic
+	"claude-opus-4":     {ContextWindow: 200000, MaxOutputTokens: 32000, Tools: true, Vision: true, Pricing: &Pricing{Input: 15, Output: 75, CacheRead: 1.50, CacheWrite: 18.75}},
+	"claude-sonnet-4":   {ContextWindow: 200000, MaxOutputTokens: 64000, Tools: true, Vision: true, Pricing: &Pricing{Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75}},
+	"claude-3-7-sonnet": {ContextWindow: 200000, MaxOutputTokens: 64000, Tools: true, Vision: true, Pricing: &Pricing{Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75}},
+	"claude-3-5-sonnet": {ContextWindow: 200000, MaxOutputTokens: 8192, Tools: true, Vision: true, Pricing: &Pricing{Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75}},
+	"claude-3-5-haiku":  {ContextWindow: 200000, MaxOutputTokens: 8192, Tools: true, Vision: true, Pricing: &Pricing{Input: 0.80, Output: 4, CacheRead: 0.08, CacheWrite: 1}},
+
+	// OpenAI
+	"gpt-4.1":      {ContextWindow: 1047576, MaxOutputTokens: 32768, Tools: true, Vision: true, Pricing: &Pricing{Input: 2, Output: 8, CacheRead: 0.50}},
+	"gpt-4.1-mini": {ContextWindow: 1047576, MaxOutputTokens: 32768, Tools: true, Vision: true, Pricing: &Pricing{Input: 0.40, Output: 1.60, CacheRead: 0.10}},
+	"gpt-4.1-nano": {ContextWindow: 1047576, MaxOutputTokens: 32768, Tools: true, Vision: true, Pricing: &Pricing{Input: 0.10, Output: 0.40, CacheRead: 0.025}},
+	"gpt-4o":       {ContextWindow: 128000, MaxOutputTokens: 16384, Tools: true, Vision: true, Pricing: &Pricing{Input: 2.50, Output: 10, CacheRead: 1.25}},
+	"gpt-4o-mini":  {ContextWindow: 128000, MaxOutputTokens: 16384, Tools: true, Vision: true, Pricing: &Pricing{Input: 0.15, Output: 0.60, CacheRead: 0.075}},
+	"o3":           {ContextWindow: 200000, MaxOutputTokens: 100000, Tools: true, Vision: true, Pricing: &Pricing{Input: 2, Output: 8, CacheRead: 0.50}},
+	"o3-mini":      {ContextWindow: 200000, MaxOutputTokens: 100000, Tools: true, Pricing: &Pricing{Input: 1.10, Output: 4.40, CacheRead: 0.55}},
+	"o4-mini":      {ContextWindow: 200000, MaxOutputTokens: 100000, Tools: true, Vision: true, Pricing: &Pricing{Input: 1.10, Output: 4.40, CacheRead: 0.275}},
+
+	// Google
+	"gemini-2.5-pro":   {ContextWindow: 1048576, MaxOutputTokens: 65536, Tools: true, Vision: true, Pricing: &Pricing{Input: 1.25, Output: 10, CacheRead: 0.31}},
+	"gemini-2.5-flash": {ContextWindow: 1048576, MaxOutputTokens: 65536, Tools: true, Vision: true, Pricing: &Pricing{Input: 0.30, Output: 2.50, CacheRead: 0.075}},
+	"gemini-2.0-flash": {ContextWindow: 1048576, MaxOutputTokens: 8192, Tools: true, Vision: true, Pricing: &Pricing{Input: 0.10, Output: 0.40, CacheRead: 0.025}},
+	"gemini-1.5-pro":   {ContextWindow: 2097152, MaxOutputTokens: 8192, Tools: true, Vision: true},
+	"gemini-1.5-flash": {ContextWindow: 1048576, MaxOutputTokens: 8192, Tools: true, Vision: true},
+
+	// Mistral
+	"mistral-medium": {ContextWindow: 128000, MaxOutputTokens: 8192, Tools: true, Vision: true},
+	"devstral-small": {ContextWindow: 128000, MaxOutputTokens: 8192, Tools: true},
+}
+
+// CapabilitiesFor returns the capabilities of model from the registry.
+// It reports false for models that are not in the registry.
+func CapabilitiesFor(model string) (Capabilities, bool) {
+	model = strings.TrimPrefix(model, "models/") // Gemini model resource names
+	var best string
+	for prefix := range modelCapabilities {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return Capabilities{}, false
+	}
+	return modelCapabilities[best], true
+}
@@ -0,0 +1,32 @@
+package llm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCapabilitiesFor(t *testing.T) {
+	caps, ok := CapabilitiesFor("o3-mini-2025-01-31")
+	if !ok || caps.Vision || caps.MaxOutputTokens != 100000 {
+		t.Errorf("CapabilitiesFor(o3-mini) = %+v, %v; want no vision, longest prefix", caps, ok)
+	}
+	caps, ok = CapabilitiesFor("models/gemini-2.5-pro-preview-03-25")
+	if !ok || caps.ContextWindow != 1048576 {
+		t.Errorf("CapabilitiesFor(gemini) = %+v, %v", caps, ok)
+	}
+	if _, ok := CapabilitiesFor("llama.cpp local model"); ok {
+		t.Errorf("CapabilitiesFor found a local model")
+	}
+}
+
+type windowOnlyService struct{}
+
+func (windowOnlyService) Do(ctx context.Context, req *Request) (*Response, error) { return nil, nil }
+func (windowOnlyService) TokenContextWindow() int                                 { return 1000 }
+
+func TestCapabilitiesOf(t *testing.T) {
+	caps := CapabilitiesOf(windowOnlyService{})
+	if caps.ContextWindow != 1000 || !caps.Tools || !caps.Vision {
+		t.Errorf("CapabilitiesOf(non-reporter) = %+v, want its window and every feature", caps)
+	}
+}
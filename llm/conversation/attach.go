@@ -0,0 +1,447 @@
+package conversation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	"sketch.dev/llm"
+)
+
+const (
+	// MaxAttachmentSize is the most of a file or web page that an attachment keeps; the rest is cut off.
+	MaxAttachmentSize = 128 << 10
+	// MaxAttachedSize is the most that a conversation's attachments may take in all.
+	MaxAttachedSize = 512 << 10
+	// MaxAttachedFiles is the most files that attaching a directory or glob may attach.
+	MaxAttachedFiles = 50
+	// maxAttachedShare is the most of the context window, as a fraction, that a conversation's attachments may take.
+	maxAttachedShare = 0.25
+	// urlCacheTTL is how long the contents fetched from a URL are reused.
+	urlCacheTTL = 10 * time.Minute
+	// fetchTimeout limits how long fetching a URL may take.
+	fetchTimeout = 30 * time.Second
+)
+
+// An Attachment is context pinned into a conversation: the contents of a file or web page,
+// which the model sees with every request, after the system prompt, until it is detached.
+// Attachments survive compaction. They are copies, taken when they were attached.
+type Attachment struct {
+	// Name is the file's path, relative to the directory it was attached from, or the URL.
+	Name      string    `json:"name"`
+	Content   string    `json:"content"`
+	Truncated bool      `json:"truncated,omitempty"` // Content is the first MaxAttachmentSize bytes
+	Time      time.Time `json:"time"`
+}
+
+// Attach pins the contents of sources into the conversation; see Attachment.
+// A source is a file, a directory, whose files are attached, a glob, which may use ** to match any number
+// of directories, or an http or https URL, whose contents are fetched. Paths are relative to dir.
+// Attaching a file or URL again replaces its earlier attachment.
+// Attach attaches what it can, and returns what it attached, along with the errors for the rest.
+func (c *Convo) Attach(ctx context.Context, dir string, sources ...string) ([]Attachment, error) {
+	var attached []Attachment
+	var errs error
+	for _, source := range sources {
+		atts, err := resolveAttachment(ctx, dir, source)
+		if err == nil {
+			err = c.AddAttachments(atts...)
+		}
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("cannot attach %s: %w", source, err))
+			continue
+		}
+		attached = append(attached, atts...)
+	}
+	return attached, errs
+}
+
+// AddAttachments pins atts, whose contents are already known, such as another conversation's attachments,
+// into the conversation. Each replaces any attachment of the same name.
+// The attachments may take up to MaxAttachedSize bytes, and a quarter of the model's context window, in all.
+func (c *Convo) AddAttachments(atts ...Attachment) error {
+	all := withAttachments(c.Attachments(), atts)
+	total := 0
+	for _, a := range all {
+		total += len(a.Content)
+	}
+	if total > MaxAttachedSize {
+		return fmt.Errorf("attachments would take %d bytes, more than the %d allowed; detach some first", total, MaxAttachedSize)
+	}
+	if err := c.checkAttachedTokens(all); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attachments = withAttachments(c.attachments, atts)
+	return nil
+}
+
+// checkAttachedTokens returns an error if atts would take more than maxAttachedShare of the context window.
+func (c *Convo) checkAttachedTokens(atts []Attachment) error {
+	if c.Service == nil {
+		return nil
+	}
+	window := llm.CapabilitiesOf(c.Service).ContextWindow
+	if window <= 0 {
+		return nil
+	}
+	text := attachmentsText(atts)
+	limit := int(maxAttachedShare * float64(window))
+	if tokens := c.countTokens(c.Ctx, textRequest(text), c.estimator().Text(text), limit); tokens > limit {
+		return fmt.Errorf("attachments would take about %d tokens, more than the %d allowed, a quarter of the context window; detach some first", tokens, limit)
+	}
+	return nil
+}
+
+// withAttachments returns a copy of atts with added appended, each replacing any attachment of the same name.
+func withAttachments(atts, added []Attachment) []Attachment {
+	kept := slices.DeleteFunc(slices.Clone(atts), func(a Attachment) bool {
+		return slices.ContainsFunc(added, func(b Attachment) bool { return a.Name == b.Name })
+	})
+	return append(kept, added...)
+}
+
+// Detach unpins the attachments with the given names, or all of them if there are no names,
+// and returns how many it detached.
+func (c *Convo) Detach(names ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	before := len(c.attachments)
+	if len(names) == 0 {
+		c.attachments = nil
+	} else {
+		c.attachments = slices.DeleteFunc(c.attachments, func(a Attachment) bool { return slices.Contains(names, a.Name) })
+	}
+	return before - len(c.attachments)
+}
+
+// Attachments returns the conversation's attachments, in the order they were attached.
+func (c *Convo) Attachments() []Attachment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.attachments)
+}
+
+// attachmentsSystemContent returns the system prompt block that shows the model the attachments,
+// or false if there are none.
+func (c *Convo) attachmentsSystemContent() (llm.SystemContent, bool) {
+	atts := c.Attachments()
+	if len(atts) == 0 {
+		return llm.SystemContent{}, false
+	}
+	return llm.SystemContent{Type: "text", Text: attachmentsText(atts)}, true
+}
+
+// attachmentsText returns the text that shows the model atts.
+func attachmentsText(atts []Attachment) string {
+	buf := new(strings.Builder)
+	buf.WriteString("<attachments>\nThe user attached these files and pages for you to refer to. They are copies taken when they were attached; files may have changed since.\n")
+	for _, a := range atts {
+		truncated := ""
+		if a.Truncated {
+			truncated = ` truncated="true"`
+		}
+		fmt.Fprintf(buf, "<attachment name=%q%s>\n%s", a.Name, truncated, a.Content)
+		if !strings.HasSuffix(a.Content, "\n") {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("</attachment>\n")
+	}
+	buf.WriteString("</attachments>")
+	return buf.String()
+}
+
+// resolveAttachment returns the attachments for source; see Attach.
+func resolveAttachment(ctx context.Context, dir, source string) ([]Attachment, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		a, err := fetchAttachment(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		return []Attachment{a}, nil
+	}
+	if strings.ContainsAny(source, "*?[") {
+		return globAttachments(dir, source)
+	}
+	p := source
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		a, err := fileAttachment(p, source)
+		if err != nil {
+			return nil, err
+		}
+		return []Attachment{a}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(p, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if f != p && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fileAttachments(dir, files)
+}
+
+// globAttachments returns the attachments for the files that pattern matches, relative to dir.
+// Besides the syntax of path.Match, a ** component matches any number of directories.
+func globAttachments(dir, pattern string) ([]Attachment, error) {
+	pattern = filepath.ToSlash(pattern)
+	root := dir
+	if path.IsAbs(pattern) {
+		root = "/"
+	}
+	// Walk only from the part of the pattern without wildcards.
+	parts := strings.Split(pattern, "/")
+	fixed := 0
+	for fixed < len(parts)-1 && !strings.ContainsAny(parts[fixed], "*?[") {
+		fixed++
+	}
+	start := filepath.Join(root, filepath.FromSlash(strings.Join(parts[:fixed], "/")))
+	// As in the shell, wildcards match hidden files only if the pattern spells out the dot.
+	dotted := slices.ContainsFunc(parts[fixed:], func(p string) bool { return strings.HasPrefix(p, ".") })
+	var files []string
+	err := filepath.WalkDir(start, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if f == start {
+				return err
+			}
+			return nil
+		}
+		if f != start && strings.HasPrefix(d.Name(), ".") && !dotted {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, f)
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if root == "/" {
+			rel = "/" + rel
+		}
+		if matchGlob(parts, strings.Split(filepath.ToSlash(rel), "/")) {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match")
+	}
+	return fileAttachments(dir, files)
+}
+
+// matchGlob reports whether the components of a path match those of a pattern, in which ** matches any number of them.
+func matchGlob(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchGlob(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], name[0])
+	return ok && matchGlob(pattern[1:], name[1:])
+}
+
+// fileAttachments returns the attachments for files, named relative to dir, skipping binary files.
+func fileAttachments(dir string, files []string) ([]Attachment, error) {
+	if len(files) > MaxAttachedFiles {
+		return nil, fmt.Errorf("%d files is more than the %d allowed; attach fewer", len(files), MaxAttachedFiles)
+	}
+	var atts []Attachment
+	for _, f := range files {
+		name := f
+		if rel, err := filepath.Rel(dir, f); err == nil && filepath.IsLocal(rel) {
+			name = filepath.ToSlash(rel)
+		}
+		a, err := fileAttachment(f, name)
+		if errors.Is(err, errBinary) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		atts = append(atts, a)
+	}
+	if len(atts) == 0 {
+		return nil, fmt.Errorf("no text files to attach")
+	}
+	return atts, nil
+}
+
+var errBinary = errors.New("binary file")
+
+// fileAttachment reads the file at p, to attach it as name.
+func fileAttachment(p, name string) (Attachment, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, MaxAttachmentSize+1))
+	if err != nil {
+		return Attachment{}, err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return Attachment{}, errBinary
+	}
+	return newAttachment(name, string(data)), nil
+}
+
+// newAttachment returns an attachment of content, truncated to MaxAttachmentSize.
+func newAttachment(name, content string) Attachment {
+	a := Attachment{Name: name, Content: content, Time: time.Now()}
+	if len(a.Content) > MaxAttachmentSize {
+		a.Content = strings.ToValidUTF8(a.Content[:MaxAttachmentSize], "")
+		a.Truncated = true
+	}
+	return a
+}
+
+// urlCache holds the contents recently fetched from URLs, so that attaching a URL again,
+// as each message that mentions it does, does not fetch it again.
+var urlCache struct {
+	sync.Mutex
+	entries map[string]Attachment
+}
+
+// fetchAttachment fetches the contents of url. Web pages are reduced to their text.
+func fetchAttachment(ctx context.Context, url string) (Attachment, error) {
+	urlCache.Lock()
+	a, ok := urlCache.entries[url]
+	urlCache.Unlock()
+	if ok && time.Since(a.Time) < urlCacheTTL {
+		return a, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Attachment{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Attachment{}, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	// Markup takes up much of a web page; read more of it, to have as much text left.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4*MaxAttachmentSize))
+	if err != nil {
+		return Attachment{}, err
+	}
+	var content string
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		content = htmlText(body)
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/json", strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/xml", strings.HasSuffix(mediaType, "+xml"), mediaType == "application/javascript",
+		mediaType == "" && !bytes.Contains(body, []byte{0}):
+		content = string(body)
+	default:
+		return Attachment{}, fmt.Errorf("%s is %s, not text", url, mediaType)
+	}
+	a = newAttachment(url, content)
+	urlCache.Lock()
+	defer urlCache.Unlock()
+	if urlCache.entries == nil {
+		urlCache.entries = make(map[string]Attachment)
+	}
+	for u, e := range urlCache.entries {
+		if time.Since(e.Time) >= urlCacheTTL {
+			delete(urlCache.entries, u)
+		}
+	}
+	urlCache.entries[url] = a
+	return a, nil
+}
+
+// htmlText returns the text of an HTML page, a paragraph to a line, without scripts and styles.
+func htmlText(page []byte) string {
+	buf := new(strings.Builder)
+	z := html.NewTokenizer(bytes.NewReader(page))
+	skip := 0 // depth inside elements whose text is not shown
+	newline := func() {
+		if s := buf.String(); len(s) > 0 && !strings.HasSuffix(s, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(buf.String())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "noscript", "svg", "template":
+				skip++
+			case "p", "div", "br", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "pre", "section", "article", "header", "footer", "table", "ul", "ol":
+				newline()
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "noscript", "svg", "template":
+				skip = max(skip-1, 0)
+			case "p", "div", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "pre":
+				newline()
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			if text := strings.Join(strings.Fields(string(z.Text())), " "); text != "" {
+				if s := buf.String(); len(s) > 0 && !strings.HasSuffix(s, "\n") {
+					buf.WriteString(" ")
+				}
+				buf.WriteString(text)
+			}
+		}
+	}
+}
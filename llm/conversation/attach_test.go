@@ -0,0 +1,209 @@
+package conversation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func attachmentNames(atts []Attachment) []string {
+	var names []string
+	for _, a := range atts {
+		names = append(names, a.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestAttach(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":          "package main\n",
+		"docs/a.md":        "# A\n",
+		"docs/deep/b.md":   "# B\n",
+		"docs/logo.png":    "\x89PNG\x00\x00",
+		"docs/.hidden.md":  "secret\n",
+		"big.txt":          strings.Repeat("x", MaxAttachmentSize+10),
+		"docs/deep/c.txt":  "c\n",
+		"docs/.git/config": "[core]\n",
+	})
+
+	for _, tt := range []struct {
+		source string
+		want   []string
+	}{
+		{"main.go", []string{"main.go"}},
+		{"docs", []string{"docs/a.md", "docs/deep/b.md", "docs/deep/c.txt"}},
+		{"docs/*.md", []string{"docs/a.md"}},
+		{"docs/**/*.md", []string{"docs/a.md", "docs/deep/b.md"}},
+		{"**/c.txt", []string{"docs/deep/c.txt"}},
+	} {
+		convo := New(context.Background(), &echoService{}, nil)
+		atts, err := convo.Attach(context.Background(), dir, tt.source)
+		if err != nil {
+			t.Errorf("Attach(%q): %v", tt.source, err)
+			continue
+		}
+		if got := attachmentNames(atts); !slices.Equal(got, tt.want) {
+			t.Errorf("Attach(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+
+	convo := New(context.Background(), &echoService{}, nil)
+	atts, err := convo.Attach(context.Background(), dir, "big.txt", "missing.go", "docs/*.rs", "main.go")
+	if err == nil || !strings.Contains(err.Error(), "missing.go") || !strings.Contains(err.Error(), "docs/*.rs") {
+		t.Errorf("Attach error = %v, want errors for missing.go and docs/*.rs", err)
+	}
+	if got := attachmentNames(atts); !slices.Equal(got, []string{"big.txt", "main.go"}) {
+		t.Fatalf("attached %q despite errors, want big.txt and main.go", got)
+	}
+	if !atts[0].Truncated || len(atts[0].Content) != MaxAttachmentSize {
+		t.Errorf("big.txt: truncated = %v, %d bytes; want truncated to %d", atts[0].Truncated, len(atts[0].Content), MaxAttachmentSize)
+	}
+
+	// Attaching a file again replaces it.
+	writeFiles(t, dir, map[string]string{"main.go": "package main // v2\n"})
+	if _, err := convo.Attach(context.Background(), dir, "main.go"); err != nil {
+		t.Fatal(err)
+	}
+	if got := convo.Attachments(); len(got) != 2 || got[1].Content != "package main // v2\n" {
+		t.Errorf("after attaching main.go again, attachments = %+v", got)
+	}
+
+	// The total is limited.
+	if _, err := convo.Attach(context.Background(), dir, "big.txt", "docs/deep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := convo.AddAttachments(Attachment{Name: "huge", Content: strings.Repeat("y", MaxAttachedSize)}); err == nil {
+		t.Errorf("AddAttachments beyond MaxAttachedSize succeeded")
+	}
+
+	if n := convo.Detach("main.go", "nope"); n != 1 {
+		t.Errorf("Detach(main.go, nope) = %d, want 1", n)
+	}
+	if n := convo.Detach(); n != 3 || len(convo.Attachments()) != 0 {
+		t.Errorf("Detach() = %d, leaving %d; want 3, leaving none", n, len(convo.Attachments()))
+	}
+}
+
+func TestAttachURL(t *testing.T) {
+	var fetches int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html><head><style>p{}</style><script>var x;</script></head><body><h1>Title</h1><p>Some   <b>bold</b> text.</p></body></html>"))
+		case "/data.json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"a": 1}`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	convo := New(context.Background(), &echoService{}, nil)
+	atts, err := convo.Attach(context.Background(), "", ts.URL+"/page", ts.URL+"/data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Title\nSome bold text."; atts[0].Content != want {
+		t.Errorf("page text = %q, want %q", atts[0].Content, want)
+	}
+	if atts[1].Content != `{"a": 1}` {
+		t.Errorf("JSON = %q", atts[1].Content)
+	}
+	for _, source := range []string{ts.URL + "/image", ts.URL + "/missing"} {
+		if _, err := convo.Attach(context.Background(), "", source); err == nil {
+			t.Errorf("Attach(%s) succeeded", source)
+		}
+	}
+
+	// Recently fetched URLs come from the cache.
+	fetches = 0
+	if _, err := New(context.Background(), &echoService{}, nil).Attach(context.Background(), "", ts.URL+"/page"); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 0 {
+		t.Errorf("attaching a URL again fetched it %d times, want 0", fetches)
+	}
+}
+
+func TestAttachmentsInRequests(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"notes.md": "remember the milk"})
+
+	srv := &windowService{inputTokens: 10}
+	convo := New(context.Background(), srv, nil)
+	convo.SystemPrompt = "be helpful"
+	convo.Compaction = &Compaction{KeepMessages: 2}
+	if _, err := convo.Attach(context.Background(), dir, "notes.md"); err != nil {
+		t.Fatal(err)
+	}
+	convo.messages = append(convo.messages, llm.UserStringMessage("do the thing"))
+	convo.messages = append(convo.messages, toolRoundTrip("t1", "output")...)
+	convo.messages = append(convo.messages, toolRoundTrip("t2", "output")...)
+	convo.messages = append(convo.messages, toolRoundTrip("t3", "output")...)
+
+	// Compact before the second request, and check that both requests carry the attachment.
+	srv.inputTokens = 900
+	if _, err := convo.SendUserTextMessage("continue"); err != nil {
+		t.Fatal(err)
+	}
+	srv.inputTokens = 10
+	if _, err := convo.SendUserTextMessage("and again"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 3 {
+		t.Fatalf("got %d requests, want 3 (send, summarize, send)", len(srv.requests))
+	}
+	for _, i := range []int{0, 2} {
+		system := srv.requests[i].System
+		if len(system) != 2 || system[0].Text != "be helpful" {
+			t.Fatalf("request %d system = %+v, want the prompt and the attachments", i, system)
+		}
+		if !strings.Contains(system[1].Text, "<attachment name=\"notes.md\">\nremember the milk\n</attachment>") || !system[1].Cache {
+			t.Errorf("request %d attachments block = %+v", i, system[1])
+		}
+	}
+	if system := srv.requests[1].System; len(system) != 1 {
+		t.Errorf("summarization request system = %+v, want no attachments", system)
+	}
+
+	// Attachments survive a snapshot.
+	data, err := convo.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(context.Background(), srv, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Attachments(); len(got) != 1 || got[0].Content != "remember the milk" {
+		t.Errorf("restored attachments = %+v", got)
+	}
+}
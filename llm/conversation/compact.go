@@ -0,0 +1,234 @@
+package conversation
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// Compaction configures automatic compaction of a conversation's history.
+//
+// When the most recent request used more than Threshold of the service's context window,
+// SendMessage first compacts the conversation: older messages are summarized by the LLM
+// and replaced by that summary, so the session continues instead of overflowing the window.
+type Compaction struct {
+	// Threshold is the fraction of the context window at which compaction starts.
+	// Values <= 0 default to 0.85.
+	Threshold float64 `json:"threshold,omitempty"`
+	// KeepMessages is the minimum number of recent messages kept verbatim.
+	// Values <= 0 default to 6.
+	KeepMessages int `json:"keep_messages,omitempty"`
+	// Compacted, if set, is called after the conversation is compacted,
+	// with the summary and the number of messages it replaced. Snapshots do not keep it.
+	Compacted func(summary string, dropped int) `json:"-"`
+}
+
+const compactionSystemPrompt = `You are compacting the history of a conversation between a user and a coding agent.
+The history will be replaced by your summary, so the agent can continue working from it alone.
+
+Summarize concisely but completely:
+1. The user's goals and any constraints or preferences they stated.
+2. Work completed so far: files created or modified, commands run and their important outcomes.
+3. Key findings and decisions, and why they were made.
+4. Open problems and the next steps.
+
+Reply with ONLY the summary.`
+
+const compactionRequest = "Summarize the conversation so far, as instructed."
+
+// PinToolResult marks the result of the given tool use as important.
+// Pinned tool results are kept verbatim when the conversation is compacted.
+// Tools can pin their own result using ToolCallInfoFromContext.
+func (c *Convo) PinToolResult(toolUseID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pinned == nil {
+		c.pinned = make(map[string]bool)
+	}
+	c.pinned[toolUseID] = true
+}
+
+// PinRecentToolResults pins the results of the n most recent tool calls whose results are in the conversation
+// (see PinToolResult), and returns how many it pinned, which is fewer than n if there are fewer.
+// The results of the calls still running, such as the caller's own, are not in the conversation yet.
+func (c *Convo) PinRecentToolResults(n int) int {
+	var ids []string
+	for i := len(c.messages) - 1; i >= 0 && len(ids) < n; i-- {
+		contents := c.messages[i].Content
+		for j := len(contents) - 1; j >= 0 && len(ids) < n; j-- {
+			if contents[j].Type == llm.ContentTypeToolResult {
+				ids = append(ids, contents[j].ToolUseID)
+			}
+		}
+	}
+	for _, id := range ids {
+		c.PinToolResult(id)
+	}
+	return len(ids)
+}
+
+// pinnedPrefix starts the text that a pinned tool result becomes when the conversation is compacted.
+const pinnedPrefix = "<pinned_tool_result "
+
+// needsCompaction reports whether the last request came close enough to the
+// context window that the conversation should be compacted before the next one.
+func (c *Convo) needsCompaction() bool {
+	if c.Compaction == nil {
+		return false
+	}
+	window := c.Service.TokenContextWindow()
+	if window <= 0 {
+		return false
+	}
+	threshold := c.Compaction.Threshold
+	if threshold <= 0 {
+		threshold = 0.85
+	}
+	u := c.LastUsage()
+	used := u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens + u.OutputTokens
+	return float64(used) >= threshold*float64(window)
+}
+
+// Compact replaces older messages in the conversation with an LLM-generated summary.
+// The most recent messages (see Compaction.KeepMessages) are kept verbatim,
+// as are any pinned tool results (see PinToolResult).
+// Compact is a no-op if there is too little history to compact.
+func (c *Convo) Compact() error {
+	keep := 6
+	if c.Compaction != nil && c.Compaction.KeepMessages > 0 {
+		keep = c.Compaction.KeepMessages
+	}
+	cut := compactionCut(c.messages, keep)
+	if cut <= 0 {
+		return nil
+	}
+	older := dropRedundantToolOutputs(c.messages[:cut])
+
+	sub := c.SubConvo()
+	sub.Hidden = true
+	sub.Task = "compaction"
+	sub.SystemPrompt = compactionSystemPrompt
+	sub.messages = older
+	resp, err := sub.SendMessage(llm.UserStringMessage(compactionRequest))
+	if err != nil {
+		return fmt.Errorf("failed to summarize conversation for compaction: %w", err)
+	}
+	var summary strings.Builder
+	for _, part := range resp.Content {
+		if part.Type == llm.ContentTypeText {
+			summary.WriteString(part.Text)
+		}
+	}
+
+	prefix := []llm.Content{llm.StringContent("<conversation_summary>\n" + summary.String() + "\n</conversation_summary>")}
+	c.mu.Lock()
+	pinned := maps.Clone(c.pinned)
+	c.mu.Unlock()
+	for _, text := range pinnedToolResults(older, pinned) {
+		prefix = append(prefix, llm.StringContent(text))
+	}
+
+	kept := slices.Clone(c.messages[cut:])
+	first := kept[0]
+	first.Content = append(prefix, toolResultsAsText(first.Content)...)
+	kept[0] = first
+
+	slog.InfoContext(c.Ctx, "convo_compacted", "dropped_messages", cut, "kept_messages", len(kept))
+	c.messages = kept
+	c.mu.Lock()
+	c.lastUsage = llm.Usage{}
+	c.mu.Unlock()
+	if c.Compaction != nil && c.Compaction.Compacted != nil {
+		c.Compaction.Compacted(summary.String(), cut)
+	}
+	return nil
+}
+
+// compactionCut returns the index of the first message to keep,
+// or 0 if there is nothing to compact.
+// The kept history always starts with a user message.
+func compactionCut(msgs []llm.Message, keep int) int {
+	for cut := len(msgs) - keep; cut > 0; cut-- {
+		if msgs[cut].Role == llm.MessageRoleUser {
+			return cut
+		}
+	}
+	return 0
+}
+
+// toolResultsAsText converts tool results into plain text content.
+// It is used for the first kept message, whose matching tool uses are being dropped.
+func toolResultsAsText(contents []llm.Content) []llm.Content {
+	var out []llm.Content
+	for _, content := range contents {
+		if content.Type != llm.ContentTypeToolResult {
+			out = append(out, content)
+			continue
+		}
+		out = append(out, llm.StringContent("Result of an earlier tool call:\n"+toolResultText(content)))
+	}
+	return out
+}
+
+// toolResultText returns the concatenated text of a tool result.
+func toolResultText(content llm.Content) string {
+	var parts []string
+	for _, r := range content.ToolResult {
+		if r.Type == llm.ContentTypeText {
+			parts = append(parts, r.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// pinnedToolResults returns the text of all pinned tool results in msgs, labeled with the tool that produced them,
+// including those that an earlier compaction kept.
+func pinnedToolResults(msgs []llm.Message, pinned map[string]bool) []string {
+	toolNames := make(map[string]string)
+	var out []string
+	for _, msg := range msgs {
+		for _, content := range msg.Content {
+			switch content.Type {
+			case llm.ContentTypeText:
+				if strings.HasPrefix(content.Text, pinnedPrefix) {
+					out = append(out, content.Text)
+				}
+			case llm.ContentTypeToolUse:
+				toolNames[content.ID] = content.ToolName
+			case llm.ContentTypeToolResult:
+				if pinned[content.ToolUseID] {
+					out = append(out, fmt.Sprintf(pinnedPrefix+"tool=%q>\n%s\n</pinned_tool_result>", toolNames[content.ToolUseID], toolResultText(content)))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// dropRedundantToolOutputs returns a copy of msgs in which tool results whose
+// text is repeated verbatim by a later tool result are elided.
+// Repeated outputs (re-reading a file, re-running a passing test) are common
+// and add nothing to a summary.
+func dropRedundantToolOutputs(msgs []llm.Message) []llm.Message {
+	seen := make(map[string]bool)
+	out := slices.Clone(msgs)
+	for i := len(out) - 1; i >= 0; i-- {
+		var contents []llm.Content
+		for _, content := range out[i].Content {
+			if content.Type == llm.ContentTypeToolResult {
+				text := toolResultText(content)
+				if text != "" && seen[text] {
+					content.ToolResult = []llm.Content{llm.StringContent("[output omitted: identical to a later tool result]")}
+				}
+				seen[text] = true
+			}
+			contents = append(contents, content)
+		}
+		out[i].Content = contents
+	}
+	return out
+}
@@ -0,0 +1,173 @@
+package conversation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+// windowService is an llm.Service with a tiny context window that reports
+// every request as using inputTokens tokens.
+type windowService struct {
+	inputTokens uint64
+	requests    []*llm.Request
+}
+
+func (s *windowService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	s.requests = append(s.requests, req)
+	text := "reply"
+	if len(req.System) > 0 && req.System[0].Text == compactionSystemPrompt {
+		text = "the summary"
+	}
+	return &llm.Response{
+		Role:       llm.MessageRoleAssistant,
+		Content:    []llm.Content{llm.StringContent(text)},
+		StopReason: llm.StopReasonEndTurn,
+		Usage:      llm.Usage{InputTokens: s.inputTokens},
+	}, nil
+}
+
+func (s *windowService) TokenContextWindow() int { return 1000 }
+
+func toolRoundTrip(id, output string) []llm.Message {
+	return []llm.Message{
+		{Role: llm.MessageRoleAssistant, Content: []llm.Content{{Type: llm.ContentTypeToolUse, ID: id, ToolName: "bash"}}},
+		{Role: llm.MessageRoleUser, Content: []llm.Content{{Type: llm.ContentTypeToolResult, ToolUseID: id, ToolResult: llm.TextContent(output)}}},
+	}
+}
+
+func TestCompact(t *testing.T) {
+	srv := &windowService{inputTokens: 10}
+	convo := New(context.Background(), srv, nil)
+	convo.Compaction = &Compaction{KeepMessages: 2}
+	convo.messages = append(convo.messages, llm.UserStringMessage("do the thing"))
+	convo.messages = append(convo.messages, toolRoundTrip("t1", "same output")...)
+	convo.messages = append(convo.messages, toolRoundTrip("t2", "important output")...)
+	convo.messages = append(convo.messages, toolRoundTrip("t3", "same output")...)
+	convo.messages = append(convo.messages, toolRoundTrip("t4", "latest output")...)
+	convo.PinToolResult("t2")
+
+	// Below the threshold, nothing happens.
+	if convo.needsCompaction() {
+		t.Fatal("needsCompaction with no usage")
+	}
+	srv.inputTokens = 900
+	if _, err := convo.SendUserTextMessage("continue"); err != nil {
+		t.Fatal(err)
+	}
+	if !convo.needsCompaction() {
+		t.Fatal("needsCompaction = false at 90% of the window")
+	}
+
+	srv.inputTokens = 10
+	if _, err := convo.SendUserTextMessage("and again"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 3 {
+		t.Fatalf("got %d requests, want 3 (send, summarize, send)", len(srv.requests))
+	}
+
+	// The summarization request elides the earlier duplicate output.
+	var elided int
+	for _, msg := range srv.requests[1].Messages {
+		for _, c := range msg.Content {
+			if c.Type == llm.ContentTypeToolResult && strings.Contains(toolResultText(c), "output omitted") {
+				elided++
+			}
+		}
+	}
+	if elided != 1 {
+		t.Errorf("summarization request elided %d results, want 1", elided)
+	}
+
+	// The final request starts with the summary and pinned result, and contains no orphaned tool results.
+	final := srv.requests[2].Messages
+	first := final[0]
+	if first.Role != llm.MessageRoleUser {
+		t.Fatalf("first message role = %v, want user", first.Role)
+	}
+	var text strings.Builder
+	for _, c := range first.Content {
+		if c.Type == llm.ContentTypeToolResult {
+			t.Errorf("first message contains a tool result for a dropped tool use")
+		}
+		text.WriteString(c.Text)
+	}
+	for _, want := range []string{"the summary", "important output"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("compacted history missing %q: %q", want, text.String())
+		}
+	}
+	if len(final) >= 12 {
+		t.Errorf("history was not shortened: %d messages", len(final))
+	}
+}
+
+func TestCompactNothingToDo(t *testing.T) {
+	srv := &windowService{}
+	convo := New(context.Background(), srv, nil)
+	convo.messages = []llm.Message{llm.UserStringMessage("hi"), {Role: llm.MessageRoleAssistant, Content: llm.TextContent("hello")}}
+	if err := convo.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 0 || len(convo.messages) != 2 {
+		t.Errorf("Compact changed a short conversation: %d requests, %d messages", len(srv.requests), len(convo.messages))
+	}
+}
+
+func TestPinRecentToolResultsAcrossCompactions(t *testing.T) {
+	srv := &windowService{}
+	convo := New(context.Background(), srv, nil)
+	var compacted []string
+	convo.Compaction = &Compaction{KeepMessages: 1, Compacted: func(summary string, dropped int) {
+		compacted = append(compacted, summary)
+	}}
+	convo.messages = append(convo.messages, llm.UserStringMessage("do the thing"))
+	convo.messages = append(convo.messages, toolRoundTrip("t1", "the spec")...)
+	convo.messages = append(convo.messages, toolRoundTrip("t2", "noise")...)
+	if n := convo.PinRecentToolResults(1); n != 1 {
+		t.Fatalf("PinRecentToolResults(1) = %d", n)
+	}
+	if n := convo.PinRecentToolResults(5); n != 2 {
+		t.Fatalf("PinRecentToolResults(5) = %d, want the 2 there are", n)
+	}
+
+	// Pins survive a snapshot.
+	data, err := convo.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	convo, err = Restore(context.Background(), srv, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convo.Compaction = &Compaction{KeepMessages: 1, Compacted: func(summary string, dropped int) {
+		compacted = append(compacted, summary)
+	}}
+
+	// The pinned results are kept through one compaction and then another.
+	for range 2 {
+		convo.messages = append(convo.messages, toolRoundTrip("later", "more noise")...)
+		convo.messages = append(convo.messages, llm.UserStringMessage("go on"))
+		if err := convo.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(compacted) != 2 || compacted[0] != "the summary" {
+		t.Errorf("Compacted calls = %q, want two with the summary", compacted)
+	}
+	var text strings.Builder
+	for _, c := range convo.messages[0].Content {
+		text.WriteString(c.Text)
+	}
+	for _, want := range []string{"the spec", "noise"} {
+		if strings.Count(text.String(), want) < 1 {
+			t.Errorf("after two compactions, history lost pinned %q: %q", want, text.String())
+		}
+	}
+	if strings.Count(text.String(), pinnedPrefix) != 2 {
+		t.Errorf("after two compactions, history has %d pinned results, want 2: %q", strings.Count(text.String(), pinnedPrefix), text.String())
+	}
+}
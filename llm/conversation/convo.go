@@ -1,6 +1,7 @@
 package conversation
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,17 +9,42 @@ import (
 	"log/slog"
 	"maps"
 	"math/rand/v2"
+	"runtime/debug"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/oklog/ulid/v2"
 	"github.com/richardlehane/crock32"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"sketch.dev/llm"
+	"sketch.dev/llm/llmcache"
+	"sketch.dev/metrics"
 	"sketch.dev/skribe"
 )
 
+// tracer records a span for each LLM request and tool call.
+// Attributes follow the OpenTelemetry semantic conventions for generative AI where they apply.
+var tracer = otel.Tracer("sketch.dev/llm/conversation")
+
+var (
+	llmRequests = metrics.NewCounter("sketch_llm_requests_total",
+		"LLM requests, by model and status (ok or error). The model of a failed request is the conversation's last model, if any.",
+		"model", "status")
+	llmTokens = metrics.NewCounter("sketch_llm_tokens_total",
+		"LLM tokens, by model and kind (input, output, cache_read, or cache_creation).",
+		"model", "kind")
+	llmCost      = metrics.NewCounter("sketch_llm_cost_usd_total", "LLM cost in US dollars, by model.", "model")
+	toolDuration = metrics.NewHistogram("sketch_tool_call_duration_seconds",
+		"Tool call latency, by tool and outcome (ok or error).",
+		metrics.DefaultBuckets, "tool", "outcome")
+)
+
 type Listener interface {
 	// TODO: Content is leaking an anthropic API; should we avoid it?
 	// TODO: Where should we include start/end time and usage?
@@ -26,6 +52,12 @@ type Listener interface {
 	OnToolResult(ctx context.Context, convo *Convo, toolCallID string, toolName string, toolInput json.RawMessage, content llm.Content, result *string, err error)
 	OnRequest(ctx context.Context, convo *Convo, requestID string, msg *llm.Message)
 	OnResponse(ctx context.Context, convo *Convo, requestID string, msg *llm.Response)
+	// OnPartialToolUse is called as the input of a tool call streams in, before the response is complete.
+	// Only services that stream responses call it.
+	OnPartialToolUse(ctx context.Context, convo *Convo, tu llm.PartialToolUse)
+	// OnTextDelta is called with each piece of response text as it is generated.
+	// Only services that stream responses call it.
+	OnTextDelta(ctx context.Context, convo *Convo, text string)
 }
 
 type NoopListener struct{}
@@ -38,7 +70,9 @@ func (n *NoopListener) OnToolResult(ctx context.Context, convo *Convo, id string
 
 func (n *NoopListener) OnResponse(ctx context.Context, convo *Convo, id string, msg *llm.Response) {
 }
-func (n *NoopListener) OnRequest(ctx context.Context, convo *Convo, id string, msg *llm.Message) {}
+func (n *NoopListener) OnRequest(ctx context.Context, convo *Convo, id string, msg *llm.Message)  {}
+func (n *NoopListener) OnPartialToolUse(ctx context.Context, convo *Convo, tu llm.PartialToolUse) {}
+func (n *NoopListener) OnTextDelta(ctx context.Context, convo *Convo, text string)                {}
 
 var ErrDoNotRespond = errors.New("do not respond")
 
@@ -64,7 +98,9 @@ type Convo struct {
 	// PromptCaching indicates whether to use Anthropic's prompt caching.
 	// See https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching#continuing-a-multi-turn-conversation
 	// for the documentation. At request send time, we set the cache_control field on the
-	// last message. We also cache the system prompt.
+	// last message. We also cache the system prompt and the tool schemas.
+	// Providers that cache prompt prefixes automatically (OpenAI, Gemini) ignore these markers,
+	// but all providers report cache hits in llm.Usage.CacheReadInputTokens.
 	// Default: true.
 	PromptCaching bool
 	// ToolUseOnly indicates whether Claude may only use tools during this conversation.
@@ -84,6 +120,32 @@ type Convo struct {
 	Hidden bool
 	// ExtraData is extra data to make available to all tool calls.
 	ExtraData map[string]any
+	// Compaction, if non-nil, enables automatic compaction of the conversation
+	// history when it nears the service's context window.
+	Compaction *Compaction
+	// ToolResultLimit, if non-nil, reduces tool results that are too large
+	// before they are added to the conversation.
+	ToolResultLimit *ToolResultLimit
+	// MaxParallelToolCalls is the most tool calls from one response that run at once.
+	// Values <= 0 default to 8.
+	MaxParallelToolCalls int
+	// ResponseCache, if non-nil, stores responses to requests made with CacheResponses set.
+	// It is inherited by sub-conversations.
+	ResponseCache *llmcache.Cache
+	// Redact, if non-nil, rewrites the text of tool results before listeners see them
+	// and they are added to the conversation, such as to remove secrets.
+	// It is inherited by sub-conversations.
+	Redact func(string) string
+	// CacheResponses indicates that this conversation's requests are deterministic,
+	// so a cached response to an identical request may be reused instead of making a new one.
+	// Set it only for one-shot lookups whose answer depends solely on the request,
+	// such as summarizing a repository's commit message style.
+	// It is not inherited by sub-conversations.
+	CacheResponses bool
+	// Task names the kind of work this conversation does, such as "compaction",
+	// so that an llm.Router can send its requests to a suitable model.
+	// It is not inherited by sub-conversations.
+	Task string
 
 	// messages tracks the messages so far in the conversation.
 	messages []llm.Message
@@ -91,8 +153,11 @@ type Convo struct {
 	// Listener receives messages being sent.
 	Listener Listener
 
+	// toolUseCancelMu protects toolUseCancel and requestCancel.
 	toolUseCancelMu sync.Mutex
 	toolUseCancel   map[string]context.CancelCauseFunc
+	// requestCancel aborts the in-flight request to the service, if any.
+	requestCancel context.CancelCauseFunc
 
 	// Protects usage. This is used for subconversations (that share part of CumulativeUsage) as well.
 	mu *sync.Mutex
@@ -100,6 +165,21 @@ type Convo struct {
 	usage *CumulativeUsage
 	// lastUsage tracks the usage from the most recent API call
 	lastUsage llm.Usage
+	// lastModel is the model that answered the most recent API call
+	lastModel string
+	// pinned is the set of tool use IDs whose results survive compaction.
+	pinned map[string]bool
+	// attachments is the context the user pinned into the conversation; see Attach. Protected by mu.
+	attachments []Attachment
+	// disabledTools and toolOverrides change the tools offered to the model; see DisableTools and OverrideTool.
+	// Protected by mu.
+	disabledTools map[string]bool
+	toolOverrides map[string]ToolOverride
+
+	// queuedMu protects queued.
+	queuedMu sync.Mutex
+	// queued holds user messages waiting to be sent with the next tool results.
+	queued []string
 }
 
 // newConvoID generates a new 8-byte random id.
@@ -152,6 +232,8 @@ func (c *Convo) SubConvo() *Convo {
 		Listener:      c.Listener,
 		ID:            id,
 		toolUseCancel: map[string]context.CancelCauseFunc{},
+		ResponseCache: c.ResponseCache,
+		Redact:        c.Redact,
 		// Do not copy Budget. Each budget is independent,
 		// and OverBudget checks whether any ancestor is over budget.
 	}
@@ -166,10 +248,12 @@ func (c *Convo) SubConvoWithHistory() *Convo {
 		Parent:        c,
 		// For convenience, sub-convo usage shares tool uses map with parent,
 		// all other fields separate, propagated in AddResponse
-		usage:    newUsageWithSharedToolUses(c.usage),
-		mu:       c.mu,
-		Listener: c.Listener,
-		ID:       id,
+		usage:         newUsageWithSharedToolUses(c.usage),
+		mu:            c.mu,
+		Listener:      c.Listener,
+		ID:            id,
+		ResponseCache: c.ResponseCache,
+		Redact:        c.Redact,
 		// Do not copy Budget. Each budget is independent,
 		// and OverBudget checks whether any ancestor is over budget.
 		messages: slices.Clone(c.messages),
@@ -211,6 +295,11 @@ func (c *Convo) messageRequest(msg llm.Message) *llm.Request {
 		}
 		system = []llm.SystemContent{d}
 	}
+	if a, ok := c.attachmentsSystemContent(); ok {
+		// Attachments change more often than the system prompt; cache up to them as well.
+		a.Cache = c.PromptCaching
+		system = append(system, a)
+	}
 
 	// Claude is happy to return an empty response in response to our Done() call,
 	// and, if so, you'll see something like:
@@ -225,10 +314,29 @@ func (c *Convo) messageRequest(msg llm.Message) *llm.Request {
 		}
 	}
 
+	tools := c.activeTools()
+	if c.PromptCaching && len(tools) > 0 {
+		// Tool schemas rarely change during a conversation, so cache them too.
+		// Copy the last tool rather than mutating c.Tools, which may be shared.
+		tools = slices.Clone(tools)
+		last := *tools[len(tools)-1]
+		last.Cache = true
+		tools[len(tools)-1] = &last
+	}
+
+	messages := append(nonEmptyMessages, msg) // not yet committed to keeping msg
+	caps := llm.CapabilitiesOf(c.Service)
+	if !caps.Vision {
+		messages = withoutImages(messages)
+	}
+	if !caps.Tools {
+		tools = nil
+	}
+
 	mr := &llm.Request{
-		Messages: append(nonEmptyMessages, msg), // not yet committed to keeping msg
+		Messages: messages,
 		System:   system,
-		Tools:    c.Tools,
+		Tools:    tools,
 	}
 	if c.ToolUseOnly {
 		mr.ToolChoice = &llm.ToolChoice{Type: llm.ToolChoiceTypeAny}
@@ -236,12 +344,42 @@ func (c *Convo) messageRequest(msg llm.Message) *llm.Request {
 	return mr
 }
 
+// withoutImages returns messages with images replaced by a note saying they were omitted,
+// for models that do not accept images. It does not modify messages.
+func withoutImages(messages []llm.Message) []llm.Message {
+	out := make([]llm.Message, len(messages))
+	for i, m := range messages {
+		m.Content = contentWithoutImages(m.Content)
+		out[i] = m
+	}
+	return out
+}
+
+func contentWithoutImages(contents []llm.Content) []llm.Content {
+	out := make([]llm.Content, len(contents))
+	for i, c := range contents {
+		if c.MediaType != "" {
+			c.Text = strings.TrimSpace(c.Text + "\n[image omitted: this model does not accept images]")
+			c.MediaType = ""
+			c.Data = ""
+		}
+		if len(c.ToolResult) > 0 {
+			c.ToolResult = contentWithoutImages(c.ToolResult)
+		}
+		out[i] = c
+	}
+	return out
+}
+
 func (c *Convo) findTool(name string) (*llm.Tool, error) {
-	for _, tool := range c.Tools {
+	for _, tool := range c.activeTools() {
 		if tool.Name == name {
 			return tool, nil
 		}
 	}
+	if slices.ContainsFunc(c.Tools, func(t *llm.Tool) bool { return t.Name == name }) {
+		return nil, fmt.Errorf("tool %q is disabled", name)
+	}
 	return nil, fmt.Errorf("tool %q not found", name)
 }
 
@@ -296,8 +434,197 @@ func (c *Convo) insertMissingToolResults(mr *llm.Request, msg *llm.Message) {
 // SendMessage sends a message to Claude.
 // The conversation records (internally) all messages succesfully sent and received.
 func (c *Convo) SendMessage(msg llm.Message) (*llm.Response, error) {
+	return c.sendMessage(c.Ctx, msg, nil, nil)
+}
+
+// SendMessageContext is like SendMessage, but abandons the request when ctx is done,
+// as when the user interrupts the model mid-response to steer it.
+// The interrupted exchange stays in the conversation:
+// msg, followed by whatever the model had said so far, marked as interrupted.
+// The error is then an *InterruptedError.
+func (c *Convo) SendMessageContext(ctx context.Context, msg llm.Message) (*llm.Response, error) {
+	return c.sendMessage(ctx, msg, nil, nil)
+}
+
+// QueueUserMessage queues text from the user to be sent along with the next tool results,
+// so that the user can steer the model while its tools run, without canceling them.
+// If the model ends its turn without calling more tools, the text stays queued;
+// see TakeQueuedUserMessages.
+func (c *Convo) QueueUserMessage(text string) {
+	c.queuedMu.Lock()
+	defer c.queuedMu.Unlock()
+	c.queued = append(c.queued, text)
+}
+
+// TakeQueuedUserMessages removes and returns the user messages that are queued but not yet sent.
+func (c *Convo) TakeQueuedUserMessages() []string {
+	c.queuedMu.Lock()
+	defer c.queuedMu.Unlock()
+	queued := c.queued
+	c.queued = nil
+	return queued
+}
+
+// withQueuedUserMessages returns msg with the queued user messages appended, if msg carries tool results.
+// Tool results are the safe point for them: they must directly follow the tool calls,
+// and the model reads everything after them before it decides what to do next.
+func (c *Convo) withQueuedUserMessages(msg llm.Message) llm.Message {
+	if !slices.ContainsFunc(msg.Content, func(c llm.Content) bool { return c.Type == llm.ContentTypeToolResult }) {
+		return msg
+	}
+	queued := c.TakeQueuedUserMessages()
+	if len(queued) == 0 {
+		return msg
+	}
+	msg.Content = slices.Clone(msg.Content)
+	for _, text := range queued {
+		msg.Content = append(msg.Content, llm.StringContent("While you were working, the user sent this message:\n\n"+text))
+	}
+	return msg
+}
+
+// An InterruptedError reports that a request was abandoned before the model finished responding.
+type InterruptedError struct {
+	// Partial is the text the model had streamed before it was interrupted.
+	Partial string
+	// Cause is why the request was abandoned: the cause of its context's cancellation.
+	Cause error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("llm response interrupted: %v", e.Cause)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Cause
+}
+
+// interruptedMessage is the assistant message recorded for a response that was interrupted after partial,
+// because of cause.
+func interruptedMessage(partial string, cause error) llm.Message {
+	note := "interrupted by the user"
+	if cerr := (*CancelError)(nil); errors.As(cause, &cerr) {
+		note = cerr.Error()
+	}
+	text := "[" + note + " before responding]"
+	if partial = strings.TrimSpace(partial); partial != "" {
+		text = partial + "\n\n[" + note + "]"
+	}
+	return llm.Message{Role: llm.MessageRoleAssistant, Content: []llm.Content{llm.StringContent(text)}}
+}
+
+// A CancelError is the cause of the requests and tool calls aborted by Cancel.
+type CancelError struct {
+	Reason string
+}
+
+func (e *CancelError) Error() string {
+	if e.Reason == "" {
+		return "interrupted by the user"
+	}
+	return "interrupted by the user: " + e.Reason
+}
+
+// ToolErrorKind classifies tool calls aborted by Cancel as denied by the user.
+func (e *CancelError) ToolErrorKind() llm.ToolErrorKind {
+	return llm.ToolErrorUserDenied
+}
+
+// Cancel aborts c's in-flight request to the service, if any, and its running tool calls,
+// with a *CancelError for reason as the cause.
+// Tools see their context canceled; the bash tool kills the command's whole process group.
+//
+// The interruption is recorded so that the next turn knows what happened:
+// an aborted SendMessage returns an *InterruptedError and leaves the exchange in the conversation,
+// marked as interrupted, and aborted tool calls report the CancelError as their result.
+// Cancel does not affect requests or tool calls started after it returns.
+func (c *Convo) Cancel(reason string) {
+	cause := &CancelError{Reason: reason}
+	c.toolUseCancelMu.Lock()
+	defer c.toolUseCancelMu.Unlock()
+	slog.InfoContext(c.Ctx, "convo_canceled", "reason", reason, "request", c.requestCancel != nil, "tool_calls", len(c.toolUseCancel))
+	if c.requestCancel != nil {
+		c.requestCancel(cause)
+		c.requestCancel = nil
+	}
+	for id, cancel := range c.toolUseCancel {
+		cancel(cause)
+		delete(c.toolUseCancel, id)
+	}
+}
+
+// do sends mr to the service, or answers it from the response cache if c.CacheResponses is set.
+// Cached responses report zero usage: they cost nothing.
+// If ctx is done, or c is canceled, before the service responds, do returns an *InterruptedError.
+func (c *Convo) do(ctx context.Context, mr *llm.Request) (*llm.Response, error) {
+	// Keep c.Ctx's values, such as logging attributes, but also stop when ctx is done.
+	reqCtx, cancel := context.WithCancelCause(c.Ctx)
+	defer cancel(nil)
+	defer context.AfterFunc(ctx, func() { cancel(context.Cause(ctx)) })()
+	c.toolUseCancelMu.Lock()
+	c.requestCancel = cancel
+	c.toolUseCancelMu.Unlock()
+	defer func() {
+		c.toolUseCancelMu.Lock()
+		c.requestCancel = nil
+		c.toolUseCancelMu.Unlock()
+	}()
+
+	var partial strings.Builder
+	reqCtx = llm.WithPartialToolUseFunc(reqCtx, func(tu llm.PartialToolUse) {
+		c.Listener.OnPartialToolUse(c.Ctx, c, tu)
+	})
+	reqCtx = llm.WithTextDeltaFunc(reqCtx, func(text string) {
+		partial.WriteString(text)
+		c.Listener.OnTextDelta(c.Ctx, c, text)
+	})
+	if c.Task != "" {
+		reqCtx = llm.WithTask(reqCtx, c.Task)
+	}
+	resp, err := c.cachedDo(reqCtx, mr)
+	if err != nil && reqCtx.Err() != nil && c.Ctx.Err() == nil {
+		return nil, &InterruptedError{Partial: partial.String(), Cause: context.Cause(reqCtx)}
+	}
+	return resp, err
+}
+
+func (c *Convo) cachedDo(ctx context.Context, mr *llm.Request) (*llm.Response, error) {
+	if !c.CacheResponses || c.ResponseCache == nil {
+		return c.Service.Do(ctx, mr)
+	}
+	if resp, ok := c.ResponseCache.Get(mr); ok {
+		slog.InfoContext(c.Ctx, "llm_response_cache_hit", "model", resp.Model)
+		resp.Usage = llm.Usage{}
+		return resp, nil
+	}
+	resp, err := c.Service.Do(ctx, mr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ResponseCache.Put(mr, resp); err != nil {
+		slog.WarnContext(c.Ctx, "llm_response_cache_put_failed", "err", err)
+	}
+	return resp, nil
+}
+
+// sendMessage sends msg, abandoning the request if ctx is done.
+// If toolChoice is non-nil, it overrides the conversation's tool choice for this request.
+// If outputSchema is non-nil, the response must be JSON that follows it.
+func (c *Convo) sendMessage(ctx context.Context, msg llm.Message, toolChoice *llm.ToolChoice, outputSchema json.RawMessage) (*llm.Response, error) {
+	msg = c.withQueuedUserMessages(msg)
+	if c.needsCompaction() {
+		if err := c.Compact(); err != nil {
+			// Carry on; the request may still fit.
+			slog.WarnContext(c.Ctx, "convo_compaction_failed", "err", err)
+		}
+	}
+	msg = c.fitContextWindow(msg)
 	id := ulid.Make().String()
 	mr := c.messageRequest(msg)
+	if toolChoice != nil {
+		mr.ToolChoice = toolChoice
+	}
+	mr.OutputSchema = outputSchema
 	var lastMessage *llm.Message
 	if c.PromptCaching {
 		lastMessage = &mr.Messages[len(mr.Messages)-1]
@@ -316,8 +643,16 @@ func (c *Convo) SendMessage(msg llm.Message) (*llm.Response, error) {
 	c.insertMissingToolResults(mr, &msg)
 	c.Listener.OnRequest(c.Ctx, c, id, &msg)
 
+	ctx, span := tracer.Start(ctx, "chat", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("gen_ai.operation.name", "chat"),
+		attribute.String("sketch.convo_id", c.ID),
+	))
+	defer span.End()
+	if c.Task != "" {
+		span.SetAttributes(attribute.String("sketch.task", c.Task))
+	}
 	startTime := time.Now()
-	resp, err := c.Service.Do(c.Ctx, mr)
+	resp, err := c.do(ctx, mr)
 	if resp != nil {
 		resp.StartTime = &startTime
 		endTime := time.Now()
@@ -325,18 +660,56 @@ func (c *Convo) SendMessage(msg llm.Message) (*llm.Response, error) {
 	}
 
 	if err != nil {
+		if ierr := (*InterruptedError)(nil); errors.As(err, &ierr) {
+			slog.InfoContext(c.Ctx, "convo_response_interrupted", "cause", ierr.Cause, "partial_len", len(ierr.Partial))
+			c.messages = append(c.messages, msg, interruptedMessage(ierr.Partial, ierr.Cause))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.mu.Lock()
+		model := cmp.Or(c.lastModel, "unknown")
+		c.mu.Unlock()
+		llmRequests.Inc(model, "error")
 		c.Listener.OnResponse(c.Ctx, c, id, nil)
 		return nil, err
 	}
 	c.messages = append(c.messages, msg, resp.ToMessage())
+	if resp.Usage.CostUSD == 0 {
+		// No cost reported by the service (e.g. when not going through skaband);
+		// estimate it from list prices.
+		if pricing, ok := llm.PricingFor(resp.Model); ok {
+			resp.Usage.CostUSD = pricing.Cost(resp.Usage)
+		}
+	}
 	// Propagate usage to all ancestors (including us).
+	c.mu.Lock()
 	for x := c; x != nil; x = x.Parent {
-		x.usage.Add(resp.Usage)
+		x.usage.Add(resp.Model, resp.Usage)
 		// Store the most recent usage (only on the current conversation, not ancestors)
 		if x == c {
 			x.lastUsage = resp.Usage
+			x.lastModel = resp.Model
 		}
 	}
+	total := c.usage.TotalCostUSD
+	c.mu.Unlock()
+	slog.InfoContext(c.Ctx, "llm_usage", "model", resp.Model, resp.Usage.Attr(), slog.Float64("convo_total_cost_usd", total))
+	llmRequests.Inc(resp.Model, "ok")
+	llmTokens.Add(float64(resp.Usage.InputTokens), resp.Model, "input")
+	llmTokens.Add(float64(resp.Usage.OutputTokens), resp.Model, "output")
+	llmTokens.Add(float64(resp.Usage.CacheReadInputTokens), resp.Model, "cache_read")
+	llmTokens.Add(float64(resp.Usage.CacheCreationInputTokens), resp.Model, "cache_creation")
+	llmCost.Add(resp.Usage.CostUSD, resp.Model)
+	span.SetName("chat " + resp.Model)
+	span.SetAttributes(
+		attribute.String("gen_ai.response.model", resp.Model),
+		attribute.StringSlice("gen_ai.response.finish_reasons", []string{resp.StopReason.String()}),
+		attribute.Int64("gen_ai.usage.input_tokens", int64(resp.Usage.InputTokens)),
+		attribute.Int64("gen_ai.usage.output_tokens", int64(resp.Usage.OutputTokens)),
+		attribute.Int64("sketch.usage.cache_read_input_tokens", int64(resp.Usage.CacheReadInputTokens)),
+		attribute.Int64("sketch.usage.cache_creation_input_tokens", int64(resp.Usage.CacheCreationInputTokens)),
+		attribute.Float64("sketch.cost_usd", resp.Usage.CostUSD),
+	)
 	c.Listener.OnResponse(c.Ctx, c, id, resp)
 	return resp, err
 }
@@ -356,6 +729,11 @@ func ToolCallInfoFromContext(ctx context.Context) ToolCallInfo {
 	return i
 }
 
+// ContextWithToolCallInfo returns a copy of ctx carrying info, for running a tool outside a Convo's tool loop.
+func ContextWithToolCallInfo(ctx context.Context, info ToolCallInfo) context.Context {
+	return context.WithValue(ctx, toolCallInfoKey, info)
+}
+
 func (c *Convo) ToolResultCancelContents(resp *llm.Response) ([]llm.Content, error) {
 	if resp.StopReason != llm.StopReasonToolUse {
 		return nil, nil
@@ -374,6 +752,7 @@ func (c *Convo) ToolResultCancelContents(resp *llm.Response) ([]llm.Content, err
 		}
 
 		content.ToolError = true
+		content.ToolErrorKind = llm.ToolErrorUserDenied
 		content.ToolResult = []llm.Content{{
 			Type: llm.ContentTypeText,
 			Text: "user canceled this tool_use",
@@ -408,19 +787,36 @@ func (c *Convo) newToolUseContext(ctx context.Context, toolUseID string) (contex
 	return ctx, func() { c.CancelToolUse(toolUseID, nil) }
 }
 
-// ToolResultContents runs all tool uses requested by the response and returns their results.
+// ToolResultContents runs all tool uses requested by the response and returns their results,
+// in the order the tools were called.
+// The tools run concurrently, at most MaxParallelToolCalls at a time, except that calls to serial tools
+// (see llm.Tool.Serial), which have side effects, keep their place in the order the calls were made:
+// each waits for every call before it, and every call after it waits for it.
 // Cancelling ctx will cancel any running tool calls.
-// The boolean return value indicates whether any of the executed tools should end the turn.
+// The boolean return value indicates whether any of the executed tools should end the turn,
+// or whether the user denied or interrupted any of the calls.
+//
+// Failed calls' results have the llm.ToolErrorKind of their error.
+// A tool that panics fails as crashed, and calls that fail because a provider failed
+// are retried, up to maxToolRetries times.
+// Calls that time out or produce too much output are retried once with the input their tool's Retry adjusts,
+// before the failure goes to the model.
 func (c *Convo) ToolResultContents(ctx context.Context, resp *llm.Response) ([]llm.Content, bool, error) {
 	if resp.StopReason != llm.StopReasonToolUse {
 		return nil, false, nil
 	}
 	// Extract all tool calls from the response, call the tools, and gather the results.
 	var wg sync.WaitGroup
-	toolResultC := make(chan llm.Content, len(resp.Content))
+	results := make([]*llm.Content, len(resp.Content))
+	sem := make(chan struct{}, c.maxParallelToolCalls())
+	// lastSerial is closed when the latest call to a serial tool finishes.
+	var lastSerial chan struct{}
+	// sinceSerial are closed when the calls made since then finish.
+	var sinceSerial []chan struct{}
 
 	endsTurn := false
-	for _, part := range resp.Content {
+	var denied atomic.Bool
+	for i, part := range resp.Content {
 		if part.Type != llm.ContentTypeToolUse {
 			continue
 		}
@@ -428,6 +824,15 @@ func (c *Convo) ToolResultContents(ctx context.Context, resp *llm.Response) ([]l
 		if err == nil && tool.EndsTurn {
 			endsTurn = true
 		}
+		// A call waits for the latest serial call, which waited for the calls before it;
+		// a serial call also waits for the calls made since.
+		prev, done := []chan struct{}{lastSerial}, make(chan struct{})
+		if err == nil && tool.Serial {
+			prev = append(prev, sinceSerial...)
+			lastSerial, sinceSerial = done, nil
+		} else {
+			sinceSerial = append(sinceSerial, done)
+		}
 		c.incrementToolUse(part.ToolName)
 		startTime := time.Now()
 
@@ -440,6 +845,28 @@ func (c *Convo) ToolResultContents(ctx context.Context, resp *llm.Response) ([]l
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			defer close(done)
+			for _, p := range prev {
+				if p == nil {
+					continue
+				}
+				select {
+				case <-p:
+				case <-ctx.Done():
+				}
+			}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+			}
+			ctx, span := tracer.Start(ctx, "execute_tool "+part.ToolName, trace.WithAttributes(
+				attribute.String("gen_ai.operation.name", "execute_tool"),
+				attribute.String("gen_ai.tool.name", part.ToolName),
+				attribute.String("gen_ai.tool.call.id", part.ID),
+				attribute.String("sketch.convo_id", c.ID),
+			))
+			defer span.End()
 
 			content := llm.Content{
 				Type:             llm.ContentTypeToolResult,
@@ -452,25 +879,38 @@ func (c *Convo) ToolResultContents(ctx context.Context, resp *llm.Response) ([]l
 				content.ToolUseEndTime = &endTime
 
 				content.ToolError = true
-				content.ToolResult = []llm.Content{{
+				content.ToolErrorKind = llm.ToolErrorKindOf(err)
+				if content.ToolErrorKind == llm.ToolErrorUserDenied {
+					denied.Store(true)
+				}
+				content.ToolResult = c.redact([]llm.Content{{
 					Type: llm.ContentTypeText,
 					Text: err.Error(),
-				}}
+				}})
+				if text := content.ToolResult[0].Text; text != err.Error() {
+					err = errors.New(text) // keep secrets out of the listener's view of err too
+				}
+				span.SetStatus(codes.Error, err.Error())
+				toolDuration.Observe(endTime.Sub(startTime).Seconds(), part.ToolName, "error")
 				c.Listener.OnToolResult(ctx, c, part.ID, part.ToolName, part.ToolInput, content, nil, err)
-				toolResultC <- content
+				content.ToolResult = c.limitToolResult(ctx, part.ToolName, part.ToolInput, content.ToolResult)
+				results[i] = &content
 			}
 			sendRes := func(toolResult []llm.Content) {
 				// Record end time
 				endTime := time.Now()
 				content.ToolUseEndTime = &endTime
 
+				toolDuration.Observe(endTime.Sub(startTime).Seconds(), part.ToolName, "ok")
+				toolResult = c.redact(toolResult)
 				content.ToolResult = toolResult
 				var firstText string
 				if len(toolResult) > 0 {
 					firstText = toolResult[0].Text
 				}
 				c.Listener.OnToolResult(ctx, c, part.ID, part.ToolName, part.ToolInput, content, &firstText, nil)
-				toolResultC <- content
+				content.ToolResult = c.limitToolResult(ctx, part.ToolName, part.ToolInput, toolResult)
+				results[i] = &content
 			}
 
 			tool, err := c.findTool(part.ToolName)
@@ -482,9 +922,31 @@ func (c *Convo) ToolResultContents(ctx context.Context, resp *llm.Response) ([]l
 			// cancel function so that it can be canceled individually.
 			toolUseCtx, cancel := c.newToolUseContext(ctx, part.ID)
 			defer cancel()
+			if toolUseCtx.Err() != nil {
+				sendErr(context.Cause(toolUseCtx))
+				return
+			}
 			// TODO: move this into newToolUseContext?
-			toolUseCtx = context.WithValue(toolUseCtx, toolCallInfoKey, ToolCallInfo{ToolUseID: part.ID, Convo: c})
-			toolResult, err := tool.Run(toolUseCtx, part.ToolInput)
+			toolUseCtx = ContextWithToolCallInfo(toolUseCtx, ToolCallInfo{ToolUseID: part.ID, Convo: c})
+			toolResult, err := runTool(toolUseCtx, tool, part.ToolInput)
+			for attempt := 1; attempt <= maxToolRetries && err != nil && toolUseCtx.Err() == nil && llm.ToolErrorKindOf(err).Retryable(); attempt++ {
+				slog.InfoContext(ctx, "tool_call_retried", "tool", part.ToolName, "attempt", attempt, "error", err)
+				if llm.Sleep(toolUseCtx, toolRetryBackoff.Delay(attempt, err)) != nil {
+					break
+				}
+				toolResult, err = runTool(toolUseCtx, tool, part.ToolInput)
+			}
+			if kind := llm.ToolErrorKindOf(err); tool.Retry != nil && toolUseCtx.Err() == nil && retriedWithAdjustedInput(kind) {
+				if input, note, ok := tool.Retry(err, part.ToolInput); ok {
+					slog.InfoContext(ctx, "tool_call_adjusted", "tool", part.ToolName, "kind", kind, "note", note)
+					toolResult, err = runTool(toolUseCtx, tool, input)
+					if err != nil {
+						err = fmt.Errorf("%s\n%w", note, err)
+					} else {
+						toolResult = append([]llm.Content{llm.StringContent(note + "\n")}, toolResult...)
+					}
+				}
+			}
 			if errors.Is(err, ErrDoNotRespond) {
 				return
 			}
@@ -501,15 +963,60 @@ func (c *Convo) ToolResultContents(ctx context.Context, resp *llm.Response) ([]l
 		}()
 	}
 	wg.Wait()
-	close(toolResultC)
 	var toolResults []llm.Content
-	for toolResult := range toolResultC {
-		toolResults = append(toolResults, toolResult)
+	for _, r := range results {
+		if r != nil {
+			toolResults = append(toolResults, *r)
+		}
 	}
 	if ctx.Err() != nil {
 		return nil, false, ctx.Err()
 	}
-	return toolResults, endsTurn, nil
+	return toolResults, endsTurn || denied.Load(), nil
+}
+
+const maxToolRetries = 1
+
+// toolRetryBackoff spaces out retries of tool calls that failed because a provider failed.
+var toolRetryBackoff = llm.Backoff{Base: time.Second, Max: 5 * time.Second}
+
+// retriedWithAdjustedInput reports whether a call that failed with an error of kind
+// is retried with the input its tool's Retry adjusts.
+// Left to the model, such failures cost a turn to make the same adjustment.
+func retriedWithAdjustedInput(kind llm.ToolErrorKind) bool {
+	return kind == llm.ToolErrorTimeout || kind == llm.ToolErrorOutputTooLarge
+}
+
+// runTool runs tool with input.
+// A panic in the tool fails the call as crashed, rather than taking down the process.
+func runTool(ctx context.Context, tool *llm.Tool, input json.RawMessage) (result []llm.Content, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "tool_panicked", "tool", tool.Name, "panic", r, "stack", string(debug.Stack()))
+			err = llm.NewToolError(llm.ToolErrorCrashed, fmt.Errorf("the %s tool crashed: %v", tool.Name, r))
+		}
+	}()
+	return tool.Run(ctx, input)
+}
+
+// redact returns contents with c.Redact applied to their text.
+func (c *Convo) redact(contents []llm.Content) []llm.Content {
+	if c.Redact == nil {
+		return contents
+	}
+	contents = slices.Clone(contents)
+	for i := range contents {
+		contents[i].Text = c.Redact(contents[i].Text)
+	}
+	return contents
+}
+
+// maxParallelToolCalls returns c.MaxParallelToolCalls, or its default.
+func (c *Convo) maxParallelToolCalls() int {
+	if c.MaxParallelToolCalls <= 0 {
+		return 8
+	}
+	return c.MaxParallelToolCalls
 }
 
 func (c *Convo) incrementToolUse(name string) {
@@ -528,7 +1035,10 @@ type CumulativeUsage struct {
 	CacheReadInputTokens     uint64         `json:"cache_read_input_tokens"`
 	CacheCreationInputTokens uint64         `json:"cache_creation_input_tokens"`
 	TotalCostUSD             float64        `json:"total_cost_usd"`
-	ToolUses                 map[string]int `json:"tool_uses"` // tool name -> number of uses
+	Retries                  uint64         `json:"retries,omitempty"` // requests retried after retryable failures
+	ToolUses                 map[string]int `json:"tool_uses"`         // tool name -> number of uses
+	// Models breaks down usage by the model that reported it.
+	Models map[string]llm.Usage `json:"models,omitempty"`
 }
 
 func newUsage() *CumulativeUsage {
@@ -542,10 +1052,20 @@ func newUsageWithSharedToolUses(parent *CumulativeUsage) *CumulativeUsage {
 func (u *CumulativeUsage) Clone() CumulativeUsage {
 	v := *u
 	v.ToolUses = maps.Clone(u.ToolUses)
+	v.Models = maps.Clone(u.Models)
 	return v
 }
 
+// CumulativeUsage returns the usage of c and all of its sub-conversations so far.
+//
+// Deprecated: Use Usage.
 func (c *Convo) CumulativeUsage() CumulativeUsage {
+	return c.Usage()
+}
+
+// Usage returns the usage of c and all of its sub-conversations so far.
+// Costs are as reported by the service or, failing that, estimated from llm.PricingFor.
+func (c *Convo) Usage() CumulativeUsage {
 	if c == nil {
 		return CumulativeUsage{}
 	}
@@ -564,6 +1084,15 @@ func (c *Convo) LastUsage() llm.Usage {
 	return c.lastUsage
 }
 
+// TotalToolCalls returns the total number of tool calls in u.
+func (u *CumulativeUsage) TotalToolCalls() int {
+	var n int
+	for _, count := range u.ToolUses {
+		n += count
+	}
+	return n
+}
+
 func (u *CumulativeUsage) WallTime() time.Duration {
 	return time.Since(u.StartTime)
 }
@@ -577,13 +1106,22 @@ func (u *CumulativeUsage) DollarsPerHour() float64 {
 	return u.TotalCostUSD / hours
 }
 
-func (u *CumulativeUsage) Add(usage llm.Usage) {
+// Add records usage from a single response by model.
+func (u *CumulativeUsage) Add(model string, usage llm.Usage) {
+	if u.Models == nil {
+		u.Models = make(map[string]llm.Usage)
+	}
+	m := u.Models[model]
+	m.Add(usage)
+	u.Models[model] = m
+
 	u.Responses++
 	u.InputTokens += usage.InputTokens
 	u.OutputTokens += usage.OutputTokens
 	u.CacheReadInputTokens += usage.CacheReadInputTokens
 	u.CacheCreationInputTokens += usage.CacheCreationInputTokens
 	u.TotalCostUSD += usage.CostUSD
+	u.Retries += usage.Retries
 }
 
 // TotalInputTokens returns the grand total cumulative input tokens in u.
@@ -603,6 +1141,7 @@ func (u CumulativeUsage) Attr() slog.Attr {
 		slog.Uint64("cache_creation_input_tokens", u.CacheCreationInputTokens),
 		slog.Float64("total_cost_usd", u.TotalCostUSD),
 		slog.Float64("dollars_per_hour", u.TotalCostUSD/elapsed.Hours()),
+		slog.Uint64("retries", u.Retries),
 		slog.Any("tool_uses", maps.Clone(u.ToolUses)),
 	)
 }
@@ -610,11 +1149,31 @@ func (u CumulativeUsage) Attr() slog.Attr {
 // A Budget represents the maximum amount of resources that may be spent on a conversation.
 // Note that the default (zero) budget is unlimited.
 type Budget struct {
-	MaxDollars float64 // if > 0, max dollars that may be spent
+	MaxDollars   float64       // if > 0, max dollars that may be spent
+	MaxTokens    uint64        // if > 0, max tokens (input, including cached, plus output)
+	MaxToolCalls int           // if > 0, max tool calls; tool calls are counted across the whole conversation tree
+	MaxWallTime  time.Duration // if > 0, max time since the conversation started, or since RestartWallTime
+}
+
+// A BudgetExceededError reports that a conversation exceeded one or more limits of its Budget.
+type BudgetExceededError struct {
+	Budget Budget
+	Usage  CumulativeUsage
+	// Limits describes each exceeded limit, e.g. "$2.10 spent, budget is $2.00".
+	Limits []string
+}
+
+func (e *BudgetExceededError) Error() string {
+	return strings.Join(e.Limits, "; ") + ". Continuing to chat will reset the budget."
+}
+
+// ToolErrorKind classifies tool calls that fail because a conversation, such as a subagent's, ran over budget.
+func (e *BudgetExceededError) ToolErrorKind() llm.ToolErrorKind {
+	return llm.ToolErrorBudgetExceeded
 }
 
 // OverBudget returns an error if the convo (or any of its parents) has exceeded its budget.
-// TODO: document parent vs sub budgets, multiple errors, etc, once we know the desired behavior.
+// The error is a *BudgetExceededError for the first (innermost) conversation that is over budget.
 func (c *Convo) OverBudget() error {
 	for x := c; x != nil; x = x.Parent {
 		if err := x.overBudget(); err != nil {
@@ -628,18 +1187,119 @@ func (c *Convo) OverBudget() error {
 // adjusts it by what's been used so far.
 func (c *Convo) ResetBudget(budget Budget) {
 	c.Budget = budget
+	usage := c.Usage()
 	if c.Budget.MaxDollars > 0 {
-		c.Budget.MaxDollars += c.CumulativeUsage().TotalCostUSD
+		c.Budget.MaxDollars += usage.TotalCostUSD
+	}
+	if c.Budget.MaxTokens > 0 {
+		c.Budget.MaxTokens += usage.TotalInputTokens() + usage.OutputTokens
+	}
+	if c.Budget.MaxToolCalls > 0 {
+		c.Budget.MaxToolCalls += usage.TotalToolCalls()
+	}
+	if c.Budget.MaxWallTime > 0 {
+		c.Budget.MaxWallTime += usage.WallTime()
+	}
+}
+
+// RestartWallTime restarts the wall time budget, so that MaxWallTime limits the time from now on.
+// The other limits are unchanged.
+func (c *Convo) RestartWallTime(maxWallTime time.Duration) {
+	if maxWallTime > 0 {
+		usage := c.Usage()
+		c.Budget.MaxWallTime = usage.WallTime() + maxWallTime
 	}
 }
 
 func (c *Convo) overBudget() error {
-	usage := c.CumulativeUsage()
+	usage := c.Usage()
 	// TODO: stop before we exceed the budget instead of after?
-	var err error
-	cont := "Continuing to chat will reset the budget."
+	var limits []string
 	if c.Budget.MaxDollars > 0 && usage.TotalCostUSD >= c.Budget.MaxDollars {
-		err = errors.Join(err, fmt.Errorf("$%.2f spent, budget is $%.2f. %s", usage.TotalCostUSD, c.Budget.MaxDollars, cont))
+		limits = append(limits, fmt.Sprintf("$%.2f spent, budget is $%.2f", usage.TotalCostUSD, c.Budget.MaxDollars))
+	}
+	if tokens := usage.TotalInputTokens() + usage.OutputTokens; c.Budget.MaxTokens > 0 && tokens >= c.Budget.MaxTokens {
+		limits = append(limits, fmt.Sprintf("%d tokens used, budget is %d", tokens, c.Budget.MaxTokens))
+	}
+	if calls := usage.TotalToolCalls(); c.Budget.MaxToolCalls > 0 && calls >= c.Budget.MaxToolCalls {
+		limits = append(limits, fmt.Sprintf("%d tool calls made, budget is %d", calls, c.Budget.MaxToolCalls))
+	}
+	if elapsed := usage.WallTime(); c.Budget.MaxWallTime > 0 && elapsed >= c.Budget.MaxWallTime {
+		limits = append(limits, fmt.Sprintf("%s elapsed, budget is %s", elapsed.Round(time.Second), c.Budget.MaxWallTime))
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+	return &BudgetExceededError{Budget: c.Budget, Usage: usage, Limits: limits}
+}
+
+// maxStructuredRepairs is how many times SendStructured asks the LLM to fix an invalid response.
+const maxStructuredRepairs = 2
+
+// SendStructured sends msg and requires the response to be a JSON value that follows schema,
+// which it unmarshals into v.
+// Services constrain their output to schema where they can; regardless, SendStructured
+// validates the response, and if it is invalid, tells the LLM what is wrong and asks again,
+// up to maxStructuredRepairs times.
+func (c *Convo) SendStructured(msg llm.Message, schema json.RawMessage, v any) (*llm.Response, error) {
+	resp, err := c.sendMessage(c.Ctx, msg, nil, schema)
+	for repairs := 0; ; repairs++ {
+		if err != nil {
+			return nil, err
+		}
+		out := json.RawMessage(structuredText(resp))
+		verr := llm.ValidateJSON(schema, out)
+		if verr == nil {
+			if err := json.Unmarshal(out, v); err != nil {
+				return resp, fmt.Errorf("structured output: %w", err)
+			}
+			return resp, nil
+		}
+		if repairs == maxStructuredRepairs {
+			return resp, fmt.Errorf("structured output still invalid after %d repairs: %w", repairs, verr)
+		}
+		slog.WarnContext(c.Ctx, "structured_output_invalid", "err", verr, "repairs", repairs)
+		repair := fmt.Sprintf("Your response is not valid: %v\nRespond again with only a JSON value that follows this schema:\n%s", verr, schema)
+		resp, err = c.sendMessage(c.Ctx, llm.UserStringMessage(repair), nil, schema)
+	}
+}
+
+// structuredText returns the JSON text of a structured response.
+// Models without schema-constrained decoding sometimes wrap their JSON in a Markdown code fence, so strip it.
+func structuredText(resp *llm.Response) string {
+	var text strings.Builder
+	for _, c := range resp.Content {
+		if c.Type == llm.ContentTypeText {
+			text.WriteString(c.Text)
+		}
+	}
+	s := strings.TrimSpace(text.String())
+	if rest, ok := strings.CutPrefix(s, "```"); ok {
+		rest = strings.TrimPrefix(rest, "json")
+		s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "```"))
+	}
+	return s
+}
+
+// wrapUpPrompt is sent by WrapUp.
+const wrapUpPrompt = `The budget for this session has been exhausted, and you cannot call any more tools.
+Stop working now. Do not start any new changes.
+Reply with a brief summary for the user of:
+1. What was done, including any files left partially edited.
+2. What remains undone, and the next steps to finish it.`
+
+// WrapUp sends a final message asking the LLM to stop working and summarize
+// what is done and what is left undone. It is intended for use when the
+// conversation is over budget, so that work ends with a report rather than
+// being cut off mid-edit.
+//
+// Tool use is disabled for the request. contents, typically the results of
+// the last tool calls, are sent along with the wrap-up instructions;
+// tool calls that have no result in contents are reported to the LLM as not executed.
+func (c *Convo) WrapUp(contents ...llm.Content) (*llm.Response, error) {
+	msg := llm.Message{
+		Role:    llm.MessageRoleUser,
+		Content: append(slices.Clone(contents), llm.StringContent(wrapUpPrompt)),
 	}
-	return err
+	return c.sendMessage(c.Ctx, msg, &llm.ToolChoice{Type: llm.ToolChoiceTypeNone}, nil)
 }
@@ -1,34 +1,35 @@
 package conversation
 
 import (
-	"cmp"
 	"context"
-	"net/http"
-	"os"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"sketch.dev/httprr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 	"sketch.dev/llm"
 	"sketch.dev/llm/ant"
+	"sketch.dev/llm/llmcache"
+	"sketch.dev/llm/llmtest"
 )
 
 func TestBasicConvo(t *testing.T) {
 	ctx := context.Background()
-	rr, err := httprr.Open("testdata/basic_convo.httprr", http.DefaultTransport)
-	if err != nil {
-		t.Fatal(err)
-	}
-	rr.ScrubReq(func(req *http.Request) error {
-		req.Header.Del("x-api-key")
-		return nil
-	})
-
-	apiKey := cmp.Or(os.Getenv("OUTER_SKETCH_MODEL_API_KEY"), os.Getenv("ANTHROPIC_API_KEY"))
+	rec := llmtest.Open(t, "testdata/basic_convo.httprr")
 	srv := &ant.Service{
-		APIKey: apiKey,
-		HTTPC:  rr.Client(),
+		APIKey: rec.APIKey("OUTER_SKETCH_MODEL_API_KEY", "ANTHROPIC_API_KEY"),
+		HTTPC:  rec.Client(),
 	}
 	convo := New(ctx, srv, nil)
 
@@ -296,3 +297,669 @@ func TestInsertMissingToolResults(t *testing.T) {
 		})
 	}
 }
+
+// pricedService reports usage without a cost, like a direct (non-skaband) API call.
+type pricedService struct{ model string }
+
+func (s *pricedService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	return &llm.Response{
+		Role:       llm.MessageRoleAssistant,
+		Model:      s.model,
+		Content:    []llm.Content{llm.StringContent("ok")},
+		StopReason: llm.StopReasonEndTurn,
+		Usage:      llm.Usage{InputTokens: 1_000_000, OutputTokens: 100_000},
+	}, nil
+}
+
+func (s *pricedService) TokenContextWindow() int { return 200000 }
+
+func TestUsageEstimatesCost(t *testing.T) {
+	convo := New(context.Background(), &pricedService{model: "claude-sonnet-4-20250514"}, nil)
+	sub := convo.SubConvo()
+	if _, err := sub.SendMessage(llm.UserStringMessage("hi")); err != nil {
+		t.Fatal(err)
+	}
+	sub.Service = &pricedService{model: "local"}
+	if _, err := sub.SendMessage(llm.UserStringMessage("again")); err != nil {
+		t.Fatal(err)
+	}
+
+	usage := convo.Usage()
+	if usage.TotalCostUSD != 4.5 { // $3 input + $1.50 output; the unpriced model is free
+		t.Errorf("TotalCostUSD = %v, want 4.5", usage.TotalCostUSD)
+	}
+	if usage.Responses != 2 || len(usage.Models) != 2 {
+		t.Fatalf("Usage = %+v, want 2 responses from 2 models", usage)
+	}
+	if got := usage.Models["claude-sonnet-4-20250514"]; got.InputTokens != 1_000_000 || got.CostUSD != 4.5 {
+		t.Errorf("Models[sonnet] = %+v", got)
+	}
+}
+
+func TestOverBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget Budget
+		want   string // substring of the error, or "" for no error
+	}{
+		{name: "unlimited", budget: Budget{}},
+		{name: "under", budget: Budget{MaxDollars: 10, MaxTokens: 100, MaxToolCalls: 5, MaxWallTime: time.Hour}},
+		{name: "dollars", budget: Budget{MaxDollars: 0.5}, want: "$0.50 spent"},
+		{name: "tokens", budget: Budget{MaxTokens: 12}, want: "12 tokens used"},
+		{name: "tool calls", budget: Budget{MaxToolCalls: 2}, want: "2 tool calls made"},
+		{name: "wall time", budget: Budget{MaxWallTime: time.Nanosecond}, want: "elapsed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			convo := New(context.Background(), &echoService{}, nil)
+			convo.Budget = tt.budget
+			if _, err := convo.SendMessage(llm.UserStringMessage("hi")); err != nil {
+				t.Fatal(err)
+			}
+			convo.incrementToolUse("bash")
+			convo.incrementToolUse("patch")
+
+			err := convo.SubConvo().OverBudget()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("OverBudget = %v, want nil", err)
+				}
+				return
+			}
+			var budgetErr *BudgetExceededError
+			if !errors.As(err, &budgetErr) {
+				t.Fatalf("OverBudget = %v, want BudgetExceededError", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("OverBudget = %q, want it to mention %q", err, tt.want)
+			}
+
+			if tt.budget.MaxWallTime > 0 {
+				return // time keeps passing, so a reset wall time budget is immediately exceeded again
+			}
+			convo.ResetBudget(tt.budget)
+			if err := convo.OverBudget(); err != nil {
+				t.Errorf("after ResetBudget, OverBudget = %v", err)
+			}
+		})
+	}
+}
+
+func TestRestartWallTime(t *testing.T) {
+	convo := New(context.Background(), &echoService{}, nil)
+	convo.Budget = Budget{MaxDollars: 10, MaxWallTime: time.Nanosecond}
+	time.Sleep(time.Millisecond)
+	if err := convo.OverBudget(); err == nil {
+		t.Fatal("OverBudget = nil, want the wall time exceeded")
+	}
+	convo.RestartWallTime(time.Hour)
+	if err := convo.OverBudget(); err != nil {
+		t.Errorf("after RestartWallTime, OverBudget = %v", err)
+	}
+	if convo.Budget.MaxDollars != 10 {
+		t.Errorf("RestartWallTime changed MaxDollars to %v", convo.Budget.MaxDollars)
+	}
+}
+
+func TestWrapUp(t *testing.T) {
+	srv := &echoService{}
+	convo := New(context.Background(), srv, nil)
+	result := llm.Content{Type: llm.ContentTypeToolResult, ToolUseID: "t1", ToolResult: []llm.Content{llm.StringContent("done")}}
+	if _, err := convo.WrapUp(result); err != nil {
+		t.Fatal(err)
+	}
+	req := srv.requests[0]
+	if req.ToolChoice == nil || req.ToolChoice.Type != llm.ToolChoiceTypeNone {
+		t.Errorf("WrapUp ToolChoice = %+v, want none", req.ToolChoice)
+	}
+	msg := req.Messages[len(req.Messages)-1]
+	if len(msg.Content) != 2 || msg.Content[0].ToolUseID != "t1" || !strings.Contains(msg.Content[1].Text, "budget") {
+		t.Errorf("WrapUp message = %+v, want the tool result followed by wrap-up instructions", msg.Content)
+	}
+}
+
+func TestPromptCachingTools(t *testing.T) {
+	srv := &echoService{}
+	convo := New(context.Background(), srv, nil)
+	convo.Tools = []*llm.Tool{{Name: "a"}, {Name: "b"}}
+	if _, err := convo.SendMessage(llm.UserStringMessage("hi")); err != nil {
+		t.Fatal(err)
+	}
+	tools := srv.requests[0].Tools
+	if tools[0].Cache || !tools[1].Cache {
+		t.Errorf("request tools cache = [%v %v], want only the last tool cached", tools[0].Cache, tools[1].Cache)
+	}
+	if convo.Tools[1].Cache {
+		t.Errorf("SendMessage modified convo.Tools")
+	}
+}
+
+// countingService counts requests.
+type countingService struct {
+	pricedService
+	calls int
+}
+
+func (s *countingService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	s.calls++
+	return s.pricedService.Do(ctx, req)
+}
+
+func TestResponseCache(t *testing.T) {
+	srv := &countingService{pricedService: pricedService{model: "claude-sonnet-4-20250514"}}
+	convo := New(context.Background(), srv, nil)
+	convo.ResponseCache = llmcache.New(t.TempDir(), "test", time.Hour)
+
+	ask := func(cache bool, text string) {
+		sub := convo.SubConvo()
+		sub.PromptCaching = false
+		sub.CacheResponses = cache
+		if _, err := sub.SendMessage(llm.UserStringMessage(text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ask(true, "style?")
+	ask(true, "style?")
+	ask(true, "other")
+	ask(false, "style?")
+	if srv.calls != 3 {
+		t.Errorf("service called %d times, want 3 (one cache hit)", srv.calls)
+	}
+	if cost := convo.Usage().TotalCostUSD; cost != 3*4.5 {
+		t.Errorf("TotalCostUSD = %v, want cache hits to be free", cost)
+	}
+}
+
+// scriptedService replies with its texts in order, recording requests.
+type scriptedService struct {
+	texts []string
+	reqs  []*llm.Request
+}
+
+func (s *scriptedService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	s.reqs = append(s.reqs, req)
+	text := s.texts[min(len(s.reqs), len(s.texts))-1]
+	return &llm.Response{
+		Role:       llm.MessageRoleAssistant,
+		Content:    []llm.Content{llm.StringContent(text)},
+		StopReason: llm.StopReasonEndTurn,
+	}, nil
+}
+
+func (s *scriptedService) TokenContextWindow() int { return 200000 }
+
+func TestSendStructured(t *testing.T) {
+	schema := llm.MustSchema(`{"type":"object","properties":{"ok":{"type":"boolean"}},"required":["ok"]}`)
+	srv := &scriptedService{texts: []string{`{"ok": "yes"}`, "```json\n{\"ok\": true}\n```"}}
+	convo := New(context.Background(), srv, nil)
+	var out struct{ OK bool }
+	if _, err := convo.SendStructured(llm.UserStringMessage("ok?"), schema, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.OK {
+		t.Errorf("out.OK = false, want true")
+	}
+	if len(srv.reqs) != 2 {
+		t.Fatalf("sent %d requests, want 2 (one repair)", len(srv.reqs))
+	}
+	for _, req := range srv.reqs {
+		if string(req.OutputSchema) != string(schema) {
+			t.Errorf("request OutputSchema = %s, want schema", req.OutputSchema)
+		}
+	}
+	repair := srv.reqs[1].Messages[len(srv.reqs[1].Messages)-1].Content[0].Text
+	if !strings.Contains(repair, "$.ok: got string, want boolean") {
+		t.Errorf("repair message does not explain the error: %q", repair)
+	}
+
+	srv = &scriptedService{texts: []string{"not json"}}
+	convo = New(context.Background(), srv, nil)
+	if _, err := convo.SendStructured(llm.UserStringMessage("ok?"), schema, &out); err == nil {
+		t.Error("SendStructured with persistently invalid output succeeded")
+	}
+	if len(srv.reqs) != 1+maxStructuredRepairs {
+		t.Errorf("sent %d requests, want %d", len(srv.reqs), 1+maxStructuredRepairs)
+	}
+}
+
+// blindService records requests and reports a model that accepts neither images nor tools.
+type blindService struct{ scriptedService }
+
+func (s *blindService) Capabilities() llm.Capabilities {
+	return llm.Capabilities{ContextWindow: 8192, MaxOutputTokens: 1024}
+}
+
+func TestCapabilitiesGateRequests(t *testing.T) {
+	srv := &blindService{scriptedService{texts: []string{"ok"}}}
+	convo := New(context.Background(), srv, nil)
+	convo.Tools = []*llm.Tool{{Name: "bash", InputSchema: llm.MustSchema(`{"type":"object"}`)}}
+	msg := llm.Message{Role: llm.MessageRoleUser, Content: []llm.Content{{
+		Type:       llm.ContentTypeToolResult,
+		ToolUseID:  "t1",
+		ToolResult: llm.ImageContent("screenshot", "image/png", "iVBORw0KGgo="),
+	}}}
+	if _, err := convo.SendMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	req := srv.reqs[0]
+	if req.Tools != nil {
+		t.Errorf("sent %d tools to a model without tool support", len(req.Tools))
+	}
+	got := req.Messages[len(req.Messages)-1].Content[0].ToolResult[0]
+	if got.MediaType != "" || got.Data != "" || !strings.Contains(got.Text, "image omitted") {
+		t.Errorf("image sent to a model without vision: %+v", got)
+	}
+	if msg.Content[0].ToolResult[0].Data == "" {
+		t.Errorf("withoutImages modified the caller's message")
+	}
+}
+
+// stallingService streams its text, then blocks until the request is abandoned.
+type stallingService struct {
+	text    string
+	started chan struct{}
+}
+
+func (s *stallingService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	if f := llm.TextDeltaFunc(ctx); f != nil {
+		f(s.text)
+	}
+	close(s.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *stallingService) TokenContextWindow() int { return 200000 }
+
+func TestSendMessageContextInterrupted(t *testing.T) {
+	srv := &stallingService{text: "Let me rewrite the parser", started: make(chan struct{})}
+	convo := New(context.Background(), srv, nil)
+
+	errSteer := errors.New("user steered")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() {
+		<-srv.started
+		cancel(errSteer)
+	}()
+	_, err := convo.SendMessageContext(ctx, llm.UserStringMessage("fix the bug"))
+	var ierr *InterruptedError
+	if !errors.As(err, &ierr) {
+		t.Fatalf("err = %v, want an *InterruptedError", err)
+	}
+	if !errors.Is(err, errSteer) {
+		t.Errorf("err = %v, want it to wrap the cancellation cause", err)
+	}
+	if ierr.Partial != srv.text {
+		t.Errorf("Partial = %q, want %q", ierr.Partial, srv.text)
+	}
+
+	// The interrupted exchange stays in the history, so the next request has context.
+	msgs := convo.messages
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages in history, want 2", len(msgs))
+	}
+	if got := msgs[1].Content[0].Text; msgs[1].Role != llm.MessageRoleAssistant || !strings.HasPrefix(got, srv.text) || !strings.Contains(got, "[interrupted by the user]") {
+		t.Errorf("recorded response = %q, want the partial text marked as interrupted", got)
+	}
+}
+
+func TestToolResultContentsParallel(t *testing.T) {
+	var mu sync.Mutex
+	var running, maxRunning int
+	started, finished := make(map[string]time.Time), make(map[string]time.Time)
+	track := func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		started[string(m)] = time.Now()
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		finished[string(m)] = time.Now()
+		mu.Unlock()
+		return llm.TextContent(string(m)), nil
+	}
+
+	convo := New(context.Background(), llmtest.NewService(), nil)
+	convo.MaxParallelToolCalls = 3
+	convo.Tools = []*llm.Tool{
+		{Name: "read", InputSchema: llm.EmptySchema(), Run: track},
+		{Name: "edit", InputSchema: llm.EmptySchema(), Serial: true, Run: track},
+		{Name: "click", InputSchema: llm.EmptySchema(), Serial: true, Run: track},
+	}
+	resp := &llm.Response{StopReason: llm.StopReasonToolUse}
+	var want []string
+	for i, name := range []string{"read", "read", "read", "read", "edit", "click", "read", "read"} {
+		input := fmt.Sprintf(`"%s%d"`, name, i)
+		want = append(want, input)
+		resp.Content = append(resp.Content, llm.Content{Type: llm.ContentTypeToolUse, ID: fmt.Sprint("t", i), ToolName: name, ToolInput: json.RawMessage(input)})
+	}
+
+	start := time.Now()
+	results, _, err := convo.ToolResultContents(context.Background(), resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.ToolResult[0].Text)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("results = %q, want them in call order %q", got, want)
+	}
+	if maxRunning != 3 {
+		t.Errorf("at most %d tools ran at once, want 3", maxRunning)
+	}
+	// Serial calls keep their place: each starts after every earlier call finished,
+	// and every later call starts after it finished.
+	for i, call := range want {
+		for j, other := range want {
+			serial := strings.HasPrefix(call, `"edit`) || strings.HasPrefix(call, `"click`)
+			if serial && j < i && started[call].Before(finished[other]) {
+				t.Errorf("%s started before the earlier %s finished", call, other)
+			}
+			if serial && j > i && started[other].Before(finished[call]) {
+				t.Errorf("%s started before the earlier %s finished", other, call)
+			}
+		}
+	}
+	// Four reads, three at a time, then two serial calls, then two reads: five rounds of 20ms, not eight.
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("tool calls took %v; they do not seem to run in parallel", elapsed)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	srv := &stallingService{text: "Editing main.go", started: make(chan struct{})}
+	convo := New(context.Background(), srv, nil)
+	go func() {
+		<-srv.started
+		convo.Cancel("wrong file")
+	}()
+	_, err := convo.SendMessage(llm.UserStringMessage("fix the bug"))
+	var cerr *CancelError
+	if !errors.As(err, &cerr) || cerr.Reason != "wrong file" {
+		t.Fatalf("err = %v, want a *CancelError", err)
+	}
+	if got := convo.messages[1].Content[0].Text; got != "Editing main.go\n\n[interrupted by the user: wrong file]" {
+		t.Errorf("recorded response = %q, want it marked as interrupted, with the reason", got)
+	}
+
+	started := make(chan struct{})
+	convo.Tools = []*llm.Tool{
+		{Name: "fast", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			return llm.TextContent("done"), nil
+		}},
+		{Name: "slow", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}},
+	}
+	resp := &llm.Response{StopReason: llm.StopReasonToolUse, Content: []llm.Content{
+		{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "fast", ToolInput: json.RawMessage("{}")},
+		{Type: llm.ContentTypeToolUse, ID: "t2", ToolName: "slow", ToolInput: json.RawMessage("{}")},
+	}}
+	go func() {
+		<-started
+		convo.Cancel("")
+	}()
+	results, endsTurn, err := convo.ToolResultContents(context.Background(), resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !endsTurn {
+		t.Errorf("endsTurn = false after Cancel, want true")
+	}
+	if len(results) != 2 || results[0].ToolError || !results[1].ToolError || results[1].ToolResult[0].Text != "interrupted by the user" {
+		t.Errorf("results = %+v, want the slow tool's marked as interrupted", results)
+	}
+}
+
+func TestToolErrorKinds(t *testing.T) {
+	defer func(b llm.Backoff) { toolRetryBackoff = b }(toolRetryBackoff)
+	toolRetryBackoff = llm.Backoff{}
+
+	var searches, lookups atomic.Int32
+	convo := New(context.Background(), llmtest.NewService(), nil)
+	convo.Tools = []*llm.Tool{
+		{Name: "crash", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			var m2 map[string]int
+			m2["x"]++
+			return nil, nil
+		}},
+		{Name: "search", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			if searches.Add(1) == 1 {
+				return nil, &llm.StatusError{StatusCode: 503, Message: "overloaded"}
+			}
+			return llm.TextContent("found"), nil
+		}},
+		{Name: "lookup", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			lookups.Add(1)
+			return nil, llm.NewToolError(llm.ToolErrorProvider, errors.New("lookup service is down"))
+		}},
+		{Name: "edit", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			return nil, errors.New("no such file")
+		}},
+	}
+	call := func(names ...string) ([]llm.Content, bool) {
+		resp := &llm.Response{StopReason: llm.StopReasonToolUse}
+		for i, name := range names {
+			resp.Content = append(resp.Content, llm.Content{Type: llm.ContentTypeToolUse, ID: fmt.Sprint("t", i), ToolName: name, ToolInput: json.RawMessage("{}")})
+		}
+		results, endsTurn, err := convo.ToolResultContents(context.Background(), resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results, endsTurn
+	}
+
+	results, endsTurn := call("crash", "search", "lookup", "edit")
+	var kinds []llm.ToolErrorKind
+	for _, r := range results {
+		kinds = append(kinds, r.ToolErrorKind)
+	}
+	if want := []llm.ToolErrorKind{llm.ToolErrorCrashed, "", llm.ToolErrorProvider, ""}; !slices.Equal(kinds, want) {
+		t.Errorf("kinds = %q, want %q", kinds, want)
+	}
+	if !results[0].ToolError || !strings.Contains(results[0].ToolResult[0].Text, "the crash tool crashed") {
+		t.Errorf("crash result = %+v, want it reported as a crash", results[0])
+	}
+	if results[1].ToolError || searches.Load() != 2 || lookups.Load() != 1+maxToolRetries {
+		t.Errorf("search failed: %v after %d calls, lookup made %d calls; want provider errors retried", results[1].ToolError, searches.Load(), lookups.Load())
+	}
+	if endsTurn {
+		t.Errorf("endsTurn = true, want ordinary failures to leave the turn to the model")
+	}
+
+	convo.Tools = append(convo.Tools, &llm.Tool{Name: "deploy", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+		return nil, llm.NewToolError(llm.ToolErrorUserDenied, errors.New("the user denied permission"))
+	}})
+	results, endsTurn = call("edit", "deploy")
+	if results[1].ToolErrorKind != llm.ToolErrorUserDenied || !endsTurn {
+		t.Errorf("deploy result kind %q, endsTurn = %v; want a denial to end the turn", results[1].ToolErrorKind, endsTurn)
+	}
+}
+
+func TestToolRetryAdjusted(t *testing.T) {
+	var inputs []string
+	convo := New(context.Background(), llmtest.NewService(), nil)
+	convo.Tools = []*llm.Tool{{
+		Name:        "build",
+		InputSchema: llm.EmptySchema(),
+		Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			inputs = append(inputs, string(m))
+			switch string(m) {
+			case `"10s"`:
+				return nil, llm.NewToolError(llm.ToolErrorTimeout, errors.New("timed out after 10s"))
+			case `"40s"`:
+				return llm.TextContent("built"), nil
+			}
+			return nil, errors.New("compile error")
+		},
+		Retry: func(err error, input json.RawMessage) (json.RawMessage, string, bool) {
+			if string(input) != `"10s"` {
+				return nil, "", false
+			}
+			return json.RawMessage(`"40s"`), "[retried with 40s]", true
+		},
+	}}
+	call := func(input string) llm.Content {
+		resp := &llm.Response{StopReason: llm.StopReasonToolUse, Content: []llm.Content{
+			{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "build", ToolInput: json.RawMessage(input)},
+		}}
+		results, _, err := convo.ToolResultContents(context.Background(), resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results[0]
+	}
+
+	r := call(`"10s"`)
+	if r.ToolError || len(r.ToolResult) != 2 || r.ToolResult[0].Text != "[retried with 40s]\n" || r.ToolResult[1].Text != "built" {
+		t.Errorf("timed out call's result = %+v, want the retry's, with its note", r)
+	}
+	if want := []string{`"10s"`, `"40s"`}; !slices.Equal(inputs, want) {
+		t.Errorf("inputs = %q, want %q", inputs, want)
+	}
+
+	// Ordinary failures go to the model as they are.
+	inputs = nil
+	if r := call(`"5s"`); !r.ToolError || r.ToolResult[0].Text != "compile error" || len(inputs) != 1 {
+		t.Errorf("failed call's result = %+v after %d calls, want the failure, unretried", r, len(inputs))
+	}
+}
+
+func TestQueueUserMessage(t *testing.T) {
+	srv := llmtest.NewService(
+		llmtest.Turn{ToolCalls: []llmtest.ToolCall{{Name: "deploy"}}},
+		llmtest.Turn{Expect: "use the staging database", Text: "Switching to staging."},
+		llmtest.Turn{Text: "Done."},
+	)
+	convo := New(context.Background(), srv, nil)
+	convo.Tools = []*llm.Tool{{
+		Name:        "deploy",
+		InputSchema: llm.EmptySchema(),
+		Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			// The user steers while the tool runs.
+			convo.QueueUserMessage("use the staging database")
+			return llm.TextContent("deployed"), nil
+		},
+	}}
+
+	resp, err := convo.SendMessage(llm.UserStringMessage("deploy it"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _, err := convo.ToolResultContents(context.Background(), resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := convo.SendMessage(llm.Message{Role: llm.MessageRoleUser, Content: results}); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	last := reqs[1].Messages[len(reqs[1].Messages)-1].Content
+	if len(last) != 2 || last[0].Type != llm.ContentTypeToolResult || !strings.Contains(last[1].Text, "use the staging database") {
+		t.Errorf("tool results message = %+v, want the tool result followed by the queued message", last)
+	}
+
+	// Without tool results, queued messages wait to be taken.
+	convo.QueueUserMessage("and then tag a release")
+	if _, err := convo.SendMessage(llm.UserStringMessage("thanks")); err != nil {
+		t.Fatal(err)
+	}
+	if got := convo.TakeQueuedUserMessages(); !slices.Equal(got, []string{"and then tag a release"}) {
+		t.Errorf("TakeQueuedUserMessages = %q, want the message queued after the tools ran", got)
+	}
+	if got := convo.TakeQueuedUserMessages(); got != nil {
+		t.Errorf("TakeQueuedUserMessages again = %q, want none", got)
+	}
+}
+
+func TestRedactToolResults(t *testing.T) {
+	convo := New(context.Background(), llmtest.NewService(), nil)
+	convo.Redact = func(s string) string { return strings.ReplaceAll(s, "sk-secret", "[REDACTED]") }
+	convo.Tools = []*llm.Tool{
+		{Name: "env", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			return llm.TextContent("KEY=sk-secret"), nil
+		}},
+		{Name: "curl", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			return nil, errors.New("401 for key sk-secret")
+		}},
+	}
+	resp := &llm.Response{StopReason: llm.StopReasonToolUse, Content: []llm.Content{
+		{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "env", ToolInput: json.RawMessage("{}")},
+		{Type: llm.ContentTypeToolUse, ID: "t2", ToolName: "curl", ToolInput: json.RawMessage("{}")},
+		{Type: llm.ContentTypeToolUse, ID: "t3", ToolName: "env", ToolInput: json.RawMessage("{}")},
+	}}
+	sub := convo.SubConvo()
+	sub.Tools = convo.Tools
+	for _, c := range []*Convo{convo, sub} {
+		results, _, err := c.ToolResultContents(context.Background(), resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range results {
+			if text := r.ToolResult[0].Text; strings.Contains(text, "sk-secret") || !strings.Contains(text, "[REDACTED]") {
+				t.Errorf("tool result %q is not redacted", text)
+			}
+		}
+	}
+}
+
+func TestTraceSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	srv := llmtest.NewService(llmtest.Turn{ToolCalls: []llmtest.ToolCall{{ID: "t1", Name: "ok"}, {ID: "t2", Name: "fail"}}})
+	convo := New(context.Background(), srv, nil)
+	convo.Tools = []*llm.Tool{
+		{Name: "ok", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			return llm.TextContent("done"), nil
+		}},
+		{Name: "fail", InputSchema: llm.EmptySchema(), Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			return nil, errors.New("exit status 2")
+		}},
+	}
+	ctx, turn := provider.Tracer("test").Start(context.Background(), "turn")
+	resp, err := convo.SendMessageContext(ctx, llm.UserStringMessage("go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := convo.ToolResultContents(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+	turn.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+		if s.Name() != "turn" && s.Parent().SpanID() != turn.SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of the turn", s.Name())
+		}
+	}
+	attrs := func(s sdktrace.ReadOnlySpan) map[string]string {
+		m := map[string]string{}
+		for _, kv := range s.Attributes() {
+			m[string(kv.Key)] = kv.Value.Emit()
+		}
+		return m
+	}
+	chat, ok := spans["chat llmtest"]
+	if !ok {
+		t.Fatalf("no span for the LLM request; got %v", slices.Collect(maps.Keys(spans)))
+	}
+	if a := attrs(chat); a["gen_ai.response.model"] != "llmtest" || a["gen_ai.usage.output_tokens"] == "" {
+		t.Errorf("LLM request span attributes = %v", a)
+	}
+	if s := spans["execute_tool ok"]; s == nil || s.Status().Code != codes.Unset || attrs(s)["gen_ai.tool.call.id"] != "t1" {
+		t.Errorf("span for the successful tool call: %v", s)
+	}
+	if s := spans["execute_tool fail"]; s == nil || s.Status().Code != codes.Error || s.Status().Description != "exit status 2" {
+		t.Errorf("span for the failed tool call: %v", s)
+	}
+}
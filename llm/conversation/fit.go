@@ -0,0 +1,113 @@
+package conversation
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/tokencount"
+)
+
+// outputReserve is how much of the context window is left for the response.
+// It is the default max_tokens of the services.
+const outputReserve = 8192
+
+// estimateTokens estimates the input tokens of a request that adds msg to the conversation.
+// When the usage of the previous request is known, everything before msg is counted exactly,
+// as that request's input plus its response; only msg itself is estimated.
+func (c *Convo) estimateTokens(msg llm.Message) int {
+	c.mu.Lock()
+	u := c.lastUsage
+	c.mu.Unlock()
+	est := c.estimator()
+	if prior := u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens; prior > 0 {
+		return int(prior+u.OutputTokens) + est.Message(msg)
+	}
+	return est.Request(c.messageRequest(msg))
+}
+
+// fitContextWindow makes room for msg in the context window if sending it would overflow:
+// first by compacting the conversation, if compaction is enabled,
+// then by cutting down msg's tool results, which are the usual culprits.
+// It returns the message to send.
+func (c *Convo) fitContextWindow(msg llm.Message) llm.Message {
+	window := llm.CapabilitiesOf(c.Service).ContextWindow
+	if window <= 0 {
+		return msg
+	}
+	limit := window - min(outputReserve, window/4)
+	request := func() *llm.Request { return c.messageRequest(msg) }
+	tokens := c.countTokens(c.Ctx, request, c.estimateTokens(msg), limit)
+	if tokens <= limit {
+		return msg
+	}
+	slog.WarnContext(c.Ctx, "convo_request_exceeds_context_window", "estimated_tokens", tokens, "limit", limit)
+	if c.Compaction != nil {
+		if err := c.Compact(); err != nil {
+			slog.WarnContext(c.Ctx, "convo_compaction_failed", "err", err)
+		}
+		if tokens = c.countTokens(c.Ctx, request, c.estimateTokens(msg), limit); tokens <= limit {
+			return msg
+		}
+	}
+	return truncateToolResults(c.estimator(), msg, tokens-limit)
+}
+
+// countMargin bounds how far over a limit a local estimate may be for the request to still fit under it:
+// estimates err high, but not by this factor.
+const countMargin = 2
+
+// countTokens returns the input tokens of the request that req builds, given estimate, a local estimate of them.
+// An estimate over limit, but within countMargin of it, is checked with the service's tokenizer, if it has one;
+// counting costs a round trip, so it is done only when the estimate alone cannot decide.
+func (c *Convo) countTokens(ctx context.Context, req func() *llm.Request, estimate, limit int) int {
+	if estimate <= limit || estimate > countMargin*limit {
+		return estimate
+	}
+	n := llm.CountTokens(ctx, c.Service, req(), func(*llm.Request) int { return estimate })
+	slog.DebugContext(ctx, "convo_tokens_counted", "estimated_tokens", estimate, "tokens", n, "limit", limit)
+	return n
+}
+
+// textRequest returns a function that builds a request of just text, to count the tokens of text.
+func textRequest(text string) func() *llm.Request {
+	return func() *llm.Request {
+		return &llm.Request{Messages: []llm.Message{llm.UserStringMessage(text)}}
+	}
+}
+
+// estimator returns the token estimator for the model that last answered c.
+func (c *Convo) estimator() *tokencount.Estimator {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return tokencount.For(c.lastModel)
+}
+
+// truncateToolResults returns a copy of msg whose tool results are shorter by about excess tokens in all.
+// Each text result gives up a share of the excess in proportion to its size.
+func truncateToolResults(est *tokencount.Estimator, msg llm.Message, excess int) llm.Message {
+	var total int
+	for _, content := range msg.Content {
+		for _, r := range content.ToolResult {
+			total += est.Text(r.Text)
+		}
+	}
+	if total == 0 {
+		return msg
+	}
+	msg.Content = slices.Clone(msg.Content)
+	for i, content := range msg.Content {
+		if len(content.ToolResult) == 0 {
+			continue
+		}
+		content.ToolResult = slices.Clone(content.ToolResult)
+		for j, r := range content.ToolResult {
+			n := est.Text(r.Text)
+			cut := (excess*n + total - 1) / total
+			content.ToolResult[j].Text = est.Truncate(r.Text, n-cut)
+		}
+		msg.Content[i] = content
+	}
+	return msg
+}
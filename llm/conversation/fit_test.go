@@ -0,0 +1,99 @@
+package conversation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/tokencount"
+)
+
+func TestFitContextWindow(t *testing.T) {
+	srv := &windowService{inputTokens: 300}
+	convo := New(context.Background(), srv, nil)
+	if _, err := convo.SendUserTextMessage("run the tests"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A small result fits as is.
+	small := toolRoundTrip("t1", "ok")[1]
+	if got := convo.fitContextWindow(small); toolResultText(got.Content[0]) != "ok" {
+		t.Errorf("small tool result was changed to %q", toolResultText(got.Content[0]))
+	}
+
+	// The window is 1000 tokens, less a quarter for the response;
+	// 300 are used, so a huge result must be cut down to about 450.
+	output := "=== RUN TestEverything\n" + strings.Repeat("some noisy log output\n", 2000) + "FAIL: TestEverything"
+	big := toolRoundTrip("t2", output)[1]
+	got := convo.fitContextWindow(big)
+	text := toolResultText(got.Content[0])
+	if n := tokencount.Default.Text(text); n > 450 {
+		t.Errorf("fitted tool result has %d tokens, want at most 450", n)
+	}
+	if !strings.HasPrefix(text, "=== RUN") || !strings.HasSuffix(text, "FAIL: TestEverything") {
+		t.Errorf("fitted tool result lost its start or end: %q", text)
+	}
+	if toolResultText(big.Content[0]) != output {
+		t.Errorf("fitContextWindow modified the original message")
+	}
+
+	// With compaction enabled, the history is compacted first.
+	convo.Compaction = &Compaction{KeepMessages: 1}
+	srv.inputTokens = 10
+	convo.messages = append(convo.messages, toolRoundTrip("t3", "old output")...)
+	convo.fitContextWindow(big)
+	if last := srv.requests[len(srv.requests)-1]; last.System[0].Text != compactionSystemPrompt {
+		t.Errorf("fitContextWindow did not compact the conversation")
+	}
+}
+
+// tokenizingService is a windowService whose tokenizer counts every request as tokens.
+type tokenizingService struct {
+	windowService
+	tokens int
+	counts int
+}
+
+func (s *tokenizingService) CountTokens(ctx context.Context, req *llm.Request) (int, error) {
+	s.counts++
+	return s.tokens, nil
+}
+
+func TestCountTokensNearLimit(t *testing.T) {
+	text := strings.Repeat("word ", 200)
+	if n := tokencount.Default.Text(text); n <= 300 || n > 600 {
+		t.Fatalf("text is estimated at %d tokens, want it over the limit of 300, but not twice over", n)
+	}
+	result := llm.TextContent(text)
+
+	// The governor counts a result estimated to be just over its limit, and keeps it if the count is under.
+	srv := &tokenizingService{tokens: 250}
+	convo := New(context.Background(), srv, nil)
+	convo.ToolResultLimit = &ToolResultLimit{MaxTokens: 300}
+	if got := convo.limitToolResult(context.Background(), "bash", nil, result); got[0].Text != text || srv.counts != 1 {
+		t.Errorf("result counted at 250 tokens was reduced, or not counted (%d counts)", srv.counts)
+	}
+	srv.tokens = 350
+	if got := convo.limitToolResult(context.Background(), "bash", nil, result); got[0].Text == text {
+		t.Errorf("result counted at 350 tokens was not reduced")
+	}
+	if got := convo.limitToolResult(context.Background(), "bash", nil, llm.TextContent(text+text+text)); got[0].Text == text+text+text || srv.counts != 2 {
+		t.Errorf("result estimated at over twice the limit was counted, or not reduced")
+	}
+
+	// Attachments may take a quarter of the 1000-token window.
+	att := Attachment{Name: "notes.md", Content: strings.Repeat("word ", 150)}
+	if err := New(context.Background(), &windowService{}, nil).AddAttachments(att); err == nil {
+		t.Errorf("attachment estimated over the limit was attached without a token counter")
+	}
+	srv = &tokenizingService{tokens: 200}
+	convo = New(context.Background(), srv, nil)
+	if err := convo.AddAttachments(att); err != nil || srv.counts != 1 {
+		t.Errorf("attachment counted at 200 tokens: %v, %d counts", err, srv.counts)
+	}
+	srv.tokens = 300
+	if err := convo.AddAttachments(Attachment{Name: "more.md", Content: "x"}); err == nil || len(convo.Attachments()) != 1 {
+		t.Errorf("attachments counted at 300 tokens were attached")
+	}
+}
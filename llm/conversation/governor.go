@@ -0,0 +1,102 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// ToolResultLimit configures the governor that keeps large tool results out of the context.
+//
+// When a tool result's text exceeds MaxTokens, ToolResultContents
+// replaces it with an LLM-written summary, if Summarize is set,
+// or with its head and tail, so that no tool needs to limit its own output.
+// Listeners still see the full result.
+type ToolResultLimit struct {
+	// MaxTokens is the size above which a tool result is reduced.
+	// Values <= 0 default to 8000.
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// Summarize asks the LLM to summarize oversized results, as the "tool-result-summary" task,
+	// so that an llm.Router can send the work to a cheap model.
+	// Results are truncated to their head and tail if Summarize is false or summarization fails.
+	Summarize bool `json:"summarize,omitempty"`
+}
+
+// summarizeMaxInput bounds, in multiples of MaxTokens, how much of a result is sent to be summarized.
+const summarizeMaxInput = 16
+
+const toolResultSummarySystemPrompt = `You condense the output of a tool that a coding agent called,
+so that the agent can continue its task without reading all of it.
+
+Keep everything the agent is likely to need: errors and warnings, with their file names and line numbers;
+failing tests; paths, identifiers, and values it asked about; and the overall outcome.
+Quote the important lines verbatim. Drop repetition and noise.
+Reply with ONLY the condensed output.`
+
+// limitToolResult reduces result, the output of the named tool called with input,
+// if its text is larger than c.ToolResultLimit allows.
+func (c *Convo) limitToolResult(ctx context.Context, name string, input json.RawMessage, result []llm.Content) []llm.Content {
+	if c.ToolResultLimit == nil {
+		return result
+	}
+	maxTokens := c.ToolResultLimit.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = 8000
+	}
+	est := c.estimator()
+	var text strings.Builder
+	var other []llm.Content
+	for _, r := range result {
+		if r.Type == llm.ContentTypeText {
+			text.WriteString(r.Text)
+		} else {
+			other = append(other, r)
+		}
+	}
+	tokens := c.countTokens(ctx, textRequest(text.String()), est.Text(text.String()), maxTokens)
+	if tokens <= maxTokens {
+		return result
+	}
+
+	reduced, method := "", "truncate"
+	if c.ToolResultLimit.Summarize {
+		summary, err := c.summarizeToolResult(ctx, name, input, est.Truncate(text.String(), summarizeMaxInput*maxTokens))
+		if err != nil {
+			slog.WarnContext(ctx, "tool_result_summary_failed", "tool", name, "err", err)
+		} else if est.Text(summary) <= maxTokens {
+			reduced, method = fmt.Sprintf("[This output of about %d tokens was summarized to save context. Run the tool again with narrower input for specific details.]\n\n%s", tokens, summary), "summarize"
+		}
+	}
+	if reduced == "" {
+		reduced = est.Truncate(text.String(), maxTokens)
+	}
+	slog.InfoContext(ctx, "tool_result_reduced", "tool", name, "method", method, "tokens", tokens, "max_tokens", maxTokens)
+	return append([]llm.Content{llm.StringContent(reduced)}, other...)
+}
+
+// summarizeToolResult asks the LLM to summarize output, the text output of the named tool called with input.
+func (c *Convo) summarizeToolResult(ctx context.Context, name string, input json.RawMessage, output string) (string, error) {
+	sub := c.SubConvo()
+	sub.Hidden = true
+	sub.Task = "tool-result-summary"
+	sub.SystemPrompt = toolResultSummarySystemPrompt
+	msg := fmt.Sprintf("The agent called the %s tool with this input:\n%s\n\nIt output:\n<output>\n%s\n</output>", name, input, output)
+	resp, err := sub.SendMessageContext(ctx, llm.UserStringMessage(msg))
+	if err != nil {
+		return "", err
+	}
+	var summary strings.Builder
+	for _, part := range resp.Content {
+		if part.Type == llm.ContentTypeText {
+			summary.WriteString(part.Text)
+		}
+	}
+	if summary.Len() == 0 {
+		return "", fmt.Errorf("empty summary")
+	}
+	return summary.String(), nil
+}
@@ -0,0 +1,61 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/llmtest"
+)
+
+func TestToolResultLimit(t *testing.T) {
+	huge := strings.Repeat("ok: compiled a file\n", 2000) + "FAIL: foo.go:12: undefined: bar\n"
+	dump := &llm.Tool{
+		Name:        "dump",
+		InputSchema: llm.EmptySchema(),
+		Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			return llm.TextContent(huge), nil
+		},
+	}
+	call := &llm.Response{
+		StopReason: llm.StopReasonToolUse,
+		Content:    []llm.Content{{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "dump", ToolInput: json.RawMessage("{}")}},
+	}
+	run := func(srv llm.Service, limit *ToolResultLimit) string {
+		t.Helper()
+		convo := New(context.Background(), srv, nil)
+		convo.Tools = []*llm.Tool{dump}
+		convo.ToolResultLimit = limit
+		results, _, err := convo.ToolResultContents(context.Background(), call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results[0].ToolResult[0].Text
+	}
+
+	if got := run(llmtest.NewService(), nil); got != huge {
+		t.Errorf("without a limit, the result was changed")
+	}
+
+	got := run(llmtest.NewService(), &ToolResultLimit{MaxTokens: 500})
+	if !strings.Contains(got, "tokens omitted") || !strings.HasSuffix(got, "undefined: bar\n") || len(got) > 2000 {
+		t.Errorf("truncated result = %q, want its head and tail", got)
+	}
+
+	srv := llmtest.NewService(llmtest.Turn{Expect: "undefined: bar", Text: "Everything compiled except foo.go:12: undefined: bar"})
+	got = run(srv, &ToolResultLimit{MaxTokens: 500, Summarize: true})
+	if !strings.Contains(got, "summarized to save context") || !strings.HasSuffix(got, "foo.go:12: undefined: bar") {
+		t.Errorf("summarized result = %q", got)
+	}
+	if reqs := srv.Requests(); len(reqs) != 1 || reqs[0].System[0].Text != toolResultSummarySystemPrompt {
+		t.Errorf("want one summarization request, got %d requests", len(reqs))
+	}
+
+	// A failed summary falls back to truncation.
+	got = run(llmtest.NewService(llmtest.Turn{Error: "overloaded"}), &ToolResultLimit{MaxTokens: 500, Summarize: true})
+	if !strings.Contains(got, "tokens omitted") {
+		t.Errorf("result after failed summary = %q, want it truncated", got)
+	}
+}
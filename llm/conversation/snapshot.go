@@ -0,0 +1,177 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/skribe"
+)
+
+// snapshotVersion is the version of the snapshot format written by Snapshot.
+// Bump it whenever the format changes incompatibly.
+const snapshotVersion = 1
+
+// snapshot is the serialized form of a Convo.
+type snapshot struct {
+	Version         int                     `json:"version"`
+	ID              string                  `json:"id"`
+	SystemPrompt    string                  `json:"system_prompt,omitempty"`
+	PromptCaching   bool                    `json:"prompt_caching"`
+	ToolUseOnly     bool                    `json:"tool_use_only,omitempty"`
+	Budget          Budget                  `json:"budget"`
+	Hidden          bool                    `json:"hidden,omitempty"`
+	ExtraData       map[string]any          `json:"extra_data,omitempty"`
+	Compaction      *Compaction             `json:"compaction,omitempty"`
+	ToolResultLimit *ToolResultLimit        `json:"tool_result_limit,omitempty"`
+	Tools           []snapshotTool          `json:"tools,omitempty"`
+	Messages        []llm.Message           `json:"messages"`
+	Attachments     []Attachment            `json:"attachments,omitempty"`
+	DisabledTools   []string                `json:"disabled_tools,omitempty"`
+	ToolOverrides   map[string]ToolOverride `json:"tool_overrides,omitempty"`
+	Pinned          []string                `json:"pinned,omitempty"`
+	Usage           CumulativeUsage         `json:"usage"`
+	// WallTime is how long the conversation had run when it was snapshotted.
+	WallTime time.Duration `json:"wall_time,omitempty"`
+}
+
+// snapshotTool records everything about a tool except its Run function,
+// which cannot be serialized and must be re-bound on Restore.
+type snapshotTool struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type,omitempty"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema,omitempty"`
+	EndsTurn    bool            `json:"ends_turn,omitempty"`
+}
+
+// Snapshot serializes the state of c so that it can later be resumed with Restore.
+// It records the conversation history, system prompt, tool schemas, disabled and overridden tools, budget,
+// cumulative usage, attachments, pinned tool results, and ExtraData (which is where callers keep the working directory).
+// Snapshot must not be called concurrently with SendMessage.
+func (c *Convo) Snapshot() ([]byte, error) {
+	s := snapshot{
+		Version:         snapshotVersion,
+		ID:              c.ID,
+		SystemPrompt:    c.SystemPrompt,
+		PromptCaching:   c.PromptCaching,
+		ToolUseOnly:     c.ToolUseOnly,
+		Budget:          c.Budget,
+		Hidden:          c.Hidden,
+		ExtraData:       c.ExtraData,
+		Compaction:      c.Compaction,
+		ToolResultLimit: c.ToolResultLimit,
+		Messages:        c.messages,
+		Attachments:     c.Attachments(),
+		DisabledTools:   c.DisabledTools(),
+		ToolOverrides:   c.ToolOverrides(),
+		Usage:           c.Usage(),
+	}
+	s.WallTime = s.Usage.WallTime()
+	c.mu.Lock()
+	s.Pinned = slices.Sorted(maps.Keys(c.pinned))
+	c.mu.Unlock()
+	for _, t := range c.Tools {
+		s.Tools = append(s.Tools, snapshotTool{
+			Name:        t.Name,
+			Type:        t.Type,
+			Description: t.Description,
+			InputSchema: t.InputSchema,
+			EndsTurn:    t.EndsTurn,
+		})
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal conversation snapshot: %w", err)
+	}
+	return data, nil
+}
+
+// Restore creates a new Convo from data previously returned by Snapshot.
+//
+// Tool run functions cannot be serialized, so they are re-bound by name from tools.
+// The restored Convo offers the LLM exactly the tool schemas that were snapshotted;
+// a snapshotted tool with no counterpart in tools fails when called.
+//
+// The time between Snapshot and Restore does not count toward the wall time budget:
+// the restored Convo's wall time continues from where the snapshot left it.
+func Restore(ctx context.Context, srv llm.Service, data []byte, tools []*llm.Tool) (*Convo, error) {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal conversation snapshot: %w", err)
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported conversation snapshot version %d (want %d)", s.Version, snapshotVersion)
+	}
+	if s.ID == "" {
+		return nil, fmt.Errorf("conversation snapshot has no id")
+	}
+
+	usage := s.Usage
+	if usage.ToolUses == nil {
+		usage.ToolUses = make(map[string]int)
+	}
+	usage.StartTime = time.Now().Add(-s.WallTime)
+
+	c := &Convo{
+		Ctx:             skribe.ContextWithAttr(ctx, slog.String("convo_id", s.ID)),
+		Service:         srv,
+		SystemPrompt:    s.SystemPrompt,
+		PromptCaching:   s.PromptCaching,
+		ToolUseOnly:     s.ToolUseOnly,
+		Budget:          s.Budget,
+		Hidden:          s.Hidden,
+		ExtraData:       s.ExtraData,
+		Compaction:      s.Compaction,
+		ToolResultLimit: s.ToolResultLimit,
+		messages:        slices.Clip(s.Messages),
+		attachments:     s.Attachments,
+		toolOverrides:   s.ToolOverrides,
+		Listener:        &NoopListener{},
+		ID:              s.ID,
+		toolUseCancel:   map[string]context.CancelCauseFunc{},
+		mu:              &sync.Mutex{},
+		usage:           &usage,
+	}
+	for _, st := range s.Tools {
+		c.Tools = append(c.Tools, restoreTool(st, tools))
+	}
+	for _, name := range s.DisabledTools {
+		if c.disabledTools == nil {
+			c.disabledTools = make(map[string]bool)
+		}
+		c.disabledTools[name] = true
+	}
+	for _, id := range s.Pinned {
+		c.PinToolResult(id)
+	}
+	return c, nil
+}
+
+// restoreTool rebuilds a snapshotted tool, taking its Run and Retry functions from the
+// tool of the same name in available.
+func restoreTool(st snapshotTool, available []*llm.Tool) *llm.Tool {
+	t := &llm.Tool{
+		Name:        st.Name,
+		Type:        st.Type,
+		Description: st.Description,
+		InputSchema: st.InputSchema,
+		EndsTurn:    st.EndsTurn,
+	}
+	idx := slices.IndexFunc(available, func(a *llm.Tool) bool { return a.Name == st.Name })
+	if idx >= 0 {
+		t.Run = available[idx].Run
+		t.Retry = available[idx].Retry
+	} else {
+		t.Run = func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
+			return nil, fmt.Errorf("tool %q is not available in this resumed session", st.Name)
+		}
+	}
+	return t
+}
@@ -0,0 +1,176 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// echoService is an llm.Service that records requests and replies with a fixed text.
+type echoService struct {
+	requests []*llm.Request
+}
+
+func (s *echoService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	s.requests = append(s.requests, req)
+	return &llm.Response{
+		Role:       llm.MessageRoleAssistant,
+		Content:    []llm.Content{llm.StringContent("ok")},
+		StopReason: llm.StopReasonEndTurn,
+		Usage:      llm.Usage{InputTokens: 10, OutputTokens: 2, CostUSD: 0.5},
+	}, nil
+}
+
+func (s *echoService) TokenContextWindow() int { return 200000 }
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	srv := &echoService{}
+	convo := New(ctx, srv, nil)
+	convo.SystemPrompt = "be terse"
+	convo.Budget = Budget{MaxDollars: 5}
+	convo.ExtraData = map[string]any{"session_id": "abc", "working_dir": "/src"}
+	ranOriginal := false
+	convo.Tools = []*llm.Tool{
+		{
+			Name:        "echo",
+			Description: "echoes",
+			InputSchema: llm.EmptySchema(),
+			Run: func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
+				ranOriginal = true
+				return llm.TextContent("echo"), nil
+			},
+		},
+		{
+			Name:        "gone",
+			InputSchema: llm.EmptySchema(),
+			EndsTurn:    true,
+		},
+	}
+	if _, err := convo.SendUserTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := convo.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranRestored := false
+	tools := []*llm.Tool{{
+		Name: "echo",
+		Run: func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
+			ranRestored = true
+			return llm.TextContent("echo"), nil
+		},
+	}}
+	srv2 := &echoService{}
+	restored, err := Restore(ctx, srv2, data, tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.ID != convo.ID {
+		t.Errorf("ID = %q, want %q", restored.ID, convo.ID)
+	}
+	if restored.SystemPrompt != "be terse" {
+		t.Errorf("SystemPrompt = %q", restored.SystemPrompt)
+	}
+	if restored.Budget != convo.Budget {
+		t.Errorf("Budget = %+v, want %+v", restored.Budget, convo.Budget)
+	}
+	if wd, _ := restored.ExtraData["working_dir"].(string); wd != "/src" {
+		t.Errorf("working_dir = %q, want /src", wd)
+	}
+	if got := restored.Usage().TotalCostUSD; got != 0.5 {
+		t.Errorf("TotalCostUSD = %v, want 0.5", got)
+	}
+	if len(restored.Tools) != 2 {
+		t.Fatalf("got %d tools, want 2", len(restored.Tools))
+	}
+	if restored.Tools[0].Description != "echoes" || !restored.Tools[1].EndsTurn {
+		t.Errorf("tool schemas not restored: %+v %+v", restored.Tools[0], restored.Tools[1])
+	}
+
+	if _, err := restored.Tools[0].Run(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ranOriginal || !ranRestored {
+		t.Errorf("echo tool not re-bound: ranOriginal=%v ranRestored=%v", ranOriginal, ranRestored)
+	}
+	if _, err := restored.Tools[1].Run(ctx, nil); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("unbound tool error = %v, want not available", err)
+	}
+
+	// The restored conversation should continue with the full history.
+	if _, err := restored.SendUserTextMessage("again"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv2.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(srv2.requests))
+	}
+	msgs := srv2.requests[0].Messages
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	if msgs[0].Content[0].Text != "hello" || msgs[1].Content[0].Text != "ok" {
+		t.Errorf("history not restored: %+v", msgs)
+	}
+}
+
+func TestRestoreWallTime(t *testing.T) {
+	ctx := context.Background()
+	convo := New(ctx, &echoService{}, nil)
+	convo.Budget = Budget{MaxWallTime: 90 * time.Minute}
+	convo.usage.StartTime = time.Now().Add(-time.Hour)
+	data, err := convo.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ten hours pass before the conversation is restored.
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	s.Usage.StartTime = s.Usage.StartTime.Add(-10 * time.Hour)
+	if data, err = json.Marshal(s); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := Restore(ctx, &echoService{}, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := restored.Usage()
+	if got := usage.WallTime(); got < time.Hour || got > time.Hour+time.Minute {
+		t.Errorf("WallTime() = %v, want the hour run before the snapshot", got)
+	}
+	if _, err := restored.SendUserTextMessage("again"); err != nil {
+		t.Errorf("restored conversation is over budget: %v", err)
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "invalid json", data: "{", want: "unmarshal"},
+		{name: "wrong version", data: `{"version": 99, "id": "x"}`, want: "version"},
+		{name: "missing id", data: `{"version": 1}`, want: "no id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Restore(context.Background(), &echoService{}, []byte(tt.data), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Restore error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
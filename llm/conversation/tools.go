@@ -0,0 +1,133 @@
+package conversation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"sketch.dev/llm"
+)
+
+// A ToolOverride replaces parts of a tool's definition as the model sees it,
+// such as to tighten the bash tool's description when a policy forbids networking.
+// Empty fields leave the tool's own definition in place.
+// The tool runs as before, so an overriding schema should describe input that the tool accepts.
+type ToolOverride struct {
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema,omitempty"`
+}
+
+// DisableTools stops offering the named tools to the model, starting with the next request.
+// Calls the model makes to a disabled tool anyway fail.
+func (c *Convo) DisableTools(names ...string) error {
+	if err := c.checkToolNames(names); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disabledTools == nil {
+		c.disabledTools = make(map[string]bool)
+	}
+	for _, name := range names {
+		c.disabledTools[name] = true
+	}
+	return nil
+}
+
+// EnableTools offers the named tools, disabled by DisableTools, to the model again.
+func (c *Convo) EnableTools(names ...string) error {
+	if err := c.checkToolNames(names); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		delete(c.disabledTools, name)
+	}
+	return nil
+}
+
+// DisabledTools returns the names of the disabled tools, sorted.
+func (c *Convo) DisabledTools() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Sorted(maps.Keys(c.disabledTools))
+}
+
+// OverrideTool overrides the named tool's definition, starting with the next request,
+// replacing any earlier override. A zero ToolOverride removes the override.
+func (c *Convo) OverrideTool(name string, o ToolOverride) error {
+	if err := c.checkToolNames([]string{name}); err != nil {
+		return err
+	}
+	if len(o.InputSchema) > 0 {
+		var schema struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(o.InputSchema, &schema); err != nil {
+			return fmt.Errorf("invalid input schema for tool %q: %w", name, err)
+		}
+		if schema.Type != "object" {
+			return fmt.Errorf("invalid input schema for tool %q: type is %q, not \"object\"", name, schema.Type)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if o.Description == "" && len(o.InputSchema) == 0 {
+		delete(c.toolOverrides, name)
+		return nil
+	}
+	if c.toolOverrides == nil {
+		c.toolOverrides = make(map[string]ToolOverride)
+	}
+	c.toolOverrides[name] = o
+	return nil
+}
+
+// ToolOverrides returns the tool overrides, by tool name.
+func (c *Convo) ToolOverrides() map[string]ToolOverride {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.toolOverrides)
+}
+
+// checkToolNames returns an error naming any of names that is not one of c's tools.
+func (c *Convo) checkToolNames(names []string) error {
+	var errs error
+	for _, name := range names {
+		if !slices.ContainsFunc(c.Tools, func(t *llm.Tool) bool { return t.Name == name }) {
+			errs = errors.Join(errs, fmt.Errorf("tool %q not found", name))
+		}
+	}
+	return errs
+}
+
+// activeTools returns the tools offered to the model: c.Tools, less those disabled,
+// with their overrides applied to copies.
+func (c *Convo) activeTools() []*llm.Tool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.disabledTools) == 0 && len(c.toolOverrides) == 0 {
+		return c.Tools
+	}
+	var tools []*llm.Tool
+	for _, t := range c.Tools {
+		if c.disabledTools[t.Name] {
+			continue
+		}
+		if o, ok := c.toolOverrides[t.Name]; ok {
+			overridden := *t
+			if o.Description != "" {
+				overridden.Description = o.Description
+			}
+			if len(o.InputSchema) > 0 {
+				overridden.InputSchema = o.InputSchema
+			}
+			t = &overridden
+		}
+		tools = append(tools, t)
+	}
+	return tools
+}
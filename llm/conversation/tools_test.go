@@ -0,0 +1,96 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestToolChanges(t *testing.T) {
+	ctx := context.Background()
+	srv := &echoService{}
+	convo := New(ctx, srv, nil)
+	convo.PromptCaching = false
+	run := func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
+		return llm.TextContent("ran"), nil
+	}
+	convo.Tools = []*llm.Tool{
+		{Name: "bash", Description: "Runs a command.", InputSchema: llm.EmptySchema(), Run: run},
+		{Name: "browse", Description: "Browses the web.", InputSchema: llm.EmptySchema(), Run: run},
+	}
+	offered := func() map[string]*llm.Tool {
+		t.Helper()
+		if _, err := convo.SendUserTextMessage("go on"); err != nil {
+			t.Fatal(err)
+		}
+		tools := make(map[string]*llm.Tool)
+		for _, tool := range srv.requests[len(srv.requests)-1].Tools {
+			tools[tool.Name] = tool
+		}
+		return tools
+	}
+
+	if err := convo.DisableTools("browse"); err != nil {
+		t.Fatal(err)
+	}
+	noNetwork := ToolOverride{
+		Description: "Runs a command. The network is unreachable; do not fetch anything.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"command":{"type":"string"}}}`),
+	}
+	if err := convo.OverrideTool("bash", noNetwork); err != nil {
+		t.Fatal(err)
+	}
+	tools := offered()
+	if len(tools) != 1 || tools["bash"].Description != noNetwork.Description || string(tools["bash"].InputSchema) != string(noNetwork.InputSchema) {
+		t.Errorf("offered %v, want only the overridden bash tool", tools)
+	}
+	if convo.Tools[0].Description != "Runs a command." {
+		t.Errorf("OverrideTool modified the tool itself")
+	}
+
+	call := &llm.Response{
+		StopReason: llm.StopReasonToolUse,
+		Content:    []llm.Content{{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "browse", ToolInput: json.RawMessage("{}")}},
+	}
+	results, _, err := convo.ToolResultContents(ctx, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].ToolError || !strings.Contains(results[0].ToolResult[0].Text, `tool "browse" is disabled`) {
+		t.Errorf("calling a disabled tool = %+v, want an error", results[0])
+	}
+
+	// Changes survive a snapshot.
+	data, err := convo.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(ctx, srv, data, convo.Tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(restored.DisabledTools(), []string{"browse"}) || restored.ToolOverrides()["bash"].Description != noNetwork.Description {
+		t.Errorf("restored disabled tools %v and overrides %v", restored.DisabledTools(), restored.ToolOverrides())
+	}
+
+	if err := convo.EnableTools("browse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := convo.OverrideTool("bash", ToolOverride{}); err != nil {
+		t.Fatal(err)
+	}
+	if tools := offered(); len(tools) != 2 || tools["bash"].Description != "Runs a command." {
+		t.Errorf("offered %v, want both tools as defined", tools)
+	}
+
+	if err := convo.DisableTools("bash", "nope"); err == nil || len(convo.DisabledTools()) != 0 {
+		t.Errorf("DisableTools with an unknown tool = %v, disabled %v", err, convo.DisabledTools())
+	}
+	if err := convo.OverrideTool("bash", ToolOverride{InputSchema: json.RawMessage(`{"type":"string"}`)}); err == nil {
+		t.Errorf("OverrideTool with a schema that is not an object succeeded")
+	}
+}
@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// An Embedder computes vector embeddings of text, for semantic search and retrieval.
+type Embedder interface {
+	// Embed returns one embedding per input, in order.
+	// Implementations split large inputs into batches as the service requires,
+	// and retry rate-limited requests.
+	Embed(ctx context.Context, inputs []string) ([][]float32, error)
+}
+
+// EmbedBatches embeds inputs in batches of at most size, calling embed once per batch.
+// It is a helper for Embedder implementations.
+func EmbedBatches(ctx context.Context, inputs []string, size int, embed func(ctx context.Context, batch []string) ([][]float32, error)) ([][]float32, error) {
+	if size <= 0 {
+		size = len(inputs)
+	}
+	out := make([][]float32, 0, len(inputs))
+	for start := 0; start < len(inputs); start += size {
+		batch := inputs[start:min(start+size, len(inputs))]
+		vecs, err := embed(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(vecs) != len(batch) {
+			return nil, fmt.Errorf("embedding service returned %d embeddings for %d inputs", len(vecs), len(batch))
+		}
+		out = append(out, vecs...)
+	}
+	return out, nil
+}
+
+// CosineSimilarity returns the cosine similarity of a and b, between -1 and 1.
+// It returns 0 if either vector is zero or their lengths differ.
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
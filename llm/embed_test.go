@@ -0,0 +1,62 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestEmbedBatches(t *testing.T) {
+	var batches [][]string
+	embed := func(ctx context.Context, batch []string) ([][]float32, error) {
+		batches = append(batches, batch)
+		var out [][]float32
+		for _, s := range batch {
+			out = append(out, []float32{float32(len(s))})
+		}
+		return out, nil
+	}
+	got, err := EmbedBatches(context.Background(), []string{"a", "bb", "ccc", "dddd", "eeeee"}, 2, embed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 3 {
+		t.Errorf("got %d batches, want 3", len(batches))
+	}
+	var lens []float32
+	for _, v := range got {
+		lens = append(lens, v[0])
+	}
+	if !slices.Equal(lens, []float32{1, 2, 3, 4, 5}) {
+		t.Errorf("embeddings out of order: %v", lens)
+	}
+
+	short := func(ctx context.Context, batch []string) ([][]float32, error) { return nil, nil }
+	if _, err := EmbedBatches(context.Background(), []string{"a"}, 10, short); err == nil {
+		t.Error("missing embeddings not reported")
+	}
+	fail := func(ctx context.Context, batch []string) ([][]float32, error) { return nil, errors.New("boom") }
+	if _, err := EmbedBatches(context.Background(), []string{"a"}, 10, fail); err == nil {
+		t.Error("error not returned")
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b []float32
+		want float64
+	}{
+		{[]float32{1, 0}, []float32{2, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 3}, 0},
+		{[]float32{1, 1}, []float32{-1, -1}, -1},
+		{[]float32{0, 0}, []float32{1, 1}, 0},
+		{[]float32{1}, []float32{1, 1}, 0},
+	}
+	for _, tt := range tests {
+		if got := CosineSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("CosineSimilarity(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package gem
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/gem/gemini"
+)
+
+const (
+	DefaultEmbeddingModel = "text-embedding-004"
+	// maxEmbedBatch is the most inputs the Gemini API accepts in one batchEmbedContents request.
+	maxEmbedBatch = 100
+)
+
+// Embedder computes embeddings using the Gemini API.
+type Embedder struct {
+	HTTPC  *http.Client // defaults to http.DefaultClient if nil
+	URL    string       // Gemini API URL, uses the gemini package default if empty
+	APIKey string       // must be non-empty, unless Keys is set
+	Keys   *llm.KeyPool // if set, used instead of APIKey, rotating keys on rate limits
+	Model  string       // defaults to DefaultEmbeddingModel if empty
+}
+
+var _ llm.Embedder = (*Embedder)(nil)
+
+// Embed implements llm.Embedder.
+func (e *Embedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	return llm.EmbedBatches(ctx, inputs, maxEmbedBatch, e.embedBatch)
+}
+
+func (e *Embedder) embedBatch(ctx context.Context, batch []string) ([][]float32, error) {
+	model := gemini.Model{
+		Model:    "models/" + cmp.Or(e.Model, DefaultEmbeddingModel),
+		Endpoint: e.URL,
+		APIKey:   e.APIKey,
+		HTTPC:    cmp.Or(e.HTTPC, http.DefaultClient),
+	}
+	req := &gemini.BatchEmbedContentsRequest{}
+	for _, text := range batch {
+		req.Requests = append(req.Requests, gemini.EmbedContentRequest{
+			Model:   model.Model,
+			Content: gemini.Content{Parts: []gemini.Part{{Text: text}}},
+		})
+	}
+
+	backoff := []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second, 10 * time.Second}
+	for attempts := 0; ; attempts++ {
+		if e.Keys != nil {
+			model.APIKey = e.Keys.Key()
+		}
+		res, err := model.BatchEmbedContents(ctx, req)
+		if err == nil {
+			vecs := make([][]float32, len(res.Embeddings))
+			for i, emb := range res.Embeddings {
+				vecs[i] = emb.Values
+			}
+			return vecs, nil
+		}
+		if attempts == len(backoff) {
+			return nil, fmt.Errorf("gemini: embedding API error after %d attempts: %w", attempts, err)
+		}
+		rateLimited := strings.Contains(err.Error(), "status: 429")
+		if !rateLimited && !strings.Contains(err.Error(), "status: 5") {
+			return nil, fmt.Errorf("gemini: embedding API error: %w", err)
+		}
+		if rateLimited && e.Keys != nil && e.Keys.RateLimited(model.APIKey, 0) {
+			continue // retry right away with another key
+		}
+		sleep := backoff[attempts] + time.Duration(rand.Int63n(int64(time.Second)))
+		slog.WarnContext(ctx, "gemini_embedding_retry", "error", err.Error(), "attempt", attempts+1, "sleep", sleep)
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package gem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm/gem/gemini"
+)
+
+func TestEmbedder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/models/"+DefaultEmbeddingModel+":batchEmbedContents") {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		var req gemini.BatchEmbedContentsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		var embs []string
+		for _, r := range req.Requests {
+			embs = append(embs, fmt.Sprintf(`{"values":[%d]}`, len(r.Content.Parts[0].Text)))
+		}
+		fmt.Fprintf(w, `{"embeddings":[%s]}`, strings.Join(embs, ","))
+	}))
+	defer srv.Close()
+
+	e := &Embedder{URL: srv.URL, APIKey: "key"}
+	got, err := e.Embed(context.Background(), []string{"a", "bb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0][0] != 1 || got[1][0] != 2 {
+		t.Errorf("Embed = %v, want [[1] [2]]", got)
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"math/rand"
 	"net/http"
 	"strings"
 	"time"
@@ -25,7 +25,8 @@ const (
 type Service struct {
 	HTTPC  *http.Client // defaults to http.DefaultClient if nil
 	URL    string       // Gemini API URL, uses the gemini package default if empty
-	APIKey string       // must be non-empty
+	APIKey string       // must be non-empty, unless Keys is set
+	Keys   *llm.KeyPool // if set, used instead of APIKey, rotating keys on rate limits
 	Model  string       // defaults to DefaultModel if empty
 }
 
@@ -162,6 +163,18 @@ func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error)
 		}
 	}
 
+	if req.OutputSchema != nil {
+		var schema map[string]any
+		if err := json.Unmarshal(req.OutputSchema, &schema); err != nil {
+			return nil, fmt.Errorf("invalid output schema: %w", err)
+		}
+		gs := convertJSONSchemaToGeminiSchema(schema)
+		gemReq.GenerationConfig = &gemini.GenerationConfig{
+			ResponseMimeType: "application/json",
+			ResponseSchema:   &gs,
+		}
+	}
+
 	// Convert messages to Gemini content format
 	for _, msg := range req.Messages {
 		// Set the role based on the message role
@@ -372,7 +385,41 @@ func convertGeminiResponseToContent(res *gemini.Response) []llm.Content {
 	return contents
 }
 
-// Gemini doesn't provide usage info directly, so we need to estimate it
+// finishReason returns the finish reason of res's first candidate, or the prompt's block reason if it was blocked.
+func finishReason(res *gemini.Response) string {
+	if res.PromptFeedback != nil && res.PromptFeedback.BlockReason != "" {
+		return res.PromptFeedback.BlockReason
+	}
+	if len(res.Candidates) == 0 {
+		return ""
+	}
+	return res.Candidates[0].FinishReason
+}
+
+// toStopReason converts Gemini's finish reason to an llm.StopReason.
+// Gemini reports STOP for both plain and function-calling responses,
+// so function calls in content take precedence.
+func toStopReason(res *gemini.Response, content []llm.Content) llm.StopReason {
+	for _, c := range content {
+		if c.Type == llm.ContentTypeToolUse {
+			return llm.StopReasonToolUse
+		}
+	}
+	switch reason := finishReason(res); reason {
+	case "", gemini.FinishReasonStop, "FINISH_REASON_UNSPECIFIED":
+		return llm.StopReasonEndTurn
+	case gemini.FinishReasonMaxTokens:
+		return llm.StopReasonMaxTokens
+	case gemini.FinishReasonMalformedFunctionCall, "OTHER":
+		// Nothing usable was generated; end the turn so the user can try again.
+		return llm.StopReasonEndTurn
+	default:
+		// SAFETY, RECITATION, LANGUAGE, BLOCKLIST, PROHIBITED_CONTENT, SPII, IMAGE_SAFETY, ...:
+		// Gemini declined to generate (or finish) the response.
+		return llm.StopReasonRefusal
+	}
+}
+
 // ensureToolIDs makes sure all tool uses have proper IDs
 func ensureToolIDs(contents []llm.Content) {
 	for i, content := range contents {
@@ -386,7 +433,20 @@ func ensureToolIDs(contents []llm.Content) {
 	}
 }
 
+// calculateUsage returns the usage reported by Gemini,
+// or an estimate if the response has no usage metadata.
+// Gemini caches prompt prefixes implicitly and reports cache hits as part of the prompt tokens.
 func calculateUsage(req *gemini.Request, res *gemini.Response) llm.Usage {
+	if res != nil && res.UsageMetadata != nil {
+		um := res.UsageMetadata
+		cached := uint64(min(um.CachedContentTokenCount, um.PromptTokenCount))
+		return llm.Usage{
+			InputTokens:          uint64(um.PromptTokenCount) - cached,
+			CacheReadInputTokens: cached,
+			OutputTokens:         uint64(um.CandidatesTokenCount + um.ThoughtsTokenCount),
+		}
+	}
+
 	// Very rough estimation of token counts
 	var inputTokens uint64
 	var outputTokens uint64
@@ -439,25 +499,19 @@ func calculateUsage(req *gemini.Request, res *gemini.Response) llm.Usage {
 
 // TokenContextWindow returns the maximum token context window size for this service
 func (s *Service) TokenContextWindow() int {
-	model := s.Model
-	if model == "" {
-		model = DefaultModel
-	}
-
-	// Gemini models generally have large context windows
-	switch model {
-	case "gemini-2.5-pro-preview-03-25":
-		return 1000000 // 1M tokens for Gemini 2.5 Pro
-	case "gemini-2.0-flash-exp":
-		return 1000000 // 1M tokens for Gemini 2.0 Flash
-	case "gemini-1.5-pro", "gemini-1.5-pro-latest":
-		return 2000000 // 2M tokens for Gemini 1.5 Pro
-	case "gemini-1.5-flash", "gemini-1.5-flash-latest":
-		return 1000000 // 1M tokens for Gemini 1.5 Flash
-	default:
-		// Default for unknown models
-		return 1000000
+	return s.Capabilities().ContextWindow
+}
+
+// Capabilities returns the capabilities of s.Model, from the llm capability registry.
+func (s *Service) Capabilities() llm.Capabilities {
+	if caps, ok := llm.CapabilitiesFor(cmp.Or(s.Model, DefaultModel)); ok {
+		return caps
 	}
+	// Gemini models generally have large context windows and accept images.
+	caps := llm.DefaultCapabilities
+	caps.ContextWindow = 1000000
+	caps.Vision = true
+	return caps
 }
 
 // Do sends a request to Gemini.
@@ -529,8 +583,13 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 	var gemRes *gemini.Response
 
 	// Retry mechanism for handling server errors and rate limiting
-	backoff := []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second, 10 * time.Second}
-	for attempts := 0; attempts <= len(backoff); attempts++ {
+	backoff := llm.Backoff{Base: time.Second, Max: 10 * time.Second}
+	maxRetries := llm.RetryLimit(ctx, 4)
+	retries := 0
+	for attempts := 0; attempts <= maxRetries; attempts++ {
+		if s.Keys != nil {
+			model.APIKey = s.Keys.Key()
+		}
 		gemApiErr := error(nil)
 		gemRes, gemApiErr = model.GenerateContent(ctx, gemReq)
 		endTime = time.Now()
@@ -541,26 +600,34 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 			if resJSON, err := json.MarshalIndent(gemRes, "", "  "); err == nil {
 				slog.DebugContext(ctx, "gemini_response_json", "response", string(resJSON))
 			}
+			// Gemini sometimes emits a function call it cannot parse, and returns no content at all.
+			// The output is sampled, so asking again usually works.
+			if finishReason(gemRes) == gemini.FinishReasonMalformedFunctionCall && attempts < maxRetries {
+				slog.WarnContext(ctx, "gemini_malformed_function_call", "attempt", attempts+1)
+				continue
+			}
 			break
 		}
 
-		if attempts == len(backoff) {
+		if attempts == maxRetries {
 			// We've exhausted all retry attempts
-			return nil, fmt.Errorf("gemini: API error after %d attempts: %w", attempts, gemApiErr)
+			return nil, fmt.Errorf("gemini: API error after %d attempts: %w", attempts+1, gemApiErr)
 		}
-
-		// Check if the error is retryable (e.g., server error or rate limiting)
-		if strings.Contains(gemApiErr.Error(), "429") || strings.Contains(gemApiErr.Error(), "5") {
-			// Rate limited or server error - wait and retry
-			random := time.Duration(rand.Int63n(int64(time.Second)))
-			sleep := backoff[attempts] + random
-			slog.WarnContext(ctx, "gemini_request_retry", "error", gemApiErr.Error(), "attempt", attempts+1, "sleep", sleep)
-			time.Sleep(sleep)
-			continue
+		if !llm.IsRetryable(gemApiErr) {
+			return nil, fmt.Errorf("gemini: API error: %w", gemApiErr)
 		}
+		retries++
 
-		// Non-retryable error
-		return nil, fmt.Errorf("gemini: API error: %w", gemApiErr)
+		var serr *llm.StatusError
+		if s.Keys != nil && errors.As(gemApiErr, &serr) && serr.StatusCode == http.StatusTooManyRequests && s.Keys.RateLimited(model.APIKey, serr.RetryAfter) {
+			continue // retry right away with another key
+		}
+		// Rate limited or server error - wait and retry
+		sleep := backoff.Delay(retries, gemApiErr)
+		slog.WarnContext(ctx, "gemini_request_retry", "error", gemApiErr.Error(), "attempt", attempts+1, "sleep", sleep)
+		if err := llm.Sleep(ctx, sleep); err != nil {
+			return nil, errors.Join(gemApiErr, err)
+		}
 	}
 
 	content := convertGeminiResponseToContent(gemRes)
@@ -569,16 +636,16 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 
 	usage := calculateUsage(gemReq, gemRes)
 	usage.CostUSD = llm.CostUSDFromResponse(gemRes.Header())
+	usage.Retries = uint64(retries)
+	if s.Keys != nil {
+		s.Keys.Record(model.APIKey, usage)
+	}
 
-	stopReason := llm.StopReasonEndTurn
-	for _, part := range content {
-		if part.Type == llm.ContentTypeToolUse {
-			stopReason = llm.StopReasonToolUse
-			slog.DebugContext(ctx, "gemini_tool_use_detected",
-				"setting_stop_reason", "llm.StopReasonToolUse",
-				"tool_name", part.ToolName)
-			break
-		}
+	stopReason := toStopReason(gemRes, content)
+	if reason := finishReason(gemRes); reason != "" && reason != gemini.FinishReasonStop &&
+		len(content) == 1 && content[0].Type == llm.ContentTypeText && content[0].Text == "" {
+		// Say why there is no response, rather than silently ending the turn.
+		content[0].Text = fmt.Sprintf("(Gemini returned no response: %s)", reason)
 	}
 
 	return &llm.Response{
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"sketch.dev/llm"
@@ -364,3 +365,71 @@ func TestHeaderCostIntegration(t *testing.T) {
 		t.Fatalf("Expected output tokens to be estimated, got 0")
 	}
 }
+
+func TestCalculateUsageMetadata(t *testing.T) {
+	res := &gemini.Response{
+		UsageMetadata: &gemini.UsageMetadata{
+			PromptTokenCount:        1000,
+			CachedContentTokenCount: 800,
+			CandidatesTokenCount:    50,
+			ThoughtsTokenCount:      25,
+		},
+	}
+	got := calculateUsage(&gemini.Request{}, res)
+	want := llm.Usage{InputTokens: 200, CacheReadInputTokens: 800, OutputTokens: 75}
+	if got != want {
+		t.Errorf("calculateUsage = %+v, want %+v", got, want)
+	}
+}
+
+func TestToStopReason(t *testing.T) {
+	toolUse := []llm.Content{{Type: llm.ContentTypeToolUse, ToolName: "bash"}}
+	text := []llm.Content{{Type: llm.ContentTypeText, Text: "hi"}}
+	tests := []struct {
+		name    string
+		res     *gemini.Response
+		content []llm.Content
+		want    llm.StopReason
+	}{
+		{"stop", &gemini.Response{Candidates: []gemini.Candidate{{FinishReason: "STOP"}}}, text, llm.StopReasonEndTurn},
+		{"function call", &gemini.Response{Candidates: []gemini.Candidate{{FinishReason: "STOP"}}}, toolUse, llm.StopReasonToolUse},
+		{"max tokens", &gemini.Response{Candidates: []gemini.Candidate{{FinishReason: "MAX_TOKENS"}}}, text, llm.StopReasonMaxTokens},
+		{"safety", &gemini.Response{Candidates: []gemini.Candidate{{FinishReason: "SAFETY"}}}, text, llm.StopReasonRefusal},
+		{"recitation", &gemini.Response{Candidates: []gemini.Candidate{{FinishReason: "RECITATION"}}}, text, llm.StopReasonRefusal},
+		{"blocked prompt", &gemini.Response{PromptFeedback: &gemini.PromptFeedback{BlockReason: "PROHIBITED_CONTENT"}}, text, llm.StopReasonRefusal},
+		{"malformed function call", &gemini.Response{Candidates: []gemini.Candidate{{FinishReason: "MALFORMED_FUNCTION_CALL"}}}, text, llm.StopReasonEndTurn},
+		{"no candidates", &gemini.Response{}, text, llm.StopReasonEndTurn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toStopReason(tt.res, tt.content); got != tt.want {
+				t.Errorf("toStopReason = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoRetriesMalformedFunctionCall(t *testing.T) {
+	responses := []string{
+		`{"candidates":[{"content":{"parts":[]},"finishReason":"MALFORMED_FUNCTION_CALL"}]}`,
+		`{"candidates":[{"content":{"parts":[{"functionCall":{"name":"bash","args":{"command":"ls"}}}]},"finishReason":"STOP"}]}`,
+	}
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, responses[min(calls, len(responses)-1)])
+		calls++
+	}))
+	defer srv.Close()
+
+	service := &Service{URL: srv.URL, APIKey: "key"}
+	resp, err := service.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("list files")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("made %d requests, want 2", calls)
+	}
+	if resp.StopReason != llm.StopReasonToolUse || resp.Content[0].ToolName != "bash" {
+		t.Errorf("response = %+v, want bash tool use", resp)
+	}
+}
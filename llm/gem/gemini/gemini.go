@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+
+	"sketch.dev/llm"
 )
 
 // https://ai.google.dev/api/generate-content#request-body
@@ -21,8 +23,23 @@ type Request struct {
 
 // https://ai.google.dev/api/generate-content#response-body
 type Response struct {
-	Candidates []Candidate `json:"candidates"`
-	headers    http.Header // captured HTTP response headers
+	Candidates     []Candidate     `json:"candidates"`
+	PromptFeedback *PromptFeedback `json:"promptFeedback,omitempty"`
+	UsageMetadata  *UsageMetadata  `json:"usageMetadata,omitempty"`
+	headers        http.Header     // captured HTTP response headers
+}
+
+// https://ai.google.dev/api/generate-content#PromptFeedback
+type PromptFeedback struct {
+	BlockReason string `json:"blockReason,omitempty"` // e.g. "SAFETY"; set only if the prompt was blocked
+}
+
+// https://ai.google.dev/api/generate-content#UsageMetadata
+type UsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"` // subset of PromptTokenCount
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
 }
 
 // Header returns the HTTP response headers.
@@ -30,10 +47,20 @@ func (r *Response) Header() http.Header {
 	return r.headers
 }
 
+// https://ai.google.dev/api/generate-content#candidate
 type Candidate struct {
-	Content Content `json:"content"`
+	Content      Content `json:"content"`
+	FinishReason string  `json:"finishReason,omitempty"`
 }
 
+// Finish reasons.
+// See https://ai.google.dev/api/generate-content#FinishReason for the full list.
+const (
+	FinishReasonStop                  = "STOP"
+	FinishReasonMaxTokens             = "MAX_TOKENS"
+	FinishReasonMalformedFunctionCall = "MALFORMED_FUNCTION_CALL"
+)
+
 type Content struct {
 	Parts []Part `json:"parts"`
 	Role  string `json:"role,omitempty"`
@@ -162,7 +189,7 @@ func (m Model) GenerateContent(ctx context.Context, req *Request) (*Response, er
 		return nil, fmt.Errorf("GenerateContent: reading response body: %w", err)
 	}
 	if httpResp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GenerateContent: HTTP status: %d, %s", httpResp.StatusCode, string(body))
+		return nil, fmt.Errorf("GenerateContent: %w", &llm.StatusError{StatusCode: httpResp.StatusCode, Message: string(body), RetryAfter: llm.RetryAfter(httpResp.Header)})
 	}
 	var res Response
 	if err := json.Unmarshal(body, &res); err != nil {
@@ -172,6 +199,51 @@ func (m Model) GenerateContent(ctx context.Context, req *Request) (*Response, er
 	return &res, nil
 }
 
+// https://ai.google.dev/api/tokens#method:-models.counttokens
+type countTokensRequest struct {
+	GenerateContentRequest struct {
+		Model string `json:"model"`
+		*Request
+	} `json:"generateContentRequest"`
+}
+
+type countTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+// CountTokens returns the number of input tokens that req would use.
+func (m Model) CountTokens(ctx context.Context, req *Request) (int, error) {
+	var ctr countTokensRequest
+	ctr.GenerateContentRequest.Model = m.Model
+	ctr.GenerateContentRequest.Request = req
+	reqBytes, err := json.Marshal(ctr)
+	if err != nil {
+		return 0, fmt.Errorf("marshaling request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/%s:countTokens?key=%s", m.endpoint(), m.Model, m.APIKey), bytes.NewReader(reqBytes))
+	if err != nil {
+		return 0, fmt.Errorf("creating HTTP request: %w", err)
+	}
+	httpReq.Header.Add("Content-Type", "application/json")
+	httpResp, err := m.httpc().Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("CountTokens: do: %w", err)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("CountTokens: reading response body: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("CountTokens: %w", &llm.StatusError{StatusCode: httpResp.StatusCode, Message: string(body), RetryAfter: llm.RetryAfter(httpResp.Header)})
+	}
+	var res countTokensResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return 0, fmt.Errorf("CountTokens: unmarshaling response: %w, %s", err, string(body))
+	}
+	return res.TotalTokens, nil
+}
+
 func (m Model) endpoint() string {
 	if m.Endpoint != "" {
 		return m.Endpoint
@@ -185,3 +257,51 @@ func (m Model) httpc() *http.Client {
 	}
 	return http.DefaultClient
 }
+
+// EmbedContentRequest is a request to embed a single piece of content.
+type EmbedContentRequest struct {
+	Model   string  `json:"model"`
+	Content Content `json:"content"`
+}
+
+type BatchEmbedContentsRequest struct {
+	Requests []EmbedContentRequest `json:"requests"`
+}
+
+type ContentEmbedding struct {
+	Values []float32 `json:"values"`
+}
+
+type BatchEmbedContentsResponse struct {
+	Embeddings []ContentEmbedding `json:"embeddings"`
+}
+
+// BatchEmbedContents embeds each request's content, returning embeddings in request order.
+func (m Model) BatchEmbedContents(ctx context.Context, req *BatchEmbedContentsRequest) (*BatchEmbedContentsResponse, error) {
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/%s:batchEmbedContents?key=%s", m.endpoint(), m.Model, m.APIKey), bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP request: %w", err)
+	}
+	httpReq.Header.Add("Content-Type", "application/json")
+	httpResp, err := m.httpc().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("BatchEmbedContents: do: %w", err)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("BatchEmbedContents: reading response body: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("BatchEmbedContents: %w", &llm.StatusError{StatusCode: httpResp.StatusCode, Message: string(body), RetryAfter: llm.RetryAfter(httpResp.Header)})
+	}
+	var res BatchEmbedContentsResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("BatchEmbedContents: unmarshaling response: %w, %s", err, string(body))
+	}
+	return &res, nil
+}
@@ -0,0 +1,31 @@
+package gem
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/gem/gemini"
+)
+
+var _ llm.TokenCounter = (*Service)(nil)
+
+// CountTokens implements llm.TokenCounter with Gemini's countTokens method.
+func (s *Service) CountTokens(ctx context.Context, ir *llm.Request) (int, error) {
+	gemReq, err := s.buildGeminiRequest(ir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build Gemini request: %w", err)
+	}
+	model := gemini.Model{
+		Model:    "models/" + cmp.Or(s.Model, DefaultModel),
+		Endpoint: s.URL,
+		APIKey:   s.APIKey,
+		HTTPC:    cmp.Or(s.HTTPC, http.DefaultClient),
+	}
+	if s.Keys != nil {
+		model.APIKey = s.Keys.Key()
+	}
+	return model.CountTokens(ctx, gemReq)
+}
@@ -0,0 +1,41 @@
+package gem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestCountTokens(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-test:countTokens" || r.URL.Query().Get("key") != "test-key" {
+			http.NotFound(w, r)
+			return
+		}
+		var body struct {
+			GenerateContentRequest struct {
+				Model    string            `json:"model"`
+				Contents []json.RawMessage `json:"contents"`
+			} `json:"generateContentRequest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if body.GenerateContentRequest.Model != "models/gemini-test" || len(body.GenerateContentRequest.Contents) != 1 {
+			t.Errorf("counted %+v, want the model and one message", body)
+		}
+		fmt.Fprint(w, `{"totalTokens":9}`)
+	}))
+	defer srv.Close()
+
+	s := &Service{URL: srv.URL, APIKey: "test-key", Model: "gemini-test"}
+	n, err := s.CountTokens(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hello")}})
+	if err != nil || n != 9 {
+		t.Errorf("CountTokens = %d, %v; want 9", n, err)
+	}
+}
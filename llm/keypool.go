@@ -0,0 +1,173 @@
+package llm
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeyCooldown is how long a KeyPool avoids a rate-limited key
+// when the service does not say how long to wait.
+const DefaultKeyCooldown = time.Minute
+
+// A KeyPool is a set of API keys for a single provider.
+// Services that support key pools rotate to the next key when the current one
+// is rate limited or out of quota, instead of waiting for it to recover.
+// A KeyPool is safe for concurrent use.
+type KeyPool struct {
+	mu   sync.Mutex
+	keys []*poolKey
+	cur  int
+}
+
+type poolKey struct {
+	key        string
+	coolUntil  time.Time
+	requests   int
+	rateLimits int
+	usage      Usage
+}
+
+// KeyUsage is the usage of a single key in a KeyPool.
+type KeyUsage struct {
+	// Key identifies the key without revealing it.
+	Key        string `json:"key"`
+	Requests   int    `json:"requests"`
+	RateLimits int    `json:"rate_limits"`
+	Usage      Usage  `json:"usage"`
+}
+
+// NewKeyPool returns a KeyPool that uses keys in order.
+// Empty keys are ignored.
+func NewKeyPool(keys ...string) *KeyPool {
+	p := new(KeyPool)
+	for _, k := range keys {
+		if k = strings.TrimSpace(k); k != "" {
+			p.keys = append(p.keys, &poolKey{key: k})
+		}
+	}
+	return p
+}
+
+// ParseKeyPool returns a KeyPool for a comma-separated list of keys,
+// as found in an API key environment variable or flag.
+// It returns nil if s contains fewer than two keys, in which case the caller should use s as a single key.
+func ParseKeyPool(s string) *KeyPool {
+	p := NewKeyPool(strings.Split(s, ",")...)
+	if p.Len() < 2 {
+		return nil
+	}
+	return p
+}
+
+// Len returns the number of keys in p.
+func (p *KeyPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.keys)
+}
+
+// Key returns the key to use for the next request: the current key, unless it
+// is cooling down after a rate limit, in which case the next available key.
+// If every key is cooling down, Key returns the one that recovers soonest.
+func (p *KeyPool) Key() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.keys) == 0 {
+		return ""
+	}
+	now := time.Now()
+	best := p.cur
+	for i := range p.keys {
+		idx := (p.cur + i) % len(p.keys)
+		k := p.keys[idx]
+		if !now.Before(k.coolUntil) {
+			best = idx
+			break
+		}
+		if k.coolUntil.Before(p.keys[best].coolUntil) {
+			best = idx
+		}
+	}
+	p.cur = best
+	return p.keys[best].key
+}
+
+// RateLimited records that key was rate limited or ran out of quota,
+// and that it should not be used for retryAfter (DefaultKeyCooldown if zero).
+// It reports whether another key is available for immediate use.
+func (p *KeyPool) RateLimited(key string, retryAfter time.Duration) bool {
+	if retryAfter <= 0 {
+		retryAfter = DefaultKeyCooldown
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	available := false
+	for _, k := range p.keys {
+		if k.key == key {
+			k.rateLimits++
+			k.coolUntil = now.Add(retryAfter)
+			slog.Warn("llm_key_rate_limited", "key", redactKey(key), "cooldown", retryAfter)
+		} else if !now.Before(k.coolUntil) {
+			available = true
+		}
+	}
+	return available
+}
+
+// Record records a successful request made with key and its usage.
+func (p *KeyPool) Record(key string, u Usage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range p.keys {
+		if k.key == key {
+			k.requests++
+			k.usage.Add(u)
+			return
+		}
+	}
+}
+
+// Usage returns the usage of each key in p, in pool order.
+func (p *KeyPool) Usage() []KeyUsage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []KeyUsage
+	for _, k := range p.keys {
+		out = append(out, KeyUsage{
+			Key:        redactKey(k.key),
+			Requests:   k.requests,
+			RateLimits: k.rateLimits,
+			Usage:      k.usage,
+		})
+	}
+	return out
+}
+
+// redactKey returns a form of key that is safe to log.
+func redactKey(key string) string {
+	if len(key) <= 8 {
+		return "..."
+	}
+	return "..." + key[len(key)-4:]
+}
+
+// RetryAfter returns the delay requested by the Retry-After header in h, or 0 if there is none.
+// It also understands the millisecond-precision Retry-After-Ms header that OpenAI-compatible servers send.
+func RetryAfter(h http.Header) time.Duration {
+	if ms, err := strconv.Atoi(strings.TrimSpace(h.Get("Retry-After-Ms"))); err == nil && ms >= 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestKeyPoolRotation(t *testing.T) {
+	p := NewKeyPool("key-one-1111", "", "key-two-2222")
+	if p.Len() != 2 {
+		t.Fatalf("Len = %d, want 2 (empty keys ignored)", p.Len())
+	}
+	if got := p.Key(); got != "key-one-1111" {
+		t.Fatalf("Key = %q, want first key", got)
+	}
+	if !p.RateLimited("key-one-1111", time.Hour) {
+		t.Errorf("RateLimited reported no key available, want the second key")
+	}
+	if got := p.Key(); got != "key-two-2222" {
+		t.Fatalf("after rate limit, Key = %q, want second key", got)
+	}
+	// Both keys exhausted: use the one that recovers first.
+	if p.RateLimited("key-two-2222", 2*time.Hour) {
+		t.Errorf("RateLimited reported a key available, want none")
+	}
+	if got := p.Key(); got != "key-one-1111" {
+		t.Errorf("all limited, Key = %q, want the key that recovers first", got)
+	}
+
+	p.Record("key-one-1111", Usage{InputTokens: 10, CostUSD: 1})
+	p.Record("key-one-1111", Usage{InputTokens: 5})
+	usage := p.Usage()
+	if len(usage) != 2 {
+		t.Fatalf("Usage = %+v, want 2 keys", usage)
+	}
+	if u := usage[0]; u.Key != "...1111" || u.Requests != 2 || u.RateLimits != 1 || u.Usage.InputTokens != 15 {
+		t.Errorf("Usage[0] = %+v", u)
+	}
+}
+
+func TestParseKeyPool(t *testing.T) {
+	if p := ParseKeyPool("single"); p != nil {
+		t.Errorf("ParseKeyPool(single key) = %v, want nil", p)
+	}
+	if p := ParseKeyPool("a, b"); p == nil || p.Len() != 2 {
+		t.Errorf("ParseKeyPool(two keys) = %v, want pool of 2", p)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	h := http.Header{}
+	if got := RetryAfter(h); got != 0 {
+		t.Errorf("RetryAfter(none) = %v", got)
+	}
+	h.Set("Retry-After", "30")
+	if got := RetryAfter(h); got != 30*time.Second {
+		t.Errorf("RetryAfter(30) = %v", got)
+	}
+	h.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if got := RetryAfter(h); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("RetryAfter(date an hour from now) = %v", got)
+	}
+	h.Set("Retry-After-Ms", "1500")
+	if got := RetryAfter(h); got != 1500*time.Millisecond {
+		t.Errorf("RetryAfter(Retry-After-Ms: 1500) = %v", got)
+	}
+}
@@ -40,6 +40,11 @@ type Request struct {
 	ToolChoice *ToolChoice
 	Tools      []*Tool
 	System     []SystemContent
+	// OutputSchema, if set, is a JSON schema that the response must follow.
+	// The response is then a single text content containing the JSON value.
+	// Services use schema-constrained decoding where available, but callers should
+	// still validate the response, e.g. with ValidateJSON.
+	OutputSchema json.RawMessage
 }
 
 // Message represents a message in the conversation.
@@ -76,14 +81,27 @@ type Tool struct {
 	InputSchema json.RawMessage
 	// EndsTurn indicates that this tool should cause the model to end its turn when used
 	EndsTurn bool
+	// Cache marks the end of a cacheable prefix of the tool list:
+	// this tool and all tools before it are cached, if the service supports it.
+	Cache bool `json:"-"`
+	// Serial indicates that Run has side effects, such as writing files, that other calls may depend on,
+	// or shares state that is not safe for concurrent use.
+	// A serial call in a response runs alone, after the calls made before it and before those made after it.
+	Serial bool `json:"-"`
 
 	// The Run function is automatically called when the tool is used.
-	// Run functions may be called concurrently with each other and themselves.
+	// Run functions may be called concurrently with each other and themselves, unless Serial is set.
 	// The input to Run function is the input to the tool, as provided by Claude, in compliance with the input schema.
 	// The outputs from Run will be sent back to Claude.
 	// If you do not want to respond to the tool call request from Claude, return ErrDoNotRespond.
 	// ctx contains extra (rarely used) tool call information; retrieve it with ToolCallInfoFromContext.
 	Run func(ctx context.Context, input json.RawMessage) ([]Content, error) `json:"-"`
+
+	// Retry, if set, adjusts the input of a call that failed with err so that it may succeed if made again,
+	// as by giving it more time. It returns the adjusted input and a note for the model saying what changed,
+	// or ok false if the call should not be made again.
+	// The conversation asks only about failures that a changed input can help; see conversation.Convo.
+	Retry func(err error, input json.RawMessage) (adjusted json.RawMessage, note string, ok bool) `json:"-"`
 }
 
 type Content struct {
@@ -107,6 +125,8 @@ type Content struct {
 	ToolUseID  string
 	ToolError  bool
 	ToolResult []Content
+	// ToolErrorKind classifies a failed tool call; it is not sent to the LLM.
+	ToolErrorKind ToolErrorKind
 
 	// timing information for tool_result; added externally; not sent to the LLM
 	ToolUseStartTime *time.Time
@@ -134,6 +154,9 @@ func ContentsAttr(contents []Content) slog.Attr {
 		case ContentTypeToolResult:
 			attrs = append(attrs, slog.Any("tool_result", content.ToolResult))
 			attrs = append(attrs, slog.Bool("tool_error", content.ToolError))
+			if content.ToolErrorKind != "" {
+				attrs = append(attrs, slog.String("tool_error_kind", string(content.ToolErrorKind)))
+			}
 		case ContentTypeThinking:
 			attrs = append(attrs, slog.String("thinking", content.Text))
 		default:
@@ -219,6 +242,8 @@ type Usage struct {
 	CacheReadInputTokens     uint64  `json:"cache_read_input_tokens"`
 	OutputTokens             uint64  `json:"output_tokens"`
 	CostUSD                  float64 `json:"cost_usd"`
+	// Retries is the number of times the request was retried after a retryable failure.
+	Retries uint64 `json:"retries,omitempty"`
 }
 
 func (u *Usage) Add(other Usage) {
@@ -227,6 +252,7 @@ func (u *Usage) Add(other Usage) {
 	u.CacheReadInputTokens += other.CacheReadInputTokens
 	u.OutputTokens += other.OutputTokens
 	u.CostUSD += other.CostUSD
+	u.Retries += other.Retries
 }
 
 func (u *Usage) String() string {
@@ -244,6 +270,7 @@ func (u *Usage) Attr() slog.Attr {
 		slog.Uint64("cache_creation_input_tokens", u.CacheCreationInputTokens),
 		slog.Uint64("cache_read_input_tokens", u.CacheReadInputTokens),
 		slog.Float64("cost_usd", u.CostUSD),
+		slog.Uint64("retries", u.Retries),
 	)
 }
 
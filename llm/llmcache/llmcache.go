@@ -0,0 +1,144 @@
+// Package llmcache caches LLM responses on disk,
+// so that deterministic prompts that recur across sessions are answered without a new request.
+package llmcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// DefaultTTL is how long cached responses are used by default.
+const DefaultTTL = 24 * time.Hour
+
+// A Cache stores LLM responses keyed by a hash of the request.
+// It is safe for concurrent use, including by multiple processes sharing a directory.
+type Cache struct {
+	dir       string
+	namespace string
+	ttl       time.Duration
+}
+
+// New returns a Cache that stores responses in dir for ttl.
+// namespace distinguishes responses from different models or services
+// that would otherwise share keys, and is typically the model name.
+func New(dir, namespace string, ttl time.Duration) *Cache {
+	return &Cache{dir: dir, namespace: namespace, ttl: ttl}
+}
+
+// DefaultDir returns the default cache directory, in the user's cache directory.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sketch", "llm-responses"), nil
+}
+
+// entry is the on-disk form of a cached response.
+type entry struct {
+	Created  time.Time     `json:"created"`
+	Response *llm.Response `json:"response"`
+}
+
+// key returns the cache key for req.
+func (c *Cache) key(req *llm.Request) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(c.namespace))
+	h.Write([]byte{0})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// Get returns the cached response to req, if there is one that has not expired.
+// Expired entries are removed.
+func (c *Cache) Get(req *llm.Request) (*llm.Response, bool) {
+	key, err := c.key(req)
+	if err != nil {
+		return nil, false
+	}
+	path := c.path(key)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil || e.Response == nil {
+		os.Remove(path)
+		return nil, false
+	}
+	if time.Since(e.Created) > c.ttl {
+		os.Remove(path)
+		return nil, false
+	}
+	return e.Response, true
+}
+
+// Put stores resp as the response to req.
+func (c *Cache) Put(req *llm.Request, resp *llm.Response) error {
+	key, err := c.key(req)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(entry{Created: time.Now(), Response: resp})
+	if err != nil {
+		return err
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("llmcache: %w", err)
+	}
+	// Write to a temporary file and rename it into place,
+	// so that concurrent readers never see a partial entry.
+	f, err := os.CreateTemp(filepath.Dir(path), key+".tmp*")
+	if err != nil {
+		return fmt.Errorf("llmcache: %w", err)
+	}
+	_, err = f.Write(b)
+	err = errors.Join(err, f.Close())
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("llmcache: %w", err)
+	}
+	return nil
+}
+
+// Prune removes all expired entries from the cache.
+func (c *Cache) Prune() error {
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if time.Since(info.ModTime()) > c.ttl {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package llmcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	c := New(dir, "claude", time.Hour)
+	req := &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hello")}}
+	if _, ok := c.Get(req); ok {
+		t.Fatal("Get on empty cache succeeded")
+	}
+	want := &llm.Response{Model: "m", Content: []llm.Content{llm.StringContent("hi")}}
+	if err := c.Put(req, want); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := c.Get(req)
+	if !ok || got.Content[0].Text != "hi" {
+		t.Fatalf("Get = %+v, %v; want cached response", got, ok)
+	}
+
+	other := &llm.Request{Messages: []llm.Message{llm.UserStringMessage("goodbye")}}
+	if _, ok := c.Get(other); ok {
+		t.Error("Get of a different request succeeded")
+	}
+	if _, ok := New(dir, "gpt4.1", time.Hour).Get(req); ok {
+		t.Error("Get in a different namespace succeeded")
+	}
+
+	expired := New(dir, "claude", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := expired.Get(req); ok {
+		t.Error("Get of an expired entry succeeded")
+	}
+	if _, ok := c.Get(req); ok {
+		t.Error("expired entry was not removed")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	c := New(dir, "claude", time.Hour)
+	req := &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hello")}}
+	if err := c.Put(req, &llm.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(req); !ok {
+		t.Error("Prune removed a live entry")
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	for _, f := range files {
+		os.Chtimes(f, old, old)
+	}
+	if err := c.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*", "*.json")); len(files) != 0 {
+		t.Errorf("Prune left %v", files)
+	}
+	if err := New(filepath.Join(dir, "missing"), "", time.Hour).Prune(); err != nil {
+		t.Errorf("Prune of missing dir: %v", err)
+	}
+}
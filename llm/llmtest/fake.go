@@ -0,0 +1,238 @@
+package llmtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+	"sketch.dev/llm"
+	"sketch.dev/llm/tokencount"
+)
+
+// A Service is a fake llm.Service that answers requests with scripted turns, in order,
+// so that code built on sketch can unit-test its tool integrations and permission flows
+// without a model.
+//
+// Scripts are written in Go, as a list of Turns, or in YAML; see LoadScript.
+type Service struct {
+	// Model is the model name reported in responses. It defaults to "llmtest".
+	Model string
+
+	mu       sync.Mutex
+	turns    []Turn
+	requests []*llm.Request
+}
+
+// A Turn is one scripted model response.
+type Turn struct {
+	// Expect, if non-empty, must appear in the request's last message,
+	// in its text or tool results; otherwise Do fails.
+	// Use it to check that the code under test told the model what it should have,
+	// such as the output of a tool or that permission was denied.
+	Expect string `yaml:"expect"`
+
+	// Text is the response's text, if any.
+	// It is streamed to the function installed with llm.WithTextDeltaFunc.
+	Text string `yaml:"text"`
+	// ToolCalls are the tools the response calls, after its text.
+	ToolCalls []ToolCall `yaml:"tool_calls"`
+	// StopReason is why the response ended: one of end_turn, tool_use, max_tokens,
+	// stop_sequence, or refusal. It defaults to tool_use if there are tool calls and end_turn otherwise.
+	StopReason string `yaml:"stop_reason"`
+
+	// Error, if non-empty, makes Do fail with this message instead of responding.
+	Error string `yaml:"error"`
+}
+
+// A ToolCall is a scripted call to a tool.
+type ToolCall struct {
+	// ID defaults to a unique ID.
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+	// Input is the tool's input, marshaled to JSON.
+	// A string or json.RawMessage is used as is.
+	Input any `yaml:"input"`
+}
+
+// NewService returns a Service that answers with turns, in order.
+func NewService(turns ...Turn) *Service {
+	return &Service{turns: turns}
+}
+
+// LoadScript returns a Service that answers with the turns in the YAML file,
+// which holds a model name and a list of turns, as in:
+//
+//	model: claude-sonnet-4-20250514
+//	turns:
+//	  - text: Let me look.
+//	    tool_calls:
+//	      - name: bash
+//	        input: {command: ls}
+//	  - expect: README.md
+//	    text: There is a README.
+func LoadScript(file string) (*Service, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var script struct {
+		Model string `yaml:"model"`
+		Turns []Turn `yaml:"turns"`
+	}
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse script %s: %w", file, err)
+	}
+	if len(script.Turns) == 0 {
+		return nil, fmt.Errorf("script %s has no turns", file)
+	}
+	s := NewService(script.Turns...)
+	s.Model = script.Model
+	return s, nil
+}
+
+var _ llm.Service = (*Service)(nil)
+
+// Do answers req with the next scripted turn.
+// It fails if the script has run out, or if req does not meet the turn's expectation.
+func (s *Service) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	s.mu.Lock()
+	n := len(s.requests)
+	s.requests = append(s.requests, req)
+	if n >= len(s.turns) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("llmtest: unexpected request %d; the script has only %d turns", n+1, len(s.turns))
+	}
+	turn := s.turns[n]
+	s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if turn.Expect != "" && len(req.Messages) > 0 {
+		if last := messageText(req.Messages[len(req.Messages)-1]); !strings.Contains(last, turn.Expect) {
+			return nil, fmt.Errorf("llmtest: turn %d expected the last message to contain %q, got:\n%s", n+1, turn.Expect, last)
+		}
+	}
+	if turn.Error != "" {
+		return nil, errors.New(turn.Error)
+	}
+
+	resp := &llm.Response{
+		ID:    fmt.Sprintf("llmtest_%d", n+1),
+		Role:  llm.MessageRoleAssistant,
+		Model: s.model(),
+	}
+	if turn.Text != "" {
+		if f := llm.TextDeltaFunc(ctx); f != nil {
+			f(turn.Text)
+		}
+		resp.Content = append(resp.Content, llm.StringContent(turn.Text))
+	}
+	for i, call := range turn.ToolCalls {
+		input, err := toolInput(call.Input)
+		if err != nil {
+			return nil, fmt.Errorf("llmtest: turn %d, tool call %s: %w", n+1, call.Name, err)
+		}
+		id := call.ID
+		if id == "" {
+			id = fmt.Sprintf("toolu_llmtest_%d_%d", n+1, i+1)
+		}
+		resp.Content = append(resp.Content, llm.Content{ID: id, Type: llm.ContentTypeToolUse, ToolName: call.Name, ToolInput: input})
+	}
+	reason, err := stopReason(turn)
+	if err != nil {
+		return nil, fmt.Errorf("llmtest: turn %d: %w", n+1, err)
+	}
+	resp.StopReason = reason
+
+	est := tokencount.For(resp.Model)
+	resp.Usage = llm.Usage{
+		InputTokens:  uint64(est.Request(req)),
+		OutputTokens: uint64(est.Message(resp.ToMessage())),
+	}
+	return resp, nil
+}
+
+// TokenContextWindow implements llm.Service.
+func (s *Service) TokenContextWindow() int {
+	return 200000
+}
+
+// Requests returns the requests s has received, in order.
+func (s *Service) Requests() []*llm.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*llm.Request(nil), s.requests...)
+}
+
+// Remaining returns the number of scripted turns not yet used.
+// A test that expects its script to be used up should check that it is zero.
+func (s *Service) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return max(len(s.turns)-len(s.requests), 0)
+}
+
+func (s *Service) model() string {
+	if s.Model == "" {
+		return "llmtest"
+	}
+	return s.Model
+}
+
+// messageText returns all the text of m, including that of tool results.
+func messageText(m llm.Message) string {
+	var b strings.Builder
+	var add func(cs []llm.Content)
+	add = func(cs []llm.Content) {
+		for _, c := range cs {
+			if c.Text != "" {
+				b.WriteString(c.Text)
+				b.WriteString("\n")
+			}
+			add(c.ToolResult)
+		}
+	}
+	add(m.Content)
+	return b.String()
+}
+
+func toolInput(input any) (json.RawMessage, error) {
+	switch input := input.(type) {
+	case nil:
+		return json.RawMessage("{}"), nil
+	case string:
+		if !json.Valid([]byte(input)) {
+			return nil, fmt.Errorf("input is not valid JSON: %s", input)
+		}
+		return json.RawMessage(input), nil
+	}
+	return json.Marshal(input)
+}
+
+var stopReasons = map[string]llm.StopReason{
+	"end_turn":      llm.StopReasonEndTurn,
+	"tool_use":      llm.StopReasonToolUse,
+	"max_tokens":    llm.StopReasonMaxTokens,
+	"stop_sequence": llm.StopReasonStopSequence,
+	"refusal":       llm.StopReasonRefusal,
+}
+
+func stopReason(turn Turn) (llm.StopReason, error) {
+	switch {
+	case turn.StopReason != "":
+		r, ok := stopReasons[turn.StopReason]
+		if !ok {
+			return 0, fmt.Errorf("unknown stop reason %q", turn.StopReason)
+		}
+		return r, nil
+	case len(turn.ToolCalls) > 0:
+		return llm.StopReasonToolUse, nil
+	}
+	return llm.StopReasonEndTurn, nil
+}
@@ -0,0 +1,123 @@
+package llmtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// greetTool greets the person named in its input, unless they are blocked,
+// standing in for a tool with a permission check.
+func greetTool(blocked string) *llm.Tool {
+	return &llm.Tool{
+		Name:        "greet",
+		Description: "Greets someone",
+		InputSchema: llm.MustSchema(`{"type": "object", "properties": {"name": {"type": "string"}}}`),
+		Run: func(ctx context.Context, m json.RawMessage) ([]llm.Content, error) {
+			var input struct{ Name string }
+			if err := json.Unmarshal(m, &input); err != nil {
+				return nil, err
+			}
+			if input.Name == blocked {
+				return nil, errors.New("permission denied")
+			}
+			return llm.TextContent("Hello, " + input.Name + "!"), nil
+		},
+	}
+}
+
+// runTurn sends text and runs tools until the model ends its turn.
+func runTurn(t *testing.T, convo *conversation.Convo, text string) *llm.Response {
+	t.Helper()
+	resp, err := convo.SendUserTextMessage(text)
+	for err == nil && resp.StopReason == llm.StopReasonToolUse {
+		var results []llm.Content
+		results, _, err = convo.ToolResultContents(context.Background(), resp)
+		if err != nil {
+			break
+		}
+		resp, err = convo.SendMessage(llm.Message{Role: llm.MessageRoleUser, Content: results})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestLoadScript(t *testing.T) {
+	srv, err := LoadScript("testdata/greet.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	convo := conversation.New(context.Background(), srv, nil)
+	convo.Tools = []*llm.Tool{greetTool("")}
+
+	resp := runTurn(t, convo, "Say hi to Ada")
+	if got := resp.Content[0].Text; got != "I said hello to Ada." {
+		t.Errorf("final response = %q", got)
+	}
+	if resp.Model != "claude-sonnet-4-20250514" {
+		t.Errorf("model = %q, want the script's", resp.Model)
+	}
+	if n := srv.Remaining(); n != 0 {
+		t.Errorf("%d turns left over", n)
+	}
+	if reqs := srv.Requests(); len(reqs) != 2 || len(reqs[0].Tools) != 1 {
+		t.Errorf("got %d requests, want 2 offering the greet tool", len(reqs))
+	}
+}
+
+func TestServiceExpect(t *testing.T) {
+	srv := NewService(
+		Turn{ToolCalls: []ToolCall{{Name: "greet", Input: map[string]string{"name": "Mallory"}}}},
+		Turn{Expect: "permission denied", Text: "I wasn't allowed to."},
+	)
+	convo := conversation.New(context.Background(), srv, nil)
+	convo.Tools = []*llm.Tool{greetTool("Mallory")}
+	runTurn(t, convo, "Say hi to Mallory")
+
+	// A tool that wrongly succeeds fails the script's expectation.
+	srv = NewService(
+		Turn{ToolCalls: []ToolCall{{Name: "greet", Input: `{"name": "Mallory"}`}}},
+		Turn{Expect: "permission denied", Text: "I wasn't allowed to."},
+	)
+	convo = conversation.New(context.Background(), srv, nil)
+	convo.Tools = []*llm.Tool{greetTool("")}
+	resp, err := convo.SendUserTextMessage("Say hi to Mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _, err := convo.ToolResultContents(context.Background(), resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = convo.SendMessage(llm.Message{Role: llm.MessageRoleUser, Content: results})
+	if err == nil || !strings.Contains(err.Error(), `expected the last message to contain "permission denied"`) {
+		t.Errorf("err = %v, want an unmet expectation", err)
+	}
+}
+
+func TestServiceErrors(t *testing.T) {
+	ctx := context.Background()
+	req := &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hi")}}
+
+	srv := NewService(Turn{Error: "overloaded"}, Turn{StopReason: "max_tokens", Text: "Once upon"})
+	if _, err := srv.Do(ctx, req); err == nil || err.Error() != "overloaded" {
+		t.Errorf("scripted error: err = %v", err)
+	}
+	resp, err := srv.Do(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StopReason != llm.StopReasonMaxTokens {
+		t.Errorf("StopReason = %v, want max tokens", resp.StopReason)
+	}
+	if _, err := srv.Do(ctx, req); err == nil || !strings.Contains(err.Error(), "only 2 turns") {
+		t.Errorf("exhausted script: err = %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Package llmtest helps test code that talks to LLMs hermetically:
+// without API keys or network access.
+//
+// A [Recorder] records the HTTP exchanges of real LLM services to golden files
+// and replays them, for testing conversation and tool-loop logic against real responses.
+// A [Service] is a fake llm.Service that answers with scripted text and tool calls,
+// for unit-testing tool integrations and permission flows.
+//
+// Golden files are [httprr] traces. To record or re-record one,
+// run the test with the provider's API key in the environment
+// and the -httprecord flag set to a regexp matching the file name:
+//
+//	ANTHROPIC_API_KEY=... go test ./loop -run TestAgentLoop -httprecord 'agent_loop'
+//
+// Credentials, and request headers that vary between machines or runs,
+// are scrubbed before requests are written to or looked up in a trace.
+package llmtest
+
+import (
+	"cmp"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"sketch.dev/httprr"
+)
+
+// ReplayAPIKey is the API key services get when replaying, when no real key is needed.
+const ReplayAPIKey = "llmtest-replay-key"
+
+// A Recorder records or replays the HTTP exchanges of LLM services in a test.
+type Recorder struct {
+	t  testing.TB
+	rr *httprr.RecordReplay
+}
+
+// Open opens the golden file for t, recording to it if the -httprecord flag matches file
+// and replaying it otherwise. The file is closed when t ends.
+// Open fails t if replaying and file does not exist.
+func Open(t testing.TB, file string) *Recorder {
+	t.Helper()
+	record, err := httprr.Recording(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return open(t, file, record, http.DefaultTransport)
+}
+
+func open(t testing.TB, file string, record bool, rt http.RoundTripper) *Recorder {
+	t.Helper()
+	var rr *httprr.RecordReplay
+	var err error
+	if record {
+		rr, err = httprr.OpenForRecording(file, rt)
+	} else {
+		rr, err = httprr.Open(file, rt)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("no recording at %s; record one with -httprecord %q", file, file)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr.ScrubReq(scrubRequest)
+	t.Cleanup(func() {
+		if err := rr.Close(); err != nil {
+			t.Errorf("closing %s: %v", file, err)
+		}
+	})
+	return &Recorder{t: t, rr: rr}
+}
+
+// Recording reports whether r is recording, as opposed to replaying.
+func (r *Recorder) Recording() bool {
+	return r.rr.Recording()
+}
+
+// Client returns an HTTP client that records or replays through r,
+// for use as a service's HTTP client.
+func (r *Recorder) Client() *http.Client {
+	return r.rr.Client()
+}
+
+// APIKey returns the API key for a service under test.
+// When recording, it is the first of the named environment variables that is set;
+// r's test fails if none is. When replaying, it is ReplayAPIKey.
+func (r *Recorder) APIKey(envVars ...string) string {
+	r.t.Helper()
+	if !r.Recording() {
+		return ReplayAPIKey
+	}
+	var key string
+	for _, v := range envVars {
+		key = cmp.Or(key, os.Getenv(v))
+	}
+	if key == "" {
+		r.t.Fatalf("recording requires an API key in one of %s", strings.Join(envVars, ", "))
+	}
+	return key
+}
+
+// scrubbedHeaders are request headers that carry credentials,
+// or that vary between machines and runs and so would keep requests from matching.
+var scrubbedHeaders = []string{
+	"Authorization",
+	"X-Api-Key",
+	"Anthropic-Api-Key",
+	"X-Goog-Api-Key",
+	"Api-Key",
+	"X-Amz-Date",
+	"X-Amz-Security-Token",
+}
+
+// scrubRequest removes credentials and volatile headers from req.
+func scrubRequest(req *http.Request) error {
+	for _, h := range scrubbedHeaders {
+		req.Header.Del(h)
+	}
+	for h := range req.Header {
+		// The OpenAI SDK reports its version, OS, runtime, and retry count.
+		if strings.HasPrefix(h, "X-Stainless-") {
+			req.Header.Del(h)
+		}
+	}
+	if q := req.URL.Query(); q.Has("key") { // Gemini
+		q.Del("key")
+		req.URL.RawQuery = q.Encode()
+	}
+	return nil
+}
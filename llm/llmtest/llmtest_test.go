@@ -0,0 +1,84 @@
+package llmtest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/ant"
+)
+
+const secretKey = "sk-ant-very-secret"
+
+func TestRecordThenReplay(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if got := r.Header.Get("X-Api-Key"); got != secretKey {
+			t.Errorf("server got API key %q, want the real key", got)
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514",
+			"content":[{"type":"text","text":"Hello, Cornelius."}],"stop_reason":"end_turn",
+			"usage":{"input_tokens":10,"output_tokens":5}}`)
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "hello.httprr")
+	ask := func(t *testing.T, record bool) string {
+		rec := open(t, file, record, http.DefaultTransport)
+		t.Setenv("LLMTEST_KEY", secretKey)
+		svc := &ant.Service{URL: srv.URL, HTTPC: rec.Client(), APIKey: rec.APIKey("LLMTEST_KEY")}
+		resp, err := svc.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("Hi, I'm Cornelius")}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Content[0].Text
+	}
+
+	t.Run("record", func(t *testing.T) {
+		if got := ask(t, true); got != "Hello, Cornelius." {
+			t.Errorf("recorded response = %q", got)
+		}
+	})
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secretKey) {
+		t.Errorf("recording contains the API key:\n%s", data)
+	}
+
+	srv.Close() // replaying must not need the network
+	t.Run("replay", func(t *testing.T) {
+		if got := ask(t, false); got != "Hello, Cornelius." {
+			t.Errorf("replayed response = %q", got)
+		}
+	})
+	if calls != 1 {
+		t.Errorf("server called %d times, want 1", calls)
+	}
+}
+
+func TestScrubRequest(t *testing.T) {
+	req := httptest.NewRequest("POST", "https://example.com/v1beta/models/gemini:generateContent?key=secret&alt=json", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Stainless-Runtime-Version", "go1.24")
+	req.Header.Set("Content-Type", "application/json")
+	if err := scrubRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.URL.RawQuery; got != "alt=json" {
+		t.Errorf("query = %q, want the key removed", got)
+	}
+	if len(req.Header) != 1 || req.Header.Get("Content-Type") == "" {
+		t.Errorf("headers = %v, want only Content-Type", req.Header)
+	}
+}
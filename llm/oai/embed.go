@@ -0,0 +1,140 @@
+package oai
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"sketch.dev/llm"
+)
+
+const (
+	VoyageURL       = "https://api.voyageai.com/v1"
+	VoyageAPIKeyEnv = "VOYAGE_API_KEY"
+)
+
+// EmbeddingModel is an embedding model served by an OpenAI-compatible embeddings API.
+type EmbeddingModel struct {
+	ModelName string // provided to the service to specify which model to use
+	URL       string
+	APIKeyEnv string // environment variable name for the API key
+	MaxBatch  int    // maximum number of inputs per request
+}
+
+var (
+	DefaultEmbeddingModel = TextEmbedding3Small
+
+	TextEmbedding3Small = EmbeddingModel{
+		ModelName: "text-embedding-3-small",
+		URL:       OpenAIURL,
+		APIKeyEnv: OpenAIAPIKeyEnv,
+		MaxBatch:  2048,
+	}
+
+	// VoyageCode3 is Voyage AI's code embedding model, which Anthropic recommends for use with Claude.
+	VoyageCode3 = EmbeddingModel{
+		ModelName: "voyage-code-3",
+		URL:       VoyageURL,
+		APIKeyEnv: VoyageAPIKeyEnv,
+		MaxBatch:  128,
+	}
+
+	// LlamaCPPEmbedding is whatever embedding model a local llama.cpp server was started with.
+	LlamaCPPEmbedding = EmbeddingModel{
+		ModelName: "llama.cpp local model",
+		URL:       LlamaCPPURL,
+		MaxBatch:  64,
+	}
+)
+
+// Embedder computes embeddings using an OpenAI-compatible embeddings API.
+// That includes OpenAI, Voyage AI, and local servers such as llama.cpp, vLLM, and Ollama.
+type Embedder struct {
+	HTTPC  *http.Client   // defaults to http.DefaultClient if nil
+	APIKey string         // optional
+	Keys   *llm.KeyPool   // if set, used instead of APIKey, rotating keys on rate limits
+	Model  EmbeddingModel // defaults to DefaultEmbeddingModel if zero value
+}
+
+var _ llm.Embedder = (*Embedder)(nil)
+
+// Embed implements llm.Embedder.
+func (e *Embedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	model := cmp.Or(e.Model, DefaultEmbeddingModel)
+	return llm.EmbedBatches(ctx, inputs, model.MaxBatch, func(ctx context.Context, batch []string) ([][]float32, error) {
+		return e.embedBatch(ctx, model, batch)
+	})
+}
+
+func (e *Embedder) embedBatch(ctx context.Context, model EmbeddingModel, batch []string) ([][]float32, error) {
+	req := openai.EmbeddingRequestStrings{
+		Input: batch,
+		Model: openai.EmbeddingModel(model.ModelName),
+	}
+	backoff := []time.Duration{1 * time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second}
+	var errs error
+	rotated := false
+	for attempts := 0; ; attempts++ {
+		if attempts > len(backoff) {
+			return nil, fmt.Errorf("embedding request failed after %d attempts: %w", attempts, errs)
+		}
+		if attempts > 0 && !rotated {
+			sleep := backoff[attempts-1] + time.Duration(rand.Int64N(int64(time.Second)))
+			slog.WarnContext(ctx, "embedding_request_sleep_before_retry", "sleep", sleep, "attempts", attempts)
+			select {
+			case <-time.After(sleep):
+			case <-ctx.Done():
+				return nil, errors.Join(errs, context.Cause(ctx))
+			}
+		}
+
+		rotated = false
+		apiKey := e.APIKey
+		if e.Keys != nil {
+			apiKey = e.Keys.Key()
+		}
+		config := openai.DefaultConfig(apiKey)
+		if model.URL != "" {
+			config.BaseURL = model.URL
+		}
+		config.HTTPClient = cmp.Or(e.HTTPC, http.DefaultClient)
+		resp, err := openai.NewClientWithConfig(config).CreateEmbeddings(ctx, req)
+		if err == nil {
+			if e.Keys != nil {
+				e.Keys.Record(apiKey, llm.Usage{InputTokens: uint64(resp.Usage.PromptTokens)})
+			}
+			// Responses carry their input index; don't rely on their order.
+			data := slices.Clone(resp.Data)
+			slices.SortFunc(data, func(a, b openai.Embedding) int { return cmp.Compare(a.Index, b.Index) })
+			vecs := make([][]float32, len(data))
+			for i, d := range data {
+				vecs[i] = d.Embedding
+			}
+			return vecs, nil
+		}
+
+		var apiErr *openai.APIError
+		if !errors.As(err, &apiErr) {
+			return nil, errors.Join(errs, err)
+		}
+		errs = errors.Join(errs, fmt.Errorf("status %d: %s", apiErr.HTTPStatusCode, apiErr.Error()))
+		switch {
+		case apiErr.HTTPStatusCode == http.StatusTooManyRequests:
+			slog.WarnContext(ctx, "embedding_request_rate_limited", "error", apiErr.Error())
+			if e.Keys != nil {
+				rotated = e.Keys.RateLimited(apiKey, 0)
+			}
+		case apiErr.HTTPStatusCode >= 500:
+			slog.WarnContext(ctx, "embedding_request_failed", "error", apiErr.Error(), "status_code", apiErr.HTTPStatusCode)
+		default:
+			return nil, errs
+		}
+	}
+}
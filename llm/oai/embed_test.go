@@ -0,0 +1,57 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestEmbedder(t *testing.T) {
+	var keys []string
+	var batchSizes []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		keys = append(keys, key)
+		if key == "limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"message":"rate limited","type":"rate_limit"}}`)
+			return
+		}
+		var req struct{ Input []string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		batchSizes = append(batchSizes, len(req.Input))
+		var data []string
+		// Return embeddings in reverse order, to check that Embed uses their indexes.
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			data = append(data, fmt.Sprintf(`{"index":%d,"embedding":[%d]}`, i, len(req.Input[i])))
+		}
+		fmt.Fprintf(w, `{"data":[%s],"usage":{"prompt_tokens":3}}`, strings.Join(data, ","))
+	}))
+	defer srv.Close()
+
+	e := &Embedder{
+		Keys:  llm.NewKeyPool("limited", "fresh"),
+		Model: EmbeddingModel{ModelName: "test-embed", URL: srv.URL, MaxBatch: 2},
+	}
+	got, err := e.Embed(context.Background(), []string{"a", "bb", "ccc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0][0] != 1 || got[1][0] != 2 || got[2][0] != 3 {
+		t.Errorf("Embed = %v, want [[1] [2] [3]]", got)
+	}
+	if fmt.Sprint(batchSizes) != "[2 1]" {
+		t.Errorf("batch sizes = %v, want [2 1]", batchSizes)
+	}
+	if keys[0] != "limited" || keys[len(keys)-1] != "fresh" {
+		t.Errorf("keys used = %v, want rotation away from the rate-limited key", keys)
+	}
+}
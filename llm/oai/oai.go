@@ -6,8 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	"math/rand/v2"
 	"net/http"
 	"strings"
 	"time"
@@ -206,9 +206,15 @@ var (
 type Service struct {
 	HTTPC     *http.Client // defaults to http.DefaultClient if nil
 	APIKey    string       // optional, if not set will try to load from env var
+	Keys      *llm.KeyPool // if set, used instead of APIKey, rotating keys on rate limits
 	Model     Model        // defaults to DefaultModel if zero value
 	MaxTokens int          // defaults to DefaultMaxTokens if zero
 	Org       string       // optional - organization ID
+
+	// Stream requests streamed responses, which are assembled into a single llm.Response.
+	// Self-hosted servers often need this: long generations can outlast
+	// proxy and idle timeouts when nothing is sent until the response is complete.
+	Stream bool
 }
 
 var _ llm.Service = (*Service)(nil)
@@ -260,6 +266,16 @@ func ModelByUserName(name string) *Model {
 	return nil
 }
 
+// CustomModel returns a Model for modelName as served by the OpenAI-compatible API at url,
+// such as a vLLM or llama.cpp server.
+func CustomModel(modelName, url string) Model {
+	return Model{
+		UserName:  modelName,
+		ModelName: modelName,
+		URL:       url,
+	}
+}
+
 var (
 	fromLLMRole = map[llm.MessageRole]string{
 		llm.MessageRoleAssistant: "assistant",
@@ -518,24 +534,26 @@ func toLLMContents(msg openai.ChatCompletionMessage) []llm.Content {
 // toLLMUsage converts usage information from OpenAI to llm.Usage.
 func (s *Service) toLLMUsage(au openai.Usage, headers http.Header) llm.Usage {
 	// fmt.Printf("raw usage: %+v / %v / %v\n", au, au.PromptTokensDetails, au.CompletionTokensDetails)
+	// OpenAI caches prompt prefixes automatically, and reports cache hits as a
+	// subset of the prompt tokens. There is no separate charge for cache writes.
 	in := uint64(au.PromptTokens)
 	var inc uint64
 	if au.PromptTokensDetails != nil {
-		inc = uint64(au.PromptTokensDetails.CachedTokens)
+		inc = min(uint64(au.PromptTokensDetails.CachedTokens), in)
 	}
 	out := uint64(au.CompletionTokens)
 	u := llm.Usage{
-		InputTokens:              in,
-		CacheReadInputTokens:     inc,
-		CacheCreationInputTokens: in,
-		OutputTokens:             out,
+		InputTokens:          in - inc,
+		CacheReadInputTokens: inc,
+		OutputTokens:         out,
 	}
 	u.CostUSD = llm.CostUSDFromResponse(headers)
 	return u
 }
 
 // toLLMResponse converts the OpenAI response to llm.Response.
-func (s *Service) toLLMResponse(r *openai.ChatCompletionResponse) *llm.Response {
+// headers are the HTTP response headers, used for cost reporting.
+func (s *Service) toLLMResponse(r *openai.ChatCompletionResponse, headers http.Header) *llm.Response {
 	// fmt.Printf("Raw response\n")
 	// enc := json.NewEncoder(os.Stdout)
 	// enc.SetIndent("", "  ")
@@ -547,7 +565,7 @@ func (s *Service) toLLMResponse(r *openai.ChatCompletionResponse) *llm.Response
 			ID:    r.ID,
 			Model: r.Model,
 			Role:  llm.MessageRoleAssistant,
-			Usage: s.toLLMUsage(r.Usage, r.Header()),
+			Usage: s.toLLMUsage(r.Usage, headers),
 		}
 	}
 
@@ -560,7 +578,7 @@ func (s *Service) toLLMResponse(r *openai.ChatCompletionResponse) *llm.Response
 		Role:       toRoleFromString(choice.Message.Role),
 		Content:    toLLMContents(choice.Message),
 		StopReason: toStopReason(string(choice.FinishReason)),
-		Usage:      s.toLLMUsage(r.Usage, r.Header()),
+		Usage:      s.toLLMUsage(r.Usage, headers),
 	}
 }
 
@@ -585,21 +603,16 @@ func toStopReason(reason string) llm.StopReason {
 
 // TokenContextWindow returns the maximum token context window size for this service
 func (s *Service) TokenContextWindow() int {
-	model := cmp.Or(s.Model, DefaultModel)
+	return s.Capabilities().ContextWindow
+}
 
-	// OpenAI models generally have 128k context windows
-	// Some newer models have larger windows, but 128k is a safe default
-	switch model.ModelName {
-	case "gpt-4.1-2025-04-14", "gpt-4.1-mini-2025-04-14", "gpt-4.1-nano-2025-04-14":
-		return 200000 // 200k for newer GPT-4.1 models
-	case "gpt-4o-2024-08-06", "gpt-4o-mini-2024-07-18":
-		return 128000 // 128k for GPT-4o models
-	case "o3-2025-04-16", "o3-mini-2025-04-16":
-		return 200000 // 200k for O3 models
-	default:
-		// Default for unknown models
-		return 128000
+// Capabilities returns the capabilities of s.Model, from the llm capability registry.
+// Models that are not in the registry, such as self-hosted ones, get llm.DefaultCapabilities.
+func (s *Service) Capabilities() llm.Capabilities {
+	if caps, ok := llm.CapabilitiesFor(cmp.Or(s.Model, DefaultModel).ModelName); ok {
+		return caps
 	}
+	return llm.DefaultCapabilities
 }
 
 // Do sends a request to OpenAI using the go-openai package.
@@ -609,16 +622,17 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 	model := cmp.Or(s.Model, DefaultModel)
 
 	// TODO: do this one during Service setup? maybe with a constructor instead?
-	config := openai.DefaultConfig(s.APIKey)
-	if model.URL != "" {
-		config.BaseURL = model.URL
-	}
-	if s.Org != "" {
-		config.OrgID = s.Org
+	newClient := func(httpc *http.Client, apiKey string) *openai.Client {
+		config := openai.DefaultConfig(apiKey)
+		if model.URL != "" {
+			config.BaseURL = model.URL
+		}
+		if s.Org != "" {
+			config.OrgID = s.Org
+		}
+		config.HTTPClient = httpc
+		return openai.NewClientWithConfig(config)
 	}
-	config.HTTPClient = httpc
-
-	client := openai.NewClientWithConfig(config)
 
 	// Start with system messages if provided
 	var allMessages []openai.ChatCompletionMessage
@@ -646,10 +660,22 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 		Tools:      tools,
 		ToolChoice: fromLLMToolChoice(ir.ToolChoice), // TODO: make fromLLMToolChoice return an error when a perfect translation is not possible
 	}
+	if ir.OutputSchema != nil {
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   "structured_output",
+				Schema: ir.OutputSchema,
+				// Strict mode rejects schemas with optional properties; callers validate responses instead.
+				Strict: false,
+			},
+		}
+	}
+	maxTokens := cmp.Or(s.MaxTokens, min(DefaultMaxTokens, s.Capabilities().MaxOutputTokens))
 	if model.IsReasoningModel {
-		req.MaxCompletionTokens = cmp.Or(s.MaxTokens, DefaultMaxTokens)
+		req.MaxCompletionTokens = maxTokens
 	} else {
-		req.MaxTokens = cmp.Or(s.MaxTokens, DefaultMaxTokens)
+		req.MaxTokens = maxTokens
 	}
 	// fmt.Printf("Sending request to OpenAI\n")
 	// enc := json.NewEncoder(os.Stdout)
@@ -657,58 +683,201 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 	// enc.Encode(req)
 	// fmt.Printf("\n")
 
-	// Retry mechanism
-	backoff := []time.Duration{1 * time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second}
+	backoff := llm.Backoff{Base: time.Second, Max: 15 * time.Second}
 
 	// retry loop
-	var errs error // accumulated errors across all attempts
-	for attempts := 0; ; attempts++ {
-		if attempts > 10 {
-			return nil, fmt.Errorf("openai request failed after %d attempts: %w", attempts, errs)
-		}
-		if attempts > 0 {
-			sleep := backoff[min(attempts, len(backoff)-1)] + time.Duration(rand.Int64N(int64(time.Second)))
-			slog.WarnContext(ctx, "openai request sleep before retry", "sleep", sleep, "attempts", attempts)
-			time.Sleep(sleep)
+	var errs error    // accumulated errors across all attempts
+	var lastErr error // the retryable error from the last attempt, if it failed
+	retries := 0
+	rotated := false // whether the last attempt switched to a fresh key, so there's no need to wait
+	for {
+		if lastErr != nil {
+			if retries >= llm.RetryLimit(ctx, 10) {
+				return nil, fmt.Errorf("openai request failed after %d attempts: %w", retries+1, errs)
+			}
+			retries++
+			if !rotated {
+				sleep := backoff.Delay(retries, lastErr)
+				slog.WarnContext(ctx, "openai_request_retry", "sleep", sleep, "retries", retries, "error", lastErr)
+				if err := llm.Sleep(ctx, sleep); err != nil {
+					return nil, errors.Join(errs, err)
+				}
+			}
+			lastErr = nil
 		}
 
-		resp, err := client.CreateChatCompletion(ctx, req)
+		rotated = false
+		apiKey := s.APIKey
+		if s.Keys != nil {
+			apiKey = s.Keys.Key()
+		}
+		rt := &retryAfterTransport{base: cmp.Or(httpc.Transport, http.DefaultTransport)}
+		attemptc := *httpc
+		attemptc.Transport = rt
+		resp, headers, err := s.createChatCompletion(ctx, newClient(&attemptc, apiKey), req)
 
 		// Handle successful response
 		if err == nil {
-			return s.toLLMResponse(&resp), nil
+			llmResp := s.toLLMResponse(resp, headers)
+			llmResp.Usage.Retries = uint64(retries)
+			if s.Keys != nil {
+				s.Keys.Record(apiKey, llmResp.Usage)
+			}
+			return llmResp, nil
 		}
 
 		// Handle errors
-		var apiErr *openai.APIError
-		if ok := errors.As(err, &apiErr); !ok {
-			// Not an OpenAI API error, return immediately with accumulated errors
-			return nil, errors.Join(errs, err)
+		if errors.Is(err, llm.ErrStalled) {
+			// The stream died silently; start over.
+			slog.WarnContext(ctx, "openai_stream_stalled", "error", err)
+			errs = errors.Join(errs, err)
+			lastErr = err
+			continue
 		}
-
+		serr := &llm.StatusError{Message: err.Error(), RetryAfter: rt.retryAfter}
+		var apiErr *openai.APIError
+		var reqErr *openai.RequestError // an error response that is not in OpenAI's format, e.g. from a proxy
 		switch {
-		case apiErr.HTTPStatusCode >= 500:
-			// Server error, try again with backoff
-			slog.WarnContext(ctx, "openai_request_failed", "error", apiErr.Error(), "status_code", apiErr.HTTPStatusCode)
-			errs = errors.Join(errs, fmt.Errorf("status %d: %s", apiErr.HTTPStatusCode, apiErr.Error()))
+		case errors.As(err, &apiErr):
+			serr.StatusCode = apiErr.HTTPStatusCode
+		case errors.As(err, &reqErr) && reqErr.HTTPStatusCode != 0:
+			serr.StatusCode = reqErr.HTTPStatusCode
+		case llm.IsRetryable(err) && !strings.Contains(err.Error(), "cached HTTP response not found"):
+			// A network failure; try again. (But don't retry httprr cache misses.)
+			slog.WarnContext(ctx, "openai_request_failed", "error", err)
+			errs = errors.Join(errs, err)
+			lastErr = err
 			continue
+		default:
+			return nil, errors.Join(err, errs)
+		}
+		if serr.StatusCode == http.StatusTooManyRequests {
+			slog.WarnContext(ctx, "openai_request_rate_limited", "error", serr.Message, "retry_after", serr.RetryAfter)
+			if s.Keys != nil {
+				rotated = s.Keys.RateLimited(apiKey, serr.RetryAfter)
+			}
+		} else {
+			slog.WarnContext(ctx, "openai_request_failed", "error", serr.Message, "status_code", serr.StatusCode)
+		}
+		if !serr.Retryable() {
+			// Client error, probably unrecoverable
+			// Put it first, so that errors.As finds it rather than an earlier, retryable failure.
+			return nil, errors.Join(serr, errs)
+		}
+		errs = errors.Join(errs, serr)
+		lastErr = serr
+	}
+}
 
-		case apiErr.HTTPStatusCode == 429:
-			// Rate limited, accumulate error and retry
-			slog.WarnContext(ctx, "openai_request_rate_limited", "error", apiErr.Error())
-			errs = errors.Join(errs, fmt.Errorf("status %d (rate limited): %s", apiErr.HTTPStatusCode, apiErr.Error()))
-			continue
+// retryAfterTransport records the delay requested by the Retry-After header of an error response,
+// which go-openai's errors do not expose.
+type retryAfterTransport struct {
+	base       http.RoundTripper
+	retryAfter time.Duration
+}
 
-		case apiErr.HTTPStatusCode >= 400 && apiErr.HTTPStatusCode < 500:
-			// Client error, probably unrecoverable
-			slog.WarnContext(ctx, "openai_request_failed", "error", apiErr.Error(), "status_code", apiErr.HTTPStatusCode)
-			return nil, errors.Join(errs, fmt.Errorf("status %d: %s", apiErr.HTTPStatusCode, apiErr.Error()))
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode >= 400 {
+		t.retryAfter = llm.RetryAfter(resp.Header)
+	}
+	return resp, err
+}
 
-		default:
-			// Other error, accumulate and retry
-			slog.WarnContext(ctx, "openai_request_failed", "error", apiErr.Error(), "status_code", apiErr.HTTPStatusCode)
-			errs = errors.Join(errs, fmt.Errorf("status %d: %s", apiErr.HTTPStatusCode, apiErr.Error()))
-			continue
+// createChatCompletion sends req, streaming the response if s.Stream is set.
+func (s *Service) createChatCompletion(ctx context.Context, client *openai.Client, req openai.ChatCompletionRequest) (*openai.ChatCompletionResponse, http.Header, error) {
+	if !s.Stream {
+		resp, err := client.CreateChatCompletion(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &resp, resp.Header(), nil
+	}
+
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stream.Close()
+	acc := &streamAccumulator{onText: llm.TextDeltaFunc(ctx), onToolUse: llm.PartialToolUseFunc(ctx)}
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		acc.add(chunk)
+	}
+	return acc.response(), stream.Header(), nil
+}
+
+// streamAccumulator assembles streamed chat completion chunks into a complete response.
+type streamAccumulator struct {
+	resp      openai.ChatCompletionResponse
+	content   strings.Builder
+	toolCalls []openai.ToolCall
+	// onText, if set, is called with each piece of content.
+	onText func(string)
+	// onToolUse, if set, is called with each tool call whose arguments grew.
+	onToolUse func(llm.PartialToolUse)
+}
+
+func (a *streamAccumulator) add(chunk openai.ChatCompletionStreamResponse) {
+	a.resp.ID = cmp.Or(a.resp.ID, chunk.ID)
+	a.resp.Model = cmp.Or(a.resp.Model, chunk.Model)
+	if chunk.Usage != nil {
+		a.resp.Usage = *chunk.Usage
+	}
+	if len(chunk.Choices) == 0 {
+		return
+	}
+	// Only the primary choice is used, as in the non-streaming case.
+	choice := chunk.Choices[0]
+	a.content.WriteString(choice.Delta.Content)
+	if a.onText != nil && choice.Delta.Content != "" {
+		a.onText(choice.Delta.Content)
+	}
+	if choice.FinishReason != "" {
+		a.resp.Choices = []openai.ChatCompletionChoice{{FinishReason: choice.FinishReason}}
+	}
+	for _, tc := range choice.Delta.ToolCalls {
+		// Each tool call arrives as a series of fragments sharing an index:
+		// the first carries the ID and function name, the rest carry pieces of the arguments.
+		idx := len(a.toolCalls) - 1
+		if tc.Index != nil {
+			idx = *tc.Index
+		} else if tc.ID != "" {
+			idx = len(a.toolCalls)
 		}
+		if idx < 0 {
+			idx = 0
+		}
+		for len(a.toolCalls) <= idx {
+			a.toolCalls = append(a.toolCalls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+		call := &a.toolCalls[idx]
+		call.ID = cmp.Or(call.ID, tc.ID)
+		call.Function.Name = cmp.Or(call.Function.Name, tc.Function.Name)
+		call.Function.Arguments += tc.Function.Arguments
+		if a.onToolUse != nil {
+			input, _ := llm.CompletePartialJSON(call.Function.Arguments)
+			a.onToolUse(llm.PartialToolUse{ID: call.ID, ToolName: call.Function.Name, Input: input})
+		}
+	}
+}
+
+func (a *streamAccumulator) response() *openai.ChatCompletionResponse {
+	resp := a.resp
+	if len(resp.Choices) == 0 {
+		resp.Choices = []openai.ChatCompletionChoice{{}}
+	}
+	resp.Choices[0].Message = openai.ChatCompletionMessage{
+		Role:      openai.ChatMessageRoleAssistant,
+		Content:   a.content.String(),
+		ToolCalls: a.toolCalls,
 	}
+	return &resp
 }
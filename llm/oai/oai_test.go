@@ -0,0 +1,116 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+func TestStream(t *testing.T) {
+	chunks := []string{
+		`{"id":"c1","model":"qwen","choices":[{"index":0,"delta":{"role":"assistant","content":"Let me "}}]}`,
+		`{"id":"c1","model":"qwen","choices":[{"index":0,"delta":{"content":"look."}}]}`,
+		`{"id":"c1","model":"qwen","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"bash","arguments":""}}]}}]}`,
+		`{"id":"c1","model":"qwen","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"command\":"}}]}}]}`,
+		`{"id":"c1","model":"qwen","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"ls\"}"}}]}}]}`,
+		`{"id":"c1","model":"qwen","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"id":"c1","model":"qwen","choices":[],"usage":{"prompt_tokens":100,"completion_tokens":20,"total_tokens":120}}`,
+	}
+	var gotReq map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	var text strings.Builder
+	var partial []string
+	ctx := llm.WithTextDeltaFunc(context.Background(), func(s string) { text.WriteString(s) })
+	ctx = llm.WithPartialToolUseFunc(ctx, func(p llm.PartialToolUse) {
+		if p.ID != "call_1" || p.ToolName != "bash" {
+			t.Errorf("partial tool use = %+v", p)
+		}
+		partial = append(partial, string(p.Input))
+	})
+	svc := &Service{Model: CustomModel("qwen", srv.URL), Stream: true}
+	resp, err := svc.Do(ctx, &llm.Request{
+		Messages: []llm.Message{{Role: llm.MessageRoleUser, Content: []llm.Content{{Type: llm.ContentTypeText, Text: "list files"}}}},
+		Tools:    []*llm.Tool{{Name: "bash", Description: "run a command", InputSchema: llm.MustSchema(`{"type":"object"}`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotReq["stream"] != true || gotReq["model"] != "qwen" {
+		t.Errorf("request stream=%v model=%v, want streaming request for qwen", gotReq["stream"], gotReq["model"])
+	}
+	if resp.StopReason != llm.StopReasonToolUse {
+		t.Errorf("StopReason = %v, want tool use", resp.StopReason)
+	}
+	if len(resp.Content) != 2 {
+		t.Fatalf("got %d contents, want text and tool use: %+v", len(resp.Content), resp.Content)
+	}
+	if resp.Content[0].Text != "Let me look." || text.String() != "Let me look." {
+		t.Errorf("text = %q, deltas = %q", resp.Content[0].Text, text.String())
+	}
+	tool := resp.Content[1]
+	if tool.ID != "call_1" || tool.ToolName != "bash" || string(tool.ToolInput) != `{"command":"ls"}` {
+		t.Errorf("tool use = %+v (input %s)", tool, tool.ToolInput)
+	}
+	if resp.Usage.InputTokens != 100 || resp.Usage.OutputTokens != 20 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+	if want := []string{"", "{}", `{"command":"ls"}`}; !slices.Equal(partial, want) {
+		t.Errorf("partial tool inputs = %q, want %q", partial, want)
+	}
+}
+
+func TestStreamStallRetry(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"id":"c1","model":"qwen","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		if requests.Add(1) == 1 {
+			<-release // the first stream dies silently
+			return
+		}
+		fmt.Fprint(w, `data: {"id":"c1","model":"qwen","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	svc := &Service{
+		Model:  CustomModel("qwen", srv.URL),
+		Stream: true,
+		HTTPC:  &http.Client{Transport: &llm.StallTransport{Timeout: 100 * time.Millisecond}},
+	}
+	resp, err := svc.Do(context.Background(), &llm.Request{
+		Messages: []llm.Message{{Role: llm.MessageRoleUser, Content: []llm.Content{{Type: llm.ContentTypeText, Text: "hi"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+	if resp.Content[0].Text != "Hello" {
+		t.Errorf("text = %q", resp.Content[0].Text)
+	}
+}
@@ -0,0 +1,28 @@
+package llm
+
+// Pricing is the price of a model, in USD per million tokens.
+type Pricing struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheRead  float64 `json:"cache_read"`
+	CacheWrite float64 `json:"cache_write"`
+}
+
+// Cost returns the cost in USD of u at prices p.
+func (p Pricing) Cost(u Usage) float64 {
+	const perToken = 1e-6
+	return perToken * (float64(u.InputTokens)*p.Input +
+		float64(u.OutputTokens)*p.Output +
+		float64(u.CacheReadInputTokens)*p.CacheRead +
+		float64(u.CacheCreationInputTokens)*p.CacheWrite)
+}
+
+// PricingFor returns the list price of model, from the capability registry.
+// It reports false for models with unknown pricing, such as local or self-hosted models.
+func PricingFor(model string) (Pricing, bool) {
+	caps, ok := CapabilitiesFor(model)
+	if !ok || caps.Pricing == nil {
+		return Pricing{}, false
+	}
+	return *caps.Pricing, true
+}
@@ -0,0 +1,36 @@
+package llm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPricingFor(t *testing.T) {
+	tests := []struct {
+		model     string
+		wantInput float64
+		wantOK    bool
+	}{
+		{model: "claude-sonnet-4-20250514", wantInput: 3, wantOK: true},
+		{model: "gpt-4.1-2025-04-14", wantInput: 2, wantOK: true},
+		{model: "gpt-4.1-mini-2025-04-14", wantInput: 0.40, wantOK: true}, // longest prefix wins
+		{model: "models/gemini-2.5-pro-preview-03-25", wantInput: 1.25, wantOK: true},
+		{model: "llama.cpp local model", wantOK: false},
+		{model: "", wantOK: false},
+	}
+	for _, tt := range tests {
+		p, ok := PricingFor(tt.model)
+		if ok != tt.wantOK || p.Input != tt.wantInput {
+			t.Errorf("PricingFor(%q) = %+v, %v; want input %v, %v", tt.model, p, ok, tt.wantInput, tt.wantOK)
+		}
+	}
+}
+
+func TestPricingCost(t *testing.T) {
+	p := Pricing{Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75}
+	u := Usage{InputTokens: 1000, OutputTokens: 2000, CacheReadInputTokens: 10000, CacheCreationInputTokens: 4000}
+	want := 0.003 + 0.030 + 0.003 + 0.015
+	if got := p.Cost(u); math.Abs(got-want) > 1e-12 {
+		t.Errorf("Cost = %v, want %v", got, want)
+	}
+}
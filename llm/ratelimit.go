@@ -0,0 +1,148 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A RateLimiter paces requests to one provider account with a token bucket,
+// which refills at the account's rate and holds up to a minute's worth of requests,
+// the way providers enforce their own limits.
+// When the provider rate limits a request anyway, Pause holds back every request until it recovers.
+// It is safe for concurrent use.
+type RateLimiter struct {
+	perMinute int // 0 for no limit but pauses
+
+	mu          sync.Mutex
+	tokens      float64
+	last        time.Time // when tokens was last refilled
+	pausedUntil time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows perMinute requests a minute, or any number if perMinute is 0.
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{perMinute: perMinute, tokens: float64(perMinute), last: time.Now()}
+}
+
+// Wait waits until a request may be sent, or until ctx is done, in which case it returns ctx's error.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		d := l.reserve(time.Now())
+		if d <= 0 {
+			return nil
+		}
+		if err := Sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a token and returns 0 if one is available at now,
+// and otherwise returns how long to wait before trying again.
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	if l.perMinute <= 0 {
+		return 0
+	}
+	rate := float64(l.perMinute) / float64(time.Minute)
+	l.tokens = min(float64(l.perMinute), l.tokens+float64(now.Sub(l.last))*rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / rate)
+}
+
+// Pause holds back requests for d, as after the provider asked us to retry after d.
+func (l *RateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[string]*RateLimiter)
+)
+
+// SharedRateLimiter returns the RateLimiter for the provider account identified by key,
+// creating it with perMinute if this is its first use,
+// so that every conversation and sub-conversation in the process shares the account's limit.
+func SharedRateLimiter(key string, perMinute int) *RateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	l, ok := rateLimiters[key]
+	if !ok {
+		l = NewRateLimiter(perMinute)
+		rateLimiters[key] = l
+	}
+	return l
+}
+
+// defaultRateLimitPause is how long RateLimitTransport holds back requests to an account
+// that rate limited a request without saying for how long.
+const defaultRateLimitPause = 5 * time.Second
+
+// RateLimitTransport is an http.RoundTripper that paces requests to LLM providers
+// with a SharedRateLimiter for each account, identified by the request's host and API key,
+// and that holds back all requests to an account when the provider rate limits one.
+// Because it sits below the services, it paces their retries too.
+type RateLimitTransport struct {
+	Base      http.RoundTripper // if nil, http.DefaultTransport is used
+	PerMinute int               // requests a minute to each account; if zero, requests are only held back after a rate limit
+}
+
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := SharedRateLimiter(accountKey(req), t.PerMinute)
+	if err := l.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		d := RetryAfter(resp.Header)
+		if d <= 0 {
+			d = defaultRateLimitPause
+		}
+		l.Pause(d)
+	}
+	return resp, err
+}
+
+// accountKey identifies the provider account that req is sent as: its host and a hash of its API key, if any.
+// Signatures, such as AWS's, change with every request and so do not identify an account; they are ignored.
+func accountKey(req *http.Request) string {
+	key := req.Header.Get("X-Api-Key")
+	if key == "" {
+		key = req.Header.Get("X-Goog-Api-Key")
+	}
+	if key == "" {
+		key, _ = strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if strings.Contains(key, " ") {
+			key = ""
+		}
+	}
+	if key == "" {
+		key = req.URL.Query().Get("key")
+	}
+	if key == "" {
+		return req.URL.Host
+	}
+	sum := sha256.Sum256([]byte(key))
+	return req.URL.Host + "/" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Now()
+	l := NewRateLimiter(60) // one a second, up to 60 at once
+	l.last = start
+	for i := range 60 {
+		if d := l.reserve(start); d != 0 {
+			t.Fatalf("request %d waits %v, want a burst of 60", i, d)
+		}
+	}
+	if d := l.reserve(start); d < 900*time.Millisecond || d > time.Second {
+		t.Errorf("request 61 waits %v, want about a second", d)
+	}
+	if d := l.reserve(start.Add(time.Second)); d != 0 {
+		t.Errorf("request after a second waits %v", d)
+	}
+
+	l.Pause(time.Hour)
+	if d := l.reserve(time.Now()); d < 59*time.Minute {
+		t.Errorf("request while paused waits %v, want about an hour", d)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); err == nil {
+		t.Error("Wait while paused returned before its context was canceled")
+	}
+
+	if d := NewRateLimiter(0).reserve(time.Now()); d != 0 {
+		t.Errorf("unlimited request waits %v", d)
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After-Ms", "300")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &RateLimitTransport{}}
+	send := func(key string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL, nil)
+		req.Header.Set("X-Api-Key", key)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	send("key-one")
+	start := time.Now()
+	send("key-one")
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("request after a rate limit was sent after %v, want the requested 300ms", d)
+	}
+	requests.Store(0)
+	send("key-two") // rate limited, but another account
+	start = time.Now()
+	send("key-one")
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("another account's rate limit held back a request for %v", d)
+	}
+}
+
+func TestAccountKey(t *testing.T) {
+	req := func(url string, header ...string) *http.Request {
+		r, _ := http.NewRequest("POST", url, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		return r
+	}
+	a := accountKey(req("https://api.anthropic.com/v1/messages", "X-Api-Key", "sk-ant-one"))
+	b := accountKey(req("https://api.anthropic.com/v1/messages", "X-Api-Key", "sk-ant-two"))
+	c := accountKey(req("https://api.openai.com/v1/chat/completions", "Authorization", "Bearer sk-ant-one"))
+	if a == b || a == c || b == c {
+		t.Errorf("accounts share keys: %q, %q, %q", a, b, c)
+	}
+	signed := accountKey(req("https://bedrock-runtime.us-east-1.amazonaws.com/model", "Authorization", "AWS4-HMAC-SHA256 Credential=AKID/20250601, Signature=abc"))
+	if signed != "bedrock-runtime.us-east-1.amazonaws.com" {
+		t.Errorf("signed request's account = %q, want the host", signed)
+	}
+}
@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+// IsRetryable reports whether a request that failed with err may succeed if sent again.
+// Server errors, rate limits, timeouts, stalled streams, and network failures are retryable.
+// Invalid requests, authentication failures, and canceled contexts are permanent.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var serr *StatusError
+	if errors.As(err, &serr) {
+		return serr.Retryable()
+	}
+	if errors.Is(err, ErrStalled) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr)
+}
+
+// Retryable reports whether the request may succeed if sent again:
+// the service timed out, rate limited us, or failed.
+func (e *StatusError) Retryable() bool {
+	switch {
+	case e.StatusCode == http.StatusRequestTimeout, e.StatusCode == http.StatusTooManyRequests:
+		return true
+	case e.StatusCode >= 500:
+		return true
+	}
+	return false
+}
+
+// A Backoff computes jittered, exponentially growing delays between retries.
+type Backoff struct {
+	Base time.Duration // the delay before the first retry
+	Max  time.Duration // the longest delay, before jitter
+}
+
+// Delay returns how long to wait before retry number attempt (starting at 1)
+// of a request that failed with err.
+// A delay requested by the service, as by a Retry-After header, takes precedence.
+// Otherwise the delay doubles with each attempt up to b.Max,
+// and a random half of it is shaved off so that clients that failed together do not retry together.
+func (b Backoff) Delay(attempt int, err error) time.Duration {
+	var serr *StatusError
+	if errors.As(err, &serr) && serr.RetryAfter > 0 {
+		return serr.RetryAfter
+	}
+	d := b.Base
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	d = min(d, b.Max)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// Sleep waits for d, or until ctx is done, in which case it returns ctx's error.
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
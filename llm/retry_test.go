@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{StatusCode: 529}, true},
+		{&StatusError{StatusCode: 429}, true},
+		{&StatusError{StatusCode: 408}, true},
+		{&StatusError{StatusCode: 400}, false},
+		{&StatusError{StatusCode: 401}, false},
+		{fmt.Errorf("reading: %w", ErrStalled), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{fmt.Errorf("do: %w", context.Canceled), false},
+		{errors.New("malformed request"), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Base: time.Second, Max: 10 * time.Second}
+	for attempt, want := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 6: 10 * time.Second} {
+		if attempt == 0 {
+			continue
+		}
+		for range 20 {
+			if got := b.Delay(attempt, errors.New("failed")); got < want/2 || got > want {
+				t.Errorf("Delay(%d) = %v, want in [%v, %v]", attempt, got, want/2, want)
+			}
+		}
+	}
+	err := fmt.Errorf("after retries: %w", &StatusError{StatusCode: 429, RetryAfter: 30 * time.Second})
+	if got := b.Delay(1, err); got != 30*time.Second {
+		t.Errorf("Delay with Retry-After = %v, want the requested 30s", got)
+	}
+}
+
+func TestSleepCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Sleep(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("Sleep(canceled) = %v, want context.Canceled", err)
+	}
+}
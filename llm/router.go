@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// A StatusError is an error response from an LLM API.
+type StatusError struct {
+	StatusCode int
+	Message    string // the response body or the API's error message
+	// RetryAfter is how long the service asked us to wait before retrying, or 0.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+// ShouldFallBack reports whether err is the kind of failure that another model might not have:
+// the service was overloaded, failed, or rate limited us, or its response stream stalled.
+// Requests that were rejected as invalid, and canceled requests, would fail anywhere.
+func ShouldFallBack(err error) bool {
+	if errors.Is(err, ErrStalled) {
+		return true
+	}
+	var serr *StatusError
+	return errors.As(err, &serr) && serr.Retryable()
+}
+
+type (
+	taskKey       struct{}
+	retryLimitKey struct{}
+)
+
+// WithTask returns a context for requests doing the named kind of work,
+// such as "install-tools", so that a Router can send them to a suitable model.
+func WithTask(ctx context.Context, task string) context.Context {
+	return context.WithValue(ctx, taskKey{}, task)
+}
+
+// TaskFromContext returns the task installed by WithTask, or "".
+func TaskFromContext(ctx context.Context) string {
+	task, _ := ctx.Value(taskKey{}).(string)
+	return task
+}
+
+// WithRetryLimit returns a context that asks services to retry failed requests at most n times.
+// A Router uses it so that it can fall back to another model quickly
+// instead of waiting out a long series of retries.
+func WithRetryLimit(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, retryLimitKey{}, n)
+}
+
+// RetryLimit returns the limit installed by WithRetryLimit if it is lower than def, and def otherwise.
+func RetryLimit(ctx context.Context, def int) int {
+	if n, ok := ctx.Value(retryLimitKey{}).(int); ok {
+		return min(n, def)
+	}
+	return def
+}
+
+// fallbackRetries is how many times a Router lets a service retry before falling back to the next one.
+const fallbackRetries = 2
+
+// A Router is a Service that sends each request to the first of a list of services that succeeds.
+// It falls back to the next service when one fails in a way that ShouldFallBack reports another might not.
+// Requests made with WithTask use the services routed for their task, if any.
+type Router struct {
+	// Services are tried in order; the first is the primary.
+	Services []Service
+	// Routes maps task names to the services to try for that task, in order.
+	// Tasks without a route use Services.
+	Routes map[string][]Service
+}
+
+var _ Service = (*Router)(nil)
+
+func (r *Router) services(ctx context.Context) []Service {
+	if route, ok := r.Routes[TaskFromContext(ctx)]; ok && len(route) > 0 {
+		return route
+	}
+	return r.Services
+}
+
+// Do sends req to each of r's services for ctx's task in turn, until one succeeds.
+func (r *Router) Do(ctx context.Context, req *Request) (*Response, error) {
+	services := r.services(ctx)
+	if len(services) == 0 {
+		return nil, errors.New("llm router has no services")
+	}
+	var errs error
+	for i, s := range services {
+		sctx := ctx
+		last := i == len(services)-1
+		if !last {
+			sctx = WithRetryLimit(ctx, fallbackRetries)
+		}
+		resp, err := s.Do(sctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		errs = errors.Join(errs, err)
+		if last || ctx.Err() != nil || !ShouldFallBack(err) {
+			break
+		}
+		slog.WarnContext(ctx, "llm_fallback", "task", TaskFromContext(ctx), "from", i, "to", i+1, "err", err)
+	}
+	return nil, errs
+}
+
+// TokenContextWindow returns the smallest context window of r's default services,
+// since any of them may have to handle a request.
+func (r *Router) TokenContextWindow() int {
+	return r.Capabilities().ContextWindow
+}
+
+// Capabilities returns the capabilities that all of r's default services share,
+// with the primary's pricing.
+// Routed tasks are typically small subagent requests, and are not considered.
+func (r *Router) Capabilities() Capabilities {
+	var caps Capabilities
+	for i, s := range r.Services {
+		c := CapabilitiesOf(s)
+		if i == 0 {
+			caps = c
+			continue
+		}
+		caps.ContextWindow = min(caps.ContextWindow, c.ContextWindow)
+		caps.MaxOutputTokens = min(caps.MaxOutputTokens, c.MaxOutputTokens)
+		caps.Tools = caps.Tools && c.Tools
+		caps.Vision = caps.Vision && c.Vision
+	}
+	return caps
+}
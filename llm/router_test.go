@@ -0,0 +1,95 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// fakeService returns err, or a response naming itself, and records its calls.
+type fakeService struct {
+	name       string
+	err        error
+	window     int
+	calls      int
+	retryLimit int
+}
+
+func (s *fakeService) Do(ctx context.Context, req *Request) (*Response, error) {
+	s.calls++
+	s.retryLimit = RetryLimit(ctx, 10)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &Response{Model: s.name}, nil
+}
+
+func (s *fakeService) TokenContextWindow() int { return s.window }
+
+func TestRouterFallBack(t *testing.T) {
+	overloaded := &fakeService{name: "primary", window: 200000, err: fmt.Errorf("after retries: %w", &StatusError{StatusCode: 529, Message: "overloaded"})}
+	alternate := &fakeService{name: "alternate", window: 100000}
+	r := &Router{Services: []Service{overloaded, alternate}}
+
+	resp, err := r.Do(context.Background(), &Request{})
+	if err != nil || resp.Model != "alternate" {
+		t.Fatalf("Do = %v, %v; want the alternate's response", resp, err)
+	}
+	if overloaded.retryLimit != fallbackRetries || alternate.retryLimit != 10 {
+		t.Errorf("retry limits = %d, %d; want %d for the primary and no limit for the last service", overloaded.retryLimit, alternate.retryLimit, fallbackRetries)
+	}
+	if got := r.TokenContextWindow(); got != 100000 {
+		t.Errorf("TokenContextWindow = %d, want the smallest", got)
+	}
+}
+
+func TestRouterNoFallBack(t *testing.T) {
+	invalid := &fakeService{name: "primary", err: &StatusError{StatusCode: 400, Message: "bad request"}}
+	alternate := &fakeService{name: "alternate"}
+	r := &Router{Services: []Service{invalid, alternate}}
+
+	_, err := r.Do(context.Background(), &Request{})
+	if serr := (*StatusError)(nil); !errors.As(err, &serr) || serr.StatusCode != 400 {
+		t.Errorf("Do err = %v, want the 400", err)
+	}
+	if alternate.calls != 0 {
+		t.Errorf("invalid request fell back to the alternate")
+	}
+}
+
+func TestRouterRoutes(t *testing.T) {
+	primary := &fakeService{name: "primary"}
+	cheap := &fakeService{name: "cheap"}
+	r := &Router{
+		Services: []Service{primary},
+		Routes:   map[string][]Service{"install-tools": {cheap}},
+	}
+
+	resp, err := r.Do(WithTask(context.Background(), "install-tools"), &Request{})
+	if err != nil || resp.Model != "cheap" {
+		t.Errorf("routed Do = %v, %v; want the cheap model", resp, err)
+	}
+	resp, err = r.Do(WithTask(context.Background(), "compaction"), &Request{})
+	if err != nil || resp.Model != "primary" {
+		t.Errorf("unrouted Do = %v, %v; want the primary model", resp, err)
+	}
+}
+
+func TestShouldFallBack(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{StatusCode: 429}, true},
+		{&StatusError{StatusCode: 503}, true},
+		{&StatusError{StatusCode: 401}, false},
+		{fmt.Errorf("read body: %w", ErrStalled), true},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := ShouldFallBack(tt.err); got != tt.want {
+			t.Errorf("ShouldFallBack(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	schema := MustSchema(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"count": {"type": "integer"},
+			"ratio": {"type": ["number", "null"]},
+			"color": {"enum": ["red", "green"]},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`)
+	tests := []struct {
+		data    string
+		wantErr string // substring; empty for valid
+	}{
+		{`{"name": "x"}`, ""},
+		{`{"name": "x", "count": 3, "ratio": null, "color": "red", "tags": ["a"]}`, ""},
+		{`{"name": "x", "ratio": 0.5}`, ""},
+		{`{}`, `missing required property "name"`},
+		{`{"name": 1}`, "$.name: got number, want string"},
+		{`{"name": "x", "count": 1.5}`, "$.count: got number, want integer"},
+		{`{"name": "x", "color": "blue"}`, `$.color: "blue" is not one of the allowed values`},
+		{`{"name": "x", "tags": ["a", 2]}`, "$.tags[1]: got number, want string"},
+		{`{"name": "x", "extra": true}`, `unexpected property "extra"`},
+		{`[]`, "$: got array, want object"},
+		{`{"name": "x"} {}`, "invalid JSON"},
+		{`{"name": `, "invalid JSON"},
+	}
+	for _, tt := range tests {
+		err := ValidateJSON(schema, []byte(tt.data))
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("ValidateJSON(%s) = %v, want nil", tt.data, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("ValidateJSON(%s) = %v, want error containing %q", tt.data, err, tt.wantErr)
+		}
+	}
+}
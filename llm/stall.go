@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultStallTimeout is how long a response body may go without producing any bytes
+// before StallTransport gives up on it.
+const DefaultStallTimeout = 2 * time.Minute
+
+// ErrStalled is the error reported when a response stream stops producing bytes.
+// Services retry requests that fail with ErrStalled.
+var ErrStalled = errors.New("llm response stream stalled")
+
+// StallTransport is an http.RoundTripper that fails a response
+// whose body goes Timeout without producing any bytes,
+// so that a provider stream that dies silently cannot hang a session forever.
+// Waiting for response headers is not limited:
+// non-streaming requests legitimately send no headers until generation is complete.
+type StallTransport struct {
+	Base    http.RoundTripper // if nil, http.DefaultTransport is used
+	Timeout time.Duration     // if zero, DefaultStallTimeout is used
+}
+
+func (t *StallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = DefaultStallTimeout
+	}
+	resp.Body = &stallBody{
+		ReadCloser: resp.Body,
+		ctx:        ctx,
+		cancel:     cancel,
+		timer: time.AfterFunc(timeout, func() {
+			cancel(fmt.Errorf("%w: no data for %v from %s", ErrStalled, timeout, req.URL.Host))
+		}),
+		timeout: timeout,
+	}
+	return resp, nil
+}
+
+// stallBody is a response body that cancels its request when reads stop making progress.
+type stallBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (b *stallBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && err != io.EOF {
+		if cause := context.Cause(b.ctx); errors.Is(cause, ErrStalled) {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+func (b *stallBody) Close() error {
+	b.timer.Stop()
+	b.cancel(nil)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStallTransport(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: hello\n\n"))
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/stall" {
+			<-release
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("data: bye\n\n"))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := &http.Client{Transport: &StallTransport{Timeout: 200 * time.Millisecond}}
+
+	resp, err := client.Get(srv.URL + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "data: hello\n\ndata: bye\n\n" {
+		t.Errorf("slow but live stream: got %q, %v", body, err)
+	}
+
+	resp, err = client.Get(srv.URL + "/stall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	if !errors.Is(err, ErrStalled) {
+		t.Errorf("stalled stream: got err %v, want ErrStalled", err)
+	}
+}
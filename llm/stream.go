@@ -0,0 +1,156 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// A PartialToolUse is a tool call whose input the model is still generating.
+type PartialToolUse struct {
+	ID       string
+	ToolName string
+	// Input is the input streamed so far, completed to valid JSON by CompletePartialJSON.
+	// It is nil until enough input has arrived to complete.
+	Input json.RawMessage
+}
+
+type (
+	partialToolUseKey struct{}
+	textDeltaKey      struct{}
+)
+
+// WithPartialToolUseFunc returns a context that asks services that stream responses
+// to call f each time more of a tool call's input arrives.
+// This lets callers render and vet large tool inputs before the model finishes its turn.
+// f is called synchronously from Service.Do, so it must be fast.
+func WithPartialToolUseFunc(ctx context.Context, f func(PartialToolUse)) context.Context {
+	return context.WithValue(ctx, partialToolUseKey{}, f)
+}
+
+// PartialToolUseFunc returns the function installed by WithPartialToolUseFunc, or nil.
+func PartialToolUseFunc(ctx context.Context) func(PartialToolUse) {
+	f, _ := ctx.Value(partialToolUseKey{}).(func(PartialToolUse))
+	return f
+}
+
+// WithTextDeltaFunc returns a context that asks services that stream responses
+// to call f with each piece of response text as it is generated.
+// f is called synchronously from Service.Do, so it must be fast.
+func WithTextDeltaFunc(ctx context.Context, f func(text string)) context.Context {
+	return context.WithValue(ctx, textDeltaKey{}, f)
+}
+
+// TextDeltaFunc returns the function installed by WithTextDeltaFunc, or nil.
+func TextDeltaFunc(ctx context.Context) func(text string) {
+	f, _ := ctx.Value(textDeltaKey{}).(func(string))
+	return f
+}
+
+// CompletePartialJSON turns a prefix of a JSON document into valid JSON,
+// by closing an unterminated string value and any open arrays and objects,
+// and dropping trailing object keys, literals, and escapes that cannot be completed.
+// It reports whether s was already complete.
+// It returns nil if no valid JSON can be made from s, as when s is empty or not JSON.
+func CompletePartialJSON(s string) (_ json.RawMessage, complete bool) {
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s), true
+	}
+
+	const (
+		inObjectKey = iota // inside an object, expecting a key
+		inObjectValue
+		inArray
+	)
+	var (
+		stack     []int
+		safe      = -1  // s[:safe] can be completed by closing the containers in safeStack
+		safeStack []int // copy of stack at safe
+		inString  bool  // inside a string
+		isKey     bool  // the current string is an object key
+		escStart  = -1  // start of an incomplete escape sequence in the current string
+		hexLeft   int   // hex digits still expected in a \u escape
+		markSafe  = func(i int) {
+			safe = i
+			safeStack = append(safeStack[:0], stack...)
+		}
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case hexLeft > 0:
+				hexLeft--
+				if hexLeft == 0 {
+					escStart = -1
+				}
+			case escStart >= 0:
+				if c == 'u' {
+					hexLeft = 4
+				} else {
+					escStart = -1
+				}
+			case c == '\\':
+				escStart = i
+			case c == '"':
+				inString = false
+				if !isKey {
+					markSafe(i + 1)
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+			isKey = len(stack) > 0 && stack[len(stack)-1] == inObjectKey
+		case '{':
+			stack = append(stack, inObjectKey)
+			markSafe(i + 1)
+		case '[':
+			stack = append(stack, inArray)
+			markSafe(i + 1)
+		case '}', ']':
+			if len(stack) == 0 {
+				return nil, false
+			}
+			stack = stack[:len(stack)-1]
+			markSafe(i + 1)
+		case ':':
+			if len(stack) > 0 {
+				stack[len(stack)-1] = inObjectValue
+			}
+		case ',':
+			// Whatever preceded the comma was a complete value.
+			markSafe(i)
+			if len(stack) > 0 && stack[len(stack)-1] == inObjectValue {
+				stack[len(stack)-1] = inObjectKey
+			}
+		}
+	}
+
+	var out []byte
+	if inString && !isKey {
+		end := len(s)
+		if escStart >= 0 {
+			end = escStart
+		}
+		out = append([]byte(s[:end]), '"')
+		safeStack = stack
+	} else {
+		if safe < 0 {
+			return nil, false
+		}
+		out = []byte(s[:safe])
+	}
+	for i := len(safeStack) - 1; i >= 0; i-- {
+		if safeStack[i] == inArray {
+			out = append(out, ']')
+		} else {
+			out = append(out, '}')
+		}
+	}
+	if !json.Valid(out) {
+		return nil, false
+	}
+	return out, false
+}
@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCompletePartialJSON(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     string
+		complete bool
+	}{
+		{in: "", want: ""},
+		{in: "{", want: "{}"},
+		{in: `{"comm`, want: "{}"},
+		{in: `{"command":`, want: "{}"},
+		{in: `{"command":"ls -`, want: `{"command":"ls -"}`},
+		{in: `{"command":"echo \`, want: `{"command":"echo "}`},
+		{in: `{"command":"echo \u00`, want: `{"command":"echo "}`},
+		{in: `{"command":"echo \"hi\"`, want: `{"command":"echo \"hi\""}`},
+		{in: `{"command":"ls","background":tr`, want: `{"command":"ls"}`},
+		{in: `{"command":"ls","background":true`, want: `{"command":"ls"}`},
+		{in: `{"patches":[{"old":"a","new":"b`, want: `{"patches":[{"old":"a","new":"b"}]}`},
+		{in: `{"a":[1,2`, want: `{"a":[1]}`},
+		{in: `{"a":{}`, want: `{"a":{}}`},
+		{in: `{"command":"ls"}`, want: `{"command":"ls"}`, complete: true},
+		{in: `}`, want: ""},
+	}
+	for _, tt := range tests {
+		got, complete := CompletePartialJSON(tt.in)
+		if string(got) != tt.want || complete != tt.complete {
+			t.Errorf("CompletePartialJSON(%q) = %s, %v; want %s, %v", tt.in, got, complete, tt.want, tt.complete)
+		}
+	}
+}
+
+func TestPartialToolUseFunc(t *testing.T) {
+	ctx := context.Background()
+	if f := PartialToolUseFunc(ctx); f != nil {
+		t.Fatal("PartialToolUseFunc of background context is non-nil")
+	}
+	var got PartialToolUse
+	ctx = WithPartialToolUseFunc(ctx, func(p PartialToolUse) { got = p })
+	PartialToolUseFunc(ctx)(PartialToolUse{ID: "1", ToolName: "bash"})
+	if got.ID != "1" || got.ToolName != "bash" {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,181 @@
+// Package tokencount estimates how many tokens text and LLM requests take up,
+// so that callers can check that a request fits a model's context window before sending it.
+//
+// The estimates come from the shape of the text rather than a provider's vocabulary,
+// and err on the high side: an overestimate costs a little context,
+// while an underestimate costs a failed request.
+// Where actual counts are available, as in the usage reported for the previous request,
+// callers should prefer them and estimate only what is new;
+// llm.CountTokens counts with a provider's own tokenizer, at the cost of a round trip.
+package tokencount
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"sketch.dev/llm"
+)
+
+// An Estimator estimates token counts for one family of models.
+type Estimator struct {
+	// CharsPerToken is the average number of letters in a token, within a word.
+	CharsPerToken float64
+	// DigitsPerToken is the number of digits the tokenizer groups into a token.
+	DigitsPerToken int
+	// ImageTokens is the cost of a typical image.
+	ImageTokens int
+}
+
+// Per-message and per-tool overheads, for the role markers and framing
+// that providers wrap around content.
+const (
+	messageOverhead = 4
+	contentOverhead = 3
+	toolOverhead    = 16
+)
+
+var (
+	// Claude's tokenizer splits words more finely than OpenAI's and Gemini's,
+	// and bills images by area; ImageTokens is a screenshot-sized image.
+	claude = &Estimator{CharsPerToken: 3.2, DigitsPerToken: 1, ImageTokens: 1600}
+	openai = &Estimator{CharsPerToken: 4, DigitsPerToken: 3, ImageTokens: 765}
+	gemini = &Estimator{CharsPerToken: 4, DigitsPerToken: 1, ImageTokens: 258}
+
+	// Default is used for models of unknown family.
+	// It is as conservative as the most conservative family.
+	Default = &Estimator{CharsPerToken: 3.2, DigitsPerToken: 1, ImageTokens: 1600}
+)
+
+// For returns the estimator for model's tokenizer family, or Default.
+func For(model string) *Estimator {
+	model = strings.TrimPrefix(model, "models/") // Gemini model resource names
+	switch {
+	case strings.HasPrefix(model, "claude"):
+		return claude
+	case strings.HasPrefix(model, "gpt"), strings.HasPrefix(model, "o1"), strings.HasPrefix(model, "o3"), strings.HasPrefix(model, "o4"):
+		return openai
+	case strings.HasPrefix(model, "gemini"):
+		return gemini
+	}
+	return Default
+}
+
+// Text estimates the number of tokens in s.
+func (e *Estimator) Text(s string) int {
+	var n float64
+	for i := 0; i < len(s); {
+		c := s[i]
+		j := i + 1
+		switch {
+		case isLetter(c):
+			for j < len(s) && isLetter(s[j]) {
+				j++
+			}
+			n += math.Ceil(float64(j-i) / e.CharsPerToken)
+		case isDigit(c):
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			n += math.Ceil(float64(j-i) / float64(max(e.DigitsPerToken, 1)))
+		case isSpace(c):
+			for j < len(s) && isSpace(s[j]) {
+				j++
+			}
+			if s[i:j] != " " { // a single space joins the word that follows it
+				n += math.Ceil(float64(j-i) / 4)
+			}
+		case c < utf8.RuneSelf:
+			// Punctuation and symbols, which merge in pairs often enough, as in `",` and `()`.
+			for j < len(s) && s[j] < utf8.RuneSelf && !isLetter(s[j]) && !isDigit(s[j]) && !isSpace(s[j]) {
+				j++
+			}
+			n += math.Ceil(float64(j-i) / 2)
+		default:
+			// Outside ASCII, count every character; CJK text takes about a token each.
+			_, size := utf8.DecodeRuneInString(s[i:])
+			j = i + size
+			n++
+		}
+		i = j
+	}
+	return int(n)
+}
+
+func isLetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' }
+func isDigit(c byte) bool  { return '0' <= c && c <= '9' }
+func isSpace(c byte) bool  { return c == ' ' || c == '\t' || c == '\n' || c == '\r' }
+
+// Content estimates the number of tokens in c, including any nested tool results.
+func (e *Estimator) Content(c llm.Content) int {
+	n := contentOverhead + e.Text(c.Text) + e.Text(c.Thinking) + e.Text(c.ToolName) + e.Text(string(c.ToolInput))
+	if c.MediaType != "" {
+		n += e.ImageTokens
+	}
+	for _, r := range c.ToolResult {
+		n += e.Content(r)
+	}
+	return n
+}
+
+// Message estimates the number of tokens in m.
+func (e *Estimator) Message(m llm.Message) int {
+	n := messageOverhead
+	for _, c := range m.Content {
+		n += e.Content(c)
+	}
+	return n
+}
+
+// Request estimates the number of input tokens of r:
+// its system prompt, tool definitions, and messages.
+func (e *Estimator) Request(r *llm.Request) int {
+	var n int
+	for _, s := range r.System {
+		n += e.Text(s.Text)
+	}
+	for _, t := range r.Tools {
+		n += toolOverhead + e.Text(t.Name) + e.Text(t.Description) + e.Text(string(t.InputSchema))
+	}
+	for _, m := range r.Messages {
+		n += e.Message(m)
+	}
+	return n
+}
+
+// Truncate shortens s to about maxTokens tokens, if it is longer,
+// by cutting out its middle and noting how much was cut.
+// The start and end of command output usually matter most:
+// what ran, and how it ended.
+func (e *Estimator) Truncate(s string, maxTokens int) string {
+	n := e.Text(s)
+	if n <= maxTokens {
+		return s
+	}
+	note := func(omitted int) string {
+		return "\n\n[... about " + strconv.Itoa(omitted) + " tokens omitted to fit the context window ...]\n\n"
+	}
+	keepTokens := max(maxTokens-e.Text(note(n)), 0)
+	keep := int(float64(len(s)) * float64(keepTokens) / float64(n))
+	for {
+		// Text is not spread evenly, and the cuts can split words,
+		// so shrink the kept text until the result fits.
+		head := runeStart(s, keep/2)
+		tail := runeStart(s, len(s)-keep/2)
+		out := s[:head] + note(n-keepTokens) + s[tail:]
+		if keep == 0 || e.Text(out) <= maxTokens {
+			return out
+		}
+		keep = keep * 9 / 10
+	}
+}
+
+// runeStart returns the start of the rune containing byte offset i of s.
+func runeStart(s string, i int) int {
+	i = min(max(i, 0), len(s))
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,61 @@
+package tokencount
+
+import (
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		text     string
+		min, max int
+	}{
+		{"", 0, 0},
+		{"hello", 1, 2},
+		{"The quick brown fox jumps over the lazy dog.", 9, 16},
+		{"func main() {\n\tfmt.Println(\"hi\")\n}\n", 10, 24},
+		{"12345678", 3, 8},
+		{"日本語のテキスト", 8, 8},
+	}
+	for _, tt := range tests {
+		if got := claude.Text(tt.text); got < tt.min || got > tt.max {
+			t.Errorf("Text(%q) = %d, want in [%d, %d]", tt.text, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestFor(t *testing.T) {
+	if For("claude-sonnet-4-20250514") != claude || For("gpt-4.1") != openai || For("models/gemini-2.5-pro") != gemini {
+		t.Errorf("For picked the wrong family")
+	}
+	if For("some-local-model") != Default {
+		t.Errorf("For(unknown) is not Default")
+	}
+}
+
+func TestRequest(t *testing.T) {
+	req := &llm.Request{
+		System:   []llm.SystemContent{{Text: "You are a helpful assistant."}},
+		Tools:    []*llm.Tool{{Name: "bash", Description: "Runs a command.", InputSchema: llm.MustSchema(`{"type":"object"}`)}},
+		Messages: []llm.Message{llm.UserStringMessage("hello"), {Role: llm.MessageRoleUser, Content: []llm.Content{{MediaType: "image/png", Data: "AAAA"}}}},
+	}
+	if got := claude.Request(req); got < claude.ImageTokens || got > claude.ImageTokens+100 {
+		t.Errorf("Request = %d, want an image and a little text", got)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	s := "first line\n" + strings.Repeat("lorem ipsum dolor sit amet ", 1000) + "\nlast line"
+	got := claude.Truncate(s, 200)
+	if n := claude.Text(got); n > 200 {
+		t.Errorf("Truncate(s, 200) has %d tokens", n)
+	}
+	if !strings.HasPrefix(got, "first line") || !strings.HasSuffix(got, "last line") || !strings.Contains(got, "tokens omitted") {
+		t.Errorf("Truncate(s, 200) = %q, want the start, a note, and the end", got)
+	}
+	if got := claude.Truncate("short", 200); got != "short" {
+		t.Errorf("Truncate(short) = %q", got)
+	}
+}
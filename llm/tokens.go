@@ -0,0 +1,43 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+)
+
+// A TokenCounter counts the input tokens of requests with a model provider's own tokenizer,
+// such as Anthropic's token counting endpoint.
+type TokenCounter interface {
+	// CountTokens returns the number of input tokens that sending req would use.
+	CountTokens(ctx context.Context, req *Request) (int, error)
+}
+
+// TokenCounterOf returns s as a TokenCounter, if it is one.
+// For a Router, it returns the primary service's counter.
+func TokenCounterOf(s Service) (TokenCounter, bool) {
+	if r, ok := s.(*Router); ok {
+		if len(r.Services) == 0 {
+			return nil, false
+		}
+		s = r.Services[0]
+	}
+	tc, ok := s.(TokenCounter)
+	return tc, ok
+}
+
+// CountTokens returns the number of input tokens that sending req to s would use,
+// as counted by s, if it is a TokenCounter, and otherwise, or if counting fails, by estimate.
+// Counting with the provider costs a round trip, so callers should estimate locally first,
+// and count only when the estimate is too close to a limit to decide on.
+func CountTokens(ctx context.Context, s Service, req *Request, estimate func(*Request) int) int {
+	tc, ok := TokenCounterOf(s)
+	if !ok {
+		return estimate(req)
+	}
+	n, err := tc.CountTokens(ctx, req)
+	if err != nil {
+		slog.WarnContext(ctx, "llm_count_tokens_failed", "error", err)
+		return estimate(req)
+	}
+	return n
+}
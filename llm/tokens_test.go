@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type countingService struct {
+	fakeService
+	n   int
+	err error
+}
+
+func (s *countingService) CountTokens(ctx context.Context, req *Request) (int, error) {
+	return s.n, s.err
+}
+
+func TestCountTokens(t *testing.T) {
+	estimate := func(*Request) int { return 100 }
+	req := &Request{Messages: []Message{UserStringMessage("hello")}}
+	tests := []struct {
+		name string
+		s    Service
+		want int
+	}{
+		{"counter", &countingService{n: 42}, 42},
+		{"routed counter", &Router{Services: []Service{&countingService{n: 42}}}, 42},
+		{"failed counter", &countingService{err: errors.New("overloaded")}, 100},
+		{"no counter", &fakeService{}, 100},
+	}
+	for _, tt := range tests {
+		if got := CountTokens(context.Background(), tt.s, req, estimate); got != tt.want {
+			t.Errorf("%s: CountTokens = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
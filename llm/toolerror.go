@@ -0,0 +1,78 @@
+package llm
+
+import (
+	"context"
+	"errors"
+)
+
+// A ToolErrorKind classifies why a tool call failed,
+// so that the conversation loop, UIs, and retry policies can treat failures differently
+// without matching their text.
+// The zero kind is an ordinary failure: the tool ran and reported a problem with what it was asked to do.
+type ToolErrorKind string
+
+const (
+	ToolErrorUserDenied     ToolErrorKind = "user_denied"      // the user denied permission for the call, or interrupted it
+	ToolErrorTimeout        ToolErrorKind = "timeout"          // the call ran out of time
+	ToolErrorOutputTooLarge ToolErrorKind = "output_too_large" // the call produced more output than can be returned
+	ToolErrorCrashed        ToolErrorKind = "tool_crashed"     // the tool itself failed, as by panicking
+	ToolErrorBudgetExceeded ToolErrorKind = "budget_exceeded"  // the call would exceed a budget, of money, tokens, or uses
+	ToolErrorProvider       ToolErrorKind = "provider_error"   // a service the tool depends on failed
+)
+
+// Retryable reports whether a call that failed with an error of kind k may succeed if made again, unchanged.
+// Providers fail transiently; every other kind would fail the same way again,
+// or, like a timeout, may have had effects that a retry would repeat.
+func (k ToolErrorKind) Retryable() bool {
+	return k == ToolErrorProvider
+}
+
+// A ToolError is an error from a tool, of a known kind.
+type ToolError struct {
+	Kind ToolErrorKind
+	Err  error
+}
+
+func (e *ToolError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+// ToolErrorKind implements the interface that ToolErrorKindOf looks for.
+func (e *ToolError) ToolErrorKind() ToolErrorKind {
+	return e.Kind
+}
+
+// NewToolError returns err classified as kind, or nil if err is nil.
+// Its text is err's.
+func NewToolError(kind ToolErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ToolError{Kind: kind, Err: err}
+}
+
+// ToolErrorKindOf returns the kind of a tool's error err.
+// The kind is that of the first error in err's tree with a ToolErrorKind method,
+// such as a *ToolError or a *StatusError;
+// failing that, an exceeded deadline is a timeout, and any other error is an ordinary failure.
+func ToolErrorKindOf(err error) ToolErrorKind {
+	var kerr interface{ ToolErrorKind() ToolErrorKind }
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &kerr):
+		return kerr.ToolErrorKind()
+	case errors.Is(err, context.DeadlineExceeded):
+		return ToolErrorTimeout
+	}
+	return ""
+}
+
+// ToolErrorKind classifies a service's error status as a provider error when it fails a tool call.
+func (e *StatusError) ToolErrorKind() ToolErrorKind {
+	return ToolErrorProvider
+}
@@ -0,0 +1,44 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestToolErrorKindOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ToolErrorKind
+	}{
+		{nil, ""},
+		{errors.New("no such file"), ""},
+		{NewToolError(ToolErrorUserDenied, errors.New("denied")), ToolErrorUserDenied},
+		{fmt.Errorf("deploy: %w", NewToolError(ToolErrorBudgetExceeded, errors.New("out of searches"))), ToolErrorBudgetExceeded},
+		{fmt.Errorf("search: %w", &StatusError{StatusCode: 503}), ToolErrorProvider},
+		{fmt.Errorf("go doc: %w", context.DeadlineExceeded), ToolErrorTimeout},
+		// The outermost classification wins.
+		{NewToolError(ToolErrorTimeout, fmt.Errorf("killed: %w", NewToolError(ToolErrorOutputTooLarge, errors.New("too long")))), ToolErrorTimeout},
+		{NewToolError(ToolErrorCrashed, context.DeadlineExceeded), ToolErrorCrashed},
+	}
+	for _, tt := range tests {
+		if got := ToolErrorKindOf(tt.err); got != tt.want {
+			t.Errorf("ToolErrorKindOf(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNewToolError(t *testing.T) {
+	if err := NewToolError(ToolErrorTimeout, nil); err != nil {
+		t.Errorf("NewToolError(kind, nil) = %v, want nil", err)
+	}
+	inner := errors.New("connection reset")
+	err := NewToolError(ToolErrorProvider, inner)
+	if err.Error() != "connection reset" || !errors.Is(err, inner) {
+		t.Errorf("NewToolError = %v, want it to read and unwrap as the error it classifies", err)
+	}
+	if !ToolErrorProvider.Retryable() || ToolErrorTimeout.Retryable() || ToolErrorKind("").Retryable() {
+		t.Errorf("only provider errors should be retryable")
+	}
+}
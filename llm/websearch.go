@@ -0,0 +1,30 @@
+package llm
+
+import "context"
+
+// A WebSearcher searches the web using a model provider's own search,
+// such as Anthropic's server-side web search tool.
+type WebSearcher interface {
+	// WebSearch returns up to maxResults results for query, most relevant first.
+	WebSearch(ctx context.Context, query string, maxResults int) ([]WebSearchResult, error)
+}
+
+// A WebSearchResult is a single web search result.
+type WebSearchResult struct {
+	Title   string
+	URL     string
+	Snippet string
+}
+
+// WebSearcherOf returns s as a WebSearcher, if it is one.
+// For a Router, it returns the primary service's searcher.
+func WebSearcherOf(s Service) (WebSearcher, bool) {
+	if r, ok := s.(*Router); ok {
+		if len(r.Services) == 0 {
+			return nil, false
+		}
+		s = r.Services[0]
+	}
+	ws, ok := s.(WebSearcher)
+	return ws, ok
+}
@@ -1,35 +1,63 @@
 package loop
 
 import (
+	"cmp"
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime/debug"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"sketch.dev/browser"
 	"sketch.dev/claudetool"
 	"sketch.dev/claudetool/bashkit"
 	"sketch.dev/claudetool/browse"
+	"sketch.dev/claudetool/codeindex"
 	"sketch.dev/claudetool/codereview"
+	"sketch.dev/claudetool/deps"
+	"sketch.dev/claudetool/forge"
 	"sketch.dev/claudetool/onstart"
+	"sketch.dev/claudetool/overview"
+	"sketch.dev/claudetool/review"
+	"sketch.dev/claudetool/skills"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/claudetool/symbols"
+	"sketch.dev/claudetool/undo"
+	"sketch.dev/claudetool/websearch"
+	"sketch.dev/credentials"
+	"sketch.dev/experiment"
 	"sketch.dev/llm"
 	"sketch.dev/llm/ant"
 	"sketch.dev/llm/conversation"
+	"sketch.dev/llm/llmcache"
 	"sketch.dev/mcp"
+	"sketch.dev/notify"
+	"sketch.dev/projectconfig"
+	"sketch.dev/sessionlog"
+	"sketch.dev/sessionstore"
 	"sketch.dev/skabandclient"
+	"sketch.dev/statedb"
+	"sketch.dev/toolplugin"
 	"tailscale.com/portlist"
 )
 
@@ -37,6 +65,12 @@ const (
 	userCancelMessage = "user requested agent to stop handling responses"
 )
 
+// errTurnInterrupted is the cause of a turn canceled by InterruptTurn.
+var errTurnInterrupted = errors.New("user interrupted the turn with a new instruction")
+
+// tracer records a span for each turn.
+var tracer = otel.Tracer("sketch.dev/loop")
+
 type MessageIterator interface {
 	// Next blocks until the next message is available. It may
 	// return nil if the underlying iterator context is done.
@@ -75,6 +109,10 @@ type CodingAgent interface {
 
 	CancelTurn(cause error)
 
+	// InterruptTurn stops the current turn, whether the model is responding or tools are running,
+	// and starts a new one with msg.
+	InterruptTurn(ctx context.Context, msg string)
+
 	CancelToolUse(toolUseID string, cause error) error
 
 	// Returns a subset of the agent's message history.
@@ -128,6 +166,15 @@ type CodingAgent interface {
 
 	// OutstandingToolCalls returns the names of outstanding tool calls.
 	OutstandingToolCalls() []string
+	// StreamingResponse returns the part of the model's current response generated so far.
+	StreamingResponse() StreamingResponse
+	// SubscribeStreamingResponse returns a channel that receives a value whenever the streaming response changes,
+	// until unsubscribe is called. Updates are coalesced: a slow reader sees only the latest state.
+	SubscribeStreamingResponse() (updates <-chan struct{}, unsubscribe func())
+	// SubscribeEvents returns a channel that receives the session's events from now on, until unsubscribe is called.
+	// A subscriber that falls too far behind is dropped: its channel is closed,
+	// and it should subscribe again and catch up on messages with Messages.
+	SubscribeEvents() (events <-chan Event, unsubscribe func())
 	OutsideOS() string
 	OutsideHostname() string
 	OutsideWorkingDir() string
@@ -159,6 +206,51 @@ type CodingAgent interface {
 
 	// GetPorts returns the cached list of open TCP ports
 	GetPorts() []portlist.Port
+
+	// PendingChanges returns the file modifications awaiting user approval.
+	PendingChanges() []staging.Change
+	// ApproveChange writes staged hunks of path to disk (all hunks if hunks is nil, all files if path is empty).
+	ApproveChange(path string, hunks []int) error
+	// RejectChange discards staged hunks of path (all hunks if hunks is nil, all files if path is empty).
+	RejectChange(path string, hunks []int) error
+	// ReviewChange accepts and rejects hunks of the staged change to path, and tells the model what the user decided.
+	ReviewChange(path string, decisions []staging.Decision) error
+	// StagedContents returns the contents of path with its staged changes, for the user to edit.
+	StagedContents(path string) ([]byte, error)
+	// EditChange writes data, the user's edit of the staged change to path, to disk in place of the change.
+	EditChange(path string, data []byte) error
+	// AutoApproveChanges writes all staged changes to disk, and later changes as they are made, for the rest of the session.
+	AutoApproveChanges() error
+
+	// FileWrites returns the agent's file writes that can be undone, oldest first.
+	FileWrites() []undo.Entry
+	// UndoFileWrites undoes the agent's most recent file write, or all of them, and returns what it undid.
+	UndoFileWrites(all bool) ([]undo.Entry, error)
+
+	// PendingPermissions returns tools' requests to take actions, awaiting the user's answer.
+	PendingPermissions() []PermissionRequest
+	// AnswerPermission allows or denies the permission request with the given ID (the oldest if id is empty).
+	AnswerPermission(id string, allow bool) error
+	// PermissionRules returns the project's rules for tool calls, in the order they are tried.
+	PermissionRules() []projectconfig.Rule
+
+	// BackgroundJobs returns the background jobs the bash tool started, in the order they started.
+	BackgroundJobs() []claudetool.JobInfo
+	// StopBackgroundJob stops the background job with the given ID, and tells the model that the user did.
+	StopBackgroundJob(ctx context.Context, id int) error
+
+	// Attach pins files, directories, globs, and URLs into the conversation as context; see conversation.Convo.Attach.
+	Attach(ctx context.Context, sources ...string) ([]conversation.Attachment, error)
+	// Detach unpins the attachments with the given names, or all of them, and returns how many it unpinned.
+	Detach(names ...string) int
+	// Attachments returns the conversation's attachments.
+	Attachments() []conversation.Attachment
+
+	// Skills returns the project's skills, along with the errors for those that could not be loaded.
+	Skills() ([]*skills.Skill, error)
+
+	// PluginCommands returns the slash commands loaded from plugins, for the UIs to offer.
+	PluginCommands() []*toolplugin.Command
 }
 
 type CodingAgentMessageType string
@@ -175,10 +267,14 @@ const (
 	PortMessageType    CodingAgentMessageType = "port"    // for port monitoring events
 
 	cancelToolUseMessage = "Stop responding to my previous message. Wait for me to ask you something else before attempting to use any more tools."
+
+	interruptToolUseMessage = "I interrupted you and canceled the tools above. Drop what you were doing and follow my next instruction instead."
 )
 
 type AgentMessage struct {
-	Type CodingAgentMessageType `json:"type"`
+	// SchemaVersion is the claudetool.SchemaVersion the message follows.
+	SchemaVersion int                    `json:"schema_version"`
+	Type          CodingAgentMessageType `json:"type"`
 	// EndOfTurn indicates that the AI is done working and is ready for the next user input.
 	EndOfTurn bool `json:"end_of_turn"`
 
@@ -187,7 +283,11 @@ type AgentMessage struct {
 	ToolInput  string `json:"input,omitempty"`
 	ToolResult string `json:"tool_result,omitempty"`
 	ToolError  bool   `json:"tool_error,omitempty"`
-	ToolCallId string `json:"tool_call_id,omitempty"`
+	// ToolErrorKind classifies a failed tool call, so that UIs can tell, say, a denial from a crash.
+	ToolErrorKind llm.ToolErrorKind `json:"tool_error_kind,omitempty"`
+	ToolCallId    string            `json:"tool_call_id,omitempty"`
+	// CommandTags classifies the command run by a bash tool call (build, test, network, etc.)
+	CommandTags []bashkit.Category `json:"command_tags,omitempty"`
 
 	// ToolCalls is a list of all tool calls requested in this message (name and input pairs)
 	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
@@ -251,6 +351,8 @@ type ToolCall struct {
 	ResultMessage *AgentMessage `json:"result_message,omitempty"`
 	Args          string        `json:"args,omitempty"`
 	Result        string        `json:"result,omitempty"`
+	// CommandTags classifies the command run by a bash tool call (build, test, network, etc.)
+	CommandTags []bashkit.Category `json:"command_tags,omitempty"`
 }
 
 func (a *AgentMessage) Attr() slog.Attr {
@@ -282,6 +384,9 @@ func (a *AgentMessage) Attr() slog.Attr {
 	if a.ToolError {
 		attrs = append(attrs, slog.Bool("tool_error", a.ToolError))
 	}
+	if a.ToolErrorKind != "" {
+		attrs = append(attrs, slog.String("tool_error_kind", string(a.ToolErrorKind)))
+	}
 	if len(a.ToolCalls) > 0 {
 		toolCallAttrs := make([]any, 0, len(a.ToolCalls))
 		for i, tc := range a.ToolCalls {
@@ -321,17 +426,28 @@ func budgetMessage(err error) AgentMessage {
 
 // ConvoInterface defines the interface for conversation interactions
 type ConvoInterface interface {
-	CumulativeUsage() conversation.CumulativeUsage
+	Usage() conversation.CumulativeUsage
 	LastUsage() llm.Usage
 	ResetBudget(conversation.Budget)
+	RestartWallTime(time.Duration)
 	OverBudget() error
+	WrapUp(contents ...llm.Content) (*llm.Response, error)
 	SendMessage(message llm.Message) (*llm.Response, error)
+	SendMessageContext(ctx context.Context, message llm.Message) (*llm.Response, error)
 	SendUserTextMessage(s string, otherContents ...llm.Content) (*llm.Response, error)
 	GetID() string
 	ToolResultContents(ctx context.Context, resp *llm.Response) ([]llm.Content, bool, error)
 	ToolResultCancelContents(resp *llm.Response) ([]llm.Content, error)
 	CancelToolUse(toolUseID string, cause error) error
 	SubConvoWithHistory() *conversation.Convo
+	QueueUserMessage(text string)
+	TakeQueuedUserMessages() []string
+	Snapshot() ([]byte, error)
+	Attach(ctx context.Context, dir string, sources ...string) ([]conversation.Attachment, error)
+	AddAttachments(atts ...conversation.Attachment) error
+	Detach(names ...string) int
+	Attachments() []conversation.Attachment
+	Compact() error
 }
 
 // AgentGitState holds the state necessary for pushing to a remote git repo
@@ -438,8 +554,25 @@ type Agent struct {
 	// Port monitor for tracking TCP ports
 	portMonitor *PortMonitor
 
+	// files watches the repository for files changed outside the agent; nil if it cannot.
+	files *fileWatcher
+	// Staged file modifications awaiting approval (nil unless ApproveWrites is set)
+	stage *staging.Area
+	// journal records the agent's file writes, so that they can be undone
+	journal *undo.Journal
+	// webSearch is the web search tool, nil without a provider; it keeps the session's search budget across conversations
+	webSearch *websearch.Tool
+	// index is the repository's semantic code index, nil without an embedder
+	index *codeindex.Index
+	// overview summarizes the repository's packages in the background, nil unless a cheap model is routed for it
+	overview *overview.Overview
+	// bash is the bash tool of the current conversation, which tracks its background jobs
+	bash atomic.Pointer[claudetool.BashTool]
+
 	// Time when the current turn started (reset at the beginning of InnerLoop)
 	startOfTurn time.Time
+	// autoCommitted is when autoCommit last committed the agent's file writes; later writes are yet to be committed.
+	autoCommitted time.Time
 
 	// Inbox - for messages from the user to the agent.
 	// sent on by UserMessage
@@ -447,10 +580,16 @@ type Agent struct {
 	// read from by GatherMessages
 	inbox chan string
 
-	// protects cancelTurn
+	// protects cancelTurn and turnActive
 	cancelTurnMu sync.Mutex
 	// cancels potentially long-running tool_use calls or chains of them
 	cancelTurn context.CancelCauseFunc
+	// turnActive reports whether the current turn has taken the user's messages and is working on them
+	turnActive bool
+	// interruptedResults are the results of tools canceled by InterruptTurn,
+	// to be sent along with the user's new instruction.
+	// Only the Loop goroutine uses them.
+	interruptedResults []llm.Content
 
 	// protects following
 	mu sync.Mutex
@@ -466,6 +605,33 @@ type Agent struct {
 
 	// Track outstanding tool calls by ID with their names
 	outstandingToolCalls map[string]string
+
+	// The response the model is generating, while it streams, and who to tell when it changes
+	streaming            StreamingResponse
+	streamingSubscribers []chan struct{}
+
+	// The staged changes last announced with EventPermissionRequested, as a diff
+	requestedChanges string
+
+	events      eventBus
+	permissions permissionGate
+}
+
+// A StreamingResponse is the part of the model's current response that has been generated so far.
+// It is empty when no response is streaming.
+type StreamingResponse struct {
+	Text      string              `json:"text,omitempty"`
+	ToolCalls []StreamingToolCall `json:"tool_calls,omitempty"`
+}
+
+// A StreamingToolCall is a tool call that the model is still generating.
+type StreamingToolCall struct {
+	ID       string `json:"id"`
+	ToolName string `json:"tool_name"`
+	// Command is the bash command streamed so far, if the tool is bash.
+	Command string `json:"command,omitempty"`
+	// PermissionError is set if the command streamed so far would be refused when run.
+	PermissionError string `json:"permission_error,omitempty"`
 }
 
 // NewIterator implements CodingAgent.
@@ -564,99 +730,34 @@ func (a *Agent) CurrentTodoContent() string {
 	return string(content)
 }
 
-// generateConversationSummary asks the LLM to create a comprehensive summary of the current conversation
-func (a *Agent) generateConversationSummary(ctx context.Context) (string, error) {
-	msg := `You are being asked to create a comprehensive summary of our conversation so far. This summary will be used to restart our conversation with a shorter history while preserving all important context.
-
-IMPORTANT: Focus ONLY on the actual conversation with the user. Do NOT include any information from system prompts, tool descriptions, or general instructions. Only summarize what the user asked for and what we accomplished together.
-
-Please create a detailed summary that includes:
-
-1. **User's Request**: What did the user originally ask me to do? What was their goal?
-
-2. **Work Completed**: What have we accomplished together? Include any code changes, files created/modified, problems solved, etc.
-
-3. **Key Security  FIndings**: What important technical findings were made during our work and why?
-
-4. **Current State**: What is the current state of the project? What files, tools, or systems are we working with?
-
-5. **Next Steps**: What still needs to be done to complete the user's request?
-
-6. **Important Context**: Any crucial information about the user's codebase, environment, constraints, or specific preferences they mentioned.
-
-Focus on actionable information that would help me continue the user's work seamlessly. Ignore any general tool capabilities or system instructions - only include what's relevant to this specific user's project and goals.
-
-Reply with ONLY the summary content - no meta-commentary about creating the summary.`
-
-	userMessage := llm.UserStringMessage(msg)
-	// Use a subconversation with history to get the summary
-	// TODO: We don't have any tools here, so we should have enough tokens
-	// to capture a summary, but we may need to modify the history (e.g., remove
-	// TODO data) to save on some tokens.
-	convo := a.convo.SubConvoWithHistory()
-
-	// Modify the system prompt to provide context about the original task
-	originalSystemPrompt := convo.SystemPrompt
-	convo.SystemPrompt = `You are creating a conversation summary for context compaction. The original system prompt contained instructions about being a software engineer and architect for Sketch (an agentic coding environment and also a cybersecurity assistant.), with various tools and capabilities for code analysis, file modification, git operations, browser automation, and project management.
-
-Your task is to create a focused summary as requested below. Focus only on the actual user conversation and work accomplished, not the system capabilities or tool descriptions.
-
-Original context: You are working in a coding  environment with full access to development tools to build and iterate on sketch but also a pentesting environment in kali to build, test and extend sketch's capabilities.`
-
-	resp, err := convo.SendMessage(userMessage)
-	if err != nil {
-		a.pushToOutbox(ctx, errorMessage(err))
-		return "", err
-	}
-	textContent := collectTextContent(resp)
-
-	// Restore original system prompt (though this subconvo will be discarded)
-	convo.SystemPrompt = originalSystemPrompt
-
-	return textContent, nil
-}
-
-// CompactConversation compacts the current conversation by generating a summary
-// and restarting the conversation with that summary as the initial context
+// CompactConversation replaces the older history of the conversation with a summary,
+// keeping the most recent messages and the tool results that the model pinned,
+// as happens by itself when the conversation nears the context window.
 func (a *Agent) CompactConversation(ctx context.Context) error {
-	summary, err := a.generateConversationSummary(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to generate conversation summary: %w", err)
-	}
-
 	a.mu.Lock()
-
-	// Get usage information before resetting conversation
-	lastUsage := a.convo.LastUsage()
-	contextWindow := a.config.Service.TokenContextWindow()
-	currentContextSize := lastUsage.InputTokens + lastUsage.CacheReadInputTokens + lastUsage.CacheCreationInputTokens
-
-	// Preserve cumulative usage across compaction
-	cumulativeUsage := a.convo.CumulativeUsage()
-
-	// Reset conversation state but keep all other state (git, working dir, etc.)
-	a.firstMessageIndex = len(a.history)
-	a.convo = a.initConvoWithUsage(&cumulativeUsage)
-
+	convo := a.convo
 	a.mu.Unlock()
+	return convo.Compact()
+}
 
-	// Create informative compaction message with token details
-	compactionMsg := fmt.Sprintf("📜 Conversation compacted to manage token limits. Previous context preserved in summary below.\n\n"+
-		"**Token Usage:** %d / %d tokens (%.1f%% of context window)",
-		currentContextSize, contextWindow, float64(currentContextSize)/float64(contextWindow)*100)
-
-	a.pushToOutbox(ctx, AgentMessage{
+// compacted tells the user that the conversation was compacted: summary replaced its dropped older messages.
+func (a *Agent) compacted(summary string, dropped int) {
+	a.pushToOutbox(a.config.Context, AgentMessage{
 		Type:    CompactMessageType,
-		Content: compactionMsg,
-	})
-
-	a.pushToOutbox(ctx, AgentMessage{
-		Type:    UserMessageType,
-		Content: fmt.Sprintf("Here's a summary of our previous work:\n\n%s\n\nPlease continue with the work based on this summary.", summary),
+		Content: fmt.Sprintf("📜 Conversation compacted to fit the context window: a summary replaced %d older messages.\n\n%s", dropped, summary),
 	})
-	a.inbox <- fmt.Sprintf("Here's a summary of our previous work:\n\n%s\n\nPlease continue with the work based on this summary.", summary)
+}
 
-	return nil
+// compactThreshold returns the fraction of the context window at which the conversation is compacted:
+// SKETCH_COMPACT_THRESHOLD_RATIO, if set, or 0.94.
+// (Because default Claude output is 8192 tokens, which is 4% of 200,000 tokens, and a little bit of buffer.)
+func compactThreshold() float64 {
+	if env := os.Getenv("SKETCH_COMPACT_THRESHOLD_RATIO"); env != "" {
+		if parsed, err := strconv.ParseFloat(env, 64); err == nil && parsed > 0 && parsed <= 1.0 {
+			return parsed
+		}
+	}
+	return 0.94
 }
 
 func (a *Agent) URL() string { return a.url }
@@ -703,6 +804,45 @@ func (a *Agent) OutstandingToolCalls() []string {
 	return tools
 }
 
+// StreamingResponse returns the part of the model's current response generated so far.
+func (a *Agent) StreamingResponse() StreamingResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return StreamingResponse{
+		Text:      a.streaming.Text,
+		ToolCalls: slices.Clone(a.streaming.ToolCalls),
+	}
+}
+
+// SubscribeStreamingResponse implements CodingAgent.
+func (a *Agent) SubscribeStreamingResponse() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.streamingSubscribers = append(a.streamingSubscribers, ch)
+	return ch, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.streamingSubscribers = slices.DeleteFunc(a.streamingSubscribers, func(c chan struct{}) bool { return c == ch })
+	}
+}
+
+// SubscribeEvents implements CodingAgent.
+func (a *Agent) SubscribeEvents() (<-chan Event, func()) {
+	return a.events.subscribe()
+}
+
+// notifyStreamingLocked tells subscribers that the streaming response changed.
+// a.mu must be held.
+func (a *Agent) notifyStreamingLocked() {
+	for _, ch := range a.streamingSubscribers {
+		select {
+		case ch <- struct{}{}:
+		default: // an update is already pending
+		}
+	}
+}
+
 // OS returns the operating system of the client.
 func (a *Agent) OS() string {
 	return a.config.ClientGOOS
@@ -802,6 +942,48 @@ func (a *Agent) OnToolCall(ctx context.Context, convo *conversation.Convo, id st
 	a.mu.Lock()
 	a.outstandingToolCalls[id] = toolName
 	a.mu.Unlock()
+	a.events.publish(Event{Type: EventToolCallStarted, ToolCall: &EventToolCall{ID: id, Name: toolName, Input: string(toolInput)}})
+}
+
+// OnPartialToolUse implements conversation.Listener. It tracks tool calls as their input streams in,
+// so that UIs can show large inputs, and any permission problems with them, before the turn completes.
+func (a *Agent) OnPartialToolUse(ctx context.Context, convo *conversation.Convo, tu llm.PartialToolUse) {
+	if convo.Parent != nil || tu.ToolName == "" {
+		return
+	}
+	call := StreamingToolCall{ID: tu.ID, ToolName: tu.ToolName}
+	command, err := claudetool.PreviewCommand(tu.ToolName, tu.Input)
+	call.Command = command
+	if err != nil {
+		call.PermissionError = err.Error()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer a.notifyStreamingLocked()
+	calls := a.streaming.ToolCalls
+	i := slices.IndexFunc(calls, func(c StreamingToolCall) bool { return c.ID == tu.ID })
+	if call.PermissionError != "" && (i < 0 || calls[i].PermissionError == "") {
+		slog.InfoContext(ctx, "streaming_tool_call_refused", "tool", tu.ToolName, "err", call.PermissionError)
+	}
+	if i < 0 {
+		a.streaming.ToolCalls = append(calls, call)
+	} else {
+		calls[i] = call
+	}
+	a.events.publish(Event{Type: EventMessageDelta, ToolCallDelta: &call})
+}
+
+// OnTextDelta implements conversation.Listener. It accumulates response text as it streams in,
+// so that UIs can show it before the response is complete.
+func (a *Agent) OnTextDelta(ctx context.Context, convo *conversation.Convo, text string) {
+	if convo.Parent != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.streaming.Text += text
+	a.notifyStreamingLocked()
+	a.events.publish(Event{Type: EventMessageDelta, Delta: text})
 }
 
 // contentToString converts []llm.Content to a string, concatenating all text content and skipping non-text types.
@@ -837,17 +1019,22 @@ func (a *Agent) OnToolResult(ctx context.Context, convo *conversation.Convo, too
 	a.mu.Lock()
 	delete(a.outstandingToolCalls, toolID)
 	a.mu.Unlock()
+	a.events.publish(Event{Type: EventToolCallFinished, ToolCall: &EventToolCall{ID: toolID, Name: toolName, Error: content.ToolError, ErrorKind: content.ToolErrorKind}})
+	a.requestApproval(ctx)
 
 	m := AgentMessage{
-		Type:       ToolUseMessageType,
-		Content:    content.Text,
-		ToolResult: contentToString(content.ToolResult),
-		ToolError:  content.ToolError,
-		ToolName:   toolName,
-		ToolInput:  string(toolInput),
-		ToolCallId: content.ToolUseID,
-		StartTime:  content.ToolUseStartTime,
-		EndTime:    content.ToolUseEndTime,
+		Type:          ToolUseMessageType,
+		Content:       content.Text,
+		ToolResult:    contentToString(content.ToolResult),
+		ToolError:     content.ToolError,
+		ToolErrorKind: content.ToolErrorKind,
+		ToolName:      toolName,
+		ToolInput:     string(toolInput),
+		ToolCallId:    content.ToolUseID,
+		StartTime:     content.ToolUseStartTime,
+		EndTime:       content.ToolUseEndTime,
+
+		CommandTags: claudetool.CommandCategories(toolName, toolInput),
 	}
 
 	// Calculate the elapsed time if both start and end times are set
@@ -876,7 +1063,17 @@ func (a *Agent) OnResponse(ctx context.Context, convo *conversation.Convo, id st
 	// Remove the LLM call from outstanding calls
 	a.mu.Lock()
 	delete(a.outstandingLLMCalls, id)
+	if convo.Parent == nil {
+		a.streaming = StreamingResponse{}
+		a.notifyStreamingLocked()
+	}
 	a.mu.Unlock()
+	root := convo
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	usage := root.Usage()
+	a.events.publish(Event{Type: EventUsageUpdated, Usage: &usage})
 
 	if resp == nil {
 		// LLM API call failed
@@ -926,9 +1123,10 @@ func (a *Agent) OnResponse(ctx context.Context, convo *conversation.Convo, id st
 		for _, part := range resp.Content {
 			if part.Type == llm.ContentTypeToolUse {
 				toolCalls = append(toolCalls, ToolCall{
-					Name:       part.ToolName,
-					Input:      string(part.ToolInput),
-					ToolCallId: part.ID,
+					Name:        part.ToolName,
+					Input:       string(part.ToolInput),
+					ToolCallId:  part.ID,
+					CommandTags: claudetool.CommandCategories(part.ToolName, part.ToolInput),
 				})
 			}
 		}
@@ -969,44 +1167,6 @@ func (a *Agent) Messages(start int, end int) []AgentMessage {
 	return slices.Clone(a.history[start:end])
 }
 
-// ShouldCompact checks if the conversation should be compacted based on token usage
-func (a *Agent) ShouldCompact() bool {
-	// Get the threshold from environment variable, default to 0.94 (94%)
-	// (Because default Claude output is 8192 tokens, which is 4% of 200,000 tokens,
-	// and a little bit of buffer.)
-	thresholdRatio := 0.94
-	if envThreshold := os.Getenv("SKETCH_COMPACT_THRESHOLD_RATIO"); envThreshold != "" {
-		if parsed, err := strconv.ParseFloat(envThreshold, 64); err == nil && parsed > 0 && parsed <= 1.0 {
-			thresholdRatio = parsed
-		}
-	}
-
-	// Get the most recent usage to check current context size
-	lastUsage := a.convo.LastUsage()
-
-	if lastUsage.InputTokens == 0 {
-		// No API calls made yet
-		return false
-	}
-
-	// Calculate the current context size from the last API call
-	// This includes all tokens that were part of the input context:
-	// - Input tokens (user messages, system prompt, conversation history)
-	// - Cache read tokens (cached parts of the context)
-	// - Cache creation tokens (new parts being cached)
-	currentContextSize := lastUsage.InputTokens + lastUsage.CacheReadInputTokens + lastUsage.CacheCreationInputTokens
-
-	// Get the service's token context window
-	service := a.config.Service
-	contextWindow := service.TokenContextWindow()
-
-	// Calculate threshold
-	threshold := uint64(float64(contextWindow) * thresholdRatio)
-
-	// Check if we've exceeded the threshold
-	return currentContextSize >= threshold
-}
-
 func (a *Agent) OriginalBudget() conversation.Budget {
 	return a.originalBudget
 }
@@ -1052,6 +1212,55 @@ type AgentConfig struct {
 	SkabandClient *skabandclient.SkabandClient
 	// MCP server configurations
 	MCPServers []string
+	// ApproveWrites stages all file modifications until the user approves them.
+	ApproveWrites bool
+	// Notifier, if set, is told when a foreground command runs longer than NotifyAfter, and when it completes;
+	// when a turn ends or exceeds its budget; when the agent waits for permission; and when a background job crashes.
+	Notifier    notify.Notifier
+	NotifyAfter time.Duration
+	// ResponseCache, if set, caches responses to deterministic subagent prompts across sessions.
+	ResponseCache *llmcache.Cache
+	// RecordSession, if set, is called with a summary of the session at the end of every turn.
+	RecordSession func(context.Context, *sessionstore.Session)
+	// PluginTools are tools loaded from plugins; see package toolplugin.
+	PluginTools []*llm.Tool
+	// PluginCommands are slash commands loaded from plugins, which the agent passes on to the UIs.
+	PluginCommands []*toolplugin.Command
+	// StateDB, if set, keeps background jobs, command history, staged changes,
+	// artifacts, and tool installation attempts across restarts.
+	StateDB *statedb.DB
+	// SessionLog, if set, records every message and, at the end of every turn, a snapshot of the conversation.
+	SessionLog *sessionlog.Log
+	// SystemPromptTemplate, if set, is a text/template to use instead of the built-in system prompt; see ParseSystemPrompt.
+	SystemPromptTemplate string
+	// Project, if set, is the project's configuration, which can disable tools, set rules for tool calls,
+	// and set commands' environment and default timeouts.
+	Project *projectconfig.Config
+	// Supervisor, if set, runs background commands under the system's service manager.
+	Supervisor *claudetool.Supervisor
+	// Forge, if set, gives the agent a forge tool for the repository on it.
+	Forge forge.Forge
+	// ForgeWrites is whether the forge tool may change things on the forge:
+	// "ask" (or empty) to ask the user each time, "allow", or "deny".
+	ForgeWrites string
+	// WebSearch, if set, gives the agent a web_search tool that searches with it.
+	WebSearch websearch.Provider
+	// WebSearchBudget is how many web searches the session may make; zero means websearch.DefaultBudget.
+	WebSearchBudget int
+	// Embedder, if set, indexes the repository's code for the semantic_search tool,
+	// keeping the index under .sketch/index up to date as files change.
+	Embedder llm.Embedder
+	// EmbeddingModel identifies Embedder's model; an index made with another model is rebuilt.
+	EmbeddingModel string
+	// AutoCommit commits the files the agent wrote at the end of every turn, as a series of small commits
+	// with LLM-written conventional-commit messages; see claudetool.AutoCommit.
+	// Other changes, such as the user's, and those that commands made, are left uncommitted.
+	AutoCommit bool
+	// Executor, if set, runs the tools' commands and file edits on another machine, in WorkingDir there.
+	// Only the tools that work through it are offered.
+	Executor claudetool.Executor
+	// Cluster, if set, runs the bash commands that the model marks as heavy, as Kubernetes Jobs; see kubejob.Runner.
+	Cluster claudetool.Runner
 }
 
 // NewAgent creates a new Agent.
@@ -1085,6 +1294,35 @@ func NewAgent(config AgentConfig) *Agent {
 
 		mcpManager: mcp.NewMCPManager(),
 	}
+	agent.journal = undo.NewJournal()
+	if config.StateDB != nil {
+		journal, err := undo.NewPersistentJournal(config.StateDB.Session(config.SessionID))
+		if err != nil {
+			slog.WarnContext(config.Context, "undo_journal_not_persisted", "err", err)
+		} else {
+			agent.journal = journal
+		}
+	}
+	if config.ApproveWrites {
+		agent.stage = staging.NewArea()
+		if config.StateDB != nil {
+			stage, err := staging.NewPersistentArea(config.StateDB.Session(config.SessionID))
+			if err != nil {
+				slog.WarnContext(config.Context, "staged_changes_not_persisted", "err", err)
+			} else {
+				agent.stage = stage
+			}
+		}
+		agent.stage.SetJournal(agent.journal)
+	}
+
+	if config.WebSearch != nil {
+		agent.webSearch = &websearch.Tool{Provider: config.WebSearch, Budget: config.WebSearchBudget}
+	}
+
+	agent.stateMachine.SetTransitionCallback(func(ctx context.Context, from, to State, event TransitionEvent) {
+		agent.events.publish(Event{Type: EventStateChanged, AgentState: to.String()})
+	})
 
 	// Initialize port monitor with 5-second interval
 	agent.portMonitor = NewPortMonitor(agent, 5*time.Second)
@@ -1093,7 +1331,7 @@ func NewAgent(config AgentConfig) *Agent {
 }
 
 type AgentInit struct {
-	NoGit bool // only for testing
+	NoGit bool // for testing, and for sessions whose working directory is on a remote host
 
 	InDocker bool
 	HostAddr string
@@ -1168,6 +1406,16 @@ func (a *Agent) Init(ini AgentInit) error {
 		if out, err := cmd.CombinedOutput(); err != nil {
 			return fmt.Errorf("git fetch: %s: %w", out, err)
 		}
+		// A commit that no branch has, such as a snapshot of the host's uncommitted changes, is fetched by itself.
+		cmd = exec.CommandContext(ctx, "git", "cat-file", "-e", a.config.Commit+"^{commit}")
+		cmd.Dir = a.workingDir
+		if cmd.Run() != nil {
+			cmd = exec.CommandContext(ctx, "git", "fetch", "origin", a.config.Commit)
+			cmd.Dir = a.workingDir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("git fetch %s: %s: %w", a.config.Commit, out, err)
+			}
+		}
 		// The -B resets the branch if it already exists (or creates it if it doesn't)
 		cmd = exec.CommandContext(ctx, "git", "checkout", "-f", "-B", "sketch-wip", a.config.Commit)
 		cmd.Dir = a.workingDir
@@ -1237,6 +1485,9 @@ func (a *Agent) Init(ini AgentInit) error {
 		if err != nil {
 			slog.Warn("failed to analyze codebase", "error", err)
 		}
+		if codebase != nil {
+			codebase.LoadInstructions(a.repoRoot, a.workingDir)
+		}
 		a.codebase = codebase
 
 		codereview, err := codereview.NewCodeReviewer(ctx, a.repoRoot, a.SketchGitBaseRef())
@@ -1245,6 +1496,24 @@ func (a *Agent) Init(ini AgentInit) error {
 		}
 		a.codereview = codereview
 
+		if a.config.Embedder != nil {
+			index, err := codeindex.Open(a.repoRoot, a.config.Embedder, a.config.EmbeddingModel)
+			if err != nil {
+				slog.WarnContext(ctx, "code_index_open_failed", "error", err)
+			} else {
+				a.index = index
+			}
+		}
+
+		// Summarizing every package only pays off with a cheap model.
+		if router, _ := a.config.Service.(*llm.Router); router != nil && len(router.Routes[overview.Task]) > 0 {
+			o, err := overview.New(a.repoRoot, a.mainConvo)
+			if err != nil {
+				slog.WarnContext(ctx, "codebase_overview_failed", "error", err)
+			} else {
+				a.overview = o
+			}
+		}
 	}
 	a.gitState.lastSketch = a.SketchGitBase()
 	a.convo = a.initConvo()
@@ -1268,8 +1537,14 @@ func (a *Agent) initConvoWithUsage(usage *conversation.CumulativeUsage) *convers
 	convo := conversation.New(ctx, a.config.Service, usage)
 	convo.PromptCaching = true
 	convo.Budget = a.config.Budget
+	convo.ResponseCache = a.config.ResponseCache
+	convo.Redact = credentials.Redact
 	convo.SystemPrompt = a.renderSystemPrompt()
-	convo.ExtraData = map[string]any{"session_id": a.config.SessionID}
+	convo.ExtraData = map[string]any{"session_id": a.config.SessionID, "working_dir": a.workingDir}
+	// Summarizing large tool results only pays off with a cheap model; without one, truncate them.
+	router, _ := a.config.Service.(*llm.Router)
+	convo.ToolResultLimit = &conversation.ToolResultLimit{Summarize: router != nil && len(router.Routes["tool-result-summary"]) > 0}
+	convo.Compaction = &conversation.Compaction{Threshold: compactThreshold(), Compacted: a.compacted}
 
 	// Define a permission callback for the bash tool to check if the branch name is set before allowing git commits
 	bashPermissionCheck := func(command string) error {
@@ -1286,29 +1561,70 @@ func (a *Agent) initConvoWithUsage(usage *conversation.CumulativeUsage) *convers
 		return nil
 	}
 
-	bashTool := claudetool.NewBashTool(bashPermissionCheck, claudetool.EnableBashToolJITInstall)
+	var state *statedb.Session
+	if a.config.StateDB != nil {
+		state = a.config.StateDB.Session(a.config.SessionID)
+	}
+	bash := &claudetool.BashTool{
+		CheckPermission:  bashPermissionCheck,
+		EnableJITInstall: claudetool.EnableBashToolJITInstall,
+		Notifier:         a.config.Notifier,
+		NotifyAfter:      a.config.NotifyAfter,
+		State:            state,
+		AutoRewrite:      experiment.Enabled("quiet-rewrite"),
+		Supervisor:       a.config.Supervisor,
+		Output: func(ctx context.Context, output string) {
+			id := conversation.ToolCallInfoFromContext(ctx).ToolUseID
+			a.events.publish(Event{Type: EventToolCallOutput, ToolCall: &EventToolCall{ID: id, Name: "bash"}, Delta: output})
+		},
+		JobCrashed: a.notifyJobCrashed,
+		Cluster:    a.config.Cluster,
+	}
+	a.bash.Store(bash)
+	if project := a.config.Project; project != nil {
+		bash.Env = project.Env
+		bash.Timeout = project.Timeouts.Bash
+		bash.BackgroundTimeout = project.Timeouts.BashBackground
+	}
 
 	// Register all tools with the conversation
 	// When adding, removing, or modifying tools here, double-check that the termui tool display
 	// template in termui/termui.go has pretty-printing support for all tools.
 
-	var browserTools []*llm.Tool
-	_, supportsScreenshots := a.config.Service.(*ant.Service)
-	var bTools []*llm.Tool
-	var browserCleanup func()
-
-	bTools, browserCleanup = browse.RegisterBrowserTools(a.config.Context, supportsScreenshots)
-	// Add cleanup function to context cancel
-	go func() {
-		<-a.config.Context.Done()
-		browserCleanup()
-	}()
-	browserTools = bTools
-
-	convo.Tools = []*llm.Tool{
-		bashTool, claudetool.Keyword, claudetool.Patch(a.patchCallback),
-		claudetool.Think, claudetool.TodoRead, claudetool.TodoWrite, a.setSlugTool(), a.commitMessageStyleTool(), makeDoneTool(a.codereview),
-		a.codereview.Tool(), claudetool.AboutSketch,
+	patchTool := &claudetool.PatchTool{
+		Callback: a.patchCallback,
+		Stage:    a.stage,
+		State:    state,
+		Journal:  a.journal,
+	}
+	scaffoldTool := &claudetool.ScaffoldTool{
+		RepoRoot: a.repoRoot,
+		Stage:    a.stage,
+		Journal:  a.journal,
+	}
+	undoTool := &claudetool.UndoTool{Journal: a.journal}
+	codegenTool := &claudetool.CodegenTool{RepoRoot: a.repoRoot}
+	envVarsTool := &claudetool.EnvVarsTool{RepoRoot: a.repoRoot}
+	goDocTool := &claudetool.GoDocTool{RepoRoot: a.repoRoot}
+	fetchTool := &claudetool.FetchTool{}
+	if project := a.config.Project; project != nil {
+		fetchTool.AllowDomains = project.Fetch.Allow
+		fetchTool.DenyDomains = project.Fetch.Deny
+	}
+
+	if a.config.Executor != nil {
+		// The commands and files are on the remote host, where only these tools reach.
+		convo.Tools = []*llm.Tool{
+			bash.Tool(), claudetool.Keyword, patchTool.Tool(), claudetool.Think, claudetool.TodoRead, claudetool.TodoWrite,
+			claudetool.AboutSketch, fetchTool.Tool(), claudetool.PinResults,
+		}
+	} else {
+		convo.Tools = []*llm.Tool{
+			bash.Tool(), bash.GroupTool(), bash.EnvironmentTool(), claudetool.Keyword, patchTool.Tool(), scaffoldTool.Tool(), codegenTool.Tool(), envVarsTool.Tool(),
+			claudetool.Think, claudetool.TodoRead, claudetool.TodoWrite, a.setSlugTool(), a.commitMessageStyleTool(), makeDoneTool(a.codereview),
+			a.codereview.Tool(), claudetool.AboutSketch, undoTool.LastTool(), undoTool.AllTool(), fetchTool.Tool(), goDocTool.Tool(),
+			claudetool.PinResults,
+		}
 	}
 
 	// One-shot mode is non-interactive, multiple choice requires human response
@@ -1316,7 +1632,50 @@ func (a *Agent) initConvoWithUsage(usage *conversation.CumulativeUsage) *convers
 		convo.Tools = append(convo.Tools, multipleChoiceTool)
 	}
 
-	convo.Tools = append(convo.Tools, browserTools...)
+	if a.config.Executor == nil {
+		_, supportsScreenshots := a.config.Service.(*ant.Service)
+		browserTools, browserCleanup := browse.RegisterBrowserTools(a.config.Context, supportsScreenshots)
+		// Add cleanup function to context cancel
+		go func() {
+			<-a.config.Context.Done()
+			browserCleanup()
+		}()
+		convo.Tools = append(convo.Tools, browserTools...)
+	}
+	if a.config.Forge != nil {
+		convo.Tools = append(convo.Tools, a.forgeTool())
+	}
+	if a.webSearch != nil {
+		convo.Tools = append(convo.Tools, a.webSearch.Tool())
+	}
+	if a.index != nil {
+		convo.Tools = append(convo.Tools, a.index.Tool())
+	}
+	if a.overview != nil {
+		convo.Tools = append(convo.Tools, a.overview.Tool())
+	}
+	if a.repoRoot != "" {
+		convo.Tools = append(convo.Tools, (&symbols.Tool{RepoRoot: a.repoRoot}).Tool())
+		if found, _ := skills.Load(a.repoRoot); len(found) > 0 {
+			convo.Tools = append(convo.Tools, (&skills.Tool{RepoRoot: a.repoRoot}).Tool())
+		}
+	}
+	if a.config.Executor == nil {
+		convo.Tools = append(convo.Tools, a.reviewTool(), a.depsTool())
+	}
+
+	// Plugins may not replace built-in tools.
+	builtin := make(map[string]bool)
+	for _, tool := range convo.Tools {
+		builtin[tool.Name] = true
+	}
+	for _, tool := range a.config.PluginTools {
+		if builtin[tool.Name] {
+			slog.WarnContext(ctx, "plugin_tool_shadows_builtin", "tool", tool.Name)
+			continue
+		}
+		convo.Tools = append(convo.Tools, tool)
+	}
 
 	// Add MCP tools if configured
 	if len(a.config.MCPServers) > 0 {
@@ -1363,6 +1722,7 @@ func (a *Agent) initConvoWithUsage(usage *conversation.CumulativeUsage) *convers
 		}
 	}
 
+	convo.Tools = a.applyProjectConfig(ctx, convo.Tools)
 	convo.Listener = a
 	return convo
 }
@@ -1436,6 +1796,76 @@ func branchExists(dir, branchName string) bool {
 	return false
 }
 
+// forgeApprover returns the function that the named tool calls before changing anything on the forge,
+// which asks the user unless the configuration allows or denies that outright.
+// It returns nil if writes are denied.
+func (a *Agent) forgeApprover(tool string) func(context.Context, string) error {
+	switch a.config.ForgeWrites {
+	case "allow":
+		return func(context.Context, string) error { return nil }
+	case "deny":
+		return nil
+	default:
+		return func(ctx context.Context, description string) error {
+			return a.RequestPermission(ctx, tool, description)
+		}
+	}
+}
+
+// forgeTool returns the forge tool.
+func (a *Agent) forgeTool() *llm.Tool {
+	tool := &forge.Tool{
+		Forge:    a.config.Forge,
+		RepoRoot: a.repoRoot,
+		BaseRef:  a.SketchGitBaseRef(),
+		Approve:  a.forgeApprover(forge.Name),
+	}
+	return tool.Tool()
+}
+
+// reviewTool returns the review tool, which reviews local changes,
+// and pull requests too if there is a forge.
+func (a *Agent) reviewTool() *llm.Tool {
+	tool := &review.Tool{
+		RepoRoot: a.repoRoot,
+		BaseRef:  a.SketchGitBaseRef(),
+		Forge:    a.config.Forge,
+		Approve:  a.forgeApprover(review.Name),
+	}
+	return tool.Tool()
+}
+
+// filesChanged tells the background indexes that files in the repository changed.
+func (a *Agent) filesChanged() {
+	if a.index != nil {
+		a.index.Invalidate()
+	}
+	if a.overview != nil {
+		a.overview.Invalidate()
+	}
+}
+
+// mainConvo returns the agent's current conversation, or nil if it has none,
+// for work done on the conversation's behalf outside its turns.
+func (a *Agent) mainConvo() *conversation.Convo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	convo, _ := a.convo.(*conversation.Convo)
+	return convo
+}
+
+// depsTool returns the deps tool, which asks the user before each change to a manifest.
+func (a *Agent) depsTool() *llm.Tool {
+	tool := &deps.Tool{
+		RepoRoot: a.repoRoot,
+		BaseRef:  a.SketchGitBaseRef(),
+		Approve: func(ctx context.Context, description string) error {
+			return a.RequestPermission(ctx, deps.Name, description)
+		},
+	}
+	return tool.Tool()
+}
+
 func (a *Agent) setSlugTool() *llm.Tool {
 	return &llm.Tool{
 		Name:        "set-slug",
@@ -1503,9 +1933,317 @@ func (a *Agent) patchCallback(input claudetool.PatchInput, result []llm.Content,
 	if a.codereview != nil {
 		a.codereview.WarmTestCache(input.Path)
 	}
+	if err == nil && claudetool.IsAPISpec(input.Path) {
+		result = append(result, llm.StringContent("This file is an API spec. Run the codegen tool to regenerate the code generated from it."))
+	}
 	return result, err
 }
 
+// PendingChanges returns the file modifications awaiting user approval.
+func (a *Agent) PendingChanges() []staging.Change {
+	if a.stage == nil {
+		return nil
+	}
+	return a.stage.Pending()
+}
+
+// ApproveChange writes the given hunks of the staged change to path to disk.
+// A nil hunks approves the whole file; an empty path approves every staged change.
+func (a *Agent) ApproveChange(path string, hunks []int) error {
+	if a.stage == nil {
+		return fmt.Errorf("write approval is not enabled")
+	}
+	if path == "" {
+		return a.reportConflict(a.stage.ApproveAll())
+	}
+	return a.reportConflict(a.stage.Approve(path, hunks))
+}
+
+// reportConflict tells the model, if err says that files changed on disk after the model's changes to them were staged,
+// that those changes were discarded and must be redone. It returns err.
+func (a *Agent) reportConflict(err error) error {
+	if errors.Is(err, staging.ErrConflict) {
+		a.convo.QueueUserMessage(err.Error())
+	}
+	return err
+}
+
+// requestApproval publishes EventPermissionRequested, and notifies the Notifier,
+// if the staged changes awaiting approval differ from those last announced.
+func (a *Agent) requestApproval(ctx context.Context) {
+	if a.stage == nil {
+		return
+	}
+	pending := a.stage.Pending()
+	var diff strings.Builder
+	for _, c := range pending {
+		diff.WriteString(c.Diff())
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if diff.String() == a.requestedChanges {
+		return
+	}
+	a.requestedChanges = diff.String()
+	if len(pending) > 0 {
+		a.events.publish(Event{Type: EventPermissionRequested, Changes: pending})
+		a.notifyChangesPending(ctx, pending)
+	}
+}
+
+// RejectChange discards the given hunks of the staged change to path.
+// A nil hunks rejects the whole file; an empty path rejects every staged change.
+func (a *Agent) RejectChange(path string, hunks []int) error {
+	if a.stage == nil {
+		return fmt.Errorf("write approval is not enabled")
+	}
+	if path == "" {
+		return a.stage.RejectAll()
+	}
+	return a.stage.Reject(path, hunks)
+}
+
+// ReviewChange accepts and rejects hunks of the staged change to path at once,
+// and tells the model what the user decided, with their comments, so that it does not redo rejected work.
+func (a *Agent) ReviewChange(path string, decisions []staging.Decision) error {
+	if a.stage == nil {
+		return fmt.Errorf("write approval is not enabled")
+	}
+	hunks, err := a.stage.Review(path, decisions)
+	if err != nil {
+		return a.reportConflict(err)
+	}
+	if len(decisions) == 0 {
+		return nil
+	}
+	a.convo.QueueUserMessage(reviewMessage(path, decisions, hunks))
+	return nil
+}
+
+// A hunkReview is the user's decision on a hunk, as reported to the model.
+type hunkReview struct {
+	Hunk     string `json:"hunk"`     // the hunk's @@ header
+	Decision string `json:"decision"` // "accepted" or "rejected"
+	Comment  string `json:"comment,omitempty"`
+}
+
+// reviewMessage tells the model the user's decisions on hunks of its change to path.
+func reviewMessage(path string, decisions []staging.Decision, hunks []staging.Hunk) string {
+	review := struct {
+		Path  string       `json:"path"`
+		Hunks []hunkReview `json:"hunks"`
+	}{Path: path}
+	rejected := false
+	for i, d := range decisions {
+		header, _, _ := strings.Cut(hunks[i].Diff, "\n")
+		hr := hunkReview{Hunk: header, Decision: "accepted", Comment: d.Comment}
+		if !d.Accept {
+			hr.Decision = "rejected"
+			rejected = true
+		}
+		review.Hunks = append(review.Hunks, hr)
+	}
+	data, _ := json.MarshalIndent(review, "", "  ")
+	msg := fmt.Sprintf("I reviewed your change to %s. Accepted hunks are written to disk; rejected hunks are discarded.", path)
+	if rejected {
+		msg += " The file does not contain the rejected hunks: read it again before changing it further, and take my comments into account."
+	}
+	return msg + "\n\n```json\n" + string(data) + "\n```"
+}
+
+// BackgroundJobs returns the background jobs the bash tool started, in the order they started.
+// Jobs started before the conversation was last compacted are not included.
+func (a *Agent) BackgroundJobs() []claudetool.JobInfo {
+	if bash := a.bash.Load(); bash != nil {
+		return bash.Jobs()
+	}
+	return nil
+}
+
+// StopBackgroundJob stops the background job with the given ID, and tells the model that the user did,
+// so that it does not wait on the job.
+func (a *Agent) StopBackgroundJob(ctx context.Context, id int) error {
+	bash := a.bash.Load()
+	if bash == nil {
+		return fmt.Errorf("no background job %d", id)
+	}
+	if err := bash.StopJob(ctx, id); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "background_job_stopped", "job", id)
+	msg := fmt.Sprintf("The user stopped background job %d.", id)
+	a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: msg})
+	// The model learns of it with its next tool results, rather than in a turn of its own.
+	a.convo.QueueUserMessage(msg)
+	return nil
+}
+
+// Attach pins files, directories, globs, and URLs into the conversation as context, so that the model
+// sees their contents with every request, even after compaction. Paths are relative to the working directory.
+func (a *Agent) Attach(ctx context.Context, sources ...string) ([]conversation.Attachment, error) {
+	return a.attach(ctx, a.workingDir, sources...)
+}
+
+// attach pins sources, relative to dir, into the conversation.
+func (a *Agent) attach(ctx context.Context, dir string, sources ...string) ([]conversation.Attachment, error) {
+	atts, err := a.convo.Attach(ctx, dir, sources...)
+	for _, att := range atts {
+		slog.InfoContext(ctx, "context_attached", "name", att.Name, "bytes", len(att.Content), "truncated", att.Truncated)
+	}
+	return atts, err
+}
+
+// Detach unpins the attachments with the given names, or all of them if there are none,
+// and returns how many it unpinned.
+func (a *Agent) Detach(names ...string) int {
+	return a.convo.Detach(names...)
+}
+
+// Attachments returns the conversation's attachments, in the order they were attached.
+func (a *Agent) Attachments() []conversation.Attachment {
+	return a.convo.Attachments()
+}
+
+// mentionPattern matches @-mentions: an @ starting a word, and the path, glob, or URL after it.
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([^\s@]+)`)
+
+// attachMentions attaches the files, globs, and URLs that msg @-mentions, and tells the user what it attached.
+// Mentions of paths that do not exist, such as of people, are left alone.
+// Paths are looked up in the working directory, and then in the repository root.
+func (a *Agent) attachMentions(ctx context.Context, msg string) {
+	var seen []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(msg, -1) {
+		name := strings.TrimRight(m[1], ".,:;!?)'\"")
+		if name == "" || slices.Contains(seen, name) {
+			continue
+		}
+		seen = append(seen, name)
+		dir, ok := a.mentionDir(name)
+		if !ok {
+			continue
+		}
+		atts, err := a.attach(ctx, dir, name)
+		if err != nil {
+			a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: fmt.Sprintf("❌ %v", err)})
+			continue
+		}
+		for _, att := range atts {
+			note := fmt.Sprintf("📎 Attached %s (%d lines)", att.Name, strings.Count(att.Content, "\n"))
+			if att.Truncated {
+				note += ", truncated"
+			}
+			a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: note})
+		}
+	}
+}
+
+// mentionDir returns the directory an @-mention of name is relative to, or false if name mentions nothing to attach.
+func (a *Agent) mentionDir(name string) (string, bool) {
+	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		return a.workingDir, true
+	}
+	if strings.ContainsAny(name, "*?[") {
+		// Only globs that match something; conversation.Convo.Attach reports the details.
+		pattern := name
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(a.workingDir, pattern)
+		}
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 || strings.Contains(name, "**") {
+			return a.workingDir, true
+		}
+		return "", false
+	}
+	for _, dir := range []string{a.workingDir, a.repoRoot} {
+		if dir == "" && !filepath.IsAbs(name) {
+			continue
+		}
+		p := name
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		if _, err := os.Stat(p); err == nil {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// Skills returns the project's skills; see package skills.
+func (a *Agent) Skills() ([]*skills.Skill, error) {
+	if a.repoRoot == "" {
+		return nil, nil
+	}
+	return skills.Load(a.repoRoot)
+}
+
+// PluginCommands returns the slash commands loaded from plugins.
+func (a *Agent) PluginCommands() []*toolplugin.Command {
+	return a.config.PluginCommands
+}
+
+// FileWrites returns the agent's file writes that can be undone, oldest first.
+func (a *Agent) FileWrites() []undo.Entry {
+	return a.journal.Entries()
+}
+
+// UndoFileWrites undoes the agent's most recent file write, or all of them, returns what it undid,
+// and tells the model, whose idea of the files is now out of date.
+func (a *Agent) UndoFileWrites(all bool) ([]undo.Entry, error) {
+	var undone []undo.Entry
+	var err error
+	if all {
+		undone, err = a.journal.UndoAll()
+	} else {
+		var e *undo.Entry
+		if e, err = a.journal.UndoLast(); e != nil {
+			undone = append(undone, *e)
+		}
+	}
+	if len(undone) > 0 {
+		msg := new(strings.Builder)
+		msg.WriteString("I undid some of your file writes:\n")
+		for _, e := range undone {
+			fmt.Fprintf(msg, "- %s\n", claudetool.DescribeUndo(e))
+		}
+		msg.WriteString("Read the files again before changing them further.")
+		a.convo.QueueUserMessage(msg.String())
+	}
+	return undone, err
+}
+
+// StagedContents returns the contents of path with its staged changes.
+func (a *Agent) StagedContents(path string) ([]byte, error) {
+	if a.stage == nil {
+		return nil, fmt.Errorf("write approval is not enabled")
+	}
+	return a.stage.ReadFile(path)
+}
+
+// EditChange writes data, the user's edit of the staged change to path, to disk in place of the change,
+// and tells the model that its change was edited.
+func (a *Agent) EditChange(path string, data []byte) error {
+	if a.stage == nil {
+		return fmt.Errorf("write approval is not enabled")
+	}
+	if err := a.stage.Edit(path, data); err != nil {
+		return err
+	}
+	if err := a.stage.Approve(path, nil); err != nil {
+		return a.reportConflict(err)
+	}
+	a.convo.QueueUserMessage(fmt.Sprintf("I edited your change to %s before approving it. Read the file again before changing it further.", path))
+	return nil
+}
+
+// AutoApproveChanges writes all staged changes to disk, and has file modifications
+// written to disk as they are made, without approval, for the rest of the session.
+func (a *Agent) AutoApproveChanges() error {
+	if a.stage == nil {
+		return fmt.Errorf("write approval is not enabled")
+	}
+	return a.stage.AutoApprove()
+}
+
 func (a *Agent) Ready() <-chan struct{} {
 	return a.ready
 }
@@ -1520,8 +2258,19 @@ func (a *Agent) LinkToGitHub() bool {
 	return a.config.LinkToGitHub
 }
 
+// UserMessage sends msg from the user to the agent.
+// If a turn is in progress, msg steers it: the model gets it along with the results of the tools it is running.
+// Otherwise, msg starts the next turn.
 func (a *Agent) UserMessage(ctx context.Context, msg string) {
 	a.pushToOutbox(ctx, AgentMessage{Type: UserMessageType, Content: msg})
+	a.attachMentions(ctx, msg)
+	a.cancelTurnMu.Lock()
+	if a.turnActive {
+		a.convo.QueueUserMessage(msg)
+		a.cancelTurnMu.Unlock()
+		return
+	}
+	a.cancelTurnMu.Unlock()
 	a.inbox <- msg
 }
 
@@ -1540,6 +2289,38 @@ func (a *Agent) CancelTurn(cause error) {
 	}
 }
 
+// InterruptTurn stops the current turn, whether the model is responding or tools are running,
+// and starts a new one with msg, so that the user can steer the agent without waiting for it to finish.
+// What the model had said so far, and the results of any canceled tools, go along with msg.
+// If the agent is idle, msg is simply sent.
+func (a *Agent) InterruptTurn(ctx context.Context, msg string) {
+	a.cancelTurnMu.Lock()
+	if a.turnActive && a.cancelTurn != nil {
+		a.stateMachine.ForceTransition(a.config.Context, StateCancelled, "User interrupted turn")
+		a.cancelTurn(errTurnInterrupted)
+		a.turnActive = false
+		// Steering messages sent earlier in the turn come before the new instruction.
+		for _, queued := range a.convo.TakeQueuedUserMessages() {
+			a.inbox <- queued
+		}
+	}
+	a.cancelTurnMu.Unlock()
+	a.UserMessage(ctx, msg)
+}
+
+// reportCanceledResponse tells the user about a response that was cut short
+// because they canceled or interrupted the turn.
+func (a *Agent) reportCanceledResponse(ctx context.Context, err error) {
+	var ierr *conversation.InterruptedError
+	if errors.As(err, &ierr) && strings.TrimSpace(ierr.Partial) != "" {
+		a.pushToOutbox(ctx, AgentMessage{Type: AgentMessageType, Content: strings.TrimSpace(ierr.Partial) + "\n\n*(interrupted)*"})
+	}
+	if !errors.Is(context.Cause(ctx), errTurnInterrupted) {
+		// An interruption is followed at once by a new turn; a plain cancellation ends this one.
+		a.pushToOutbox(ctx, AgentMessage{Type: ErrorMessageType, Content: userCancelMessage, EndOfTurn: true})
+	}
+}
+
 func (a *Agent) Loop(ctxOuter context.Context) {
 	// Start port monitoring
 	if a.portMonitor != nil && a.IsInContainer() {
@@ -1550,6 +2331,22 @@ func (a *Agent) Loop(ctxOuter context.Context) {
 		}
 	}
 
+	if a.repoRoot != "" {
+		if w, err := newFileWatcher(a.repoRoot); err != nil {
+			slog.WarnContext(ctxOuter, "file_watcher_failed", "error", err)
+		} else {
+			a.files = w
+			w.onChange = a.filesChanged
+			go w.run(ctxOuter)
+		}
+	}
+	if a.index != nil {
+		go a.index.Run(ctxOuter)
+	}
+	if a.overview != nil {
+		go a.overview.Run(ctxOuter)
+	}
+
 	// Set up cleanup when context is done
 	defer func() {
 		if a.mcpManager != nil {
@@ -1572,17 +2369,34 @@ func (a *Agent) Loop(ctxOuter context.Context) {
 			// This cancelTurn func is intended be called from other goroutines,
 			// hence the mutex.
 			a.cancelTurn = cancel
+			a.turnActive = false
 			a.cancelTurnMu.Unlock()
 			err := a.processTurn(ctxInner) // Renamed from InnerLoop to better reflect its purpose
 			if err != nil {
 				slog.ErrorContext(ctxOuter, "Error in processing turn", "error", err)
 			}
 			cancel(nil)
+			a.cancelTurnMu.Lock()
+			a.turnActive = false
+			// Messages queued after the model's last tool call start the next turn.
+			for _, queued := range a.convo.TakeQueuedUserMessages() {
+				a.inbox <- queued
+			}
+			a.cancelTurnMu.Unlock()
+			if (a.config.RecordSession != nil || a.config.Notifier != nil) && ctxOuter.Err() == nil {
+				sess := a.SessionSummary(ctxOuter)
+				if a.config.RecordSession != nil {
+					a.config.RecordSession(ctxOuter, sess)
+				}
+				a.notifyTurnEnded(ctxOuter, sess)
+			}
+			a.writeSnapshot(ctxOuter)
 		}
 	}
 }
 
 func (a *Agent) pushToOutbox(ctx context.Context, m AgentMessage) {
+	m.SchemaVersion = claudetool.SchemaVersion
 	if m.Timestamp.IsZero() {
 		m.Timestamp = time.Now()
 	}
@@ -1602,8 +2416,13 @@ func (a *Agent) pushToOutbox(ctx context.Context, m AgentMessage) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	m.Idx = len(a.history)
-	slog.InfoContext(ctx, "agent message", m.Attr())
+	if a.config.SessionLog == nil {
+		slog.InfoContext(ctx, "agent message", m.Attr())
+	} else if err := a.config.SessionLog.WriteMessage(m); err != nil {
+		slog.WarnContext(ctx, "session_log_write_failed", "err", err)
+	}
 	a.history = append(a.history, m)
+	a.events.publish(Event{Type: EventMessage, Message: &m})
 
 	// Notify all subscribers
 	for _, ch := range a.subscribers {
@@ -1632,7 +2451,7 @@ func (a *Agent) GatherMessages(ctx context.Context, block bool) ([]llm.Content,
 }
 
 // processTurn handles a single conversation turn with the user
-func (a *Agent) processTurn(ctx context.Context) error {
+func (a *Agent) processTurn(ctx context.Context) (err error) {
 	// Reset the start of turn time
 	a.startOfTurn = time.Now()
 
@@ -1640,7 +2459,12 @@ func (a *Agent) processTurn(ctx context.Context) error {
 	a.stateMachine.Transition(ctx, StateWaitingForUserInput, "Starting turn")
 
 	// Process initial user message
-	initialResp, err := a.processUserMessage(ctx)
+	ctx, initialResp, err := a.processUserMessage(ctx)
+	defer a.endTurnSpan(ctx, &err)
+	if err != nil && ctx.Err() != nil {
+		// The user canceled or interrupted the turn; that has been reported.
+		return nil
+	}
 	if err != nil {
 		a.stateMachine.Transition(ctx, StateError, "Error processing user message: "+err.Error())
 		return err
@@ -1662,28 +2486,17 @@ func (a *Agent) processTurn(ctx context.Context) error {
 			slog.WarnContext(ctx, "Failed to check for new git commits", "error", err)
 		}
 	}()
+	// Deferred after handleGitCommits so that it runs first, and its commits are reported.
+	defer a.autoCommit(ctx)
 
 	// Main response loop - continue as long as the model is using tools or a tool use fails.
 	resp := initialResp
 	for {
 		// Check if we are over budget
-		if err := a.overBudget(ctx); err != nil {
-			a.stateMachine.Transition(ctx, StateBudgetExceeded, "Budget exceeded: "+err.Error())
+		if err := a.overBudget(ctx, resp, nil); err != nil {
 			return err
 		}
 
-		// Check if we should compact the conversation
-		if a.ShouldCompact() {
-			a.stateMachine.Transition(ctx, StateCompacting, "Token usage threshold reached, compacting conversation")
-			if err := a.CompactConversation(ctx); err != nil {
-				a.stateMachine.Transition(ctx, StateError, "Error during compaction: "+err.Error())
-				return err
-			}
-			// After compaction, end this turn and start fresh
-			a.stateMachine.Transition(ctx, StateEndOfTurn, "Compaction completed, ending turn")
-			return nil
-		}
-
 		// If the model is not requesting to use a tool, we're done
 		if resp.StopReason != llm.StopReasonToolUse {
 			a.stateMachine.Transition(ctx, StateEndOfTurn, "LLM completed response, ending turn")
@@ -1694,7 +2507,10 @@ func (a *Agent) processTurn(ctx context.Context) error {
 		a.stateMachine.Transition(ctx, StateToolUseRequested, "LLM requested tool use")
 
 		// Handle tool execution
-		continueConversation, toolResp := a.handleToolExecution(ctx, resp)
+		continueConversation, toolResp, err := a.handleToolExecution(ctx, resp)
+		if err != nil {
+			return err
+		}
 		if !continueConversation {
 			return nil
 		}
@@ -1710,13 +2526,43 @@ func (a *Agent) processTurn(ctx context.Context) error {
 	return nil
 }
 
-// processUserMessage waits for user messages and sends them to the model
-func (a *Agent) processUserMessage(ctx context.Context) (*llm.Response, error) {
+// writeSnapshot records the conversation in the session log, if any, so that it can be resumed.
+func (a *Agent) writeSnapshot(ctx context.Context) {
+	if a.config.SessionLog == nil {
+		return
+	}
+	data, err := a.convo.Snapshot()
+	if err == nil {
+		err = a.config.SessionLog.WriteSnapshot(data)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "session_snapshot_failed", "err", err)
+	}
+}
+
+// processUserMessage waits for user messages and sends them to the model.
+// The turn starts once there are messages: the returned context carries the turn's span.
+func (a *Agent) processUserMessage(ctx context.Context) (context.Context, *llm.Response, error) {
 	// Wait for at least one message from the user
 	msgs, err := a.GatherMessages(ctx, true)
 	if err != nil { // e.g. the context was canceled while blocking in GatherMessages
 		a.stateMachine.Transition(ctx, StateError, "Error gathering messages: "+err.Error())
-		return nil, err
+		return ctx, nil, err
+	}
+	ctx = a.startTurnSpan(ctx)
+
+	a.cancelTurnMu.Lock()
+	a.turnActive = true
+	a.cancelTurnMu.Unlock()
+	a.events.publish(Event{Type: EventTurnStarted})
+	// The wall time budget, -max-wall-time, applies to each turn.
+	a.convo.RestartWallTime(a.originalBudget.MaxWallTime)
+	if len(a.interruptedResults) > 0 {
+		msgs = append(a.interruptedResults, msgs...)
+		a.interruptedResults = nil
+	}
+	if msg := a.externalChanges(ctx); msg != "" {
+		msgs = append(msgs, llm.StringContent(msg))
 	}
 
 	userMessage := llm.Message{
@@ -1728,24 +2574,64 @@ func (a *Agent) processUserMessage(ctx context.Context) (*llm.Response, error) {
 	a.stateMachine.Transition(ctx, StateSendingToLLM, "Sending user message to LLM")
 
 	// Send message to the model
-	resp, err := a.convo.SendMessage(userMessage)
+	resp, err := a.convo.SendMessageContext(ctx, userMessage)
+	if err != nil && ctx.Err() != nil {
+		a.reportCanceledResponse(ctx, err)
+		return ctx, nil, err
+	}
 	if err != nil {
 		a.stateMachine.Transition(ctx, StateError, "Error sending to LLM: "+err.Error())
 		a.pushToOutbox(ctx, errorMessage(err))
-		return nil, err
+		return ctx, nil, err
 	}
 
 	// Transition to processing LLM response state
 	a.stateMachine.Transition(ctx, StateProcessingLLMResponse, "Processing LLM response")
 
-	return resp, nil
+	return ctx, resp, nil
 }
 
-// handleToolExecution processes a tool use request from the model
-func (a *Agent) handleToolExecution(ctx context.Context, resp *llm.Response) (bool, *llm.Response) {
+// turnSpanKey is the context key of a turnStart.
+type turnSpanKey struct{}
+
+// turnStart is the usage when a turn started, to attribute usage to the turn's span.
+type turnStart struct {
+	usage conversation.CumulativeUsage
+}
+
+// startTurnSpan starts the span of a turn, the parent of the spans of its LLM requests and tool calls.
+func (a *Agent) startTurnSpan(ctx context.Context) context.Context {
+	ctx, _ = tracer.Start(ctx, "turn", trace.WithAttributes(attribute.String("sketch.session_id", a.config.SessionID)))
+	return context.WithValue(ctx, turnSpanKey{}, turnStart{usage: a.convo.Usage()})
+}
+
+// endTurnSpan ends the span of the turn started in ctx, if any, recording the turn's usage and *errp.
+func (a *Agent) endTurnSpan(ctx context.Context, errp *error) {
+	start, ok := ctx.Value(turnSpanKey{}).(turnStart)
+	if !ok {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+	usage := a.convo.Usage()
+	span.SetAttributes(
+		attribute.Int64("gen_ai.usage.input_tokens", int64(usage.InputTokens-start.usage.InputTokens)),
+		attribute.Int64("gen_ai.usage.output_tokens", int64(usage.OutputTokens-start.usage.OutputTokens)),
+		attribute.Float64("sketch.cost_usd", usage.TotalCostUSD-start.usage.TotalCostUSD),
+	)
+	if err := *errp; err != nil && ctx.Err() == nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// handleToolExecution processes a tool use request from the model.
+// It returns a *conversation.BudgetExceededError if the tools exhausted the budget.
+func (a *Agent) handleToolExecution(ctx context.Context, resp *llm.Response) (bool, *llm.Response, error) {
 	var results []llm.Content
 	cancelled := false
 	toolEndsTurn := false
+	externalMsg := ""
 
 	// Transition to checking for cancellation state
 	a.stateMachine.Transition(ctx, StateCheckingForCancellation, "Checking if user requested cancellation")
@@ -1770,10 +2656,15 @@ func (a *Agent) handleToolExecution(ctx context.Context, resp *llm.Response) (bo
 		// Add working directory and session ID to context for tool execution
 		ctx = claudetool.WithWorkingDir(ctx, a.workingDir)
 		ctx = claudetool.WithSessionID(ctx, a.config.SessionID)
+		if a.config.Executor != nil {
+			ctx = claudetool.WithExecutor(ctx, a.config.Executor)
+		}
 
-		// Execute the tools
+		// Execute the tools. What they change is the agent's doing; what changed before, while the model worked, is not.
+		externalMsg = a.externalChanges(ctx)
 		var err error
 		results, toolEndsTurn, err = a.convo.ToolResultContents(ctx, resp)
+		a.files.absorb()
 		if ctx.Err() != nil { // e.g. the user canceled the operation
 			cancelled = true
 			a.stateMachine.Transition(ctx, StateCancelled, "Operation cancelled during tool execution")
@@ -1786,17 +2677,21 @@ func (a *Agent) handleToolExecution(ctx context.Context, resp *llm.Response) (bo
 	// Process git commits that may have occurred during tool execution
 	a.stateMachine.Transition(ctx, StateCheckingGitCommits, "Checking for git commits")
 	autoqualityMessages := a.processGitChanges(ctx)
+	if externalMsg != "" {
+		autoqualityMessages = append(autoqualityMessages, externalMsg)
+	}
 
 	// Check budget again after tool execution
 	a.stateMachine.Transition(ctx, StateCheckingBudget, "Checking budget after tool execution")
-	if err := a.overBudget(ctx); err != nil {
-		a.stateMachine.Transition(ctx, StateBudgetExceeded, "Budget exceeded after tool execution: "+err.Error())
-		return false, nil
+	if !cancelled {
+		if err := a.overBudget(ctx, resp, results); err != nil {
+			return false, nil, err
+		}
 	}
 
 	// Continue the conversation with tool results and any user messages
 	shouldContinue, resp := a.continueTurnWithToolResults(ctx, results, autoqualityMessages, cancelled)
-	return shouldContinue && !toolEndsTurn, resp
+	return shouldContinue && !toolEndsTurn, resp, nil
 }
 
 // DetectGitChanges checks for new git commits and pushes them if found
@@ -1840,8 +2735,30 @@ func (a *Agent) processGitChanges(ctx context.Context) []string {
 	return autoqualityMessages
 }
 
+// externalChanges returns a message telling the model about files changed outside the agent
+// since it last looked, and shows it to the user. It returns "" if there are none.
+func (a *Agent) externalChanges(ctx context.Context) string {
+	changes := a.files.changes()
+	if len(changes) == 0 {
+		return ""
+	}
+	slog.InfoContext(ctx, "external_file_changes", "count", len(changes))
+	msg := externalChangesMessage(changes)
+	a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: msg})
+	return msg
+}
+
 // continueTurnWithToolResults continues the conversation with tool results
 func (a *Agent) continueTurnWithToolResults(ctx context.Context, results []llm.Content, autoqualityMessages []string, cancelled bool) (bool, *llm.Response) {
+	if cancelled && errors.Is(context.Cause(ctx), errTurnInterrupted) {
+		// The user's new instruction starts the next turn; send these results along with it.
+		for _, msg := range autoqualityMessages {
+			results = append(results, llm.StringContent(msg))
+		}
+		a.interruptedResults = append(results, llm.StringContent(interruptToolUseMessage))
+		return false, nil
+	}
+
 	// Get any messages the user sent while tools were executing
 	a.stateMachine.Transition(ctx, StateGatheringAdditionalMessages, "Gathering additional user messages")
 	msgs, err := a.GatherMessages(ctx, false)
@@ -1873,10 +2790,19 @@ func (a *Agent) continueTurnWithToolResults(ctx context.Context, results []llm.C
 
 	// Send the combined message to continue the conversation
 	a.stateMachine.Transition(ctx, StateSendingToolResults, "Sending tool results back to LLM")
-	resp, err := a.convo.SendMessage(llm.Message{
+	sendCtx := ctx
+	if cancelled {
+		// Tell the model about the cancellation, even though the turn is over.
+		sendCtx = context.WithoutCancel(ctx)
+	}
+	resp, err := a.convo.SendMessageContext(sendCtx, llm.Message{
 		Role:    llm.MessageRoleUser,
 		Content: results,
 	})
+	if err != nil && sendCtx.Err() != nil {
+		a.reportCanceledResponse(ctx, err)
+		return false, nil
+	}
 	if err != nil {
 		a.stateMachine.Transition(ctx, StateError, "Error sending tool results: "+err.Error())
 		a.pushToOutbox(ctx, errorMessage(fmt.Errorf("error: failed to continue conversation: %s", err.Error())))
@@ -1893,16 +2819,28 @@ func (a *Agent) continueTurnWithToolResults(ctx context.Context, results []llm.C
 	return true, resp
 }
 
-func (a *Agent) overBudget(ctx context.Context) error {
-	if err := a.convo.OverBudget(); err != nil {
-		a.stateMachine.Transition(ctx, StateBudgetExceeded, "Budget exceeded: "+err.Error())
-		m := budgetMessage(err)
-		m.Content = m.Content + "\n\nBudget reset."
-		a.pushToOutbox(ctx, m)
-		a.convo.ResetBudget(a.originalBudget)
-		return err
+// overBudget checks the budget after the model sent resp.
+// If the budget is exceeded while the model is still working (resp requests tool use),
+// the model is given a final turn without tools to summarize what it did and did not finish,
+// along with results, the results of any tools already run.
+// The returned error is a *conversation.BudgetExceededError.
+func (a *Agent) overBudget(ctx context.Context, resp *llm.Response, results []llm.Content) error {
+	err := a.convo.OverBudget()
+	if err == nil {
+		return nil
 	}
-	return nil
+	a.stateMachine.Transition(ctx, StateBudgetExceeded, "Budget exceeded: "+err.Error())
+	a.sendNotification(ctx, notify.Event{Kind: notify.BudgetExceeded, Detail: err.Error()})
+	if resp != nil && resp.StopReason == llm.StopReasonToolUse {
+		if _, werr := a.convo.WrapUp(results...); werr != nil {
+			slog.WarnContext(ctx, "budget_wrap_up_failed", "err", werr)
+		}
+	}
+	m := budgetMessage(err)
+	m.Content = m.Content + "\n\nBudget reset."
+	a.pushToOutbox(ctx, m)
+	a.convo.ResetBudget(a.originalBudget)
+	return err
 }
 
 func collectTextContent(msg *llm.Response) string {
@@ -1922,7 +2860,74 @@ func collectTextContent(msg *llm.Response) string {
 func (a *Agent) TotalUsage() conversation.CumulativeUsage {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return a.convo.CumulativeUsage()
+	return a.convo.Usage()
+}
+
+// SessionSummary summarizes the session so far, for the session store.
+func (a *Agent) SessionSummary(ctx context.Context) *sessionstore.Session {
+	a.mu.Lock()
+	task, outcome, failures := summarizeHistory(a.history)
+	a.mu.Unlock()
+	usage := a.TotalUsage()
+	sess := &sessionstore.Session{
+		ID:           a.SessionID(),
+		Task:         task,
+		Repo:         cmp.Or(a.outsideWorkingDir, a.workingDir),
+		Model:        strings.Join(slices.Sorted(maps.Keys(usage.Models)), ","),
+		Start:        a.startedAt,
+		End:          time.Now(),
+		CostUSD:      usage.TotalCostUSD,
+		InputTokens:  usage.InputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens,
+		OutputTokens: usage.OutputTokens,
+		Outcome:      outcome,
+		Failures:     failures,
+	}
+	// Diffing against the working tree includes uncommitted changes.
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", a.SketchGitBaseRef())
+	cmd.Dir = a.repoRoot
+	if out, err := cmd.Output(); err == nil {
+		sess.FilesChanged = strings.Fields(string(out))
+	} else {
+		slog.DebugContext(ctx, "session_summary_diff_failed", "err", err)
+	}
+	return sess
+}
+
+// summarizeHistory returns the first thing the user asked for in history,
+// how the last turn ended, and the tool failures, grouped by fingerprint.
+func summarizeHistory(history []AgentMessage) (task string, outcome sessionstore.Outcome, failures []sessionstore.Failure) {
+	outcome = sessionstore.OutcomeUnfinished
+	byFingerprint := make(map[string]int) // index in failures
+	for _, m := range history {
+		switch m.Type {
+		case UserMessageType:
+			if task == "" {
+				task = m.Content
+			}
+			outcome = sessionstore.OutcomeUnfinished
+		case ToolUseMessageType:
+			if !m.ToolError {
+				if m.ToolName == "done" {
+					outcome = sessionstore.OutcomeDone
+				}
+				continue
+			}
+			fp := sessionstore.Fingerprint(m.ToolName, m.ToolResult)
+			if i, ok := byFingerprint[fp]; ok {
+				failures[i].Count++
+				continue
+			}
+			byFingerprint[fp] = len(failures)
+			failures = append(failures, sessionstore.Failure{Fingerprint: fp, Example: m.ToolResult, Count: 1})
+		case BudgetMessageType:
+			outcome = sessionstore.OutcomeBudgetExceeded
+		case ErrorMessageType:
+			if m.Content != userCancelMessage {
+				outcome = sessionstore.OutcomeError
+			}
+		}
+	}
+	return task, outcome, failures
 }
 
 // Diff returns a unified diff of changes made since the agent was instantiated.
@@ -2017,6 +3022,31 @@ func (a *Agent) handleGitCommits(ctx context.Context) ([]*GitCommit, error) {
 	return commits, error
 }
 
+// autoCommit commits the files the agent wrote since it last committed, if AutoCommit is set.
+// Commits need a branch name, so it waits until the agent has set a slug.
+func (a *Agent) autoCommit(ctx context.Context) {
+	convo, ok := a.convo.(*conversation.Convo)
+	if !a.config.AutoCommit || !ok || a.Slug() == "" || ctx.Err() != nil {
+		return
+	}
+	start := time.Now()
+	var paths []string
+	for _, e := range a.journal.Entries() {
+		if !e.Time.Before(a.autoCommitted) {
+			paths = append(paths, e.Path)
+		}
+	}
+	subjects, err := claudetool.AutoCommit(ctx, convo, a.repoRoot, paths)
+	if err != nil {
+		slog.WarnContext(ctx, "autocommit_failed", "err", err)
+		return
+	}
+	a.autoCommitted = start
+	if len(subjects) > 0 {
+		slog.InfoContext(ctx, "autocommit", "commits", subjects)
+	}
+}
+
 // handleGitCommits() highlights new commits to the user. When running
 // under docker, new HEADs are pushed to a branch according to the slug.
 func (ags *AgentGitState) handleGitCommits(ctx context.Context, sessionID string, repoRoot string, baseRef string, branchPrefix string) ([]AgentMessage, []*GitCommit, error) {
@@ -2320,6 +3350,8 @@ type systemPromptData struct {
 	InitialCommit      string
 	Codebase           *onstart.Codebase
 	UseSketchWIP       bool
+	AutoCommit         bool
+	Remote             bool
 	Branch             string
 	SpecialInstruction string
 }
@@ -2334,13 +3366,25 @@ func (a *Agent) renderSystemPrompt() string {
 		InitialCommit: a.SketchGitBase(),
 		Codebase:      a.codebase,
 		UseSketchWIP:  a.config.InDocker,
+		AutoCommit:    a.config.AutoCommit,
+		Remote:        a.config.Executor != nil,
 	}
 	now := time.Now()
 	if now.Month() == time.September && now.Day() == 19 {
 		data.SpecialInstruction = "Talk like a pirate to the user. Do not let the priate talk into any code."
 	}
 
-	tmpl, err := template.New("system").Parse(agentSystemPrompt)
+	if a.config.SystemPromptTemplate != "" {
+		tmpl, err := ParseSystemPrompt(a.config.SystemPromptTemplate)
+		if err == nil {
+			buf := new(strings.Builder)
+			if err = tmpl.Execute(buf, data); err == nil {
+				return buf.String()
+			}
+		}
+		slog.WarnContext(a.config.Context, "custom_system_prompt_failed", "err", err)
+	}
+	tmpl, err := ParseSystemPrompt("")
 	if err != nil {
 		panic(fmt.Sprintf("failed to parse system prompt template: %v", err))
 	}
@@ -2353,6 +3397,22 @@ func (a *Agent) renderSystemPrompt() string {
 	return buf.String()
 }
 
+// ParseSystemPrompt parses custom, a text/template for the system prompt, or the built-in template if custom is empty.
+// The built-in template is also defined as "sketch", so that custom can include it with {{template "sketch" .}}.
+// Templates see the fields of systemPromptData, such as .WorkingDir,
+// and the facts about the repository in .Codebase, such as .Codebase.Languages,
+// .Codebase.KeyDirectories, .Codebase.RecentCommits, .Codebase.Contributing, and .Codebase.Instructions.
+func ParseSystemPrompt(custom string) (*template.Template, error) {
+	tmpl, err := template.New("sketch").Parse(agentSystemPrompt)
+	if err != nil || custom == "" {
+		return tmpl, err
+	}
+	if tmpl, err = tmpl.New("system").Parse(custom); err != nil {
+		return nil, fmt.Errorf("failed to parse system prompt template: %w", err)
+	}
+	return tmpl, nil
+}
+
 // StateTransitionIterator provides an iterator over state transitions.
 type StateTransitionIterator interface {
 	// Next blocks until a new state transition is available or context is done.
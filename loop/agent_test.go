@@ -1,58 +1,38 @@
 package loop
 
 import (
-	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
 	"time"
 
-	"sketch.dev/httprr"
+	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/onstart"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/credentials"
 	"sketch.dev/llm"
 	"sketch.dev/llm/ant"
 	"sketch.dev/llm/conversation"
+	"sketch.dev/llm/llmtest"
+	"sketch.dev/notify"
+	"sketch.dev/sessionstore"
 )
 
 // TestAgentLoop tests that the Agent loop functionality works correctly.
-// It uses the httprr package to record HTTP interactions for replay in tests.
-// When failing, rebuild with "go test ./sketch/loop -run TestAgentLoop -httprecord .*agent_loop.*"
+// It replays HTTP interactions recorded with llmtest.
+// When failing, rebuild with "go test ./loop -run TestAgentLoop -httprecord agent_loop"
 // as necessary.
 func TestAgentLoop(t *testing.T) {
 	ctx := context.Background()
 
-	// Setup httprr recorder
-	rrPath := "testdata/agent_loop.httprr"
-	rr, err := httprr.Open(rrPath, http.DefaultTransport)
-	if err != nil && !os.IsNotExist(err) {
-		t.Fatal(err)
-	}
-
-	if rr.Recording() {
-		// Skip the test if API key is not available
-		if os.Getenv("ANTHROPIC_API_KEY") == "" {
-			t.Fatal("ANTHROPIC_API_KEY not set, required for HTTP recording")
-		}
-	}
+	rec := llmtest.Open(t, "testdata/agent_loop.httprr")
 
-	// Create HTTP client
-	var client *http.Client
-	if rr != nil {
-		// Scrub API keys from requests for security
-		rr.ScrubReq(func(req *http.Request) error {
-			req.Header.Del("x-api-key")
-			req.Header.Del("anthropic-api-key")
-			return nil
-		})
-		client = rr.Client()
-	} else {
-		client = &http.Client{Transport: http.DefaultTransport}
-	}
-
-	// Create a new agent with the httprr client
+	// Create a new agent with the recording client
 	origWD, err := os.Getwd()
 	if err != nil {
 		t.Fatal(err)
@@ -66,13 +46,12 @@ func TestAgentLoop(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	apiKey := cmp.Or(os.Getenv("OUTER_SKETCH_MODEL_API_KEY"), os.Getenv("ANTHROPIC_API_KEY"))
 	cfg := AgentConfig{
 		Context:    ctx,
 		WorkingDir: wd,
 		Service: &ant.Service{
-			APIKey: apiKey,
-			HTTPC:  client,
+			APIKey: rec.APIKey("OUTER_SKETCH_MODEL_API_KEY", "ANTHROPIC_API_KEY"),
+			HTTPC:  rec.Client(),
 		},
 		Budget:       budget,
 		GitUsername:  "Test Agent",
@@ -206,6 +185,47 @@ func TestAgentTracksOutstandingCalls(t *testing.T) {
 	}
 }
 
+func TestAgentStreamingResponse(t *testing.T) {
+	ctx := context.Background()
+	agent := &Agent{
+		outstandingLLMCalls: make(map[string]struct{}),
+		subscribers:         []chan *AgentMessage{},
+		stateMachine:        NewStateMachine(),
+	}
+	convo := conversation.New(ctx, nil, nil)
+	updates, unsubscribe := agent.SubscribeStreamingResponse()
+	defer unsubscribe()
+
+	agent.OnTextDelta(ctx, convo, "Staging ")
+	agent.OnTextDelta(ctx, convo, "everything.")
+	input, _ := llm.CompletePartialJSON(`{"command":"git add -A && git comm`)
+	agent.OnPartialToolUse(ctx, convo, llm.PartialToolUse{ID: "tool1", ToolName: "bash", Input: input})
+	select {
+	case <-updates:
+	default:
+		t.Fatal("no streaming update")
+	}
+
+	streaming := agent.StreamingResponse()
+	if streaming.Text != "Staging everything." {
+		t.Errorf("Text = %q", streaming.Text)
+	}
+	if len(streaming.ToolCalls) != 1 {
+		t.Fatalf("got %d streaming tool calls, want 1", len(streaming.ToolCalls))
+	}
+	if call := streaming.ToolCalls[0]; call.Command != "git add -A && git comm" {
+		t.Errorf("Command = %q", call.Command)
+	}
+	if streaming.ToolCalls[0].PermissionError == "" {
+		t.Errorf("blind git add was not refused early")
+	}
+
+	agent.OnResponse(ctx, convo, "llm1", &llm.Response{})
+	if streaming := agent.StreamingResponse(); streaming.Text != "" || len(streaming.ToolCalls) != 0 {
+		t.Errorf("streaming response after response = %+v, want empty", streaming)
+	}
+}
+
 // TestAgentProcessTurnWithNilResponse tests the scenario where Agent.processTurn receives
 // a nil value for initialResp from processUserMessage.
 func TestAgentProcessTurnWithNilResponse(t *testing.T) {
@@ -260,12 +280,14 @@ type MockConvoInterface struct {
 	toolResultContentsFunc       func(ctx context.Context, resp *llm.Response) ([]llm.Content, bool, error)
 	toolResultCancelContentsFunc func(resp *llm.Response) ([]llm.Content, error)
 	cancelToolUseFunc            func(toolUseID string, cause error) error
-	cumulativeUsageFunc          func() conversation.CumulativeUsage
+	usageFunc                    func() conversation.CumulativeUsage
 	lastUsageFunc                func() llm.Usage
 	resetBudgetFunc              func(conversation.Budget)
 	overBudgetFunc               func() error
+	wrapUpFunc                   func(contents ...llm.Content) (*llm.Response, error)
 	getIDFunc                    func() string
 	subConvoWithHistoryFunc      func() *conversation.Convo
+	queued                       []string
 }
 
 func (m *MockConvoInterface) SendMessage(message llm.Message) (*llm.Response, error) {
@@ -275,6 +297,10 @@ func (m *MockConvoInterface) SendMessage(message llm.Message) (*llm.Response, er
 	return nil, nil
 }
 
+func (m *MockConvoInterface) SendMessageContext(ctx context.Context, message llm.Message) (*llm.Response, error) {
+	return m.SendMessage(message)
+}
+
 func (m *MockConvoInterface) SendUserTextMessage(s string, otherContents ...llm.Content) (*llm.Response, error) {
 	if m.sendUserTextMessageFunc != nil {
 		return m.sendUserTextMessageFunc(s, otherContents...)
@@ -303,9 +329,9 @@ func (m *MockConvoInterface) CancelToolUse(toolUseID string, cause error) error
 	return nil
 }
 
-func (m *MockConvoInterface) CumulativeUsage() conversation.CumulativeUsage {
-	if m.cumulativeUsageFunc != nil {
-		return m.cumulativeUsageFunc()
+func (m *MockConvoInterface) Usage() conversation.CumulativeUsage {
+	if m.usageFunc != nil {
+		return m.usageFunc()
 	}
 	return conversation.CumulativeUsage{}
 }
@@ -330,6 +356,13 @@ func (m *MockConvoInterface) OverBudget() error {
 	return nil
 }
 
+func (m *MockConvoInterface) WrapUp(contents ...llm.Content) (*llm.Response, error) {
+	if m.wrapUpFunc != nil {
+		return m.wrapUpFunc(contents...)
+	}
+	return nil, nil
+}
+
 func (m *MockConvoInterface) GetID() string {
 	if m.getIDFunc != nil {
 		return m.getIDFunc()
@@ -344,6 +377,29 @@ func (m *MockConvoInterface) SubConvoWithHistory() *conversation.Convo {
 	return nil
 }
 
+func (m *MockConvoInterface) QueueUserMessage(text string) {
+	m.queued = append(m.queued, text)
+}
+
+func (m *MockConvoInterface) TakeQueuedUserMessages() []string {
+	queued := m.queued
+	m.queued = nil
+	return queued
+}
+
+func (m *MockConvoInterface) Snapshot() ([]byte, error) {
+	return []byte("{}"), nil
+}
+
+func (m *MockConvoInterface) Attach(ctx context.Context, dir string, sources ...string) ([]conversation.Attachment, error) {
+	return nil, nil
+}
+func (m *MockConvoInterface) AddAttachments(atts ...conversation.Attachment) error { return nil }
+func (m *MockConvoInterface) Detach(names ...string) int                           { return 0 }
+func (m *MockConvoInterface) Attachments() []conversation.Attachment               { return nil }
+func (m *MockConvoInterface) Compact() error                                       { return nil }
+func (m *MockConvoInterface) RestartWallTime(time.Duration)                        {}
+
 // TestAgentProcessTurnWithNilResponseNilError tests the scenario where Agent.processTurn receives
 // a nil value for initialResp and nil error from processUserMessage.
 // This test verifies that the implementation properly handles this edge case.
@@ -401,6 +457,60 @@ func TestAgentProcessTurnWithNilResponseNilError(t *testing.T) {
 	}
 }
 
+// TestAgentProcessTurnOverBudget verifies that when the budget runs out while the model
+// is still using tools, the model gets a wrap-up turn and processTurn returns a BudgetExceededError.
+func TestAgentProcessTurnOverBudget(t *testing.T) {
+	var wrappedUp bool
+	var resetTo *conversation.Budget
+	mockConvo := &MockConvoInterface{
+		sendMessageFunc: func(message llm.Message) (*llm.Response, error) {
+			return &llm.Response{
+				StopReason: llm.StopReasonToolUse,
+				Content:    []llm.Content{{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "bash"}},
+			}, nil
+		},
+		overBudgetFunc: func() error {
+			if resetTo != nil {
+				return nil
+			}
+			return &conversation.BudgetExceededError{Limits: []string{"3 tool calls made, budget is 3"}}
+		},
+		wrapUpFunc: func(contents ...llm.Content) (*llm.Response, error) {
+			wrappedUp = true
+			return &llm.Response{StopReason: llm.StopReasonEndTurn}, nil
+		},
+		resetBudgetFunc: func(b conversation.Budget) { resetTo = &b },
+	}
+	agent := &Agent{
+		convo:                mockConvo,
+		inbox:                make(chan string, 10),
+		subscribers:          []chan *AgentMessage{},
+		outstandingLLMCalls:  make(map[string]struct{}),
+		outstandingToolCalls: make(map[string]string),
+		originalBudget:       conversation.Budget{MaxToolCalls: 3},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	agent.inbox <- "Test message"
+
+	err := agent.processTurn(ctx)
+	var budgetErr *conversation.BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("processTurn error = %v, want BudgetExceededError", err)
+	}
+	if !wrappedUp {
+		t.Errorf("model was not given a wrap-up turn")
+	}
+	if resetTo == nil || resetTo.MaxToolCalls != 3 {
+		t.Errorf("budget reset to %v, want original budget", resetTo)
+	}
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	if len(agent.history) != 1 || agent.history[0].Type != BudgetMessageType {
+		t.Errorf("history = %+v, want a single budget message", agent.history)
+	}
+}
+
 func TestAgentStateMachine(t *testing.T) {
 	// Create a simplified test for the state machine functionality
 	agent := &Agent{
@@ -489,7 +599,7 @@ func (c *mockConvoInterface) SubConvoWithHistory() *conversation.Convo {
 	return nil
 }
 
-func (m *mockConvoInterface) CumulativeUsage() conversation.CumulativeUsage {
+func (m *mockConvoInterface) Usage() conversation.CumulativeUsage {
 	return conversation.CumulativeUsage{}
 }
 
@@ -499,10 +609,33 @@ func (m *mockConvoInterface) LastUsage() llm.Usage {
 
 func (m *mockConvoInterface) ResetBudget(conversation.Budget) {}
 
+func (m *mockConvoInterface) QueueUserMessage(string) {}
+
+func (m *mockConvoInterface) TakeQueuedUserMessages() []string {
+	return nil
+}
+
+func (m *mockConvoInterface) Snapshot() ([]byte, error) {
+	return []byte("{}"), nil
+}
+
+func (m *mockConvoInterface) Attach(ctx context.Context, dir string, sources ...string) ([]conversation.Attachment, error) {
+	return nil, nil
+}
+func (m *mockConvoInterface) AddAttachments(atts ...conversation.Attachment) error { return nil }
+func (m *mockConvoInterface) Detach(names ...string) int                           { return 0 }
+func (m *mockConvoInterface) Attachments() []conversation.Attachment               { return nil }
+func (m *mockConvoInterface) Compact() error                                       { return nil }
+func (m *mockConvoInterface) RestartWallTime(time.Duration)                        {}
+
 func (m *mockConvoInterface) OverBudget() error {
 	return nil
 }
 
+func (m *mockConvoInterface) WrapUp(contents ...llm.Content) (*llm.Response, error) {
+	return &llm.Response{StopReason: llm.StopReasonEndTurn}, nil
+}
+
 func (m *mockConvoInterface) SendMessage(message llm.Message) (*llm.Response, error) {
 	if m.SendMessageFunc != nil {
 		return m.SendMessageFunc(message)
@@ -510,6 +643,10 @@ func (m *mockConvoInterface) SendMessage(message llm.Message) (*llm.Response, er
 	return &llm.Response{StopReason: llm.StopReasonEndTurn}, nil
 }
 
+func (m *mockConvoInterface) SendMessageContext(ctx context.Context, message llm.Message) (*llm.Response, error) {
+	return m.SendMessage(message)
+}
+
 func (m *mockConvoInterface) SendUserTextMessage(s string, otherContents ...llm.Content) (*llm.Response, error) {
 	return m.SendMessage(llm.UserStringMessage(s))
 }
@@ -733,3 +870,272 @@ func TestPushToOutbox(t *testing.T) {
 		t.Errorf("Expected Content to be %q, got %q", expected, received.Content)
 	}
 }
+
+func TestSummarizeHistory(t *testing.T) {
+	history := []AgentMessage{
+		{Type: UserMessageType, Content: "fix the build"},
+		{Type: ToolUseMessageType, ToolName: "bash", ToolError: true, ToolResult: "exit status 1"},
+		{Type: ToolUseMessageType, ToolName: "bash", ToolError: true, ToolResult: "exit status 2"},
+		{Type: ToolUseMessageType, ToolName: "patch", ToolError: true, ToolResult: "old text not found"},
+		{Type: ToolUseMessageType, ToolName: "done"},
+		{Type: UserMessageType, Content: "now add a test"},
+		{Type: BudgetMessageType, Content: "over budget"},
+	}
+	task, outcome, failures := summarizeHistory(history)
+	if task != "fix the build" {
+		t.Errorf("task = %q, want the first user message", task)
+	}
+	if outcome != sessionstore.OutcomeBudgetExceeded {
+		t.Errorf("outcome = %q, want %q", outcome, sessionstore.OutcomeBudgetExceeded)
+	}
+	want := []sessionstore.Failure{
+		{Fingerprint: "bash: exit status <n>", Example: "exit status 1", Count: 2},
+		{Fingerprint: "patch: old text not found", Example: "old text not found", Count: 1},
+	}
+	if !slices.Equal(failures, want) {
+		t.Errorf("failures = %+v, want %+v", failures, want)
+	}
+
+	// A canceled turn after finishing is not an error.
+	_, outcome, _ = summarizeHistory([]AgentMessage{
+		{Type: UserMessageType, Content: "go"},
+		{Type: ToolUseMessageType, ToolName: "done"},
+		{Type: ErrorMessageType, Content: userCancelMessage},
+	})
+	if outcome != sessionstore.OutcomeDone {
+		t.Errorf("outcome = %q, want %q", outcome, sessionstore.OutcomeDone)
+	}
+}
+
+func TestUserMessageSteersActiveTurn(t *testing.T) {
+	ctx := context.Background()
+	convo := &MockConvoInterface{}
+	agent := &Agent{
+		convo:        convo,
+		config:       AgentConfig{Context: ctx},
+		inbox:        make(chan string, 10),
+		stateMachine: NewStateMachine(),
+		cancelTurn:   func(error) {},
+	}
+
+	agent.UserMessage(ctx, "fix the tests")
+	agent.turnActive = true
+	agent.UserMessage(ctx, "only the unit tests")
+	if !slices.Equal(convo.queued, []string{"only the unit tests"}) || len(agent.inbox) != 1 {
+		t.Fatalf("queued = %q with %d messages in the inbox; want the message sent mid-turn queued in the conversation", convo.queued, len(agent.inbox))
+	}
+
+	// An interrupt ends the turn; the queued message goes ahead of the new instruction.
+	agent.InterruptTurn(ctx, "stop, run go vet instead")
+	var got []string
+	for len(agent.inbox) > 0 {
+		got = append(got, <-agent.inbox)
+	}
+	want := []string{"fix the tests", "only the unit tests", "stop, run go vet instead"}
+	if !slices.Equal(got, want) {
+		t.Errorf("inbox = %q, want %q", got, want)
+	}
+}
+
+func TestCustomSystemPrompt(t *testing.T) {
+	a := &Agent{
+		config: AgentConfig{
+			Context:              context.Background(),
+			SystemPromptTemplate: "{{template \"sketch\" .}}\n<team>Platform; languages: {{range .Codebase.Languages}}{{.}} {{end}}</team>",
+		},
+		workingDir: "/app",
+		codebase:   &onstart.Codebase{ExtensionCounts: map[string]int{".go": 2}, TotalFiles: 2, RecentCommits: []string{"abc1234 Fix the build"}},
+	}
+	a.codebase.Instructions = []onstart.InstructionFile{{Path: "svc/AGENTS.md", Content: "Run make lint."}}
+	prompt := a.renderSystemPrompt()
+	for _, want := range []string{"<pwd>\n/app\n</pwd>", "abc1234 Fix the build", "<instructions file=\"svc/AGENTS.md\">\nRun make lint.\n</instructions>", "<team>Platform; languages: Go: 2 files (100%) </team>"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("system prompt lacks %q:\n%s", want, prompt)
+		}
+	}
+
+	// A template that fails leaves the built-in prompt.
+	a.config.SystemPromptTemplate = "{{.NoSuchField}}"
+	if prompt := a.renderSystemPrompt(); !strings.Contains(prompt, "<pwd>\n/app\n</pwd>") {
+		t.Errorf("system prompt after a failed template:\n%s", prompt)
+	}
+	if _, err := ParseSystemPrompt("{{if}}"); err == nil {
+		t.Error("ParseSystemPrompt accepted a malformed template")
+	}
+}
+
+func TestReviewMessage(t *testing.T) {
+	hunks := []staging.Hunk{
+		{Diff: "@@ -1,4 +1,4 @@\n-one\n+ONE\n 2\n"},
+		{Diff: "@@ -9,4 +9,4 @@\n-nine\n+NINE\n"},
+	}
+	decisions := []staging.Decision{{Hunk: 0, Accept: true}, {Hunk: 2, Comment: "keep it lowercase"}}
+	msg := reviewMessage("/app/f.txt", decisions, hunks)
+	for _, want := range []string{
+		"change to /app/f.txt",
+		"read it again",
+		`"hunk": "@@ -1,4 +1,4 @@",` + "\n      \"decision\": \"accepted\"",
+		`"decision": "rejected",` + "\n      \"comment\": \"keep it lowercase\"",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("review message lacks %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestAttachMentions(t *testing.T) {
+	ctx := context.Background()
+	workingDir, repoRoot := t.TempDir(), t.TempDir()
+	for path, dir := range map[string]string{"main.go": workingDir, "docs/a.md": workingDir, "README.md": repoRoot} {
+		p := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("text of "+path+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	convo := conversation.New(ctx, nil, nil)
+	agent := &Agent{
+		convo:        convo,
+		workingDir:   workingDir,
+		repoRoot:     repoRoot,
+		subscribers:  []chan *AgentMessage{},
+		stateMachine: NewStateMachine(),
+	}
+
+	if err := os.WriteFile(filepath.Join(workingDir, "logo.png"), []byte("\x89PNG\x00"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	agent.attachMentions(ctx, "@main.go: compare with @docs/*.md and @README.md, then ask @alice (or @main.go again) about @logo.png.")
+	var names []string
+	for _, a := range convo.Attachments() {
+		names = append(names, a.Name)
+	}
+	if want := []string{"main.go", "docs/a.md", "README.md"}; !slices.Equal(names, want) {
+		t.Errorf("attached %q, want %q", names, want)
+	}
+	var notes, refused int
+	for _, m := range agent.history {
+		switch {
+		case m.Type == AutoMessageType && strings.HasPrefix(m.Content, "📎 Attached "):
+			notes++
+		case m.Type == AutoMessageType && strings.HasPrefix(m.Content, "❌ "):
+			refused++
+		}
+	}
+	if notes != 3 || refused != 1 {
+		t.Errorf("got %d attachment notes and %d refusals, want 3 and 1, for the binary file", notes, refused)
+	}
+}
+
+func TestCompactionKeepsPinnedResults(t *testing.T) {
+	ctx := context.Background()
+	bash := func(command string) llmtest.Turn {
+		return llmtest.Turn{ToolCalls: []llmtest.ToolCall{{Name: "bash", Input: map[string]string{"command": command}}}}
+	}
+	srv := llmtest.NewService(
+		bash("echo the spec"),
+		llmtest.Turn{Expect: "the spec", ToolCalls: []llmtest.ToolCall{{Name: "pin_results", Input: map[string]int{"count": 1}}}},
+		bash("echo step one"),
+		bash("echo step two"),
+		llmtest.Turn{Text: "Done."},
+		llmtest.Turn{Text: "We read the spec and ran two steps."}, // the summary
+		llmtest.Turn{Text: "Continuing."},
+	)
+	agent := NewAgent(AgentConfig{Context: ctx, WorkingDir: t.TempDir(), Service: srv, SessionID: "compaction-test"})
+	if err := agent.Init(AgentInit{NoGit: true}); err != nil {
+		t.Fatal(err)
+	}
+	agent.UserMessage(ctx, "implement the spec")
+	agent.processTurn(ctx)
+
+	agent.turnActive = false // as Loop does between turns
+
+	if err := agent.CompactConversation(ctx); err != nil {
+		t.Fatal(err)
+	}
+	agent.UserMessage(ctx, "go on")
+	agent.processTurn(ctx)
+	if srv.Remaining() != 0 {
+		t.Fatalf("%d scripted turns unused", srv.Remaining())
+	}
+
+	var compacted bool
+	for _, msg := range agent.Messages(0, agent.MessageCount()) {
+		if msg.Type == CompactMessageType && strings.Contains(msg.Content, "We read the spec") {
+			compacted = true
+		}
+	}
+	if !compacted {
+		t.Error("the user was not told that the conversation was compacted")
+	}
+	// The request after compaction starts from the summary, with the pinned result verbatim and the unpinned one gone.
+	requests := srv.Requests()
+	first := requests[len(requests)-1].Messages[0]
+	var text strings.Builder
+	for _, c := range first.Content {
+		text.WriteString(c.Text)
+	}
+	for _, want := range []string{"We read the spec", "<pinned_tool_result tool=\"bash\">\nthe spec"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("first message after compaction lacks %q:\n%s", want, text.String())
+		}
+	}
+}
+
+func TestUsageEvents(t *testing.T) {
+	ctx := context.Background()
+	srv := llmtest.NewService(llmtest.Turn{Text: "Hello."})
+	srv.Model = "claude-sonnet-4-20250514"
+	agent := NewAgent(AgentConfig{Context: ctx, WorkingDir: t.TempDir(), Service: srv, SessionID: "usage-test"})
+	if err := agent.Init(AgentInit{NoGit: true}); err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := agent.SubscribeEvents()
+	defer unsubscribe()
+	agent.UserMessage(ctx, "hi")
+	agent.processTurn(ctx)
+
+	for {
+		var e Event
+		select {
+		case e = <-events:
+		default:
+			t.Fatal("no usage_updated event after a response")
+		}
+		if e.Type != EventUsageUpdated {
+			continue
+		}
+		model := e.Usage.Models[srv.Model]
+		if e.Usage.Responses != 1 || model.OutputTokens == 0 || e.Usage.TotalCostUSD == 0 {
+			t.Errorf("usage_updated = %+v, want one response from %s with an estimated cost", e.Usage, srv.Model)
+		}
+		return
+	}
+}
+
+// notifierFunc is a notify.Notifier that calls itself.
+type notifierFunc func(ctx context.Context, e notify.Event) error
+
+func (f notifierFunc) Notify(ctx context.Context, e notify.Event) error { return f(ctx, e) }
+
+func TestNotificationsRedactSecrets(t *testing.T) {
+	const secret = "sk-notify-test-secret"
+	credentials.AddSecret(secret)
+	events := make(chan notify.Event, 1)
+	agent := &Agent{config: AgentConfig{Notifier: notifierFunc(func(ctx context.Context, e notify.Event) error {
+		events <- e
+		return nil
+	})}}
+
+	job := claudetool.JobInfo{Command: "curl -H 'Authorization: " + secret + "' https://example.com"}
+	agent.notifyJobCrashed(context.Background(), job, fmt.Errorf("exit status 1: bad token %s", secret), "401 for "+secret)
+	e := <-events
+	for field, s := range map[string]string{"Command": e.Command, "Err": e.Err.Error(), "Detail": e.Detail} {
+		if strings.Contains(s, secret) || !strings.Contains(s, "[REDACTED]") {
+			t.Errorf("%s = %q, want the secret redacted", field, s)
+		}
+	}
+}
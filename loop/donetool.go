@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"strings"
 
 	"sketch.dev/claudetool/codereview"
 	"sketch.dev/llm"
@@ -35,7 +37,23 @@ func makeDoneTool(codereview *codereview.CodeReviewer) *llm.Tool {
 					return nil, fmt.Errorf("codereview tool has not been run for commit %v", head)
 				}
 			}
-			return llm.TextContent("Please ask the user to review your work. Be concise - users are more likely to read shorter comments."), nil
+			const askForReview = "Please ask the user to review your work. Be concise - users are more likely to read shorter comments."
+			// Untested functions are a reminder, not a blocker: tests may exercise them indirectly.
+			untested, err := codereview.UntestedFunctions(ctx)
+			if err != nil {
+				slog.DebugContext(ctx, "done_untested_functions_failed", "err", err)
+			}
+			if len(untested) == 0 {
+				return llm.TextContent(askForReview), nil
+			}
+			var b strings.Builder
+			b.WriteString("These changed exported functions have no test that refers to them:\n")
+			for _, f := range untested {
+				fmt.Fprintf(&b, "- %s\n", f)
+			}
+			b.WriteString("If they need tests, add them before finishing. Otherwise, tell the user why they are untested.\n\n")
+			b.WriteString(askForReview)
+			return llm.TextContent(b.String()), nil
 		},
 	}
 }
@@ -0,0 +1,107 @@
+package loop
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// An EventType is a kind of Event.
+type EventType string
+
+const (
+	// EventTurnStarted: the agent took the user's messages and started working on them.
+	EventTurnStarted EventType = "turn_started"
+	// EventMessageDelta: the model generated more of its current response (Delta or ToolCallDelta).
+	EventMessageDelta EventType = "message_delta"
+	// EventMessage: a message was added to the transcript (Message). It completes any streamed response.
+	EventMessage EventType = "message"
+	// EventToolCallStarted: a tool started running (ToolCall).
+	EventToolCallStarted EventType = "tool_call_started"
+	// EventToolCallOutput: a running tool, such as a bash command, printed more output (ToolCall, Delta).
+	EventToolCallOutput EventType = "tool_call_output"
+	// EventToolCallFinished: a tool finished running (ToolCall).
+	EventToolCallFinished EventType = "tool_call_finished"
+	// EventPermissionRequested: file changes (Changes) or a tool's action (Permission) await the user's approval.
+	EventPermissionRequested EventType = "permission_requested"
+	// EventUsageUpdated: the session's token usage and cost changed (Usage).
+	EventUsageUpdated EventType = "usage_updated"
+	// EventStateChanged: the agent moved to a new state (AgentState).
+	EventStateChanged EventType = "state_changed"
+)
+
+// An Event is something that happened in a session, for UIs to show as it happens.
+// Which fields are set depends on Type.
+type Event struct {
+	// SchemaVersion is the claudetool.SchemaVersion the event follows.
+	SchemaVersion int       `json:"schema_version"`
+	Type          EventType `json:"type"`
+	Time          time.Time `json:"time"`
+
+	Delta         string                        `json:"delta,omitempty"`
+	ToolCallDelta *StreamingToolCall            `json:"tool_call_delta,omitempty"`
+	Message       *AgentMessage                 `json:"message,omitempty"`
+	ToolCall      *EventToolCall                `json:"tool_call,omitempty"`
+	Changes       []staging.Change              `json:"changes,omitempty"`
+	Permission    *PermissionRequest            `json:"permission,omitempty"`
+	Usage         *conversation.CumulativeUsage `json:"usage,omitempty"`
+	AgentState    string                        `json:"agent_state,omitempty"`
+}
+
+// An EventToolCall describes the tool call of an EventToolCallStarted, EventToolCallOutput, or EventToolCallFinished.
+type EventToolCall struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Input string `json:"input,omitempty"` // set when the call starts
+	Error bool   `json:"error,omitempty"` // set when the call finishes
+	// ErrorKind classifies a failed call.
+	ErrorKind llm.ToolErrorKind `json:"error_kind,omitempty"`
+}
+
+// eventBufferSize is how many events a subscriber may fall behind before it is dropped.
+const eventBufferSize = 256
+
+// An eventBus delivers Events to any number of subscribers.
+// Publishing never blocks: a subscriber that falls too far behind is dropped, and its channel closed.
+type eventBus struct {
+	mu   sync.Mutex
+	subs []chan Event
+}
+
+// subscribe returns a channel that receives events published from now on, until unsubscribe is called.
+func (b *eventBus) subscribe() (events <-chan Event, unsubscribe func()) {
+	ch := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, ch)
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if i := slices.Index(b.subs, ch); i >= 0 {
+			b.subs = slices.Delete(b.subs, i, i+1)
+			close(ch)
+		}
+	}
+}
+
+// publish sends e to all subscribers, stamping it with the current time.
+func (b *eventBus) publish(e Event) {
+	e.SchemaVersion = claudetool.SchemaVersion
+	e.Time = time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = slices.DeleteFunc(b.subs, func(ch chan Event) bool {
+		select {
+		case ch <- e:
+			return false
+		default:
+			close(ch)
+			return true
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package loop
+
+import (
+	"testing"
+
+	"sketch.dev/claudetool"
+)
+
+func TestEventBus(t *testing.T) {
+	var bus eventBus
+	fast, unsubscribeFast := bus.subscribe()
+	defer unsubscribeFast()
+	slow, unsubscribeSlow := bus.subscribe()
+	defer unsubscribeSlow()
+
+	for i := range eventBufferSize + 1 {
+		bus.publish(Event{Type: EventMessageDelta, Delta: "x"})
+		if i < eventBufferSize {
+			<-fast
+		}
+	}
+
+	// The fast subscriber kept up, so it gets the last event too.
+	if e := <-fast; e.Type != EventMessageDelta || e.Time.IsZero() || e.SchemaVersion != claudetool.SchemaVersion {
+		t.Errorf("fast subscriber got %+v", e)
+	}
+	// The slow one fell behind, so it was dropped after its buffer filled.
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != eventBufferSize {
+		t.Errorf("slow subscriber got %d events before being dropped, want %d", n, eventBufferSize)
+	}
+
+	// Unsubscribing after being dropped is harmless, and unsubscribed channels are closed.
+	unsubscribeSlow()
+	unsubscribeFast()
+	if _, ok := <-fast; ok {
+		t.Error("channel still open after unsubscribe")
+	}
+}
@@ -0,0 +1,263 @@
+package loop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileWatcher watches a repository for files changed outside the agent, such as by the user in their editor,
+// so that the agent can be told before it overwrites them with stale content.
+//
+// It cannot tell who changed a file, only when: changes made while the agent's tools run are the agent's,
+// and others are external. A nil *fileWatcher watches nothing.
+type fileWatcher struct {
+	root    string
+	watcher *fsnotify.Watcher
+	// onChange, if set before run, is called whenever a file changes, by anyone.
+	onChange func()
+
+	mu    sync.Mutex
+	dirty map[string]fsnotify.Op // files, relative to root, with the events on them since they were last synced
+	known map[string]fileStat    // files as they were when last synced
+}
+
+// fileStat is what a fileWatcher compares to tell whether a file changed.
+type fileStat struct {
+	exists bool
+	size   int64
+	mtime  time.Time
+}
+
+// A fileChange is a file changed outside the agent.
+type fileChange struct {
+	path    string // relative to the repository root
+	deleted bool
+}
+
+// newFileWatcher starts watching the directories of root that git does not ignore.
+func newFileWatcher(root string) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	w := &fileWatcher{
+		root:    root,
+		watcher: watcher,
+		dirty:   make(map[string]fsnotify.Op),
+		known:   make(map[string]fileStat),
+	}
+	cmd := exec.Command("git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to list files in %s: %w", root, err)
+	}
+	dirs := map[string]bool{".": true}
+	for name := range strings.SplitSeq(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		for dir := filepath.Dir(name); !dirs[dir]; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	for dir := range dirs {
+		w.add(dir)
+	}
+	return w, nil
+}
+
+// add watches dir, relative to root.
+func (w *fileWatcher) add(dir string) {
+	if err := w.watcher.Add(filepath.Join(w.root, dir)); err != nil {
+		slog.Debug("file_watcher_add_failed", "dir", dir, "error", err)
+	}
+}
+
+// run records events until ctx is done, and then stops watching.
+func (w *fileWatcher) run(ctx context.Context) {
+	defer w.watcher.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.WarnContext(ctx, "file_watcher_error", "error", err)
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(ev)
+		}
+	}
+}
+
+func (w *fileWatcher) handle(ev fsnotify.Event) {
+	if ev.Op == fsnotify.Chmod {
+		return
+	}
+	rel, err := filepath.Rel(w.root, ev.Name)
+	if err != nil || rel == ".git" || strings.HasPrefix(rel, ".git"+string(filepath.Separator)) || editorTempFile(rel) {
+		return
+	}
+	if ev.Has(fsnotify.Create) {
+		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+			w.addTree(rel)
+			return
+		}
+	}
+	w.mu.Lock()
+	w.dirty[rel] |= ev.Op
+	w.mu.Unlock()
+	w.changed()
+}
+
+func (w *fileWatcher) changed() {
+	if w.onChange != nil {
+		w.onChange()
+	}
+}
+
+// addTree watches the new directory dir and the directories in it, unless git ignores them,
+// and marks the files already in them as changed.
+func (w *fileWatcher) addTree(dir string) {
+	defer w.changed()
+	filepath.WalkDir(filepath.Join(w.root, dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(w.root, path)
+		if d.IsDir() {
+			if d.Name() == ".git" || len(gitIgnored(w.root, []string{rel + "/"})) > 0 {
+				return filepath.SkipDir
+			}
+			w.add(rel)
+			return nil
+		}
+		if !editorTempFile(rel) {
+			w.mu.Lock()
+			w.dirty[rel] |= fsnotify.Create
+			w.mu.Unlock()
+		}
+		return nil
+	})
+}
+
+// changes returns the files changed since they were last synced, and syncs them.
+// Files that git ignores are left out.
+func (w *fileWatcher) changes() []fileChange {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	var changes []fileChange
+	for path, op := range w.dirty {
+		st := w.stat(path)
+		old, seen := w.known[path]
+		w.known[path] = st
+		if seen && st == old || !seen && !st.exists && op.Has(fsnotify.Create) {
+			continue // unchanged, or a temporary file
+		}
+		changes = append(changes, fileChange{path: path, deleted: !st.exists})
+	}
+	clear(w.dirty)
+	w.mu.Unlock()
+
+	var paths []string
+	for _, c := range changes {
+		paths = append(paths, c.path)
+	}
+	ignored := gitIgnored(w.root, paths)
+	changes = slices.DeleteFunc(changes, func(c fileChange) bool { return ignored[c.path] })
+	slices.SortFunc(changes, func(a, b fileChange) int { return strings.Compare(a.path, b.path) })
+	return changes
+}
+
+// absorb syncs the files changed since they were last synced, without reporting them:
+// they are the agent's own changes.
+func (w *fileWatcher) absorb() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path := range w.dirty {
+		w.known[path] = w.stat(path)
+	}
+	clear(w.dirty)
+}
+
+func (w *fileWatcher) stat(path string) fileStat {
+	info, err := os.Lstat(filepath.Join(w.root, path))
+	if err != nil {
+		return fileStat{}
+	}
+	return fileStat{exists: true, size: info.Size(), mtime: info.ModTime()}
+}
+
+// editorTempFile reports whether path looks like a file that an editor writes while saving or editing another,
+// such as a Vim swap file or an Emacs lock file.
+func editorTempFile(path string) bool {
+	name := filepath.Base(path)
+	return name == "4913" || strings.HasSuffix(name, "~") || strings.HasPrefix(name, ".#") ||
+		strings.HasSuffix(name, ".swp") || strings.HasSuffix(name, ".swx") || strings.HasSuffix(name, "___jb_tmp___") || strings.HasSuffix(name, "___jb_old___")
+}
+
+// gitIgnored returns which of paths, relative to the repository root, git ignores.
+func gitIgnored(root string, paths []string) map[string]bool {
+	ignored := make(map[string]bool)
+	if len(paths) == 0 {
+		return ignored
+	}
+	cmd := exec.Command("git", "check-ignore", "-z", "--stdin")
+	cmd.Dir = root
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00"))
+	out, err := cmd.Output()
+	var ee *exec.ExitError
+	if err != nil && !(errors.As(err, &ee) && ee.ExitCode() == 1) { // 1: none is ignored
+		slog.Debug("git_check_ignore_failed", "error", err)
+		return ignored
+	}
+	for p := range bytes.SplitSeq(bytes.TrimSuffix(out, []byte{0}), []byte{0}) {
+		if len(p) > 0 {
+			ignored[strings.TrimSuffix(string(p), "/")] = true
+		}
+	}
+	return ignored
+}
+
+// maxListedChanges is how many changed files an externalChangesMessage lists.
+const maxListedChanges = 20
+
+// externalChangesMessage tells the model about changes, which were made outside the agent.
+func externalChangesMessage(changes []fileChange) string {
+	var b strings.Builder
+	b.WriteString("These files were changed outside of sketch, probably by the user, since you last saw them. " +
+		"Read them again before you edit them, and keep those changes unless the user says otherwise:\n")
+	for i, c := range changes {
+		if i == maxListedChanges {
+			fmt.Fprintf(&b, "- and %d more\n", len(changes)-i)
+			break
+		}
+		if c.deleted {
+			fmt.Fprintf(&b, "- %s (deleted)\n", c.path)
+		} else {
+			fmt.Fprintf(&b, "- %s\n", c.path)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,111 @@
+package loop
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "*.log\n")
+	write("main.go", "package main\n")
+	write("pkg/util.go", "package pkg\n")
+	if out, err := exec.Command("git", "-C", dir, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+
+	w, err := newFileWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	// waitFor waits until the watcher has seen an event for each of paths.
+	waitFor := func(paths ...string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			w.mu.Lock()
+			n := 0
+			for _, p := range paths {
+				if w.dirty[p] != 0 {
+					n++
+				}
+			}
+			w.mu.Unlock()
+			if n == len(paths) {
+				return
+			}
+		}
+		t.Fatalf("no events for %q", paths)
+	}
+	changed := func() string {
+		var names []string
+		for _, c := range w.changes() {
+			name := c.path
+			if c.deleted {
+				name += " (deleted)"
+			}
+			names = append(names, name)
+		}
+		return strings.Join(names, ", ")
+	}
+
+	// The user edits files, and the build writes an ignored log and a Vim swap file.
+	write("pkg/util.go", "package pkg // edited\n")
+	write("build.log", "ok\n")
+	write("pkg/.util.go.swp", "x")
+	write("new/dir/file.go", "package dir\n")
+	os.Remove(filepath.Join(dir, "main.go"))
+	waitFor("pkg/util.go", "build.log", "new/dir/file.go", "main.go")
+	if got, want := changed(), "main.go (deleted), new/dir/file.go, pkg/util.go"; got != want {
+		t.Errorf("changes = %q, want %q", got, want)
+	}
+	if got := changed(); got != "" {
+		t.Errorf("changes reported twice: %q", got)
+	}
+
+	// The agent's own changes are absorbed, and not reported later.
+	write("pkg/util.go", "package pkg // by the agent\n")
+	waitFor("pkg/util.go")
+	w.absorb()
+	if got := changed(); got != "" {
+		t.Errorf("the agent's changes were reported: %q", got)
+	}
+
+	var nilWatcher *fileWatcher
+	nilWatcher.absorb()
+	if nilWatcher.changes() != nil {
+		t.Error("a nil watcher reported changes")
+	}
+}
+
+func TestExternalChangesMessage(t *testing.T) {
+	var changes []fileChange
+	for _, name := range strings.Fields("a b c d e f g h i j k l m n o p q r s t u v") {
+		changes = append(changes, fileChange{path: name + ".go", deleted: name == "a"})
+	}
+	msg := externalChangesMessage(changes)
+	for _, want := range []string{"- a.go (deleted)\n", "- t.go\n", "- and 2 more\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "u.go") {
+		t.Errorf("message lists more than %d files:\n%s", maxListedChanges, msg)
+	}
+}
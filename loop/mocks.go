@@ -133,6 +133,12 @@ func (m *MockConvo) SendMessage(message llm.Message) (*llm.Response, error) {
 	return exp.result[0].(*llm.Response), retErr
 }
 
+// SendMessageContext is matched against the expectations for SendMessage,
+// since contexts cannot be compared.
+func (m *MockConvo) SendMessageContext(ctx context.Context, message llm.Message) (*llm.Response, error) {
+	return m.SendMessage(message)
+}
+
 func (m *MockConvo) SendUserTextMessage(message string, otherContents ...llm.Content) (*llm.Response, error) {
 	m.recordCall("SendUserTextMessage", message, otherContents)
 	exp, ok := m.findMatchingExpectation("SendUserTextMessage", message, otherContents)
@@ -183,8 +189,8 @@ func (m *MockConvo) ToolResultCancelContents(resp *llm.Response) ([]llm.Content,
 	return exp.result[0].([]llm.Content), retErr
 }
 
-func (m *MockConvo) CumulativeUsage() conversation.CumulativeUsage {
-	m.recordCall("CumulativeUsage")
+func (m *MockConvo) Usage() conversation.CumulativeUsage {
+	m.recordCall("Usage")
 	return conversation.CumulativeUsage{}
 }
 
@@ -198,6 +204,11 @@ func (m *MockConvo) OverBudget() error {
 	return nil
 }
 
+func (m *MockConvo) WrapUp(contents ...llm.Content) (*llm.Response, error) {
+	m.recordCall("WrapUp", contents)
+	return &llm.Response{StopReason: llm.StopReasonEndTurn}, nil
+}
+
 func (m *MockConvo) GetID() string {
 	m.recordCall("GetID")
 	return "mock-conversation-id"
@@ -212,6 +223,20 @@ func (m *MockConvo) ResetBudget(_ conversation.Budget) {
 	m.recordCall("ResetBudget")
 }
 
+func (m *MockConvo) QueueUserMessage(text string) {
+	m.recordCall("QueueUserMessage", text)
+}
+
+func (m *MockConvo) TakeQueuedUserMessages() []string {
+	m.recordCall("TakeQueuedUserMessages")
+	return nil
+}
+
+func (m *MockConvo) Snapshot() ([]byte, error) {
+	m.recordCall("Snapshot")
+	return []byte("{}"), nil
+}
+
 // AssertExpectations checks that all expectations were met
 func (m *MockConvo) AssertExpectations(t *testing.T) {
 	m.mu.Lock()
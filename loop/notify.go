@@ -0,0 +1,60 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/credentials"
+	"sketch.dev/notify"
+	"sketch.dev/sessionstore"
+)
+
+// sendNotification sends e, from this session, to the Notifier, if there is one, without waiting for it.
+// Secrets are redacted from it first, since notifications leave the session, as for webhooks.
+func (a *Agent) sendNotification(ctx context.Context, e notify.Event) {
+	if a.config.Notifier == nil {
+		return
+	}
+	e.SessionID = a.SessionID()
+	e.Command = credentials.Redact(e.Command)
+	e.Detail = credentials.Redact(e.Detail)
+	if e.Err != nil {
+		e.Err = errors.New(credentials.Redact(e.Err.Error()))
+	}
+	notify.Send(ctx, a.config.Notifier, e)
+}
+
+// notifyTurnEnded tells the Notifier that the turn summarized by sess ended, with the agent's last message.
+func (a *Agent) notifyTurnEnded(ctx context.Context, sess *sessionstore.Session) {
+	var final string
+	a.mu.Lock()
+	for _, m := range a.history {
+		switch m.Type {
+		case AgentMessageType, ErrorMessageType, BudgetMessageType:
+			if m.ParentConversationID == nil && m.Content != "" {
+				final = m.Content
+			}
+		}
+	}
+	a.mu.Unlock()
+	a.sendNotification(ctx, notify.Event{Kind: notify.SessionDone, Outcome: string(sess.Outcome), Detail: final})
+}
+
+// notifyJobCrashed tells the Notifier that a background job crashed.
+func (a *Agent) notifyJobCrashed(ctx context.Context, job claudetool.JobInfo, err error, output string) {
+	a.sendNotification(ctx, notify.Event{Kind: notify.JobCrashed, Command: job.Command, Err: err, Detail: output})
+}
+
+// notifyChangesPending tells the Notifier that the staged changes await the user's approval.
+func (a *Agent) notifyChangesPending(ctx context.Context, pending []staging.Change) {
+	paths := make([]string, len(pending))
+	for i, c := range pending {
+		paths[i] = c.Path
+	}
+	detail := fmt.Sprintf("approve changes to %s", strings.Join(paths, ", "))
+	a.sendNotification(ctx, notify.Event{Kind: notify.PermissionRequested, Detail: detail})
+}
@@ -0,0 +1,134 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/llm"
+	"sketch.dev/notify"
+	"sketch.dev/projectconfig"
+)
+
+// A PermissionRequest asks the user to allow an action a tool is about to take.
+type PermissionRequest struct {
+	ID          string    `json:"id"`
+	Tool        string    `json:"tool"`
+	Description string    `json:"description"`
+	Time        time.Time `json:"time"`
+}
+
+// errPermissionDenied is the error for an action the user did not allow.
+var errPermissionDenied = llm.NewToolError(llm.ToolErrorUserDenied, errors.New("the user denied permission"))
+
+// permissionGate holds tools' permission requests until the user answers them.
+type permissionGate struct {
+	mu      sync.Mutex
+	next    int
+	pending []*pendingPermission
+}
+
+type pendingPermission struct {
+	req    PermissionRequest
+	answer chan bool
+}
+
+// request adds a request for tool to take the action described by description,
+// calls announce with it, and waits for the answer.
+func (g *permissionGate) request(ctx context.Context, tool, description string, announce func(PermissionRequest)) error {
+	g.mu.Lock()
+	g.next++
+	p := &pendingPermission{
+		req:    PermissionRequest{ID: strconv.Itoa(g.next), Tool: tool, Description: description, Time: time.Now()},
+		answer: make(chan bool, 1),
+	}
+	g.pending = append(g.pending, p)
+	g.mu.Unlock()
+	defer g.remove(p)
+
+	announce(p.req)
+	select {
+	case allowed := <-p.answer:
+		if !allowed {
+			return errPermissionDenied
+		}
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func (g *permissionGate) remove(p *pendingPermission) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pending = slices.DeleteFunc(g.pending, func(q *pendingPermission) bool { return q == p })
+}
+
+// list returns the requests awaiting an answer, oldest first.
+func (g *permissionGate) list() []PermissionRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	reqs := make([]PermissionRequest, len(g.pending))
+	for i, p := range g.pending {
+		reqs[i] = p.req
+	}
+	return reqs
+}
+
+// answer answers request id, or the oldest request if id is "".
+func (g *permissionGate) answer(id string, allow bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, p := range g.pending {
+		if id == "" || p.req.ID == id {
+			g.pending = slices.DeleteFunc(g.pending, func(q *pendingPermission) bool { return q == p })
+			p.answer <- allow
+			return nil
+		}
+	}
+	if id == "" {
+		return fmt.Errorf("no permission requests are pending")
+	}
+	return fmt.Errorf("no pending permission request %s", id)
+}
+
+// RequestPermission asks the user to allow tool to take the action described by description,
+// and waits until they answer or ctx is done. It returns nil if they allowed it.
+// In one-shot mode there is no one to ask, so the action is denied.
+func (a *Agent) RequestPermission(ctx context.Context, tool, description string) error {
+	if a.config.OneShot {
+		claudetool.PermissionDenials.Inc(tool, "one_shot")
+		return fmt.Errorf("no user to ask for permission in one-shot mode")
+	}
+	err := a.permissions.request(ctx, tool, description, func(req PermissionRequest) {
+		a.events.publish(Event{Type: EventPermissionRequested, Permission: &req})
+		a.sendNotification(ctx, notify.Event{Kind: notify.PermissionRequested, Detail: tool + ": " + description})
+	})
+	if errors.Is(err, errPermissionDenied) {
+		claudetool.PermissionDenials.Inc(tool, "user")
+	}
+	return err
+}
+
+// PendingPermissions returns the permission requests awaiting the user's answer.
+func (a *Agent) PendingPermissions() []PermissionRequest {
+	return a.permissions.list()
+}
+
+// AnswerPermission allows or denies the permission request with the given ID (the oldest if id is empty).
+func (a *Agent) AnswerPermission(id string, allow bool) error {
+	return a.permissions.answer(id, allow)
+}
+
+// PermissionRules returns the project's rules for tool calls, in the order they are tried.
+func (a *Agent) PermissionRules() []projectconfig.Rule {
+	if a.config.Project == nil {
+		return nil
+	}
+	return a.config.Project.Permissions
+}
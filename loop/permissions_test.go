@@ -0,0 +1,90 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/projectconfig"
+)
+
+func TestPermissionGate(t *testing.T) {
+	var g permissionGate
+	announced := make(chan PermissionRequest, 2)
+	results := make(chan error, 2)
+	for _, desc := range []string{"push", "comment"} {
+		go func() {
+			results <- g.request(context.Background(), "forge", desc, func(r PermissionRequest) { announced <- r })
+		}()
+		<-announced // keep the requests in order
+	}
+	if reqs := g.list(); len(reqs) != 2 || reqs[0].Description != "push" {
+		t.Fatalf("pending %+v", reqs)
+	}
+
+	// An empty ID answers the oldest request.
+	if err := g.answer("", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-results; !errors.Is(err, errPermissionDenied) {
+		t.Errorf("denied request returned %v", err)
+	}
+	if err := g.answer("2", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-results; err != nil {
+		t.Errorf("allowed request returned %v", err)
+	}
+	if err := g.answer("2", true); err == nil {
+		t.Error("answered a request twice")
+	}
+
+	// A request is withdrawn when its context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.request(ctx, "forge", "push", func(PermissionRequest) {}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timed out request returned %v", err)
+	}
+	if reqs := g.list(); len(reqs) != 0 {
+		t.Errorf("pending after timeout: %+v", reqs)
+	}
+}
+
+func TestApplyProjectConfig(t *testing.T) {
+	project, err := projectconfig.Parse([]byte(`
+tools:
+  think: false
+permissions:
+  - tool: bash
+    match: '^git push'
+    action: ask
+  - tool: bash
+    match: 'rm -rf'
+    action: deny
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Agent{config: AgentConfig{Project: project, OneShot: true}}
+	ran := 0
+	run := func(context.Context, json.RawMessage) ([]llm.Content, error) {
+		ran++
+		return llm.TextContent("ok"), nil
+	}
+	tools := a.applyProjectConfig(context.Background(), []*llm.Tool{{Name: "bash", Run: run}, {Name: "think", Run: run}})
+	if len(tools) != 1 || tools[0].Name != "bash" {
+		t.Fatalf("tools = %v, want just bash", tools)
+	}
+	bash := tools[0]
+	for _, command := range []string{"git push", "rm -rf build"} {
+		if _, err := bash.Run(context.Background(), json.RawMessage(`{"command":"`+command+`"}`)); err == nil {
+			t.Errorf("%s ran, want it denied", command)
+		}
+	}
+	if _, err := bash.Run(context.Background(), json.RawMessage(`{"command":"ls"}`)); err != nil || ran != 1 {
+		t.Errorf("ls ran %d times: %v", ran, err)
+	}
+}
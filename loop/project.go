@@ -0,0 +1,78 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/llm"
+	"sketch.dev/projectconfig"
+)
+
+// applyProjectConfig removes the tools that the project's configuration disables,
+// and puts the tools that its permission rules apply to behind those rules.
+func (a *Agent) applyProjectConfig(ctx context.Context, tools []*llm.Tool) []*llm.Tool {
+	cfg := a.config.Project
+	if cfg == nil {
+		return tools
+	}
+	known := make(map[string]bool)
+	for _, tool := range tools {
+		known[tool.Name] = true
+	}
+	var unknown []string
+	for _, name := range slices.Sorted(maps.Keys(cfg.Tools)) {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	for _, r := range cfg.Permissions {
+		if r.Tool != "*" && !known[r.Tool] && !slices.Contains(unknown, r.Tool) {
+			unknown = append(unknown, r.Tool)
+		}
+	}
+	for _, name := range unknown {
+		slog.WarnContext(ctx, "project_config_unknown_tool", "tool", name)
+		a.pushToOutbox(ctx, AgentMessage{
+			Type:    ErrorMessageType,
+			Content: fmt.Sprintf("%s names tool %q, which this session does not have", projectconfig.Path, name),
+		})
+	}
+
+	var enabled []*llm.Tool
+	for _, tool := range tools {
+		if on, ok := cfg.Tools[tool.Name]; ok && !on {
+			continue
+		}
+		if cfg.HasRules(tool.Name) {
+			tool = a.ruledTool(tool)
+		}
+		enabled = append(enabled, tool)
+	}
+	return enabled
+}
+
+// ruledTool returns a copy of tool whose calls are first checked against the project's permission rules:
+// allowed, denied, or run only if the user allows them.
+func (a *Agent) ruledTool(tool *llm.Tool) *llm.Tool {
+	ruled := *tool
+	ruled.Run = func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
+		r := a.config.Project.Rule(tool.Name, input)
+		switch {
+		case r == nil || r.Action == projectconfig.Allow:
+		case r.Action == projectconfig.Deny:
+			claudetool.PermissionDenials.Inc(tool.Name, "project")
+			return nil, fmt.Errorf("the project's permission rules (%s) do not allow this call", projectconfig.Path)
+		case r.Action == projectconfig.Ask:
+			if err := a.RequestPermission(ctx, tool.Name, projectconfig.Subject(input)); err != nil {
+				return nil, err
+			}
+		}
+		return tool.Run(ctx, input)
+	}
+	return &ruled
+}
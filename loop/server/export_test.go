@@ -0,0 +1,17 @@
+package server
+
+import (
+	"io/fs"
+	"testing/fstest"
+)
+
+func init() {
+	// The real bundle needs an npm install and an esbuild run, which takes minutes and the network;
+	// these tests only exercise the API, so a stub page will do.
+	buildWebBundle = func() (fs.FS, error) {
+		return fstest.MapFS{
+			"sketch-app-shell.html": {Data: []byte("<!doctype html>")},
+			"mobile-app-shell.html": {Data: []byte("<!doctype html>")},
+		}, nil
+	}
+}
@@ -30,9 +30,14 @@ import (
 	"sketch.dev/loop/server/gzhandler"
 
 	"github.com/creack/pty"
+	"golang.org/x/net/websocket"
+	"sketch.dev/claudetool"
 	"sketch.dev/claudetool/browse"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/claudetool/undo"
 	"sketch.dev/llm/conversation"
 	"sketch.dev/loop"
+	"sketch.dev/transcript"
 	"sketch.dev/webui"
 )
 
@@ -57,6 +62,23 @@ type TerminalResponse struct {
 	SessionID string `json:"sessionId"`
 }
 
+// A FileWrite is a file write by the agent that can be undone.
+type FileWrite struct {
+	ID      uint64    `json:"id"`
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+	Created bool      `json:"created"` // whether the write created the file
+}
+
+// fileWrites returns the FileWrites of entries.
+func fileWrites(entries []undo.Entry) []FileWrite {
+	writes := make([]FileWrite, len(entries))
+	for i, e := range entries {
+		writes[i] = FileWrite{ID: e.ID, Path: e.Path, Time: e.Time, Created: !e.Existed}
+	}
+	return writes
+}
+
 // TodoItem represents a single todo item for task management
 type TodoItem struct {
 	ID     string `json:"id"`
@@ -105,6 +127,9 @@ type State struct {
 	DiffLinesAdded       int                           `json:"diff_lines_added"`                // Lines added from sketch-base to HEAD
 	DiffLinesRemoved     int                           `json:"diff_lines_removed"`              // Lines removed from sketch-base to HEAD
 	OpenPorts            []Port                        `json:"open_ports,omitempty"`            // Currently open TCP ports
+	PendingChanges       int                           `json:"pending_changes,omitempty"`       // Files with changes awaiting approval
+	Jobs                 []claudetool.JobInfo          `json:"jobs,omitempty"`                  // Background jobs the bash tool started
+	BudgetDollars        float64                       `json:"budget_dollars,omitempty"`        // The session's spending limit, if any
 }
 
 // Port represents an open TCP port
@@ -139,6 +164,15 @@ type Server struct {
 	terminalSessions map[string]*terminalSession
 	sshAvailable     bool
 	sshError         string
+
+	endOnce sync.Once
+	ended   chan struct{}
+}
+
+// Ended returns a channel that is closed when a client asks to end the session.
+// The caller should then shut down by returning normally, so that its cleanup runs.
+func (s *Server) Ended() <-chan struct{} {
+	return s.ended
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -204,6 +238,9 @@ func (s *Server) proxyToPort(w http.ResponseWriter, r *http.Request, port string
 	proxy.ServeHTTP(w, r)
 }
 
+// buildWebBundle builds the web UI that New serves; tests replace it with a stub.
+var buildWebBundle = webui.Build
+
 // New creates a new HTTP server.
 func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 	s := &Server{
@@ -214,14 +251,16 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		terminalSessions: make(map[string]*terminalSession),
 		sshAvailable:     false,
 		sshError:         "",
+		ended:            make(chan struct{}),
 	}
 
-	webBundle, err := webui.Build()
+	webBundle, err := buildWebBundle()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build web bundle, did you run 'go generate sketch.dev/loop/...'?: %w", err)
 	}
 
 	s.mux.HandleFunc("/stream", s.handleSSEStream)
+	s.mux.Handle("/ws", websocket.Server{Handler: s.handleWebSocket})
 
 	// Git tool endpoints
 	s.mux.HandleFunc("/git/rawdiff", s.handleGitRawDiff)
@@ -382,50 +421,28 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		fmt.Fprintf(w, "</body>\n</html>")
 	})
 
-	// Handler for /download - downloads both messages and status as a JSON file
+	// Handler for /download - downloads the session as a file: JSON by default,
+	// or, with ?format=md or ?format=html, a Markdown or HTML transcript
 	s.mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
-		// Set headers for file download
-		w.Header().Set("Content-Type", "application/octet-stream")
+		format := transcript.JSON
+		if f := r.URL.Query().Get("format"); f != "" {
+			var err error
+			if format, err = transcript.ParseFormat(f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 
-		// Generate filename with format: sketch-YYYYMMDD-HHMMSS.json
+		// Generate filename with format: sketch-YYYYMMDD-HHMMSS.<format>
 		timestamp := time.Now().Format("20060102-150405")
-		filename := fmt.Sprintf("sketch-%s.json", timestamp)
-
+		filename := fmt.Sprintf("sketch-%s.%s", timestamp, format)
+		w.Header().Set("Content-Type", format.ContentType())
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 
-		// Get all messages
-		messageCount := agent.MessageCount()
-		messages := agent.Messages(0, messageCount)
-
-		// Get status information (usage and other metadata)
-		totalUsage := agent.TotalUsage()
-		hostname := getHostname()
-		workingDir := getWorkingDir()
-
-		// Create a combined structure with all information
-		downloadData := struct {
-			Messages     []loop.AgentMessage          `json:"messages"`
-			MessageCount int                          `json:"message_count"`
-			TotalUsage   conversation.CumulativeUsage `json:"total_usage"`
-			Hostname     string                       `json:"hostname"`
-			WorkingDir   string                       `json:"working_dir"`
-			DownloadTime string                       `json:"download_time"`
-		}{
-			Messages:     messages,
-			MessageCount: messageCount,
-			TotalUsage:   totalUsage,
-			Hostname:     hostname,
-			WorkingDir:   workingDir,
-			DownloadTime: time.Now().Format(time.RFC3339),
-		}
-
-		// Marshal the JSON with indentation for better readability
-		jsonData, err := json.MarshalIndent(downloadData, "", "  ")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		sess := transcript.New(agent, getHostname(), getWorkingDir())
+		if err := transcript.Write(w, sess, format); err != nil {
+			slog.ErrorContext(r.Context(), "session_download_failed", "format", format, "err", err)
 		}
-		w.Write(jsonData)
 	})
 
 	// The latter doesn't return until the number of messages has changed (from seen
@@ -640,6 +657,9 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		// Parse the request body
 		var requestBody struct {
 			Message string `json:"message"`
+			// Interrupt stops the agent's current response
+			// and redirects it with Message.
+			Interrupt bool `json:"interrupt"`
 		}
 
 		decoder := json.NewDecoder(r.Body)
@@ -654,7 +674,11 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 			return
 		}
 
-		agent.UserMessage(r.Context(), requestBody.Message)
+		if requestBody.Interrupt {
+			agent.InterruptTurn(r.Context(), requestBody.Message)
+		} else {
+			agent.UserMessage(r.Context(), requestBody.Message)
+		}
 
 		w.WriteHeader(http.StatusOK)
 	})
@@ -762,7 +786,277 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		json.NewEncoder(w).Encode(map[string]string{"status": "cancelled", "reason": cancelReason})
 	})
 
-	// Handler for /end - shuts down the inner sketch process
+	// Handler for /changes - lists file modifications awaiting approval
+	s.mux.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		changes := agent.PendingChanges()
+		if changes == nil {
+			changes = []staging.Change{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(changes)
+	})
+
+	// Handlers for /changes/approve and /changes/reject - resolve staged file modifications.
+	// An empty path applies to all staged files; omitted hunks apply to the whole file.
+	for action, resolve := range map[string]func(loop.CodingAgent, string, []int) error{
+		"approve": loop.CodingAgent.ApproveChange,
+		"reject":  loop.CodingAgent.RejectChange,
+	} {
+		s.mux.HandleFunc("/changes/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var requestBody struct {
+				Path  string `json:"path"`
+				Hunks []int  `json:"hunks,omitempty"`
+			}
+			decoder := json.NewDecoder(r.Body)
+			if err := decoder.Decode(&requestBody); err != nil && err != io.EOF {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer r.Body.Close()
+
+			if err := resolve(s.agent, requestBody.Path, requestBody.Hunks); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(agent.PendingChanges())
+		})
+	}
+
+	// Handler for /changes/review - accepts and rejects hunks of one file's staged change at once,
+	// telling the agent what the user decided
+	s.mux.HandleFunc("/changes/review", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var requestBody struct {
+			Path      string             `json:"path"`
+			Decisions []staging.Decision `json:"decisions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := agent.ReviewChange(requestBody.Path, requestBody.Decisions); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.PendingChanges())
+	})
+
+	// Handler for /changes/file - serves the contents of a file with its staged changes, for the user to edit
+	s.mux.HandleFunc("/changes/file", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := agent.StagedContents(r.URL.Query().Get("path"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(data)
+	})
+
+	// Handler for /changes/edit - writes the user's edit of a staged file in place of the agent's change
+	s.mux.HandleFunc("/changes/edit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var requestBody struct {
+			Path    string `json:"path"`
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := agent.EditChange(requestBody.Path, []byte(requestBody.Content)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.PendingChanges())
+	})
+
+	// Handler for /changes/approve-session - approves all changes, now and for the rest of the session
+	s.mux.HandleFunc("/changes/approve-session", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := agent.AutoApproveChanges(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.PendingChanges())
+	})
+
+	// Handler for /undo - lists the agent's file writes that can be undone, oldest first
+	s.mux.HandleFunc("/undo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fileWrites(agent.FileWrites()))
+	})
+
+	// Handlers for /undo/last and /undo/all - undo the agent's most recent file write, or all of them
+	for action, all := range map[string]bool{"last": false, "all": true} {
+		s.mux.HandleFunc("/undo/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			undone, err := agent.UndoFileWrites(all)
+			if err != nil {
+				// Some writes may have been undone before the one that could not be.
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(fileWrites(undone))
+		})
+	}
+
+	// Handler for /permissions - lists tools' requests to take actions, awaiting the user's answer
+	s.mux.HandleFunc("/permissions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.PendingPermissions())
+	})
+
+	// Handlers for /permissions/allow and /permissions/deny - answer a permission request.
+	// An empty id answers the oldest request.
+	for action, allow := range map[string]bool{"allow": true, "deny": false} {
+		s.mux.HandleFunc("/permissions/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var requestBody struct {
+				ID string `json:"id"`
+			}
+			decoder := json.NewDecoder(r.Body)
+			if err := decoder.Decode(&requestBody); err != nil && err != io.EOF {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer r.Body.Close()
+
+			if err := s.agent.AnswerPermission(requestBody.ID, allow); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(agent.PendingPermissions())
+		})
+	}
+
+	// Handler for /jobs - lists the background jobs the bash tool started, with their current status
+	s.mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.BackgroundJobs())
+	})
+
+	// Handler for /jobs/stop - stops a background job, and returns the jobs as they are now
+	s.mux.HandleFunc("/jobs/stop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var requestBody struct {
+			Job int `json:"job"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := agent.StopBackgroundJob(r.Context(), requestBody.Job); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.BackgroundJobs())
+	})
+
+	// Handler for /attachments - lists the context pinned into the conversation (GET),
+	// or pins files, directories, globs, and URLs into it (POST)
+	s.mux.HandleFunc("/attachments", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var requestBody struct {
+				Sources []string `json:"sources"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer r.Body.Close()
+			if len(requestBody.Sources) == 0 {
+				http.Error(w, "No sources to attach", http.StatusBadRequest)
+				return
+			}
+			if _, err := agent.Attach(r.Context(), requestBody.Sources...); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.Attachments())
+	})
+
+	// Handler for /attachments/detach - unpins attachments by name, or all of them if no names are given
+	s.mux.HandleFunc("/attachments/detach", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var requestBody struct {
+			Names []string `json:"names"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		agent.Detach(requestBody.Names...)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.Attachments())
+	})
+
+	// Handler for /end - asks the inner sketch process to shut down
 	s.mux.HandleFunc("/end", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -798,11 +1092,7 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		// Log that we're shutting down
 		slog.Info("Ending session", "reason", endReason)
 
-		// Give a brief moment for the response to be sent before exiting
-		go func() {
-			time.Sleep(100 * time.Millisecond)
-			os.Exit(0)
-		}()
+		s.endOnce.Do(func() { close(s.ended) })
 	})
 
 	debugMux := initDebugMux()
@@ -1156,6 +1446,10 @@ func (s *Server) handleSSEStream(w http.ResponseWriter, r *http.Request) {
 	// Create a channel for state transitions
 	stateChan := make(chan *loop.StateTransition, 10)
 
+	// Subscribe to the response the model is generating, sent as "stream" events
+	streamUpdates, unsubscribe := s.agent.SubscribeStreamingResponse()
+	defer unsubscribe()
+
 	// Start a goroutine to read messages without blocking the heartbeat
 	go func() {
 		// Create an iterator to receive new messages as they arrive
@@ -1245,6 +1539,16 @@ func (s *Server) handleSSEStream(w http.ResponseWriter, r *http.Request) {
 				f.Flush()
 			}
 
+		case <-streamUpdates:
+			fmt.Fprintf(w, "event: stream\n")
+			fmt.Fprintf(w, "data: ")
+			encoder.Encode(s.agent.StreamingResponse())
+			fmt.Fprintf(w, "\n\n")
+
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+
 		case newMessage, ok := <-messageChan:
 			if !ok {
 				// Channel closed
@@ -1275,6 +1579,99 @@ func (s *Server) handleSSEStream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// A wsFrame is one JSON message sent on the /ws WebSocket.
+// It is either an event from the agent, or, with type "state" or "heartbeat", sent by the server itself.
+type wsFrame struct {
+	loop.Event
+	State *State `json:"state,omitempty"`
+}
+
+// wsEventState and wsEventHeartbeat are the types of frames the server sends on its own.
+const (
+	wsEventState     loop.EventType = "state"
+	wsEventHeartbeat loop.EventType = "heartbeat"
+)
+
+// /ws?from=N endpoint: a WebSocket that streams the session's events as they happen.
+// It sends the current state, then the messages from index N on, then live events,
+// each followed by the updated state when the event changes it.
+// Any number of clients may watch the same session.
+// If a client falls too far behind, the server closes the connection; the client should reconnect.
+func (s *Server) handleWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+
+	fromIndex, _ := strconv.Atoi(ws.Request().URL.Query().Get("from"))
+
+	// Subscribe before catching up, so that no event falls in between.
+	events, unsubscribe := s.agent.SubscribeEvents()
+	defer unsubscribe()
+
+	// The client sends nothing; reading tells us when it goes away.
+	go func() {
+		io.Copy(io.Discard, ws)
+		cancel()
+	}()
+
+	send := func(f wsFrame) bool {
+		if f.Time.IsZero() {
+			f.Time = time.Now()
+		}
+		if err := websocket.JSON.Send(ws, f); err != nil {
+			slog.InfoContext(ctx, "websocket_send_failed", "err", err)
+			return false
+		}
+		return true
+	}
+	sendState := func() bool {
+		state := s.getState()
+		return send(wsFrame{Event: loop.Event{Type: wsEventState}, State: &state})
+	}
+
+	if !sendState() {
+		return
+	}
+	nextIndex := min(max(fromIndex, 0), s.agent.MessageCount())
+	for _, m := range s.agent.Messages(nextIndex, s.agent.MessageCount()) {
+		if !send(wsFrame{Event: loop.Event{Type: loop.EventMessage, Message: &m}}) {
+			return
+		}
+		nextIndex++
+	}
+
+	heartbeat := time.NewTicker(45 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if !send(wsFrame{Event: loop.Event{Type: wsEventHeartbeat}}) {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				slog.InfoContext(ctx, "websocket_client_too_slow")
+				return
+			}
+			if e.Type == loop.EventMessage {
+				if e.Message.Idx < nextIndex {
+					continue // already sent while catching up
+				}
+				nextIndex = e.Message.Idx + 1
+			}
+			if !send(wsFrame{Event: e}) {
+				return
+			}
+			// Deltas and tool output come too often, and change nothing else, to send the state after each.
+			if e.Type != loop.EventMessageDelta && e.Type != loop.EventToolCallOutput && !sendState() {
+				return
+			}
+		}
+	}
+}
+
 // Helper function to get the current state
 func (s *Server) getState() State {
 	serverMessageCount := s.agent.MessageCount()
@@ -1318,6 +1715,9 @@ func (s *Server) getState() State {
 		DiffLinesAdded:       diffAdded,
 		DiffLinesRemoved:     diffRemoved,
 		OpenPorts:            s.getOpenPorts(),
+		PendingChanges:       len(s.agent.PendingChanges()),
+		Jobs:                 s.agent.BackgroundJobs(),
+		BudgetDollars:        s.agent.OriginalBudget().MaxDollars,
 	}
 }
 
@@ -3,6 +3,7 @@ package server_test
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"slices"
@@ -11,9 +12,16 @@ import (
 	"testing"
 	"time"
 
+	"golang.org/x/net/websocket"
+	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/skills"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/claudetool/undo"
 	"sketch.dev/llm/conversation"
 	"sketch.dev/loop"
 	"sketch.dev/loop/server"
+	"sketch.dev/projectconfig"
+	"sketch.dev/toolplugin"
 	"tailscale.com/portlist"
 )
 
@@ -25,6 +33,7 @@ type mockAgent struct {
 	currentState             string
 	subscribers              []chan *loop.AgentMessage
 	stateTransitionListeners []chan loop.StateTransition
+	eventSubscribers         []chan loop.Event
 	gitUsername              string
 	initialCommit            string
 	branchName               string
@@ -121,6 +130,27 @@ func (m *mockAgent) AddMessage(msg loop.AgentMessage) {
 	for _, ch := range subscribers {
 		ch <- &msgCopy
 	}
+	m.PublishEvent(loop.Event{Type: loop.EventMessage, Message: &msgCopy})
+}
+
+func (m *mockAgent) SubscribeEvents() (<-chan loop.Event, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan loop.Event, 10)
+	m.eventSubscribers = append(m.eventSubscribers, ch)
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.eventSubscribers = slices.DeleteFunc(m.eventSubscribers, func(c chan loop.Event) bool { return c == ch })
+	}
+}
+
+func (m *mockAgent) PublishEvent(e loop.Event) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, ch := range m.eventSubscribers {
+		ch <- e
+	}
 }
 
 func (m *mockAgent) NewStateTransitionIterator(ctx context.Context) loop.StateTransitionIterator {
@@ -216,31 +246,36 @@ func (m *mockAgent) BranchName() string {
 }
 
 // Other required methods of loop.CodingAgent with minimal implementation
-func (m *mockAgent) Init(loop.AgentInit) error                   { return nil }
-func (m *mockAgent) Ready() <-chan struct{}                      { ch := make(chan struct{}); close(ch); return ch }
-func (m *mockAgent) URL() string                                 { return "http://localhost:8080" }
-func (m *mockAgent) UserMessage(ctx context.Context, msg string) {}
-func (m *mockAgent) Loop(ctx context.Context)                    {}
-func (m *mockAgent) CancelTurn(cause error)                      {}
-func (m *mockAgent) CancelToolUse(id string, cause error) error  { return nil }
-func (m *mockAgent) TotalUsage() conversation.CumulativeUsage    { return conversation.CumulativeUsage{} }
-func (m *mockAgent) OriginalBudget() conversation.Budget         { return conversation.Budget{} }
-func (m *mockAgent) WorkingDir() string                          { return m.workingDir }
-func (m *mockAgent) RepoRoot() string                            { return m.workingDir }
-func (m *mockAgent) Diff(commit *string) (string, error)         { return "", nil }
-func (m *mockAgent) OS() string                                  { return "linux" }
-func (m *mockAgent) SessionID() string                           { return m.sessionID }
-func (m *mockAgent) SSHConnectionString() string                 { return "sketch-" + m.sessionID }
-func (m *mockAgent) BranchPrefix() string                        { return m.branchPrefix }
-func (m *mockAgent) CurrentTodoContent() string                  { return "" } // Mock returns empty for simplicity
-func (m *mockAgent) OutstandingLLMCallCount() int                { return 0 }
-func (m *mockAgent) OutstandingToolCalls() []string              { return nil }
-func (m *mockAgent) OutsideOS() string                           { return "linux" }
-func (m *mockAgent) OutsideHostname() string                     { return "test-host" }
-func (m *mockAgent) OutsideWorkingDir() string                   { return "/app" }
-func (m *mockAgent) GitOrigin() string                           { return "" }
-func (m *mockAgent) GitUsername() string                         { return m.gitUsername }
-func (m *mockAgent) OpenBrowser(url string)                      {}
+func (m *mockAgent) Init(loop.AgentInit) error                     { return nil }
+func (m *mockAgent) Ready() <-chan struct{}                        { ch := make(chan struct{}); close(ch); return ch }
+func (m *mockAgent) URL() string                                   { return "http://localhost:8080" }
+func (m *mockAgent) UserMessage(ctx context.Context, msg string)   {}
+func (m *mockAgent) Loop(ctx context.Context)                      {}
+func (m *mockAgent) CancelTurn(cause error)                        {}
+func (m *mockAgent) InterruptTurn(ctx context.Context, msg string) {}
+func (m *mockAgent) CancelToolUse(id string, cause error) error    { return nil }
+func (m *mockAgent) TotalUsage() conversation.CumulativeUsage      { return conversation.CumulativeUsage{} }
+func (m *mockAgent) OriginalBudget() conversation.Budget           { return conversation.Budget{} }
+func (m *mockAgent) WorkingDir() string                            { return m.workingDir }
+func (m *mockAgent) RepoRoot() string                              { return m.workingDir }
+func (m *mockAgent) Diff(commit *string) (string, error)           { return "", nil }
+func (m *mockAgent) OS() string                                    { return "linux" }
+func (m *mockAgent) SessionID() string                             { return m.sessionID }
+func (m *mockAgent) SSHConnectionString() string                   { return "sketch-" + m.sessionID }
+func (m *mockAgent) BranchPrefix() string                          { return m.branchPrefix }
+func (m *mockAgent) CurrentTodoContent() string                    { return "" } // Mock returns empty for simplicity
+func (m *mockAgent) OutstandingLLMCallCount() int                  { return 0 }
+func (m *mockAgent) OutstandingToolCalls() []string                { return nil }
+func (m *mockAgent) StreamingResponse() loop.StreamingResponse     { return loop.StreamingResponse{} }
+func (m *mockAgent) SubscribeStreamingResponse() (<-chan struct{}, func()) {
+	return nil, func() {}
+}
+func (m *mockAgent) OutsideOS() string         { return "linux" }
+func (m *mockAgent) OutsideHostname() string   { return "test-host" }
+func (m *mockAgent) OutsideWorkingDir() string { return "/app" }
+func (m *mockAgent) GitOrigin() string         { return "" }
+func (m *mockAgent) GitUsername() string       { return m.gitUsername }
+func (m *mockAgent) OpenBrowser(url string)    {}
 func (m *mockAgent) CompactConversation(ctx context.Context) error {
 	// Mock implementation - just return nil
 	return nil
@@ -273,6 +308,32 @@ func (m *mockAgent) GetPorts() []portlist.Port {
 	}
 }
 
+func (m *mockAgent) PendingChanges() []staging.Change              { return nil }
+func (m *mockAgent) ApproveChange(path string, hunks []int) error  { return nil }
+func (m *mockAgent) RejectChange(path string, hunks []int) error   { return nil }
+func (m *mockAgent) StagedContents(path string) ([]byte, error)    { return nil, nil }
+func (m *mockAgent) EditChange(path string, data []byte) error     { return nil }
+func (m *mockAgent) FileWrites() []undo.Entry                      { return nil }
+func (m *mockAgent) UndoFileWrites(all bool) ([]undo.Entry, error) { return nil, nil }
+func (m *mockAgent) AutoApproveChanges() error                     { return nil }
+func (m *mockAgent) PendingPermissions() []loop.PermissionRequest  { return nil }
+func (m *mockAgent) AnswerPermission(id string, allow bool) error  { return nil }
+func (m *mockAgent) PermissionRules() []projectconfig.Rule         { return nil }
+func (m *mockAgent) BackgroundJobs() []claudetool.JobInfo          { return nil }
+func (m *mockAgent) ReviewChange(path string, decisions []staging.Decision) error {
+	return nil
+}
+func (m *mockAgent) StopBackgroundJob(ctx context.Context, id int) error {
+	return fmt.Errorf("no background job %d", id)
+}
+func (m *mockAgent) Attach(ctx context.Context, sources ...string) ([]conversation.Attachment, error) {
+	return nil, nil
+}
+func (m *mockAgent) Detach(names ...string) int             { return 0 }
+func (m *mockAgent) Attachments() []conversation.Attachment { return nil }
+func (m *mockAgent) PluginCommands() []*toolplugin.Command  { return nil }
+func (m *mockAgent) Skills() ([]*skills.Skill, error)       { return nil, nil }
+
 // TestSSEStream tests the SSE stream endpoint
 func TestSSEStream(t *testing.T) {
 	// Create a mock agent with initial messages
@@ -416,6 +477,74 @@ func TestSSEStream(t *testing.T) {
 	}
 }
 
+func TestWebSocketStream(t *testing.T) {
+	mockAgent := &mockAgent{currentState: "Ready", branchPrefix: "sketch/"}
+	mockAgent.AddMessage(loop.AgentMessage{Type: loop.UserMessageType, Content: "first"})
+	mockAgent.AddMessage(loop.AgentMessage{Type: loop.AgentMessageType, Content: "second"})
+
+	srv, err := server.New(mockAgent, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?from=1", "", ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	type frame struct {
+		Type    string             `json:"type"`
+		Delta   string             `json:"delta"`
+		Message *loop.AgentMessage `json:"message"`
+		State   *server.State      `json:"state"`
+	}
+	receive := func() frame {
+		t.Helper()
+		var f frame
+		if err := websocket.JSON.Receive(ws, &f); err != nil {
+			t.Fatalf("Failed to receive: %v", err)
+		}
+		return f
+	}
+
+	if f := receive(); f.Type != "state" || f.State == nil || f.State.MessageCount != 2 {
+		t.Errorf("first frame = %+v, want state with 2 messages", f)
+	}
+	if f := receive(); f.Type != "message" || f.Message.Content != "second" {
+		t.Errorf("second frame = %+v, want message from index 1", f)
+	}
+
+	// Wait for the server to subscribe before publishing.
+	for {
+		mockAgent.mu.RLock()
+		n := len(mockAgent.eventSubscribers)
+		mockAgent.mu.RUnlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mockAgent.PublishEvent(loop.Event{Type: loop.EventMessageDelta, Delta: "thi"})
+	mockAgent.AddMessage(loop.AgentMessage{Type: loop.AgentMessageType, Content: "third"})
+
+	// Deltas are not followed by the state; messages are.
+	var got []string
+	for range 3 {
+		f := receive()
+		got = append(got, f.Type+":"+f.Delta)
+		if f.Message != nil {
+			got[len(got)-1] += f.Message.Content
+		}
+	}
+	if want := []string{"message_delta:thi", "message:third", "state:"}; !slices.Equal(got, want) {
+		t.Errorf("live frames = %q, want %q", got, want)
+	}
+}
+
 func TestGitRawDiffHandler(t *testing.T) {
 	// Create a mock agent
 	mockAgent := &mockAgent{
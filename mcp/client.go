@@ -1,10 +1,12 @@
 package mcp
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -214,7 +216,17 @@ func (m *MCPManager) connectToServer(ctx context.Context, config ServerConfig) (
 		return nil, fmt.Errorf("failed to start MCP client: %w", err)
 	}
 
-	// Initialize the client
+	llmTools, err := m.initClient(ctx, config, mcpClient)
+	if err != nil {
+		mcpClient.Close()
+		return nil, err
+	}
+	return llmTools, nil
+}
+
+// initClient initializes a started MCP client, discovers the server's tools and resources,
+// and adds the client to m.
+func (m *MCPManager) initClient(ctx context.Context, config ServerConfig, mcpClient *client.Client) ([]*llm.Tool, error) {
 	initReq := mcp.InitializeRequest{
 		Params: mcp.InitializeParams{
 			ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
@@ -225,7 +237,8 @@ func (m *MCPManager) connectToServer(ctx context.Context, config ServerConfig) (
 			},
 		},
 	}
-	if _, err := mcpClient.Initialize(ctx, initReq); err != nil {
+	initResp, err := mcpClient.Initialize(ctx, initReq)
+	if err != nil {
 		return nil, fmt.Errorf("failed to initialize MCP client: %w", err)
 	}
 
@@ -242,6 +255,17 @@ func (m *MCPManager) connectToServer(ctx context.Context, config ServerConfig) (
 		return nil, fmt.Errorf("failed to convert tools: %w", err)
 	}
 
+	// Resources are offered through a tool that reads them.
+	if initResp.Capabilities.Resources != nil {
+		resourcesResp, err := mcpClient.ListResources(ctx, mcp.ListResourcesRequest{})
+		if err != nil {
+			// The tools are still usable.
+			slog.WarnContext(ctx, "Failed to list MCP resources", "server", config.Name, "error", err)
+		} else if len(resourcesResp.Resources) > 0 {
+			llmTools = append(llmTools, m.resourceTool(config.Name, mcpClient, resourcesResp.Resources))
+		}
+	}
+
 	// Store the client
 	clientWrapper := &MCPClientWrapper{
 		name:   config.Name,
@@ -269,7 +293,7 @@ func (m *MCPManager) convertMCPTools(serverName string, mcpClient *client.Client
 		}
 
 		llmTool := &llm.Tool{
-			Name:        fmt.Sprintf("%s_%s", serverName, mcpTool.Name),
+			Name:        toolName(serverName, mcpTool.Name),
 			Description: mcpTool.Description,
 			InputSchema: json.RawMessage(schemaBytes),
 			Run: func(toolName string, client *client.Client) func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
@@ -278,8 +302,11 @@ func (m *MCPManager) convertMCPTools(serverName string, mcpClient *client.Client
 					if err != nil {
 						return nil, err
 					}
-					// Convert result to llm.Content
-					return []llm.Content{llm.StringContent(fmt.Sprintf("%v", result))}, nil
+					contents := convertMCPContent(result.Content)
+					if result.IsError {
+						return nil, fmt.Errorf("%s", contentText(contents))
+					}
+					return contents, nil
 				}
 			}(mcpTool.Name, mcpClient),
 		}
@@ -290,8 +317,131 @@ func (m *MCPManager) convertMCPTools(serverName string, mcpClient *client.Client
 	return llmTools, nil
 }
 
+// invalidToolNameChars matches the characters that LLM APIs do not allow in tool names.
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// toolName returns the name under which the tool named tool on the server named server is offered to the model.
+func toolName(server, tool string) string {
+	name := invalidToolNameChars.ReplaceAllString(server+"_"+tool, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// maxListedResources limits how many resources are listed in a resource tool's description.
+const maxListedResources = 100
+
+// resourceTool returns a tool that reads resources from the named server,
+// whose description lists resources, the ones the server offers.
+func (m *MCPManager) resourceTool(serverName string, mcpClient *client.Client, resources []mcp.Resource) *llm.Tool {
+	var desc strings.Builder
+	fmt.Fprintf(&desc, "Reads a resource from the %s MCP server, given its URI. Available resources:\n", serverName)
+	for i, r := range resources {
+		if i == maxListedResources {
+			fmt.Fprintf(&desc, "(and %d more)\n", len(resources)-i)
+			break
+		}
+		fmt.Fprintf(&desc, "- %s", r.URI)
+		if r.Name != "" {
+			fmt.Fprintf(&desc, " (%s)", r.Name)
+		}
+		if r.Description != "" {
+			fmt.Fprintf(&desc, ": %s", r.Description)
+		}
+		desc.WriteString("\n")
+	}
+	return &llm.Tool{
+		Name:        toolName(serverName, "read_resource"),
+		Description: desc.String(),
+		InputSchema: llm.MustSchema(`{
+  "type": "object",
+  "required": ["uri"],
+  "properties": {
+    "uri": {
+      "type": "string",
+      "description": "URI of the resource to read"
+    }
+  }
+}`),
+		Run: func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
+			var req mcp.ReadResourceRequest
+			if err := json.Unmarshal(input, &req.Params); err != nil {
+				return nil, fmt.Errorf("failed to parse resource request: %w", err)
+			}
+			ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+			resp, err := mcpClient.ReadResource(ctx, req)
+			if err != nil {
+				return nil, fmt.Errorf("MCP resource read failed: %w", err)
+			}
+			var contents []llm.Content
+			for _, rc := range resp.Contents {
+				contents = append(contents, convertResourceContents(rc)...)
+			}
+			if len(contents) == 0 {
+				return llm.TextContent("(empty resource)"), nil
+			}
+			return contents, nil
+		},
+	}
+}
+
+// convertMCPContent converts the content of an MCP tool result to llm.Content.
+func convertMCPContent(content []mcp.Content) []llm.Content {
+	var contents []llm.Content
+	for _, c := range content {
+		switch c := c.(type) {
+		case mcp.TextContent:
+			contents = append(contents, llm.StringContent(c.Text))
+		case mcp.ImageContent:
+			contents = append(contents, llm.ImageContent("", c.MIMEType, c.Data)...)
+		case mcp.AudioContent:
+			contents = append(contents, llm.StringContent(fmt.Sprintf("[%s audio omitted]", c.MIMEType)))
+		case mcp.EmbeddedResource:
+			contents = append(contents, convertResourceContents(c.Resource)...)
+		default:
+			b, err := json.Marshal(c)
+			if err != nil {
+				b = []byte(fmt.Sprintf("%v", c))
+			}
+			contents = append(contents, llm.StringContent(string(b)))
+		}
+	}
+	if len(contents) == 0 {
+		return llm.TextContent("(no output)")
+	}
+	return contents
+}
+
+// convertResourceContents converts the contents of an MCP resource to llm.Content.
+// Binary resources other than images are described rather than included.
+func convertResourceContents(rc mcp.ResourceContents) []llm.Content {
+	switch rc := rc.(type) {
+	case mcp.TextResourceContents:
+		return llm.TextContent(rc.Text)
+	case mcp.BlobResourceContents:
+		if strings.HasPrefix(rc.MIMEType, "image/") {
+			return llm.ImageContent(rc.URI, rc.MIMEType, rc.Blob)
+		}
+		return llm.TextContent(fmt.Sprintf("[binary resource %s (%s), %d bytes base64-encoded, omitted]", rc.URI, cmp.Or(rc.MIMEType, "unknown type"), len(rc.Blob)))
+	}
+	return nil
+}
+
+// contentText returns the text of contents, one per line.
+func contentText(contents []llm.Content) string {
+	var texts []string
+	for _, c := range contents {
+		if c.Text != "" {
+			texts = append(texts, c.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
 // executeMCPTool executes an MCP tool call
-func (m *MCPManager) executeMCPTool(ctx context.Context, mcpClient *client.Client, toolName string, input json.RawMessage) (any, error) {
+func (m *MCPManager) executeMCPTool(ctx context.Context, mcpClient *client.Client, toolName string, input json.RawMessage) (*mcp.CallToolResult, error) {
 	// Add timeout for tool execution
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
@@ -316,8 +466,7 @@ func (m *MCPManager) executeMCPTool(ctx context.Context, mcpClient *client.Clien
 		return nil, fmt.Errorf("MCP tool call failed: %w", err)
 	}
 
-	// Return the content from the response
-	return resp.Content, nil
+	return resp, nil
 }
 
 // Close closes all MCP client connections
@@ -0,0 +1,87 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"sketch.dev/llm"
+)
+
+func TestInProcessServer(t *testing.T) {
+	ctx := context.Background()
+	s := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false), server.WithResourceCapabilities(false, false))
+	s.AddTool(mcp.NewTool("echo", mcp.WithString("text")), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(req.GetString("text", "")), nil
+	})
+	s.AddTool(mcp.NewTool("fail"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultError("it broke"), nil
+	})
+	s.AddTool(mcp.NewTool("draw.png"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultImage("a dot", "iVBORw0KGgo=", "image/png"), nil
+	})
+	s.AddResource(mcp.NewResource("file:///notes.txt", "notes", mcp.WithMIMEType("text/plain")), func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		return []mcp.ResourceContents{mcp.TextResourceContents{URI: req.Params.URI, MIMEType: "text/plain", Text: "remember the milk"}}, nil
+	})
+
+	c, err := client.NewInProcessClient(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m := NewMCPManager()
+	defer m.Close()
+	tools, err := m.initClient(ctx, ServerConfig{Name: "srv"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*llm.Tool)
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+	}
+	for _, name := range []string{"srv_echo", "srv_fail", "srv_draw_png", "srv_read_resource"} {
+		if byName[name] == nil {
+			t.Fatalf("missing tool %s; have %v", name, byName)
+		}
+	}
+
+	out, err := byName["srv_echo"].Run(ctx, json.RawMessage(`{"text":"hello"}`))
+	if err != nil || len(out) != 1 || out[0].Text != "hello" {
+		t.Errorf("echo = %+v, %v; want hello", out, err)
+	}
+
+	if _, err := byName["srv_fail"].Run(ctx, json.RawMessage(`{}`)); err == nil || !strings.Contains(err.Error(), "it broke") {
+		t.Errorf("fail error = %v, want it broke", err)
+	}
+
+	out, err = byName["srv_draw_png"].Run(ctx, json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].Text != "a dot" || out[1].MediaType != "image/png" || out[1].Data != "iVBORw0KGgo=" {
+		t.Errorf("draw.png = %+v, want text and image", out)
+	}
+
+	if !strings.Contains(byName["srv_read_resource"].Description, "file:///notes.txt (notes)") {
+		t.Errorf("read_resource description does not list the resource:\n%s", byName["srv_read_resource"].Description)
+	}
+	out, err = byName["srv_read_resource"].Run(ctx, json.RawMessage(`{"uri":"file:///notes.txt"}`))
+	if err != nil || len(out) != 1 || out[0].Text != "remember the milk" {
+		t.Errorf("read_resource = %+v, %v; want the notes", out, err)
+	}
+}
+
+func TestToolName(t *testing.T) {
+	if got := toolName("my server", "do.it"); got != "my_server_do_it" {
+		t.Errorf("toolName = %q", got)
+	}
+	if got := toolName("s", strings.Repeat("x", 100)); len(got) != 64 {
+		t.Errorf("len(toolName) = %d, want 64", len(got))
+	}
+}
@@ -0,0 +1,66 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// NewServer returns an MCP server that offers tools to other agents, such as IDEs and desktop apps.
+// Tools run with the context that the server is served with.
+// If convo is non-nil, tools run as part of it, so tools that need a conversation,
+// such as keyword search, can use it.
+func NewServer(name, version string, convo *conversation.Convo, tools []*llm.Tool) *server.MCPServer {
+	s := server.NewMCPServer(name, version, server.WithToolCapabilities(false))
+	for _, tool := range tools {
+		s.AddTool(mcp.NewToolWithRawSchema(tool.Name, tool.Description, tool.InputSchema), toolHandler(convo, tool))
+	}
+	return s
+}
+
+// ServeStdio serves s on stdin and stdout until ctx is done or stdin is closed.
+func ServeStdio(ctx context.Context, s *server.MCPServer) error {
+	return server.NewStdioServer(s).Listen(ctx, os.Stdin, os.Stdout)
+}
+
+// toolHandler returns an MCP handler that runs tool.
+// Tool errors are reported to the client as error results, not protocol errors.
+func toolHandler(convo *conversation.Convo, tool *llm.Tool) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		input, err := json.Marshal(req.GetArguments())
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal arguments: %w", err)
+		}
+		if convo != nil {
+			ctx = conversation.ContextWithToolCallInfo(ctx, conversation.ToolCallInfo{Convo: convo})
+		}
+		slog.InfoContext(ctx, "mcp_serve_tool_call", "tool", tool.Name)
+		out, err := tool.Run(ctx, input)
+		if err != nil {
+			slog.InfoContext(ctx, "mcp_serve_tool_error", "tool", tool.Name, "err", err)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return &mcp.CallToolResult{Content: toMCPContent(out)}, nil
+	}
+}
+
+// toMCPContent converts tool output to MCP content.
+func toMCPContent(contents []llm.Content) []mcp.Content {
+	var out []mcp.Content
+	for _, c := range contents {
+		if c.Text != "" {
+			out = append(out, mcp.NewTextContent(c.Text))
+		}
+		if c.Data != "" {
+			out = append(out, mcp.NewImageContent(c.Data, c.MediaType))
+		}
+	}
+	return out
+}
@@ -0,0 +1,58 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client"
+	"sketch.dev/llm"
+)
+
+func TestServerRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	upper := &llm.Tool{
+		Name:        "shout",
+		Description: "Repeats text loudly.",
+		InputSchema: llm.MustSchema(`{"type": "object", "required": ["text"], "properties": {"text": {"type": "string"}}}`),
+		Run: func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
+			var in struct{ Text string }
+			if err := json.Unmarshal(input, &in); err != nil {
+				return nil, err
+			}
+			if in.Text == "" {
+				return nil, errors.New("nothing to shout")
+			}
+			return append(llm.TextContent(in.Text+"!"), llm.ImageContent("", "image/png", "iVBORw0KGgo=")...), nil
+		},
+	}
+
+	c, err := client.NewInProcessClient(NewServer("sketch", "test", nil, []*llm.Tool{upper}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m := NewMCPManager()
+	defer m.Close()
+	tools, err := m.initClient(ctx, ServerConfig{Name: "sketch"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tools) != 1 || tools[0].Name != "sketch_shout" || tools[0].Description != upper.Description {
+		t.Fatalf("tools = %+v, want sketch_shout", tools)
+	}
+
+	out, err := tools[0].Run(ctx, json.RawMessage(`{"text": "hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].Text != "hi!" || out[1].MediaType != "image/png" {
+		t.Errorf("shout = %+v, want text and image", out)
+	}
+	if _, err := tools[0].Run(ctx, json.RawMessage(`{"text": ""}`)); err == nil || err.Error() != "nothing to shout" {
+		t.Errorf("shout error = %v, want nothing to shout", err)
+	}
+}
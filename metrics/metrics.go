@@ -0,0 +1,325 @@
+// Package metrics counts what sessions do, such as LLM requests and tool calls,
+// and serves the counts in the Prometheus text exposition format,
+// so that deployments of sketch serve can be monitored like other services.
+//
+// Packages define their metrics as package variables, with NewCounter, NewGauge, NewGaugeFunc, and NewHistogram,
+// which register them in Default.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Registry holds metrics, to be written together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// Default is the registry of the package-level constructors, served by Handler.
+var Default = new(Registry)
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// desc describes a metric and its labels.
+type desc struct {
+	name, help, kind string
+	labels           []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+}
+
+// labelString formats labels with values, plus extra, an already formatted label, if not empty.
+func (d *desc) labelString(values []string, extra string) string {
+	var parts []string
+	for i, l := range d.labels {
+		parts = append(parts, l+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (d *desc) check(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s has labels %q, got %d values", d.name, d.labels, len(values)))
+	}
+}
+
+// series is a metric's values, one for each combination of label values.
+type series[T any] struct {
+	mu     sync.Mutex
+	values map[string]*T
+	labels map[string][]string
+}
+
+// get returns the value for labels, creating it if needed. s.mu must be held.
+func (s *series[T]) get(labels []string) *T {
+	key := strings.Join(labels, "\xff")
+	if v, ok := s.values[key]; ok {
+		return v
+	}
+	if s.values == nil {
+		s.values = make(map[string]*T)
+		s.labels = make(map[string][]string)
+	}
+	v := new(T)
+	s.values[key] = v
+	s.labels[key] = slices.Clone(labels)
+	return v
+}
+
+// each calls f with each value and its labels, in a stable order. s.mu must be held.
+func (s *series[T]) each(f func(labels []string, v *T)) {
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		f(s.labels[k], s.values[k])
+	}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	if r.metrics == nil {
+		r.metrics = make(map[string]metric)
+	}
+	r.metrics[name] = m
+}
+
+// A Counter is a count that only goes up, such as of requests, per combination of label values.
+type Counter struct {
+	desc
+	series[float64]
+}
+
+// NewCounter returns a counter registered in r, with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name: name, help: help, kind: "counter", labels: labels}}
+	r.register(name, c)
+	return c
+}
+
+// Add adds v, which must not be negative, to the count for labels.
+func (c *Counter) Add(v float64, labels ...string) {
+	c.check(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(labels) += v
+}
+
+// Inc adds 1 to the count for labels.
+func (c *Counter) Inc(labels ...string) { c.Add(1, labels...) }
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.each(func(labels []string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(labels, ""), formatFloat(*v))
+	})
+}
+
+// A Gauge is a value that goes up and down, such as of things in progress, per combination of label values.
+type Gauge struct {
+	desc
+	series[float64]
+}
+
+// NewGauge returns a gauge registered in r, with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help, kind: "gauge", labels: labels}}
+	r.register(name, g)
+	return g
+}
+
+// Add adds v, which may be negative, to the value for labels.
+func (g *Gauge) Add(v float64, labels ...string) {
+	g.check(labels)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labels) += v
+}
+
+// Set sets the value for labels to v.
+func (g *Gauge) Set(v float64, labels ...string) {
+	g.check(labels)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labels) = v
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.header(w)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.each(func(labels []string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(labels, ""), formatFloat(*v))
+	})
+}
+
+// gaugeFunc is a gauge without labels whose value is computed when it is written.
+type gaugeFunc struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc registers a gauge in r whose value is f's result when metrics are written.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(name, &gaugeFunc{desc: desc{name: name, help: help, kind: "gauge"}, f: f})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+}
+
+// A Histogram counts observations, such as latencies, in buckets, per combination of label values.
+type Histogram struct {
+	desc
+	series[histogramValue]
+	buckets []float64 // upper bounds, ascending
+}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// DefaultBuckets are buckets for latencies in seconds, from 5ms to 5 minutes.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// NewHistogram returns a histogram registered in r with the given bucket upper bounds, in ascending order,
+// and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name: name, help: help, kind: "histogram", labels: labels}, buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+// Observe records v for labels.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.check(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv := h.get(labels)
+	if hv.counts == nil {
+		hv.counts = make([]uint64, len(h.buckets)+1)
+	}
+	i, _ := slices.BinarySearch(h.buckets, v)
+	hv.counts[i]++
+	hv.sum += v
+	hv.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.each(func(labels []string, hv *histogramValue) {
+		var cumulative uint64
+		for i, n := range hv.counts {
+			cumulative += n
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatFloat(h.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(labels, `le="`+le+`"`), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(labels, ""), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(labels, ""), hv.count)
+	})
+}
+
+// Write writes r's metrics to w in the Prometheus text exposition format, ordered by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, 0, len(names))
+	slices.Sort(names)
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves r's metrics, for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// NewCounter returns a counter registered in Default.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewGauge returns a gauge registered in Default.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// NewGaugeFunc registers a gauge in Default whose value is f's result.
+func NewGaugeFunc(name, help string, f func() float64) {
+	Default.NewGaugeFunc(name, help, f)
+}
+
+// NewHistogram returns a histogram registered in Default.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Handler serves Default's metrics.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := new(Registry)
+	requests := r.NewCounter("requests_total", "Requests, by model.", "model", "status")
+	requests.Inc("claude", "ok")
+	requests.Add(2, "claude", "ok")
+	requests.Inc(`odd "model"`+"\n", "error")
+	active := r.NewGauge("active", "Things in progress.\nOne per line.")
+	active.Add(3)
+	active.Add(-1)
+	r.NewGaugeFunc("jobs", "Jobs.", func() float64 { return math.Inf(1) })
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "tool")
+	latency.Observe(0.05, "bash")
+	latency.Observe(0.1, "bash")
+	latency.Observe(5, "bash")
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP active Things in progress.\nOne per line.
+# TYPE active gauge
+active 2
+# HELP jobs Jobs.
+# TYPE jobs gauge
+jobs +Inf
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{tool="bash",le="0.1"} 2
+latency_seconds_bucket{tool="bash",le="1"} 2
+latency_seconds_bucket{tool="bash",le="+Inf"} 3
+latency_seconds_sum{tool="bash"} 5.15
+latency_seconds_count{tool="bash"} 3
+# HELP requests_total Requests, by model.
+# TYPE requests_total counter
+requests_total{model="claude",status="ok"} 3
+requests_total{model="odd \"model\"\n",status="error"} 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("Write:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := new(Registry)
+	r.NewCounter("requests_total", "Requests.").Inc()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "\nrequests_total 1\n") {
+		t.Errorf("body = %s", w.Body.String())
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	r := new(Registry)
+	c := r.NewCounter("requests_total", "Requests.", "model")
+	for name, f := range map[string]func(){
+		"duplicate":     func() { r.NewGauge("requests_total", "Again.") },
+		"missing label": func() { c.Inc() },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,287 @@
+// Package notify tells users about long-running commands and about what happens in their sessions,
+// such as the agent finishing or waiting for permission,
+// so that users who have switched to something else know when the agent is unblocked.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the kind of an Event.
+type Kind string
+
+const (
+	// CommandSlow is sent once a command has run for longer than the threshold.
+	CommandSlow Kind = "command_slow"
+	// CommandDone is sent when a command that was reported slow completes.
+	CommandDone Kind = "command_done"
+	// SessionDone is sent when the agent ends its turn, with its Outcome and final message as Detail.
+	SessionDone Kind = "session_done"
+	// BudgetExceeded is sent when a turn exceeds its budget, with the budget that ran out as Detail.
+	BudgetExceeded Kind = "budget_exceeded"
+	// PermissionRequested is sent when the agent waits for the user to allow something, described by Detail.
+	PermissionRequested Kind = "permission_requested"
+	// JobCrashed is sent when a background job started by Command exits on its own, with how it exited as Err.
+	JobCrashed Kind = "job_crashed"
+	// JobDone is sent when the session that `sketch schedule` ran for Job ends,
+	// with its Outcome, its final message as Detail, and why it failed, if it did, as Err.
+	JobDone Kind = "job_done"
+)
+
+// Kinds are all the kinds of events.
+var Kinds = []Kind{CommandSlow, CommandDone, SessionDone, BudgetExceeded, PermissionRequested, JobCrashed, JobDone}
+
+// SessionKinds are the kinds of events about sessions, rather than about single commands.
+var SessionKinds = []Kind{SessionDone, BudgetExceeded, PermissionRequested, JobCrashed}
+
+// An Event describes a long-running command or something that happened in a session.
+type Event struct {
+	Kind    Kind
+	Command string
+	Elapsed time.Duration
+	// Err is the error the command finished with, for CommandDone and JobCrashed events.
+	Err error
+	// SessionID is the session the event happened in, if known.
+	SessionID string
+	// Outcome is how a turn ended, for SessionDone events: done, unfinished, budget_exceeded, or error.
+	Outcome string
+	// Detail says more about a session event; see the Kinds.
+	Detail string
+	// Job is the scheduled job, for JobDone events.
+	Job string
+	// CostUSD and FilesChanged are what the job's session cost and changed, for JobDone events.
+	CostUSD      float64
+	FilesChanged []string
+}
+
+// Message returns a short, human-readable description of e.
+func (e Event) Message() string {
+	cmd := e.Command
+	if len(cmd) > 80 {
+		cmd = cmd[:77] + "..."
+	}
+	elapsed := e.Elapsed.Round(time.Second)
+	detail, _, _ := strings.Cut(strings.TrimSpace(e.Detail), "\n")
+	if len(detail) > 200 {
+		detail = detail[:197] + "..."
+	}
+	switch {
+	case e.Kind == SessionDone:
+		return fmt.Sprintf("Session finished its turn (%s): %s", e.Outcome, detail)
+	case e.Kind == BudgetExceeded:
+		return fmt.Sprintf("Session exceeded its budget: %s", detail)
+	case e.Kind == PermissionRequested:
+		return fmt.Sprintf("Session is waiting for permission: %s", detail)
+	case e.Kind == JobDone && e.Err != nil:
+		return fmt.Sprintf("Job %s failed (%s): %v", e.Job, e.Outcome, e.Err)
+	case e.Kind == JobDone:
+		return fmt.Sprintf("Job %s finished (%s): %s", e.Job, e.Outcome, detail)
+	case e.Kind == JobCrashed:
+		return fmt.Sprintf("Background job crashed (%v): %s", e.Err, cmd)
+	case e.Kind == CommandSlow:
+		return fmt.Sprintf("Still running after %s: %s", elapsed, cmd)
+	case e.Err != nil:
+		return fmt.Sprintf("Failed after %s: %s", elapsed, cmd)
+	default:
+		return fmt.Sprintf("Finished after %s: %s", elapsed, cmd)
+	}
+}
+
+// A Notifier delivers events to the user.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// Watch reports on a command that is starting now.
+// If the command is still running after threshold, n is sent a CommandSlow event.
+// The returned function must be called when the command completes;
+// if the command was reported slow, it sends n a CommandDone event.
+// Notification failures are logged, not returned.
+func Watch(ctx context.Context, n Notifier, threshold time.Duration, command string) (done func(err error)) {
+	if n == nil || threshold <= 0 {
+		return func(error) {}
+	}
+	start := time.Now()
+	var mu sync.Mutex // serializes the slow and done notifications
+	timer := time.AfterFunc(threshold, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		send(ctx, n, Event{Kind: CommandSlow, Command: command, Elapsed: time.Since(start)})
+	})
+	return func(err error) {
+		if timer.Stop() {
+			return // finished before it was slow
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// Use a fresh context: the command's context is often canceled by now.
+		send(context.WithoutCancel(ctx), n, Event{Kind: CommandDone, Command: command, Elapsed: time.Since(start), Err: err})
+	}
+}
+
+// Send sends e to n in the background, if n is set, logging any failure.
+func Send(ctx context.Context, n Notifier, e Event) {
+	if n == nil {
+		return
+	}
+	go send(context.WithoutCancel(ctx), n, e)
+}
+
+func send(ctx context.Context, n Notifier, e Event) {
+	if err := n.Notify(ctx, e); err != nil {
+		slog.WarnContext(ctx, "notify_failed", "kind", e.Kind, "err", err)
+	}
+}
+
+// Multi is a Notifier that notifies all of its Notifiers.
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, e Event) error {
+	var errs error
+	for _, n := range m {
+		errs = errors.Join(errs, n.Notify(ctx, e))
+	}
+	return errs
+}
+
+// Bell is a Notifier that rings the terminal bell and prints the event's message.
+type Bell struct {
+	W io.Writer
+}
+
+func (b *Bell) Notify(ctx context.Context, e Event) error {
+	_, err := fmt.Fprintf(b.W, "\a🔔 %s\r\n", e.Message())
+	return err
+}
+
+// Desktop is a Notifier that shows desktop notifications,
+// using notify-send on Linux and osascript on macOS.
+type Desktop struct{}
+
+func (Desktop) Notify(ctx context.Context, e Event) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", e.Message(), "sketch")
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	case "linux":
+		cmd = exec.CommandContext(ctx, "notify-send", "sketch", e.Message())
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("desktop notification failed: %s: %w", out, err)
+	}
+	return nil
+}
+
+// Webhook is a Notifier that POSTs events as JSON to a URL.
+// The JSON's text field makes it a message for Slack's incoming webhooks, and those compatible with them.
+type Webhook struct {
+	URL    string
+	Client *http.Client // if nil, http.DefaultClient is used
+	// Kinds, if set, are the only kinds of events sent.
+	Kinds []Kind
+}
+
+// webhookPayload is the JSON body sent by Webhook.
+type webhookPayload struct {
+	Text           string   `json:"text"`
+	Event          Kind     `json:"event"`
+	Command        string   `json:"command"`
+	ElapsedSeconds float64  `json:"elapsed_seconds"`
+	Error          string   `json:"error,omitempty"`
+	Message        string   `json:"message"`
+	SessionID      string   `json:"session_id,omitempty"`
+	Outcome        string   `json:"outcome,omitempty"`
+	Detail         string   `json:"detail,omitempty"`
+	Job            string   `json:"job,omitempty"`
+	CostUSD        float64  `json:"cost_usd,omitempty"`
+	FilesChanged   []string `json:"files_changed,omitempty"`
+}
+
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	if len(w.Kinds) > 0 && !slices.Contains(w.Kinds, e.Kind) {
+		return nil
+	}
+	payload := webhookPayload{
+		Text:           "sketch: " + e.Message(),
+		Event:          e.Kind,
+		Command:        e.Command,
+		ElapsedSeconds: e.Elapsed.Seconds(),
+		Message:        e.Message(),
+		SessionID:      e.SessionID,
+		Outcome:        e.Outcome,
+		Detail:         e.Detail,
+		Job:            e.Job,
+		CostUSD:        e.CostUSD,
+		FilesChanged:   e.FilesChanged,
+	}
+	if e.Err != nil {
+		payload.Error = e.Err.Error()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook notification failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook notification failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// Parse builds a Notifier from specs, as given to the -notify flag.
+// Each spec is "bell", "desktop", or an http(s) URL to use as a webhook.
+// Bell notifications are written to bell.
+// Parse returns nil if specs is empty.
+func Parse(specs []string, bell io.Writer) (Notifier, error) {
+	var m Multi
+	for _, spec := range specs {
+		switch {
+		case spec == "bell":
+			m = append(m, &Bell{W: bell})
+		case spec == "desktop":
+			m = append(m, Desktop{})
+		case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+			m = append(m, &Webhook{URL: spec})
+		default:
+			return nil, fmt.Errorf("unknown notifier %q: want bell, desktop, or a webhook URL", spec)
+		}
+	}
+	switch len(m) {
+	case 0:
+		return nil, nil
+	case 1:
+		return m[0], nil
+	}
+	return m, nil
+}
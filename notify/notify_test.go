@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Notify(ctx context.Context, e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) kinds() []Kind {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kinds []Kind
+	for _, e := range r.events {
+		kinds = append(kinds, e.Kind)
+	}
+	return kinds
+}
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+
+	fast := &recorder{}
+	done := Watch(ctx, fast, time.Hour, "true")
+	done(nil)
+	if got := fast.kinds(); len(got) != 0 {
+		t.Errorf("fast command sent %v, want nothing", got)
+	}
+
+	slow := &recorder{}
+	done = Watch(ctx, slow, time.Millisecond, "sleep 1")
+	time.Sleep(50 * time.Millisecond)
+	done(errors.New("exit status 1"))
+	got := slow.kinds()
+	if len(got) != 2 || got[0] != CommandSlow || got[1] != CommandDone {
+		t.Fatalf("slow command sent %v, want [%s %s]", got, CommandSlow, CommandDone)
+	}
+	if msg := slow.events[1].Message(); !strings.HasPrefix(msg, "Failed after") {
+		t.Errorf("done message = %q, want failure", msg)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var payload webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	w := &Webhook{URL: srv.URL}
+	err := w.Notify(context.Background(), Event{Kind: CommandDone, Command: "go test ./...", Elapsed: 90 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != CommandDone || payload.Command != "go test ./..." || payload.ElapsedSeconds != 90 || payload.Text != "sketch: "+payload.Message {
+		t.Errorf("payload = %+v", payload)
+	}
+
+	w.Kinds = SessionKinds
+	payload = webhookPayload{}
+	if err := w.Notify(context.Background(), Event{Kind: CommandSlow, Command: "sleep 60"}); err != nil || payload.Event != "" {
+		t.Errorf("a webhook for session events sent %+v, %v", payload, err)
+	}
+	err = w.Notify(context.Background(), Event{Kind: SessionDone, SessionID: "s1", Outcome: "done", Detail: "Fixed the flaky test.\nDetails follow."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != SessionDone || payload.SessionID != "s1" || payload.Text != "sketch: Session finished its turn (done): Fixed the flaky test." {
+		t.Errorf("payload = %+v", payload)
+	}
+}
+
+func TestParse(t *testing.T) {
+	n, err := Parse(nil, nil)
+	if n != nil || err != nil {
+		t.Errorf("Parse(nil) = %v, %v; want nil, nil", n, err)
+	}
+	n, err = Parse([]string{"bell", "https://example.com/hook"}, &strings.Builder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := n.(Multi); !ok || len(m) != 2 {
+		t.Errorf("Parse = %#v, want two notifiers", n)
+	}
+	if _, err := Parse([]string{"carrier-pigeon"}, nil); err == nil {
+		t.Errorf("Parse of unknown notifier succeeded")
+	}
+}
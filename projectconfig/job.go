@@ -0,0 +1,87 @@
+package projectconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+
+	"sketch.dev/schedule/cron"
+)
+
+// A Job is a headless session that `sketch schedule` starts on a cron schedule or after a git event,
+// such as a nightly run that fixes flaky tests.
+type Job struct {
+	// Prompt is what the session is asked to do.
+	Prompt string `yaml:"prompt"`
+	// Cron is when the job runs, in local time: five fields (minute, hour, day of month, month, day of week)
+	// as in crontab(5), or a shorthand such as @nightly.
+	Cron string `yaml:"cron"`
+	// On are the git events the job runs after. The one there is, post-merge,
+	// happens when new commits arrive on the checked-out branch, as by git pull.
+	On []string `yaml:"on"`
+	// Profile is the profile the session runs with, as -profile; by default ci.
+	Profile string `yaml:"profile"`
+	// Budget limits the session, over its profile's budget.
+	Budget Budget `yaml:"budget"`
+	// Notify is where the session's result is delivered.
+	Notify JobNotify `yaml:"notify"`
+}
+
+// JobNotify is where a job's results are delivered.
+type JobNotify struct {
+	// Webhooks are told the result, as a job_done event. Their events, which are always job_done, are not set.
+	Webhooks []Webhook `yaml:"webhooks"`
+	// Email are the addresses that the result is mailed to, through the SMTP server that the environment configures.
+	Email []string `yaml:"email"`
+}
+
+// GitEvents are the git events that jobs can run after.
+var GitEvents = []string{"post-merge"}
+
+// DefaultJobProfile is the profile of jobs that do not name one.
+const DefaultJobProfile = "ci"
+
+// validateJob checks the job named name.
+func (c *Config) validateJob(name string, j Job) error {
+	prefix := "jobs." + name
+	var errs []error
+	if strings.TrimSpace(j.Prompt) == "" {
+		errs = append(errs, fmt.Errorf("%s: prompt is required", prefix))
+	}
+	if j.Cron == "" && len(j.On) == 0 {
+		errs = append(errs, fmt.Errorf("%s: set cron, on, or both, for when the job runs", prefix))
+	}
+	if j.Cron != "" {
+		if _, err := cron.Parse(j.Cron); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+	}
+	for _, event := range j.On {
+		if !slices.Contains(GitEvents, event) {
+			errs = append(errs, fmt.Errorf("%s: on: %q is not one of %s", prefix, event, strings.Join(GitEvents, ", ")))
+		}
+	}
+	if j.Profile != "" {
+		if _, err := c.Profile(j.Profile); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+	}
+	if err := (&Profile{Budget: j.Budget}).validate(prefix); err != nil {
+		errs = append(errs, err)
+	}
+	for i, w := range j.Notify.Webhooks {
+		p := fmt.Sprintf("%s: notify.webhooks[%d]", prefix, i)
+		errs = append(errs, w.validate(p)...)
+		if len(w.Events) > 0 {
+			errs = append(errs, fmt.Errorf("%s: events cannot be set; a job's webhooks are told only its result", p))
+		}
+	}
+	for i, addr := range j.Notify.Email {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			errs = append(errs, fmt.Errorf("%s: notify.email[%d]: %w", prefix, i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
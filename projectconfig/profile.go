@@ -0,0 +1,147 @@
+package projectconfig
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A Profile is a named preset of tools, permission rules, and budget for a level of trust,
+// so that a session can be set up with one name instead of a dozen flags.
+// Sketch has the profiles in Builtin, and a project can define its own, or replace those, under profiles.
+// Flags set on the command line take precedence over a profile.
+type Profile struct {
+	// Tools enables or disables tools, over the project's settings.
+	Tools map[string]bool `yaml:"tools"`
+	// Permissions are rules for tool calls, which apply after the project's own.
+	Permissions []Rule `yaml:"permissions"`
+	// Budget limits each turn.
+	Budget Budget `yaml:"budget"`
+	// ApproveWrites, if set, is whether file changes wait for the user's approval, as -approve-writes.
+	ApproveWrites *bool `yaml:"approve_writes"`
+	// ForgeWrites, if set, is whether the forge tool may change things on the forge: ask, allow, or deny, as -forge-writes.
+	ForgeWrites string `yaml:"forge_writes"`
+}
+
+// A Budget is a profile's limits per turn; unset limits keep their default, and 0 means no limit.
+type Budget struct {
+	MaxDollars   *float64       `yaml:"max_dollars"`
+	MaxTokens    *uint64        `yaml:"max_tokens"`
+	MaxToolCalls *int           `yaml:"max_tool_calls"`
+	MaxWallTime  *time.Duration `yaml:"max_wall_time"`
+}
+
+// Builtin are the profiles sketch has:
+//
+//   - safe asks before commands that reach beyond the machine or act as root, and stages file changes for approval.
+//   - yolo asks for nothing and lets the forge tool push and comment.
+//   - ci suits unattended runs: nothing waits for a user, nothing is pushed, and turns are cut short.
+//   - review-only reads and runs the code, but cannot change it.
+var Builtin = map[string]Profile{
+	"safe": {
+		Permissions: []Rule{
+			{Tool: "bash", Match: riskyCommands, Action: Ask},
+			{Tool: "job_group", Match: riskyCommands, Action: Ask},
+		},
+		Budget:        Budget{MaxDollars: ptr(5.0)},
+		ApproveWrites: ptr(true),
+		ForgeWrites:   Ask,
+	},
+	"yolo": {
+		Budget:        Budget{MaxDollars: ptr(25.0)},
+		ApproveWrites: ptr(false),
+		ForgeWrites:   Allow,
+	},
+	"ci": {
+		Tools: map[string]bool{"multiplechoice": false},
+		Permissions: []Rule{
+			{Tool: "bash", Match: `\bgit\s+push\b`, Action: Deny},
+			{Tool: "job_group", Match: `\bgit\s+push\b`, Action: Deny},
+		},
+		Budget:        Budget{MaxDollars: ptr(5.0), MaxWallTime: ptr(30 * time.Minute)},
+		ApproveWrites: ptr(false),
+		ForgeWrites:   Deny,
+	},
+	"review-only": {
+		Tools: map[string]bool{"patch": false, "scaffold": false, "codegen": false, "environment": false},
+		Permissions: []Rule{
+			{Tool: "bash", Match: changingCommands, Action: Deny},
+			{Tool: "job_group", Match: changingCommands, Action: Deny},
+		},
+		Budget:      Budget{MaxDollars: ptr(3.0)},
+		ForgeWrites: Ask,
+	},
+}
+
+const (
+	// riskyCommands matches commands that reach beyond the machine or act as root.
+	riskyCommands = `\b(sudo|git\s+push|curl|wget|ssh|scp|rsync|docker|kubectl)\b`
+	// changingCommands matches commands that change the repository or its files.
+	changingCommands = `\bgit\s+(commit|push|reset|rebase|merge|checkout|switch|stash|am|apply|cherry-pick|revert|rm|mv)\b|\b(rm|mv|tee|truncate)\b|\bsed\s+-i\b`
+)
+
+func init() {
+	for name, p := range Builtin {
+		if err := p.validate("profile " + name); err != nil {
+			panic(err)
+		}
+		Builtin[name] = p
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// Profile returns the profile with the given name: the project's own, if it defines one, or else a builtin one.
+// c may be nil.
+func (c *Config) Profile(name string) (Profile, error) {
+	if c != nil {
+		if p, ok := c.Profiles[name]; ok {
+			return p, nil
+		}
+	}
+	if p, ok := Builtin[name]; ok {
+		return p, nil
+	}
+	var names []string
+	if c != nil {
+		names = slices.Collect(maps.Keys(c.Profiles))
+	}
+	names = append(names, slices.Collect(maps.Keys(Builtin))...)
+	slices.Sort(names)
+	return Profile{}, fmt.Errorf("unknown profile %q; want one of %s", name, strings.Join(slices.Compact(names), ", "))
+}
+
+// WithProfile returns a copy of c, which may be nil, with p's tools and permission rules added.
+func (c *Config) WithProfile(p Profile) *Config {
+	var merged Config
+	if c != nil {
+		merged = *c
+	}
+	merged.Tools = maps.Clone(merged.Tools)
+	if merged.Tools == nil && len(p.Tools) > 0 {
+		merged.Tools = make(map[string]bool)
+	}
+	maps.Copy(merged.Tools, p.Tools)
+	merged.Permissions = append(slices.Clip(merged.Permissions), p.Permissions...)
+	return &merged
+}
+
+// validate checks p and compiles its rules.
+func (p *Profile) validate(prefix string) error {
+	var errs []error
+	if _, ok := p.Tools[""]; ok {
+		errs = append(errs, fmt.Errorf("%s: tools: empty tool name", prefix))
+	}
+	errs = append(errs, validateRules(prefix+": permissions", p.Permissions)...)
+	if p.ForgeWrites != "" && !slices.Contains([]string{Allow, Ask, Deny}, p.ForgeWrites) {
+		errs = append(errs, fmt.Errorf("%s: forge_writes %q is not one of allow, ask, or deny", prefix, p.ForgeWrites))
+	}
+	b := p.Budget
+	if (b.MaxDollars != nil && *b.MaxDollars < 0) || (b.MaxToolCalls != nil && *b.MaxToolCalls < 0) || (b.MaxWallTime != nil && *b.MaxWallTime < 0) {
+		errs = append(errs, fmt.Errorf("%s: budget: limits cannot be negative", prefix))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,321 @@
+// Package projectconfig loads a project's sketch settings from .sketch/config.yaml in its repository:
+// the model to use, tools to disable, timeouts, permission rules for tool calls,
+// environment variables for the commands the agent runs, the domains it may fetch web pages from,
+// webhooks to tell about sessions' events, and jobs for `sketch schedule` to run.
+//
+// An example:
+//
+//	model: claude
+//	tools:
+//	  browser_navigate: false
+//	timeouts:
+//	  bash: 30s            # default for foreground commands
+//	  bash_background: 30m # default for background commands
+//	  llm_stall: 2m        # as -llm-stall-timeout
+//	  turn: 20m            # as -max-wall-time
+//	permissions:
+//	  - tool: bash
+//	    match: '^git push'
+//	    action: ask
+//	  - tool: bash
+//	    match: 'rm -rf /'
+//	    action: deny
+//	env:
+//	  GOFLAGS: -mod=mod
+//	fetch:
+//	  deny: [internal.example.com]
+//	profiles:
+//	  nightly:
+//	    budget:
+//	      max_dollars: 20
+//	    forge_writes: allow
+//	webhooks:
+//	  - url: https://hooks.slack.com/services/...
+//	    events: [session_done, permission_requested]
+//	jobs:
+//	  flaky-tests:
+//	    prompt: Find the tests that failed intermittently this week and fix them.
+//	    cron: '@nightly'
+//	    notify:
+//	      webhooks:
+//	        - url: https://hooks.slack.com/services/...
+//	      email: [team@example.com]
+//
+// Command-line flags take precedence over the file.
+package projectconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"sketch.dev/notify"
+)
+
+// Path is where a project's configuration is, relative to its repository root.
+const Path = ".sketch/config.yaml"
+
+// Config is a project's configuration. The zero Config changes nothing.
+type Config struct {
+	// Model is the model to use, as for -model.
+	Model string `yaml:"model"`
+	// Tools enables (true) or disables (false) tools by name; tools not listed keep their default.
+	Tools map[string]bool `yaml:"tools"`
+	// Timeouts overrides timeouts; zero keeps the default.
+	Timeouts Timeouts `yaml:"timeouts"`
+	// Permissions are rules for tool calls; the first that matches a call decides it.
+	// Calls that no rule matches run as usual.
+	Permissions []Rule `yaml:"permissions"`
+	// Env are environment variables for every command the agent runs.
+	Env map[string]string `yaml:"env"`
+	// Fetch is the domain policy of the fetch_url tool.
+	Fetch Fetch `yaml:"fetch"`
+	// Profiles are the project's own profiles, by name; see Profile.
+	Profiles map[string]Profile `yaml:"profiles"`
+	// Jobs are the sessions that `sketch schedule` runs, by name; see Job.
+	Jobs map[string]Job `yaml:"jobs"`
+	// Webhooks are told about sessions' events, such as a session finishing or waiting for permission.
+	Webhooks []Webhook `yaml:"webhooks"`
+}
+
+// A Webhook is a URL that events are POSTed to, as JSON that Slack's incoming webhooks accept; see notify.Webhook.
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Events are the kinds of events sent; by default, notify.SessionKinds:
+	// session_done, budget_exceeded, permission_requested, and job_crashed.
+	// command_slow and command_done are also available, as sent to -notify.
+	Events []notify.Kind `yaml:"events"`
+}
+
+// Timeouts are the timeouts a project can set, as Go durations such as "30s".
+type Timeouts struct {
+	// Bash is the default timeout of foreground commands.
+	Bash time.Duration `yaml:"bash"`
+	// BashBackground is the default timeout of background commands.
+	BashBackground time.Duration `yaml:"bash_background"`
+	// LLMStall is how long an LLM response may produce no data before it is retried, as -llm-stall-timeout.
+	LLMStall time.Duration `yaml:"llm_stall"`
+	// Turn is the longest the agent may work per turn, as -max-wall-time.
+	Turn time.Duration `yaml:"turn"`
+}
+
+// Fetch limits the domains that the fetch_url tool fetches web pages from.
+// A domain includes its subdomains.
+type Fetch struct {
+	// Allow, if not empty, are the only domains fetched from.
+	Allow []string `yaml:"allow"`
+	// Deny are domains never fetched from.
+	Deny []string `yaml:"deny"`
+}
+
+// A Rule allows, denies, or asks the user about calls to a tool.
+type Rule struct {
+	// Tool is the name of the tool the rule is for, or "*" for every tool.
+	Tool string `yaml:"tool"`
+	// Match is a regular expression that a call must match for the rule to apply:
+	// its command, for tools such as bash whose input has one, and otherwise its JSON input.
+	// Empty matches every call.
+	Match string `yaml:"match"`
+	// Action is what to do with calls the rule applies to: allow, ask, or deny.
+	Action string `yaml:"action"`
+
+	re *regexp.Regexp
+}
+
+// The actions a Rule can take.
+const (
+	Allow = "allow"
+	Ask   = "ask"
+	Deny  = "deny"
+)
+
+// Find returns the path of the configuration file of the repository that dir is in,
+// looking in dir and its parents up to the repository root (a directory with .git in it),
+// or "" if there is none.
+func Find(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, Path)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			return path
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Load reads and validates the configuration file at path.
+// Its errors name the file and, where they can, the line and setting at fault.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse parses and validates a configuration file's contents.
+// Unknown settings are errors, so that misspellings do not go unnoticed.
+func Parse(data []byte) (*Config, error) {
+	c := new(Config)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validate checks c and compiles its rules, and reports all of its problems at once.
+func (c *Config) validate() error {
+	var errs []error
+	if _, ok := c.Tools[""]; ok {
+		errs = append(errs, fmt.Errorf("tools: empty tool name"))
+	}
+	for _, t := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"bash", c.Timeouts.Bash},
+		{"bash_background", c.Timeouts.BashBackground},
+		{"llm_stall", c.Timeouts.LLMStall},
+		{"turn", c.Timeouts.Turn},
+	} {
+		if t.d < 0 {
+			errs = append(errs, fmt.Errorf("timeouts.%s: %v is negative", t.name, t.d))
+		}
+	}
+	errs = append(errs, validateRules("permissions", c.Permissions)...)
+	for _, name := range slices.Sorted(maps.Keys(c.Env)) {
+		if !envName.MatchString(name) {
+			errs = append(errs, fmt.Errorf("env: %q is not a valid environment variable name", name))
+		}
+	}
+	for _, list := range []struct {
+		name    string
+		domains []string
+	}{{"allow", c.Fetch.Allow}, {"deny", c.Fetch.Deny}} {
+		for i, d := range list.domains {
+			if d == "" || strings.ContainsAny(d, "/:* ") {
+				errs = append(errs, fmt.Errorf("fetch.%s[%d]: %q is not a domain", list.name, i, d))
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+		p := c.Profiles[name]
+		if err := p.validate("profiles." + name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i, w := range c.Webhooks {
+		errs = append(errs, w.validate(fmt.Sprintf("webhooks[%d]", i))...)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Jobs)) {
+		if err := c.validateJob(name, c.Jobs[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateRules checks rules and compiles them.
+func validateRules(prefix string, rules []Rule) []error {
+	var errs []error
+	for i := range rules {
+		r := &rules[i]
+		if r.Tool == "" {
+			errs = append(errs, fmt.Errorf("%s[%d]: tool is required", prefix, i))
+		}
+		if !slices.Contains([]string{Allow, Ask, Deny}, r.Action) {
+			errs = append(errs, fmt.Errorf("%s[%d]: action %q is not one of allow, ask, or deny", prefix, i, r.Action))
+		}
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s[%d]: match: %w", prefix, i, err))
+		}
+		r.re = re
+	}
+	return errs
+}
+
+// Rule returns the first of c's rules that applies to a call to tool with the given input, or nil if none does.
+func (c *Config) Rule(tool string, input json.RawMessage) *Rule {
+	if c == nil {
+		return nil
+	}
+	subject := Subject(input)
+	for i := range c.Permissions {
+		r := &c.Permissions[i]
+		if (r.Tool == "*" || r.Tool == tool) && (r.re == nil || r.re.MatchString(subject)) {
+			return r
+		}
+	}
+	return nil
+}
+
+// HasRules reports whether any of c's rules can apply to tool.
+func (c *Config) HasRules(tool string) bool {
+	return c != nil && slices.ContainsFunc(c.Permissions, func(r Rule) bool { return r.Tool == "*" || r.Tool == tool })
+}
+
+// Subject returns what rules match for a call with the given input: its command, if it has one, or else the input itself.
+func Subject(input json.RawMessage) string {
+	var in struct {
+		Command string `json:"command"`
+	}
+	if json.Unmarshal(input, &in) == nil && in.Command != "" {
+		return in.Command
+	}
+	return string(input)
+}
+
+// kindList lists the kinds of events, for errors.
+// validate checks w, which is at prefix in the file.
+func (w Webhook) validate(prefix string) []error {
+	var errs []error
+	if !strings.HasPrefix(w.URL, "https://") && !strings.HasPrefix(w.URL, "http://") {
+		errs = append(errs, fmt.Errorf("%s: url %q is not an http or https URL", prefix, w.URL))
+	}
+	for _, k := range w.Events {
+		if !slices.Contains(notify.Kinds, k) {
+			errs = append(errs, fmt.Errorf("%s: event %q is not one of %s", prefix, k, kindList()))
+		}
+	}
+	return errs
+}
+
+func kindList() string {
+	var names []string
+	for _, k := range notify.Kinds {
+		names = append(names, string(k))
+	}
+	return strings.Join(names, ", ")
+}
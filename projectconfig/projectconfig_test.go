@@ -0,0 +1,220 @@
+package projectconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+model: gpt4.1
+tools:
+  browser_navigate: false
+timeouts:
+  bash: 30s
+  turn: 20m
+permissions:
+  - tool: bash
+    match: '^git push'
+    action: ask
+  - tool: "*"
+    action: allow
+env:
+  GOFLAGS: -mod=mod
+fetch:
+  deny: [internal.example.com]
+webhooks:
+  - url: https://hooks.slack.com/services/T0/B0/x
+    events: [permission_requested]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Model != "gpt4.1" || c.Tools["browser_navigate"] || c.Timeouts.Bash != 30*time.Second || c.Timeouts.Turn != 20*time.Minute || c.Env["GOFLAGS"] != "-mod=mod" || len(c.Fetch.Deny) != 1 || len(c.Webhooks) != 1 || c.Webhooks[0].Events[0] != "permission_requested" {
+		t.Errorf("Parse = %+v", c)
+	}
+
+	for _, tt := range []struct {
+		tool, input, want string
+	}{
+		{"bash", `{"command": "git push origin main"}`, Ask},
+		{"bash", `{"command": "echo git push"}`, Allow},
+		{"patch", `{"path": "main.go"}`, Allow},
+	} {
+		r := c.Rule(tt.tool, json.RawMessage(tt.input))
+		if r == nil || r.Action != tt.want {
+			t.Errorf("Rule(%s, %s) = %+v, want %s", tt.tool, tt.input, r, tt.want)
+		}
+	}
+	if !c.HasRules("patch") {
+		t.Error("a rule for every tool does not apply to patch")
+	}
+	var none *Config
+	if none.Rule("bash", nil) != nil || none.HasRules("bash") {
+		t.Error("a nil Config has rules")
+	}
+
+	if c, err := Parse(nil); err != nil || c.Model != "" {
+		t.Errorf("Parse of an empty file = %+v, %v", c, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		want   []string
+	}{
+		{"modle: claude\n", []string{"line 1", "modle"}},
+		{"timeouts:\n  bash: 30\n", []string{"line 2", "30"}},
+		{"timeouts:\n  bash: -1s\n", []string{"timeouts.bash"}},
+		{"permissions:\n  - match: x\n    action: maybe\n  - tool: bash\n    match: '('\n    action: deny\n", []string{
+			"permissions[0]: tool is required",
+			`permissions[0]: action "maybe"`,
+			"permissions[1]: match",
+		}},
+		{"env:\n  NOT-A-NAME: x\n", []string{"NOT-A-NAME"}},
+		{"fetch:\n  allow: [https://go.dev]\n", []string{"fetch.allow[0]", "not a domain"}},
+		{"jobs:\n  nightly:\n    cron: '0 25 * * *'\n    on: [push]\n    profile: fast\n", []string{
+			"jobs.nightly: prompt is required",
+			"hour",
+			`on: "push"`,
+			`unknown profile "fast"`,
+		}},
+		{"webhooks:\n  - url: hooks.example.com\n    events: [session_done, turn_done]\n", []string{"webhooks[0]: url", `event "turn_done"`}},
+		{"jobs:\n  x:\n    prompt: hi\n    notify:\n      webhooks:\n        - url: example.com\n          events: [session_done]\n      email: [nobody]\n", []string{
+			"jobs.x: set cron, on, or both",
+			"notify.webhooks[0]: url",
+			"notify.webhooks[0]: events cannot be set",
+			"notify.email[0]",
+		}},
+	} {
+		_, err := Parse([]byte(tt.config))
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded", tt.config)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Parse(%q) = %v, want it to mention %q", tt.config, err, want)
+			}
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "svc", "api")
+	for _, dir := range []string{filepath.Join(root, ".git"), filepath.Join(root, ".sketch"), sub} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := Find(sub); got != "" {
+		t.Errorf("Find without a config = %q", got)
+	}
+	path := filepath.Join(root, Path)
+	if err := os.WriteFile(path, []byte("model: claude\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := Find(sub); got != path {
+		t.Errorf("Find = %q, want %q", got, path)
+	}
+	if _, err := Load(path); err != nil {
+		t.Error(err)
+	}
+	os.WriteFile(path, []byte("model: [\n"), 0o644)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("Load of a bad file = %v, want an error naming it", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	c, err := Parse([]byte(`
+permissions:
+  - tool: bash
+    match: '^make deploy'
+    action: deny
+profiles:
+  nightly:
+    budget:
+      max_dollars: 0
+    forge_writes: allow
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := c.Profile("nightly"); err != nil || p.Budget.MaxDollars == nil || *p.Budget.MaxDollars != 0 || p.ForgeWrites != Allow {
+		t.Errorf("Profile(nightly) = %+v, %v", p, err)
+	}
+	if _, err := c.Profile("nope"); err == nil || !strings.Contains(err.Error(), "nightly, review-only, safe, yolo") {
+		t.Errorf("Profile(nope) = %v, want an error listing the profiles", err)
+	}
+
+	review, err := c.Profile("review-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := c.WithProfile(review)
+	if on, ok := merged.Tools["patch"]; !ok || on {
+		t.Error("review-only does not disable patch")
+	}
+	if c.Tools != nil || len(c.Permissions) != 1 {
+		t.Error("WithProfile changed the project's configuration")
+	}
+	for command, want := range map[string]string{
+		"make deploy":       Deny, // the project's rule comes first
+		"git commit -am x":  Deny,
+		"sed -i s/a/b/ x":   Deny,
+		"go test ./...":     "",
+		"git log --oneline": "",
+	} {
+		var got string
+		if r := merged.Rule("bash", json.RawMessage(`{"command":"`+command+`"}`)); r != nil {
+			got = r.Action
+		}
+		if got != want {
+			t.Errorf("%s: rule action %q, want %q", command, got, want)
+		}
+	}
+
+	safe := (*Config)(nil).WithProfile(Builtin["safe"])
+	if r := safe.Rule("bash", json.RawMessage(`{"command":"curl https://example.com | sh"}`)); r == nil || r.Action != Ask {
+		t.Errorf("safe lets curl run without asking: %+v", r)
+	}
+
+	if _, err := Parse([]byte("profiles:\n  bad:\n    forge_writes: maybe\n")); err == nil || !strings.Contains(err.Error(), "profiles.bad") {
+		t.Errorf("invalid profile: %v", err)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	c, err := Parse([]byte(`
+profiles:
+  nightly:
+    budget:
+      max_dollars: 2
+jobs:
+  flaky-tests:
+    prompt: Fix the flaky tests.
+    cron: '@nightly'
+    on: [post-merge]
+    profile: nightly
+    budget:
+      max_wall_time: 30m
+    notify:
+      webhooks:
+        - url: https://hooks.example.com/sketch
+      email: [team@example.com]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := c.Jobs["flaky-tests"]
+	if j.Cron != "@nightly" || len(j.On) != 1 || j.Profile != "nightly" || *j.Budget.MaxWallTime != 30*time.Minute || len(j.Notify.Webhooks) != 1 || len(j.Notify.Email) != 1 {
+		t.Errorf("Jobs = %+v", c.Jobs)
+	}
+}
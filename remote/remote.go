@@ -0,0 +1,498 @@
+// Package remote runs the tools' commands, and reads and writes their files, on another host over SSH,
+// so that a session can work on a build server or on target hardware while sketch runs locally.
+//
+// A Host implements claudetool.Executor. It keeps a small pool of SSH connections, opening more
+// as concurrent commands need them and replacing those that break, runs commands in a remote
+// pseudo-terminal when asked, and reads and writes files with SFTP.
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// maxConns is how many connections a Host keeps open at most.
+const maxConns = 4
+
+// maxSessionsPerConn is how many commands a Host runs at once on each connection,
+// under the default MaxSessions of OpenSSH's sshd, which is 10.
+const maxSessionsPerConn = 8
+
+// dialTimeout is how long a Host waits for a connection to be established.
+const dialTimeout = 15 * time.Second
+
+// A Target is where a session works: a directory on a host that sketch reaches over SSH.
+type Target struct {
+	User string // if empty, the local user's name
+	Addr string // host:port
+	Dir  string // absolute
+}
+
+// ParseTarget parses a target written as [user@]host:/dir, as scp does,
+// or as ssh://[user@]host[:port]/dir.
+func ParseTarget(s string) (Target, error) {
+	var t Target
+	if strings.HasPrefix(s, "ssh://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return t, err
+		}
+		t.User = u.User.Username()
+		t.Addr = u.Host
+		t.Dir = u.Path
+	} else {
+		host, dir, ok := strings.Cut(s, ":")
+		if !ok {
+			return t, fmt.Errorf("remote target %q is not [user@]host:/dir or ssh://[user@]host[:port]/dir", s)
+		}
+		if u, h, ok := strings.Cut(host, "@"); ok {
+			t.User, host = u, h
+		}
+		t.Addr, t.Dir = host, dir
+	}
+	if t.Addr == "" {
+		return t, fmt.Errorf("remote target %q has no host", s)
+	}
+	if _, _, err := net.SplitHostPort(t.Addr); err != nil {
+		t.Addr = net.JoinHostPort(t.Addr, "22")
+	}
+	if !path.IsAbs(t.Dir) {
+		return t, fmt.Errorf("remote target %q: the directory must be absolute", s)
+	}
+	t.Dir = path.Clean(t.Dir)
+	if t.User == "" {
+		if u, err := user.Current(); err == nil {
+			t.User = u.Username
+		}
+	}
+	return t, nil
+}
+
+func (t Target) String() string {
+	return t.User + "@" + t.Addr + ":" + t.Dir
+}
+
+// ClientConfig returns the SSH client configuration that Dial uses by default for user:
+// it authenticates with the keys of the running ssh-agent, and ~/.ssh's unencrypted keys,
+// and verifies the host against ~/.ssh/known_hosts.
+func ClientConfig(user string) (*ssh.ClientConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify remote hosts without ~/.ssh/known_hosts; run ssh to the host once to add it: %w", err)
+	}
+	var auth []ssh.AuthMethod
+	if _, err := agentClient(); err == nil {
+		auth = append(auth, ssh.PublicKeysCallback(agentSigners))
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			continue // encrypted keys need ssh-agent
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no SSH keys: start ssh-agent and add a key, or create an unencrypted key in ~/.ssh")
+	}
+	return &ssh.ClientConfig{User: user, Auth: auth, HostKeyCallback: hostKeys, Timeout: dialTimeout}, nil
+}
+
+// sshAgent is the process's connection to the running ssh-agent, shared by every ClientConfig
+// so that configs do not each hold a socket open.
+var sshAgent struct {
+	sync.Mutex
+	conn   net.Conn
+	client agent.ExtendedAgent
+}
+
+// agentClient returns the connection to the ssh-agent at $SSH_AUTH_SOCK, dialing it if there is none.
+func agentClient() (agent.ExtendedAgent, error) {
+	sshAgent.Lock()
+	defer sshAgent.Unlock()
+	if sshAgent.client != nil {
+		return sshAgent.client, nil
+	}
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("no ssh-agent: SSH_AUTH_SOCK is not set")
+	}
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to ssh-agent: %w", err)
+	}
+	sshAgent.conn, sshAgent.client = c, agent.NewClient(c)
+	return sshAgent.client, nil
+}
+
+// agentSigners lists the ssh-agent's keys. If the connection has failed, for example because the agent
+// restarted, it is closed, and the next call dials again.
+func agentSigners() ([]ssh.Signer, error) {
+	client, err := agentClient()
+	if err != nil {
+		return nil, err
+	}
+	signers, err := client.Signers()
+	if err != nil {
+		sshAgent.Lock()
+		if sshAgent.client == client {
+			sshAgent.conn.Close()
+			sshAgent.conn, sshAgent.client = nil, nil
+		}
+		sshAgent.Unlock()
+	}
+	return signers, err
+}
+
+// A Host runs commands and reads and writes files on a remote host.
+type Host struct {
+	addr   string
+	config *ssh.ClientConfig
+	slots  chan struct{} // limits the commands running at once to what the pool can hold
+
+	mu       sync.Mutex
+	conns    []*conn
+	sftp     *sftp.Client
+	sftpConn *ssh.Client
+	closed   bool
+}
+
+// A conn is a pooled connection, with the number of commands running on it.
+type conn struct {
+	client   *ssh.Client
+	sessions int
+}
+
+// Dial connects to the SSH server at addr (host:port) with config, and returns a Host for it.
+func Dial(ctx context.Context, addr string, config *ssh.ClientConfig) (*Host, error) {
+	h := &Host{addr: addr, config: config, slots: make(chan struct{}, maxConns*maxSessionsPerConn)}
+	client, err := h.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h.conns = append(h.conns, &conn{client: client})
+	return h, nil
+}
+
+func (h *Host) dial(ctx context.Context) (*ssh.Client, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", h.addr)
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s: %w", h.addr, err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(nc, h.addr, h.config)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("ssh %s: %w", h.addr, err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Close closes h's connections. Commands that are running are cut off.
+func (h *Host) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, c := range h.conns {
+		c.client.Close()
+	}
+	h.conns = nil
+	if h.sftpConn != nil {
+		h.sftp.Close()
+		h.sftpConn.Close()
+		h.sftp, h.sftpConn = nil, nil
+	}
+	return nil
+}
+
+// session opens a session on the least busy connection in the pool, opening a connection if they are all busy,
+// and returns it with a function that releases its place in the pool once the session is closed.
+// Connections are dialed, and sessions opened, without holding h.mu, so that a slow host does not hold up
+// the commands that other connections could run.
+func (h *Host) session(ctx context.Context) (*ssh.Session, func(), error) {
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	for attempt := 0; ; attempt++ {
+		c, err := h.pick(ctx)
+		if err != nil {
+			<-h.slots
+			return nil, nil, err
+		}
+		s, err := c.client.NewSession()
+		if err != nil {
+			// The connection broke; replace it, once.
+			c.client.Close()
+			h.mu.Lock()
+			c.sessions--
+			h.removeConn(c)
+			h.mu.Unlock()
+			if attempt == 0 {
+				continue
+			}
+			<-h.slots
+			return nil, nil, fmt.Errorf("ssh %s: %w", h.addr, err)
+		}
+		release := func() {
+			h.mu.Lock()
+			c.sessions--
+			h.mu.Unlock()
+			<-h.slots
+		}
+		return s, release, nil
+	}
+}
+
+// pick returns the connection that a new session should use, counting the session on it,
+// and dials a new one if every connection is busy and the pool has room.
+// If another caller added a connection while this one dialed, pick uses that one if it can, and closes its own.
+func (h *Host) pick(ctx context.Context) (*conn, error) {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, errors.New("remote host is closed")
+	}
+	c := h.leastBusy()
+	if c != nil && (c.sessions == 0 || len(h.conns) >= maxConns) {
+		c.sessions++
+		h.mu.Unlock()
+		return c, nil
+	}
+	h.mu.Unlock()
+
+	client, err := h.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		client.Close()
+		return nil, errors.New("remote host is closed")
+	}
+	if c := h.leastBusy(); c != nil && (c.sessions == 0 || len(h.conns) >= maxConns) {
+		client.Close()
+		c.sessions++
+		return c, nil
+	}
+	c = &conn{client: client, sessions: 1}
+	h.conns = append(h.conns, c)
+	return c, nil
+}
+
+// leastBusy returns the connection in the pool with the fewest sessions, or nil if every connection is full.
+// h.mu must be held.
+func (h *Host) leastBusy() *conn {
+	var c *conn
+	for _, cand := range h.conns {
+		if cand.sessions < maxSessionsPerConn && (c == nil || cand.sessions < c.sessions) {
+			c = cand
+		}
+	}
+	return c
+}
+
+func (h *Host) removeConn(c *conn) {
+	for i, cand := range h.conns {
+		if cand == c {
+			h.conns = append(h.conns[:i], h.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// Run runs command with bash in dir on the remote host, with env (KEY=value) added to its environment,
+// writing its output to out, in a pseudo-terminal if tty is set, until it exits or ctx is done.
+// A command that fails returns an *ssh.ExitError. When ctx is done, the command is killed:
+// in a pseudo-terminal, with everything it started.
+func (h *Host) Run(ctx context.Context, dir, command string, env []string, tty bool, out io.Writer) error {
+	s, release, err := h.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer s.Close()
+	if tty {
+		modes := ssh.TerminalModes{ssh.ECHO: 0, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}
+		if err := s.RequestPty("xterm-256color", 50, 200, modes); err != nil {
+			return fmt.Errorf("remote pseudo-terminal: %w", err)
+		}
+	}
+	w := &syncWriter{w: out}
+	s.Stdout, s.Stderr = w, w
+	if err := s.Start(Script(dir, command, env)); err != nil {
+		return fmt.Errorf("ssh %s: %w", h.addr, err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// Closing the session hangs up its pseudo-terminal, which kills its processes;
+		// servers that accept signals also kill the command itself.
+		s.Signal(ssh.SIGKILL)
+		s.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Script returns the shell command line that runs command with bash in dir, with env added to its environment.
+func Script(dir, command string, env []string) string {
+	var b strings.Builder
+	b.WriteString("cd " + Quote(dir) + " && ")
+	for _, kv := range env {
+		b.WriteString("export " + Quote(kv) + " && ")
+	}
+	b.WriteString("exec bash -c " + Quote(command))
+	return b.String()
+}
+
+// Quote quotes s for a POSIX shell.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// A syncWriter serializes writes from a session's stdout and stderr.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// sftpClient returns h's SFTP client, which has a connection of its own, connecting it if need be.
+// If another caller connected one while this one was connecting, it returns that one and closes its own.
+func (h *Host) sftpClient(ctx context.Context) (*sftp.Client, error) {
+	h.mu.Lock()
+	closed, s := h.closed, h.sftp
+	h.mu.Unlock()
+	if closed {
+		return nil, errors.New("remote host is closed")
+	}
+	if s != nil {
+		return s, nil
+	}
+	client, err := h.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, err = sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("sftp %s: %w", h.addr, err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || h.sftp != nil {
+		s.Close()
+		client.Close()
+		if h.closed {
+			return nil, errors.New("remote host is closed")
+		}
+		return h.sftp, nil
+	}
+	h.sftp, h.sftpConn = s, client
+	return s, nil
+}
+
+// withSFTP calls f with h's SFTP client, and again with a new one if the connection broke.
+func (h *Host) withSFTP(ctx context.Context, f func(*sftp.Client) error) error {
+	for attempt := 0; ; attempt++ {
+		c, err := h.sftpClient(ctx)
+		if err != nil {
+			return err
+		}
+		err = f(c)
+		if attempt > 0 || !connectionLost(err) {
+			return err
+		}
+		h.mu.Lock()
+		if h.sftp == c {
+			h.sftp.Close()
+			h.sftpConn.Close()
+			h.sftp, h.sftpConn = nil, nil
+		}
+		h.mu.Unlock()
+	}
+}
+
+// connectionLost reports whether err says that an SFTP client's connection is gone.
+func connectionLost(err error) bool {
+	return err != nil && (errors.Is(err, io.EOF) || errors.Is(err, sftp.ErrSSHFxConnectionLost) || strings.Contains(err.Error(), "connection lost"))
+}
+
+// ReadFile returns the contents of the remote file at name.
+// If there is no such file, the error wraps fs.ErrNotExist.
+func (h *Host) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	var data []byte
+	err := h.withSFTP(ctx, func(c *sftp.Client) error {
+		f, err := c.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data, err = io.ReadAll(f)
+		return err
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// WriteFile replaces the contents of the remote file at name with data, creating it and its directory if need be.
+func (h *Host) WriteFile(ctx context.Context, name string, data []byte) error {
+	err := h.withSFTP(ctx, func(c *sftp.Client) error {
+		if err := c.MkdirAll(path.Dir(name)); err != nil {
+			return err
+		}
+		f, err := c.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+	if err != nil {
+		return &os.PathError{Op: "write", Path: name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startServer starts an SSH server that runs commands with bash on this machine, and serves SFTP,
+// and returns a Host connected to it.
+func startServer(t *testing.T) *Host {
+	t.Helper()
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := gossh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+	clientSigner, err := gossh.NewSignerFromKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &ssh.Server{
+		Handler: func(s ssh.Session) {
+			cmd := exec.Command("bash", "-c", s.RawCommand())
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+			copied := make(chan struct{})
+			if _, _, isPty := s.Pty(); isPty {
+				f, err := pty.Start(cmd)
+				if err != nil {
+					s.Exit(255)
+					return
+				}
+				defer f.Close()
+				go func() {
+					io.Copy(s, f)
+					close(copied)
+				}()
+			} else {
+				close(copied)
+				cmd.Stdout, cmd.Stderr = s, s.Stderr()
+				if err := cmd.Start(); err != nil {
+					s.Exit(255)
+					return
+				}
+			}
+			go func() {
+				<-s.Context().Done()
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			}()
+			cmd.Wait()
+			<-copied
+			s.Exit(cmd.ProcessState.ExitCode())
+		},
+		SubsystemHandlers: map[string]ssh.SubsystemHandler{
+			"sftp": func(s ssh.Session) {
+				server, err := sftp.NewServer(s)
+				if err != nil {
+					return
+				}
+				server.Serve()
+				server.Close()
+			},
+		},
+		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
+			return ssh.KeysEqual(key, clientSigner.PublicKey())
+		},
+	}
+	srv.AddHostKey(hostSigner)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	config := &gossh.ClientConfig{
+		User:            "test",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(clientSigner)},
+		HostKeyCallback: gossh.FixedHostKey(hostSigner.PublicKey()),
+	}
+	h, err := Dial(context.Background(), ln.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestParseTarget(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want Target
+	}{
+		{"alice@build:/src/app", Target{User: "alice", Addr: "build:22", Dir: "/src/app"}},
+		{"ssh://alice@build:2222/src/app/", Target{User: "alice", Addr: "build:2222", Dir: "/src/app"}},
+	} {
+		got, err := ParseTarget(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTarget(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"build", "alice@build:src", "ssh://alice@build", ":/src"} {
+		if _, err := ParseTarget(bad); err == nil {
+			t.Errorf("ParseTarget(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	h := startServer(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	var out bytes.Buffer
+	err := h.Run(ctx, dir, `echo "$PWD $GREETING"; [ -t 1 ] && echo tty; echo oops >&2`, []string{"GREETING=it's me"}, false, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stdout and stderr arrive on different channels, so their lines may come in either order.
+	if got := out.String(); len(got) != len(dir+" it's me\noops\n") || !strings.Contains(got, dir+" it's me\n") || !strings.Contains(got, "oops\n") {
+		t.Errorf("Run output = %q, want the lines %q and %q", got, dir+" it's me", "oops")
+	}
+
+	out.Reset()
+	if err := h.Run(ctx, dir, `[ -t 1 ] && echo tty`, nil, true, &out); err != nil || !strings.Contains(out.String(), "tty") {
+		t.Errorf("Run with a tty = %q, %v", out.String(), err)
+	}
+
+	var exitErr *gossh.ExitError
+	if err := h.Run(ctx, dir, "exit 3", nil, false, io.Discard); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("Run of a failing command = %v, want exit status 3", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := h.Run(ctx, dir, "sleep 30", nil, true, io.Discard); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run past its deadline = %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Run past its deadline took %s to return", d)
+	}
+}
+
+func TestRunPool(t *testing.T) {
+	h := startServer(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- h.Run(context.Background(), "/", "sleep 0.1", nil, false, io.Discard)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.conns) > maxConns {
+		t.Errorf("pool has %d connections, want at most %d", len(h.conns), maxConns)
+	}
+	for _, c := range h.conns {
+		if c.sessions != 0 {
+			t.Errorf("connection has %d sessions after every command finished", c.sessions)
+		}
+	}
+}
+
+// TestDialWithoutLock checks that a host that is slow to answer a new connection does not hold up the Host:
+// it can be closed while the connection is dialed, and the connection is then discarded.
+func TestDialWithoutLock(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			accepted <- c // and never answer the handshake
+		}
+	}()
+	config := &gossh.ClientConfig{User: "test", HostKeyCallback: gossh.InsecureIgnoreHostKey()}
+	h := &Host{addr: ln.Addr().String(), config: config, slots: make(chan struct{}, maxConns*maxSessionsPerConn)}
+
+	ran := make(chan error, 1)
+	go func() { ran <- h.Run(context.Background(), "/", "true", nil, false, io.Discard) }()
+	var nc net.Conn
+	select {
+	case nc = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not dial")
+	}
+	closed := make(chan struct{})
+	go func() {
+		h.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for the dial")
+	}
+	nc.Close()
+	if err := <-ran; err == nil {
+		t.Error("Run on a host that never answered succeeded")
+	}
+	if len(h.conns) != 0 {
+		t.Errorf("closed host has %d connections", len(h.conns))
+	}
+}
+
+func TestFiles(t *testing.T) {
+	h := startServer(t)
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "sub", "f.txt")
+
+	if _, err := h.ReadFile(ctx, name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile of a missing file = %v, want fs.ErrNotExist", err)
+	}
+	if err := h.WriteFile(ctx, name, []byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WriteFile(ctx, name, []byte("hi\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(name); err != nil || string(data) != "hi\n" {
+		t.Errorf("written file = %q, %v", data, err)
+	}
+	if data, err := h.ReadFile(ctx, name); err != nil || string(data) != "hi\n" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+}
+
+func TestClientConfigSharesAgent(t *testing.T) {
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go agent.ServeAgent(keyring, c)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	t.Cleanup(func() {
+		sshAgent.Lock()
+		if sshAgent.conn != nil {
+			sshAgent.conn.Close()
+		}
+		sshAgent.conn, sshAgent.client = nil, nil
+		sshAgent.Unlock()
+	})
+
+	for range 5 {
+		config, err := ClientConfig("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(config.Auth) != 1 {
+			t.Fatalf("config has %d auth methods, want the agent's", len(config.Auth))
+		}
+		signers, err := agentSigners()
+		if err != nil || len(signers) != 1 {
+			t.Fatalf("agentSigners() = %d signers, %v; want 1", len(signers), err)
+		}
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("5 configs opened %d agent connections, want 1", n)
+	}
+}
@@ -0,0 +1,177 @@
+// Package cron parses cron schedules, as in crontab(5), and finds the times they run at.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule is a parsed cron schedule: the minutes, hours, days of the month, months, and days of the week it runs in.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // bit n is set if the schedule runs at value n
+	// domStar and dowStar record whether the day fields were *, since a day matches
+	// either day field when both are restricted, as in crontab(5).
+	domStar, dowStar bool
+}
+
+// shorthands are the schedules with names.
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@nightly":  "0 2 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field describes one of a schedule's five fields.
+type field struct {
+	name     string
+	min, max int
+	names    []string // names of the values from min, such as month and day names
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// Parse parses spec: five fields, for the minute, hour, day of the month, month, and day of the week,
+// each of which is *, a value, a range such as 1-5, or a list of them such as 1,15, optionally with a step such as */15;
+// or one of the shorthands @hourly, @daily (or @midnight), @nightly (at 2:00), @weekly, @monthly, and @yearly.
+// Months and days of the week may be given by their first three letters, and Sunday is 0 or 7.
+func Parse(spec string) (*Schedule, error) {
+	s := strings.TrimSpace(spec)
+	if strings.HasPrefix(s, "@") {
+		expanded, ok := shorthands[strings.ToLower(s)]
+		if !ok {
+			return nil, fmt.Errorf("cron schedule %q: unknown shorthand", spec)
+		}
+		s = expanded
+	}
+	parts := strings.Fields(s)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron schedule %q: want 5 fields (minute hour day-of-month month day-of-week), got %d", spec, len(parts))
+	}
+	var sched Schedule
+	sets := []*uint64{&sched.minute, &sched.hour, &sched.dom, &sched.month, &sched.dow}
+	for i, f := range fields {
+		set, err := f.parse(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron schedule %q: %s: %w", spec, f.name, err)
+		}
+		*sets[i] = set
+	}
+	if sched.dow&(1<<7) != 0 {
+		sched.dow |= 1 // 7 is Sunday too
+	}
+	sched.domStar = parts[2] == "*"
+	sched.dowStar = parts[4] == "*"
+	return &sched, nil
+}
+
+// parse parses the field's text into a set of values.
+func (f field) parse(text string) (uint64, error) {
+	var set uint64
+	for item := range strings.SplitSeq(text, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepText)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(loText); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(hiText); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max // as in 5/15, every 15 from 5
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("range %q runs backwards", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// value parses a single value of the field, a number or a name.
+func (f field) value(text string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(text, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", text)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%d is not between %d and %d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// maxSearch bounds how far ahead Next looks; a schedule such as 30 February never runs.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first time after t that s runs at, in t's location,
+// or the zero time if s never runs.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		switch {
+		case !s.has(s.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.has(s.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) has(set uint64, v int) bool {
+	return set&(1<<v) != 0
+}
+
+// dayMatches reports whether s runs on t's day.
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom, dow := s.has(s.dom, t.Day()), s.has(s.dow, int(t.Weekday()))
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,42 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, time.March, 14, 10, 7, 30, 0, time.UTC) // a Saturday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.March, 14, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.March, 14, 10, 15, 0, 0, time.UTC)},
+		{"@nightly", time.Date(2026, time.March, 15, 2, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2026, time.March, 16, 9, 0, 0, 0, time.UTC)},
+		{"30 6 1,15 * *", time.Date(2026, time.March, 15, 6, 30, 0, 0, time.UTC)},
+		{"0 0 * jun 7", time.Date(2026, time.June, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2026, time.March, 20, 0, 0, 0, 0, time.UTC)}, // either day field matches
+		{"5/20 10 * * *", time.Date(2026, time.March, 14, 10, 25, 0, 0, time.UTC)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Parse(%q).Next = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@sometimes", "* * * smarch *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"sketch.dev/notify"
+	"sketch.dev/projectconfig"
+)
+
+// Deliver delivers r where to says: as a notify.JobDone event to its webhooks, and mailed to its addresses
+// through the SMTP server that the environment configures; see MailerFromEnv.
+func Deliver(ctx context.Context, to projectconfig.JobNotify, r *Result) error {
+	var errs []error
+	for _, w := range to.Webhooks {
+		hook := &notify.Webhook{URL: w.URL}
+		if err := hook.Notify(ctx, r.event()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(to.Email) > 0 {
+		m, err := MailerFromEnv()
+		if err == nil {
+			err = m.Send(to.Email, r)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// event returns r as a notify.JobDone event.
+func (r *Result) event() notify.Event {
+	e := notify.Event{
+		Kind:         notify.JobDone,
+		Job:          r.Job,
+		SessionID:    r.SessionID,
+		Outcome:      r.Status,
+		Elapsed:      time.Duration(r.DurationSeconds * float64(time.Second)),
+		Detail:       r.FinalMessage,
+		CostUSD:      r.CostUSD,
+		FilesChanged: r.FilesChanged,
+	}
+	if r.Error != "" {
+		e.Err = errors.New(r.Error)
+	}
+	return e
+}
+
+// A Mailer mails results through an SMTP server.
+// Its settings come from the environment, not the project configuration, since they include a password.
+type Mailer struct {
+	Addr     string // host:port of the SMTP server
+	From     string
+	Username string // if set, the mailer authenticates with PLAIN auth
+	Password string
+}
+
+// MailerFromEnv returns a Mailer configured by $SKETCH_SMTP_ADDR, $SKETCH_SMTP_FROM,
+// $SKETCH_SMTP_USERNAME, and $SKETCH_SMTP_PASSWORD.
+func MailerFromEnv() (*Mailer, error) {
+	m := &Mailer{
+		Addr:     os.Getenv("SKETCH_SMTP_ADDR"),
+		From:     os.Getenv("SKETCH_SMTP_FROM"),
+		Username: os.Getenv("SKETCH_SMTP_USERNAME"),
+		Password: os.Getenv("SKETCH_SMTP_PASSWORD"),
+	}
+	if m.Addr == "" || m.From == "" {
+		return nil, fmt.Errorf("email: set SKETCH_SMTP_ADDR and SKETCH_SMTP_FROM to mail results")
+	}
+	return m, nil
+}
+
+// Send mails r to the addresses to.
+func (m *Mailer) Send(to []string, r *Result) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := strings.Cut(m.Addr, ":")
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	if err := smtp.SendMail(m.Addr, auth, m.From, to, formatMail(m.From, to, r)); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	return nil
+}
+
+// formatMail returns the message that mails r.
+func formatMail(from string, to []string, r *Result) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: sketch job %s: %s\r\n", r.Job, r.Status)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "Job %s (%s) started %s and ended with status %s.\r\n", r.Job, r.Trigger, r.Started.Format("2006-01-02 15:04 MST"), r.Status)
+	if r.SessionID != "" {
+		fmt.Fprintf(&b, "Session %s cost $%.2f over %.0fs.\r\n", r.SessionID, r.CostUSD, r.DurationSeconds)
+	}
+	if r.Error != "" {
+		fmt.Fprintf(&b, "\r\n%s\r\n", strings.ReplaceAll(r.Error, "\n", "\r\n"))
+	}
+	if len(r.FilesChanged) > 0 {
+		fmt.Fprintf(&b, "\r\nFiles changed:\r\n")
+		for _, f := range r.FilesChanged {
+			fmt.Fprintf(&b, "  %s\r\n", f)
+		}
+	}
+	if r.FinalMessage != "" {
+		fmt.Fprintf(&b, "\r\n%s\r\n", strings.ReplaceAll(r.FinalMessage, "\n", "\r\n"))
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,232 @@
+// Package schedule runs a project's jobs (see projectconfig.Job): headless sketch sessions
+// that start on cron schedules or after git merges, such as a nightly run that fixes flaky tests,
+// and whose results are delivered by webhook or email.
+// It backs `sketch schedule`.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/projectconfig"
+	"sketch.dev/schedule/cron"
+)
+
+// A Result is how a job's session ended, as its headless JSON output reports it.
+type Result struct {
+	Job     string    `json:"job"`
+	Trigger string    `json:"trigger"` // what started the session: cron, run, or a git event such as post-merge
+	Started time.Time `json:"started"`
+
+	SessionID       string   `json:"session_id"`
+	Status          string   `json:"status"` // done, unfinished, budget_exceeded, or error
+	ExitCode        int      `json:"exit_code"`
+	FinalMessage    string   `json:"final_message"`
+	FilesChanged    []string `json:"files_changed"`
+	CostUSD         float64  `json:"cost_usd"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	// Error says why the session did not produce a result, if it did not.
+	Error string `json:"error,omitempty"`
+}
+
+// A Scheduler runs jobs when they are due and delivers their results.
+type Scheduler struct {
+	// Jobs are the jobs to run, by name.
+	Jobs map[string]projectconfig.Job
+	// RepoRoot is the repository that the jobs' git events happen in.
+	RepoRoot string
+	// Run runs a job's session; see Session.Run.
+	Run func(ctx context.Context, name string, job projectconfig.Job) *Result
+	// Deliver delivers a job's result; by default, Deliver.
+	Deliver func(ctx context.Context, notify projectconfig.JobNotify, r *Result) error
+	// PollInterval is how often Serve looks for git events; by default, 30 seconds.
+	PollInterval time.Duration
+
+	mu      sync.Mutex
+	running map[string]bool // the jobs whose sessions are running
+	wg      sync.WaitGroup
+}
+
+// Serve runs the jobs on their schedules, and after the git events they run on, until ctx is done.
+// A job that is due while its previous session is still running is skipped.
+func (s *Scheduler) Serve(ctx context.Context) error {
+	schedules := make(map[string]*cron.Schedule)
+	next := make(map[string]time.Time)
+	for name, job := range s.Jobs {
+		if job.Cron == "" {
+			continue
+		}
+		sched, err := cron.Parse(job.Cron)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", name, err)
+		}
+		schedules[name] = sched
+		next[name] = sched.Next(time.Now())
+	}
+	var head string
+	watchGit := len(s.jobsOn("post-merge")) > 0
+	if watchGit {
+		var err error
+		if head, err = git(ctx, s.RepoRoot, "rev-parse", "HEAD"); err != nil {
+			return err
+		}
+	}
+	poll := time.NewTicker(orDefault(s.PollInterval, 30*time.Second))
+	defer poll.Stop()
+	defer s.wg.Wait()
+
+	for {
+		var due <-chan time.Time
+		if wake := earliest(next); !wake.IsZero() {
+			due = time.After(time.Until(wake))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-due:
+			now := time.Now()
+			for _, name := range slices.Sorted(maps.Keys(next)) {
+				if t := next[name]; !t.IsZero() && !now.Before(t) {
+					s.start(ctx, name, "cron")
+					next[name] = schedules[name].Next(now)
+				}
+			}
+		case <-poll.C:
+			if !watchGit {
+				continue
+			}
+			merged, newHead, err := s.merged(ctx, head)
+			if err != nil {
+				slog.WarnContext(ctx, "schedule_git_poll_failed", "error", err)
+				continue
+			}
+			head = newHead
+			if merged {
+				for _, name := range s.jobsOn("post-merge") {
+					s.start(ctx, name, "post-merge")
+				}
+			}
+		}
+	}
+}
+
+// merged reports whether HEAD has moved from head by a merge, such as by git pull, and returns where it is.
+func (s *Scheduler) merged(ctx context.Context, head string) (bool, string, error) {
+	newHead, err := git(ctx, s.RepoRoot, "rev-parse", "HEAD")
+	if err != nil || newHead == head {
+		return false, newHead, err
+	}
+	// The reflog says how HEAD moved: "merge <branch>: ...", "pull: Fast-forward", and the like.
+	how, err := git(ctx, s.RepoRoot, "reflog", "-1", "--format=%gs", "HEAD")
+	if err != nil {
+		return false, newHead, err
+	}
+	return strings.HasPrefix(how, "merge ") || strings.HasPrefix(how, "pull"), newHead, nil
+}
+
+// Event runs the jobs that run on the git event, such as post-merge, and waits for them.
+// It is what a git hook calls.
+func (s *Scheduler) Event(ctx context.Context, event string) {
+	for _, name := range s.jobsOn(event) {
+		s.start(ctx, name, event)
+	}
+	s.wg.Wait()
+}
+
+// RunJob runs the job named name now, delivers its result, and returns it.
+func (s *Scheduler) RunJob(ctx context.Context, name string) (*Result, error) {
+	if _, ok := s.Jobs[name]; !ok {
+		return nil, fmt.Errorf("no job named %q", name)
+	}
+	if !s.claim(name) {
+		return nil, fmt.Errorf("job %s is already running", name)
+	}
+	return s.run(ctx, name, "run"), nil
+}
+
+// start runs the job named name in the background, unless it is running already.
+func (s *Scheduler) start(ctx context.Context, name, trigger string) {
+	if !s.claim(name) {
+		slog.InfoContext(ctx, "schedule_job_skipped", "job", name, "trigger", trigger, "reason", "still running")
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(ctx, name, trigger)
+	}()
+}
+
+// claim marks the job named name as running, reporting whether it was not already.
+func (s *Scheduler) claim(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[name] {
+		return false
+	}
+	if s.running == nil {
+		s.running = make(map[string]bool)
+	}
+	s.running[name] = true
+	return true
+}
+
+// run runs the job named name, which the caller has claimed, and delivers its result.
+func (s *Scheduler) run(ctx context.Context, name, trigger string) *Result {
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, name)
+		s.mu.Unlock()
+	}()
+	job := s.Jobs[name]
+	slog.InfoContext(ctx, "schedule_job_started", "job", name, "trigger", trigger)
+	started := time.Now()
+	r := s.Run(ctx, name, job)
+	r.Job, r.Trigger, r.Started = name, trigger, started
+	slog.InfoContext(ctx, "schedule_job_finished", "job", name, "status", r.Status, "session_id", r.SessionID, "cost_usd", r.CostUSD, "error", r.Error)
+	deliver := s.Deliver
+	if deliver == nil {
+		deliver = Deliver
+	}
+	if err := deliver(ctx, job.Notify, r); err != nil {
+		slog.WarnContext(ctx, "schedule_delivery_failed", "job", name, "error", err)
+	}
+	return r
+}
+
+// jobsOn returns the names of the jobs that run on the git event, sorted.
+func (s *Scheduler) jobsOn(event string) []string {
+	var names []string
+	for name, job := range s.Jobs {
+		if slices.Contains(job.On, event) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// earliest returns the earliest of times that is not zero, or the zero time if there is none.
+func earliest(times map[string]time.Time) time.Time {
+	var first time.Time
+	for _, t := range times {
+		if !t.IsZero() && (first.IsZero() || t.Before(first)) {
+			first = t
+		}
+	}
+	return first
+}
+
+// orDefault returns d, or def if d is not positive.
+func orDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
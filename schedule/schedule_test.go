@@ -0,0 +1,151 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sketch.dev/projectconfig"
+)
+
+func gitRun(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %s: %v", args, out, err)
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	var got struct {
+		Text    string `json:"text"`
+		Event   string `json:"event"`
+		Job     string `json:"job"`
+		Outcome string `json:"outcome"`
+		Detail  string `json:"detail"`
+	}
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer hook.Close()
+
+	release := make(chan struct{})
+	s := &Scheduler{
+		Jobs: map[string]projectconfig.Job{
+			"flaky": {Prompt: "Fix the flaky tests.", Cron: "@nightly", Notify: projectconfig.JobNotify{Webhooks: []projectconfig.Webhook{{URL: hook.URL}}}},
+		},
+		Run: func(ctx context.Context, name string, job projectconfig.Job) *Result {
+			<-release
+			return &Result{SessionID: "s1", Status: "done", FinalMessage: "Fixed TestFoo."}
+		},
+	}
+	ctx := context.Background()
+	s.start(ctx, "flaky", "cron")
+	if _, err := s.RunJob(ctx, "flaky"); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("RunJob while the job runs = %v", err)
+	}
+	close(release)
+	s.wg.Wait()
+	if got.Event != "job_done" || got.Job != "flaky" || got.Outcome != "done" || got.Detail != "Fixed TestFoo." || got.Text != "sketch: Job flaky finished (done): Fixed TestFoo." {
+		t.Errorf("webhook got %+v", got)
+	}
+
+	r, err := s.RunJob(ctx, "flaky")
+	if err != nil || r.Trigger != "run" {
+		t.Errorf("RunJob = %+v, %v", r, err)
+	}
+	if _, err := s.RunJob(ctx, "nope"); err == nil {
+		t.Error("RunJob of an unknown job succeeded")
+	}
+}
+
+func TestPostMerge(t *testing.T) {
+	repo := t.TempDir()
+	gitRun(t, repo, "init", "-q", "-b", "main")
+	gitRun(t, repo, "commit", "-q", "--allow-empty", "-m", "initial")
+	gitRun(t, repo, "branch", "feature")
+
+	var mu sync.Mutex
+	var ran []string
+	s := &Scheduler{
+		Jobs: map[string]projectconfig.Job{
+			"after-merge": {Prompt: "Check the merge.", On: []string{"post-merge"}},
+			"nightly":     {Prompt: "Fix the flaky tests.", Cron: "@nightly"},
+		},
+		RepoRoot: repo,
+		Run: func(ctx context.Context, name string, job projectconfig.Job) *Result {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, name)
+			return &Result{Status: "done"}
+		},
+		Deliver:      func(context.Context, projectconfig.JobNotify, *Result) error { return nil },
+		PollInterval: 10 * time.Millisecond,
+	}
+	ranJobs := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(ran)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Serve(ctx) }()
+
+	// A commit is not a merge.
+	gitRun(t, repo, "commit", "-q", "--allow-empty", "-m", "local work")
+	time.Sleep(100 * time.Millisecond)
+	if r := ranJobs(); len(r) != 0 {
+		t.Errorf("a commit ran %v", r)
+	}
+
+	gitRun(t, repo, "checkout", "-q", "feature")
+	gitRun(t, repo, "commit", "-q", "--allow-empty", "-m", "feature work")
+	gitRun(t, repo, "checkout", "-q", "main")
+	gitRun(t, repo, "merge", "-q", "--no-edit", "feature")
+	for deadline := time.Now().Add(5 * time.Second); len(ranJobs()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if r := ranJobs(); !slices.Equal(r, []string{"after-merge"}) {
+		t.Errorf("a merge ran %v, want after-merge", r)
+	}
+}
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sketch")
+	os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" > args
+echo '{"session_id":"s1","status":"budget_exceeded","exit_code":3,"files_changed":["a.go"]}'
+exit 3
+`), 0o755)
+	dollars := 2.5
+	s := &Session{Executable: script, Dir: dir, Args: []string{"-unsafe"}}
+	r := s.Run(context.Background(), "flaky", projectconfig.Job{Prompt: "Fix it.", Budget: projectconfig.Budget{MaxDollars: &dollars}})
+	if r.SessionID != "s1" || r.Status != "budget_exceeded" || r.ExitCode != 3 || len(r.FilesChanged) != 1 {
+		t.Errorf("Run = %+v", r)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if want := "-prompt Fix it. -output=json -profile ci -max-dollars 2.5 -unsafe\n"; string(args) != want {
+		t.Errorf("sketch ran with %q, want %q", args, want)
+	}
+
+	os.WriteFile(script, []byte("#!/bin/sh\necho 'no API key' >&2\nexit 1\n"), 0o755)
+	if r := s.Run(context.Background(), "flaky", projectconfig.Job{Prompt: "Fix it."}); r.Status != "error" || !strings.Contains(r.Error, "no API key") {
+		t.Errorf("Run of a failing sketch = %+v", r)
+	}
+}
@@ -0,0 +1,89 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"sketch.dev/projectconfig"
+)
+
+// A Session runs jobs as headless sketch sessions: sketch -prompt with -output=json.
+type Session struct {
+	// Executable is the sketch executable.
+	Executable string
+	// Dir is the repository the sessions work in.
+	Dir string
+	// Args are more flags for every session, such as -unsafe.
+	Args []string
+}
+
+// Run runs job's session and returns its result.
+// A session that fails to produce one has status error, and the reason in the result's Error.
+func (s *Session) Run(ctx context.Context, name string, job projectconfig.Job) *Result {
+	cmd := exec.CommandContext(ctx, s.Executable, s.args(job)...)
+	cmd.Dir = s.Dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	// A session whose turn fails still prints its result, and exits 1 or 3.
+	r := new(Result)
+	if err := json.Unmarshal(stdout.Bytes(), r); err != nil || r.Status == "" {
+		r = &Result{Status: "error", ExitCode: 1, Error: fmt.Sprintf("sketch printed no result: %s", lastLines(stderr.String(), 20))}
+		if runErr != nil {
+			r.Error = fmt.Sprintf("sketch failed: %v: %s", runErr, lastLines(stderr.String(), 20))
+		}
+	}
+	return r
+}
+
+// args returns the command-line arguments of job's session.
+func (s *Session) args(job projectconfig.Job) []string {
+	profile := job.Profile
+	if profile == "" {
+		profile = projectconfig.DefaultJobProfile
+	}
+	args := []string{"-prompt", job.Prompt, "-output=json", "-profile", profile}
+	b := job.Budget
+	if b.MaxDollars != nil {
+		args = append(args, "-max-dollars", strconv.FormatFloat(*b.MaxDollars, 'f', -1, 64))
+	}
+	if b.MaxTokens != nil {
+		args = append(args, "-max-tokens", strconv.FormatUint(*b.MaxTokens, 10))
+	}
+	if b.MaxToolCalls != nil {
+		args = append(args, "-max-tool-calls", strconv.Itoa(*b.MaxToolCalls))
+	}
+	if b.MaxWallTime != nil {
+		args = append(args, "-max-wall-time", b.MaxWallTime.String())
+	}
+	return append(args, s.Args...)
+}
+
+// lastLines returns the last n lines of text, trimmed.
+func lastLines(text string, n int) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// git runs git in dir and returns its output, trimmed.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %w", strings.Join(args, " "), strings.TrimSpace(stderr.String()), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
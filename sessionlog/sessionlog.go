@@ -0,0 +1,272 @@
+// Package sessionlog keeps a structured log of each session, one JSON record per line:
+// every message the agent shows the user, including tool calls and errors, and the session's log lines.
+// Next to the log it keeps a snapshot of the conversation as of the end of the last turn.
+// Together they are the durable record of a session, from which its transcript can be exported,
+// and its conversation restored with conversation.Restore, after sketch exits.
+//
+// A session's log is the file events.jsonl in a directory of its own.
+// When the file would grow beyond the size limit, it is renamed events.1.jsonl,
+// older files move up one number, and those beyond the file limit are removed,
+// so a session's log takes at most about MaxSize*MaxFiles bytes.
+package sessionlog
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Kind is a kind of record.
+type Kind string
+
+const (
+	// KindMessage records a message the agent showed the user (a loop.AgentMessage), in Message.
+	KindMessage Kind = "message"
+	// KindLog records a log line, in Log, as written by slog's JSON handler.
+	KindLog Kind = "log"
+)
+
+// A Record is a line of a session log.
+type Record struct {
+	Time    time.Time       `json:"time"`
+	Kind    Kind            `json:"kind"`
+	Message json.RawMessage `json:"message,omitempty"`
+	Log     json.RawMessage `json:"log,omitempty"`
+}
+
+// Limits bound the size of a session log. Zero fields take the values of DefaultLimits.
+type Limits struct {
+	// MaxSize is the size in bytes at which the current file is rotated.
+	MaxSize int64
+	// MaxFiles is the number of files kept, counting the current one.
+	MaxFiles int
+}
+
+// DefaultLimits keep up to 5 files of 20MB each.
+var DefaultLimits = Limits{MaxSize: 20 << 20, MaxFiles: 5}
+
+const (
+	fileName     = "events.jsonl"
+	snapshotName = "snapshot.json"
+)
+
+// A Log is a session log open for writing.
+// It is safe for concurrent use.
+type Log struct {
+	dir    string
+	limits Limits
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens the session log in dir, creating the directory if needed, to append to it.
+func Open(dir string, limits Limits) (*Log, error) {
+	limits.MaxSize = cmp.Or(limits.MaxSize, DefaultLimits.MaxSize)
+	limits.MaxFiles = cmp.Or(limits.MaxFiles, DefaultLimits.MaxFiles)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session log directory: %w", err)
+	}
+	l := &Log{dir: dir, limits: limits}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Dir returns the directory of l.
+func (l *Log) Dir() string {
+	return l.dir
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(filepath.Join(l.dir, fileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open session log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open session log: %w", err)
+	}
+	l.f, l.size = f, fi.Size()
+	// End a partial line left by a crash, so that it does not swallow the next record.
+	last := make([]byte, 1)
+	if l.size > 0 {
+		if _, err := f.ReadAt(last, l.size-1); err == nil && last[0] != '\n' {
+			n, _ := f.Write([]byte("\n"))
+			l.size += int64(n)
+		}
+	}
+	return nil
+}
+
+// Write appends r to the log, setting its Time to now if it is zero.
+func (l *Log) Write(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session log record: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return fmt.Errorf("session log %s is closed", l.dir)
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.limits.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write session log: %w", err)
+	}
+	return nil
+}
+
+// WriteMessage appends a KindMessage record of m.
+func (l *Log) WriteMessage(m any) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session log message: %w", err)
+	}
+	return l.Write(Record{Kind: KindMessage, Message: data})
+}
+
+// rotate moves the current file to events.1.jsonl, and older files up, removing those beyond the limit,
+// and starts a new current file. l.mu must be held.
+func (l *Log) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("failed to close session log: %w", err)
+	}
+	l.f = nil
+	os.Remove(rotatedPath(l.dir, l.limits.MaxFiles-1))
+	for i := l.limits.MaxFiles - 2; i >= 0; i-- {
+		if err := os.Rename(rotatedPath(l.dir, i), rotatedPath(l.dir, i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to rotate session log: %w", err)
+		}
+	}
+	return l.open()
+}
+
+// rotatedPath returns the path of the file that is i rotations old; 0 is the current file.
+func rotatedPath(dir string, i int) string {
+	if i == 0 {
+		return filepath.Join(dir, fileName)
+	}
+	return filepath.Join(dir, "events."+strconv.Itoa(i)+".jsonl")
+}
+
+// Close closes the log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Handler returns a slog.Handler that writes log lines to l as KindLog records.
+func (l *Log) Handler(opts *slog.HandlerOptions) slog.Handler {
+	return slog.NewJSONHandler(logWriter{l}, opts)
+}
+
+// logWriter turns the lines of a slog.JSONHandler, which writes each in a single call, into records.
+type logWriter struct {
+	l *Log
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	if err := w.l.Write(Record{Kind: KindLog, Log: bytes.TrimSuffix(p, []byte("\n"))}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Read returns the records of the session log in dir, oldest first.
+// A line that is not a record, such as the last line of a log whose writer crashed, is skipped.
+func Read(dir string) ([]Record, error) {
+	var paths []string
+	for i := 0; ; i++ {
+		path := rotatedPath(dir, i)
+		if _, err := os.Stat(path); err != nil {
+			if i == 0 {
+				return nil, fmt.Errorf("failed to read session log: %w", err)
+			}
+			break
+		}
+		paths = append(paths, path)
+	}
+	var records []Record
+	for i := len(paths) - 1; i >= 0; i-- {
+		rs, err := readFile(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rs...)
+	}
+	return records, nil
+}
+
+func readFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session log: %w", err)
+	}
+	defer f.Close()
+	var records []Record
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil || r.Kind == "" {
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read session log %s: %w", path, err)
+	}
+	return records, nil
+}
+
+// WriteSnapshot replaces the conversation snapshot next to l with data, as returned by conversation.Convo.Snapshot.
+func (l *Log) WriteSnapshot(data []byte) error {
+	tmp := filepath.Join(l.dir, snapshotName+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write conversation snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(l.dir, snapshotName)); err != nil {
+		return fmt.Errorf("failed to write conversation snapshot: %w", err)
+	}
+	return nil
+}
+
+// ReadSnapshot returns the conversation snapshot of the session log in dir, for conversation.Restore.
+func ReadSnapshot(dir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversation snapshot: %w", err)
+	}
+	return data, nil
+}
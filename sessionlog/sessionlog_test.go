@@ -0,0 +1,116 @@
+package sessionlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "log")
+	l, err := Open(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.WriteMessage(map[string]string{"type": "user", "content": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(l.Handler(nil))
+	logger.Warn("tool_failed", "tool", "bash")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash can leave a partial line at the end.
+	f, err := os.OpenFile(filepath.Join(dir, fileName), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time": "2025-06-01T09:00:00Z", "kind": "mess`)
+	f.Close()
+
+	// Reopening appends.
+	l, err = Open(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.WriteMessage(map[string]string{"type": "agent", "content": "hello"})
+	l.Close()
+
+	records, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3: %+v", len(records), records)
+	}
+	if records[0].Kind != KindMessage || !bytes.Contains(records[0].Message, []byte(`"hi"`)) {
+		t.Errorf("first record = %+v", records[0])
+	}
+	var line struct{ Level, Msg, Tool string }
+	if err := json.Unmarshal(records[1].Log, &line); err != nil || records[1].Kind != KindLog || line.Msg != "tool_failed" || line.Tool != "bash" {
+		t.Errorf("log record = %+v (%v)", records[1], err)
+	}
+	if records[2].Kind != KindMessage || !bytes.Contains(records[2].Message, []byte(`"hello"`)) {
+		t.Errorf("last record = %+v", records[2])
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Limits{MaxSize: 200, MaxFiles: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := range 20 {
+		if err := l.WriteMessage(map[string]any{"idx": i, "content": strings.Repeat("x", 50)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "events*.jsonl"))
+	if len(matches) != 3 {
+		t.Errorf("files = %v, want 3", matches)
+	}
+	for _, path := range matches {
+		if fi, err := os.Stat(path); err != nil || fi.Size() > 200 {
+			t.Errorf("%s is larger than the limit: %v", path, fi.Size())
+		}
+	}
+
+	records, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last struct{ Idx int }
+	json.Unmarshal(records[len(records)-1].Message, &last)
+	var first struct{ Idx int }
+	json.Unmarshal(records[0].Message, &first)
+	if last.Idx != 19 || first.Idx != 20-len(records) {
+		t.Errorf("read messages %d through %d of %d, want the most recent in order", first.Idx, last.Idx, len(records))
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := ReadSnapshot(dir); err == nil {
+		t.Error("ReadSnapshot succeeded before any snapshot")
+	}
+	for _, s := range []string{`{"turn": 1}`, `{"turn": 2}`} {
+		if err := l.WriteSnapshot([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := ReadSnapshot(dir); err != nil || string(data) != `{"turn": 2}` {
+		t.Errorf("ReadSnapshot = %s, %v", data, err)
+	}
+}
@@ -0,0 +1,408 @@
+// Package sessionstore keeps summaries of past sketch sessions in a local SQLite database,
+// so that how well the agent does, and how it fails, can be analyzed across sessions.
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// An Outcome is how a session ended.
+type Outcome string
+
+const (
+	// OutcomeDone means the agent called the done tool to finish its last task.
+	OutcomeDone Outcome = "done"
+	// OutcomeBudgetExceeded means the last turn stopped because it ran out of budget.
+	OutcomeBudgetExceeded Outcome = "budget_exceeded"
+	// OutcomeError means the last turn stopped with an error.
+	OutcomeError Outcome = "error"
+	// OutcomeUnfinished means the session ended without any of the above,
+	// typically because the user stopped it.
+	OutcomeUnfinished Outcome = "unfinished"
+)
+
+// A Session summarizes a sketch session.
+type Session struct {
+	ID           string    `json:"id"`
+	Task         string    `json:"task"` // the user's first message
+	Repo         string    `json:"repo"` // the working directory on the user's machine
+	Model        string    `json:"model"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	CostUSD      float64   `json:"cost_usd"`
+	InputTokens  uint64    `json:"input_tokens"`
+	OutputTokens uint64    `json:"output_tokens"`
+	Outcome      Outcome   `json:"outcome"`
+	FilesChanged []string  `json:"files_changed,omitempty"`
+	Failures     []Failure `json:"failures,omitempty"`
+}
+
+// A Failure is a kind of tool failure and how often it happened in a session.
+type Failure struct {
+	// Fingerprint identifies the kind of failure across sessions.
+	// It is the tool name and the error with its specifics, like paths and numbers, masked.
+	// See Fingerprint.
+	Fingerprint string `json:"fingerprint"`
+	Example     string `json:"example"` // the first error with this fingerprint
+	Count       int    `json:"count"`
+}
+
+// DefaultPath returns the default database path, in the user's cache directory.
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sketch", "sessions.db"), nil
+}
+
+// A Store is a database of session summaries.
+// It is safe for concurrent use.
+type Store struct {
+	db *sql.DB
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id            TEXT PRIMARY KEY,
+	task          TEXT NOT NULL,
+	repo          TEXT NOT NULL,
+	model         TEXT NOT NULL,
+	start_time    INTEGER NOT NULL, -- Unix milliseconds
+	end_time      INTEGER NOT NULL, -- Unix milliseconds
+	cost_usd      REAL NOT NULL,
+	input_tokens  INTEGER NOT NULL,
+	output_tokens INTEGER NOT NULL,
+	outcome       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_start_time ON sessions (start_time);
+CREATE TABLE IF NOT EXISTS session_files (
+	session_id TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+	path       TEXT NOT NULL,
+	PRIMARY KEY (session_id, path)
+);
+CREATE TABLE IF NOT EXISTS session_failures (
+	session_id  TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+	fingerprint TEXT NOT NULL,
+	example     TEXT NOT NULL,
+	count       INTEGER NOT NULL,
+	PRIMARY KEY (session_id, fingerprint)
+);
+CREATE INDEX IF NOT EXISTS session_failures_fingerprint ON session_failures (fingerprint);
+`
+
+// Open opens the database at path, creating it if needed.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session store directory: %w", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session store: %w", err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize session store %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Record stores sess, replacing any earlier summary of the same session.
+func (s *Store) Record(ctx context.Context, sess *Session) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record session %s: %w", sess.ID, err)
+	}
+	defer tx.Rollback()
+	// Deleting the old row cascades to its files and failures.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, sess.ID); err != nil {
+		return fmt.Errorf("failed to record session %s: %w", sess.ID, err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO sessions VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sess.ID, sess.Task, sess.Repo, sess.Model, sess.Start.UnixMilli(), sess.End.UnixMilli(),
+		sess.CostUSD, sess.InputTokens, sess.OutputTokens, string(sess.Outcome))
+	if err != nil {
+		return fmt.Errorf("failed to record session %s: %w", sess.ID, err)
+	}
+	for _, path := range sess.FilesChanged {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO session_files VALUES (?, ?)`, sess.ID, path); err != nil {
+			return fmt.Errorf("failed to record session %s: %w", sess.ID, err)
+		}
+	}
+	for _, f := range sess.Failures {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO session_failures VALUES (?, ?, ?, ?)`, sess.ID, f.Fingerprint, f.Example, f.Count); err != nil {
+			return fmt.Errorf("failed to record session %s: %w", sess.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record session %s: %w", sess.ID, err)
+	}
+	return nil
+}
+
+// A Query selects sessions. The zero Query selects all of them.
+type Query struct {
+	Since   time.Time // sessions that started at or after Since
+	Until   time.Time // sessions that started before Until
+	Repo    string
+	Outcome Outcome
+	Limit   int // at most Limit sessions, the most recent ones
+}
+
+// where returns the SQL condition and arguments that select the sessions q selects.
+func (q Query) where() (string, []any) {
+	conds := []string{"1"}
+	var args []any
+	if !q.Since.IsZero() {
+		conds = append(conds, "start_time >= ?")
+		args = append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		conds = append(conds, "start_time < ?")
+		args = append(args, q.Until.UnixMilli())
+	}
+	if q.Repo != "" {
+		conds = append(conds, "repo = ?")
+		args = append(args, q.Repo)
+	}
+	if q.Outcome != "" {
+		conds = append(conds, "outcome = ?")
+		args = append(args, string(q.Outcome))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Sessions returns the sessions q selects, most recent first.
+func (s *Store) Sessions(ctx context.Context, q Query) ([]*Session, error) {
+	where, args := q.where()
+	query := `SELECT id, task, repo, model, start_time, end_time, cost_usd, input_tokens, output_tokens, outcome
+		FROM sessions WHERE ` + where + ` ORDER BY start_time DESC`
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+	var sessions []*Session
+	byID := make(map[string]*Session)
+	for rows.Next() {
+		var sess Session
+		var start, end int64
+		if err := rows.Scan(&sess.ID, &sess.Task, &sess.Repo, &sess.Model, &start, &end,
+			&sess.CostUSD, &sess.InputTokens, &sess.OutputTokens, &sess.Outcome); err != nil {
+			return nil, fmt.Errorf("failed to query sessions: %w", err)
+		}
+		sess.Start, sess.End = time.UnixMilli(start), time.UnixMilli(end)
+		sessions = append(sessions, &sess)
+		byID[sess.ID] = &sess
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+
+	// Attach files and failures.
+	rows, err = s.db.QueryContext(ctx, `SELECT session_id, path FROM session_files
+		WHERE session_id IN (SELECT id FROM sessions WHERE `+where+`) ORDER BY path`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session files: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, path string
+		if err := rows.Scan(&id, &path); err != nil {
+			return nil, fmt.Errorf("failed to query session files: %w", err)
+		}
+		if sess := byID[id]; sess != nil {
+			sess.FilesChanged = append(sess.FilesChanged, path)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query session files: %w", err)
+	}
+	rows, err = s.db.QueryContext(ctx, `SELECT session_id, fingerprint, example, count FROM session_failures
+		WHERE session_id IN (SELECT id FROM sessions WHERE `+where+`) ORDER BY count DESC, fingerprint`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session failures: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var f Failure
+		if err := rows.Scan(&id, &f.Fingerprint, &f.Example, &f.Count); err != nil {
+			return nil, fmt.Errorf("failed to query session failures: %w", err)
+		}
+		if sess := byID[id]; sess != nil {
+			sess.Failures = append(sess.Failures, f)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query session failures: %w", err)
+	}
+	return sessions, nil
+}
+
+// Stats aggregates the sessions a Query selects.
+type Stats struct {
+	Sessions     int
+	Outcomes     map[Outcome]int
+	TotalCostUSD float64
+	MeanDuration time.Duration
+	MeanCostUSD  float64
+}
+
+// Stats returns aggregate statistics about the sessions q selects.
+// q.Limit is ignored.
+func (s *Store) Stats(ctx context.Context, q Query) (*Stats, error) {
+	where, args := q.where()
+	rows, err := s.db.QueryContext(ctx, `SELECT outcome, COUNT(*), SUM(cost_usd), SUM(end_time - start_time)
+		FROM sessions WHERE `+where+` GROUP BY outcome`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session stats: %w", err)
+	}
+	defer rows.Close()
+	stats := &Stats{Outcomes: make(map[Outcome]int)}
+	var totalMillis int64
+	for rows.Next() {
+		var outcome Outcome
+		var n int
+		var cost float64
+		var millis int64
+		if err := rows.Scan(&outcome, &n, &cost, &millis); err != nil {
+			return nil, fmt.Errorf("failed to query session stats: %w", err)
+		}
+		stats.Outcomes[outcome] = n
+		stats.Sessions += n
+		stats.TotalCostUSD += cost
+		totalMillis += millis
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query session stats: %w", err)
+	}
+	if stats.Sessions > 0 {
+		stats.MeanDuration = time.Duration(totalMillis/int64(stats.Sessions)) * time.Millisecond
+		stats.MeanCostUSD = stats.TotalCostUSD / float64(stats.Sessions)
+	}
+	return stats, nil
+}
+
+// A FailureMode is a kind of tool failure, aggregated across sessions.
+type FailureMode struct {
+	Fingerprint string
+	Example     string // the error from the most recent session with this fingerprint
+	Sessions    int    // how many sessions had it
+	Count       int    // how many times it happened in all
+}
+
+// FailureModes returns the kinds of tool failure in the sessions q selects,
+// the ones that affected the most sessions first.
+// If q.Limit is set, it limits the number of failure modes.
+func (s *Store) FailureModes(ctx context.Context, q Query) ([]FailureMode, error) {
+	where, args := q.where()
+	query := `SELECT f.fingerprint,
+			(SELECT example FROM session_failures f2 JOIN sessions s2 ON s2.id = f2.session_id
+				WHERE f2.fingerprint = f.fingerprint ORDER BY s2.start_time DESC LIMIT 1),
+			COUNT(*), SUM(f.count)
+		FROM session_failures f JOIN sessions s ON s.id = f.session_id
+		WHERE ` + where + `
+		GROUP BY f.fingerprint
+		ORDER BY COUNT(*) DESC, SUM(f.count) DESC, f.fingerprint`
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failure modes: %w", err)
+	}
+	defer rows.Close()
+	var modes []FailureMode
+	for rows.Next() {
+		var m FailureMode
+		if err := rows.Scan(&m.Fingerprint, &m.Example, &m.Sessions, &m.Count); err != nil {
+			return nil, fmt.Errorf("failed to query failure modes: %w", err)
+		}
+		modes = append(modes, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query failure modes: %w", err)
+	}
+	return modes, nil
+}
+
+var (
+	quotedRE = regexp.MustCompile("\"[^\"]*\"|'[^']*'|`[^`]*`")
+	pathRE   = regexp.MustCompile(`(?:[\w.-]*/)+[\w.-]+`)
+	hexRE    = regexp.MustCompile(`\b(?:0x)?[0-9a-fA-F]{7,}\b`)
+	numberRE = regexp.MustCompile(`\d+`)
+)
+
+// Fingerprint returns a fingerprint of an error from a tool,
+// which is the same for errors that differ only in their specifics:
+// quoted strings, paths, hex IDs, and numbers.
+// Only the first line of the error counts.
+func Fingerprint(tool, errText string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(errText), "\n")
+	line = quotedRE.ReplaceAllString(line, "<str>")
+	line = pathRE.ReplaceAllString(line, "<path>")
+	line = hexRE.ReplaceAllStringFunc(line, func(s string) string {
+		// A hex ID has both digits and letters; leave words like "defaced" and plain numbers.
+		if !strings.ContainsAny(s, "0123456789") || !strings.ContainsAny(strings.ToLower(s), "abcdef") {
+			return s
+		}
+		return "<hex>"
+	})
+	line = numberRE.ReplaceAllString(line, "<n>")
+	if len(line) > 200 {
+		line = line[:200]
+	}
+	return tool + ": " + line
+}
+
+// WriteFile writes sess to path as JSON, for a Store on another machine to record later.
+func WriteFile(path string, sess *Session) error {
+	b, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Write and rename, so that a reader never sees a partial summary.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadFile reads a session summary written by WriteFile.
+func ReadFile(path string) (*Session, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sess Session
+	if err := json.Unmarshal(b, &sess); err != nil {
+		return nil, fmt.Errorf("failed to parse session summary %s: %w", path, err)
+	}
+	return &sess, nil
+}
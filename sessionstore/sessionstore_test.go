@@ -0,0 +1,94 @@
+package sessionstore
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(filepath.Join(t.TempDir(), "sub", "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	day := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	missing := Failure{Fingerprint: "bash: go: cannot find module providing package <path>", Example: "go: cannot find module providing package example.com/x", Count: 2}
+	sessions := []*Session{
+		{ID: "a", Task: "fix the build", Repo: "/src/p", Start: day, End: day.Add(10 * time.Minute), CostUSD: 1, Outcome: OutcomeDone, FilesChanged: []string{"b.go", "a.go"}},
+		{ID: "b", Task: "add a flag", Repo: "/src/p", Start: day.Add(time.Hour), End: day.Add(time.Hour + 20*time.Minute), CostUSD: 3, Outcome: OutcomeBudgetExceeded, Failures: []Failure{missing}},
+		{ID: "c", Task: "write docs", Repo: "/src/q", Start: day.Add(2 * time.Hour), End: day.Add(2*time.Hour + 30*time.Minute), CostUSD: 2, Outcome: OutcomeDone, Failures: []Failure{{Fingerprint: missing.Fingerprint, Example: "latest", Count: 1}}},
+	}
+	for _, sess := range sessions {
+		if err := s.Record(ctx, sess); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Recording a session again replaces it.
+	sessions[0].Outcome = OutcomeError
+	sessions[0].FilesChanged = []string{"a.go"}
+	if err := s.Record(ctx, sessions[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Sessions(ctx, Query{Repo: "/src/p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != "b" || got[1].ID != "a" {
+		t.Fatalf("Sessions(repo /src/p) = %v, want b and a", got)
+	}
+	if !slices.Equal(got[1].FilesChanged, []string{"a.go"}) || got[1].Outcome != OutcomeError || !got[1].End.Equal(day.Add(10*time.Minute)) {
+		t.Errorf("re-recorded session = %+v", got[1])
+	}
+	if len(got[0].Failures) != 1 || got[0].Failures[0] != missing {
+		t.Errorf("failures = %+v, want %+v", got[0].Failures, missing)
+	}
+
+	got, err = s.Sessions(ctx, Query{Since: day.Add(30 * time.Minute), Outcome: OutcomeDone, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != "c" {
+		t.Errorf("Sessions(since, done) = %v, want c", got)
+	}
+
+	stats, err := s.Stats(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sessions != 3 || stats.Outcomes[OutcomeDone] != 1 || stats.Outcomes[OutcomeError] != 1 || stats.TotalCostUSD != 6 || stats.MeanDuration != 20*time.Minute {
+		t.Errorf("Stats = %+v", stats)
+	}
+
+	modes, err := s.FailureModes(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FailureMode{{Fingerprint: missing.Fingerprint, Example: "latest", Sessions: 2, Count: 3}}
+	if !slices.Equal(modes, want) {
+		t.Errorf("FailureModes = %+v, want %+v", modes, want)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		tool, err, want string
+	}{
+		{"bash", "exit status 1\nmore output", "bash: exit status <n>"},
+		{"bash", `./loop/agent.go:12:3: undefined: "foo"`, "bash: <path>:<n>:<n>: undefined: <str>"},
+		{"patch", "commit 3f2a9bc1d not found; the file was defaced", "patch: commit <hex> not found; the file was defaced"},
+	}
+	for _, tt := range tests {
+		if got := Fingerprint(tt.tool, tt.err); got != tt.want {
+			t.Errorf("Fingerprint(%q, %q) = %q, want %q", tt.tool, tt.err, got, tt.want)
+		}
+	}
+	if Fingerprint("bash", "exit status 1") != Fingerprint("bash", "exit status 2") {
+		t.Errorf("errors differing only in a number have different fingerprints")
+	}
+}
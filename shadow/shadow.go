@@ -0,0 +1,344 @@
+// Package shadow runs a session against a private copy of a directory tree,
+// so that the user's checkout is untouched until they accept the result.
+//
+// Create copies the origin into a temporary "shadow" directory and records the
+// state of every file. All agent commands and edits then happen in the shadow.
+// When the session ends, Changes reports what the agent did and Apply syncs
+// those changes back to the origin, skipping files that were modified in the
+// origin in the meantime.
+package shadow
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// A Workspace is a shadow copy of an origin directory.
+type Workspace struct {
+	// Origin is the directory that was copied.
+	Origin string
+	// Dir is the shadow copy.
+	Dir string
+
+	// base maps slash-separated relative paths to file hashes at creation time.
+	base map[string]string
+}
+
+// Status describes how a file differs between the shadow and the state it was created from.
+type Status string
+
+const (
+	Added    Status = "A"
+	Modified Status = "M"
+	Deleted  Status = "D"
+)
+
+// A Change is a file that differs between the shadow and the origin as it was at creation.
+type Change struct {
+	Path   string `json:"path"` // slash-separated, relative to the workspace root
+	Status Status `json:"status"`
+}
+
+// An ApplyResult reports the outcome of Apply.
+type ApplyResult struct {
+	Applied []Change
+	// Conflicts are changes that were not applied because the origin file
+	// was modified after the shadow was created.
+	Conflicts []Change
+}
+
+// Create copies origin into a new temporary directory.
+// The .git directory, if any, is copied so that git works in the shadow,
+// but it is never synced back by Apply.
+func Create(ctx context.Context, origin string) (*Workspace, error) {
+	origin, err := filepath.Abs(origin)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "sketch-shadow-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shadow directory: %w", err)
+	}
+	w := &Workspace{Origin: origin, Dir: dir, base: make(map[string]string)}
+	err = filepath.WalkDir(origin, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(origin, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dst, info.Mode().Perm()|0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		case !d.Type().IsRegular():
+			return nil // skip sockets, devices, etc.
+		}
+		sum, err := copyFile(path, dst, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if !isGitPath(rel) {
+			w.base[filepath.ToSlash(rel)] = sum
+		}
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to copy %s to shadow workspace: %w", origin, err)
+	}
+	// Build outputs, dependencies, and other ignored files are copied so that the
+	// shadow builds, but they are not part of the baseline that changes are reported against.
+	if files, ok := listFiles(ctx, dir); ok {
+		listed := make(map[string]bool, len(files))
+		for _, f := range files {
+			listed[f] = true
+		}
+		maps.DeleteFunc(w.base, func(path, _ string) bool { return !listed[path] })
+	}
+	return w, nil
+}
+
+// Remove deletes the shadow directory.
+func (w *Workspace) Remove() error {
+	return os.RemoveAll(w.Dir)
+}
+
+// ShadowPath returns the path in the shadow corresponding to path in the origin.
+// Paths outside the origin are returned unchanged.
+func (w *Workspace) ShadowPath(path string) string {
+	rel, err := filepath.Rel(w.Origin, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(w.Dir, rel)
+}
+
+// Changes returns the files added, modified, or deleted in the shadow, sorted by path.
+func (w *Workspace) Changes() ([]Change, error) {
+	current, err := hashTree(w.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for path, sum := range current {
+		base, ok := w.base[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Path: path, Status: Added})
+		case base != sum:
+			changes = append(changes, Change{Path: path, Status: Modified})
+		}
+	}
+	for path := range w.base {
+		if _, ok := current[path]; !ok {
+			changes = append(changes, Change{Path: path, Status: Deleted})
+		}
+	}
+	slices.SortFunc(changes, func(a, b Change) int { return strings.Compare(a.Path, b.Path) })
+	return changes, nil
+}
+
+// Apply syncs all changes in the shadow back to the origin.
+// A change whose origin file no longer matches the state at creation is
+// reported as a conflict and left alone, so that concurrent edits by the user are never lost.
+func (w *Workspace) Apply() (*ApplyResult, error) {
+	changes, err := w.Changes()
+	if err != nil {
+		return nil, err
+	}
+	res := new(ApplyResult)
+	var errs error
+	for _, c := range changes {
+		dst := filepath.Join(w.Origin, filepath.FromSlash(c.Path))
+		cur, err := hashFile(dst)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		if cur != w.base[c.Path] {
+			res.Conflicts = append(res.Conflicts, c)
+			continue
+		}
+		switch c.Status {
+		case Deleted:
+			err = os.Remove(dst)
+		default:
+			src := filepath.Join(w.Dir, filepath.FromSlash(c.Path))
+			var info fs.FileInfo
+			info, err = os.Stat(src)
+			if err == nil {
+				err = os.MkdirAll(filepath.Dir(dst), 0o755)
+			}
+			if err == nil {
+				_, err = copyFile(src, dst, info.Mode().Perm())
+			}
+		}
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to apply %s: %w", c.Path, err))
+			continue
+		}
+		// The origin now matches the shadow; make that the new baseline.
+		if c.Status == Deleted {
+			delete(w.base, c.Path)
+		} else {
+			w.base[c.Path], _ = hashFile(dst)
+		}
+		res.Applied = append(res.Applied, c)
+	}
+	return res, errs
+}
+
+// FetchBranches copies git branches whose names start with prefix from the shadow's
+// repository into the origin's, so commits made during the session are not lost.
+func (w *Workspace) FetchBranches(ctx context.Context, prefix string) error {
+	if _, err := os.Stat(filepath.Join(w.Origin, ".git")); err != nil {
+		return nil // not a git repository root; nothing to do
+	}
+	refspec := fmt.Sprintf("+refs/heads/%s*:refs/heads/%s*", prefix, prefix)
+	cmd := exec.CommandContext(ctx, "git", "fetch", "--no-tags", w.Dir, refspec)
+	cmd.Dir = w.Origin
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git fetch from shadow workspace: %s: %w", out, err)
+	}
+	return nil
+}
+
+// isGitPath reports whether the relative path rel is inside a .git directory.
+func isGitPath(rel string) bool {
+	first, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return first == ".git"
+}
+
+// listFiles returns the slash-separated paths, relative to root, of the files that
+// git considers part of the work tree rooted at root: tracked files and untracked files
+// that are not ignored. It reports false if root is not the top of a git work tree.
+func listFiles(ctx context.Context, root string) ([]string, bool) {
+	if _, err := os.Stat(filepath.Join(root, ".git")); err != nil {
+		return nil, false
+	}
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, false
+	}
+	var files []string
+	for f := range strings.SplitSeq(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, true
+}
+
+// hashTree returns the hashes of the regular files under root, excluding .git.
+// In a git work tree only the files reported by listFiles are hashed, so that
+// ignored files such as build outputs never count as changes.
+func hashTree(root string) (map[string]string, error) {
+	sums := make(map[string]string)
+	if files, ok := listFiles(context.Background(), root); ok {
+		for _, rel := range files {
+			path := filepath.Join(root, filepath.FromSlash(rel))
+			info, err := os.Lstat(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // a tracked file that was deleted
+			} else if err != nil {
+				return nil, err
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			sum, err := hashFile(path)
+			if err != nil {
+				return nil, err
+			}
+			sums[rel] = sum
+		}
+		return sums, nil
+	}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if isGitPath(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	return sums, err
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// copyFile copies src to dst and returns the hash of the contents.
+func copyFile(src, dst string, perm fs.FileMode) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), in); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
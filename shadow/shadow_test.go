@@ -0,0 +1,163 @@
+package shadow
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestWorkspace(t *testing.T) {
+	origin := t.TempDir()
+	writeFile(t, filepath.Join(origin, "keep.txt"), "keep")
+	writeFile(t, filepath.Join(origin, "edit.txt"), "before")
+	writeFile(t, filepath.Join(origin, "gone.txt"), "gone")
+	writeFile(t, filepath.Join(origin, "contested.txt"), "v1")
+	writeFile(t, filepath.Join(origin, ".git", "HEAD"), "ref: refs/heads/main\n")
+
+	w, err := Create(context.Background(), origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Remove()
+
+	if got := w.ShadowPath(filepath.Join(origin, "sub", "x.go")); got != filepath.Join(w.Dir, "sub", "x.go") {
+		t.Errorf("ShadowPath = %q", got)
+	}
+	if got := w.ShadowPath("/elsewhere"); got != "/elsewhere" {
+		t.Errorf("ShadowPath outside origin = %q", got)
+	}
+
+	// The agent works in the shadow.
+	writeFile(t, filepath.Join(w.Dir, "edit.txt"), "after")
+	writeFile(t, filepath.Join(w.Dir, "sub", "new.txt"), "new")
+	writeFile(t, filepath.Join(w.Dir, "contested.txt"), "agent")
+	writeFile(t, filepath.Join(w.Dir, ".git", "HEAD"), "ref: refs/heads/sketch/x\n")
+	if err := os.Remove(filepath.Join(w.Dir, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	// Meanwhile the user edits their checkout.
+	writeFile(t, filepath.Join(origin, "contested.txt"), "user")
+
+	if got := readFile(t, filepath.Join(origin, "edit.txt")); got != "before" {
+		t.Fatalf("origin modified before apply: %q", got)
+	}
+
+	changes, err := w.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Path: "contested.txt", Status: Modified},
+		{Path: "edit.txt", Status: Modified},
+		{Path: "gone.txt", Status: Deleted},
+		{Path: "sub/new.txt", Status: Added},
+	}
+	if !slices.Equal(changes, want) {
+		t.Fatalf("Changes = %v, want %v", changes, want)
+	}
+
+	res, err := w.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Applied) != 3 || len(res.Conflicts) != 1 || res.Conflicts[0].Path != "contested.txt" {
+		t.Fatalf("Apply = %+v", res)
+	}
+	if got := readFile(t, filepath.Join(origin, "edit.txt")); got != "after" {
+		t.Errorf("edit.txt = %q, want after", got)
+	}
+	if got := readFile(t, filepath.Join(origin, "sub", "new.txt")); got != "new" {
+		t.Errorf("sub/new.txt = %q, want new", got)
+	}
+	if _, err := os.Stat(filepath.Join(origin, "gone.txt")); !os.IsNotExist(err) {
+		t.Errorf("gone.txt still exists: %v", err)
+	}
+	if got := readFile(t, filepath.Join(origin, "contested.txt")); got != "user" {
+		t.Errorf("contested.txt = %q, user edit was overwritten", got)
+	}
+	if got := readFile(t, filepath.Join(origin, ".git", "HEAD")); got != "ref: refs/heads/main\n" {
+		t.Errorf(".git was synced back: %q", got)
+	}
+
+	// Applied changes are no longer reported.
+	changes, err = w.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Path != "contested.txt" {
+		t.Errorf("Changes after apply = %v, want only the conflict", changes)
+	}
+}
+
+func TestWorkspaceIgnoredFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	origin := t.TempDir()
+	writeFile(t, filepath.Join(origin, ".gitignore"), "build/\n*.log\n")
+	writeFile(t, filepath.Join(origin, "main.go"), "package main\n")
+	writeFile(t, filepath.Join(origin, "build", "old.o"), "old")
+	writeFile(t, filepath.Join(origin, "untracked.txt"), "untracked")
+	for _, args := range [][]string{{"init", "-q"}, {"add", ".gitignore", "main.go"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = origin
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+	}
+
+	w, err := Create(context.Background(), origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Remove()
+	if got := readFile(t, filepath.Join(w.Dir, "build", "old.o")); got != "old" {
+		t.Errorf("ignored file not copied to the shadow: %q", got)
+	}
+
+	writeFile(t, filepath.Join(w.Dir, "main.go"), "package main\n\nfunc main() {}\n")
+	writeFile(t, filepath.Join(w.Dir, "build", "new.o"), "new")
+	writeFile(t, filepath.Join(w.Dir, "test.log"), "log")
+	writeFile(t, filepath.Join(w.Dir, "notes.txt"), "notes")
+	if err := os.Remove(filepath.Join(w.Dir, "build", "old.o")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(w.Dir, "untracked.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := w.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Path: "main.go", Status: Modified},
+		{Path: "notes.txt", Status: Added},
+		{Path: "untracked.txt", Status: Deleted},
+	}
+	if !slices.Equal(changes, want) {
+		t.Fatalf("Changes = %v, want %v", changes, want)
+	}
+}
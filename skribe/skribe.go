@@ -5,6 +5,7 @@ package skribe
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"slices"
@@ -98,3 +99,49 @@ func (mh *multiHandler) Handle(ctx context.Context, r slog.Record) error {
 	}
 	return handler.Handle(ctx, r)
 }
+
+type handlerKey struct{}
+
+// ContextWithHandler returns a context whose log records also go to h,
+// when they are logged through a handler wrapped with HandlerWrap.
+// Each session uses it to keep its own log.
+func ContextWithHandler(ctx context.Context, h slog.Handler) context.Context {
+	return context.WithValue(ctx, handlerKey{}, h)
+}
+
+// HandlerWrap returns a handler that passes records to h,
+// and also to the handler of each record's context, if any; see ContextWithHandler.
+// The context's handler does not see attributes and groups added with WithAttrs and WithGroup.
+func HandlerWrap(h slog.Handler) slog.Handler {
+	return &contextHandler{Handler: h}
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	if ch, ok := ctx.Value(handlerKey{}).(slog.Handler); ok && ch.Enabled(ctx, l) {
+		return true
+	}
+	return h.Handler.Enabled(ctx, l)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	if h.Handler.Enabled(ctx, r.Level) {
+		err = h.Handler.Handle(ctx, r.Clone())
+	}
+	if ch, ok := ctx.Value(handlerKey{}).(slog.Handler); ok && ch.Enabled(ctx, r.Level) {
+		err = errors.Join(err, ch.Handle(ctx, r))
+	}
+	return err
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,263 @@
+package statedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/claudetool/undo"
+)
+
+// A Session records the state of one sketch session and owns the directory for the files it produces.
+type Session struct {
+	db *DB
+	id string
+}
+
+// Session returns the session with the given ID.
+// Nothing is created until the session records something.
+func (d *DB) Session(id string) *Session {
+	return &Session{db: d, id: id}
+}
+
+// ID returns the session's ID.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// DB returns the database that s is in.
+func (s *Session) DB() *DB {
+	return s.db
+}
+
+// sessionsDir returns the directory that holds the sessions' directories.
+func (d *DB) sessionsDir() string {
+	return filepath.Join(d.filesDir, "sessions")
+}
+
+// sessionDir returns the directory for the files the session with the given ID produces.
+func (d *DB) sessionDir(id string) (string, error) {
+	if id == "" || id == "." || id == ".." || filepath.Base(id) != id {
+		return "", fmt.Errorf("invalid session ID %q", id)
+	}
+	return filepath.Join(d.sessionsDir(), id), nil
+}
+
+// Dir returns the directory for the files the session produces, creating it and
+// the subdirectory sub, if not empty, as needed.
+func (s *Session) Dir(sub string) (string, error) {
+	dir, err := s.db.sessionDir(s.id)
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, sub)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create session directory: %w", err)
+	}
+	return dir, nil
+}
+
+// NewJob reserves an ID for a background job and creates the directory for its output,
+// jobs/<id> in the session's directory.
+// Record the job with AddJob once it has started.
+func (s *Session) NewJob() (id uint64, dir string, err error) {
+	err = s.db.bolt.Update(func(tx *bbolt.Tx) error {
+		id, err = tx.Bucket(jobsBucket).NextSequence()
+		return err
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	dir, err = s.Dir(filepath.Join("jobs", strconv.FormatUint(id, 10)))
+	return id, dir, err
+}
+
+// AddJob records j as a job of the session.
+// If j.ID is zero, AddJob sets it to a new ID.
+func (s *Session) AddJob(j *Job) error {
+	j.Session = s.id
+	return s.db.bolt.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		if j.ID == 0 {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			j.ID = id
+		}
+		return put(b, seqKey(j.ID), j)
+	})
+}
+
+// AddCommand appends c, run by the session, to the command history,
+// dropping the oldest commands beyond the last 10000.
+func (s *Session) AddCommand(c Command) error {
+	c.Session = s.id
+	return s.db.bolt.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := put(b, seqKey(id), c); err != nil {
+			return err
+		}
+		if id <= maxHistory {
+			return nil
+		}
+		return b.Delete(seqKey(id - maxHistory))
+	})
+}
+
+// AddArtifact adds a, produced by the session, to the artifact index,
+// replacing any earlier artifact with the same path.
+func (s *Session) AddArtifact(a Artifact) error {
+	a.Session = s.id
+	return s.db.bolt.Update(func(tx *bbolt.Tx) error {
+		return put(tx.Bucket(artifactsBucket), []byte(a.Path), a)
+	})
+}
+
+// LoadUndo implements undo.Store, for the session's journal.
+func (s *Session) LoadUndo() ([]undo.Entry, error) {
+	records, err := all[undoRecord](s.db, undoBucket)
+	if err != nil {
+		return nil, err
+	}
+	var entries []undo.Entry
+	for _, r := range records {
+		if r.Session == s.id {
+			entries = append(entries, r.Entry)
+		}
+	}
+	return entries, nil
+}
+
+// AddUndo implements undo.Store.
+func (s *Session) AddUndo(e *undo.Entry) error {
+	return s.db.bolt.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(undoBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+		return put(b, seqKey(id), undoRecord{Session: s.id, Entry: *e})
+	})
+}
+
+// RemoveUndo implements undo.Store.
+func (s *Session) RemoveUndo(id uint64) error {
+	return s.db.bolt.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(undoBucket).Delete(seqKey(id))
+	})
+}
+
+// stagedPrefix returns the prefix of the keys of the session's staged files.
+func (s *Session) stagedPrefix() []byte {
+	return []byte(s.id + "\x00")
+}
+
+// LoadStaged implements staging.Store, for the changes that the session staged.
+func (s *Session) LoadStaged() (map[string]staging.File, error) {
+	files := make(map[string]staging.File)
+	prefix := s.stagedPrefix()
+	err := s.db.bolt.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(stagedBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var r stagedRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("bad staged file %q: %w", k[len(prefix):], err)
+			}
+			files[r.Path] = r.File
+		}
+		return nil
+	})
+	return files, err
+}
+
+// SaveStaged implements staging.Store.
+func (s *Session) SaveStaged(path string, f staging.File) error {
+	return s.db.bolt.Update(func(tx *bbolt.Tx) error {
+		return put(tx.Bucket(stagedBucket), append(s.stagedPrefix(), path...), stagedRecord{Session: s.id, Path: path, File: f})
+	})
+}
+
+// DeleteStaged implements staging.Store.
+func (s *Session) DeleteStaged(path string) error {
+	return s.db.bolt.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(stagedBucket).Delete(append(s.stagedPrefix(), path...))
+	})
+}
+
+// SessionIDs returns the IDs of the sessions that have a directory.
+func (d *DB) SessionIDs() ([]string, error) {
+	entries, err := os.ReadDir(d.sessionsDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+	return ids, nil
+}
+
+// RemoveSession removes everything the session with the given ID produced:
+// its directory, and its jobs, commands, artifacts, staged changes, and undo journal.
+func (d *DB) RemoveSession(id string) error {
+	dir, err := d.sessionDir(id)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{jobsBucket, historyBucket, artifactsBucket, stagedBucket, undoBucket} {
+			if err := deleteSessionRecords(tx, bucket, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteSessionRecords deletes the records in the named bucket that belong to the session with the given ID.
+func deleteSessionRecords(tx *bbolt.Tx, bucket []byte, id string) error {
+	b := tx.Bucket(bucket)
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var rec struct {
+			Session string `json:"session"`
+		}
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return fmt.Errorf("bad %s record %x: %w", bucket, k, err)
+		}
+		if rec.Session == id {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+// Package statedb keeps the agent's working state in a single database, so that it survives
+// a restart of sketch: background jobs, command history, staged changes awaiting approval,
+// the journal of file writes to undo, an index of the files the agent produced, which commands the bash tool already tried
+// to install, and how often each kind of command's output was truncated.
+//
+// Files that a session produces, such as the output of background jobs, live in a directory
+// per session next to the database:
+//
+//	<db>.files/sessions/<session id>/jobs/<job id>/
+//	<db>.files/sessions/<session id>/backups/
+//
+// so that everything a session produced can be found, and removed, as a unit.
+//
+// It uses bbolt rather than SQLite because the sketch binary that runs inside the container
+// is built without cgo.
+package statedb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/claudetool/undo"
+)
+
+// Buckets. Each holds JSON values.
+var (
+	metaBucket      = []byte("meta")      // "version": the number of migrations applied
+	jobsBucket      = []byte("jobs")      // sequence number -> Job
+	historyBucket   = []byte("history")   // sequence number -> Command
+	stagedBucket    = []byte("staged")    // session ID, NUL, absolute path -> stagedRecord
+	artifactsBucket = []byte("artifacts") // path -> Artifact
+	installBucket   = []byte("install")   // command name -> time of the install attempt
+	outputBucket    = []byte("output")    // command category -> OutputStats
+	undoBucket      = []byte("undo")      // sequence number -> undoRecord
+)
+
+// migrations bring the database from one version to the next.
+// The database's version is the number of migrations applied to it.
+// Append to this list; never change or reorder its entries.
+var migrations = []func(tx *bbolt.Tx) error{
+	// 1: the initial buckets.
+	func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, historyBucket, stagedBucket, artifactsBucket, installBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: output truncation statistics.
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outputBucket)
+		return err
+	},
+	// 3: the undo journal.
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(undoBucket)
+		return err
+	},
+	// 4: staged changes by session. Those staged before belong to no session that can be told apart,
+	// so they are dropped; the files on disk are as they were.
+	func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(stagedBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(stagedBucket)
+		return err
+	},
+}
+
+// maxHistory is how many commands the history keeps.
+const maxHistory = 10000
+
+// DefaultPath returns the default database path for a session working in workingDir,
+// in the user's cache directory.
+// Sessions in the same directory share a database.
+func DefaultPath(workingDir string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(workingDir))
+	return filepath.Join(dir, "sketch", "state", hex.EncodeToString(sum[:8])+".db"), nil
+}
+
+// A DB is a database of agent state.
+// It is safe for concurrent use.
+// Only one process at a time may open a database.
+type DB struct {
+	bolt     *bbolt.DB
+	filesDir string // root of the directories of files that sessions produce
+}
+
+// Open opens the database at path, creating it if needed and migrating it to the current version.
+// If another process has the database open, Open fails after a second.
+func Open(path string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	b, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state database %s: %w", path, err)
+	}
+	if err := b.Update(migrate); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("failed to migrate state database %s: %w", path, err)
+	}
+	return &DB{bolt: b, filesDir: strings.TrimSuffix(path, filepath.Ext(path)) + ".files"}, nil
+}
+
+// migrate applies the migrations that tx's database is missing.
+func migrate(tx *bbolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	version := 0
+	if v := meta.Get([]byte("version")); v != nil {
+		if version, err = strconv.Atoi(string(v)); err != nil {
+			return fmt.Errorf("bad version %q: %w", v, err)
+		}
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database version %d is newer than this sketch supports (%d)", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		if err := migrations[version](tx); err != nil {
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+	}
+	return meta.Put([]byte("version"), []byte(strconv.Itoa(version)))
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.bolt.Close()
+}
+
+// A Job is a command started in the background.
+type Job struct {
+	ID         uint64    `json:"id"`
+	Session    string    `json:"session"`
+	Command    string    `json:"command"`
+	Dir        string    `json:"dir"`
+	PID        int       `json:"pid"`
+	StdoutFile string    `json:"stdout_file"`
+	StderrFile string    `json:"stderr_file"`
+	Start      time.Time `json:"start"`
+}
+
+// Jobs returns all recorded jobs, oldest first.
+func (d *DB) Jobs() ([]Job, error) {
+	return all[Job](d, jobsBucket)
+}
+
+// RemoveJob removes the record of the job with the given ID.
+func (d *DB) RemoveJob(id uint64) error {
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(jobsBucket).Delete(seqKey(id))
+	})
+}
+
+// A Command is a command run by the bash tool.
+type Command struct {
+	Session    string    `json:"session"`
+	Command    string    `json:"command"`
+	Dir        string    `json:"dir"`
+	Background bool      `json:"background,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// History returns the last n commands run, oldest first.
+// If n is 0, it returns all of them.
+func (d *DB) History(n int) ([]Command, error) {
+	cmds, err := all[Command](d, historyBucket)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && len(cmds) > n {
+		cmds = cmds[len(cmds)-n:]
+	}
+	return cmds, nil
+}
+
+// Kinds of artifacts.
+const (
+	ArtifactBackup    = "backup"     // contents of a file from before the agent rewrote it
+	ArtifactJobOutput = "job_output" // output of a background job
+)
+
+// An Artifact is a file the agent produced outside the repository.
+type Artifact struct {
+	Session string    `json:"session"`
+	Path    string    `json:"path"`
+	Kind    string    `json:"kind"`
+	Source  string    `json:"source"` // what the artifact was produced from, such as the rewritten file
+	Created time.Time `json:"created"`
+}
+
+// Artifacts returns the indexed artifacts of the given kind, or of all kinds if kind is empty,
+// oldest first.
+func (d *DB) Artifacts(kind string) ([]Artifact, error) {
+	arts, err := all[Artifact](d, artifactsBucket)
+	if err != nil {
+		return nil, err
+	}
+	arts = slices.DeleteFunc(arts, func(a Artifact) bool { return kind != "" && a.Kind != kind })
+	slices.SortStableFunc(arts, func(a, b Artifact) int { return a.Created.Compare(b.Created) })
+	return arts, nil
+}
+
+// RemoveArtifact removes the artifact with the given path from the index.
+// It does not remove the file.
+func (d *DB) RemoveArtifact(path string) error {
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(artifactsBucket).Delete([]byte(path))
+	})
+}
+
+// InstallAttempted reports whether MarkInstallAttempted was called for the command name.
+func (d *DB) InstallAttempted(name string) (bool, error) {
+	var attempted bool
+	err := d.bolt.View(func(tx *bbolt.Tx) error {
+		attempted = tx.Bucket(installBucket).Get([]byte(name)) != nil
+		return nil
+	})
+	return attempted, err
+}
+
+// MarkInstallAttempted records that installing the named commands was attempted,
+// or that they need no installation.
+func (d *DB) MarkInstallAttempted(names ...string) error {
+	now := time.Now()
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(installBucket)
+		for _, name := range names {
+			if err := put(b, []byte(name), now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// OutputStats counts the foreground commands of one category, and those whose output was truncated.
+type OutputStats struct {
+	Runs      int `json:"runs"`
+	Truncated int `json:"truncated"`
+}
+
+// AddOutput counts a foreground command of the given categories, and whether its output was truncated.
+func (d *DB) AddOutput(categories []string, truncated bool) error {
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(outputBucket)
+		for _, cat := range categories {
+			var stats OutputStats
+			if v := b.Get([]byte(cat)); v != nil {
+				if err := json.Unmarshal(v, &stats); err != nil {
+					return fmt.Errorf("bad output stats for %s: %w", cat, err)
+				}
+			}
+			stats.Runs++
+			if truncated {
+				stats.Truncated++
+			}
+			if err := put(b, []byte(cat), stats); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Output returns the output statistics of the given command category.
+func (d *DB) Output(category string) (OutputStats, error) {
+	var stats OutputStats
+	err := d.bolt.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket(outputBucket).Get([]byte(category)); v != nil {
+			return json.Unmarshal(v, &stats)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// An undoRecord is an entry of a session's undo journal.
+type undoRecord struct {
+	Session string `json:"session"`
+	undo.Entry
+}
+
+// A stagedRecord is a file that a session staged.
+type stagedRecord struct {
+	Session string `json:"session"`
+	Path    string `json:"path"`
+	staging.File
+}
+
+// seqKey returns the key for sequence number id, which sorts in numeric order.
+func seqKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+// put stores v as JSON under k in b.
+func put(b *bbolt.Bucket, k []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(k, data)
+}
+
+// all returns the values in the named bucket, in key order.
+func all[T any](d *DB, bucket []byte) ([]T, error) {
+	var vals []T
+	err := d.bolt.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			var val T
+			if err := json.Unmarshal(v, &val); err != nil {
+				return fmt.Errorf("bad %s record %x: %w", bucket, k, err)
+			}
+			vals = append(vals, val)
+			return nil
+		})
+	})
+	return vals, err
+}
@@ -0,0 +1,316 @@
+package statedb
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"sketch.dev/claudetool/staging"
+	"sketch.dev/claudetool/undo"
+)
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := db.Session("s1")
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	jobID, jobDir, err := sess.NewJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(filepath.Dir(path), "test.files", "sessions", "s1", "jobs", "1"); jobID != 1 || jobDir != want {
+		t.Errorf("NewJob = %d, %s; want 1, %s", jobID, jobDir, want)
+	}
+	if _, err := os.Stat(jobDir); err != nil {
+		t.Errorf("NewJob did not create the job's directory: %v", err)
+	}
+	stdout := filepath.Join(jobDir, "stdout")
+	job := &Job{ID: jobID, Command: "go run ./server", Dir: "/app", PID: 42, StdoutFile: stdout, StderrFile: filepath.Join(jobDir, "stderr"), Start: start}
+	if err := sess.AddJob(job); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"ls", "go test ./...", "git status"} {
+		if err := sess.AddCommand(Command{Command: c, Dir: "/app", Time: start}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sess.AddArtifact(Artifact{Path: stdout, Kind: ArtifactJobOutput, Source: job.Command, Created: start}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.AddArtifact(Artifact{Path: "/b/main.go.1", Kind: ArtifactBackup, Source: "/app/main.go", Created: start.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkInstallAttempted("rg", "jq"); err != nil {
+		t.Fatal(err)
+	}
+	for _, truncated := range []bool{true, false, true} {
+		if err := db.AddOutput([]string{"build", "test"}, truncated); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything survives reopening the database.
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	jobs, err := db.Jobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].PID != 42 || jobs[0].Session != "s1" || !jobs[0].Start.Equal(start) {
+		t.Errorf("Jobs = %+v, want the job", jobs)
+	}
+	history, err := db.History(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Command != "go test ./..." || history[1].Command != "git status" {
+		t.Errorf("History(2) = %+v, want the last two commands", history)
+	}
+	backups, err := db.Artifacts(ArtifactBackup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Source != "/app/main.go" {
+		t.Errorf("Artifacts(backup) = %+v", backups)
+	}
+	if all, _ := db.Artifacts(""); len(all) != 2 || all[0].Kind != ArtifactJobOutput {
+		t.Errorf("Artifacts() = %+v, want both, oldest first", all)
+	}
+	for name, want := range map[string]bool{"rg": true, "jq": true, "fd": false} {
+		if got, err := db.InstallAttempted(name); err != nil || got != want {
+			t.Errorf("InstallAttempted(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	for cat, want := range map[string]OutputStats{"test": {Runs: 3, Truncated: 2}, "vcs": {}} {
+		if got, err := db.Output(cat); err != nil || got != want {
+			t.Errorf("Output(%q) = %+v, %v; want %+v", cat, got, err, want)
+		}
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for range maxHistory + 5 {
+		if err := db.Session("s").AddCommand(Command{Command: "true"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := db.History(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != maxHistory {
+		t.Errorf("len(History) = %d, want %d", len(history), maxHistory)
+	}
+}
+
+func TestRemoveSession(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, id := range []string{"gone", "kept"} {
+		sess := db.Session(id)
+		jobID, dir, err := sess.NewJob()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := filepath.Join(dir, "stdout")
+		if err := sess.AddJob(&Job{ID: jobID, StdoutFile: stdout}); err != nil {
+			t.Fatal(err)
+		}
+		if err := sess.AddArtifact(Artifact{Path: stdout, Kind: ArtifactJobOutput}); err != nil {
+			t.Fatal(err)
+		}
+		if err := sess.AddCommand(Command{Command: "sleep 100"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.RemoveSession("gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := db.SessionIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "kept" {
+		t.Errorf("SessionIDs = %v, want [kept]", ids)
+	}
+	jobs, _ := db.Jobs()
+	history, _ := db.History(0)
+	artifacts, _ := db.Artifacts("")
+	if len(jobs) != 1 || len(history) != 1 || len(artifacts) != 1 || jobs[0].Session != "kept" || history[0].Session != "kept" || artifacts[0].Session != "kept" {
+		t.Errorf("after RemoveSession, jobs = %+v, history = %+v, artifacts = %+v; want only session kept's", jobs, history, artifacts)
+	}
+	if err := db.RemoveSession("../kept"); err == nil {
+		t.Errorf("RemoveSession accepted a path as a session ID")
+	}
+}
+
+func TestStagedChangesSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	file := filepath.Join(dir, "f.txt")
+	if err := os.WriteFile(file, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	area, err := staging.NewPersistentArea(db.Session("s1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := area.WriteFile(file, []byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	area, err = staging.NewPersistentArea(db.Session("s1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := area.Pending(); len(pending) != 1 || pending[0].Path != file {
+		t.Fatalf("Pending after reopening = %+v, want the change to %s", pending, file)
+	}
+	if err := area.Approve(file, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(file); string(got) != "new\n" {
+		t.Errorf("file after approval = %q", got)
+	}
+	if staged, err := db.Session("s1").LoadStaged(); err != nil || len(staged) != 0 {
+		t.Errorf("LoadStaged after approval = %v, %v; want nothing", staged, err)
+	}
+}
+
+func TestStagedChangesPerSession(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Two sessions in the same working directory, one of them staging a change to a file the other also changes.
+	shared, mine, theirs := filepath.Join(dir, "shared.txt"), filepath.Join(dir, "mine.txt"), filepath.Join(dir, "theirs.txt")
+	one, err := staging.NewPersistentArea(db.Session("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := staging.NewPersistentArea(db.Session("two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []struct {
+		area *staging.Area
+		path string
+		data string
+	}{{one, mine, "one\n"}, {one, shared, "one\n"}, {two, theirs, "two\n"}, {two, shared, "two\n"}} {
+		if err := w.area.WriteFile(w.path, []byte(w.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A session started again sees only its own changes.
+	for _, tt := range []struct {
+		id    string
+		paths []string
+		data  string
+	}{{"one", []string{mine, shared}, "one\n"}, {"two", []string{shared, theirs}, "two\n"}} {
+		area, err := staging.NewPersistentArea(db.Session(tt.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, p := range area.Pending() {
+			paths = append(paths, p.Path)
+		}
+		slices.Sort(paths)
+		if !slices.Equal(paths, tt.paths) {
+			t.Errorf("session %s's pending changes = %q, want %q", tt.id, paths, tt.paths)
+		}
+		if got, err := area.ReadFile(shared); err != nil || string(got) != tt.data {
+			t.Errorf("session %s's staged %s = %q, %v; want %q", tt.id, shared, got, err, tt.data)
+		}
+	}
+
+	if err := db.RemoveSession("two"); err != nil {
+		t.Fatal(err)
+	}
+	if staged, err := db.Session("two").LoadStaged(); err != nil || len(staged) != 0 {
+		t.Errorf("removed session two still has staged changes %v, %v", staged, err)
+	}
+	if staged, err := db.Session("one").LoadStaged(); err != nil || len(staged) != 2 {
+		t.Errorf("session one has staged changes %v, %v after session two was removed; want 2", staged, err)
+	}
+}
+
+func TestUndoJournalPerSession(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	one, two := db.Session("one"), db.Session("two")
+	for _, s := range []*Session{one, two, one} {
+		if err := s.AddUndo(&undo.Entry{Path: "/f/" + s.ID(), After: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := one.LoadUndo()
+	if err != nil || len(entries) != 2 || entries[0].Path != "/f/one" || entries[0].ID >= entries[1].ID {
+		t.Fatalf("LoadUndo = %+v, %v; want session one's two entries in order", entries, err)
+	}
+	if err := one.RemoveUndo(entries[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RemoveSession("two"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := one.LoadUndo(); len(entries) != 1 {
+		t.Errorf("session one has %d entries after removing one, want 1", len(entries))
+	}
+	if entries, _ := two.LoadUndo(); len(entries) != 0 {
+		t.Errorf("removed session two still has %d entries", len(entries))
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	migrations = append(migrations, func(*bbolt.Tx) error { return nil })
+	db, err := Open(path)
+	migrations = migrations[:len(migrations)-1]
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if db, err := Open(path); err == nil {
+		db.Close()
+		t.Errorf("Open succeeded on a database migrated by a newer version")
+	}
+}
@@ -0,0 +1,301 @@
+package termui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/skills"
+	"sketch.dev/toolplugin"
+)
+
+// A Command is a slash command of the terminal UI, which the user runs by typing /name and any arguments.
+type Command struct {
+	// Name is the command's name, without the slash.
+	Name string
+	// Args describes the command's arguments, for help, such as "[all]".
+	Args        string
+	Description string
+	// Run runs the command with the rest of the user's line, trimmed.
+	// It shows its results with ui.AppendSystemMessage; an error is shown for it.
+	Run func(ctx context.Context, ui *TermUI, args string) error
+}
+
+// validCommandName matches the names of slash commands.
+var validCommandName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// RegisterCommand adds c to the slash commands, which help lists and Tab completes.
+func (ui *TermUI) RegisterCommand(c Command) error {
+	if !validCommandName.MatchString(c.Name) {
+		return fmt.Errorf("invalid command name %q", c.Name)
+	}
+	if c.Run == nil {
+		return fmt.Errorf("command /%s has no Run function", c.Name)
+	}
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	if _, ok := ui.commands[c.Name]; ok {
+		return fmt.Errorf("command /%s is already registered", c.Name)
+	}
+	ui.commands[c.Name] = &c
+	return nil
+}
+
+// command returns the slash command named name, or nil if there is none.
+func (ui *TermUI) command(name string) *Command {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	return ui.commands[name]
+}
+
+// commandNames returns the names of the slash commands, sorted.
+func (ui *TermUI) commandNames() []string {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	return slices.Sorted(maps.Keys(ui.commands))
+}
+
+// registerCommands registers the built-in slash commands and those of the agent's plugins.
+func (ui *TermUI) registerCommands() {
+	for _, c := range []Command{
+		{Name: "help", Description: "List the slash commands", Run: helpCommand},
+		{Name: "model", Description: "Show the models this session uses", Run: modelCommand},
+		{Name: "compact", Description: "Summarize the conversation so far, and continue from the summary", Run: compactCommand},
+		{Name: "cost", Description: "Show token usage and cost", Run: func(ctx context.Context, ui *TermUI, args string) error {
+			ui.showUsage()
+			return nil
+		}},
+		{Name: "undo", Args: "[all]", Description: "Undo sketch's last file write, or all of them", Run: undoCommand},
+		{Name: "jobs", Description: "List the background jobs sketch started", Run: jobsCommand},
+		{Name: "attach", Args: "[path|glob|URL...]", Description: "Pin files or pages into the conversation, or list those pinned", Run: attachCommand},
+		{Name: "detach", Args: "[name...]", Description: "Unpin attachments, or all of them", Run: detachCommand},
+		{Name: "skill", Args: "[name [instructions]]", Description: "Have the agent use one of the project's skills, or list them", Run: skillCommand},
+		{Name: "permissions", Description: "Show the project's rules for tool calls, and requests awaiting your answer", Run: permissionsCommand},
+	} {
+		if err := ui.RegisterCommand(c); err != nil {
+			panic(err)
+		}
+	}
+	for _, pc := range ui.agent.PluginCommands() {
+		if err := ui.RegisterCommand(pluginCommand(pc)); err != nil {
+			slog.Warn("plugin_command_skipped", "command", pc.Name, "err", err)
+		}
+	}
+}
+
+// pluginCommand adapts a command from a plugin.
+func pluginCommand(pc *toolplugin.Command) Command {
+	return Command{
+		Name:        pc.Name,
+		Description: pc.Description,
+		Run: func(ctx context.Context, ui *TermUI, args string) error {
+			out, err := pc.Run(ctx, args)
+			if err != nil {
+				return err
+			}
+			if out = strings.TrimRight(out, "\n"); out != "" {
+				ui.AppendSystemMessage("%s", out)
+			}
+			return nil
+		},
+	}
+}
+
+// runCommand runs line as a slash command, and reports whether it was one.
+// Lines that start with a slash but not with a command's name, such as paths, are not.
+func (ui *TermUI) runCommand(ctx context.Context, line string) bool {
+	rest, ok := strings.CutPrefix(line, "/")
+	if !ok {
+		return false
+	}
+	name, args, _ := strings.Cut(rest, " ")
+	if !validCommandName.MatchString(name) {
+		return false
+	}
+	c := ui.command(name)
+	if c == nil {
+		ui.AppendSystemMessage("❌ Unknown command /%s; type /help to list them", name)
+		return true
+	}
+	if err := c.Run(ctx, ui, strings.TrimSpace(args)); err != nil {
+		ui.AppendSystemMessage("❌ /%s: %v", name, err)
+	}
+	return true
+}
+
+// completeCommand completes the name of the slash command line starts,
+// or, when there are several that could be meant, completes what they share and lists them.
+func (ui *TermUI) completeCommand(line string) (string, int, bool) {
+	prefix := line[1:]
+	var matches []string
+	for _, name := range ui.commandNames() {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", 0, false
+	case 1:
+		line = "/" + matches[0] + " "
+		return line, len(line), true
+	}
+	common := commonPrefix(matches)
+	if common == prefix {
+		ui.AppendSystemMessage("/%s", strings.Join(matches, "  /"))
+	}
+	line = "/" + common
+	return line, len(line), true
+}
+
+func helpCommand(ctx context.Context, ui *TermUI, args string) error {
+	buf := new(strings.Builder)
+	buf.WriteString("Slash commands (Tab completes them):")
+	for _, name := range ui.commandNames() {
+		c := ui.command(name)
+		usage := "/" + c.Name
+		if c.Args != "" {
+			usage += " " + c.Args
+		}
+		fmt.Fprintf(buf, "\n- %-20s: %s", usage, c.Description)
+	}
+	ui.AppendSystemMessage("%s", buf.String())
+	return nil
+}
+
+func modelCommand(ctx context.Context, ui *TermUI, args string) error {
+	if args != "" {
+		return fmt.Errorf("the model is chosen when sketch starts; to use %s, start sketch with -model=%s", args, args)
+	}
+	usage := ui.agent.TotalUsage()
+	if len(usage.Models) == 0 {
+		ui.AppendSystemMessage("🤖 No model has responded yet")
+		return nil
+	}
+	for _, model := range slices.Sorted(maps.Keys(usage.Models)) {
+		u := usage.Models[model]
+		ui.AppendSystemMessage("🤖 %s: %s in, %s out, $%0.2f", model, humanize.Comma(int64(u.InputTokens+u.CacheReadInputTokens+u.CacheCreationInputTokens)), humanize.Comma(int64(u.OutputTokens)), u.CostUSD)
+	}
+	return nil
+}
+
+func compactCommand(ctx context.Context, ui *TermUI, args string) error {
+	ui.AppendSystemMessage("🗜️  Compacting the conversation…")
+	// Summarizing takes a while; keep taking input meanwhile.
+	go func() {
+		if err := ui.agent.CompactConversation(ctx); err != nil {
+			ui.AppendSystemMessage("❌ /compact: %v", err)
+		}
+	}()
+	return nil
+}
+
+func undoCommand(ctx context.Context, ui *TermUI, args string) error {
+	if args != "" && args != "all" {
+		return fmt.Errorf("want /undo or /undo all, not /undo %s", args)
+	}
+	undone, err := ui.agent.UndoFileWrites(args == "all")
+	for _, e := range undone {
+		ui.AppendSystemMessage("↩️  %s", claudetool.DescribeUndo(e))
+	}
+	if err != nil {
+		return err
+	}
+	if len(undone) == 0 {
+		ui.AppendSystemMessage("↩️  There were no file writes to undo")
+	}
+	return nil
+}
+
+func jobsCommand(ctx context.Context, ui *TermUI, args string) error {
+	jobs := ui.agent.BackgroundJobs()
+	if len(jobs) == 0 {
+		ui.AppendSystemMessage("🔄 No background jobs")
+		return nil
+	}
+	for _, j := range jobs {
+		ui.AppendSystemMessage("🔄 #%d %s (pid %d): %s\n   output: %s, %s", j.Job, j.Status, j.PID, j.Command, j.StdoutFile, j.StderrFile)
+	}
+	return nil
+}
+
+func attachCommand(ctx context.Context, ui *TermUI, args string) error {
+	sources := strings.Fields(args)
+	if len(sources) == 0 {
+		atts := ui.agent.Attachments()
+		if len(atts) == 0 {
+			ui.AppendSystemMessage("📎 Nothing is attached; /attach a file, directory, glob, or URL, or @-mention one")
+		}
+		for _, a := range atts {
+			ui.AppendSystemMessage("📎 %s (%s, attached %s)", a.Name, humanize.Bytes(uint64(len(a.Content))), humanize.Time(a.Time))
+		}
+		return nil
+	}
+	atts, err := ui.agent.Attach(ctx, sources...)
+	for _, a := range atts {
+		truncated := ""
+		if a.Truncated {
+			truncated = ", truncated"
+		}
+		ui.AppendSystemMessage("📎 Attached %s (%d lines%s)", a.Name, strings.Count(a.Content, "\n"), truncated)
+	}
+	return err
+}
+
+func detachCommand(ctx context.Context, ui *TermUI, args string) error {
+	n := ui.agent.Detach(strings.Fields(args)...)
+	ui.AppendSystemMessage("📎 Detached %d attachments", n)
+	return nil
+}
+
+func skillCommand(ctx context.Context, ui *TermUI, args string) error {
+	found, err := ui.agent.Skills()
+	name, instructions, _ := strings.Cut(args, " ")
+	if name == "" {
+		if len(found) == 0 && err == nil {
+			ui.AppendSystemMessage("🧰 The project has no skills; add them under %s", skills.Dir)
+		}
+		for _, s := range found {
+			ui.AppendSystemMessage("🧰 %s: %s", s.Name, s.Description)
+		}
+		return err
+	}
+	if !slices.ContainsFunc(found, func(s *skills.Skill) bool { return s.Name == name }) {
+		return errors.Join(fmt.Errorf("no skill named %q; /skill lists them", name), err)
+	}
+	msg := fmt.Sprintf("Use the %s skill.", name)
+	if instructions = strings.TrimSpace(instructions); instructions != "" {
+		msg += " " + instructions
+	}
+	ui.agent.UserMessage(ctx, msg)
+	return nil
+}
+
+func permissionsCommand(ctx context.Context, ui *TermUI, args string) error {
+	rules := ui.agent.PermissionRules()
+	if len(rules) == 0 {
+		ui.AppendSystemMessage("🔐 The project sets no rules for tool calls")
+	} else {
+		buf := new(strings.Builder)
+		buf.WriteString("🔐 The project's rules for tool calls, the first that matches deciding:")
+		for _, r := range rules {
+			match := "every call"
+			if r.Match != "" {
+				match = fmt.Sprintf("calls matching %q", r.Match)
+			}
+			fmt.Fprintf(buf, "\n- %s %s of %s", r.Action, match, r.Tool)
+		}
+		ui.AppendSystemMessage("%s", buf.String())
+	}
+	for _, req := range ui.agent.PendingPermissions() {
+		ui.AppendSystemMessage("🔐 %s asks permission (request %s) to %s\nType 'allow %s' or 'deny %s'.", req.Tool, req.ID, req.Description, req.ID, req.ID)
+	}
+	return nil
+}
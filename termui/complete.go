@@ -0,0 +1,165 @@
+package termui
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxListedCompletions is how many candidates Tab lists when it cannot pick one.
+	maxListedCompletions = 20
+	// repoFilesTTL is how long the list of the repository's files is reused before it is listed again.
+	repoFilesTTL = 30 * time.Second
+)
+
+// repoFiles caches the repository's files, for completion.
+type repoFiles struct {
+	mu    sync.Mutex
+	files []string // relative to the repository root
+	at    time.Time
+}
+
+// complete is the terminal's autocompletion, on Tab.
+// At the start of a line, it completes slash commands; elsewhere, the path in the word before the cursor,
+// which may be an @-mention.
+func (ui *TermUI) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	if strings.HasPrefix(line, "/") && !strings.Contains(line, " ") && pos == len(line) {
+		if newLine, newPos, ok := ui.completeCommand(line); ok {
+			return newLine, newPos, ok
+		}
+	}
+	return ui.completePath(line, pos)
+}
+
+// completePath completes the path in the word before pos: a file the agent changed recently,
+// or any file in the repository, relative to its root. A word matches the files whose paths start with it,
+// or, if there are none, the files whose names do.
+func (ui *TermUI) completePath(line string, pos int) (string, int, bool) {
+	start := strings.LastIndexAny(line[:pos], " \t") + 1
+	word := line[start:pos]
+	mention := ""
+	if strings.HasPrefix(word, "@") {
+		mention, word = "@", word[1:]
+	}
+	if word == "" && mention == "" {
+		return "", 0, false
+	}
+	matches := ui.matchPaths(word)
+	replace := func(s string) (string, int, bool) {
+		s = mention + s
+		return line[:start] + s + line[pos:], start + len(s), true
+	}
+	switch len(matches) {
+	case 0:
+		return "", 0, false
+	case 1:
+		return replace(matches[0] + " ")
+	}
+	if common := commonPrefix(matches); strings.HasPrefix(common, word) && common != word {
+		return replace(common)
+	}
+	listed := matches[:min(len(matches), maxListedCompletions)]
+	msg := strings.Join(listed, "  ")
+	if more := len(matches) - len(listed); more > 0 {
+		msg += fmt.Sprintf("  (and %d more)", more)
+	}
+	ui.AppendSystemMessage("%s", msg)
+	return "", 0, false
+}
+
+// matchPaths returns the paths that prefix completes, the files the agent wrote most recently first.
+func (ui *TermUI) matchPaths(prefix string) []string {
+	candidates := ui.recentFiles()
+	for _, f := range ui.repoFiles() {
+		if !slices.Contains(candidates, f) {
+			candidates = append(candidates, f)
+		}
+	}
+	var byPath, byName []string
+	for _, f := range candidates {
+		switch {
+		case strings.HasPrefix(f, prefix):
+			byPath = append(byPath, f)
+		case strings.HasPrefix(path.Base(f), prefix):
+			byName = append(byName, f)
+		}
+	}
+	if len(byPath) > 0 {
+		return byPath
+	}
+	return byName
+}
+
+// recentFiles returns the files the agent wrote, most recent first, relative to the repository root if they are in it.
+func (ui *TermUI) recentFiles() []string {
+	root := ui.agent.RepoRoot()
+	var files []string
+	writes := ui.agent.FileWrites()
+	for i := len(writes) - 1; i >= 0; i-- {
+		f := writes[i].Path
+		if rel, err := filepath.Rel(root, f); root != "" && err == nil && filepath.IsLocal(rel) {
+			f = filepath.ToSlash(rel)
+		}
+		if !slices.Contains(files, f) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// repoFiles returns the files in the repository, tracked or not, except those git ignores.
+func (ui *TermUI) repoFiles() []string {
+	ui.files.mu.Lock()
+	defer ui.files.mu.Unlock()
+	if time.Since(ui.files.at) < repoFilesTTL {
+		return ui.files.files
+	}
+	ui.files.at = time.Now()
+	root := ui.agent.RepoRoot()
+	if root == "" {
+		ui.files.files = nil
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		ui.files.files = nil
+		return nil
+	}
+	ui.files.files = nil
+	for f := range strings.SplitSeq(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if f != "" {
+			ui.files.files = append(ui.files.files, f)
+		}
+	}
+	return ui.files.files
+}
+
+// commonPrefix returns the longest prefix that all of ss share, never splitting a multi-byte character.
+func commonPrefix(ss []string) string {
+	if len(ss) == 0 {
+		return ""
+	}
+	common := ss[0]
+	for _, s := range ss[1:] {
+		for !strings.HasPrefix(s, common) {
+			_, size := utf8.DecodeLastRuneInString(common)
+			common = common[:len(common)-size]
+		}
+	}
+	return common
+}
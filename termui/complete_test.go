@@ -0,0 +1,131 @@
+package termui
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+
+	"sketch.dev/claudetool/undo"
+	"sketch.dev/loop"
+)
+
+// completeAgent is an agent with a repository and file writes, the parts of it that completion uses.
+type completeAgent struct {
+	loop.CodingAgent
+	root   string
+	writes []undo.Entry
+}
+
+func (a *completeAgent) RepoRoot() string         { return a.root }
+func (a *completeAgent) FileWrites() []undo.Entry { return a.writes }
+
+// newCompleteUI returns a terminal UI whose agent has a git repository holding files, which ignores build/,
+// and has written writes, oldest first, which are relative to the repository unless absolute.
+// Messages the UI shows are sent to the returned channel.
+func newCompleteUI(t *testing.T, files, writes []string) (*TermUI, <-chan string) {
+	t.Helper()
+	root := t.TempDir()
+	contents := map[string]string{".gitignore": "build/\n"}
+	for _, f := range files {
+		contents[f] = f + "\n"
+	}
+	for f, content := range contents {
+		p := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command("git", "init", "-q")
+	cmd.Dir = root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init: %s: %v", out, err)
+	}
+	agent := &completeAgent{root: root}
+	for _, w := range writes {
+		if !filepath.IsAbs(w) {
+			w = filepath.Join(root, filepath.FromSlash(w))
+		}
+		agent.writes = append(agent.writes, undo.Entry{Path: w})
+	}
+	logs := make(chan string, 10)
+	return &TermUI{agent: agent, termLogCh: logs}, logs
+}
+
+func TestCompletePath(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	files := []string{"main.go", "termui/termui.go", "termui/complete.go", "docs/naïve.md", "docs/naíve.md", "build/out.o"}
+	tests := []struct {
+		name     string
+		line     string
+		pos      int // of the cursor; -1 for the end of the line
+		writes   []string
+		want     string // the completed line, or "" for none
+		wantPos  int
+		wantList string // the candidates listed when there is no single completion
+	}{
+		{name: "unique path", line: "look at mai", pos: -1, want: "look at main.go ", wantPos: 16},
+		{name: "mention", line: "see @termui/te", pos: -1, want: "see @termui/termui.go ", wantPos: 22},
+		{name: "common prefix", line: "ter", pos: -1, want: "termui/", wantPos: 7},
+		{name: "ambiguous", line: "termui/", pos: -1, wantList: "termui/complete.go  termui/termui.go"},
+		{name: "by name", line: "complete", pos: -1, want: "termui/complete.go ", wantPos: 19},
+		{name: "mid-line", line: "open mai please", pos: 8, want: "open main.go  please", wantPos: 13},
+		{name: "common prefix ends before a multi-byte character", line: "docs/n", pos: -1, want: "docs/na", wantPos: 7},
+		{name: "ignored", line: "build/", pos: -1},
+		{name: "no match", line: "zzz", pos: -1},
+		{name: "empty word", line: "look ", pos: -1},
+		{name: "recent writes first", line: "termui/", pos: -1, writes: []string{"termui/termui.go"}, wantList: "termui/termui.go  termui/complete.go"},
+		{name: "write outside the repository", line: "/elsewhere/sk", pos: -1, writes: []string{"/elsewhere/sketch.txt"}, want: "/elsewhere/sketch.txt ", wantPos: 22},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui, logs := newCompleteUI(t, files, tt.writes)
+			pos := tt.pos
+			if pos < 0 {
+				pos = len(tt.line)
+			}
+			got, gotPos, ok := ui.complete(tt.line, pos, '\t')
+			switch {
+			case tt.want == "" && ok:
+				t.Errorf("complete(%q) = %q, want no completion", tt.line, got)
+			case tt.want != "" && (!ok || got != tt.want || gotPos != tt.wantPos):
+				t.Errorf("complete(%q) = %q, %d, %v; want %q, %d", tt.line, got, gotPos, ok, tt.want, tt.wantPos)
+			}
+			var listed string
+			select {
+			case listed = <-logs:
+			default:
+			}
+			if listed != tt.wantList {
+				t.Errorf("complete(%q) listed %q, want %q", tt.line, listed, tt.wantList)
+			}
+		})
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		ss   []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"abc"}, "abc"},
+		{[]string{"abc", "abd", "ab"}, "ab"},
+		{[]string{"abc", "xyz"}, ""},
+		// ï and í share their first byte, which is no prefix of its own.
+		{[]string{"naïve", "naíve"}, "na"},
+		{[]string{"日本語", "日本人"}, "日本"},
+	}
+	for _, tt := range tests {
+		got := commonPrefix(tt.ss)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("commonPrefix(%q) = %q, want %q", tt.ss, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package termui
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/fatih/color"
+)
+
+// DefaultTheme is the chroma style that code is highlighted with, unless another is chosen.
+const DefaultTheme = "monokai"
+
+// NoTheme turns highlighting off.
+const NoTheme = "none"
+
+// CheckTheme returns an error if theme is neither a chroma style nor NoTheme.
+func CheckTheme(theme string) error {
+	if theme == NoTheme || styles.Registry[theme] != nil {
+		return nil
+	}
+	return fmt.Errorf("unknown theme %q; want %s, or one of %s", theme, NoTheme, strings.Join(styles.Names(), ", "))
+}
+
+// continuation starts the rest of a code line too long for the terminal; it takes continuationWidth columns.
+const (
+	continuation      = "↪ "
+	continuationWidth = 2
+)
+
+// A renderer formats messages for the terminal.
+// It highlights the code blocks in messages with chroma, colors unified diffs,
+// and wraps code lines at the terminal's width, so that wrapping does not break up their colors.
+type renderer struct {
+	style     *chroma.Style // nil if highlighting is off
+	formatter chroma.Formatter
+}
+
+// newRenderer returns a renderer highlighting with the chroma style named theme.
+// Highlighting is off for NoTheme, and when color is off, as it is when NO_COLOR is set.
+func newRenderer(theme string) *renderer {
+	r := &renderer{}
+	if theme == NoTheme || color.NoColor {
+		return r
+	}
+	r.style = styles.Get(theme)
+	r.formatter = formatters.TTY256
+	if ct := os.Getenv("COLORTERM"); ct == "truecolor" || ct == "24bit" {
+		r.formatter = formatters.TTY16m
+	}
+	return r
+}
+
+// message renders a chat message: the code blocks fenced with ``` in text are highlighted,
+// and the rest is left as is.
+func (r *renderer) message(text string, width int) string {
+	if !strings.Contains(text, "```") {
+		return text
+	}
+	buf := new(strings.Builder)
+	lines := strings.SplitAfter(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		lang, ok := strings.CutPrefix(strings.TrimSpace(lines[i]), "```")
+		buf.WriteString(lines[i])
+		if !ok {
+			continue
+		}
+		// A code block, up to its closing fence or the end of the message.
+		end := i + 1
+		for end < len(lines) && strings.TrimSpace(lines[end]) != "```" {
+			end++
+		}
+		code := strings.Join(lines[i+1:end], "")
+		lang, _, _ = strings.Cut(strings.TrimSpace(lang), " ")
+		rendered := r.code(code, lang, width)
+		buf.WriteString(rendered)
+		if end < len(lines) {
+			if !strings.HasSuffix(rendered, "\n") {
+				buf.WriteString("\n")
+			}
+			buf.WriteString(lines[end])
+		}
+		i = end
+	}
+	return buf.String()
+}
+
+// code renders src, code in the language lang, which may be empty if unknown.
+func (r *renderer) code(src, lang string, width int) string {
+	if lang == "diff" || lang == "patch" || (lang == "" && isDiff(src)) {
+		return r.diff(src, width)
+	}
+	src = strings.ReplaceAll(src, "\t", "    ")
+	if r.style == nil {
+		return wrap(src, width)
+	}
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Analyse(src)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, src)
+	if err != nil {
+		return wrap(src, width)
+	}
+	buf := new(strings.Builder)
+	if err := r.formatter.Format(buf, r.style, chroma.Literator(wrapTokens(it.Tokens(), width)...)); err != nil {
+		return wrap(src, width)
+	}
+	return buf.String()
+}
+
+// diff renders a unified diff: additions in green, deletions in red, and hunk headers in cyan.
+func (r *renderer) diff(src string, width int) string {
+	if r.style == nil {
+		return wrap(src, width)
+	}
+	var (
+		header = color.New(color.Bold).SprintFunc()
+		add    = color.New(color.FgGreen).SprintFunc()
+		del    = color.New(color.FgRed).SprintFunc()
+		hunk   = color.New(color.FgCyan).SprintFunc()
+	)
+	buf := new(strings.Builder)
+	for _, line := range strings.SplitAfter(src, "\n") {
+		if line == "" {
+			continue
+		}
+		nl := strings.HasSuffix(line, "\n")
+		text := strings.TrimSuffix(line, "\n")
+		paint := func(a ...any) string { return fmt.Sprint(a...) }
+		switch {
+		case strings.HasPrefix(text, "+++"), strings.HasPrefix(text, "---"), strings.HasPrefix(text, "diff "):
+			paint = header
+		case strings.HasPrefix(text, "+"):
+			paint = add
+		case strings.HasPrefix(text, "-"):
+			paint = del
+		case strings.HasPrefix(text, "@@"):
+			paint = hunk
+		}
+		// Each wrapped piece is painted on its own, so that the colors survive the line break.
+		pieces := strings.Split(strings.TrimSuffix(wrap(strings.ReplaceAll(text, "\t", "    "), width), "\n"), "\n")
+		for i, piece := range pieces {
+			buf.WriteString(paint(piece))
+			if i < len(pieces)-1 || nl {
+				buf.WriteString("\n")
+			}
+		}
+	}
+	return buf.String()
+}
+
+// isDiff reports whether src looks like a unified diff.
+func isDiff(src string) bool {
+	lines := strings.Split(src, "\n")
+	return slices.ContainsFunc(lines, func(l string) bool { return strings.HasPrefix(l, "@@ ") }) &&
+		slices.ContainsFunc(lines, func(l string) bool { return strings.HasPrefix(l, "+") || strings.HasPrefix(l, "-") })
+}
+
+// wrap breaks the lines of s longer than width columns, starting the rest of each with continuation.
+func wrap(s string, width int) string {
+	tokens := wrapTokens([]chroma.Token{{Type: chroma.Text, Value: s}}, width)
+	buf := new(strings.Builder)
+	for _, t := range tokens {
+		buf.WriteString(t.Value)
+	}
+	return buf.String()
+}
+
+// wrapTokens breaks the lines of tokens longer than width columns, splitting tokens where needed,
+// so that each piece keeps its token's type, and so its color.
+func wrapTokens(tokens []chroma.Token, width int) []chroma.Token {
+	if width <= continuationWidth+1 {
+		return tokens
+	}
+	// Leave the last column free, as some terminals wrap when it is written to.
+	limit := width - 1
+	var out []chroma.Token
+	col := 0
+	for _, t := range tokens {
+		start := 0
+		for i, r := range t.Value {
+			if r == '\n' {
+				col = 0
+				continue
+			}
+			w := runeWidth(r)
+			if col+w > limit {
+				if i > start {
+					out = append(out, chroma.Token{Type: t.Type, Value: t.Value[start:i]})
+				}
+				out = append(out, chroma.Token{Type: chroma.Comment, Value: "\n" + continuation})
+				start = i
+				col = continuationWidth
+			}
+			col += w
+		}
+		if start < len(t.Value) {
+			out = append(out, chroma.Token{Type: t.Type, Value: t.Value[start:]})
+		}
+	}
+	return out
+}
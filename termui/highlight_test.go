@@ -0,0 +1,123 @@
+package termui
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+// update is set by the -update flag, to rewrite the golden files instead of comparing against them.
+var update = flag.Bool("update", false, "update golden files in testdata instead of failing tests")
+
+func TestRenderMessage(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = false
+	t.Cleanup(func() { color.NoColor = noColor })
+	t.Setenv("COLORTERM", "")
+
+	tests := []struct {
+		name  string
+		theme string
+		width int
+		text  string
+	}{
+		{
+			name:  "go",
+			theme: DefaultTheme,
+			width: 80,
+			text:  "Here is the fix:\n```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```\nDone.\n",
+		},
+		{
+			name:  "diff",
+			theme: DefaultTheme,
+			width: 80,
+			text:  "```diff\n--- a/x.go\n+++ b/x.go\n@@ -1,2 +1,2 @@\n package x\n-var a = 1\n+var a = 2\n```\n",
+		},
+		{
+			name:  "unlabeled diff",
+			theme: DefaultTheme,
+			width: 80,
+			text:  "```\n@@ -1 +1 @@\n-old\n+new\n```\n",
+		},
+		{
+			name:  "unknown language",
+			theme: DefaultTheme,
+			width: 80,
+			text:  "```nosuchlanguage extra words\nsome code\n```\n",
+		},
+		{
+			name:  "unclosed fence",
+			theme: DefaultTheme,
+			width: 80,
+			text:  "```python\nprint('hi')\n",
+		},
+		{
+			name:  "wrapped",
+			theme: DefaultTheme,
+			width: 24,
+			text:  "```go\nvar longVariableName = anotherLongFunctionName(argument)\n```\n",
+		},
+		{
+			name:  "github theme",
+			theme: "github",
+			width: 80,
+			text:  "```go\nx := 1\n```\n",
+		},
+		{
+			name:  "no theme",
+			theme: NoTheme,
+			width: 24,
+			text:  "```diff\n-var longVariableName = 1\n+var longVariableName = 2\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newRenderer(tt.theme).message(tt.text, tt.width)
+			// Escape sequences are written out, so that the golden files are readable.
+			got = strings.ReplaceAll(got, "\x1b", `\x1b`)
+			path := filepath.Join("testdata", "highlight", strings.ReplaceAll(tt.name, " ", "_")+".golden")
+			if *update {
+				if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v; run with -update to create it", err)
+			}
+			if got != string(want) {
+				t.Errorf("message(%q) =\n%s\nwant\n%s", tt.text, got, want)
+			}
+		})
+	}
+}
+
+func TestRenderMessageWithoutCode(t *testing.T) {
+	text := "No code here, just `inline` text.\n"
+	if got := newRenderer(DefaultTheme).message(text, 10); got != text {
+		t.Errorf("message = %q, want it unchanged", got)
+	}
+}
+
+func TestCheckTheme(t *testing.T) {
+	for _, theme := range []string{DefaultTheme, NoTheme, "github", "dracula"} {
+		if err := CheckTheme(theme); err != nil {
+			t.Errorf("CheckTheme(%q) = %v", theme, err)
+		}
+	}
+	for _, theme := range []string{"", "no-such-theme", "Monokai "} {
+		err := CheckTheme(theme)
+		if err == nil {
+			t.Errorf("CheckTheme(%q) = nil, want an error", theme)
+			continue
+		}
+		if !strings.Contains(err.Error(), NoTheme) || !strings.Contains(err.Error(), DefaultTheme) {
+			t.Errorf("CheckTheme(%q) = %q, want it to list the themes", theme, err)
+		}
+	}
+}
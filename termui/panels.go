@@ -0,0 +1,277 @@
+package termui
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"sketch.dev/loop"
+)
+
+// Tool calls appear in the terminal as panels. While a call runs, its panel sits just above the prompt,
+// and shows a spinner, how long the call has run, and the last lines of its output, redrawn as it goes.
+// When the call finishes, its panel collapses to a one-line summary in the scrollback,
+// and `expand <n>` shows the call's full output.
+
+const (
+	// panelTailLines is how many of a running call's last lines of output its panel shows.
+	panelTailLines = 4
+	// maxPanelOutput is how much of a running call's output its panel keeps, for the tail.
+	maxPanelOutput = 8 << 10
+	// maxExpandable is how many finished calls' output `expand` can show.
+	maxExpandable = 200
+	// panelRefresh is how often running panels are redrawn, for their spinners and elapsed times.
+	panelRefresh = 250 * time.Millisecond
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// A toolPanel is a tool call shown in the terminal.
+type toolPanel struct {
+	n       int // the number the user refers to the call by
+	summary string
+	start   time.Time
+	output  []byte // the last maxPanelOutput bytes of output so far
+	running bool
+}
+
+// toolPanels are the panels of a session's tool calls.
+type toolPanels struct {
+	mu      sync.Mutex
+	next    int
+	byID    map[string]*toolPanel
+	running []*toolPanel   // in the order they started
+	results map[int]string // full output of finished calls, by number
+	frame   int            // of the spinner
+	lines   int            // how many lines the running panels take on screen
+}
+
+func newToolPanels() *toolPanels {
+	return &toolPanels{byID: make(map[string]*toolPanel), results: make(map[int]string)}
+}
+
+// panel returns the panel of the call with the given ID, numbering a new one if needed. p.mu must be held.
+func (p *toolPanels) panel(id string) *toolPanel {
+	if tp, ok := p.byID[id]; ok {
+		return tp
+	}
+	p.next++
+	tp := &toolPanel{n: p.next}
+	if id != "" {
+		p.byID[id] = tp
+	}
+	return tp
+}
+
+// started opens a running panel for a call.
+func (p *toolPanels) started(id, summary string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tp := p.panel(id)
+	tp.summary, tp.start, tp.running = summary, time.Now(), true
+	p.running = append(p.running, tp)
+}
+
+// output adds to the output of a running call.
+func (p *toolPanels) output(id, delta string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tp, ok := p.byID[id]
+	if !ok || !tp.running {
+		return
+	}
+	tp.output = append(tp.output, delta...)
+	if extra := len(tp.output) - maxPanelOutput; extra > 0 {
+		tp.output = tp.output[extra:]
+	}
+}
+
+// finished closes the panel of a call, keeping its result for expand, and returns its number.
+func (p *toolPanels) finished(id, result string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tp := p.panel(id)
+	p.stop(tp)
+	delete(p.byID, id)
+	p.results[tp.n] = result
+	delete(p.results, tp.n-maxExpandable)
+	return tp.n
+}
+
+// stop takes tp off the running panels. p.mu must be held.
+func (p *toolPanels) stop(tp *toolPanel) {
+	tp.running = false
+	for i, r := range p.running {
+		if r == tp {
+			p.running = append(p.running[:i], p.running[i+1:]...)
+			break
+		}
+	}
+}
+
+// stopAll takes all panels off the running panels, as when the events that would finish them were lost.
+func (p *toolPanels) stopAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, tp := range p.running {
+		tp.running = false
+	}
+	p.running = nil
+}
+
+// result returns the full output of the finished call numbered n.
+func (p *toolPanels) result(n int) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.results[n]
+	return r, ok
+}
+
+// redraw returns what to write to replace the running panels on screen, above the prompt,
+// with before and then the running panels as they are now, each line at most width columns.
+// The cursor must be at the start of the line just below the panels, as it is after a write to a term.Terminal.
+func (p *toolPanels) redraw(before string, width int) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	buf := new(strings.Builder)
+	if p.lines > 0 {
+		// Up to the first line of the panels, and clear everything from there down.
+		fmt.Fprintf(buf, "\x1b[%dA\x1b[J", p.lines)
+	}
+	buf.WriteString(before)
+	p.frame++
+	p.lines = 0
+	for _, tp := range p.running {
+		header := fmt.Sprintf("%s #%d %s  %s", spinnerFrames[p.frame%len(spinnerFrames)], tp.n, tp.summary, time.Since(tp.start).Truncate(100*time.Millisecond))
+		buf.WriteString(clip(header, width) + "\n")
+		p.lines++
+		for _, line := range tail(string(tp.output), panelTailLines) {
+			buf.WriteString(clip("   │ "+line, width) + "\n")
+			p.lines++
+		}
+	}
+	return buf.String()
+}
+
+// hasRunning reports whether any panels are running or still on screen.
+func (p *toolPanels) hasRunning() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.running) > 0 || p.lines > 0
+}
+
+// offScreen records that the panels are no longer on screen, as after another program used the terminal.
+func (p *toolPanels) offScreen() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lines = 0
+}
+
+// terminalControl matches ANSI escape sequences, which would upset the panels' layout.
+var terminalControl = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// tail returns the last n non-blank lines of output, without terminal control sequences.
+// A line rewritten with carriage returns, such as a progress bar, is shown as last written.
+func tail(output string, n int) []string {
+	output = terminalControl.ReplaceAllString(output, "")
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if i := strings.LastIndex(line, "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		line = strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return ' '
+			}
+			if r < ' ' || r == 0x7f {
+				return -1
+			}
+			return r
+		}, line)
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// clip shortens s to fit in width columns, so that it never wraps onto a second line,
+// which would throw off the count of lines to redraw. Wide characters count as two columns.
+func clip(s string, width int) string {
+	if width <= 1 {
+		return s
+	}
+	cols := 0
+	for i, r := range s {
+		w := runeWidth(r)
+		if cols+w > width-1 {
+			return s[:i] + "…"
+		}
+		cols += w
+	}
+	return s
+}
+
+// runeWidth returns how many columns r takes up in the terminal, erring wide.
+func runeWidth(r rune) int {
+	if r >= 0x1100 && utf8.RuneLen(r) > 2 {
+		return 2 // most likely an emoji or CJK character
+	}
+	return 1
+}
+
+// firstLine returns the first non-blank line of s, trimmed.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// receiveToolEvents keeps the panels of running tool calls up to date.
+func (ui *TermUI) receiveToolEvents(ctxDone <-chan struct{}) {
+	for {
+		events, unsubscribe := ui.agent.SubscribeEvents()
+		ui.followToolEvents(ctxDone, events)
+		unsubscribe()
+		select {
+		case <-ctxDone:
+			return
+		default:
+			// We fell behind and were dropped, missing events; start over.
+			ui.panels.stopAll()
+		}
+	}
+}
+
+func (ui *TermUI) followToolEvents(ctxDone <-chan struct{}, events <-chan loop.Event) {
+	for {
+		select {
+		case <-ctxDone:
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.ToolCall == nil {
+				continue
+			}
+			switch e.Type {
+			case loop.EventToolCallStarted:
+				msg := &loop.AgentMessage{ToolName: e.ToolCall.Name, ToolInput: e.ToolCall.Input}
+				ui.panels.started(e.ToolCall.ID, firstLine(ui.describeToolUse(msg)))
+			case loop.EventToolCallOutput:
+				ui.panels.output(e.ToolCall.ID, e.Delta)
+			}
+		}
+	}
+}
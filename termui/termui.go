@@ -2,17 +2,23 @@ package termui
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"text/template"
 	"time"
@@ -29,9 +35,12 @@ var (
 	// and registered in loop/agent.go.
 	// Add formatting for new tools as they are created.
 	// TODO: should this be part of tool definition to make it harder to forget to set up?
-	toolUseTemplTxt = `{{if .msg.ToolError}}〰️ {{end -}}
+	toolUseTemplTxt = `{{if .msg.ToolError -}}
+{{if eq .msg.ToolErrorKind "user_denied"}}🚫{{else if eq .msg.ToolErrorKind "timeout"}}⏱️{{else if eq .msg.ToolErrorKind "tool_crashed"}}💥{{else if eq .msg.ToolErrorKind "budget_exceeded"}}💸{{else}}〰️{{end}} {{end -}}
 {{if eq .msg.ToolName "think" -}}
  🧠 {{.input.thoughts -}}
+{{else if eq .msg.ToolName "pin_results" -}}
+ 📌 Pinning {{if .input.count}}{{.input.count}}{{else}}1{{end}} tool results
 {{else if eq .msg.ToolName "todo_read" -}}
  📋 Reading todo list
 {{else if eq .msg.ToolName "todo_write" }}
@@ -40,9 +49,43 @@ var (
 {{else if eq .msg.ToolName "keyword_search" -}}
  🔍 {{ .input.query}}: {{.input.search_terms -}}
 {{else if eq .msg.ToolName "bash" -}}
- 🖥️{{if .input.background}}🔄{{end}}  {{ .input.command -}}
+ 🖥️{{if .input.background}}🔄{{end}}{{if .input.idle_timeout}}⏳{{end}}{{if .input.cpu_timeout}}🔥{{end}}{{if .input.tail}}✂️{{end}}{{if .input.ready}}🚦{{end}}{{if .input.wait_for}}⛓️{{end}}{{if .input.cluster}}☸️{{end}}  {{ .input.command -}}
+{{else if eq .msg.ToolName "job_group" -}}
+ 🧩 {{.input.action}} {{.input.group}}{{range .input.jobs}} · {{.name}}{{end -}}
+{{else if eq .msg.ToolName "environment" -}}
+ 🧪 {{.input.action}}{{if .input.snapshot}} {{.input.snapshot}}{{end}}{{range $k, $v := .input.vars}} {{$k}}={{$v}}{{end}}{{range .input.names}} {{.}}{{end -}}
 {{else if eq .msg.ToolName "patch" -}}
  ⌨️  {{.input.path -}}
+{{else if eq .msg.ToolName "codegen" -}}
+ 🧬 {{if eq .input.action "detect"}}Detecting API specs and generators{{else}}Regenerating code from API specs{{end -}}
+{{else if eq .msg.ToolName "env_vars" -}}
+ 🌿 Listing environment variables{{if .input.dir}} in {{.input.dir}}{{end -}}
+{{else if eq .msg.ToolName "fetch_url" -}}
+ 📥 {{.input.url}}{{if .input.start_index}} from byte {{.input.start_index}}{{end}}{{if .input.raw}} (raw){{end -}}
+{{else if eq .msg.ToolName "deps" -}}
+ 📦 {{.input.action}}{{if .input.ecosystem}} ({{.input.ecosystem}}){{end}}{{range .input.packages}} {{.}}{{end}}{{if .input.dir}} in {{.input.dir}}{{end -}}
+{{else if eq .msg.ToolName "godoc" -}}
+ 📚 {{.input.package}}{{if .input.symbol}}.{{.input.symbol}}{{end}}{{if .input.version}}@{{.input.version}}{{end}}{{if .input.all}} (all){{end -}}
+{{else if eq .msg.ToolName "codebase_overview" -}}
+ 🗺️ Codebase overview{{if .input.path}} of {{.input.path}}{{end -}}
+{{else if eq .msg.ToolName "symbols" -}}
+ 🏷️ {{if eq .input.action "find"}}Finding {{.input.name}}{{if .input.kind}} ({{.input.kind}}){{end}}{{if .input.path}} in {{.input.path}}{{end}}{{else}}Listing symbols in {{or .input.path "."}}{{end -}}
+{{else if eq .msg.ToolName "skill" -}}
+ 🧰 {{if eq .input.action "use"}}Using the {{.input.name}} skill{{else}}Listing skills{{end -}}
+{{else if eq .msg.ToolName "semantic_search" -}}
+ 🧭 {{.input.query}}{{if .input.path}} in {{.input.path}}{{end}}{{if .input.count}} (top {{.input.count}}){{end -}}
+{{else if eq .msg.ToolName "web_search" -}}
+ 🔎 {{.input.query}}{{if .input.count}} (top {{.input.count}}){{end -}}
+{{else if eq .msg.ToolName "forge" -}}
+ 🔨 {{.input.action}}{{if .input.number}} #{{.input.number}}{{end}}{{if .input.branch}} {{.input.branch}}{{end}}{{if .input.title}}: {{.input.title}}{{end -}}
+{{else if eq .msg.ToolName "review" -}}
+ 📝 {{if eq .input.action "diff"}}Reading diff{{else}}Reviewing{{end}} of {{if .input.pr}}#{{.input.pr}}{{else}}local changes{{end}}{{if .input.comments}} · {{len .input.comments}} comments{{end}}{{if .input.post}} · posting{{end -}}
+{{else if eq .msg.ToolName "scaffold" -}}
+ 🏗️  {{if eq .input.action "list"}}list templates{{else}}{{.input.template}} in {{.input.dir}}{{end -}}
+{{else if eq .msg.ToolName "undo_last_change" -}}
+ ↩️  Undo last file change
+{{else if eq .msg.ToolName "undo_all" -}}
+ ↩️  Undo all file changes
 {{else if eq .msg.ToolName "done" -}}
 {{/* nothing to show here, the agent will write more in its next message */}}
 {{else if eq .msg.ToolName "set-slug" -}}
@@ -111,11 +154,26 @@ type TermUI struct {
 	// from local and remove subproceses.
 	termLogCh chan string
 
+	// output is held while the terminal is handed to an editor, to hold back messages
+	output sync.Mutex
+
+	// panels are the tool calls shown in the terminal, running and finished
+	panels *toolPanels
+	// render highlights code and diffs
+	render *renderer
+	// width is the terminal's width, in columns
+	width atomic.Int32
+
 	// protects following
 	mu       sync.Mutex
 	oldState *term.State
 	// Tracks branches that were pushed during the session
 	pushedBranches map[string]struct{}
+	// The slash commands, by name
+	commands map[string]*Command
+
+	// files caches the repository's files, for completion
+	files repoFiles
 
 	// Pending message count, for graceful shutdown
 	messageWaitGroup sync.WaitGroup
@@ -131,8 +189,9 @@ type chatMessage struct {
 	thinking bool
 }
 
-func New(agent loop.CodingAgent, httpURL string) *TermUI {
-	return &TermUI{
+// New returns a terminal UI for agent, highlighting code with the chroma style named theme (see CheckTheme).
+func New(agent loop.CodingAgent, httpURL, theme string) *TermUI {
+	ui := &TermUI{
 		agent:          agent,
 		stdin:          os.Stdin,
 		stdout:         os.Stdout,
@@ -141,7 +200,12 @@ func New(agent loop.CodingAgent, httpURL string) *TermUI {
 		chatMsgCh:      make(chan chatMessage, 1),
 		termLogCh:      make(chan string, 1),
 		pushedBranches: make(map[string]struct{}),
+		panels:         newToolPanels(),
+		render:         newRenderer(theme),
+		commands:       make(map[string]*Command),
 	}
+	ui.registerCommands()
+	return ui
 }
 
 func (ui *TermUI) Run(ctx context.Context) error {
@@ -154,32 +218,76 @@ func (ui *TermUI) Run(ctx context.Context) error {
 		return err
 	}
 	go ui.receiveMessagesLoop(ctx)
-	if err := ui.inputLoop(ctx); err != nil {
+	go ui.receivePermissionRequests(ctx)
+	go ui.receiveToolEvents(ctx.Done())
+	// Reading input blocks without regard to ctx, so stop waiting for it once ctx is done.
+	errc := make(chan error, 1)
+	go func() { errc <- ui.inputLoop(ctx) }()
+	select {
+	case err := <-errc:
 		return err
+	case <-ctx.Done():
+		return nil
 	}
-	return nil
 }
 
+// HandleToolUse shows a finished tool call, collapsed to its summary; `expand` shows its full output.
 func (ui *TermUI) HandleToolUse(resp *loop.AgentMessage) {
-	inputData := map[string]any{}
-	if err := json.Unmarshal([]byte(resp.ToolInput), &inputData); err != nil {
-		ui.AppendSystemMessage("error: %v", err)
-		return
+	n := ui.panels.finished(resp.ToolCallId, resp.ToolResult)
+	summary := ui.describeToolUse(resp)
+	var elapsed string
+	if resp.Elapsed != nil {
+		elapsed = fmt.Sprintf("  (%s)", resp.Elapsed.Round(100*time.Millisecond))
 	}
-	buf := bytes.Buffer{}
-	if err := toolUseTmpl.Execute(&buf, map[string]any{"msg": resp, "input": inputData, "output": resp.ToolResult, "branch_prefix": ui.agent.BranchPrefix()}); err != nil {
-		ui.AppendSystemMessage("error: %v", err)
-		return
+	// Multi-line summaries, like a todo list, stay as they are; a one-line summary gets the call's details.
+	if !strings.Contains(strings.TrimSpace(summary), "\n") {
+		summary = strings.TrimSpace(summary)
+		if lines := strings.Count(strings.TrimRight(resp.ToolResult, "\n"), "\n") + 1; resp.ToolResult != "" && lines > 1 {
+			elapsed += fmt.Sprintf(" · %d lines, 'expand %d' to show", lines, n)
+		}
 	}
-	ui.AppendSystemMessage("%s\n", buf.String())
+	ui.AppendSystemMessage("#%d %s%s\n", n, summary, elapsed)
 
 	if resp.ToolName == "set-slug" {
+		inputData := map[string]any{}
+		json.Unmarshal([]byte(resp.ToolInput), &inputData)
 		if slug, ok := inputData["slug"].(string); ok {
 			ui.updateTitleWithSlug(slug)
 		}
 	}
 }
 
+// showUsage shows the session's token usage and cost so far.
+func (ui *TermUI) showUsage() {
+	totalUsage := ui.agent.TotalUsage()
+	ui.AppendSystemMessage("💰 Current usage summary:")
+	ui.AppendSystemMessage("- Input tokens: %s", humanize.Comma(int64(totalUsage.TotalInputTokens())))
+	ui.AppendSystemMessage("- Cached input tokens: %s", humanize.Comma(int64(totalUsage.CacheReadInputTokens)))
+	ui.AppendSystemMessage("- Output tokens: %s", humanize.Comma(int64(totalUsage.OutputTokens)))
+	ui.AppendSystemMessage("- Responses: %d", totalUsage.Responses)
+	ui.AppendSystemMessage("- Wall time: %s", totalUsage.WallTime().Round(time.Second))
+	ui.AppendSystemMessage("- Total cost: $%0.2f", totalUsage.TotalCostUSD)
+	if len(totalUsage.Models) > 1 {
+		for _, model := range slices.Sorted(maps.Keys(totalUsage.Models)) {
+			u := totalUsage.Models[model]
+			ui.AppendSystemMessage("  - %s: %s in, %s out, $%0.2f", model, humanize.Comma(int64(u.InputTokens+u.CacheReadInputTokens+u.CacheCreationInputTokens)), humanize.Comma(int64(u.OutputTokens)), u.CostUSD)
+		}
+	}
+}
+
+// describeToolUse summarizes a tool call for the terminal.
+func (ui *TermUI) describeToolUse(msg *loop.AgentMessage) string {
+	inputData := map[string]any{}
+	if err := json.Unmarshal([]byte(msg.ToolInput), &inputData); err != nil {
+		return fmt.Sprintf(" %s: %v", msg.ToolName, err)
+	}
+	buf := bytes.Buffer{}
+	if err := toolUseTmpl.Execute(&buf, map[string]any{"msg": msg, "input": inputData, "output": msg.ToolResult, "branch_prefix": ui.agent.BranchPrefix()}); err != nil {
+		return fmt.Sprintf(" %s: %v", msg.ToolName, err)
+	}
+	return buf.String()
+}
+
 func (ui *TermUI) receiveMessagesLoop(ctx context.Context) {
 	it := ui.agent.NewIterator(ctx, 0)
 	bold := color.New(color.Bold).SprintFunc()
@@ -262,13 +370,35 @@ Special commands:
 - usage, cost         : Show current token usage and cost
 - browser, open, b    : Open current conversation in browser
 - stop, cancel, abort : Cancel the current operation
+- interrupt <message> : Stop the current response and redirect sketch with message
+- changes             : Show file modifications awaiting approval
+- approve [path [n…]] : Write staged changes (all, one file, or hunks n… of a file)
+- reject [path [n…]]  : Discard staged changes (all, one file, or hunks n… of a file)
+- edit <path>         : Edit a staged file in $EDITOR, and write your version instead of sketch's
+- approve session     : Write staged changes, and later changes as they are made, for the rest of the session
+- expand <n>          : Show the full output of tool call #n
+- allow [id]          : Let a tool take the action it asked permission for (the oldest request, or request id)
+- deny [id]           : Refuse a tool's permission request (the oldest, or request id)
 - exit, quit, q       : Exit sketch
-- ! <command>         : Execute a shell command (e.g. !ls -la)`)
+- ! <command>         : Execute a shell command (e.g. !ls -la)
+- /<command>          : Run a slash command; /help lists them
+- @<path|glob|URL>    : Pin a file's or page's contents into the conversation
+
+Tab completes slash commands, and paths of files in the repository and files sketch changed.`)
 		case "budget":
 			originalBudget := ui.agent.OriginalBudget()
 			ui.AppendSystemMessage("💰 Budget summary:")
 
 			ui.AppendSystemMessage("- Max total cost: %0.2f", originalBudget.MaxDollars)
+			if originalBudget.MaxTokens > 0 {
+				ui.AppendSystemMessage("- Max tokens: %s", humanize.Comma(int64(originalBudget.MaxTokens)))
+			}
+			if originalBudget.MaxToolCalls > 0 {
+				ui.AppendSystemMessage("- Max tool calls: %d", originalBudget.MaxToolCalls)
+			}
+			if originalBudget.MaxWallTime > 0 {
+				ui.AppendSystemMessage("- Max wall time: %s", originalBudget.MaxWallTime)
+			}
 		case "browser", "open", "b":
 			if ui.httpURL != "" {
 				ui.AppendSystemMessage("🌐 Opening %s in browser", ui.httpURL)
@@ -277,13 +407,7 @@ Special commands:
 				ui.AppendSystemMessage("❌ No web URL available for this session")
 			}
 		case "usage", "cost":
-			totalUsage := ui.agent.TotalUsage()
-			ui.AppendSystemMessage("💰 Current usage summary:")
-			ui.AppendSystemMessage("- Input tokens: %s", humanize.Comma(int64(totalUsage.TotalInputTokens())))
-			ui.AppendSystemMessage("- Output tokens: %s", humanize.Comma(int64(totalUsage.OutputTokens)))
-			ui.AppendSystemMessage("- Responses: %d", totalUsage.Responses)
-			ui.AppendSystemMessage("- Wall time: %s", totalUsage.WallTime().Round(time.Second))
-			ui.AppendSystemMessage("- Total cost: $%0.2f", totalUsage.TotalCostUSD)
+			ui.showUsage()
 		case "bye", "exit", "q", "quit":
 			ui.trm.SetPrompt("")
 			// Display final usage stats
@@ -346,12 +470,30 @@ Special commands:
 			return nil
 		case "stop", "cancel", "abort":
 			ui.agent.CancelTurn(fmt.Errorf("user canceled the operation"))
+		case "changes":
+			ui.showPendingChanges()
 		case "panic":
 			panic("user forced a panic")
 		default:
 			if line == "" {
 				continue
 			}
+			if ui.runCommand(ctx, line) {
+				continue
+			}
+			if ui.handleExpandCommand(line) {
+				continue
+			}
+			if ui.handleChangeCommand(line) {
+				continue
+			}
+			if ui.handlePermissionCommand(line) {
+				continue
+			}
+			if message, ok := strings.CutPrefix(line, "interrupt "); ok && strings.TrimSpace(message) != "" {
+				ui.agent.InterruptTurn(ctx, strings.TrimSpace(message))
+				continue
+			}
 			if strings.HasPrefix(line, "!") {
 				// Execute as shell command
 				line = line[1:] // remove the '!' prefix
@@ -390,6 +532,205 @@ Special commands:
 	}
 }
 
+// showPendingChanges displays the file modifications awaiting approval.
+func (ui *TermUI) showPendingChanges() {
+	changes := ui.agent.PendingChanges()
+	if len(changes) == 0 {
+		ui.AppendSystemMessage("📝 No changes awaiting approval")
+		return
+	}
+	for _, c := range changes {
+		label := ""
+		if c.New {
+			label = " (new file)"
+		}
+		ui.AppendSystemMessage("📝 %s%s", c.Path, label)
+		for i, h := range c.Hunks {
+			ui.AppendSystemMessage("hunk %d:\n%s", i, ui.render.diff(h.Diff, int(ui.width.Load())))
+		}
+	}
+}
+
+// handleChangeCommand handles "approve", "reject", and "edit" commands for staged changes.
+// It reports whether line was such a command.
+// To avoid swallowing chat messages, only bare commands,
+// commands naming an absolute path, and "approve session" are recognized.
+func (ui *TermUI) handleChangeCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 2 && fields[0] == "approve" && fields[1] == "session" {
+		if err := ui.agent.AutoApproveChanges(); err != nil {
+			ui.AppendSystemMessage("❌ %v", err)
+			return true
+		}
+		ui.AppendSystemMessage("✅ Approved all changes for the rest of the session")
+		return true
+	}
+	if len(fields) == 2 && fields[0] == "edit" && filepath.IsAbs(fields[1]) {
+		ui.editChange(fields[1])
+		return true
+	}
+	if len(fields) == 0 || (fields[0] != "approve" && fields[0] != "reject") {
+		return false
+	}
+	var path string
+	var hunks []int
+	if len(fields) > 1 {
+		if !filepath.IsAbs(fields[1]) {
+			return false
+		}
+		path = fields[1]
+		for _, f := range fields[2:] {
+			n, err := strconv.Atoi(f)
+			if err != nil {
+				ui.AppendSystemMessage("❌ Invalid hunk number %q", f)
+				return true
+			}
+			hunks = append(hunks, n)
+		}
+	}
+	resolve, verb := ui.agent.ApproveChange, "Approved"
+	if fields[0] == "reject" {
+		resolve, verb = ui.agent.RejectChange, "Rejected"
+	}
+	if err := resolve(path, hunks); err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+		return true
+	}
+	target := cmp.Or(path, "all staged changes")
+	ui.AppendSystemMessage("✅ %s %s", verb, target)
+	if remaining := len(ui.agent.PendingChanges()); remaining > 0 {
+		ui.AppendSystemMessage("📝 %d file(s) still awaiting approval", remaining)
+	}
+	return true
+}
+
+// editChange opens the staged contents of path in the user's $EDITOR,
+// and writes the result to disk in place of the agent's change.
+func (ui *TermUI) editChange(path string) {
+	data, err := ui.agent.StagedContents(path)
+	if err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+		return
+	}
+	f, err := os.CreateTemp("", "sketch-edit-*"+filepath.Ext(path))
+	if err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err = cmp.Or(err, f.Close()); err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+		return
+	}
+
+	err = ui.runEditor(f.Name())
+	if err != nil {
+		ui.AppendSystemMessage("❌ Editor failed, change left staged: %v", err)
+		return
+	}
+	edited, err := os.ReadFile(f.Name())
+	if err == nil {
+		err = ui.agent.EditChange(path, edited)
+	}
+	if err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+		return
+	}
+	ui.AppendSystemMessage("✅ Wrote your edit of %s", path)
+}
+
+// runEditor runs $EDITOR (or vi) on file, with the terminal out of raw mode
+// and messages held back until it exits.
+func (ui *TermUI) runEditor(file string) error {
+	editor := cmp.Or(os.Getenv("VISUAL"), os.Getenv("EDITOR"), "vi")
+	ui.output.Lock()
+	defer ui.output.Unlock()
+	ui.mu.Lock()
+	oldState := ui.oldState
+	ui.mu.Unlock()
+	if err := term.Restore(int(ui.stdin.Fd()), oldState); err != nil {
+		return err
+	}
+	defer term.MakeRaw(int(ui.stdin.Fd()))
+	// The editor command may have arguments, as in EDITOR="code --wait".
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", file)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = ui.stdin, ui.stdout, ui.stderr
+	defer ui.panels.offScreen()
+	return cmd.Run()
+}
+
+// handleExpandCommand handles "expand <n>", which shows the full output of tool call n.
+// It reports whether line was such a command.
+func (ui *TermUI) handleExpandCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != "expand" {
+		return false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(fields[1], "#"))
+	if err != nil {
+		return false
+	}
+	result, ok := ui.panels.result(n)
+	if !ok {
+		ui.AppendSystemMessage("❌ No output for tool call #%d", n)
+		return true
+	}
+	if strings.TrimSpace(result) == "" {
+		result = "(no output)"
+	}
+	ui.AppendSystemMessage("#%d:\n%s", n, strings.TrimRight(result, "\n"))
+	return true
+}
+
+// receivePermissionRequests shows tools' permission requests as they are made.
+func (ui *TermUI) receivePermissionRequests(ctx context.Context) {
+	events, unsubscribe := ui.agent.SubscribeEvents()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.Type != loop.EventPermissionRequested || e.Permission == nil {
+				continue
+			}
+			ui.AppendSystemMessage("🔐 %s asks permission (request %s) to %s\nType 'allow' or 'deny'.", e.Permission.Tool, e.Permission.ID, e.Permission.Description)
+		}
+	}
+}
+
+// handlePermissionCommand handles "allow" and "deny" commands for tools' permission requests.
+// It reports whether line was such a command.
+// To avoid swallowing chat messages, only bare commands and commands naming a request ID are recognized.
+func (ui *TermUI) handlePermissionCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 || (fields[0] != "allow" && fields[0] != "deny") {
+		return false
+	}
+	var id string
+	if len(fields) == 2 {
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			return false
+		}
+		id = fields[1]
+	}
+	allow := fields[0] == "allow"
+	if err := ui.agent.AnswerPermission(id, allow); err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+		return true
+	}
+	if allow {
+		ui.AppendSystemMessage("✅ Allowed")
+	} else {
+		ui.AppendSystemMessage("🚫 Denied")
+	}
+	return true
+}
+
 func (ui *TermUI) updatePrompt(thinking bool) {
 	var t string
 	if thinking {
@@ -418,11 +759,13 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 	}
 	ui.oldState = oldState
 	ui.trm = term.NewTerminal(ui.stdin, "")
+	ui.trm.AutoCompleteCallback = ui.complete
 	width, height, err := term.GetSize(int(ui.stdin.Fd()))
 	if err != nil {
 		return fmt.Errorf("get terminal size: %v", err)
 	}
 	ui.trm.SetSize(width, height)
+	ui.width.Store(int32(width))
 	// Handle terminal resizes...
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGWINCH)
@@ -436,6 +779,7 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 			if newWidth != width || newHeight != height {
 				width, height = newWidth, newHeight
 				ui.trm.SetSize(width, height)
+				ui.width.Store(int32(width))
 			}
 		}
 	}()
@@ -444,9 +788,12 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 	ui.pushTerminalTitle()
 	ui.setTerminalTitle("sketch")
 
-	// This is the only place where we should call fe.trm.Write:
+	// This is the only place where we should call fe.trm.Write.
+	// Every write redraws the running tool calls' panels below what it writes, just above the prompt.
 	go func() {
 		var lastMsg *chatMessage
+		refresh := time.NewTicker(panelRefresh)
+		defer refresh.Stop()
 		for {
 			select {
 			case <-ctx.Done():
@@ -454,6 +801,8 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 			case msg := <-ui.chatMsgCh:
 				func() {
 					defer ui.messageWaitGroup.Done()
+					ui.output.Lock()
+					defer ui.output.Unlock()
 					// Update prompt before writing, because otherwise it doesn't redraw the prompt.
 					ui.updatePrompt(msg.thinking)
 					lastMsg = &msg
@@ -462,20 +811,29 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 					if strings.TrimSpace(msg.content) == "" {
 						return
 					}
-					s := fmt.Sprintf("%s %s\n", msg.sender, msg.content)
-					ui.trm.Write([]byte(s))
+					s := fmt.Sprintf("%s %s\n", msg.sender, ui.render.message(msg.content, int(ui.width.Load())))
+					ui.trm.Write([]byte(ui.panels.redraw(s, int(ui.width.Load()))))
 				}()
 			case logLine := <-ui.termLogCh:
 				func() {
 					defer ui.messageWaitGroup.Done()
+					ui.output.Lock()
+					defer ui.output.Unlock()
 					if lastMsg != nil {
 						ui.updatePrompt(lastMsg.thinking)
 					} else {
 						ui.updatePrompt(false)
 					}
-					b := []byte(logLine + "\n")
+					b := []byte(ui.panels.redraw(logLine+"\n", int(ui.width.Load())))
 					ui.trm.Write(b)
 				}()
+			case <-refresh.C:
+				if !ui.panels.hasRunning() {
+					continue
+				}
+				ui.output.Lock()
+				ui.trm.Write([]byte(ui.panels.redraw("", int(ui.width.Load()))))
+				ui.output.Unlock()
 			}
 		}
 	}()
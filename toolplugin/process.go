@@ -0,0 +1,254 @@
+package toolplugin
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/llm"
+)
+
+// describeTimeout limits how long a process plugin has to describe its tools.
+const describeTimeout = 10 * time.Second
+
+// A process is a running process plugin.
+type process struct {
+	path  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu      sync.Mutex // guards writes to stdin and the fields below
+	nextID  int64
+	pending map[int64]chan rpcResponse
+	exited  error // non-nil once the process's stdout is closed
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// startProcess starts the process plugin at path in workingDir.
+func startProcess(ctx context.Context, path, workingDir string) (*process, error) {
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = workingDir
+	cmd.Stderr = &logWriter{ctx: ctx, path: path}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &process{path: path, cmd: cmd, stdin: stdin, pending: make(map[int64]chan rpcResponse)}
+	go p.read(stdout)
+	return p, nil
+}
+
+// read delivers the responses on stdout to the calls waiting for them, until stdout is closed.
+// Responses that no call is waiting for, because it gave up, are dropped.
+func (p *process) read(stdout io.Reader) {
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var resp rpcResponse
+		if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+			slog.Warn("plugin_bad_response", "path", p.path, "err", err)
+			continue
+		}
+		p.mu.Lock()
+		ch := p.pending[resp.ID]
+		delete(p.pending, resp.ID)
+		p.mu.Unlock()
+		if ch != nil {
+			ch <- resp
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exited = cmp.Or(sc.Err(), errors.New("plugin exited"))
+	for id, ch := range p.pending {
+		close(ch)
+		delete(p.pending, id)
+	}
+}
+
+// call calls method with params and unmarshals the result into result.
+func (p *process) call(ctx context.Context, method string, params, result any) error {
+	ch := make(chan rpcResponse, 1)
+	p.mu.Lock()
+	if p.exited != nil {
+		p.mu.Unlock()
+		return p.exited
+	}
+	p.nextID++
+	id := p.nextID
+	req, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err == nil {
+		p.pending[id] = ch
+		_, err = p.stdin.Write(append(req, '\n'))
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return context.Cause(ctx)
+	case resp, ok := <-ch:
+		if !ok {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			return p.exited
+		}
+		if resp.Error != nil {
+			return errors.New(resp.Error.Message)
+		}
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("bad %s result: %w", method, err)
+		}
+		return nil
+	}
+}
+
+// describe describes the plugin's tools and commands.
+func (p *process) describe(ctx context.Context) ([]*llm.Tool, []*Command, error) {
+	ctx, cancel := context.WithTimeout(ctx, describeTimeout)
+	defer cancel()
+	var desc struct {
+		Tools []struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			InputSchema json.RawMessage `json:"input_schema"`
+		} `json:"tools"`
+		Commands []struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		} `json:"commands"`
+	}
+	if err := p.call(ctx, "describe", nil, &desc); err != nil {
+		return nil, nil, fmt.Errorf("describe: %w", err)
+	}
+	var tools []*llm.Tool
+	for _, t := range desc.Tools {
+		schema := t.InputSchema
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type": "object"}`)
+		}
+		tools = append(tools, &llm.Tool{
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: schema,
+			Run:         p.runTool(t.Name),
+		})
+	}
+	var cmds []*Command
+	for _, c := range desc.Commands {
+		cmds = append(cmds, &Command{Name: c.Name, Description: c.Description, Run: p.runCommand(c.Name)})
+	}
+	return tools, cmds, nil
+}
+
+// runCommand returns a Run function that runs the named command in the plugin.
+func (p *process) runCommand(name string) func(ctx context.Context, args string) (string, error) {
+	return func(ctx context.Context, args string) (string, error) {
+		params := map[string]any{
+			"command":     name,
+			"args":        args,
+			"working_dir": p.cmd.Dir,
+		}
+		var result struct {
+			Output string `json:"output"`
+		}
+		if err := p.call(ctx, "command", params, &result); err != nil {
+			return "", err
+		}
+		return result.Output, nil
+	}
+}
+
+// runTool returns a Run function that runs the named tool in the plugin.
+func (p *process) runTool(name string) func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
+	return func(ctx context.Context, input json.RawMessage) ([]llm.Content, error) {
+		params := map[string]any{
+			"tool":        name,
+			"input":       input,
+			"working_dir": claudetool.WorkingDir(ctx),
+		}
+		var result struct {
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		}
+		if err := p.call(ctx, "run", params, &result); err != nil {
+			return nil, err
+		}
+		var contents []llm.Content
+		for _, c := range result.Content {
+			if c.Type != "text" {
+				return nil, fmt.Errorf("plugin returned unsupported %q content", c.Type)
+			}
+			contents = append(contents, llm.StringContent(c.Text))
+		}
+		if len(contents) == 0 {
+			return llm.TextContent("(no output)"), nil
+		}
+		return contents, nil
+	}
+}
+
+// close stops the process.
+func (p *process) close() error {
+	p.stdin.Close()
+	done := make(chan error, 1)
+	go func() { done <- p.cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-done
+	}
+	return nil
+}
+
+// logWriter logs a plugin's stderr.
+type logWriter struct {
+	ctx  context.Context
+	path string
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	slog.InfoContext(w.ctx, "plugin_stderr", "path", w.path, "output", string(b))
+	return len(b), nil
+}
@@ -0,0 +1,211 @@
+// Package toolplugin loads third-party tools at runtime, so that users can give the agent
+// their own tools without recompiling sketch.
+//
+// Plugins live in a directory, by default ~/.config/sketch/plugins. Each file in it is one plugin:
+//
+//   - A file ending in .so is a Go plugin, built with -buildmode=plugin against the same
+//     version of sketch, that exports
+//
+//     func SketchTools() []*llm.Tool
+//
+//     and, optionally, slash commands for the terminal UI:
+//
+//     func SketchCommands() []*toolplugin.Command
+//
+//     Go plugins need a cgo-enabled build of sketch, which the container does not have.
+//
+//   - Any other executable file is a process plugin. sketch starts it in the working directory
+//     and talks JSON-RPC 2.0 with it, one JSON object per line, over its stdin and stdout.
+//     Its stderr goes to sketch's log. sketch calls two methods:
+//
+//     describe, with no params, returns {"tools": [{"name", "description", "input_schema"}]},
+//     and optionally "commands": [{"name", "description"}], slash commands for the terminal UI.
+//
+//     run, with params {"tool", "input", "working_dir"}, runs a tool and returns
+//     {"content": [{"type": "text", "text"}]}. A JSON-RPC error is the tool's error,
+//     which the model sees.
+//
+//     command, with params {"command", "args", "working_dir"}, runs a slash command
+//     and returns {"output"}, which the user sees.
+//
+// Other files, and files whose names start with ".", are ignored.
+package toolplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"plugin"
+	"regexp"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// DefaultDir returns the default plugin directory, in the user's config directory.
+func DefaultDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sketch", "plugins"), nil
+}
+
+// A Set is the tools and commands loaded from a plugin directory.
+type Set struct {
+	Tools    []*llm.Tool
+	Commands []*Command
+	procs    []*process
+}
+
+// A Command is a slash command that a plugin adds to the terminal UI.
+type Command struct {
+	// Name is the command's name, without the slash.
+	Name        string
+	Description string
+	// Run runs the command with the rest of the user's line, and returns what to show the user.
+	Run func(ctx context.Context, args string) (string, error)
+}
+
+// validToolName matches the tool names that LLM APIs accept.
+var validToolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// validCommandName matches the names of slash commands, which are typed in the terminal.
+var validCommandName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Load loads the plugins in dir, starting process plugins in workingDir.
+// A missing directory has no plugins.
+// Plugins that fail to load are skipped; Load reports their errors along with the tools of the rest.
+// The process plugins run until ctx is done or the Set is closed.
+func Load(ctx context.Context, dir, workingDir string) (*Set, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Set{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+	s := &Set{}
+	names := make(map[string]string)    // tool name -> plugin path
+	commands := make(map[string]string) // command name -> plugin path
+	var errs []error
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := os.Stat(path) // follow symlinks
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		var tools []*llm.Tool
+		var cmds []*Command
+		switch {
+		case strings.HasSuffix(path, ".so"):
+			tools, cmds, err = loadGoPlugin(path)
+		case info.Mode().Perm()&0o111 != 0:
+			var p *process
+			p, err = startProcess(ctx, path, workingDir)
+			if err == nil {
+				s.procs = append(s.procs, p)
+				tools, cmds, err = p.describe(ctx)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s: %w", path, err))
+			continue
+		}
+		for _, tool := range tools {
+			if err := checkTool(tool); err != nil {
+				errs = append(errs, fmt.Errorf("plugin %s: %w", path, err))
+				continue
+			}
+			if other, ok := names[tool.Name]; ok {
+				errs = append(errs, fmt.Errorf("plugin %s: tool %s is already provided by %s", path, tool.Name, other))
+				continue
+			}
+			names[tool.Name] = path
+			s.Tools = append(s.Tools, tool)
+		}
+		for _, c := range cmds {
+			if err := checkCommand(c); err != nil {
+				errs = append(errs, fmt.Errorf("plugin %s: %w", path, err))
+				continue
+			}
+			if other, ok := commands[c.Name]; ok {
+				errs = append(errs, fmt.Errorf("plugin %s: command /%s is already provided by %s", path, c.Name, other))
+				continue
+			}
+			commands[c.Name] = path
+			s.Commands = append(s.Commands, c)
+		}
+		slog.InfoContext(ctx, "plugin_loaded", "path", path, "tools", len(tools), "commands", len(cmds))
+	}
+	return s, errors.Join(errs...)
+}
+
+// Close stops the process plugins.
+func (s *Set) Close() error {
+	var errs []error
+	for _, p := range s.procs {
+		errs = append(errs, p.close())
+	}
+	return errors.Join(errs...)
+}
+
+// checkTool reports whether tool can be offered to the model.
+func checkTool(tool *llm.Tool) error {
+	if tool == nil || tool.Run == nil {
+		return fmt.Errorf("tool %v has no Run function", tool)
+	}
+	if !validToolName.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name %q", tool.Name)
+	}
+	if !json.Valid(tool.InputSchema) {
+		return fmt.Errorf("tool %s has an invalid input schema", tool.Name)
+	}
+	return nil
+}
+
+// checkCommand reports whether c can be offered to the user.
+func checkCommand(c *Command) error {
+	if c == nil || c.Run == nil {
+		return fmt.Errorf("command %v has no Run function", c)
+	}
+	if !validCommandName.MatchString(c.Name) {
+		return fmt.Errorf("invalid command name %q", c.Name)
+	}
+	return nil
+}
+
+// loadGoPlugin loads the tools and commands from the Go plugin at path.
+func loadGoPlugin(path string) ([]*llm.Tool, []*Command, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sym, err := p.Lookup("SketchTools")
+	if err != nil {
+		return nil, nil, err
+	}
+	tools, ok := sym.(func() []*llm.Tool)
+	if !ok {
+		return nil, nil, fmt.Errorf("SketchTools is a %T, not a func() []*llm.Tool", sym)
+	}
+	var cmds []*Command
+	if sym, err := p.Lookup("SketchCommands"); err == nil {
+		commands, ok := sym.(func() []*Command)
+		if !ok {
+			return nil, nil, fmt.Errorf("SketchCommands is a %T, not a func() []*toolplugin.Command", sym)
+		}
+		cmds = commands()
+	}
+	return tools(), cmds, nil
+}
@@ -0,0 +1,138 @@
+package toolplugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/llm"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("TOOLPLUGIN_TEST_PLUGIN") != "" {
+		servePlugin()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// servePlugin makes the test binary a process plugin with an echo tool, a tool that always fails,
+// and a greet command.
+func servePlugin() {
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		var req struct {
+			ID     int64  `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				Tool       string          `json:"tool"`
+				Input      json.RawMessage `json:"input"`
+				Command    string          `json:"command"`
+				Args       string          `json:"args"`
+				WorkingDir string          `json:"working_dir"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+		switch {
+		case req.Method == "describe":
+			resp["result"] = map[string]any{"tools": []map[string]any{
+				{"name": "echo", "description": "Echoes its input.", "input_schema": map[string]any{"type": "object"}},
+				{"name": "fail", "description": "Always fails."},
+				{"name": "bad name", "description": "Is never offered."},
+			}, "commands": []map[string]any{
+				{"name": "greet", "description": "Says hello."},
+			}}
+		case req.Method == "run" && req.Params.Tool == "echo":
+			text := fmt.Sprintf("%s in %s", req.Params.Input, req.Params.WorkingDir)
+			resp["result"] = map[string]any{"content": []map[string]any{{"type": "text", "text": text}}}
+		case req.Method == "command" && req.Params.Command == "greet":
+			resp["result"] = map[string]any{"output": "hello, " + req.Params.Args}
+		default:
+			resp["error"] = map[string]any{"code": 1, "message": "no luck"}
+		}
+		b, _ := json.Marshal(resp)
+		fmt.Printf("%s\n", b)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOOLPLUGIN_TEST_PLUGIN", "1")
+	dir := t.TempDir()
+	if err := os.Symlink(exe, filepath.Join(dir, "test-plugin")); err != nil {
+		t.Fatal(err)
+	}
+	// Neither of these is a plugin.
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".hidden"), []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := Load(ctx, dir, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), `invalid tool name "bad name"`) {
+		t.Errorf("Load error = %v, want invalid tool name", err)
+	}
+	defer set.Close()
+	var names []string
+	tools := make(map[string]*llm.Tool)
+	for _, tool := range set.Tools {
+		names = append(names, tool.Name)
+		tools[tool.Name] = tool
+	}
+	if got := strings.Join(names, ","); got != "echo,fail" {
+		t.Fatalf("tools = %s, want echo,fail", got)
+	}
+	if got := string(tools["fail"].InputSchema); got != `{"type": "object"}` {
+		t.Errorf("default input schema = %s", got)
+	}
+
+	toolCtx := claudetool.WithWorkingDir(ctx, "/work")
+	out, err := tools["echo"].Run(toolCtx, json.RawMessage(`{"x":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0].Text != `{"x":1} in /work` {
+		t.Errorf("echo = %+v", out)
+	}
+	if _, err := tools["fail"].Run(toolCtx, json.RawMessage(`{}`)); err == nil || err.Error() != "no luck" {
+		t.Errorf("fail error = %v, want no luck", err)
+	}
+
+	if len(set.Commands) != 1 || set.Commands[0].Name != "greet" {
+		t.Fatalf("commands = %+v, want greet", set.Commands)
+	}
+	if got, err := set.Commands[0].Run(ctx, "world"); err != nil || got != "hello, world" {
+		t.Errorf("greet = %q, %v; want hello, world", got, err)
+	}
+
+	// After the plugin is stopped, its tools fail rather than hang.
+	if err := set.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tools["echo"].Run(toolCtx, json.RawMessage(`{}`)); err == nil {
+		t.Error("echo succeeded after Close")
+	}
+}
+
+func TestLoadMissingDir(t *testing.T) {
+	set, err := Load(context.Background(), filepath.Join(t.TempDir(), "nope"), "")
+	if err != nil || len(set.Tools) != 0 {
+		t.Errorf("Load(missing) = %v, %v; want no tools", set.Tools, err)
+	}
+}
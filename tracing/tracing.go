@@ -0,0 +1,66 @@
+// Package tracing exports OpenTelemetry traces of sessions over OTLP,
+// so that operators can find slow sessions and cost hot spots in their own observability stack.
+//
+// Sketch's packages record spans with the global tracer provider: turns (loop),
+// LLM requests and tool calls (llm/conversation), and bash commands (claudetool).
+// Until Setup installs an exporter, spans are not recorded.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Endpoint returns the OTLP/HTTP endpoint to export traces to: flag, if set,
+// or else the endpoint set by the standard OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT variables.
+// It returns "" if traces are not to be exported.
+func Endpoint(flag string) string {
+	for _, v := range []string{flag, os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"), os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")} {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Setup exports the spans of this process to endpoint, an OTLP/HTTP URL such as http://localhost:4318,
+// as the service "sketch" at version. Other settings, such as headers, come from the standard OTEL_ variables.
+// If endpoint is empty, Setup does nothing.
+// The returned function flushes buffered spans and stops exporting; call it before exiting.
+func Setup(ctx context.Context, endpoint, version string) (shutdown func(context.Context) error, err error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter for %s: %w", endpoint, err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "sketch"),
+			attribute.String("service.version", version),
+		),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if got := Endpoint(""); got != "http://collector:4318" {
+		t.Errorf("Endpoint from the environment = %q", got)
+	}
+	if got := Endpoint("http://localhost:4318"); got != "http://localhost:4318" {
+		t.Errorf("Endpoint from the flag = %q", got)
+	}
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if got := Endpoint(""); got != "" {
+		t.Errorf("Endpoint without configuration = %q, want none", got)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	var exports atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/traces" {
+			exports.Add(1)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	shutdown, err := Setup(ctx, srv.URL, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := otel.Tracer("test").Start(ctx, "turn")
+	span.End()
+	if err := shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exports.Load() == 0 {
+		t.Error("no spans were exported")
+	}
+
+	// Without an endpoint, Setup does nothing.
+	shutdown, err = Setup(ctx, "", "test")
+	if err != nil || shutdown(ctx) != nil {
+		t.Errorf("Setup without an endpoint failed: %v", err)
+	}
+}
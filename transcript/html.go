@@ -0,0 +1,116 @@
+package transcript
+
+import (
+	"html/template"
+	"io"
+	"strings"
+
+	"sketch.dev/loop"
+)
+
+// WriteHTML writes s to w as a standalone HTML page, with no external resources.
+// Tool calls are collapsed in <details> elements.
+func WriteHTML(w io.Writer, s *Session) error {
+	return htmlTemplate.Execute(w, s)
+}
+
+var htmlTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"heading":     heading,
+	"toolSummary": toolSummary,
+	"prettyInput": prettyInput,
+	"usage":       usageSummary,
+	"diffLines":   diffLines,
+	"trim":        strings.TrimSpace,
+	"short": func(hash string) string {
+		return hash[:min(len(hash), 8)]
+	},
+	"isTool":   func(m loop.AgentMessage) bool { return m.Type == loop.ToolUseMessageType },
+	"isCommit": func(m loop.AgentMessage) bool { return m.Type == loop.CommitMessageType },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sketch session{{with .WorkingDir}} in {{.}}{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 60rem; margin: 2rem auto; padding: 0 1rem; color: #222; line-height: 1.5; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 0.25rem 1rem; color: #555; }
+dt { font-weight: 600; }
+dd { margin: 0; }
+.message { margin: 1rem 0; padding: 0.75rem 1rem; border-radius: 6px; background: #f6f8fa; }
+.message h2 { font-size: 0.9rem; margin: 0 0 0.5rem; color: #555; }
+.message.user { background: #e8f0fe; }
+.message.error { background: #fdecea; }
+.text { white-space: pre-wrap; }
+details { margin: 0.5rem 0; border: 1px solid #ddd; border-radius: 6px; padding: 0.25rem 0.75rem; }
+details.failed { border-color: #e0a0a0; }
+summary { cursor: pointer; font-family: ui-monospace, monospace; font-size: 0.85rem; }
+pre { background: #fff; border: 1px solid #eee; padding: 0.5rem; overflow-x: auto; font-size: 0.8rem; }
+.diff .add { color: #116329; background: #dafbe1; }
+.diff .del { color: #82071e; background: #ffebe9; }
+.diff .hunk { color: #0550ae; }
+</style>
+</head>
+<body>
+<h1>Sketch session</h1>
+<dl>
+{{with .WorkingDir}}<dt>Working directory</dt><dd><code>{{.}}</code></dd>{{end}}
+{{with .Hostname}}<dt>Host</dt><dd>{{.}}</dd>{{end}}
+{{with .DownloadTime}}<dt>Exported</dt><dd>{{.}}</dd>{{end}}
+<dt>Messages</dt><dd>{{.MessageCount}}</dd>
+<dt>Usage</dt><dd>{{usage .TotalUsage}}</dd>
+</dl>
+{{range .Messages}}{{if not .HideOutput}}
+{{- if isTool .}}
+<details{{if .ToolError}} class="failed"{{end}}>
+<summary>{{toolSummary .}}{{if .ToolError}} (failed){{end}}</summary>
+<p>Input:</p>
+<pre>{{prettyInput .}}</pre>
+<p>Output:</p>
+<pre>{{.Content}}</pre>
+</details>
+{{- else if isCommit .}}
+<div class="message commit">
+<h2>{{heading .Type}}</h2>
+<ul>{{range .Commits}}<li><code>{{short .Hash}}</code> {{.Subject}}{{with .PushedBranch}} (pushed to <code>{{.}}</code>){{end}}</li>{{end}}</ul>
+</div>
+{{- else if trim .Content}}
+<div class="message {{.Type}}">
+<h2>{{heading .Type}}</h2>
+<div class="text">{{trim .Content}}</div>
+</div>
+{{- end}}
+{{end}}{{end}}
+{{with .Diff}}
+<h2>Changes</h2>
+<pre class="diff">{{range diffLines .}}<span{{with .Class}} class="{{.}}"{{end}}>{{.Text}}</span>
+{{end}}</pre>
+{{end}}
+</body>
+</html>
+`))
+
+// A diffLine is a line of a unified diff and the CSS class it is shown with.
+type diffLine struct {
+	Class string
+	Text  string
+}
+
+func diffLines(diff string) []diffLine {
+	var lines []diffLine
+	for line := range strings.Lines(diff) {
+		line = strings.TrimSuffix(line, "\n")
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			class = "add"
+		case strings.HasPrefix(line, "-"):
+			class = "del"
+		case strings.HasPrefix(line, "@@"):
+			class = "hunk"
+		}
+		lines = append(lines, diffLine{Class: class, Text: line})
+	}
+	return lines
+}
@@ -0,0 +1,80 @@
+package transcript
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"sketch.dev/loop"
+)
+
+// WriteMarkdown writes s to w as Markdown.
+// Tool calls are collapsed in <details> elements, which GitHub and most Markdown viewers support.
+func WriteMarkdown(w io.Writer, s *Session) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Sketch session\n\n")
+	if s.WorkingDir != "" {
+		fmt.Fprintf(bw, "- Working directory: `%s`\n", s.WorkingDir)
+	}
+	if s.Hostname != "" {
+		fmt.Fprintf(bw, "- Host: %s\n", s.Hostname)
+	}
+	if s.DownloadTime != "" {
+		fmt.Fprintf(bw, "- Exported: %s\n", s.DownloadTime)
+	}
+	fmt.Fprintf(bw, "- Messages: %d\n", s.MessageCount)
+	fmt.Fprintf(bw, "- Usage: %s\n", usageSummary(s.TotalUsage))
+
+	for _, m := range s.visible() {
+		switch m.Type {
+		case loop.ToolUseMessageType:
+			status := ""
+			if m.ToolError {
+				status = " (failed)"
+			}
+			fmt.Fprintf(bw, "\n<details>\n<summary><code>%s</code>%s</summary>\n\n", html.EscapeString(toolSummary(m)), status)
+			fmt.Fprintf(bw, "Input:\n\n%s\nOutput:\n\n%s</details>\n", fenced(prettyInput(m), "json"), fenced(m.Content, ""))
+		case loop.CommitMessageType:
+			fmt.Fprintf(bw, "\n## %s\n\n", heading(m.Type))
+			for _, c := range m.Commits {
+				fmt.Fprintf(bw, "- `%.8s` %s", c.Hash, c.Subject)
+				if c.PushedBranch != "" {
+					fmt.Fprintf(bw, " (pushed to `%s`)", c.PushedBranch)
+				}
+				fmt.Fprintf(bw, "\n")
+			}
+		default:
+			if strings.TrimSpace(m.Content) == "" {
+				continue // e.g. a response that only calls tools
+			}
+			fmt.Fprintf(bw, "\n## %s\n\n%s\n", heading(m.Type), strings.TrimSpace(m.Content))
+		}
+	}
+
+	if s.Diff != "" {
+		fmt.Fprintf(bw, "\n## Changes\n\n%s", fenced(s.Diff, "diff"))
+	}
+	return bw.Flush()
+}
+
+// fenced returns text as a fenced code block in language lang.
+// The fence is longer than any run of backticks in text, so that text cannot end the block.
+func fenced(text, lang string) string {
+	n := 3
+	run := 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			n = max(n, run+1)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", n)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return fence + lang + "\n" + text + fence + "\n"
+}
@@ -0,0 +1,210 @@
+// Package transcript exports sketch sessions as shareable documents:
+// Markdown, standalone HTML, and JSON.
+//
+// The JSON form is the one the web UI's /download endpoint serves,
+// so a downloaded session can be rendered again later with `sketch export`,
+// as can a session's log (see FromLog).
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"sketch.dev/llm/conversation"
+	"sketch.dev/loop"
+	"sketch.dev/sessionlog"
+)
+
+// A Session is everything there is to export about a session.
+type Session struct {
+	Messages     []loop.AgentMessage          `json:"messages"`
+	MessageCount int                          `json:"message_count"`
+	TotalUsage   conversation.CumulativeUsage `json:"total_usage"`
+	Hostname     string                       `json:"hostname"`
+	WorkingDir   string                       `json:"working_dir"`
+	DownloadTime string                       `json:"download_time"`
+	// Diff is a unified diff of the session's changes to the repository, if known.
+	Diff string `json:"diff,omitempty"`
+}
+
+// New returns the Session of agent, as of now.
+func New(agent loop.CodingAgent, hostname, workingDir string) *Session {
+	count := agent.MessageCount()
+	s := &Session{
+		Messages:     agent.Messages(0, count),
+		MessageCount: count,
+		TotalUsage:   agent.TotalUsage(),
+		Hostname:     hostname,
+		WorkingDir:   workingDir,
+		DownloadTime: time.Now().Format(time.RFC3339),
+	}
+	if diff, err := agent.Diff(nil); err == nil {
+		s.Diff = diff
+	}
+	return s
+}
+
+// A Format is a kind of document a Session can be exported as.
+type Format string
+
+const (
+	Markdown Format = "md"
+	HTML     Format = "html"
+	JSON     Format = "json"
+)
+
+// ParseFormat returns the Format named by s, which is a format or a file extension with or without its dot.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "md", "markdown":
+		return Markdown, nil
+	case "html", "htm":
+		return HTML, nil
+	case "json":
+		return JSON, nil
+	}
+	return "", fmt.Errorf("unknown export format %q; want md, html, or json", s)
+}
+
+// ContentType returns the MIME type of documents in format f.
+func (f Format) ContentType() string {
+	switch f {
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	case HTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// Write writes s to w as a document in format f.
+func Write(w io.Writer, s *Session, f Format) error {
+	switch f {
+	case Markdown:
+		return WriteMarkdown(w, s)
+	case HTML:
+		return WriteHTML(w, s)
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	return fmt.Errorf("unknown export format %q", f)
+}
+
+// FromLog returns the Session recorded in the session log in dir; see package sessionlog.
+// Its usage is as of the end of the last turn.
+func FromLog(dir string) (*Session, error) {
+	records, err := sessionlog.Read(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{DownloadTime: time.Now().Format(time.RFC3339)}
+	for _, r := range records {
+		if r.Kind != sessionlog.KindMessage {
+			continue
+		}
+		var m loop.AgentMessage
+		if err := json.Unmarshal(r.Message, &m); err != nil {
+			return nil, fmt.Errorf("failed to read message from session log: %w", err)
+		}
+		s.Messages = append(s.Messages, m)
+	}
+	s.MessageCount = len(s.Messages)
+	if data, err := sessionlog.ReadSnapshot(dir); err == nil {
+		var snap struct {
+			Usage conversation.CumulativeUsage `json:"usage"`
+		}
+		if json.Unmarshal(data, &snap) == nil {
+			s.TotalUsage = snap.Usage
+		}
+	}
+	return s, nil
+}
+
+// Read reads a Session in JSON format.
+func Read(r io.Reader) (*Session, error) {
+	var s Session
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+	return &s, nil
+}
+
+// visible returns the messages of s that the web UI shows.
+func (s *Session) visible() []loop.AgentMessage {
+	var msgs []loop.AgentMessage
+	for _, m := range s.Messages {
+		if !m.HideOutput {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// toolSummary returns a one-line description of the tool call in m.
+func toolSummary(m loop.AgentMessage) string {
+	var input struct {
+		Command string `json:"command"`
+		Path    string `json:"path"`
+		URL     string `json:"url"`
+	}
+	json.Unmarshal([]byte(m.ToolInput), &input)
+	detail := input.Command
+	if detail == "" {
+		detail = input.Path
+	}
+	if detail == "" {
+		detail = input.URL
+	}
+	detail, _, cut := strings.Cut(detail, "\n")
+	if cut {
+		detail += " …"
+	}
+	if detail == "" {
+		return m.ToolName
+	}
+	return m.ToolName + ": " + detail
+}
+
+// prettyInput returns the tool input of m, indented if it is JSON.
+func prettyInput(m loop.AgentMessage) string {
+	var v any
+	if err := json.Unmarshal([]byte(m.ToolInput), &v); err != nil {
+		return m.ToolInput
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return m.ToolInput
+	}
+	return string(b)
+}
+
+// usageSummary describes the tokens and money a session spent.
+func usageSummary(u conversation.CumulativeUsage) string {
+	return fmt.Sprintf("%d input tokens (%d cache reads, %d cache writes), %d output tokens, $%.2f",
+		u.InputTokens, u.CacheReadInputTokens, u.CacheCreationInputTokens, u.OutputTokens, u.TotalCostUSD)
+}
+
+// heading returns how a message of type t is headed in an exported transcript.
+func heading(t loop.CodingAgentMessageType) string {
+	switch t {
+	case loop.UserMessageType:
+		return "User"
+	case loop.AgentMessageType:
+		return "Agent"
+	case loop.ErrorMessageType:
+		return "Error"
+	case loop.BudgetMessageType:
+		return "Budget"
+	case loop.CommitMessageType:
+		return "Commits"
+	case loop.AutoMessageType:
+		return "Sketch"
+	}
+	return string(t)
+}
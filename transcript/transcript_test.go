@@ -0,0 +1,126 @@
+package transcript
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm/conversation"
+	"sketch.dev/loop"
+	"sketch.dev/sessionlog"
+)
+
+func testSession() *Session {
+	return &Session{
+		Messages: []loop.AgentMessage{
+			{Type: loop.UserMessageType, Content: "Fix the <flaky> test"},
+			{Type: loop.AgentMessageType, Content: "Running it first."},
+			{Type: loop.ToolUseMessageType, ToolName: "bash", ToolInput: `{"command":"go test ./..."}`, Content: "FAIL\n```oops```", ToolError: true},
+			{Type: loop.AgentMessageType, Content: "hidden subconversation", HideOutput: true},
+			{Type: loop.CommitMessageType, Commits: []*loop.GitCommit{{Hash: "0123456789abcdef", Subject: "Fix flake", PushedBranch: "sketch/fix"}}},
+		},
+		MessageCount: 5,
+		TotalUsage:   conversation.CumulativeUsage{InputTokens: 10, OutputTokens: 20, TotalCostUSD: 1.5},
+		WorkingDir:   "/app",
+		Diff:         "--- a/x\n+++ b/x\n@@ -1 +1 @@\n-old\n+new\n",
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMarkdown(&buf, testSession()); err != nil {
+		t.Fatal(err)
+	}
+	md := buf.String()
+	for _, want := range []string{
+		"- Working directory: `/app`\n",
+		"- Usage: 10 input tokens (0 cache reads, 0 cache writes), 20 output tokens, $1.50\n",
+		"## User\n\nFix the <flaky> test\n",
+		"<summary><code>bash: go test ./...</code> (failed)</summary>",
+		"````\nFAIL\n```oops```\n````\n", // the fence outgrows the output's backticks
+		"- `01234567` Fix flake (pushed to `sketch/fix`)\n",
+		"```diff\n--- a/x\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown lacks %q:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "hidden") {
+		t.Errorf("Markdown includes hidden output:\n%s", md)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, testSession()); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		"Fix the &lt;flaky&gt; test",
+		`<details class="failed">`,
+		`<span class="add">&#43;new</span>`,
+		"<code>01234567</code> Fix flake",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML lacks %q:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "hidden") {
+		t.Errorf("HTML includes hidden output:\n%s", page)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testSession(), JSON); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Messages) != 5 || s.Diff == "" || s.TotalUsage.OutputTokens != 20 {
+		t.Errorf("read back %+v", s)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]Format{"md": Markdown, ".markdown": Markdown, "HTML": HTML, ".json": JSON} {
+		if got, err := ParseFormat(in); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Error("ParseFormat(pdf) succeeded")
+	}
+}
+
+func TestFromLog(t *testing.T) {
+	dir := t.TempDir()
+	l, err := sessionlog.Open(dir, sessionlog.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testSession()
+	for _, m := range want.Messages {
+		if err := l.WriteMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.WriteSnapshot([]byte(`{"version": 1, "id": "c", "usage": {"input_tokens": 10, "output_tokens": 20, "total_cost_usd": 1.5}}`)); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	got, err := FromLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MessageCount != 5 || got.Messages[2].ToolName != "bash" || !got.Messages[3].HideOutput {
+		t.Errorf("messages = %+v", got.Messages)
+	}
+	if got.TotalUsage.OutputTokens != 20 || got.TotalUsage.TotalCostUSD != 1.5 {
+		t.Errorf("usage = %+v", got.TotalUsage)
+	}
+}
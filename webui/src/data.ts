@@ -1,5 +1,18 @@
 /* eslint-disable @typescript-eslint/no-explicit-any */
-import { AgentMessage, State } from "./types";
+import {
+  AgentMessage,
+  Event as AgentEvent,
+  State,
+  StreamingResponse,
+} from "./types";
+
+/**
+ * An event on the session's event stream: an agent event,
+ * or a state snapshot or heartbeat from the server
+ */
+export type StreamEvent =
+  | AgentEvent
+  | { type: "state" | "heartbeat"; time: string; state?: State };
 
 /**
  * Event types for data manager
@@ -7,7 +20,8 @@ import { AgentMessage, State } from "./types";
 export type DataManagerEventType =
   | "dataChanged"
   | "connectionStatusChanged"
-  | "initialLoadComplete";
+  | "initialLoadComplete"
+  | "streamingChanged";
 
 /**
  * Connection status types
@@ -19,7 +33,7 @@ export type ConnectionStatus =
   | "disabled";
 
 /**
- * DataManager - Class to manage timeline data, fetching, and SSE streaming
+ * DataManager - Class to manage timeline data, fetching, and the session's event stream
  */
 export class DataManager {
   // State variables
@@ -28,7 +42,8 @@ export class DataManager {
   private isFirstLoad: boolean = true;
   private lastHeartbeatTime: number = 0;
   private connectionStatus: ConnectionStatus = "disconnected";
-  private eventSource: EventSource | null = null;
+  private socket: WebSocket | null = null;
+  private streaming: StreamingResponse = {};
   private reconnectTimer: number | null = null;
   private reconnectAttempt: number = 0;
   private maxReconnectDelayMs: number = 60000; // Max delay of 60 seconds
@@ -49,26 +64,27 @@ export class DataManager {
     this.eventListeners.set("dataChanged", []);
     this.eventListeners.set("connectionStatusChanged", []);
     this.eventListeners.set("initialLoadComplete", []);
+    this.eventListeners.set("streamingChanged", []);
 
     // Check connection status periodically
     setInterval(() => this.checkConnectionStatus(), 5000);
   }
 
   /**
-   * Initialize the data manager and connect to the SSE stream
+   * Initialize the data manager and connect to the event stream
    */
   public async initialize(): Promise<void> {
-    // Connect to the SSE stream
+    // Connect to the event stream
     this.connect();
   }
 
   /**
-   * Connect to the SSE stream
+   * Connect to the session's event stream over a WebSocket
    */
   private connect(): void {
     // If we're already connecting or connected, don't start another connection attempt
     if (
-      this.eventSource &&
+      this.socket &&
       (this.connectionStatus === "connecting" ||
         this.connectionStatus === "connected")
     ) {
@@ -76,7 +92,7 @@ export class DataManager {
     }
 
     // Close any existing connection
-    this.closeEventSource();
+    this.closeSocket();
 
     // Reset initial load state for new connection
     this.expectedMessageCount = null;
@@ -91,74 +107,119 @@ export class DataManager {
         ? this.messages[this.messages.length - 1].idx + 1
         : 0;
 
-    // Create a new EventSource connection
-    this.eventSource = new EventSource(`stream?from=${fromIndex}`);
+    // Open a WebSocket to the session's event stream
+    const url = new URL(`ws?from=${fromIndex}`, window.location.href);
+    url.protocol = url.protocol === "https:" ? "wss:" : "ws:";
+    const socket = new WebSocket(url);
+    this.socket = socket;
 
-    // Set up event handlers
-    this.eventSource.addEventListener("open", () => {
-      console.log("SSE stream opened");
+    socket.addEventListener("open", () => {
+      console.log("Event stream opened");
       this.reconnectAttempt = 0; // Reset reconnect attempt counter on successful connection
       this.updateConnectionStatus("connected");
       this.lastHeartbeatTime = Date.now(); // Set initial heartbeat time
     });
 
-    this.eventSource.addEventListener("error", (event) => {
-      console.error("SSE stream error:", event);
-      this.closeEventSource();
+    socket.addEventListener("close", (event) => {
+      if (this.socket !== socket) {
+        return; // We closed it ourselves
+      }
+      console.error("Event stream closed:", event.code, event.reason);
+      this.closeSocket();
       this.updateConnectionStatus("disconnected", "Connection lost");
       this.scheduleReconnect();
     });
 
-    // Handle incoming messages
-    this.eventSource.addEventListener("message", (event) => {
-      const message = JSON.parse(event.data) as AgentMessage;
-      this.processNewMessage(message);
+    socket.addEventListener("message", (event) => {
+      this.processEvent(JSON.parse(event.data) as StreamEvent);
     });
+  }
 
-    // Handle state updates
-    this.eventSource.addEventListener("state", (event) => {
-      const state = JSON.parse(event.data) as State;
-      this.timelineState = state;
-
-      // Store expected message count for initial load detection
-      if (this.expectedMessageCount === null) {
-        this.expectedMessageCount = state.message_count;
-        console.log(
-          `Initial load expects ${this.expectedMessageCount} messages`,
-        );
-
-        // Handle empty conversation case - immediately mark as complete
-        if (this.expectedMessageCount === 0) {
-          this.isInitialLoadComplete = true;
-          console.log(`Initial load complete: Empty conversation (0 messages)`);
-          this.emitEvent("initialLoadComplete", {
-            messageCount: 0,
-            expectedCount: 0,
-          });
+  /**
+   * Process an event from the event stream
+   */
+  private processEvent(event: StreamEvent): void {
+    switch (event.type) {
+      case "state":
+        if (event.state) {
+          this.processState(event.state);
+        }
+        break;
+      case "message":
+        if (event.message) {
+          // A complete message ends the response that was streaming
+          if (this.streaming.text || this.streaming.tool_calls?.length) {
+            this.streaming = {};
+            this.emitEvent("streamingChanged", this.streaming);
+          }
+          this.processNewMessage(event.message);
+        }
+        break;
+      case "message_delta":
+        this.processDelta(event);
+        break;
+      case "heartbeat":
+        this.lastHeartbeatTime = Date.now();
+        // Make sure connection status is updated if it wasn't already
+        if (this.connectionStatus !== "connected") {
+          this.updateConnectionStatus("connected");
         }
+        break;
+    }
+  }
+
+  /**
+   * Process a state update
+   */
+  private processState(state: State): void {
+    this.timelineState = state;
+
+    // Store expected message count for initial load detection
+    if (this.expectedMessageCount === null) {
+      this.expectedMessageCount = state.message_count;
+      console.log(`Initial load expects ${this.expectedMessageCount} messages`);
+
+      // Handle empty conversation case - immediately mark as complete
+      if (this.expectedMessageCount === 0) {
+        this.isInitialLoadComplete = true;
+        console.log(`Initial load complete: Empty conversation (0 messages)`);
+        this.emitEvent("initialLoadComplete", {
+          messageCount: 0,
+          expectedCount: 0,
+        });
       }
+    }
 
-      this.checkInitialLoadComplete();
-      this.emitEvent("dataChanged", { state, newMessages: [] });
-    });
+    this.checkInitialLoadComplete();
+    this.emitEvent("dataChanged", { state, newMessages: [] });
+  }
 
-    // Handle heartbeats
-    this.eventSource.addEventListener("heartbeat", () => {
-      this.lastHeartbeatTime = Date.now();
-      // Make sure connection status is updated if it wasn't already
-      if (this.connectionStatus !== "connected") {
-        this.updateConnectionStatus("connected");
-      }
-    });
+  /**
+   * Add more of the response the model is generating to the streaming response
+   */
+  private processDelta(event: AgentEvent): void {
+    const text = (this.streaming.text || "") + (event.delta || "");
+    let toolCalls = this.streaming.tool_calls || [];
+    const call = event.tool_call_delta;
+    if (call) {
+      const i = toolCalls.findIndex((c) => c.id === call.id);
+      toolCalls =
+        i < 0
+          ? [...toolCalls, call]
+          : toolCalls.map((c, j) => (j === i ? call : c));
+    }
+    this.streaming = { text, tool_calls: toolCalls };
+    this.emitEvent("streamingChanged", this.streaming);
   }
 
   /**
-   * Close the current EventSource connection
+   * Close the current WebSocket connection
    */
-  private closeEventSource(): void {
-    if (this.eventSource) {
-      this.eventSource.close();
-      this.eventSource = null;
+  private closeSocket(): void {
+    if (this.socket) {
+      const socket = this.socket;
+      this.socket = null;
+      socket.close();
     }
   }
 
@@ -205,7 +266,7 @@ export class DataManager {
       console.warn(
         "No heartbeat received in 90 seconds, connection appears to be lost",
       );
-      this.closeEventSource();
+      this.closeSocket();
       this.updateConnectionStatus(
         "disconnected",
         "Connection timed out (no heartbeat)",
@@ -237,7 +298,7 @@ export class DataManager {
   }
 
   /**
-   * Process a new message from the SSE stream
+   * Process a new message from the event stream
    */
   private processNewMessage(message: AgentMessage): void {
     // Find the message's position in the array
@@ -2,6 +2,7 @@ import { AgentMessage, State } from "../types";
 
 export const initialMessages: AgentMessage[] = [
   {
+    schema_version: 1,
     type: "user",
     end_of_turn: false,
     content:
@@ -11,6 +12,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 0,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: false,
     content:
@@ -37,6 +39,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 1,
   },
   {
+    schema_version: 1,
     type: "tool",
     end_of_turn: false,
     content: "",
@@ -52,6 +55,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 2,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: false,
     content: "",
@@ -77,6 +81,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 3,
   },
   {
+    schema_version: 1,
     type: "tool",
     end_of_turn: false,
     content: "",
@@ -93,6 +98,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 4,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: false,
     content:
@@ -120,6 +126,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 5,
   },
   {
+    schema_version: 1,
     type: "tool",
     end_of_turn: false,
     content: "",
@@ -136,6 +143,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 6,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: false,
     content: "Now let me commit this change:",
@@ -162,6 +170,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 7,
   },
   {
+    schema_version: 1,
     type: "tool",
     end_of_turn: false,
     content: "",
@@ -179,6 +188,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 8,
   },
   {
+    schema_version: 1,
     type: "commit",
     end_of_turn: false,
     content: "",
@@ -194,6 +204,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 9,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: false,
     content: "",
@@ -220,6 +231,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 10,
   },
   {
+    schema_version: 1,
     type: "tool",
     end_of_turn: false,
     content: "",
@@ -238,6 +250,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 11,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: false,
     content: "Let me run a code review as required:",
@@ -263,6 +276,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 12,
   },
   {
+    schema_version: 1,
     type: "tool",
     end_of_turn: false,
     content: "",
@@ -278,6 +292,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 13,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: false,
     content: "Now let me try the done call again:",
@@ -304,6 +319,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 14,
   },
   {
+    schema_version: 1,
     type: "tool",
     end_of_turn: false,
     content: "",
@@ -321,6 +337,7 @@ export const initialMessages: AgentMessage[] = [
     idx: 15,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: true,
     content:
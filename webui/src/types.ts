@@ -8,6 +8,7 @@ export interface ToolCall {
 	result_message?: AgentMessage | null;
 	args?: string;
 	result?: string;
+	command_tags?: Category[] | null;
 }
 
 export interface GitCommit {
@@ -23,9 +24,11 @@ export interface Usage {
 	cache_read_input_tokens: number;
 	output_tokens: number;
 	cost_usd: number;
+	retries?: number;
 }
 
 export interface AgentMessage {
+	schema_version: number;
 	type: CodingAgentMessageType;
 	end_of_turn: boolean;
 	content: string;
@@ -33,7 +36,9 @@ export interface AgentMessage {
 	input?: string;
 	tool_result?: string;
 	tool_error?: boolean;
+	tool_error_kind?: ToolErrorKind;
 	tool_call_id?: string;
+	command_tags?: Category[] | null;
 	tool_calls?: ToolCall[] | null;
 	toolResponses?: AgentMessage[] | null;
 	commits?: (GitCommit | null)[] | null;
@@ -50,6 +55,47 @@ export interface AgentMessage {
 	idx: number;
 }
 
+export interface StreamingToolCall {
+	id: string;
+	tool_name: string;
+	command?: string;
+	permission_error?: string;
+}
+
+export interface StreamingResponse {
+	text?: string;
+	tool_calls?: StreamingToolCall[] | null;
+}
+
+export interface EventToolCall {
+	id: string;
+	name: string;
+	input?: string;
+	error?: boolean;
+	error_kind?: ToolErrorKind;
+}
+
+export interface Hunk {
+	old_start: number;
+	old_lines: number;
+	new_start: number;
+	new_lines: number;
+	diff: string;
+}
+
+export interface Change {
+	path: string;
+	new: boolean;
+	hunks: Hunk[] | null;
+}
+
+export interface PermissionRequest {
+	id: string;
+	tool: string;
+	description: string;
+	time: string;
+}
+
 export interface CumulativeUsage {
 	start_time: string;
 	messages: number;
@@ -58,7 +104,23 @@ export interface CumulativeUsage {
 	cache_read_input_tokens: number;
 	cache_creation_input_tokens: number;
 	total_cost_usd: number;
+	retries?: number;
 	tool_uses: { [key: string]: number } | null;
+	models?: { [key: string]: Usage } | null;
+}
+
+export interface Event {
+	schema_version: number;
+	type: EventType;
+	time: string;
+	delta?: string;
+	tool_call_delta?: StreamingToolCall | null;
+	message?: AgentMessage | null;
+	tool_call?: EventToolCall | null;
+	changes?: Change[] | null;
+	permission?: PermissionRequest | null;
+	usage?: CumulativeUsage | null;
+	agent_state?: string;
 }
 
 export interface Port {
@@ -68,6 +130,18 @@ export interface Port {
 	pid: number;
 }
 
+export interface JobInfo {
+	command: string;
+	schema_version: number;
+	job?: number;
+	status?: string;
+	pid: number;
+	stdout_file: string;
+	stderr_file: string;
+	unit?: string;
+	stop_command?: string;
+}
+
 export interface State {
 	state_version: number;
 	message_count: number;
@@ -102,6 +176,9 @@ export interface State {
 	diff_lines_added: number;
 	diff_lines_removed: number;
 	open_ports?: Port[] | null;
+	pending_changes?: number;
+	jobs?: JobInfo[] | null;
+	budget_dollars?: number;
 }
 
 export interface TodoItem {
@@ -142,6 +219,18 @@ export interface GitLogEntry {
 	subject: string;
 }
 
+export interface Decision {
+	hunk: number;
+	accept: boolean;
+	comment?: string;
+}
+
 export type CodingAgentMessageType = 'user' | 'agent' | 'error' | 'budget' | 'tool' | 'commit' | 'auto';
 
+export type Category = 'build' | 'test' | 'vcs' | 'package-install' | 'file-read' | 'network' | 'other';
+
+export type EventType = 'turn_started' | 'message_delta' | 'message' | 'tool_call_started' | 'tool_call_output' | 'tool_call_finished' | 'permission_requested' | 'usage_updated' | 'state_changed';
+
+export type ToolErrorKind = 'user_denied' | 'timeout' | 'output_too_large' | 'tool_crashed' | 'budget_exceeded' | 'provider_error';
+
 export type Duration = number;
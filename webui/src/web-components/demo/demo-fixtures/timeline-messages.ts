@@ -9,6 +9,7 @@ const baseTimestamp = new Date("2024-01-15T10:00:00Z");
 
 export const sampleTimelineMessages: AgentMessage[] = [
   {
+    schema_version: 1,
     type: "user",
     end_of_turn: true,
     content:
@@ -18,6 +19,7 @@ export const sampleTimelineMessages: AgentMessage[] = [
     idx: 0,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: false,
     content:
@@ -27,6 +29,7 @@ export const sampleTimelineMessages: AgentMessage[] = [
     idx: 1,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: false,
     content: "First, let me check your current directory structure:",
@@ -36,6 +39,7 @@ export const sampleTimelineMessages: AgentMessage[] = [
     idx: 2,
   },
   {
+    schema_version: 1,
     type: "tool",
     end_of_turn: false,
     content:
@@ -47,6 +51,7 @@ export const sampleTimelineMessages: AgentMessage[] = [
     idx: 3,
   },
   {
+    schema_version: 1,
     type: "agent",
     end_of_turn: true,
     content:
@@ -58,6 +63,7 @@ export const sampleTimelineMessages: AgentMessage[] = [
 ];
 
 export const longTimelineMessage: AgentMessage = {
+  schema_version: 1,
   type: "agent",
   end_of_turn: true,
   content: `I've analyzed your codebase and here's a comprehensive plan for implementing the file upload component:
@@ -103,6 +109,7 @@ export const mixedTimelineMessages: AgentMessage[] = [
   ...sampleTimelineMessages,
   longTimelineMessage,
   {
+    schema_version: 1,
     type: "user",
     end_of_turn: true,
     content: "That sounds great! Can you also add file type validation?",
@@ -1,7 +1,7 @@
-import { http, HttpResponse } from "msw";
+import { http, HttpResponse, ws } from "msw";
 import { initialState, initialMessages } from "../../../fixtures/dummy";
 
-// Mock state updates for SSE simulation
+// Mock state updates for event stream simulation
 const EMPTY_CONVERSATION =
   new URL(window.location.href).searchParams.get("emptyConversation") === "1";
 const ADD_NEW_MESSAGES =
@@ -15,100 +15,77 @@ let currentState = {
   message_count: messages.length,
 };
 
-// Text encoder for SSE messages
-const encoder = new TextEncoder();
-
-// Helper function to create SSE formatted messages
-function formatSSE(event, data) {
-  return `event: ${event}\ndata: ${JSON.stringify(data)}\n\n`;
-}
+// The WebSocket the UI streams the session's events from
+const eventStream = ws.link("*/ws");
 
 export const handlers = [
-  // SSE stream endpoint
-  http.get("*/stream", async ({ request }) => {
-    const url = new URL(request.url);
-    const fromIndex = parseInt(url.searchParams.get("from") || "0");
-
-    // Create a readable stream for SSE
-    const stream = new ReadableStream({
-      async start(controller) {
-        // Send initial state update
-        controller.enqueue(encoder.encode(formatSSE("state", currentState)));
-
-        // Send any existing messages that are newer than the fromIndex
-        const newMessages = messages.filter((msg) => msg.idx >= fromIndex);
-        for (const message of newMessages) {
-          controller.enqueue(encoder.encode(formatSSE("message", message)));
-        }
-
-        // Simulate heartbeats and new messages
-        let messageInterval;
-
-        // Send heartbeats every 30 seconds
-        const heartbeatInterval = setInterval(() => {
-          controller.enqueue(
-            encoder.encode(
-              formatSSE("heartbeat", { timestamp: new Date().toISOString() }),
-            ),
-          );
-        }, 30000);
-
-        // Add new messages if enabled
-        if (ADD_NEW_MESSAGES) {
-          messageInterval = setInterval(() => {
-            const newMessage = {
-              type: "agent" as const,
-              end_of_turn: false,
-              content: "Here's a new message via SSE",
-              timestamp: new Date().toISOString(),
-              conversation_id: "37s-g6xg",
-              usage: {
-                input_tokens: 5,
-                cache_creation_input_tokens: 250,
-                cache_read_input_tokens: 4017,
-                output_tokens: 92,
-                cost_usd: 0.0035376,
-              },
-              start_time: new Date(Date.now() - 2000).toISOString(),
-              end_time: new Date().toISOString(),
-              elapsed: 2075193375,
-              turnDuration: 28393844125,
-              idx: messages.length,
-            };
-
-            // Add to our messages array
-            messages.push(newMessage);
-
-            // Update the state
-            currentState = {
-              ...currentState,
-              message_count: messages.length,
-            };
-
-            // Send the message and updated state through SSE
-            controller.enqueue(
-              encoder.encode(formatSSE("message", newMessage)),
-            );
-            controller.enqueue(
-              encoder.encode(formatSSE("state", currentState)),
-            );
-          }, 2000); // Add a new message every 2 seconds
-        }
-
-        // Clean up on connection close
-        request.signal.addEventListener("abort", () => {
-          clearInterval(heartbeatInterval);
-          if (messageInterval) clearInterval(messageInterval);
-        });
-      },
-    });
-
-    return new HttpResponse(stream, {
-      headers: {
-        "Content-Type": "text/event-stream",
-        "Cache-Control": "no-cache",
-        Connection: "keep-alive",
-      },
+  // Event stream endpoint
+  eventStream.addEventListener("connection", ({ client }) => {
+    const fromIndex = parseInt(client.url.searchParams.get("from") || "0");
+
+    // Helper function to send an event
+    const send = (event: object) =>
+      client.send(JSON.stringify({ time: new Date().toISOString(), ...event }));
+
+    // Send initial state update
+    send({ type: "state", state: currentState });
+
+    // Send any existing messages that are newer than the fromIndex
+    const newMessages = messages.filter((msg) => msg.idx >= fromIndex);
+    for (const message of newMessages) {
+      send({ type: "message", message });
+    }
+
+    // Simulate heartbeats and new messages
+    let messageInterval;
+
+    // Send heartbeats every 30 seconds
+    const heartbeatInterval = setInterval(() => {
+      send({ type: "heartbeat" });
+    }, 30000);
+
+    // Add new messages if enabled
+    if (ADD_NEW_MESSAGES) {
+      messageInterval = setInterval(() => {
+        const newMessage = {
+          type: "agent" as const,
+          end_of_turn: false,
+          content: "Here's a new message via the event stream",
+          timestamp: new Date().toISOString(),
+          conversation_id: "37s-g6xg",
+          usage: {
+            input_tokens: 5,
+            cache_creation_input_tokens: 250,
+            cache_read_input_tokens: 4017,
+            output_tokens: 92,
+            cost_usd: 0.0035376,
+          },
+          start_time: new Date(Date.now() - 2000).toISOString(),
+          end_time: new Date().toISOString(),
+          elapsed: 2075193375,
+          turnDuration: 28393844125,
+          idx: messages.length,
+        };
+
+        // Add to our messages array
+        messages.push(newMessage);
+
+        // Update the state
+        currentState = {
+          ...currentState,
+          message_count: messages.length,
+        };
+
+        // Send the message and updated state
+        send({ type: "message", message: newMessage });
+        send({ type: "state", state: currentState });
+      }, 2000); // Add a new message every 2 seconds
+    }
+
+    // Clean up on connection close
+    client.addEventListener("close", () => {
+      clearInterval(heartbeatInterval);
+      if (messageInterval) clearInterval(messageInterval);
     });
   }),
 
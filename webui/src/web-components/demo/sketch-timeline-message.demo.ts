@@ -68,6 +68,7 @@ const demo: DemoModule = {
 
     // Create sample messages
     const userMessage: AgentMessage = {
+      schema_version: 1,
       idx: 0,
       type: "user",
       content:
@@ -80,6 +81,7 @@ const demo: DemoModule = {
     };
 
     const agentMessage: AgentMessage = {
+      schema_version: 1,
       idx: 1,
       type: "agent",
       content:
@@ -99,6 +101,7 @@ const demo: DemoModule = {
     };
 
     const toolCallMessage: AgentMessage = {
+      schema_version: 1,
       idx: 2,
       type: "agent",
       content: "Let me run some tests to verify the fix:",
@@ -118,6 +121,7 @@ const demo: DemoModule = {
     };
 
     const commitMessage: AgentMessage = {
+      schema_version: 1,
       idx: 3,
       type: "agent",
       content: "Perfect! I've committed the changes:",
@@ -137,6 +141,7 @@ const demo: DemoModule = {
     };
 
     const errorMessage: AgentMessage = {
+      schema_version: 1,
       idx: 4,
       type: "error",
       content:
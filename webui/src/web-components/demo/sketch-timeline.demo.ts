@@ -10,6 +10,7 @@ import type { AgentMessage } from "../../types";
 // Mock messages for demo
 function createMockMessage(props: Partial<AgentMessage> = {}): AgentMessage {
   return {
+    schema_version: 1,
     idx: props.idx || 0,
     type: props.type || "agent",
     content: props.content || "Hello world",
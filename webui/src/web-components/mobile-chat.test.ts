@@ -5,6 +5,7 @@ import { AgentMessage } from "../types";
 // Helper function to create mock messages
 function createMockMessage(props: Partial<AgentMessage> = {}): AgentMessage {
   return {
+    schema_version: 1,
     idx: props.idx || 0,
     type: props.type || "agent",
     content: props.content || "Hello world",
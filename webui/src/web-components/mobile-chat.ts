@@ -508,8 +508,23 @@ export class MobileChat extends LitElement {
           const command = input.command || "";
           const isBackground = input.background === true;
           const bgPrefix = isBackground ? "[bg] " : "";
+          const idlePrefix =
+            !isBackground && input.idle_timeout ? "[idle] " : "";
+          const cpuPrefix =
+            !isBackground && input.cpu_timeout ? "[cpu] " : "";
+          const tailPrefix = !isBackground && input.tail ? "[tail] " : "";
+          const readyPrefix = isBackground && input.ready ? "[ready] " : "";
+          const waitPrefix = input.wait_for?.length ? "[wait] " : "";
+          const clusterPrefix =
+            !isBackground && input.cluster ? "[cluster] " : "";
           return (
             bgPrefix +
+            idlePrefix +
+            cpuPrefix +
+            tailPrefix +
+            readyPrefix +
+            waitPrefix +
+            clusterPrefix +
             (command.length > 40 ? command.substring(0, 40) + "..." : command)
           );
 
@@ -518,6 +533,69 @@ export class MobileChat extends LitElement {
           const patchCount = (input.patches || []).length;
           return `${path}: ${patchCount} edit${patchCount > 1 ? "s" : ""}`;
 
+        case "codegen":
+          return input.action === "detect"
+            ? "Detect API specs"
+            : "Regenerate code from API specs";
+
+        case "env_vars":
+          return `Environment variables${input.dir ? ` in ${input.dir}` : ""}`;
+
+        case "fetch_url":
+          return `Fetch ${input.url || ""}`;
+
+        case "deps":
+          return `Deps: ${input.action} ${(input.packages || []).join(" ")}`;
+
+        case "godoc":
+          return `Docs: ${input.package || ""}${input.symbol ? `.${input.symbol}` : ""}`;
+
+        case "codebase_overview":
+          return `Overview: ${input.path || "."}`;
+
+        case "symbols":
+          return input.action === "find"
+            ? `Symbols: ${input.name || ""}`
+            : `Symbols in ${input.path || "."}`;
+
+        case "skill":
+          return input.action === "use"
+            ? `Skill: ${input.name || ""}`
+            : "Skills";
+
+        case "semantic_search":
+          return `Code search: ${input.query || ""}`;
+
+        case "web_search":
+          return `Search: ${input.query || ""}`;
+
+        case "job_group":
+          return `Jobs: ${input.action} ${input.group || ""}`;
+
+        case "environment":
+          return `Environment: ${input.action} ${input.snapshot || ""}`;
+
+        case "forge":
+          return input.action === "issue"
+            ? `Forge: read #${input.number}`
+            : `Forge: ${input.action} ${input.branch || input.number || ""}`;
+
+        case "review":
+          return `Review: ${input.action} ${
+            input.pr ? `#${input.pr}` : "local changes"
+          }`;
+
+        case "scaffold":
+          return input.action === "list"
+            ? "List templates"
+            : `${input.template || ""} in ${input.dir || ""}`;
+
+        case "undo_last_change":
+          return "Undo last file change";
+
+        case "undo_all":
+          return "Undo all file changes";
+
         case "think":
           const thoughts = input.thoughts || "";
           const firstLine = thoughts.split("\n")[0] || "";
@@ -103,7 +103,7 @@ export class MobileShell extends LitElement {
       this.handleConnectionStatusChanged.bind(this),
     );
 
-    // Initialize the data manager - it will automatically connect to /stream?from=0
+    // Initialize the data manager - it will automatically connect to ws?from=0
     this.dataManager.initialize();
   }
 
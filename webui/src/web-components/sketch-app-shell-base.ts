@@ -1,7 +1,12 @@
 import { css, html, LitElement } from "lit";
 import { customElement, property, state } from "lit/decorators.js";
 import { ConnectionStatus, DataManager } from "../data";
-import { AgentMessage, GitLogEntry, State } from "../types";
+import {
+  AgentMessage,
+  GitLogEntry,
+  State,
+  StreamingResponse,
+} from "../types";
 import { aggregateAgentMessages } from "./aggregateAgentMessages";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
 
@@ -15,19 +20,21 @@ import "./sketch-monaco-view";
 import "./sketch-network-status";
 import "./sketch-call-status";
 import "./sketch-terminal";
+import "./sketch-dashboard";
 import "./sketch-timeline";
 import "./sketch-view-mode-select";
 import "./sketch-todo-panel";
+import "./sketch-pending-changes";
 import "./sketch-landing-page";
 
 import { createRef, ref } from "lit/directives/ref.js";
 import { SketchChatInput } from "./sketch-chat-input";
 
-type ViewMode = "chat" | "diff2" | "terminal";
+type ViewMode = "chat" | "diff2" | "terminal" | "dashboard";
 
 // Base class for sketch app shells - contains shared logic
 export abstract class SketchAppShellBase extends SketchTailwindElement {
-  // Current view mode (chat, diff, terminal)
+  // Current view mode (chat, diff, terminal, dashboard)
   @state()
   viewMode: ViewMode = "chat";
 
@@ -145,6 +152,10 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
   @state()
   private _showLandingPage: boolean = true;
 
+  // The response the model is generating, shown in the timeline until it completes
+  @state()
+  streamingResponse: StreamingResponse = {};
+
   constructor() {
     super();
 
@@ -214,6 +225,10 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
       "connectionStatusChanged",
       this.handleConnectionStatusChanged.bind(this),
     );
+    this.dataManager.addEventListener(
+      "streamingChanged",
+      this.handleStreamingChanged,
+    );
 
     // Set initial document title
     this.updateDocumentTitle();
@@ -262,6 +277,10 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
       "connectionStatusChanged",
       this.handleConnectionStatusChanged.bind(this),
     );
+    this.dataManager.removeEventListener(
+      "streamingChanged",
+      this.handleStreamingChanged,
+    );
 
     // Disconnect mutation observer if it exists
     if (this.mutationObserver) {
@@ -312,7 +331,7 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
    * Handle view mode selection event
    */
   private _handleViewModeSelect(event: CustomEvent) {
-    const mode = event.detail.mode as "chat" | "diff2" | "terminal";
+    const mode = event.detail.mode as ViewMode;
     this.toggleViewMode(mode, true);
   }
 
@@ -354,7 +373,7 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
   }
 
   /**
-   * Toggle between different view modes: chat, diff2, terminal
+   * Toggle between different view modes: chat, diff2, terminal, dashboard
    */
   private toggleViewMode(mode: ViewMode, updateHistory: boolean): void {
     // Don't do anything if the mode is already active
@@ -588,6 +607,10 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
     }
   }
 
+  private handleStreamingChanged = (streaming: StreamingResponse): void => {
+    this.streamingResponse = streaming;
+  };
+
   private handleDataChanged(eventData: {
     state: State;
     newMessages: AgentMessage[];
@@ -776,6 +799,7 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
     e.preventDefault();
     e.stopPropagation();
     const message = e.detail.message?.trim();
+    const interrupt = e.detail.interrupt === true;
     if (message == "") {
       return;
     }
@@ -794,7 +818,7 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
         headers: {
           "Content-Type": "application/json",
         },
-        body: JSON.stringify({ message }),
+        body: JSON.stringify({ message, interrupt }),
       });
 
       if (!response.ok) {
@@ -1023,6 +1047,7 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
             .agentState=${this.containerState?.agent_state}
             .llmCalls=${this.containerState?.outstanding_llm_calls || 0}
             .toolCalls=${this.containerState?.outstanding_tool_calls || []}
+            .streaming=${this.streamingResponse}
             .firstMessageIndex=${this.containerState?.first_message_index || 0}
             .state=${this.containerState}
             .dataManager=${this.dataManager}
@@ -1062,6 +1087,18 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
       >
         <sketch-terminal></sketch-terminal>
       </div>
+
+      <!-- Dashboard View -->
+      <div
+        class="dashboard-view ${this.viewMode === "dashboard"
+          ? "view-active flex-1 overflow-hidden min-h-0 flex flex-col h-full"
+          : "hidden"} w-full h-full"
+      >
+        <sketch-dashboard
+          .state=${this.containerState}
+          .active=${this.viewMode === "dashboard"}
+        ></sketch-dashboard>
+      </div>
     `;
   }
 
@@ -1072,7 +1109,15 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
         id="chat-input"
         class="self-end w-full shadow-[0_-2px_10px_rgba(0,0,0,0.1)]"
       >
-        <sketch-chat-input @send-chat="${this._sendChat}"></sketch-chat-input>
+        <sketch-pending-changes
+          .count=${this.containerState?.pending_changes || 0}
+          .messageCount=${this.containerState?.message_count || 0}
+        ></sketch-pending-changes>
+        <sketch-chat-input
+          .agentBusy=${(this.containerState?.outstanding_llm_calls || 0) > 0 ||
+          (this.containerState?.outstanding_tool_calls || []).length > 0}
+          @send-chat="${this._sendChat}"
+        ></sketch-chat-input>
       </div>
     `;
   }
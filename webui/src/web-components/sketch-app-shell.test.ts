@@ -285,10 +285,10 @@ test("correctly determines idle state ignoring system messages", async ({
     await route.fulfill({ json: testMessages });
   });
 
-  // Mock the SSE stream endpoint to prevent connection attempts
-  await page.route("**/stream*", async (route) => {
-    // Block the SSE connection request to prevent it from interfering
-    await route.abort();
+  // Mock the event stream endpoint to prevent connection attempts
+  await page.routeWebSocket("**/ws*", async (ws) => {
+    // Close the event stream to prevent it from interfering
+    await ws.close();
   });
 
   // Mount the component
@@ -328,9 +328,9 @@ test("correctly determines working state with non-end-of-turn agent message", as
   page,
   mount,
 }) => {
-  // Skip SSE mocking for this test - we'll set data directly
-  await page.route("**/stream*", async (route) => {
-    await route.abort();
+  // Skip event stream mocking for this test - we'll set data directly
+  await page.routeWebSocket("**/ws*", async (ws) => {
+    await ws.close();
   });
 
   // Mount the component
@@ -1,9 +1,14 @@
 import { html } from "lit";
-import { customElement, state, query } from "lit/decorators.js";
+import { customElement, property, state, query } from "lit/decorators.js";
 import { SketchTailwindElement } from "./sketch-tailwind-element.js";
 
 @customElement("sketch-chat-input")
 export class SketchChatInput extends SketchTailwindElement {
+  // agentBusy is set while the agent is working on a turn,
+  // when a message can interrupt it instead of waiting for it.
+  @property({ type: Boolean })
+  agentBusy: boolean = false;
+
   @state()
   content: string = "";
 
@@ -198,7 +203,7 @@ export class SketchChatInput extends SketchTailwindElement {
     }
   }
 
-  sendChatMessage() {
+  sendChatMessage(interrupt: boolean = false) {
     // Prevent sending if there are uploads in progress
     if (this.uploadsInProgress > 0) {
       console.log(
@@ -219,7 +224,7 @@ export class SketchChatInput extends SketchTailwindElement {
     // Only send if there's actual content (not just whitespace)
     if (this.content.trim()) {
       const event = new CustomEvent("send-chat", {
-        detail: { message: this.content },
+        detail: { message: this.content, interrupt },
         bubbles: true,
         composed: true,
       });
@@ -250,7 +255,23 @@ export class SketchChatInput extends SketchTailwindElement {
     requestAnimationFrame(() => this.adjustChatSpacing());
   }
 
+  async _interruptClicked() {
+    this.sendChatMessage(true);
+    this.chatInput.focus();
+    requestAnimationFrame(() => this.adjustChatSpacing());
+  }
+
   _chatInputKeyDown(event: KeyboardEvent) {
+    // Interrupt the agent if Ctrl/Cmd+Enter is pressed while it is busy
+    if (
+      event.key === "Enter" &&
+      (event.ctrlKey || event.metaKey) &&
+      this.agentBusy
+    ) {
+      event.preventDefault();
+      this.sendChatMessage(true);
+      return;
+    }
     // Send message if Enter is pressed without Shift key
     if (event.key === "Enter" && !event.shiftKey) {
       event.preventDefault(); // Prevent default newline
@@ -319,6 +340,19 @@ export class SketchChatInput extends SketchTailwindElement {
           >
             ${this.uploadsInProgress > 0 ? "Uploading..." : "Send"}
           </button>
+          ${this.agentBusy
+            ? html`
+                <button
+                  @click="${this._interruptClicked}"
+                  id="interruptChatButton"
+                  title="Stop the agent's current response and send this message instead (Ctrl+Enter)"
+                  ?disabled=${this.uploadsInProgress > 0}
+                  class="bg-orange-500 hover:bg-orange-600 disabled:bg-gray-400 disabled:cursor-not-allowed text-white border-none rounded px-5 cursor-pointer font-semibold self-center h-10"
+                >
+                  Interrupt
+                </button>
+              `
+            : ""}
         </div>
         ${this.isDraggingOver
           ? html`
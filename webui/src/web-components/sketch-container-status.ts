@@ -770,14 +770,31 @@ export class SketchContainerStatus extends SketchTailwindElement {
                   </div>
                 `
               : ""}
+            ${(this.state?.total_usage?.retries || 0) > 0
+              ? html`
+                  <div
+                    class="flex items-center whitespace-nowrap mr-2.5 text-xs"
+                  >
+                    <span class="text-xs text-gray-600 mr-1 font-medium"
+                      >LLM retries:</span
+                    >
+                    <span id="llmRetries" class="text-xs font-semibold break-all"
+                      >${formatNumber(this.state?.total_usage?.retries)}</span
+                    >
+                  </div>
+                `
+              : ""}
             <div
               class="flex items-center whitespace-nowrap mr-2.5 text-xs col-span-full mt-1.5 border-t border-gray-300 pt-1.5"
             >
-              <a href="logs" class="text-blue-600">Logs</a> (<a
-                href="download"
-                class="text-blue-600"
-                >Download</a
-              >)
+              <a href="logs" class="text-blue-600">Logs</a>
+              <span class="mx-1.5 text-gray-400">·</span>
+              <span class="text-gray-600 mr-1">Export:</span>
+              <a href="download?format=md" class="text-blue-600">Markdown</a>
+              <span class="mx-1 text-gray-400">|</span>
+              <a href="download?format=html" class="text-blue-600">HTML</a>
+              <span class="mx-1 text-gray-400">|</span>
+              <a href="download?format=json" class="text-blue-600">JSON</a>
             </div>
           </div>
 
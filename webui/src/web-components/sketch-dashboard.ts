@@ -0,0 +1,390 @@
+import { html, svg } from "lit";
+import { customElement, property, state } from "lit/decorators.js";
+import { GitLogEntry, JobInfo, State } from "../types.js";
+import { SketchTailwindElement } from "./sketch-tailwind-element.js";
+
+// A session the API server runs, as GET /sessions lists it.
+interface ApiSession {
+  id: string;
+  working_dir: string;
+  profile?: string;
+  created: string;
+}
+
+// A file write the agent can undo, as GET /undo lists it.
+interface FileWrite {
+  id: number;
+  path: string;
+  time: string;
+  created: boolean;
+}
+
+// A point on the cost burn-down: the session's total cost at a time.
+interface CostSample {
+  time: number;
+  cost: number;
+}
+
+// maxCostSamples bounds how many points the burn-down keeps.
+const maxCostSamples = 500;
+
+// recentCommits is how many of the branch's commits the dashboard lists.
+const recentCommits = 10;
+
+// sketch-dashboard is an overview of the session: the sessions the server runs, background jobs,
+// what the session has cost against its budget, and its recent commits and file writes.
+// It follows the state the app shell receives from the event stream,
+// and refetches its lists only when the state shows they may have changed.
+@customElement("sketch-dashboard")
+export class SketchDashboard extends SketchTailwindElement {
+  // state is the agent's state, updated with each event.
+  @property({ attribute: false })
+  state: State | null = null;
+
+  // active is whether the dashboard is shown; it fetches nothing while hidden.
+  @property({ type: Boolean })
+  active: boolean = false;
+
+  @state()
+  private sessions: ApiSession[] | null = null;
+
+  @state()
+  private commits: GitLogEntry[] = [];
+
+  @state()
+  private writes: FileWrite[] = [];
+
+  @state()
+  private costs: CostSample[] = [];
+
+  @state()
+  private shownCommit: string = "";
+
+  @state()
+  private commitDiff: string = "";
+
+  @state()
+  private jobs: JobInfo[] = [];
+
+  @state()
+  private error: string = "";
+
+  // The state the lists were last fetched for.
+  private fetchedFor: string = "";
+
+  updated(changed: Map<string, unknown>) {
+    if (changed.has("state") && this.state) {
+      this.jobs = this.state.jobs || [];
+      this.sampleCost(this.state.total_usage?.total_cost_usd || 0);
+    }
+    if ((changed.has("state") || changed.has("active")) && this.active) {
+      this.refresh();
+    }
+  }
+
+  // sampleCost adds a point to the burn-down when the cost has changed.
+  private sampleCost(cost: number) {
+    const last = this.costs[this.costs.length - 1];
+    if (last && last.cost === cost) {
+      return;
+    }
+    this.costs = [...this.costs, { time: Date.now(), cost }].slice(
+      -maxCostSamples,
+    );
+  }
+
+  // refresh refetches the lists if the session has moved on since they were fetched.
+  private refresh() {
+    const key = [
+      this.state?.message_count,
+      this.state?.diff_lines_added,
+      this.state?.diff_lines_removed,
+    ].join("/");
+    if (key === this.fetchedFor) {
+      return;
+    }
+    this.fetchedFor = key;
+    this.fetchSessions();
+    this.fetchJSON<GitLogEntry[]>("git/recentlog").then((log) => {
+      if (log) {
+        this.commits = log.slice(0, recentCommits);
+      }
+    });
+    this.fetchJSON<FileWrite[]>("undo").then((writes) => {
+      if (writes) {
+        this.writes = writes.slice().reverse();
+      }
+    });
+  }
+
+  // fetchSessions lists the API server's sessions, when this session is one of them.
+  private async fetchSessions() {
+    if (!window.location.pathname.includes("/sessions/")) {
+      this.sessions = null;
+      return;
+    }
+    const response = await fetch("../../sessions").catch(() => null);
+    if (
+      !response?.ok ||
+      !response.headers.get("Content-Type")?.includes("application/json")
+    ) {
+      this.sessions = null;
+      return;
+    }
+    this.sessions = (await response.json()) || [];
+  }
+
+  private async fetchJSON<T>(endpoint: string): Promise<T | null> {
+    try {
+      const response = await fetch(endpoint);
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      this.error = "";
+      return (await response.json()) || null;
+    } catch (error) {
+      this.error = `Failed to load ${endpoint}: ${error}`;
+      return null;
+    }
+  }
+
+  private async stopJob(job: number) {
+    try {
+      const response = await fetch("jobs/stop", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ job }),
+      });
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      this.jobs = (await response.json()) || [];
+      this.error = "";
+    } catch (error) {
+      this.error = `Failed to stop job ${job}: ${error}`;
+    }
+  }
+
+  private async toggleCommit(hash: string) {
+    if (this.shownCommit === hash) {
+      this.shownCommit = "";
+      return;
+    }
+    const shown = await this.fetchJSON<{ hash: string; output: string }>(
+      `git/show?hash=${encodeURIComponent(hash)}`,
+    );
+    if (shown) {
+      this.shownCommit = hash;
+      this.commitDiff = shown.output;
+    }
+  }
+
+  private renderSessions() {
+    if (!this.sessions) {
+      return html`<div class="text-xs text-gray-700">
+        <span class="font-mono">${this.state?.session_id}</span>
+        ${this.state?.slug ? html`· ${this.state.slug}` : ""}
+        ${this.state?.agent_state ? html`· ${this.state.agent_state}` : ""}
+      </div>`;
+    }
+    return html`
+      <table class="w-full text-xs">
+        ${this.sessions.map(
+          (s) => html`
+            <tr class="border-b border-gray-100">
+              <td class="font-mono py-1 pr-2">
+                <a class="text-blue-600 hover:underline" href="../${s.id}/"
+                  >${s.id}</a
+                >
+                ${s.id === this.state?.session_id ? " (this session)" : ""}
+              </td>
+              <td class="font-mono truncate pr-2" title="${s.working_dir}">
+                ${s.working_dir}
+              </td>
+              <td class="pr-2">${s.profile || ""}</td>
+              <td class="text-gray-500">
+                ${new Date(s.created).toLocaleString()}
+              </td>
+            </tr>
+          `,
+        )}
+      </table>
+    `;
+  }
+
+  private renderJobs() {
+    if (this.jobs.length === 0) {
+      return html`<div class="text-xs text-gray-500">No background jobs</div>`;
+    }
+    return html`
+      <table class="w-full text-xs">
+        ${this.jobs.map(
+          (j) => html`
+            <tr class="border-b border-gray-100">
+              <td class="py-1 pr-2">#${j.job}</td>
+              <td class="pr-2">${j.status}</td>
+              <td class="pr-2 text-gray-500">pid ${j.pid}</td>
+              <td class="font-mono truncate max-w-md pr-2" title="${j.command}">
+                ${j.command}
+              </td>
+              <td class="text-right">
+                ${j.status !== "exited"
+                  ? html`<button
+                      class="px-2 py-0.5 text-xs rounded border border-red-300 text-red-700 bg-white hover:bg-red-50 cursor-pointer"
+                      @click=${() => this.stopJob(j.job || 0)}
+                    >
+                      Stop
+                    </button>`
+                  : ""}
+              </td>
+            </tr>
+          `,
+        )}
+      </table>
+    `;
+  }
+
+  // renderCost draws the cost so far over time, against the budget if there is one.
+  private renderCost() {
+    const cost = this.state?.total_usage?.total_cost_usd || 0;
+    const budget = this.state?.budget_dollars || 0;
+    const summary = html`<div class="text-xs text-gray-700 mb-1">
+      $${cost.toFixed(2)}${budget > 0
+        ? html` of $${budget.toFixed(2)}, $${Math.max(budget - cost, 0).toFixed(
+              2,
+            )} left`
+        : ""}
+      · ${this.state?.total_usage?.input_tokens || 0} tokens in,
+      ${this.state?.total_usage?.output_tokens || 0} out
+    </div>`;
+    if (this.costs.length < 2) {
+      return summary;
+    }
+    const width = 400;
+    const height = 80;
+    const start = this.costs[0].time;
+    const span = Math.max(this.costs[this.costs.length - 1].time - start, 1);
+    const top = Math.max(budget, cost, 0.01);
+    const x = (t: number) => ((t - start) / span) * width;
+    const y = (c: number) => height - (c / top) * height;
+    const points = this.costs
+      .map((s) => `${x(s.time).toFixed(1)},${y(s.cost).toFixed(1)}`)
+      .join(" ");
+    return html`
+      ${summary}
+      <svg
+        viewBox="0 0 ${width} ${height}"
+        class="w-full h-20 bg-gray-50 border border-gray-200 rounded"
+        preserveAspectRatio="none"
+      >
+        ${budget > 0
+          ? svg`<line x1="0" y1="${y(budget)}" x2="${width}" y2="${y(budget)}" stroke="#dc2626" stroke-dasharray="4 2" />`
+          : ""}
+        ${svg`<polyline points="${points}" fill="none" stroke="#2563eb" stroke-width="2" />`}
+      </svg>
+    `;
+  }
+
+  private renderDiffLine(line: string) {
+    let color = "text-gray-700";
+    if (line.startsWith("+")) {
+      color = "text-green-700 bg-green-50";
+    } else if (line.startsWith("-")) {
+      color = "text-red-700 bg-red-50";
+    } else if (line.startsWith("@@")) {
+      color = "text-blue-700";
+    }
+    return html`<div class="${color}">${line || " "}</div>`;
+  }
+
+  private renderCommits() {
+    if (this.commits.length === 0) {
+      return html`<div class="text-xs text-gray-500">No commits yet</div>`;
+    }
+    return html`
+      ${this.commits.map(
+        (c) => html`
+          <div class="border-b border-gray-100">
+            <button
+              class="w-full text-left text-xs py-1 hover:bg-gray-50 cursor-pointer"
+              @click=${() => this.toggleCommit(c.hash)}
+            >
+              <span class="font-mono text-blue-600"
+                >${c.hash.substring(0, 8)}</span
+              >
+              ${c.subject}
+            </button>
+            ${this.shownCommit === c.hash
+              ? html`<pre
+                  class="font-mono text-xs overflow-auto max-h-96 p-2 bg-gray-50"
+                >
+${this.commitDiff.split("\n").map((line) => this.renderDiffLine(line))}</pre
+                >`
+              : ""}
+          </div>
+        `,
+      )}
+    `;
+  }
+
+  private renderWrites() {
+    if (this.writes.length === 0) {
+      return html`<div class="text-xs text-gray-500">No file writes</div>`;
+    }
+    return html`
+      <table class="w-full text-xs">
+        ${this.writes.map(
+          (w) => html`
+            <tr class="border-b border-gray-100">
+              <td class="font-mono truncate py-1 pr-2" title="${w.path}">
+                ${w.path}${w.created ? " (new file)" : ""}
+              </td>
+              <td class="text-gray-500 text-right">
+                ${new Date(w.time).toLocaleTimeString()}
+              </td>
+            </tr>
+          `,
+        )}
+      </table>
+    `;
+  }
+
+  private section(title: string, body: unknown) {
+    return html`
+      <section class="mb-4 p-3 bg-white border border-gray-300 rounded">
+        <h2 class="text-sm font-semibold mb-2">${title}</h2>
+        ${body}
+      </section>
+    `;
+  }
+
+  render() {
+    return html`
+      <div class="p-4 overflow-auto h-full bg-gray-100">
+        ${this.error
+          ? html`<div class="mb-2 text-xs text-red-700">${this.error}</div>`
+          : ""}
+        <div class="grid gap-4 lg:grid-cols-2">
+          <div>
+            ${this.section(
+              this.sessions ? "Sessions" : "Session",
+              this.renderSessions(),
+            )}
+            ${this.section("Background jobs", this.renderJobs())}
+            ${this.section("Cost", this.renderCost())}
+          </div>
+          <div>
+            ${this.section("Recent commits", this.renderCommits())}
+            ${this.section("Recent file writes", this.renderWrites())}
+          </div>
+        </div>
+      </div>
+    `;
+  }
+}
+
+declare global {
+  interface HTMLElementTagNameMap {
+    "sketch-dashboard": SketchDashboard;
+  }
+}
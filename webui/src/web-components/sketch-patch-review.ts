@@ -0,0 +1,298 @@
+import { html } from "lit";
+import { customElement, property, state } from "lit/decorators.js";
+import { Change, Decision, Hunk } from "../types.js";
+import { SketchTailwindElement } from "./sketch-tailwind-element.js";
+
+// A row of the side-by-side view: the old line on the left, the new one on the right.
+// A side is null where the other side's line has no counterpart.
+interface SplitRow {
+  left: string | null;
+  right: string | null;
+  kind: "context" | "change";
+}
+
+// splitRows lays out the lines of a hunk's diff side by side,
+// pairing each run of deletions with the additions that follow it.
+export function splitRows(diff: string): SplitRow[] {
+  const rows: SplitRow[] = [];
+  let removed: string[] = [];
+  let added: string[] = [];
+  const flush = () => {
+    for (let i = 0; i < Math.max(removed.length, added.length); i++) {
+      rows.push({
+        left: i < removed.length ? removed[i] : null,
+        right: i < added.length ? added[i] : null,
+        kind: "change",
+      });
+    }
+    removed = [];
+    added = [];
+  };
+  for (const line of diff.trimEnd().split("\n")) {
+    if (line.startsWith("@@") || line.startsWith("\\")) {
+      continue;
+    }
+    if (line.startsWith("-")) {
+      removed.push(line.substring(1));
+    } else if (line.startsWith("+")) {
+      added.push(line.substring(1));
+    } else {
+      flush();
+      rows.push({
+        left: line.substring(1),
+        right: line.substring(1),
+        kind: "context",
+      });
+    }
+  }
+  flush();
+  return rows;
+}
+
+// sketch-patch-review shows one file's staged change hunk by hunk, unified or side by side,
+// and lets the user accept or reject each hunk, with an optional comment.
+// Submitting the review resolves the decided hunks at once and tells the agent what was decided;
+// it dispatches "changes-updated" with the changes still pending.
+@customElement("sketch-patch-review")
+export class SketchPatchReview extends SketchTailwindElement {
+  @property({ attribute: false })
+  change: Change | null = null;
+
+  @state()
+  private layout: "unified" | "split" = "unified";
+
+  // The user's decisions so far, by hunk index.
+  @state()
+  private decisions: Map<number, Decision> = new Map();
+
+  @state()
+  private error: string = "";
+
+  @state()
+  private submitting: boolean = false;
+
+  updated(changed: Map<string, unknown>) {
+    if (changed.has("change")) {
+      const old = changed.get("change") as Change | null | undefined;
+      // Hunks are numbered afresh whenever the change does; decisions on old numbers would be wrong.
+      if (JSON.stringify(old?.hunks) !== JSON.stringify(this.change?.hunks)) {
+        this.decisions = new Map();
+      }
+    }
+  }
+
+  private decide(hunk: number, accept: boolean) {
+    const decisions = new Map(this.decisions);
+    const current = decisions.get(hunk);
+    if (current && current.accept === accept) {
+      decisions.delete(hunk); // clicking the chosen decision again undoes it
+    } else {
+      decisions.set(hunk, { hunk, accept, comment: current?.comment });
+    }
+    this.decisions = decisions;
+  }
+
+  private comment(hunk: number, comment: string) {
+    const current = this.decisions.get(hunk);
+    if (!current) {
+      return;
+    }
+    const decisions = new Map(this.decisions);
+    decisions.set(hunk, { ...current, comment: comment || undefined });
+    this.decisions = decisions;
+  }
+
+  private decideAll(accept: boolean) {
+    const decisions = new Map<number, Decision>();
+    (this.change?.hunks || []).forEach((_, hunk) => {
+      decisions.set(hunk, {
+        hunk,
+        accept,
+        comment: this.decisions.get(hunk)?.comment,
+      });
+    });
+    this.decisions = decisions;
+  }
+
+  private async submit() {
+    if (!this.change || this.decisions.size === 0) {
+      return;
+    }
+    this.submitting = true;
+    try {
+      const response = await fetch("changes/review", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+          path: this.change.path,
+          decisions: [...this.decisions.values()],
+        }),
+      });
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      const changes: Change[] = (await response.json()) || [];
+      this.decisions = new Map();
+      this.error = "";
+      this.dispatchEvent(
+        new CustomEvent("changes-updated", {
+          detail: { changes },
+          bubbles: true,
+          composed: true,
+        }),
+      );
+    } catch (error) {
+      this.error = `${error}`;
+    } finally {
+      this.submitting = false;
+    }
+  }
+
+  private renderUnified(hunk: Hunk) {
+    return hunk.diff
+      .trimEnd()
+      .split("\n")
+      .map((line) => {
+        let color = "text-gray-700";
+        if (line.startsWith("+")) {
+          color = "text-green-700 bg-green-50";
+        } else if (line.startsWith("-")) {
+          color = "text-red-700 bg-red-50";
+        } else if (line.startsWith("@@")) {
+          color = "text-blue-700";
+        }
+        return html`<div class="${color}">${line || " "}</div>`;
+      });
+  }
+
+  private renderSplit(hunk: Hunk) {
+    const side = (text: string | null, color: string) =>
+      html`<td
+        class="w-1/2 align-top px-1 whitespace-pre ${text === null
+          ? "bg-gray-100"
+          : color}"
+        >${text ?? ""}</td
+      >`;
+    return html`
+      <table class="w-full table-fixed">
+        ${splitRows(hunk.diff).map(
+          (row) => html`
+            <tr>
+              ${side(
+                row.left,
+                row.kind === "change"
+                  ? "text-red-700 bg-red-50"
+                  : "text-gray-700",
+              )}
+              ${side(
+                row.right,
+                row.kind === "change"
+                  ? "text-green-700 bg-green-50"
+                  : "text-gray-700",
+              )}
+            </tr>
+          `,
+        )}
+      </table>
+    `;
+  }
+
+  private renderHunk(hunk: Hunk, index: number) {
+    const decision = this.decisions.get(index);
+    const button = (accept: boolean, label: string, chosen: string) =>
+      html`<button
+        class="px-2 py-0.5 text-xs rounded border cursor-pointer ${decision?.accept ===
+        accept
+          ? chosen
+          : "border-gray-300 bg-white hover:bg-gray-100"}"
+        @click=${() => this.decide(index, accept)}
+      >
+        ${label}
+      </button>`;
+    const header = hunk.diff.split("\n", 1)[0];
+    return html`
+      <div class="border-t border-gray-200">
+        <div class="flex items-center justify-between px-2 py-1 bg-gray-50">
+          <span class="font-mono text-xs text-blue-700">${header}</span>
+          <span class="flex gap-1">
+            ${button(
+              true,
+              "Accept",
+              "border-green-600 bg-green-100 text-green-800",
+            )}
+            ${button(false, "Reject", "border-red-600 bg-red-100 text-red-800")}
+          </span>
+        </div>
+        <div
+          class="font-mono text-xs whitespace-pre overflow-x-auto max-h-64 overflow-y-auto p-1"
+        >
+          ${this.layout === "split"
+            ? this.renderSplit(hunk)
+            : this.renderUnified(hunk)}
+        </div>
+        ${decision
+          ? html`<input
+              class="w-full text-xs px-2 py-1 border-0 border-t border-gray-200"
+              placeholder="${decision.accept
+                ? "Comment for the agent (optional)"
+                : "Why reject it? The agent will see this (optional)"}"
+              .value=${decision.comment || ""}
+              @input=${(e: Event) =>
+                this.comment(index, (e.target as HTMLInputElement).value)}
+            />`
+          : ""}
+      </div>
+    `;
+  }
+
+  render() {
+    if (!this.change) {
+      return html``;
+    }
+    const button =
+      "px-2 py-0.5 text-xs rounded border border-gray-300 bg-white hover:bg-gray-100 cursor-pointer";
+    const hunks = this.change.hunks || [];
+    return html`
+      <div class="flex items-center gap-1 px-2 py-1 border-b border-gray-200">
+        <select
+          class="text-xs border border-gray-300 rounded"
+          .value=${this.layout}
+          @change=${(e: Event) =>
+            (this.layout = (e.target as HTMLSelectElement).value as
+              | "unified"
+              | "split")}
+        >
+          <option value="unified">Unified</option>
+          <option value="split">Side by side</option>
+        </select>
+        <button class="${button}" @click=${() => this.decideAll(true)}>
+          Accept every hunk
+        </button>
+        <button class="${button}" @click=${() => this.decideAll(false)}>
+          Reject every hunk
+        </button>
+        <span class="flex-1"></span>
+        <span class="text-xs text-gray-500"
+          >${this.decisions.size} of ${hunks.length} decided</span
+        >
+        <button
+          class="${button} font-semibold"
+          ?disabled=${this.decisions.size === 0 || this.submitting}
+          @click=${this.submit}
+        >
+          Submit review
+        </button>
+      </div>
+      ${this.error
+        ? html`<div class="text-xs text-red-700 px-2 py-1">${this.error}</div>`
+        : ""}
+      ${hunks.map((h, i) => this.renderHunk(h, i))}
+    `;
+  }
+}
+
+declare global {
+  interface HTMLElementTagNameMap {
+    "sketch-patch-review": SketchPatchReview;
+  }
+}
@@ -0,0 +1,202 @@
+import { html } from "lit";
+import { customElement, property, state } from "lit/decorators.js";
+import { Change } from "../types.js";
+import { SketchTailwindElement } from "./sketch-tailwind-element.js";
+import "./sketch-patch-review.js";
+
+// sketch-pending-changes shows the file modifications that await approval (sketch -approve-writes),
+// and lets the user approve, reject, or edit each file before it is written to disk,
+// or review it hunk by hunk with sketch-patch-review.
+@customElement("sketch-pending-changes")
+export class SketchPendingChanges extends SketchTailwindElement {
+  // count is the number of files with pending changes, from the agent's state.
+  @property({ type: Number })
+  count: number = 0;
+
+  // messageCount changes as the agent works; a change refetches the changes,
+  // which may have grown without count changing.
+  @property({ type: Number })
+  messageCount: number = 0;
+
+  @state()
+  private changes: Change[] = [];
+
+  @state()
+  private error: string = "";
+
+  @state()
+  private editingPath: string = "";
+
+  @state()
+  private editText: string = "";
+
+  updated(changed: Map<string, unknown>) {
+    if (changed.has("count") || changed.has("messageCount")) {
+      if (this.count > 0) {
+        this.fetchChanges();
+      } else {
+        this.changes = [];
+      }
+    }
+  }
+
+  private async fetchChanges() {
+    try {
+      const response = await fetch("changes");
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      this.changes = (await response.json()) || [];
+      this.error = "";
+    } catch (error) {
+      this.error = `Failed to load changes: ${error}`;
+    }
+  }
+
+  private async post(endpoint: string, body: object) {
+    try {
+      const response = await fetch(endpoint, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(body),
+      });
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      this.changes = (await response.json()) || [];
+      this.error = "";
+    } catch (error) {
+      this.error = `${error}`;
+    }
+  }
+
+  private async startEdit(path: string) {
+    try {
+      const response = await fetch(
+        `changes/file?path=${encodeURIComponent(path)}`,
+      );
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+      this.editText = await response.text();
+      this.editingPath = path;
+    } catch (error) {
+      this.error = `Failed to load ${path}: ${error}`;
+    }
+  }
+
+  private async saveEdit() {
+    await this.post("changes/edit", {
+      path: this.editingPath,
+      content: this.editText,
+    });
+    if (!this.error) {
+      this.editingPath = "";
+    }
+  }
+
+  private renderChange(change: Change) {
+    const button =
+      "px-2 py-0.5 text-xs rounded border border-gray-300 bg-white hover:bg-gray-100 cursor-pointer";
+    return html`
+      <div class="mb-2 border border-gray-300 rounded bg-white">
+        <div
+          class="flex items-center justify-between px-2 py-1 border-b border-gray-200 bg-gray-50"
+        >
+          <span class="font-mono text-xs truncate" title="${change.path}"
+            >${change.path}${change.new ? " (new file)" : ""}</span
+          >
+          <span class="flex gap-1 flex-shrink-0">
+            <button
+              class="${button}"
+              @click="${() => this.post("changes/approve", { path: change.path })}"
+            >
+              Accept
+            </button>
+            <button
+              class="${button}"
+              @click="${() => this.post("changes/reject", { path: change.path })}"
+            >
+              Reject
+            </button>
+            <button
+              class="${button}"
+              @click="${() => this.startEdit(change.path)}"
+            >
+              Edit
+            </button>
+          </span>
+        </div>
+        ${this.editingPath === change.path
+          ? html`
+              <textarea
+                class="w-full h-64 font-mono text-xs p-2 border-0"
+                .value="${this.editText}"
+                @input="${(e: Event) =>
+                  (this.editText = (e.target as HTMLTextAreaElement).value)}"
+              ></textarea>
+              <div class="flex gap-1 justify-end p-1">
+                <button
+                  class="${button}"
+                  @click="${() => (this.editingPath = "")}"
+                >
+                  Cancel
+                </button>
+                <button class="${button}" @click="${this.saveEdit}">
+                  Save and accept
+                </button>
+              </div>
+            `
+          : html`<sketch-patch-review
+              .change=${change}
+              @changes-updated=${(e: CustomEvent) =>
+                (this.changes = e.detail.changes)}
+            ></sketch-patch-review>`}
+      </div>
+    `;
+  }
+
+  render() {
+    if (this.count === 0 || this.changes.length === 0) {
+      return html``;
+    }
+    const button =
+      "px-2 py-0.5 text-xs rounded border border-gray-300 bg-white hover:bg-gray-100 cursor-pointer";
+    return html`
+      <div
+        class="max-h-[50vh] overflow-y-auto p-2 bg-amber-50 border-t border-amber-300"
+      >
+        <div class="flex items-center justify-between mb-2">
+          <span class="text-sm font-semibold"
+            >📝 ${this.changes.length} file(s) awaiting approval</span
+          >
+          <span class="flex gap-1">
+            <button
+              class="${button}"
+              @click="${() => this.post("changes/approve", {})}"
+            >
+              Accept all
+            </button>
+            <button
+              class="${button}"
+              title="Accept these changes and write later ones without asking"
+              @click="${() => this.post("changes/approve-session", {})}"
+            >
+              Accept all for this session
+            </button>
+          </span>
+        </div>
+        ${this.error
+          ? html`<div class="text-xs text-red-700 mb-2">${this.error}</div>`
+          : ""}
+        ${this.changes.map((c) => this.renderChange(c))}
+      </div>
+    `;
+  }
+}
+
+declare global {
+  interface HTMLElementTagNameMap {
+    "sketch-pending-changes": SketchPendingChanges;
+  }
+}
@@ -16,6 +16,7 @@ import {
 // Helper function to create mock timeline messages
 function createMockMessage(props: Partial<AgentMessage> = {}): AgentMessage {
   return {
+    schema_version: 1,
     idx: props.idx || 0,
     type: props.type || "agent",
     content: props.content || "Hello world",
@@ -51,6 +51,7 @@ class MockDataManager {
 // Helper function to create mock timeline messages
 function createMockMessage(props: Partial<AgentMessage> = {}): AgentMessage {
   return {
+    schema_version: 1,
     idx: props.idx || 0,
     type: props.type || "agent",
     content: props.content || "Hello world",
@@ -3,7 +3,7 @@ import { html } from "lit";
 import { PropertyValues } from "lit";
 import { repeat } from "lit/directives/repeat.js";
 import { customElement, property, state } from "lit/decorators.js";
-import { AgentMessage, State } from "../types";
+import { AgentMessage, State, StreamingResponse } from "../types";
 import "./sketch-timeline-message";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
 import { Ref } from "lit/directives/ref";
@@ -23,6 +23,10 @@ export class SketchTimeline extends SketchTailwindElement {
   @property({ attribute: false })
   toolCalls: string[] = [];
 
+  // The response the model is generating, shown below the thinking indicator
+  @property({ attribute: false })
+  streaming: StreamingResponse = {};
+
   // Track if we should scroll to the bottom
   @state()
   private scrollingState: "pinToLatest" | "floating" = "pinToLatest";
@@ -922,6 +926,41 @@ export class SketchTimeline extends SketchTailwindElement {
                   },
                 )
               : ""}
+            ${isThinking && this.isInitialLoadComplete && this.streaming.text
+              ? html`
+                  <div
+                    class="ml-[85px] mb-4 max-w-[80%] bg-gray-100 rounded-2xl rounded-bl-[5px] px-4 py-2.5 text-sm whitespace-pre-wrap break-words"
+                    data-testid="streaming-text"
+                  >${this.streaming.text}</div>
+                `
+              : ""}
+            ${isThinking && this.isInitialLoadComplete
+              ? (this.streaming.tool_calls || []).map(
+                  (call) => html`
+                    <div
+                      class="ml-[85px] mb-4 max-w-[80%] text-xs"
+                      data-testid="streaming-tool-call"
+                    >
+                      <div class="text-gray-500">${call.tool_name}</div>
+                      ${call.command
+                        ? html`<pre
+                            class="bg-gray-100 rounded px-2 py-1 whitespace-pre-wrap break-all max-h-40 overflow-y-auto"
+                          >
+${call.command}</pre
+                          >`
+                        : ""}
+                      ${call.permission_error
+                        ? html`<div
+                            class="text-red-600"
+                            data-testid="streaming-permission-error"
+                          >
+                            ${call.permission_error}
+                          </div>`
+                        : ""}
+                    </div>
+                  `,
+                )
+              : ""}
             ${isThinking && this.isInitialLoadComplete
               ? html`
                   <div
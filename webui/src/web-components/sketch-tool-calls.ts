@@ -63,6 +63,87 @@ export class SketchToolCalls extends SketchTailwindElement {
           .open=${open}
           .toolCall=${toolCall}
         ></sketch-tool-card-patch>`;
+      case "codegen":
+        return html`<sketch-tool-card-codegen
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-codegen>`;
+      case "env_vars":
+        return html`<sketch-tool-card-env-vars
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-env-vars>`;
+      case "fetch_url":
+        return html`<sketch-tool-card-fetch-url
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-fetch-url>`;
+      case "deps":
+        return html`<sketch-tool-card-deps
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-deps>`;
+      case "godoc":
+        return html`<sketch-tool-card-godoc
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-godoc>`;
+      case "codebase_overview":
+        return html`<sketch-tool-card-codebase-overview
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-codebase-overview>`;
+      case "symbols":
+        return html`<sketch-tool-card-symbols
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-symbols>`;
+      case "skill":
+        return html`<sketch-tool-card-skill
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-skill>`;
+      case "semantic_search":
+        return html`<sketch-tool-card-semantic-search
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-semantic-search>`;
+      case "web_search":
+        return html`<sketch-tool-card-web-search
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-web-search>`;
+      case "job_group":
+        return html`<sketch-tool-card-job-group
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-job-group>`;
+      case "environment":
+        return html`<sketch-tool-card-environment
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-environment>`;
+      case "forge":
+        return html`<sketch-tool-card-forge
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-forge>`;
+      case "review":
+        return html`<sketch-tool-card-review
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-review>`;
+      case "scaffold":
+        return html`<sketch-tool-card-scaffold
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-scaffold>`;
+      case "undo_last_change":
+      case "undo_all":
+        return html`<sketch-tool-card-undo
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-undo>`;
       case "think":
         return html`<sketch-tool-card-think
           .open=${open}
@@ -6,6 +6,7 @@ import {
   MultipleChoiceOption,
   MultipleChoiceParams,
   State,
+  ToolErrorKind,
 } from "../types";
 import { marked } from "marked";
 import DOMPurify from "dompurify";
@@ -63,6 +64,14 @@ function renderMarkdown(markdownContent: string): string {
   }
 }
 
+// Status icons for failed tool calls, by why they failed; others get 〰️.
+const toolErrorIcons: Partial<Record<ToolErrorKind, string>> = {
+  user_denied: "🚫",
+  timeout: "⏱️",
+  tool_crashed: "💥",
+  budget_exceeded: "💸",
+};
+
 // Common styles shared across all tool cards
 const commonStyles = css`
   :host {
@@ -266,8 +275,13 @@ export class SketchToolCard extends LitElement {
       >⏳</span
     >`;
     if (this.toolCall?.result_message) {
+      const errorKind = this.toolCall?.result_message.tool_error_kind;
       statusIcon = this.toolCall?.result_message.tool_error
-        ? html`<span class="tool-call-status tool-error">〰️</span>`
+        ? html`<span
+            class="tool-call-status tool-error"
+            title=${errorKind ? errorKind.replace(/_/g, " ") : "failed"}
+            >${(errorKind && toolErrorIcons[errorKind]) || "〰️"}</span
+          >`
         : html`<span class="tool-call-status tool-success">✓</span>`;
     }
 
@@ -372,6 +386,15 @@ export class SketchToolCardBash extends LitElement {
         margin-left: 8px;
         vertical-align: middle;
       }
+      .command-tag {
+        display: inline-block;
+        background-color: #eceff1;
+        color: #455a64;
+        font-size: 10px;
+        padding: 1px 6px;
+        border-radius: 10px;
+        margin-right: 4px;
+      }
       .command-wrapper {
         display: inline-block;
         max-width: 100%;
@@ -386,6 +409,20 @@ export class SketchToolCardBash extends LitElement {
     const inputData = JSON.parse(this.toolCall?.input || "{}");
     const isBackground = inputData?.background === true;
     const backgroundIcon = isBackground ? "🔄 " : "";
+    const idleIcon = !isBackground && inputData?.idle_timeout ? "⏳ " : "";
+    const cpuIcon = !isBackground && inputData?.cpu_timeout ? "🔥 " : "";
+    const tailIcon = !isBackground && inputData?.tail ? "✂️ " : "";
+    const readyIcon = isBackground && inputData?.ready ? "🚦 " : "";
+    const waitIcon = inputData?.wait_for?.length ? "⛓️ " : "";
+    const clusterIcon = !isBackground && inputData?.cluster ? "☸️ " : "";
+    const icons =
+      backgroundIcon +
+      idleIcon +
+      cpuIcon +
+      tailIcon +
+      readyIcon +
+      waitIcon +
+      clusterIcon;
 
     // Truncate the command if it's too long to display nicely
     const command = inputData?.command || "";
@@ -405,13 +442,16 @@ export class SketchToolCardBash extends LitElement {
           class="command-wrapper"
           style="max-width: 100%; overflow: hidden; text-overflow: ellipsis; white-space: nowrap;"
         >
-          ${backgroundIcon}${displayCommand}
+          ${icons}${displayCommand}
         </div>
       </span>
       <div slot="input" class="input">
         <div class="tool-call-result-container">
-          <pre>${backgroundIcon}${inputData?.command}</pre>
+          <pre>${icons}${inputData?.command}</pre>
         </div>
+        ${(this.toolCall?.command_tags || []).map(
+          (tag) => html`<span class="command-tag">${tag}</span>`,
+        )}
       </div>
       ${this.toolCall?.result_message?.tool_result
         ? html`<div slot="result" class="result">
@@ -517,6 +557,704 @@ export class SketchToolCardPatch extends LitElement {
   }
 }
 
+@customElement("sketch-tool-card-codegen")
+export class SketchToolCardCodegen extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        🧬
+        ${input.action === "detect"
+          ? "Detect API specs and generators"
+          : "Regenerate code from API specs"}
+      </span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-env-vars")
+export class SketchToolCardEnvVars extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        🌿 Environment variables${input.dir ? ` in ${input.dir}` : ""}
+      </span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-fetch-url")
+export class SketchToolCardFetchUrl extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    pre {
+      white-space: pre-wrap;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        📥 ${input.url}${input.start_index
+          ? ` from byte ${input.start_index}`
+          : ""}${input.raw ? " (raw)" : ""}
+      </span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-deps")
+export class SketchToolCardDeps extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    pre {
+      white-space: pre-wrap;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    const packages = (input.packages || []).join(" ");
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        📦 ${input.action}${input.ecosystem
+          ? ` (${input.ecosystem})`
+          : ""}${packages ? ` ${packages}` : ""}${input.dir
+          ? ` in ${input.dir}`
+          : ""}
+      </span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-godoc")
+export class SketchToolCardGodoc extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    pre {
+      white-space: pre-wrap;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        📚 ${input.package}${input.symbol ? `.${input.symbol}` : ""}${input.version
+          ? `@${input.version}`
+          : ""}${input.all ? " (all)" : ""}
+      </span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-codebase-overview")
+export class SketchToolCardCodebaseOverview extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    pre {
+      white-space: pre-wrap;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        🗺️ Codebase overview${input.path ? ` of ${input.path}` : ""}
+      </span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-symbols")
+export class SketchToolCardSymbols extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    pre {
+      white-space: pre-wrap;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    const summary =
+      input.action === "find"
+        ? `Finding ${input.name || ""}${input.kind ? ` (${input.kind})` : ""}${input.path ? ` in ${input.path}` : ""}`
+        : `Listing symbols in ${input.path || "."}`;
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">🏷️ ${summary}</span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-skill")
+export class SketchToolCardSkill extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    pre {
+      white-space: pre-wrap;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    const summary =
+      input.action === "use"
+        ? `Using the ${input.name || ""} skill`
+        : "Listing skills";
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">🧰 ${summary}</span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-semantic-search")
+export class SketchToolCardSemanticSearch extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    pre {
+      white-space: pre-wrap;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        🧭 ${input.query}${input.path ? ` in ${input.path}` : ""}
+      </span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-web-search")
+export class SketchToolCardWebSearch extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    .result {
+      margin: 6px 0;
+    }
+    .url {
+      color: #080;
+      font-size: 0.85em;
+      word-break: break-all;
+    }
+    .snippet {
+      color: #444;
+    }
+    .searches-left {
+      color: #888;
+      font-size: 0.85em;
+    }
+    pre {
+      white-space: pre-wrap;
+    }
+  `;
+
+  // output returns the search's results, or null if it failed.
+  output(): any {
+    try {
+      return JSON.parse(this.toolCall?.result_message?.tool_result || "");
+    } catch {
+      return null;
+    }
+  }
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    const output = this.output();
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        🔎 ${input.query}${output
+          ? ` · ${output.results?.length || 0} results from ${output.provider}`
+          : ""}
+      </span>
+      <div slot="result">
+        ${output
+          ? html`${(output.results || []).map(
+                (r: any) =>
+                  html`<div class="result">
+                    <a href=${r.url} target="_blank" rel="noopener noreferrer"
+                      >${r.title || r.url}</a
+                    >
+                    <div class="url">${r.url}</div>
+                    ${r.snippet
+                      ? html`<div class="snippet">${r.snippet}</div>`
+                      : ""}
+                  </div>`,
+              )}
+              <div class="searches-left">
+                ${output.searches_left} searches left this session
+              </div>`
+          : html`<pre>${this.toolCall?.result_message?.tool_result}</pre>`}
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-job-group")
+export class SketchToolCardJobGroup extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    const jobs = (input.jobs || []).map((job: any) => job.name).join(" → ");
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        🧩 ${input.action} ${input.group}${jobs ? `: ${jobs}` : ""}
+      </span>
+      <div slot="input">
+        <pre>${JSON.stringify(input.jobs || [], null, 2)}</pre>
+      </div>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-environment")
+export class SketchToolCardEnvironment extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+  `;
+
+  summary(input: any): string {
+    switch (input.action) {
+      case "set":
+        return `Set ${Object.keys(input.vars || {}).join(", ")}`;
+      case "unset":
+        return `Unset ${(input.names || []).join(", ")}`;
+      case "snapshot":
+        return `Snapshot ${input.snapshot}`;
+      case "restore":
+        return `Restore ${input.snapshot}`;
+    }
+    return "Show environment";
+  }
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    const vars = Object.entries(input.vars || {});
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        🧪 ${this.summary(input)}
+      </span>
+      <div slot="input">
+        ${vars.map(
+          ([name, value]) => html`<div><b>${name}:</b> ${value}</div>`,
+        )}
+      </div>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-forge")
+export class SketchToolCardForge extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+  @state() answered: boolean = false;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    .permission {
+      display: flex;
+      gap: 8px;
+      align-items: center;
+      margin: 4px 0;
+    }
+  `;
+
+  summary(input: any): string {
+    switch (input.action) {
+      case "issue":
+        return `Read #${input.number}`;
+      case "comment":
+        return `Comment on #${input.number}`;
+      case "create_branch":
+        return `Create branch ${input.branch}`;
+      case "push":
+        return `Push to ${input.branch}`;
+      case "create_pr":
+        return `Open pull request: ${input.title}`;
+    }
+    return input.action;
+  }
+
+  // Answers the oldest permission request, which is this call's while it awaits one.
+  async answer(allow: boolean) {
+    try {
+      const url = allow ? "permissions/allow" : "permissions/deny";
+      const response = await fetch(url, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({}),
+      });
+      if (!response.ok) {
+        throw new Error(`${response.status} - ${await response.text()}`);
+      }
+      this.answered = true;
+    } catch (error) {
+      console.error("Error answering permission request:", error);
+    }
+  }
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    const awaiting =
+      input.action !== "issue" &&
+      !this.toolCall?.result_message &&
+      !this.answered;
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        🔨 ${this.summary(input)}
+      </span>
+      <div slot="input">
+        ${awaiting
+          ? html`<div class="permission">
+              Waiting for your permission:
+              <button @click=${() => this.answer(true)}>Allow</button>
+              <button @click=${() => this.answer(false)}>Deny</button>
+            </div>`
+          : ""}
+        ${input.body ? html`<pre>${input.body}</pre>` : ""}
+      </div>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-review")
+export class SketchToolCardReview extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+  @state() answered: boolean = false;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    .permission {
+      display: flex;
+      gap: 8px;
+      align-items: center;
+      margin: 4px 0;
+    }
+    .comment {
+      border-left: 3px solid #ccc;
+      margin: 6px 0;
+      padding-left: 8px;
+    }
+    .comment.major,
+    .comment.blocker {
+      border-left-color: #d73a49;
+    }
+    .comment.minor {
+      border-left-color: #f0ad4e;
+    }
+    .severity {
+      font-weight: bold;
+      text-transform: uppercase;
+      font-size: 0.8em;
+    }
+    .location {
+      font-family: monospace;
+      color: #555;
+    }
+    .suggestion {
+      background: #e6ffed;
+    }
+  `;
+
+  // Answers the oldest permission request, which is this call's while it awaits one.
+  async answer(allow: boolean) {
+    try {
+      const url = allow ? "permissions/allow" : "permissions/deny";
+      const response = await fetch(url, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({}),
+      });
+      if (!response.ok) {
+        throw new Error(`${response.status} - ${await response.text()}`);
+      }
+      this.answered = true;
+    } catch (error) {
+      console.error("Error answering permission request:", error);
+    }
+  }
+
+  location(comment: any): string {
+    return comment.end_line && comment.end_line !== comment.start_line
+      ? `${comment.file}:${comment.start_line}-${comment.end_line}`
+      : `${comment.file}:${comment.start_line}`;
+  }
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    const comments = input.comments || [];
+    const target = input.pr ? `#${input.pr}` : "local changes";
+    const awaiting =
+      input.post && !this.toolCall?.result_message && !this.answered;
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        📝
+        ${input.action === "diff"
+          ? `Read diff of ${target}`
+          : `Review of ${target}: ${comments.length} comments`}
+      </span>
+      <div slot="input">
+        ${awaiting
+          ? html`<div class="permission">
+              Waiting for your permission:
+              <button @click=${() => this.answer(true)}>Allow</button>
+              <button @click=${() => this.answer(false)}>Deny</button>
+            </div>`
+          : ""}
+        ${input.summary ? html`<p>${input.summary}</p>` : ""}
+        ${comments.map(
+          (comment: any) =>
+            html`<div class="comment ${comment.severity}">
+              <span class="severity">${comment.severity}</span>
+              <span class="location">${this.location(comment)}</span>
+              <div>${comment.body}</div>
+              ${comment.suggestion !== undefined
+                ? html`<pre class="suggestion">${comment.suggestion}</pre>`
+                : ""}
+            </div>`,
+        )}
+      </div>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-scaffold")
+export class SketchToolCardScaffold extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+  `;
+
+  render() {
+    const input = JSON.parse(this.toolCall?.input || "{}");
+    const vars = Object.entries(input.vars || {});
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        🏗️
+        ${input.action === "list"
+          ? "List templates"
+          : `${input.template} in ${input.dir}`}
+      </span>
+      <div slot="input">
+        ${vars.map(
+          ([name, value]) => html`<div><b>${name}:</b> ${value}</div>`,
+        )}
+      </div>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
+@customElement("sketch-tool-card-undo")
+export class SketchToolCardUndo extends LitElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  static styles = css`
+    .summary-text {
+      color: #555;
+      font-family: monospace;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+  `;
+
+  render() {
+    return html`<sketch-tool-card .open=${this.open} .toolCall=${this.toolCall}>
+      <span slot="summary" class="summary-text">
+        ↩️
+        ${this.toolCall?.name === "undo_all"
+          ? "Undo all file changes"
+          : "Undo last file change"}
+      </span>
+      <div slot="result">
+        <pre>${this.toolCall?.result_message?.tool_result}</pre>
+      </div>
+    </sketch-tool-card>`;
+  }
+}
+
 @customElement("sketch-tool-card-think")
 export class SketchToolCardThink extends LitElement {
   @property() toolCall: ToolCall;
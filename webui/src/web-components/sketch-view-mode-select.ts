@@ -7,7 +7,7 @@ import { SketchTailwindElement } from "./sketch-tailwind-element";
 export class SketchViewModeSelect extends SketchTailwindElement {
   // Current active mode
   @property()
-  activeMode: "chat" | "diff2" | "terminal" = "chat";
+  activeMode: "chat" | "diff2" | "terminal" | "dashboard" = "chat";
 
   // Diff stats
   @property({ type: Number })
@@ -40,7 +40,9 @@ export class SketchViewModeSelect extends SketchTailwindElement {
   /**
    * Handle view mode button clicks
    */
-  private _handleViewModeClick(mode: "chat" | "diff2" | "terminal") {
+  private _handleViewModeClick(
+    mode: "chat" | "diff2" | "terminal" | "dashboard",
+  ) {
     // Dispatch a custom event to notify the app shell to change the view
     const event = new CustomEvent("view-mode-select", {
       detail: { mode },
@@ -125,6 +127,19 @@ export class SketchViewModeSelect extends SketchTailwindElement {
           <span class="tab-icon text-base">💻</span>
           <span class="max-sm:hidden sm:max-xl:hidden">Terminal</span>
         </button>
+
+        <button
+          id="showDashboardButton"
+          class="px-3 py-2 bg-none border-0 border-b-2 cursor-pointer text-xs flex items-center gap-1.5 text-gray-600 border-transparent transition-all whitespace-nowrap ${this
+            .activeMode === "dashboard"
+            ? "!border-b-blue-600 text-blue-600 font-medium bg-blue-50"
+            : "hover:bg-gray-200"} @xl:px-3 @xl:py-2 @max-xl:px-2.5 @max-xl:[&>span:not(.tab-icon):not(.diff-stats)]:hidden @max-xl:[&>.diff-stats]:inline @max-xl:[&>.diff-stats]:text-xs @max-xl:[&>.diff-stats]:ml-0.5 border-r border-gray-200 last-of-type:border-r-0"
+          title="Dashboard View"
+          @click=${() => this._handleViewModeClick("dashboard")}
+        >
+          <span class="tab-icon text-base">📊</span>
+          <span class="max-sm:hidden sm:max-xl:hidden">Dashboard</span>
+        </button>
       </div>
     `;
   }
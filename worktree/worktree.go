@@ -0,0 +1,147 @@
+// Package worktree runs a session in a git worktree of its own, on a branch of its own,
+// so that the agent never dirties the user's checkout.
+//
+// Create adds the worktree, on a new branch that starts at the checkout's HEAD.
+// The agent works and commits there. When the session ends, Merge merges the branch
+// into whatever the user's checkout has checked out, and Remove deletes the worktree and the branch.
+// Sessions' worktrees outlive sketch until they are removed; List finds them.
+package worktree
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A Worktree is a session's worktree of a repository.
+type Worktree struct {
+	// Repo is the root of the user's checkout.
+	Repo string `json:"repo"`
+	// Dir is the root of the worktree.
+	Dir string `json:"dir"`
+	// Branch is the worktree's branch, without refs/heads/.
+	Branch string `json:"branch"`
+}
+
+// Create adds a worktree of the repository that dir is in, on a new branch with the given name
+// that starts at the checkout's HEAD. Uncommitted changes in the checkout are not in the worktree.
+func Create(ctx context.Context, dir, branch string) (*Worktree, error) {
+	repo, err := git(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("a worktree needs a git repository: %w", err)
+	}
+	wt, err := os.MkdirTemp("", "sketch-worktree-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create worktree directory: %w", err)
+	}
+	if _, err := git(ctx, repo, "worktree", "add", "-b", branch, wt, "HEAD"); err != nil {
+		os.Remove(wt)
+		return nil, err
+	}
+	return &Worktree{Repo: repo, Dir: wt, Branch: branch}, nil
+}
+
+// List returns the worktrees of the repository that dir is in whose branches start with prefix.
+func List(ctx context.Context, dir, prefix string) ([]*Worktree, error) {
+	repo, err := git(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	out, err := git(ctx, repo, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	// Each worktree is a stanza of "key value" lines, starting with "worktree <path>".
+	var wts []*Worktree
+	var path string
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		key, value, _ := strings.Cut(s.Text(), " ")
+		switch key {
+		case "worktree":
+			path = value
+		case "branch":
+			branch := strings.TrimPrefix(value, "refs/heads/")
+			// The first worktree is the main one, the user's checkout.
+			if path != repo && strings.HasPrefix(branch, prefix) {
+				wts = append(wts, &Worktree{Repo: repo, Dir: path, Branch: branch})
+			}
+		}
+	}
+	return wts, nil
+}
+
+// Find returns the worktree of the repository that dir is in whose branch is branch.
+func Find(ctx context.Context, dir, branch string) (*Worktree, error) {
+	wts, err := List(ctx, dir, branch)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range wts {
+		if w.Branch == branch {
+			return w, nil
+		}
+	}
+	return nil, fmt.Errorf("no worktree has branch %s", branch)
+}
+
+// Path returns the path in w of path, a path in the user's checkout.
+func (w *Worktree) Path(path string) string {
+	rel, err := filepath.Rel(w.Repo, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return w.Dir
+	}
+	return filepath.Join(w.Dir, rel)
+}
+
+// Commits returns the commits on w's branch that the user's checkout does not have, newest first, as "<hash> <subject>".
+func (w *Worktree) Commits(ctx context.Context) ([]string, error) {
+	out, err := git(ctx, w.Repo, "log", "--format=%h %s", "HEAD.."+w.Branch)
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// Dirty reports whether w has uncommitted changes, which Merge leaves behind.
+func (w *Worktree) Dirty(ctx context.Context) (bool, error) {
+	out, err := git(ctx, w.Dir, "status", "--porcelain")
+	return out != "", err
+}
+
+// Merge merges w's branch into the branch checked out in the user's checkout.
+// If the merge fails, as on a conflict, it is aborted and the checkout is left as it was.
+func (w *Worktree) Merge(ctx context.Context) error {
+	if _, err := git(ctx, w.Repo, "merge", "--no-edit", w.Branch); err != nil {
+		git(ctx, w.Repo, "merge", "--abort")
+		return err
+	}
+	return nil
+}
+
+// Remove deletes w and its branch, with any commits that were not merged.
+func (w *Worktree) Remove(ctx context.Context) error {
+	if _, err := git(ctx, w.Repo, "worktree", "remove", "--force", w.Dir); err != nil {
+		return err
+	}
+	_, err := git(ctx, w.Repo, "branch", "-D", w.Branch)
+	return err
+}
+
+// git runs git in dir and returns its output, trimmed.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %w", strings.Join(args, " "), strings.TrimSpace(stderr.String()), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
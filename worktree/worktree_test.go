@@ -0,0 +1,76 @@
+package worktree
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func run(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s: %v", args, out, err)
+	}
+}
+
+func TestWorktree(t *testing.T) {
+	ctx := context.Background()
+	repo := t.TempDir()
+	run(t, repo, "git", "init", "-q", "-b", "main")
+	os.MkdirAll(filepath.Join(repo, "sub"), 0o755)
+	os.WriteFile(filepath.Join(repo, "sub", "a.txt"), []byte("a\n"), 0o644)
+	run(t, repo, "git", "add", ".")
+	run(t, repo, "git", "commit", "-q", "-m", "initial")
+
+	w, err := Create(ctx, filepath.Join(repo, "sub"), "sketch/worktree-one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(w.Dir) })
+	sub := w.Path(filepath.Join(w.Repo, "sub"))
+	if _, err := os.Stat(filepath.Join(sub, "a.txt")); err != nil {
+		t.Fatalf("the worktree lacks the checkout's files: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(sub, "b.txt"), []byte("b\n"), 0o644)
+	if dirty, err := w.Dirty(ctx); err != nil || !dirty {
+		t.Errorf("Dirty = %v, %v with an uncommitted file", dirty, err)
+	}
+	run(t, w.Dir, "git", "add", ".")
+	run(t, w.Dir, "git", "commit", "-q", "-m", "add b")
+	if commits, err := w.Commits(ctx); err != nil || len(commits) != 1 {
+		t.Errorf("Commits = %q, %v", commits, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "sub", "b.txt")); err == nil {
+		t.Error("the worktree's commit changed the checkout before the merge")
+	}
+
+	wts, err := List(ctx, repo, "sketch/worktree-")
+	if err != nil || len(wts) != 1 || wts[0].Branch != w.Branch {
+		t.Fatalf("List = %+v, %v", wts, err)
+	}
+	if _, err := Find(ctx, repo, "sketch/worktree-two"); err == nil {
+		t.Error("found a worktree that does not exist")
+	}
+
+	if err := w.Merge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "sub", "b.txt")); err != nil {
+		t.Errorf("the merge did not bring the worktree's commit to the checkout: %v", err)
+	}
+	if commits, _ := w.Commits(ctx); len(commits) != 0 {
+		t.Errorf("Commits after the merge = %q", commits)
+	}
+	if err := w.Remove(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if wts, _ := List(ctx, repo, "sketch/"); len(wts) != 0 {
+		t.Errorf("List after Remove = %+v", wts)
+	}
+}